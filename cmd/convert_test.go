@@ -0,0 +1,59 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestCmpConvertSmartConfig stores the members of the extensions cmp.stored_extensions lists, instead of the
+// built-in list
+func TestCmpConvertSmartConfig(t *testing.T) {
+	dir := t.TempDir()
+	file, err := os.Create(filepath.Join(dir, "notes.tar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(file)
+	for _, name := range []string{"notes.txt", "app.LOG", "photo.jpg"} {
+		body := strings.Repeat(name+"\n", 500)
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(body)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	config := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(config, []byte("cmp:\n  stored_extensions: [\".TXT\", log]\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	env := []string{"GSN_CONFIG=" + config}
+
+	if got := runGsn(t, dir, env, "cmp", "convert", "notes.tar", "--to", "zip", "--smart", "--no-color"); got.Code != 0 {
+		t.Fatalf("cmp convert --smart = exit %d\n%s", got.Code, got.Stderr)
+	}
+	r, err := zip.OpenReader(filepath.Join(dir, "notes.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	want := map[string]uint16{"notes.txt": zip.Store, "app.LOG": zip.Store, "photo.jpg": zip.Deflate}
+	for _, f := range r.File {
+		if f.Method != want[f.Name] {
+			t.Errorf("%s: method %d, want %d", f.Name, f.Method, want[f.Name])
+		}
+	}
+
+	// Only a zip can store some members and compress the others
+	if got := runGsn(t, dir, env, "cmp", "convert", "notes.tar", "--to", "tar.gz", "--smart", "--no-color"); got.Code != 2 || !strings.Contains(got.Stderr, "--smart") {
+		t.Errorf("cmp convert --to tar.gz --smart = exit %d\n%s", got.Code, got.Stderr)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"gsn-dev-tools/internals/docs"
+
+	"github.com/spf13/cobra"
+)
+
+// TestEveryCommandIsDocumented walks the whole command tree, hidden commands included, and requires the
+// short and long description and an example that gsn docs renders for each of them
+func TestEveryCommandIsDocumented(t *testing.T) {
+	root := newRootCmd()
+	// cobra adds its help and completion commands on the first run, they are documented by cobra
+	root.InitDefaultHelpCmd()
+	root.InitDefaultCompletionCmd()
+
+	count := 0
+	var walk func(c *cobra.Command)
+	walk = func(c *cobra.Command) {
+		if c.Parent() == root && (c.Name() == "completion" || c.Name() == "help") {
+			return
+		}
+		count++
+		if strings.TrimSpace(c.Short) == "" {
+			t.Errorf("%s has no Short", c.CommandPath())
+		}
+		if strings.TrimSpace(c.Long) == "" {
+			t.Errorf("%s has no Long", c.CommandPath())
+		}
+		if strings.TrimSpace(c.Example) == "" {
+			t.Errorf("%s has no Example", c.CommandPath())
+		} else if !strings.Contains(c.Example, "gsn ") {
+			t.Errorf("%s: the example does not run gsn: %q", c.CommandPath(), c.Example)
+		}
+		for _, sub := range c.Commands() {
+			walk(sub)
+		}
+	}
+	walk(root)
+	if count < 50 {
+		t.Errorf("walked %d commands, the tree is not the one gsn runs", count)
+	}
+
+	// gsn docs --check agrees
+	if problems := docs.Undocumented(root); len(problems) > 0 {
+		t.Errorf("docs.Undocumented = %v", problems)
+	}
+}
+
+func TestUndocumentedReportsMissingParts(t *testing.T) {
+	root := &cobra.Command{Use: "gsn", Short: "s", Long: "l", Example: "  gsn"}
+	root.AddCommand(&cobra.Command{Use: "bare", Run: func(*cobra.Command, []string) {}})
+	root.AddCommand(&cobra.Command{Use: "partial", Short: "s", Example: "  gsn partial", Run: func(*cobra.Command, []string) {}})
+	got := strings.Join(docs.Undocumented(root), "\n")
+	want := "gsn bare: missing Short, Long, Example\ngsn partial: missing Long"
+	if got != want {
+		t.Errorf("Undocumented =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestExitCodesPerCommandFamily runs a representative failure of each command family and checks the code
+// gsn exits with, the contract gsn exit-codes documents
+func TestExitCodesPerCommandFamily(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "project"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "project", "a.txt"), []byte("a\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := runGsn(t, dir, nil, "cmp", "project", "--no-color"); got.Code != 0 {
+		t.Fatalf("cmp = exit %d: %s", got.Code, got.Stderr)
+	}
+
+	api := newFakeAPI(t, map[string]string{
+		"GET /user":                     `{"login":"octocat"}`,
+		"GET /repos/owner/repo/pulls/5": `{"number":5,"state":"closed","head":{"sha":"abc"}}`,
+	})
+	env := append(api.env(), "GIT_CEILING_DIRECTORIES="+filepath.Dir(dir))
+
+	tests := []struct {
+		name    string
+		args    []string
+		code    int
+		wantErr string
+	}{
+		{"exit-codes", []string{"exit-codes"}, 0, ""},
+
+		// Usage errors, from cobra and from validation
+		{"unknown command", []string{"nosuchcmd"}, 2, `unknown command "nosuchcmd"`},
+		{"unknown flag", []string{"cmp", "--nosuchflag"}, 2, "unknown flag: --nosuchflag"},
+		{"too many arguments", []string{"extract", "project.tar.gz", "a", "b"}, 2, "accepts"},
+		{"missing argument", []string{"cmp"}, 2, "requires the path to compress"},
+		{"invalid flag value", []string{"cmp", "project", "--format", "rar"}, 2, "unsupported archive format 'rar'"},
+
+		// Files
+		{"cmp missing source", []string{"cmp", "missing"}, 3, "no such file or directory"},
+		{"extract missing entry", []string{"extract", "project.tar.gz", "--file", "nope"}, 3, "no entry matching 'nope'"},
+		{"du missing path", []string{"du", "missing"}, 3, "no such file or directory"},
+		{"grep at depth 0", []string{"cmp", "grep", "a", "project.tar.gz", "--max-depth", "0"}, 2, "--max-depth 0 leaves no file to search"},
+		{"grep with both depth flags", []string{"cmp", "grep", "a", "project.tar.gz", "--max-depth", "2", "--top-level-only"}, 2, "none of the others can be"},
+		{"verify without manifest", []string{"cmp", "verify", "project.tar.gz"}, 1, "No manifest found"},
+
+		// State and secrets
+		{"backups restore without backup", []string{"backups", "restore", "project/a.txt"}, 3, "there is no backup of"},
+		{"secret get missing", []string{"secret", "get", "nope", "--backend", "file"}, 3, "secret not found"},
+
+		// Git
+		{"git outside a repository", []string{"git", "export"}, 4, "not inside a git work tree"},
+
+		// Network and time
+		{"wait timeout", []string{"wait", "--tcp", "127.0.0.1:1", "--timeout", "200ms"}, 124, "Timed out after 200ms"},
+		{"cron invalid expression", []string{"cron", "next", "bad"}, 2, "Invalid cron expression"},
+		{"time skipped by DST", []string{"time", "2024-03-10T02:30", "--from", "America/New_York"}, 1, "does not exist in America/New_York"},
+		{"time repeated by DST", []string{"time", "2024-11-03 01:30", "--from", "America/New_York"}, 1, "happens twice in America/New_York"},
+		{"time unknown zone", []string{"time", "now", "--in", "Mars/Olympus"}, 2, "unknown time zone 'Mars/Olympus'"},
+		{"cert inspect missing file", []string{"cert", "inspect", "missing.pem"}, 3, "no such file or directory"},
+
+		// GitHub
+		{"pr merge invalid method", []string{"pr", "merge", "https://github.com/owner/repo/pull/5", "--method", "fast"}, 2, "invalid --method 'fast'"},
+		{"pr merge missing PR", []string{"pr", "merge", "https://github.com/owner/repo/pull/7"}, 3, "Not Found"},
+		{"pr merge closed PR", []string{"pr", "merge", "https://github.com/owner/repo/pull/5"}, 4, "pull request is closed"},
+		// A batch failing for different reasons
+		{"pr merge mixed failures", []string{"pr", "merge", "https://github.com/owner/repo/pull/5", "https://github.com/owner/repo/pull/7"}, 1, "pull request is closed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := runGsn(t, dir, env, append(test.args, "--no-color")...)
+			if got.Code != test.code || !strings.Contains(got.Stdout+got.Stderr, test.wantErr) {
+				t.Errorf("gsn %s = exit %d, want %d with %q\n%s%s", strings.Join(test.args, " "), got.Code, test.code, test.wantErr, got.Stdout, got.Stderr)
+			}
+		})
+	}
+}
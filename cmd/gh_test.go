@@ -0,0 +1,287 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeAPI is a GitHub API served by routes, keyed by "METHOD /path", recording every request with its body.
+// A route missing from routes answers 404.
+type fakeAPI struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func newFakeAPI(t *testing.T, routes map[string]string) *fakeAPI {
+	t.Helper()
+	api := &fakeAPI{}
+	api.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		call := r.Method + " " + r.URL.RequestURI()
+		if len(body) > 0 {
+			call += " " + strings.TrimSpace(string(body))
+		}
+		api.mu.Lock()
+		api.requests = append(api.requests, call)
+		api.mu.Unlock()
+
+		response, ok := routes[r.Method+" "+r.URL.Path]
+		if !ok {
+			http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+			return
+		}
+		_, _ = io.WriteString(w, response)
+	}))
+	t.Cleanup(api.Close)
+	return api
+}
+
+// env points gsn at the fake API with a token
+func (api *fakeAPI) env() []string {
+	return []string{"GSN_GH_TOKEN=ghp_test1234", "GSN_GH_API_URL=" + api.URL}
+}
+
+// Requests returns the requests received so far, "METHOD /uri body"
+func (api *fakeAPI) Requests() []string {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return append([]string(nil), api.requests...)
+}
+
+// fakeUserAPI answers GET /user as octocat, reporting scopes in X-OAuth-Scopes unless scopes is nil
+func fakeUserAPI(t *testing.T, scopes *string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/user" || r.Header.Get("Authorization") != "Bearer ghp_test1234" {
+			http.Error(w, `{"message":"Bad credentials"}`, http.StatusUnauthorized)
+			return
+		}
+		if scopes != nil {
+			w.Header().Set("X-OAuth-Scopes", *scopes)
+		}
+		_, _ = io.WriteString(w, `{"login":"octocat"}`)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGhAuthLoginStoresToken(t *testing.T) {
+	home := t.TempDir()
+	server := fakeUserAPI(t, nil)
+	env := []string{"GSN_HOME=" + home, "GSN_GH_API_URL=" + server.URL}
+
+	got := runGsnInput(t, t.TempDir(), env, "ghp_test1234\n", "gh", "auth", "login", "--no-color")
+	if got.Code != 0 {
+		t.Fatalf("login exited %d: %s%s", got.Code, got.Stdout, got.Stderr)
+	}
+	for _, want := range []string{"Pull requests: Read and write", "Logged in as octocat"} {
+		if !strings.Contains(got.Stdout, want) {
+			t.Errorf("login output misses %q:\n%s", want, got.Stdout)
+		}
+	}
+	path := filepath.Join(home, "config", "gh-token")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("token file mode = %v, want 0600", info.Mode().Perm())
+	}
+	if data, _ := os.ReadFile(path); string(data) != "ghp_test1234\n" {
+		t.Errorf("token file = %q", data)
+	}
+
+	got = runGsnInput(t, t.TempDir(), env, "ghp_wrong\n", "gh", "auth", "login", "--no-color")
+	if got.Code == 0 || !strings.Contains(got.Stderr, "Bad credentials") {
+		t.Errorf("a rejected token = exit %d: %s", got.Code, got.Stderr)
+	}
+}
+
+func TestGhAuthStatus(t *testing.T) {
+	scopes := "repo, gist"
+	server := fakeUserAPI(t, &scopes)
+	got := runGsn(t, t.TempDir(), []string{"GSN_GH_TOKEN=ghp_test1234", "GSN_GH_API_URL=" + server.URL}, "gh", "auth", "status")
+	want := "Host:   " + server.URL + "\n" +
+		"Token:  ********1234 (from env GSN_GH_TOKEN)\n" +
+		"User:   octocat\n" +
+		"Scopes: repo, gist\n"
+	if got.Code != 0 || got.Stdout != want {
+		t.Errorf("auth status = exit %d\n%s%s\nwant\n%s", got.Code, got.Stdout, got.Stderr, want)
+	}
+}
+
+func TestGhAuthCheck(t *testing.T) {
+	check := func(scopes string) gsnResult {
+		server := fakeUserAPI(t, &scopes)
+		return runGsn(t, t.TempDir(), []string{"GSN_GH_TOKEN=ghp_test1234", "GSN_GH_API_URL=" + server.URL}, "gh", "auth", "check", "--no-color")
+	}
+	if got := check("repo"); got.Code != 0 || strings.Contains(got.Stdout, "missing") {
+		t.Errorf("check with repo = exit %d:\n%s%s", got.Code, got.Stdout, got.Stderr)
+	}
+
+	got := check("public_repo")
+	if got.Code != 1 || !strings.Contains(got.Stdout, "ERROR: pr merge: missing repo") {
+		t.Errorf("check with public_repo = exit %d:\n%s%s", got.Code, got.Stdout, got.Stderr)
+	}
+}
+
+func TestGhAuthCheckFineGrained(t *testing.T) {
+	server := fakeUserAPI(t, nil)
+	got := runGsn(t, t.TempDir(), []string{"GSN_GH_TOKEN=ghp_test1234", "GSN_GH_API_URL=" + server.URL}, "gh", "auth", "check")
+	if got.Code != 0 || !strings.Contains(got.Stdout, "Fine-grained tokens do not report scopes") || !strings.Contains(got.Stdout, "Commit statuses: Read and write") {
+		t.Errorf("check with a fine-grained token = exit %d:\n%s%s", got.Code, got.Stdout, got.Stderr)
+	}
+}
+
+func TestApproveWithSavedTemplate(t *testing.T) {
+	api := newFakeAPI(t, map[string]string{
+		"GET /repos/owner/repo/pulls/7":          `{"number":7,"title":"Fix the parser","state":"open","user":{"login":"octocat"},"head":{"sha":"abc123"},"additions":5,"deletions":1,"changed_files":1}`,
+		"POST /repos/owner/repo/pulls/7/reviews": `{"id":99}`,
+	})
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	config := "gh:\n  review_templates:\n    thanks: \"Thanks @{author}! {{{files_changed} file, +{additions}}}\"\n    broken: \"Hi {user}\"\n"
+	if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	env := append(api.env(), "GSN_CONFIG="+configPath)
+
+	got := runGsn(t, t.TempDir(), env, "approve", "https://github.com/owner/repo/pull/7", "--template", "thanks")
+	if got.Code != 0 {
+		t.Fatalf("approve --template = exit %d:\n%s%s", got.Code, got.Stdout, got.Stderr)
+	}
+	want := `POST /repos/owner/repo/pulls/7/reviews {"commit_id":"abc123","event":"APPROVE","body":"Thanks @octocat! {1 file, +5}"}`
+	if requests := api.Requests(); !slices.Contains(requests, want) {
+		t.Errorf("requests = %v, want %s", requests, want)
+	}
+
+	// A template with an unknown placeholder, or no template of that name, fails before any request
+	for _, name := range []string{"broken", "missing"} {
+		before := len(api.Requests())
+		got := runGsn(t, t.TempDir(), env, "approve", "https://github.com/owner/repo/pull/7", "--template", name)
+		if got.Code == 0 || len(api.Requests()) != before {
+			t.Errorf("approve --template %s = exit %d, %d request(s):\n%s", name, got.Code, len(api.Requests())-before, got.Stderr)
+		}
+	}
+}
+
+func TestGhQueueFlush(t *testing.T) {
+	api := newFakeAPI(t, map[string]string{
+		"GET /user":                              `{"login":"me"}`,
+		"GET /repos/owner/repo/pulls/1":          `{"number":1,"title":"Bump","state":"open","user":{"login":"bot"},"head":{"sha":"abc1234"}}`,
+		"GET /repos/owner/repo/pulls/1/reviews":  `[{"state":"APPROVED","commit_id":"abc1234","user":{"login":"me"}}]`,
+		"POST /repos/owner/repo/issues/1/labels": `[]`,
+	})
+	home := t.TempDir()
+	queueDir := filepath.Join(home, "state", "gh-queue")
+	// An approval already applied by a flush whose response was lost, in the version 1 format, and a label write
+	files := map[string]string{
+		"00000000000000000001.json": `{"version":1,"id":"00000000000000000001","kind":"approve","pr":"https://github.com/owner/repo/pull/1"}`,
+		"00000000000000000002.json": `{"schema_version":2,"id":"00000000000000000002","kind":"request","method":"POST","path":"/repos/owner/repo/issues/1/labels","body":{"labels":["deps"]}}`,
+	}
+	if err := os.MkdirAll(queueDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(queueDir, name), []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	env := append(api.env(), "GSN_HOME="+home)
+
+	got := runGsn(t, t.TempDir(), env, "gh", "queue", "flush")
+	if got.Code != 0 || !strings.Contains(got.Stdout, "Skipped owner/repo#1, already approved at abc1234") ||
+		!strings.Contains(got.Stdout, "Replayed POST /repos/owner/repo/issues/1/labels") || !strings.Contains(got.Stdout, "2 replayed, 0 failed.") {
+		t.Fatalf("flush = exit %d:\n%s%s", got.Code, got.Stdout, got.Stderr)
+	}
+	for _, r := range api.Requests() {
+		if strings.HasPrefix(r, "POST /repos/owner/repo/pulls/1/reviews") {
+			t.Errorf("the approval was submitted again: %s", r)
+		}
+	}
+	if entries, _ := os.ReadDir(queueDir); slices.ContainsFunc(entries, func(e os.DirEntry) bool { return strings.HasSuffix(e.Name(), ".json") }) {
+		t.Errorf("operations left in the queue: %v", entries)
+	}
+
+	// A second flush has nothing to do and sends nothing
+	before := len(api.Requests())
+	got = runGsn(t, t.TempDir(), env, "gh", "queue", "flush")
+	if got.Code != 0 || !strings.Contains(got.Stdout, "The queue is empty.") || len(api.Requests()) != before {
+		t.Errorf("second flush = exit %d:\n%s%s", got.Code, got.Stdout, got.Stderr)
+	}
+}
+
+func TestGhCommandsUnderDryRun(t *testing.T) {
+	routes := map[string]string{
+		"GET /repos/owner/repo/pulls/42": `{"number":42,"title":"Fix the parser","state":"open","user":{"login":"octocat"},"head":{"sha":"abc123"}}`,
+		"GET /repos/owner/repo/pulls":    `[{"number":42,"title":"Bump lodash","state":"open","user":{"login":"dependabot[bot]"},"head":{"sha":"abc123"}}]`,
+		"GET /repos/owner/repo/issues/3": `{"number":3,"state":"open"}`,
+	}
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"approve", []string{"approve", "owner/repo#42"},
+			[]string{"POST /repos/owner/repo/pulls/42/reviews\n", "Would approve owner/repo#42: Fix the parser"}},
+		{"approve-bots", []string{"approve-bots", "-R", "owner/repo", "--no-preview"},
+			[]string{"POST /repos/owner/repo/pulls/42/reviews\n", "Would approve owner/repo#42: Bump lodash"}},
+		{"pr merge", []string{"pr", "merge", "owner/repo#42", "--method", "rebase"},
+			[]string{"PUT /repos/owner/repo/pulls/42/merge\n", "Would merge owner/repo#42 with rebase"}},
+		{"pr label", []string{"pr", "label", "owner/repo#42", "--add", "bug"},
+			[]string{"POST /repos/owner/repo/issues/42/labels\n", "Would label owner/repo#42 with [bug]"}},
+		{"issue close", []string{"issue", "close", "owner/repo#3", "--comment", "Done"},
+			[]string{"POST /repos/owner/repo/issues/3/comments\n", "PATCH /repos/owner/repo/issues/3\n", "Would close owner/repo#3 as completed\n"}},
+		{"gh status set", []string{"gh", "status", "set", "owner/repo#42", "--context", "perf", "--state", "success"},
+			[]string{"POST /repos/owner/repo/statuses/abc123\n"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := newFakeAPI(t, routes)
+			got := runGsn(t, t.TempDir(), api.env(), append(test.args, "--dry-run")...)
+			if got.Code != 0 {
+				t.Fatalf("exit %d\n%s%s", got.Code, got.Stdout, got.Stderr)
+			}
+			for _, want := range test.want {
+				if !strings.Contains(got.Stdout, want) {
+					t.Errorf("stdout\n%s\nwithout %q", got.Stdout, want)
+				}
+			}
+			// The PRs and issues are resolved, nothing is written
+			for _, r := range api.Requests() {
+				if !strings.HasPrefix(r, "GET ") {
+					t.Errorf("sent %s", r)
+				}
+			}
+		})
+	}
+}
+
+func TestGhDryRunRefused(t *testing.T) {
+	api := newFakeAPI(t, nil)
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"porcelain", []string{"approve", "owner/repo#42", "--porcelain", "--dry-run"}, "--porcelain cannot be used with --dry-run"},
+		{"not adopted", []string{"gh", "queue", "flush", "--dry-run"}, "gsn gh queue flush does not support --dry-run yet"},
+	}
+	for _, test := range tests {
+		got := runGsn(t, t.TempDir(), api.env(), test.args...)
+		if got.Code != 2 || !strings.Contains(got.Stderr, test.want) {
+			t.Errorf("%s: exit %d\n%s%s", test.name, got.Code, got.Stdout, got.Stderr)
+		}
+	}
+	if requests := api.Requests(); len(requests) != 0 {
+		t.Errorf("refused commands sent %v", requests)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeSizedTree creates project/ below dir with subdirectories of growing size
+func writeSizedTree(t *testing.T, dir string) {
+	t.Helper()
+	for i, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		sub := filepath.Join(dir, "project", name)
+		if err := os.MkdirAll(sub, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(sub, "data.bin"), make([]byte, (i+1)*1000), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCompressAsksAboveTheLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"max-size", []string{"--max-size", "10KB"}},
+		{"max-files", []string{"--max-files", "5"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeSizedTree(t, dir)
+
+			got := runGsn(t, dir, nil, append([]string{"cmp", "project", "--no-color"}, test.args...)...)
+			if got.Code == 0 || !strings.Contains(got.Stderr, "rerun with --yes") {
+				t.Fatalf("without --yes = exit %d:\n%s%s", got.Code, got.Stdout, got.Stderr)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "project.tar.gz")); !os.IsNotExist(err) {
+				t.Errorf("the archive was written without confirmation: %v", err)
+			}
+			// The five largest subdirectories, largest first, and not the sixth
+			largest := strings.Split(got.Stdout[strings.Index(got.Stdout, "Largest entries:"):], "\n")
+			for i, name := range []string{"f", "e", "d", "c", "b"} {
+				if want := "  " + filepath.Join("project", name); !strings.HasSuffix(largest[i+1], want) {
+					t.Errorf("largest entry %d = %q, want %s", i+1, largest[i+1], want)
+				}
+			}
+			if strings.Contains(got.Stdout, filepath.Join("project", "a")) {
+				t.Errorf("the smallest subdirectory is listed:\n%s", got.Stdout)
+			}
+
+			got = runGsn(t, dir, nil, append([]string{"cmp", "project", "--yes"}, test.args...)...)
+			if got.Code != 0 {
+				t.Fatalf("with --yes = exit %d:\n%s%s", got.Code, got.Stdout, got.Stderr)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "project.tar.gz")); err != nil {
+				t.Errorf("no archive with --yes: %v", err)
+			}
+		})
+	}
+}
+
+func TestCompressBelowTheLimitsDoesNotAsk(t *testing.T) {
+	dir := t.TempDir()
+	writeSizedTree(t, dir)
+	got := runGsn(t, dir, nil, "cmp", "project", "--max-size", "1MB", "--max-files", "6")
+	if got.Code != 0 || strings.Contains(got.Stdout, "Largest entries") {
+		t.Errorf("below the limits = exit %d:\n%s%s", got.Code, got.Stdout, got.Stderr)
+	}
+}
+
+func TestCompressRefusesRootAndHome(t *testing.T) {
+	home := t.TempDir()
+	if err := os.WriteFile(filepath.Join(home, "notes.txt"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	env := []string{"HOME=" + home}
+
+	got := runGsn(t, t.TempDir(), env, "cmp", "/", "--no-color")
+	if got.Code == 0 || !strings.Contains(got.Stderr, "refusing to compress the filesystem root") {
+		t.Errorf("cmp / = exit %d: %s", got.Code, got.Stderr)
+	}
+	got = runGsn(t, t.TempDir(), env, "cmp", home, "--no-color")
+	if got.Code == 0 || !strings.Contains(got.Stderr, "refusing to compress your entire home directory") {
+		t.Errorf("cmp $HOME = exit %d: %s", got.Code, got.Stderr)
+	}
+
+	out := t.TempDir()
+	got = runGsn(t, out, env, "cmp", home, "--i-know-what-im-doing", "-o", filepath.Join(out, "home.tar.gz"))
+	if got.Code != 0 {
+		t.Errorf("cmp $HOME --i-know-what-im-doing = exit %d: %s", got.Code, got.Stderr)
+	}
+}
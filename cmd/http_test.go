@@ -0,0 +1,63 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestHTTPSaveAndRun(t *testing.T) {
+	api := newFakeAPI(t, map[string]string{
+		"POST /v1/users":   `{"id":42,"name":"ada"}`,
+		"GET /v1/users/42": `{"id":42,"name":"ada"}`,
+	})
+	env := []string{"GSN_HOME=" + t.TempDir()}
+
+	// Saved, then sent, the body printed as received on a pipe
+	got := runGsn(t, t.TempDir(), env, "http", "post", api.URL+"/v1/users", "-d", `{"name": "{{name}}"}`, "--var", "name=ada", "--save", "create-user")
+	if got.Code != 0 || got.Stdout != `{"id":42,"name":"ada"}` || !strings.Contains(got.Stderr, "Saved create-user: POST "+api.URL+"/v1/users") {
+		t.Fatalf("http post --save = %+v", got)
+	}
+
+	got = runGsn(t, t.TempDir(), env, "http", "--run", "create-user", "--var", "name=grace")
+	if got.Code != 0 || !strings.Contains(got.Stderr, "200 OK") {
+		t.Errorf("http --run = %+v", got)
+	}
+	got = runGsn(t, t.TempDir(), env, "http", "--run", "create-user")
+	if got.Code != 2 || !strings.Contains(got.Stderr, "no value for name, pass --var name=value") {
+		t.Errorf("http --run without its variable = %+v", got)
+	}
+	want := []string{`POST /v1/users {"name": "ada"}`, `POST /v1/users {"name": "grace"}`}
+	if requests := api.Requests(); !slices.Equal(requests, want) {
+		t.Errorf("requests = %q, want %q", requests, want)
+	}
+
+	got = runGsn(t, t.TempDir(), env, "http", "--list")
+	if got.Code != 0 || got.Stdout != "create-user  POST    "+api.URL+"/v1/users\n" {
+		t.Errorf("http --list = %+v", got)
+	}
+
+	// The status decides the exit code
+	for path, code := range map[string]int{"/v1/users/42": 0, "/v1/users/7": 3} {
+		if got := runGsn(t, t.TempDir(), env, "http", "get", api.URL+path); got.Code != code {
+			t.Errorf("http get %s exits with %d, want %d\n%s", path, got.Code, code, got.Stderr)
+		}
+	}
+	if got := runGsn(t, t.TempDir(), env, "http", "--run", "nope"); got.Code != 3 {
+		t.Errorf("http --run of an unknown request exits with %d\n%s", got.Code, got.Stderr)
+	}
+
+	// Secrets are only saved as references
+	got = runGsn(t, t.TempDir(), env, "http", "get", api.URL+"/v1/users/42", "--token", "ghp_clear", "--save", "leaky")
+	if got.Code != 2 || !strings.Contains(got.Stderr, "refusing to save a token in clear") {
+		t.Errorf("http --save with a clear token = %+v", got)
+	}
+
+	got = runGsn(t, t.TempDir(), env, "http", "--delete", "create-user")
+	if got.Code != 0 || !strings.Contains(got.Stdout, "Deleted create-user") {
+		t.Errorf("http --delete = %+v", got)
+	}
+	if got := runGsn(t, t.TempDir(), env, "http", "--list"); !strings.HasPrefix(got.Stdout, "No saved requests") {
+		t.Errorf("http --list after the delete = %+v", got)
+	}
+}
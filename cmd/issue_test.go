@@ -0,0 +1,97 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// issueRoutes is a repository with the open issue 3, the closed issue 4 and a pull request among its issues
+var issueRoutes = map[string]string{
+	"GET /user":                                `{"login":"octocat"}`,
+	"POST /repos/owner/repo/issues":            `{"number":12,"html_url":"https://github.com/owner/repo/issues/12"}`,
+	"GET /repos/owner/repo/issues/3":           `{"number":3,"state":"open"}`,
+	"GET /repos/owner/repo/issues/4":           `{"number":4,"state":"closed"}`,
+	"POST /repos/owner/repo/issues/3/comments": `{}`,
+	"PATCH /repos/owner/repo/issues/3":         `{}`,
+	"GET /repos/owner/repo/issues": `[
+		{"number":3,"title":"Crash on start","user":{"login":"alice"},"labels":[{"name":"bug"},{"name":"triage"}],"assignees":[{"login":"octocat"}]},
+		{"number":5,"title":"Bump deps","user":{"login":"dependabot[bot]"},"pull_request":{}},
+		{"number":6,"title":"Docs typo","user":{"login":"bob"}}
+	]`,
+}
+
+func TestIssueCreate(t *testing.T) {
+	api := newFakeAPI(t, issueRoutes)
+	got := runGsn(t, t.TempDir(), api.env(), "issue", "create", "-R", "owner/repo", "--title", "Crash on start",
+		"--body", "Steps", "--label", "bug,triage", "--assignee", "@me", "--no-color")
+	if got.Code != 0 || got.Stdout != "OK: Created owner/repo#12: https://github.com/owner/repo/issues/12\n" {
+		t.Fatalf("create = exit %d\n%s%s", got.Code, got.Stdout, got.Stderr)
+	}
+	want := []string{
+		"GET /user",
+		`POST /repos/owner/repo/issues {"title":"Crash on start","body":"Steps","labels":["bug","triage"],"assignees":["octocat"]}`,
+	}
+	if requests := api.Requests(); !slices.Equal(requests, want) {
+		t.Errorf("requests =\n%s\nwant\n%s", strings.Join(requests, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Without a title nothing is sent
+	api = newFakeAPI(t, issueRoutes)
+	got = runGsn(t, t.TempDir(), api.env(), "issue", "create", "-R", "owner/repo", "--body", "Steps")
+	if got.Code != 2 || !strings.Contains(got.Stderr, "needs a title") || len(api.Requests()) != 0 {
+		t.Errorf("create without a title = exit %d %s, requests %v", got.Code, got.Stderr, api.Requests())
+	}
+}
+
+func TestIssueClose(t *testing.T) {
+	api := newFakeAPI(t, issueRoutes)
+	got := runGsn(t, t.TempDir(), api.env(), "issue", "close", "owner/repo#3", "https://github.com/owner/repo/issues/4",
+		"--comment", "Duplicate of #1", "--reason", "not_planned", "--no-color")
+	// The closed issue fails, the open one is commented on, then closed
+	if got.Code != 1 || got.Stdout != "OK: Closed owner/repo#3\n" || !strings.Contains(got.Stderr, "Failed to close https://github.com/owner/repo/issues/4: issue is already closed") {
+		t.Errorf("close = exit %d\n%s%s", got.Code, got.Stdout, got.Stderr)
+	}
+	want := []string{
+		"GET /repos/owner/repo/issues/3",
+		`POST /repos/owner/repo/issues/3/comments {"body":"Duplicate of #1"}`,
+		`PATCH /repos/owner/repo/issues/3 {"state":"closed","state_reason":"not_planned"}`,
+		"GET /repos/owner/repo/issues/4",
+	}
+	if requests := api.Requests(); !slices.Equal(requests, want) {
+		t.Errorf("requests =\n%s\nwant\n%s", strings.Join(requests, "\n"), strings.Join(want, "\n"))
+	}
+
+	got = runGsn(t, t.TempDir(), api.env(), "issue", "close", "owner/repo#3", "--reason", "wontfix")
+	if got.Code != 2 {
+		t.Errorf("close with an invalid reason = exit %d %s", got.Code, got.Stderr)
+	}
+}
+
+func TestIssueList(t *testing.T) {
+	api := newFakeAPI(t, issueRoutes)
+	got := runGsn(t, t.TempDir(), api.env(), "issue", "list", "-R", "owner/repo", "--assignee", "@me", "--label", "bug,triage",
+		"--state", "all", "--columns", "number,title,labels,assignees,author", "--tsv")
+	want := "number\ttitle\tlabels\tassignees\tauthor\n" +
+		"3\tCrash on start\tbug,triage\toctocat\talice\n" +
+		"6\tDocs typo\t\t\tbob\n"
+	if got.Code != 0 || got.Stdout != want {
+		t.Errorf("list = exit %d\n%s%s\nwant\n%s", got.Code, got.Stdout, got.Stderr, want)
+	}
+	wantRequests := []string{"GET /user", "GET /repos/owner/repo/issues?assignee=octocat&labels=bug%2Ctriage&per_page=100&state=all"}
+	if requests := api.Requests(); !slices.Equal(requests, wantRequests) {
+		t.Errorf("requests = %v, want %v", requests, wantRequests)
+	}
+}
+
+func TestIssueCreateDryRun(t *testing.T) {
+	api := newFakeAPI(t, issueRoutes)
+	got := runGsn(t, t.TempDir(), api.env(), "issue", "create", "-R", "owner/repo", "--title", "Crash on start", "--dry-run")
+	if got.Code != 0 || !strings.Contains(got.Stdout, "POST /repos/owner/repo/issues\n") ||
+		!strings.Contains(got.Stdout, "Would create issue in owner/repo: Crash on start\n") {
+		t.Errorf("create --dry-run = exit %d\n%s%s", got.Code, got.Stdout, got.Stderr)
+	}
+	if requests := api.Requests(); len(requests) != 0 {
+		t.Errorf("create --dry-run sent %v", requests)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// localeTree is a directory whose counts and sizes need both marks of a locale: 1,235 files in 2.7 MiB, all
+// three days old
+func localeTree(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	old := time.Now().Add(-72 * time.Hour)
+	files := map[string]int{"src/video/clip.mp4": 1_600_000}
+	for i := 1; i <= 1234; i++ {
+		files[fmt.Sprintf("src/photos/p%d.jpg", i)] = 1024
+	}
+	for name, size := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, make([]byte, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// TestLocaleGolden pins the output of the same commands under en_US and de_DE, each typing its own decimal
+// mark, in testdata/locale/<command>-<locale>.golden
+func TestLocaleGolden(t *testing.T) {
+	goldenDir, err := filepath.Abs(filepath.Join("testdata", "locale"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := localeTree(t)
+
+	tests := []struct {
+		golden string
+		env    []string
+		args   []string
+	}{
+		{"du-en_US", nil, []string{"du", "src", "--locale", "en_US"}},
+		{"du-de_DE", nil, []string{"du", "src", "--locale", "de_DE"}},
+		{"prune-en_US", nil, []string{"prune", "src", "--older-than", "1.5d", "--archive-to", "old", "--dry-run", "--locale", "en_US"}},
+		{"prune-de_DE", nil, []string{"prune", "src", "--older-than", "1,5d", "--archive-to", "old", "--dry-run", "--locale", "de_DE"}},
+		// The locale of the environment, LC_ALL winning over LANG, gives the same output as --locale
+		{"du-de_DE", []string{"LANG=de_DE.UTF-8"}, []string{"du", "src"}},
+		{"du-en_US", []string{"LANG=de_DE.UTF-8", "LC_ALL=en_US.UTF-8"}, []string{"du", "src"}},
+		{"prune-de_DE", []string{"LC_NUMERIC=de_DE"}, []string{"prune", "src", "--older-than", "1,5d", "--archive-to", "old", "--dry-run"}},
+	}
+	for _, test := range tests {
+		t.Run(test.golden, func(t *testing.T) {
+			got := runGsn(t, dir, test.env, append(test.args, "--no-color")...)
+			want, err := os.ReadFile(filepath.Join(goldenDir, test.golden+".golden"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Code != 0 || got.Stdout != string(want) {
+				t.Errorf("gsn %s with %q = exit %d\n%s\nwant testdata/locale/%s.golden\n%s%s", strings.Join(test.args, " "), test.env, got.Code, got.Stdout, test.golden, want, got.Stderr)
+			}
+		})
+	}
+}
+
+func TestLocaleErrors(t *testing.T) {
+	dir := localeTree(t)
+	tests := []struct {
+		args   []string
+		stderr string
+	}{
+		{[]string{"du", "src", "--locale", "xx_XX"}, "unknown --locale 'xx_XX'"},
+		// A mark that may separate thousands is refused, suggesting both readings with the marks of the locale
+		{[]string{"prune", "src", "--older-than", "1,500d", "--locale", "en_US"}, "'1,500d' is ambiguous, 1,500 may separate thousands: write 1500 without a separator, or 1.5 with the decimal mark"},
+		{[]string{"prune", "src", "--older-than", "1.500d", "--locale", "de_DE"}, "'1.500d' is ambiguous, 1.500 may separate thousands: write 1500 without a separator, or 1,5 with the decimal mark"},
+		{[]string{"prune", "src", "--older-than", "1.000,5d", "--locale", "de_DE"}, "has more than one decimal mark"},
+	}
+	for _, test := range tests {
+		got := runGsn(t, dir, nil, append(test.args, "--dry-run", "--no-color")...)
+		if got.Code != 2 || !strings.Contains(got.Stderr, test.stderr) {
+			t.Errorf("gsn %s = exit %d\n%s\nwant exit 2 and %q", strings.Join(test.args, " "), got.Code, got.Stderr, test.stderr)
+		}
+	}
+}
@@ -2,25 +2,111 @@ package main
 
 import (
 	"fmt"
-	"os"
 
+	"gsn-dev-tools/internals/backups"
 	"gsn-dev-tools/internals/certificates"
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/config"
+	"gsn-dev-tools/internals/cron"
+	"gsn-dev-tools/internals/daemon"
+	"gsn-dev-tools/internals/dns"
+	"gsn-dev-tools/internals/docs"
+	"gsn-dev-tools/internals/dotenv"
+	"gsn-dev-tools/internals/dryrun"
 	"gsn-dev-tools/internals/files"
+	"gsn-dev-tools/internals/git"
+	"gsn-dev-tools/internals/hooks"
+	"gsn-dev-tools/internals/paths"
+	"gsn-dev-tools/internals/progress"
+	"gsn-dev-tools/internals/redact"
+	"gsn-dev-tools/internals/remote"
+	"gsn-dev-tools/internals/request"
+	"gsn-dev-tools/internals/scaffold"
+	"gsn-dev-tools/internals/secrets"
+	"gsn-dev-tools/internals/signing"
+	"gsn-dev-tools/internals/state"
+	"gsn-dev-tools/internals/style"
+	"gsn-dev-tools/internals/timeconv"
+	"gsn-dev-tools/internals/tmpfs"
+	"gsn-dev-tools/internals/units"
+	"gsn-dev-tools/internals/waitfor"
 	"gsn-dev-tools/pkg/gh"
 
 	"github.com/spf13/cobra"
 )
 
 func main() {
+	rootCmd := newRootCmd()
+
+	// Redaction starts before cobra parses the command line, its errors quote the arguments
+	clierr.AtExit(redact.Close)
+	if err := config.StartRedaction(); err != nil {
+		clierr.Fatal(err)
+	}
+
+	err := rootCmd.Execute()
+	tmpfs.CleanupAll()
+	remote.CloseAll()
+	code := clierr.Success
+	if err != nil {
+		fmt.Println(err.Error())
+		// Commands exit on their own failures, an error returned here is cobra rejecting the command line
+		code = clierr.CodeOf(err)
+		if code == clierr.Failure {
+			code = clierr.Usage
+		}
+	}
+	// Exit runs the exit hooks, like flushing the redaction filters, on success too
+	clierr.Exit(code)
+}
+
+// newRootCmd builds the gsn command tree with its global flags
+func newRootCmd() *cobra.Command {
 	var rootCmd = &cobra.Command{
 		Use:   "gsn",
 		Short: "A small cli program to run my most usual tools and commands in my day to day as a Software Engineer",
+		Long: `gsn bundles the everyday tools of a software engineer: compressing, copying and renaming files, managing
+certificates and secrets, and reviewing GitHub pull requests. Per-command hooks and workspaces are read from
+the config file in the gsn config dir.`,
+		Example: `  gsn cmp ./project --manifest
+  gsn approve https://github.com/owner/repo/pull/42
+  gsn docs --format man -o ./man`,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			style.Configure(cmd)
+			units.Configure(cmd)
+			if err := units.ConfigureLocale(cmd); err != nil {
+				clierr.Fatal(err)
+			}
+			if err := dryrun.Configure(cmd); err != nil {
+				clierr.Fatal(err)
+			}
+			if err := remote.Configure(cmd); err != nil {
+				clierr.Fatal(err)
+			}
+			if err := progress.Configure(cmd); err != nil {
+				clierr.Fatal(err)
+			}
+			paths.Migrate()
+			if err := hooks.Pre(cmd, args); err != nil {
+				clierr.Fatal(err)
+			}
+		},
 	}
 
+	style.AddFlag(rootCmd)
+	units.AddFlag(rootCmd)
+	units.AddLocaleFlag(rootCmd)
+	hooks.AddFlag(rootCmd)
+	dryrun.AddFlag(rootCmd)
+	remote.AddFlag(rootCmd)
+	progress.AddFlag(rootCmd)
+
 	// Define a command that accepts one argument
 	var showCmd = &cobra.Command{
-		Use:   "show [text]",
-		Short: "Print the provided text",
+		Use:     "show [text]",
+		Short:   "Print the provided text",
+		Long:    "Prints a greeting for the name given with --name. Useful to check that gsn is installed and on the PATH.",
+		Example: "  gsn show --name Ada",
 		Run: func(cmd *cobra.Command, args []string) {
 			name, _ := cmd.Flags().GetString("name")
 			if name == "" {
@@ -32,15 +118,48 @@ func main() {
 	}
 
 	showCmd.Flags().StringP("name", "n", "", "Name to print")
+	dryrun.ReadOnly(showCmd)
 
 	rootCmd.AddCommand(showCmd)
 	rootCmd.AddCommand(gh.ApproveGhPrs())
+	rootCmd.AddCommand(gh.ApproveBotsCmd())
+	rootCmd.AddCommand(gh.PrCmd())
+	rootCmd.AddCommand(gh.IssueCmd())
+	rootCmd.AddCommand(gh.GhCmd())
+	rootCmd.AddCommand(git.GitCmd())
 	rootCmd.AddCommand(files.FileUpdateCmd())
+	rootCmd.AddCommand(files.SlugCmd())
 	rootCmd.AddCommand(files.CompressionCmd())
+	rootCmd.AddCommand(files.ExtractionCmd())
+	rootCmd.AddCommand(files.DiskUsageCmd())
+	rootCmd.AddCommand(files.LinesOfCodeCmd())
+	rootCmd.AddCommand(files.FileTypeCmd())
+	rootCmd.AddCommand(scaffold.ScaffoldCmd())
+	rootCmd.AddCommand(dotenv.EnvCmd())
+	rootCmd.AddCommand(waitfor.WaitCmd())
+	rootCmd.AddCommand(dns.DNSCmd())
+	rootCmd.AddCommand(request.HTTPCmd())
+	rootCmd.AddCommand(cron.CronCmd())
+	rootCmd.AddCommand(timeconv.TimeCmd())
+	rootCmd.AddCommand(files.CopyCmd())
+	rootCmd.AddCommand(files.PruneCmd())
+	rootCmd.AddCommand(files.SnapCmd())
+	rootCmd.AddCommand(tmpfs.CleanTempCmd())
+	rootCmd.AddCommand(secrets.SecretCmd())
 	rootCmd.AddCommand(certificates.GenerateCertsCmd())
-
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err.Error())
-		os.Exit(1)
-	}
+	rootCmd.AddCommand(certificates.CertCmd())
+	rootCmd.AddCommand(signing.VerifySigCmd())
+	rootCmd.AddCommand(config.ConfigCmd())
+	rootCmd.AddCommand(state.StateCmd())
+	rootCmd.AddCommand(backups.BackupsCmd())
+	rootCmd.AddCommand(files.BackupCmd())
+	rootCmd.AddCommand(files.ExportStateCmd())
+	rootCmd.AddCommand(files.ImportStateCmd())
+	rootCmd.AddCommand(docs.DocsCmd())
+	rootCmd.AddCommand(daemon.DaemonCmd())
+	rootCmd.AddCommand(daemon.ClientCmd())
+	exitCodesCmd := clierr.ExitCodesCmd()
+	dryrun.ReadOnly(exitCodesCmd)
+	rootCmd.AddCommand(exitCodesCmd)
+	return rootCmd
 }
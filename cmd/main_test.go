@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMain runs gsn itself when a test starts the test binary again through runGsn
+func TestMain(m *testing.M) {
+	if os.Getenv("GSN_TEST_MAIN") == "1" {
+		os.Args = append([]string{"gsn"}, os.Args[1:]...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// gsnResult is what a gsn run printed and how it exited
+type gsnResult struct {
+	Stdout string
+	Stderr string
+	Code   int
+}
+
+// runGsn runs gsn with args in dir, with a home, config and state of its own so the user's are never read
+func runGsn(t *testing.T, dir string, env []string, args ...string) gsnResult {
+	t.Helper()
+	return runGsnInput(t, dir, env, "", args...)
+}
+
+// runGsnInput is runGsn with stdin reading input
+func runGsnInput(t *testing.T, dir string, env []string, input string, args ...string) gsnResult {
+	t.Helper()
+	home := t.TempDir()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = dir
+	cmd.Env = append([]string{
+		"GSN_TEST_MAIN=1",
+		"HOME=" + home,
+		"XDG_CONFIG_HOME=" + filepath.Join(home, ".config"),
+		"XDG_STATE_HOME=" + filepath.Join(home, ".local/state"),
+		"XDG_DATA_HOME=" + filepath.Join(home, ".local/share"),
+		"XDG_CACHE_HOME=" + filepath.Join(home, ".cache"),
+		"TMPDIR=" + t.TempDir(),
+		"PATH=" + os.Getenv("PATH"),
+		"LANG=C",
+	}, env...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	result := gsnResult{}
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		result.Code = exitErr.ExitCode()
+	case err != nil:
+		t.Fatalf("running gsn %v: %v", args, err)
+	}
+	result.Stdout, result.Stderr = stdout.String(), stderr.String()
+	return result
+}
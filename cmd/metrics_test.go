@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// readMetrics parses a Prometheus textfile into its samples, the name and labels mapped to the value
+func readMetrics(t *testing.T, path string) map[string]string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		samples[line[:i]] = line[i+1:]
+	}
+	return samples
+}
+
+func TestCompressMetricsFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "project"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, body := range map[string]string{"a.txt": "hello\n", "b.txt": "world!\n"} {
+		if err := os.WriteFile(filepath.Join(dir, "project", name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	metrics := filepath.Join(dir, "gsn.prom")
+
+	got := runGsn(t, dir, nil, "cmp", "project", "--metrics-file", metrics, "--metrics-profile", "nightly")
+	if got.Code != 0 {
+		t.Fatalf("gsn cmp exited %d: %s", got.Code, got.Stderr)
+	}
+	labels := `{profile="nightly",source="` + filepath.Join(dir, "project") + `"}`
+	samples := readMetrics(t, metrics)
+	archive, err := os.Stat(filepath.Join(dir, "project.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"gsn_cmp_success":       "1",
+		"gsn_cmp_files":         "2",
+		"gsn_cmp_source_bytes":  "13",
+		"gsn_cmp_archive_bytes": strconv.FormatInt(archive.Size(), 10),
+	} {
+		if samples[name+labels] != want {
+			t.Errorf("%s%s = %q, want %s", name, labels, samples[name+labels], want)
+		}
+	}
+	for _, name := range []string{"gsn_cmp_last_run_timestamp_seconds", "gsn_cmp_duration_seconds"} {
+		if !regexp.MustCompile(`^[0-9.e+]+$`).MatchString(samples[name+labels]) {
+			t.Errorf("%s = %q, want a number", name, samples[name+labels])
+		}
+	}
+
+	// A failed run still writes the file, with success 0
+	got = runGsn(t, dir, nil, "cmp", "missing", "--metrics-file", metrics)
+	if got.Code == 0 {
+		t.Fatal("compressing a missing directory succeeded")
+	}
+	labels = `{profile="default",source="` + filepath.Join(dir, "missing") + `"}`
+	if samples := readMetrics(t, metrics); samples["gsn_cmp_success"+labels] != "0" {
+		t.Errorf("failed run wrote %v, want gsn_cmp_success%s 0", samples, labels)
+	}
+}
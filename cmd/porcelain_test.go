@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestApprovePorcelainGolden pins the output of approve --porcelain, the format scripts parse: one tab separated
+// line per PR in input order on stdout, nothing else there
+func TestApprovePorcelainGolden(t *testing.T) {
+	goldenDir, err := filepath.Abs(filepath.Join("testdata", "porcelain"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := newFakeAPI(t, map[string]string{
+		"GET /repos/owner/repo/pulls/7":          `{"number":7,"title":"Fix the parser","state":"open","user":{"login":"octocat"},"head":{"sha":"abc123"}}`,
+		"POST /repos/owner/repo/pulls/7/reviews": `{"id":9001}`,
+		"GET /repos/owner/repo/pulls/8":          `{"number":8,"title":"Bump deps","state":"open","user":{"login":"bot"},"head":{"sha":"def456"}}`,
+		"POST /repos/owner/repo/pulls/8/reviews": `{"id":9002}`,
+		"GET /repos/owner/repo/pulls/5":          `{"number":5,"title":"Old","state":"closed","user":{"login":"octocat"},"head":{"sha":"fff000"}}`,
+		"GET /repos/owner/repo/pulls/6":          `{"number":6,"title":"Older","state":"closed","user":{"login":"octocat"},"head":{"sha":"fff111"}}`,
+	})
+
+	tests := []struct {
+		golden string
+		args   []string
+		env    []string
+		code   int
+	}{
+		// The PRs as given, URLs and short references alike
+		{"approved", []string{"https://github.com/owner/repo/pull/7", "owner/repo#8"}, api.env(), 0},
+		// Failures keep their place in the input order, the code is the shared one or 1 for mixed reasons
+		{"mixed", []string{"owner/repo#5", "owner/repo#7", "owner/repo#404", "owner/repo#8"}, api.env(), 1},
+		{"same-failure", []string{"owner/repo#5", "owner/repo#6"}, api.env(), 4},
+		{"head-moved", []string{"owner/repo#7", "--head-sha", "0000000"}, api.env(), 4},
+		{"invalid-reference", []string{"not-a-pr", "owner/repo#7"}, api.env(), 1},
+		// Without gh or a token every PR fails with the same error
+		{"no-access", []string{"owner/repo#7", "owner/repo#8"}, []string{"PATH=" + t.TempDir()}, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.golden, func(t *testing.T) {
+			got := runGsn(t, t.TempDir(), test.env, append([]string{"approve", "--porcelain", "--no-color"}, test.args...)...)
+			want, err := os.ReadFile(filepath.Join(goldenDir, test.golden+".golden"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Stdout != string(want) {
+				t.Errorf("approve --porcelain %s =\n%s\nwant testdata/porcelain/%s.golden\n%s", strings.Join(test.args, " "), got.Stdout, test.golden, want)
+			}
+			if got.Code != test.code {
+				t.Errorf("exit %d, want %d\n%s", got.Code, test.code, got.Stderr)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCmpConfigPresets(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "config.yaml")
+	write := func(path string, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(config, `cmp:
+  presets:
+    node:
+      extends: node
+      exclude: [.turbo]
+    web:
+      extends: node
+      exclude: [public/build]
+      include: [public/build/robots.txt]
+    rust:
+      sentinels: [Cargo.toml]
+      exclude: [target]
+`)
+	for _, name := range []string{"package.json", "src/index.js", ".turbo/cache", "node_modules/x/index.js", "public/build/app.js", "public/build/robots.txt", "public/index.html"} {
+		write(filepath.Join(dir, "project", name), "x")
+	}
+	env := []string{"GSN_CONFIG=" + config}
+
+	got := runGsn(t, dir, env, "cmp", "presets", "--tsv", "--no-color")
+	want := "name\tsource\tsentinels\texclude\tinclude\n" +
+		"go\tbuiltin\tgo.mod\tvendor bin\tgo.sum\n" +
+		"node\tconfig\tpackage.json\tnode_modules .next dist coverage .turbo\t\n" +
+		"python\tbuiltin\tpyproject.toml setup.py requirements.txt\tvenv .venv __pycache__ .tox *.pyc .pytest_cache .mypy_cache\t\n" +
+		"rust\tconfig\tCargo.toml\ttarget\t\n" +
+		"web\tconfig\tpackage.json\tnode_modules .next dist coverage .turbo public/build\tpublic/build/robots.txt\n"
+	if got.Code != 0 || got.Stdout != want {
+		t.Errorf("cmp presets = exit %d:\n%s\nwant\n%s%s", got.Code, got.Stdout, want, got.Stderr)
+	}
+
+	// auto picks the config node preset, which extends the built-in one
+	got = runGsn(t, dir, env, "cmp", "map", "project", "--preset", "auto", "--tsv", "--columns", "entry", "--no-color")
+	if want := "entry\nproject/package.json\nproject/public/\nproject/public/build/\nproject/public/build/app.js\nproject/public/build/robots.txt\nproject/public/index.html\nproject/src/\nproject/src/index.js\n"; got.Code != 0 || got.Stdout != want {
+		t.Errorf("cmp map --preset auto = exit %d:\n%s%s", got.Code, got.Stdout, got.Stderr)
+	}
+	if !strings.Contains(got.Stderr, "Preset node: excluding node_modules, .next, dist, coverage, .turbo") {
+		t.Errorf("the chosen preset was not reported:\n%s", got.Stderr)
+	}
+
+	// An include does not bring back an entry below an excluded directory, the directory is skipped whole
+	got = runGsn(t, dir, env, "cmp", "map", "project", "--preset", "web", "--exclude", "*.html", "--tsv", "--columns", "entry", "--no-color")
+	if want := "entry\nproject/package.json\nproject/public/\nproject/src/\nproject/src/index.js\n"; got.Code != 0 || got.Stdout != want {
+		t.Errorf("cmp map --preset web = exit %d:\n%s%s", got.Code, got.Stdout, got.Stderr)
+	}
+
+	for content, wantErr := range map[string]string{
+		"cmp:\n  presets:\n    auto: {}\n":                                 "'auto' is reserved for preset detection and cannot be defined",
+		"cmp:\n  presets:\n    a: {extends: b}\n    b: {extends: a}\n":     "presets extend each other in a loop",
+		"cmp:\n  presets:\n    rust: {extends: rust, exclude: [target]}\n": "preset 'rust' extends itself but there is no built-in 'rust'",
+	} {
+		write(config, content)
+		if got := runGsn(t, dir, env, "cmp", "presets", "--no-color"); got.Code != 1 || !strings.Contains(got.Stderr, wantErr) {
+			t.Errorf("cmp presets with %q = exit %d: %s", content, got.Code, got.Stderr)
+		}
+	}
+}
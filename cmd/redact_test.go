@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestRedactOutput runs commands with a redact section in the config: plain output, JSON output and errors
+// leave out the listed names, and the exit codes are those of an unredacted run
+func TestRedactOutput(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"acme-corp/report.txt", "cust-1234/notes.txt", "public/readme.txt"} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, make([]byte, 100), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(config, []byte("redact:\n  strings: [acme-corp]\n  patterns: ['cust-\\d+']\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	env := []string{"GSN_CONFIG=" + config}
+
+	got := runGsn(t, dir, env, "du", ".", "--no-color")
+	if got.Code != 0 || strings.Contains(got.Stdout, "acme") || strings.Contains(got.Stdout, "1234") ||
+		strings.Count(got.Stdout, "[REDACTED]") != 2 || !strings.Contains(got.Stdout, "public") {
+		t.Errorf("du = exit %d\n%s%s", got.Code, got.Stdout, got.Stderr)
+	}
+
+	got = runGsn(t, dir, env, "du", ".", "--json", "--relative-json")
+	var report struct {
+		Entries []struct{ Path string }
+	}
+	if err := json.Unmarshal([]byte(got.Stdout), &report); got.Code != 0 || err != nil {
+		t.Fatalf("du --json = exit %d, %v\n%s%s", got.Code, err, got.Stdout, got.Stderr)
+	}
+	var paths []string
+	for _, entry := range report.Entries {
+		paths = append(paths, entry.Path)
+	}
+	if strings.Join(paths, " ") != "[REDACTED] [REDACTED] public" {
+		t.Errorf("du --json paths = %v", paths)
+	}
+
+	// An error names the path it failed on, and exits as it would unredacted
+	if got = runGsn(t, dir, env, "du", "acme-corp/missing"); got.Code == 0 || strings.Contains(got.Stderr, "acme") || !strings.Contains(got.Stderr, "[REDACTED]/missing") {
+		t.Errorf("du of a missing path = exit %d\n%s", got.Code, got.Stderr)
+	}
+	if want := runGsn(t, dir, nil, "du", "acme-corp/missing"); want.Code != got.Code {
+		t.Errorf("redacted run exits %d, unredacted %d", got.Code, want.Code)
+	}
+
+	if err := os.WriteFile(config, []byte("redact:\n  patterns: ['cust-(']\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got = runGsn(t, dir, env, "du", "."); got.Code != 2 || !strings.Contains(got.Stderr, "invalid redact pattern 'cust-('") {
+		t.Errorf("an invalid pattern = exit %d\n%s", got.Code, got.Stderr)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gsn-dev-tools/internals/remote/sftptest"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// TestNoFlagHidesAPersistentOne walks the command tree for local flags named like a persistent flag of the
+// root, which would read as the global flag on the command line and leave it unset
+func TestNoFlagHidesAPersistentOne(t *testing.T) {
+	root := newRootCmd()
+	var walk func(c *cobra.Command)
+	walk = func(c *cobra.Command) {
+		root.PersistentFlags().VisitAll(func(global *pflag.Flag) {
+			if local := c.LocalNonPersistentFlags().Lookup(global.Name); local != nil {
+				t.Errorf("%s --%s hides the persistent --%s", c.CommandPath(), local.Name, global.Name)
+			}
+		})
+		for _, sub := range c.Commands() {
+			walk(sub)
+		}
+	}
+	walk(root)
+}
+
+// TestRemoteDu lists a directory of a host served over SSH in process, the way gsn --remote reaches sshd
+func TestRemoteDu(t *testing.T) {
+	root := t.TempDir()
+	for name, size := range map[string]int{"srv/logs/app.log": 3000, "srv/logs/old/app.log.1": 2048, "srv/cache/blob": 5000} {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, make([]byte, size), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	home := t.TempDir()
+	hostSpec := (&sftptest.Server{Root: root}).ListenSSH(t, home)
+
+	got := runGsn(t, t.TempDir(), []string{"HOME=" + home}, "--remote", hostSpec, "du", "/srv", "--sort", "size:desc", "--no-color")
+	want := "path   size     files\nlogs   4.9 KiB  2\ncache  4.9 KiB  1\n\nTotal: 9.8 KiB in 3 file(s)\n"
+	if got.Code != 0 || got.Stdout != want {
+		t.Errorf("gsn --remote du = exit %d\n%s\nwant\n%s%s", got.Code, got.Stdout, want, got.Stderr)
+	}
+
+	// A command that does not read its tree over SFTP refuses rather than running locally
+	if got = runGsn(t, t.TempDir(), []string{"HOME=" + home}, "--remote", hostSpec, "loc", "."); got.Code != 2 || !strings.Contains(got.Stderr, "does not support --remote yet") {
+		t.Errorf("gsn --remote loc = exit %d\n%s", got.Code, got.Stderr)
+	}
+}
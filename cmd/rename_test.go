@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// dirNames lists the names in dir, sorted
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestRenameWorkspaceUndo(t *testing.T) {
+	dir := t.TempDir()
+	home := t.TempDir()
+	inbox, scans := filepath.Join(dir, "inbox"), filepath.Join(dir, "scans")
+	files := map[string][]string{
+		inbox: {"IMG 01.jpg", "My Scan.PDF"},
+		scans: {"Report Final.txt"},
+	}
+	for root, names := range files {
+		if err := os.MkdirAll(root, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range names {
+			if err := os.WriteFile(filepath.Join(root, name), []byte(name), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	config := fmt.Sprintf("workspaces:\n  inboxes:\n    roots: [%q, %q, %q]\n    rename:\n      extension: md\n",
+		inbox, filepath.Join(dir, "missing"), scans)
+	if err := os.MkdirAll(filepath.Join(home, "config"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(home, "config", "config.yaml"), []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	env := []string{"GSN_HOME=" + home}
+
+	got := runGsn(t, dir, env, "rename", "--workspace", "inboxes", "--no-color")
+	if got.Code != 0 {
+		t.Fatalf("rename --workspace = exit %d:\n%s%s", got.Code, got.Stdout, got.Stderr)
+	}
+	if !strings.Contains(got.Stderr, "Skipping missing workspace root '"+filepath.Join(dir, "missing")+"'") {
+		t.Errorf("the missing root was not reported:\n%s", got.Stderr)
+	}
+	if !strings.Contains(got.Stdout, "Renamed 3 file(s) across 2 root(s) of workspace 'inboxes'") {
+		t.Errorf("summary missing:\n%s", got.Stdout)
+	}
+	if names := dirNames(t, inbox); !slices.Equal(names, []string{".gsn-rename-journal.json", "img.md", "my_scan.md"}) {
+		t.Errorf("inbox after the run = %v", names)
+	}
+	if names := dirNames(t, scans); !slices.Equal(names, []string{".gsn-rename-journal.json", "report_final.md"}) {
+		t.Errorf("scans after the run = %v", names)
+	}
+
+	// One journal for the whole run, kept in the state dir
+	m := regexp.MustCompile(`Journal: (\S+)`).FindStringSubmatch(got.Stdout)
+	if m == nil {
+		t.Fatalf("no journal printed:\n%s", got.Stdout)
+	}
+	journal := m[1]
+	if filepath.Dir(journal) != filepath.Join(home, "state", "journals") {
+		t.Errorf("journal written to %s, want the state dir", journal)
+	}
+
+	got = runGsn(t, dir, env, "rename", "--undo", journal)
+	if got.Code != 0 {
+		t.Fatalf("rename --undo = exit %d:\n%s%s", got.Code, got.Stdout, got.Stderr)
+	}
+	if !strings.Contains(got.Stdout, "Restored 3 of 3 file(s)") {
+		t.Errorf("undo summary missing:\n%s", got.Stdout)
+	}
+	// Both roots are back as they were, their own journals included
+	for root, names := range files {
+		if got := dirNames(t, root); !slices.Equal(got, names) {
+			t.Errorf("%s after undo = %v, want %v", root, got, names)
+		}
+	}
+	if _, err := os.Stat(journal); !os.IsNotExist(err) {
+		t.Errorf("the aggregate journal is left after a complete undo: %v", err)
+	}
+}
+
+// treeFiles maps the path of every file below root, relative to it, to its content
+func treeFiles(t *testing.T, root string) map[string]string {
+	t.Helper()
+	tree := map[string]string{}
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		rel, _ := filepath.Rel(root, path)
+		tree[filepath.ToSlash(rel)] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+// fileNames returns the sorted keys of a treeFiles map
+func fileNames(tree map[string]string) []string {
+	names := slices.Collect(maps.Keys(tree))
+	slices.Sort(names)
+	return names
+}
+
+func TestRenameArchiveRestoreNames(t *testing.T) {
+	dir := t.TempDir()
+	env := []string{"GSN_HOME=" + t.TempDir()}
+	photos := filepath.Join(dir, "photos")
+	for name, content := range map[string]string{"IMG 01.jpg": "img", "My Scan.PDF": "scan", "2023/Beach Day.JPG": "beach"} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(photos, name)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(photos, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run := func(args ...string) gsnResult {
+		t.Helper()
+		got := runGsn(t, dir, env, append(args, "--no-color")...)
+		if got.Code != 0 {
+			t.Fatalf("gsn %s = exit %d:\n%s%s", strings.Join(args, " "), got.Code, got.Stdout, got.Stderr)
+		}
+		return got
+	}
+
+	// Each directory keeps its own journal
+	run("rename", "photos")
+	run("rename", "photos/2023", "-e", "jpg")
+	// A new file takes an original name again before the tree is archived
+	if err := os.WriteFile(filepath.Join(photos, "IMG 01.jpg"), []byte("new"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	archived := treeFiles(t, photos)
+	if names := fileNames(archived); !slices.Equal(names, []string{".gsn-rename-journal.json", "2023/.gsn-rename-journal.json", "2023/beach_day.jpg", "IMG 01.jpg", "img.txt", "my_scan.txt"}) {
+		t.Fatalf("renamed tree = %v", names)
+	}
+	run("cmp", "photos")
+
+	// Without --restore-names the tree comes back as it was archived
+	run("extract", "photos.tar.gz", "-o", "plain")
+	if got := treeFiles(t, filepath.Join(dir, "plain", "photos")); !maps.Equal(got, archived) {
+		t.Errorf("plain extraction = %v", fileNames(got))
+	}
+
+	// The move onto the taken name is left in the journal, the rest is undone and the other journal removed
+	got := run("extract", "photos.tar.gz", "-o", "skipped", "--restore-names", "--on-conflict", "skip")
+	if !strings.Contains(got.Stdout, "Restored 2 original name(s) from 2 rename journal(s)") {
+		t.Errorf("summary missing:\n%s", got.Stdout)
+	}
+	skipped := treeFiles(t, filepath.Join(dir, "skipped", "photos"))
+	if names := fileNames(skipped); !slices.Equal(names, []string{".gsn-rename-journal.json", "2023/Beach Day.JPG", "IMG 01.jpg", "My Scan.PDF", "img.txt"}) {
+		t.Errorf("tree restored with skip = %v", names)
+	}
+	if skipped["IMG 01.jpg"] != "new" || skipped["img.txt"] != "img" || skipped["My Scan.PDF"] != "scan" || skipped["2023/Beach Day.JPG"] != "beach" {
+		t.Errorf("contents restored with skip = %v", skipped)
+	}
+	journal := skipped[".gsn-rename-journal.json"]
+	if !strings.Contains(journal, `"from": "IMG 01.jpg"`) || strings.Contains(journal, "My Scan.PDF") {
+		t.Errorf("journal left = %s", journal)
+	}
+
+	// Overwriting undoes every move, and --keep-journal keeps the journals as they were
+	run("extract", "photos.tar.gz", "-o", "kept", "--restore-names", "--keep-journal")
+	kept := treeFiles(t, filepath.Join(dir, "kept", "photos"))
+	if names := fileNames(kept); !slices.Equal(names, []string{".gsn-rename-journal.json", "2023/.gsn-rename-journal.json", "2023/Beach Day.JPG", "IMG 01.jpg", "My Scan.PDF"}) {
+		t.Errorf("tree restored with --keep-journal = %v", names)
+	}
+	if kept["IMG 01.jpg"] != "img" || kept[".gsn-rename-journal.json"] != archived[".gsn-rename-journal.json"] {
+		t.Errorf("contents restored with --keep-journal = %v", kept)
+	}
+
+	// The source is untouched
+	if got := treeFiles(t, photos); !maps.Equal(got, archived) {
+		t.Errorf("source after the extractions = %v", fileNames(got))
+	}
+}
+
+func TestRestoreNamesRefusesEscapingJournals(t *testing.T) {
+	dir := t.TempDir()
+	env := []string{"GSN_HOME=" + t.TempDir()}
+	docs := filepath.Join(dir, "docs")
+	if err := os.MkdirAll(docs, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	journal := `{"schema_version":1,"command":"rename","root":"/elsewhere","entries":[{"from":"../../.bashrc","to":"notes.txt"}]}`
+	for name, content := range map[string]string{"notes.txt": "echo owned", ".gsn-rename-journal.json": journal} {
+		if err := os.WriteFile(filepath.Join(docs, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := runGsn(t, dir, env, "cmp", "docs", "--no-color"); got.Code != 0 {
+		t.Fatalf("cmp = exit %d:\n%s", got.Code, got.Stderr)
+	}
+
+	got := runGsn(t, dir, env, "extract", "docs.tar.gz", "-o", "out", "--restore-names", "--no-color")
+	if got.Code == 0 || !strings.Contains(got.Stderr, "records a move outside its directory: '../../.bashrc' -> 'notes.txt'") {
+		t.Errorf("extract = exit %d:\n%s%s", got.Code, got.Stdout, got.Stderr)
+	}
+	if names := fileNames(treeFiles(t, filepath.Join(dir, "out"))); !slices.Equal(names, []string{"docs/.gsn-rename-journal.json", "docs/notes.txt"}) {
+		t.Errorf("extracted tree = %v", names)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// staleRoutes is a review queue holding owner/repo#1, created and requested from octocat age ago
+func staleRoutes(age time.Duration) map[string]string {
+	at := time.Now().Add(-age).UTC().Format(time.RFC3339)
+	return map[string]string{
+		"GET /user": `{"login":"octocat"}`,
+		"GET /search/issues": fmt.Sprintf(`{"total_count":1,"items":[{"number":1,"title":"Fix the cache","user":{"login":"alice"},
+			"html_url":"https://github.com/owner/repo/pull/1","created_at":%q}]}`, at),
+		"GET /repos/owner/repo/issues/1/events": fmt.Sprintf(`[{"event":"review_requested","created_at":%q,"requested_reviewer":{"login":"octocat"}}]`, at),
+	}
+}
+
+func TestPrStaleExitCodes(t *testing.T) {
+	tests := []struct {
+		name   string
+		age    time.Duration
+		args   []string
+		code   int
+		stdout string
+	}{
+		{"stale", 72 * time.Hour, nil, 4, "3d    owner/repo#1  Fix the cache  @alice\n"},
+		{"quiet", 72 * time.Hour, []string{"-q"}, 4, ""},
+		{"not stale yet", time.Hour, nil, 0, "OK: No review request is waiting on you for over 2d\n"},
+		{"longer max age", 72 * time.Hour, []string{"--max-age", "1w"}, 0, "OK: No review request is waiting on you for over 7d\n"},
+		{"invalid max age", 72 * time.Hour, []string{"--max-age", "soon"}, 2, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			api := newFakeAPI(t, staleRoutes(test.age))
+			env := append(api.env(), "GSN_HOME="+t.TempDir())
+			got := runGsn(t, t.TempDir(), env, append([]string{"pr", "stale", "--no-color"}, test.args...)...)
+			if got.Code != test.code || got.Stdout != test.stdout {
+				t.Errorf("gsn pr stale %v = exit %d\n%q\nwant exit %d\n%q\n%s", test.args, got.Code, got.Stdout, test.code, test.stdout, got.Stderr)
+			}
+		})
+	}
+}
+
+func TestPrStaleSnooze(t *testing.T) {
+	api := newFakeAPI(t, staleRoutes(72*time.Hour))
+	env := append(api.env(), "GSN_HOME="+t.TempDir())
+	dir := t.TempDir()
+
+	got := runGsn(t, dir, env, "pr", "stale", "snooze", "owner/repo#1", "2d", "--no-color")
+	if got.Code != 0 || !strings.HasPrefix(got.Stdout, "OK: Snoozed owner/repo#1 until ") {
+		t.Fatalf("snooze = exit %d\n%s%s", got.Code, got.Stdout, got.Stderr)
+	}
+
+	// The snooze is kept for the next run, which then finds nothing stale
+	got = runGsn(t, dir, env, "pr", "stale", "--no-color")
+	if got.Code != 0 || got.Stderr != "1 snoozed review request(s) left out\n" {
+		t.Errorf("stale while snoozed = exit %d\n%s%s", got.Code, got.Stdout, got.Stderr)
+	}
+
+	got = runGsn(t, dir, env, "pr", "stale", "snooze", "owner/repo#1", "--off", "--no-color")
+	if got.Code != 0 || got.Stdout != "OK: owner/repo#1 is no longer snoozed\n" {
+		t.Fatalf("snooze --off = exit %d\n%s%s", got.Code, got.Stdout, got.Stderr)
+	}
+	if got = runGsn(t, dir, env, "pr", "stale", "-q"); got.Code != 4 {
+		t.Errorf("stale once the snooze ended = exit %d\n%s", got.Code, got.Stderr)
+	}
+
+	// Under --dry-run the snooze is only planned
+	got = runGsn(t, dir, env, "pr", "stale", "snooze", "owner/repo#1", "--dry-run")
+	if got.Code != 0 || !strings.Contains(got.Stdout, "snooze owner/repo#1 until ") {
+		t.Errorf("snooze --dry-run = exit %d\n%s%s", got.Code, got.Stdout, got.Stderr)
+	}
+	if got = runGsn(t, dir, env, "pr", "stale", "-q"); got.Code != 4 {
+		t.Errorf("stale after a dry-run snooze = exit %d\n%s", got.Code, got.Stderr)
+	}
+
+	for _, args := range [][]string{{"owner/repo#1", "1d", "--off"}, {"owner/repo#1", "forever"}, {"not-a-pr"}} {
+		if got = runGsn(t, dir, env, append([]string{"pr", "stale", "snooze"}, args...)...); got.Code == 0 {
+			t.Errorf("snooze %q succeeded", args)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestNoColorOutputIsPlain(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name string
+		args []string
+		env  []string
+		want gsnResult
+	}{
+		{
+			name: "exit-codes",
+			args: []string{"exit-codes", "--no-color"},
+			want: gsnResult{Stdout: "" +
+				"  0    Success\n" +
+				"  1    Failure without a more specific code, and differences found by verify, diff and snap diff\n" +
+				"  2    Usage error: unknown command or flag, wrong arguments, a flag value that does not validate\n" +
+				"  3    Not found: a file, archive entry, backup, git ref, pull request or other GitHub resource\n" +
+				"  4    Conflict: a name collision, a pull request closed or moved, a file changed meanwhile\n" +
+				"  124  Timeout: a deadline such as --timeout expired\n" +
+				"  130  Interrupted with Ctrl-C\n"},
+		},
+		{
+			name: "slug",
+			args: []string{"slug", "Héllo Wörld & Co", "--no-color"},
+			want: gsnResult{Stdout: "hello-world-co\n"},
+		},
+		{
+			name: "extract error",
+			args: []string{"extract", "missing.tar.gz", "--no-color"},
+			want: gsnResult{Stderr: "ERROR: open missing.tar.gz: no such file or directory\n", Code: 3},
+		},
+		{
+			name: "NO_COLOR",
+			args: []string{"extract", "missing.tar.gz"},
+			env:  []string{"NO_COLOR=1"},
+			want: gsnResult{Stderr: "ERROR: open missing.tar.gz: no such file or directory\n", Code: 3},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := runGsn(t, dir, test.env, test.args...)
+			if got != test.want {
+				t.Errorf("gsn %v =\n%+v\nwant\n%+v", test.args, got, test.want)
+			}
+		})
+	}
+}
+
+func TestNoColorCompressionIsASCII(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "project"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "project", "a.txt"), []byte("hello\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got := runGsn(t, dir, nil, "cmp", "project", "--no-color")
+	if got.Code != 0 {
+		t.Fatalf("gsn cmp exited %d: %s%s", got.Code, got.Stdout, got.Stderr)
+	}
+	for _, out := range []string{got.Stdout, got.Stderr} {
+		for i := 0; i < len(out); i++ {
+			if out[i] >= 0x80 || (out[i] < 0x20 && out[i] != '\n' && out[i] != '\r') {
+				t.Fatalf("output holds byte %#x at %d:\n%q", out[i], i, out)
+			}
+		}
+	}
+	timings := regexp.MustCompile(`Time: [^)]+\)|[0-9.]+ [KMG]?i?B/s|\([0-9.]+% `)
+	stdout := timings.ReplaceAllString(got.Stdout, "…")
+	want := "OK: Compression successful. Archive created: " + filepath.Join(dir, "project.tar.gz") + " (…\n" +
+		"1 file(s), 6 B -> "
+	if len(stdout) < len(want) || stdout[:len(want)] != want {
+		t.Errorf("stdout =\n%s\nwant it to start with\n%s", stdout, want)
+	}
+}
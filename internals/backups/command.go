@@ -0,0 +1,160 @@
+package backups
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/output"
+	"gsn-dev-tools/internals/style"
+	"gsn-dev-tools/internals/units"
+
+	"github.com/spf13/cobra"
+)
+
+// entryColumns declares the columns available to `backups list`
+var entryColumns = []output.Column[Entry]{
+	{Name: "id", Value: func(e Entry) any { return e.ID }},
+	{Name: "path", Value: func(e Entry) any { return e.Path }},
+	{Name: "size", Value: func(e Entry) any { return e.Size }, Display: func(e Entry) string { return units.FormatBytes(e.Size) }},
+	{Name: "command", Value: func(e Entry) any { return e.Command }},
+	{Name: "age", Value: func(e Entry) any { return time.Since(e.BackedUpAt) }, Display: func(e Entry) string { return units.FormatDuration(time.Since(e.BackedUpAt).Round(time.Second)) }},
+	{Name: "sha256", Value: func(e Entry) any { return e.Hash }, Display: func(e Entry) string { return e.Hash[:12] }},
+}
+
+func BackupsCmd() *cobra.Command {
+	backupsCmd := &cobra.Command{
+		Use:   "backups",
+		Short: "Lists and restores the contents gsn backed up before overwriting files",
+		Long: `Commands run with --backup copy every file they are about to overwrite into the backup store in
+~/.local/state/gsn/backups first. Identical contents are stored once, and only the newest --keep backups of a
+path are kept (default 5).`,
+		Example: `  gsn backups list
+  gsn backups restore ./config.json`,
+	}
+
+	backupsCmd.AddCommand(listCmd())
+	backupsCmd.AddCommand(restoreCmd())
+	backupsCmd.AddCommand(pruneCmd())
+	return backupsCmd
+}
+
+func listCmd() *cobra.Command {
+	listCmd := &cobra.Command{
+		Use:   "list [path]",
+		Short: "Lists the backups, of a single path when given",
+		Long:  "Lists the backups in the store oldest first, or only those of the given path.",
+		Example: `  gsn backups list
+  gsn backups list ./config.json --sort age`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			opts, err := output.OptionsFromFlags(cmd)
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			store, err := Open()
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			path := ""
+			if len(args) == 1 {
+				path = args[0]
+			}
+			entries, err := store.List(path)
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			if err := output.Render(os.Stdout, entryColumns, entries, opts); err != nil {
+				clierr.Fatalf("%v", err)
+			}
+		},
+	}
+
+	output.AddFlags(listCmd)
+	dryrun.ReadOnly(listCmd)
+	return listCmd
+}
+
+func restoreCmd() *cobra.Command {
+	var id, keep int
+	var force bool
+
+	restoreCmd := &cobra.Command{
+		Use:   "restore <path>",
+		Short: "Writes a backed up content back to its path",
+		Long: `Restores the newest backup of a path, or the one given with --id. A file changed after the gsn command that
+backed it up wrote it is only replaced with --force. The content being replaced is backed up itself, so
+restoring again brings it back.`,
+		Example: `  gsn backups restore ./config.json
+  gsn backups restore ./config.json --id 12 --force`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			store, err := Open()
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			entry, err := store.Restore(args[0], id, force, keep)
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			fmt.Printf(style.Success()+"Restored '%s' from backup %d taken by %s on %s\n", entry.Path, entry.ID, entry.Command, entry.BackedUpAt.Local().Format(time.DateTime))
+		},
+	}
+
+	restoreCmd.Flags().IntVar(&id, "id", 0, "Backup to restore, as shown by gsn backups list (default: the newest)")
+	restoreCmd.Flags().BoolVarP(&force, "force", "f", false, "Replace the file even when it changed after gsn wrote it")
+	restoreCmd.Flags().IntVar(&keep, "keep", DefaultKeep, "Backups of the path to keep, 0 keeps all")
+	return restoreCmd
+}
+
+func pruneCmd() *cobra.Command {
+	var keep int
+
+	pruneCmd := &cobra.Command{
+		Use:     "prune",
+		Short:   "Drops all but the newest backups of every path",
+		Long:    "Keeps the newest --keep backups of every path and removes the contents no backup refers to anymore.",
+		Example: "  gsn backups prune --keep 2",
+		Args:    cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if keep < 1 {
+				clierr.Exitf(clierr.Usage, "--keep must be at least 1")
+			}
+			store, err := Open()
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			dropped, err := store.Prune(keep)
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			fmt.Printf(style.Trash()+"Dropped %d backup(s)\n", dropped)
+		},
+	}
+
+	pruneCmd.Flags().IntVar(&keep, "keep", DefaultKeep, "Backups to keep per path")
+	return pruneCmd
+}
+
+// AddFlags registers --backup and --keep on a command that overwrites files
+func AddFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("backup", false, "Copy every file about to be overwritten into the gsn backup store first")
+	cmd.Flags().Int("keep", DefaultKeep, "Backups kept per path with --backup, 0 keeps all")
+}
+
+// FromFlags opens the backup store when --backup is set, the store is nil otherwise
+func FromFlags(cmd *cobra.Command) (*Store, int, error) {
+	backup, _ := cmd.Flags().GetBool("backup")
+	keep, _ := cmd.Flags().GetInt("keep")
+	if !backup {
+		return nil, keep, nil
+	}
+	if keep < 0 {
+		return nil, keep, clierr.Newf(clierr.Usage, "--keep cannot be negative")
+	}
+	store, err := Open()
+	return store, keep, err
+}
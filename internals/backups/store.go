@@ -0,0 +1,441 @@
+// Package backups keeps the contents files had before gsn overwrote them, in a store below the gsn state dir
+// where identical contents are kept once
+package backups
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/lockfile"
+	"gsn-dev-tools/internals/output"
+	"gsn-dev-tools/internals/paths"
+	"gsn-dev-tools/internals/state"
+)
+
+// DefaultKeep is how many backups of a path commands keep unless --keep says otherwise
+const DefaultKeep = 5
+
+// indexKind versions the index of the backup store
+var indexKind = state.Register(&state.Kind{
+	Name:     "backup index",
+	Format:   state.JSON,
+	Version:  1,
+	Patterns: []string{"index.json"},
+	Files: func() ([]string, error) {
+		dir, err := storeDir()
+		if err != nil {
+			return nil, err
+		}
+		return []string{filepath.Join(dir, "index.json")}, nil
+	},
+})
+
+// Entry is one backed up content of a path
+type Entry struct {
+	ID         int         `json:"id"`
+	Path       string      `json:"path"`
+	Hash       string      `json:"hash"`
+	Size       int64       `json:"size"`
+	Mode       os.FileMode `json:"mode"`
+	Command    string      `json:"command"`
+	BackedUpAt time.Time   `json:"backed_up_at"`
+
+	// Written is the hash of what the command wrote over the path, restore compares the file with it to
+	// notice changes made after gsn wrote it
+	Written string `json:"written,omitempty"`
+}
+
+// index is the content of index.json
+type index struct {
+	SchemaVersion int     `json:"schema_version"`
+	NextID        int     `json:"next_id"`
+	Entries       []Entry `json:"entries"`
+}
+
+// Store holds the index and the objects, one file per distinct content named by its SHA-256
+type Store struct {
+	Dir   string
+	index index
+}
+
+// storeDir returns the location of the backup store
+func storeDir() (string, error) {
+	dir, err := paths.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "backups"), nil
+}
+
+// Open loads the backup store, which is created with the first backup
+func Open() (*Store, error) {
+	dir, err := storeDir()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{Dir: dir}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load reads the index, a missing index is an empty store
+func (s *Store) load() error {
+	s.index = index{NextID: 1}
+	doc, err := indexKind.Load(s.indexPath(), &s.index)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return doc.Writable()
+}
+
+// update runs change on the index read again under the store lock and saves it, so gsn processes backing up at
+// the same time keep each other's backups
+func (s *Store) update(change func() error) error {
+	unlock, err := lockfile.ForFile(s.indexPath())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := s.load(); err != nil {
+		return err
+	}
+	if err := change(); err != nil {
+		return err
+	}
+	return s.save()
+}
+
+func (s *Store) indexPath() string {
+	return filepath.Join(s.Dir, "index.json")
+}
+
+func (s *Store) objectPath(hash string) string {
+	return filepath.Join(s.Dir, "objects", hash[:2], hash)
+}
+
+// Backup copies the regular file at path into the store before a command overwrites it and keeps the newest
+// keep backups of the path. It returns nil when there is no regular file to back up.
+func (s *Store) Backup(path string, command string, keep int) (*Entry, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Lstat(abs)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && !info.Mode().IsRegular()) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entry *Entry
+	err = s.update(func() error {
+		entry, err = s.backup(abs, info, command, keep)
+		if err != nil {
+			return fmt.Errorf("error backing up '%s': %w", path, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// backup stores the content of abs and records it, the store lock is held
+func (s *Store) backup(abs string, info os.FileInfo, command string, keep int) (*Entry, error) {
+	hash, err := s.storeObject(abs)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := Entry{
+		ID:         s.index.NextID,
+		Path:       abs,
+		Hash:       hash,
+		Size:       info.Size(),
+		Mode:       info.Mode().Perm(),
+		Command:    command,
+		BackedUpAt: time.Now().UTC(),
+	}
+	s.index.NextID++
+	s.index.Entries = append(s.index.Entries, entry)
+	s.retain(abs, keep)
+	return &entry, nil
+}
+
+// Written records the hash of what a command wrote over a backed up path
+func (s *Store) Written(entry *Entry, hash string) error {
+	entry.Written = hash
+	return s.update(func() error {
+		s.setWritten(entry.ID, hash)
+		return nil
+	})
+}
+
+func (s *Store) setWritten(id int, hash string) {
+	for i := range s.index.Entries {
+		if s.index.Entries[i].ID == id {
+			s.index.Entries[i].Written = hash
+		}
+	}
+}
+
+// storeObject copies the file into the objects unless the store has its content already
+func (s *Store) storeObject(path string) (string, error) {
+	hash, err := hashFile(path)
+	if err != nil {
+		return "", err
+	}
+	object := s.objectPath(hash)
+	if _, err := os.Stat(object); err == nil {
+		return hash, nil
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(object), 0o700); err != nil {
+		return "", err
+	}
+	out, err := os.CreateTemp(filepath.Dir(object), ".partial-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(out.Name())
+
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	return hash, os.Rename(out.Name(), object)
+}
+
+// List returns the backups of path, or of every path when it is empty, oldest first
+func (s *Store) List(path string) ([]Entry, error) {
+	if path == "" {
+		return slices.Clone(s.index.Entries), nil
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, e := range s.index.Entries {
+		if e.Path == abs {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// Restore writes the content of a backup of path back to it, the newest one when id is 0. A file changed
+// after gsn last wrote it is only replaced with force. Its current content is backed up first, so a restore
+// can be undone too.
+func (s *Store) Restore(path string, id int, force bool, keep int) (*Entry, error) {
+	var restored *Entry
+	err := s.update(func() error {
+		var err error
+		restored, err = s.restore(path, id, force, keep)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return restored, nil
+}
+
+// restore writes the backup back, the store lock is held
+func (s *Store) restore(path string, id int, force bool, keep int) (*Entry, error) {
+	entries, err := s.List(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, clierr.Newf(clierr.NotFound, "there is no backup of '%s'", path)
+	}
+
+	entry := entries[len(entries)-1]
+	if id != 0 {
+		i := slices.IndexFunc(entries, func(e Entry) bool { return e.ID == id })
+		if i < 0 {
+			return nil, clierr.Newf(clierr.NotFound, "'%s' has no backup %d", path, id)
+		}
+		entry = entries[i]
+	}
+
+	current, err := hashFile(entry.Path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if current == entry.Hash {
+		return &entry, nil
+	}
+	if latest := entries[len(entries)-1]; current != "" && !force {
+		switch {
+		case latest.Written == "":
+			return nil, clierr.Newf(clierr.Conflict, "cannot tell whether '%s' changed after %s wrote it, use --force to replace it", path, latest.Command)
+		case current != latest.Written:
+			return nil, clierr.Newf(clierr.Conflict, "'%s' changed after %s wrote it, use --force to replace it", path, latest.Command)
+		}
+	}
+
+	// Retention waits until the content is written, it could drop the backup being restored
+	var undo *Entry
+	if current != "" {
+		info, err := os.Lstat(entry.Path)
+		if err != nil {
+			return nil, err
+		}
+		if undo, err = s.backup(entry.Path, info, "backups restore", 0); err != nil {
+			return nil, fmt.Errorf("error backing up '%s': %w", path, err)
+		}
+	}
+	if err := s.writeObject(entry); err != nil {
+		return nil, err
+	}
+	if undo != nil {
+		s.setWritten(undo.ID, entry.Hash)
+	}
+	s.retain(entry.Path, keep)
+	return &entry, nil
+}
+
+// writeObject replaces the file of entry with its backed up content
+func (s *Store) writeObject(entry Entry) error {
+	in, err := os.Open(s.objectPath(entry.Hash))
+	if err != nil {
+		return fmt.Errorf("the content of backup %d is missing from the store: %w", entry.ID, err)
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(entry.Path), 0o755); err != nil {
+		return err
+	}
+	out, err := os.CreateTemp(filepath.Dir(entry.Path), "."+filepath.Base(entry.Path)+".gsn-partial-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Chmod(entry.Mode)
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), entry.Path)
+}
+
+// Prune keeps the newest keep backups of every path and returns how many were dropped
+func (s *Store) Prune(keep int) (int, error) {
+	dropped := 0
+	err := s.update(func() error {
+		before := len(s.index.Entries)
+		paths := make(map[string]bool)
+		for _, e := range s.index.Entries {
+			paths[e.Path] = true
+		}
+		for path := range paths {
+			s.retain(path, keep)
+		}
+		dropped = before - len(s.index.Entries)
+		return nil
+	})
+	return dropped, err
+}
+
+// retain drops all but the newest keep backups of path, a keep below 1 keeps everything
+func (s *Store) retain(path string, keep int) {
+	if keep < 1 {
+		return
+	}
+	count := 0
+	for _, e := range s.index.Entries {
+		if e.Path == path {
+			count++
+		}
+	}
+	s.index.Entries = slices.DeleteFunc(s.index.Entries, func(e Entry) bool {
+		if e.Path != path || count <= keep {
+			return false
+		}
+		count--
+		return true
+	})
+}
+
+// save writes the index and removes the objects no backup refers to anymore
+func (s *Store) save() error {
+	s.index.SchemaVersion = indexKind.Version
+	data, err := json.MarshalIndent(s.index, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.Dir, 0o700); err != nil {
+		return err
+	}
+	if err := output.WriteFileAtomic(s.indexPath(), append(data, '\n'), 0o600); err != nil {
+		return err
+	}
+
+	used := make(map[string]bool)
+	for _, e := range s.index.Entries {
+		used[e.Hash] = true
+	}
+	objectsDir := filepath.Join(s.Dir, "objects")
+	dirs, _ := os.ReadDir(objectsDir)
+	for _, dir := range dirs {
+		dir := filepath.Join(objectsDir, dir.Name())
+		objects, _ := os.ReadDir(dir)
+		for _, object := range objects {
+			// Dot files are objects still being written
+			if name := object.Name(); !used[name] && !strings.HasPrefix(name, ".") {
+				os.Remove(filepath.Join(dir, name))
+			}
+		}
+		os.Remove(dir) // Only succeeds once the directory is empty
+	}
+	return nil
+}
+
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
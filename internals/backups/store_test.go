@@ -0,0 +1,330 @@
+package backups
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/state"
+)
+
+func sha(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func write(t *testing.T, path string, content string, mode os.FileMode) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), mode); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func read(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// objects lists the hashes of the contents kept in the store
+func objects(t *testing.T, s *Store) []string {
+	t.Helper()
+	var hashes []string
+	filepath.WalkDir(filepath.Join(s.Dir, "objects"), func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			hashes = append(hashes, d.Name())
+		}
+		return nil
+	})
+	slices.Sort(hashes)
+	return hashes
+}
+
+// ids returns the IDs of entries
+func ids(entries []Entry) []int {
+	var result []int
+	for _, e := range entries {
+		result = append(result, e.ID)
+	}
+	return result
+}
+
+func TestBackupDedupesContents(t *testing.T) {
+	s := &Store{Dir: t.TempDir()}
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.yaml"), filepath.Join(dir, "b.yaml")
+	write(t, a, "shared", 0o640)
+	write(t, b, "shared", 0o600)
+
+	first, err := s.Backup(a, "cp", DefaultKeep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ID != 1 || first.Path != a || first.Hash != sha("shared") || first.Size != 6 || first.Mode != 0o640 || first.Command != "cp" || first.BackedUpAt.IsZero() {
+		t.Errorf("first backup = %+v", first)
+	}
+	// The same content under another path, and again under the same path, is stored once
+	if _, err := s.Backup(b, "extract", DefaultKeep); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Backup(a, "cp", DefaultKeep); err != nil {
+		t.Fatal(err)
+	}
+	if got := objects(t, s); !slices.Equal(got, []string{sha("shared")}) {
+		t.Errorf("objects = %v", got)
+	}
+	if data := read(t, s.objectPath(sha("shared"))); data != "shared" {
+		t.Errorf("object = %q", data)
+	}
+
+	all, _ := s.List("")
+	ofA, _ := s.List(a)
+	if !slices.Equal(ids(all), []int{1, 2, 3}) || !slices.Equal(ids(ofA), []int{1, 3}) {
+		t.Errorf("List = %v, List(a) = %v", ids(all), ids(ofA))
+	}
+
+	// Only regular files are backed up
+	if err := os.Symlink(a, filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{filepath.Join(dir, "missing"), filepath.Join(dir, "link"), dir} {
+		if entry, err := s.Backup(path, "cp", DefaultKeep); entry != nil || err != nil {
+			t.Errorf("Backup(%s) = %+v, %v", path, entry, err)
+		}
+	}
+	if all, _ := s.List(""); len(all) != 3 {
+		t.Errorf("%d backups after skipped paths", len(all))
+	}
+}
+
+func TestRetention(t *testing.T) {
+	s := &Store{Dir: t.TempDir()}
+	dir := t.TempDir()
+	config, other := filepath.Join(dir, "config.yaml"), filepath.Join(dir, "other.yaml")
+
+	write(t, other, "v1", 0o644)
+	if _, err := s.Backup(other, "cp", 3); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 4; i++ {
+		write(t, config, fmt.Sprintf("v%d", i), 0o644)
+		if _, err := s.Backup(config, "cp", 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The oldest backup of config is dropped, its content stays since other.yaml still refers to it
+	if entries, _ := s.List(config); !slices.Equal(ids(entries), []int{3, 4, 5}) {
+		t.Errorf("backups of config = %v", ids(entries))
+	}
+	if got := objects(t, s); len(got) != 4 || !slices.Contains(got, sha("v1")) {
+		t.Errorf("objects = %v", got)
+	}
+
+	// A keep below 1 keeps every backup
+	write(t, config, "v5", 0o644)
+	if _, err := s.Backup(config, "cp", 0); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := s.List(config); len(entries) != 4 {
+		t.Errorf("%d backups of config with keep 0", len(entries))
+	}
+
+	dropped, err := s.Prune(1)
+	if err != nil || dropped != 3 {
+		t.Errorf("Prune = %d, %v", dropped, err)
+	}
+	all, _ := s.List("")
+	if !slices.Equal(ids(all), []int{1, 6}) {
+		t.Errorf("after prune = %v", ids(all))
+	}
+	want := []string{sha("v1"), sha("v5")}
+	slices.Sort(want)
+	if got := objects(t, s); !slices.Equal(got, want) {
+		t.Errorf("objects after prune = %v, want the contents of v1 and v5", got)
+	}
+	if dropped, _ := s.Prune(1); dropped != 0 {
+		t.Errorf("second prune dropped %d", dropped)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	s := &Store{Dir: t.TempDir()}
+	path := filepath.Join(t.TempDir(), "settings.json")
+
+	// cp backs up v1 and writes v2 over it
+	write(t, path, "v1", 0o600)
+	entry, err := s.Backup(path, "cp", DefaultKeep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(t, path, "v2", 0o644)
+	if err := s.Written(entry, sha("v2")); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := s.Restore(path, 0, false, DefaultKeep)
+	if err != nil || restored.ID != entry.ID {
+		t.Fatalf("Restore = %+v, %v", restored, err)
+	}
+	if info, _ := os.Stat(path); read(t, path) != "v1" || info.Mode().Perm() != 0o600 {
+		t.Errorf("restored %q with mode %o", read(t, path), info.Mode().Perm())
+	}
+
+	// The content replaced by the restore was backed up, so the restore can be undone
+	entries, _ := s.List(path)
+	undo := entries[len(entries)-1]
+	if len(entries) != 2 || undo.Hash != sha("v2") || undo.Command != "backups restore" || undo.Written != sha("v1") || undo.Mode != 0o644 {
+		t.Fatalf("backups after the restore = %+v", entries)
+	}
+	if _, err := s.Restore(path, 0, false, DefaultKeep); err != nil {
+		t.Fatal(err)
+	}
+	if read(t, path) != "v2" {
+		t.Errorf("undone restore left %q", read(t, path))
+	}
+
+	// Restoring what the file holds already changes nothing
+	before, _ := s.List(path)
+	if _, err := s.Restore(path, undo.ID, false, DefaultKeep); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := s.List(path); len(after) != len(before) {
+		t.Errorf("a no-op restore added backups: %v", ids(after))
+	}
+
+	// A file changed after gsn wrote it is only replaced with --force
+	write(t, path, "edited by hand", 0o644)
+	_, err = s.Restore(path, entry.ID, false, DefaultKeep)
+	if clierr.CodeOf(err) != clierr.Conflict || !strings.Contains(err.Error(), "changed after backups restore wrote it, use --force") {
+		t.Errorf("restore over an edited file = %v", err)
+	}
+	if read(t, path) != "edited by hand" {
+		t.Errorf("the refused restore wrote %q", read(t, path))
+	}
+	if _, err := s.Restore(path, entry.ID, true, DefaultKeep); err != nil || read(t, path) != "v1" {
+		t.Errorf("forced restore = %v, %q", err, read(t, path))
+	}
+	if entries, _ := s.List(path); entries[len(entries)-1].Hash != sha("edited by hand") {
+		t.Error("the forced restore did not back up the edited content")
+	}
+
+	// A deleted file is written back, with nothing to back up first
+	os.Remove(path)
+	before, _ = s.List(path)
+	if _, err := s.Restore(path, entry.ID, false, DefaultKeep); err != nil || read(t, path) != "v1" {
+		t.Errorf("restore of a deleted file = %v", err)
+	}
+	if after, _ := s.List(path); len(after) != len(before) {
+		t.Errorf("%d backups after restoring a deleted file, want %d", len(after), len(before))
+	}
+}
+
+func TestRestoreRefuses(t *testing.T) {
+	s := &Store{Dir: t.TempDir()}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "notes.txt")
+	write(t, path, "v1", 0o644)
+	if _, err := s.Backup(path, "extract", DefaultKeep); err != nil {
+		t.Fatal(err)
+	}
+	write(t, path, "v2", 0o644)
+
+	tests := []struct {
+		path    string
+		id      int
+		code    clierr.Code
+		wantErr string
+	}{
+		{filepath.Join(dir, "other.txt"), 0, clierr.NotFound, "there is no backup of"},
+		{path, 42, clierr.NotFound, "has no backup 42"},
+		// Nothing recorded what extract wrote
+		{path, 0, clierr.Conflict, "cannot tell whether '" + path + "' changed after extract wrote it, use --force to replace it"},
+	}
+	for _, test := range tests {
+		if _, err := s.Restore(test.path, test.id, false, DefaultKeep); clierr.CodeOf(err) != test.code || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("Restore(%s, %d) = %v, want %q", test.path, test.id, err, test.wantErr)
+		}
+	}
+
+	// A backup whose content went missing from the store fails without touching the file
+	os.Remove(s.objectPath(sha("v1")))
+	if _, err := s.Restore(path, 0, true, DefaultKeep); err == nil || !strings.Contains(err.Error(), "the content of backup 1 is missing from the store") {
+		t.Errorf("restore of a missing object = %v", err)
+	}
+	if read(t, path) != "v2" {
+		t.Errorf("the failed restore left %q", read(t, path))
+	}
+}
+
+func TestConcurrentBackupsKeepEachOther(t *testing.T) {
+	dir, storeDir := t.TempDir(), t.TempDir()
+	var wg sync.WaitGroup
+	for i := range 12 {
+		path := filepath.Join(dir, fmt.Sprintf("file-%02d", i))
+		write(t, path, fmt.Sprint(i), 0o644)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Each like a gsn process of its own, with its own view of the index
+			if _, err := (&Store{Dir: storeDir}).Backup(path, "cp", DefaultKeep); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	s := &Store{Dir: storeDir}
+	if err := s.load(); err != nil {
+		t.Fatal(err)
+	}
+	all, _ := s.List("")
+	got := ids(all)
+	slices.Sort(got)
+	if len(got) != 12 || got[0] != 1 || got[11] != 12 || len(objects(t, s)) != 12 {
+		t.Errorf("IDs %v, %d objects", got, len(objects(t, s)))
+	}
+}
+
+func TestOpen(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("GSN_HOME", home)
+	s, err := Open()
+	if err != nil || s.Dir != filepath.Join(home, "state", "backups") {
+		t.Fatalf("Open = %+v, %v", s, err)
+	}
+	path := filepath.Join(t.TempDir(), "a")
+	write(t, path, "a", 0o644)
+	if _, err := s.Backup(path, "cp", DefaultKeep); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(s.indexPath()); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("index: %v, %v", info, err)
+	}
+
+	reopened, err := Open()
+	if entries, _ := reopened.List(path); err != nil || len(entries) != 1 {
+		t.Errorf("reopened store has %d backups of %s, %v", len(entries), path, err)
+	}
+
+	// An index written by a newer gsn is refused
+	write(t, s.indexPath(), `{"schema_version":2,"next_id":1,"entries":[]}`, 0o600)
+	_, err = Open()
+	var newer *state.NewerError
+	if !errors.As(err, &newer) || clierr.CodeOf(err) != clierr.Conflict {
+		t.Errorf("Open with a newer index = %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package certificates
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/style"
+
+	"github.com/spf13/cobra"
+)
+
+func bundleCertsCmd() *cobra.Command {
+	bundleCmd := &cobra.Command{
+		Use:   "bundle --leaf <leaf.pem> --certs <dir> -o <fullchain.pem>",
+		Short: "Assembles a leaf-first certificate chain from loose certificates",
+		Long: `Follows the issuer of the leaf through the certificates found below --certs, matching subject/issuer names,
+authority/subject key identifiers and signatures, and writes the chain leaf first. The self-signed root is left out
+unless --include-root is set. Missing intermediates can be downloaded from the AIA URL with --fetch-missing.`,
+		Example: `  gsn cert bundle --leaf server.pem --certs ./ca -o fullchain.pem
+  gsn cert bundle --leaf server.pem --certs ./ca --fetch-missing --include-root`,
+		Args: cobra.NoArgs,
+		Run:  BundleCertificates,
+	}
+
+	bundleCmd.Flags().String("leaf", "", "Leaf certificate file")
+	bundleCmd.Flags().String("certs", "", "Directory holding intermediate and root certificates")
+	bundleCmd.Flags().StringP("output", "o", "fullchain.pem", "Output file for the chain")
+	bundleCmd.Flags().Bool("include-root", false, "Append the self-signed root to the chain")
+	bundleCmd.Flags().Bool("fetch-missing", false, "Download missing intermediates from the AIA CA Issuers URL")
+	bundleCmd.Flags().Duration("timeout", 10*time.Second, "Timeout for downloading missing intermediates")
+	bundleCmd.MarkFlagRequired("leaf")
+	return bundleCmd
+}
+
+func BundleCertificates(cmd *cobra.Command, args []string) {
+	leafPath, _ := cmd.Flags().GetString("leaf")
+	certsDir, _ := cmd.Flags().GetString("certs")
+	outputPath, _ := cmd.Flags().GetString("output")
+	includeRoot, _ := cmd.Flags().GetBool("include-root")
+	fetchMissing, _ := cmd.Flags().GetBool("fetch-missing")
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+
+	data, err := os.ReadFile(leafPath)
+	if err != nil {
+		clierr.Fatalf("Error reading leaf: %v", err)
+	}
+	leafCerts := parseCertificates(data)
+	if len(leafCerts) == 0 {
+		clierr.Fatalf("No certificate found in '%s'", leafPath)
+	}
+
+	// Extra certificates in the leaf file are candidates too, e.g. an existing partial chain
+	pool := leafCerts[1:]
+	if certsDir != "" {
+		scanned, err := scanDirectory(certsDir)
+		if err != nil {
+			clierr.Fatalf("Error scanning '%s': %v", certsDir, err)
+		}
+		for _, c := range scanned {
+			pool = append(pool, c.Cert)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
+	defer cancel()
+
+	chain, root, err := buildChain(ctx, leafCerts[0], pool, fetchMissing)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	if err := verifyChain(chain, root); err != nil {
+		clierr.Fatalf("Chain does not verify: %v", err)
+	}
+	if includeRoot {
+		if root == nil {
+			fmt.Fprintln(os.Stderr, style.Warning()+"Root certificate not found, writing the chain without it")
+		} else {
+			chain = append(chain, root)
+		}
+	}
+
+	var out bytes.Buffer
+	for _, c := range chain {
+		pem.Encode(&out, &pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})
+	}
+	if err := os.WriteFile(outputPath, out.Bytes(), 0o644); err != nil {
+		clierr.Fatalf("Error writing '%s': %v", outputPath, err)
+	}
+
+	for i, c := range chain {
+		fmt.Printf("  %d: %s\n", i, c.Subject)
+	}
+	fmt.Printf(style.Success()+"Wrote %d certificate(s) to %s\n", len(chain), outputPath)
+}
+
+// buildChain walks from leaf to its root through pool. It returns the chain without the root, and the root
+// when one was found. Loops, ambiguous issuers and missing intermediates are errors.
+func buildChain(ctx context.Context, leaf *x509.Certificate, pool []*x509.Certificate, fetchMissing bool) ([]*x509.Certificate, *x509.Certificate, error) {
+	chain := []*x509.Certificate{leaf}
+	seen := map[[32]byte]bool{sha256.Sum256(leaf.Raw): true}
+
+	current := leaf
+	for {
+		if isSelfSigned(current) {
+			if current == leaf {
+				return nil, nil, fmt.Errorf("'%s' is self-signed, there is no chain to build", leaf.Subject)
+			}
+			return chain[:len(chain)-1], current, nil
+		}
+
+		issuers := findIssuers(current, pool)
+		if len(issuers) == 0 && fetchMissing && len(current.IssuingCertificateURL) > 0 {
+			fetched, err := fetchIssuer(ctx, current)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to fetch issuer of '%s': %w", current.Subject, err)
+			}
+			issuers = findIssuers(current, []*x509.Certificate{fetched})
+		}
+
+		switch len(issuers) {
+		case 0:
+			// The chain may end at a root that only lives in the system trust store
+			if _, err := current.Verify(x509.VerifyOptions{Intermediates: x509.NewCertPool(), KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err == nil {
+				return chain, nil, nil
+			}
+			return nil, nil, fmt.Errorf("missing intermediate: no certificate issued '%s' (issuer '%s')", current.Subject, current.Issuer)
+		case 1:
+		default:
+			return nil, nil, fmt.Errorf("ambiguous issuer for '%s': %d candidates match '%s'", current.Subject, len(issuers), current.Issuer)
+		}
+
+		issuer := issuers[0]
+		sum := sha256.Sum256(issuer.Raw)
+		if seen[sum] {
+			return nil, nil, fmt.Errorf("certificate loop detected at '%s'", issuer.Subject)
+		}
+		seen[sum] = true
+		chain = append(chain, issuer)
+		current = issuer
+	}
+}
+
+// findIssuers returns the distinct certificates of pool that issued cert
+func findIssuers(cert *x509.Certificate, pool []*x509.Certificate) []*x509.Certificate {
+	var found []*x509.Certificate
+	seen := make(map[[32]byte]bool)
+	for _, candidate := range pool {
+		if !bytes.Equal(candidate.RawSubject, cert.RawIssuer) {
+			continue
+		}
+		if len(cert.AuthorityKeyId) > 0 && len(candidate.SubjectKeyId) > 0 && !bytes.Equal(cert.AuthorityKeyId, candidate.SubjectKeyId) {
+			continue
+		}
+		if cert.CheckSignatureFrom(candidate) != nil {
+			continue
+		}
+
+		sum := sha256.Sum256(candidate.Raw)
+		if !seen[sum] {
+			seen[sum] = true
+			found = append(found, candidate)
+		}
+	}
+	return found
+}
+
+// isSelfSigned reports whether cert is a root signing itself
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawSubject, cert.RawIssuer) && cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+}
+
+// verifyChain checks that the leaf verifies through the chain, against root or the system roots
+func verifyChain(chain []*x509.Certificate, root *x509.Certificate) error {
+	opts := x509.VerifyOptions{
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	for _, c := range chain[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	if root != nil {
+		opts.Roots = x509.NewCertPool()
+		opts.Roots.AddCert(root)
+	}
+
+	_, err := chain[0].Verify(opts)
+	return err
+}
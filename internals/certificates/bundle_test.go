@@ -0,0 +1,191 @@
+package certificates
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testHierarchy is a root, two intermediates below it and a leaf issued by the second intermediate
+type testHierarchy struct {
+	root, int1, int2, leaf *testCert
+}
+
+func newTestHierarchy(t *testing.T) testHierarchy {
+	t.Helper()
+	var h testHierarchy
+	h.root = newTestCA(t, "Test Root", nil)
+	h.int1 = newTestCA(t, "Test Intermediate 1", h.root)
+	h.int2 = newTestCA(t, "Test Intermediate 2", h.int1)
+	h.leaf = newTestLeaf(t, "www.example.com", h.int2, nil)
+	return h
+}
+
+// subjects lists the common names of certs, in order
+func subjects(certs []*x509.Certificate) string {
+	var names []string
+	for _, c := range certs {
+		names = append(names, c.Subject.CommonName)
+	}
+	return strings.Join(names, " > ")
+}
+
+// verifyLeafFirst verifies chain[0] through the rest of chain against root, the way a TLS client does
+func verifyLeafFirst(t *testing.T, chain []*x509.Certificate, root *x509.Certificate) {
+	t.Helper()
+	roots, intermediates := x509.NewCertPool(), x509.NewCertPool()
+	roots.AddCert(root)
+	for _, c := range chain[1:] {
+		intermediates.AddCert(c)
+	}
+	verified, err := chain[0].Verify(x509.VerifyOptions{
+		DNSName:       "www.example.com",
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	if err != nil {
+		t.Fatalf("the chain %s does not verify: %v", subjects(chain), err)
+	}
+	if got := subjects(verified[0]); got != "www.example.com > Test Intermediate 2 > Test Intermediate 1 > Test Root" {
+		t.Errorf("verified path = %s", got)
+	}
+}
+
+func TestBuildChain(t *testing.T) {
+	h := newTestHierarchy(t)
+	unrelated := newTestCA(t, "Unrelated Root", nil)
+	pool := []*x509.Certificate{unrelated.Cert, h.root.Cert, newTestLeaf(t, "other", unrelated, nil).Cert, h.int1.Cert, h.int2.Cert}
+
+	chain, root, err := buildChain(context.Background(), h.leaf.Cert, pool, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := subjects(chain); got != "www.example.com > Test Intermediate 2 > Test Intermediate 1" {
+		t.Errorf("chain = %s, want it leaf first without the root", got)
+	}
+	if root == nil || !root.Equal(h.root.Cert) {
+		t.Fatalf("root = %v, want Test Root", root)
+	}
+	if err := verifyChain(chain, root); err != nil {
+		t.Errorf("verifyChain = %v", err)
+	}
+	verifyLeafFirst(t, chain, root)
+}
+
+func TestBuildChainErrors(t *testing.T) {
+	h := newTestHierarchy(t)
+
+	_, _, err := buildChain(context.Background(), h.leaf.Cert, []*x509.Certificate{h.root.Cert, h.int2.Cert}, false)
+	if err == nil || !strings.Contains(err.Error(), "missing intermediate") || !strings.Contains(err.Error(), "Test Intermediate 1") {
+		t.Errorf("without Intermediate 1 = %v, want a missing intermediate", err)
+	}
+
+	_, _, err = buildChain(context.Background(), h.root.Cert, nil, false)
+	if err == nil || !strings.Contains(err.Error(), "self-signed") {
+		t.Errorf("from the root = %v, want it refused", err)
+	}
+
+	// The same subject and key certified twice: both verify, so which one belongs in the chain is a guess
+	cross := newTestCert(t, &x509.Certificate{
+		Subject:               h.int2.Cert.Subject,
+		SubjectKeyId:          h.int2.Cert.SubjectKeyId,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, h.int1, h.int2.Key)
+	_, _, err = buildChain(context.Background(), h.leaf.Cert, []*x509.Certificate{h.root.Cert, h.int1.Cert, h.int2.Cert, cross.Cert}, false)
+	if err == nil || !strings.Contains(err.Error(), "ambiguous issuer") {
+		t.Errorf("with two issuers = %v, want it ambiguous", err)
+	}
+
+	// A and B certify each other, the walk comes back to A
+	keyA, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	keyB, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	nameA, nameB := pkix.Name{CommonName: "Loop A"}, pkix.Name{CommonName: "Loop B"}
+	stubA := &testCert{Cert: &x509.Certificate{Subject: nameA, SubjectKeyId: []byte{0xa}}, Key: keyA}
+	stubB := &testCert{Cert: &x509.Certificate{Subject: nameB, SubjectKeyId: []byte{0xb}}, Key: keyB}
+	caTemplate := func(name pkix.Name, ski byte) *x509.Certificate {
+		return &x509.Certificate{Subject: name, SubjectKeyId: []byte{ski}, IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}
+	}
+	loopA := newTestCert(t, caTemplate(nameA, 0xa), stubB, keyA)
+	loopB := newTestCert(t, caTemplate(nameB, 0xb), stubA, keyB)
+	loopLeaf := newTestLeaf(t, "www.example.com", loopA, nil)
+	_, _, err = buildChain(context.Background(), loopLeaf.Cert, []*x509.Certificate{loopA.Cert, loopB.Cert}, false)
+	if err == nil || !strings.Contains(err.Error(), "loop") {
+		t.Errorf("with a loop = %v, want it detected", err)
+	}
+}
+
+func TestBuildChainFetchesMissingIntermediate(t *testing.T) {
+	root := newTestCA(t, "Test Root", nil)
+	intermediate := newTestCA(t, "Test Intermediate", root)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// CA Issuers URLs serve DER
+		w.Header().Set("Content-Type", "application/pkix-cert")
+		_, _ = w.Write(intermediate.Cert.Raw)
+	}))
+	defer server.Close()
+	leaf := newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "www.example.com"},
+		DNSNames:              []string{"www.example.com"},
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IssuingCertificateURL: []string{server.URL + "/intermediate.cer"},
+	}, intermediate, nil)
+
+	if _, _, err := buildChain(context.Background(), leaf.Cert, []*x509.Certificate{root.Cert}, false); err == nil {
+		t.Fatal("built a chain without the intermediate and without fetching it")
+	}
+	chain, gotRoot, err := buildChain(context.Background(), leaf.Cert, []*x509.Certificate{root.Cert}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := subjects(chain); got != "www.example.com > Test Intermediate" || gotRoot == nil {
+		t.Errorf("chain = %s, root %v", got, gotRoot)
+	}
+}
+
+func TestBundleCommandWritesLeafFirst(t *testing.T) {
+	h := newTestHierarchy(t)
+	dir := t.TempDir()
+	leafPath := writeTestFile(t, dir, "leaf.pem", certPEM(h.leaf))
+	// Loose and out of order, the root among the intermediates
+	writeTestFile(t, dir, "ca/root.pem", certPEM(h.root))
+	writeTestFile(t, dir, "ca/both.pem", certPEM(h.int1, h.int2))
+
+	for _, includeRoot := range []bool{false, true} {
+		out := filepath.Join(dir, "fullchain.pem")
+		cmd := bundleCertsCmd()
+		args := []string{"--leaf", leafPath, "--certs", filepath.Join(dir, "ca"), "-o", out}
+		if includeRoot {
+			args = append(args, "--include-root")
+		}
+		cmd.SetArgs(args)
+		if err := cmd.Execute(); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chain := parseCertificates(data)
+		want := "www.example.com > Test Intermediate 2 > Test Intermediate 1"
+		if includeRoot {
+			want += " > Test Root"
+		}
+		if got := subjects(chain); got != want {
+			t.Errorf("--include-root=%v wrote %s, want %s", includeRoot, got, want)
+		}
+		verifyLeafFirst(t, chain, h.root.Cert)
+	}
+}
@@ -0,0 +1,169 @@
+package certificates
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/style"
+
+	"github.com/spf13/cobra"
+)
+
+func connectCertsCmd() *cobra.Command {
+	connectCmd := &cobra.Command{
+		Use:   "connect <host:port>",
+		Short: "Connects to a TLS server, optionally with a client certificate, and reports the handshake",
+		Long: `Performs a TLS handshake with host:port, presenting --cert/--key when given, and prints the negotiated version,
+cipher suite, the verification result against --ca (or the system roots) and the chain the server presented.
+With --message the text is sent after the handshake and the first reply line is printed, e.g. against cert serve.`,
+		Example: `  gsn cert connect example.com:443
+  gsn cert connect localhost:8443 --ca ca.pem --cert client.pem --key client.key --message ping`,
+		Args: cobra.ExactArgs(1),
+		Run:  ConnectTLS,
+	}
+
+	connectCmd.Flags().String("cert", "", "Client certificate (PEM) for mutual TLS")
+	connectCmd.Flags().String("key", "", "Client private key (PEM) for mutual TLS")
+	connectCmd.Flags().String("ca", "", "CA file used to verify the server instead of the system roots")
+	connectCmd.Flags().String("server-name", "", "Server name for SNI and verification (default: host)")
+	connectCmd.Flags().String("message", "", "Line to send after the handshake")
+	connectCmd.Flags().Duration("timeout", 10*time.Second, "Timeout for the connection and handshake")
+	return connectCmd
+}
+
+func ConnectTLS(cmd *cobra.Command, args []string) {
+	certPath, _ := cmd.Flags().GetString("cert")
+	keyPath, _ := cmd.Flags().GetString("key")
+	caPath, _ := cmd.Flags().GetString("ca")
+	serverName, _ := cmd.Flags().GetString("server-name")
+	message, _ := cmd.Flags().GetString("message")
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+
+	if serverName == "" {
+		host, _, err := net.SplitHostPort(args[0])
+		if err != nil {
+			clierr.Exitf(clierr.Usage, "'%s' is not host:port", args[0])
+		}
+		serverName = host
+	}
+
+	config, err := clientTLSConfig(certPath, keyPath, caPath, serverName)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
+	defer cancel()
+
+	result, err := connectTLS(ctx, args[0], config, message)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, style.Failure()+"Handshake with %s failed: %v\n", args[0], err)
+		clierr.Exit(clierr.CodeOf(err))
+	}
+
+	fmt.Printf(style.Success()+"Connected to %s: %s\n", args[0], handshakeSummary(result.State))
+	if result.VerifyErr != nil {
+		fmt.Printf(style.Warning()+"Server chain does not verify: %v\n", result.VerifyErr)
+	} else {
+		fmt.Println("Server chain verified")
+	}
+	fmt.Println("Server chain:")
+	for i, c := range result.State.PeerCertificates {
+		fmt.Printf("  %d: %s (issuer %s, expires %s)\n", i, c.Subject, c.Issuer, c.NotAfter.Format(time.RFC3339))
+	}
+	if message != "" {
+		fmt.Printf("Reply: %s\n", result.Reply)
+	}
+	if result.VerifyErr != nil {
+		clierr.Exit(clierr.Failure)
+	}
+}
+
+// clientTLSConfig builds the client side configuration. Verification is done after the handshake so
+// the chain can still be reported when it does not verify.
+func clientTLSConfig(certPath string, keyPath string, caPath string, serverName string) (*tls.Config, error) {
+	config := &tls.Config{ServerName: serverName, InsecureSkipVerify: true}
+	if certPath != "" || keyPath != "" {
+		pair, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client key pair: %w", err)
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	if caPath != "" {
+		pool, err := loadCertPool(caPath)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// connectResult is what `cert connect` learned from a server
+type connectResult struct {
+	State     tls.ConnectionState
+	VerifyErr error
+	Reply     string
+}
+
+// connectTLS performs the handshake, verifies the server chain and optionally exchanges one line
+func connectTLS(ctx context.Context, addr string, config *tls.Config, message string) (*connectResult, error) {
+	dialer := &tls.Dialer{Config: config}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	tlsConn := conn.(*tls.Conn)
+	result := &connectResult{State: tlsConn.ConnectionState()}
+	result.VerifyErr = verifyServerChain(result.State, config)
+
+	if message == "" {
+		// With TLS 1.3 the server checks the client certificate after the client finished its side of the
+		// handshake, a rejection only shows up as an alert on the first read
+		tlsConn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+		_, err := tlsConn.Read(make([]byte, 1))
+		var netErr net.Error
+		if err != nil && err != io.EOF && !(errors.As(err, &netErr) && netErr.Timeout()) {
+			return nil, err
+		}
+	} else {
+		if deadline, ok := ctx.Deadline(); ok {
+			tlsConn.SetDeadline(deadline)
+		}
+		if _, err := fmt.Fprintln(tlsConn, message); err != nil {
+			return nil, err
+		}
+		reply, err := bufio.NewReader(tlsConn).ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		result.Reply = reply[:len(reply)-1]
+	}
+	return result, nil
+}
+
+// verifyServerChain checks the presented chain against the configured roots and the server name
+func verifyServerChain(state tls.ConnectionState, config *tls.Config) error {
+	if len(state.PeerCertificates) == 0 {
+		return fmt.Errorf("server presented no certificate")
+	}
+
+	opts := x509.VerifyOptions{Roots: config.RootCAs, DNSName: config.ServerName, Intermediates: x509.NewCertPool()}
+	for _, c := range state.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	_, err := state.PeerCertificates[0].Verify(opts)
+	return err
+}
@@ -12,8 +12,13 @@ import (
 	"fmt"
 	"math/big"
 	"net/url"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	"gsn-dev-tools/internals/clierr"
+
 	"github.com/spf13/cobra"
 )
 
@@ -33,13 +38,94 @@ func GenerateCertsCmd() *cobra.Command {
 	certCmd := cobra.Command{
 		Use:   "csr <hash_algorithm>",
 		Short: "Generates private key, csr and signed certificate to be used",
-		Long:  "Generate a CSR and a signed certificate based on an specific hashing algorithm.",
-		Run:   CertificateGeneration,
+		Long: `Generate a CSR and a signed certificate based on an specific hashing algorithm: sha1, sha256, sha384 or
+sha512. Subject attributes beyond the standard fields, the DC and those added with --subject-attr, are carried
+from the CSR into the certificate.
+
+--strict checks the certificate against the rules public CAs enforce before anything is generated: a validity
+of at most 398 days, subject alternative names that include the common name, no SHA-1, RSA keys of at least
+2048 bits, a wildcard only as the whole leftmost label and no IP address as common name. Every broken rule is
+listed with its identifier and gsn csr exits with 2; --strict=warn only prints them as warnings.`,
+		Example: `  gsn csr sha256
+  gsn csr sha256 --subject-attr 1.2.840.113549.1.9.1=pki@example.com
+  gsn csr sha256 --cn api.example.com --san api.example.com --san "*.api.example.com" --days 397 --strict
+  gsn csr sha1 --strict=warn`,
+		Args: cobra.ExactArgs(1),
+		Run:  CertificateGeneration,
 	}
 
+	certCmd.Flags().StringArray("subject-attr", nil, "Add a subject attribute as OID=value, e.g. 2.5.4.12=Engineer (repeatable)")
+	certCmd.Flags().String("cn", defaultCommonName, "Common name of the subject, also added to the subject alternative names")
+	certCmd.Flags().StringArray("san", []string{defaultAltName}, "Subject alternative name (repeatable)")
+	certCmd.Flags().Int("days", 365, "Validity of the certificate in days")
+	addStrictFlag(&certCmd)
 	return &certCmd
 }
 
+// The subject the certificates were always generated for, kept as defaults of --cn and --san
+const (
+	defaultCommonName = "USAMZS00000000000000000000001372070201E"
+	defaultAltName    = "https://www.powerflex.com"
+)
+
+// csrSignatureAlgorithms maps the hash_algorithm argument to the signature of the ECDSA key
+var csrSignatureAlgorithms = map[string]x509.SignatureAlgorithm{
+	"sha1":   x509.ECDSAWithSHA1,
+	"sha256": x509.ECDSAWithSHA256,
+	"sha384": x509.ECDSAWithSHA384,
+	"sha512": x509.ECDSAWithSHA512,
+}
+
+// standardSubjectOIDs are the attributes pkix.Name keeps in its own fields, every other attribute of a parsed
+// subject is only found in Names and is lost unless copied to ExtraNames
+var standardSubjectOIDs = []asn1.ObjectIdentifier{
+	{2, 5, 4, 3},  // commonName
+	{2, 5, 4, 5},  // serialNumber
+	{2, 5, 4, 6},  // countryName
+	{2, 5, 4, 7},  // localityName
+	{2, 5, 4, 8},  // stateOrProvinceName
+	{2, 5, 4, 9},  // streetAddress
+	{2, 5, 4, 10}, // organizationName
+	{2, 5, 4, 11}, // organizationalUnitName
+	{2, 5, 4, 17}, // postalCode
+}
+
+// withExtraNames returns a parsed subject whose custom attributes, like DC, are carried in ExtraNames so they
+// are written again when the subject is marshalled
+func withExtraNames(subject pkix.Name) pkix.Name {
+	subject.ExtraNames = nil
+	for _, attr := range subject.Names {
+		if !slices.ContainsFunc(standardSubjectOIDs, attr.Type.Equal) {
+			subject.ExtraNames = append(subject.ExtraNames, attr)
+		}
+	}
+	return subject
+}
+
+// parseSubjectAttrs parses --subject-attr values of the form OID=value
+func parseSubjectAttrs(values []string) ([]pkix.AttributeTypeAndValue, error) {
+	attrs := make([]pkix.AttributeTypeAndValue, 0, len(values))
+	for _, v := range values {
+		oidText, value, ok := strings.Cut(v, "=")
+		if !ok || value == "" {
+			return nil, clierr.Newf(clierr.Usage, "invalid --subject-attr '%s', expected OID=value", v)
+		}
+		var oid asn1.ObjectIdentifier
+		for _, part := range strings.Split(oidText, ".") {
+			n, err := strconv.Atoi(part)
+			if err != nil || n < 0 {
+				return nil, clierr.Newf(clierr.Usage, "invalid OID '%s' in --subject-attr", oidText)
+			}
+			oid = append(oid, n)
+		}
+		if len(oid) < 2 {
+			return nil, clierr.Newf(clierr.Usage, "invalid OID '%s' in --subject-attr", oidText)
+		}
+		attrs = append(attrs, pkix.AttributeTypeAndValue{Type: oid, Value: value})
+	}
+	return attrs, nil
+}
+
 // generateECDSAKeyPair generates an ECDSA key pair using P-256 curve
 func generateECDSAKeyPair() (*KeyPair, error) {
 	// Generate private key with P-256 curve
@@ -77,6 +163,8 @@ func createCSR(
 	locality string,
 	organization string,
 	organizationalUnit *string,
+	extraAttrs []pkix.AttributeTypeAndValue,
+	signatureAlgorithm x509.SignatureAlgorithm,
 ) (*CSRResult, error) {
 	// Build subject
 	subject := pkix.Name{
@@ -95,13 +183,12 @@ func createCSR(
 	if domainComponent != nil {
 		// Domain Component OID: 0.9.2342.19200300.100.1.25
 		dcOID := asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 25}
-		subject.ExtraNames = []pkix.AttributeTypeAndValue{
-			{
-				Type:  dcOID,
-				Value: *domainComponent,
-			},
-		}
+		subject.ExtraNames = append(subject.ExtraNames, pkix.AttributeTypeAndValue{
+			Type:  dcOID,
+			Value: *domainComponent,
+		})
 	}
+	subject.ExtraNames = append(subject.ExtraNames, extraAttrs...)
 
 	// Build DNS names for SAN
 	var dnsNames []string
@@ -123,7 +210,7 @@ func createCSR(
 	// Create CSR template
 	template := x509.CertificateRequest{
 		Subject:            subject,
-		SignatureAlgorithm: x509.ECDSAWithSHA256,
+		SignatureAlgorithm: signatureAlgorithm,
 		DNSNames:           finalDNS,
 	}
 
@@ -181,12 +268,13 @@ func signCSRToPKCS7(
 	// Create certificate template
 	template := x509.Certificate{
 		SerialNumber:          serialNumber,
-		Subject:               csr.Subject,
+		Subject:               withExtraNames(csr.Subject),
 		NotBefore:             notBefore,
 		NotAfter:              notAfter,
 		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment,
 		BasicConstraintsValid: true,
 		DNSNames:              csr.DNSNames,
+		SignatureAlgorithm:    csr.SignatureAlgorithm,
 	}
 
 	// Add URIs if present in alt names
@@ -235,29 +323,22 @@ func signCSRToPKCS7(
 	oidSignedData := asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
 	oidData := asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
 
-	// Empty digest algorithms
-	emptySet, _ := asn1.Marshal([]interface{}{})
+	// Empty digest algorithms and signer infos, both are a SET OF, a Go slice would marshal as a SEQUENCE
+	emptySet, _ := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true})
 
 	// Content info with data type
 	contentInfoData := contentInfo{
 		ContentType: oidData,
 	}
 
-	// Wrap certificate in a SET
-	certSet, err := asn1.Marshal([]asn1.RawValue{
-		{FullBytes: certBytes},
-	})
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal certificate set: %w", err)
-	}
-
 	// Create signed data
 	sd := signedData{
 		Version:          1,
 		DigestAlgorithms: asn1.RawValue{FullBytes: emptySet},
 		ContentInfo:      contentInfoData,
-		Certificates:     asn1.RawValue{FullBytes: certSet, Class: 2, Tag: 0, IsCompound: true},
-		SignerInfos:      asn1.RawValue{FullBytes: emptySet},
+		// The certificates are a [0] IMPLICIT SET OF Certificate, the tag replaces the one of the SET
+		Certificates: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certBytes},
+		SignerInfos:  asn1.RawValue{FullBytes: emptySet},
 	}
 
 	// Marshal signed data
@@ -290,39 +371,68 @@ func signCSRToPKCS7(
 }
 
 func CertificateGeneration(cmd *cobra.Command, args []string) {
+	attrValues, _ := cmd.Flags().GetStringArray("subject-attr")
+	commonName, _ := cmd.Flags().GetString("cn")
+	altNames, _ := cmd.Flags().GetStringArray("san")
+	validityDays, _ := cmd.Flags().GetInt("days")
+
+	signatureAlgorithm, ok := csrSignatureAlgorithms[strings.ToLower(args[0])]
+	if !ok {
+		clierr.Exitf(clierr.Usage, "invalid hash algorithm '%s', use sha1, sha256, sha384 or sha512", args[0])
+	}
+	if validityDays <= 0 {
+		clierr.Exitf(clierr.Usage, "--days must be positive")
+	}
+	strictMode, err := strictModeFromFlags(cmd)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	extraAttrs, err := parseSubjectAttrs(attrValues)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+
 	// Generate key pair
 	keyPair, err := generateECDSAKeyPair()
 	if err != nil {
 		fmt.Printf("Error generating key pair: %v\n", err)
 		return
 	}
-	fmt.Println("Generated ECDSA key pair with P-256 curve")
-
-	// Uncomment to print private key
-	fmt.Printf("Private key PEM:\n%s\n", keyPair.PrivateKeyPEM)
 
 	// Create CSR
 	domainComponent := "CSO"
 	csrResult, err := createCSR(
 		keyPair,
-		"USAMZS00000000000000000000001372070201E",
+		commonName,
 		&domainComponent,
-		[]string{"https://www.powerflex.com"},
+		altNames,
 		"US",
 		"California",
 		"San Diego",
 		"AMZ",
 		nil,
+		extraAttrs,
+		signatureAlgorithm,
 	)
 	if err != nil {
 		fmt.Printf("Error creating CSR: %v\n", err)
 		return
 	}
 
+	// The CSR is checked before anything is printed, a rejected configuration leaves no key behind
+	if err := enforceStrict(profileFromCSR(csrResult.CSR, validityDays), strictMode); err != nil {
+		clierr.Fatalf("%v", err)
+	}
+
+	fmt.Println("Generated ECDSA key pair with P-256 curve")
+
+	// Uncomment to print private key
+	fmt.Printf("Private key PEM:\n%s\n", keyPair.PrivateKeyPEM)
+
 	fmt.Printf("CSR PEM:\n%s\n", csrResult.CSRPEM)
 
 	// Sign CSR and get PKCS#7 certificate
-	pkcs7Cert, err := signCSRToPKCS7(csrResult.CSRPEM, keyPair.PrivateKey, 365)
+	pkcs7Cert, err := signCSRToPKCS7(csrResult.CSRPEM, keyPair.PrivateKey, validityDays)
 	if err != nil {
 		fmt.Printf("Error signing CSR: %v\n", err)
 		return
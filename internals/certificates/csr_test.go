@@ -0,0 +1,132 @@
+package certificates
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"gsn-dev-tools/internals/clierr"
+)
+
+// dcOID is the Domain Component attribute type
+var dcOID = asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 25}
+
+// parsePKCS7Certificates reads the certificates of a base64 PKCS#7 SignedData as RFC 2315 lays it out, the
+// certificates being a [0] IMPLICIT SET OF Certificate
+func parsePKCS7Certificates(t *testing.T, b64 string) []*x509.Certificate {
+	t.Helper()
+	der, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var outer struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue `asn1:"explicit,tag:0"`
+	}
+	if rest, err := asn1.Unmarshal(der, &outer); err != nil || len(rest) > 0 {
+		t.Fatalf("ContentInfo: %v, %d bytes left", err, len(rest))
+	}
+	if !outer.ContentType.Equal(asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}) {
+		t.Fatalf("content type = %v, want signedData", outer.ContentType)
+	}
+	var signed struct {
+		Version          int
+		DigestAlgorithms asn1.RawValue `asn1:"set"`
+		ContentInfo      asn1.RawValue
+		Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+		CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+		SignerInfos      asn1.RawValue `asn1:"set"`
+	}
+	if _, err := asn1.Unmarshal(outer.Content.Bytes, &signed); err != nil {
+		t.Fatalf("SignedData: %v", err)
+	}
+	if signed.DigestAlgorithms.Tag != asn1.TagSet || signed.SignerInfos.Tag != asn1.TagSet {
+		t.Fatalf("digestAlgorithms and signerInfos are tagged %d and %d, want SET", signed.DigestAlgorithms.Tag, signed.SignerInfos.Tag)
+	}
+	if signed.Certificates.Class != asn1.ClassContextSpecific || signed.Certificates.Tag != 0 {
+		t.Fatalf("certificates are tagged %d/%d, want [0] IMPLICIT", signed.Certificates.Class, signed.Certificates.Tag)
+	}
+	certs, err := x509.ParseCertificates(signed.Certificates.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certs
+}
+
+// attrValues returns the values of the attributes of type oid in a parsed subject
+func attrValues(subject pkix.Name, oid asn1.ObjectIdentifier) []string {
+	var values []string
+	for _, attr := range subject.Names {
+		if attr.Type.Equal(oid) {
+			values = append(values, fmt.Sprint(attr.Value))
+		}
+	}
+	return values
+}
+
+func TestCSRSubjectCarriesTheDC(t *testing.T) {
+	keyPair, err := generateECDSAKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	extra, err := parseSubjectAttrs([]string{"1.2.3.4=custom", "0.9.2342.19200300.100.1.25=EXAMPLE"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc, unit := "CSO", "Platform"
+	result, err := createCSR(keyPair, "device.example.com", &dc, []string{"alt.example.com"}, "US", "California", "San Diego", "AMZ", &unit,
+		extra, x509.ECDSAWithSHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The CSR as it is parsed back from its PEM
+	if got := attrValues(result.CSR.Subject, dcOID); !slices.Equal(got, []string{"CSO", "EXAMPLE"}) {
+		t.Errorf("DC of the CSR = %q, want CSO then EXAMPLE", got)
+	}
+	if got := attrValues(result.CSR.Subject, asn1.ObjectIdentifier{1, 2, 3, 4}); !slices.Equal(got, []string{"custom"}) {
+		t.Errorf("1.2.3.4 of the CSR = %q", got)
+	}
+
+	// The certificate issued for it keeps the DC along the standard fields
+	p7, err := signCSRToPKCS7(result.CSRPEM, keyPair.PrivateKey, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs := parsePKCS7Certificates(t, p7)
+	if len(certs) != 1 {
+		t.Fatalf("the PKCS#7 holds %d certificates, want 1", len(certs))
+	}
+	subject := certs[0].Subject
+	if got := attrValues(subject, dcOID); !slices.Equal(got, []string{"CSO", "EXAMPLE"}) {
+		t.Errorf("DC of the certificate = %q, want CSO then EXAMPLE", got)
+	}
+	if subject.CommonName != "device.example.com" || !slices.Equal(subject.Organization, []string{"AMZ"}) ||
+		!slices.Equal(subject.OrganizationalUnit, []string{"Platform"}) || !slices.Equal(subject.Locality, []string{"San Diego"}) {
+		t.Errorf("subject = %+v", subject)
+	}
+	// Copied once, not once from Names and again from ExtraNames
+	if got := subject.String(); strings.Count(got, "0.9.2342.19200300.100.1.25=CSO") != 1 || strings.Count(got, "1.2.3.4=custom") != 1 {
+		t.Errorf("subject = %s", got)
+	}
+	if !slices.Equal(certs[0].DNSNames, []string{"device.example.com", "alt.example.com"}) {
+		t.Errorf("DNS names = %q", certs[0].DNSNames)
+	}
+}
+
+func TestParseSubjectAttrs(t *testing.T) {
+	attrs, err := parseSubjectAttrs([]string{"2.5.4.45=id", "1.3.6.1.4.1.99999.1=a=b"})
+	if err != nil || len(attrs) != 2 || !attrs[0].Type.Equal(asn1.ObjectIdentifier{2, 5, 4, 45}) || attrs[1].Value != "a=b" {
+		t.Errorf("parseSubjectAttrs = %+v, %v", attrs, err)
+	}
+	for _, value := range []string{"2.5.4.45", "2.5.4.45=", "=x", "1=x", "1.x.3=v", "1.-2=v"} {
+		if _, err := parseSubjectAttrs([]string{value}); clierr.CodeOf(err) != clierr.Usage {
+			t.Errorf("parseSubjectAttrs(%s) = %v, want a usage error", value, err)
+		}
+	}
+}
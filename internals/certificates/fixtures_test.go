@@ -0,0 +1,117 @@
+package certificates
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testCert is a generated certificate with its private key
+type testCert struct {
+	Cert *x509.Certificate
+	Key  crypto.Signer
+}
+
+// testSerial numbers the generated certificates so every one has its own serial
+var testSerial atomic.Int64
+
+// newTestCert generates a certificate from template signed by parent, self-signed when parent is nil. The key is
+// a P-256 key unless key is given. Serial, validity and the key identifiers are filled in when template leaves
+// them empty.
+func newTestCert(t *testing.T, template *x509.Certificate, parent *testCert, key crypto.Signer) *testCert {
+	t.Helper()
+	if key == nil {
+		var err error
+		if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if template.SerialNumber == nil {
+		template.SerialNumber = big.NewInt(1000 + testSerial.Add(1))
+	}
+	if template.NotBefore.IsZero() {
+		template.NotBefore = time.Now().Add(-time.Hour)
+		template.NotAfter = time.Now().Add(24 * time.Hour)
+	}
+	if template.SubjectKeyId == nil {
+		template.SubjectKeyId = big.NewInt(testSerial.Add(1)).Bytes()
+	}
+
+	signer, issuer := key, template
+	if parent != nil {
+		signer, issuer = parent.Key, parent.Cert
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, key.Public(), signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{Cert: cert, Key: key}
+}
+
+// newTestCA generates a CA certificate named cn, a root when parent is nil and an intermediate otherwise
+func newTestCA(t *testing.T, cn string, parent *testCert) *testCert {
+	t.Helper()
+	return newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: cn},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+	}, parent, nil)
+}
+
+// newTestLeaf generates a server and client certificate for cn, signed by ca
+func newTestLeaf(t *testing.T, cn string, ca *testCert, key crypto.Signer) *testCert {
+	t.Helper()
+	return newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: cn},
+		DNSNames:    []string{cn},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}, ca, key)
+}
+
+// certPEM encodes certs as PEM, in order
+func certPEM(certs ...*testCert) []byte {
+	var data []byte
+	for _, c := range certs {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Cert.Raw})...)
+	}
+	return data
+}
+
+// keyPEM encodes the key of c as PKCS#8 PEM
+func keyPEM(t *testing.T, c *testCert) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(c.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+// writeTestFile writes data to name below dir and returns its path
+func writeTestFile(t *testing.T, dir string, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,170 @@
+package certificates
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/style"
+
+	"github.com/spf13/cobra"
+)
+
+func inspectCertCmd() *cobra.Command {
+	inspectCmd := &cobra.Command{
+		Use:   "inspect <file|host:port>",
+		Short: "Prints the details of a certificate file or of the chain served by an endpoint",
+		Long: `Parses a PEM/DER certificate file, or connects to host:port and reads the chain it presents, and prints
+the leaf's subject, validity, SANs and revocation endpoints. With --check-revocation the OCSP responders and CRL
+distribution points are queried; unreachable endpoints are reported without failing the command.`,
+		Example: `  gsn cert inspect server.pem
+  gsn cert inspect example.com:443 --check-revocation`,
+		Args: cobra.ExactArgs(1),
+		Run:  InspectCertificate,
+	}
+
+	inspectCmd.Flags().String("issuer", "", "Issuer certificate file, used when the chain does not include it")
+	inspectCmd.Flags().Bool("check-revocation", false, "Query the OCSP responders and CRL distribution points")
+	inspectCmd.Flags().Duration("timeout", 10*time.Second, "Timeout for the connection and revocation queries")
+	dryrun.ReadOnly(inspectCmd)
+	return inspectCmd
+}
+
+func InspectCertificate(cmd *cobra.Command, args []string) {
+	issuerPath, _ := cmd.Flags().GetString("issuer")
+	checkRevoked, _ := cmd.Flags().GetBool("check-revocation")
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+
+	ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
+	defer cancel()
+
+	chain, err := loadChain(ctx, args[0])
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+
+	leaf := chain[0]
+	var issuer *x509.Certificate
+	if len(chain) > 1 {
+		issuer = chain[1]
+	}
+	if issuerPath != "" {
+		data, err := os.ReadFile(issuerPath)
+		if err != nil {
+			clierr.Fatalf("Error reading issuer: %v", err)
+		}
+		certs := parseCertificates(data)
+		if len(certs) == 0 {
+			clierr.Fatalf("No certificate found in '%s'", issuerPath)
+		}
+		issuer = certs[0]
+	}
+
+	printCertificate(leaf, len(chain))
+
+	if !checkRevoked {
+		return
+	}
+	if issuer == nil && len(leaf.IssuingCertificateURL) > 0 {
+		if issuer, err = fetchIssuer(ctx, leaf); err != nil {
+			fmt.Fprintf(os.Stderr, style.Warning()+"Could not fetch issuer: %v\n", err)
+		}
+	}
+
+	fmt.Println()
+	results := checkRevocation(ctx, leaf, issuer)
+	if len(results) == 0 {
+		fmt.Println("Revocation:  no OCSP responder or CRL distribution point in certificate")
+		return
+	}
+	fmt.Println("Revocation:")
+	for _, r := range results {
+		line := fmt.Sprintf("  %-4s %s %6dms  %s", r.Method, revocationLabel(r.Status, 11), r.Latency.Milliseconds(), r.URL)
+		if !r.RevokedAt.IsZero() {
+			line += fmt.Sprintf(" (revoked %s)", r.RevokedAt.Format(time.RFC3339))
+		}
+		if r.Detail != "" {
+			line += " - " + r.Detail
+		}
+		fmt.Println(line)
+	}
+}
+
+// loadChain reads the certificates of a file, or the chain presented by a TLS endpoint when target is not a file
+func loadChain(ctx context.Context, target string) ([]*x509.Certificate, error) {
+	if data, err := os.ReadFile(target); err == nil {
+		certs := parseCertificates(data)
+		if len(certs) == 0 {
+			return nil, fmt.Errorf("no certificate found in '%s'", target)
+		}
+		return certs, nil
+	} else if !strings.Contains(target, ":") {
+		return nil, err
+	}
+
+	host, _, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, fmt.Errorf("'%s' is neither a file nor host:port", target)
+	}
+
+	// The chain is only inspected, so verification problems must not prevent reading it
+	dialer := &tls.Dialer{Config: &tls.Config{ServerName: host, InsecureSkipVerify: true}}
+	conn, err := dialer.DialContext(ctx, "tcp", target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", target, err)
+	}
+	defer conn.Close()
+
+	chain := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("%s did not present a certificate", target)
+	}
+	return chain, nil
+}
+
+// printCertificate prints the fields of cert that matter when debugging a deployment
+func printCertificate(cert *x509.Certificate, chainLength int) {
+	fmt.Printf("Subject:     %s\n", cert.Subject)
+	fmt.Printf("Issuer:      %s\n", cert.Issuer)
+	fmt.Printf("Serial:      %s\n", cert.SerialNumber.Text(16))
+	fmt.Printf("Not before:  %s\n", cert.NotBefore.Format(time.RFC3339))
+	fmt.Printf("Not after:   %s (%dd)\n", cert.NotAfter.Format(time.RFC3339), int(time.Until(cert.NotAfter).Hours()/24))
+	fmt.Printf("Key:         %s\n", cert.PublicKeyAlgorithm)
+	if len(cert.DNSNames) > 0 {
+		fmt.Printf("DNS names:   %s\n", strings.Join(cert.DNSNames, ", "))
+	}
+	if len(cert.IPAddresses) > 0 {
+		ips := make([]string, len(cert.IPAddresses))
+		for i, ip := range cert.IPAddresses {
+			ips[i] = ip.String()
+		}
+		fmt.Printf("IP SANs:     %s\n", strings.Join(ips, ", "))
+	}
+	if len(cert.OCSPServer) > 0 {
+		fmt.Printf("OCSP:        %s\n", strings.Join(cert.OCSPServer, ", "))
+	}
+	if len(cert.CRLDistributionPoints) > 0 {
+		fmt.Printf("CRL:         %s\n", strings.Join(cert.CRLDistributionPoints, ", "))
+	}
+	fmt.Printf("Chain:       %d certificate(s)\n", chainLength)
+}
+
+// revocationLabel pads and decorates a revocation status for the terminal
+func revocationLabel(status string, width int) string {
+	padded := fmt.Sprintf("%-*s", width, status)
+	switch status {
+	case revocationGood:
+		return style.Green(padded)
+	case revocationRevoked:
+		return style.Red(padded)
+	default:
+		return style.Yellow(padded)
+	}
+}
@@ -0,0 +1,354 @@
+package certificates
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/output"
+	"gsn-dev-tools/internals/style"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	matchPaired     = "paired"
+	matchOrphanKey  = "orphan key"
+	matchOrphanCert = "orphan cert"
+	matchEncrypted  = "encrypted, skipped"
+)
+
+// errEncryptedKey is returned for encrypted keys when no passphrase was supplied
+var errEncryptedKey = errors.New("encrypted key")
+
+// foundKey is a private key found on disk by `cert match`
+type foundKey struct {
+	Path        string
+	Algorithm   string
+	Fingerprint string
+	Encrypted   bool
+}
+
+// matchRow is one line of the `cert match` report
+type matchRow struct {
+	Key         string
+	Cert        string
+	Subject     string
+	Algorithm   string
+	Fingerprint string
+	Status      string
+}
+
+// matchColumns declares the columns available to `cert match`
+var matchColumns = []output.Column[matchRow]{
+	{Name: "status", Value: func(r matchRow) any { return r.Status }},
+	{Name: "key", Value: func(r matchRow) any { return r.Key }},
+	{Name: "cert", Value: func(r matchRow) any { return r.Cert }},
+	{Name: "subject", Value: func(r matchRow) any { return r.Subject }},
+	{Name: "algorithm", Value: func(r matchRow) any { return r.Algorithm }},
+	{
+		Name:    "fingerprint",
+		Value:   func(r matchRow) any { return r.Fingerprint },
+		Display: func(r matchRow) string { return shortFingerprint(r.Fingerprint) },
+	},
+}
+
+func matchCertsCmd() *cobra.Command {
+	matchCmd := &cobra.Command{
+		Use:   "match <directory>",
+		Short: "Reports which private key belongs to which certificate",
+		Long: `Parses every private key and certificate below a directory, compares their public key fingerprints and
+lists the pairs as well as orphaned keys and certificates. RSA, ECDSA and Ed25519 keys are supported.
+Encrypted keys are skipped unless --passphrase (or GSN_KEY_PASSPHRASE) is supplied.`,
+		Example: `  gsn cert match ./certs
+  gsn cert match ./certs --rename-pairs`,
+		Args: cobra.ExactArgs(1),
+		Run:  MatchCertificates,
+	}
+
+	matchCmd.Flags().String("passphrase", "", "Passphrase for encrypted PEM keys (default $GSN_KEY_PASSPHRASE)")
+	matchCmd.Flags().Bool("rename-pairs", false, "Rename each pair to <cn>.crt and <cn>.key next to the certificate")
+	output.AddFlags(matchCmd)
+	return matchCmd
+}
+
+func MatchCertificates(cmd *cobra.Command, args []string) {
+	passphrase, _ := cmd.Flags().GetString("passphrase")
+	renamePairs, _ := cmd.Flags().GetBool("rename-pairs")
+	if passphrase == "" {
+		passphrase = os.Getenv("GSN_KEY_PASSPHRASE")
+	}
+
+	opts, err := output.OptionsFromFlags(cmd)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+
+	certs, keys, err := scanKeysAndCerts(args[0], []byte(passphrase))
+	if err != nil {
+		clierr.Fatalf("Error scanning '%s': %v", args[0], err)
+	}
+
+	rows := matchKeys(certs, keys)
+	if err := output.Render(os.Stdout, matchColumns, rows, opts); err != nil {
+		clierr.Fatalf("%v", err)
+	}
+
+	if renamePairs {
+		renameMatchedPairs(rows)
+	}
+}
+
+// scanKeysAndCerts collects every certificate and private key below root
+func scanKeysAndCerts(root string, passphrase []byte) ([]scannedCert, []foundKey, error) {
+	var certs []scannedCert
+	var keys []foundKey
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for _, cert := range parseCertificates(data) {
+			certs = append(certs, scannedCert{Path: path, Cert: cert})
+		}
+		keys = append(keys, parsePrivateKeys(path, data, passphrase)...)
+		return nil
+	})
+	return certs, keys, err
+}
+
+// parsePrivateKeys extracts every PEM private key of a file and fingerprints its public half
+func parsePrivateKeys(path string, data []byte, passphrase []byte) []foundKey {
+	var keys []foundKey
+	rest := data
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if !strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			continue
+		}
+
+		public, err := publicKeyOf(block, passphrase)
+		if errors.Is(err, errEncryptedKey) {
+			keys = append(keys, foundKey{Path: path, Encrypted: true})
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		fingerprint, algorithm, err := publicKeyFingerprint(public)
+		if err != nil {
+			continue
+		}
+		keys = append(keys, foundKey{Path: path, Algorithm: algorithm, Fingerprint: fingerprint})
+	}
+	return keys
+}
+
+// publicKeyOf decodes a private key block, decrypting legacy encrypted PEM when a passphrase is given
+func publicKeyOf(block *pem.Block, passphrase []byte) (crypto.PublicKey, error) {
+	der := block.Bytes
+	// Legacy PEM encryption is deprecated but still common in old key stores
+	if x509.IsEncryptedPEMBlock(block) {
+		if len(passphrase) == 0 {
+			return nil, errEncryptedKey
+		}
+		var err error
+		if der, err = x509.DecryptPEMBlock(block, passphrase); err != nil {
+			return nil, fmt.Errorf("%w: %v", errEncryptedKey, err)
+		}
+	}
+	if block.Type == "ENCRYPTED PRIVATE KEY" {
+		// PKCS#8 encryption is not supported by the standard library
+		return nil, errEncryptedKey
+	}
+
+	var key any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(der)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(der)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(der)
+	}
+	if err != nil && x509.IsEncryptedPEMBlock(block) {
+		// A wrong passphrase now and then decrypts to garbage with valid padding
+		return nil, fmt.Errorf("%w: %v", errEncryptedKey, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer.Public(), nil
+}
+
+// publicKeyFingerprint returns the SHA-256 of the PKIX encoded public key and its algorithm name
+func publicKeyFingerprint(public crypto.PublicKey) (string, string, error) {
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256(der)
+
+	algorithm := "unknown"
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		algorithm = fmt.Sprintf("RSA-%d", k.N.BitLen())
+	case *ecdsa.PublicKey:
+		algorithm = "ECDSA-" + k.Curve.Params().Name
+	case ed25519.PublicKey:
+		algorithm = "Ed25519"
+	}
+	return hex.EncodeToString(sum[:]), algorithm, nil
+}
+
+// matchKeys pairs keys and certificates by public key fingerprint
+func matchKeys(certs []scannedCert, keys []foundKey) []matchRow {
+	keysByFingerprint := make(map[string][]foundKey)
+	var rows []matchRow
+	for _, key := range keys {
+		if key.Encrypted {
+			rows = append(rows, matchRow{Key: key.Path, Status: matchEncrypted})
+			continue
+		}
+		keysByFingerprint[key.Fingerprint] = append(keysByFingerprint[key.Fingerprint], key)
+	}
+
+	used := make(map[string]bool)
+	for _, c := range certs {
+		fingerprint, algorithm, err := publicKeyFingerprint(c.Cert.PublicKey)
+		if err != nil {
+			continue
+		}
+
+		row := matchRow{Cert: c.Path, Subject: c.Cert.Subject.CommonName, Algorithm: algorithm, Fingerprint: fingerprint, Status: matchOrphanCert}
+		matches := keysByFingerprint[fingerprint]
+		if len(matches) == 0 {
+			rows = append(rows, row)
+			continue
+		}
+		for _, key := range matches {
+			row.Key = key.Path
+			row.Status = matchPaired
+			rows = append(rows, row)
+		}
+		used[fingerprint] = true
+	}
+
+	for fingerprint, matches := range keysByFingerprint {
+		if used[fingerprint] {
+			continue
+		}
+		for _, key := range matches {
+			rows = append(rows, matchRow{Key: key.Path, Algorithm: key.Algorithm, Fingerprint: fingerprint, Status: matchOrphanKey})
+		}
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].Status != rows[j].Status {
+			return rows[i].Status > rows[j].Status
+		}
+		return rows[i].Cert+rows[i].Key < rows[j].Cert+rows[j].Key
+	})
+	return rows
+}
+
+// renameMatchedPairs renames the files of every pair after the certificate CN. Files holding more than one
+// key or certificate, and pairs whose target names already exist, are left alone.
+func renameMatchedPairs(rows []matchRow) {
+	uses := make(map[string]int)
+	for _, r := range rows {
+		if r.Status == matchPaired {
+			uses[r.Cert]++
+			uses[r.Key]++
+		}
+	}
+
+	renamed := 0
+	for _, r := range rows {
+		if r.Status != matchPaired || r.Subject == "" || r.Cert == r.Key || uses[r.Cert] > 1 || uses[r.Key] > 1 {
+			continue
+		}
+
+		base := pairBaseName(r.Subject)
+		dir := filepath.Dir(r.Cert)
+		certTarget := filepath.Join(dir, base+".crt")
+		keyTarget := filepath.Join(dir, base+".key")
+		if certTarget == r.Cert && keyTarget == r.Key {
+			continue
+		}
+		if exists(certTarget) && certTarget != r.Cert || exists(keyTarget) && keyTarget != r.Key {
+			fmt.Printf("Warning: Skipping pair '%s' - '%s' or '%s' already exists\n", r.Subject, certTarget, keyTarget)
+			continue
+		}
+
+		if err := os.Rename(r.Cert, certTarget); err != nil {
+			fmt.Fprintf(os.Stderr, style.Warning()+"Failed to rename '%s': %v\n", r.Cert, err)
+			continue
+		}
+		if err := os.Rename(r.Key, keyTarget); err != nil {
+			fmt.Fprintf(os.Stderr, style.Warning()+"Failed to rename '%s': %v\n", r.Key, err)
+			continue
+		}
+		fmt.Printf("Renamed: '%s' + '%s' -> '%s' + '%s'\n", r.Cert, r.Key, certTarget, keyTarget)
+		renamed++
+	}
+	fmt.Printf("\nCompleted! Renamed %d pair(s).\n", renamed)
+}
+
+// pairBaseName turns a certificate CN into a file name, e.g. "*.example.com" becomes "wildcard.example.com"
+func pairBaseName(cn string) string {
+	cn = strings.ReplaceAll(cn, "*", "wildcard")
+	var b strings.Builder
+	for _, r := range strings.ToLower(cn) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	return strings.Trim(b.String(), "._")
+}
+
+// shortFingerprint abbreviates a hex fingerprint for the aligned table
+func shortFingerprint(fingerprint string) string {
+	if len(fingerprint) > 16 {
+		return fingerprint[:16]
+	}
+	return fingerprint
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
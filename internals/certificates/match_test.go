@@ -0,0 +1,235 @@
+package certificates
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// matchFixture holds the keys of the generated match fixture set
+type matchFixture struct {
+	dir string
+	rsa *testCert
+	ec  *testCert
+	ed  *testCert
+}
+
+// selfSigned generates a self-signed certificate for cn with key
+func selfSigned(t *testing.T, cn string, key crypto.Signer) *testCert {
+	t.Helper()
+	return newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: cn}}, nil, key)
+}
+
+// writeMatchFixture writes a directory with a pair of every key type, each key in another encoding, an orphaned
+// key and certificate, a file holding both halves of a pair and a legacy encrypted key
+func writeMatchFixture(t *testing.T) matchFixture {
+	t.Helper()
+	dir := t.TempDir()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := matchFixture{
+		dir: dir,
+		rsa: selfSigned(t, "rsa.example.com", rsaKey),
+		ec:  selfSigned(t, "ec.example.com", ecKey),
+		ed:  selfSigned(t, "*.ed.example.com", edKey),
+	}
+
+	writeTestFile(t, dir, "server-1.crt", certPEM(f.rsa))
+	writeTestFile(t, dir, "private/a.key", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}))
+
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, dir, "ec.pem", certPEM(f.ec))
+	writeTestFile(t, dir, "ec-key.pem", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER}))
+
+	// PKCS#8 key in the same file as its certificate
+	writeTestFile(t, dir, "ed.pem", append(certPEM(f.ed), keyPEM(t, f.ed)...))
+
+	orphanKey := newTestLeaf(t, "orphan-key", newTestCA(t, "CA", nil), nil)
+	writeTestFile(t, dir, "orphan.key", keyPEM(t, orphanKey))
+	writeTestFile(t, dir, "orphan.crt", certPEM(selfSigned(t, "orphan.example.com", nil)))
+
+	encrypted := newTestLeaf(t, "encrypted.example.com", newTestCA(t, "CA", nil), nil)
+	ecEncDER, err := x509.MarshalECPrivateKey(encrypted.Key.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := x509.EncryptPEMBlock(rand.Reader, "EC PRIVATE KEY", ecEncDER, []byte("s3cret"), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, dir, "encrypted.key", pem.EncodeToMemory(block))
+	writeTestFile(t, dir, "encrypted.crt", certPEM(encrypted))
+
+	// Neither is a key or a certificate
+	writeTestFile(t, dir, "README.md", []byte("# certs\n"))
+	return f
+}
+
+// matchSummary renders rows as "status key cert algorithm" with paths relative to dir
+func matchSummary(t *testing.T, dir string, rows []matchRow) []string {
+	t.Helper()
+	rel := func(path string) string {
+		if path == "" {
+			return "-"
+		}
+		r, err := filepath.Rel(dir, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return filepath.ToSlash(r)
+	}
+	var lines []string
+	for _, r := range rows {
+		algorithm := r.Algorithm
+		if algorithm == "" {
+			algorithm = "-"
+		}
+		lines = append(lines, strings.Join([]string{r.Status, rel(r.Key), rel(r.Cert), algorithm}, " "))
+	}
+	return lines
+}
+
+func TestMatchKeys(t *testing.T) {
+	f := writeMatchFixture(t)
+
+	tests := []struct {
+		name       string
+		passphrase string
+		want       []string
+	}{
+		{"without passphrase", "", []string{
+			"paired ec-key.pem ec.pem ECDSA-P-384",
+			"paired ed.pem ed.pem Ed25519",
+			"paired private/a.key server-1.crt RSA-2048",
+			"orphan key orphan.key - ECDSA-P-256",
+			"orphan cert - encrypted.crt ECDSA-P-256",
+			"orphan cert - orphan.crt ECDSA-P-256",
+			"encrypted, skipped encrypted.key - -",
+		}},
+		{"with passphrase", "s3cret", []string{
+			"paired ec-key.pem ec.pem ECDSA-P-384",
+			"paired ed.pem ed.pem Ed25519",
+			"paired encrypted.key encrypted.crt ECDSA-P-256",
+			"paired private/a.key server-1.crt RSA-2048",
+			"orphan key orphan.key - ECDSA-P-256",
+			"orphan cert - orphan.crt ECDSA-P-256",
+		}},
+		// A wrong passphrase leaves the key as encrypted as no passphrase does, also when the garbage it decrypts
+		// to happens to be padded correctly
+		{"wrong passphrase", "nope", []string{
+			"paired ec-key.pem ec.pem ECDSA-P-384",
+			"paired ed.pem ed.pem Ed25519",
+			"paired private/a.key server-1.crt RSA-2048",
+			"orphan key orphan.key - ECDSA-P-256",
+			"orphan cert - encrypted.crt ECDSA-P-256",
+			"orphan cert - orphan.crt ECDSA-P-256",
+			"encrypted, skipped encrypted.key - -",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			certs, keys, err := scanKeysAndCerts(f.dir, []byte(test.passphrase))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := matchSummary(t, f.dir, matchKeys(certs, keys))
+			if !slices.Equal(got, test.want) {
+				t.Errorf("rows =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestPublicKeyFingerprintMatchesAcrossEncodings(t *testing.T) {
+	f := writeMatchFixture(t)
+	for _, c := range []*testCert{f.rsa, f.ec, f.ed} {
+		fromCert, _, err := publicKeyFingerprint(c.Cert.PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fromKey, algorithm, err := publicKeyFingerprint(c.Key.Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fromCert != fromKey || len(fromCert) != 64 {
+			t.Errorf("%s: certificate %s and key %s fingerprints differ", algorithm, fromCert, fromKey)
+		}
+	}
+	// Another key of the same type never matches
+	other, _, _ := publicKeyFingerprint(selfSigned(t, "other", nil).Cert.PublicKey)
+	ec, _, _ := publicKeyFingerprint(f.ec.Cert.PublicKey)
+	if other == ec {
+		t.Error("two ECDSA keys share a fingerprint")
+	}
+}
+
+func TestRenameMatchedPairs(t *testing.T) {
+	f := writeMatchFixture(t)
+	certs, keys, err := scanKeysAndCerts(f.dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	renameMatchedPairs(matchKeys(certs, keys))
+
+	// Pairs of two files are named after the CN next to the certificate, the key moving out of private/. ed.pem
+	// holds both halves and stays as it is.
+	for _, name := range []string{"rsa.example.com.crt", "rsa.example.com.key", "ec.example.com.crt", "ec.example.com.key", "ed.pem"} {
+		if _, err := os.Stat(filepath.Join(f.dir, name)); err != nil {
+			t.Errorf("%s missing after the rename: %v", name, err)
+		}
+	}
+	for _, name := range []string{"server-1.crt", "private/a.key", "ec.pem", "ec-key.pem", "wildcard.ed.example.com.crt"} {
+		if _, err := os.Stat(filepath.Join(f.dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s still exists or was created: %v", name, err)
+		}
+	}
+
+	// A second run finds the pairs under their new names and has nothing left to do
+	certs, keys, err = scanKeysAndCerts(f.dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := matchSummary(t, f.dir, matchKeys(certs, keys))
+	if !slices.Contains(got, "paired rsa.example.com.key rsa.example.com.crt RSA-2048") {
+		t.Errorf("rows after the rename =\n%s", strings.Join(got, "\n"))
+	}
+}
+
+func TestPairBaseName(t *testing.T) {
+	tests := map[string]string{
+		"api.example.com":      "api.example.com",
+		"*.example.com":        "wildcard.example.com",
+		"My Server/01":         "my_server_01",
+		"..hidden..":           "hidden",
+		"USAMZS0001372070201E": "usamzs0001372070201e",
+	}
+	for cn, want := range tests {
+		if got := pairBaseName(cn); got != want {
+			t.Errorf("pairBaseName(%q) = %q, want %q", cn, got, want)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package certificates
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	revocationGood        = "good"
+	revocationRevoked     = "revoked"
+	revocationUnknown     = "unknown"
+	revocationUnreachable = "unreachable"
+)
+
+// maxRevocationResponse caps OCSP responses and CRL downloads
+const maxRevocationResponse = 20 << 20
+
+// revocationResult is the outcome of querying a single OCSP responder or CRL distribution point
+type revocationResult struct {
+	Method    string
+	URL       string
+	Status    string
+	RevokedAt time.Time
+	Detail    string
+	Latency   time.Duration
+}
+
+// checkRevocation queries every OCSP responder and CRL distribution point listed in cert.
+// Network and parse failures are reported in the results instead of being returned as errors.
+func checkRevocation(ctx context.Context, cert *x509.Certificate, issuer *x509.Certificate) []revocationResult {
+	var results []revocationResult
+	for _, url := range cert.OCSPServer {
+		results = append(results, timed("ocsp", url, func() revocationResult {
+			return checkOCSP(ctx, url, cert, issuer)
+		}))
+	}
+	for _, url := range cert.CRLDistributionPoints {
+		results = append(results, timed("crl", url, func() revocationResult {
+			return checkCRL(ctx, url, cert, issuer)
+		}))
+	}
+	return results
+}
+
+// timed runs check and fills in the method, URL and latency of its result
+func timed(method string, url string, check func() revocationResult) revocationResult {
+	start := time.Now()
+	result := check()
+	result.Method = method
+	result.URL = url
+	result.Latency = time.Since(start)
+	return result
+}
+
+// checkOCSP posts an RFC 6960 request for cert to the responder and verifies the signed response
+func checkOCSP(ctx context.Context, url string, cert *x509.Certificate, issuer *x509.Certificate) revocationResult {
+	if issuer == nil {
+		return revocationResult{Status: revocationUnknown, Detail: "issuer certificate not available"}
+	}
+
+	request, err := ocsp.CreateRequest(cert, issuer, &ocsp.RequestOptions{Hash: crypto.SHA256})
+	if err != nil {
+		return revocationResult{Status: revocationUnknown, Detail: err.Error()}
+	}
+
+	body, err := httpFetch(ctx, http.MethodPost, url, "application/ocsp-request", request)
+	if err != nil {
+		return revocationResult{Status: revocationUnreachable, Detail: err.Error()}
+	}
+
+	response, err := ocsp.ParseResponseForCert(body, cert, issuer)
+	if err != nil {
+		return revocationResult{Status: revocationUnknown, Detail: fmt.Sprintf("invalid response: %v", err)}
+	}
+
+	switch response.Status {
+	case ocsp.Good:
+		return revocationResult{Status: revocationGood}
+	case ocsp.Revoked:
+		return revocationResult{Status: revocationRevoked, RevokedAt: response.RevokedAt}
+	default:
+		return revocationResult{Status: revocationUnknown, Detail: "responder does not know the certificate"}
+	}
+}
+
+// checkCRL downloads the revocation list, verifies it against the issuer when known and looks up cert's serial
+func checkCRL(ctx context.Context, url string, cert *x509.Certificate, issuer *x509.Certificate) revocationResult {
+	body, err := httpFetch(ctx, http.MethodGet, url, "", nil)
+	if err != nil {
+		return revocationResult{Status: revocationUnreachable, Detail: err.Error()}
+	}
+
+	der := body
+	if block, _ := pem.Decode(body); block != nil && block.Type == "X509 CRL" {
+		der = block.Bytes
+	}
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		return revocationResult{Status: revocationUnknown, Detail: fmt.Sprintf("invalid CRL: %v", err)}
+	}
+
+	detail := ""
+	if issuer == nil {
+		detail = "CRL signature not verified, issuer not available"
+	} else if err := crl.CheckSignatureFrom(issuer); err != nil {
+		return revocationResult{Status: revocationUnknown, Detail: fmt.Sprintf("CRL signature invalid: %v", err)}
+	}
+	if !crl.NextUpdate.IsZero() && time.Now().After(crl.NextUpdate) {
+		detail = "CRL is past its next update"
+	}
+
+	for _, entry := range crl.RevokedCertificateEntries {
+		if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			return revocationResult{Status: revocationRevoked, RevokedAt: entry.RevocationTime, Detail: detail}
+		}
+	}
+	return revocationResult{Status: revocationGood, Detail: detail}
+}
+
+// httpFetch performs a request bounded by ctx and returns the body of a 200 response
+func httpFetch(ctx context.Context, method string, url string, contentType string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxRevocationResponse))
+}
+
+// fetchIssuer downloads the issuer certificate from the AIA "CA Issuers" URLs of cert
+func fetchIssuer(ctx context.Context, cert *x509.Certificate) (*x509.Certificate, error) {
+	var lastErr error
+	for _, url := range cert.IssuingCertificateURL {
+		body, err := httpFetch(ctx, http.MethodGet, url, "", nil)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if certs := parseCertificates(body); len(certs) > 0 {
+			return certs[0], nil
+		}
+		lastErr = fmt.Errorf("no certificate found at %s", url)
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("certificate has no issuer URL")
+	}
+	return nil, lastErr
+}
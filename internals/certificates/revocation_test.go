@@ -0,0 +1,227 @@
+package certificates
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// revokedAt is when the revoked fixtures were revoked, to the second as both protocols carry it
+var revokedAt = time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+
+// revocationStub serves an OCSP responder at /ocsp and a CRL at /crl for the certificates of ca. The responder
+// answers with the status set for a serial and unknown for any other.
+type revocationStub struct {
+	*httptest.Server
+	ca       *testCert
+	statuses map[int64]int
+	crl      []byte
+}
+
+func newRevocationStub(t *testing.T, ca *testCert) *revocationStub {
+	t.Helper()
+	s := &revocationStub{ca: ca, statuses: map[int64]int{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ocsp":
+			s.answerOCSP(t, w, r)
+		case "/crl":
+			_, _ = w.Write(s.crl)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *revocationStub) answerOCSP(t *testing.T, w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	req, err := ocsp.ParseRequest(body)
+	if err != nil || r.Header.Get("Content-Type") != "application/ocsp-request" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	status, ok := s.statuses[req.SerialNumber.Int64()]
+	if !ok {
+		status = ocsp.Unknown
+	}
+	template := ocsp.Response{
+		Status:       status,
+		SerialNumber: req.SerialNumber,
+		ThisUpdate:   time.Now().Add(-time.Minute),
+		NextUpdate:   time.Now().Add(time.Hour),
+	}
+	if status == ocsp.Revoked {
+		template.RevokedAt = revokedAt
+		template.RevocationReason = ocsp.KeyCompromise
+	}
+	resp, err := ocsp.CreateResponse(s.ca.Cert, s.ca.Cert, template, s.ca.Key)
+	if err != nil {
+		t.Errorf("signing the OCSP response: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	_, _ = w.Write(resp)
+}
+
+// testCRL generates a PEM CRL signed by signer revoking serials at revokedAt
+func testCRL(t *testing.T, signer *testCert, nextUpdate time.Time, serials ...int64) []byte {
+	t.Helper()
+	var entries []x509.RevocationListEntry
+	for _, serial := range serials {
+		entries = append(entries, x509.RevocationListEntry{SerialNumber: big.NewInt(serial), RevocationTime: revokedAt})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(1),
+		ThisUpdate:                time.Now().Add(-48 * time.Hour),
+		NextUpdate:                nextUpdate,
+		RevokedCertificateEntries: entries,
+	}, signer.Cert, signer.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+}
+
+// newRevocableLeaf generates a leaf of ca listing the responder and CRL of stub
+func newRevocableLeaf(t *testing.T, ca *testCert, ocspURL string, crlURL string) *testCert {
+	t.Helper()
+	return newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "leaf.example.com"},
+		OCSPServer:            []string{ocspURL},
+		CRLDistributionPoints: []string{crlURL},
+	}, ca, nil)
+}
+
+// statusesOf returns "method=status" for every result, in order
+func statusesOf(results []revocationResult) string {
+	var parts []string
+	for _, r := range results {
+		parts = append(parts, r.Method+"="+r.Status)
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestCheckRevocation(t *testing.T) {
+	ca := newTestCA(t, "Test Root", nil)
+	stub := newRevocationStub(t, ca)
+	good := newRevocableLeaf(t, ca, stub.URL+"/ocsp", stub.URL+"/crl")
+	revoked := newRevocableLeaf(t, ca, stub.URL+"/ocsp", stub.URL+"/crl")
+	unlisted := newRevocableLeaf(t, ca, stub.URL+"/ocsp", stub.URL+"/crl")
+	stub.statuses[good.Cert.SerialNumber.Int64()] = ocsp.Good
+	stub.statuses[revoked.Cert.SerialNumber.Int64()] = ocsp.Revoked
+	stub.crl = testCRL(t, ca, time.Now().Add(24*time.Hour), revoked.Cert.SerialNumber.Int64())
+
+	tests := []struct {
+		name string
+		leaf *testCert
+		want string
+	}{
+		{"good", good, "ocsp=good crl=good"},
+		{"revoked", revoked, "ocsp=revoked crl=revoked"},
+		// The responder does not know the certificate, the CRL does not list it
+		{"unknown", unlisted, "ocsp=unknown crl=good"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			results := checkRevocation(context.Background(), test.leaf.Cert, ca.Cert)
+			if got := statusesOf(results); got != test.want {
+				t.Fatalf("statuses = %s, want %s (%+v)", got, test.want, results)
+			}
+			for _, r := range results {
+				if r.Status == revocationRevoked && !r.RevokedAt.Equal(revokedAt) {
+					t.Errorf("%s revoked at %v, want %v", r.Method, r.RevokedAt, revokedAt)
+				}
+				if r.Latency <= 0 {
+					t.Errorf("%s latency = %v", r.Method, r.Latency)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckRevocationUnreachable(t *testing.T) {
+	// Nothing listens on a port taken from a closed listener
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := "http://" + listener.Addr().String()
+	listener.Close()
+
+	ca := newTestCA(t, "Test Root", nil)
+	leaf := newRevocableLeaf(t, ca, closed+"/ocsp", closed+"/crl")
+	results := checkRevocation(context.Background(), leaf.Cert, ca.Cert)
+	if got := statusesOf(results); got != "ocsp=unreachable crl=unreachable" {
+		t.Errorf("statuses = %s, want both unreachable", got)
+	}
+
+	// A responder that never answers is given up on when the context ends
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Once the body is read the server notices the client hanging up
+		_, _ = io.ReadAll(r.Body)
+		<-r.Context().Done()
+	}))
+	defer hanging.Close()
+	leaf = newRevocableLeaf(t, ca, hanging.URL+"/ocsp", hanging.URL+"/crl")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	results = checkRevocation(ctx, leaf.Cert, ca.Cert)
+	if got := statusesOf(results); got != "ocsp=unreachable crl=unreachable" {
+		t.Errorf("statuses after the timeout = %s, want both unreachable", got)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("the timeout took %v", elapsed)
+	}
+}
+
+func TestCheckRevocationUntrustedAnswers(t *testing.T) {
+	ca := newTestCA(t, "Test Root", nil)
+	other := newTestCA(t, "Other Root", nil)
+	stub := newRevocationStub(t, ca)
+	leaf := newRevocableLeaf(t, ca, stub.URL+"/ocsp", stub.URL+"/crl")
+	stub.statuses[leaf.Cert.SerialNumber.Int64()] = ocsp.Good
+
+	// A CRL signed by another CA says nothing about the leaf
+	stub.crl = testCRL(t, other, time.Now().Add(24*time.Hour))
+	results := checkRevocation(context.Background(), leaf.Cert, ca.Cert)
+	if got := statusesOf(results); got != "ocsp=good crl=unknown" || !strings.Contains(results[1].Detail, "CRL signature invalid") {
+		t.Errorf("CRL of another CA = %s %+v", got, results)
+	}
+
+	// Without the issuer OCSP cannot build its request, the CRL is read without verifying it
+	stub.crl = testCRL(t, ca, time.Now().Add(24*time.Hour))
+	results = checkRevocation(context.Background(), leaf.Cert, nil)
+	if got := statusesOf(results); got != "ocsp=unknown crl=good" || !strings.Contains(results[1].Detail, "not verified") {
+		t.Errorf("without the issuer = %s %+v", got, results)
+	}
+
+	// A stale CRL still answers, with a note
+	stub.crl = testCRL(t, ca, time.Now().Add(-time.Hour))
+	results = checkRevocation(context.Background(), leaf.Cert, ca.Cert)
+	if got := statusesOf(results); got != "ocsp=good crl=good" || !strings.Contains(results[1].Detail, "past its next update") {
+		t.Errorf("stale CRL = %s %+v", got, results)
+	}
+
+	// Garbage is not a CRL
+	stub.crl = []byte("not a crl")
+	results = checkRevocation(context.Background(), leaf.Cert, ca.Cert)
+	if got := statusesOf(results); got != "ocsp=good crl=unknown" {
+		t.Errorf("invalid CRL = %s %+v", got, results)
+	}
+}
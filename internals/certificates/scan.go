@@ -0,0 +1,139 @@
+package certificates
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/output"
+
+	"github.com/spf13/cobra"
+)
+
+// scannedCert is a certificate found on disk by `cert scan`
+type scannedCert struct {
+	Path string
+	Cert *x509.Certificate
+}
+
+// scanColumns declares the columns available to `cert scan`
+var scanColumns = []output.Column[scannedCert]{
+	{Name: "file", Value: func(c scannedCert) any { return c.Path }},
+	{Name: "subject", Value: func(c scannedCert) any { return c.Cert.Subject.CommonName }},
+	{Name: "issuer", Value: func(c scannedCert) any { return c.Cert.Issuer.CommonName }},
+	{Name: "not_after", Value: func(c scannedCert) any { return c.Cert.NotAfter }},
+	{
+		Name:    "expires_in",
+		Value:   func(c scannedCert) any { return time.Until(c.Cert.NotAfter) },
+		Display: func(c scannedCert) string { return fmt.Sprintf("%dd", int(time.Until(c.Cert.NotAfter).Hours()/24)) },
+	},
+	{Name: "serial", Value: func(c scannedCert) any { return c.Cert.SerialNumber.Text(16) }},
+}
+
+func CertCmd() *cobra.Command {
+	certCmd := &cobra.Command{
+		Use:   "cert",
+		Short: "Inspect and manage certificates",
+		Long: `Commands to scan, inspect, pair and bundle certificates, to smoke test TLS and mutual TLS connections, and to
+add local CAs to project bundles or the system trust store.`,
+		Example: `  gsn cert scan ./certs
+  gsn cert inspect example.com:443 --check-revocation`,
+	}
+
+	certCmd.AddCommand(scanCertsCmd())
+	certCmd.AddCommand(inspectCertCmd())
+	certCmd.AddCommand(matchCertsCmd())
+	certCmd.AddCommand(bundleCertsCmd())
+	certCmd.AddCommand(serveCertsCmd())
+	certCmd.AddCommand(connectCertsCmd())
+	certCmd.AddCommand(trustCertsCmd())
+	certCmd.AddCommand(untrustCertsCmd())
+	return certCmd
+}
+
+func scanCertsCmd() *cobra.Command {
+	scanCmd := &cobra.Command{
+		Use:   "scan <directory>",
+		Short: "Lists every certificate found below a directory",
+		Long:  "Walks a directory, parses PEM and DER encoded certificates and prints their subject, issuer and expiry.",
+		Example: `  gsn cert scan /etc/ssl/private
+  gsn cert scan ./certs --sort not_after --csv`,
+		Args: cobra.ExactArgs(1),
+		Run:  ScanCertificates,
+	}
+
+	output.AddFlags(scanCmd)
+	dryrun.ReadOnly(scanCmd)
+	return scanCmd
+}
+
+func ScanCertificates(cmd *cobra.Command, args []string) {
+	opts, err := output.OptionsFromFlags(cmd)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+
+	certs, err := scanDirectory(args[0])
+	if err != nil {
+		clierr.Fatalf("Error scanning '%s': %v", args[0], err)
+	}
+
+	if err := output.Render(os.Stdout, scanColumns, certs, opts); err != nil {
+		clierr.Fatalf("%v", err)
+	}
+}
+
+// scanDirectory collects every parsable certificate below root
+func scanDirectory(root string) ([]scannedCert, error) {
+	var found []scannedCert
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for _, cert := range parseCertificates(data) {
+			found = append(found, scannedCert{Path: path, Cert: cert})
+		}
+		return nil
+	})
+	return found, err
+}
+
+// parseCertificates extracts every certificate from PEM data, or a single DER certificate
+func parseCertificates(data []byte) []*x509.Certificate {
+	var certs []*x509.Certificate
+	rest := data
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if !strings.Contains(block.Type, "CERTIFICATE") || block.Type == "CERTIFICATE REQUEST" {
+			continue
+		}
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+			certs = append(certs, cert)
+		}
+	}
+
+	if len(certs) == 0 {
+		if cert, err := x509.ParseCertificate(data); err == nil {
+			certs = append(certs, cert)
+		}
+	}
+	return certs
+}
@@ -0,0 +1,221 @@
+package certificates
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+
+	"github.com/spf13/cobra"
+)
+
+func serveCertsCmd() *cobra.Command {
+	serveCmd := &cobra.Command{
+		Use:   "serve --cert <cert.pem> --key <key.pem>",
+		Short: "Runs a TLS or mutual-TLS test server and prints every handshake",
+		Long: `Starts a TLS echo server (or a small HTTPS server with --http) using the given certificate and key. With --client-ca
+clients must present a certificate signed by that CA. Each handshake prints the negotiated version, cipher suite and the
+client certificate subject (peer). Stop it with Ctrl+C.`,
+		Example: `  gsn cert serve --cert server.pem --key server.key
+  gsn cert serve --cert server.pem --key server.key --client-ca ca.pem --http --port 9443`,
+		Args: cobra.NoArgs,
+		Run:  ServeTLS,
+	}
+
+	serveCmd.Flags().String("cert", "", "Server certificate (PEM, may include the chain)")
+	serveCmd.Flags().String("key", "", "Server private key (PEM)")
+	serveCmd.Flags().String("client-ca", "", "Require client certificates signed by this CA (enables mTLS)")
+	serveCmd.Flags().String("host", "127.0.0.1", "Address to listen on")
+	serveCmd.Flags().Int("port", 8443, "Port to listen on")
+	serveCmd.Flags().Bool("http", false, "Serve HTTPS and answer every request with the handshake details")
+	serveCmd.MarkFlagRequired("cert")
+	serveCmd.MarkFlagRequired("key")
+	return serveCmd
+}
+
+func ServeTLS(cmd *cobra.Command, args []string) {
+	certPath, _ := cmd.Flags().GetString("cert")
+	keyPath, _ := cmd.Flags().GetString("key")
+	clientCA, _ := cmd.Flags().GetString("client-ca")
+	host, _ := cmd.Flags().GetString("host")
+	port, _ := cmd.Flags().GetInt("port")
+	useHTTP, _ := cmd.Flags().GetBool("http")
+
+	config, err := serverTLSConfig(certPath, keyPath, clientCA)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+
+	ln, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	mode := "TLS echo"
+	if useHTTP {
+		mode = "HTTPS"
+	}
+	if clientCA != "" {
+		mode += " (mTLS)"
+	}
+	fmt.Printf("Listening on %s, %s. Press Ctrl+C to stop.\n", ln.Addr(), mode)
+
+	if err := runTLSServer(ctx, ln, config, useHTTP, os.Stdout); err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	fmt.Println("Server stopped.")
+}
+
+// serverTLSConfig loads the server key pair and, when clientCA is set, requires verified client certificates
+func serverTLSConfig(certPath string, keyPath string, clientCA string) (*tls.Config, error) {
+	pair, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load key pair: %w", err)
+	}
+
+	config := &tls.Config{Certificates: []tls.Certificate{pair}, MinVersion: tls.VersionTLS12}
+	if clientCA != "" {
+		pool, err := loadCertPool(clientCA)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// loadCertPool builds a pool out of every certificate in a file
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	certs := parseCertificates(data)
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate found in '%s'", path)
+	}
+
+	pool := x509.NewCertPool()
+	for _, c := range certs {
+		pool.AddCert(c)
+	}
+	return pool, nil
+}
+
+// runTLSServer serves TLS connections from ln until ctx is done, then waits for open connections to finish
+func runTLSServer(ctx context.Context, ln net.Listener, config *tls.Config, useHTTP bool, log io.Writer) error {
+	var logMu sync.Mutex
+	logf := func(format string, a ...any) {
+		logMu.Lock()
+		defer logMu.Unlock()
+		fmt.Fprintf(log, format, a...)
+	}
+
+	if useHTTP {
+		server := &http.Server{
+			TLSConfig: config,
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				details := handshakeSummary(*r.TLS)
+				logf("%s %s %s %s %s\n", timestamp(), r.RemoteAddr, r.Method, r.URL.Path, details)
+				fmt.Fprintln(w, details)
+			}),
+		}
+		go func() {
+			<-ctx.Done()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			server.Shutdown(shutdownCtx)
+		}()
+
+		err := server.ServeTLS(ln, "", "")
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	}
+
+	tlsListener := tls.NewListener(ln, config)
+	go func() {
+		<-ctx.Done()
+		tlsListener.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := tlsListener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serveEcho(ctx, conn.(*tls.Conn), logf)
+		}()
+	}
+}
+
+// serveEcho completes the handshake, logs it and echoes every line back until the client or ctx closes
+func serveEcho(ctx context.Context, conn *tls.Conn, logf func(string, ...any)) {
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if err := conn.HandshakeContext(ctx); err != nil {
+		logf("%s %s handshake failed: %v\n", timestamp(), conn.RemoteAddr(), err)
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	details := handshakeSummary(conn.ConnectionState())
+	logf("%s %s %s\n", timestamp(), conn.RemoteAddr(), details)
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		if _, err := fmt.Fprintln(conn, scanner.Text()); err != nil {
+			return
+		}
+	}
+}
+
+// handshakeSummary describes the negotiated parameters and the certificate presented by the peer
+func handshakeSummary(state tls.ConnectionState) string {
+	parts := []string{tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite)}
+	if state.NegotiatedProtocol != "" {
+		parts = append(parts, "alpn="+state.NegotiatedProtocol)
+	}
+	if len(state.PeerCertificates) > 0 {
+		parts = append(parts, "peer="+state.PeerCertificates[0].Subject.String())
+	} else {
+		parts = append(parts, "peer=none")
+	}
+	return strings.Join(parts, " ")
+}
+
+// timestamp prefixes server log lines
+func timestamp() string {
+	return time.Now().Format("15:04:05")
+}
@@ -0,0 +1,244 @@
+package certificates
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer the server goroutines can log to while the test reads it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// tlsFixture holds the files of a CA, a server and a client certificate it issued, and a client of another CA
+type tlsFixture struct {
+	caPath, serverCert, serverKey string
+	clientCert, clientKey         string
+	strangerCert, strangerKey     string
+	otherCAPath                   string
+}
+
+func writeTLSFixture(t *testing.T) tlsFixture {
+	t.Helper()
+	dir := t.TempDir()
+	ca := newTestCA(t, "Test CA", nil)
+	other := newTestCA(t, "Other CA", nil)
+	// The server key comes from the generator of gsn csr
+	pair, err := generateECDSAKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTestLeaf(t, "localhost", ca, pair.PrivateKey)
+	client := newTestLeaf(t, "client", ca, nil)
+	stranger := newTestLeaf(t, "stranger", other, nil)
+
+	return tlsFixture{
+		caPath:       writeTestFile(t, dir, "ca.pem", certPEM(ca)),
+		otherCAPath:  writeTestFile(t, dir, "other-ca.pem", certPEM(other)),
+		serverCert:   writeTestFile(t, dir, "server.pem", certPEM(server, ca)),
+		serverKey:    writeTestFile(t, dir, "server.key", []byte(pair.PrivateKeyPEM)),
+		clientCert:   writeTestFile(t, dir, "client.pem", certPEM(client)),
+		clientKey:    writeTestFile(t, dir, "client.key", keyPEM(t, client)),
+		strangerCert: writeTestFile(t, dir, "stranger.pem", certPEM(stranger)),
+		strangerKey:  writeTestFile(t, dir, "stranger.key", keyPEM(t, stranger)),
+	}
+}
+
+// startTLSServer runs runTLSServer on a free local port until the test ends, returning its address, its log and
+// a function stopping it and returning the server's error
+func startTLSServer(t *testing.T, config *tls.Config, useHTTP bool) (string, *syncBuffer, func() error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	log := &syncBuffer{}
+	done := make(chan error, 1)
+	go func() { done <- runTLSServer(ctx, ln, config, useHTTP, log) }()
+
+	var once sync.Once
+	var serveErr error
+	stop := func() error {
+		once.Do(func() {
+			cancel()
+			select {
+			case serveErr = <-done:
+			case <-time.After(10 * time.Second):
+				t.Fatal("the server did not stop")
+			}
+		})
+		return serveErr
+	}
+	t.Cleanup(func() { stop() })
+	return ln.Addr().String(), log, stop
+}
+
+func TestMutualTLSEndToEnd(t *testing.T) {
+	f := writeTLSFixture(t)
+	config, err := serverTLSConfig(f.serverCert, f.serverKey, f.caPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, log, stop := startTLSServer(t, config, false)
+
+	client, err := clientTLSConfig(f.clientCert, f.clientKey, f.caPath, "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := connectTLS(context.Background(), addr, client, "ping")
+	if err != nil {
+		t.Fatalf("connect with the client certificate: %v", err)
+	}
+	if result.Reply != "ping" {
+		t.Errorf("reply = %q, want the echo", result.Reply)
+	}
+	if result.VerifyErr != nil {
+		t.Errorf("the server chain does not verify: %v", result.VerifyErr)
+	}
+	if len(result.State.PeerCertificates) != 2 || result.State.PeerCertificates[0].Subject.CommonName != "localhost" {
+		t.Errorf("server presented %d certificate(s)", len(result.State.PeerCertificates))
+	}
+	if summary := handshakeSummary(result.State); !strings.HasPrefix(summary, "TLS 1.3 TLS_") || !strings.Contains(summary, "peer=CN=localhost") {
+		t.Errorf("client side summary = %q", summary)
+	}
+
+	// Without a client certificate, and with one of another CA, the server refuses the handshake
+	for _, pair := range [][2]string{{"", ""}, {f.strangerCert, f.strangerKey}} {
+		client, err := clientTLSConfig(pair[0], pair[1], f.caPath, "localhost")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := connectTLS(context.Background(), addr, client, ""); err == nil {
+			t.Errorf("connected with client certificate %q", pair[0])
+		}
+	}
+
+	if err := stop(); err != nil {
+		t.Errorf("server stopped with %v", err)
+	}
+	lines := log.String()
+	if !strings.Contains(lines, "peer=CN=client") {
+		t.Errorf("the server did not log the client certificate:\n%s", lines)
+	}
+	if strings.Count(lines, "handshake failed") != 2 {
+		t.Errorf("the server did not log both refused handshakes:\n%s", lines)
+	}
+}
+
+func TestConnectReportsUnverifiedServer(t *testing.T) {
+	f := writeTLSFixture(t)
+	config, err := serverTLSConfig(f.serverCert, f.serverKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, log, stop := startTLSServer(t, config, false)
+
+	// Another CA, and the right CA with another name: the handshake completes, the chain is reported as failing
+	for _, test := range []struct{ ca, name string }{{f.otherCAPath, "localhost"}, {f.caPath, "example.com"}} {
+		client, err := clientTLSConfig("", "", test.ca, test.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := connectTLS(context.Background(), addr, client, "hello")
+		if err != nil {
+			t.Fatalf("connect verifying with %s for %s: %v", test.ca, test.name, err)
+		}
+		if result.VerifyErr == nil || result.Reply != "hello" {
+			t.Errorf("verifying with %s for %s = %v, reply %q, want a verification error and the echo", test.ca, test.name, result.VerifyErr, result.Reply)
+		}
+	}
+	stop()
+	if !strings.Contains(log.String(), "peer=none") {
+		t.Errorf("log = %s", log.String())
+	}
+}
+
+func TestTLSServerShutsDownWithOpenConnections(t *testing.T) {
+	f := writeTLSFixture(t)
+	config, err := serverTLSConfig(f.serverCert, f.serverKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, _, stop := startTLSServer(t, config, false)
+
+	client, err := clientTLSConfig("", "", f.caPath, "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := tls.Dial("tcp", addr, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The idle connection is closed by the server rather than keeping it running
+	start := time.Now()
+	if err := stop(); err != nil {
+		t.Errorf("server stopped with %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("shutdown took %v", elapsed)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("the connection is still open after the shutdown")
+	}
+}
+
+func TestHTTPSServerAnswersWithHandshake(t *testing.T) {
+	f := writeTLSFixture(t)
+	config, err := serverTLSConfig(f.serverCert, f.serverKey, f.caPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, log, stop := startTLSServer(t, config, true)
+
+	client, err := clientTLSConfig(f.clientCert, f.clientKey, f.caPath, "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.InsecureSkipVerify = false
+	httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: client}, Timeout: 10 * time.Second}
+	resp, err := httpClient.Get("https://" + addr + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "peer=CN=client") {
+		t.Errorf("response = %q", body)
+	}
+	httpClient.CloseIdleConnections()
+
+	if err := stop(); err != nil {
+		t.Errorf("server stopped with %v", err)
+	}
+	if !strings.Contains(log.String(), "GET /health") {
+		t.Errorf("log = %s", log.String())
+	}
+}
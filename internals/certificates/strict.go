@@ -0,0 +1,191 @@
+package certificates
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/style"
+
+	"github.com/spf13/cobra"
+)
+
+// maxPublicValidityDays is the longest validity the CA/Browser Forum baseline requirements allow for a TLS
+// certificate
+const maxPublicValidityDays = 398
+
+// minRSABits is the smallest RSA modulus public CAs accept
+const minRSABits = 2048
+
+// certProfile is what a certificate is generated with, as far as strict mode checks it
+type certProfile struct {
+	CommonName         string
+	DNSNames           []string
+	IPAddresses        []net.IP
+	ValidityDays       int
+	SignatureAlgorithm x509.SignatureAlgorithm
+	PublicKeyAlgorithm x509.PublicKeyAlgorithm
+	// KeyBits is the size of the RSA modulus or of the ECDSA curve
+	KeyBits int
+}
+
+// profileFromCSR describes the certificate a CSR becomes once signed for validityDays
+func profileFromCSR(csr *x509.CertificateRequest, validityDays int) certProfile {
+	p := certProfile{
+		CommonName:         csr.Subject.CommonName,
+		DNSNames:           csr.DNSNames,
+		IPAddresses:        csr.IPAddresses,
+		ValidityDays:       validityDays,
+		SignatureAlgorithm: csr.SignatureAlgorithm,
+		PublicKeyAlgorithm: csr.PublicKeyAlgorithm,
+	}
+	switch key := csr.PublicKey.(type) {
+	case *rsa.PublicKey:
+		p.KeyBits = key.N.BitLen()
+	case *ecdsa.PublicKey:
+		p.KeyBits = key.Curve.Params().BitSize
+	}
+	return p
+}
+
+// strictRule is a constraint public CAs enforce. Check returns why a profile breaks it, or "" when it does not.
+type strictRule struct {
+	ID    string
+	Check func(p certProfile) string
+}
+
+// strictRules are checked in order by --strict, a new rule only needs an entry here
+var strictRules = []strictRule{
+	{ID: "max-validity", Check: func(p certProfile) string {
+		if p.ValidityDays > maxPublicValidityDays {
+			return fmt.Sprintf("a validity of %d days is over the %d days public CAs issue", p.ValidityDays, maxPublicValidityDays)
+		}
+		return ""
+	}},
+	{ID: "san-required", Check: func(p certProfile) string {
+		if len(p.DNSNames) == 0 && len(p.IPAddresses) == 0 {
+			return "the certificate has no subject alternative names, browsers ignore the common name"
+		}
+		return ""
+	}},
+	{ID: "cn-in-san", Check: func(p certProfile) string {
+		if p.CommonName == "" || len(p.DNSNames) == 0 && len(p.IPAddresses) == 0 {
+			return ""
+		}
+		for _, name := range p.DNSNames {
+			if strings.EqualFold(name, p.CommonName) {
+				return ""
+			}
+		}
+		for _, ip := range p.IPAddresses {
+			if ip.String() == p.CommonName {
+				return ""
+			}
+		}
+		return fmt.Sprintf("the common name '%s' is not one of the subject alternative names", p.CommonName)
+	}},
+	{ID: "no-sha1", Check: func(p certProfile) string {
+		switch p.SignatureAlgorithm {
+		case x509.SHA1WithRSA, x509.ECDSAWithSHA1, x509.DSAWithSHA1:
+			return fmt.Sprintf("the signature algorithm %s uses SHA-1", p.SignatureAlgorithm)
+		}
+		return ""
+	}},
+	{ID: "rsa-min-bits", Check: func(p certProfile) string {
+		if p.PublicKeyAlgorithm == x509.RSA && p.KeyBits < minRSABits {
+			return fmt.Sprintf("an RSA key of %d bits is under the %d bits public CAs accept", p.KeyBits, minRSABits)
+		}
+		return ""
+	}},
+	{ID: "wildcard-depth", Check: func(p certProfile) string {
+		for _, name := range p.DNSNames {
+			labels := strings.Split(name, ".")
+			for i, label := range labels {
+				if strings.Contains(label, "*") && (i > 0 || label != "*" || len(labels) < 3) {
+					return fmt.Sprintf("the wildcard of '%s' may only cover one label, as the whole leftmost label of a name with two more", name)
+				}
+			}
+		}
+		return ""
+	}},
+	{ID: "cn-not-ip", Check: func(p certProfile) string {
+		if net.ParseIP(p.CommonName) != nil {
+			return fmt.Sprintf("the common name '%s' is an IP address, IP addresses only belong in the IP subject alternative names", p.CommonName)
+		}
+		return ""
+	}},
+}
+
+// strictViolation is a rule a profile breaks
+type strictViolation struct {
+	Rule   string
+	Reason string
+}
+
+// checkStrict returns every rule p breaks, in the order of strictRules
+func checkStrict(p certProfile) []strictViolation {
+	var violations []strictViolation
+	for _, rule := range strictRules {
+		if reason := rule.Check(p); reason != "" {
+			violations = append(violations, strictViolation{Rule: rule.ID, Reason: reason})
+		}
+	}
+	return violations
+}
+
+// Strict modes of --strict
+const (
+	strictOff   = "off"
+	strictError = "error"
+	strictWarn  = "warn"
+)
+
+// addStrictFlag registers --strict on a command generating certificates, --strict alone means error
+func addStrictFlag(cmd *cobra.Command) {
+	cmd.Flags().String("strict", strictOff, "Check the certificate against the rules of public CAs: error, warn or off")
+	cmd.Flags().Lookup("strict").NoOptDefVal = strictError
+}
+
+// strictModeFromFlags reads --strict, accepting true and false for error and off
+func strictModeFromFlags(cmd *cobra.Command) (string, error) {
+	mode, _ := cmd.Flags().GetString("strict")
+	switch strings.ToLower(mode) {
+	case strictOff, "false":
+		return strictOff, nil
+	case strictError, "true":
+		return strictError, nil
+	case strictWarn:
+		return strictWarn, nil
+	}
+	return "", clierr.Newf(clierr.Usage, "invalid --strict '%s', use error, warn or off", mode)
+}
+
+// enforceStrict checks p in the given mode: in error mode every violation is listed in the returned error, in
+// warn mode they are printed as warnings
+func enforceStrict(p certProfile, mode string) error {
+	if mode == strictOff {
+		return nil
+	}
+	violations := checkStrict(p)
+	if len(violations) == 0 {
+		return nil
+	}
+	if mode == strictWarn {
+		for _, v := range violations {
+			fmt.Fprintf(os.Stderr, style.Warning()+"strict rule %s: %s\n", v.Rule, v.Reason)
+		}
+		return nil
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "the certificate breaks %d strict rule(s), nothing was generated:", len(violations))
+	for _, v := range violations {
+		fmt.Fprintf(&b, "\n  %s: %s", v.Rule, v.Reason)
+	}
+	return clierr.Newf(clierr.Usage, "%s", b.String())
+}
@@ -0,0 +1,261 @@
+package certificates
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"gsn-dev-tools/internals/clierr"
+
+	"github.com/spf13/cobra"
+)
+
+// compliantProfile is a certificate every strict rule accepts
+func compliantProfile() certProfile {
+	return certProfile{
+		CommonName:         "www.example.com",
+		DNSNames:           []string{"www.example.com", "example.com"},
+		ValidityDays:       90,
+		SignatureAlgorithm: x509.ECDSAWithSHA256,
+		PublicKeyAlgorithm: x509.ECDSA,
+		KeyBits:            256,
+	}
+}
+
+// ruleIDs lists the rules of violations
+func ruleIDs(violations []strictViolation) []string {
+	var ids []string
+	for _, v := range violations {
+		ids = append(ids, v.Rule)
+	}
+	return ids
+}
+
+// TestStrictRules checks every rule on its own: each case changes the compliant profile so that it breaks the
+// rule, or only just keeps it
+func TestStrictRules(t *testing.T) {
+	tests := []struct {
+		rule   string
+		name   string
+		change func(p *certProfile)
+		// breaks is whether the rule is the one broken, the profile passes every rule otherwise
+		breaks bool
+	}{
+		{"max-validity", "398 days", func(p *certProfile) { p.ValidityDays = 398 }, false},
+		{"max-validity", "399 days", func(p *certProfile) { p.ValidityDays = 399 }, true},
+		{"max-validity", "ten years", func(p *certProfile) { p.ValidityDays = 3650 }, true},
+
+		{"san-required", "no names", func(p *certProfile) { p.DNSNames = nil }, true},
+		{"san-required", "an IP only", func(p *certProfile) {
+			p.CommonName, p.DNSNames, p.IPAddresses = "", nil, []net.IP{net.ParseIP("192.0.2.10")}
+		}, false},
+
+		{"cn-in-san", "another name", func(p *certProfile) { p.DNSNames = []string{"api.example.com"} }, true},
+		{"cn-in-san", "other case", func(p *certProfile) { p.CommonName = "WWW.Example.COM" }, false},
+		{"cn-in-san", "no common name", func(p *certProfile) { p.CommonName = "" }, false},
+
+		{"no-sha1", "SHA-1 with RSA", func(p *certProfile) {
+			p.SignatureAlgorithm, p.PublicKeyAlgorithm, p.KeyBits = x509.SHA1WithRSA, x509.RSA, 2048
+		}, true},
+		{"no-sha1", "ECDSA with SHA-1", func(p *certProfile) { p.SignatureAlgorithm = x509.ECDSAWithSHA1 }, true},
+		{"no-sha1", "SHA-384", func(p *certProfile) { p.SignatureAlgorithm = x509.ECDSAWithSHA384 }, false},
+
+		{"rsa-min-bits", "1024 bits", func(p *certProfile) {
+			p.SignatureAlgorithm, p.PublicKeyAlgorithm, p.KeyBits = x509.SHA256WithRSA, x509.RSA, 1024
+		}, true},
+		{"rsa-min-bits", "2048 bits", func(p *certProfile) {
+			p.SignatureAlgorithm, p.PublicKeyAlgorithm, p.KeyBits = x509.SHA256WithRSA, x509.RSA, 2048
+		}, false},
+		{"rsa-min-bits", "a smaller ECDSA curve", func(p *certProfile) { p.KeyBits = 224 }, false},
+
+		{"wildcard-depth", "leftmost label", func(p *certProfile) { p.DNSNames = append(p.DNSNames, "*.example.com") }, false},
+		{"wildcard-depth", "a label deeper", func(p *certProfile) { p.DNSNames = append(p.DNSNames, "www.*.example.com") }, true},
+		{"wildcard-depth", "part of a label", func(p *certProfile) { p.DNSNames = append(p.DNSNames, "w*.example.com") }, true},
+		{"wildcard-depth", "a whole registered domain", func(p *certProfile) { p.DNSNames = append(p.DNSNames, "*.com") }, true},
+		{"wildcard-depth", "two wildcards", func(p *certProfile) { p.DNSNames = append(p.DNSNames, "*.*.example.com") }, true},
+
+		{"cn-not-ip", "IPv4", func(p *certProfile) {
+			p.CommonName, p.IPAddresses = "192.0.2.10", []net.IP{net.ParseIP("192.0.2.10")}
+		}, true},
+		{"cn-not-ip", "IPv6", func(p *certProfile) {
+			p.CommonName, p.IPAddresses = "2001:db8::1", []net.IP{net.ParseIP("2001:db8::1")}
+		}, true},
+		{"cn-not-ip", "a name like an address", func(p *certProfile) {
+			p.CommonName, p.DNSNames = "10.example.com", []string{"10.example.com"}
+		}, false},
+	}
+
+	covered := map[string]bool{}
+	for _, test := range tests {
+		t.Run(test.rule+"/"+test.name, func(t *testing.T) {
+			p := compliantProfile()
+			test.change(&p)
+			violations := checkStrict(p)
+			var want []string
+			if test.breaks {
+				want = []string{test.rule}
+			}
+			if got := ruleIDs(violations); !slices.Equal(got, want) {
+				t.Fatalf("broken rules = %q, want %q", got, want)
+			}
+			if test.breaks && violations[0].Reason == "" {
+				t.Errorf("%s has no reason", test.rule)
+			}
+		})
+		if test.breaks {
+			covered[test.rule] = true
+		}
+	}
+
+	// A new rule needs cases here
+	for _, rule := range strictRules {
+		if !covered[rule.ID] {
+			t.Errorf("no case breaks the rule %s", rule.ID)
+		}
+	}
+}
+
+func TestCheckStrictKeepsTheRuleOrder(t *testing.T) {
+	p := certProfile{
+		CommonName:         "192.0.2.10",
+		ValidityDays:       825,
+		SignatureAlgorithm: x509.SHA1WithRSA,
+		PublicKeyAlgorithm: x509.RSA,
+		KeyBits:            1024,
+	}
+	want := []string{"max-validity", "san-required", "no-sha1", "rsa-min-bits", "cn-not-ip"}
+	if got := ruleIDs(checkStrict(p)); !slices.Equal(got, want) {
+		t.Errorf("broken rules = %q, want %q", got, want)
+	}
+	if violations := checkStrict(compliantProfile()); len(violations) > 0 {
+		t.Errorf("the compliant profile breaks %+v", violations)
+	}
+}
+
+func TestProfileFromCSR(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		key      any
+		template x509.CertificateRequest
+		want     certProfile
+		broken   []string
+	}{
+		{rsaKey, x509.CertificateRequest{
+			Subject:            pkix.Name{CommonName: "legacy.example.com"},
+			DNSNames:           []string{"legacy.example.com"},
+			SignatureAlgorithm: x509.SHA256WithRSA,
+		}, certProfile{CommonName: "legacy.example.com", ValidityDays: 365, SignatureAlgorithm: x509.SHA256WithRSA, PublicKeyAlgorithm: x509.RSA, KeyBits: 1024}, []string{"rsa-min-bits"}},
+		{ecKey, x509.CertificateRequest{
+			Subject:            pkix.Name{CommonName: "192.0.2.10"},
+			IPAddresses:        []net.IP{net.ParseIP("192.0.2.10")},
+			SignatureAlgorithm: x509.ECDSAWithSHA384,
+		}, certProfile{CommonName: "192.0.2.10", ValidityDays: 365, SignatureAlgorithm: x509.ECDSAWithSHA384, PublicKeyAlgorithm: x509.ECDSA, KeyBits: 384}, []string{"cn-not-ip"}},
+	}
+	for _, test := range tests {
+		der, err := x509.CreateCertificateRequest(rand.Reader, &test.template, test.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := profileFromCSR(csr, 365)
+		if p.CommonName != test.want.CommonName || p.ValidityDays != test.want.ValidityDays || p.SignatureAlgorithm != test.want.SignatureAlgorithm ||
+			p.PublicKeyAlgorithm != test.want.PublicKeyAlgorithm || p.KeyBits != test.want.KeyBits {
+			t.Errorf("profile = %+v, want %+v", p, test.want)
+		}
+		if got := ruleIDs(checkStrict(p)); !slices.Equal(got, test.broken) {
+			t.Errorf("%s: broken rules = %q, want %q", p.CommonName, got, test.broken)
+		}
+	}
+}
+
+func TestEnforceStrict(t *testing.T) {
+	p := compliantProfile()
+	p.ValidityDays = 730
+	p.DNSNames = append(p.DNSNames, "a.*.example.com")
+
+	for _, mode := range []string{strictOff, strictError, strictWarn} {
+		if err := enforceStrict(compliantProfile(), mode); err != nil {
+			t.Errorf("%s mode on a compliant profile = %v", mode, err)
+		}
+	}
+	if err := enforceStrict(p, strictOff); err != nil {
+		t.Errorf("off mode = %v", err)
+	}
+
+	err := enforceStrict(p, strictError)
+	want := "the certificate breaks 2 strict rule(s), nothing was generated:\n" +
+		"  max-validity: a validity of 730 days is over the 398 days public CAs issue\n" +
+		"  wildcard-depth: the wildcard of 'a.*.example.com' may only cover one label, as the whole leftmost label of a name with two more"
+	if err == nil || err.Error() != want || clierr.CodeOf(err) != clierr.Usage {
+		t.Errorf("error mode = %v, want a usage error\n%s", err, want)
+	}
+
+	// Warn mode prints the same rules and goes on
+	stderr, err := os.CreateTemp(t.TempDir(), "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stderr
+	os.Stderr = stderr
+	err = enforceStrict(p, strictWarn)
+	os.Stderr = saved
+	if err != nil {
+		t.Errorf("warn mode = %v", err)
+	}
+	data, _ := os.ReadFile(stderr.Name())
+	for _, rule := range []string{"strict rule max-validity: a validity of 730 days", "strict rule wildcard-depth: the wildcard of 'a.*.example.com'"} {
+		if !strings.Contains(string(data), rule) {
+			t.Errorf("warnings do not hold %q:\n%s", rule, data)
+		}
+	}
+}
+
+func TestStrictModeFromFlags(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, strictOff},
+		{[]string{"--strict"}, strictError},
+		{[]string{"--strict=warn"}, strictWarn},
+		{[]string{"--strict=WARN"}, strictWarn},
+		{[]string{"--strict=true"}, strictError},
+		{[]string{"--strict=false"}, strictOff},
+		{[]string{"--strict=off"}, strictOff},
+	}
+	for _, test := range tests {
+		cmd := &cobra.Command{Use: "csr"}
+		addStrictFlag(cmd)
+		if err := cmd.ParseFlags(test.args); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := strictModeFromFlags(cmd); err != nil || got != test.want {
+			t.Errorf("%q: mode = %q, %v, want %q", test.args, got, err, test.want)
+		}
+	}
+
+	cmd := &cobra.Command{Use: "csr"}
+	addStrictFlag(cmd)
+	_ = cmd.ParseFlags([]string{"--strict=loud"})
+	if _, err := strictModeFromFlags(cmd); err == nil || clierr.CodeOf(err) != clierr.Usage || !strings.Contains(err.Error(), "invalid --strict 'loud'") {
+		t.Errorf("--strict=loud = %v", err)
+	}
+}
@@ -0,0 +1,452 @@
+package certificates
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/execx"
+	"gsn-dev-tools/internals/output"
+	"gsn-dev-tools/internals/style"
+	"gsn-dev-tools/internals/tmpfs"
+	"gsn-dev-tools/internals/tui"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// Runner runs the tools of the system trust store, tests replace it with an execx.Fake
+var Runner execx.Runner = execx.Default
+
+const (
+	// projectBundleName is the bundle written into a --project directory
+	projectBundleName = "ca-bundle.pem"
+	// debianAnchorDir holds the local CAs update-ca-certificates adds to the Debian trust store
+	debianAnchorDir = "/usr/local/share/ca-certificates"
+	// macSystemKeychain is the keychain of the certificates trusted by every user of a Mac
+	macSystemKeychain = "/Library/Keychains/System.keychain"
+)
+
+// Platforms of the system trust store
+const (
+	platformMac    = "macos"
+	platformDebian = "debian"
+)
+
+func trustCertsCmd() *cobra.Command {
+	trustCmd := &cobra.Command{
+		Use:   "trust <ca.pem> --project <dir> | --system",
+		Short: "Adds a CA certificate to a project bundle or the system trust store",
+		Long: `Adds every certificate of a PEM file, typically a local CA, to a trust store.
+
+--project appends them to the ca-bundle.pem of a directory, or to the .pem or .crt file named, for docker images
+and test harnesses to copy. Certificates already in the bundle, by SHA-256 fingerprint, are not added twice.
+
+--system installs them for the whole machine: security add-trusted-cert into the System keychain on macOS,
+a gsn-<fingerprint>.crt in /usr/local/share/ca-certificates and update-ca-certificates on Debian and Ubuntu.
+The commands run with sudo unless gsn runs as root, and are shown and confirmed first; --yes skips the question.`,
+		Example: `  gsn cert trust ./certs/ca.pem --project ./docker/certs
+  gsn cert trust ./certs/ca.pem --project ./test/fixtures/roots.pem
+  gsn cert trust ./certs/ca.pem --system`,
+		Args: cobra.ExactArgs(1),
+		Run:  TrustCertificate,
+	}
+	addTrustFlags(trustCmd)
+	return trustCmd
+}
+
+func untrustCertsCmd() *cobra.Command {
+	untrustCmd := &cobra.Command{
+		Use:   "untrust <ca.pem> --project <dir> | --system",
+		Short: "Removes a CA certificate added by gsn cert trust",
+		Long: `Removes every certificate of a PEM file from a trust store again, matched by fingerprint.
+
+--project drops them from the bundle of the directory or file, and removes the bundle when nothing is left in it.
+--system removes the trust settings and the certificate from the System keychain on macOS, or the
+gsn-<fingerprint>.crt written by gsn cert trust and refreshes the store on Debian and Ubuntu. As with trust, the
+commands are confirmed first unless --yes is set.`,
+		Example: `  gsn cert untrust ./certs/ca.pem --project ./docker/certs
+  gsn cert untrust ./certs/ca.pem --system --yes`,
+		Args: cobra.ExactArgs(1),
+		Run:  UntrustCertificate,
+	}
+	addTrustFlags(untrustCmd)
+	return untrustCmd
+}
+
+func addTrustFlags(cmd *cobra.Command) {
+	cmd.Flags().String("project", "", "Project directory holding ca-bundle.pem, or the .pem or .crt bundle file itself")
+	cmd.Flags().Bool("system", false, "Change the trust store of the operating system, with sudo unless run as root")
+	cmd.Flags().BoolP("yes", "y", false, "Change the system trust store without asking")
+	cmd.MarkFlagsMutuallyExclusive("project", "system")
+	cmd.MarkFlagsOneRequired("project", "system")
+	dryrun.Adopt(cmd)
+}
+
+func TrustCertificate(cmd *cobra.Command, args []string) {
+	changeTrust(cmd, args[0], true)
+}
+
+func UntrustCertificate(cmd *cobra.Command, args []string) {
+	changeTrust(cmd, args[0], false)
+}
+
+// changeTrust adds the certificates of caPath to the store chosen by the flags, or removes them
+func changeTrust(cmd *cobra.Command, caPath string, add bool) {
+	project, _ := cmd.Flags().GetString("project")
+	assumeYes, _ := cmd.Flags().GetBool("yes")
+
+	data, err := os.ReadFile(caPath)
+	if err != nil {
+		clierr.Fatalf("Error reading '%s': %v", caPath, err)
+	}
+	certs := parseCertificates(data)
+	if len(certs) == 0 {
+		clierr.Fatalf("No certificate found in '%s'", caPath)
+	}
+	if add {
+		for _, c := range certs {
+			if !c.IsCA {
+				fmt.Fprintf(os.Stderr, style.Warning()+"'%s' is not a CA certificate, only it is trusted and not what it signs\n", c.Subject)
+			}
+		}
+	}
+
+	if project != "" {
+		changeProjectTrust(projectBundlePath(project), certs, add)
+		return
+	}
+	changeSystemTrust(cmd, certs, add, assumeYes)
+}
+
+// projectBundlePath is the bundle of --project: the file named when it ends in .pem or .crt, the ca-bundle.pem
+// of the directory otherwise
+func projectBundlePath(project string) string {
+	switch strings.ToLower(filepath.Ext(project)) {
+	case ".pem", ".crt":
+		return project
+	}
+	return filepath.Join(project, projectBundleName)
+}
+
+// changeProjectTrust adds certs to the bundle at path or removes them from it
+func changeProjectTrust(path string, certs []*x509.Certificate, add bool) {
+	data, err := os.ReadFile(path)
+	// A bundle is created by the first certificate added to it
+	if err != nil && (!add || !errors.Is(err, fs.ErrNotExist)) {
+		clierr.Fatalf("Error reading '%s': %v", path, err)
+	}
+
+	var changed []*x509.Certificate
+	if add {
+		data, changed = addToBundle(data, certs)
+	} else {
+		data, changed = removeFromBundle(data, certs)
+	}
+	if len(changed) == 0 {
+		if add {
+			fmt.Printf("Every certificate is in %s already\n", path)
+		} else {
+			fmt.Printf("None of the certificates is in %s\n", path)
+		}
+		return
+	}
+
+	empty := len(bundleBlocks(data)) == 0 && len(bytes.TrimSpace(data)) == 0
+	var description string
+	switch {
+	case add:
+		description = fmt.Sprintf("add %d certificate(s) to %s", len(changed), path)
+	case empty:
+		description = fmt.Sprintf("remove %s, it holds no other certificate", path)
+	default:
+		description = fmt.Sprintf("remove %d certificate(s) from %s", len(changed), path)
+	}
+	err = dryrun.Do(description, func() error {
+		if !add && empty {
+			return os.Remove(path)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		return output.WriteFileAtomic(path, data, 0o644)
+	})
+	if err != nil {
+		clierr.Fatalf("Error writing '%s': %v", path, err)
+	}
+	if dryrun.Enabled() {
+		return
+	}
+
+	for _, c := range changed {
+		fmt.Printf("  %s  %s\n", certFingerprint(c)[:16], c.Subject)
+	}
+	if add {
+		fmt.Printf(style.Success()+"Added %d certificate(s) to %s\n", len(changed), path)
+	} else {
+		fmt.Printf(style.Success()+"Removed %d certificate(s) from %s\n", len(changed), path)
+	}
+}
+
+// bundleBlock is a PEM block of a bundle and where it sits in the file, cert is nil for other blocks
+type bundleBlock struct {
+	cert       *x509.Certificate
+	start, end int
+}
+
+// bundleBlocks lists the PEM blocks of a bundle in order
+func bundleBlocks(data []byte) []bundleBlock {
+	var blocks []bundleBlock
+	rest := data
+	for {
+		begin := bytes.Index(rest, []byte("-----BEGIN "))
+		if begin < 0 {
+			return blocks
+		}
+		block, after := pem.Decode(rest[begin:])
+		if block == nil {
+			return blocks
+		}
+		b := bundleBlock{start: len(data) - len(rest) + begin, end: len(data) - len(after)}
+		if block.Type == "CERTIFICATE" {
+			b.cert, _ = x509.ParseCertificate(block.Bytes)
+		}
+		blocks = append(blocks, b)
+		rest = after
+	}
+}
+
+// addToBundle appends the certificates of certs missing from the bundle, each under a comment naming its
+// subject. It returns the new bundle and the certificates added.
+func addToBundle(data []byte, certs []*x509.Certificate) ([]byte, []*x509.Certificate) {
+	present := map[string]bool{}
+	for _, b := range bundleBlocks(data) {
+		if b.cert != nil {
+			present[certFingerprint(b.cert)] = true
+		}
+	}
+
+	var out bytes.Buffer
+	out.Write(data)
+	if out.Len() > 0 && !bytes.HasSuffix(data, []byte("\n")) {
+		out.WriteByte('\n')
+	}
+	var added []*x509.Certificate
+	for _, c := range certs {
+		fingerprint := certFingerprint(c)
+		if present[fingerprint] {
+			continue
+		}
+		present[fingerprint] = true
+		fmt.Fprintf(&out, "# %s\n", c.Subject)
+		pem.Encode(&out, &pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})
+		added = append(added, c)
+	}
+	return out.Bytes(), added
+}
+
+// removeFromBundle drops the blocks of the certificates of certs from the bundle, with the comment line right
+// above each of them. Everything else is kept as it is. It returns the new bundle and the certificates removed.
+func removeFromBundle(data []byte, certs []*x509.Certificate) ([]byte, []*x509.Certificate) {
+	wanted := map[string]*x509.Certificate{}
+	for _, c := range certs {
+		wanted[certFingerprint(c)] = c
+	}
+
+	var out []byte
+	var removed []*x509.Certificate
+	kept := 0
+	for _, b := range bundleBlocks(data) {
+		if b.cert == nil {
+			continue
+		}
+		c, ok := wanted[certFingerprint(b.cert)]
+		if !ok {
+			continue
+		}
+		start := b.start
+		if lineStart := bytes.LastIndexByte(data[:max(start-1, 0)], '\n') + 1; start > 0 && bytes.HasPrefix(data[lineStart:], []byte("# ")) {
+			start = lineStart
+		}
+		out = append(out, data[kept:max(start, kept)]...)
+		kept = b.end
+		if !slices.Contains(removed, c) {
+			removed = append(removed, c)
+		}
+	}
+	if removed == nil {
+		return data, nil
+	}
+	return append(out, data[kept:]...), removed
+}
+
+// certFingerprint is the hex SHA-256 of the DER of a certificate
+func certFingerprint(c *x509.Certificate) string {
+	sum := sha256.Sum256(c.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// trustItem is a certificate of the CA file written alone to path, the system tools take one certificate a file
+type trustItem struct {
+	path string
+	cert *x509.Certificate
+}
+
+// trustCommand is a command changing the system trust store
+type trustCommand struct {
+	name string
+	args []string
+}
+
+func (c trustCommand) String() string {
+	return strings.Join(append([]string{c.name}, c.args...), " ")
+}
+
+// systemTrustCommands returns the commands adding items to the trust store of platform, or removing them. They
+// need root, with sudo they run through it.
+func systemTrustCommands(platform string, items []trustItem, add bool, sudo bool) ([]trustCommand, error) {
+	var commands []trustCommand
+	switch platform {
+	case platformMac:
+		for _, item := range items {
+			if add {
+				commands = append(commands, trustCommand{"security", []string{"add-trusted-cert", "-d", "-r", "trustRoot", "-k", macSystemKeychain, item.path}})
+				continue
+			}
+			sum := sha1.Sum(item.cert.Raw)
+			commands = append(commands,
+				trustCommand{"security", []string{"remove-trusted-cert", "-d", item.path}},
+				trustCommand{"security", []string{"delete-certificate", "-Z", strings.ToUpper(hex.EncodeToString(sum[:])), macSystemKeychain}})
+		}
+	case platformDebian:
+		for _, item := range items {
+			anchor := debianAnchorPath(item.cert)
+			if add {
+				commands = append(commands, trustCommand{"install", []string{"-m", "0644", item.path, anchor}})
+			} else {
+				commands = append(commands, trustCommand{"rm", []string{"-f", anchor}})
+			}
+		}
+		if add {
+			commands = append(commands, trustCommand{"update-ca-certificates", nil})
+		} else {
+			commands = append(commands, trustCommand{"update-ca-certificates", []string{"--fresh"}})
+		}
+	default:
+		return nil, fmt.Errorf("the system trust store of %s is not supported, only macOS and Debian-likes with update-ca-certificates are; use --project", platform)
+	}
+
+	if sudo {
+		for i, c := range commands {
+			commands[i] = trustCommand{"sudo", append([]string{c.name}, c.args...)}
+		}
+	}
+	return commands, nil
+}
+
+// debianAnchorPath is where gsn cert trust puts a certificate for update-ca-certificates, named by its
+// fingerprint so untrust finds it again
+func debianAnchorPath(c *x509.Certificate) string {
+	return filepath.Join(debianAnchorDir, "gsn-"+certFingerprint(c)[:16]+".crt")
+}
+
+// trustPlatform detects the trust store of the running system
+func trustPlatform() string {
+	switch {
+	case runtime.GOOS == "darwin":
+		return platformMac
+	case runtime.GOOS == "linux":
+		if _, err := Runner.LookPath("update-ca-certificates"); err == nil {
+			return platformDebian
+		}
+	}
+	return runtime.GOOS
+}
+
+// needsSudo reports whether the system commands run through sudo, which fails when it is missing
+func needsSudo() (bool, error) {
+	if os.Geteuid() == 0 {
+		return false, nil
+	}
+	if _, err := Runner.LookPath("sudo"); err != nil {
+		return false, errors.New("changing the system trust store needs root and sudo was not found, run gsn as root")
+	}
+	return true, nil
+}
+
+// changeSystemTrust installs certs into the system trust store or removes them, after confirmation
+func changeSystemTrust(cmd *cobra.Command, certs []*x509.Certificate, add bool, assumeYes bool) {
+	sudo, err := needsSudo()
+	if err != nil {
+		clierr.Fatal(err)
+	}
+	workspace, err := tmpfs.New("trust")
+	if err != nil {
+		clierr.Fatal(err)
+	}
+	defer workspace.Cleanup()
+
+	items := make([]trustItem, len(certs))
+	for i, c := range certs {
+		items[i] = trustItem{path: workspace.Path(fmt.Sprintf("ca-%d.pem", i+1)), cert: c}
+		if err := os.WriteFile(items[i].path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw}), 0o644); err != nil {
+			clierr.Fatal(err)
+		}
+	}
+	commands, err := systemTrustCommands(trustPlatform(), items, add, sudo)
+	if err != nil {
+		clierr.Exitf(clierr.Usage, "%v", err)
+	}
+
+	if !assumeYes && !dryrun.Enabled() {
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			clierr.Exitf(clierr.Usage, "refusing to change the system trust store without confirmation, rerun with --yes")
+		}
+		for _, c := range certs {
+			fmt.Printf("  %s  %s\n", certFingerprint(c)[:16], c.Subject)
+		}
+		for _, c := range commands {
+			fmt.Printf("  $ %s\n", c)
+		}
+		question := "Trust these certificates for every program on this machine?"
+		if !add {
+			question = "Remove these certificates from the system trust store?"
+		}
+		if !tui.Confirm(question) {
+			fmt.Println("Nothing changed.")
+			return
+		}
+	}
+
+	for _, c := range commands {
+		err := dryrun.Do("run "+c.String(), func() error {
+			opts := execx.Options{Stdin: os.Stdin, Stdout: os.Stderr, Stderr: os.Stderr}
+			_, _, _, err := Runner.Run(cmd.Context(), c.name, c.args, opts)
+			return err
+		})
+		if err != nil {
+			clierr.Fatalf("%s failed: %v", c, err)
+		}
+	}
+	if dryrun.Enabled() {
+		return
+	}
+	if add {
+		fmt.Printf(style.Success()+"Trusted %d certificate(s) in the system store\n", len(certs))
+	} else {
+		fmt.Printf(style.Success()+"Removed %d certificate(s) from the system store\n", len(certs))
+	}
+}
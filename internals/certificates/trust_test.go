@@ -0,0 +1,230 @@
+package certificates
+
+import (
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"gsn-dev-tools/internals/execx"
+)
+
+// useRunner makes r run the tools of the system trust store for the test
+func useRunner(t *testing.T, r execx.Runner) {
+	t.Helper()
+	saved := Runner
+	Runner = r
+	t.Cleanup(func() { Runner = saved })
+}
+
+// runTrust runs gsn cert trust, or untrust, with args
+func runTrust(t *testing.T, add bool, args ...string) {
+	t.Helper()
+	cmd := untrustCertsCmd()
+	if add {
+		cmd = trustCertsCmd()
+	}
+	cmd.SetArgs(args)
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProjectBundlePath(t *testing.T) {
+	tests := map[string]string{
+		"docker/certs":          filepath.Join("docker/certs", projectBundleName),
+		"test/roots.pem":        "test/roots.pem",
+		"test/ROOTS.CRT":        "test/ROOTS.CRT",
+		"certs.d":               filepath.Join("certs.d", projectBundleName),
+		"fixtures/ca.pem.d/sub": filepath.Join("fixtures/ca.pem.d/sub", projectBundleName),
+	}
+	for project, want := range tests {
+		if got := projectBundlePath(project); got != want {
+			t.Errorf("projectBundlePath(%q) = %q, want %q", project, got, want)
+		}
+	}
+}
+
+// TestAddToBundle appends each missing certificate once under a comment naming it, after what the bundle held
+func TestAddToBundle(t *testing.T) {
+	root, other := newTestCA(t, "Dev Root", nil), newTestCA(t, "Other Root", nil)
+
+	data, added := addToBundle(nil, []*x509.Certificate{root.Cert, root.Cert, other.Cert})
+	if len(added) != 2 {
+		t.Fatalf("added %d certificate(s) to an empty bundle, want 2", len(added))
+	}
+	want := "# CN=Dev Root\n" + string(certPEM(root)) + "# CN=Other Root\n" + string(certPEM(other))
+	if string(data) != want {
+		t.Errorf("new bundle =\n%s\nwant\n%s", data, want)
+	}
+	if again, added := addToBundle(data, []*x509.Certificate{other.Cert, root.Cert}); len(added) != 0 || string(again) != want {
+		t.Errorf("adding them again added %d", len(added))
+	}
+
+	// A bundle of someone else's, without a final line end, is kept byte for byte ahead of the new certificate
+	existing := "# roots of the CI\n" + strings.TrimSuffix(string(certPEM(other)), "\n")
+	data, added = addToBundle([]byte(existing), []*x509.Certificate{other.Cert, root.Cert})
+	if len(added) != 1 || added[0] != root.Cert || string(data) != existing+"\n# CN=Dev Root\n"+string(certPEM(root)) {
+		t.Errorf("bundle with a certificate in it =\n%s", data)
+	}
+}
+
+// TestRemoveFromBundle drops the blocks of the certificates with the comment above each, and nothing else
+func TestRemoveFromBundle(t *testing.T) {
+	root, other, third := newTestCA(t, "Dev Root", nil), newTestCA(t, "Other Root", nil), newTestCA(t, "Third Root", nil)
+	bundle := "# Bundle of the test harness\n\n" +
+		"# CN=Dev Root\n" + string(certPEM(root)) +
+		"\n" + string(certPEM(other)) +
+		"# CN=Third Root\n" + string(certPEM(third)) +
+		"# Dev Root again\n" + string(certPEM(root))
+
+	data, removed := removeFromBundle([]byte(bundle), []*x509.Certificate{root.Cert})
+	want := "# Bundle of the test harness\n\n" + "\n" + string(certPEM(other)) + "# CN=Third Root\n" + string(certPEM(third))
+	if len(removed) != 1 || string(data) != want {
+		t.Errorf("removed %d, bundle =\n%s\nwant\n%s", len(removed), data, want)
+	}
+
+	data, removed = removeFromBundle(data, []*x509.Certificate{other.Cert, third.Cert})
+	if len(removed) != 2 || string(data) != "# Bundle of the test harness\n\n\n" {
+		t.Errorf("removed %d, bundle = %q", len(removed), data)
+	}
+
+	if data, removed := removeFromBundle([]byte(bundle), []*x509.Certificate{newTestCA(t, "Absent", nil).Cert}); removed != nil || string(data) != bundle {
+		t.Errorf("removing an absent certificate = %d removed", len(removed))
+	}
+}
+
+// TestProjectTrust runs trust and untrust on a project directory: the bundle is created, not written twice,
+// and removed once nothing is left in it
+func TestProjectTrust(t *testing.T) {
+	dir := t.TempDir()
+	root, other := newTestCA(t, "Dev Root", nil), newTestCA(t, "Other Root", nil)
+	caPath := writeTestFile(t, dir, "ca.pem", certPEM(root, other))
+	project := filepath.Join(dir, "docker", "certs")
+	bundle := filepath.Join(project, projectBundleName)
+
+	runTrust(t, true, caPath, "--project", project)
+	first, err := os.ReadFile(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := subjects(parseCertificates(first)); got != "Dev Root > Other Root" {
+		t.Errorf("bundle holds %s", got)
+	}
+	runTrust(t, true, caPath, "--project", project)
+	if again, _ := os.ReadFile(bundle); string(again) != string(first) {
+		t.Error("trusting the same file twice changed the bundle")
+	}
+
+	runTrust(t, false, writeTestFile(t, dir, "other.pem", certPEM(other)), "--project", project)
+	if got, _ := os.ReadFile(bundle); string(got) != "# CN=Dev Root\n"+string(certPEM(root)) {
+		t.Errorf("after untrusting Other Root, bundle =\n%s", got)
+	}
+	runTrust(t, false, caPath, "--project", project)
+	if _, err := os.Stat(bundle); !os.IsNotExist(err) {
+		t.Errorf("emptied bundle still there: %v", err)
+	}
+
+	// A .pem named by --project is the bundle itself
+	named := filepath.Join(dir, "fixtures", "roots.pem")
+	runTrust(t, true, caPath, "--project", named)
+	if data, _ := os.ReadFile(named); len(parseCertificates(data)) != 2 {
+		t.Errorf("%s holds %d certificate(s)", named, len(parseCertificates(data)))
+	}
+}
+
+func TestSystemTrustCommands(t *testing.T) {
+	ca := newTestCA(t, "Dev Root", nil)
+	items := []trustItem{{path: "/tmp/trust/ca-1.pem", cert: ca.Cert}}
+	sum := sha1.Sum(ca.Cert.Raw)
+	sha1Hex := strings.ToUpper(hex.EncodeToString(sum[:]))
+	anchor := "/usr/local/share/ca-certificates/gsn-" + certFingerprint(ca.Cert)[:16] + ".crt"
+
+	tests := []struct {
+		platform  string
+		add, sudo bool
+		want      []string
+	}{
+		{platformMac, true, false, []string{
+			"security add-trusted-cert -d -r trustRoot -k /Library/Keychains/System.keychain /tmp/trust/ca-1.pem",
+		}},
+		{platformMac, false, true, []string{
+			"sudo security remove-trusted-cert -d /tmp/trust/ca-1.pem",
+			"sudo security delete-certificate -Z " + sha1Hex + " /Library/Keychains/System.keychain",
+		}},
+		{platformDebian, true, true, []string{
+			"sudo install -m 0644 /tmp/trust/ca-1.pem " + anchor,
+			"sudo update-ca-certificates",
+		}},
+		{platformDebian, false, false, []string{
+			"rm -f " + anchor,
+			"update-ca-certificates --fresh",
+		}},
+	}
+	for _, test := range tests {
+		commands, err := systemTrustCommands(test.platform, items, test.add, test.sudo)
+		if err != nil {
+			t.Errorf("%s add=%v: %v", test.platform, test.add, err)
+			continue
+		}
+		var got []string
+		for _, c := range commands {
+			got = append(got, c.String())
+		}
+		if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("%s add=%v sudo=%v =\n%s\nwant\n%s", test.platform, test.add, test.sudo, strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+		}
+	}
+
+	// One update-ca-certificates refreshes the store for every certificate
+	two := append(items, trustItem{path: "/tmp/trust/ca-2.pem", cert: newTestCA(t, "Other Root", nil).Cert})
+	if commands, _ := systemTrustCommands(platformDebian, two, true, false); len(commands) != 3 || commands[2].name != "update-ca-certificates" {
+		t.Errorf("two certificates on Debian = %v", commands)
+	}
+
+	for _, platform := range []string{"windows", "linux", "freebsd"} {
+		if _, err := systemTrustCommands(platform, items, true, false); err == nil || !strings.Contains(err.Error(), "the system trust store of "+platform+" is not supported") {
+			t.Errorf("%s = %v", platform, err)
+		}
+	}
+}
+
+// TestSystemTrustRuns runs gsn cert trust --system --yes on a Debian-like with the tools faked
+func TestSystemTrustRuns(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the Debian trust store is only picked on Linux")
+	}
+	t.Setenv("TMPDIR", t.TempDir())
+	dir := t.TempDir()
+	ca := newTestCA(t, "Dev Root", nil)
+	caPath := writeTestFile(t, dir, "ca.pem", certPEM(ca))
+	anchor := "/usr/local/share/ca-certificates/gsn-" + certFingerprint(ca.Cert)[:16] + ".crt"
+
+	// Run as root the commands go without sudo
+	viaSudo, direct := 0, 1
+	if os.Geteuid() != 0 {
+		viaSudo, direct = 2, 0
+	}
+	fake := &execx.Fake{Paths: []string{"update-ca-certificates", "sudo"}}
+	fake.Expect("sudo", execx.Rest()).Times(viaSudo)
+	fake.Expect("install", execx.Rest()).Times(direct)
+	fake.Expect("update-ca-certificates").Times(direct)
+	useRunner(t, fake)
+
+	runTrust(t, true, caPath, "--system", "--yes")
+	if err := fake.Verify(); err != nil {
+		t.Error(err)
+	}
+	calls := fake.Calls()
+	if len(calls) != 2 {
+		t.Fatalf("ran %d command(s), want 2", len(calls))
+	}
+	install := strings.TrimPrefix(strings.Join(append([]string{calls[0].Name}, calls[0].Args...), " "), "sudo ")
+	if !strings.HasPrefix(install, "install -m 0644 "+os.Getenv("TMPDIR")) || !strings.HasSuffix(install, " "+anchor) {
+		t.Errorf("first command = %s", install)
+	}
+}
@@ -0,0 +1,136 @@
+// Package clierr defines the exit codes of gsn. Commands wrap their errors with the code scripts should see,
+// and every failure exits through Fatal or main, which read the code from the error chain.
+package clierr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"gsn-dev-tools/internals/style"
+)
+
+// Code is the exit status of gsn
+type Code int
+
+const (
+	Success Code = 0
+	// Failure is any error without a more specific code
+	Failure Code = 1
+	// Usage is an invalid command line: unknown flags, missing arguments or flag values that do not validate
+	Usage Code = 2
+	// NotFound is a file, pull request, archive entry or other named thing that does not exist
+	NotFound Code = 3
+	// Conflict is a precondition that failed: a name collision, failing checks, a file changed meanwhile
+	Conflict Code = 4
+	// Timeout is a deadline that expired, like timeout(1) reports it
+	Timeout Code = 124
+	// Interrupted is a run stopped by Ctrl-C, 128 plus SIGINT like shells report it
+	Interrupted Code = 130
+)
+
+// Coder is implemented by errors that know their exit code, like the API errors of pkg/gh
+type Coder interface {
+	ExitCode() Code
+}
+
+// Error attaches an exit code to an error
+type Error struct {
+	Code Code
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) ExitCode() Code {
+	return e.Code
+}
+
+// New attaches code to err, nil stays nil
+func New(code Code, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Code: code, Err: err}
+}
+
+// Newf formats an error like fmt.Errorf and attaches code to it
+func Newf(code Code, format string, args ...any) error {
+	return &Error{Code: code, Err: fmt.Errorf(format, args...)}
+}
+
+// CodeOf maps an error chain to its exit code: the outermost Coder wins, then expired deadlines, cancellations,
+// and missing or existing files. Anything else is a Failure.
+func CodeOf(err error) Code {
+	var coder Coder
+	switch {
+	case err == nil:
+		return Success
+	case errors.As(err, &coder):
+		return coder.ExitCode()
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return Timeout
+	case errors.Is(err, context.Canceled):
+		return Interrupted
+	case errors.Is(err, fs.ErrNotExist):
+		return NotFound
+	case errors.Is(err, fs.ErrExist):
+		return Conflict
+	}
+	return Failure
+}
+
+// exitHooks run before the process exits
+var exitHooks []func()
+
+// AtExit adds f to what runs before gsn exits through Exit. Like deferred calls the hooks run last added first,
+// so a hook still writing output runs before the one flushing it.
+func AtExit(f func()) {
+	exitHooks = append(exitHooks, f)
+}
+
+// Exit runs the exit hooks and exits with code. Every exit of gsn goes through it, os.Exit would skip them.
+func Exit(code Code) {
+	for i := len(exitHooks) - 1; i >= 0; i-- {
+		exitHooks[i]()
+	}
+	os.Exit(int(code))
+}
+
+// Fatal prints err and exits with its code
+func Fatal(err error) {
+	fmt.Fprintln(os.Stderr, style.Error()+err.Error())
+	Exit(CodeOf(err))
+}
+
+// Fatalf prints a formatted error and exits. The code is that of the first error among args with a specific
+// one, so messages formatting an error with %v keep its code.
+func Fatalf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, style.Error()+format+"\n", args...)
+	Exit(codeOfArgs(args))
+}
+
+// Exitf prints a formatted error and exits with code, for failures detected by the command itself
+func Exitf(code Code, format string, args ...any) {
+	fmt.Fprintf(os.Stderr, style.Error()+format+"\n", args...)
+	Exit(code)
+}
+
+func codeOfArgs(args []any) Code {
+	for _, arg := range args {
+		if err, ok := arg.(error); ok {
+			if code := CodeOf(err); code != Failure {
+				return code
+			}
+		}
+	}
+	return Failure
+}
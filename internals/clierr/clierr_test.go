@@ -0,0 +1,72 @@
+package clierr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"testing"
+)
+
+// apiError is a Coder like the API errors of pkg/gh
+type apiError struct{ code Code }
+
+func (e apiError) Error() string  { return "api error" }
+func (e apiError) ExitCode() Code { return e.code }
+
+func TestCodeOf(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want Code
+	}{
+		{"nil", nil, Success},
+		{"plain", errors.New("boom"), Failure},
+		{"coded", Newf(Usage, "bad flag"), Usage},
+		{"wrapped", fmt.Errorf("copying: %w", New(Conflict, errors.New("collision"))), Conflict},
+		{"outermost coder wins", New(Usage, fmt.Errorf("x: %w", New(NotFound, fs.ErrNotExist))), Usage},
+		{"coder of another package", fmt.Errorf("merge: %w", apiError{NotFound}), NotFound},
+		{"joined", errors.Join(errors.New("first"), Newf(Timeout, "second")), Timeout},
+		{"deadline", fmt.Errorf("wait: %w", context.DeadlineExceeded), Timeout},
+		{"i/o deadline", os.ErrDeadlineExceeded, Timeout},
+		{"canceled", fmt.Errorf("copy: %w", context.Canceled), Interrupted},
+		{"missing file", &fs.PathError{Op: "open", Path: "a", Err: fs.ErrNotExist}, NotFound},
+		{"existing file", fmt.Errorf("create: %w", fs.ErrExist), Conflict},
+	}
+	for _, test := range tests {
+		if got := CodeOf(test.err); got != test.want {
+			t.Errorf("CodeOf(%s) = %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestNewKeepsNil(t *testing.T) {
+	if err := New(Conflict, nil); err != nil {
+		t.Errorf("New(Conflict, nil) = %v", err)
+	}
+	err := Newf(NotFound, "'%s' has no backup %d", "a", 3)
+	if err.Error() != "'a' has no backup 3" {
+		t.Errorf("Newf = %q", err)
+	}
+}
+
+// TestCodeOfArgs checks the code Fatalf exits with when it formats errors
+func TestCodeOfArgs(t *testing.T) {
+	tests := []struct {
+		args []any
+		want Code
+	}{
+		{nil, Failure},
+		{[]any{"name", 3}, Failure},
+		{[]any{"a.txt", errors.New("boom")}, Failure},
+		{[]any{"a.txt", fs.ErrNotExist}, NotFound},
+		// The first error with a specific code
+		{[]any{errors.New("boom"), Newf(Conflict, "x"), Newf(Usage, "y")}, Conflict},
+	}
+	for _, test := range tests {
+		if got := codeOfArgs(test.args); got != test.want {
+			t.Errorf("codeOfArgs(%v) = %d, want %d", test.args, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package clierr
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// codes lists the exit codes in the order gsn exit-codes prints them
+var codes = []struct {
+	Code    Code
+	Meaning string
+}{
+	{Success, "Success"},
+	{Failure, "Failure without a more specific code, and differences found by verify, diff and snap diff"},
+	{Usage, "Usage error: unknown command or flag, wrong arguments, a flag value that does not validate"},
+	{NotFound, "Not found: a file, archive entry, backup, git ref, pull request or other GitHub resource"},
+	{Conflict, "Conflict: a name collision, a pull request closed or moved, a file changed meanwhile"},
+	{Timeout, "Timeout: a deadline such as --timeout expired"},
+	{Interrupted, "Interrupted with Ctrl-C"},
+}
+
+// codeTable renders codes indented for help text
+func codeTable() string {
+	var b strings.Builder
+	for _, c := range codes {
+		fmt.Fprintf(&b, "  %-4d %s\n", c.Code, c.Meaning)
+	}
+	return b.String()
+}
+
+func ExitCodesCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "exit-codes",
+		Short: "Lists the exit codes of gsn",
+		Long: `Every gsn command exits with one of these codes, so scripts can tell why a command failed without parsing
+its output:
+
+` + codeTable() + `
+A batch of pull requests exits with the code its failures share, or 1 when they failed for different reasons.
+A run stopped with SIGTERM exits with 143.`,
+		Example: "  gsn exit-codes",
+		Args:    cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Print(codeTable())
+		},
+	}
+}
@@ -0,0 +1,35 @@
+package clipboard
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	"gsn-dev-tools/internals/execx"
+)
+
+// tools are the clipboard programs tried in order, the first one found on the PATH is used
+var tools = [][]string{
+	{"pbcopy"},
+	{"wl-copy"},
+	{"xclip", "-selection", "clipboard"},
+	{"xsel", "--clipboard", "--input"},
+	{"clip.exe"},
+}
+
+// Copy puts text on the system clipboard
+func Copy(ctx context.Context, text string) error {
+	for _, tool := range tools {
+		if tool[0] == "wl-copy" && os.Getenv("WAYLAND_DISPLAY") == "" {
+			continue
+		}
+		if _, err := execx.Default.LookPath(tool[0]); err != nil {
+			continue
+		}
+		_, _, _, err := execx.Default.Run(ctx, tool[0], tool[1:], execx.Options{Stdin: strings.NewReader(text), Stdout: os.Stderr, Stderr: os.Stderr})
+		return err
+	}
+	return fmt.Errorf("no clipboard tool found on %s (install pbcopy, wl-copy, xclip or xsel)", runtime.GOOS)
+}
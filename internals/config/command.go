@@ -0,0 +1,116 @@
+package config
+
+import (
+	"fmt"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/paths"
+	"gsn-dev-tools/internals/style"
+
+	"github.com/spf13/cobra"
+)
+
+func ConfigCmd() *cobra.Command {
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "Manage the gsn config file",
+		Long: `gsn reads config.yaml from the gsn config dir, or the file named by $GSN_CONFIG. Values that should not sit in
+plaintext, like review templates with tokens or hooks calling webhooks, go to config.secrets.age next to it.
+
+The redact section lists strings and regular expressions replaced with [REDACTED] in everything gsn prints, in
+its completion history and in --status-file, for names that must not reach a shared terminal:
+
+  redact:
+    strings: [Acme Corp, acme-internal]
+    patterns: ['(?i)customer-\d+']
+
+JSON output stays valid JSON, only its strings are redacted. An editor gsn opens gets the terminal unfiltered.`,
+		Example: `  gsn config secret set gh.review_templates.deploy "LGTM, deploying with token abc123"`,
+	}
+
+	configCmd.AddCommand(secretCmd())
+	configCmd.AddCommand(pathsCmd())
+	return configCmd
+}
+
+func pathsCmd() *cobra.Command {
+	pathsCmd := &cobra.Command{
+		Use:   "paths",
+		Short: "Print the directories gsn keeps its files in",
+		Long: `Prints the config, state, data and cache dirs and the config file. They follow the XDG base directories, like
+$XDG_CONFIG_HOME and ~/.config when unset, the Library folders for config and cache on macOS, and %AppData%
+and %LocalAppData% on Windows. With $GSN_HOME every dir is below it, for a portable install, e.g. on a USB stick.
+
+Files older versions of gsn kept elsewhere, like the workspace journals in the config dir, are moved to their
+dir by the first command run; a portable install moves nothing.`,
+		Example: `  gsn config paths
+  GSN_HOME=/media/usb/gsn gsn config paths`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			for _, kind := range paths.Kinds {
+				dir, err := paths.Dir(kind)
+				if err != nil {
+					clierr.Fatalf("%v", err)
+				}
+				fmt.Printf("%-7s %s\n", kind, dir)
+			}
+			file, err := Path()
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			fmt.Printf("%-7s %s\n", "file", file)
+		},
+	}
+	dryrun.ReadOnly(pathsCmd)
+	return pathsCmd
+}
+
+func secretCmd() *cobra.Command {
+	secretCmd := &cobra.Command{
+		Use:   "secret",
+		Short: "Set or unset the keys of the encrypted config.secrets.age",
+		Long: `config.secrets.age is encrypted with age to the identity file given by $GSN_AGE_IDENTITY, secrets.identity of
+the config file, or age-identity.txt next to the config file. At load time it is decrypted and its keys are
+merged over the plaintext config, mapping by mapping, so a secret key wins over the same key in config.yaml.
+When it cannot be decrypted gsn warns and goes on without its keys.`,
+		Example: `  gsn config secret set hooks.cmp.post_success "curl -fsS https://hooks.example.com/T0KEN"
+  gsn config secret unset hooks.cmp.post_success`,
+	}
+
+	secretCmd.AddCommand(&cobra.Command{
+		Use:   "set <key> <value>",
+		Short: "Store a value under a dotted key and re-encrypt the file",
+		Long: `Stores the value under a dotted config key, e.g. gh.review_templates.thanks. A new identity is generated when
+none exists yet. Values that would make the merged config invalid are refused.`,
+		Example: `  gsn config secret set gh.review_templates.thanks "Thanks @{author}, token xyz"`,
+		Args:    cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			created, err := SetSecret(args[0], args[1])
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			if created != "" {
+				fmt.Printf(style.Warning()+"Generated the age identity %s, back it up to keep your secrets readable\n", created)
+			}
+			path, _ := SecretsPath()
+			fmt.Printf(style.Success()+"Stored %s in %s\n", args[0], path)
+		},
+	})
+
+	secretCmd.AddCommand(&cobra.Command{
+		Use:     "unset <key>",
+		Short:   "Remove a dotted key and re-encrypt the file",
+		Long:    "Removes a dotted key from config.secrets.age, dropping the mappings it leaves empty.",
+		Example: "  gsn config secret unset gh.review_templates.thanks",
+		Args:    cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := UnsetSecret(args[0]); err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			path, _ := SecretsPath()
+			fmt.Printf(style.Trash()+"Removed %s from %s\n", args[0], path)
+		},
+	})
+	return secretCmd
+}
@@ -0,0 +1,321 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gsn-dev-tools/internals/paths"
+	"gsn-dev-tools/internals/state"
+	"gsn-dev-tools/internals/style"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fileName is the config file inside the gsn config dir
+const fileName = "config.yaml"
+
+// configKind versions the config file, gsn state migrate adds schema_version to it keeping its comments
+var configKind = state.Register(&state.Kind{
+	Name:     "config",
+	Format:   state.YAML,
+	Version:  1,
+	Patterns: []string{"*.yaml", "*.yml"},
+	Files: func() ([]string, error) {
+		path, err := Path()
+		if err != nil {
+			return nil, err
+		}
+		return []string{path}, nil
+	},
+})
+
+// Config is the user configuration read from ~/.config/gsn/config.yaml (or $GSN_CONFIG)
+type Config struct {
+	SchemaVersion int `yaml:"schema_version,omitempty"`
+
+	// Hooks maps a command path without the root, e.g. "cmp" or "pr merge", to its hooks
+	Hooks map[string]CommandHooks `yaml:"hooks"`
+
+	// Workspaces groups directories that are processed together with --workspace
+	Workspaces map[string]Workspace `yaml:"workspaces"`
+
+	// GH holds the defaults of the GitHub commands
+	GH GHSettings `yaml:"gh"`
+
+	// Cmp holds the settings of gsn cmp
+	Cmp CmpSettings `yaml:"cmp"`
+
+	// Backups maps a profile name usable with gsn backup run to what is archived and where it is kept
+	Backups map[string]BackupProfile `yaml:"backups"`
+
+	// Secrets locates the identity decrypting config.secrets.age, whose keys are merged over this file
+	Secrets SecretsSettings `yaml:"secrets"`
+
+	// Redact lists what is replaced with [REDACTED] in the output and the history of every command
+	Redact RedactSettings `yaml:"redact"`
+
+	// secretsErr is why config.secrets.age was not merged
+	secretsErr error
+}
+
+// GHSettings are the settings of the GitHub commands
+type GHSettings struct {
+	// ReviewTemplates maps a name usable with --template to a review message with placeholders
+	ReviewTemplates map[string]string `yaml:"review_templates"`
+}
+
+// CmpSettings are the settings of gsn cmp
+type CmpSettings struct {
+	// Presets adds archiving presets for --preset, or replaces the built-in preset of the same name
+	Presets map[string]ArchivePreset `yaml:"presets"`
+	// StoredExtensions replaces the extensions of the already compressed files --smart stores uncompressed
+	StoredExtensions []string `yaml:"stored_extensions"`
+}
+
+// RedactSettings are the strings and regular expressions gsn redacts, they can be kept in config.secrets.age
+type RedactSettings struct {
+	Strings  []string `yaml:"strings"`
+	Patterns []string `yaml:"patterns"`
+}
+
+// ArchivePreset is a named set of patterns applied by gsn cmp --preset
+type ArchivePreset struct {
+	// Extends names a preset whose patterns are kept, the lists below are added to them
+	Extends   string   `yaml:"extends"`
+	Sentinels []string `yaml:"sentinels"`
+	Exclude   []string `yaml:"exclude"`
+	Include   []string `yaml:"include"`
+}
+
+// BackupProfile describes the archives gsn backup run writes for a profile
+type BackupProfile struct {
+	Source  string   `yaml:"source"`
+	Preset  string   `yaml:"preset"`
+	Exclude []string `yaml:"exclude"`
+	// Format is an archive format as --format of gsn cmp takes it, tar.gz when empty
+	Format string `yaml:"format"`
+	// Destination is the directory the archives are written to and rotated in
+	Destination string `yaml:"destination"`
+	// Remote is an sftp://[user@]host[:port]/path directory the archives are uploaded to and rotated in as well
+	Remote string `yaml:"remote"`
+	// Keep is how many archives of the profile are kept, 0 keeps all
+	Keep int `yaml:"keep"`
+	// Notify is a --notify target told about every run
+	Notify string `yaml:"notify"`
+	// Sign signs the checksum file, with gpg's default key unless SignKey or SSHKey name a key as --sign-key
+	// and --ssh-key of gsn cmp do
+	Sign    bool   `yaml:"sign"`
+	SignKey string `yaml:"sign_key"`
+	SSHKey  string `yaml:"ssh_key"`
+	// ParallelHash writes a gsn-tree-sha256 tree hash as checksum, as --parallel-hash of gsn backup run does
+	ParallelHash bool `yaml:"parallel_hash"`
+	// Recipients and RecipientsFile encrypt the archives with age, as --recipient and --recipients-file of gsn
+	// cmp do
+	Recipients     []string `yaml:"recipients"`
+	RecipientsFile string   `yaml:"recipients_file"`
+}
+
+// Workspace is a named set of root directories plus the options used for them.
+// In YAML it is either a list of roots or a mapping with roots and per-command options.
+type Workspace struct {
+	Roots  []string       `yaml:"roots"`
+	Rename RenameSettings `yaml:"rename"`
+}
+
+// RenameSettings are the rename defaults of a workspace, flags given on the command line win
+type RenameSettings struct {
+	Extension string `yaml:"extension"`
+	Template  string `yaml:"template"`
+	Sort      string `yaml:"sort"`
+}
+
+// UnmarshalYAML accepts the short list-of-roots form of a workspace
+func (w *Workspace) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.SequenceNode {
+		return node.Decode(&w.Roots)
+	}
+
+	type plain Workspace
+	return node.Decode((*plain)(w))
+}
+
+// CommandHooks lists the external commands run around a single gsn command
+type CommandHooks struct {
+	Pre         HookList `yaml:"pre"`
+	PostSuccess HookList `yaml:"post_success"`
+	PostFailure HookList `yaml:"post_failure"`
+}
+
+// HookList is a list of hooks; a single hook may be written without the list
+type HookList []Hook
+
+// UnmarshalYAML accepts a single hook in place of a list
+func (l *HookList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.SequenceNode {
+		var hook Hook
+		if err := node.Decode(&hook); err != nil {
+			return err
+		}
+		*l = HookList{hook}
+		return nil
+	}
+
+	var hooks []Hook
+	if err := node.Decode(&hooks); err != nil {
+		return err
+	}
+	*l = hooks
+	return nil
+}
+
+// Hook is a shell command with an optional timeout. In YAML it is either a plain string or a
+// mapping with run and timeout keys.
+type Hook struct {
+	Run     string        `yaml:"run"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// UnmarshalYAML accepts the short string form of a hook
+func (h *Hook) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		h.Run = node.Value
+		return nil
+	}
+
+	type plain Hook
+	return node.Decode((*plain)(h))
+}
+
+var (
+	loadOnce sync.Once
+	loaded   *Config
+	loadErr  error
+)
+
+// Path returns the config file location, honoring $GSN_CONFIG
+func Path() (string, error) {
+	if path := os.Getenv("GSN_CONFIG"); path != "" {
+		return path, nil
+	}
+
+	dir, err := paths.ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, fileName), nil
+}
+
+// ExpandHome replaces a leading ~ with the current user's home directory
+func ExpandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~"))
+}
+
+// Load reads the config file once per process. A missing file yields an empty config.
+func Load() (*Config, error) {
+	loadOnce.Do(func() {
+		loaded, loadErr = load()
+	})
+	return loaded, loadErr
+}
+
+func load() (*Config, error) {
+	plain, doc, err := readPlain()
+	if err != nil {
+		return nil, err
+	}
+	if doc.Newer() {
+		fmt.Fprintf(os.Stderr, style.Warning()+"%s has schema version %d, this gsn knows up to %d and ignores settings it does not know\n",
+			doc.Path, doc.Version, configKind.Version)
+	}
+
+	var cfg Config
+	if err := yaml.Unmarshal(plain, &cfg); err != nil {
+		path, _ := Path()
+		return nil, fmt.Errorf("invalid config '%s': %w", path, err)
+	}
+
+	// An unreadable secrets file only costs its keys, commands missing one of them say why
+	secrets, err := loadSecrets(cfg.Secrets)
+	if err != nil {
+		cfg.secretsErr = err
+		fmt.Fprintf(os.Stderr, style.Warning()+"Ignoring %s: %v\n", secretsFileName, err)
+		return &cfg, nil
+	}
+	if secrets == nil {
+		return &cfg, nil
+	}
+
+	merged, err := mergeConfig(plain, secrets)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config with %s merged: %w", secretsFileName, err)
+	}
+	return merged, nil
+}
+
+// readPlain reads the plaintext config file migrated to the current schema, a missing file reads as empty
+func readPlain() ([]byte, *state.Document, error) {
+	path, err := Path()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, fmt.Errorf("failed to read config '%s': %w", path, err)
+	}
+	doc, err := configKind.Parse(path, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Only a migrated file needs its values encoded again
+	if doc.Version >= configKind.Version {
+		return data, doc, nil
+	}
+	data, err = doc.Marshal()
+	return data, doc, err
+}
+
+// loadSecrets decrypts config.secrets.age with the configured identity, nil when there is no secrets file
+func loadSecrets(settings SecretsSettings) (map[string]any, error) {
+	secretsPath, err := SecretsPath()
+	if err != nil {
+		return nil, err
+	}
+	identity, err := identityPath(settings)
+	if err != nil {
+		return nil, err
+	}
+	return readSecrets(secretsPath, identity)
+}
+
+// mergeConfig decodes the plaintext config with the secret values merged over it
+func mergeConfig(plain []byte, secrets map[string]any) (*Config, error) {
+	values := map[string]any{}
+	if err := yaml.Unmarshal(plain, &values); err != nil {
+		return nil, err
+	}
+	mergeValues(values, secrets)
+
+	data, err := yaml.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
@@ -0,0 +1,21 @@
+package config
+
+import (
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/redact"
+)
+
+// StartRedaction redacts the output of the process from here on with the redact section of the config file. A
+// config that does not load redacts nothing, the commands reading it report why. An invalid pattern is a usage
+// error rather than a pattern left out.
+func StartRedaction() error {
+	cfg, err := Load()
+	if err != nil {
+		return nil
+	}
+	if err := redact.Configure(cfg.Redact.Strings, cfg.Redact.Patterns); err != nil {
+		path, _ := Path()
+		return clierr.Newf(clierr.Usage, "%s: %v", path, err)
+	}
+	return redact.Start()
+}
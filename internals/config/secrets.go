@@ -0,0 +1,278 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gsn-dev-tools/internals/output"
+
+	"filippo.io/age"
+	"gopkg.in/yaml.v3"
+)
+
+// secretsFileName is the age encrypted file next to the config file whose keys are merged over it
+const secretsFileName = "config.secrets.age"
+
+// identityFileName is the default age identity next to the config file, as written by age-keygen
+const identityFileName = "age-identity.txt"
+
+// SecretsSettings locates the key of config.secrets.age
+type SecretsSettings struct {
+	// Identity is the age identity file, $GSN_AGE_IDENTITY wins over it
+	Identity string `yaml:"identity"`
+}
+
+// SecretsPath returns the location of the encrypted secrets file, next to the config file
+func SecretsPath() (string, error) {
+	path, err := Path()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(path), secretsFileName), nil
+}
+
+// identityPath returns the identity file from $GSN_AGE_IDENTITY, secrets.identity or the default location
+func identityPath(settings SecretsSettings) (string, error) {
+	if path := os.Getenv("GSN_AGE_IDENTITY"); path != "" {
+		return ExpandHome(path), nil
+	}
+	if settings.Identity != "" {
+		return ExpandHome(settings.Identity), nil
+	}
+	path, err := Path()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(path), identityFileName), nil
+}
+
+// MissingValue adds to err, which reports a value absent from the config, why config.secrets.age was not
+// merged, since the value may be one of its keys
+func (c *Config) MissingValue(err error) error {
+	if c.secretsErr == nil {
+		return err
+	}
+	return fmt.Errorf("%w (%s could not be read: %v)", err, secretsFileName, c.secretsErr)
+}
+
+// readSecrets decrypts the secrets file into its YAML values, a missing file yields no values
+func readSecrets(path string, identity string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	identities, err := loadIdentities(identity)
+	if err != nil {
+		return nil, err
+	}
+	r, err := age.Decrypt(bytes.NewReader(data), identities...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt with '%s': %w", identity, err)
+	}
+	plain, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt with '%s': %w", identity, err)
+	}
+
+	values := map[string]any{}
+	if err := yaml.Unmarshal(plain, &values); err != nil {
+		return nil, fmt.Errorf("invalid YAML in '%s': %w", path, err)
+	}
+	return values, nil
+}
+
+func loadIdentities(path string) ([]age.Identity, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read age identity: %w", err)
+	}
+	defer f.Close()
+
+	identities, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid age identity '%s': %w", path, err)
+	}
+	return identities, nil
+}
+
+// mergeValues copies src over dst, merging mappings key by key so a secret only replaces what it names
+func mergeValues(dst map[string]any, src map[string]any) {
+	for key, value := range src {
+		srcMap, srcIsMap := value.(map[string]any)
+		dstMap, dstIsMap := dst[key].(map[string]any)
+		if srcIsMap && dstIsMap {
+			mergeValues(dstMap, srcMap)
+			continue
+		}
+		dst[key] = value
+	}
+}
+
+// SetSecret stores value under a dotted key such as gh.review_templates.thanks in the secrets file and
+// encrypts it again. Without an identity file a new one is generated, its path is returned in that case.
+func SetSecret(key string, value string) (string, error) {
+	return updateSecrets(key, func(values map[string]any, path []string) error {
+		parent := values
+		for _, name := range path[:len(path)-1] {
+			child, ok := parent[name].(map[string]any)
+			if !ok {
+				if _, exists := parent[name]; exists {
+					return fmt.Errorf("'%s' holds a value, not a mapping", name)
+				}
+				child = map[string]any{}
+				parent[name] = child
+			}
+			parent = child
+		}
+		parent[path[len(path)-1]] = value
+		return nil
+	})
+}
+
+// UnsetSecret removes a dotted key from the secrets file, dropping mappings left empty
+func UnsetSecret(key string) error {
+	_, err := updateSecrets(key, func(values map[string]any, path []string) error {
+		if !removeValue(values, path) {
+			return fmt.Errorf("'%s' is not set in %s", key, secretsFileName)
+		}
+		return nil
+	})
+	return err
+}
+
+func removeValue(values map[string]any, path []string) bool {
+	if len(path) == 1 {
+		_, ok := values[path[0]]
+		delete(values, path[0])
+		return ok
+	}
+	child, ok := values[path[0]].(map[string]any)
+	if !ok || !removeValue(child, path[1:]) {
+		return false
+	}
+	if len(child) == 0 {
+		delete(values, path[0])
+	}
+	return true
+}
+
+// updateSecrets decrypts the secrets file, applies change and encrypts the result for the identity again,
+// refusing changes that would make the merged config invalid
+func updateSecrets(key string, change func(values map[string]any, path []string) error) (string, error) {
+	path := strings.Split(key, ".")
+	if slices.Contains(path, "") {
+		return "", fmt.Errorf("invalid key '%s', expected dotted names such as gh.review_templates.thanks", key)
+	}
+
+	plain, doc, err := readPlain()
+	if err != nil {
+		return "", err
+	}
+	// The merged config could not be fully validated against a newer config
+	if err := doc.Writable(); err != nil {
+		return "", err
+	}
+	var settings Config
+	if err := yaml.Unmarshal(plain, &settings); err != nil {
+		return "", err
+	}
+	identity, err := identityPath(settings.Secrets)
+	if err != nil {
+		return "", err
+	}
+	secretsPath, err := SecretsPath()
+	if err != nil {
+		return "", err
+	}
+
+	created := ""
+	if _, err := os.Stat(identity); errors.Is(err, os.ErrNotExist) {
+		if _, err := os.Stat(secretsPath); err == nil {
+			return "", fmt.Errorf("age identity '%s' is missing, %s cannot be decrypted", identity, secretsPath)
+		}
+		if err := generateIdentity(identity); err != nil {
+			return "", err
+		}
+		created = identity
+	}
+
+	values, err := readSecrets(secretsPath, identity)
+	if err != nil {
+		return "", err
+	}
+	if values == nil {
+		values = map[string]any{}
+	}
+	if err := change(values, path); err != nil {
+		return "", err
+	}
+	if _, err := mergeConfig(plain, values); err != nil {
+		return "", fmt.Errorf("'%s' would make the config invalid: %w", key, err)
+	}
+
+	data, err := yaml.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	if err := writeSecrets(secretsPath, identity, data); err != nil {
+		return "", err
+	}
+	return created, nil
+}
+
+// writeSecrets encrypts data to the recipients of the identity file and replaces the secrets file
+func writeSecrets(path string, identity string, data []byte) error {
+	identities, err := loadIdentities(identity)
+	if err != nil {
+		return err
+	}
+	var recipients []age.Recipient
+	for _, id := range identities {
+		if x, ok := id.(*age.X25519Identity); ok {
+			recipients = append(recipients, x.Recipient())
+		}
+	}
+	if len(recipients) == 0 {
+		return fmt.Errorf("age identity '%s' has no X25519 key to encrypt to", identity)
+	}
+
+	var encrypted bytes.Buffer
+	w, err := age.Encrypt(&encrypted, recipients...)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return output.WriteFileAtomic(path, encrypted.Bytes(), 0o600)
+}
+
+// generateIdentity writes a new X25519 identity in the format of age-keygen
+func generateIdentity(path string) error {
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	content := fmt.Sprintf("# public key: %s\n%s\n", id.Recipient(), id)
+	return output.WriteFileAtomic(path, []byte(content), 0o600)
+}
@@ -0,0 +1,329 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// The fixed identities of the tests, the second one cannot decrypt what is encrypted to the first
+const (
+	testIdentity      = "AGE-SECRET-KEY-1VDSTWS4S99EXAD4AEVTSYQFLF4DH9W0CJLM6PSJN2X7WDN4U8E5Q3NFMD6"
+	testRecipient     = "age17y0cd2guvrhd3y9fk9atyxyf3e8ja5drw8ykju5ytqg5u00nvg9slgwmx5"
+	otherTestIdentity = "AGE-SECRET-KEY-1KSTSAAMDKJQE8QQNUC7SYG3A2CUSHCGN42DE4PT57T5LX4DML0RS2E602T"
+)
+
+// plainConfig is the plaintext config the merge tests start from
+const plainConfig = `gh:
+  review_templates:
+    thanks: "Thanks {author}!"
+    lgtm: "LGTM"
+redact:
+  strings: [plain-token]
+  patterns: ["ghp_[A-Za-z0-9]+"]
+workspaces:
+  work: [~/work]
+`
+
+// useConfigDir points $GSN_CONFIG at config.yaml in a new dir holding plain, and returns the dir
+func useConfigDir(t *testing.T, plain string) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("GSN_CONFIG", filepath.Join(dir, fileName))
+	t.Setenv("GSN_AGE_IDENTITY", "")
+	if plain != "" {
+		writeFile(t, filepath.Join(dir, fileName), plain)
+	}
+	return dir
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeIdentity writes identity as age-keygen would and returns its path
+func writeIdentity(t *testing.T, path string, identity string) string {
+	t.Helper()
+	writeFile(t, path, "# created: 2024-05-01T12:00:00Z\n"+identity+"\n")
+	return path
+}
+
+func TestLoadMergesSecrets(t *testing.T) {
+	dir := useConfigDir(t, plainConfig)
+	identity := writeIdentity(t, filepath.Join(dir, identityFileName), testIdentity)
+	secrets := `gh:
+  review_templates:
+    thanks: "Thanks from the vault"
+    private: "Internal only"
+redact:
+  strings: [vault-token]
+workspaces:
+  secret: [/srv/secret]
+`
+	if err := writeSecrets(filepath.Join(dir, secretsFileName), identity, []byte(secrets)); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Mappings merge key by key, a secret replaces only what it names
+	templates := cfg.GH.ReviewTemplates
+	if templates["thanks"] != "Thanks from the vault" || templates["lgtm"] != "LGTM" || templates["private"] != "Internal only" || len(templates) != 3 {
+		t.Errorf("review templates = %v", templates)
+	}
+	if len(cfg.Workspaces) != 2 || cfg.Workspaces["work"].Roots[0] != "~/work" || cfg.Workspaces["secret"].Roots[0] != "/srv/secret" {
+		t.Errorf("workspaces = %+v", cfg.Workspaces)
+	}
+	// Lists are values: the secret list replaces the plaintext one, what it does not name stays
+	if !slices.Equal(cfg.Redact.Strings, []string{"vault-token"}) || !slices.Equal(cfg.Redact.Patterns, []string{"ghp_[A-Za-z0-9]+"}) {
+		t.Errorf("redact = %+v", cfg.Redact)
+	}
+	if cfg.secretsErr != nil {
+		t.Errorf("secretsErr = %v", cfg.secretsErr)
+	}
+}
+
+func TestLoadWithoutSecrets(t *testing.T) {
+	useConfigDir(t, plainConfig)
+	var cfg *Config
+	stderr := captureStderr(t, func() {
+		var err error
+		if cfg, err = load(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if stderr != "" || cfg.secretsErr != nil || cfg.GH.ReviewTemplates["thanks"] != "Thanks {author}!" {
+		t.Errorf("without a secrets file: %q, %v, %v", stderr, cfg.secretsErr, cfg.GH.ReviewTemplates)
+	}
+}
+
+func TestLoadIgnoresUnreadableSecrets(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(dir string)
+		wantErr string
+	}{
+		{"no identity", func(dir string) {
+			os.Remove(filepath.Join(dir, identityFileName))
+		}, "cannot read age identity"},
+		{"another identity", func(dir string) {
+			writeIdentity(t, filepath.Join(dir, identityFileName), otherTestIdentity)
+		}, "cannot decrypt with"},
+		{"not an identity", func(dir string) {
+			writeFile(t, filepath.Join(dir, identityFileName), "hunter2\n")
+		}, "invalid age identity"},
+		{"corrupt secrets", func(dir string) {
+			writeFile(t, filepath.Join(dir, secretsFileName), "age-encryption.org/v1\ngarbage")
+		}, "cannot decrypt with"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := useConfigDir(t, plainConfig)
+			identity := writeIdentity(t, filepath.Join(dir, identityFileName), testIdentity)
+			if err := writeSecrets(filepath.Join(dir, secretsFileName), identity, []byte("gh:\n  review_templates:\n    private: x\n")); err != nil {
+				t.Fatal(err)
+			}
+			test.prepare(dir)
+
+			var cfg *Config
+			stderr := captureStderr(t, func() {
+				var err error
+				if cfg, err = load(); err != nil {
+					t.Fatal(err)
+				}
+			})
+			// The plaintext config is kept, the secret keys are dropped and a lookup missing one says why
+			if !strings.Contains(stderr, "Ignoring config.secrets.age: "+test.wantErr) {
+				t.Errorf("stderr = %q", stderr)
+			}
+			if _, ok := cfg.GH.ReviewTemplates["private"]; ok || cfg.GH.ReviewTemplates["lgtm"] != "LGTM" {
+				t.Errorf("review templates = %v", cfg.GH.ReviewTemplates)
+			}
+			err := cfg.MissingValue(os.ErrNotExist)
+			if !strings.Contains(err.Error(), "config.secrets.age could not be read: "+test.wantErr) || !strings.HasPrefix(err.Error(), os.ErrNotExist.Error()) {
+				t.Errorf("MissingValue = %v", err)
+			}
+		})
+	}
+}
+
+func TestIdentityPathPrecedence(t *testing.T) {
+	dir := useConfigDir(t, "")
+	fromEnv, fromConfig := filepath.Join(dir, "env.txt"), filepath.Join(dir, "config.txt")
+
+	if got, _ := identityPath(SecretsSettings{}); got != filepath.Join(dir, identityFileName) {
+		t.Errorf("default identity = %s", got)
+	}
+	if got, _ := identityPath(SecretsSettings{Identity: fromConfig}); got != fromConfig {
+		t.Errorf("secrets.identity = %s", got)
+	}
+	t.Setenv("GSN_AGE_IDENTITY", fromEnv)
+	if got, _ := identityPath(SecretsSettings{Identity: fromConfig}); got != fromEnv {
+		t.Errorf("$GSN_AGE_IDENTITY = %s, want it to win over secrets.identity", got)
+	}
+
+	// The identity named by the plaintext config decrypts the secrets
+	t.Setenv("GSN_AGE_IDENTITY", "")
+	dir = useConfigDir(t, "secrets:\n  identity: "+fromConfig+"\n")
+	writeIdentity(t, fromConfig, testIdentity)
+	writeIdentity(t, filepath.Join(dir, identityFileName), otherTestIdentity)
+	if err := writeSecrets(filepath.Join(dir, secretsFileName), fromConfig, []byte("gh:\n  review_templates:\n    private: x\n")); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err := load(); err != nil || cfg.GH.ReviewTemplates["private"] != "x" {
+		t.Errorf("load with secrets.identity = %+v, %v", cfg, err)
+	}
+}
+
+// decryptSecrets returns the decrypted secrets file of the config dir
+func decryptSecrets(t *testing.T, dir string, identity string) map[string]any {
+	t.Helper()
+	values, err := readSecrets(filepath.Join(dir, secretsFileName), identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return values
+}
+
+func TestSetAndUnsetSecret(t *testing.T) {
+	dir := useConfigDir(t, plainConfig)
+	secretsPath, identity := filepath.Join(dir, secretsFileName), filepath.Join(dir, identityFileName)
+
+	// The first secret generates an identity
+	created, err := SetSecret("gh.review_templates.thanks", "Thanks from the vault")
+	if err != nil || created != identity {
+		t.Fatalf("SetSecret = %q, %v", created, err)
+	}
+	if info, err := os.Stat(identity); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("identity: %v, %v", info, err)
+	}
+	first, err := os.ReadFile(secretsPath)
+	if err != nil || !bytes.HasPrefix(first, []byte("age-encryption.org/v1\n")) || bytes.Contains(first, []byte("vault")) {
+		t.Fatalf("secrets file = %q, %v", first, err)
+	}
+
+	// Later secrets reuse it and encrypt the whole file again
+	if created, err := SetSecret("gh.review_templates.private", "Internal only"); err != nil || created != "" {
+		t.Fatalf("second SetSecret = %q, %v", created, err)
+	}
+	if _, err := SetSecret("redact.strings", "vault-token"); err == nil {
+		t.Error("a string was accepted for a list")
+	}
+	second, _ := os.ReadFile(secretsPath)
+	if bytes.Equal(first, second) {
+		t.Error("the secrets file was not encrypted again")
+	}
+	values := decryptSecrets(t, dir, identity)
+	templates, _ := values["gh"].(map[string]any)["review_templates"].(map[string]any)
+	if len(values) != 1 || templates["thanks"] != "Thanks from the vault" || templates["private"] != "Internal only" {
+		t.Errorf("secrets = %v", values)
+	}
+	if cfg, err := load(); err != nil || cfg.GH.ReviewTemplates["thanks"] != "Thanks from the vault" || cfg.GH.ReviewTemplates["lgtm"] != "LGTM" {
+		t.Errorf("merged config = %+v, %v", cfg, err)
+	}
+
+	// Only the identity decrypts it
+	other := writeIdentity(t, filepath.Join(t.TempDir(), "other.txt"), otherTestIdentity)
+	if _, err := readSecrets(secretsPath, other); err == nil {
+		t.Error("another identity decrypted the secrets")
+	}
+
+	// Unsetting drops mappings left empty
+	if err := UnsetSecret("gh.review_templates.thanks"); err != nil {
+		t.Fatal(err)
+	}
+	if err := UnsetSecret("gh.review_templates.thanks"); err == nil || !strings.Contains(err.Error(), "'gh.review_templates.thanks' is not set in config.secrets.age") {
+		t.Errorf("second UnsetSecret = %v", err)
+	}
+	if err := UnsetSecret("gh.review_templates.private"); err != nil {
+		t.Fatal(err)
+	}
+	if values := decryptSecrets(t, dir, identity); len(values) != 0 {
+		t.Errorf("secrets after unsetting everything = %v", values)
+	}
+}
+
+func TestSetSecretRefuses(t *testing.T) {
+	dir := useConfigDir(t, plainConfig)
+	identity := writeIdentity(t, filepath.Join(dir, identityFileName), testIdentity)
+	if _, err := SetSecret("gh.review_templates.thanks", "kept"); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := os.ReadFile(filepath.Join(dir, secretsFileName))
+
+	tests := []struct{ key, value, wantErr string }{
+		{"gh..thanks", "x", "invalid key 'gh..thanks'"},
+		{"", "x", "invalid key ''"},
+		{"gh.review_templates.thanks.more", "x", "'thanks' holds a value, not a mapping"},
+		{"gh.review_templates", "x", "'gh.review_templates' would make the config invalid"},
+		{"backups.home.keep", "many", "'backups.home.keep' would make the config invalid"},
+	}
+	for _, test := range tests {
+		if _, err := SetSecret(test.key, test.value); err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("SetSecret(%q) = %v, want %q", test.key, err, test.wantErr)
+		}
+	}
+	if after, _ := os.ReadFile(filepath.Join(dir, secretsFileName)); !bytes.Equal(before, after) {
+		t.Error("a refused secret changed the file")
+	}
+	if values := decryptSecrets(t, dir, identity); len(values) != 1 {
+		t.Errorf("secrets = %v", values)
+	}
+
+	// Secrets encrypted to an identity that is gone are never replaced by a new identity
+	os.Remove(identity)
+	if _, err := SetSecret("gh.review_templates.thanks", "x"); err == nil || !strings.Contains(err.Error(), "is missing, ") {
+		t.Errorf("SetSecret without the identity = %v", err)
+	}
+	if _, err := os.Stat(identity); err == nil {
+		t.Error("a new identity was generated over existing secrets")
+	}
+}
+
+func TestWriteSecretsEncryptsToTheIdentity(t *testing.T) {
+	dir := t.TempDir()
+	identity := writeIdentity(t, filepath.Join(dir, "key.txt"), "# public key: "+testRecipient+"\n"+testIdentity)
+	path := filepath.Join(dir, "nested", secretsFileName)
+	if err := writeSecrets(path, identity, []byte("a: 1\n")); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("secrets file: %v, %v", info, err)
+	}
+	if values, err := readSecrets(path, identity); err != nil || values["a"] != 1 {
+		t.Errorf("readSecrets = %v, %v", values, err)
+	}
+	// A missing file is no secrets at all
+	if values, err := readSecrets(filepath.Join(dir, "missing.age"), identity); err != nil || values != nil {
+		t.Errorf("readSecrets of a missing file = %v, %v", values, err)
+	}
+}
+
+// captureStderr runs f with os.Stderr sent to a temp file and returns what f wrote
+func captureStderr(t *testing.T, f func()) string {
+	t.Helper()
+	file, err := os.CreateTemp(t.TempDir(), "stderr-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	saved := os.Stderr
+	os.Stderr = file
+	defer func() { os.Stderr = saved }()
+
+	f()
+
+	data, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,156 @@
+package cron
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/style"
+
+	"github.com/spf13/cobra"
+)
+
+func CronCmd() *cobra.Command {
+	cronCmd := &cobra.Command{
+		Use:   "cron",
+		Short: "Explains cron expressions and lists when they run",
+		Long: `Reads cron expressions: five fields (minute, hour, day of month, month, day of week), six with a leading
+seconds field, or @yearly, @annually, @monthly, @weekly, @daily, @midnight and @hourly. Fields take *, ?,
+values, ranges a-b, steps */n, a-b/n and a/n and comma separated lists; months and days of week take names like
+JAN or MON, and Sunday is 0 or 7. As in Vixie cron, when both the day of month and the day of week are
+restricted a day matching either of them runs.
+
+Run times are computed on the wall clock of --tz, the local time zone by default: a time skipped when clocks go
+forward runs right after the gap, and a time repeated when they go back runs once. Quote the expression, the
+shell expands * otherwise.`,
+		Example: `  gsn cron explain "0 3 * * 1-5"
+  gsn cron next "*/15 9-17 * * MON-FRI" --count 10
+  gsn cron validate "0 25 * * *"`,
+	}
+
+	cronCmd.AddCommand(explainCmd())
+	cronCmd.AddCommand(nextCmd())
+	cronCmd.AddCommand(validateCmd())
+	dryrun.ReadOnly(cronCmd.Commands()...)
+	return cronCmd
+}
+
+// expressionArg requires the expression as one argument, an unquoted one arrives split and glob expanded
+func expressionArg(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return clierr.Newf(clierr.Usage, "expected the expression as one quoted argument, e.g. gsn cron %s \"0 3 * * 1-5\"", cmd.Name())
+	}
+	return nil
+}
+
+// addScheduleFlags adds the flags of the commands listing run times
+func addScheduleFlags(cmd *cobra.Command, count int) {
+	cmd.Flags().IntP("count", "n", count, "Number of run times to list")
+	cmd.Flags().String("tz", "", "Time zone the schedule runs in, e.g. Europe/Madrid (default local)")
+}
+
+func explainCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "explain <expression>",
+		Short: "Describes a cron expression and lists its next run times",
+		Long: `Prints the expression in English and its next run times in the time zone of the schedule and in UTC. An
+invalid expression is reported with the offending part marked, and exits with 2.`,
+		Example: `  gsn cron explain "0 3 * * 1-5"
+  gsn cron explain @daily --tz America/New_York --count 3`,
+		Args: expressionArg,
+		Run: func(cmd *cobra.Command, args []string) {
+			s, loc, count := scheduleFromFlags(cmd, args[0])
+			fmt.Println(s.Describe())
+			if count == 0 {
+				return
+			}
+			times := s.Upcoming(time.Now().In(loc), count)
+			if len(times) == 0 {
+				fmt.Println(style.Warning() + "The schedule never runs")
+				return
+			}
+			fmt.Printf("\nNext %d run(s), %s and UTC:\n", len(times), loc)
+			for _, t := range times {
+				fmt.Printf("  %s   %s\n", t.Format("Mon 2006-01-02 15:04:05 MST"), t.UTC().Format("2006-01-02 15:04:05 MST"))
+			}
+		},
+	}
+
+	addScheduleFlags(cmd, 5)
+	return cmd
+}
+
+func nextCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "next <expression>",
+		Short: "Prints the next run times of a cron expression",
+		Long: `Prints the next run times, one RFC 3339 timestamp per line in the time zone of the schedule, for scripts.
+Exits with 1 when the schedule never runs.`,
+		Example: `  gsn cron next "0 3 * * 1-5" --count 5
+  gsn cron next @hourly -n 1 --tz UTC`,
+		Args: expressionArg,
+		Run: func(cmd *cobra.Command, args []string) {
+			s, loc, count := scheduleFromFlags(cmd, args[0])
+			times := s.Upcoming(time.Now().In(loc), count)
+			if len(times) == 0 && count > 0 {
+				clierr.Exitf(clierr.Failure, "The schedule never runs")
+			}
+			for _, t := range times {
+				fmt.Println(t.Format(time.RFC3339))
+			}
+		},
+	}
+
+	addScheduleFlags(cmd, 5)
+	return cmd
+}
+
+func validateCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "validate <expression>",
+		Short: "Checks a cron expression",
+		Long:  `Exits with 0 when the expression is valid, and with 2 printing what is wrong otherwise.`,
+		Example: `  gsn cron validate "0 3 * * 1-5"
+  gsn cron validate "$SCHEDULE" || exit 1`,
+		Args: expressionArg,
+		Run: func(cmd *cobra.Command, args []string) {
+			parse(args[0])
+			fmt.Println(style.Success() + "Valid")
+		},
+	}
+}
+
+// scheduleFromFlags parses the expression and the flags shared by explain and next, exiting on errors
+func scheduleFromFlags(cmd *cobra.Command, expr string) (*Schedule, *time.Location, int) {
+	count, _ := cmd.Flags().GetInt("count")
+	tz, _ := cmd.Flags().GetString("tz")
+
+	if count < 0 {
+		clierr.Exitf(clierr.Usage, "--count cannot be negative")
+	}
+	loc := time.Local
+	if tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			clierr.Exitf(clierr.Usage, "unknown time zone '%s'", tz)
+		}
+	}
+	return parse(expr), loc, count
+}
+
+// parse parses expr or exits showing where it is wrong
+func parse(expr string) *Schedule {
+	s, err := Parse(expr)
+	var parseErr *ParseError
+	if errors.As(err, &parseErr) {
+		fmt.Fprintf(os.Stderr, style.Error()+"Invalid cron expression, %s\n%s\n", parseErr.Msg, parseErr.Caret("  "))
+		clierr.Exit(clierr.Usage)
+	}
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	return s
+}
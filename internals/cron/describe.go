@@ -0,0 +1,242 @@
+package cron
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxListedTimes is how many times of day are listed one by one before the fields are described apart
+const maxListedTimes = 8
+
+// Describe renders the schedule in English, e.g. "At 03:00, on Monday through Friday"
+func (s *Schedule) Describe() string {
+	parts := []string{s.describeTime()}
+	if days := s.describeDays(); days != "" {
+		parts = append(parts, days)
+	}
+	if months := describeMonths(s.month); months != "" {
+		parts = append(parts, months)
+	}
+	text := strings.Join(parts, ", ")
+	return strings.ToUpper(text[:1]) + text[1:]
+}
+
+func (s *Schedule) describeTime() string {
+	seconds, secondsFixed := values(s.second)
+	minutes, minutesFixed := values(s.minute)
+	hours, hoursFixed := values(s.hour)
+	showSeconds := !secondsFixed || len(seconds) > 1 || seconds[0] != 0
+
+	if secondsFixed && minutesFixed && hoursFixed && len(seconds)*len(minutes)*len(hours) <= maxListedTimes {
+		var times []string
+		for _, h := range hours {
+			for _, m := range minutes {
+				for _, sec := range seconds {
+					if showSeconds {
+						times = append(times, fmt.Sprintf("%02d:%02d:%02d", h, m, sec))
+					} else {
+						times = append(times, fmt.Sprintf("%02d:%02d", h, m))
+					}
+				}
+			}
+		}
+		return "at " + joinList(times)
+	}
+
+	var parts []string
+	if showSeconds {
+		parts = append(parts, describeUnit(s.second, "second", "seconds"))
+	}
+	// Every minute is implied by every second
+	if !(s.minute.Star && len(s.minute.Items) == 1 && s.minute.Items[0].Step == 1 && showSeconds && !secondsFixed) {
+		parts = append(parts, describeUnit(s.minute, "minute", "minutes"))
+	}
+	text := strings.Join(parts, ", ")
+	if hours := describeHours(s.hour, minutesFixed); strings.HasPrefix(hours, "of ") {
+		text += " " + hours
+	} else if hours != "" {
+		text += ", " + hours
+	}
+	return text
+}
+
+// describeUnit renders the seconds or minutes: every minute, every 15 minutes, at minute 5, at minutes 0 and 30,
+// every minute from 10 through 20
+func describeUnit(f fieldSpec, singular string, plural string) string {
+	if vals, fixed := values(f); fixed {
+		if len(vals) == 1 {
+			return fmt.Sprintf("at %s %d", singular, vals[0])
+		}
+		return fmt.Sprintf("at %s %s", plural, joinList(numbers(vals)))
+	}
+	var phrases []string
+	for _, it := range f.Items {
+		switch {
+		case it.Star && it.Step == 1:
+			phrases = append(phrases, "every "+singular)
+		case it.Star:
+			phrases = append(phrases, fmt.Sprintf("every %d %s", it.Step, plural))
+		case it.From == it.To:
+			phrases = append(phrases, fmt.Sprintf("at %s %d", singular, it.From))
+		case it.Step == 1:
+			phrases = append(phrases, fmt.Sprintf("every %s from %d through %d", singular, it.From, it.To))
+		default:
+			phrases = append(phrases, fmt.Sprintf("every %d %s from %d through %d", it.Step, plural, it.From, it.To))
+		}
+	}
+	return joinList(phrases)
+}
+
+// describeHours renders the hours, nothing when every hour is selected and the minutes say it already
+func describeHours(f fieldSpec, minutesFixed bool) string {
+	if vals, fixed := values(f); fixed {
+		clock := make([]string, len(vals))
+		for i, h := range vals {
+			clock[i] = fmt.Sprintf("%02d:00", h)
+		}
+		if len(vals) == 1 {
+			return "in the hour from " + clock[0]
+		}
+		return "in the hours from " + joinList(clock)
+	}
+	var phrases []string
+	for _, it := range f.Items {
+		switch {
+		case it.Star && it.Step == 1:
+			if !minutesFixed {
+				return ""
+			}
+			phrases = append(phrases, "of every hour")
+		case it.Star:
+			phrases = append(phrases, fmt.Sprintf("every %d hours", it.Step))
+		case it.From == it.To:
+			phrases = append(phrases, fmt.Sprintf("in the hour from %02d:00", it.From))
+		case it.Step == 1:
+			phrases = append(phrases, fmt.Sprintf("between %02d:00 and %02d:59", it.From, it.To))
+		default:
+			phrases = append(phrases, fmt.Sprintf("every %d hours from %02d:00 through %02d:00", it.Step, it.From, it.To))
+		}
+	}
+	return joinList(phrases)
+}
+
+func (s *Schedule) describeDays() string {
+	var dom, dow string
+	if !(s.dom.Star && s.dom.Items[0].Step == 1) {
+		dom = describeDom(s.dom)
+	}
+	if !(s.dow.Star && s.dow.Items[0].Step == 1) {
+		dow = describeNamed(s.dow, func(v int) string { return time.Weekday(v % 7).String() })
+	}
+	switch {
+	case dom != "" && dow != "" && !s.dom.Star && !s.dow.Star:
+		return "on " + dom + " or on " + dow
+	case dom != "" && dow != "":
+		return "on " + dom + " and on " + dow
+	case dom != "":
+		return "on " + dom
+	case dow != "":
+		return "on " + dow
+	}
+	return ""
+}
+
+func describeDom(f fieldSpec) string {
+	if vals, fixed := values(f); fixed {
+		if len(vals) == 1 {
+			return fmt.Sprintf("day %d of the month", vals[0])
+		}
+		return fmt.Sprintf("days %s of the month", joinList(numbers(vals)))
+	}
+	var phrases []string
+	for _, it := range f.Items {
+		switch {
+		case it.Star:
+			phrases = append(phrases, fmt.Sprintf("every %s day of the month from day 1", ordinal(it.Step)))
+		case it.From == it.To:
+			phrases = append(phrases, fmt.Sprintf("day %d", it.From))
+		case it.Step == 1:
+			phrases = append(phrases, fmt.Sprintf("days %d through %d", it.From, it.To))
+		default:
+			phrases = append(phrases, fmt.Sprintf("every %s day from day %d through %d", ordinal(it.Step), it.From, it.To))
+		}
+	}
+	text := joinList(phrases)
+	if !strings.Contains(text, "of the month") {
+		text += " of the month"
+	}
+	return text
+}
+
+// describeNamed renders the months or days of week by name: January through March, or each of them for steps,
+// which read better listed
+func describeNamed(f fieldSpec, name func(int) string) string {
+	var phrases []string
+	for _, it := range f.Items {
+		switch {
+		case it.From == it.To:
+			phrases = append(phrases, name(it.From))
+		case it.Step == 1:
+			phrases = append(phrases, name(it.From)+" through "+name(it.To))
+		default:
+			for v := it.From; v <= it.To; v += it.Step {
+				phrases = append(phrases, name(v))
+			}
+		}
+	}
+	return joinList(phrases)
+}
+
+func describeMonths(f fieldSpec) string {
+	if f.Star && f.Items[0].Step == 1 {
+		return ""
+	}
+	return "in " + describeNamed(f, func(v int) string { return time.Month(v).String() })
+}
+
+// values lists the values of a field made of single values only
+func values(f fieldSpec) ([]int, bool) {
+	var vals []int
+	for _, it := range f.Items {
+		if it.Star || it.From != it.To {
+			return nil, false
+		}
+		vals = append(vals, it.From)
+	}
+	return vals, true
+}
+
+func numbers(vals []int) []string {
+	texts := make([]string, len(vals))
+	for i, v := range vals {
+		texts[i] = fmt.Sprint(v)
+	}
+	return texts
+}
+
+// ordinal renders 2 as 2nd
+func ordinal(n int) string {
+	suffix := "th"
+	switch {
+	case n%100 >= 11 && n%100 <= 13:
+	case n%10 == 1:
+		suffix = "st"
+	case n%10 == 2:
+		suffix = "nd"
+	case n%10 == 3:
+		suffix = "rd"
+	}
+	return fmt.Sprintf("%d%s", n, suffix)
+}
+
+// joinList joins a, b and c
+func joinList(items []string) string {
+	switch len(items) {
+	case 0:
+		return ""
+	case 1:
+		return items[0]
+	}
+	return strings.Join(items[:len(items)-1], ", ") + " and " + items[len(items)-1]
+}
@@ -0,0 +1,64 @@
+package cron
+
+import "testing"
+
+func TestDescribe(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"* * * * *", "Every minute"},
+		{"*/15 * * * *", "Every 15 minutes"},
+		{"*/10 * * * * *", "Every 10 seconds"},
+		{"0 3 * * 1-5", "At 03:00, on Monday through Friday"},
+		{"0 0,12 * * *", "At 00:00 and 12:00"},
+		{"10,20,30 1,2,3 * * *", "At minutes 10, 20 and 30, in the hours from 01:00, 02:00 and 03:00"},
+		{"15 10 1,15 * *", "At 10:15, on days 1 and 15 of the month"},
+		{"30 2 1 * *", "At 02:30, on day 1 of the month"},
+		{"@yearly", "At 00:00, on day 1 of the month, in January"},
+		{"@weekly", "At 00:00, on Sunday"},
+		{"@hourly", "At minute 0 of every hour"},
+		{"7 * * * 2-4", "At minute 7 of every hour, on Tuesday through Thursday"},
+		{"0 22 * * 7", "At 22:00, on Sunday"},
+		{"0 0 * * */2", "At 00:00, on Sunday, Tuesday, Thursday and Saturday"},
+		{"0 0 12 * SUN,SAT", "At 00:00, on day 12 of the month or on Sunday and Saturday"},
+		// Either day matches when neither is a *, both when one is
+		{"0 0 13 * 5", "At 00:00, on day 13 of the month or on Friday"},
+		{"0 0 1-7 * 1", "At 00:00, on days 1 through 7 of the month or on Monday"},
+		{"0 0 */2 * 1", "At 00:00, on every 2nd day of the month from day 1 and on Monday"},
+		{"0 0 */10 * *", "At 00:00, on every 10th day of the month from day 1"},
+		{"0 0 5-20/5 * *", "At 00:00, on every 5th day from day 5 through 20 of the month"},
+		{"0 0 * JUL *", "At 00:00, in July"},
+		{"0 0 1 */3 *", "At 00:00, on day 1 of the month, in January, April, July and October"},
+		{"0 0 1 JAN-MAR/2 *", "At 00:00, on day 1 of the month, in January and March"},
+		{"0 9-17/4 * * MON-FRI", "At minute 0, every 4 hours from 09:00 through 17:00, on Monday through Friday"},
+		{"5,35 */6 * * *", "At minutes 5 and 35, every 6 hours"},
+		{"0 8-18 * * *", "At minute 0, between 08:00 and 18:59"},
+		{"*/5 1-3 * * *", "Every 5 minutes, between 01:00 and 03:59"},
+		{"1-5 12 * * *", "Every minute from 1 through 5, in the hour from 12:00"},
+		{"30 0 12 * * *", "At 12:00:30"},
+		{"0,30 0 12 * * *", "At 12:00:00 and 12:00:30"},
+		// Past maxListedTimes the fields are described apart
+		{"0 0,10,20 1,2,3 * * *", "At minutes 0, 10 and 20, in the hours from 01:00, 02:00 and 03:00"},
+		{"0 1,2,3,4,5,6,7,8,9 * * *", "At minute 0, in the hours from 01:00, 02:00, 03:00, 04:00, 05:00, 06:00, 07:00, 08:00 and 09:00"},
+		{"0 0 1,2,3,4,5,6,7,8 * *", "At 00:00, on days 1, 2, 3, 4, 5, 6, 7 and 8 of the month"},
+	}
+	for _, test := range tests {
+		s, err := Parse(test.expr)
+		if err != nil {
+			t.Errorf("Parse(%q) = %v", test.expr, err)
+			continue
+		}
+		if got := s.Describe(); got != test.want {
+			t.Errorf("Describe(%q) = %q, want %q", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestOrdinal(t *testing.T) {
+	for n, want := range map[int]string{1: "1st", 2: "2nd", 3: "3rd", 4: "4th", 11: "11th", 12: "12th", 13: "13th", 21: "21st", 22: "22nd", 23: "23rd", 30: "30th", 101: "101st", 111: "111th"} {
+		if got := ordinal(n); got != want {
+			t.Errorf("ordinal(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package cron
+
+import "time"
+
+// searchDays bounds the search for the next run: a 29th of February may be eight years away across a century
+const searchDays = 9 * 366
+
+// Next returns the first time after after the schedule fires, in the location of after, or the zero time when it
+// never does. Times are matched on the wall clock: a time skipped when clocks go forward fires at the first
+// instant after the gap, and a time that occurs twice when clocks go back fires once, the first time.
+func (s *Schedule) Next(after time.Time) time.Time {
+	loc := after.Location()
+	y, mo, d := after.Date()
+	startH, startM, startS := after.Clock()
+	startS++
+
+	// Days are walked on a calendar without DST, the location only matters once a wall time is found
+	day := time.Date(y, mo, d, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < searchDays; i, day = i+1, day.AddDate(0, 0, 1) {
+		if !s.matchDay(day) {
+			continue
+		}
+		first := i == 0
+		for h := 0; h < 24; h++ {
+			if first && h < startH || !has(s.hour, h) {
+				continue
+			}
+			for m := 0; m < 60; m++ {
+				if first && h == startH && m < startM || !has(s.minute, m) {
+					continue
+				}
+				for sec := 0; sec < 60; sec++ {
+					if first && h == startH && m == startM && sec < startS || !has(s.second, sec) {
+						continue
+					}
+					if t := resolve(day, h, m, sec, loc); t.After(after) {
+						return t
+					}
+				}
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// Upcoming returns the next count times the schedule fires after after
+func (s *Schedule) Upcoming(after time.Time, count int) []time.Time {
+	var times []time.Time
+	for len(times) < count {
+		t := s.Next(after)
+		if t.IsZero() {
+			break
+		}
+		times = append(times, t)
+		after = t
+	}
+	return times
+}
+
+// matchDay applies the day rules of cron to a calendar day: the month must match, and the day of month and the
+// day of week must both match when one of them is a *, either of them otherwise
+func (s *Schedule) matchDay(day time.Time) bool {
+	if !has(s.month, int(day.Month())) {
+		return false
+	}
+	domMatch := has(s.dom, day.Day())
+	dowMatch := has(s.dow, int(day.Weekday()))
+	if s.dom.Star || s.dow.Star {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+func has(f fieldSpec, v int) bool {
+	return f.Bits&(1<<v) != 0
+}
+
+// resolve turns a wall time of day into an instant of loc. A wall time inside a gap, when clocks go forward,
+// becomes the end of the gap; of a wall time occurring twice the first instant is taken, which time.Date does not
+// guarantee.
+func resolve(day time.Time, h int, m int, sec int, loc *time.Location) time.Time {
+	y, mo, d := day.Date()
+	t := time.Date(y, mo, d, h, m, sec, 0, loc)
+	wanted := time.Date(y, mo, d, h, m, sec, 0, time.UTC)
+	got := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+	if got.Equal(wanted) {
+		// When clocks went back, the same wall time may have come earlier, before the zone t is in started
+		if start, _ := t.ZoneBounds(); !start.IsZero() {
+			_, offset := t.Zone()
+			_, before := start.Add(-time.Second).Zone()
+			if earlier := t.Add(time.Duration(offset-before) * time.Second); before > offset && earlier.Before(start) {
+				return earlier.In(loc)
+			}
+		}
+		return t
+	}
+	// time.Date moved the time out of the gap by the offset of one side, the transition is the other bound of
+	// the zone it landed in
+	start, end := t.ZoneBounds()
+	if got.Before(wanted) {
+		return end
+	}
+	return start
+}
@@ -0,0 +1,195 @@
+package cron
+
+import (
+	"slices"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+// mustParse parses expr or fails the test
+func mustParse(t *testing.T, expr string) *Schedule {
+	t.Helper()
+	s, err := Parse(expr)
+	if err != nil {
+		t.Fatalf("Parse(%q) = %v", expr, err)
+	}
+	return s
+}
+
+// instants formats times as UTC instants, which tell the two occurrences of a repeated wall time apart
+func instants(times []time.Time) []string {
+	texts := make([]string, len(times))
+	for i, t := range times {
+		texts[i] = t.UTC().Format(time.RFC3339)
+	}
+	return texts
+}
+
+func TestUpcoming(t *testing.T) {
+	tests := []struct {
+		expr  string
+		after string
+		want  []string
+	}{
+		{"* * * * *", "2025-01-01T00:00:00Z", []string{"2025-01-01T00:01:00Z", "2025-01-01T00:02:00Z"}},
+		// A time between two runs starts from the next one, a time on a run skips it
+		{"*/15 * * * *", "2025-01-01T00:07:30Z", []string{"2025-01-01T00:15:00Z", "2025-01-01T00:30:00Z"}},
+		{"*/15 * * * *", "2025-01-01T00:15:00Z", []string{"2025-01-01T00:30:00Z", "2025-01-01T00:45:00Z"}},
+		{"*/15 * * * *", "2025-01-01T00:14:59.999Z", []string{"2025-01-01T00:15:00Z", "2025-01-01T00:30:00Z"}},
+		{"*/20 * * * * *", "2025-01-01T00:00:50Z", []string{"2025-01-01T00:01:00Z", "2025-01-01T00:01:20Z", "2025-01-01T00:01:40Z"}},
+		{"@yearly", "2025-12-31T23:59:59Z", []string{"2026-01-01T00:00:00Z", "2027-01-01T00:00:00Z"}},
+		{"59 23 31 12 *", "2025-12-31T23:59:00Z", []string{"2026-12-31T23:59:00Z"}},
+		{"0 12 31 * *", "2025-01-31T13:00:00Z", []string{"2025-03-31T12:00:00Z", "2025-05-31T12:00:00Z", "2025-07-31T12:00:00Z"}},
+		{"0 0 */10 * *", "2025-02-11T00:00:00Z", []string{"2025-02-21T00:00:00Z", "2025-03-01T00:00:00Z", "2025-03-11T00:00:00Z"}},
+		{"0 0 1 JAN-MAR/2 *", "2025-01-01T00:00:00Z", []string{"2025-03-01T00:00:00Z", "2026-01-01T00:00:00Z"}},
+		{"0 9-17/4 * * MON-FRI", "2025-05-02T10:00:00Z", []string{"2025-05-02T13:00:00Z", "2025-05-02T17:00:00Z", "2025-05-05T09:00:00Z"}},
+		{"0 22 * * 7", "2025-05-01T00:00:00Z", []string{"2025-05-04T22:00:00Z", "2025-05-11T22:00:00Z"}},
+		// The 29th of February waits for leap years, eight years across 2100
+		{"0 0 29 2 *", "2025-01-01T00:00:00Z", []string{"2028-02-29T00:00:00Z", "2032-02-29T00:00:00Z"}},
+		{"0 0 29 2 *", "2096-03-01T00:00:00Z", []string{"2104-02-29T00:00:00Z"}},
+		// Day of month or day of week when neither is a *: Fridays and the 13th, a Tuesday
+		{"0 0 13 * 5", "2025-05-01T00:00:00Z", []string{"2025-05-02T00:00:00Z", "2025-05-09T00:00:00Z", "2025-05-13T00:00:00Z", "2025-05-16T00:00:00Z"}},
+		// The 30th of February never comes, but Mondays of February do
+		{"0 0 30 2 1", "2025-02-20T00:00:00Z", []string{"2025-02-24T00:00:00Z", "2026-02-02T00:00:00Z"}},
+		// Both when one starts with *, even with a step: Mondays on odd days
+		{"0 0 */2 * 1", "2025-05-01T00:00:00Z", []string{"2025-05-05T00:00:00Z", "2025-05-19T00:00:00Z", "2025-06-09T00:00:00Z"}},
+		{"0 0 1 * ?", "2025-05-01T00:00:00Z", []string{"2025-06-01T00:00:00Z", "2025-07-01T00:00:00Z"}},
+	}
+	for _, test := range tests {
+		after, err := time.Parse(time.RFC3339, test.after)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := instants(mustParse(t, test.expr).Upcoming(after, len(test.want))); !slices.Equal(got, test.want) {
+			t.Errorf("Upcoming(%q, %s) = %q\nwant %q", test.expr, test.after, got, test.want)
+		}
+	}
+}
+
+func TestUpcomingAcrossDST(t *testing.T) {
+	tests := []struct {
+		name  string
+		zone  string
+		expr  string
+		after string
+		want  []string
+	}{
+		// New York springs forward on 9 March 2025 at 02:00 EST, falls back on 2 November at 02:00 EDT
+		{"skipped time fires at the end of the gap", "America/New_York", "30 2 * * *", "2025-03-08T12:00:00-05:00",
+			[]string{"2025-03-09T07:00:00Z", "2025-03-10T06:30:00Z"}},
+		{"skipped time with seconds", "America/New_York", "30 30 2 * * *", "2025-03-08T12:00:00-05:00",
+			[]string{"2025-03-09T07:00:00Z", "2025-03-10T06:30:30Z"}},
+		{"repeated time fires once", "America/New_York", "30 1 * * *", "2025-11-01T12:00:00-04:00",
+			[]string{"2025-11-02T05:30:00Z", "2025-11-03T06:30:00Z"}},
+		{"half hours over the gap", "America/New_York", "*/30 * * * *", "2025-03-09T01:15:00-05:00",
+			[]string{"2025-03-09T06:30:00Z", "2025-03-09T07:00:00Z", "2025-03-09T07:30:00Z"}},
+		{"half hours over the repeated hour", "America/New_York", "*/30 * * * *", "2025-11-02T00:45:00-04:00",
+			[]string{"2025-11-02T05:00:00Z", "2025-11-02T05:30:00Z", "2025-11-02T07:00:00Z", "2025-11-02T07:30:00Z"}},
+		// Starting in the second pass of the repeated hour, its runs are behind
+		{"from inside the repeated hour", "America/New_York", "*/30 * * * *", "2025-11-02T01:10:00-05:00",
+			[]string{"2025-11-02T07:00:00Z", "2025-11-02T07:30:00Z"}},
+		// 02:00 resolves to 03:00, which fires once
+		{"hourly over the gap", "America/New_York", "0 * * * *", "2025-03-09T00:30:00-05:00",
+			[]string{"2025-03-09T06:00:00Z", "2025-03-09T07:00:00Z", "2025-03-09T08:00:00Z"}},
+		{"hourly over the repeated hour", "America/New_York", "0 * * * *", "2025-11-02T00:30:00-04:00",
+			[]string{"2025-11-02T05:00:00Z", "2025-11-02T07:00:00Z", "2025-11-02T08:00:00Z"}},
+		{"times clear of the transitions keep their wall time", "America/New_York", "0 12 * * *", "2025-03-08T13:00:00-05:00",
+			[]string{"2025-03-09T16:00:00Z", "2025-03-10T16:00:00Z"}},
+		// Berlin springs forward on 30 March 2025 at 02:00 CET, falls back on 26 October at 03:00 CEST
+		{"Berlin gap", "Europe/Berlin", "0 2 * * *", "2025-03-29T12:00:00+01:00",
+			[]string{"2025-03-30T01:00:00Z", "2025-03-31T00:00:00Z"}},
+		{"Berlin repeated time", "Europe/Berlin", "30 2 * * *", "2025-10-25T12:00:00+02:00",
+			[]string{"2025-10-26T00:30:00Z", "2025-10-27T01:30:00Z"}},
+		// Lord Howe Island moves its clocks by half an hour: 02:00 becomes 02:30 on 5 October 2025, and 02:00
+		// goes back to 01:30 on 6 April 2025
+		{"half hour gap", "Australia/Lord_Howe", "15 2 * * *", "2025-10-04T12:00:00+10:30",
+			[]string{"2025-10-04T15:30:00Z", "2025-10-05T15:15:00Z"}},
+		{"half hour repeated", "Australia/Lord_Howe", "45 1 * * *", "2025-04-05T12:00:00+11:00",
+			[]string{"2025-04-05T14:45:00Z", "2025-04-06T15:15:00Z"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			loc, err := time.LoadLocation(test.zone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			after, err := time.Parse(time.RFC3339, test.after)
+			if err != nil {
+				t.Fatal(err)
+			}
+			times := mustParse(t, test.expr).Upcoming(after.In(loc), len(test.want))
+			if got := instants(times); !slices.Equal(got, test.want) {
+				t.Errorf("Upcoming(%q, %s in %s) = %q\nwant %q", test.expr, test.after, test.zone, got, test.want)
+			}
+			for _, next := range times {
+				if next.Location() != loc {
+					t.Errorf("%s is not in %s", next, loc)
+				}
+			}
+		})
+	}
+}
+
+// TestNextMatchesAScan checks Next against the runs found by testing each minute on its own
+func TestNextMatchesAScan(t *testing.T) {
+	start := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 45)
+	exprs := []string{"*/7 * * * *", "0 */5 * * *", "0 0 29 * *", "30 12 * * 4", "0 0 13 * 5", "0 0 */3 * 1-5", "5,55 1-3 * 2,3 *", "0 0 31 * *", "@weekly"}
+	for _, expr := range exprs {
+		s := mustParse(t, expr)
+		var want []string
+		for m := start.Add(time.Minute); m.Before(end); m = m.Add(time.Minute) {
+			if s.matchDay(m) && has(s.hour, m.Hour()) && has(s.minute, m.Minute()) {
+				want = append(want, m.Format(time.RFC3339))
+			}
+		}
+		var got []string
+		for next := s.Next(start); next.Before(end); next = s.Next(next) {
+			got = append(got, next.Format(time.RFC3339))
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("%q fires %d times in February 2024, the scan finds %d\n%q\n%q", expr, len(got), len(want), got, want)
+		}
+	}
+}
+
+func TestNextNeverFiring(t *testing.T) {
+	// Parse refuses such schedules, Next still ends its search
+	var s Schedule
+	if next := s.Next(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)); !next.IsZero() {
+		t.Errorf("a schedule selecting nothing fires at %s", next)
+	}
+	if times := s.Upcoming(time.Now(), 3); len(times) != 0 {
+		t.Errorf("Upcoming = %v", times)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		zone string
+		day  string
+		h, m int
+		want string
+	}{
+		{"America/New_York", "2025-03-09", 2, 30, "2025-03-09T03:00:00-04:00"},
+		{"America/New_York", "2025-11-02", 1, 30, "2025-11-02T01:30:00-04:00"},
+		{"Europe/Berlin", "2025-03-30", 2, 30, "2025-03-30T03:00:00+02:00"},
+		{"Europe/Berlin", "2025-10-26", 2, 30, "2025-10-26T02:30:00+02:00"},
+		{"Europe/Berlin", "2025-10-26", 3, 0, "2025-10-26T03:00:00+01:00"},
+		{"Australia/Lord_Howe", "2025-10-05", 2, 0, "2025-10-05T02:30:00+11:00"},
+		{"Australia/Lord_Howe", "2025-04-06", 1, 30, "2025-04-06T01:30:00+11:00"},
+		{"Asia/Kolkata", "2025-06-01", 12, 0, "2025-06-01T12:00:00+05:30"},
+		{"UTC", "2025-06-01", 0, 0, "2025-06-01T00:00:00Z"},
+	}
+	for _, test := range tests {
+		loc, err := time.LoadLocation(test.zone)
+		if err != nil {
+			t.Fatal(err)
+		}
+		day, _ := time.Parse(time.DateOnly, test.day)
+		if got := resolve(day, test.h, test.m, 0, loc).Format(time.RFC3339); got != test.want {
+			t.Errorf("resolve(%s %02d:%02d in %s) = %s, want %s", test.day, test.h, test.m, test.zone, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+// Package cron parses cron expressions, computes when they fire and describes them in English
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// field describes one position of a cron expression
+type field struct {
+	Name     string
+	Min, Max int
+	// Names are accepted for the values from Min, like JAN or MON
+	Names []string
+}
+
+var (
+	secondField = field{Name: "second", Min: 0, Max: 59}
+	minuteField = field{Name: "minute", Min: 0, Max: 59}
+	hourField   = field{Name: "hour", Min: 0, Max: 23}
+	domField    = field{Name: "day of month", Min: 1, Max: 31}
+	monthField  = field{Name: "month", Min: 1, Max: 12, Names: []string{"JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}}
+	// Day of week 7 is Sunday too, it is folded onto 0 once parsed
+	dowField = field{Name: "day of week", Min: 0, Max: 7, Names: []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}}
+)
+
+// macros maps the @ shortcuts to their expressions
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseError locates what is wrong in an expression, Pos and Len are byte offsets in Expr
+type ParseError struct {
+	Expr string
+	Pos  int
+	Len  int
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("column %d: %s", e.Pos+1, e.Msg)
+}
+
+// Caret returns the expression with a line marking the offending part below it, each prefixed with indent
+func (e *ParseError) Caret(indent string) string {
+	return indent + e.Expr + "\n" + indent + strings.Repeat(" ", e.Pos) + strings.Repeat("^", max(e.Len, 1))
+}
+
+// item is one comma separated part of a field: a value, a range or * with an optional step
+type item struct {
+	Star     bool
+	From, To int
+	Step     int
+}
+
+// fieldSpec is a parsed field: its items and the values they select as a bit set
+type fieldSpec struct {
+	Items []item
+	Bits  uint64
+	// Star is set when the field starts with * or ?, as in Vixie cron, which matters for the days (see
+	// Schedule.matchDay)
+	Star bool
+}
+
+// Schedule is a parsed cron expression
+type Schedule struct {
+	Expr string
+	// Seconds is set when the expression has six fields, the first one being the seconds
+	Seconds bool
+
+	second, minute, hour, dom, month, dow fieldSpec
+}
+
+// Parse reads a cron expression: five fields (minute hour day-of-month month day-of-week), six with a leading
+// seconds field, or one of @yearly, @annually, @monthly, @weekly, @daily, @midnight and @hourly. Fields take
+// *, ?, values, ranges a-b, steps */n, a-b/n and a/n, and comma separated lists of them; months and days of
+// week also take their three letter English names.
+func Parse(expr string) (*Schedule, error) {
+	trimmed := strings.TrimSpace(expr)
+	if strings.HasPrefix(trimmed, "@") {
+		expanded, ok := macros[strings.ToLower(trimmed)]
+		if !ok {
+			pos := strings.Index(expr, trimmed)
+			return nil, &ParseError{Expr: expr, Pos: pos, Len: len(trimmed), Msg: fmt.Sprintf("unknown macro '%s', expected @yearly, @annually, @monthly, @weekly, @daily, @midnight or @hourly", trimmed)}
+		}
+		s, err := Parse(expanded)
+		if err != nil {
+			return nil, err
+		}
+		s.Expr = expr
+		return s, nil
+	}
+
+	type token struct {
+		Text string
+		Pos  int
+	}
+	var tokens []token
+	for i := 0; i < len(expr); {
+		if expr[i] == ' ' || expr[i] == '\t' {
+			i++
+			continue
+		}
+		start := i
+		for i < len(expr) && expr[i] != ' ' && expr[i] != '\t' {
+			i++
+		}
+		tokens = append(tokens, token{Text: expr[start:i], Pos: start})
+	}
+
+	s := &Schedule{Expr: expr}
+	fields := []*fieldSpec{&s.minute, &s.hour, &s.dom, &s.month, &s.dow}
+	defs := []field{minuteField, hourField, domField, monthField, dowField}
+	switch len(tokens) {
+	case 5:
+		s.second = fieldSpec{Items: []item{{From: 0, To: 0, Step: 1}}, Bits: 1}
+	case 6:
+		s.Seconds = true
+		fields = append([]*fieldSpec{&s.second}, fields...)
+		defs = append([]field{secondField}, defs...)
+	default:
+		pos, length := 0, len(expr)
+		if len(tokens) > 6 {
+			pos = tokens[6].Pos
+			length = len(expr) - pos
+		}
+		return nil, &ParseError{Expr: expr, Pos: pos, Len: length, Msg: fmt.Sprintf("expected 5 fields, or 6 with seconds, found %d", len(tokens))}
+	}
+
+	for i, tok := range tokens {
+		spec, err := parseField(expr, tok.Text, tok.Pos, defs[i])
+		if err != nil {
+			return nil, err
+		}
+		*fields[i] = spec
+	}
+	// Sunday is 0 and 7
+	if s.dow.Bits&(1<<7) != 0 {
+		s.dow.Bits = s.dow.Bits&^(1<<7) | 1
+	}
+
+	if s.dow.Star {
+		if err := s.checkReachable(tokens[len(tokens)-3].Pos, len(tokens[len(tokens)-3].Text)); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// checkReachable refuses a day of month no selected month has, like 30 2, when the day of week is a * and cannot
+// match instead
+func (s *Schedule) checkReachable(pos int, length int) error {
+	longest := 0
+	for m := 1; m <= 12; m++ {
+		if s.month.Bits&(1<<m) != 0 {
+			longest = max(longest, daysIn(m))
+		}
+	}
+	for d := 1; d <= longest; d++ {
+		if s.dom.Bits&(1<<d) != 0 {
+			return nil
+		}
+	}
+	return &ParseError{Expr: s.Expr, Pos: pos, Len: length, Msg: "no selected month has this day, the schedule never fires"}
+}
+
+// daysIn returns the most days month m can have
+func daysIn(m int) int {
+	switch m {
+	case 2:
+		return 29
+	case 4, 6, 9, 11:
+		return 30
+	}
+	return 31
+}
+
+// parseField reads the text of one field found at pos in expr
+func parseField(expr string, text string, pos int, f field) (fieldSpec, error) {
+	spec := fieldSpec{Star: strings.HasPrefix(text, "*") || strings.HasPrefix(text, "?")}
+	everything := false
+	offset := 0
+	for _, part := range strings.Split(text, ",") {
+		partPos := pos + offset
+		offset += len(part) + 1
+		if part == "" {
+			return spec, &ParseError{Expr: expr, Pos: partPos, Len: 1, Msg: fmt.Sprintf("empty item in the %s field", f.Name)}
+		}
+
+		it, err := parseItem(expr, part, partPos, f)
+		if err != nil {
+			return spec, err
+		}
+		if it.Star && it.Step == 1 {
+			everything = true
+		}
+		spec.Items = append(spec.Items, it)
+		for v := it.From; v <= it.To; v += it.Step {
+			spec.Bits |= 1 << v
+		}
+	}
+	// A list holding * selects everything, the other items add nothing to describe
+	if everything {
+		spec.Items = []item{{Star: true, From: f.Min, To: f.Max, Step: 1}}
+	}
+	return spec, nil
+}
+
+func parseItem(expr string, part string, pos int, f field) (item, error) {
+	rangeText, stepText, hasStep := strings.Cut(part, "/")
+	it := item{Step: 1}
+	if hasStep {
+		stepPos := pos + len(rangeText) + 1
+		step, err := strconv.Atoi(stepText)
+		if err != nil || step < 1 {
+			return it, &ParseError{Expr: expr, Pos: stepPos, Len: max(len(stepText), 1), Msg: fmt.Sprintf("step '%s' of the %s field is not a positive number", stepText, f.Name)}
+		}
+		if step > f.Max-f.Min {
+			return it, &ParseError{Expr: expr, Pos: stepPos, Len: len(stepText), Msg: fmt.Sprintf("step %d of the %s field is larger than its range %d-%d", step, f.Name, f.Min, f.Max)}
+		}
+		it.Step = step
+	}
+
+	switch {
+	case rangeText == "*" || rangeText == "?":
+		it.Star = true
+		it.From, it.To = f.Min, f.Max
+		if f.Max == 7 {
+			// * in the day of week is 0-6, 7 would repeat Sunday
+			it.To = 6
+		}
+		return it, nil
+	case rangeText == "":
+		return it, &ParseError{Expr: expr, Pos: pos, Len: 1, Msg: fmt.Sprintf("missing value before the step in the %s field", f.Name)}
+	}
+
+	fromText, toText, isRange := strings.Cut(rangeText, "-")
+	from, err := parseValue(expr, fromText, pos, f)
+	if err != nil {
+		return it, err
+	}
+	it.From, it.To = from, from
+	switch {
+	case isRange:
+		toPos := pos + len(fromText) + 1
+		to, err := parseValue(expr, toText, toPos, f)
+		if err != nil {
+			return it, err
+		}
+		if to < from {
+			return it, &ParseError{Expr: expr, Pos: pos, Len: len(rangeText), Msg: fmt.Sprintf("range %s of the %s field ends before it starts", rangeText, f.Name)}
+		}
+		it.To = to
+	case hasStep:
+		// a/n runs from a to the end of the field
+		it.To = f.Max
+		if f.Max == 7 {
+			it.To = 6
+		}
+	}
+	return it, nil
+}
+
+func parseValue(expr string, text string, pos int, f field) (int, error) {
+	if text == "" {
+		return 0, &ParseError{Expr: expr, Pos: pos, Len: 1, Msg: fmt.Sprintf("missing value in the %s field", f.Name)}
+	}
+	for i, name := range f.Names {
+		if strings.EqualFold(text, name) {
+			return f.Min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(text)
+	if err != nil {
+		msg := fmt.Sprintf("'%s' is not a number in the %s field", text, f.Name)
+		if len(f.Names) > 0 {
+			msg = fmt.Sprintf("'%s' is neither a number nor a name (%s-%s) in the %s field", text, f.Names[0], f.Names[len(f.Names)-1], f.Name)
+		}
+		return 0, &ParseError{Expr: expr, Pos: pos, Len: len(text), Msg: msg}
+	}
+	if v < f.Min || v > f.Max {
+		return 0, &ParseError{Expr: expr, Pos: pos, Len: len(text), Msg: fmt.Sprintf("%s %d is out of range %d-%d", f.Name, v, f.Min, f.Max)}
+	}
+	return v, nil
+}
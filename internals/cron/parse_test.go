@@ -0,0 +1,200 @@
+package cron
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// selected lists the values a field selects
+func selected(f fieldSpec) []int {
+	var vals []int
+	for v := 0; v < 64; v++ {
+		if has(f, v) {
+			vals = append(vals, v)
+		}
+	}
+	return vals
+}
+
+// span returns the values from lo to hi by step
+func span(lo int, hi int, step int) []int {
+	var vals []int
+	for v := lo; v <= hi; v += step {
+		vals = append(vals, v)
+	}
+	return vals
+}
+
+func TestParse(t *testing.T) {
+	every := map[string][]int{"second": {0}, "minute": span(0, 59, 1), "hour": span(0, 23, 1), "dom": span(1, 31, 1), "month": span(1, 12, 1), "dow": span(0, 6, 1)}
+	tests := []struct {
+		expr    string
+		seconds bool
+		// want overrides the fields not selecting everything
+		want map[string][]int
+	}{
+		{"* * * * *", false, nil},
+		{"? * ? * ?", false, nil},
+		{"\t0  3 * *\t1-5 ", false, map[string][]int{"minute": {0}, "hour": {3}, "dow": span(1, 5, 1)}},
+		{"*/15 */6 * * *", false, map[string][]int{"minute": {0, 15, 30, 45}, "hour": {0, 6, 12, 18}}},
+		{"5/20 3-23/10 * * *", false, map[string][]int{"minute": {5, 25, 45}, "hour": {3, 13, 23}}},
+		{"1,2,3,2 * * * *", false, map[string][]int{"minute": {1, 2, 3}}},
+		{"0-10/5,58-59 * * * *", false, map[string][]int{"minute": {0, 5, 10, 58, 59}}},
+		{"5,* * * * *", false, nil},
+		{"0 0 */10 * *", false, map[string][]int{"minute": {0}, "hour": {0}, "dom": {1, 11, 21, 31}}},
+		{"0 0 1 jan-Mar/2,DEC *", false, map[string][]int{"minute": {0}, "hour": {0}, "dom": {1}, "month": {1, 3, 12}}},
+		{"0 0 * * MON-fri", false, map[string][]int{"minute": {0}, "hour": {0}, "dow": span(1, 5, 1)}},
+		// 7 is Sunday too, folded onto 0
+		{"0 0 * * 7", false, map[string][]int{"minute": {0}, "hour": {0}, "dow": {0}}},
+		{"0 0 * * 5-7", false, map[string][]int{"minute": {0}, "hour": {0}, "dow": {0, 5, 6}}},
+		{"0 0 * * 0,7", false, map[string][]int{"minute": {0}, "hour": {0}, "dow": {0}}},
+		{"0 0 * * */2", false, map[string][]int{"minute": {0}, "hour": {0}, "dow": {0, 2, 4, 6}}},
+		{"0 0 * * 1/3", false, map[string][]int{"minute": {0}, "hour": {0}, "dow": {1, 4}}},
+		// A day of month only some months have is fine while one of them is selected, or the day of week may match
+		{"0 0 31 1-2 *", false, map[string][]int{"minute": {0}, "hour": {0}, "dom": {31}, "month": {1, 2}}},
+		{"0 0 29 2 *", false, map[string][]int{"minute": {0}, "hour": {0}, "dom": {29}, "month": {2}}},
+		{"0 0 30 2 1", false, map[string][]int{"minute": {0}, "hour": {0}, "dom": {30}, "month": {2}, "dow": {1}}},
+		{"*/20 * * * * *", true, map[string][]int{"second": {0, 20, 40}}},
+		{"59 59 23 31 12 6", true, map[string][]int{"second": {59}, "minute": {59}, "hour": {23}, "dom": {31}, "month": {12}, "dow": {6}}},
+		{"@yearly", false, map[string][]int{"minute": {0}, "hour": {0}, "dom": {1}, "month": {1}}},
+		{"@annually", false, map[string][]int{"minute": {0}, "hour": {0}, "dom": {1}, "month": {1}}},
+		{"@monthly", false, map[string][]int{"minute": {0}, "hour": {0}, "dom": {1}}},
+		{"@weekly", false, map[string][]int{"minute": {0}, "hour": {0}, "dow": {0}}},
+		{" @Daily ", false, map[string][]int{"minute": {0}, "hour": {0}}},
+		{"@midnight", false, map[string][]int{"minute": {0}, "hour": {0}}},
+		{"@HOURLY", false, map[string][]int{"minute": {0}}},
+	}
+	for _, test := range tests {
+		s, err := Parse(test.expr)
+		if err != nil {
+			t.Errorf("Parse(%q) = %v", test.expr, err)
+			continue
+		}
+		if s.Expr != test.expr || s.Seconds != test.seconds {
+			t.Errorf("Parse(%q) = Expr %q, Seconds %v", test.expr, s.Expr, s.Seconds)
+		}
+		if test.seconds {
+			every["second"] = span(0, 59, 1)
+		} else {
+			every["second"] = []int{0}
+		}
+		fields := map[string]fieldSpec{"second": s.second, "minute": s.minute, "hour": s.hour, "dom": s.dom, "month": s.month, "dow": s.dow}
+		for name, f := range fields {
+			want, ok := test.want[name]
+			if !ok {
+				want = every[name]
+			}
+			if got := selected(f); !slices.Equal(got, want) {
+				t.Errorf("Parse(%q) %s = %v, want %v", test.expr, name, got, want)
+			}
+		}
+	}
+}
+
+func TestParseStarDays(t *testing.T) {
+	// Whether the days start with * decides how they combine, a * with a step included
+	tests := []struct {
+		expr             string
+		domStar, dowStar bool
+	}{
+		{"0 0 * * *", true, true},
+		{"0 0 ? * MON", true, false},
+		{"0 0 */2 * MON", true, false},
+		{"0 0 1 * ?", false, true},
+		{"0 0 1 * */2", false, true},
+		{"0 0 1-31 * 0-6", false, false},
+		{"0 0 1,* * 1", false, false},
+	}
+	for _, test := range tests {
+		s, err := Parse(test.expr)
+		if err != nil || s.dom.Star != test.domStar || s.dow.Star != test.dowStar {
+			t.Errorf("Parse(%q) = dom star %v, dow star %v, %v; want %v, %v", test.expr, s.dom.Star, s.dow.Star, err, test.domStar, test.dowStar)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	macroHint := ", expected @yearly, @annually, @monthly, @weekly, @daily, @midnight or @hourly"
+	tests := []struct {
+		expr     string
+		pos, len int
+		msg      string
+	}{
+		{"", 0, 0, "expected 5 fields, or 6 with seconds, found 0"},
+		{"* * * *", 0, 7, "expected 5 fields, or 6 with seconds, found 4"},
+		{"* * * * * * *", 12, 1, "expected 5 fields, or 6 with seconds, found 7"},
+		{"0 0 * * * * 2025 x", 12, 6, "expected 5 fields, or 6 with seconds, found 8"},
+		{"@often", 0, 6, "unknown macro '@often'" + macroHint},
+		{" @reboot ", 1, 7, "unknown macro '@reboot'" + macroHint},
+		{"60 * * * *", 0, 2, "minute 60 is out of range 0-59"},
+		{"* 24 * * *", 2, 2, "hour 24 is out of range 0-23"},
+		{"* * 0 * *", 4, 1, "day of month 0 is out of range 1-31"},
+		{"* * 32 * *", 4, 2, "day of month 32 is out of range 1-31"},
+		{"* * * 0 *", 6, 1, "month 0 is out of range 1-12"},
+		{"* * * 13 *", 6, 2, "month 13 is out of range 1-12"},
+		{"* * * * 8", 8, 1, "day of week 8 is out of range 0-7"},
+		{"61 * * * * *", 0, 2, "second 61 is out of range 0-59"},
+		{"* * * * * 8", 10, 1, "day of week 8 is out of range 0-7"},
+		{"* * * FOO *", 6, 3, "'FOO' is neither a number nor a name (JAN-DEC) in the month field"},
+		{"* * * * MONDAY", 8, 6, "'MONDAY' is neither a number nor a name (SUN-SAT) in the day of week field"},
+		{"x * * * *", 0, 1, "'x' is not a number in the minute field"},
+		{"* * L * *", 4, 1, "'L' is not a number in the day of month field"},
+		{"-1 * * * *", 0, 1, "missing value in the minute field"},
+		{"1,,2 * * * *", 2, 1, "empty item in the minute field"},
+		{",1 * * * *", 0, 1, "empty item in the minute field"},
+		{"1, * * * *", 2, 1, "empty item in the minute field"},
+		{"*/0 * * * *", 2, 1, "step '0' of the minute field is not a positive number"},
+		{"*/-1 * * * *", 2, 2, "step '-1' of the minute field is not a positive number"},
+		{"*/x * * * *", 2, 1, "step 'x' of the minute field is not a positive number"},
+		{"*/ * * * *", 2, 1, "step '' of the minute field is not a positive number"},
+		{"*/60 * * * *", 2, 2, "step 60 of the minute field is larger than its range 0-59"},
+		{"* * */31 * *", 6, 2, "step 31 of the day of month field is larger than its range 1-31"},
+		{"/5 * * * *", 0, 1, "missing value before the step in the minute field"},
+		{"5-1 * * * *", 0, 3, "range 5-1 of the minute field ends before it starts"},
+		{"5- * * * *", 2, 1, "missing value in the minute field"},
+		{"* * * * FRI-MON", 8, 7, "range FRI-MON of the day of week field ends before it starts"},
+		// Sunday is 0 at the start of a range
+		{"* * * * MON-SUN", 8, 7, "range MON-SUN of the day of week field ends before it starts"},
+		{"0 0 30 2 *", 4, 2, "no selected month has this day, the schedule never fires"},
+		{"0 0 31 4,6 *", 4, 2, "no selected month has this day, the schedule never fires"},
+		{"0 0 31 2 ?", 4, 2, "no selected month has this day, the schedule never fires"},
+		{"0 0 0 31 2 */2", 6, 2, "no selected month has this day, the schedule never fires"},
+	}
+	for _, test := range tests {
+		_, err := Parse(test.expr)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("Parse(%q) = %v, want a ParseError", test.expr, err)
+			continue
+		}
+		if parseErr.Expr != test.expr || parseErr.Pos != test.pos || parseErr.Len != test.len || parseErr.Msg != test.msg {
+			t.Errorf("Parse(%q) = %+v\nwant column %d length %d: %s", test.expr, *parseErr, test.pos+1, test.len, test.msg)
+		}
+	}
+}
+
+func TestParseErrorCaret(t *testing.T) {
+	tests := []struct {
+		expr  string
+		error string
+		caret string
+	}{
+		{"0 25 * * *", "column 3: hour 25 is out of range 0-23", "  0 25 * * *\n    ^^"},
+		{"0 0 * * FRI-MON", "column 9: range FRI-MON of the day of week field ends before it starts", "  0 0 * * FRI-MON\n          ^^^^^^^"},
+		{"1,,2 * * * *", "column 3: empty item in the minute field", "  1,,2 * * * *\n    ^"},
+		// An empty expression still gets a caret
+		{"", "column 1: expected 5 fields, or 6 with seconds, found 0", "  \n  ^"},
+	}
+	for _, test := range tests {
+		_, err := Parse(test.expr)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) || err.Error() != test.error {
+			t.Errorf("Parse(%q) = %v, want %s", test.expr, err, test.error)
+			continue
+		}
+		if caret := parseErr.Caret("  "); caret != test.caret {
+			t.Errorf("Caret of %q =\n%s\nwant\n%s", test.expr, caret, test.caret)
+		}
+	}
+}
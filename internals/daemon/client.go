@@ -0,0 +1,231 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/progress"
+	"gsn-dev-tools/internals/redact"
+
+	"github.com/schollz/progressbar/v3"
+	"github.com/spf13/cobra"
+)
+
+// pollInterval is how often gsn client asks the daemon for the state of its job
+const pollInterval = 200 * time.Millisecond
+
+func ClientCmd() *cobra.Command {
+	clientCmd := &cobra.Command{
+		Use:   "client",
+		Short: "Runs an operation through gsn daemon, or in-process when it is not running",
+		Long: `Sends the operation to the running gsn daemon, waits for its job and prints the result as JSON. Without a
+daemon, or with --local, the operation runs in this process instead with the same result. Relative paths are
+resolved against the current directory before they are sent.`,
+		Example: `  gsn client compress ./project
+  gsn client rename-plan ./photos -t photo_{n}
+  gsn client pr-list --repo owner/repo --local`,
+	}
+
+	clientCmd.PersistentFlags().Bool("local", false, "Run the operation in this process even when the daemon is running")
+	for _, op := range ops {
+		clientCmd.AddCommand(opCmd(op))
+	}
+	return clientCmd
+}
+
+// opCmd builds the client subcommand of an operation, its args and flags are the operation's params
+func opCmd(op Op) *cobra.Command {
+	use := op.Name
+	for _, p := range op.Args {
+		use += " <" + p.Name + ">"
+	}
+
+	cmd := &cobra.Command{
+		Use:     use,
+		Short:   op.Short,
+		Long:    op.Short + ". Prints the result as JSON.",
+		Example: op.Example,
+		Args:    cobra.ExactArgs(len(op.Args)),
+		Run: func(cmd *cobra.Command, args []string) {
+			params, err := paramsFromCommand(cmd, op, args)
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			var result any
+			local, _ := cmd.Flags().GetBool("local")
+			c, cerr := connect(cmd.Context())
+			if local || cerr != nil {
+				result, err = op.Run(cmd.Context(), params, nil)
+			} else {
+				result, err = c.run(cmd.Context(), op, params)
+			}
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			data, err := json.MarshalIndent(result, "", "  ")
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			redact.JSONOutput()
+			fmt.Println(string(data))
+		},
+	}
+
+	for _, p := range op.Flags {
+		if p.Bool {
+			cmd.Flags().BoolP(p.Name, p.Shorthand, false, p.Usage)
+		} else {
+			cmd.Flags().StringP(p.Name, p.Shorthand, p.Default, p.Usage)
+		}
+	}
+	return cmd
+}
+
+// paramsFromCommand collects the args and flags of an operation, with paths made absolute
+func paramsFromCommand(cmd *cobra.Command, op Op, args []string) (Params, error) {
+	params := Params{}
+	for i, p := range op.Args {
+		params[p.Name] = args[i]
+	}
+	for _, p := range op.Flags {
+		value := cmd.Flags().Lookup(p.Name).Value.String()
+		if p.Bool && value == "false" {
+			continue
+		}
+		if value != "" {
+			params[p.Name] = value
+		}
+	}
+
+	for _, p := range append(append([]Param(nil), op.Args...), op.Flags...) {
+		if !p.Path || params[p.Name] == "" {
+			continue
+		}
+		abs, err := filepath.Abs(params[p.Name])
+		if err != nil {
+			return nil, err
+		}
+		params[p.Name] = abs
+	}
+	return params, op.validate(params)
+}
+
+// daemonClient talks to the daemon described by the endpoint file
+type daemonClient struct {
+	endpoint endpoint
+	http     *http.Client
+}
+
+// connect reads the endpoint file and checks that the daemon answers with the token
+func connect(ctx context.Context) (*daemonClient, error) {
+	path, err := endpointFile()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ep endpoint
+	if err := json.Unmarshal(data, &ep); err != nil {
+		return nil, fmt.Errorf("invalid endpoint file '%s': %w", path, err)
+	}
+
+	dialer := &net.Dialer{Timeout: 2 * time.Second}
+	c := &daemonClient{
+		endpoint: ep,
+		http: &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, ep.Network, ep.Address)
+			},
+		}},
+	}
+	if err := c.do(ctx, http.MethodGet, "/jobs", nil, nil); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// run starts a job and waits for it, showing its progress when it reports any
+func (c *daemonClient) run(ctx context.Context, op Op, params Params) (any, error) {
+	var job Job
+	if err := c.do(ctx, http.MethodPost, "/jobs", jobRequest{Op: op.Name, Params: params}, &job); err != nil {
+		return nil, err
+	}
+
+	var bar *progressbar.ProgressBar
+	for job.State == stateRunning {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(pollInterval):
+		}
+		if err := c.do(ctx, http.MethodGet, "/jobs/"+job.ID, nil, &job); err != nil {
+			return nil, err
+		}
+		if job.Total > 0 {
+			if bar == nil {
+				bar = progress.NewBytes(job.Total, fmt.Sprintf("%s (job %s)", op.Name, job.ID))
+			}
+			bar.Set64(job.Done)
+		}
+	}
+	if bar != nil {
+		bar.Finish()
+	}
+
+	if job.State == stateFailed {
+		return nil, fmt.Errorf("%s", job.Error)
+	}
+	return job.Result, nil
+}
+
+// do sends an authenticated request, decoding the response into out when given
+func (c *daemonClient) do(ctx context.Context, method string, path string, in any, out any) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+
+	host := "gsn"
+	if c.endpoint.Network == "tcp" {
+		host = c.endpoint.Address
+	}
+	req, err := http.NewRequestWithContext(ctx, method, "http://"+host+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.endpoint.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		return fmt.Errorf("daemon answered %s: %s", resp.Status, strings.TrimSpace(apiErr.Error))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,157 @@
+package daemon
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gsn-dev-tools/internals/units"
+)
+
+// maxFinishedJobs is how many finished jobs the daemon keeps for /jobs/<id>, older ones are forgotten
+const maxFinishedJobs = 100
+
+// States of a Job
+const (
+	stateRunning   = "running"
+	stateSucceeded = "succeeded"
+	stateFailed    = "failed"
+)
+
+// Job is an operation run by the daemon, as reported by /jobs/<id>. Done and Total count bytes for
+// operations reporting progress and stay 0 otherwise.
+type Job struct {
+	ID         string    `json:"id"`
+	Op         string    `json:"op"`
+	Params     Params    `json:"params"`
+	State      string    `json:"state"`
+	Done       int64     `json:"done"`
+	Total      int64     `json:"total"`
+	Result     any       `json:"result,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at,omitzero"`
+}
+
+// jobProgress records the progress of a job, it is the progress.Tracker handed to the operation
+type jobProgress struct {
+	done  atomic.Int64
+	total atomic.Int64
+}
+
+func (p *jobProgress) Write(b []byte) (int, error) {
+	p.done.Add(int64(len(b)))
+	return len(b), nil
+}
+
+func (p *jobProgress) Add64(n int64) error {
+	p.done.Add(n)
+	return nil
+}
+
+func (p *jobProgress) ChangeMax64(total int64) {
+	p.total.Store(total)
+}
+
+func (p *jobProgress) Finish() error {
+	return nil
+}
+
+// jobRegistry runs jobs and keeps them until maxFinishedJobs newer jobs finished
+type jobRegistry struct {
+	mu       sync.Mutex
+	jobs     map[string]*Job
+	progress map[string]*jobProgress
+	finished []string
+	log      func(string, ...any)
+}
+
+func newJobRegistry(log func(string, ...any)) *jobRegistry {
+	return &jobRegistry{jobs: make(map[string]*Job), progress: make(map[string]*jobProgress), log: log}
+}
+
+// start runs op in the background, ctx cancels the jobs when the daemon stops
+func (r *jobRegistry) start(ctx context.Context, op Op, params Params) Job {
+	job := &Job{ID: newJobID(), Op: op.Name, Params: params, State: stateRunning, StartedAt: time.Now().UTC()}
+	tracker := &jobProgress{}
+
+	r.mu.Lock()
+	r.jobs[job.ID] = job
+	r.progress[job.ID] = tracker
+	snapshot := r.snapshot(job)
+	r.mu.Unlock()
+
+	r.log("Job %s started: %s %v\n", job.ID, op.Name, params)
+	go func() {
+		result, err := op.Run(ctx, params, tracker)
+		r.finish(job, result, err)
+	}()
+	return snapshot
+}
+
+func (r *jobRegistry) finish(job *Job, result any, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job.FinishedAt = time.Now().UTC()
+	if err != nil {
+		job.State, job.Error = stateFailed, err.Error()
+		r.log("Job %s failed: %v\n", job.ID, err)
+	} else {
+		job.State, job.Result = stateSucceeded, result
+		r.log("Job %s succeeded in %s\n", job.ID, units.FormatDuration(job.FinishedAt.Sub(job.StartedAt)))
+	}
+
+	r.finished = append(r.finished, job.ID)
+	if len(r.finished) > maxFinishedJobs {
+		oldest := r.finished[0]
+		r.finished = r.finished[1:]
+		delete(r.jobs, oldest)
+		delete(r.progress, oldest)
+	}
+}
+
+// get returns a copy of a job with its current progress
+func (r *jobRegistry) get(id string) (Job, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job, ok := r.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return r.snapshot(job), true
+}
+
+// list returns copies of every known job, oldest first
+func (r *jobRegistry) list() []Job {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := make([]Job, 0, len(r.jobs))
+	for _, job := range r.jobs {
+		result = append(result, r.snapshot(job))
+	}
+	slices.SortFunc(result, func(a, b Job) int { return a.StartedAt.Compare(b.StartedAt) })
+	return result
+}
+
+// snapshot copies job, r.mu must be held
+func (r *jobRegistry) snapshot(job *Job) Job {
+	s := *job
+	if p := r.progress[job.ID]; p != nil {
+		s.Done, s.Total = p.done.Load(), p.total.Load()
+	}
+	return s
+}
+
+// newJobID returns a short random ID
+func newJobID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,183 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"gsn-dev-tools/internals/files"
+	"gsn-dev-tools/internals/progress"
+	"gsn-dev-tools/pkg/gh"
+)
+
+// Param is an input of an operation, a positional argument or a flag of its gsn client subcommand
+type Param struct {
+	Name      string
+	Shorthand string
+	Usage     string
+	Default   string
+
+	// Path values are made absolute by gsn client, the daemon does not share its working directory
+	Path bool
+	// Bool params are flags without a value
+	Bool bool
+}
+
+// Params holds the values of an operation's params by name
+type Params map[string]string
+
+// Bool reads a Bool param, unset means false
+func (p Params) Bool(name string) bool {
+	value, _ := strconv.ParseBool(p[name])
+	return value
+}
+
+// Op is an operation the daemon runs as a job, and gsn client runs in-process when no daemon is running
+type Op struct {
+	Name    string
+	Short   string
+	Example string
+	Args    []Param
+	Flags   []Param
+
+	// Run performs the operation, tracker is nil when it runs in-process and may draw its own progress bar
+	Run func(ctx context.Context, params Params, tracker progress.Tracker) (any, error)
+}
+
+var renameFlags = []Param{
+	{Name: "extension", Shorthand: "e", Usage: "File extension to apply to all files", Default: "txt"},
+	{Name: "template", Shorthand: "t", Usage: "Name template using {name} and {n} placeholders, e.g. photo_{n}"},
+	{Name: "sort", Usage: "Order used to number files: name, natural, mtime or size", Default: "name"},
+}
+
+// ops lists the operations served by the daemon, in the order gsn client shows them
+var ops = []Op{
+	{
+		Name:    "compress",
+		Short:   "Compresses a file or directory into an archive next to it",
+		Example: "  gsn client compress ./project --manifest",
+		Args:    []Param{{Name: "path", Usage: "File or directory to compress", Path: true}},
+		Flags: []Param{
+			{Name: "format", Usage: "Archive format: tar.gz, tar, tar.zst, or gz or zst for a single file", Default: "tar.gz"},
+			{Name: "manifest", Usage: "Also write <archive>.manifest.json", Bool: true},
+			{Name: "sparse", Usage: "Store the holes of sparse files instead of their zeros", Bool: true},
+		},
+		Run: func(ctx context.Context, p Params, tracker progress.Tracker) (any, error) {
+			return files.Compress(p["path"], files.CompressOptions{Manifest: p.Bool("manifest"), Sparse: p.Bool("sparse"), Format: p["format"], Progress: tracker})
+		},
+	},
+	{
+		Name:    "extract",
+		Short:   "Extracts every entry of an archive",
+		Example: "  gsn client extract project.tar.gz -o ./restored --on-conflict backup",
+		Args:    []Param{{Name: "archive", Usage: "Archive to extract", Path: true}},
+		Flags: []Param{
+			{Name: "output", Shorthand: "o", Usage: "Destination directory", Default: ".", Path: true},
+			{Name: "on-conflict", Usage: "What to do with existing files: overwrite, skip or backup", Default: "overwrite"},
+		},
+		Run: func(ctx context.Context, p Params, tracker progress.Tracker) (any, error) {
+			return files.Extract(p["archive"], p["output"], p["on-conflict"])
+		},
+	},
+	{
+		Name:    "hash",
+		Short:   "Lists a file or directory with the SHA-256 of every file",
+		Example: "  gsn client hash ./project",
+		Args:    []Param{{Name: "path", Usage: "File or directory to hash", Path: true}},
+		Run: func(ctx context.Context, p Params, tracker progress.Tracker) (any, error) {
+			return files.Hash(p["path"])
+		},
+	},
+	{
+		Name:    "rename-plan",
+		Short:   "Shows the renames gsn rename would do in a directory",
+		Example: "  gsn client rename-plan ./photos -t photo_{n} --sort mtime",
+		Args:    []Param{{Name: "directory", Usage: "Directory whose files are renamed", Path: true}},
+		Flags:   renameFlags,
+		Run: func(ctx context.Context, p Params, tracker progress.Tracker) (any, error) {
+			return files.PlanRename(p["directory"], renameOptions(p))
+		},
+	},
+	{
+		Name:    "rename-apply",
+		Short:   "Renames the files of a directory like gsn rename",
+		Example: "  gsn client rename-apply ./photos -t photo_{n} --sort mtime",
+		Args:    []Param{{Name: "directory", Usage: "Directory whose files are renamed", Path: true}},
+		Flags:   renameFlags,
+		Run: func(ctx context.Context, p Params, tracker progress.Tracker) (any, error) {
+			renamed, err := files.ApplyRename(p["directory"], renameOptions(p))
+			if err != nil {
+				return nil, err
+			}
+			return map[string]int{"renamed": renamed}, nil
+		},
+	},
+	{
+		Name:    "pr-list",
+		Short:   "Lists the open pull requests of a repository",
+		Example: `  gsn client pr-list --repo owner/repo --search "author:app/dependabot"`,
+		Flags: []Param{
+			{Name: "repo", Shorthand: "R", Usage: "Repository in owner/repo format"},
+			{Name: "search", Shorthand: "s", Usage: "Filter pull requests with a GitHub search query"},
+		},
+		Run: func(ctx context.Context, p Params, tracker progress.Tracker) (any, error) {
+			return gh.ListPullRequests(ctx, p["repo"], p["search"])
+		},
+	},
+	{
+		Name:    "approve",
+		Short:   "Approves a pull request like gsn approve",
+		Example: `  gsn client approve https://github.com/owner/repo/pull/42 --head-sha 1a2b3c4 -m "LGTM"`,
+		Args:    []Param{{Name: "pr", Usage: "Pull request URL"}},
+		Flags: []Param{
+			{Name: "head-sha", Usage: "Refuse to approve unless the PR head matches this commit SHA"},
+			{Name: "message", Shorthand: "m", Usage: "Review message, may use the placeholders of gsn approve"},
+		},
+		Run: func(ctx context.Context, p Params, tracker progress.Tracker) (any, error) {
+			message, err := gh.ApprovePR(ctx, p["pr"], p["head-sha"], p["message"])
+			if err != nil {
+				return nil, err
+			}
+			return map[string]string{"message": message}, nil
+		},
+	},
+}
+
+func renameOptions(p Params) files.RenameOptions {
+	return files.RenameOptions{Extension: p["extension"], Template: p["template"], Sort: p["sort"]}
+}
+
+// lookupOp finds an operation by name
+func lookupOp(name string) (Op, bool) {
+	for _, op := range ops {
+		if op.Name == name {
+			return op, true
+		}
+	}
+	return Op{}, false
+}
+
+// validate rejects unknown and missing params, and relative paths the daemon would resolve against its own
+// working directory
+func (op Op) validate(params Params) error {
+	known := make(map[string]Param)
+	for _, p := range append(append([]Param(nil), op.Args...), op.Flags...) {
+		known[p.Name] = p
+	}
+	for name, value := range params {
+		p, ok := known[name]
+		if !ok {
+			return fmt.Errorf("%s has no param '%s'", op.Name, name)
+		}
+		if p.Path && value != "" && !filepath.IsAbs(value) {
+			return fmt.Errorf("param '%s' must be an absolute path, got '%s'", name, value)
+		}
+	}
+	for _, p := range op.Args {
+		if params[p.Name] == "" {
+			return fmt.Errorf("%s needs the param '%s'", op.Name, p.Name)
+		}
+	}
+	return nil
+}
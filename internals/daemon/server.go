@@ -0,0 +1,222 @@
+package daemon
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/output"
+	"gsn-dev-tools/internals/paths"
+
+	"github.com/spf13/cobra"
+)
+
+// endpointFileName is the file in the state dir telling clients where the daemon listens and its token
+const endpointFileName = "daemon.json"
+
+// maxRequestBody caps the size of a job request
+const maxRequestBody = 1 << 20
+
+// endpoint is the content of the endpoint file, written with mode 0600 since it holds the token
+type endpoint struct {
+	Network string `json:"network"`
+	Address string `json:"address"`
+	Token   string `json:"token"`
+	PID     int    `json:"pid"`
+}
+
+// jobRequest is the body of POST /jobs
+type jobRequest struct {
+	Op     string `json:"op"`
+	Params Params `json:"params"`
+}
+
+func DaemonCmd() *cobra.Command {
+	var socketPath string
+	var port int
+
+	daemonCmd := &cobra.Command{
+		Use:   "daemon",
+		Short: "Serves gsn operations to editors and scripts over a local HTTP API",
+		Long: `Runs in the foreground and serves compress, extract, hash, rename and pull request operations as JSON over
+a unix socket, or over 127.0.0.1 with --port, so editors and scripts do not start a gsn process per call.
+Every request needs the header "Authorization: Bearer <token>". The address and a random token are written to
+daemon.json in ~/.local/state/gsn with mode 0600 and removed when the daemon stops; gsn client reads it.
+
+  POST /jobs        {"op": "compress", "params": {"path": "/abs/path"}} starts a job and returns it
+  GET  /jobs        lists the jobs
+  GET  /jobs/<id>   returns the state, progress in bytes where known, and the result or error of a job
+
+Paths must be absolute, the daemon does not share the working directory of its clients.`,
+		Example: `  gsn daemon
+  gsn daemon --port 7878
+  curl --unix-socket ~/.local/state/gsn/daemon.sock -H "Authorization: Bearer $TOKEN" http://gsn/jobs`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := runDaemon(cmd.Context(), socketPath, port); err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			fmt.Println("Daemon stopped.")
+		},
+	}
+
+	daemonCmd.Flags().StringVar(&socketPath, "socket", "", "Unix socket to listen on (default: daemon.sock in ~/.local/state/gsn)")
+	daemonCmd.Flags().IntVar(&port, "port", 0, "Listen on this 127.0.0.1 port instead of a unix socket")
+	return daemonCmd
+}
+
+// runDaemon serves the API until interrupted
+func runDaemon(ctx context.Context, socketPath string, port int) error {
+	endpointPath, err := endpointFile()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(endpointPath), 0o700); err != nil {
+		return err
+	}
+	if c, err := connect(ctx); err == nil {
+		return fmt.Errorf("a daemon is already running at %s (pid %d)", c.endpoint.Address, c.endpoint.PID)
+	}
+
+	ln, err := listen(endpointPath, socketPath, port)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return err
+	}
+	ep := endpoint{Network: ln.Addr().Network(), Address: ln.Addr().String(), Token: hex.EncodeToString(token), PID: os.Getpid()}
+	data, err := json.MarshalIndent(ep, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := output.WriteFileAtomic(endpointPath, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("error writing '%s': %w", endpointPath, err)
+	}
+	defer os.Remove(endpointPath)
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logf := func(format string, args ...any) {
+		fmt.Printf("%s "+format, append([]any{time.Now().Format(time.TimeOnly)}, args...)...)
+	}
+	server := &http.Server{Handler: newHandler(ctx, newJobRegistry(logf), ep.Token)}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Printf("Listening on %s, token in %s. Press Ctrl+C to stop.\n", ep.Address, endpointPath)
+	if err := server.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// listen opens the unix socket, replacing a stale one left by a daemon that did not stop cleanly, or the
+// loopback port
+func listen(endpointPath string, socketPath string, port int) (net.Listener, error) {
+	if port != 0 {
+		return net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	}
+
+	if socketPath == "" {
+		socketPath = filepath.Join(filepath.Dir(endpointPath), "daemon.sock")
+	}
+	if info, err := os.Lstat(socketPath); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(socketPath)
+	}
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socketPath, 0o600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// newHandler serves the job API, rejecting requests without the token
+func newHandler(ctx context.Context, jobs *jobRegistry, token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", func(w http.ResponseWriter, r *http.Request) {
+		var req jobRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxRequestBody)).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid job request: %w", err))
+			return
+		}
+		op, ok := lookupOp(req.Op)
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("unknown operation '%s'", req.Op))
+			return
+		}
+		if req.Params == nil {
+			req.Params = Params{}
+		}
+		if err := op.validate(req.Params); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, jobs.start(ctx, op, req.Params))
+	})
+	mux.HandleFunc("GET /jobs", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, jobs.list())
+	})
+	mux.HandleFunc("GET /jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		job, ok := jobs.get(r.PathValue("id"))
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no job has the ID '%s'", r.PathValue("id")))
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
+	})
+
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("missing or wrong token"))
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// endpointFile returns the location of the endpoint file
+func endpointFile() (string, error) {
+	dir, err := paths.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, endpointFileName), nil
+}
@@ -0,0 +1,334 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gsn-dev-tools/internals/progress"
+)
+
+const testToken = "s3cret"
+
+// gate is a test operation that reports 40 of 100 bytes and waits to be released, or for its job to be
+// cancelled when the daemon stops
+type gate struct {
+	started chan struct{}
+	release chan error
+}
+
+// useGateOp adds the operation "gate" to ops until the test ends
+func useGateOp(t *testing.T) *gate {
+	t.Helper()
+	g := &gate{started: make(chan struct{}, 200), release: make(chan error)}
+	saved := ops
+	ops = append(append([]Op(nil), ops...), Op{
+		Name:  "gate",
+		Args:  []Param{{Name: "name"}},
+		Flags: []Param{{Name: "dir", Path: true}},
+		Run: func(ctx context.Context, p Params, tracker progress.Tracker) (any, error) {
+			tracker.ChangeMax64(100)
+			tracker.Add64(40)
+			g.started <- struct{}{}
+			select {
+			case err := <-g.release:
+				if err != nil {
+					return nil, err
+				}
+				tracker.Add64(60)
+				return map[string]string{"hello": p["name"]}, nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		},
+	})
+	t.Cleanup(func() { ops = saved })
+	return g
+}
+
+// testDaemon serves the job API with testToken until the test ends, cancel stops its jobs like a stopping daemon
+type testDaemon struct {
+	t      *testing.T
+	server *httptest.Server
+	cancel context.CancelFunc
+}
+
+func newTestDaemon(t *testing.T) *testDaemon {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	server := httptest.NewServer(newHandler(ctx, newJobRegistry(func(string, ...any) {}), testToken))
+	t.Cleanup(func() {
+		cancel()
+		server.Close()
+	})
+	return &testDaemon{t: t, server: server, cancel: cancel}
+}
+
+// call sends a request with the token and decodes the response into out when given, returning the status
+func (d *testDaemon) call(method string, path string, body string, out any) int {
+	d.t.Helper()
+	req, err := http.NewRequest(method, d.server.URL+path, strings.NewReader(body))
+	if err != nil {
+		d.t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	resp, err := d.server.Client().Do(req)
+	if err != nil {
+		d.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			d.t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// startJob posts a job and returns it as the daemon answered
+func (d *testDaemon) startJob(body string) Job {
+	d.t.Helper()
+	var job Job
+	if status := d.call(http.MethodPost, "/jobs", body, &job); status != http.StatusAccepted {
+		d.t.Fatalf("POST /jobs %s = %d", body, status)
+	}
+	return job
+}
+
+// waitJob polls a job until it is no longer running
+func (d *testDaemon) waitJob(id string) Job {
+	d.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var job Job
+		if status := d.call(http.MethodGet, "/jobs/"+id, "", &job); status != http.StatusOK {
+			d.t.Fatalf("GET /jobs/%s = %d", id, status)
+		}
+		if job.State != stateRunning {
+			return job
+		}
+		if time.Now().After(deadline) {
+			d.t.Fatalf("job %s still runs: %+v", id, job)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestJobLifecycle(t *testing.T) {
+	g := useGateOp(t)
+	d := newTestDaemon(t)
+
+	job := d.startJob(`{"op":"gate","params":{"name":"world"}}`)
+	if job.ID == "" || job.Op != "gate" || job.State != stateRunning || job.StartedAt.IsZero() || !job.FinishedAt.IsZero() {
+		t.Fatalf("started job = %+v", job)
+	}
+	<-g.started
+
+	// While it runs, its progress is reported
+	var running Job
+	d.call(http.MethodGet, "/jobs/"+job.ID, "", &running)
+	if running.State != stateRunning || running.Done != 40 || running.Total != 100 || running.Params["name"] != "world" {
+		t.Errorf("running job = %+v", running)
+	}
+
+	g.release <- nil
+	done := d.waitJob(job.ID)
+	if done.State != stateSucceeded || done.Done != 100 || done.FinishedAt.Before(done.StartedAt) || done.Error != "" {
+		t.Errorf("finished job = %+v", done)
+	}
+	if result, _ := done.Result.(map[string]any); result["hello"] != "world" {
+		t.Errorf("result = %#v", done.Result)
+	}
+
+	// A failing job keeps its error
+	failing := d.startJob(`{"op":"gate","params":{"name":"x"}}`)
+	<-g.started
+	g.release <- errors.New("disk full")
+	if failed := d.waitJob(failing.ID); failed.State != stateFailed || failed.Error != "disk full" || failed.Result != nil {
+		t.Errorf("failed job = %+v", failed)
+	}
+
+	var jobs []Job
+	if status := d.call(http.MethodGet, "/jobs", "", &jobs); status != http.StatusOK || len(jobs) != 2 || jobs[0].ID != job.ID || jobs[1].ID != failing.ID {
+		t.Errorf("GET /jobs = %d, %+v", status, jobs)
+	}
+}
+
+func TestStoppingDaemonCancelsJobs(t *testing.T) {
+	g := useGateOp(t)
+	d := newTestDaemon(t)
+
+	job := d.startJob(`{"op":"gate","params":{"name":"x"}}`)
+	<-g.started
+	d.cancel()
+	if cancelled := d.waitJob(job.ID); cancelled.State != stateFailed || cancelled.Error != context.Canceled.Error() {
+		t.Errorf("job after the daemon stopped = %+v", cancelled)
+	}
+}
+
+func TestRejectedRequests(t *testing.T) {
+	useGateOp(t)
+	d := newTestDaemon(t)
+
+	tests := []struct {
+		name, method, path, body string
+		status                   int
+		wantErr                  string
+	}{
+		{"invalid JSON", "POST", "/jobs", `{"op":`, http.StatusBadRequest, "invalid job request"},
+		{"unknown operation", "POST", "/jobs", `{"op":"format-disk"}`, http.StatusNotFound, "unknown operation 'format-disk'"},
+		{"unknown param", "POST", "/jobs", `{"op":"gate","params":{"name":"x","force":"true"}}`, http.StatusBadRequest, "gate has no param 'force'"},
+		{"missing arg", "POST", "/jobs", `{"op":"gate"}`, http.StatusBadRequest, "gate needs the param 'name'"},
+		{"relative path", "POST", "/jobs", `{"op":"gate","params":{"name":"x","dir":"src"}}`, http.StatusBadRequest, "param 'dir' must be an absolute path, got 'src'"},
+		{"unknown job", "GET", "/jobs/feedbeef", "", http.StatusNotFound, "no job has the ID 'feedbeef'"},
+		{"wrong method", "DELETE", "/jobs", "", http.StatusMethodNotAllowed, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var body struct {
+				Error string `json:"error"`
+			}
+			var out any = &body
+			if test.wantErr == "" {
+				out = nil
+			}
+			if status := d.call(test.method, test.path, test.body, out); status != test.status || !strings.Contains(body.Error, test.wantErr) {
+				t.Errorf("%s %s = %d %q, want %d %q", test.method, test.path, status, body.Error, test.status, test.wantErr)
+			}
+		})
+	}
+
+	var jobs []Job
+	if d.call(http.MethodGet, "/jobs", "", &jobs); len(jobs) != 0 {
+		t.Errorf("rejected requests started jobs: %+v", jobs)
+	}
+}
+
+func TestRequestsNeedTheToken(t *testing.T) {
+	d := newTestDaemon(t)
+	for _, header := range []string{"", "Bearer wrong", "Bearer " + testToken + "x", "Basic " + testToken, testToken} {
+		req, _ := http.NewRequest(http.MethodGet, d.server.URL+"/jobs", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		resp, err := d.server.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized || !bytes.Contains(body, []byte("missing or wrong token")) {
+			t.Errorf("Authorization %q = %d %s", header, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestFinishedJobsAreForgotten(t *testing.T) {
+	g := useGateOp(t)
+	d := newTestDaemon(t)
+
+	var ids []string
+	for i := range maxFinishedJobs + 3 {
+		job := d.startJob(fmt.Sprintf(`{"op":"gate","params":{"name":"%d"}}`, i))
+		<-g.started
+		g.release <- nil
+		d.waitJob(job.ID)
+		ids = append(ids, job.ID)
+	}
+
+	var jobs []Job
+	d.call(http.MethodGet, "/jobs", "", &jobs)
+	if len(jobs) != maxFinishedJobs || jobs[0].ID != ids[3] {
+		t.Errorf("%d job(s) kept, the oldest %s, want %d from %s", len(jobs), jobs[0].ID, maxFinishedJobs, ids[3])
+	}
+	for _, id := range ids[:3] {
+		if status := d.call(http.MethodGet, "/jobs/"+id, "", nil); status != http.StatusNotFound {
+			t.Errorf("GET /jobs/%s = %d, want it forgotten", id, status)
+		}
+	}
+}
+
+func TestHashJob(t *testing.T) {
+	d := newTestDaemon(t)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "hello.txt"), []byte("hello\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	params, _ := json.Marshal(Params{"path": dir})
+
+	job := d.waitJob(d.startJob(`{"op":"hash","params":` + string(params) + `}`).ID)
+	result, _ := json.Marshal(job.Result)
+	// The SHA-256 of "hello\n"
+	if job.State != stateSucceeded || !bytes.Contains(result, []byte("hello.txt")) ||
+		!bytes.Contains(result, []byte("5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03")) {
+		t.Errorf("hash job = %+v, result %s", job, result)
+	}
+
+	missing, _ := json.Marshal(Params{"path": filepath.Join(dir, "missing")})
+	if job := d.waitJob(d.startJob(`{"op":"hash","params":` + string(missing) + `}`).ID); job.State != stateFailed || job.Error == "" {
+		t.Errorf("hash of a missing path = %+v", job)
+	}
+}
+
+func TestClientRunsJobsThroughTheDaemon(t *testing.T) {
+	g := useGateOp(t)
+	d := newTestDaemon(t)
+	t.Setenv("GSN_HOME", t.TempDir())
+
+	// Without an endpoint file there is no daemon
+	if _, err := connect(context.Background()); err == nil {
+		t.Fatal("connected without an endpoint file")
+	}
+
+	path, err := endpointFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeEndpoint := func(token string) {
+		data, _ := json.Marshal(endpoint{Network: "tcp", Address: d.server.Listener.Addr().String(), Token: token})
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeEndpoint("stale")
+	if _, err := connect(context.Background()); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("connect with a stale token = %v", err)
+	}
+
+	writeEndpoint(testToken)
+	c, err := connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	op, _ := lookupOp("gate")
+	go func() {
+		<-g.started
+		g.release <- nil
+		<-g.started
+		g.release <- errors.New("disk full")
+	}()
+	result, err := c.run(context.Background(), op, Params{"name": "client"})
+	if got, _ := result.(map[string]any); err != nil || got["hello"] != "client" {
+		t.Errorf("run = %#v, %v", result, err)
+	}
+	if _, err := c.run(context.Background(), op, Params{"name": "client"}); err == nil || err.Error() != "disk full" {
+		t.Errorf("run of a failing job = %v", err)
+	}
+	if _, err := c.run(context.Background(), Op{Name: "nope"}, nil); err == nil || !strings.Contains(err.Error(), "unknown operation 'nope'") {
+		t.Errorf("run of an unknown operation = %v", err)
+	}
+}
@@ -0,0 +1,301 @@
+// Package diffout renders the changes found by the diff commands. A command builds a change set, one Change per
+// key with the values on both sides, and Render prints it as aligned, colored lines, as JSON or as a one line
+// summary, in the order of the keys.
+package diffout
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/redact"
+	"gsn-dev-tools/internals/style"
+
+	"github.com/rivo/uniseg"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// Kind is how a key differs between the two sides
+type Kind string
+
+const (
+	Added     Kind = "added"
+	Removed   Kind = "removed"
+	Modified  Kind = "modified"
+	Unchanged Kind = "unchanged"
+)
+
+// Change is a key of the change set with its value on each side. Detail names what differs when the values do
+// not tell, like the size of a file.
+type Change struct {
+	Key    string `json:"key"`
+	Kind   Kind   `json:"change"`
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Format selects how a change set is rendered
+type Format int
+
+const (
+	FormatUnified Format = iota
+	FormatJSON
+	FormatSummary
+)
+
+// minValueWidth keeps truncated values readable on narrow terminals
+const minValueWidth = 8
+
+// Options controls what Render prints
+type Options struct {
+	Format Format
+	// Context is the number of unchanged keys kept around a change, longer runs of unchanged keys are collapsed
+	// into a marker line. 0 leaves unchanged keys out.
+	Context int
+	// ShowValues prints the values, without it only the keys are printed
+	ShowValues bool
+	// MaxWidth truncates values so lines fit, 0 never truncates
+	MaxWidth int
+}
+
+// AddFlags registers --diff-format on a diff command
+func AddFlags(cmd *cobra.Command) {
+	cmd.Flags().String("diff-format", "unified", "Output format: unified, json or summary")
+}
+
+// AddContextFlag registers --context on a diff command whose change set includes the unchanged keys
+func AddContextFlag(cmd *cobra.Command) {
+	cmd.Flags().IntP("context", "U", 0, "Unchanged keys to show around each change")
+}
+
+// OptionsFromFlags reads the flags of AddFlags and AddContextFlag and detects the width of the terminal
+func OptionsFromFlags(cmd *cobra.Command) (Options, error) {
+	formatName, _ := cmd.Flags().GetString("diff-format")
+	context, _ := cmd.Flags().GetInt("context")
+
+	var opts Options
+	switch formatName {
+	case "unified":
+		opts.Format = FormatUnified
+	case "json":
+		opts.Format = FormatJSON
+	case "summary":
+		opts.Format = FormatSummary
+	default:
+		return opts, clierr.Newf(clierr.Usage, "invalid --diff-format '%s', use unified, json or summary", formatName)
+	}
+	if context < 0 {
+		return opts, clierr.Newf(clierr.Usage, "--context must not be negative")
+	}
+	opts.Context = context
+
+	fd := redact.Fd(os.Stdout)
+	if term.IsTerminal(fd) {
+		if width, _, err := term.GetSize(fd); err == nil {
+			opts.MaxWidth = width
+		}
+	}
+	return opts, nil
+}
+
+// Count returns the number of changes, unchanged keys aside
+func Count(changes []Change) int {
+	n := 0
+	for _, c := range changes {
+		if c.Kind != Unchanged {
+			n++
+		}
+	}
+	return n
+}
+
+// Render writes the changes sorted by key
+func Render(w io.Writer, changes []Change, opts Options) error {
+	sorted := make([]Change, len(changes))
+	copy(sorted, changes)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Key < sorted[j].Key })
+
+	switch opts.Format {
+	case FormatJSON:
+		return renderJSON(w, sorted, opts)
+	case FormatSummary:
+		_, err := fmt.Fprintln(w, summary(sorted))
+		return err
+	}
+	return renderUnified(w, sorted, opts)
+}
+
+// summary counts the changes of each kind
+func summary(changes []Change) string {
+	counts := map[Kind]int{}
+	for _, c := range changes {
+		counts[c.Kind]++
+	}
+	if Count(changes) == 0 {
+		return "No differences"
+	}
+	return fmt.Sprintf("%d added, %d removed, %d modified", counts[Added], counts[Removed], counts[Modified])
+}
+
+func renderJSON(w io.Writer, changes []Change, opts Options) error {
+	list := make([]Change, 0, len(changes))
+	for _, c := range changes {
+		if c.Kind == Unchanged {
+			continue
+		}
+		if !opts.ShowValues {
+			c.Before, c.After = "", ""
+		}
+		list = append(list, c)
+	}
+	data, err := json.MarshalIndent(struct {
+		Changes []Change `json:"changes"`
+		Summary string   `json:"summary"`
+	}{list, summary(changes)}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if w == os.Stdout {
+		redact.JSONOutput()
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+// renderUnified prints a line per change, marked -, + or ~, with the keys aligned in a column
+func renderUnified(w io.Writer, changes []Change, opts Options) error {
+	count := Count(changes)
+	if count == 0 {
+		_, err := fmt.Fprintln(w, style.Success()+"No differences")
+		return err
+	}
+
+	shown := collapse(changes, opts.Context)
+	keyWidth := 0
+	for _, c := range shown {
+		if c != nil {
+			keyWidth = max(keyWidth, uniseg.StringWidth(c.Key))
+		}
+	}
+
+	var b strings.Builder
+	skipped := 0
+	for i, c := range shown {
+		if c == nil {
+			skipped++
+			if i+1 < len(shown) && shown[i+1] != nil || i+1 == len(shown) {
+				fmt.Fprintf(&b, "%s\n", style.Bold(fmt.Sprintf("@@ %d unchanged @@", skipped)))
+				skipped = 0
+			}
+			continue
+		}
+		b.WriteString(formatLine(*c, keyWidth, opts))
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "\n%d difference(s)\n", count)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// collapse keeps the changes and up to context unchanged keys before and after each, every unchanged key left
+// out is nil
+func collapse(changes []Change, context int) []*Change {
+	near := make([]bool, len(changes))
+	for i, c := range changes {
+		if c.Kind == Unchanged {
+			continue
+		}
+		for j := max(0, i-context); j <= min(len(changes)-1, i+context); j++ {
+			near[j] = true
+		}
+	}
+
+	shown := make([]*Change, 0, len(changes))
+	for i := range changes {
+		if !near[i] {
+			if context == 0 {
+				continue
+			}
+			shown = append(shown, nil)
+			continue
+		}
+		shown = append(shown, &changes[i])
+	}
+	return shown
+}
+
+// formatLine renders a change as its mark, the padded key and, with ShowValues, its values
+func formatLine(c Change, keyWidth int, opts Options) string {
+	mark, color := "  ", func(s string) string { return s }
+	switch c.Kind {
+	case Added:
+		mark, color = "+ ", style.Green
+	case Removed:
+		mark, color = "- ", style.Red
+	case Modified:
+		mark, color = "~ ", style.Yellow
+	}
+
+	line := mark + c.Key
+	var value string
+	if opts.ShowValues {
+		// Values share what the terminal leaves after the key column
+		budget := 0
+		if opts.MaxWidth > 0 {
+			budget = max(opts.MaxWidth-len(mark)-keyWidth-2, minValueWidth)
+		}
+		switch c.Kind {
+		case Added:
+			value = truncate(printable(c.After), budget)
+		case Removed, Unchanged:
+			value = truncate(printable(c.Before), budget)
+		case Modified:
+			half := 0
+			if budget > 0 {
+				half = max((budget-4)/2, minValueWidth)
+			}
+			value = truncate(printable(c.Before), half) + " -> " + truncate(printable(c.After), half)
+		}
+	}
+	if c.Detail != "" {
+		value = strings.TrimSpace(value + " (" + c.Detail + ")")
+	}
+	if value != "" {
+		line += strings.Repeat(" ", keyWidth-uniseg.StringWidth(c.Key)+2) + value
+	}
+	return color(line)
+}
+
+// printable escapes line breaks and tabs so a value stays on its line
+var printable = strings.NewReplacer("\r", `\r`, "\n", `\n`, "\t", `\t`).Replace
+
+// truncate shortens s to at most width display cells, marking the cut with an ellipsis or ~ in plain mode. A
+// width of 0 keeps s whole.
+func truncate(s string, width int) string {
+	if width <= 0 || uniseg.StringWidth(s) <= width {
+		return s
+	}
+	marker := "…"
+	if style.Plain() {
+		marker = "~"
+	}
+
+	var b strings.Builder
+	used := 0
+	g := uniseg.NewGraphemes(s)
+	for g.Next() {
+		cw := g.Width()
+		if used+cw > width-1 {
+			break
+		}
+		b.WriteString(g.Str())
+		used += cw
+	}
+	return b.String() + marker
+}
@@ -0,0 +1,229 @@
+package diffout
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"gsn-dev-tools/internals/style"
+
+	"github.com/spf13/cobra"
+)
+
+// usePlain switches the style to plain or colored output for the test
+func usePlain(t *testing.T, plain bool) {
+	t.Helper()
+	was := style.Plain()
+	style.SetPlain(plain)
+	t.Cleanup(func() { style.SetPlain(was) })
+}
+
+// envChanges is a change set in no particular order, with a key of each kind
+var envChanges = []Change{
+	{Key: "PORT", Kind: Unchanged, Before: "8080", After: "8080"},
+	{Key: "API_KEY", Kind: Modified, Before: "old-secret", After: "new-secret"},
+	{Key: "DEBUG", Kind: Removed, Before: "true"},
+	{Key: "NAME", Kind: Added, After: "abcdefghijklmnopqrstuvwxyz"},
+	{Key: "HOST", Kind: Unchanged, Before: "localhost", After: "localhost"},
+}
+
+func render(t *testing.T, changes []Change, opts Options) string {
+	t.Helper()
+	var out bytes.Buffer
+	if err := Render(&out, changes, opts); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestRenderUnified(t *testing.T) {
+	tests := []struct {
+		name  string
+		plain bool
+		opts  Options
+		want  string
+	}{
+		{"keys only", true, Options{},
+			"~ API_KEY\n" +
+				"- DEBUG\n" +
+				"+ NAME\n" +
+				"\n3 difference(s)\n"},
+		{"values", true, Options{ShowValues: true},
+			"~ API_KEY  old-secret -> new-secret\n" +
+				"- DEBUG    true\n" +
+				"+ NAME     abcdefghijklmnopqrstuvwxyz\n" +
+				"\n3 difference(s)\n"},
+		{"values with context", true, Options{ShowValues: true, Context: 1},
+			"~ API_KEY  old-secret -> new-secret\n" +
+				"- DEBUG    true\n" +
+				"  HOST     localhost\n" +
+				"+ NAME     abcdefghijklmnopqrstuvwxyz\n" +
+				"  PORT     8080\n" +
+				"\n3 difference(s)\n"},
+		{"colored", false, Options{ShowValues: true, Context: 1},
+			"\x1b[33m~ API_KEY  old-secret -> new-secret\x1b[0m\n" +
+				"\x1b[31m- DEBUG    true\x1b[0m\n" +
+				"  HOST     localhost\n" +
+				"\x1b[32m+ NAME     abcdefghijklmnopqrstuvwxyz\x1b[0m\n" +
+				"  PORT     8080\n" +
+				"\n3 difference(s)\n"},
+		// 30 columns leave 19 for a value once the mark and key column are taken, and at least 8 for each side
+		{"truncated", true, Options{ShowValues: true, MaxWidth: 30},
+			"~ API_KEY  old-sec~ -> new-sec~\n" +
+				"- DEBUG    true\n" +
+				"+ NAME     abcdefghijklmnopqr~\n" +
+				"\n3 difference(s)\n"},
+		{"truncated and colored", false, Options{ShowValues: true, MaxWidth: 30},
+			"\x1b[33m~ API_KEY  old-sec… -> new-sec…\x1b[0m\n" +
+				"\x1b[31m- DEBUG    true\x1b[0m\n" +
+				"\x1b[32m+ NAME     abcdefghijklmnopqr…\x1b[0m\n" +
+				"\n3 difference(s)\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			usePlain(t, test.plain)
+			if got := render(t, envChanges, test.opts); got != test.want {
+				t.Errorf("Render =\n%s\nwant\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRenderCollapsesUnchangedRuns(t *testing.T) {
+	usePlain(t, true)
+	var changes []Change
+	for _, key := range strings.Split("abcdefghij", "") {
+		changes = append(changes, Change{Key: key, Kind: Unchanged, Before: "1"})
+	}
+	changes[4] = Change{Key: "e", Kind: Modified, Before: "1", After: "2"}
+
+	want := "@@ 3 unchanged @@\n" +
+		"  d  1\n" +
+		"~ e  1 -> 2\n" +
+		"  f  1\n" +
+		"@@ 4 unchanged @@\n" +
+		"\n1 difference(s)\n"
+	if got := render(t, changes, Options{ShowValues: true, Context: 1}); got != want {
+		t.Errorf("Render =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRenderDetailAndEscapes(t *testing.T) {
+	usePlain(t, true)
+	// cmp diff tells why an entry differs without values
+	changes := []Change{
+		{Key: "notes.txt", Kind: Modified, Detail: "size 12 B -> 14 B"},
+		{Key: "bin/tool", Kind: Added},
+	}
+	want := "+ bin/tool\n" +
+		"~ notes.txt  (size 12 B -> 14 B)\n" +
+		"\n2 difference(s)\n"
+	if got := render(t, changes, Options{}); got != want {
+		t.Errorf("Render =\n%s\nwant\n%s", got, want)
+	}
+
+	// A value keeps to its line
+	changes = []Change{{Key: "MOTD", Kind: Modified, Before: "line one\r\n", After: "line one\nline\ttwo"}}
+	want = "~ MOTD  line one\\r\\n -> line one\\nline\\ttwo\n" +
+		"\n1 difference(s)\n"
+	if got := render(t, changes, Options{ShowValues: true}); got != want {
+		t.Errorf("Render =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRenderNoDifferences(t *testing.T) {
+	usePlain(t, true)
+	same := []Change{{Key: "PORT", Kind: Unchanged, Before: "8080"}}
+	if got := render(t, same, Options{Context: 3}); got != "OK: No differences\n" {
+		t.Errorf("unified = %q", got)
+	}
+	if got := render(t, same, Options{Format: FormatSummary}); got != "No differences\n" {
+		t.Errorf("summary = %q", got)
+	}
+}
+
+func TestRenderSummaryAndJSON(t *testing.T) {
+	if got := render(t, envChanges, Options{Format: FormatSummary}); got != "1 added, 1 removed, 1 modified\n" {
+		t.Errorf("summary = %q", got)
+	}
+
+	for _, showValues := range []bool{false, true} {
+		var doc struct {
+			Changes []Change `json:"changes"`
+			Summary string   `json:"summary"`
+		}
+		if err := json.Unmarshal([]byte(render(t, envChanges, Options{Format: FormatJSON, ShowValues: showValues})), &doc); err != nil {
+			t.Fatal(err)
+		}
+		// Unchanged keys are left out, the others sorted
+		if len(doc.Changes) != 3 || doc.Changes[0].Key != "API_KEY" || doc.Changes[1].Key != "DEBUG" || doc.Changes[2].Key != "NAME" {
+			t.Fatalf("changes = %+v", doc.Changes)
+		}
+		if hasValues := doc.Changes[0].Before != "" || doc.Changes[2].After != ""; hasValues != showValues {
+			t.Errorf("values shown = %v with ShowValues %v: %+v", hasValues, showValues, doc.Changes)
+		}
+		if doc.Changes[0].Kind != Modified || doc.Summary != "1 added, 1 removed, 1 modified" {
+			t.Errorf("document = %+v", doc)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s     string
+		width int
+		plain bool
+		want  string
+	}{
+		{"short", 10, false, "short"},
+		{"exactly10!", 10, false, "exactly10!"},
+		{"eleven char", 10, false, "eleven ch…"},
+		{"eleven char", 10, true, "eleven ch~"},
+		{"eleven char", 0, false, "eleven char"},
+		// Wide characters count two cells and are never split
+		{"日本語テキスト", 7, false, "日本語…"},
+		{"日本語テキスト", 8, false, "日本語…"},
+		{"ab👨‍👩‍👧cd", 4, false, "ab…"},
+		{"ab👨‍👩‍👧cd", 5, false, "ab👨‍👩‍👧…"},
+	}
+	for _, test := range tests {
+		usePlain(t, test.plain)
+		if got := truncate(test.s, test.width); got != test.want {
+			t.Errorf("truncate(%q, %d) plain=%v = %q, want %q", test.s, test.width, test.plain, got, test.want)
+		}
+	}
+}
+
+func TestOptionsFromFlags(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    Options
+		wantErr string
+	}{
+		{nil, Options{Format: FormatUnified}, ""},
+		{[]string{"--diff-format", "json", "-U", "3"}, Options{Format: FormatJSON, Context: 3}, ""},
+		{[]string{"--diff-format", "summary"}, Options{Format: FormatSummary}, ""},
+		{[]string{"--diff-format", "side-by-side"}, Options{}, "invalid --diff-format 'side-by-side'"},
+		{[]string{"--context", "-1"}, Options{}, "--context must not be negative"},
+	}
+	for _, test := range tests {
+		cmd := &cobra.Command{Use: "diff"}
+		AddFlags(cmd)
+		AddContextFlag(cmd)
+		if err := cmd.ParseFlags(test.args); err != nil {
+			t.Fatal(err)
+		}
+		// stdout is not a terminal under go test, so nothing is truncated
+		opts, err := OptionsFromFlags(cmd)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%v: err = %v, want %q", test.args, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil || opts != test.want {
+			t.Errorf("%v: options = %+v, %v, want %+v", test.args, opts, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// typeCAA is missing from dnsmessage, its records are read from the raw resource data
+const typeCAA = dnsmessage.Type(257)
+
+// udpSize is the EDNS0 buffer size advertised, the value DNS flag day 2020 settled on
+const udpSize = 1232
+
+// recordTypes maps the names accepted on the command line to their types, in the order they are queried
+var recordTypes = []struct {
+	Name string
+	Type dnsmessage.Type
+}{
+	{"A", dnsmessage.TypeA},
+	{"AAAA", dnsmessage.TypeAAAA},
+	{"CNAME", dnsmessage.TypeCNAME},
+	{"MX", dnsmessage.TypeMX},
+	{"TXT", dnsmessage.TypeTXT},
+	{"CAA", typeCAA},
+	{"NS", dnsmessage.TypeNS},
+}
+
+func parseType(name string) (dnsmessage.Type, error) {
+	for _, t := range recordTypes {
+		if strings.EqualFold(t.Name, name) {
+			return t.Type, nil
+		}
+	}
+	names := make([]string, len(recordTypes))
+	for i, t := range recordTypes {
+		names[i] = t.Name
+	}
+	return 0, fmt.Errorf("unknown record type '%s', expected one of %s", name, strings.Join(names, ", "))
+}
+
+func typeName(t dnsmessage.Type) string {
+	for _, rt := range recordTypes {
+		if rt.Type == t {
+			return rt.Name
+		}
+	}
+	return strconv.Itoa(int(t))
+}
+
+// record is one answer, Value is rendered the way dig prints it
+type record struct {
+	Value string `json:"value"`
+	TTL   uint32 `json:"ttl"`
+}
+
+// answer is the reply of one server to one question
+type answer struct {
+	Rcode   dnsmessage.RCode
+	Records []record
+	RTT     time.Duration
+}
+
+// query asks server, an ip:port, for the records of type t of name. It uses UDP and retries over TCP when the
+// reply is truncated. Only records of type t are returned, the CNAMEs followed to reach them are left out.
+func query(ctx context.Context, server string, name string, t dnsmessage.Type) (answer, error) {
+	fqdn, err := dnsmessage.NewName(dnsName(name))
+	if err != nil {
+		return answer{}, fmt.Errorf("invalid name '%s': %w", name, err)
+	}
+	id := uint16(rand.Uint32())
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	builder.EnableCompression()
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(udpSize, dnsmessage.RCodeSuccess, false); err != nil {
+		return answer{}, err
+	}
+	if err := builder.StartQuestions(); err != nil {
+		return answer{}, err
+	}
+	if err := builder.Question(dnsmessage.Question{Name: fqdn, Type: t, Class: dnsmessage.ClassINET}); err != nil {
+		return answer{}, err
+	}
+	if err := builder.StartAdditionals(); err != nil {
+		return answer{}, err
+	}
+	if err := builder.OPTResource(opt, dnsmessage.OPTResource{}); err != nil {
+		return answer{}, err
+	}
+	msg, err := builder.Finish()
+	if err != nil {
+		return answer{}, err
+	}
+
+	start := time.Now()
+	reply, err := exchange(ctx, "udp", server, msg, id)
+	if err == nil && reply.Truncated {
+		reply, err = exchange(ctx, "tcp", server, msg, id)
+	}
+	if err != nil {
+		return answer{}, err
+	}
+	a := answer{Rcode: reply.RCode, RTT: time.Since(start)}
+	for _, rr := range reply.Answers {
+		if rr.Header.Type != t {
+			continue
+		}
+		a.Records = append(a.Records, record{Value: formatRecord(rr.Body), TTL: rr.Header.TTL})
+	}
+	slices.SortFunc(a.Records, func(x, y record) int { return strings.Compare(x.Value, y.Value) })
+	return a, nil
+}
+
+// exchange sends msg over network and waits for the reply carrying id
+func exchange(ctx context.Context, network string, server string, msg []byte, id uint16) (*dnsmessage.Message, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	if network == "tcp" {
+		framed := binary.BigEndian.AppendUint16(nil, uint16(len(msg)))
+		if _, err := conn.Write(append(framed, msg...)); err != nil {
+			return nil, err
+		}
+		var size [2]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, binary.BigEndian.Uint16(size[:]))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return nil, err
+		}
+		return parseReply(buf, id)
+	}
+
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil && errors.Is(err, os.ErrDeadlineExceeded) {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+		// A stray datagram, a late answer to an earlier query, is skipped
+		if reply, err := parseReply(buf[:n], id); err == nil {
+			return reply, nil
+		}
+	}
+}
+
+func parseReply(data []byte, id uint16) (*dnsmessage.Message, error) {
+	var m dnsmessage.Message
+	if err := m.Unpack(data); err != nil {
+		return nil, fmt.Errorf("invalid reply: %w", err)
+	}
+	if m.ID != id || !m.Response {
+		return nil, fmt.Errorf("reply does not match the query")
+	}
+	return &m, nil
+}
+
+// formatRecord renders the data of a record like dig +short
+func formatRecord(body dnsmessage.ResourceBody) string {
+	switch b := body.(type) {
+	case *dnsmessage.AResource:
+		return net.IP(b.A[:]).String()
+	case *dnsmessage.AAAAResource:
+		return net.IP(b.AAAA[:]).String()
+	case *dnsmessage.CNAMEResource:
+		return b.CNAME.String()
+	case *dnsmessage.NSResource:
+		return b.NS.String()
+	case *dnsmessage.MXResource:
+		return fmt.Sprintf("%d %s", b.Pref, b.MX)
+	case *dnsmessage.TXTResource:
+		quoted := make([]string, len(b.TXT))
+		for i, s := range b.TXT {
+			quoted[i] = strconv.Quote(s)
+		}
+		return strings.Join(quoted, " ")
+	case *dnsmessage.UnknownResource:
+		if b.Type == typeCAA {
+			return formatCAA(b.Data)
+		}
+		return fmt.Sprintf("\\# %d %x", len(b.Data), b.Data)
+	}
+	return body.GoString()
+}
+
+// formatCAA renders the flags, tag and value of a CAA record (RFC 8659)
+func formatCAA(data []byte) string {
+	if len(data) < 2 || len(data) < 2+int(data[1]) {
+		return fmt.Sprintf("\\# %d %x", len(data), data)
+	}
+	flags, tagLen := data[0], int(data[1])
+	return fmt.Sprintf("%d %s %s", flags, data[2:2+tagLen], strconv.Quote(string(data[2+tagLen:])))
+}
+
+// dnsName makes name fully qualified
+func dnsName(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+// parentName drops the first label of name, "" once the root is reached
+func parentName(name string) string {
+	name = strings.TrimSuffix(name, ".")
+	_, parent, ok := strings.Cut(name, ".")
+	if !ok {
+		return ""
+	}
+	return parent
+}
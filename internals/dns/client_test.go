@@ -0,0 +1,297 @@
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fakeDNS is a DNS server on a local UDP and TCP port answering from a fixed zone
+type fakeDNS struct {
+	Addr string
+	// Zone holds the answers by "name TYPE", names fully qualified
+	Zone map[string][]dnsmessage.Resource
+	// RCodes overrides the response code by "name TYPE"
+	RCodes map[string]dnsmessage.RCode
+	// TruncateUDP answers UDP with the TC bit and no records, as servers do for replies too large for it
+	TruncateUDP bool
+	// Stray sends a reply with another ID before each UDP answer, as a late reply to an earlier query looks
+	Stray bool
+	// Silent never answers
+	Silent bool
+
+	udpQueries, tcpQueries atomic.Int32
+}
+
+// startFakeDNS serves zone on the same port over UDP and TCP until the end of the test
+func startFakeDNS(t *testing.T, s *fakeDNS) *fakeDNS {
+	t.Helper()
+	var pc net.PacketConn
+	var l net.Listener
+	for attempt := 0; l == nil; attempt++ {
+		var err error
+		if pc, err = net.ListenPacket("udp", "127.0.0.1:0"); err != nil {
+			t.Fatal(err)
+		}
+		if l, err = net.Listen("tcp", pc.LocalAddr().String()); err != nil {
+			pc.Close()
+			if attempt == 10 {
+				t.Fatal(err)
+			}
+		}
+	}
+	t.Cleanup(func() {
+		pc.Close()
+		l.Close()
+	})
+	s.Addr = pc.LocalAddr().String()
+
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			s.udpQueries.Add(1)
+			reply, ok := s.reply(buf[:n], true)
+			if !ok {
+				continue
+			}
+			if s.Stray {
+				stray := slices.Clone(reply)
+				binary.BigEndian.PutUint16(stray, binary.BigEndian.Uint16(stray)+1)
+				pc.WriteTo(stray, from)
+			}
+			pc.WriteTo(reply, from)
+		}
+	}()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var size [2]byte
+				if _, err := io.ReadFull(conn, size[:]); err != nil {
+					return
+				}
+				msg := make([]byte, binary.BigEndian.Uint16(size[:]))
+				if _, err := io.ReadFull(conn, msg); err != nil {
+					return
+				}
+				s.tcpQueries.Add(1)
+				if reply, ok := s.reply(msg, false); ok {
+					conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(reply))), reply...))
+				}
+			}()
+		}
+	}()
+	return s
+}
+
+func (s *fakeDNS) reply(query []byte, udp bool) ([]byte, bool) {
+	var q dnsmessage.Message
+	if s.Silent || q.Unpack(query) != nil || len(q.Questions) != 1 {
+		return nil, false
+	}
+	question := q.Questions[0]
+	key := question.Name.String() + " " + typeName(question.Type)
+	reply := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: q.ID, Response: true, RecursionDesired: q.RecursionDesired, RCode: s.RCodes[key]},
+		Questions: q.Questions,
+		// Packing sets the type and length of the headers, every reply packs copies
+		Answers: slices.Clone(s.Zone[key]),
+	}
+	if udp && s.TruncateUDP {
+		reply.Truncated, reply.Answers = true, nil
+	}
+	data, err := reply.Pack()
+	return data, err == nil
+}
+
+// rr returns a resource record of name with body
+func rr(name string, ttl uint32, body dnsmessage.ResourceBody) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Class: dnsmessage.ClassINET, TTL: ttl},
+		Body:   body,
+	}
+}
+
+// caa returns the data of a CAA record
+func caa(flags byte, tag string, value string) *dnsmessage.UnknownResource {
+	data := append([]byte{flags, byte(len(tag))}, tag...)
+	return &dnsmessage.UnknownResource{Type: typeCAA, Data: append(data, value...)}
+}
+
+// exampleZone answers for example.com and www.example.com, a CNAME to it
+var exampleZone = map[string][]dnsmessage.Resource{
+	"example.com. A": {
+		rr("example.com.", 300, &dnsmessage.AResource{A: [4]byte{93, 184, 216, 34}}),
+		rr("example.com.", 120, &dnsmessage.AResource{A: [4]byte{10, 0, 0, 1}}),
+	},
+	"example.com. AAAA": {
+		rr("example.com.", 300, &dnsmessage.AAAAResource{AAAA: [16]byte{0x26, 0x06, 0x28, 0x00, 0x02, 0x20, 0, 1, 0x2, 0x48, 0x18, 0x93, 0x25, 0xc8, 0x19, 0x46}}),
+	},
+	"www.example.com. A": {
+		rr("www.example.com.", 60, &dnsmessage.CNAMEResource{CNAME: dnsmessage.MustNewName("example.com.")}),
+		rr("example.com.", 300, &dnsmessage.AResource{A: [4]byte{93, 184, 216, 34}}),
+	},
+	"www.example.com. CNAME": {
+		rr("www.example.com.", 60, &dnsmessage.CNAMEResource{CNAME: dnsmessage.MustNewName("example.com.")}),
+	},
+	"example.com. MX": {
+		rr("example.com.", 3600, &dnsmessage.MXResource{Pref: 20, MX: dnsmessage.MustNewName("mx2.example.com.")}),
+		rr("example.com.", 3600, &dnsmessage.MXResource{Pref: 10, MX: dnsmessage.MustNewName("mx1.example.com.")}),
+	},
+	"example.com. TXT": {
+		rr("example.com.", 300, &dnsmessage.TXTResource{TXT: []string{"v=spf1 -all"}}),
+		rr("example.com.", 300, &dnsmessage.TXTResource{TXT: []string{"part one ", `"quoted" part`}}),
+	},
+	"example.com. CAA": {
+		rr("example.com.", 300, caa(0, "issue", "letsencrypt.org")),
+		rr("example.com.", 300, caa(128, "iodef", "mailto:sec@example.com")),
+	},
+	"example.com. NS": {
+		rr("example.com.", 86400, &dnsmessage.NSResource{NS: dnsmessage.MustNewName("a.iana-servers.net.")}),
+	},
+}
+
+func values(records []record) []string {
+	v := make([]string, len(records))
+	for i, r := range records {
+		v[i] = r.Value
+	}
+	return v
+}
+
+func TestQueryFormatsRecordsLikeDig(t *testing.T) {
+	s := startFakeDNS(t, &fakeDNS{Zone: exampleZone})
+	tests := []struct {
+		name string
+		typ  string
+		want []string
+	}{
+		{"example.com", "A", []string{"10.0.0.1", "93.184.216.34"}},
+		{"example.com", "AAAA", []string{"2606:2800:220:1:248:1893:25c8:1946"}},
+		// The CNAME followed to reach the A record is left out
+		{"www.example.com", "A", []string{"93.184.216.34"}},
+		{"www.example.com.", "CNAME", []string{"example.com."}},
+		{"example.com", "MX", []string{"10 mx1.example.com.", "20 mx2.example.com."}},
+		{"example.com", "TXT", []string{`"part one " "\"quoted\" part"`, `"v=spf1 -all"`}},
+		{"example.com", "CAA", []string{`0 issue "letsencrypt.org"`, `128 iodef "mailto:sec@example.com"`}},
+		{"example.com", "NS", []string{"a.iana-servers.net."}},
+		{"missing.example.com", "A", nil},
+	}
+	for _, test := range tests {
+		typ, err := parseType(test.typ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, err := query(context.Background(), s.Addr, test.name, typ)
+		if err != nil || a.Rcode != dnsmessage.RCodeSuccess || !slices.Equal(values(a.Records), test.want) {
+			t.Errorf("%s %s = %q, %v, %v\nwant %q", test.name, test.typ, values(a.Records), a.Rcode, err, test.want)
+		}
+	}
+
+	a, err := query(context.Background(), s.Addr, "example.com", dnsmessage.TypeA)
+	if err != nil || a.Records[0].TTL != 120 || a.Records[1].TTL != 300 {
+		t.Errorf("TTLs = %+v, %v", a.Records, err)
+	}
+	if s.tcpQueries.Load() != 0 {
+		t.Errorf("%d queries went over TCP without truncation", s.tcpQueries.Load())
+	}
+}
+
+func TestQueryRetriesTruncatedRepliesOverTCP(t *testing.T) {
+	s := startFakeDNS(t, &fakeDNS{Zone: exampleZone, TruncateUDP: true})
+	a, err := query(context.Background(), s.Addr, "example.com", dnsmessage.TypeA)
+	if err != nil || !slices.Equal(values(a.Records), []string{"10.0.0.1", "93.184.216.34"}) {
+		t.Errorf("query = %q, %v", values(a.Records), err)
+	}
+	if s.udpQueries.Load() != 1 || s.tcpQueries.Load() != 1 {
+		t.Errorf("%d UDP and %d TCP queries, want one of each", s.udpQueries.Load(), s.tcpQueries.Load())
+	}
+}
+
+func TestQuerySkipsStrayReplies(t *testing.T) {
+	s := startFakeDNS(t, &fakeDNS{Zone: exampleZone, Stray: true})
+	a, err := query(context.Background(), s.Addr, "example.com", dnsmessage.TypeMX)
+	if err != nil || len(a.Records) != 2 {
+		t.Errorf("query = %+v, %v", a, err)
+	}
+}
+
+func TestQueryRcodesAndTimeouts(t *testing.T) {
+	s := startFakeDNS(t, &fakeDNS{Zone: exampleZone, RCodes: map[string]dnsmessage.RCode{
+		"nope.example.com. A":      dnsmessage.RCodeNameError,
+		"broken.example.com. A":    dnsmessage.RCodeServerFailure,
+		"private.example.com. TXT": dnsmessage.RCodeRefused,
+	}})
+	for name, want := range map[string]string{"nope.example.com": "NXDOMAIN", "broken.example.com": "SERVFAIL"} {
+		a, err := query(context.Background(), s.Addr, name, dnsmessage.TypeA)
+		if err != nil || rcodeName(a.Rcode) != want || len(a.Records) != 0 {
+			t.Errorf("%s = %s %+v, %v, want %s", name, rcodeName(a.Rcode), a.Records, err, want)
+		}
+	}
+	if a, _ := query(context.Background(), s.Addr, "private.example.com", dnsmessage.TypeTXT); rcodeName(a.Rcode) != "REFUSED" {
+		t.Errorf("private.example.com TXT = %s", rcodeName(a.Rcode))
+	}
+
+	// A server that never answers, the deadline of the context ends the wait. The socket shares the deadline, so
+	// its timeout can be seen first.
+	silent := startFakeDNS(t, &fakeDNS{Silent: true})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := query(ctx, silent.Addr, "example.com", dnsmessage.TypeA); !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("query of a silent server = %v, want the deadline", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("query returned after %s", elapsed)
+	}
+
+	if _, err := query(context.Background(), s.Addr, strings.Repeat("a.", 128)+"com", dnsmessage.TypeA); err == nil || !strings.HasPrefix(err.Error(), "invalid name '") {
+		t.Errorf("query of a name over 255 bytes = %v", err)
+	}
+}
+
+func TestFormatCAA(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want string
+	}{
+		{caa(0, "issuewild", ";").Data, `0 issuewild ";"`},
+		{caa(0, "issue", "").Data, `0 issue ""`},
+		// Malformed records are shown as RFC 3597 generic data
+		{[]byte{0}, `\# 1 00`},
+		{[]byte{0, 9, 'i'}, `\# 3 000969`},
+	}
+	for _, test := range tests {
+		if got := formatCAA(test.data); got != test.want {
+			t.Errorf("formatCAA(%x) = %s, want %s", test.data, got, test.want)
+		}
+	}
+}
+
+func TestParentName(t *testing.T) {
+	var chain []string
+	for name := "a.b.example.com."; name != ""; name = parentName(name) {
+		chain = append(chain, name)
+	}
+	if want := []string{"a.b.example.com.", "b.example.com", "example.com", "com"}; !slices.Equal(chain, want) {
+		t.Errorf("chain = %q, want %q", chain, want)
+	}
+}
@@ -0,0 +1,308 @@
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/output"
+	"gsn-dev-tools/internals/redact"
+	"gsn-dev-tools/internals/style"
+	"gsn-dev-tools/internals/units"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// queryTimeout bounds a single question to a single server
+const queryTimeout = 5 * time.Second
+
+// defaultTypes are queried when no type is given
+var defaultTypes = []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA, dnsmessage.TypeCNAME, dnsmessage.TypeMX, dnsmessage.TypeTXT, typeCAA}
+
+// result is the answer of one server for one record type
+type result struct {
+	Type    string        `json:"type"`
+	Server  string        `json:"server"`
+	Address string        `json:"address"`
+	Status  string        `json:"status"`
+	Records []record      `json:"records"`
+	From    string        `json:"from,omitempty"`
+	RTT     time.Duration `json:"rtt_ns"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// key is what must match between servers for them to agree, TTLs count down and are left out
+func (r result) key() string {
+	if r.Error != "" {
+		return "error"
+	}
+	values := make([]string, len(r.Records))
+	for i, rec := range r.Records {
+		values[i] = rec.Value
+	}
+	return r.Status + "|" + r.From + "|" + strings.Join(values, "\n")
+}
+
+func (r result) answer() string {
+	if r.Error != "" {
+		return r.Error
+	}
+	values := make([]string, len(r.Records))
+	for i, rec := range r.Records {
+		values[i] = rec.Value
+	}
+	text := strings.Join(values, ", ")
+	if r.From != "" {
+		text += " (from " + r.From + ")"
+	}
+	return text
+}
+
+func (r result) minTTL() int64 {
+	if len(r.Records) == 0 {
+		return -1
+	}
+	ttl := r.Records[0].TTL
+	for _, rec := range r.Records[1:] {
+		ttl = min(ttl, rec.TTL)
+	}
+	return int64(ttl)
+}
+
+// dnsColumns declares the columns available to `dns`
+var dnsColumns = []output.Column[result]{
+	{Name: "type", Value: func(r result) any { return r.Type }},
+	{Name: "server", Value: func(r result) any { return r.Server }},
+	{Name: "status", Value: func(r result) any { return r.Status }},
+	{Name: "answer", Value: func(r result) any { return r.answer() }},
+	{Name: "ttl", Value: func(r result) any { return r.minTTL() }, Display: func(r result) string {
+		if r.minTTL() < 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%ds", r.minTTL())
+	}},
+	{Name: "time", Value: func(r result) any { return r.RTT }, Display: func(r result) string { return units.FormatDuration(r.RTT) }},
+}
+
+func DNSCmd() *cobra.Command {
+	dnsCmd := &cobra.Command{
+		Use:   "dns <name> [type]",
+		Short: "Looks up DNS records and compares them across resolvers",
+		Long: `Queries the A, AAAA, CNAME, MX, TXT and CAA records of a name, or only those of type (NS too), and prints
+them per server. Without --ns the system resolver, the first nameserver of /etc/resolv.conf, is asked; --ns
+takes a comma separated list of IPs, host names, system and authoritative, which stands for every nameserver of
+the zone of the name. All servers are asked concurrently, and a type on which they do not return the same
+records, TTLs aside, is reported as a disagreement: that is propagation lag after a change.
+
+When a name has no CAA record the parents are searched like a certificate authority does (RFC 8659), and the
+name holding the records is shown with "from". --watch asks again every --interval until all servers agree or
+--timeout expires.
+
+Exits with 0 when the servers agree, 1 when they do not or a server failed, 124 on timeout with --watch.`,
+		Example: `  gsn dns example.com
+  gsn dns example.com CAA --ns 1.1.1.1,8.8.8.8,authoritative
+  gsn dns api.example.com A --ns system,authoritative --watch --timeout 10m
+  gsn dns example.com TXT --json`,
+		Args: cobra.RangeArgs(1, 2),
+		Run:  LookupDNS,
+	}
+
+	dnsCmd.Flags().StringSlice("ns", []string{"system"}, "Servers to ask: IPs, host names, system or authoritative")
+	dnsCmd.Flags().Bool("watch", false, "Ask again until all servers agree")
+	dnsCmd.Flags().Duration("interval", 5*time.Second, "Time between two rounds with --watch")
+	dnsCmd.Flags().Duration("timeout", 5*time.Minute, "Give up watching after this long")
+	dnsCmd.Flags().Bool("json", false, "Print the answers and the agreement per type as JSON")
+	output.AddFlags(dnsCmd)
+	dryrun.ReadOnly(dnsCmd)
+	return dnsCmd
+}
+
+func LookupDNS(cmd *cobra.Command, args []string) {
+	specs, _ := cmd.Flags().GetStringSlice("ns")
+	watch, _ := cmd.Flags().GetBool("watch")
+	interval, _ := cmd.Flags().GetDuration("interval")
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+	asJSON, _ := cmd.Flags().GetBool("json")
+
+	name := strings.TrimSuffix(args[0], ".")
+	types := defaultTypes
+	if len(args) == 2 {
+		t, err := parseType(args[1])
+		if err != nil {
+			clierr.Exitf(clierr.Usage, "%v", err)
+		}
+		types = []dnsmessage.Type{t}
+	}
+	opts, err := output.OptionsFromFlags(cmd)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	if asJSON && opts.Format != output.FormatTable {
+		clierr.Exitf(clierr.Usage, "--json cannot be combined with --csv or --tsv")
+	}
+	if watch && interval <= 0 {
+		clierr.Exitf(clierr.Usage, "--interval must be positive")
+	}
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if watch && timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	servers, err := resolveServers(ctx, specs, name)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+
+	start := time.Now()
+	results := lookupAll(ctx, servers, name, types)
+	disagree := disagreements(results)
+	for watch && len(disagree) > 0 && ctx.Err() == nil {
+		fmt.Fprintf(os.Stderr, "%s: servers disagree on %s, asking again in %s\n", units.FormatDuration(time.Since(start)), strings.Join(disagree, ", "), interval)
+		select {
+		case <-ctx.Done():
+		case <-time.After(interval):
+			// A round cut short by the timeout would only show errors, the previous one is kept
+			if next := lookupAll(ctx, servers, name, types); ctx.Err() == nil {
+				results, disagree = next, disagreements(next)
+			}
+		}
+	}
+
+	if asJSON {
+		agree := make(map[string]bool, len(types))
+		for _, t := range types {
+			agree[typeName(t)] = true
+		}
+		for _, t := range disagree {
+			agree[t] = false
+		}
+		data, err := json.MarshalIndent(struct {
+			Name    string          `json:"name"`
+			Results []result        `json:"results"`
+			Agree   map[string]bool `json:"agree"`
+		}{name, results, agree}, "", "  ")
+		if err != nil {
+			clierr.Fatalf("%v", err)
+		}
+		redact.JSONOutput()
+		fmt.Println(string(data))
+	} else {
+		if err := output.Render(os.Stdout, dnsColumns, results, opts); err != nil {
+			clierr.Fatalf("%v", err)
+		}
+	}
+
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		clierr.Exitf(clierr.Timeout, "Servers still disagree on %s after %s", strings.Join(disagree, ", "), timeout)
+	case ctx.Err() != nil:
+		clierr.Exitf(clierr.Interrupted, "Interrupted")
+	case len(disagree) > 0:
+		if len(servers) == 1 {
+			clierr.Exitf(clierr.Failure, "%s failed to answer %s", servers[0].Name, strings.Join(disagree, ", "))
+		}
+		clierr.Exitf(clierr.Failure, "Servers disagree on %s", strings.Join(disagree, ", "))
+	}
+	if !asJSON && opts.Format == output.FormatTable && len(servers) > 1 {
+		fmt.Printf("\n"+style.Success()+"All %d servers agree\n", len(servers))
+	}
+}
+
+// lookupAll asks every server for every type concurrently, results are ordered by type then server
+func lookupAll(ctx context.Context, servers []server, name string, types []dnsmessage.Type) []result {
+	results := make([]result, len(types)*len(servers))
+	var wg sync.WaitGroup
+	for i, t := range types {
+		for j, s := range servers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results[i*len(servers)+j] = lookup(ctx, s, name, t)
+			}()
+		}
+	}
+	wg.Wait()
+	return results
+}
+
+// lookup asks one server for one type. CAA records are searched in the parents of name when it has none.
+func lookup(ctx context.Context, s server, name string, t dnsmessage.Type) result {
+	r := result{Type: typeName(t), Server: s.Name, Address: s.Addr}
+	for qname := name; qname != ""; qname = parentName(qname) {
+		qctx, cancel := context.WithTimeout(ctx, queryTimeout)
+		a, err := query(qctx, s.Addr, qname, t)
+		cancel()
+		r.RTT += a.RTT
+		if err != nil {
+			// The socket addresses of a dial or read error repeat the server column
+			var opErr *net.OpError
+			if errors.As(err, &opErr) {
+				err = opErr.Err
+			}
+			r.Status = "error"
+			r.Error = err.Error()
+			return r
+		}
+		r.Status = rcodeName(a.Rcode)
+		r.Records = a.Records
+		empty := len(a.Records) == 0 && (a.Rcode == dnsmessage.RCodeSuccess || a.Rcode == dnsmessage.RCodeNameError)
+		if t != typeCAA || !empty {
+			if qname != name {
+				r.From = qname
+			}
+			return r
+		}
+	}
+	return r
+}
+
+// disagreements lists the types on which the servers returned different answers, or some failed
+func disagreements(results []result) []string {
+	var types []string
+	keys := make(map[string]string)
+	for _, r := range results {
+		key := r.key()
+		if first, ok := keys[r.Type]; ok && first != key || key == "error" {
+			if len(types) == 0 || types[len(types)-1] != r.Type {
+				types = append(types, r.Type)
+			}
+		}
+		if _, ok := keys[r.Type]; !ok {
+			keys[r.Type] = key
+		}
+	}
+	return types
+}
+
+func rcodeName(code dnsmessage.RCode) string {
+	switch code {
+	case dnsmessage.RCodeSuccess:
+		return "NOERROR"
+	case dnsmessage.RCodeFormatError:
+		return "FORMERR"
+	case dnsmessage.RCodeServerFailure:
+		return "SERVFAIL"
+	case dnsmessage.RCodeNameError:
+		return "NXDOMAIN"
+	case dnsmessage.RCodeNotImplemented:
+		return "NOTIMP"
+	case dnsmessage.RCodeRefused:
+		return "REFUSED"
+	}
+	return code.String()
+}
@@ -0,0 +1,93 @@
+package dns
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestLookupSearchesCAAInTheParents(t *testing.T) {
+	s := startFakeDNS(t, &fakeDNS{Zone: exampleZone})
+	srv := server{Name: "fake", Addr: s.Addr}
+
+	r := lookup(context.Background(), srv, "deep.www.example.com", typeCAA)
+	if r.Status != "NOERROR" || r.From != "example.com" || r.answer() != `0 issue "letsencrypt.org", 128 iodef "mailto:sec@example.com" (from example.com)` {
+		t.Errorf("CAA of deep.www.example.com = %+v", r)
+	}
+	if r.minTTL() != 300 {
+		t.Errorf("min TTL = %d", r.minTTL())
+	}
+
+	// Other types are not searched upwards
+	r = lookup(context.Background(), srv, "deep.www.example.com", dnsmessage.TypeA)
+	if r.From != "" || len(r.Records) != 0 || r.minTTL() != -1 {
+		t.Errorf("A of deep.www.example.com = %+v", r)
+	}
+
+	// A zone without any CAA up to the root ends with no records
+	r = lookup(context.Background(), srv, "example.org", typeCAA)
+	if r.Status != "NOERROR" || len(r.Records) != 0 {
+		t.Errorf("CAA of example.org = %+v", r)
+	}
+}
+
+func TestLookupAllAndDisagreements(t *testing.T) {
+	a := startFakeDNS(t, &fakeDNS{Zone: exampleZone})
+	b := startFakeDNS(t, &fakeDNS{Zone: exampleZone, TruncateUDP: true})
+	// A third server answering an older address with another TTL, and failing MX
+	stale := map[string][]dnsmessage.Resource{}
+	for key, answers := range exampleZone {
+		stale[key] = answers
+	}
+	stale["example.com. A"] = []dnsmessage.Resource{rr("example.com.", 5, &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}})}
+	c := startFakeDNS(t, &fakeDNS{Zone: stale, RCodes: map[string]dnsmessage.RCode{"example.com. MX": dnsmessage.RCodeServerFailure}})
+
+	servers := []server{{Name: "a", Addr: a.Addr}, {Name: "b", Addr: b.Addr}}
+	types := []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeMX, dnsmessage.TypeTXT}
+	results := lookupAll(context.Background(), servers, "example.com", types)
+
+	// Ordered by type then server, whichever answered first
+	var order []string
+	for _, r := range results {
+		order = append(order, r.Type+"/"+r.Server)
+	}
+	if want := []string{"A/a", "A/b", "MX/a", "MX/b", "TXT/a", "TXT/b"}; !slices.Equal(order, want) {
+		t.Errorf("order = %q, want %q", order, want)
+	}
+	if types := disagreements(results); len(types) != 0 {
+		t.Errorf("the same zone over UDP and TCP disagrees on %q", types)
+	}
+
+	servers = append(servers, server{Name: "c", Addr: c.Addr})
+	results = lookupAll(context.Background(), servers, "example.com", types)
+	if got := disagreements(results); !slices.Equal(got, []string{"A", "MX"}) {
+		t.Errorf("disagreements = %q, want A and MX", got)
+	}
+
+	// A server that does not answer disagrees on every type, with the error of its own, shorn of the socket addresses
+	silent := startFakeDNS(t, &fakeDNS{Silent: true})
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	results = lookupAll(ctx, []server{{Name: "a", Addr: a.Addr}, {Name: "silent", Addr: silent.Addr}}, "example.com", types[:2])
+	if got := disagreements(results); !slices.Equal(got, []string{"A", "MX"}) {
+		t.Errorf("disagreements with a silent server = %q", got)
+	}
+	if r := results[1]; r.Status != "error" || !strings.Contains(r.answer(), "deadline exceeded") && r.answer() != "i/o timeout" || r.minTTL() != -1 {
+		t.Errorf("result of the silent server = %+v", r)
+	}
+}
+
+func TestResolveServersTakesIPsAndPorts(t *testing.T) {
+	servers, err := resolveServers(context.Background(), []string{"1.1.1.1", " 127.0.0.1:5353 ", "2001:db8::1", "[2001:db8::1]:53", "1.1.1.1"}, "example.com")
+	want := []server{{"1.1.1.1", "1.1.1.1:53"}, {"127.0.0.1:5353", "127.0.0.1:5353"}, {"2001:db8::1", "[2001:db8::1]:53"}}
+	if err != nil || !slices.Equal(servers, want) {
+		t.Errorf("resolveServers = %v, %v\nwant %v", servers, err, want)
+	}
+	if _, err := resolveServers(context.Background(), []string{"not a host!"}, "example.com"); err == nil || !strings.HasPrefix(err.Error(), "invalid --ns 'not a host!': ") {
+		t.Errorf("resolveServers of an invalid host = %v", err)
+	}
+}
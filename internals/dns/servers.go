@@ -0,0 +1,140 @@
+package dns
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// resolvConf lists the nameservers of the system resolver
+const resolvConf = "/etc/resolv.conf"
+
+// server is a DNS server to ask, Name is what the table shows
+type server struct {
+	Name string
+	Addr string
+}
+
+// systemServer returns the first nameserver of resolv.conf, the one the system resolver asks first
+func systemServer() (server, error) {
+	file, err := os.Open(resolvConf)
+	if err != nil {
+		return server{}, fmt.Errorf("cannot find the system resolver: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			// A scoped IPv6 address like fe80::1%eth0 is dialed as is
+			return server{Name: "system", Addr: net.JoinHostPort(fields[1], "53")}, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return server{}, err
+	}
+	return server{}, fmt.Errorf("no nameserver in %s", resolvConf)
+}
+
+// resolveServers turns the --ns values into servers: system, authoritative for the nameservers of the zone of
+// name, an IP with an optional port, or a host name
+func resolveServers(ctx context.Context, specs []string, name string) ([]server, error) {
+	var servers []server
+	add := func(s server) {
+		if !slices.ContainsFunc(servers, func(other server) bool { return other.Addr == s.Addr }) {
+			servers = append(servers, s)
+		}
+	}
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		switch {
+		case spec == "system":
+			s, err := systemServer()
+			if err != nil {
+				return nil, err
+			}
+			add(s)
+		case spec == "authoritative":
+			auth, err := authoritativeServers(ctx, name)
+			if err != nil {
+				return nil, err
+			}
+			for _, s := range auth {
+				add(s)
+			}
+		default:
+			if addr, err := netip.ParseAddr(spec); err == nil {
+				add(server{Name: spec, Addr: net.JoinHostPort(addr.String(), "53")})
+				continue
+			}
+			if addrPort, err := netip.ParseAddrPort(spec); err == nil {
+				add(server{Name: spec, Addr: addrPort.String()})
+				continue
+			}
+			addr, err := lookupHost(ctx, spec)
+			if err != nil {
+				return nil, fmt.Errorf("invalid --ns '%s': %w", spec, err)
+			}
+			add(server{Name: spec, Addr: net.JoinHostPort(addr, "53")})
+		}
+	}
+	return servers, nil
+}
+
+// authoritativeServers finds the nameservers of the zone holding name, asking the system resolver for the NS
+// records of name and then of each parent until some are found
+func authoritativeServers(ctx context.Context, name string) ([]server, error) {
+	sys, err := systemServer()
+	if err != nil {
+		return nil, err
+	}
+	for zone := name; zone != ""; zone = parentName(zone) {
+		a, err := query(ctx, sys.Addr, zone, dnsmessage.TypeNS)
+		if err != nil {
+			return nil, fmt.Errorf("cannot find the nameservers of '%s': %w", name, err)
+		}
+		if len(a.Records) == 0 {
+			continue
+		}
+		var servers []server
+		for _, r := range a.Records {
+			host := strings.TrimSuffix(r.Value, ".")
+			addr, err := lookupHost(ctx, host)
+			if err != nil {
+				return nil, fmt.Errorf("cannot resolve nameserver '%s': %w", host, err)
+			}
+			servers = append(servers, server{Name: host, Addr: net.JoinHostPort(addr, "53")})
+		}
+		return servers, nil
+	}
+	return nil, fmt.Errorf("no nameserver found for '%s'", name)
+}
+
+// lookupHost returns an address of host, IPv4 first since it works on more networks
+func lookupHost(ctx context.Context, host string) (string, error) {
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return "", err
+	}
+	if len(addrs) == 0 {
+		return "", fmt.Errorf("no address for '%s'", host)
+	}
+	slices.SortStableFunc(addrs, func(a, b netip.Addr) int {
+		switch {
+		case a.Unmap().Is4() == b.Unmap().Is4():
+			return 0
+		case a.Unmap().Is4():
+			return -1
+		}
+		return 1
+	})
+	return addrs[0].Unmap().String(), nil
+}
@@ -0,0 +1,125 @@
+package docs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/style"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/cobra/doc"
+)
+
+// releaseDate is printed in every man page so regenerating the docs does not change them.
+// $SOURCE_DATE_EPOCH overrides it for packaging.
+var releaseDate = time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func DocsCmd() *cobra.Command {
+	docsCmd := &cobra.Command{
+		Use:   "docs",
+		Short: "Generates man pages or markdown reference docs for every command",
+		Long: `Walks the whole command tree and writes one man page (section 1) or markdown file per command into the output
+directory, including each command's examples. The output is deterministic so it can be committed or packaged.
+With --check nothing is written; it fails when a command is missing its short or long description or an example.`,
+		Example: `  gsn docs --format man -o ./docs/man
+  gsn docs --format markdown -o ./docs
+  gsn docs --check`,
+		Args: cobra.NoArgs,
+		Run:  GenerateDocs,
+	}
+
+	docsCmd.Flags().String("format", "markdown", "Output format: man or markdown")
+	docsCmd.Flags().StringP("output", "o", "docs", "Directory the files are written to")
+	docsCmd.Flags().Bool("check", false, "Only verify that every command is documented")
+	return docsCmd
+}
+
+func GenerateDocs(cmd *cobra.Command, args []string) {
+	format, _ := cmd.Flags().GetString("format")
+	outputDir, _ := cmd.Flags().GetString("output")
+	check, _ := cmd.Flags().GetBool("check")
+
+	root := cmd.Root()
+	if check {
+		problems := Undocumented(root)
+		if len(problems) > 0 {
+			for _, p := range problems {
+				fmt.Fprintln(os.Stderr, "  "+p)
+			}
+			clierr.Fatalf("%d documentation problem(s)", len(problems))
+		}
+		fmt.Println(style.Success() + "Every command is documented")
+		return
+	}
+
+	if err := os.MkdirAll(outputDir, 0o755); err != nil {
+		clierr.Fatalf("%v", err)
+	}
+
+	// The generation date footer would differ on every run
+	root.DisableAutoGenTag = true
+
+	var err error
+	switch format {
+	case "man":
+		date := sourceDate()
+		err = doc.GenManTree(root, &doc.GenManHeader{Title: strings.ToUpper(root.Name()), Section: "1", Date: &date, Source: root.Name()}, outputDir)
+	case "markdown", "md":
+		err = doc.GenMarkdownTree(root, outputDir)
+	default:
+		err = clierr.Newf(clierr.Usage, "unknown format '%s', use man or markdown", format)
+	}
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(outputDir, root.Name()+"*"))
+	fmt.Printf(style.Success()+"Wrote %d %s file(s) to %s\n", len(files), format, outputDir)
+}
+
+// Undocumented lists every available command missing a short or long description or an example
+func Undocumented(root *cobra.Command) []string {
+	var problems []string
+	var walk func(c *cobra.Command)
+	walk = func(c *cobra.Command) {
+		if (!c.IsAvailableCommand() && c != root) || (c.Parent() == root && c.Name() == "completion") {
+			// cobra documents its generated completion commands itself
+			return
+		}
+		var missing []string
+		if strings.TrimSpace(c.Short) == "" {
+			missing = append(missing, "Short")
+		}
+		if strings.TrimSpace(c.Long) == "" {
+			missing = append(missing, "Long")
+		}
+		if strings.TrimSpace(c.Example) == "" {
+			missing = append(missing, "Example")
+		}
+		if len(missing) > 0 {
+			problems = append(problems, fmt.Sprintf("%s: missing %s", c.CommandPath(), strings.Join(missing, ", ")))
+		}
+		for _, sub := range c.Commands() {
+			walk(sub)
+		}
+	}
+	walk(root)
+	sort.Strings(problems)
+	return problems
+}
+
+// sourceDate honors $SOURCE_DATE_EPOCH for reproducible builds and falls back to releaseDate
+func sourceDate() time.Time {
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		var seconds int64
+		if _, err := fmt.Sscan(epoch, &seconds); err == nil {
+			return time.Unix(seconds, 0).UTC()
+		}
+	}
+	return releaseDate
+}
@@ -0,0 +1,304 @@
+package dotenv
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/diffout"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/output"
+	"gsn-dev-tools/internals/style"
+
+	"github.com/spf13/cobra"
+)
+
+// encryptedSuffix is appended to the name of an encrypted env file when -o is not given
+const encryptedSuffix = ".age"
+
+func EnvCmd() *cobra.Command {
+	envCmd := &cobra.Command{
+		Use:   "env",
+		Short: "Encrypts, compares and checks .env files",
+		Long: `Helpers for .env files: encrypt them before they are shared, and decrypt them again, with age; compare the
+keys of two of them without showing the values; and check one against the .env.example of a project.
+
+Files are read as KEY=value lines with # comments, an optional export prefix and single or double quoted
+values, which may span lines. diff and check decrypt encrypted files on the fly.`,
+		Example: `  gsn env encrypt .env -o .env.age
+  gsn env decrypt .env.age -o .env
+  gsn env diff .env .env.staging
+  gsn env check .env --against .env.example`,
+	}
+
+	envCmd.AddCommand(encryptCmd())
+	envCmd.AddCommand(decryptCmd())
+	envCmd.AddCommand(diffCmd())
+	envCmd.AddCommand(checkCmd())
+	return envCmd
+}
+
+// addDecryptFlag adds --identity to the commands reading encrypted files
+func addDecryptFlag(cmd *cobra.Command) {
+	cmd.Flags().StringP("identity", "i", "", "age identity file to decrypt with instead of a passphrase")
+}
+
+func encryptCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "encrypt <file>",
+		Short: "Encrypts an env file with a passphrase or to age recipients",
+		Long: `Encrypts a file with age, to the given recipients or, without any, with a passphrase read from
+$GSN_ENV_PASSPHRASE or asked twice on the terminal. The result goes to <file>.age, to -o, or to stdout with
+-o -; --armor writes it as text that survives being pasted into a chat. The file is parsed first and a warning
+is printed when it is not a valid env file. Anyone with age, or gsn env decrypt, can open it again.`,
+		Example: `  gsn env encrypt .env
+  gsn env encrypt .env -o .env.enc --recipient age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p
+  gsn env encrypt .env --armor -o - | pbcopy`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			out, _ := cmd.Flags().GetString("output")
+			recipients, _ := cmd.Flags().GetStringArray("recipient")
+			armored, _ := cmd.Flags().GetBool("armor")
+			force, _ := cmd.Flags().GetBool("force")
+
+			data, err := os.ReadFile(args[0])
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			if isEncrypted(data) {
+				clierr.Exitf(clierr.Usage, "'%s' is already encrypted", args[0])
+			}
+			if _, err := parseEnv(string(data)); err != nil {
+				fmt.Fprintf(os.Stderr, style.Warning()+"'%s' is not a valid env file (%v), encrypting it as is\n", args[0], err)
+			}
+			if out == "" {
+				out = args[0] + encryptedSuffix
+			}
+			sealed, err := encrypt(data, keys{Recipients: recipients, Armor: armored})
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			if err := writeOutput(out, sealed, 0o644, force); err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			if out != "-" {
+				fmt.Fprintf(os.Stderr, style.Success()+"Encrypted %s to %s\n", args[0], out)
+			}
+		},
+	}
+
+	cmd.Flags().StringP("output", "o", "", "Write the encrypted file here, - for stdout (default <file>.age)")
+	cmd.Flags().StringArrayP("recipient", "r", nil, "Encrypt to this age public key instead of a passphrase (repeatable)")
+	cmd.Flags().BoolP("armor", "a", false, "Write PEM style text instead of binary")
+	cmd.Flags().Bool("force", false, "Overwrite the output file when it exists")
+	return cmd
+}
+
+func decryptCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "decrypt <file>",
+		Short: "Decrypts an env file encrypted with gsn env encrypt or age",
+		Long: `Decrypts an age file, binary or armored, with the passphrase from $GSN_ENV_PASSPHRASE or the terminal, or
+with --identity. The content goes to stdout, or to -o with permissions 0600.`,
+		Example: `  gsn env decrypt .env.age -o .env
+  gsn env decrypt .env.age --identity ~/.config/gsn/age-identity.txt | grep DATABASE_URL`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			out, _ := cmd.Flags().GetString("output")
+			identity, _ := cmd.Flags().GetString("identity")
+			force, _ := cmd.Flags().GetBool("force")
+
+			data, err := os.ReadFile(args[0])
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			if !isEncrypted(data) {
+				clierr.Exitf(clierr.Usage, "'%s' is not an age encrypted file", args[0])
+			}
+			plain, err := decrypt(data, keys{Identity: identity})
+			if err != nil {
+				clierr.Fatalf("Cannot decrypt '%s': %v", args[0], err)
+			}
+			if out == "" {
+				out = "-"
+			}
+			if err := writeOutput(out, plain, 0o600, force); err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			if out != "-" {
+				fmt.Fprintf(os.Stderr, style.Success()+"Decrypted %s to %s\n", args[0], out)
+			}
+		},
+	}
+
+	cmd.Flags().StringP("output", "o", "", "Write the decrypted file here instead of stdout")
+	cmd.Flags().Bool("force", false, "Overwrite the output file when it exists")
+	addDecryptFlag(cmd)
+	return cmd
+}
+
+func diffCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "diff <a> <b>",
+		Short: "Compares the keys of two env files",
+		Long: `Lists the keys only in a (-), only in b (+) and those whose value differs (~), sorted by key. Values are
+not printed unless --show-values is given, so the output can be pasted safely; long values are cut to the width
+of the terminal. --context N shows N unchanged keys around each change, --diff-format json or summary prints the
+changes as JSON or their counts. Exits with 1 when the files differ.`,
+		Example: `  gsn env diff .env .env.staging
+  gsn env diff .env.age .env --show-values --context 2
+  gsn env diff .env .env.production --diff-format json`,
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			showValues, _ := cmd.Flags().GetBool("show-values")
+			identity, _ := cmd.Flags().GetString("identity")
+			opts, err := diffout.OptionsFromFlags(cmd)
+			if err != nil {
+				clierr.Fatal(err)
+			}
+			opts.ShowValues = showValues
+
+			k := keys{Identity: identity}
+			a, err := readEnvFile(args[0], k)
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			b, err := readEnvFile(args[1], k)
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			changes := diffEnv(envValues(a), envValues(b))
+			if err := diffout.Render(os.Stdout, changes, opts); err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			if diffout.Count(changes) > 0 {
+				clierr.Exit(clierr.Failure)
+			}
+		},
+	}
+
+	cmd.Flags().Bool("show-values", false, "Print the values of the keys that differ")
+	addDecryptFlag(cmd)
+	diffout.AddFlags(cmd)
+	diffout.AddContextFlag(cmd)
+	dryrun.ReadOnly(cmd)
+	return cmd
+}
+
+func checkCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "check <file>",
+		Short: "Checks that an env file sets every key of an example file",
+		Long: `Fails when a key of the example file, .env.example next to the file by default, is missing from the file.
+Keys set to an empty value and keys the example does not know are reported as warnings.`,
+		Example: `  gsn env check .env
+  gsn env check deploy/.env.production --against .env.example`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			against, _ := cmd.Flags().GetString("against")
+			identity, _ := cmd.Flags().GetString("identity")
+
+			if against == "" {
+				against = filepath.Join(filepath.Dir(args[0]), ".env.example")
+			}
+			k := keys{Identity: identity}
+			vars, err := readEnvFile(args[0], k)
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			example, err := readEnvFile(against, k)
+			if errors.Is(err, fs.ErrNotExist) {
+				clierr.Exitf(clierr.NotFound, "no example file '%s', name one with --against", against)
+			}
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			missing, empty, extra := checkEnv(envValues(vars), example)
+			for _, key := range empty {
+				fmt.Fprintf(os.Stderr, style.Warning()+"%s is empty\n", key)
+			}
+			if len(extra) > 0 {
+				fmt.Fprintf(os.Stderr, style.Warning()+"Not in %s: %s\n", against, strings.Join(extra, ", "))
+			}
+			for _, key := range missing {
+				fmt.Println(style.Failure() + key + " is missing")
+			}
+			if len(missing) > 0 {
+				clierr.Exitf(clierr.Failure, "%s misses %d key(s) of %s", args[0], len(missing), against)
+			}
+			fmt.Printf(style.Success()+"%s sets all %d key(s) of %s\n", args[0], len(envValues(example)), against)
+		},
+	}
+
+	cmd.Flags().String("against", "", "Example file listing the required keys (default .env.example next to the file)")
+	addDecryptFlag(cmd)
+	dryrun.ReadOnly(cmd)
+	return cmd
+}
+
+// diffEnv compares the values of two env files by key, keys set to the same value are unchanged
+func diffEnv(a map[string]string, b map[string]string) []diffout.Change {
+	var changes []diffout.Change
+	for key, before := range a {
+		after, ok := b[key]
+		switch {
+		case !ok:
+			changes = append(changes, diffout.Change{Key: key, Kind: diffout.Removed, Before: before})
+		case after != before:
+			changes = append(changes, diffout.Change{Key: key, Kind: diffout.Modified, Before: before, After: after})
+		default:
+			changes = append(changes, diffout.Change{Key: key, Kind: diffout.Unchanged, Before: before, After: after})
+		}
+	}
+	for key, after := range b {
+		if _, ok := a[key]; !ok {
+			changes = append(changes, diffout.Change{Key: key, Kind: diffout.Added, After: after})
+		}
+	}
+	return changes
+}
+
+// checkEnv returns the keys of example missing from values, those set but empty, and those example does not
+// have, each in the order of the files
+func checkEnv(values map[string]string, example []envVar) (missing []string, empty []string, extra []string) {
+	known := make(map[string]bool, len(example))
+	for _, v := range example {
+		if known[v.Key] {
+			continue
+		}
+		known[v.Key] = true
+		value, ok := values[v.Key]
+		switch {
+		case !ok:
+			missing = append(missing, v.Key)
+		case value == "":
+			empty = append(empty, v.Key)
+		}
+	}
+	for key := range values {
+		if !known[key] {
+			extra = append(extra, key)
+		}
+	}
+	slices.Sort(extra)
+	return missing, empty, extra
+}
+
+// writeOutput writes data to path, or stdout for -. An existing file is only replaced with force.
+func writeOutput(path string, data []byte, perm os.FileMode, force bool) error {
+	if path == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	if _, err := os.Lstat(path); err == nil && !force {
+		return clierr.Newf(clierr.Conflict, "'%s' already exists, use --force to overwrite it", path)
+	}
+	return output.WriteFileAtomic(path, data, perm)
+}
@@ -0,0 +1,140 @@
+package dotenv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gsn-dev-tools/internals/config"
+	"gsn-dev-tools/internals/secrets"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// ageHeader starts every binary age file
+const ageHeader = "age-encryption.org/v1"
+
+// keys says how an env file is encrypted or decrypted: with age recipients and identities when given, with a
+// passphrase from $GSN_ENV_PASSPHRASE or a prompt otherwise
+type keys struct {
+	Recipients []string
+	Identity   string
+	Armor      bool
+}
+
+// isEncrypted reports whether data is an age file, binary or armored
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(ageHeader)) || bytes.HasPrefix(bytes.TrimLeft(data, " \t\r\n"), []byte(armor.Header))
+}
+
+// encrypt seals plain for the recipients of k, or a passphrase
+func encrypt(plain []byte, k keys) ([]byte, error) {
+	var recipients []age.Recipient
+	for _, r := range k.Recipients {
+		parsed, err := age.ParseRecipients(strings.NewReader(r))
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient '%s': %w", r, err)
+		}
+		recipients = append(recipients, parsed...)
+	}
+	if len(recipients) == 0 {
+		p, err := secrets.ReadPassphrase("GSN_ENV_PASSPHRASE", "Passphrase: ", true)
+		if err != nil {
+			return nil, err
+		}
+		r, err := age.NewScryptRecipient(p)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, r)
+	}
+
+	var out bytes.Buffer
+	var dst io.Writer = &out
+	var armored io.WriteCloser
+	if k.Armor {
+		armored = armor.NewWriter(&out)
+		dst = armored
+	}
+	w, err := age.Encrypt(dst, recipients...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(plain); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if armored != nil {
+		if err := armored.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// decrypt opens an age file with the identity of k, or a passphrase
+func decrypt(data []byte, k keys) ([]byte, error) {
+	var identities []age.Identity
+	if k.Identity != "" {
+		f, err := os.Open(config.ExpandHome(k.Identity))
+		if err != nil {
+			return nil, fmt.Errorf("cannot read age identity: %w", err)
+		}
+		defer f.Close()
+		if identities, err = age.ParseIdentities(f); err != nil {
+			return nil, fmt.Errorf("invalid age identity '%s': %w", k.Identity, err)
+		}
+	} else {
+		p, err := secrets.ReadPassphrase("GSN_ENV_PASSPHRASE", "Passphrase: ", false)
+		if err != nil {
+			return nil, err
+		}
+		id, err := age.NewScryptIdentity(p)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, id)
+	}
+
+	var src io.Reader = bytes.NewReader(data)
+	if !bytes.HasPrefix(data, []byte(ageHeader)) {
+		src = armor.NewReader(bytes.NewReader(bytes.TrimLeft(data, " \t\r\n")))
+	}
+	r, err := age.Decrypt(src, identities...)
+	if err != nil {
+		var noMatch *age.NoIdentityMatchError
+		if errors.As(err, &noMatch) {
+			return nil, fmt.Errorf("wrong passphrase or identity, or not encrypted for it")
+		}
+		return nil, err
+	}
+	plain, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("the encrypted file is corrupted: %w", err)
+	}
+	return plain, nil
+}
+
+// readEnvFile parses an env file, decrypting it first when it is an age file
+func readEnvFile(path string, k keys) ([]envVar, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if isEncrypted(data) {
+		if data, err = decrypt(data, k); err != nil {
+			return nil, fmt.Errorf("cannot decrypt '%s': %w", path, err)
+		}
+	}
+	vars, err := parseEnv(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid env file '%s': %w", path, err)
+	}
+	return vars, nil
+}
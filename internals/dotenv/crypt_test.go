@@ -0,0 +1,148 @@
+package dotenv
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+const envSample = "# secrets\nDB_URL=postgres://u:p@localhost/db\nTOKEN=\"multi\nline\"\n"
+
+// writeIdentity generates an age identity, writes it to a file and returns it with its path
+func writeIdentity(t *testing.T) (*age.X25519Identity, string) {
+	t.Helper()
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "key.txt")
+	if err := os.WriteFile(path, []byte("# test key\n"+id.String()+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return id, path
+}
+
+func TestEncryptDecryptWithRecipients(t *testing.T) {
+	alice, alicePath := writeIdentity(t)
+	bob, bobPath := writeIdentity(t)
+	_, evePath := writeIdentity(t)
+
+	for _, armored := range []bool{false, true} {
+		sealed, err := encrypt([]byte(envSample), keys{Recipients: []string{alice.Recipient().String(), bob.Recipient().String()}, Armor: armored})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !isEncrypted(sealed) || bytes.Contains(sealed, []byte("postgres")) {
+			t.Fatalf("armor %v: sealed = %q", armored, sealed)
+		}
+		if armored != bytes.HasPrefix(sealed, []byte(armor.Header)) {
+			t.Errorf("armor %v: sealed starts with %q", armored, sealed[:min(len(sealed), 40)])
+		}
+
+		// Either recipient opens it, anyone else does not
+		for _, path := range []string{alicePath, bobPath} {
+			plain, err := decrypt(sealed, keys{Identity: path})
+			if err != nil || string(plain) != envSample {
+				t.Errorf("armor %v: decrypt with %s = %q, %v", armored, path, plain, err)
+			}
+		}
+		if _, err := decrypt(sealed, keys{Identity: evePath}); err == nil || err.Error() != "wrong passphrase or identity, or not encrypted for it" {
+			t.Errorf("armor %v: decrypt with another identity = %v", armored, err)
+		}
+	}
+
+	if _, err := encrypt([]byte(envSample), keys{Recipients: []string{"age1notakey"}}); err == nil || !strings.HasPrefix(err.Error(), "invalid recipient 'age1notakey': ") {
+		t.Errorf("encrypt for an invalid recipient = %v", err)
+	}
+	if _, err := decrypt([]byte(ageHeader), keys{Identity: filepath.Join(t.TempDir(), "missing")}); err == nil || !strings.HasPrefix(err.Error(), "cannot read age identity: ") {
+		t.Errorf("decrypt with a missing identity = %v", err)
+	}
+	garbage := filepath.Join(t.TempDir(), "garbage")
+	if err := os.WriteFile(garbage, []byte("not a key\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decrypt([]byte(ageHeader), keys{Identity: garbage}); err == nil || !strings.HasPrefix(err.Error(), "invalid age identity '"+garbage+"': ") {
+		t.Errorf("decrypt with an invalid identity = %v", err)
+	}
+}
+
+func TestEncryptDecryptWithPassphrase(t *testing.T) {
+	t.Setenv("GSN_ENV_PASSPHRASE", "correct horse battery staple")
+	sealed, err := encrypt([]byte(envSample), keys{Armor: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Armored files are recognized after leading blank lines too
+	sealed = append([]byte("\n  \n"), sealed...)
+	if !isEncrypted(sealed) {
+		t.Fatal("the armored file is not recognized as encrypted")
+	}
+	plain, err := decrypt(sealed, keys{})
+	if err != nil || string(plain) != envSample {
+		t.Errorf("decrypt = %q, %v", plain, err)
+	}
+
+	t.Setenv("GSN_ENV_PASSPHRASE", "wrong")
+	if _, err := decrypt(sealed, keys{}); err == nil || err.Error() != "wrong passphrase or identity, or not encrypted for it" {
+		t.Errorf("decrypt with the wrong passphrase = %v", err)
+	}
+}
+
+func TestDecryptDetectsCorruption(t *testing.T) {
+	id, path := writeIdentity(t)
+	sealed, err := encrypt([]byte(envSample), keys{Recipients: []string{id.Recipient().String()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The payload is authenticated, its last byte belongs to the tag of the final chunk
+	sealed[len(sealed)-1] ^= 0x01
+	if _, err := decrypt(sealed, keys{Identity: path}); err == nil || !strings.HasPrefix(err.Error(), "the encrypted file is corrupted: ") {
+		t.Errorf("decrypt of a corrupted payload = %v", err)
+	}
+	if _, err := decrypt(sealed[:len(ageHeader)+10], keys{Identity: path}); err == nil {
+		t.Error("decrypt of a truncated header succeeded")
+	}
+}
+
+func TestReadEnvFile(t *testing.T) {
+	id, path := writeIdentity(t)
+	dir := t.TempDir()
+	plainPath := filepath.Join(dir, ".env")
+	if err := os.WriteFile(plainPath, []byte(envSample), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := encrypt([]byte(envSample), keys{Recipients: []string{id.Recipient().String()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealedPath := filepath.Join(dir, ".env"+encryptedSuffix)
+	if err := os.WriteFile(sealedPath, sealed, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same variables, whether the file is encrypted or not
+	want := []envVar{{"DB_URL", "postgres://u:p@localhost/db", 2}, {"TOKEN", "multi\nline", 3}}
+	for _, p := range []string{plainPath, sealedPath} {
+		vars, err := readEnvFile(p, keys{Identity: path})
+		if err != nil || len(vars) != len(want) || vars[0] != want[0] || vars[1] != want[1] {
+			t.Errorf("readEnvFile(%s) = %q, %v", p, vars, err)
+		}
+	}
+
+	_, other := writeIdentity(t)
+	if _, err := readEnvFile(sealedPath, keys{Identity: other}); err == nil || !strings.HasPrefix(err.Error(), "cannot decrypt '"+sealedPath+"': ") {
+		t.Errorf("readEnvFile with another identity = %v", err)
+	}
+	bad := filepath.Join(dir, "bad.env")
+	if err := os.WriteFile(bad, []byte("A=1\noops\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readEnvFile(bad, keys{}); err == nil || err.Error() != "invalid env file '"+bad+"': line 2: expected KEY=value" {
+		t.Errorf("readEnvFile of an invalid file = %v", err)
+	}
+}
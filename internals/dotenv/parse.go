@@ -0,0 +1,138 @@
+package dotenv
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// keyPattern matches the variable names accepted in an env file
+var keyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// envVar is one assignment of an env file, Line is where it starts
+type envVar struct {
+	Key   string
+	Value string
+	Line  int
+}
+
+// parseError is a line of an env file that is not an assignment
+type parseError struct {
+	Line   int
+	Reason string
+}
+
+func (e *parseError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Reason)
+}
+
+// parseEnv reads the assignments of an env file in order. It takes blank lines, # comments, an optional export
+// prefix, KEY=value with the value unquoted (an inline comment starts at " #"), 'single quoted' verbatim or
+// "double quoted" with \n, \r, \t, \", \\ and \$ escapes. Quoted values may span lines.
+func parseEnv(data string) ([]envVar, error) {
+	lines := strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n")
+	var vars []envVar
+	for i := 0; i < len(lines); i++ {
+		number := i + 1
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(line, "export"); ok && rest != "" && (rest[0] == ' ' || rest[0] == '\t') {
+			line = strings.TrimSpace(rest)
+		}
+
+		key, raw, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, &parseError{Line: number, Reason: "expected KEY=value"}
+		}
+		key = strings.TrimSpace(key)
+		if !keyPattern.MatchString(key) {
+			return nil, &parseError{Line: number, Reason: fmt.Sprintf("invalid key '%s'", key)}
+		}
+		raw = strings.TrimLeft(raw, " \t")
+
+		var value string
+		if raw != "" && (raw[0] == '"' || raw[0] == '\'') {
+			// A quoted value may continue on the next lines, up to its closing quote
+			quote := raw[0]
+			text := raw[1:]
+			end := closingQuote(text, quote)
+			for end < 0 && i+1 < len(lines) {
+				i++
+				text += "\n" + lines[i]
+				end = closingQuote(text, quote)
+			}
+			if end < 0 {
+				return nil, &parseError{Line: number, Reason: fmt.Sprintf("unterminated %c quoted value of '%s'", quote, key)}
+			}
+			if rest := strings.TrimSpace(text[end+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+				return nil, &parseError{Line: number, Reason: fmt.Sprintf("unexpected '%s' after the quoted value of '%s'", rest, key)}
+			}
+			value = text[:end]
+			if quote == '"' {
+				value = unescape(value)
+			}
+		} else {
+			value = raw
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = value[:i]
+			} else if i := strings.Index(value, "\t#"); i >= 0 {
+				value = value[:i]
+			}
+			value = strings.TrimSpace(value)
+		}
+		vars = append(vars, envVar{Key: key, Value: value, Line: number})
+	}
+	return vars, nil
+}
+
+// closingQuote returns the index of the quote ending text, skipping backslash escapes in double quotes
+func closingQuote(text string, quote byte) int {
+	for i := 0; i < len(text); i++ {
+		switch {
+		case quote == '"' && text[i] == '\\':
+			i++
+		case text[i] == quote:
+			return i
+		}
+	}
+	return -1
+}
+
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case '"', '\\', '$':
+			b.WriteByte(s[i])
+		default:
+			b.WriteByte('\\')
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// envValues maps the keys of vars to their values, a key assigned twice keeps its last value
+func envValues(vars []envVar) map[string]string {
+	values := make(map[string]string, len(vars))
+	for _, v := range vars {
+		values[v.Key] = v.Value
+	}
+	return values
+}
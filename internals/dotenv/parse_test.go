@@ -0,0 +1,73 @@
+package dotenv
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestParseEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []envVar
+	}{
+		{"blank lines and comments", "\n# comment\n   # indented comment\n\nA=1\n", []envVar{{"A", "1", 5}}},
+		{"export prefix", "export A=1\nexport\tB=2\nexportC=3\nexport=4\n",
+			[]envVar{{"A", "1", 1}, {"B", "2", 2}, {"exportC", "3", 3}, {"export", "4", 4}}},
+		{"spaces around the key and value", "  A = spaced value  \n", []envVar{{"A", "spaced value", 1}}},
+		{"empty values", "A=\nB=''\nC=\"\"\n", []envVar{{"A", "", 1}, {"B", "", 2}, {"C", "", 3}}},
+		{"= in the value", "URL=postgres://u:p@h/db?sslmode=disable\n", []envVar{{"URL", "postgres://u:p@h/db?sslmode=disable", 1}}},
+		{"inline comments", "A=1 # one\nB=2\t# two\nC=a#b\nD='x # y' # z\n",
+			[]envVar{{"A", "1", 1}, {"B", "2", 2}, {"C", "a#b", 3}, {"D", "x # y", 4}}},
+		{"single quotes are verbatim", `A='$HOME \n "q"'`, []envVar{{"A", `$HOME \n "q"`, 1}}},
+		{"double quote escapes", `A="l1\nl2\tt\r \"q\" \\ \$HOME \q"`, []envVar{{"A", "l1\nl2\tt\r \"q\" \\ $HOME \\q", 1}}},
+		{"multi-line values keep their first line", "A=\"first\n  second\n\"\nB='x\ny'\nC=3\n",
+			[]envVar{{"A", "first\n  second\n", 1}, {"B", "x\ny", 4}, {"C", "3", 6}}},
+		{"escaped quote before a newline", "A=\"a\\\"\nb\"\n", []envVar{{"A", "a\"\nb", 1}}},
+		{"CRLF line endings", "A=1\r\nB=\"x\r\ny\"\r\n", []envVar{{"A", "1", 1}, {"B", "x\ny", 2}}},
+		{"keys with dots and dashes", "app.name=x\nmy-key=y\n_A1=z\n", []envVar{{"app.name", "x", 1}, {"my-key", "y", 2}, {"_A1", "z", 3}}},
+		{"no final newline", "A=1", []envVar{{"A", "1", 1}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseEnv(test.data)
+			if err != nil || !slices.Equal(got, test.want) {
+				t.Errorf("parseEnv(%q) = %q, %v\nwant %q", test.data, got, err, test.want)
+			}
+		})
+	}
+}
+
+func TestParseEnvErrors(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"A=1\nJUST_A_WORD\n", "line 2: expected KEY=value"},
+		{"1A=x", "line 1: invalid key '1A'"},
+		{"MY KEY=x", "line 1: invalid key 'MY KEY'"},
+		{"=x", "line 1: invalid key ''"},
+		{"A=1\nB=\"open\nstill open\n", "line 2: unterminated \" quoted value of 'B'"},
+		{"A='open", "line 1: unterminated ' quoted value of 'A'"},
+		{`A="x\"`, "line 1: unterminated \" quoted value of 'A'"},
+		{"A=\"x\" y", "line 1: unexpected 'y' after the quoted value of 'A'"},
+	}
+	for _, test := range tests {
+		_, err := parseEnv(test.data)
+		var parseErr *parseError
+		if !errors.As(err, &parseErr) || err.Error() != test.want {
+			t.Errorf("parseEnv(%q) = %v, want %q", test.data, err, test.want)
+		}
+	}
+}
+
+func TestEnvValuesKeepsTheLastAssignment(t *testing.T) {
+	vars, err := parseEnv("A=1\nB=2\nA=3\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values := envValues(vars); len(values) != 2 || values["A"] != "3" || values["B"] != "2" {
+		t.Errorf("envValues = %v", values)
+	}
+}
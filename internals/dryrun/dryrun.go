@@ -0,0 +1,94 @@
+// Package dryrun implements the persistent --dry-run flag. Commands declare whether they support it: an adopted
+// command routes every change it makes through Do, which prints the change as a line of the plan instead of
+// making it, and a read-only command changes nothing so it runs as usual. Any other command refuses to run under
+// --dry-run rather than ignoring it.
+package dryrun
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"gsn-dev-tools/internals/clierr"
+
+	"github.com/spf13/cobra"
+)
+
+// annotation is the key of the cobra annotation holding the dry-run capability of a command
+const annotation = "gsn/dry-run"
+
+const (
+	capAdopted  = "adopted"
+	capReadOnly = "read-only"
+)
+
+// enabled is set from --dry-run for the running command
+var enabled bool
+
+// Plan receives the lines of the plan, nil is stdout
+var Plan io.Writer
+
+// AddFlag registers the persistent --dry-run flag on the root command
+func AddFlag(cmd *cobra.Command) {
+	cmd.PersistentFlags().Bool("dry-run", false, "Print the changes the command would make instead of making them")
+}
+
+// Adopt marks commands whose changes all go through Do
+func Adopt(cmds ...*cobra.Command) {
+	mark(capAdopted, cmds)
+}
+
+// ReadOnly marks commands that change nothing, --dry-run leaves them as they are
+func ReadOnly(cmds ...*cobra.Command) {
+	mark(capReadOnly, cmds)
+}
+
+func mark(capability string, cmds []*cobra.Command) {
+	for _, cmd := range cmds {
+		if cmd.Annotations == nil {
+			cmd.Annotations = map[string]string{}
+		}
+		cmd.Annotations[annotation] = capability
+	}
+}
+
+// Supported reports whether cmd can run under --dry-run. cobra's help and completion commands only print.
+func Supported(cmd *cobra.Command) bool {
+	if cmd.Annotations[annotation] != "" {
+		return true
+	}
+	for c := cmd; c != nil; c = c.Parent() {
+		if c.Name() == "help" || c.Name() == "completion" || c.Name() == cobra.ShellCompRequestCmd {
+			return true
+		}
+	}
+	return false
+}
+
+// Configure reads --dry-run and refuses to run a command that does not support it
+func Configure(cmd *cobra.Command) error {
+	enabled, _ = cmd.Flags().GetBool("dry-run")
+	if enabled && !Supported(cmd) {
+		return clierr.Newf(clierr.Usage, "%s does not support --dry-run yet, nothing was run", cmd.CommandPath())
+	}
+	return nil
+}
+
+// Enabled reports whether changes are printed instead of made
+func Enabled() bool {
+	return enabled
+}
+
+// Do makes a change, or under --dry-run prints it as "Would <description>" and reports success. description
+// reads as what is done, e.g. "remove /tmp/old.log".
+func Do(description string, change func() error) error {
+	if enabled {
+		plan := Plan
+		if plan == nil {
+			plan = os.Stdout
+		}
+		fmt.Fprintf(plan, "Would %s\n", description)
+		return nil
+	}
+	return change()
+}
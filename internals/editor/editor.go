@@ -0,0 +1,58 @@
+package editor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"gsn-dev-tools/internals/execx"
+	"gsn-dev-tools/internals/redact"
+	"gsn-dev-tools/internals/tmpfs"
+)
+
+// Command returns the editor from $VISUAL or $EDITOR, falling back to vi
+func Command() []string {
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		if fields := strings.Fields(os.Getenv(name)); len(fields) > 0 {
+			return fields
+		}
+	}
+	return []string{"vi"}
+}
+
+// Edit opens initial in the user's editor and returns the saved content.
+// pattern names the temp file, e.g. "issue-*.md" so the editor picks the right syntax.
+func Edit(ctx context.Context, initial string, pattern string) (string, error) {
+	workspace, err := tmpfs.New("editor")
+	if err != nil {
+		return "", err
+	}
+	defer workspace.Cleanup()
+
+	file, err := workspace.CreateFile(pattern)
+	if err != nil {
+		return "", err
+	}
+	if _, err := file.WriteString(initial); err != nil {
+		file.Close()
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+
+	editor := Command()
+	args := append(editor[1:], file.Name())
+	// An editor takes over the terminal, it gets the terminal itself rather than the redacting pipes
+	opts := execx.Options{Stdin: os.Stdin, Stdout: redact.Unfiltered(os.Stdout), Stderr: redact.Unfiltered(os.Stderr)}
+	if _, _, _, err := execx.Default.Run(ctx, editor[0], args, opts); err != nil {
+		return "", fmt.Errorf("editor '%s' failed: %w", strings.Join(editor, " "), err)
+	}
+
+	data, err := os.ReadFile(file.Name())
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
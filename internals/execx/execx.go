@@ -0,0 +1,103 @@
+package execx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Options tweaks a single command run
+type Options struct {
+	Stdin io.Reader
+	Dir   string
+
+	// Env is appended to the current environment
+	Env []string
+
+	// Timeout bounds the run on top of the context deadline, zero means no extra limit
+	Timeout time.Duration
+
+	// Stdout and Stderr stream the output instead of capturing it when set
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Runner runs external commands. Commands use Default and tests swap in a Fake.
+type Runner interface {
+	// Run executes name with args and returns the captured output and exit code.
+	// A non-zero exit is reported as an *ExitError.
+	Run(ctx context.Context, name string, args []string, opts Options) (stdout []byte, stderr []byte, exitCode int, err error)
+
+	// LookPath reports whether name can be executed, like exec.LookPath
+	LookPath(name string) (string, error)
+}
+
+// Default is the runner used by every command that shells out
+var Default Runner = OSRunner{}
+
+// ExitError is returned when a command exits with a non-zero code
+type ExitError struct {
+	Name   string
+	Code   int
+	Stderr string
+}
+
+func (e *ExitError) Error() string {
+	if e.Stderr == "" {
+		return fmt.Sprintf("%s exited with code %d", e.Name, e.Code)
+	}
+	return fmt.Sprintf("%s exited with code %d: %s", e.Name, e.Code, e.Stderr)
+}
+
+// OSRunner runs commands with os/exec
+type OSRunner struct{}
+
+func (OSRunner) Run(ctx context.Context, name string, args []string, opts Options) ([]byte, []byte, int, error) {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin = opts.Stdin
+	cmd.Dir = opts.Dir
+	if len(opts.Env) > 0 {
+		cmd.Env = append(os.Environ(), opts.Env...)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if opts.Stdout != nil {
+		cmd.Stdout = opts.Stdout
+	}
+	if opts.Stderr != nil {
+		cmd.Stderr = opts.Stderr
+	}
+
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return stdout.Bytes(), stderr.Bytes(), -1, fmt.Errorf("%s: %w", name, ctx.Err())
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		code := exitErr.ExitCode()
+		return stdout.Bytes(), stderr.Bytes(), code, &ExitError{Name: name, Code: code, Stderr: strings.TrimSpace(stderr.String())}
+	}
+	if err != nil {
+		return stdout.Bytes(), stderr.Bytes(), -1, err
+	}
+	return stdout.Bytes(), stderr.Bytes(), 0, nil
+}
+
+func (OSRunner) LookPath(name string) (string, error) {
+	return exec.LookPath(name)
+}
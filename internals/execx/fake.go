@@ -0,0 +1,245 @@
+package execx
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Matcher checks a single argument of an expected command
+type Matcher interface {
+	Match(arg string) bool
+	String() string
+}
+
+type matchFunc struct {
+	fn   func(string) bool
+	desc string
+}
+
+func (m matchFunc) Match(arg string) bool { return m.fn(arg) }
+func (m matchFunc) String() string        { return m.desc }
+
+// Eq matches an argument exactly, plain strings passed to Expect use it implicitly
+func Eq(want string) Matcher {
+	return matchFunc{func(arg string) bool { return arg == want }, fmt.Sprintf("%q", want)}
+}
+
+// Any matches any single argument
+func Any() Matcher {
+	return matchFunc{func(string) bool { return true }, "<any>"}
+}
+
+// HasPrefix matches arguments starting with prefix
+func HasPrefix(prefix string) Matcher {
+	return matchFunc{func(arg string) bool { return strings.HasPrefix(arg, prefix) }, fmt.Sprintf("%q...", prefix)}
+}
+
+// Regexp matches arguments against a regular expression
+func Regexp(pattern string) Matcher {
+	re := regexp.MustCompile(pattern)
+	return matchFunc{re.MatchString, "/" + pattern + "/"}
+}
+
+// rest is the marker returned by Rest
+type rest struct{}
+
+func (rest) Match(string) bool { return true }
+func (rest) String() string    { return "<rest...>" }
+
+// Rest matches every remaining argument, including none; it must be the last matcher
+func Rest() Matcher {
+	return rest{}
+}
+
+// Call is a command received by a Fake
+type Call struct {
+	Name  string
+	Args  []string
+	Stdin string
+	// Env is the environment added to the one of gsn
+	Env []string
+}
+
+// Expectation is a scripted command and its canned result
+type Expectation struct {
+	name     string
+	args     []Matcher
+	stdout   string
+	stderr   string
+	exitCode int
+	err      error
+	times    int
+	calls    int
+}
+
+// Return sets the stdout and exit code of the expected command
+func (e *Expectation) Return(stdout string, exitCode int) *Expectation {
+	e.stdout = stdout
+	e.exitCode = exitCode
+	return e
+}
+
+// Stderr sets the stderr of the expected command
+func (e *Expectation) Stderr(stderr string) *Expectation {
+	e.stderr = stderr
+	return e
+}
+
+// Fail makes the expected command fail to start with err
+func (e *Expectation) Fail(err error) *Expectation {
+	e.err = err
+	return e
+}
+
+// Times sets how often the command is expected, the default is once
+func (e *Expectation) Times(n int) *Expectation {
+	e.times = n
+	return e
+}
+
+func (e *Expectation) matches(name string, args []string) bool {
+	if e.name != name {
+		return false
+	}
+	for i, m := range e.args {
+		if _, ok := m.(rest); ok {
+			return true
+		}
+		if i >= len(args) || !m.Match(args[i]) {
+			return false
+		}
+	}
+	return len(args) == len(e.args)
+}
+
+func (e *Expectation) String() string {
+	parts := []string{e.name}
+	for _, m := range e.args {
+		parts = append(parts, m.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+// Fake is a scriptable Runner. With InOrder set, calls must arrive in the order of the expectations.
+type Fake struct {
+	InOrder bool
+
+	// Paths lists the binaries LookPath finds, every binary is found when nil
+	Paths []string
+
+	mu           sync.Mutex
+	expectations []*Expectation
+	calls        []Call
+	errs         []string
+}
+
+// Expect registers a command; args are strings for exact matches or Matchers
+func (f *Fake) Expect(name string, args ...any) *Expectation {
+	e := &Expectation{name: name, times: 1}
+	for _, a := range args {
+		switch v := a.(type) {
+		case string:
+			e.args = append(e.args, Eq(v))
+		case Matcher:
+			e.args = append(e.args, v)
+		default:
+			panic(fmt.Sprintf("execx: unsupported matcher %T", a))
+		}
+	}
+
+	f.mu.Lock()
+	f.expectations = append(f.expectations, e)
+	f.mu.Unlock()
+	return e
+}
+
+func (f *Fake) Run(ctx context.Context, name string, args []string, opts Options) ([]byte, []byte, int, error) {
+	call := Call{Name: name, Args: append([]string(nil), args...), Env: append([]string(nil), opts.Env...)}
+	if opts.Stdin != nil {
+		data, _ := io.ReadAll(opts.Stdin)
+		call.Stdin = string(data)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, call)
+
+	e := f.next(name, args)
+	if e == nil {
+		msg := fmt.Sprintf("unexpected command: %s %s", name, strings.Join(args, " "))
+		f.errs = append(f.errs, msg)
+		return nil, nil, -1, fmt.Errorf("execx fake: %s", msg)
+	}
+	e.calls++
+
+	if e.err != nil {
+		return nil, nil, -1, e.err
+	}
+	if opts.Stdout != nil {
+		io.WriteString(opts.Stdout, e.stdout)
+	}
+	if opts.Stderr != nil {
+		io.WriteString(opts.Stderr, e.stderr)
+	}
+	if e.exitCode != 0 {
+		return []byte(e.stdout), []byte(e.stderr), e.exitCode, &ExitError{Name: name, Code: e.exitCode, Stderr: strings.TrimSpace(e.stderr)}
+	}
+	return []byte(e.stdout), []byte(e.stderr), 0, ctx.Err()
+}
+
+// next finds the expectation serving a call, honoring InOrder
+func (f *Fake) next(name string, args []string) *Expectation {
+	for _, e := range f.expectations {
+		if e.calls >= e.times {
+			continue
+		}
+		if e.matches(name, args) {
+			return e
+		}
+		if f.InOrder {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (f *Fake) LookPath(name string) (string, error) {
+	if f.Paths == nil {
+		return "/fake/bin/" + name, nil
+	}
+	for _, p := range f.Paths {
+		if p == name {
+			return "/fake/bin/" + name, nil
+		}
+	}
+	return "", &exec.Error{Name: name, Err: exec.ErrNotFound}
+}
+
+// Calls returns every command received so far
+func (f *Fake) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Call(nil), f.calls...)
+}
+
+// Verify reports unexpected calls and expectations that were not met
+func (f *Fake) Verify() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	problems := append([]string(nil), f.errs...)
+	for _, e := range f.expectations {
+		if e.calls != e.times {
+			problems = append(problems, fmt.Sprintf("expected %s %d time(s), got %d", e, e.times, e.calls))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("execx fake: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package execx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func run(f *Fake, name string, args ...string) (string, int, error) {
+	stdout, _, code, err := f.Run(context.Background(), name, args, Options{})
+	return string(stdout), code, err
+}
+
+func TestMatchers(t *testing.T) {
+	tests := []struct {
+		matcher Matcher
+		arg     string
+		want    bool
+	}{
+		{Eq("main"), "main", true},
+		{Eq("main"), "main2", false},
+		{Any(), "", true},
+		{Any(), "anything", true},
+		{HasPrefix("--format="), "--format=%H", true},
+		{HasPrefix("--format="), "--format", false},
+		{Regexp(`^[0-9a-f]{40}$`), strings.Repeat("a", 40), true},
+		{Regexp(`^[0-9a-f]{40}$`), "HEAD", false},
+	}
+	for _, test := range tests {
+		if got := test.matcher.Match(test.arg); got != test.want {
+			t.Errorf("%s.Match(%q) = %v, want %v", test.matcher, test.arg, got, test.want)
+		}
+	}
+}
+
+func TestExpectationArguments(t *testing.T) {
+	tests := []struct {
+		name   string
+		expect []any
+		args   []string
+		want   bool
+	}{
+		{"exact", []any{"rev-parse", "HEAD"}, []string{"rev-parse", "HEAD"}, true},
+		{"extra argument", []any{"rev-parse", "HEAD"}, []string{"rev-parse", "HEAD", "--short"}, false},
+		{"missing argument", []any{"rev-parse", "HEAD"}, []string{"rev-parse"}, false},
+		{"no arguments", nil, nil, true},
+		{"matcher", []any{"log", HasPrefix("--format="), Any()}, []string{"log", "--format=%s", "main"}, true},
+		{"matcher mismatch", []any{"log", HasPrefix("--format="), Any()}, []string{"log", "--oneline", "main"}, false},
+		{"rest matches none", []any{"status", Rest()}, []string{"status"}, true},
+		{"rest matches many", []any{"status", Rest()}, []string{"status", "-s", "--branch"}, true},
+		{"rest after mismatch", []any{"status", Rest()}, []string{"diff", "-s"}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := &Fake{}
+			f.Expect("git", test.expect...).Return("ok", 0)
+			out, _, err := run(f, "git", test.args...)
+			if matched := err == nil && out == "ok"; matched != test.want {
+				t.Errorf("git %v matched = %v (%v), want %v", test.args, matched, err, test.want)
+			}
+		})
+	}
+
+	// Another binary never matches
+	f := &Fake{}
+	f.Expect("git", Rest())
+	if _, _, err := run(f, "hg", "status"); err == nil {
+		t.Error("hg matched an expectation for git")
+	}
+}
+
+func TestExpectationOrdering(t *testing.T) {
+	// Without InOrder any pending expectation serves a call
+	f := &Fake{}
+	f.Expect("git", "fetch").Return("fetched", 0)
+	f.Expect("git", "status").Return("clean", 0)
+	if out, _, _ := run(f, "git", "status"); out != "clean" {
+		t.Errorf("status = %q", out)
+	}
+	if out, _, _ := run(f, "git", "fetch"); out != "fetched" {
+		t.Errorf("fetch = %q", out)
+	}
+	if err := f.Verify(); err != nil {
+		t.Error(err)
+	}
+
+	// With InOrder the first pending expectation must match
+	f = &Fake{InOrder: true}
+	f.Expect("git", "fetch").Return("fetched", 0)
+	f.Expect("git", "status").Return("clean", 0)
+	if _, _, err := run(f, "git", "status"); err == nil {
+		t.Error("status ran before fetch")
+	}
+	if out, _, _ := run(f, "git", "fetch"); out != "fetched" {
+		t.Errorf("fetch = %q", out)
+	}
+	if out, _, _ := run(f, "git", "status"); out != "clean" {
+		t.Errorf("status = %q", out)
+	}
+	err := f.Verify()
+	if err == nil || !strings.Contains(err.Error(), "unexpected command: git status") {
+		t.Errorf("Verify = %v, want the out of order call reported", err)
+	}
+
+	// Identical expectations are used up one after the other
+	f = &Fake{InOrder: true}
+	f.Expect("git", "rev-parse", "HEAD").Return("first", 0)
+	f.Expect("git", "rev-parse", "HEAD").Return("second", 0)
+	for _, want := range []string{"first", "second"} {
+		if out, _, _ := run(f, "git", "rev-parse", "HEAD"); out != want {
+			t.Errorf("rev-parse = %q, want %q", out, want)
+		}
+	}
+	if _, _, err := run(f, "git", "rev-parse", "HEAD"); err == nil {
+		t.Error("a third rev-parse was served")
+	}
+}
+
+func TestExpectationTimes(t *testing.T) {
+	f := &Fake{}
+	f.Expect("git", "status").Times(2)
+	run(f, "git", "status")
+	err := f.Verify()
+	if err == nil || !strings.Contains(err.Error(), `expected git "status" 2 time(s), got 1`) {
+		t.Errorf("Verify after one of two calls = %v", err)
+	}
+	run(f, "git", "status")
+	if err := f.Verify(); err != nil {
+		t.Errorf("Verify after both calls = %v", err)
+	}
+}
+
+func TestFakeResults(t *testing.T) {
+	f := &Fake{}
+	f.Expect("git", "merge").Return("CONFLICT\n", 1).Stderr("Automatic merge failed\n")
+	boom := errors.New("exec format error")
+	f.Expect("tool").Fail(boom)
+	f.Expect("cat").Return("streamed", 0).Stderr("note")
+
+	_, stderr, code, err := f.Run(context.Background(), "git", []string{"merge"}, Options{})
+	var exit *ExitError
+	if !errors.As(err, &exit) || exit.Code != 1 || exit.Stderr != "Automatic merge failed" || code != 1 || string(stderr) != "Automatic merge failed\n" {
+		t.Errorf("merge = code %d, %v", code, err)
+	}
+	if _, _, _, err := f.Run(context.Background(), "tool", nil, Options{}); !errors.Is(err, boom) {
+		t.Errorf("tool = %v, want the start error", err)
+	}
+
+	var stdout, errOut bytes.Buffer
+	f.Run(context.Background(), "cat", nil, Options{Stdin: strings.NewReader("input"), Env: []string{"A=1"}, Stdout: &stdout, Stderr: &errOut})
+	if stdout.String() != "streamed" || errOut.String() != "note" {
+		t.Errorf("streamed stdout %q, stderr %q", stdout.String(), errOut.String())
+	}
+	calls := f.Calls()
+	if last := calls[len(calls)-1]; last.Stdin != "input" || len(last.Env) != 1 || last.Env[0] != "A=1" {
+		t.Errorf("recorded call = %+v", last)
+	}
+
+	// A cancelled context is reported like a real run would
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	f.Expect("sleep")
+	if _, _, _, err := f.Run(ctx, "sleep", nil, Options{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled run = %v", err)
+	}
+}
+
+func TestFakeLookPath(t *testing.T) {
+	if path, err := (&Fake{}).LookPath("gpg"); err != nil || path != "/fake/bin/gpg" {
+		t.Errorf("LookPath without Paths = %q, %v", path, err)
+	}
+	f := &Fake{Paths: []string{"git"}}
+	if _, err := f.LookPath("git"); err != nil {
+		t.Error(err)
+	}
+	if _, err := f.LookPath("gpg"); err == nil {
+		t.Error("found gpg outside Paths")
+	}
+}
@@ -0,0 +1,159 @@
+package files
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/progress"
+)
+
+// The functions of this file run file operations for gsn daemon and gsn client. They never prompt and
+// return their results instead of printing them.
+
+// CompressOptions configures Compress
+type CompressOptions struct {
+	Manifest bool
+	Sparse   bool
+
+	// Format names the archive format like gsn cmp --format, tar.gz when empty
+	Format string
+
+	// Progress receives the bytes read from the source, nil draws a progress bar
+	Progress progress.Tracker
+}
+
+// Compress writes <path>.tar.gz, or the extension of opts.Format, next to path like gsn cmp -y. Filesystem roots
+// and the home directory are refused.
+func Compress(path string, opts CompressOptions) (*CompressResult, error) {
+	var format archiveFormat
+	if opts.Format != "" {
+		var err error
+		if format, err = parseFormat(opts.Format); err != nil {
+			return nil, err
+		}
+	}
+	return compressPath(path, compressOptions{
+		Manifest:  opts.Manifest,
+		Sparse:    opts.Sparse,
+		AssumeYes: true,
+		Progress:  opts.Progress,
+		Format:    format,
+	})
+}
+
+// ExtractResult describes what Extract restored
+type ExtractResult struct {
+	Destination string `json:"destination"`
+	Entries     int    `json:"entries"`
+	Conflicts   string `json:"conflicts,omitempty"`
+	Journal     string `json:"journal,omitempty"`
+}
+
+// Extract restores every entry of an archive below destDir. onConflict is overwrite, skip or backup, the
+// interactive prompt policy is refused.
+func Extract(archivePath string, destDir string, onConflict string) (*ExtractResult, error) {
+	policy, err := parseConflictPolicy(onConflict)
+	if err != nil {
+		return nil, err
+	}
+	if policy == conflictPrompt {
+		return nil, clierr.Newf(clierr.Usage, "--on-conflict prompt needs an interactive terminal, use overwrite, skip or backup")
+	}
+	if destDir == "" {
+		destDir = "."
+	}
+
+	conflicts := newConflictResolver(policy, destDir)
+	perms := &permissionPolicy{UID: -1, GID: -1, umask: processUmask()}
+	count, err := extractAll(archivePath, destDir, conflicts, perms, nil)
+	if ferr := perms.finish(); ferr != nil && err == nil {
+		err = fmt.Errorf("failed to set directory modes: %w", ferr)
+	}
+
+	result := &ExtractResult{Destination: destDir, Entries: count, Conflicts: conflicts.summary()}
+	if len(conflicts.journal.Entries) > 0 {
+		journalPath := filepath.Join(destDir, extractJournalName)
+		if jerr := conflicts.journal.Save(journalPath); jerr != nil && err == nil {
+			err = fmt.Errorf("failed to write journal: %w", jerr)
+		}
+		result.Journal = journalPath
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Hash describes a file or directory like gsn snap create --hash
+func Hash(path string) (*Manifest, error) {
+	return snapshotPath(path, true)
+}
+
+// RenameOptions configures PlanRename and ApplyRename, empty fields use the defaults of gsn rename
+type RenameOptions struct {
+	Extension string
+	Template  string
+	Sort      string
+}
+
+// RenameChange is a single planned rename, Error is set when no valid name could be derived
+type RenameChange struct {
+	Old   string `json:"old"`
+	New   string `json:"new"`
+	Error string `json:"error,omitempty"`
+}
+
+// PlanRename computes the renames ApplyRename would do in dir without touching it
+func PlanRename(dir string, opts RenameOptions) ([]RenameChange, error) {
+	renameOpts, err := opts.resolve()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading directory: %w", err)
+	}
+	plan, err := buildRenamePlan(entries, renameOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make([]RenameChange, 0, len(plan))
+	for _, op := range plan {
+		change := RenameChange{Old: op.OldName, New: op.NewName}
+		if op.Err != nil {
+			change.Error = op.Err.Error()
+		} else if op.OldName == op.NewName {
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// ApplyRename renames the files of dir like gsn rename and returns how many were renamed
+func ApplyRename(dir string, opts RenameOptions) (int, error) {
+	renameOpts, err := opts.resolve()
+	if err != nil {
+		return 0, err
+	}
+	return renameDirectory(dir, renameOpts, nil)
+}
+
+func (o RenameOptions) resolve() (renameOptions, error) {
+	extension, sortOrder := o.Extension, o.Sort
+	if extension == "" {
+		extension = "txt"
+	}
+	if sortOrder == "" {
+		sortOrder = string(sortName)
+	}
+	order, err := parseRenameSort(sortOrder)
+	if err != nil {
+		return renameOptions{}, err
+	}
+	return renameOptions{Extension: strings.TrimPrefix(extension, "."), Template: o.Template, Sort: order}, nil
+}
@@ -0,0 +1,234 @@
+package files
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/ignore"
+	"gsn-dev-tools/internals/output"
+
+	"github.com/spf13/cobra"
+)
+
+func MapArchiveCmd() *cobra.Command {
+	mapCmd := cobra.Command{
+		Use:   "map <path>",
+		Short: "Prints how filesystem paths map to archive entry names without compressing",
+		Long: `Walks <path> exactly like cmp does and prints every filesystem path next to the entry name it gets inside the
+archive, including directories and symlinks. Nothing is read or written, use it to debug the archive layout.`,
+		Example: `  gsn cmp map ./project
+  gsn cmp map ./project/ --tsv`,
+		Args: cobra.ExactArgs(1),
+		Run:  MapArchive,
+	}
+
+	addArchiveFilterFlags(&mapCmd)
+	addGitAttributesFlag(&mapCmd)
+	output.AddFlags(&mapCmd)
+	dryrun.ReadOnly(&mapCmd)
+	return &mapCmd
+}
+
+func MapArchive(cmd *cobra.Command, args []string) {
+	opts, err := output.OptionsFromFlags(cmd)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+
+	filter, err := archiveFilterFromFlags(cmd, args[0])
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+
+	rows, err := archiveMappings(args[0], filter)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	if err := output.Render(os.Stdout, archiveMapColumns, rows, opts); err != nil {
+		clierr.Fatalf("%v", err)
+	}
+}
+
+// archiveMappings lists the entries cmp writes for path, in archive order
+func archiveMappings(path string, filter archiveFilter) ([]archiveMapping, error) {
+	var rows []archiveMapping
+	err := walkArchiveEntries(path, filter, func(filePath string, name string, info os.FileInfo) error {
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, _ = os.Readlink(filePath)
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+
+		rows = append(rows, archiveMapping{Path: filePath, Name: name, Type: entryFromHeader(header, "").Type, Link: link})
+		return nil
+	})
+	return rows, err
+}
+
+// archiveMapping is a single row of `cmp map`
+type archiveMapping struct {
+	Path string
+	Name string
+	Type string
+	Link string
+}
+
+var archiveMapColumns = []output.Column[archiveMapping]{
+	{Name: "path", Value: func(m archiveMapping) any { return m.Path }},
+	{Name: "entry", Value: func(m archiveMapping) any { return m.Name }, Display: func(m archiveMapping) string {
+		switch {
+		case m.Type == "dir":
+			return m.Name + "/"
+		case m.Link != "":
+			return m.Name + " -> " + m.Link
+		}
+		return m.Name
+	}},
+	{Name: "type", Value: func(m archiveMapping) any { return m.Type }},
+}
+
+// archiveFilter leaves entries below the source directory out of an archive. An entry matching an exclude
+// pattern is skipped, with its contents for a directory, unless it matches an include pattern. Patterns match
+// the entry name or its path relative to the source directory. With LimitDepth, entries more than MaxDepth
+// levels below the source are skipped too, the directories at MaxDepth are kept without their contents.
+// Entries the .gitattributes files of Attributes mark export-ignore are skipped whatever the include patterns.
+type archiveFilter struct {
+	Excludes []string
+	Includes []string
+
+	LimitDepth bool
+	MaxDepth   int
+
+	Attributes *ignore.Attributes
+}
+
+// skips reports whether the entry at filePath is left out, not looking at the directories above it
+func (f archiveFilter) skips(root string, filePath string) bool {
+	root, filePath = filepath.Clean(root), filepath.Clean(filePath)
+	if filePath == root {
+		return false
+	}
+	if f.LimitDepth && pathDepth(root, filePath) > f.MaxDepth {
+		return true
+	}
+	if f.Attributes != nil {
+		info, err := os.Lstat(filePath)
+		if f.Attributes.ExportIgnored(filePath, err == nil && info.IsDir()) {
+			return true
+		}
+	}
+	if len(f.Excludes) == 0 {
+		return false
+	}
+	return matchesGlob(root, filePath, f.Excludes) && !matchesGlob(root, filePath, f.Includes)
+}
+
+// skipsBelow reports whether the entry at filePath or a directory between it and root is left out
+func (f archiveFilter) skipsBelow(root string, filePath string) bool {
+	if len(f.Excludes) == 0 && !f.LimitDepth && f.Attributes == nil {
+		return false
+	}
+	root = filepath.Clean(root)
+	for p := filepath.Clean(filePath); p != root && p != filepath.Dir(p); p = filepath.Dir(p) {
+		if f.skips(root, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// walkDepth is the depth limit of the filter as walkParallel takes it
+func (f archiveFilter) walkDepth() int {
+	if !f.LimitDepth {
+		return unlimitedDepth
+	}
+	return f.MaxDepth
+}
+
+// descends reports whether the contents of the directory at dirPath are walked, false at the depth limit
+func (f archiveFilter) descends(root string, dirPath string) bool {
+	return !f.LimitDepth || pathDepth(filepath.Clean(root), filepath.Clean(dirPath)) < f.MaxDepth
+}
+
+// pathDepth counts the levels filePath is below root, root itself is at depth 0
+func pathDepth(root string, filePath string) int {
+	rel, err := filepath.Rel(root, filePath)
+	if err != nil || rel == "." {
+		return 0
+	}
+	return strings.Count(rel, string(filepath.Separator)) + 1
+}
+
+// walkArchiveEntries calls fn for every entry cmp writes for path, in archive order, with the entry name.
+// Directories are walked without following symlinks, a single file argument is stored under its base name.
+// Entries left out by filter are not visited.
+func walkArchiveEntries(path string, filter archiveFilter, fn func(filePath string, name string, info os.FileInfo) error) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	base := filepath.Base(absPath)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fn(path, base, info)
+	}
+
+	return filepath.Walk(path, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		name, err := archiveEntryName(path, base, filePath)
+		if err != nil {
+			return err
+		}
+		// The source directory itself gets no entry, its name prefixes every other entry. At depth 0 it is
+		// the only one, so extracting the archive still creates it.
+		if name == base {
+			if !filter.descends(path, filePath) {
+				if err := fn(filePath, name, info); err != nil {
+					return err
+				}
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filter.skips(path, filePath) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if err := fn(filePath, name, info); err != nil {
+			return err
+		}
+		if info.IsDir() && !filter.descends(path, filePath) {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
+
+// archiveEntryName is the slash separated name of filePath inside an archive of root, prefixed with base
+func archiveEntryName(root string, base string, filePath string) (string, error) {
+	rel, err := filepath.Rel(root, filePath)
+	if err != nil {
+		return "", err
+	}
+	if rel == "." {
+		return base, nil
+	}
+	return base + "/" + filepath.ToSlash(rel), nil
+}
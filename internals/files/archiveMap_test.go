@@ -0,0 +1,201 @@
+package files
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"gsn-dev-tools/internals/output"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+// writeMapFixture creates project/ below dir with nested directories, an empty directory and a symlink
+func writeMapFixture(t *testing.T, dir string) {
+	t.Helper()
+	writeTree(t, filepath.Join(dir, "project"), map[string]string{
+		"README.md":       "# project\n",
+		"src/main.go":     "package main\n",
+		"src/lib/util.go": "package lib\n",
+		"empty/":          "",
+	})
+	if err := os.Symlink("src/main.go", filepath.Join(dir, "project", "main-link")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestArchiveMapGolden pins the table of cmp map in testdata/map. The paths are relative, as typed, so the
+// tests run from the fixture directory.
+func TestArchiveMapGolden(t *testing.T) {
+	goldenDir, err := filepath.Abs(filepath.Join("testdata", "map"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	writeMapFixture(t, dir)
+
+	tests := []struct {
+		golden string
+		cwd    string
+		path   string
+	}{
+		{"nested", ".", "project"},
+		{"trailing-slash", ".", "project/"},
+		{"dot", "project", "."},
+		{"single-file", ".", "project/src/main.go"},
+		{"subdirectory", "project", "src/../src"},
+	}
+	for _, test := range tests {
+		t.Run(test.golden, func(t *testing.T) {
+			t.Chdir(filepath.Join(dir, test.cwd))
+			rows, err := archiveMappings(test.path, archiveFilter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got bytes.Buffer
+			if err := output.Render(&got, archiveMapColumns, rows, output.Options{Plain: true}); err != nil {
+				t.Fatal(err)
+			}
+
+			want, err := os.ReadFile(filepath.Join(goldenDir, test.golden+".golden"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != string(want) {
+				t.Errorf("cmp map %s =\n%s\nwant testdata/map/%s.golden\n%s", test.path, got.String(), test.golden, want)
+			}
+		})
+	}
+}
+
+// TestArchiveMapMatchesArchive checks the mapping against the entries cmp really writes
+func TestArchiveMapMatchesArchive(t *testing.T) {
+	dir := t.TempDir()
+	writeMapFixture(t, dir)
+	t.Chdir(dir)
+
+	for _, path := range []string{"project", "project/", "project/src/main.go"} {
+		rows, err := archiveMappings(path, archiveFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var mapped []string
+		for _, row := range rows {
+			mapped = append(mapped, row.Name)
+		}
+
+		archive := filepath.Join(t.TempDir(), "out.tar.gz")
+		_, err = compressPath(path, compressOptions{Output: archive, SkipSpaceCheck: true, Progress: progressbar.DefaultBytesSilent(-1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var written []string
+		for _, e := range readFixtureTar(t, archive) {
+			written = append(written, e.Name)
+		}
+		if !slices.Equal(mapped, written) {
+			t.Errorf("%s: cmp map lists %v, the archive holds %v", path, mapped, written)
+		}
+	}
+}
+
+// depthFixture is a tree four levels deep, with sizes that tell the levels apart in the sizing pass
+var depthFixture = map[string]string{
+	"config.yaml":              "a: 1\n",
+	".env":                     "A=1\n",
+	"empty/":                   "",
+	"src/main.go":              "package main\n",
+	"src/lib/util.go":          "package lib\n\n",
+	"src/lib/deep/x.go":        "package deep\n\n\n",
+	"src/lib/deep/er/most.txt": "the bottom\n",
+}
+
+func TestMaxDepthEntrySets(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "project")
+	writeTree(t, source, depthFixture)
+
+	tests := []struct {
+		depth int
+		want  []string
+		files int
+		size  int64
+	}{
+		// The source directory alone, so extracting still creates it
+		{0, []string{"project"}, 0, 0},
+		// Directories at the limit are kept without their contents
+		{1, []string{"project/.env", "project/config.yaml", "project/empty", "project/src"}, 2, 9},
+		{2, []string{"project/.env", "project/config.yaml", "project/empty", "project/src", "project/src/lib", "project/src/main.go"}, 3, 22},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprint("depth ", test.depth), func(t *testing.T) {
+			filter, err := archiveFilterFor("", nil, test.depth, source, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			archive := filepath.Join(t.TempDir(), "project.tar")
+			opts := compressOptions{Format: formatTar, Output: archive, Filter: filter, SkipSpaceCheck: true, Progress: progressbar.DefaultBytesSilent(-1)}
+			result, err := compressPath(source, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var written []string
+			for _, e := range readFixtureTar(t, archive) {
+				written = append(written, e.Name)
+			}
+			slices.Sort(written)
+			if !slices.Equal(written, test.want) {
+				t.Errorf("archive holds %q,\nwant %q", written, test.want)
+			}
+			if result.FileCount != test.files || result.SourceSize != test.size {
+				t.Errorf("archived %d files of %d bytes, want %d of %d", result.FileCount, result.SourceSize, test.files, test.size)
+			}
+
+			// cmp map, both sizing passes and du see the same levels
+			rows, err := archiveMappings(source, filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var mapped []string
+			for _, row := range rows {
+				mapped = append(mapped, row.Name)
+			}
+			slices.Sort(mapped)
+			if !slices.Equal(mapped, written) {
+				t.Errorf("cmp map lists %q, the archive holds %q", mapped, written)
+			}
+			for name, measure := range map[string]func(string, archiveFilter) (sourceStats, error){"parallel": measureDirectory, "streaming": measureDirectoryStreaming} {
+				stats, err := measure(source, filter)
+				if err != nil || stats.Files != test.files || stats.Size != test.size {
+					t.Errorf("%s sizing pass = %d files of %d bytes, %v", name, stats.Files, stats.Size, err)
+				}
+			}
+			entries, err := walkParallel(source, defaultWalkWorkers, test.depth)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var duFiles int
+			for _, e := range aggregateBySubdir(source, entries) {
+				duFiles += e.Files
+			}
+			if duFiles != test.files {
+				t.Errorf("du counts %d files, want %d", duFiles, test.files)
+			}
+
+			// The cut off directories come back empty
+			dest := t.TempDir()
+			conflicts, perms := testExtractPolicies(dest)
+			if _, err := extractAll(archive, dest, conflicts, perms, nil); err != nil {
+				t.Fatal(err)
+			}
+			if test.depth == 1 {
+				if children, err := os.ReadDir(filepath.Join(dest, "project", "src")); err != nil || len(children) != 0 {
+					t.Errorf("extracted src/ holds %d entries, %v", len(children), err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,612 @@
+package files
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/config"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/hooks"
+	"gsn-dev-tools/internals/notify"
+	"gsn-dev-tools/internals/output"
+	"gsn-dev-tools/internals/progress"
+	"gsn-dev-tools/internals/signing"
+	"gsn-dev-tools/internals/style"
+	"gsn-dev-tools/internals/units"
+
+	"filippo.io/age"
+	"github.com/spf13/cobra"
+)
+
+// backupTimeLayout dates the archives of a profile, names sort in creation order
+const backupTimeLayout = "20060102-150405"
+
+// checksumSuffix is appended to the archive path for its sha256sum compatible checksum
+const checksumSuffix = ".sha256sum"
+
+// Status of a stage in the report of gsn backup run
+const (
+	stageOK      = "ok"
+	stageFailed  = "failed"
+	stageSkipped = "skipped"
+)
+
+// backupStageReport is the outcome of one stage of a backup run
+type backupStageReport struct {
+	Stage    string
+	Status   string
+	Duration time.Duration
+	Detail   string
+}
+
+// backupStageColumns declares the columns of the report of `backup run`
+var backupStageColumns = []output.Column[backupStageReport]{
+	{Name: "stage", Value: func(r backupStageReport) any { return r.Stage }},
+	{Name: "status", Value: func(r backupStageReport) any { return r.Status }},
+	{Name: "duration", Value: func(r backupStageReport) any { return r.Duration }, Display: func(r backupStageReport) string { return units.FormatDuration(r.Duration.Round(time.Millisecond)) }},
+	{Name: "detail", Value: func(r backupStageReport) any { return r.Detail }},
+}
+
+// backupLocal is the location of the archives in the destination of a profile
+const backupLocal = "local"
+
+// backupArchive is an archive of a profile found in its destination or on its remote
+type backupArchive struct {
+	Path string
+	// Location is backupLocal or the remote of the profile
+	Location  string
+	CreatedAt time.Time
+	Size      int64
+}
+
+// backupArchiveColumns declares the columns available to `backup list`
+var backupArchiveColumns = []output.Column[backupArchive]{
+	{Name: "archive", Value: func(a backupArchive) any { return filepath.Base(a.Path) }},
+	{Name: "location", Value: func(a backupArchive) any { return a.Location }},
+	{Name: "created", Value: func(a backupArchive) any { return a.CreatedAt }, Display: func(a backupArchive) string { return a.CreatedAt.Format(time.DateTime) }},
+	{Name: "size", Value: func(a backupArchive) any { return a.Size }, Display: func(a backupArchive) string { return units.FormatBytes(a.Size) }},
+}
+
+func BackupCmd() *cobra.Command {
+	backupCmd := &cobra.Command{
+		Use:   "backup",
+		Short: "Runs the backup profiles of the config file",
+		Long: `A backup profile in the backups section of the config file names a source, what to leave out, the archive
+format, the directory the archives go to, how many of them to keep and who to tell:
+
+  backups:
+    photos:
+      source: ~/Pictures
+      exclude: ["*.tmp"]
+      format: tar.zst
+      destination: /mnt/backup/photos
+      remote: sftp://backup@nas/srv/backups/photos
+      keep: 7
+      notify: desktop
+      ssh_key: ~/.ssh/backup_ed25519
+      recipients_file: ~/.config/gsn/backup-recipients.txt
+
+preset takes the presets of gsn cmp --preset. remote is optional: an existing directory of an SFTP host the
+archives are uploaded to and rotated in too, reached with the keys and known_hosts --remote uses. sign: true, sign_key or ssh_key sign the checksum file into
+<archive>.sha256sum.sig as --sign, --sign-key and --ssh-key of gsn cmp do; an encrypted key takes its
+passphrase from $GSN_SIGN_PASSPHRASE when the run is not interactive. A source inside a git work tree leaves out
+the paths its .gitattributes files mark export-ignore, like gsn cmp.
+
+recipients, a list of age or SSH public keys, and recipients_file, a file listing one per line, encrypt the
+archives with age as --recipient and --recipients-file of gsn cmp do; any one of the keys decrypts them. The
+archive name gets .age appended and the format must be tar.gz, tar or tar.zst. verify reads such an archive back
+only when one of the identities gsn extract uses by default decrypts it, an archive encrypted to offline keys
+only is reported as not read back. The manifest next to the archive lists the entry names and hashes in the
+clear.
+
+gsn backup dedup keeps snapshots in a deduplicating chunk store instead of full archives, for trees that
+change little from one run to the next.`,
+		Example: `  gsn backup run photos
+  gsn backup list photos
+  gsn backup dedup ~/projects --store /mnt/backup/store`,
+	}
+
+	backupCmd.AddCommand(backupRunCmd())
+	backupCmd.AddCommand(backupListCmd())
+	backupCmd.AddCommand(backupDedupCmd())
+	return backupCmd
+}
+
+func backupRunCmd() *cobra.Command {
+	runCmd := &cobra.Command{
+		Use:   "run <profile>",
+		Short: "Archives a backup profile and rotates its old archives",
+		Long: `Runs the stages of a backup one after the other and stops at the first that fails:
+
+  compress  archives the source into <destination>/<profile>-<date>.<ext> with a manifest
+  verify    reads the archive back and hashes every file against the manifest
+  checksum  writes <archive>.sha256sum, checkable with sha256sum -c, and signs it when the profile says so
+  upload    copies the archive and its manifest, checksum and signature to the remote of the profile, if any
+  rotate    removes all but the newest keep archives of the profile, locally and on the remote
+
+An archive failing verify or checksum is removed again, so rotation never drops a good archive for a bad one. A
+failed upload keeps the local archive and leaves nothing on the remote, and skips rotation.
+The notify target of the profile is told about every run, failed ones included, and a report of the stages is
+printed at the end. On a terminal a header line checks off the stages as they complete above the progress of
+the running one, elsewhere every stage prints a line as it starts and ends. --status-file keeps the stage and
+progress of a run in a JSON file, for runs under cron.
+
+--parallel-hash, or parallel_hash: true in the profile, makes the checksum a gsn-tree-sha256:<chunksize>:<hex>
+tree hash: the archive is hashed in chunks across every CPU, much faster than SHA-256 for large archives. Such
+a checksum file is checked by gsn cmp verify --checksum, sha256sum -c does not read it.
+
+--bwlimit caps the upload to the remote, e.g. --bwlimit 5MB/s keeps a run during work hours from saturating
+the uplink. The other stages read and write the local disk at full speed.`,
+		Example: `  gsn backup run photos
+  gsn backup run photos --tsv
+  gsn backup run photos --parallel-hash
+  gsn backup run photos --bwlimit 5MB/s`,
+		Args: cobra.ExactArgs(1),
+		Run:  RunBackup,
+	}
+
+	output.AddFlags(runCmd)
+	progress.AddStatusFlags(runCmd)
+	runCmd.Flags().Bool("parallel-hash", false, "Write a gsn-tree-sha256 checksum hashed across every CPU instead of a SHA-256")
+	addBandwidthFlag(runCmd)
+	return runCmd
+}
+
+func backupListCmd() *cobra.Command {
+	listCmd := &cobra.Command{
+		Use:   "list <profile>",
+		Short: "Lists the archives of a backup profile",
+		Long:  "Lists the archives of a profile in its destination and on its remote, oldest first, with their dates and sizes.",
+		Example: `  gsn backup list photos
+  gsn backup list photos --sort size:desc`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			opts, err := output.OptionsFromFlags(cmd)
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			profile, err := loadBackupProfile(args[0])
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			archives, err := listBackupArchives(args[0], profile.Destination)
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			if profile.Remote != "" {
+				remoteArchives, err := listRemoteBackupArchives(args[0], profile.Remote)
+				if err != nil {
+					clierr.Fatalf("%v", err)
+				}
+				archives = append(archives, remoteArchives...)
+				sort.SliceStable(archives, func(i, j int) bool { return archives[i].CreatedAt.Before(archives[j].CreatedAt) })
+			}
+			if err := output.Render(os.Stdout, backupArchiveColumns, archives, opts); err != nil {
+				clierr.Fatalf("%v", err)
+			}
+		},
+	}
+
+	output.AddFlags(listCmd)
+	dryrun.ReadOnly(listCmd)
+	return listCmd
+}
+
+// loadBackupProfile reads a profile from the config file with its paths expanded
+func loadBackupProfile(name string) (config.BackupProfile, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return config.BackupProfile{}, err
+	}
+	profile, ok := cfg.Backups[name]
+	if !ok {
+		return profile, cfg.MissingValue(clierr.Newf(clierr.NotFound, "backup profile '%s' is not defined in the config file", name))
+	}
+	if profile.Source == "" || profile.Destination == "" {
+		return profile, fmt.Errorf("backup profile '%s' needs a source and a destination", name)
+	}
+	if profile.Keep < 0 {
+		return profile, fmt.Errorf("keep of backup profile '%s' cannot be negative", name)
+	}
+	profile.Source = config.ExpandHome(profile.Source)
+	profile.Destination = config.ExpandHome(profile.Destination)
+	return profile, nil
+}
+
+// backupRun carries what the stages of a run share
+type backupRun struct {
+	Name    string
+	Profile config.BackupProfile
+	Format  archiveFormat
+	Filter  archiveFilter
+
+	// Signer signs the checksum file when the profile asks for it
+	Signer *signing.Signer
+	// ParallelHash writes a tree hash as checksum instead of a SHA-256
+	ParallelHash bool
+	// Recipients encrypt the archive with age when the profile names any
+	Recipients []age.Recipient
+	// Limiter caps the upload writes to the remote, nil means unlimited
+	Limiter *bandwidthLimiter
+
+	Result *CompressResult
+	// Remote is the remote of the profile once upload connected to it
+	Remote *backupRemote
+	// Removed lists the archives dropped by rotation, RemovedRemote those dropped on the remote
+	Removed       []string
+	RemovedRemote []string
+
+	// Bar counts the progress of the running stage, Log prints messages above it
+	Bar progress.Tracker
+	Log io.Writer
+}
+
+// backupStage is one step of a run, its detail goes into the report
+type backupStage struct {
+	Name string
+	Run  func(run *backupRun) (string, error)
+	// Discard removes the archive when the stage fails, for the stages vouching for the archive itself
+	Discard bool
+}
+
+var backupStages = []backupStage{
+	{Name: "compress", Run: (*backupRun).compress, Discard: true},
+	{Name: "verify", Run: (*backupRun).verify, Discard: true},
+	{Name: "checksum", Run: (*backupRun).checksum, Discard: true},
+	{Name: "upload", Run: (*backupRun).upload},
+	{Name: "rotate", Run: (*backupRun).rotate},
+}
+
+// deliverNotification sends the event of a run to the notify target of its profile. It is a variable so tests
+// record the events rather than sending them.
+var deliverNotification = notify.Deliver
+
+func RunBackup(cmd *cobra.Command, args []string) {
+	startTime := time.Now()
+	opts, err := output.OptionsFromFlags(cmd)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	profile, err := loadBackupProfile(args[0])
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	parallelHash, _ := cmd.Flags().GetBool("parallel-hash")
+	run, err := newBackupRun(args[0], profile, parallelHash)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	if run.Limiter, err = bandwidthLimiterFromFlags(cmd); err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	finishStatus := progress.StartStatus(cmd, "backup run")
+	reports, err := run.execute()
+	finishStatus(err)
+	run.notify(startTime, err)
+
+	fmt.Fprintln(os.Stderr)
+	if renderErr := output.Render(os.Stdout, backupStageColumns, reports, opts); renderErr != nil {
+		clierr.Fatalf("%v", renderErr)
+	}
+	if err != nil {
+		clierr.Fatalf("Backup '%s' failed: %v", run.Name, err)
+	}
+	fmt.Fprintf(os.Stderr, style.Success()+"Backup '%s' written to %s (Time: %s)\n", run.Name, run.Result.ArchivePath, units.FormatDuration(time.Since(startTime)))
+}
+
+// newBackupRun prepares the run of a profile, refusing the settings it cannot honour before anything is written
+func newBackupRun(name string, profile config.BackupProfile, parallelHash bool) (*backupRun, error) {
+	var err error
+	format := formatTarGz
+	if profile.Format != "" {
+		if format, err = parseFormat(profile.Format); err != nil {
+			return nil, fmt.Errorf("invalid format in backup profile '%s': %w", name, err)
+		}
+	}
+	filter, err := archiveFilterFor(profile.Preset, profile.Exclude, unlimitedDepth, profile.Source, true)
+	if err != nil {
+		return nil, err
+	}
+	if profile.Remote != "" {
+		if _, _, err := parseBackupRemote(profile.Remote); err != nil {
+			return nil, fmt.Errorf("backup profile '%s': %w", name, err)
+		}
+	}
+
+	run := &backupRun{Name: name, Profile: profile, Format: format, Filter: filter, ParallelHash: parallelHash || profile.ParallelHash}
+	for _, key := range profile.Recipients {
+		r, err := parseRecipient(key)
+		if err != nil {
+			return nil, fmt.Errorf("backup profile '%s' has an invalid recipient '%s': %w", name, key, err)
+		}
+		run.Recipients = append(run.Recipients, r)
+	}
+	if profile.RecipientsFile != "" {
+		recipients, err := readRecipientsFile(profile.RecipientsFile)
+		if err != nil {
+			return nil, fmt.Errorf("backup profile '%s': %w", name, err)
+		}
+		run.Recipients = append(run.Recipients, recipients...)
+	}
+	if len(run.Recipients) > 0 && format.Container != containerTar {
+		return nil, fmt.Errorf("backup profile '%s' encrypts its archives, which needs format tar.gz, tar or tar.zst", name)
+	}
+	if profile.Sign || profile.SignKey != "" || profile.SSHKey != "" {
+		if profile.SignKey != "" && profile.SSHKey != "" {
+			return nil, fmt.Errorf("backup profile '%s' sets both sign_key and ssh_key", name)
+		}
+		key := signing.Key{PGPKey: config.ExpandHome(profile.SignKey), SSHKey: config.ExpandHome(profile.SSHKey)}
+		if run.Signer, err = signing.NewSigner(key); err != nil {
+			return nil, err
+		}
+	}
+	return run, nil
+}
+
+// notify tells the notify target of the profile and the post hooks how the run ended
+func (run *backupRun) notify(startTime time.Time, err error) {
+	ev := notify.NewEvent("backup run", startTime, err)
+	if run.Result != nil && err == nil {
+		ev.ArchivePath = run.Result.ArchivePath
+		ev.ArchiveSize = run.Result.ArchiveSize
+		ev.Counts = map[string]int{"files": run.Result.FileCount, "rotated": len(run.Removed)}
+		if run.Remote != nil {
+			ev.Counts["rotated_remote"] = len(run.RemovedRemote)
+		}
+	}
+	deliverNotification(run.Profile.Notify, ev)
+	hooks.Post(ev)
+}
+
+// execute runs the stages in order, those after a failed stage are reported as skipped. An archive that did not
+// make it through the stages before rotation is removed.
+func (run *backupRun) execute() ([]backupStageReport, error) {
+	reports := make([]backupStageReport, 0, len(backupStages))
+	names := make([]string, len(backupStages))
+	for i, stage := range backupStages {
+		names[i] = stage.Name
+	}
+	phases := progress.NewPhases(names...)
+	defer phases.Stop()
+	run.Log = phases.Writer()
+	defer run.closeRemote()
+
+	var failed error
+	for _, stage := range backupStages {
+		if failed != nil {
+			phases.Skip(stage.Name)
+			reports = append(reports, backupStageReport{Stage: stage.Name, Status: stageSkipped})
+			continue
+		}
+
+		run.Bar = phases.Start(stage.Name)
+		started := time.Now()
+		detail, err := stage.Run(run)
+		phases.Done(stage.Name, err)
+		report := backupStageReport{Stage: stage.Name, Status: stageOK, Duration: time.Since(started), Detail: detail}
+		if err != nil {
+			report.Status, report.Detail = stageFailed, err.Error()
+			failed = fmt.Errorf("%s: %w", stage.Name, err)
+			if stage.Discard {
+				run.discard()
+			}
+		}
+		reports = append(reports, report)
+	}
+	return reports, failed
+}
+
+func (run *backupRun) compress() (string, error) {
+	if err := os.MkdirAll(run.Profile.Destination, 0o755); err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%s-%s%s", run.Name, time.Now().Format(backupTimeLayout), run.Format.Extension())
+	if len(run.Recipients) > 0 {
+		name += encryptedExt
+	}
+	result, err := compressPath(run.Profile.Source, compressOptions{
+		Manifest:   true,
+		Format:     run.Format,
+		Filter:     run.Filter,
+		Output:     filepath.Join(run.Profile.Destination, name),
+		Recipients: run.Recipients,
+		Progress:   run.Bar,
+		Warnings:   run.Log,
+	})
+	if err != nil {
+		return "", err
+	}
+	run.Result = result
+	return fmt.Sprintf("%s file(s), %s -> %s", units.FormatInt(int64(result.FileCount)), units.FormatBytes(result.SourceSize), units.FormatBytes(result.ArchiveSize)), nil
+}
+
+func (run *backupRun) verify() (string, error) {
+	if len(run.Recipients) > 0 {
+		ok, err := decryptable(run.Result.ArchivePath)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "encrypted, not read back: no local identity decrypts it", nil
+		}
+	}
+	m, err := loadVerifiedManifest(run.Result.ArchivePath)
+	if err != nil {
+		return "", err
+	}
+	if m == nil {
+		return "", fmt.Errorf("the manifest of '%s' is missing", run.Result.ArchivePath)
+	}
+	problems, checked, err := verifyAgainstManifest(run.Result.ArchivePath, m, 0)
+	if err != nil {
+		return "", err
+	}
+	if len(problems) > 0 {
+		return "", fmt.Errorf("%d problem(s), the first: %s", len(problems), problems[0])
+	}
+	return fmt.Sprintf("%s entries, %s hashed", units.FormatInt(int64(len(m.Entries))), units.FormatInt(int64(checked))), nil
+}
+
+func (run *backupRun) checksum() (string, error) {
+	digest, err := digestFile(run.Result.ArchivePath, run.ParallelHash)
+	if err != nil {
+		return "", err
+	}
+	line := fmt.Sprintf("%s  %s\n", digest, filepath.Base(run.Result.ArchivePath))
+	if err := output.WriteFileAtomic(run.Result.ArchivePath+checksumSuffix, []byte(line), 0o644); err != nil {
+		return "", err
+	}
+	detail := digest.Hex[:12]
+	if digest.Format != digestSHA256 {
+		detail = digest.Format + " " + detail
+	}
+	if run.Signer == nil {
+		return detail, nil
+	}
+	if _, err := run.Signer.SignFile(context.Background(), run.Result.ArchivePath+checksumSuffix); err != nil {
+		return "", err
+	}
+	return detail + ", signed", nil
+}
+
+func (run *backupRun) upload() (string, error) {
+	if run.Profile.Remote == "" {
+		return "no remote in the profile", nil
+	}
+	r, err := openBackupRemote(run.Profile.Remote)
+	if err != nil {
+		return "", err
+	}
+	run.Remote = r
+	sent, err := r.upload(run.Result.ArchivePath, run.Bar, run.Limiter)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s to %s", units.FormatBytes(sent), run.Profile.Remote), nil
+}
+
+func (run *backupRun) rotate() (string, error) {
+	if run.Profile.Keep == 0 {
+		return "keeping all archives", nil
+	}
+	archives, err := listBackupArchives(run.Name, run.Profile.Destination)
+	if err != nil {
+		return "", err
+	}
+	for _, a := range archivesBeyond(archives, run.Profile.Keep) {
+		if err := removeBackupArchive(a.Path); err != nil {
+			return "", err
+		}
+		run.Removed = append(run.Removed, a.Path)
+	}
+	detail := fmt.Sprintf("%d of %d archive(s) kept", len(archives)-len(run.Removed), run.Profile.Keep)
+	if len(run.Removed) > 0 {
+		detail = fmt.Sprintf("removed %d archive(s)", len(run.Removed))
+	}
+	if run.Remote == nil {
+		return detail, nil
+	}
+
+	remoteArchives, err := run.Remote.list(run.Name)
+	if err != nil {
+		return "", err
+	}
+	for _, a := range archivesBeyond(remoteArchives, run.Profile.Keep) {
+		if err := run.Remote.remove(a.Path); err != nil {
+			return "", err
+		}
+		run.RemovedRemote = append(run.RemovedRemote, a.Path)
+	}
+	return fmt.Sprintf("%s, %d removed on the remote", detail, len(run.RemovedRemote)), nil
+}
+
+// archivesBeyond returns the archives of a list sorted oldest first that rotation removes to keep keep of them
+func archivesBeyond(archives []backupArchive, keep int) []backupArchive {
+	if len(archives) <= keep {
+		return nil
+	}
+	return archives[:len(archives)-keep]
+}
+
+// closeRemote closes the connection upload opened
+func (run *backupRun) closeRemote() {
+	if run.Remote != nil {
+		run.Remote.Close()
+	}
+}
+
+// discard removes the archive of a run that failed before rotation, with its sidecar files
+func (run *backupRun) discard() {
+	if run.Result == nil {
+		return
+	}
+	if err := removeBackupArchive(run.Result.ArchivePath); err != nil {
+		fmt.Fprintf(run.Log, style.Warning()+"Failed to remove '%s': %v\n", run.Result.ArchivePath, err)
+	}
+}
+
+// backupFiles lists an archive and the manifest, checksum and signature files written next to it, the archive
+// first
+func backupFiles(archivePath string) []string {
+	return []string{archivePath, archivePath + manifestSuffix, archivePath + manifestSuffix + manifestHashSuffix, archivePath + checksumSuffix, archivePath + checksumSuffix + signing.Suffix}
+}
+
+// removeBackupArchive deletes an archive with its manifest, checksum and signature files
+func removeBackupArchive(archivePath string) error {
+	for _, p := range backupFiles(archivePath) {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// listBackupArchives returns the archives of a profile in dir, oldest first. Only names written by backup run
+// are considered, so rotation never touches other files of the directory.
+func listBackupArchives(name string, dir string) ([]backupArchive, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var archives []backupArchive
+	for _, entry := range entries {
+		created, ok := backupCreatedAt(name, entry.Name())
+		if !ok || !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		archives = append(archives, backupArchive{Path: filepath.Join(dir, entry.Name()), Location: backupLocal, CreatedAt: created, Size: info.Size()})
+	}
+	sort.Slice(archives, func(i, j int) bool { return archives[i].CreatedAt.Before(archives[j].CreatedAt) })
+	return archives, nil
+}
+
+// backupCreatedAt returns the date in the file name of an archive of profile name, false for names backup run
+// does not write
+func backupCreatedAt(name string, fileName string) (time.Time, bool) {
+	pattern := regexp.MustCompile(`^` + regexp.QuoteMeta(name) + `-(\d{8}-\d{6})(\..+)$`)
+	m := pattern.FindStringSubmatch(fileName)
+	if m == nil {
+		return time.Time{}, false
+	}
+	if _, ok := formatOfName(strings.TrimSuffix(m[2], encryptedExt)); !ok {
+		return time.Time{}, false
+	}
+	created, err := time.ParseInLocation(backupTimeLayout, m[1], time.Local)
+	return created, err == nil
+}
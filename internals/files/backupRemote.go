@@ -0,0 +1,191 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"gsn-dev-tools/internals/progress"
+	"gsn-dev-tools/internals/remote"
+)
+
+// partialSuffix marks a file being uploaded, it is renamed into place once complete
+const partialSuffix = ".gsn-partial"
+
+// backupRemote is the directory of an SFTP host a backup profile uploads its archives to
+type backupRemote struct {
+	// Location is the remote as the profile gives it, for messages and backup list
+	Location string
+	Dir      string
+	SFTP     *remote.SFTP
+
+	close func() error
+}
+
+// dialBackupRemote connects to the [user@]host[:port] of a remote and returns its SFTP session and what closes
+// the connection. It is a variable so tests upload to an in-process SFTP server.
+var dialBackupRemote = func(hostSpec string) (*remote.SFTP, func() error, error) {
+	conn, err := remote.Dial(hostSpec)
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn.SFTP, conn.Close, nil
+}
+
+// parseBackupRemote splits sftp://[user@]host[:port]/path into the host to dial and the directory
+func parseBackupRemote(location string) (string, string, error) {
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme != "sftp" || u.Host == "" || u.Path == "" || u.RawQuery != "" {
+		return "", "", fmt.Errorf("invalid remote '%s', expected sftp://[user@]host[:port]/path", location)
+	}
+	hostSpec := u.Host
+	if u.User != nil {
+		hostSpec = u.User.Username() + "@" + u.Host
+	}
+	return hostSpec, u.Path, nil
+}
+
+// openBackupRemote connects to a remote and checks its directory exists
+func openBackupRemote(location string) (*backupRemote, error) {
+	hostSpec, dir, err := parseBackupRemote(location)
+	if err != nil {
+		return nil, err
+	}
+	c, closeConn, err := dialBackupRemote(hostSpec)
+	if err != nil {
+		return nil, err
+	}
+	r := &backupRemote{Location: location, Dir: dir, SFTP: c, close: closeConn}
+	info, err := c.Stat(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		err = fmt.Errorf("the directory %s does not exist on %s, create it first", dir, hostSpec)
+	} else if err == nil && !info.IsDir() {
+		err = fmt.Errorf("%s on %s is not a directory", dir, hostSpec)
+	}
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Close closes the connection to the host
+func (r *backupRemote) Close() error {
+	return r.close()
+}
+
+// upload copies an archive and the sidecar files it has into the directory of the remote and returns the bytes
+// sent. Every file goes through a partial name renamed into place once complete, the archive last, so the
+// remote never lists an archive without its checksum. A failure removes what was uploaded. The limiter caps
+// the writes, nil means unlimited.
+func (r *backupRemote) upload(archivePath string, bar progress.Tracker, limiter *bandwidthLimiter) (sent int64, err error) {
+	files := backupFiles(archivePath)
+	// The sidecar files first, the archive listed first by backupFiles goes last
+	files = append(files[1:len(files):len(files)], files[0])
+	var local []string
+	var total int64
+	for _, p := range files {
+		info, err := os.Stat(p)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		local = append(local, p)
+		total += info.Size()
+	}
+	bar.ChangeMax64(total)
+
+	var uploaded []string
+	defer func() {
+		if err != nil {
+			for _, p := range uploaded {
+				r.SFTP.Remove(p)
+			}
+		}
+	}()
+	for _, p := range local {
+		dst := path.Join(r.Dir, filepath.Base(p))
+		n, err := r.uploadFile(p, dst, bar, limiter)
+		sent += n
+		if err != nil {
+			return sent, err
+		}
+		uploaded = append(uploaded, dst)
+	}
+	return sent, nil
+}
+
+// uploadFile writes the local file src to dst through a partial file removed on failure
+func (r *backupRemote) uploadFile(src string, dst string, bar progress.Tracker, limiter *bandwidthLimiter) (n int64, err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	partial := dst + partialSuffix
+	out, err := r.SFTP.Create(partial, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			out.Close()
+			r.SFTP.Remove(partial)
+		}
+	}()
+	n, err = io.Copy(limiter.Writer(out), io.TeeReader(in, bar))
+	if err != nil {
+		return n, fmt.Errorf("uploading '%s': %w", src, err)
+	}
+	if err = out.Close(); err != nil {
+		return n, fmt.Errorf("uploading '%s': %w", src, err)
+	}
+	return n, r.SFTP.Rename(partial, dst)
+}
+
+// list returns the archives of profile name in the directory of the remote, oldest first
+func (r *backupRemote) list(name string) ([]backupArchive, error) {
+	entries, err := r.SFTP.ReadDir(r.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var archives []backupArchive
+	for _, entry := range entries {
+		created, ok := backupCreatedAt(name, entry.Name())
+		if !ok || !entry.Mode().IsRegular() {
+			continue
+		}
+		archives = append(archives, backupArchive{Path: path.Join(r.Dir, entry.Name()), Location: r.Location, CreatedAt: created, Size: entry.Size()})
+	}
+	sort.Slice(archives, func(i, j int) bool { return archives[i].CreatedAt.Before(archives[j].CreatedAt) })
+	return archives, nil
+}
+
+// remove deletes an archive of the remote with its sidecar files
+func (r *backupRemote) remove(archivePath string) error {
+	for _, p := range backupFiles(archivePath) {
+		if err := r.SFTP.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// listRemoteBackupArchives connects to a remote for the archives of profile name it holds
+func listRemoteBackupArchives(name string, location string) ([]backupArchive, error) {
+	r, err := openBackupRemote(location)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return r.list(name)
+}
@@ -0,0 +1,428 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"gsn-dev-tools/internals/config"
+	"gsn-dev-tools/internals/notify"
+	"gsn-dev-tools/internals/remote"
+	"gsn-dev-tools/internals/remote/sftptest"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+// delivery is a notification a run sent
+type delivery struct {
+	Target string
+	Event  notify.Event
+}
+
+// useNotifier records the notifications of backup runs instead of sending them
+func useNotifier(t *testing.T) *[]delivery {
+	t.Helper()
+	var sent []delivery
+	saved := deliverNotification
+	deliverNotification = func(target string, ev notify.Event) { sent = append(sent, delivery{target, ev}) }
+	t.Cleanup(func() { deliverNotification = saved })
+	return &sent
+}
+
+// fakeRemote is an in-process SFTP server standing in for the hosts of backup remotes
+type fakeRemote struct {
+	Server *sftptest.Server
+	// Dials lists the hosts dialed, Closed counts the connections closed
+	Dials  []string
+	Closed int
+	// Err fails every dial
+	Err error
+}
+
+// useRemote sends the connections of backup remotes to a fake serving root
+func useRemote(t *testing.T, root string) *fakeRemote {
+	t.Helper()
+	fake := &fakeRemote{Server: &sftptest.Server{Root: root}}
+	saved := dialBackupRemote
+	dialBackupRemote = func(hostSpec string) (*remote.SFTP, func() error, error) {
+		fake.Dials = append(fake.Dials, hostSpec)
+		if fake.Err != nil {
+			return nil, nil, fake.Err
+		}
+		return fake.Server.Client(t), func() error { fake.Closed++; return nil }, nil
+	}
+	t.Cleanup(func() { dialBackupRemote = saved })
+	return fake
+}
+
+// seedBackups writes archives of earlier runs with their sidecar files, and files rotation must leave alone
+func seedBackups(t *testing.T, dir string) {
+	t.Helper()
+	writeTree(t, dir, map[string]string{
+		"photos-20240101-000000.tar.gz":                  "oldest",
+		"photos-20240101-000000.tar.gz" + checksumSuffix: "x",
+		"photos-20240101-000000.tar.gz" + manifestSuffix: "x",
+		"photos-20240201-000000.tar.gz":                  "older",
+		"photos-20240201-000000.tar.gz" + checksumSuffix: "x",
+		"other-20230101-000000.tar.gz":                   "another profile",
+		"notes.txt":                                      "not an archive",
+	})
+}
+
+// dirNames lists the names in dir
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+// backupProfile is a profile archiving a small tree of base to base/local and sftp://backup@nas/backups
+func backupProfile(t *testing.T, base string) config.BackupProfile {
+	t.Helper()
+	writeTree(t, filepath.Join(base, "photos"), map[string]string{
+		"2024/beach.jpg":  strings.Repeat("sand", 2000),
+		"2024/forest.jpg": strings.Repeat("tree", 2000),
+		"album.txt":       "summer\n",
+		"cache.tmp":       "left out",
+	})
+	for _, dir := range []string{"local", "remote/backups"} {
+		if err := os.MkdirAll(filepath.Join(base, dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		seedBackups(t, filepath.Join(base, dir))
+	}
+	return config.BackupProfile{
+		Source:      filepath.Join(base, "photos"),
+		Exclude:     []string{"*.tmp"},
+		Destination: filepath.Join(base, "local"),
+		Remote:      "sftp://backup@nas/backups",
+		Keep:        2,
+		Notify:      "https://hooks.example.com/backup",
+	}
+}
+
+// runProfile runs the stages of profile and notifies like gsn backup run does
+func runProfile(t *testing.T, profile config.BackupProfile) (*backupRun, []backupStageReport, error) {
+	t.Helper()
+	run, err := newBackupRun("photos", profile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reports []backupStageReport
+	captureStderr(t, func() {
+		reports, err = run.execute()
+		run.notify(time.Now(), err)
+	})
+	return run, reports, err
+}
+
+// stageStatuses lists stage:status for every report
+func stageStatuses(reports []backupStageReport) []string {
+	var got []string
+	for _, r := range reports {
+		got = append(got, r.Stage+":"+r.Status)
+	}
+	return got
+}
+
+func TestBackupRunEndToEnd(t *testing.T) {
+	base := t.TempDir()
+	profile := backupProfile(t, base)
+	fake := useRemote(t, filepath.Join(base, "remote"))
+	sent := useNotifier(t)
+
+	run, reports, err := runProfile(t, profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"compress:ok", "verify:ok", "checksum:ok", "upload:ok", "rotate:ok"}
+	if got := stageStatuses(reports); !slices.Equal(got, want) {
+		t.Fatalf("stages = %q, want %q", got, want)
+	}
+	if !strings.Contains(reports[0].Detail, "3 file(s)") || !strings.HasSuffix(reports[3].Detail, " to sftp://backup@nas/backups") {
+		t.Errorf("details = %+v", reports)
+	}
+	if reports[4].Detail != "removed 1 archive(s), 1 removed on the remote" {
+		t.Errorf("rotate = %q", reports[4].Detail)
+	}
+
+	// The archive and its sidecar files went up unchanged, the partial names are gone
+	archive := filepath.Base(run.Result.ArchivePath)
+	for _, name := range []string{archive, archive + manifestSuffix, archive + checksumSuffix} {
+		local, err := os.ReadFile(filepath.Join(base, "local", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		uploaded, err := os.ReadFile(filepath.Join(base, "remote", "backups", name))
+		if err != nil || !bytes.Equal(local, uploaded) {
+			t.Errorf("%s on the remote = %d bytes (%v), want the %d of the local file", name, len(uploaded), err, len(local))
+		}
+	}
+	for _, dir := range []string{"local", "remote/backups"} {
+		names := dirNames(t, filepath.Join(base, dir))
+		for _, name := range names {
+			if strings.HasSuffix(name, partialSuffix) {
+				t.Errorf("%s left behind in %s", name, dir)
+			}
+		}
+		// Rotation dropped the oldest archive with its sidecars and nothing of other profiles
+		if slices.Contains(names, "photos-20240101-000000.tar.gz") || slices.Contains(names, "photos-20240101-000000.tar.gz"+checksumSuffix) ||
+			slices.Contains(names, "photos-20240101-000000.tar.gz"+manifestSuffix) {
+			t.Errorf("%s still holds the oldest archive: %q", dir, names)
+		}
+		for _, kept := range []string{"photos-20240201-000000.tar.gz", "other-20230101-000000.tar.gz", "notes.txt", archive} {
+			if !slices.Contains(names, kept) {
+				t.Errorf("%s lost %s: %q", dir, kept, names)
+			}
+		}
+	}
+	if !slices.Equal(fake.Dials, []string{"backup@nas"}) || fake.Closed != 1 {
+		t.Errorf("dials = %q, closed %d times", fake.Dials, fake.Closed)
+	}
+
+	if len(*sent) != 1 {
+		t.Fatalf("sent %d notifications, want 1", len(*sent))
+	}
+	got := (*sent)[0]
+	if got.Target != profile.Notify || got.Event.Command != "backup run" || got.Event.Status != notify.StatusSuccess ||
+		got.Event.ArchivePath != run.Result.ArchivePath || got.Event.ArchiveSize != run.Result.ArchiveSize {
+		t.Errorf("notification = %+v", got)
+	}
+	if counts := got.Event.Counts; counts["files"] != 3 || counts["rotated"] != 1 || counts["rotated_remote"] != 1 {
+		t.Errorf("counts = %v", counts)
+	}
+
+	// backup list shows both locations, oldest first
+	archives, err := listBackupArchives("photos", profile.Destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteArchives, err := listRemoteBackupArchives("photos", profile.Remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, list := range [][]backupArchive{archives, remoteArchives} {
+		if len(list) != 2 || filepath.Base(list[0].Path) != "photos-20240201-000000.tar.gz" || filepath.Base(list[1].Path) != archive {
+			t.Errorf("archives = %+v", list)
+		}
+	}
+	if archives[0].Location != backupLocal || remoteArchives[0].Location != profile.Remote || remoteArchives[1].Size != run.Result.ArchiveSize {
+		t.Errorf("locations = %+v and %+v", archives[0], remoteArchives)
+	}
+}
+
+func TestBackupRunAbortsAtTheFirstFailedStage(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(profile *config.BackupProfile, fake *fakeRemote, base string)
+		stages  []string
+		wantErr string
+		// kept tells whether the new archive stays in the destination
+		kept bool
+	}{
+		{
+			name:    "missing source",
+			setup:   func(profile *config.BackupProfile, fake *fakeRemote, base string) { profile.Source += "-missing" },
+			stages:  []string{"compress:failed", "verify:skipped", "checksum:skipped", "upload:skipped", "rotate:skipped"},
+			wantErr: "compress: ",
+		},
+		{
+			name: "unreachable host",
+			setup: func(profile *config.BackupProfile, fake *fakeRemote, base string) {
+				fake.Err = errors.New("connection refused")
+			},
+			stages:  []string{"compress:ok", "verify:ok", "checksum:ok", "upload:failed", "rotate:skipped"},
+			wantErr: "upload: connection refused",
+			kept:    true,
+		},
+		{
+			name: "missing remote directory",
+			setup: func(profile *config.BackupProfile, fake *fakeRemote, base string) {
+				profile.Remote = "sftp://backup@nas/missing"
+			},
+			stages:  []string{"compress:ok", "verify:ok", "checksum:ok", "upload:failed", "rotate:skipped"},
+			wantErr: "upload: the directory /missing does not exist on backup@nas, create it first",
+			kept:    true,
+		},
+		{
+			name: "remote disk full",
+			setup: func(profile *config.BackupProfile, fake *fakeRemote, base string) {
+				fake.Server.FailWritesAfter = 16
+			},
+			stages:  []string{"compress:ok", "verify:ok", "checksum:ok", "upload:failed", "rotate:skipped"},
+			wantErr: "upload: uploading ",
+			kept:    true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			base := t.TempDir()
+			profile := backupProfile(t, base)
+			fake := useRemote(t, filepath.Join(base, "remote"))
+			sent := useNotifier(t)
+			test.setup(&profile, fake, base)
+			remoteBefore := dirNames(t, filepath.Join(base, "remote", "backups"))
+
+			run, reports, err := runProfile(t, profile)
+			if err == nil || !strings.HasPrefix(err.Error(), test.wantErr) {
+				t.Errorf("run = %v, want %q", err, test.wantErr)
+			}
+			if got := stageStatuses(reports); !slices.Equal(got, test.stages) {
+				t.Errorf("stages = %q, want %q", got, test.stages)
+			}
+
+			// Nothing was rotated, and the remote holds nothing of the run, partial files included
+			if got := dirNames(t, filepath.Join(base, "remote", "backups")); !slices.Equal(got, remoteBefore) {
+				t.Errorf("remote holds %q, had %q", got, remoteBefore)
+			}
+			archives, err := listBackupArchives("photos", profile.Destination)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := map[bool]int{false: 2, true: 3}[test.kept]; len(archives) != want {
+				t.Errorf("the destination holds %d archives, want %d", len(archives), want)
+			}
+			if test.kept && (run.Result == nil || filepath.Base(archives[2].Path) != filepath.Base(run.Result.ArchivePath)) {
+				t.Errorf("the archive of the run is gone after a failed upload: %+v", archives)
+			}
+			if len(fake.Dials) != fake.Closed && fake.Err == nil {
+				t.Errorf("%d dials, %d closed", len(fake.Dials), fake.Closed)
+			}
+
+			// The failure is notified, without the counts of a success
+			if len(*sent) != 1 || (*sent)[0].Event.Status != notify.StatusFailure || !strings.HasPrefix((*sent)[0].Event.Error, test.wantErr) ||
+				(*sent)[0].Event.Counts != nil {
+				t.Errorf("notifications = %+v", *sent)
+			}
+		})
+	}
+}
+
+func TestBackupRunRefusesInvalidRemotes(t *testing.T) {
+	for _, location := range []string{"nas:/backups", "sftp:///backups", "sftp://nas", "ftp://nas/backups"} {
+		profile := config.BackupProfile{Source: t.TempDir(), Destination: t.TempDir(), Remote: location}
+		_, err := newBackupRun("photos", profile, false)
+		if want := "invalid remote '" + location + "', expected sftp://[user@]host[:port]/path"; err == nil || !strings.HasSuffix(err.Error(), want) {
+			t.Errorf("remote %s = %v", location, err)
+		}
+	}
+
+	// Without a remote the upload stage has nothing to do
+	base := t.TempDir()
+	profile := backupProfile(t, base)
+	profile.Remote = ""
+	fake := useRemote(t, filepath.Join(base, "remote"))
+	useNotifier(t)
+	_, reports, err := runProfile(t, profile)
+	if err != nil || reports[3].Status != stageOK || reports[3].Detail != "no remote in the profile" || len(fake.Dials) != 0 {
+		t.Errorf("run without a remote = %v, %+v, dials %q", err, reports, fake.Dials)
+	}
+}
+
+// TestBackupUploadBandwidth uploads an archive through a limiter on a fake clock: the writes to the remote stay at
+// the cap of --bwlimit and the archive arrives unchanged
+func TestBackupUploadBandwidth(t *testing.T) {
+	base := t.TempDir()
+	archive := filepath.Join(base, "photos-20240301-000000.tar.gz")
+	content := noise(1<<20, 7)
+	writeTree(t, base, map[string]string{filepath.Base(archive): content})
+	if err := os.MkdirAll(filepath.Join(base, "remote", "backups"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	useRemote(t, filepath.Join(base, "remote"))
+	r, err := openBackupRemote("sftp://backup@nas/backups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	l, clock := newFakeLimiter(context.Background(), 256<<10)
+	start := clock.now
+	sent, err := r.upload(archive, progressbar.DefaultBytesSilent(-1), l)
+	if err != nil || sent != int64(len(content)) {
+		t.Fatalf("upload = %d, %v", sent, err)
+	}
+	checkRate(t, "upload", l, sent, clock.now.Sub(start))
+	if uploaded, err := os.ReadFile(filepath.Join(base, "remote", "backups", filepath.Base(archive))); err != nil || string(uploaded) != content {
+		t.Errorf("uploaded archive = %d bytes (%v), want %d", len(uploaded), err, len(content))
+	}
+}
+
+// TestBackupRunEncrypted encrypts the archives of a profile to the keys of its recipients_file: verify reads the
+// archive back when a local identity decrypts it and says it did not otherwise, and rotation counts the encrypted
+// archives with the others
+func TestBackupRunEncrypted(t *testing.T) {
+	keys := t.TempDir()
+	recipient, identity := newAgeIdentity(t, keys, "backup-key.txt")
+	recipientsFile := filepath.Join(keys, "recipients.txt")
+	if err := os.WriteFile(recipientsFile, []byte("# offline backup key\n"+recipient.String()+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name       string
+		identities []string
+		wantVerify string
+	}{
+		{"local identity", []string{identity}, "4 entries, 3 hashed"},
+		{"offline key", nil, "encrypted, not read back: no local identity decrypts it"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			useIdentities(t, test.identities...)
+			base := t.TempDir()
+			profile := backupProfile(t, base)
+			profile.RecipientsFile = recipientsFile
+			useRemote(t, filepath.Join(base, "remote"))
+			useNotifier(t)
+
+			run, reports, err := runProfile(t, profile)
+			if err != nil {
+				t.Fatalf("%v, %+v", err, reports)
+			}
+			if !strings.HasSuffix(run.Result.ArchivePath, ".tar.gz"+encryptedExt) || reports[1].Detail != test.wantVerify {
+				t.Errorf("archive %s, verify %q, want %q", run.Result.ArchivePath, reports[1].Detail, test.wantVerify)
+			}
+			if reports[4].Detail != "removed 1 archive(s), 1 removed on the remote" {
+				t.Errorf("rotate = %q", reports[4].Detail)
+			}
+			archives, err := listBackupArchives("photos", profile.Destination)
+			if err != nil || len(archives) != 2 || archives[1].Path != run.Result.ArchivePath {
+				t.Errorf("archives = %+v, %v", archives, err)
+			}
+			uploaded := filepath.Join(base, "remote", "backups", filepath.Base(run.Result.ArchivePath))
+			useIdentities(t, identity)
+			if got := entryBodies(t, uploaded); !slices.Equal(got, []string{"photos/2024/beach.jpg=" + strings.Repeat("sand", 2000),
+				"photos/2024/forest.jpg=" + strings.Repeat("tree", 2000), "photos/album.txt=summer\n"}) {
+				t.Errorf("decrypted upload holds %d entries", len(got))
+			}
+		})
+	}
+
+	// Keys that cannot encrypt, or a format that cannot be encrypted, are refused before anything is written
+	for _, test := range []struct {
+		profile config.BackupProfile
+		want    string
+	}{
+		{config.BackupProfile{Recipients: []string{"age1nope"}}, "backup profile 'photos' has an invalid recipient 'age1nope'"},
+		{config.BackupProfile{RecipientsFile: filepath.Join(keys, "missing.txt")}, "backup profile 'photos': open "},
+		{config.BackupProfile{Recipients: []string{recipient.String()}, Format: "zip"}, "backup profile 'photos' encrypts its archives, which needs format tar.gz, tar or tar.zst"},
+	} {
+		test.profile.Source, test.profile.Destination = t.TempDir(), t.TempDir()
+		if _, err := newBackupRun("photos", test.profile, false); err == nil || !strings.HasPrefix(err.Error(), test.want) {
+			t.Errorf("%+v = %v, want %q", test.profile, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/output"
+	"gsn-dev-tools/internals/progress"
+	"gsn-dev-tools/internals/style"
+	"gsn-dev-tools/internals/units"
+
+	"github.com/spf13/cobra"
+)
+
+// Outcomes of a child directory of cmp batch
+const (
+	batchWritten = "written"
+	batchFresh   = "fresh"
+	batchFailed  = "failed"
+)
+
+// batchResult is the outcome of archiving one child directory
+type batchResult struct {
+	Name        string
+	ArchivePath string
+	Status      string
+	SourceSize  int64
+	ArchiveSize int64
+	Duration    time.Duration
+	Err         error
+}
+
+// batchColumns declares the columns of the summary of `cmp batch`
+var batchColumns = []output.Column[batchResult]{
+	{Name: "name", Value: func(r batchResult) any { return r.Name }},
+	{Name: "archive", Value: func(r batchResult) any { return filepath.Base(r.ArchivePath) }},
+	{Name: "status", Value: func(r batchResult) any { return r.Status }},
+	{Name: "size", Value: func(r batchResult) any { return r.ArchiveSize }, Display: func(r batchResult) string { return units.FormatBytes(r.ArchiveSize) }},
+	{Name: "ratio", Value: func(r batchResult) any { return r.ratio() }, Display: func(r batchResult) string {
+		if r.Status != batchWritten || r.SourceSize == 0 {
+			return "-"
+		}
+		return units.FormatDecimal(r.ratio()*100, 1) + "%"
+	}},
+	{Name: "duration", Value: func(r batchResult) any { return r.Duration }, Display: func(r batchResult) string { return units.FormatDuration(r.Duration.Round(time.Millisecond)) }},
+	{Name: "error", Value: func(r batchResult) any {
+		if r.Err == nil {
+			return ""
+		}
+		return r.Err.Error()
+	}},
+}
+
+func (r batchResult) ratio() float64 {
+	if r.SourceSize == 0 {
+		return 0
+	}
+	return float64(r.ArchiveSize) / float64(r.SourceSize)
+}
+
+func BatchCmd() *cobra.Command {
+	batchCmd := cobra.Command{
+		Use:   "batch <parent_dir>",
+		Short: "Compresses every directory of a parent directory into an archive of its own",
+		Long: `Creates one archive per directory directly inside the parent directory, next to them or in --output-dir.
+Hidden directories and those matching an --exclude pattern are left out; --preset, --exclude, the depth flags
+and --respect-gitattributes apply inside every directory as they do for gsn cmp, and --preset auto picks a
+preset per directory.
+
+--name builds the archive name, before the format extension, from {name}, the directory name, {date}, today as
+2006-01-02, and {month}, this month as 2006-01. --concurrency N archives N directories at a time, each with a
+progress line of its own. With --skip-fresh a directory whose archive exists and is newer than the directory and
+every entry below it is not archived again.
+
+--output-dir is created when missing. An archive is written to <archive>.part and renamed into place once
+complete, so a failed run keeps the previous archive. A directory that fails does not stop the others; the
+summary table lists every directory with its archive size, size as a share of the source, duration and error,
+and batch exits with 1 when any failed. The size confirmation of gsn cmp is not asked.`,
+		Example: `  gsn cmp batch ./clients
+  gsn cmp batch ./clients --name '{name}-{month}' --output-dir /mnt/archive --concurrency 4
+  gsn cmp batch ./projects --preset auto --skip-fresh --format tar.zst`,
+		Args: cobra.ExactArgs(1),
+		Run:  CompressBatch,
+	}
+
+	batchCmd.Flags().String("format", formatTarGz.String(), "Archive format: tar.gz, tar or tar.zst")
+	batchCmd.Flags().String("name", "{name}", "Archive name template using {name}, {date} and {month}")
+	batchCmd.Flags().String("output-dir", "", "Directory to write the archives to (default: the parent directory)")
+	batchCmd.Flags().Int("concurrency", 2, "Directories archived at a time")
+	batchCmd.Flags().Bool("skip-fresh", false, "Skip directories whose archive is newer than everything in them")
+	addArchiveFilterFlags(&batchCmd)
+	addGitAttributesFlag(&batchCmd)
+	output.AddFlags(&batchCmd)
+	progress.AddStatusFlags(&batchCmd)
+	return &batchCmd
+}
+
+func CompressBatch(cmd *cobra.Command, args []string) {
+	parent := args[0]
+	formatName, _ := cmd.Flags().GetString("format")
+	nameTemplate, _ := cmd.Flags().GetString("name")
+	outputDir, _ := cmd.Flags().GetString("output-dir")
+	concurrency, _ := cmd.Flags().GetInt("concurrency")
+	skipFresh, _ := cmd.Flags().GetBool("skip-fresh")
+	presetName, _ := cmd.Flags().GetString("preset")
+	excludes, _ := cmd.Flags().GetStringSlice("exclude")
+	respectAttributes, _ := cmd.Flags().GetBool("respect-gitattributes")
+
+	opts, err := output.OptionsFromFlags(cmd)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	if concurrency < 1 {
+		clierr.Exitf(clierr.Usage, "--concurrency must be at least 1")
+	}
+	if !strings.Contains(nameTemplate, "{name}") {
+		clierr.Exitf(clierr.Usage, "--name must contain {name}, or every directory would get the same archive")
+	}
+	format, err := parseFormat(formatName)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	if format.Container != containerTar {
+		clierr.Exitf(clierr.Usage, "batch archives directories, use a tar format instead of %s", format)
+	}
+	depth, err := depthFromFlags(cmd)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	if outputDir == "" {
+		outputDir = parent
+	} else if err := os.MkdirAll(outputDir, 0o755); err != nil {
+		clierr.Fatalf("%v", err)
+	}
+
+	jobs, err := planBatch(parent, outputDir, nameTemplate, format, archiveFilter{Excludes: excludes}, time.Now())
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	if len(jobs) == 0 {
+		clierr.Exitf(clierr.NotFound, "no directory to archive in '%s'", parent)
+	}
+	// Presets are chosen, and reported, before the progress lines start
+	for i := range jobs {
+		if jobs[i].Filter, err = archiveFilterFor(presetName, excludes, depth, jobs[i].Source, respectAttributes); err != nil {
+			clierr.Fatalf("%v", err)
+		}
+	}
+
+	finishStatus := progress.StartStatus(cmd, "cmp batch")
+	progress.SetPhase(fmt.Sprintf("Archiving %d directories", len(jobs)))
+	results := runBatch(jobs, concurrency, skipFresh)
+
+	var written, fresh, failed int
+	for _, r := range results {
+		switch r.Status {
+		case batchWritten:
+			written++
+		case batchFresh:
+			fresh++
+		default:
+			failed++
+		}
+	}
+	summary := fmt.Sprintf("%d archive(s) written, %d fresh, %d failed", written, fresh, failed)
+	if failed > 0 {
+		finishStatus(errors.New(summary))
+	} else {
+		finishStatus(nil)
+	}
+
+	if err := output.Render(os.Stdout, batchColumns, results, opts); err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	if failed > 0 {
+		clierr.Exitf(clierr.Failure, "%s", summary)
+	}
+	fmt.Fprintln(os.Stderr, style.Success()+summary)
+}
+
+// batchJob is a child directory to archive and where its archive goes
+type batchJob struct {
+	Name        string
+	Source      string
+	ArchivePath string
+	Format      archiveFormat
+	Filter      archiveFilter
+}
+
+// planBatch lists the directories directly inside parent, except hidden ones, those excluded and the output
+// directory itself, sorted by name
+func planBatch(parent string, outputDir string, nameTemplate string, format archiveFormat, filter archiveFilter, now time.Time) ([]batchJob, error) {
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		return nil, err
+	}
+	absOutput, err := filepath.Abs(outputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []batchJob
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		source := filepath.Join(parent, entry.Name())
+		if filter.skips(parent, source) {
+			continue
+		}
+		if abs, err := filepath.Abs(source); err == nil && abs == absOutput {
+			continue
+		}
+		name := strings.NewReplacer(
+			"{name}", entry.Name(),
+			"{date}", now.Format(time.DateOnly),
+			"{month}", now.Format("2006-01"),
+		).Replace(nameTemplate)
+		jobs = append(jobs, batchJob{Name: entry.Name(), Source: source, ArchivePath: filepath.Join(outputDir, name+format.Extension()), Format: format})
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Name < jobs[j].Name })
+	return jobs, nil
+}
+
+// runBatch archives the jobs on concurrency workers, each drawing its own progress line, and returns their
+// results in the order of jobs. A job failing does not stop the others.
+func runBatch(jobs []batchJob, concurrency int, skipFresh bool) []batchResult {
+	bars := progress.NewMulti()
+	defer bars.Stop()
+
+	results := make([]batchResult, len(jobs))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range min(concurrency, len(jobs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = archiveBatchJob(jobs[i], skipFresh, bars)
+				if results[i].Err != nil {
+					bars.Printf(style.Failure()+"%s: %v\n", jobs[i].Name, results[i].Err)
+				}
+			}
+		}()
+	}
+	for i := range jobs {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return results
+}
+
+// archiveBatchJob writes the archive of one job to a .part file renamed into place on success
+func archiveBatchJob(job batchJob, skipFresh bool, bars *progress.Multi) batchResult {
+	start := time.Now()
+	result := batchResult{Name: job.Name, ArchivePath: job.ArchivePath}
+	if skipFresh {
+		fresh, err := archiveIsFresh(job)
+		if err != nil {
+			result.Status, result.Err, result.Duration = batchFailed, err, time.Since(start)
+			return result
+		}
+		if fresh {
+			info, _ := os.Stat(job.ArchivePath)
+			result.Status, result.ArchiveSize, result.Duration = batchFresh, info.Size(), time.Since(start)
+			return result
+		}
+	}
+
+	partPath := job.ArchivePath + ".part"
+	bar := bars.Add(job.Name)
+	compressed, err := compressPath(job.Source, compressOptions{
+		Format:   job.Format,
+		Filter:   job.Filter,
+		Output:   partPath,
+		Progress: bar,
+	})
+	bar.Finish()
+	if err == nil {
+		if err = os.Rename(partPath, job.ArchivePath); err != nil {
+			os.Remove(partPath)
+		}
+	}
+	result.Duration = time.Since(start)
+	if err != nil {
+		result.Status, result.Err = batchFailed, err
+		return result
+	}
+	result.Status, result.SourceSize, result.ArchiveSize = batchWritten, compressed.SourceSize, compressed.ArchiveSize
+	return result
+}
+
+// archiveIsFresh reports whether the archive of job exists and was modified after the source directory and
+// every entry cmp would archive from it, a missing archive is not fresh
+func archiveIsFresh(job batchJob) (bool, error) {
+	archiveInfo, err := os.Stat(job.ArchivePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	sourceInfo, err := os.Stat(job.Source)
+	if err != nil {
+		return false, err
+	}
+	if !archiveInfo.ModTime().After(sourceInfo.ModTime()) {
+		return false, nil
+	}
+
+	errStale := errors.New("stale")
+	err = walkArchiveEntries(job.Source, job.Filter, func(_ string, _ string, info os.FileInfo) error {
+		if !archiveInfo.ModTime().After(info.ModTime()) {
+			return errStale
+		}
+		return nil
+	})
+	if errors.Is(err, errStale) {
+		return false, nil
+	}
+	return err == nil, err
+}
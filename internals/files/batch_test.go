@@ -0,0 +1,213 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// dateTree sets the mtime of root and everything below it to at
+func dateTree(t *testing.T, root string, at time.Time) {
+	t.Helper()
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Chtimes(path, at, at)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// threeChildren writes a parent holding alpha, beta and gamma, dated an hour ago, next to a hidden directory
+// and a plain file batch leaves alone
+func threeChildren(t *testing.T) string {
+	t.Helper()
+	parent := filepath.Join(t.TempDir(), "clients")
+	writeTree(t, parent, map[string]string{
+		"alpha/readme.md":         "# alpha\n",
+		"beta/src/main.go":        "package main\n",
+		"beta/src/deep/notes.txt": "notes\n",
+		"gamma/data.csv":          "a,b\n1,2\n",
+		"gamma/empty/":            "",
+		".hidden/secret":          "s",
+		"loose.txt":               "not a directory",
+	})
+	dateTree(t, parent, time.Now().Add(-time.Hour))
+	return parent
+}
+
+func statuses(results []batchResult) map[string]string {
+	got := map[string]string{}
+	for _, r := range results {
+		got[r.Name] = r.Status
+	}
+	return got
+}
+
+func TestPlanBatch(t *testing.T) {
+	parent := threeChildren(t)
+	writeTree(t, parent, map[string]string{"archives/": "", "scratch/tmp": "x"})
+	now := time.Date(2024, 3, 9, 12, 0, 0, 0, time.UTC)
+
+	// The output directory inside the parent and the excluded directory are not archived
+	jobs, err := planBatch(parent, filepath.Join(parent, "archives"), "{name}-{month}", formatTarZst, archiveFilter{Excludes: []string{"scratch"}}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names, archives []string
+	for _, job := range jobs {
+		names = append(names, job.Name)
+		archives = append(archives, filepath.Base(job.ArchivePath))
+		if job.Source != filepath.Join(parent, job.Name) || filepath.Dir(job.ArchivePath) != filepath.Join(parent, "archives") {
+			t.Errorf("job %+v", job)
+		}
+	}
+	if want := []string{"alpha", "beta", "gamma"}; !slices.Equal(names, want) {
+		t.Errorf("names = %q, want %q", names, want)
+	}
+	if want := []string{"alpha-2024-03.tar.zst", "beta-2024-03.tar.zst", "gamma-2024-03.tar.zst"}; !slices.Equal(archives, want) {
+		t.Errorf("archives = %q, want %q", archives, want)
+	}
+
+	// Next to the directories, every one of them is archived
+	jobs, _ = planBatch(parent, parent, "{name}_{date}", formatTarGz, archiveFilter{}, now)
+	archives = nil
+	for _, job := range jobs {
+		archives = append(archives, filepath.Base(job.ArchivePath))
+	}
+	if want := []string{"alpha_2024-03-09.tar.gz", "archives_2024-03-09.tar.gz", "beta_2024-03-09.tar.gz", "gamma_2024-03-09.tar.gz", "scratch_2024-03-09.tar.gz"}; !slices.Equal(archives, want) {
+		t.Errorf("archives next to the directories = %q, want %q", archives, want)
+	}
+}
+
+func TestRunBatchThreeChildren(t *testing.T) {
+	parent := threeChildren(t)
+	jobs, err := planBatch(parent, parent, "{name}", formatTarGz, archiveFilter{}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := runBatch(jobs, 2, true)
+	for _, r := range results {
+		if r.Status != batchWritten || r.Err != nil || r.ArchiveSize == 0 || r.SourceSize == 0 {
+			t.Errorf("first run: %+v", r)
+		}
+	}
+	var names []string
+	for _, entry := range readFixtureTar(t, filepath.Join(parent, "beta.tar.gz")) {
+		names = append(names, entry.Name)
+	}
+	if want := []string{"beta/src", "beta/src/deep", "beta/src/deep/notes.txt", "beta/src/main.go"}; !slices.Equal(names, want) {
+		t.Errorf("beta's archive holds %q, want %q", names, want)
+	}
+	if parts, _ := filepath.Glob(filepath.Join(parent, "*.part")); len(parts) > 0 {
+		t.Errorf(".part files left behind: %q", parts)
+	}
+
+	// Nothing changed, every archive is fresh and kept as it is
+	before, _ := os.Stat(filepath.Join(parent, "gamma.tar.gz"))
+	results = runBatch(jobs, 3, true)
+	if got := statuses(results); got["alpha"] != batchFresh || got["beta"] != batchFresh || got["gamma"] != batchFresh {
+		t.Errorf("second run = %v", got)
+	}
+	if after, _ := os.Stat(filepath.Join(parent, "gamma.tar.gz")); !after.ModTime().Equal(before.ModTime()) || results[2].ArchiveSize != after.Size() {
+		t.Errorf("a fresh archive was rewritten, or reported with size %d", results[2].ArchiveSize)
+	}
+
+	// A file deep in beta changed after its archive was written, only beta is archived again
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(parent, "beta", "src", "deep", "notes.txt"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if got := statuses(runBatch(jobs, 1, true)); got["alpha"] != batchFresh || got["beta"] != batchWritten || got["gamma"] != batchFresh {
+		t.Errorf("run after a change in beta = %v", got)
+	}
+
+	// Without --skip-fresh every directory is archived
+	if got := statuses(runBatch(jobs, 2, false)); got["alpha"] != batchWritten || got["beta"] != batchWritten || got["gamma"] != batchWritten {
+		t.Errorf("run without skip-fresh = %v", got)
+	}
+}
+
+func TestRunBatchFailureKeepsTheOthers(t *testing.T) {
+	parent := threeChildren(t)
+	out := t.TempDir()
+	jobs, err := planBatch(parent, out, "{name}", formatTar, archiveFilter{}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// gamma's archive cannot be renamed over a directory in its place, alpha's source is gone
+	writeTree(t, out, map[string]string{"gamma.tar/keep": "x", "alpha.tar": "previous archive"})
+	jobs = append(jobs, batchJob{Name: "ghost", Source: filepath.Join(parent, "ghost"), ArchivePath: filepath.Join(out, "alpha.tar"), Format: formatTar})
+
+	var results []batchResult
+	captureStderr(t, func() { results = runBatch(jobs, 2, false) })
+	if got := statuses(results); got["alpha"] != batchWritten || got["beta"] != batchWritten || got["gamma"] != batchFailed || got["ghost"] != batchFailed {
+		t.Errorf("statuses = %v", got)
+	}
+	if results[2].Err == nil || results[3].Err == nil {
+		t.Errorf("failures without an error: %+v", results)
+	}
+	if _, err := os.Stat(filepath.Join(out, "gamma.tar.part")); !os.IsNotExist(err) {
+		t.Errorf("gamma.tar.part was left behind: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "gamma.tar", "keep")); err != nil {
+		t.Errorf("the directory in gamma's way was touched: %v", err)
+	}
+}
+
+func TestArchiveIsFresh(t *testing.T) {
+	written := time.Now().Add(-time.Minute)
+	tests := []struct {
+		name string
+		// change runs on the source and the archive once both are dated
+		change func(t *testing.T, source, archive string)
+		filter archiveFilter
+		fresh  bool
+	}{
+		{"older than the archive", func(t *testing.T, source, archive string) {}, archiveFilter{}, true},
+		{"no archive", func(t *testing.T, source, archive string) { os.Remove(archive) }, archiveFilter{}, false},
+		{"archive as old as the source", func(t *testing.T, source, archive string) {
+			dateTree(t, source, written)
+		}, archiveFilter{}, false},
+		{"directory changed", func(t *testing.T, source, archive string) {
+			os.Chtimes(source, written.Add(time.Second), written.Add(time.Second))
+		}, archiveFilter{}, false},
+		{"nested file changed", func(t *testing.T, source, archive string) {
+			path := filepath.Join(source, "sub", "deeper", "b.txt")
+			os.Chtimes(path, written.Add(time.Second), written.Add(time.Second))
+		}, archiveFilter{}, false},
+		// An entry the archive leaves out does not make it stale
+		{"excluded file changed", func(t *testing.T, source, archive string) {
+			path := filepath.Join(source, "build", "out.o")
+			os.Chtimes(path, written.Add(time.Second), written.Add(time.Second))
+		}, archiveFilter{Excludes: []string{"build"}}, true},
+		{"below the depth limit", func(t *testing.T, source, archive string) {
+			path := filepath.Join(source, "sub", "deeper", "b.txt")
+			os.Chtimes(path, written.Add(time.Second), written.Add(time.Second))
+		}, archiveFilter{LimitDepth: true, MaxDepth: 1}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			source := filepath.Join(dir, "project")
+			writeTree(t, source, map[string]string{"a.txt": "a", "sub/deeper/b.txt": "b", "build/out.o": "o"})
+			dateTree(t, source, written.Add(-time.Hour))
+			archive := filepath.Join(dir, "project.tar.gz")
+			writeTree(t, dir, map[string]string{"project.tar.gz": "archive"})
+			if err := os.Chtimes(archive, written, written); err != nil {
+				t.Fatal(err)
+			}
+
+			test.change(t, source, archive)
+			fresh, err := archiveIsFresh(batchJob{Name: "project", Source: source, ArchivePath: archive, Filter: test.filter})
+			if err != nil || fresh != test.fresh {
+				t.Errorf("archiveIsFresh = %v, %v, want %v", fresh, err, test.fresh)
+			}
+		})
+	}
+}
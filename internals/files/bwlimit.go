@@ -0,0 +1,170 @@
+package files
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/units"
+
+	"github.com/spf13/cobra"
+)
+
+// addBandwidthFlag registers the --bwlimit flag shared by IO heavy commands
+func addBandwidthFlag(cmd *cobra.Command) {
+	cmd.Flags().String("bwlimit", "", "Cap throughput, e.g. 20MB/s (empty means unlimited)")
+}
+
+// bandwidthLimiterFromFlags builds the limiter requested with --bwlimit, nil when unlimited
+func bandwidthLimiterFromFlags(cmd *cobra.Command) (*bandwidthLimiter, error) {
+	value, _ := cmd.Flags().GetString("bwlimit")
+	if value == "" {
+		return nil, nil
+	}
+
+	rate, err := parseRate(value)
+	if err != nil {
+		return nil, err
+	}
+	return newBandwidthLimiter(cmd.Context(), rate), nil
+}
+
+// parseRate parses a throughput such as 20MB/s, 512KiB/s or a plain byte count per second
+func parseRate(value string) (int64, error) {
+	trimmed := strings.TrimSuffix(strings.TrimSpace(value), "/s")
+	rate, err := units.ParseBytes(trimmed)
+	if err != nil {
+		return 0, clierr.Newf(clierr.Usage, "invalid --bwlimit value '%s': %w", value, err)
+	}
+	if rate <= 0 {
+		return 0, clierr.Newf(clierr.Usage, "invalid --bwlimit value '%s': must be greater than zero", value)
+	}
+	return rate, nil
+}
+
+// bandwidthLimiter is a token bucket refilled at rate bytes per second. A single limiter can be shared
+// by several readers so the cap applies to the whole command, not to each file.
+type bandwidthLimiter struct {
+	ctx   context.Context
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+
+	// now and sleep are swapped for a fake clock in tests
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+func newBandwidthLimiter(ctx context.Context, bytesPerSecond int64) *bandwidthLimiter {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	l := &bandwidthLimiter{
+		ctx:   ctx,
+		rate:  float64(bytesPerSecond),
+		now:   time.Now,
+		sleep: sleepContext,
+	}
+	// Allow bursts of a tenth of a second so small reads do not sleep for every chunk
+	l.burst = max(l.rate/10, 32*1024)
+	l.tokens = l.burst
+	l.last = l.now()
+	return l
+}
+
+// Reader wraps r so every read waits for the bucket; a nil limiter returns r unchanged
+func (l *bandwidthLimiter) Reader(r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &limitedReader{r: r, limiter: l}
+}
+
+// Writer wraps w so every write waits for the bucket; a nil limiter returns w unchanged
+func (l *bandwidthLimiter) Writer(w io.Writer) io.Writer {
+	if l == nil {
+		return w
+	}
+	return &limitedWriter{w: w, limiter: l}
+}
+
+// wait takes n tokens from the bucket, sleeping until the deficit is refilled or the context is done
+func (l *bandwidthLimiter) wait(n int) error {
+	l.mu.Lock()
+	now := l.now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	deficit := -l.tokens
+	l.mu.Unlock()
+
+	if deficit <= 0 {
+		return l.ctx.Err()
+	}
+	return l.sleep(l.ctx, time.Duration(deficit/l.rate*float64(time.Second)))
+}
+
+// sleepContext sleeps for d but returns early with the context error on cancellation
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// limitedReader throttles reads of the wrapped reader through a bandwidthLimiter
+type limitedReader struct {
+	r       io.Reader
+	limiter *bandwidthLimiter
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	// Cap single reads to the burst so one large buffer cannot overshoot the rate for long
+	if len(p) > int(lr.limiter.burst) {
+		p = p[:int(lr.limiter.burst)]
+	}
+
+	n, err := lr.r.Read(p)
+	if n > 0 {
+		if werr := lr.limiter.wait(n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// limitedWriter throttles writes to the wrapped writer through a bandwidthLimiter
+type limitedWriter struct {
+	w       io.Writer
+	limiter *bandwidthLimiter
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	written := 0
+	// Waits before writing, in chunks of the burst, so the writes behind it see the capped rate
+	for len(p) > 0 {
+		chunk := p[:min(len(p), int(lw.limiter.burst))]
+		if err := lw.limiter.wait(len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := lw.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,134 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClock stands for time in a bandwidthLimiter: sleeping moves it forward at once
+type fakeClock struct {
+	now time.Time
+}
+
+// newFakeLimiter returns a limiter of rate bytes per second running on a fake clock
+func newFakeLimiter(ctx context.Context, rate int64) (*bandwidthLimiter, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := newBandwidthLimiter(ctx, rate)
+	l.now = func() time.Time { return clock.now }
+	l.sleep = func(ctx context.Context, d time.Duration) error {
+		clock.now = clock.now.Add(d)
+		return ctx.Err()
+	}
+	l.last = clock.now
+	return l, clock
+}
+
+// checkRate fails unless size bytes through l over elapsed are within 3% of its rate. The bucket starts full,
+// the first burst passes without waiting.
+func checkRate(t *testing.T, name string, l *bandwidthLimiter, size int64, elapsed time.Duration) {
+	t.Helper()
+	rate := int64(l.rate)
+	measured := (float64(size) - l.burst) / elapsed.Seconds()
+	if measured < float64(rate)*0.97 || measured > float64(rate)*1.03 {
+		t.Errorf("%s: %d bytes in %v is %.0f B/s, want %d B/s", name, size, elapsed, measured, rate)
+	}
+}
+
+func TestBandwidthLimiterThroughput(t *testing.T) {
+	const size = 20 << 20
+	for _, rate := range []int64{1 << 20, 20_000_000} {
+		l, clock := newFakeLimiter(context.Background(), rate)
+		start := clock.now
+		n, err := io.Copy(io.Discard, l.Reader(bytes.NewReader(make([]byte, size))))
+		if err != nil || n != size {
+			t.Fatalf("read %d bytes, %v", n, err)
+		}
+		checkRate(t, "reader", l, n, clock.now.Sub(start))
+
+		l, clock = newFakeLimiter(context.Background(), rate)
+		start = clock.now
+		var out bytes.Buffer
+		// A single large write is split so it cannot pass at once
+		written, err := l.Writer(&out).Write(make([]byte, size))
+		if err != nil || written != size || out.Len() != size {
+			t.Fatalf("wrote %d bytes, %v", written, err)
+		}
+		checkRate(t, "writer", l, int64(written), clock.now.Sub(start))
+	}
+}
+
+func TestBandwidthLimiterIsShared(t *testing.T) {
+	const rate, size = 1 << 20, 4 << 20
+	l, clock := newFakeLimiter(context.Background(), rate)
+	start := clock.now
+	readers := []io.Reader{l.Reader(bytes.NewReader(make([]byte, size))), l.Reader(bytes.NewReader(make([]byte, size)))}
+	buf := make([]byte, 64<<10)
+	var total int64
+	for done := 0; done < len(readers); {
+		done = 0
+		// Alternates like the workers of cmp reading several files at once
+		for _, r := range readers {
+			n, err := r.Read(buf)
+			total += int64(n)
+			if err == io.EOF {
+				done++
+			}
+		}
+	}
+	// Both readers take from the same bucket, together they stay at the cap
+	checkRate(t, "two readers", l, total, clock.now.Sub(start))
+}
+
+func TestBandwidthLimiterCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	// A byte per second: the bucket would sleep for hours once the burst is spent
+	l := newBandwidthLimiter(ctx, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+
+	start := time.Now()
+	_, err := io.Copy(io.Discard, l.Reader(bytes.NewReader(make([]byte, 1<<20))))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("copy = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("cancellation took %v", elapsed)
+	}
+}
+
+func TestNilBandwidthLimiter(t *testing.T) {
+	var l *bandwidthLimiter
+	r := strings.NewReader("x")
+	var w bytes.Buffer
+	if l.Reader(r) != io.Reader(r) || l.Writer(&w) != io.Writer(&w) {
+		t.Error("a nil limiter wrapped the reader or the writer")
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+	}{
+		{"20MB/s", 20_000_000},
+		{"512KiB/s", 512 << 10},
+		{" 1000 ", 1000},
+	}
+	for _, test := range tests {
+		if got, err := parseRate(test.value); err != nil || got != test.want {
+			t.Errorf("parseRate(%q) = %d, %v, want %d", test.value, got, err, test.want)
+		}
+	}
+	for _, value := range []string{"0", "fast", "20M/s", "-5MB/s"} {
+		if _, err := parseRate(value); err == nil {
+			t.Errorf("parseRate(%q) succeeded", value)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package files
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+// archiveChanging archives the files of dir with the sizes they had before change ran, as if change happened
+// between writing their headers and copying their content
+func archiveChanging(t *testing.T, a *archiver, dir string, names []string, change func()) (*bytes.Buffer, error) {
+	t.Helper()
+	infos := make(map[string]os.FileInfo)
+	for _, name := range names {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		infos[name] = info
+	}
+	change()
+
+	var buf bytes.Buffer
+	a.tw = tar.NewWriter(&buf)
+	for _, name := range names {
+		if err := a.addEntry(filepath.Join(dir, name), name, infos[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := a.tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf, nil
+}
+
+func newTestArchiver(warnings io.Writer) *archiver {
+	return &archiver{bar: progressbar.DefaultBytesSilent(-1), warnings: warnings}
+}
+
+func TestCopyContentKeepsHeaderSizes(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"grown.log": "0123456789", "shrunk.log": "0123456789", "after.txt": "intact\n"})
+	names := []string{"grown.log", "shrunk.log", "after.txt"}
+
+	var warnings bytes.Buffer
+	a := newTestArchiver(&warnings)
+	buf, err := archiveChanging(t, a, dir, names, func() {
+		appendFile(t, filepath.Join(dir, "grown.log"), "ABCDEF")
+		if err := os.Truncate(filepath.Join(dir, "shrunk.log"), 4); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The archive stays readable, each entry holds exactly what its header declares
+	want := map[string]string{"grown.log": "0123456789", "shrunk.log": "0123\x00\x00\x00\x00\x00\x00", "after.txt": "intact\n"}
+	tr := tar.NewReader(buf)
+	for range names {
+		header, err := tr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int64(len(body)) != header.Size || string(body) != want[header.Name] {
+			t.Errorf("%s = %q with a size of %d, want %q", header.Name, body, header.Size, want[header.Name])
+		}
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Errorf("entries after the last one: %v", err)
+	}
+
+	a.warnChanges()
+	got := warnings.String()
+	for _, line := range []string{"'grown.log' grew while it was archived, kept its first 10 B", "'shrunk.log' shrank while it was archived, padded 6 B with zeros"} {
+		if !strings.Contains(got, line) {
+			t.Errorf("warnings = %q, want %q", got, line)
+		}
+	}
+	if strings.Count(got, "\n") != 2 {
+		t.Errorf("warnings = %q, want one line per changed file", got)
+	}
+}
+
+func TestCopyContentFailOnChange(t *testing.T) {
+	for _, change := range []string{"grow", "shrink"} {
+		t.Run(change, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTree(t, dir, map[string]string{"data.bin": "0123456789"})
+			path := filepath.Join(dir, "data.bin")
+
+			a := newTestArchiver(io.Discard)
+			a.failOnChange = true
+			_, err := archiveChanging(t, a, dir, []string{"data.bin"}, func() {
+				if change == "grow" {
+					appendFile(t, path, "more")
+				} else if err := os.Truncate(path, 0); err != nil {
+					t.Fatal(err)
+				}
+			})
+			if err == nil || err.Error() != "'data.bin' changed while it was archived" {
+				t.Errorf("archiving a file that changed = %v", err)
+			}
+		})
+	}
+}
+
+func TestCopyContentUnchangedFile(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"same.txt": "same\n"})
+	var warnings bytes.Buffer
+	a := newTestArchiver(&warnings)
+	if _, err := archiveChanging(t, a, dir, []string{"same.txt"}, func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if a.warnChanges(); warnings.Len() != 0 || len(a.changes) != 0 {
+		t.Errorf("an unchanged file was reported: %q", warnings.String())
+	}
+}
+
+func appendFile(t *testing.T, path string, content string) {
+	t.Helper()
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// concurrentWriter changes a file from its own goroutine whenever the bandwidth limiter of an archiver reading
+// the file sleeps, so the changes land in the middle of a read
+type concurrentWriter struct {
+	path    string
+	change  func(file *os.File) error
+	budget  int
+	request chan struct{}
+	done    chan error
+	changes int
+}
+
+// startConcurrentWriter makes a read its file at 64 KiB/s on a fake clock, changing the file up to budget
+// times, every time a read waits when budget is negative
+func startConcurrentWriter(t *testing.T, a *archiver, path string, budget int, change func(file *os.File) error) *concurrentWriter {
+	t.Helper()
+	w := &concurrentWriter{path: path, change: change, budget: budget, request: make(chan struct{}), done: make(chan error)}
+	go func() {
+		for range w.request {
+			file, err := os.OpenFile(w.path, os.O_RDWR|os.O_APPEND, 0)
+			if err == nil {
+				err = w.change(file)
+				if closeErr := file.Close(); err == nil {
+					err = closeErr
+				}
+			}
+			w.done <- err
+		}
+	}()
+	t.Cleanup(func() { close(w.request) })
+
+	clock := time.Unix(0, 0)
+	a.limiter = newBandwidthLimiter(context.Background(), 64<<10)
+	a.limiter.now = func() time.Time { return clock }
+	a.limiter.last = clock
+	a.limiter.sleep = func(ctx context.Context, d time.Duration) error {
+		clock = clock.Add(d)
+		if w.budget == 0 {
+			return nil
+		}
+		w.budget--
+		w.changes++
+		w.request <- struct{}{}
+		return <-w.done
+	}
+	return w
+}
+
+func TestRetryChangedWithConcurrentWriter(t *testing.T) {
+	const initial = 256 << 10
+	appendLine := func(file *os.File) error {
+		_, err := file.WriteString("appended while it was read\n")
+		return err
+	}
+	tests := []struct {
+		name        string
+		budget      int
+		retries     int
+		change      func(file *os.File) error
+		wantWarning string
+	}{
+		// The writer stops within the first read, the second read holds still
+		{"appends settle", 3, 5, appendLine, ""},
+		{"truncate settles", 1, 5, func(file *os.File) error { return file.Truncate(initial / 2) }, ""},
+		// Every read is interrupted, the last one is cut to the size it started with
+		{"appends never settle", -1, 2, appendLine, "grew while it was archived, kept its first"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "app.log")
+			writeTree(t, dir, map[string]string{"app.log": strings.Repeat("line of the log\n", initial/16)})
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var warnings, buf bytes.Buffer
+			a := newTestArchiver(&warnings)
+			// Small enough that every attempt spills to the temp file
+			a.retryChanged, a.spoolMemory = test.retries, 16<<10
+			a.tw = tar.NewWriter(&buf)
+			w := startConcurrentWriter(t, a, path, test.budget, test.change)
+
+			if err := a.addEntry(path, "app.log", info); err != nil {
+				t.Fatal(err)
+			}
+			if err := a.tw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if !a.spool.spilled {
+				t.Error("the spool kept a 256 KiB entry in memory with a 16 KiB limit")
+			}
+			spoolDir := filepath.Dir(a.spool.file.Name())
+			a.close()
+			if _, err := os.Stat(spoolDir); !os.IsNotExist(err) {
+				t.Errorf("the spool was left behind in %s", spoolDir)
+			}
+			a.warnChanges()
+
+			tr := tar.NewReader(&buf)
+			header, err := tr.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := io.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			final, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if int64(len(body)) != header.Size {
+				t.Fatalf("the entry holds %d bytes, its header declares %d", len(body), header.Size)
+			}
+			if w.changes == 0 || header.Size == initial {
+				t.Errorf("the entry kept its first size after %d changes", w.changes)
+			}
+
+			if test.wantWarning == "" {
+				// The settled read is the file as the writer left it
+				if !bytes.Equal(body, final) || warnings.Len() != 0 {
+					t.Errorf("the entry holds %d of the %d bytes of the file, warnings %q", len(body), len(final), warnings.String())
+				}
+				return
+			}
+			if !bytes.HasPrefix(final, body) || len(body) >= len(final) {
+				t.Errorf("the entry holds %d bytes that are not the start of the %d of the file", len(body), len(final))
+			}
+			if got := warnings.String(); strings.Count(got, "\n") != 1 || !strings.Contains(got, test.wantWarning) {
+				t.Errorf("warnings = %q, want one %q", got, test.wantWarning)
+			}
+		})
+	}
+}
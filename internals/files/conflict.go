@@ -0,0 +1,176 @@
+package files
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"gsn-dev-tools/internals/backups"
+	"gsn-dev-tools/internals/clierr"
+
+	"golang.org/x/term"
+)
+
+// conflictPolicy decides what happens when an extracted entry already exists on disk
+type conflictPolicy string
+
+const (
+	conflictOverwrite conflictPolicy = "overwrite"
+	conflictSkip      conflictPolicy = "skip"
+	conflictBackup    conflictPolicy = "backup"
+	conflictPrompt    conflictPolicy = "prompt"
+)
+
+// parseConflictPolicy validates the --on-conflict flag value
+func parseConflictPolicy(value string) (conflictPolicy, error) {
+	switch p := conflictPolicy(strings.ToLower(value)); p {
+	case conflictOverwrite, conflictSkip, conflictBackup, conflictPrompt:
+		return p, nil
+	default:
+		return "", clierr.Newf(clierr.Usage, "invalid --on-conflict value '%s' (use overwrite, skip, backup or prompt)", value)
+	}
+}
+
+// conflictResolver applies a conflict policy per entry and keeps counts per resolution
+type conflictResolver struct {
+	policy   conflictPolicy
+	applyAll conflictPolicy
+	journal  *Journal
+	counts   map[conflictPolicy]int
+
+	// input answers the prompt policy, interactive is false when it is not a terminal
+	input       *bufio.Reader
+	interactive bool
+
+	// backups receives the files overwritten, keeping keep backups of each, written lists them until what
+	// replaced them is recorded
+	backups *backups.Store
+	keep    int
+	written []*backups.Entry
+}
+
+func newConflictResolver(policy conflictPolicy, root string) *conflictResolver {
+	return &conflictResolver{
+		policy:      policy,
+		journal:     newJournal("extract", root),
+		counts:      make(map[conflictPolicy]int),
+		input:       bufio.NewReader(os.Stdin),
+		interactive: term.IsTerminal(int(os.Stdin.Fd())),
+	}
+}
+
+// resolve checks target before an entry is written and reports whether the entry should be written
+func (c *conflictResolver) resolve(target string) (bool, error) {
+	if _, err := os.Lstat(target); os.IsNotExist(err) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	decision := c.policy
+	if decision == conflictPrompt {
+		var err error
+		if decision, err = c.ask(target); err != nil {
+			return false, err
+		}
+	}
+	c.counts[decision]++
+
+	switch decision {
+	case conflictSkip:
+		return false, nil
+	case conflictBackup:
+		backup := nextBackupName(target)
+		if err := os.Rename(target, backup); err != nil {
+			return false, fmt.Errorf("error backing up '%s': %w", target, err)
+		}
+		c.journal.Record(target, backup)
+		return true, nil
+	default:
+		if c.backups != nil {
+			backup, err := c.backups.Backup(target, "extract", c.keep)
+			if err != nil {
+				return false, err
+			}
+			if backup != nil {
+				c.written = append(c.written, backup)
+			}
+		}
+		// Remove first so symlinks are replaced rather than followed
+		if err := os.Remove(target); err != nil {
+			return false, fmt.Errorf("error replacing '%s': %w", target, err)
+		}
+		return true, nil
+	}
+}
+
+// recordWritten stores what replaced the backed up files, so gsn backups restore notices later changes
+func (c *conflictResolver) recordWritten() error {
+	for _, backup := range c.written {
+		hash, err := hashFile(backup.Path)
+		if err != nil {
+			continue // Replaced by something other than a regular file
+		}
+		if err := c.backups.Written(backup, hash); err != nil {
+			return err
+		}
+	}
+	c.written = nil
+	return nil
+}
+
+// ask prompts the user for a single conflict, remembering "apply to all" answers
+func (c *conflictResolver) ask(target string) (conflictPolicy, error) {
+	if c.applyAll != "" {
+		return c.applyAll, nil
+	}
+	if !c.interactive {
+		return "", clierr.Newf(clierr.Usage, "--on-conflict prompt requires an interactive terminal")
+	}
+
+	for {
+		fmt.Printf("'%s' already exists. [o]verwrite, [s]kip, [b]ackup (uppercase applies to all): ", target)
+		answer, err := c.input.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+
+		answer = strings.TrimSpace(answer)
+		var choice conflictPolicy
+		switch strings.ToLower(answer) {
+		case "o":
+			choice = conflictOverwrite
+		case "s":
+			choice = conflictSkip
+		case "b":
+			choice = conflictBackup
+		default:
+			continue
+		}
+
+		if answer == strings.ToUpper(answer) {
+			c.applyAll = choice
+		}
+		return choice, nil
+	}
+}
+
+// summary renders the per resolution counts, empty when nothing conflicted
+func (c *conflictResolver) summary() string {
+	if len(c.counts) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Conflicts: %d overwritten, %d skipped, %d backed up",
+		c.counts[conflictOverwrite], c.counts[conflictSkip], c.counts[conflictBackup])
+}
+
+// nextBackupName returns the first free "<name>.bak.N" path
+func nextBackupName(path string) string {
+	for n := 1; ; n++ {
+		candidate := fmt.Sprintf("%s.bak.%d", path, n)
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package files
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// extractOverExisting extracts an archive of a.txt, b.txt, c.txt and d.txt into a destination already holding
+// the first three, and returns the destination with the resolver used
+func extractOverExisting(t *testing.T, conflicts func(destDir string) *conflictResolver) (string, *conflictResolver) {
+	t.Helper()
+	archive := filepath.Join(t.TempDir(), "new.tar")
+	writeFixtureTar(t, archive, []fixtureEntry{{Name: "a.txt", Body: "new a"}, {Name: "b.txt", Body: "new b"}, {Name: "c.txt", Body: "new c"}, {Name: "d.txt", Body: "new d"}})
+	destDir := t.TempDir()
+	writeTree(t, destDir, map[string]string{"a.txt": "old a", "b.txt": "old b", "c.txt": "old c"})
+
+	resolver := conflicts(destDir)
+	_, perms := testExtractPolicies(destDir)
+	if _, err := extractAll(archive, destDir, resolver, perms, nil); err != nil {
+		t.Fatal(err)
+	}
+	return destDir, resolver
+}
+
+// checkFiles compares the content of files below dir
+func checkFiles(t *testing.T, dir string, want map[string]string) {
+	t.Helper()
+	for name, body := range want {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if string(data) != body {
+			t.Errorf("%s = %q, want %q", name, data, body)
+		}
+	}
+}
+
+func TestConflictPolicies(t *testing.T) {
+	tests := []struct {
+		policy  conflictPolicy
+		want    map[string]string
+		summary string
+	}{
+		{conflictOverwrite, map[string]string{"a.txt": "new a", "b.txt": "new b", "c.txt": "new c", "d.txt": "new d"},
+			"Conflicts: 3 overwritten, 0 skipped, 0 backed up"},
+		{conflictSkip, map[string]string{"a.txt": "old a", "b.txt": "old b", "c.txt": "old c", "d.txt": "new d"},
+			"Conflicts: 0 overwritten, 3 skipped, 0 backed up"},
+		{conflictBackup, map[string]string{"a.txt": "new a", "a.txt.bak.1": "old a", "c.txt": "new c", "c.txt.bak.1": "old c", "d.txt": "new d"},
+			"Conflicts: 0 overwritten, 0 skipped, 3 backed up"},
+	}
+	for _, test := range tests {
+		t.Run(string(test.policy), func(t *testing.T) {
+			destDir, resolver := extractOverExisting(t, func(destDir string) *conflictResolver {
+				return newConflictResolver(test.policy, destDir)
+			})
+			checkFiles(t, destDir, test.want)
+			if got := resolver.summary(); got != test.summary {
+				t.Errorf("summary = %q, want %q", got, test.summary)
+			}
+		})
+	}
+}
+
+func TestConflictBackupTakesNextFreeName(t *testing.T) {
+	destDir, _ := extractOverExisting(t, func(destDir string) *conflictResolver {
+		writeTree(t, destDir, map[string]string{"a.txt.bak.1": "older a"})
+		return newConflictResolver(conflictBackup, destDir)
+	})
+	checkFiles(t, destDir, map[string]string{"a.txt": "new a", "a.txt.bak.1": "older a", "a.txt.bak.2": "old a"})
+}
+
+func TestConflictBackupJournalRestoresPreviousState(t *testing.T) {
+	destDir, resolver := extractOverExisting(t, func(destDir string) *conflictResolver {
+		return newConflictResolver(conflictBackup, destDir)
+	})
+	journalPath := filepath.Join(destDir, extractJournalName)
+	if err := resolver.journal.Save(journalPath); err != nil {
+		t.Fatal(err)
+	}
+
+	journal, err := loadJournal(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if journal.Command != "extract" || len(journal.Entries) != 3 {
+		t.Fatalf("journal = %+v, want 3 extract moves", journal)
+	}
+	// Undoing the extraction removes what it wrote and moves the backups back
+	for _, e := range journal.Entries {
+		if err := os.Remove(e.From); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := captureUndo(t, journal); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, destDir, map[string]string{"a.txt": "old a", "b.txt": "old b", "c.txt": "old c"})
+	if _, err := os.Lstat(filepath.Join(destDir, "a.txt.bak.1")); !os.IsNotExist(err) {
+		t.Errorf("a.txt.bak.1 is left after the undo: %v", err)
+	}
+}
+
+// captureUndo undoes journal, keeping what it prints out of the test output
+func captureUndo(t *testing.T, journal *Journal) ([]JournalEntry, error) {
+	t.Helper()
+	var reverted []JournalEntry
+	var err error
+	captureStdout(t, func() { reverted, err = journal.undo() })
+	return reverted, err
+}
+
+func TestConflictPrompt(t *testing.T) {
+	var resolver *conflictResolver
+	out := captureStdout(t, func() {
+		extractOverExisting(t, func(destDir string) *conflictResolver {
+			resolver = newConflictResolver(conflictPrompt, destDir)
+			// Skip a.txt, an unknown answer asks again, then back up b.txt and everything after it
+			resolver.input = bufio.NewReader(strings.NewReader("s\nx\nB\n"))
+			resolver.interactive = true
+			return resolver
+		})
+	})
+	if n := strings.Count(out, "already exists"); n != 3 {
+		t.Errorf("prompted %d times, want 3 for a.txt, the unknown answer and b.txt:\n%s", n, out)
+	}
+	checkFiles(t, filepath.Dir(resolver.journal.Entries[0].From), map[string]string{"a.txt": "old a", "b.txt": "new b", "b.txt.bak.1": "old b", "c.txt.bak.1": "old c"})
+	if got, want := resolver.summary(), "Conflicts: 0 overwritten, 1 skipped, 2 backed up"; got != want {
+		t.Errorf("summary = %q, want %q", got, want)
+	}
+}
+
+func TestConflictPromptNeedsTerminal(t *testing.T) {
+	destDir := t.TempDir()
+	writeTree(t, destDir, map[string]string{"a.txt": "old"})
+	resolver := newConflictResolver(conflictPrompt, destDir)
+	resolver.interactive = false
+	if _, err := resolver.resolve(filepath.Join(destDir, "a.txt")); err == nil {
+		t.Error("prompting without a terminal succeeded")
+	}
+}
+
+func TestParseConflictPolicy(t *testing.T) {
+	if p, err := parseConflictPolicy("Backup"); err != nil || p != conflictBackup {
+		t.Errorf("parseConflictPolicy(Backup) = %q, %v", p, err)
+	}
+	if _, err := parseConflictPolicy("rename"); err == nil {
+		t.Error("parseConflictPolicy(rename) succeeded")
+	}
+}
@@ -0,0 +1,204 @@
+package files
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/style"
+	"gsn-dev-tools/internals/units"
+
+	"github.com/spf13/cobra"
+)
+
+func ConvertCmd() *cobra.Command {
+	convertCmd := cobra.Command{
+		Use:   "convert <archive>",
+		Short: "Recompresses an archive into another format without extracting it",
+		Long: `Streams every entry of a zip, tar, tar.gz, tar.bz2 or tar.zst archive, or the file of a single .gz, .bz2 or
+.zst file, straight into a new archive of the requested format (tar, tar.gz, tar.zst or zip). The result is written to a temp file and renamed
+into place only once the conversion succeeds.
+
+Zip members carry no ownership metadata: when converting zip to tar, entries are owned by the uid/gid
+of the user running the command. Converting to zip drops uid/gid and user/group names.
+
+--smart stores the members that are compressed already, like .jpg, .mp4, .zip or .gz files, as they are rather
+than deflating them again for next to no gain. cmp.stored_extensions in the config file replaces the list of
+extensions.`,
+		Example: `  gsn cmp convert project.tar.gz
+  gsn cmp convert project.tar.gz --to zip --rm-source
+  gsn cmp convert photos.tar.gz --to zip --smart`,
+		Args: cobra.ExactArgs(1),
+		Run:  ConvertArchive,
+	}
+
+	convertCmd.Flags().String("to", formatTarZst.String(), "Target format: tar, tar.gz, tar.zst or zip")
+	convertCmd.Flags().Bool("rm-source", false, "Delete the source archive after the converted archive is verified")
+	convertCmd.Flags().Bool("smart", false, "Store members that are compressed already, e.g. .jpg or .gz, instead of deflating them (--to zip)")
+
+	return &convertCmd
+}
+
+func ConvertArchive(cmd *cobra.Command, args []string) {
+	sourcePath := args[0]
+	toName, _ := cmd.Flags().GetString("to")
+	rmSource, _ := cmd.Flags().GetBool("rm-source")
+	smart, _ := cmd.Flags().GetBool("smart")
+	startTime := time.Now()
+
+	format, err := parseFormat(toName)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	var stored *storedTypes
+	if smart {
+		if format.Container != containerZip {
+			clierr.Exitf(clierr.Usage, "--smart stores members of a zip, a %s archive compresses its whole stream", format)
+		}
+		if stored, err = loadStoredTypes(); err != nil {
+			clierr.Fatalf("%v", err)
+		}
+	}
+
+	targetPath := trimArchiveExt(sourcePath) + format.Extension()
+	if targetPath == sourcePath {
+		clierr.Fatalf("'%s' is already a %s archive", sourcePath, format)
+	}
+
+	digests, err := convertArchive(sourcePath, targetPath, format, stored)
+	if err != nil {
+		clierr.Fatalf("Conversion failed: %v", err)
+	}
+
+	if rmSource {
+		if err := verifyDigests(targetPath, digests); err != nil {
+			clierr.Fatalf("Verification failed, keeping source: %v", err)
+		}
+		if err := os.Remove(sourcePath); err != nil {
+			clierr.Fatalf("Error removing source archive: %v", err)
+		}
+		fmt.Printf(style.Trash()+"Removed source archive %s\n", sourcePath)
+	}
+
+	fmt.Printf(style.Success()+"Converted %s -> %s (%s entries, Time: %s)\n", sourcePath, targetPath, units.FormatInt(int64(len(digests))), units.FormatDuration(time.Since(startTime)))
+}
+
+// entryDigest records what was written for a single entry so the result can be verified
+type entryDigest struct {
+	name string
+	sum  [sha256.Size]byte
+}
+
+// convertArchive streams every entry of sourcePath into a new archive at targetPath, a zip storing the entries
+// of the stored types.
+// The archive is written to a temp file in the target directory and renamed atomically on success.
+func convertArchive(sourcePath string, targetPath string, format archiveFormat, stored *storedTypes) ([]entryDigest, error) {
+	src, err := openArchive(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(targetPath), ".gsn-convert-*")
+	if err != nil {
+		return nil, fmt.Errorf("error creating temp file: %w", err)
+	}
+	tmpName := tmpFile.Name()
+	defer os.Remove(tmpName) // no-op once renamed
+	defer tmpFile.Close()
+
+	dst, err := createArchive(tmpFile, format, stored)
+	if err != nil {
+		return nil, err
+	}
+
+	digests, err := copyEntries(src, dst)
+	if err != nil {
+		return nil, err
+	}
+	if err := dst.Close(); err != nil {
+		return nil, fmt.Errorf("error finalizing archive: %w", err)
+	}
+	if err := tmpFile.Chmod(newFileMode()); err != nil {
+		return nil, err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := os.Rename(tmpName, targetPath); err != nil {
+		return nil, fmt.Errorf("error moving archive into place: %w", err)
+	}
+	return digests, nil
+}
+
+// copyEntries copies headers and content from src to dst, hashing each entry's content on the way
+func copyEntries(src entryReader, dst entryWriter) ([]entryDigest, error) {
+	var digests []entryDigest
+	for {
+		header, err := src.Next()
+		if err == io.EOF {
+			return digests, nil
+		}
+		if err != nil {
+			return digests, fmt.Errorf("failed to read entry: %w", err)
+		}
+
+		if err := dst.WriteHeader(header); err != nil {
+			return digests, fmt.Errorf("failed to write header for '%s': %w", header.Name, err)
+		}
+
+		hasher := sha256.New()
+		if header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeRegA {
+			if _, err := io.Copy(io.MultiWriter(dst, hasher), src); err != nil {
+				return digests, fmt.Errorf("failed to copy '%s': %w", header.Name, err)
+			}
+		}
+
+		var d entryDigest
+		d.name = header.Name
+		copy(d.sum[:], hasher.Sum(nil))
+		digests = append(digests, d)
+	}
+}
+
+// verifyDigests re-reads an archive and checks that its entries match the recorded digests
+func verifyDigests(archivePath string, expected []entryDigest) error {
+	r, err := openArchive(archivePath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for i := 0; ; i++ {
+		header, err := r.Next()
+		if err == io.EOF {
+			if i != len(expected) {
+				return fmt.Errorf("archive has %d entries, expected %d", i, len(expected))
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if i >= len(expected) {
+			return fmt.Errorf("unexpected extra entry '%s'", header.Name)
+		}
+
+		hasher := sha256.New()
+		if _, err := io.Copy(hasher, r); err != nil {
+			return err
+		}
+
+		var sum [sha256.Size]byte
+		copy(sum[:], hasher.Sum(nil))
+		if sum != expected[i].sum {
+			return fmt.Errorf("content mismatch for '%s'", expected[i].name)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package files
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// convertFixture matches testdata/project.tar.bz2, written by Python's tarfile since Go has no bzip2 writer
+var convertFixture = []fixtureEntry{
+	{Name: "project/", Type: tar.TypeDir, Mode: 0o755},
+	{Name: "project/README.md", Type: tar.TypeReg, Body: "# project\n", Mode: 0o644},
+	{Name: "project/bin/run.sh", Type: tar.TypeReg, Body: "#!/bin/sh\necho run\n", Mode: 0o755},
+	{Name: "project/empty", Type: tar.TypeReg, Mode: 0o600},
+	{Name: "project/docs-link", Type: tar.TypeSymlink, Link: "README.md", Mode: 0o777},
+}
+
+// convertedEntry is what a conversion has to keep of an entry
+type convertedEntry struct {
+	fixtureEntry
+	ModTime int64
+	Uid     int
+}
+
+func readConverted(t *testing.T, path string) []convertedEntry {
+	t.Helper()
+	r, err := openArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var entries []convertedEntry
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, convertedEntry{
+			fixtureEntry: fixtureEntry{Name: header.Name, Type: header.Typeflag, Body: string(body), Link: header.Linkname, Mode: header.Mode & 0o777},
+			ModTime:      header.ModTime.Unix(),
+			Uid:          header.Uid,
+		})
+	}
+}
+
+func TestConvertRoundTripsEveryPair(t *testing.T) {
+	targets := []archiveFormat{formatTar, formatTarGz, formatTarZst, formatZip}
+	sources := []archiveFormat{formatTar, formatTarGz, formatTarBz2, formatTarZst, formatZip}
+
+	for _, source := range sources {
+		for _, target := range targets {
+			t.Run(source.String()+" to "+target.String(), func(t *testing.T) {
+				dir := t.TempDir()
+				sourcePath := filepath.Join(dir, "project."+source.String())
+				if source == formatTarBz2 {
+					copyFile(t, filepath.Join("testdata", "project.tar.bz2"), sourcePath)
+				} else {
+					writeFixtureArchive(t, sourcePath, source, convertFixture)
+				}
+				targetPath := filepath.Join(dir, "converted."+target.String())
+
+				digests, err := convertArchive(sourcePath, targetPath, target, nil)
+				if err != nil {
+					t.Fatalf("convertArchive: %v", err)
+				}
+				if err := verifyDigests(targetPath, digests); err != nil {
+					t.Errorf("verifyDigests: %v", err)
+				}
+				if format, ok := formatOfName(targetPath); !ok || format != target {
+					t.Errorf("converted archive is named as %v", format)
+				}
+
+				got := readConverted(t, targetPath)
+				if len(got) != len(convertFixture) {
+					t.Fatalf("converted archive has %d entries, want %d", len(got), len(convertFixture))
+				}
+				for i, want := range convertFixture {
+					if got[i].fixtureEntry != want {
+						t.Errorf("entry %d = %+v, want %+v", i, got[i].fixtureEntry, want)
+					}
+					if got[i].ModTime != fixtureTime.Unix() {
+						t.Errorf("entry %s has mtime %d, want %d", want.Name, got[i].ModTime, fixtureTime.Unix())
+					}
+				}
+				// Zip members carry no owner, converting them to tar gives them to the user running gsn
+				if source == formatZip && target != formatZip && got[1].Uid != os.Getuid() {
+					t.Errorf("owner of a zip member converted to tar = %d, want %d", got[1].Uid, os.Getuid())
+				}
+				entries, _ := os.ReadDir(dir)
+				if len(entries) != 2 {
+					t.Errorf("conversion left %d files behind, want only the source and the result", len(entries)-2)
+				}
+			})
+		}
+	}
+}
+
+func TestConvertSingleFiles(t *testing.T) {
+	want := fixtureEntry{Name: "notes.txt", Type: tar.TypeReg, Body: "remember the milk\n", Mode: 0o644}
+	for _, source := range []archiveFormat{formatGz, formatBz2, formatZst} {
+		for _, target := range []archiveFormat{formatTar, formatTarGz, formatTarZst, formatZip} {
+			t.Run(source.String()+" to "+target.String(), func(t *testing.T) {
+				dir := t.TempDir()
+				sourcePath := filepath.Join(dir, "notes.txt."+source.String())
+				if source == formatBz2 {
+					copyFile(t, filepath.Join("testdata", "notes.txt.bz2"), sourcePath)
+				} else {
+					writeFixtureArchive(t, sourcePath, source, []fixtureEntry{want})
+				}
+				targetPath := filepath.Join(dir, "notes."+target.String())
+
+				if _, err := convertArchive(sourcePath, targetPath, target, nil); err != nil {
+					t.Fatalf("convertArchive: %v", err)
+				}
+				got := readConverted(t, targetPath)
+				if len(got) != 1 || got[0].fixtureEntry != want {
+					t.Errorf("converted entries = %+v, want %+v", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestConvertKeepsTargetOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	sourcePath := filepath.Join(dir, "broken.tar.gz")
+	if err := os.WriteFile(sourcePath, []byte("\x1f\x8b\x08\x00 not really gzip"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	targetPath := filepath.Join(dir, "broken.tar.zst")
+	if _, err := convertArchive(sourcePath, targetPath, formatTarZst, nil); err == nil {
+		t.Fatal("converting a corrupt archive succeeded")
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("a failed conversion left %d files behind", len(entries)-1)
+	}
+}
+
+func TestVerifyDigestsDetectsChanges(t *testing.T) {
+	dir := t.TempDir()
+	sourcePath := filepath.Join(dir, "project.tar")
+	writeFixtureArchive(t, sourcePath, formatTar, convertFixture)
+	targetPath := filepath.Join(dir, "project.zip")
+	digests, err := convertArchive(sourcePath, targetPath, formatZip, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed := append([]entryDigest(nil), digests...)
+	changed[1].sum[0] ^= 0xff
+	if err := verifyDigests(targetPath, changed); err == nil {
+		t.Error("verifyDigests accepted a changed entry")
+	}
+	if err := verifyDigests(targetPath, digests[:2]); err == nil {
+		t.Error("verifyDigests accepted extra entries")
+	}
+}
+
+func copyFile(t *testing.T, from string, to string) {
+	t.Helper()
+	data, err := os.ReadFile(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(to, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,432 @@
+package files
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"gsn-dev-tools/internals/backups"
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/hooks"
+	"gsn-dev-tools/internals/notify"
+	"gsn-dev-tools/internals/progress"
+	"gsn-dev-tools/internals/style"
+	"gsn-dev-tools/internals/tmpfs"
+	"gsn-dev-tools/internals/units"
+
+	"github.com/schollz/progressbar/v3"
+	"github.com/spf13/cobra"
+)
+
+// sparseBlockSize is the granularity used to detect zero blocks when --sparse is set
+const sparseBlockSize = 64 * 1024
+
+func CopyCmd() *cobra.Command {
+	copyCmd := cobra.Command{
+		Use:   "cp <src> <dst>",
+		Short: "Copies a file or directory with progress and SHA-256 verification",
+		Long: `Streams a file or directory tree to a destination while showing progress and hashing the source.
+Mode and modification time are preserved. Files are written to a partial name and renamed when complete,
+so a failed or interrupted copy never leaves a truncated destination behind unless --keep-partial is set.
+With --backup the destination files being replaced are copied into the gsn backup store first, see gsn backups.`,
+		Example: `  gsn cp disk.img /mnt/backup/ --verify
+  gsn cp ./project /mnt/backup --preset auto --exclude '*.log' --bwlimit 50MB/s
+  gsn cp ./settings.json ~/.config/app/settings.json --backup`,
+		Args: cobra.ExactArgs(2),
+		Run:  CopyFiles,
+	}
+
+	copyCmd.Flags().Bool("verify", false, "Re-read every destination file and compare its SHA-256 with the source")
+	copyCmd.Flags().Bool("sparse", false, "Leave holes for zero blocks instead of writing them")
+	copyCmd.Flags().Bool("keep-partial", false, "Keep partially written files when the copy fails")
+	addExcludeFlags(&copyCmd)
+	copyCmd.Flags().Bool("respect-gitattributes", false, "Leave out the paths .gitattributes marks export-ignore when the source is in a git work tree")
+	addBandwidthFlag(&copyCmd)
+	backups.AddFlags(&copyCmd)
+	progress.AddStatusFlags(&copyCmd)
+
+	return &copyCmd
+}
+
+func CopyFiles(cmd *cobra.Command, args []string) {
+	startTime := time.Now()
+	verify, _ := cmd.Flags().GetBool("verify")
+	sparse, _ := cmd.Flags().GetBool("sparse")
+	keepPartial, _ := cmd.Flags().GetBool("keep-partial")
+	presetName, _ := cmd.Flags().GetString("preset")
+	excludes, _ := cmd.Flags().GetStringSlice("exclude")
+	respectAttributes, _ := cmd.Flags().GetBool("respect-gitattributes")
+
+	filter, err := archiveFilterFor(presetName, excludes, unlimitedDepth, args[0], respectAttributes)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	limiter, err := bandwidthLimiterFromFlags(cmd)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	store, keep, err := backups.FromFlags(cmd)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+
+	finishStatus := progress.StartStatus(cmd, "cp")
+	result, err := copyPath(args[0], args[1], copyOptions{
+		Verify:      verify,
+		Sparse:      sparse,
+		KeepPartial: keepPartial,
+		Filter:      filter,
+		Limiter:     limiter,
+		Backups:     store,
+		Keep:        keep,
+	})
+
+	finishStatus(err)
+
+	ev := notify.NewEvent("cp", startTime, err)
+	if result != nil {
+		ev.Counts = map[string]int{"files": result.Files}
+	}
+	hooks.Post(ev)
+
+	if err != nil {
+		clierr.Fatalf("Copy failed: %v", err)
+	}
+
+	if result.Files == 1 && result.Digest != "" {
+		fmt.Printf("sha256 %s\n", result.Digest)
+	}
+	verified := ""
+	if verify {
+		verified = ", verified"
+	}
+	fmt.Printf(style.Success()+"Copied %s file(s), %s to %s%s (Time: %s)\n", units.FormatInt(int64(result.Files)), units.FormatBytes(result.Bytes), result.Destination, verified, units.FormatDuration(time.Since(startTime)))
+}
+
+// copyOptions configures copyPath
+type copyOptions struct {
+	Verify      bool
+	Sparse      bool
+	KeepPartial bool
+	Filter      archiveFilter
+	Limiter     *bandwidthLimiter
+
+	// Backups receives the destination files being overwritten, keeping Keep backups of each
+	Backups *backups.Store
+	Keep    int
+}
+
+// copyResult summarizes a copy run; Digest is only set when a single file was copied
+type copyResult struct {
+	Destination string
+	Files       int
+	Bytes       int64
+	Digest      string
+}
+
+// copier carries the state shared by every file of a copy run
+type copier struct {
+	opts   copyOptions
+	bar    *progressbar.ProgressBar
+	result *copyResult
+}
+
+// copyPath copies src to dst. Like cp, an existing destination directory receives src under its own name.
+func copyPath(src string, dst string, opts copyOptions) (*copyResult, error) {
+	srcInfo, err := os.Lstat(src)
+	if err != nil {
+		return nil, fmt.Errorf("error accessing path '%s': %w", src, err)
+	}
+
+	if dstInfo, err := os.Stat(dst); err == nil && dstInfo.IsDir() {
+		dst = filepath.Join(dst, filepath.Base(filepath.Clean(src)))
+	}
+	if sameFile(src, dst) {
+		return nil, fmt.Errorf("'%s' and '%s' are the same file", src, dst)
+	}
+
+	totalSize, totalFiles := srcInfo.Size(), 1
+	if srcInfo.IsDir() {
+		if totalSize, totalFiles, err = copySourceSize(src, opts.Filter); err != nil {
+			return nil, fmt.Errorf("error calculating size for path '%s': %w", src, err)
+		}
+	}
+
+	progress.AddFiles(totalFiles)
+	c := &copier{
+		opts:   opts,
+		bar:    progress.NewBytes(totalSize, fmt.Sprintf("Copying %s", filepath.Base(src))),
+		result: &copyResult{Destination: dst},
+	}
+
+	if srcInfo.IsDir() {
+		err = c.copyTree(src, dst)
+	} else {
+		c.result.Digest, err = c.copyEntry(src, dst, srcInfo)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.bar.Finish()
+	if c.result.Files != 1 {
+		c.result.Digest = ""
+	}
+	return c.result, nil
+}
+
+// copySourceSize adds up the size of the regular files copyTree will copy and counts them with the symlinks
+func copySourceSize(root string, filter archiveFilter) (int64, int, error) {
+	entries, err := walkParallel(root, defaultWalkWorkers, unlimitedDepth)
+	if err != nil {
+		return 0, 0, err
+	}
+	var total int64
+	files := 0
+	for _, entry := range entries {
+		if filter.skipsBelow(root, entry.Path) {
+			continue
+		}
+		if entry.Info.Mode().IsRegular() {
+			total += entry.Info.Size()
+			files++
+		} else if entry.Info.Mode()&os.ModeSymlink != 0 {
+			files++
+		}
+	}
+	return total, files, nil
+}
+
+// copyTree recreates the directory tree below src at dst, skipping excluded entries
+func (c *copier) copyTree(src string, dst string) error {
+	var dirs []string
+	err := filepath.Walk(src, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if c.opts.Filter.skips(src, filePath) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(src, filePath)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		if info.IsDir() {
+			// Keep directories writable until their content is copied, the mode is restored afterwards
+			if err := os.MkdirAll(target, info.Mode().Perm()|0o700); err != nil {
+				return err
+			}
+			dirs = append(dirs, filePath)
+			return nil
+		}
+
+		_, err = c.copyEntry(filePath, target, info)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// Restore directory metadata deepest first so writing children does not bump parent mtimes
+	for i := len(dirs) - 1; i >= 0; i-- {
+		info, err := os.Stat(dirs[i])
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, dirs[i])
+		target := filepath.Join(dst, rel)
+		if err := os.Chmod(target, info.Mode().Perm()); err != nil {
+			return err
+		}
+		if err := os.Chtimes(target, info.ModTime(), info.ModTime()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyEntry copies a single regular file or symlink and returns the SHA-256 of the copied content
+func (c *copier) copyEntry(src string, dst string, info os.FileInfo) (string, error) {
+	if info.Mode()&os.ModeSymlink != 0 {
+		link, err := os.Readlink(src)
+		if err != nil {
+			return "", err
+		}
+		if err := os.Symlink(link, dst); err != nil {
+			return "", err
+		}
+		c.result.Files++
+		progress.FileDone()
+		return "", nil
+	}
+	if !info.Mode().IsRegular() {
+		fmt.Printf("Warning: Skipping unsupported entry '%s'\n", src)
+		return "", nil
+	}
+
+	digest, err := c.copyFile(src, dst, info)
+	if err != nil {
+		return "", fmt.Errorf("copying '%s': %w", src, err)
+	}
+
+	if c.opts.Verify {
+		written, err := hashFile(dst)
+		if err != nil {
+			return "", fmt.Errorf("verifying '%s': %w", dst, err)
+		}
+		if written != digest {
+			return "", fmt.Errorf("checksum mismatch for '%s': source %s, destination %s", dst, digest, written)
+		}
+	}
+
+	c.result.Files++
+	progress.FileDone()
+	c.result.Bytes += info.Size()
+	return digest, nil
+}
+
+// copyFile streams src into a partial file next to dst, hashing the source in the same read pass,
+// and renames it into place once content and metadata are written
+func (c *copier) copyFile(src string, dst string, info os.FileInfo) (digest string, err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".gsn-partial-*")
+	if err != nil {
+		return "", err
+	}
+	partial := out.Name()
+
+	untrack := func() {}
+	if !c.opts.KeepPartial {
+		untrack = tmpfs.TrackPartial(partial)
+	}
+	defer func() {
+		untrack()
+		if err != nil {
+			out.Close()
+			if c.opts.KeepPartial {
+				fmt.Fprintf(os.Stderr, style.Warning()+"Partial copy kept at %s\n", partial)
+			} else {
+				os.Remove(partial)
+			}
+		}
+	}()
+
+	hasher := sha256.New()
+	reader := io.TeeReader(io.TeeReader(c.opts.Limiter.Reader(in), hasher), c.bar)
+
+	if c.opts.Sparse {
+		err = copySparse(out, reader)
+	} else {
+		_, err = io.Copy(out, reader)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err = out.Chmod(info.Mode().Perm()); err != nil {
+		return "", err
+	}
+	if err = out.Close(); err != nil {
+		return "", err
+	}
+	if err = os.Chtimes(partial, info.ModTime(), info.ModTime()); err != nil {
+		return "", err
+	}
+	var backup *backups.Entry
+	if c.opts.Backups != nil {
+		if backup, err = c.opts.Backups.Backup(dst, "cp", c.opts.Keep); err != nil {
+			return "", err
+		}
+	}
+	if err = os.Rename(partial, dst); err != nil {
+		return "", err
+	}
+
+	digest = hex.EncodeToString(hasher.Sum(nil))
+	if backup != nil {
+		if err = c.opts.Backups.Written(backup, digest); err != nil {
+			return "", err
+		}
+	}
+	return digest, nil
+}
+
+// copySparse writes r to out, seeking over blocks that are entirely zero so the filesystem can leave holes
+func copySparse(out *os.File, r io.Reader) error {
+	buf := make([]byte, sparseBlockSize)
+	zero := make([]byte, sparseBlockSize)
+	var size int64
+
+	for {
+		n, err := io.ReadFull(r, buf)
+		size += int64(n)
+		if n > 0 {
+			if bytes.Equal(buf[:n], zero[:n]) {
+				if _, serr := out.Seek(int64(n), io.SeekCurrent); serr != nil {
+					return serr
+				}
+			} else if _, werr := out.Write(buf[:n]); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// A trailing hole is only materialized by setting the final size
+	return out.Truncate(size)
+}
+
+// matchesGlob reports whether an entry's base name or path relative to root matches one of the globs
+func matchesGlob(root string, filePath string, patterns []string) bool {
+	rel, err := filepath.Rel(root, filePath)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	name := filepath.Base(filePath)
+
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+		if matched, _ := path.Match(pattern, rel); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// sameFile reports whether two paths refer to the same existing file
+func sameFile(a string, b string) bool {
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bi, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(ai, bi)
+}
@@ -0,0 +1,176 @@
+package files
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listTree returns the slash separated paths below root, directories with a trailing slash
+func listTree(t *testing.T, root string) []string {
+	t.Helper()
+	var paths []string
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil || p == root {
+			return err
+		}
+		rel, _ := filepath.Rel(root, p)
+		if info.IsDir() {
+			rel += "/"
+		}
+		paths = append(paths, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+func TestCopyFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "disk.img")
+	content := strings.Repeat("\x00", 3*sparseBlockSize) + "data" + strings.Repeat("\x00", sparseBlockSize)
+	if err := os.WriteFile(src, []byte(content), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2023, 3, 4, 5, 6, 7, 0, time.UTC)
+	if err := os.Chtimes(src, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(content))
+
+	for _, sparse := range []bool{false, true} {
+		// An existing destination directory receives the file under its own name
+		dst := t.TempDir()
+		result, err := copyPath(src, dst, copyOptions{Verify: true, Sparse: sparse})
+		if err != nil {
+			t.Fatalf("sparse %v: %v", sparse, err)
+		}
+		target := filepath.Join(dst, "disk.img")
+		if result.Destination != target || result.Files != 1 || result.Bytes != int64(len(content)) || result.Digest != hex.EncodeToString(sum[:]) {
+			t.Errorf("sparse %v: result = %+v", sparse, result)
+		}
+		if data, _ := os.ReadFile(target); string(data) != content {
+			t.Errorf("sparse %v: copied %d bytes that differ from the source", sparse, len(data))
+		}
+		info, err := os.Stat(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0o640 || !info.ModTime().Equal(mtime) {
+			t.Errorf("sparse %v: mode %v, mtime %v, want 0640 and %v", sparse, info.Mode().Perm(), info.ModTime(), mtime)
+		}
+		if entries, _ := os.ReadDir(dst); len(entries) != 1 {
+			t.Errorf("sparse %v: a partial file was left behind: %v", sparse, entries)
+		}
+	}
+
+	if _, err := copyPath(src, src, copyOptions{}); err == nil || !strings.Contains(err.Error(), "same file") {
+		t.Errorf("copying a file onto itself = %v", err)
+	}
+}
+
+func TestCopyTree(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "project")
+	writeTree(t, src, map[string]string{
+		"main.go":             "package main\n",
+		"debug.log":           "log\n",
+		"internal/util.go":    "package internal\n",
+		"node_modules/x/x.js": "x\n",
+		"empty/":              "",
+	})
+	if err := os.Symlink("main.go", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(src, "internal"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(t.TempDir(), "copy")
+	filter := archiveFilter{Excludes: []string{"node_modules", "*.log"}}
+	result, err := copyPath(src, dst, copyOptions{Filter: filter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"empty/", "internal/", "internal/util.go", "link", "main.go"}
+	if got := listTree(t, dst); !slices.Equal(got, want) {
+		t.Errorf("copied %v, want %v", got, want)
+	}
+	if result.Files != 3 || result.Bytes != int64(len("package main\n")+len("package internal\n")) || result.Digest != "" {
+		t.Errorf("result = %+v", result)
+	}
+	if link, _ := os.Readlink(filepath.Join(dst, "link")); link != "main.go" {
+		t.Errorf("link points to %q, want main.go", link)
+	}
+	if info, _ := os.Stat(filepath.Join(dst, "internal")); info.Mode().Perm() != 0o750 {
+		t.Errorf("directory mode = %v, want 0750", info.Mode().Perm())
+	}
+
+	// The size shown by the progress bar counts what is copied only
+	if size, files, _ := copySourceSize(src, filter); size != result.Bytes || files != result.Files {
+		t.Errorf("copySourceSize = %d bytes in %d files, want %d in %d", size, files, result.Bytes, result.Files)
+	}
+}
+
+func TestCopyTreeWithPreset(t *testing.T) {
+	t.Setenv("GSN_HOME", t.TempDir())
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{"package.json": "{}", "index.js": "x\n", "node_modules/x/x.js": "x\n"})
+	var filter archiveFilter
+	stderr := captureStderr(t, func() {
+		var err error
+		if filter, err = archiveFilterFor("auto", []string{"*.json"}, unlimitedDepth, src, false); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if !strings.Contains(stderr, "Preset node") {
+		t.Errorf("stderr = %q, want the detected preset", stderr)
+	}
+	dst := filepath.Join(t.TempDir(), "copy")
+	if _, err := copyPath(src, dst, copyOptions{Filter: filter}); err != nil {
+		t.Fatal(err)
+	}
+	if got := listTree(t, dst); !slices.Equal(got, []string{"index.js"}) {
+		t.Errorf("copied %v, want index.js only", got)
+	}
+}
+
+func TestInterruptedCopyRemovesPartialFiles(t *testing.T) {
+	for _, keep := range []bool{false, true} {
+		src := filepath.Join(t.TempDir(), "project")
+		writeTree(t, src, map[string]string{"a.txt": "alpha\n", "b.txt": "bravo\n"})
+		// A directory in the way of b.txt makes the copy fail once its content is written
+		dst := filepath.Join(t.TempDir(), "copy")
+		writeTree(t, dst, map[string]string{"project/b.txt/keep": "x"})
+		dst = filepath.Join(dst, "project")
+
+		var err error
+		stderr := captureStderr(t, func() { _, err = copyPath(src, filepath.Dir(dst), copyOptions{KeepPartial: keep}) })
+		if err == nil || !strings.Contains(err.Error(), "b.txt") {
+			t.Fatalf("keep %v: copy = %v, want a failure on b.txt", keep, err)
+		}
+
+		var partials []string
+		for _, p := range listTree(t, dst) {
+			if strings.Contains(p, ".gsn-partial-") {
+				partials = append(partials, p)
+			}
+		}
+		if keep && (len(partials) != 1 || !strings.Contains(stderr, "Partial copy kept")) {
+			t.Errorf("with --keep-partial left %v and said %q, want the partial kept", partials, stderr)
+		}
+		if !keep && len(partials) > 0 {
+			t.Errorf("the partial files %v were left behind", partials)
+		}
+		// What was copied before the failure is complete
+		if data, _ := os.ReadFile(filepath.Join(dst, "a.txt")); string(data) != "alpha\n" {
+			t.Errorf("keep %v: a.txt = %q", keep, data)
+		}
+	}
+}
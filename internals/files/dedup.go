@@ -0,0 +1,768 @@
+package files
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/output"
+	"gsn-dev-tools/internals/pathfmt"
+	"gsn-dev-tools/internals/progress"
+	"gsn-dev-tools/internals/state"
+	"gsn-dev-tools/internals/style"
+	"gsn-dev-tools/internals/units"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/spf13/cobra"
+)
+
+// Layout of a dedup store: chunks/<2 hex>/<sha256> holds the zstd compressed chunks, snapshots/ the snapshots
+const (
+	dedupChunksDir    = "chunks"
+	dedupSnapshotsDir = "snapshots"
+	dedupLockName     = "lock"
+	snapshotSuffix    = ".dedup.json"
+)
+
+// dedupSnapshotKind versions the snapshots of a dedup store. They live in the stores, not in the gsn
+// directories, so they are migrated when read.
+var dedupSnapshotKind = state.Register(&state.Kind{
+	Name:     "dedup snapshot",
+	Format:   state.JSON,
+	Version:  1,
+	Patterns: []string{"*" + snapshotSuffix},
+	Files: func() ([]string, error) {
+		return nil, nil
+	},
+})
+
+// chunkSumPattern matches the names of chunks, snapshots naming anything else are refused
+var chunkSumPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// dedupSnapshot lists the entries of a directory at the time of a run, files by the chunks holding their content
+type dedupSnapshot struct {
+	SchemaVersion int          `json:"schema_version"`
+	Source        string       `json:"source"`
+	CreatedAt     time.Time    `json:"created_at"`
+	Chunking      string       `json:"chunking"`
+	Entries       []dedupEntry `json:"entries"`
+}
+
+type dedupEntry struct {
+	Path    string      `json:"path"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+	Size    int64       `json:"size,omitempty"`
+	Link    string      `json:"link,omitempty"`
+	Chunks  []string    `json:"chunks,omitempty"`
+}
+
+func backupDedupCmd() *cobra.Command {
+	dedupCmd := &cobra.Command{
+		Use:   "dedup <dir>",
+		Short: "Snapshots a directory into a deduplicating chunk store (experimental)",
+		Long: `Splits the files of a directory into chunks, stores every chunk once under its SHA-256 in the store and
+writes a snapshot listing the files by their chunks. Chunks already in the store, from an earlier snapshot or
+another file, are not stored again, so the unchanged parts of a tree cost nothing on the next run. Files
+whose size, mode and modification time match the previous snapshot of the same name are not even read again,
+--rehash reads them anyway.
+
+--chunking cdc, the default, cuts chunks where the content says so, 512 KiB to 4 MiB and about 1 MiB on
+average, so an insertion only changes the chunks around it. --chunking fixed cuts every 4 MiB. Chunks are
+stored compressed with zstd. The store is local and not encrypted.
+
+Snapshots are written to <store>/snapshots/<name>-<date>.dedup.json, the name defaults to the base name of
+the directory. Restore them with gsn backup dedup restore, and remove the chunks no snapshot references
+anymore with gsn backup dedup gc once snapshots were deleted. Paths are shown relative to the directory when
+they are below it, --relative-to '~' abbreviates the home directory instead.`,
+		Example: `  gsn backup dedup ~/projects --store /mnt/backup/store
+  gsn backup dedup ~/Pictures --store /mnt/backup/store --name photos --exclude '*.tmp'
+  gsn backup dedup ./vm --store /mnt/backup/store --chunking fixed`,
+		Args: cobra.ExactArgs(1),
+		Run:  RunDedupBackup,
+	}
+
+	dedupCmd.Flags().String("store", "", "Directory of the chunk store, created on the first run")
+	dedupCmd.Flags().String("name", "", "Name of the snapshot, the base name of the directory by default")
+	dedupCmd.Flags().String("chunking", chunkingCDC, "How files are cut into chunks: cdc (content-defined) or fixed (4 MiB)")
+	dedupCmd.Flags().StringSlice("exclude", nil, "Leave out entries whose name or path matches this glob (repeatable)")
+	dedupCmd.Flags().Bool("rehash", false, "Read every file, also those unchanged since the previous snapshot")
+	pathfmt.AddFlags(dedupCmd)
+	_ = dedupCmd.MarkFlagRequired("store")
+
+	dedupCmd.AddCommand(dedupRestoreCmd())
+	dedupCmd.AddCommand(dedupGCCmd())
+	return dedupCmd
+}
+
+func dedupRestoreCmd() *cobra.Command {
+	restoreCmd := &cobra.Command{
+		Use:   "restore <snapshot> <dest>",
+		Short: "Rebuilds the directory of a dedup snapshot",
+		Long: `Rebuilds the files, directories and symlinks of a snapshot in dest with their modes and modification
+times. Every chunk is hashed as it is read: a file with a missing or corrupt chunk, or a size other than the
+snapshot records, is not restored and reported, the others are, and the command exits with 1.
+
+dest must be empty or missing unless --force overwrites what is there. The store is the directory two levels
+above the snapshot unless --store says otherwise. Paths are shown relative to dest when they are below it,
+--relative-to '~' abbreviates the home directory instead.`,
+		Example: `  gsn backup dedup restore /mnt/backup/store/snapshots/projects-20261015-020000.dedup.json ./restored
+  gsn backup dedup restore projects.dedup.json ./restored --store /mnt/backup/store`,
+		Args: cobra.ExactArgs(2),
+		Run:  RestoreDedupSnapshot,
+	}
+
+	restoreCmd.Flags().String("store", "", "Directory of the chunk store, by default the one the snapshot is in")
+	restoreCmd.Flags().Bool("force", false, "Restore into a destination that is not empty, overwriting its files")
+	pathfmt.AddFlags(restoreCmd)
+	return restoreCmd
+}
+
+func dedupGCCmd() *cobra.Command {
+	gcCmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Removes the chunks no snapshot of a dedup store references",
+		Long: `Reads every snapshot of the store and removes the chunks none of them references, those left behind by
+deleted snapshots. A snapshot that cannot be read stops the collection before anything is removed. The store is
+locked while it runs, so a backup cannot add chunks in the meantime.`,
+		Example: `  gsn backup dedup gc --store /mnt/backup/store
+  gsn backup dedup gc --store /mnt/backup/store --dry-run`,
+		Args: cobra.NoArgs,
+		Run:  CollectDedupStore,
+	}
+
+	gcCmd.Flags().String("store", "", "Directory of the chunk store")
+	_ = gcCmd.MarkFlagRequired("store")
+	dryrun.Adopt(gcCmd)
+	return gcCmd
+}
+
+func RunDedupBackup(cmd *cobra.Command, args []string) {
+	startTime := time.Now()
+	source := args[0]
+	storeDir, _ := cmd.Flags().GetString("store")
+	name, _ := cmd.Flags().GetString("name")
+	chunkingName, _ := cmd.Flags().GetString("chunking")
+	excludes, _ := cmd.Flags().GetStringSlice("exclude")
+	rehash, _ := cmd.Flags().GetBool("rehash")
+
+	chunking, err := parseChunking(chunkingName)
+	if err != nil {
+		clierr.Exitf(clierr.Usage, "%v", err)
+	}
+	info, err := os.Stat(source)
+	if err != nil {
+		clierr.Fatalf("Error accessing '%s': %v", source, err)
+	}
+	if !info.IsDir() {
+		clierr.Exitf(clierr.Usage, "'%s' is not a directory", source)
+	}
+	filter, err := archiveFilterFor("", excludes, unlimitedDepth, source, false)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	if name == "" {
+		abs, err := filepath.Abs(source)
+		if err != nil {
+			clierr.Fatalf("%v", err)
+		}
+		name = filepath.Base(abs)
+	}
+	if strings.ContainsAny(name, `/\`) {
+		clierr.Exitf(clierr.Usage, "the snapshot name '%s' cannot contain a path separator", name)
+	}
+
+	display, err := pathfmt.FromFlags(cmd, source)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+
+	store, err := createDedupStore(storeDir)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	result, err := store.snapshot(source, name, dedupOptions{Chunking: chunking, Filter: filter, Rehash: rehash})
+	if err != nil {
+		clierr.Fatalf("Dedup backup failed: %v", err)
+	}
+
+	fmt.Printf(style.Success()+"Snapshot written: %s (Time: %s)\n", display.Path(result.SnapshotPath), units.FormatDuration(time.Since(startTime)))
+	fmt.Printf("%s file(s), %s: %s unchanged, %s read, %s new chunk(s) taking %s, %s chunk(s) already stored\n",
+		units.FormatInt(int64(result.Files)), units.FormatBytes(result.Size), units.FormatInt(int64(result.Unchanged)), units.FormatBytes(result.Read),
+		units.FormatInt(int64(result.NewChunks)), units.FormatBytes(result.Stored), units.FormatInt(int64(result.KnownChunks)))
+}
+
+func RestoreDedupSnapshot(cmd *cobra.Command, args []string) {
+	startTime := time.Now()
+	snapshotPath, dest := args[0], args[1]
+	storeDir, _ := cmd.Flags().GetString("store")
+	force, _ := cmd.Flags().GetBool("force")
+
+	if storeDir == "" {
+		storeDir = filepath.Dir(filepath.Dir(snapshotPath))
+	}
+	display, err := pathfmt.FromFlags(cmd, dest)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	store, err := openDedupStore(storeDir)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	snap, err := loadDedupSnapshot(snapshotPath)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	if !force {
+		entries, err := os.ReadDir(dest)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			clierr.Fatalf("%v", err)
+		}
+		if len(entries) > 0 {
+			clierr.Exitf(clierr.Conflict, "'%s' is not empty, restore into an empty directory or use --force", dest)
+		}
+	}
+
+	restored, problems, err := store.restore(snap, dest, force)
+	if err != nil {
+		clierr.Fatalf("Restore failed: %v", err)
+	}
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stderr, style.Failure()+problem)
+	}
+	if len(problems) > 0 {
+		clierr.Exitf(clierr.Failure, "%d file(s) failed the integrity check and were not restored, %d entries were", len(problems), restored)
+	}
+	fmt.Printf(style.Success()+"Restored %s entries of '%s' into %s (Time: %s)\n", units.FormatInt(int64(restored)), display.Path(snap.Source), display.Path(dest), units.FormatDuration(time.Since(startTime)))
+}
+
+func CollectDedupStore(cmd *cobra.Command, args []string) {
+	storeDir, _ := cmd.Flags().GetString("store")
+	dryRun := dryrun.Enabled()
+
+	store, err := openDedupStore(storeDir)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	removed, freed, err := store.collect(dryRun)
+	if err != nil {
+		clierr.Fatalf("Garbage collection failed: %v", err)
+	}
+	if dryRun {
+		fmt.Printf("Would remove %d unreferenced chunk(s), freeing %s\n", removed, units.FormatBytes(freed))
+		return
+	}
+	fmt.Printf(style.Trash()+"Removed %d unreferenced chunk(s), freeing %s\n", removed, units.FormatBytes(freed))
+}
+
+// dedupStore is a directory of content addressed chunks and the snapshots referencing them
+type dedupStore struct {
+	Dir string
+}
+
+// createDedupStore opens a store, creating it when the directory does not exist yet
+func createDedupStore(dir string) (dedupStore, error) {
+	store := dedupStore{Dir: dir}
+	for _, sub := range []string{dedupChunksDir, dedupSnapshotsDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return store, err
+		}
+	}
+	return store, nil
+}
+
+// openDedupStore opens an existing store
+func openDedupStore(dir string) (dedupStore, error) {
+	info, err := os.Stat(filepath.Join(dir, dedupChunksDir))
+	if err != nil || !info.IsDir() {
+		return dedupStore{}, clierr.Newf(clierr.NotFound, "'%s' is not a dedup store, it has no %s directory", dir, dedupChunksDir)
+	}
+	return dedupStore{Dir: dir}, nil
+}
+
+// lock takes the store lock, failing when another backup or gc holds it
+func (s dedupStore) lock() (func(), error) {
+	path := filepath.Join(s.Dir, dedupLockName)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if errors.Is(err, os.ErrExist) {
+		return nil, clierr.Newf(clierr.Conflict, "the store is used by another gsn process (remove %s if none is running)", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(f, "%d\n", os.Getpid())
+	f.Close()
+	return func() { os.Remove(path) }, nil
+}
+
+func (s dedupStore) chunkPath(sum string) string {
+	return filepath.Join(s.Dir, dedupChunksDir, sum[:2], sum)
+}
+
+// snapshots lists the snapshot files of the store, oldest first for those of the same name
+func (s dedupStore) snapshots() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(s.Dir, dedupSnapshotsDir))
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && strings.HasSuffix(e.Name(), snapshotSuffix) {
+			paths = append(paths, filepath.Join(s.Dir, dedupSnapshotsDir, e.Name()))
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// putChunk stores a chunk unless the store already has it and returns its name, and how many bytes it took
+// when it was new
+func (s dedupStore) putChunk(enc *zstd.Encoder, data []byte) (string, int64, error) {
+	digest := sha256.Sum256(data)
+	sum := hex.EncodeToString(digest[:])
+	path := s.chunkPath(sum)
+	if _, err := os.Lstat(path); err == nil {
+		return sum, 0, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", 0, err
+	}
+	compressed := enc.EncodeAll(data, nil)
+	if err := output.WriteFileAtomic(path, compressed, 0o644); err != nil {
+		return "", 0, err
+	}
+	return sum, int64(len(compressed)), nil
+}
+
+// integrityError is a file of a snapshot that cannot be restored as it was stored: one of its chunks is missing
+// or does not hold what its name says, or the chunks do not add up to its size
+type integrityError struct {
+	Reason string
+}
+
+func (e *integrityError) Error() string {
+	return e.Reason
+}
+
+// chunkProblem describes what is wrong with a chunk, by the start of its name
+func chunkProblem(sum string, format string, args ...any) *integrityError {
+	if len(sum) > 12 {
+		sum = sum[:12]
+	}
+	return &integrityError{Reason: "chunk " + sum + " " + fmt.Sprintf(format, args...)}
+}
+
+// readChunk returns the content of a chunk after checking it hashes to its name
+func (s dedupStore) readChunk(dec *zstd.Decoder, sum string) ([]byte, error) {
+	if !chunkSumPattern.MatchString(sum) {
+		return nil, chunkProblem(sum, "is not a valid chunk name")
+	}
+	raw, err := os.ReadFile(s.chunkPath(sum))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, chunkProblem(sum, "is missing from the store")
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := dec.DecodeAll(raw, nil)
+	if err != nil {
+		return nil, chunkProblem(sum, "is corrupt: %v", err)
+	}
+	if digest := sha256.Sum256(data); hex.EncodeToString(digest[:]) != sum {
+		return nil, chunkProblem(sum, "is corrupt: its content does not match its hash")
+	}
+	return data, nil
+}
+
+// loadDedupSnapshot reads a snapshot, migrating it from older versions
+func loadDedupSnapshot(path string) (*dedupSnapshot, error) {
+	var snap dedupSnapshot
+	doc, err := dedupSnapshotKind.Load(path, &snap)
+	if err != nil {
+		return nil, err
+	}
+	if err := doc.Writable(); err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+// dedupOptions tweaks a dedup backup run
+type dedupOptions struct {
+	Chunking string
+	Filter   archiveFilter
+	Rehash   bool
+}
+
+// dedupResult sums up a dedup backup run
+type dedupResult struct {
+	SnapshotPath string
+	Files        int
+	Size         int64
+	// Unchanged counts the files taken over from the previous snapshot without reading them
+	Unchanged int
+	Read      int64
+
+	NewChunks   int
+	Stored      int64
+	KnownChunks int
+}
+
+// snapshot stores the chunks of the files below source and writes the snapshot listing them
+func (s dedupStore) snapshot(source string, name string, opts dedupOptions) (*dedupResult, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	absSource, err := filepath.Abs(source)
+	if err != nil {
+		return nil, err
+	}
+	absStore, err := filepath.Abs(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	snapshotPath := filepath.Join(s.Dir, dedupSnapshotsDir, fmt.Sprintf("%s-%s%s", name, now.Format(backupTimeLayout), snapshotSuffix))
+	if _, err := os.Lstat(snapshotPath); err == nil {
+		return nil, clierr.Newf(clierr.Conflict, "snapshot '%s' already exists, runs of the same name are a second apart at least", snapshotPath)
+	}
+
+	var previous map[string]dedupEntry
+	if !opts.Rehash {
+		if previous, err = s.previousEntries(name, opts.Chunking); err != nil {
+			return nil, err
+		}
+	}
+	// The listing is walked in parallel and stored in path order, a parent always before its children
+	walked, err := walkParallel(source, defaultWalkWorkers, opts.Filter.walkDepth())
+	if err != nil {
+		return nil, fmt.Errorf("error listing '%s': %w", source, err)
+	}
+	var size int64
+	entries := walked[:0]
+	for _, e := range walked[1:] {
+		// A store inside the source would otherwise back itself up
+		if abs, err := filepath.Abs(e.Path); err == nil && (abs == absStore || strings.HasPrefix(abs, absStore+string(filepath.Separator))) {
+			continue
+		}
+		if opts.Filter.skipsBelow(source, e.Path) {
+			continue
+		}
+		if e.Info.Mode().IsRegular() {
+			size += e.Info.Size()
+		}
+		entries = append(entries, e)
+	}
+	bar := progress.NewBytesStderr(size, "Deduplicating "+name)
+
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer enc.Close()
+
+	result := &dedupResult{SnapshotPath: snapshotPath}
+	snap := dedupSnapshot{Source: absSource, CreatedAt: now, Chunking: opts.Chunking}
+	err = func() error {
+		for _, e := range entries {
+			filePath, info := e.Path, e.Info
+			rel, err := filepath.Rel(source, filePath)
+			if err != nil {
+				return err
+			}
+			entry := dedupEntry{Path: filepath.ToSlash(rel), Mode: info.Mode(), ModTime: info.ModTime()}
+			switch {
+			case info.IsDir():
+			case info.Mode()&os.ModeSymlink != 0:
+				if entry.Link, err = os.Readlink(filePath); err != nil {
+					return err
+				}
+			case info.Mode().IsRegular():
+				entry.Size = info.Size()
+				result.Files++
+				result.Size += info.Size()
+				if prev, ok := previous[entry.Path]; ok && prev.Size == entry.Size && prev.Mode == entry.Mode &&
+					prev.ModTime.Equal(entry.ModTime) && s.hasChunks(prev.Chunks) {
+					entry.Chunks = prev.Chunks
+					result.Unchanged++
+					_ = bar.Add64(entry.Size)
+					break
+				}
+				if entry.Chunks, err = s.storeFile(filePath, opts.Chunking, enc, bar, result); err != nil {
+					return fmt.Errorf("error storing '%s': %w", filePath, err)
+				}
+			default:
+				fmt.Fprintf(os.Stderr, style.Warning()+"Skipping '%s', only files, directories and symlinks are stored\n", filePath)
+				continue
+			}
+			snap.Entries = append(snap.Entries, entry)
+		}
+		return nil
+	}()
+	_ = bar.Finish()
+	if err != nil {
+		return nil, err
+	}
+
+	snap.SchemaVersion = dedupSnapshotKind.Version
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := output.WriteFileAtomic(snapshotPath, append(data, '\n'), 0o644); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// storeFile cuts a file into chunks, stores those the store does not have yet and returns their names in order
+func (s dedupStore) storeFile(filePath string, chunking string, enc *zstd.Encoder, bar progress.Tracker, result *dedupResult) ([]string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var sums []string
+	c := newChunker(file, chunking)
+	for {
+		chunk, err := c.next()
+		if errors.Is(err, io.EOF) {
+			return sums, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		sum, stored, err := s.putChunk(enc, chunk)
+		if err != nil {
+			return nil, err
+		}
+		if stored > 0 {
+			result.NewChunks++
+			result.Stored += stored
+		} else {
+			result.KnownChunks++
+		}
+		result.Read += int64(len(chunk))
+		_ = bar.Add64(int64(len(chunk)))
+		sums = append(sums, sum)
+	}
+}
+
+// hasChunks reports whether the store still holds all the chunks, a previous entry is only reused when it does
+func (s dedupStore) hasChunks(sums []string) bool {
+	for _, sum := range sums {
+		if !chunkSumPattern.MatchString(sum) {
+			return false
+		}
+		if _, err := os.Lstat(s.chunkPath(sum)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// previousEntries returns the files of the newest snapshot of name made with the same chunking, by path
+func (s dedupStore) previousEntries(name string, chunking string) (map[string]dedupEntry, error) {
+	paths, err := s.snapshots()
+	if err != nil {
+		return nil, err
+	}
+	pattern := regexp.MustCompile(`^` + regexp.QuoteMeta(name) + `-\d{8}-\d{6}` + regexp.QuoteMeta(snapshotSuffix) + `$`)
+	for i := len(paths) - 1; i >= 0; i-- {
+		if !pattern.MatchString(filepath.Base(paths[i])) {
+			continue
+		}
+		snap, err := loadDedupSnapshot(paths[i])
+		if err != nil {
+			return nil, err
+		}
+		if snap.Chunking != chunking {
+			return nil, nil
+		}
+		entries := make(map[string]dedupEntry, len(snap.Entries))
+		for _, e := range snap.Entries {
+			if e.Mode.IsRegular() {
+				entries[e.Path] = e
+			}
+		}
+		return entries, nil
+	}
+	return nil, nil
+}
+
+// restore rebuilds the entries of snap below dest. Files with a chunk failing the integrity check are skipped and
+// returned as problems. Symlinks are created last, so no file is written through one.
+func (s dedupStore) restore(snap *dedupSnapshot, dest string, force bool) (int, []string, error) {
+	if err := os.MkdirAll(dest, 0o755); err != nil {
+		return 0, nil, err
+	}
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer dec.Close()
+
+	restored := 0
+	var problems []string
+	var dirs, links []dedupEntry
+	for _, e := range snap.Entries {
+		target, err := safeJoin(dest, e.Path)
+		if err != nil {
+			return restored, problems, err
+		}
+		switch {
+		case e.Mode.IsDir():
+			if err := os.MkdirAll(target, 0o700); err != nil {
+				return restored, problems, err
+			}
+			dirs = append(dirs, e)
+			continue
+		case e.Mode&os.ModeSymlink != 0:
+			links = append(links, e)
+			continue
+		}
+
+		err = s.restoreFile(dec, e, target)
+		var integrityErr *integrityError
+		if errors.As(err, &integrityErr) {
+			problems = append(problems, fmt.Sprintf("'%s': %v", e.Path, err))
+			continue
+		}
+		if err != nil {
+			return restored, problems, fmt.Errorf("error restoring '%s': %w", e.Path, err)
+		}
+		restored++
+	}
+
+	for _, e := range links {
+		target, _ := safeJoin(dest, e.Path)
+		if force {
+			_ = os.Remove(target)
+		}
+		if err := os.Symlink(e.Link, target); err != nil {
+			return restored, problems, err
+		}
+		restored++
+	}
+	// Directories get their mode and times last, restoring their contents changed them
+	for i := len(dirs) - 1; i >= 0; i-- {
+		target, _ := safeJoin(dest, dirs[i].Path)
+		if err := os.Chmod(target, dirs[i].Mode.Perm()); err != nil {
+			return restored, problems, err
+		}
+		if err := os.Chtimes(target, dirs[i].ModTime, dirs[i].ModTime); err != nil {
+			return restored, problems, err
+		}
+		restored++
+	}
+	return restored, problems, nil
+}
+
+// restoreFile writes the chunks of a file to a temp file next to target and moves it in place once they all
+// passed the integrity check
+func (s dedupStore) restoreFile(dec *zstd.Decoder, e dedupEntry, target string) (err error) {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".gsn-restore-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	var written int64
+	for _, sum := range e.Chunks {
+		data, err := s.readChunk(dec, sum)
+		if err != nil {
+			return err
+		}
+		if _, err := tmp.Write(data); err != nil {
+			return err
+		}
+		written += int64(len(data))
+	}
+	if written != e.Size {
+		return &integrityError{Reason: fmt.Sprintf("the chunks hold %d bytes, the snapshot records %d", written, e.Size)}
+	}
+	if err := tmp.Chmod(e.Mode.Perm()); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return err
+	}
+	return os.Chtimes(target, e.ModTime, e.ModTime)
+}
+
+// collect removes the chunks no snapshot references and returns how many there were and the bytes they took
+func (s dedupStore) collect(dryRun bool) (int, int64, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer unlock()
+
+	paths, err := s.snapshots()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return 0, 0, err
+	}
+	referenced := make(map[string]bool)
+	for _, path := range paths {
+		snap, err := loadDedupSnapshot(path)
+		if err != nil {
+			return 0, 0, fmt.Errorf("cannot read snapshot '%s', nothing was removed: %w", path, err)
+		}
+		for _, e := range snap.Entries {
+			for _, sum := range e.Chunks {
+				referenced[sum] = true
+			}
+		}
+	}
+
+	removed := 0
+	var freed int64
+	err = filepath.WalkDir(filepath.Join(s.Dir, dedupChunksDir), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || !chunkSumPattern.MatchString(d.Name()) || referenced[d.Name()] {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !dryRun {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+		removed++
+		freed += info.Size()
+		return nil
+	})
+	return removed, freed, err
+}
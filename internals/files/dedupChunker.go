@@ -0,0 +1,104 @@
+package files
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Chunk sizes of the dedup store. Fixed chunking cuts every maxChunkSize bytes, content-defined chunking cuts
+// where the rolling hash of the last bytes has its low bits clear, about every 1 MiB, never below minChunkSize
+// nor above maxChunkSize.
+const (
+	minChunkSize = 512 * 1024
+	maxChunkSize = 4 * 1024 * 1024
+	cdcMask      = 1<<20 - 1
+)
+
+// Chunking modes, as snapshots record them
+const (
+	chunkingCDC   = "cdc"
+	chunkingFixed = "fixed"
+)
+
+// gearTable maps every byte to the random value the rolling hash adds for it. It is generated from a fixed seed
+// so cut points, and so chunk hashes, stay the same from one gsn version to the next.
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x6773_6e2d_6465_6475) // "gsn-dedu"
+	for i := range table {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// parseChunking validates a chunking mode
+func parseChunking(mode string) (string, error) {
+	switch mode {
+	case chunkingCDC, chunkingFixed:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown chunking '%s' (use %s or %s)", mode, chunkingCDC, chunkingFixed)
+}
+
+// chunker splits a stream into chunks. With content-defined chunking an edit only changes the chunks around it,
+// the cut points after it realign with those of the previous version of the file.
+type chunker struct {
+	r   io.Reader
+	cdc bool
+
+	// buf holds the bytes read ahead, a whole maxChunkSize until the end of the stream, so where a chunk is
+	// cut does not depend on how reads return
+	buf []byte
+	n   int
+	eof bool
+}
+
+func newChunker(r io.Reader, mode string) *chunker {
+	return &chunker{r: r, cdc: mode == chunkingCDC, buf: make([]byte, maxChunkSize)}
+}
+
+// next returns the next chunk, or io.EOF after the last one
+func (c *chunker) next() ([]byte, error) {
+	if !c.eof && c.n < len(c.buf) {
+		m, err := io.ReadFull(c.r, c.buf[c.n:])
+		c.n += m
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			c.eof = true
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if c.n == 0 {
+		return nil, io.EOF
+	}
+
+	cut := c.n
+	if c.cdc {
+		cut = cutPoint(c.buf[:c.n])
+	}
+	chunk := bytes.Clone(c.buf[:cut])
+	c.n = copy(c.buf, c.buf[cut:c.n])
+	return chunk, nil
+}
+
+// cutPoint returns the length of the first chunk of data by the gear rolling hash, the whole of data when no cut
+// point falls within it
+func cutPoint(data []byte) int {
+	if len(data) <= minChunkSize {
+		return len(data)
+	}
+	var hash uint64
+	for i := minChunkSize; i < len(data); i++ {
+		hash = hash<<1 + gearTable[data[i]]
+		if hash&cdcMask == 0 {
+			return i + 1
+		}
+	}
+	return len(data)
+}
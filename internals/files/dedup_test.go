@@ -0,0 +1,397 @@
+package files
+
+import (
+	"bytes"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestDedupSnapshotRestoresTree(t *testing.T) {
+	source := t.TempDir()
+	writeTree(t, source, map[string]string{
+		"a.txt":          "alpha",
+		"a-b/c.txt":      "dash",
+		"a/b/c.txt":      "nested",
+		"empty/":         "",
+		"skip/drop.tmp":  "dropped",
+		"keep/drop.tmp":  "dropped",
+		"keep/kept.txt":  "kept",
+		"dup/a-copy.txt": "alpha",
+	})
+	if err := os.Symlink("a/b/c.txt", filepath.Join(source, "link")); err != nil {
+		t.Fatal(err)
+	}
+	// The store lives inside the source and must not be snapshotted into itself
+	store, err := createDedupStore(filepath.Join(source, ".store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := store.snapshot(source, "tree", dedupOptions{Chunking: chunkingCDC, Filter: archiveFilter{Excludes: []string{"*.tmp", "skip"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Files != 5 || result.Size != 24 {
+		t.Errorf("snapshot stored %d files of %d bytes, want 5 files of 24 bytes", result.Files, result.Size)
+	}
+	snap, err := loadDedupSnapshot(result.SnapshotPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, e := range snap.Entries {
+		if i > 0 && snap.Entries[i-1].Path >= e.Path {
+			t.Errorf("entries out of order: %s before %s", snap.Entries[i-1].Path, e.Path)
+		}
+	}
+
+	dest := filepath.Join(t.TempDir(), "restored")
+	if _, problems, err := store.restore(snap, dest, false); err != nil || len(problems) > 0 {
+		t.Fatalf("restore = %v %v", problems, err)
+	}
+	var got []string
+	err = filepath.Walk(dest, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dest, path)
+		got = append(got, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{".", "a", "a-b", "a-b/c.txt", "a.txt", "a/b", "a/b/c.txt", "dup", "dup/a-copy.txt", "empty", "keep", "keep/kept.txt", "link"}
+	if len(got) != len(want) {
+		t.Fatalf("restored %v, want %v", got, want)
+	}
+	for _, name := range want {
+		if _, err := os.Lstat(filepath.Join(dest, filepath.FromSlash(name))); err != nil {
+			t.Errorf("restored tree misses %s: %v", name, err)
+		}
+	}
+	if data, _ := os.ReadFile(filepath.Join(dest, "dup", "a-copy.txt")); string(data) != "alpha" {
+		t.Errorf("dup/a-copy.txt = %q, want alpha", data)
+	}
+	if link, _ := os.Readlink(filepath.Join(dest, "link")); link != "a/b/c.txt" {
+		t.Errorf("link points to %q, want a/b/c.txt", link)
+	}
+}
+
+// randomBytes returns n bytes of a fixed pseudo-random sequence, content that neither compresses nor repeats
+func randomBytes(seed uint64, n int) []byte {
+	data := make([]byte, n)
+	r := rand.New(rand.NewPCG(seed, seed))
+	for i := range data {
+		data[i] = byte(r.Uint32())
+	}
+	return data
+}
+
+// chunkFiles lists the chunk files of a store
+func chunkFiles(t *testing.T, store dedupStore) []string {
+	t.Helper()
+	var paths []string
+	err := filepath.WalkDir(filepath.Join(store.Dir, dedupChunksDir), func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			paths = append(paths, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+// backdate renames a snapshot as if it was taken in 2000, so the next run of the same name does not collide
+func backdate(t *testing.T, result *dedupResult, name string) string {
+	t.Helper()
+	path := filepath.Join(filepath.Dir(result.SnapshotPath), name+"-20000101-000000"+snapshotSuffix)
+	if err := os.Rename(result.SnapshotPath, path); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDedupRoundTrip(t *testing.T) {
+	for _, chunking := range []string{chunkingCDC, chunkingFixed} {
+		t.Run(chunking, func(t *testing.T) {
+			source := t.TempDir()
+			big := randomBytes(1, 9<<20)
+			files := map[string][]byte{
+				"big.bin":       big,
+				"small.txt":     []byte("small\n"),
+				"empty":         nil,
+				"exact.bin":     randomBytes(2, maxChunkSize),
+				"sub/again.bin": big,
+			}
+			for name, data := range files {
+				path := filepath.Join(source, filepath.FromSlash(name))
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, data, 0o640); err != nil {
+					t.Fatal(err)
+				}
+			}
+			mtime := time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)
+			if err := os.Chtimes(filepath.Join(source, "big.bin"), mtime, mtime); err != nil {
+				t.Fatal(err)
+			}
+			store, err := createDedupStore(filepath.Join(t.TempDir(), "store"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			first, err := store.snapshot(source, "tree", dedupOptions{Chunking: chunking})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The second copy of big.bin is made of chunks already stored
+			if first.Files != 5 || first.KnownChunks == 0 || first.NewChunks != len(chunkFiles(t, store)) {
+				t.Errorf("first snapshot = %+v with %d chunk files", first, len(chunkFiles(t, store)))
+			}
+
+			snap, err := loadDedupSnapshot(first.SnapshotPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dest := filepath.Join(t.TempDir(), "restored")
+			if _, problems, err := store.restore(snap, dest, false); err != nil || len(problems) > 0 {
+				t.Fatalf("restore = %v %v", problems, err)
+			}
+			for name, data := range files {
+				path := filepath.Join(dest, filepath.FromSlash(name))
+				got, err := os.ReadFile(path)
+				if err != nil || !bytes.Equal(got, data) {
+					t.Errorf("restored %s holds %d bytes (%v), want the %d stored", name, len(got), err, len(data))
+				}
+				if info, _ := os.Stat(path); info == nil || info.Mode().Perm() != 0o640 {
+					t.Errorf("restored %s has mode %v, want 0640", name, info.Mode())
+				}
+			}
+			if info, _ := os.Stat(filepath.Join(dest, "big.bin")); info == nil || !info.ModTime().Equal(mtime) {
+				t.Errorf("restored big.bin has mtime %v, want %v", info.ModTime(), mtime)
+			}
+
+			// Unchanged files are taken over without being read, an edit stores only the chunks around it
+			backdate(t, first, "tree")
+			edited := append(append(bytes.Clone(big[:3<<20]), "inserted"...), big[3<<20:]...)
+			if err := os.WriteFile(filepath.Join(source, "big.bin"), edited, 0o640); err != nil {
+				t.Fatal(err)
+			}
+			second, err := store.snapshot(source, "tree", dedupOptions{Chunking: chunking})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if second.Unchanged != 4 || second.Read != int64(len(edited)) {
+				t.Errorf("second snapshot = %+v, want 4 unchanged files and only big.bin read", second)
+			}
+			// Content-defined cut points realign after the insertion, fixed ones all shift
+			reused := second.KnownChunks
+			if chunking == chunkingCDC && reused == 0 || chunking == chunkingFixed && reused != 0 {
+				t.Errorf("%s chunking reused %d chunks of the %d of big.bin", chunking, reused, second.KnownChunks+second.NewChunks)
+			}
+
+			snap, err = loadDedupSnapshot(second.SnapshotPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dest = filepath.Join(t.TempDir(), "restored")
+			if _, problems, err := store.restore(snap, dest, false); err != nil || len(problems) > 0 {
+				t.Fatalf("restore of the second snapshot = %v %v", problems, err)
+			}
+			if got, _ := os.ReadFile(filepath.Join(dest, "big.bin")); !bytes.Equal(got, edited) {
+				t.Error("the edited big.bin was not restored as edited")
+			}
+		})
+	}
+}
+
+func TestDedupRestoreDetectsCorruption(t *testing.T) {
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+
+	tests := []struct {
+		name    string
+		corrupt func(t *testing.T, store dedupStore, snap *dedupSnapshot, entry *dedupEntry)
+		want    string
+	}{
+		{"flipped byte", func(t *testing.T, store dedupStore, snap *dedupSnapshot, entry *dedupEntry) {
+			path := store.chunkPath(entry.Chunks[0])
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			raw[len(raw)/2] ^= 0xff
+			if err := os.WriteFile(path, raw, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}, "is corrupt: "},
+		{"other content", func(t *testing.T, store dedupStore, snap *dedupSnapshot, entry *dedupEntry) {
+			if err := os.WriteFile(store.chunkPath(entry.Chunks[0]), enc.EncodeAll([]byte("something else"), nil), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}, "is corrupt: its content does not match its hash"},
+		{"missing chunk", func(t *testing.T, store dedupStore, snap *dedupSnapshot, entry *dedupEntry) {
+			if err := os.Remove(store.chunkPath(entry.Chunks[len(entry.Chunks)-1])); err != nil {
+				t.Fatal(err)
+			}
+		}, "is missing from the store"},
+		{"invalid chunk name", func(t *testing.T, store dedupStore, snap *dedupSnapshot, entry *dedupEntry) {
+			entry.Chunks[0] = "../../../etc/passwd"
+		}, "is not a valid chunk name"},
+		{"size mismatch", func(t *testing.T, store dedupStore, snap *dedupSnapshot, entry *dedupEntry) {
+			entry.Size++
+		}, "the chunks hold 2097152 bytes, the snapshot records 2097153"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := t.TempDir()
+			writeTree(t, source, map[string]string{"ok.txt": "fine", "other/ok.txt": "fine too"})
+			if err := os.WriteFile(filepath.Join(source, "victim.bin"), randomBytes(3, 2<<20), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			store, err := createDedupStore(filepath.Join(t.TempDir(), "store"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := store.snapshot(source, "tree", dedupOptions{Chunking: chunkingCDC})
+			if err != nil {
+				t.Fatal(err)
+			}
+			snap, err := loadDedupSnapshot(result.SnapshotPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			i := slices.IndexFunc(snap.Entries, func(e dedupEntry) bool { return e.Path == "victim.bin" })
+			test.corrupt(t, store, snap, &snap.Entries[i])
+
+			// The file is reported and left out, every other entry is restored
+			dest := filepath.Join(t.TempDir(), "restored")
+			restored, problems, err := store.restore(snap, dest, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(problems) != 1 || !strings.HasPrefix(problems[0], "'victim.bin': ") || !strings.Contains(problems[0], test.want) {
+				t.Errorf("problems = %q, want one for victim.bin with %q", problems, test.want)
+			}
+			if restored != len(snap.Entries)-1 {
+				t.Errorf("restored %d entries, want all %d but victim.bin", restored, len(snap.Entries)-1)
+			}
+			if got, _ := os.ReadFile(filepath.Join(dest, "other", "ok.txt")); string(got) != "fine too" {
+				t.Errorf("other/ok.txt = %q", got)
+			}
+			// Not even a partial file or a temp file stays behind
+			if names := dirNames(t, dest); !slices.Equal(names, []string{"ok.txt", "other"}) {
+				t.Errorf("restored %q", names)
+			}
+		})
+	}
+}
+
+func TestDedupSnapshotStoresMissingChunksAgain(t *testing.T) {
+	source := t.TempDir()
+	if err := os.WriteFile(filepath.Join(source, "data.bin"), randomBytes(4, 1<<20), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := createDedupStore(filepath.Join(t.TempDir(), "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := store.snapshot(source, "tree", dedupOptions{Chunking: chunkingCDC})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backdate(t, first, "tree")
+	for _, path := range chunkFiles(t, store) {
+		if err := os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The unchanged file lost its chunks, it is read and stored again rather than taken over
+	second, err := store.snapshot(source, "tree", dedupOptions{Chunking: chunkingCDC})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Unchanged != 0 || second.NewChunks == 0 {
+		t.Errorf("second snapshot = %+v", second)
+	}
+	snap, err := loadDedupSnapshot(second.SnapshotPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, problems, err := store.restore(snap, filepath.Join(t.TempDir(), "restored"), false); err != nil || len(problems) > 0 {
+		t.Errorf("restore = %v %v", problems, err)
+	}
+}
+
+func TestDedupCollect(t *testing.T) {
+	source := t.TempDir()
+	if err := os.WriteFile(filepath.Join(source, "a.bin"), randomBytes(5, 1<<20), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := createDedupStore(filepath.Join(t.TempDir(), "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := store.snapshot(source, "one", dedupOptions{Chunking: chunkingCDC})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(source, "b.bin"), randomBytes(6, 1<<20), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.snapshot(source, "two", dedupOptions{Chunking: chunkingCDC}); err != nil {
+		t.Fatal(err)
+	}
+	before := chunkFiles(t, store)
+
+	// Every chunk is referenced
+	if removed, _, err := store.collect(false); err != nil || removed != 0 {
+		t.Errorf("collect = %d, %v, want nothing removed", removed, err)
+	}
+
+	// Without snapshot two the chunks of b.bin are garbage, a dry run only counts them
+	if err := os.Remove(strings.Replace(first.SnapshotPath, "one-", "two-", 1)); err != nil {
+		t.Fatal(err)
+	}
+	removed, freed, err := store.collect(true)
+	if err != nil || removed == 0 || freed == 0 || len(chunkFiles(t, store)) != len(before) {
+		t.Errorf("dry run = %d chunks, %d bytes, %v", removed, freed, err)
+	}
+	if again, _, err := store.collect(false); err != nil || again != removed || len(chunkFiles(t, store)) != len(before)-removed {
+		t.Errorf("collect = %d, %v, want the %d of the dry run", again, err, removed)
+	}
+	snap, err := loadDedupSnapshot(first.SnapshotPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, problems, err := store.restore(snap, filepath.Join(t.TempDir(), "restored"), false); err != nil || len(problems) > 0 {
+		t.Errorf("restore after collect = %v %v", problems, err)
+	}
+
+	// Another process holding the store
+	unlock, err := store.lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+	if _, _, err := store.collect(false); clierr.CodeOf(err) != clierr.Conflict {
+		t.Errorf("collect of a locked store = %v", err)
+	}
+}
@@ -0,0 +1,289 @@
+package files
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"gsn-dev-tools/internals/tmpfs"
+)
+
+const (
+	// dockerManifestName lists the images of a docker save tarball with their config and layers
+	dockerManifestName = "manifest.json"
+	// maxDockerManifest bounds the manifest read into memory, real ones are a few KiB
+	maxDockerManifest = 16 << 20
+	// maxLayerLinks bounds the symlinks followed to find a layer, docker links a layer saved twice to the first
+	maxLayerLinks = 8
+)
+
+// dockerImage is an image of manifest.json, its layers are listed base first
+type dockerImage struct {
+	Layers []string
+}
+
+// isDockerSaveCandidate reports whether the tar stream starts like docker save writes it: with the blobs of the
+// OCI layout, or with the directory of a layer named by its 64 hex digit id. The manifest decides.
+func isDockerSaveCandidate(br *bufio.Reader) bool {
+	block, _ := br.Peek(512)
+	if len(block) < 512 {
+		return false
+	}
+	name := cString(block[:100])
+	if bytes.HasPrefix(block[257:], []byte("ustar\x00")) {
+		if prefix := cString(block[345:500]); prefix != "" {
+			name = prefix + "/" + name
+		}
+	}
+	first, _, _ := strings.Cut(strings.TrimPrefix(name, "./"), "/")
+	switch first {
+	case "blobs", "oci-layout", "index.json", dockerManifestName, "repositories":
+		return true
+	}
+	id := strings.TrimSuffix(first, ".json")
+	return len(id) == 64 && strings.Trim(id, "0123456789abcdef") == ""
+}
+
+func cString(field []byte) string {
+	if i := bytes.IndexByte(field, 0); i >= 0 {
+		field = field[:i]
+	}
+	return string(field)
+}
+
+// dockerMember is an entry of the outer tar of an image tarball and where its content starts
+type dockerMember struct {
+	header *tar.Header
+	offset int64
+	// layer is the directory the entries of a layer are listed below, "" for other members
+	layer string
+}
+
+// dockerEntryReader lists an image tarball: the members that are not layers as they are, then every layer as a
+// layer-N directory of its entries, in the order of manifest.json, the base layer first
+type dockerEntryReader struct {
+	file      *os.File
+	workspace *tmpfs.Workspace
+	steps     []dockerMember
+	nested    *nestedTar
+	current   io.Reader
+}
+
+// openDockerSave opens a tarball written by docker save, nil when it has no manifest.json listing layers. Its
+// layers are read in manifest order, which needs to seek: a compressed tarball is decompressed to a temp file
+// first.
+func openDockerSave(archivePath string, c codec) (entryReader, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	r := &dockerEntryReader{file: file}
+	if c != codecNone {
+		if err := r.spool(c); err != nil {
+			r.Close()
+			return nil, err
+		}
+	}
+
+	members, err := indexTar(r.file)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	images, err := r.readManifest(members)
+	if err != nil || images == nil {
+		r.Close()
+		return nil, err
+	}
+	if r.steps, err = dockerSteps(members, images); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// spool decompresses the tarball to a temp file and reads that instead
+func (r *dockerEntryReader) spool(c codec) error {
+	stream, decoder, err := newCodecReader(bufio.NewReader(r.file), c)
+	if err != nil {
+		return err
+	}
+	defer decoder.Close()
+	if r.workspace, err = tmpfs.New("docker-save"); err != nil {
+		return err
+	}
+	spool, err := r.workspace.CreateFile("image-*.tar")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(spool, stream); err != nil {
+		spool.Close()
+		return fmt.Errorf("failed to decompress image: %w", err)
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		spool.Close()
+		return err
+	}
+	r.file.Close()
+	r.file = spool
+	return nil
+}
+
+// indexTar records every entry of an uncompressed tar file with the offset of its content
+func indexTar(file *os.File) (map[string]dockerMember, error) {
+	members := map[string]dockerMember{}
+	tr := tar.NewReader(file)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return members, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+		offset, err := file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		members[path.Clean(header.Name)] = dockerMember{header: header, offset: offset}
+	}
+}
+
+// readManifest decodes manifest.json, nil when the tar has none or it lists no layers
+func (r *dockerEntryReader) readManifest(members map[string]dockerMember) ([]dockerImage, error) {
+	manifest, ok := members[dockerManifestName]
+	if !ok || manifest.header.Typeflag != tar.TypeReg || manifest.header.Size > maxDockerManifest {
+		return nil, nil
+	}
+	data := make([]byte, manifest.header.Size)
+	if _, err := r.file.ReadAt(data, manifest.offset); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", dockerManifestName, err)
+	}
+	var images []dockerImage
+	if json.Unmarshal(data, &images) != nil {
+		return nil, nil
+	}
+	for _, image := range images {
+		if len(image.Layers) > 0 {
+			return images, nil
+		}
+	}
+	return nil, nil
+}
+
+// dockerSteps orders the members to list: those that are not layers in name order, then the layers of every
+// image, a layer shared by several images once
+func dockerSteps(members map[string]dockerMember, images []dockerImage) ([]dockerMember, error) {
+	var layers []dockerMember
+	// isLayer holds the names of layers and of the links to them, listed holds the members actually read
+	isLayer, listed := map[string]bool{}, map[string]bool{}
+	for _, image := range images {
+		for _, name := range image.Layers {
+			name = path.Clean(name)
+			layer, resolved, err := resolveLayer(members, name)
+			if err != nil {
+				return nil, err
+			}
+			isLayer[name], isLayer[resolved] = true, true
+			if !listed[resolved] {
+				listed[resolved] = true
+				layers = append(layers, layer)
+			}
+		}
+	}
+
+	var steps []dockerMember
+	for name, member := range members {
+		if !isLayer[name] {
+			steps = append(steps, member)
+		}
+	}
+	sort.Slice(steps, func(i, j int) bool { return steps[i].header.Name < steps[j].header.Name })
+	width := len(fmt.Sprint(len(layers)))
+	for i, layer := range layers {
+		layer.layer = fmt.Sprintf("layer-%0*d/", width, i+1)
+		steps = append(steps, layer)
+	}
+	return steps, nil
+}
+
+// resolveLayer finds the member holding a layer, following the links docker writes for a layer saved twice
+func resolveLayer(members map[string]dockerMember, name string) (dockerMember, string, error) {
+	for range maxLayerLinks {
+		member, ok := members[name]
+		if !ok {
+			return dockerMember{}, "", fmt.Errorf("layer '%s' listed in %s is missing", name, dockerManifestName)
+		}
+		switch member.header.Typeflag {
+		case tar.TypeReg, tar.TypeRegA:
+			return member, name, nil
+		case tar.TypeSymlink:
+			name = path.Join(path.Dir(name), member.header.Linkname)
+		case tar.TypeLink:
+			name = path.Clean(member.header.Linkname)
+		default:
+			return dockerMember{}, "", fmt.Errorf("layer '%s' listed in %s is not a file", name, dockerManifestName)
+		}
+	}
+	return dockerMember{}, "", fmt.Errorf("layer '%s' listed in %s links too deep", name, dockerManifestName)
+}
+
+func (r *dockerEntryReader) Next() (*tar.Header, error) {
+	r.current = nil
+	for {
+		if r.nested != nil {
+			header, err := r.nested.Next()
+			if err == io.EOF {
+				r.nested.Close()
+				r.nested = nil
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			r.current = r.nested
+			return header, nil
+		}
+		if len(r.steps) == 0 {
+			return nil, io.EOF
+		}
+		step := r.steps[0]
+		r.steps = r.steps[1:]
+
+		content := io.NewSectionReader(r.file, step.offset, step.header.Size)
+		if step.layer == "" {
+			r.current = content
+			return step.header, nil
+		}
+		nested, err := newNestedTar(content, step.header.Name, step.layer, step.header.ModTime)
+		if err != nil {
+			return nil, err
+		}
+		r.nested = nested
+	}
+}
+
+func (r *dockerEntryReader) Read(p []byte) (int, error) {
+	if r.current == nil {
+		return 0, io.EOF
+	}
+	return r.current.Read(p)
+}
+
+func (r *dockerEntryReader) Close() error {
+	if r.nested != nil {
+		r.nested.Close()
+	}
+	err := r.file.Close()
+	if r.workspace != nil {
+		r.workspace.Cleanup()
+	}
+	return err
+}
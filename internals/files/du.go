@@ -0,0 +1,187 @@
+package files
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/output"
+	"gsn-dev-tools/internals/pathfmt"
+	"gsn-dev-tools/internals/redact"
+	"gsn-dev-tools/internals/remote"
+	"gsn-dev-tools/internals/units"
+
+	"github.com/spf13/cobra"
+)
+
+// duEntry is the aggregated disk usage of one immediate child of the scanned directory
+type duEntry struct {
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+	Files int    `json:"files"`
+}
+
+// duColumns declares the columns available to `du`
+var duColumns = []output.Column[duEntry]{
+	{Name: "path", Value: func(e duEntry) any { return e.Path }},
+	{
+		Name:    "size",
+		Value:   func(e duEntry) any { return e.Size },
+		Display: func(e duEntry) string { return units.FormatBytes(e.Size) },
+	},
+	{Name: "files", Value: func(e duEntry) any { return e.Files }},
+}
+
+func DiskUsageCmd() *cobra.Command {
+	duCmd := cobra.Command{
+		Use:   "du <directory>",
+		Short: "Shows the disk usage of every entry in a directory",
+		Long: `Walks a directory in parallel and prints the total size and file count of each immediate child. --max-depth
+only counts the files that many levels below the directory, the same way cmp --max-depth archives them. With
+--remote the directory is one of the remote host, listed over SFTP.
+
+Paths are shown relative to the directory, or to the one given with --relative-to: ~ abbreviates the home
+directory instead and / keeps them absolute. --json writes absolute paths unless --relative-json is given.`,
+		Example: `  gsn du ~/Downloads
+  gsn du . --sort files:desc
+  gsn du ~/code --max-depth 2
+  gsn du ~/code --relative-to '~'
+  gsn du ~/code --json
+  gsn --remote deploy@web1 du /var/log`,
+		Args: cobra.ExactArgs(1),
+		Run:  DiskUsage,
+	}
+
+	addDepthFlags(&duCmd)
+	output.AddFlags(&duCmd)
+	pathfmt.AddFlags(&duCmd)
+	duCmd.Flags().Bool("json", false, "Print the entries and the totals as JSON")
+	dryrun.ReadOnly(&duCmd)
+	remote.Adopt(&duCmd)
+	return &duCmd
+}
+
+func DiskUsage(cmd *cobra.Command, args []string) {
+	root := args[0]
+	asJSON, _ := cmd.Flags().GetBool("json")
+
+	opts, err := output.OptionsFromFlags(cmd)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	if asJSON && opts.Format != output.FormatTable {
+		clierr.Exitf(clierr.Usage, "--json cannot be combined with --csv or --tsv")
+	}
+	if opts.SortBy == "" {
+		opts.SortBy, opts.Desc = "size", true
+	}
+	display, err := pathfmt.FromFlags(cmd, root)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+
+	depth, err := depthFromFlags(cmd)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+
+	var usage []duEntry
+	if remote.Enabled() {
+		if _, usage, err = remoteDiskUsage(root, depth); err != nil {
+			clierr.Fatalf("%v", err)
+		}
+	} else {
+		entries, err := walkParallel(root, defaultWalkWorkers, depth)
+		if err != nil {
+			clierr.Fatalf("Error walking '%s': %v", root, err)
+		}
+		usage = aggregateBySubdir(root, entries)
+	}
+	var total duEntry
+	for _, e := range usage {
+		total.Size += e.Size
+		total.Files += e.Files
+	}
+
+	if asJSON {
+		if err := output.Sort(duColumns, usage, opts); err != nil {
+			clierr.Fatalf("%v", err)
+		}
+		for i := range usage {
+			usage[i].Path = display.JSONPath(usage[i].Path)
+		}
+		data, err := json.MarshalIndent(struct {
+			Entries []duEntry `json:"entries"`
+			Size    int64     `json:"size"`
+			Files   int       `json:"files"`
+		}{usage, total.Size, total.Files}, "", "  ")
+		if err != nil {
+			clierr.Fatalf("%v", err)
+		}
+		redact.JSONOutput()
+		fmt.Println(string(data))
+		return
+	}
+	for i := range usage {
+		usage[i].Path = display.Path(usage[i].Path)
+	}
+	if err := output.Render(os.Stdout, duColumns, usage, opts); err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	if opts.Format == output.FormatTable {
+		fmt.Printf("\nTotal: %s in %s file(s)\n", units.FormatBytes(total.Size), units.FormatInt(int64(total.Files)))
+	}
+}
+
+// aggregateBySubdir sums file sizes per immediate child of root, returning entries ordered by path
+func aggregateBySubdir(root string, entries []walkEntry) []duEntry {
+	usage := newSubdirUsage(root)
+	for _, entry := range entries {
+		usage.add(entry.Path, entry.Info)
+	}
+	return usage.entries()
+}
+
+// subdirUsage sums file sizes per immediate child of root as entries come in, so a walk need not keep them
+type subdirUsage struct {
+	root   string
+	totals map[string]*duEntry
+}
+
+func newSubdirUsage(root string) *subdirUsage {
+	return &subdirUsage{root: root, totals: make(map[string]*duEntry)}
+}
+
+func (u *subdirUsage) add(path string, info os.FileInfo) {
+	rel, err := filepath.Rel(u.root, path)
+	if err != nil || rel == "." {
+		return
+	}
+
+	top, _, _ := strings.Cut(rel, string(filepath.Separator))
+	key := filepath.Join(u.root, top)
+	agg, ok := u.totals[key]
+	if !ok {
+		agg = &duEntry{Path: key}
+		u.totals[key] = agg
+	}
+	if !info.IsDir() {
+		agg.Size += info.Size()
+		agg.Files++
+	}
+}
+
+// entries returns the totals ordered by path
+func (u *subdirUsage) entries() []duEntry {
+	result := make([]duEntry, 0, len(u.totals))
+	for _, agg := range u.totals {
+		result = append(result, *agg)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result
+}
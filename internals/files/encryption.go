@@ -0,0 +1,322 @@
+package files
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/config"
+	"gsn-dev-tools/internals/secrets"
+	"gsn-dev-tools/internals/style"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+	"filippo.io/age/armor"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// encryptedExt is appended to the name of an archive encrypted to recipients
+	encryptedExt = ".age"
+	ageMagic     = "age-encryption.org/v1"
+)
+
+// githubKeysURL lists the SSH public keys of a GitHub user, %s is the user name
+var githubKeysURL = "https://github.com/%s.keys"
+
+// identityFiles are the --identity files of the running command, the default locations are tried without them
+var identityFiles []string
+
+// loadedIdentities keeps the identities once read, archives opened twice, like before extracting to size the
+// entries, ask for a passphrase only once
+var loadedIdentities []age.Identity
+
+// addRecipientFlags registers the flags naming who can decrypt the archive
+func addRecipientFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayP("recipient", "r", nil, "Encrypt the archive to this age or SSH public key (repeatable)")
+	cmd.Flags().StringArray("recipients-file", nil, "Encrypt the archive to the public keys listed in this file, one per line (repeatable)")
+	cmd.Flags().StringArray("recipient-github", nil, "Encrypt the archive to the SSH keys of this GitHub user (repeatable)")
+}
+
+// addIdentityFlag registers --identity on the command and its subcommands, bound to identityFiles so any archive
+// they open can use it
+func addIdentityFlag(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringArrayVar(&identityFiles, "identity", nil, "An age identity file or SSH private key to decrypt encrypted archives with (repeatable)")
+}
+
+// recipientsFromFlags parses the recipients of addRecipientFlags, none means the archive is not encrypted
+func recipientsFromFlags(ctx context.Context, cmd *cobra.Command) ([]age.Recipient, error) {
+	keys, _ := cmd.Flags().GetStringArray("recipient")
+	files, _ := cmd.Flags().GetStringArray("recipients-file")
+	users, _ := cmd.Flags().GetStringArray("recipient-github")
+
+	var recipients []age.Recipient
+	for _, key := range keys {
+		r, err := parseRecipient(key)
+		if err != nil {
+			return nil, clierr.Newf(clierr.Usage, "invalid --recipient '%s': %v", key, err)
+		}
+		recipients = append(recipients, r)
+	}
+	for _, path := range files {
+		parsed, err := readRecipientsFile(path)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, parsed...)
+	}
+	for _, user := range users {
+		parsed, err := githubRecipients(ctx, user)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, parsed...)
+	}
+	return recipients, nil
+}
+
+// parseRecipient reads an age X25519 public key or an ssh-ed25519 or ssh-rsa public key
+func parseRecipient(key string) (age.Recipient, error) {
+	if strings.HasPrefix(key, "age1") {
+		return age.ParseX25519Recipient(key)
+	}
+	return agessh.ParseRecipient(key)
+}
+
+// readRecipientsFile reads the public keys listed in a file, a file listing none is an error
+func readRecipientsFile(path string) ([]age.Recipient, error) {
+	data, err := os.ReadFile(config.ExpandHome(path))
+	if err != nil {
+		return nil, err
+	}
+	recipients, err := parseRecipientList(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("%s lists no recipients", path)
+	}
+	return recipients, nil
+}
+
+// parseRecipientList reads one public key per line, skipping blank lines and # comments
+func parseRecipientList(data []byte) ([]age.Recipient, error) {
+	var recipients []age.Recipient
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r, err := parseRecipient(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		recipients = append(recipients, r)
+	}
+	return recipients, nil
+}
+
+// githubRecipients fetches the SSH public keys of a GitHub user, keys age cannot encrypt to, like ecdsa or
+// security keys, are skipped with a warning
+func githubRecipients(ctx context.Context, user string) ([]age.Recipient, error) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf(githubKeysURL, user), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the keys of GitHub user '%s': %w", user, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, clierr.Newf(clierr.NotFound, "no GitHub user '%s'", user)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch the keys of GitHub user '%s': %s", user, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the keys of GitHub user '%s': %w", user, err)
+	}
+
+	var recipients []age.Recipient
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		r, err := agessh.ParseRecipient(line)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, style.Warning()+"Skipping the %s key of GitHub user '%s': %v\n", strings.Fields(line)[0], user, err)
+			continue
+		}
+		recipients = append(recipients, r)
+	}
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("GitHub user '%s' has no ssh-ed25519 or ssh-rsa key to encrypt to", user)
+	}
+	return recipients, nil
+}
+
+// isEncrypted reports whether the stream starts with an age header, binary or armored
+func isEncrypted(br *bufio.Reader) bool {
+	head, _ := br.Peek(len(armor.Header))
+	return bytes.HasPrefix(head, []byte(ageMagic)) || bytes.Equal(head, []byte(armor.Header))
+}
+
+// decryptArchive decrypts an age stream with the --identity files, or the default identities without them
+func decryptArchive(br *bufio.Reader, archivePath string) (io.Reader, error) {
+	identities, err := loadArchiveIdentities()
+	if err != nil {
+		return nil, err
+	}
+	if len(identities) == 0 {
+		return nil, clierr.Newf(clierr.Usage, "'%s' is encrypted and no identity was found, give yours with --identity", archivePath)
+	}
+
+	var src io.Reader = br
+	if head, _ := br.Peek(len(armor.Header)); bytes.Equal(head, []byte(armor.Header)) {
+		src = armor.NewReader(br)
+	}
+	r, err := age.Decrypt(src, identities...)
+	if errors.As(err, new(*age.NoIdentityMatchError)) {
+		return nil, fmt.Errorf("'%s' is not encrypted to any of your identities, give the right one with --identity", archivePath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt '%s': %w", archivePath, err)
+	}
+	return r, nil
+}
+
+// decryptable reports whether one of the identities decryptArchive uses decrypts the archive at archivePath,
+// reading no more than its header
+func decryptable(archivePath string) (bool, error) {
+	identities, err := loadArchiveIdentities()
+	if err != nil || len(identities) == 0 {
+		return false, err
+	}
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	br := bufio.NewReader(file)
+	var src io.Reader = br
+	if head, _ := br.Peek(len(armor.Header)); bytes.Equal(head, []byte(armor.Header)) {
+		src = armor.NewReader(br)
+	}
+	_, err = age.Decrypt(src, identities...)
+	if errors.As(err, new(*age.NoIdentityMatchError)) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to decrypt '%s': %w", archivePath, err)
+	}
+	return true, nil
+}
+
+// loadArchiveIdentities reads the --identity files; without them $GSN_AGE_IDENTITY, the age keys.txt of the
+// user config directory and the default SSH keys are used when they exist
+func loadArchiveIdentities() ([]age.Identity, error) {
+	if loadedIdentities != nil {
+		return loadedIdentities, nil
+	}
+	if len(identityFiles) > 0 {
+		var identities []age.Identity
+		for _, path := range identityFiles {
+			parsed, err := readIdentityFile(config.ExpandHome(path))
+			if err != nil {
+				return nil, err
+			}
+			identities = append(identities, parsed...)
+		}
+		loadedIdentities = identities
+		return identities, nil
+	}
+
+	var candidates []string
+	if path := os.Getenv("GSN_AGE_IDENTITY"); path != "" {
+		candidates = append(candidates, config.ExpandHome(path))
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		candidates = append(candidates, filepath.Join(dir, "age", "keys.txt"))
+	}
+	candidates = append(candidates, config.ExpandHome("~/.ssh/id_ed25519"), config.ExpandHome("~/.ssh/id_rsa"))
+
+	var identities []age.Identity
+	for _, path := range candidates {
+		if !isRegularFile(path) {
+			continue
+		}
+		parsed, err := readIdentityFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, style.Warning()+"Skipping identity %v\n", err)
+			continue
+		}
+		identities = append(identities, parsed...)
+	}
+	loadedIdentities = identities
+	return identities, nil
+}
+
+// readIdentityFile reads an age identity file or an SSH private key. An encrypted SSH key asks for its
+// passphrase only when the archive is encrypted to it.
+func readIdentityFile(path string) ([]age.Identity, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Contains(data, []byte("PRIVATE KEY-----")) {
+		identities, err := age.ParseIdentities(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("'%s': %w", path, err)
+		}
+		return identities, nil
+	}
+
+	identity, err := agessh.ParseIdentity(data)
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		if err != nil {
+			return nil, fmt.Errorf("'%s': %w", path, err)
+		}
+		return []age.Identity{identity}, nil
+	}
+
+	pub := missing.PublicKey
+	if pub == nil {
+		pubData, err := os.ReadFile(path + ".pub")
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is encrypted and its public key %s.pub cannot be read: %w", path, path, err)
+		}
+		if pub, _, _, _, err = ssh.ParseAuthorizedKey(pubData); err != nil {
+			return nil, fmt.Errorf("'%s.pub': %w", path, err)
+		}
+	}
+	encrypted, err := agessh.NewEncryptedSSHIdentity(pub, data, func() ([]byte, error) {
+		passphrase, err := secrets.ReadPassphrase("GSN_IDENTITY_PASSPHRASE", fmt.Sprintf("Passphrase for %s: ", path), false)
+		return []byte(passphrase), err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("'%s': %w", path, err)
+	}
+	return []age.Identity{encrypted}, nil
+}
+
+func isRegularFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
@@ -0,0 +1,143 @@
+package files
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gsn-dev-tools/internals/clierr"
+)
+
+// ExportOptions configures ExportTarStream
+type ExportOptions struct {
+	// Format names the archive format like gsn cmp --format, the extension of the target picks it when empty
+	Format string
+
+	// Prefix is the directory every entry is stored below, like the source directory of a cmp archive
+	Prefix string
+
+	// Exclude leaves out the entries matching these globs like gsn cmp --exclude, matched against the names in
+	// the stream
+	Exclude []string
+}
+
+// ExportResult describes the archive written by ExportTarStream
+type ExportResult struct {
+	ArchivePath string `json:"archive_path"`
+	ArchiveSize int64  `json:"archive_size"`
+	Entries     int    `json:"entries"`
+	Excluded    int    `json:"excluded"`
+}
+
+// ExportTarStream writes the entries of a tar stream, such as the output of git archive, into a new archive of
+// any format cmp writes. The archive is written to a temp file renamed into place once the stream is complete,
+// so a failed export leaves nothing behind.
+func ExportTarStream(r io.Reader, targetPath string, opts ExportOptions) (*ExportResult, error) {
+	format, err := exportFormat(targetPath, opts.Format)
+	if err != nil {
+		return nil, err
+	}
+	filter := archiveFilter{Excludes: opts.Exclude}
+	for _, pattern := range filter.Excludes {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, clierr.Newf(clierr.Usage, "invalid pattern '%s': %v", pattern, err)
+		}
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(targetPath), ".gsn-export-*")
+	if err != nil {
+		return nil, fmt.Errorf("error creating temp file: %w", err)
+	}
+	tmpName := tmpFile.Name()
+	defer os.Remove(tmpName) // no-op once renamed
+	defer tmpFile.Close()
+
+	dst, err := createArchive(tmpFile, format, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ExportResult{ArchivePath: targetPath}
+	src := tar.NewReader(r)
+	for {
+		header, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read entry: %w", err)
+		}
+		// The global header of git archive only records the commit, zip has no room for it
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		if filter.skipsBelow(".", filepath.FromSlash(path.Clean(header.Name))) {
+			result.Excluded++
+			continue
+		}
+		if opts.Prefix != "" {
+			header.Name = path.Join(opts.Prefix, header.Name) + trailingSlash(header.Name)
+			if header.Typeflag == tar.TypeLink {
+				header.Linkname = path.Join(opts.Prefix, header.Linkname)
+			}
+		}
+
+		if err := dst.WriteHeader(header); err != nil {
+			return nil, fmt.Errorf("failed to write header for '%s': %w", header.Name, err)
+		}
+		if _, err := io.Copy(dst, src); err != nil {
+			return nil, fmt.Errorf("failed to copy '%s': %w", header.Name, err)
+		}
+		result.Entries++
+	}
+
+	if err := dst.Close(); err != nil {
+		return nil, fmt.Errorf("error finalizing archive: %w", err)
+	}
+	info, err := tmpFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := tmpFile.Chmod(newFileMode()); err != nil {
+		return nil, err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmpName, targetPath); err != nil {
+		return nil, fmt.Errorf("error moving archive into place: %w", err)
+	}
+	result.ArchiveSize = info.Size()
+	return result, nil
+}
+
+// exportFormat picks the format of an export from its name, or from the extension of the target
+func exportFormat(targetPath string, name string) (archiveFormat, error) {
+	if name == "" {
+		format, ok := formatOfName(targetPath)
+		if !ok {
+			return format, fmt.Errorf("cannot tell the archive format of '%s', name it .tar.gz, .tar, .tar.zst or .zip or use --format", targetPath)
+		}
+		name = format.String()
+	}
+	format, err := parseFormat(name)
+	if err != nil {
+		return format, err
+	}
+	if format.Container == containerNone {
+		return format, clierr.Newf(clierr.Usage, "--format %s holds a single file, export to tar%s instead", format, format.Extension())
+	}
+	return format, nil
+}
+
+// trailingSlash keeps the slash ending the names of directory entries
+func trailingSlash(name string) string {
+	if strings.HasSuffix(name, "/") {
+		return "/"
+	}
+	return ""
+}
@@ -0,0 +1,585 @@
+package files
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gsn-dev-tools/internals/backups"
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/hooks"
+	"gsn-dev-tools/internals/notify"
+	"gsn-dev-tools/internals/style"
+	"gsn-dev-tools/internals/tmpfs"
+	"gsn-dev-tools/internals/tui"
+	"gsn-dev-tools/internals/units"
+
+	"github.com/spf13/cobra"
+)
+
+// errEntryNotFound is returned when no archive entry matches the requested pattern
+var errEntryNotFound = errors.New("entry not found")
+
+func ExtractionCmd() *cobra.Command {
+	extractCmd := cobra.Command{
+		Use:   "extract <archive>",
+		Short: "Extracts an archive, or a single entry from it",
+		Long: `Extracts every entry of an archive into a destination directory, or with --file only the entries matching an
+exact path or glob. Restored entries get the modes stored in the archive masked by the umask, or the modes given
+with --chmod. With --restore-names the .gsn-rename-journal.json files of directories renamed by gsn rename before
+they were archived are replayed in reverse, so the extracted tree carries the original names again. With --backup
+the files overwritten are copied into the gsn backup store first, see gsn backups.
+
+Before writing, extract adds up the sizes of the entries to restore, from the sidecar manifest when the archive
+has one and from the archive headers otherwise, and refuses to start when the destination filesystem has less
+free space; --no-space-check skips this, and the extra read of archives without a manifest.
+
+An archive encrypted with gsn cmp --recipient is decrypted with the --identity files, age identities or SSH
+private keys, or without them with those of $GSN_AGE_IDENTITY, age/keys.txt in the user config directory,
+~/.ssh/id_ed25519 and ~/.ssh/id_rsa that exist. An encrypted SSH key the archive was encrypted to asks for its
+passphrase, or takes it from $GSN_IDENTITY_PASSPHRASE.
+
+Packages and images are read like archives, with the same checks on entry names. A .deb holds debian-binary and
+the entries of its control and data tarballs below control/ and data/, an .rpm the files of its cpio payload, and
+a docker save tarball its manifest and config files followed by the entries of every layer below layer-1/,
+layer-2/, ... in the order of manifest.json, the base layer first. Members compressed with xz or lzma cannot be
+read.`,
+		Example: `  gsn extract project.tar.gz
+  gsn extract project.tar.gz -f project/README.md --stdout
+  gsn extract project.tar.gz -f '*.go' --all -o ./src --on-conflict skip
+  gsn extract vendor.tar.gz --chmod files=644,dirs=755
+  gsn extract photos.tar.gz -o ./handback --restore-names
+  gsn extract config.tar.gz -o ~/.config --backup --keep 3
+  gsn extract secrets.tar.gz.age --identity ~/.config/age/keys.txt
+  gsn extract hello_1.0_amd64.deb -f 'data/usr/bin/*' --all -o ./pkg
+  gsn extract image.tar -f layer-3/etc/os-release --stdout
+  gsn extract --pick`,
+		Args: tui.Args(cobra.ExactArgs(1)),
+		Run:  ExtractArchive,
+	}
+
+	extractCmd.Flags().StringP("file", "f", "", "Extract only the entry matching this path or glob")
+	extractCmd.Flags().Bool("stdout", false, "Write the matched entry to stdout")
+	extractCmd.Flags().StringP("output", "o", "", "Output file for a single entry, or destination directory")
+	extractCmd.Flags().Bool("all", false, "Extract every entry matching the --file glob")
+	extractCmd.Flags().String("on-conflict", string(conflictOverwrite), "What to do with existing files: overwrite, skip, backup or prompt")
+	extractCmd.Flags().Bool("restore-names", false, "Undo the renames recorded in extracted gsn rename journals, restoring the original names")
+	extractCmd.Flags().Bool("keep-journal", false, "Keep the rename journals replayed by --restore-names")
+	extractCmd.Flags().Bool("no-space-check", false, "Start even when the destination filesystem may not have room for the entries")
+	addIdentityFlag(&extractCmd)
+	backups.AddFlags(&extractCmd)
+	addPermissionFlags(&extractCmd)
+	notify.AddFlag(&extractCmd)
+	tui.AddFlag(&extractCmd, "Pick the archive from the current directory in a searchable list")
+
+	return &extractCmd
+}
+
+func ExtractArchive(cmd *cobra.Command, args []string) {
+	archivePath, err := pathArg(cmd, args, "Extract", archivesInCwd)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	pattern, _ := cmd.Flags().GetString("file")
+	toStdout, _ := cmd.Flags().GetBool("stdout")
+	output, _ := cmd.Flags().GetString("output")
+	all, _ := cmd.Flags().GetBool("all")
+	onConflict, _ := cmd.Flags().GetString("on-conflict")
+	restoreNames, _ := cmd.Flags().GetBool("restore-names")
+	keepJournal, _ := cmd.Flags().GetBool("keep-journal")
+	noSpaceCheck, _ := cmd.Flags().GetBool("no-space-check")
+	startTime := time.Now()
+
+	if restoreNames && pattern != "" {
+		clierr.Exitf(clierr.Usage, "--restore-names needs the whole archive, it cannot be combined with --file")
+	}
+
+	policy, err := parseConflictPolicy(onConflict)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	perms, err := permissionPolicyFromFlags(cmd)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	store, keep, err := backups.FromFlags(cmd)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+
+	destDir := output
+	if destDir == "" || (pattern != "" && !all) {
+		destDir = "."
+	}
+	if !noSpaceCheck && !toStdout {
+		spaceDir := destDir
+		if pattern != "" && !all && output != "" {
+			spaceDir = filepath.Dir(output)
+		}
+		required, err := extractionSize(archivePath, pattern)
+		if err == nil {
+			err = checkFreeSpace(spaceDir, required, "the extracted entries")
+		}
+		if err != nil {
+			clierr.Fatalf("%v", err)
+		}
+	}
+
+	conflicts := newConflictResolver(policy, destDir)
+	conflicts.backups, conflicts.keep = store, keep
+
+	var count int
+	var journals []string
+	if pattern == "" {
+		count, err = extractAll(archivePath, destDir, conflicts, perms, func(target string) {
+			if filepath.Base(target) == renameJournalName {
+				journals = append(journals, target)
+			}
+		})
+	} else {
+		count, err = extractEntries(archivePath, pattern, output, toStdout, all, conflicts, perms)
+	}
+	if ferr := perms.finish(); ferr != nil && err == nil {
+		err = fmt.Errorf("failed to set directory modes: %w", ferr)
+	}
+
+	restoredNames := 0
+	if restoreNames && err == nil {
+		restoredNames, err = restoreJournaledNames(journals, conflicts, keepJournal)
+	}
+
+	if werr := conflicts.recordWritten(); werr != nil {
+		fmt.Fprintf(os.Stderr, style.Warning()+"Failed to update the backup store: %v\n", werr)
+	}
+	if len(conflicts.journal.Entries) > 0 {
+		journalPath := filepath.Join(destDir, extractJournalName)
+		if jerr := conflicts.journal.Save(journalPath); jerr != nil {
+			fmt.Fprintf(os.Stderr, style.Warning()+"Failed to write journal: %v\n", jerr)
+		} else {
+			fmt.Printf("Backups recorded in %s\n", journalPath)
+		}
+	}
+
+	ev := notify.NewEvent("extract", startTime, err)
+	ev.ArchivePath = archivePath
+	ev.Counts = map[string]int{"entries": count}
+	notify.Finish(cmd, ev)
+	hooks.Post(ev)
+
+	var notFound *entryNotFoundError
+	if errors.As(err, &notFound) {
+		fmt.Fprintf(os.Stderr, style.Error()+"%v\n", err)
+		if len(notFound.Suggestions) > 0 {
+			fmt.Fprintln(os.Stderr, "Did you mean:")
+			for _, name := range notFound.Suggestions {
+				fmt.Fprintf(os.Stderr, "  %s\n", name)
+			}
+		}
+		clierr.Exit(clierr.NotFound)
+	}
+	if err != nil {
+		clierr.Fatalf("Extraction failed: %v", err)
+	}
+
+	if !toStdout {
+		fmt.Printf(style.Success()+"Extracted %s entr%s from %s (Time: %s)\n", units.FormatInt(int64(count)), pluralY(count), archivePath, units.FormatDuration(time.Since(startTime)))
+		if restoreNames {
+			fmt.Printf("Restored %d original name(s) from %d rename journal(s)\n", restoredNames, len(journals))
+		}
+		if summary := conflicts.summary(); summary != "" {
+			fmt.Println(summary)
+		}
+	}
+}
+
+// entryNotFoundError reports a missing entry together with the closest entry names
+type entryNotFoundError struct {
+	Pattern     string
+	Suggestions []string
+}
+
+func (e *entryNotFoundError) Error() string {
+	return fmt.Sprintf("no entry matching '%s' in archive", e.Pattern)
+}
+
+func (e *entryNotFoundError) Unwrap() error {
+	return errEntryNotFound
+}
+
+func (e *entryNotFoundError) ExitCode() clierr.Code {
+	return clierr.NotFound
+}
+
+// extractAll restores every entry of the archive below destDir, calling restored, when given, with the
+// target of every restored entry
+func extractAll(archivePath string, destDir string, conflicts *conflictResolver, perms *permissionPolicy, restored func(target string)) (int, error) {
+	tr, err := openArchive(archivePath)
+	if err != nil {
+		return 0, err
+	}
+	defer tr.Close()
+
+	count := 0
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		target, err := safeJoin(destDir, header.Name)
+		if err == nil {
+			header, err = resolveLink(destDir, target, header)
+		}
+		if err != nil {
+			return count, err
+		}
+		if err := restoreEntry(tr, header, target, conflicts, perms); err != nil {
+			return count, err
+		}
+		if restored != nil {
+			restored(target)
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// resolveLink points a hard link entry at the path its first name was restored to below destDir, and refuses
+// a symlink entry leading outside destDir
+func resolveLink(destDir string, target string, header *tar.Header) (*tar.Header, error) {
+	if header.Typeflag != tar.TypeLink {
+		return header, checkSymlink(destDir, target, header)
+	}
+	linkTarget, err := safeJoin(destDir, header.Linkname)
+	if err != nil {
+		return nil, err
+	}
+	resolved := *header
+	resolved.Linkname = linkTarget
+	return &resolved, nil
+}
+
+// extractEntries writes the entries matching pattern to stdout, an output file or a destination directory.
+// Exact paths stop reading the archive as soon as the entry has been written.
+func extractEntries(archivePath string, pattern string, output string, toStdout bool, all bool, conflicts *conflictResolver, perms *permissionPolicy) (int, error) {
+	isGlob := strings.ContainsAny(pattern, "*?[")
+	if all && !isGlob {
+		return 0, clierr.Newf(clierr.Usage, "--all requires a glob pattern")
+	}
+	if all && toStdout {
+		return 0, clierr.Newf(clierr.Usage, "--all cannot be combined with --stdout")
+	}
+
+	tr, err := openArchive(archivePath)
+	if err != nil {
+		return 0, err
+	}
+	defer tr.Close()
+
+	suggestions := newNameSuggestions(pattern, 3)
+	var spool *os.File
+	var spoolHeader *tar.Header
+	count := 0
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		name := strings.TrimSuffix(path.Clean(header.Name), "/")
+		suggestions.add(name)
+		if !matchEntry(pattern, name) {
+			continue
+		}
+
+		switch {
+		case all:
+			destDir := output
+			if destDir == "" {
+				destDir = "."
+			}
+			target, err := safeJoin(destDir, header.Name)
+			if err == nil {
+				header, err = resolveLink(destDir, target, header)
+			}
+			if err != nil {
+				return count, err
+			}
+			if err := restoreEntry(tr, header, target, conflicts, perms); err != nil {
+				return count, err
+			}
+			count++
+		case !isGlob:
+			// Exact match: write it and stop reading the rest of the stream
+			return 1, writeEntry(tr, header, output, toStdout, conflicts, perms)
+		case spool == nil:
+			// Glob match: keep the entry aside until we know it is the only match
+			workspace, err := tmpfs.New("extract")
+			if err != nil {
+				return count, err
+			}
+			defer workspace.Cleanup()
+			if spool, err = workspace.CreateFile("entry-*"); err != nil {
+				return count, err
+			}
+			defer spool.Close()
+			if _, err := io.Copy(spool, tr); err != nil {
+				return count, err
+			}
+			spoolHeader = header
+		default:
+			return count, fmt.Errorf("pattern '%s' matches several entries (%s, %s, ...); use --all to extract each", pattern, spoolHeader.Name, header.Name)
+		}
+	}
+
+	if spool != nil {
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return count, err
+		}
+		return 1, writeEntry(spool, spoolHeader, output, toStdout, conflicts, perms)
+	}
+
+	if count == 0 {
+		return 0, &entryNotFoundError{Pattern: pattern, Suggestions: suggestions.names}
+	}
+	return count, nil
+}
+
+// matchEntry reports whether an archive entry name matches an exact path or glob pattern
+func matchEntry(pattern string, name string) bool {
+	pattern = strings.TrimSuffix(strings.TrimPrefix(pattern, "./"), "/")
+	if pattern == name {
+		return true
+	}
+	matched, err := path.Match(pattern, name)
+	return err == nil && matched
+}
+
+// writeEntry copies a single entry's content to stdout or to an output file
+func writeEntry(r io.Reader, header *tar.Header, output string, toStdout bool, conflicts *conflictResolver, perms *permissionPolicy) error {
+	if header.Typeflag == tar.TypeLink {
+		return fmt.Errorf("'%s' is a hard link to '%s', extract that entry instead", header.Name, header.Linkname)
+	}
+	if toStdout {
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			return fmt.Errorf("'%s' is not a regular file, --stdout only writes file contents", header.Name)
+		}
+		_, err := io.Copy(os.Stdout, r)
+		return err
+	}
+
+	if output == "" {
+		output = path.Base(header.Name)
+	}
+	if err := checkSymlink(filepath.Dir(output), output, header); err != nil {
+		return err
+	}
+	return restoreEntry(r, header, output, conflicts, perms)
+}
+
+// restoreEntry recreates a tar entry on disk at target, resolving conflicts with existing files first, and
+// hands it to perms for its mode and owner
+func restoreEntry(r io.Reader, header *tar.Header, target string, conflicts *conflictResolver, perms *permissionPolicy) error {
+	if header.Typeflag != tar.TypeDir {
+		write, err := conflicts.resolve(target)
+		if err != nil || !write {
+			return err
+		}
+	}
+
+	var err error
+	switch header.Typeflag {
+	case tar.TypeDir:
+		err = os.MkdirAll(target, 0o755)
+	case tar.TypeSymlink:
+		if err = os.MkdirAll(filepath.Dir(target), 0o755); err == nil {
+			err = os.Symlink(header.Linkname, target)
+		}
+	case tar.TypeLink:
+		if err = os.MkdirAll(filepath.Dir(target), 0o755); err == nil {
+			err = os.Link(header.Linkname, target)
+		}
+	case tar.TypeReg, tar.TypeRegA:
+		if err = os.MkdirAll(filepath.Dir(target), 0o755); err == nil {
+			err = writeRegular(r, header, target)
+		}
+	default:
+		fmt.Printf("Warning: Skipping unsupported entry '%s'\n", header.Name)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return perms.apply(target, header)
+}
+
+// writeRegular writes the content of a regular file entry, restoring the holes of sparse entries
+func writeRegular(r io.Reader, header *tar.Header, target string) error {
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if isSparseHeader(header) {
+		err = copySparse(file, r)
+	} else {
+		_, err = io.Copy(file, r)
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// safeJoin joins an entry name to destDir, refusing names that escape it, by their text or through a symlink
+// on disk below destDir, such as one an earlier entry of the archive created
+func safeJoin(destDir string, name string) (string, error) {
+	target := filepath.Join(destDir, filepath.FromSlash(name))
+	rel, err := filepath.Rel(destDir, target)
+	if err != nil || !isLocal(rel) {
+		return "", fmt.Errorf("entry '%s' escapes the destination directory", name)
+	}
+
+	parent := destDir
+	parts := strings.Split(rel, string(filepath.Separator))
+	for _, part := range parts[:len(parts)-1] {
+		parent = filepath.Join(parent, part)
+		info, err := os.Lstat(parent)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("entry '%s' would be written through the symlink '%s'", name, parent)
+		}
+	}
+	return target, nil
+}
+
+// checkSymlink refuses a symlink entry restored at target whose link is absolute or leads outside destDir
+func checkSymlink(destDir string, target string, header *tar.Header) error {
+	if header.Typeflag != tar.TypeSymlink {
+		return nil
+	}
+	escapes := fmt.Errorf("symlink '%s' points to '%s', outside the destination directory", header.Name, header.Linkname)
+	link := filepath.FromSlash(header.Linkname)
+	if filepath.IsAbs(link) || strings.HasPrefix(header.Linkname, "/") {
+		return escapes
+	}
+	if rel, err := filepath.Rel(destDir, filepath.Join(filepath.Dir(target), link)); err != nil || !isLocal(rel) {
+		return escapes
+	}
+	// Symlinks on the way are followed before .. applies, resolve what exists already to see where it leads
+	root, err := filepath.EvalSymlinks(destDir)
+	if err != nil {
+		return nil
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Dir(target) + string(filepath.Separator) + link)
+	if err != nil {
+		return nil
+	}
+	if rel, err := filepath.Rel(root, resolved); err != nil || !isLocal(rel) {
+		return escapes
+	}
+	return nil
+}
+
+// isLocal reports whether a path relative to a directory stays inside it
+func isLocal(rel string) bool {
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// nameSuggestions keeps the names closest to a pattern among those added, up to limit of them, so that
+// suggesting names for a missing entry holds a few names rather than every name of the archive
+type nameSuggestions struct {
+	pattern   string
+	limit     int
+	names     []string
+	distances []int
+}
+
+func newNameSuggestions(pattern string, limit int) *nameSuggestions {
+	return &nameSuggestions{pattern: pattern, limit: limit}
+}
+
+// add keeps name when it is closer to the pattern than the farthest name kept, on a tie the name added first
+// stays ahead
+func (s *nameSuggestions) add(name string) {
+	if s.limit == 0 || slices.Contains(s.names, name) {
+		return
+	}
+	full := len(s.names) == s.limit
+	// The edit distance is at least the difference of the lengths
+	lengths := utf8.RuneCountInString(name) - utf8.RuneCountInString(s.pattern)
+	if full && max(lengths, -lengths) >= s.distances[s.limit-1] {
+		return
+	}
+	d := levenshtein(s.pattern, name)
+	i := sort.Search(len(s.distances), func(i int) bool { return s.distances[i] > d })
+	if i == s.limit {
+		return
+	}
+	s.names = slices.Insert(s.names, i, name)
+	s.distances = slices.Insert(s.distances, i, d)
+	if full {
+		s.names, s.distances = s.names[:s.limit], s.distances[:s.limit]
+	}
+}
+
+// closestNames returns up to limit names ordered by edit distance to pattern
+func closestNames(pattern string, names []string, limit int) []string {
+	s := newNameSuggestions(pattern, limit)
+	for _, name := range names {
+		s.add(name)
+	}
+	return s.names
+}
+
+// levenshtein computes the edit distance between two strings
+func levenshtein(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr := make([]int, len(rb)+1)
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev = curr
+	}
+
+	return prev[len(rb)]
+}
+
+func pluralY(n int) string {
+	if n == 1 {
+		return "y"
+	}
+	return "ies"
+}
@@ -0,0 +1,309 @@
+package files
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"gsn-dev-tools/internals/clierr"
+)
+
+var extractFixture = []fixtureEntry{
+	{Name: "project/", Type: tar.TypeDir},
+	{Name: "project/README.md", Body: "# project\n"},
+	{Name: "project/etc/config.yaml", Body: "level: debug\n"},
+	{Name: "project/main.go", Body: "package main\n"},
+	{Name: "project/util.go", Body: "package main\n\nfunc util() {}\n"},
+	{Name: "project/config-link", Type: tar.TypeSymlink, Link: "etc/config.yaml"},
+	{Name: "project/readme-hard", Type: tar.TypeLink, Link: "project/README.md"},
+}
+
+func TestExtractEntryToStdout(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "project.tar.gz")
+	writeFixtureTar(t, archive, extractFixture)
+	conflicts, perms := testExtractPolicies(".")
+
+	for _, pattern := range []string{"project/etc/config.yaml", "./project/etc/config.yaml", "*/etc/*.yaml"} {
+		var count int
+		var err error
+		out := captureStdout(t, func() {
+			count, err = extractEntries(archive, pattern, "", true, false, conflicts, perms)
+		})
+		if err != nil || count != 1 {
+			t.Fatalf("extractEntries(%q) = %d, %v", pattern, count, err)
+		}
+		if out != "level: debug\n" {
+			t.Errorf("extractEntries(%q) wrote %q", pattern, out)
+		}
+	}
+}
+
+func TestExtractEntryToOutputFile(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "project.tar.gz")
+	writeFixtureTar(t, archive, extractFixture)
+	conflicts, perms := testExtractPolicies(dir)
+
+	output := filepath.Join(dir, "out.yaml")
+	if _, err := extractEntries(archive, "project/etc/config.yaml", output, false, false, conflicts, perms); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(output); string(data) != "level: debug\n" {
+		t.Errorf("output holds %q", data)
+	}
+}
+
+func TestExtractStdoutRefusesEntriesWithoutContent(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "project.tar.gz")
+	writeFixtureTar(t, archive, extractFixture)
+	conflicts, perms := testExtractPolicies(".")
+
+	for _, pattern := range []string{"project", "project/readme-hard", "project/config-link"} {
+		var err error
+		out := captureStdout(t, func() {
+			_, err = extractEntries(archive, pattern, "", true, false, conflicts, perms)
+		})
+		if err == nil {
+			t.Errorf("extractEntries(%q) to stdout succeeded, want an error", pattern)
+		}
+		if out != "" {
+			t.Errorf("extractEntries(%q) wrote %q to stdout", pattern, out)
+		}
+	}
+}
+
+func TestExtractGlobMatches(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "project.tar.gz")
+	writeFixtureTar(t, archive, extractFixture)
+	conflicts, perms := testExtractPolicies(dir)
+
+	if _, err := extractEntries(archive, "project/*.go", "", true, false, conflicts, perms); err == nil || !strings.Contains(err.Error(), "--all") {
+		t.Errorf("a glob matching two entries = %v, want an error suggesting --all", err)
+	}
+	if _, err := extractEntries(archive, "project/main.go", "", false, true, conflicts, perms); clierr.CodeOf(err) != clierr.Usage {
+		t.Errorf("--all with an exact path = %v, want a usage error", err)
+	}
+
+	dest := filepath.Join(dir, "src")
+	count, err := extractEntries(archive, "project/*.go", dest, false, true, conflicts, perms)
+	if err != nil || count != 2 {
+		t.Fatalf("--all = %d, %v, want 2 entries", count, err)
+	}
+	for _, name := range []string{"project/main.go", "project/util.go"} {
+		if _, err := os.Stat(filepath.Join(dest, name)); err != nil {
+			t.Errorf("--all did not extract %s: %v", name, err)
+		}
+	}
+}
+
+func TestExtractNotFoundSuggestsClosestNames(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "project.tar.gz")
+	writeFixtureTar(t, archive, extractFixture)
+	conflicts, perms := testExtractPolicies(".")
+
+	_, err := extractEntries(archive, "project/etc/config.yml", "", true, false, conflicts, perms)
+	var notFound *entryNotFoundError
+	if !errors.As(err, &notFound) {
+		t.Fatalf("err = %v, want an entryNotFoundError", err)
+	}
+	if clierr.CodeOf(err) != clierr.NotFound {
+		t.Errorf("exit code = %d, want %d", clierr.CodeOf(err), clierr.NotFound)
+	}
+	if len(notFound.Suggestions) == 0 || notFound.Suggestions[0] != "project/etc/config.yaml" {
+		t.Errorf("suggestions = %v, want project/etc/config.yaml first", notFound.Suggestions)
+	}
+}
+
+func TestExtractAllRestoresLinks(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "project.tar.gz")
+	writeFixtureTar(t, archive, extractFixture)
+	dest := filepath.Join(dir, "out")
+	conflicts, perms := testExtractPolicies(dest)
+
+	count, err := extractAll(archive, dest, conflicts, perms, nil)
+	if err != nil || count != len(extractFixture) {
+		t.Fatalf("extractAll = %d, %v", count, err)
+	}
+	if link, _ := os.Readlink(filepath.Join(dest, "project/config-link")); link != "etc/config.yaml" {
+		t.Errorf("symlink points to %q", link)
+	}
+	readme, _ := os.Stat(filepath.Join(dest, "project/README.md"))
+	hard, _ := os.Stat(filepath.Join(dest, "project/readme-hard"))
+	if readme == nil || hard == nil || !os.SameFile(readme, hard) {
+		t.Error("hard link does not share the inode of its target")
+	}
+}
+
+// TestExtractRefusesMaliciousArchives checks that no entry of a hostile archive is written outside the
+// destination, through its name, a symlink it plants or a hard link
+func TestExtractRefusesMaliciousArchives(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries func(outside string) []fixtureEntry
+	}{
+		{"dot dot name", func(string) []fixtureEntry {
+			return []fixtureEntry{{Name: "../owned.txt", Body: "x"}}
+		}},
+		{"write through absolute symlink", func(outside string) []fixtureEntry {
+			return []fixtureEntry{
+				{Name: "link", Type: tar.TypeSymlink, Link: outside},
+				{Name: "link/owned.txt", Body: "x"},
+			}
+		}},
+		{"write through relative symlink", func(string) []fixtureEntry {
+			return []fixtureEntry{
+				{Name: "link", Type: tar.TypeSymlink, Link: "../outside"},
+				{Name: "link/owned.txt", Body: "x"},
+			}
+		}},
+		{"write through nested symlink", func(string) []fixtureEntry {
+			return []fixtureEntry{
+				{Name: "a/", Type: tar.TypeDir},
+				{Name: "a/b", Type: tar.TypeSymlink, Link: "../../outside"},
+				{Name: "a/b/c/owned.txt", Body: "x"},
+			}
+		}},
+		{"symlink escaping through another symlink", func(string) []fixtureEntry {
+			return []fixtureEntry{
+				{Name: "d1/d2/", Type: tar.TypeDir},
+				{Name: "d1/d2/up", Type: tar.TypeSymlink, Link: "../.."},
+				{Name: "esc", Type: tar.TypeSymlink, Link: "d1/d2/up/../outside"},
+			}
+		}},
+		{"symlink to the archive root parent", func(string) []fixtureEntry {
+			return []fixtureEntry{{Name: "up", Type: tar.TypeSymlink, Link: ".."}}
+		}},
+		{"hard link out", func(string) []fixtureEntry {
+			return []fixtureEntry{{Name: "hard", Type: tar.TypeLink, Link: "../outside/victim.txt"}}
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			outside := filepath.Join(dir, "outside")
+			writeTree(t, outside, map[string]string{"victim.txt": "untouched"})
+			dest := filepath.Join(dir, "dest")
+			if err := os.Mkdir(dest, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			archive := filepath.Join(dir, "evil.tar")
+			writeFixtureTar(t, archive, test.entries(outside))
+
+			conflicts, perms := testExtractPolicies(dest)
+			if _, err := extractAll(archive, dest, conflicts, perms, nil); err == nil {
+				t.Error("extractAll succeeded, want the archive refused")
+			}
+
+			entries, _ := os.ReadDir(outside)
+			if len(entries) != 1 {
+				t.Errorf("outside holds %d entries, want only victim.txt", len(entries))
+			}
+			if data, _ := os.ReadFile(filepath.Join(outside, "victim.txt")); string(data) != "untouched" {
+				t.Errorf("victim.txt = %q", data)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "owned.txt")); err == nil {
+				t.Error("owned.txt was written next to the destination")
+			}
+		})
+	}
+}
+
+func TestExtractAllRefusesWritingThroughSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	outside := filepath.Join(dir, "outside")
+	dest := filepath.Join(dir, "dest")
+	writeTree(t, dir, map[string]string{"outside/": "", "dest/": ""})
+	if err := os.Symlink(outside, filepath.Join(dest, "link")); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(dir, "evil.tar")
+	writeFixtureTar(t, archive, []fixtureEntry{{Name: "link/owned.txt", Body: "x"}})
+
+	conflicts, perms := testExtractPolicies(dest)
+	if _, err := extractEntries(archive, "*/*.txt", dest, false, true, conflicts, perms); err == nil {
+		t.Error("extractEntries --all succeeded, want the entry refused")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "owned.txt")); err == nil {
+		t.Error("owned.txt was written through the symlink")
+	}
+}
+
+func TestExtractKeepsSymlinksInside(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "ok.tar")
+	writeFixtureTar(t, archive, []fixtureEntry{
+		{Name: "a/b/", Type: tar.TypeDir},
+		{Name: "a/b/file", Body: "x"},
+		{Name: "a/up", Type: tar.TypeSymlink, Link: "../a/b/file"},
+		{Name: "a/b/sibling", Type: tar.TypeSymlink, Link: "../up"},
+	})
+	dest := filepath.Join(dir, "dest")
+	conflicts, perms := testExtractPolicies(dest)
+	if _, err := extractAll(archive, dest, conflicts, perms, nil); err != nil {
+		t.Fatalf("extractAll: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dest, "a/b/sibling")); err != nil || string(data) != "x" {
+		t.Errorf("a/b/sibling = %q, %v", data, err)
+	}
+}
+
+func TestSafeJoin(t *testing.T) {
+	dest := t.TempDir()
+	if err := os.Symlink(t.TempDir(), filepath.Join(dest, "link")); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]bool{
+		"a/b.txt":          true,
+		"./a/../b.txt":     true,
+		"/etc/passwd":      true,
+		"link":             true,
+		"../b.txt":         false,
+		"a/../../b.txt":    false,
+		"link/b.txt":       false,
+		"link/deep/b.txt":  false,
+		"missing/b/c.txt":  true,
+		"link/../b.txt":    true,
+		"a/./link/../c.go": true,
+	}
+	for name, ok := range tests {
+		target, err := safeJoin(dest, name)
+		if (err == nil) != ok {
+			t.Errorf("safeJoin(%q) = %q, %v, want ok %v", name, target, err, ok)
+		}
+		if err == nil && !strings.HasPrefix(target, dest) {
+			t.Errorf("safeJoin(%q) = %q, outside %s", name, target, dest)
+		}
+	}
+}
+
+func TestClosestNames(t *testing.T) {
+	names := []string{"src/main.go", "src/mian.go", "docs/readme.md", "src/main_test.go"}
+	got := closestNames("src/main.go", names, 2)
+	if !slices.Equal(got, []string{"src/main.go", "src/mian.go"}) {
+		t.Errorf("closestNames = %v", got)
+	}
+
+	// However many names go by, only the closest few are kept: on a tie the first, a repeated name once
+	s := newNameSuggestions("src/main.go", 3)
+	for i := range 10000 {
+		s.add(fmt.Sprintf("vendor/pkg%d/file.go", i))
+		if i == 5000 {
+			s.add("src/mainx.go")
+			s.add("src/main.go")
+			s.add("src/main.go")
+			s.add("src/mainy.go")
+		}
+	}
+	s.add("src/mainz.go")
+	if !slices.Equal(s.names, []string{"src/main.go", "src/mainx.go", "src/mainy.go"}) || len(s.distances) != 3 || cap(s.names) > 8 {
+		t.Errorf("kept %v (cap %d)", s.names, cap(s.names))
+	}
+}
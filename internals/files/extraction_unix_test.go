@@ -0,0 +1,71 @@
+//go:build unix
+
+package files
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// TestExtractExactEntryStopsReading serves a gzip archive whose wanted entry comes first, followed by 32 MiB
+// of incompressible data, through a FIFO, and checks that extract closes it long before the end
+func TestExtractExactEntryStopsReading(t *testing.T) {
+	const filler = 32 << 20
+
+	var archive bytes.Buffer
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+	writeFixtureEntry(t, tw, fixtureEntry{Name: "etc/config.yaml", Body: "level: debug\n"})
+	if err := tw.WriteHeader(&tar.Header{Name: "big.bin", Mode: 0o644, Size: filler, Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	random := rand.NewChaCha8([32]byte{})
+	if _, err := io.CopyN(tw, random, filler); err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+	gz.Close()
+
+	fifo := filepath.Join(t.TempDir(), "archive.tar.gz")
+	if err := syscall.Mkfifo(fifo, 0o600); err != nil {
+		t.Skipf("cannot create a FIFO: %v", err)
+	}
+	written := make(chan int64)
+	go func() {
+		file, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+		if err != nil {
+			written <- -1
+			return
+		}
+		n, _ := io.Copy(file, &archive)
+		file.Close()
+		written <- n
+	}()
+
+	conflicts, perms := testExtractPolicies(".")
+	var err error
+	out := captureStdout(t, func() {
+		_, err = extractEntries(fifo, "etc/config.yaml", "", true, false, conflicts, perms)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "level: debug\n" {
+		t.Errorf("stdout = %q", out)
+	}
+
+	n := <-written
+	if n < 0 {
+		t.Fatal("cannot open the FIFO for writing")
+	}
+	if n > 4<<20 {
+		t.Errorf("extract read %d bytes of a %d byte archive, want it to stop after the first entry", n, filler)
+	}
+}
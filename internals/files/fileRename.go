@@ -1,136 +1,389 @@
 package files
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
-	"unicode"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/config"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/lockfile"
+	"gsn-dev-tools/internals/paths"
+	"gsn-dev-tools/internals/style"
 
 	"github.com/spf13/cobra"
 )
 
 func FileUpdateCmd() *cobra.Command {
 	updateFilesCmd := cobra.Command{
-		Use:   "rename <directory_path>",
+		Use:   "rename [directory_path]",
 		Short: "Renames and updates extensions of files in specific directories",
-		Long:  `Applies rules: 1. Clean name (remove prefix/symbols), 2. Space to _, 3. Lowercase, 4. Set new extension.`,
-		Args:  cobra.ExactArgs(1),
-		Run:   UpdateAndRenameFilesInDirectory,
+		Long: `Applies rules: 1. Clean name (remove prefix/symbols), 2. Space to _, 3. Lowercase, 4. Set new extension.
+With --template the new base name is built from placeholders: {name} is the cleaned name and {n} a sequence
+number zero padded to the file count, assigned in the order selected by --sort.
+
+The plan is checked before anything is renamed, and the run is refused when two files would get the same name
+(collision), a name is empty after cleaning or only an extension (empty-name), only the case of a name changes
+on a case-insensitive filesystem (case-only), a name is longer than the filesystem allows (name-max), or, when
+-e is given, the magic bytes of a file disagree with its new extension, like a PNG renamed to .txt (magic).
+--allow <rule> lets the run go ahead with a warning for that rule.
+
+Every move is recorded in ` + renameJournalName + ` inside the directory. With --workspace every root of a
+workspace from the config file is renamed in turn and a single aggregate journal is written to the state dir;
+--undo <journal> reverts a previous run. Two runs never rename in the same directory at once, the second waits
+for the first for a while, then fails with exit code 4.`,
+		Example: `  gsn rename ./notes -e md
+  gsn rename ./photos -t photo_{n} --sort mtime
+  gsn rename ./scans -e pdf --allow magic
+  gsn rename --undo ./photos/.gsn-rename-journal.json`,
+		Args: cobra.MaximumNArgs(1),
+		Run:  UpdateAndRenameFilesInDirectory,
 	}
 
 	updateFilesCmd.Flags().StringP("extension", "e", "txt", "File extension to apply to all files (default: txt)")
+	updateFilesCmd.Flags().StringP("template", "t", "", "Name template using {name} and {n} placeholders, e.g. photo_{n}")
+	updateFilesCmd.Flags().String("sort", string(sortName), "Order used to number files: name, natural, mtime or size")
+	updateFilesCmd.Flags().StringP("workspace", "w", "", "Rename every root of this workspace from the config file")
+	updateFilesCmd.Flags().String("undo", "", "Revert the moves recorded in this journal file")
+	updateFilesCmd.Flags().StringSlice("allow", nil, "Rename despite findings of this lint rule: collision, empty-name, case-only, name-max or magic (repeatable)")
+	dryrun.Adopt(&updateFilesCmd)
 
 	return &updateFilesCmd
 }
 
 func UpdateAndRenameFilesInDirectory(cmd *cobra.Command, args []string) {
-	directoryPath := args[0]
+	workspaceName, _ := cmd.Flags().GetString("workspace")
+	undoPath, _ := cmd.Flags().GetString("undo")
+
+	if undoPath != "" {
+		if dryrun.Enabled() {
+			clierr.Exitf(clierr.Usage, "--undo does not support --dry-run yet, nothing was run")
+		}
+		undoRename(undoPath)
+		return
+	}
+
+	if (len(args) == 0) == (workspaceName == "") {
+		clierr.Exitf(clierr.Usage, "pass either a directory or --workspace")
+	}
+
+	if workspaceName == "" {
+		opts, err := renameOptionsFromFlags(cmd, config.RenameSettings{})
+		if err != nil {
+			clierr.Fatalf("%v", err)
+		}
+
+		renamedCount, err := renameDirectory(args[0], opts, nil)
+		if err != nil {
+			clierr.Fatalf("%v", err)
+		}
+		fmt.Printf("\n%s\n", renameSummary(renamedCount, ""))
+		return
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	workspace, ok := cfg.Workspaces[workspaceName]
+	if !ok {
+		clierr.Fatalf("%v", cfg.MissingValue(fmt.Errorf("workspace '%s' is not defined in the config file", workspaceName)))
+	}
+
+	opts, err := renameOptionsFromFlags(cmd, workspace.Rename)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+
+	// The aggregate journal uses absolute paths so one undo reverts every root
+	aggregate := newJournal("rename", "")
+	renamedCount, rootCount := 0, 0
+	for _, root := range workspace.Roots {
+		root = config.ExpandHome(root)
+		if info, err := os.Stat(root); err != nil || !info.IsDir() {
+			fmt.Fprintf(os.Stderr, style.Warning()+"Skipping missing workspace root '%s'\n", root)
+			continue
+		}
+
+		fmt.Printf("==> %s\n", root)
+		count, err := renameDirectory(root, opts, aggregate)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, style.Warning()+"Failed to rename files in '%s': %v\n", root, err)
+			continue
+		}
+		renamedCount += count
+		rootCount++
+	}
+
+	if len(aggregate.Entries) > 0 {
+		journalPath, err := saveWorkspaceJournal(workspaceName, aggregate)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, style.Warning()+"Failed to write workspace journal: %v\n", err)
+		} else if !dryrun.Enabled() {
+			fmt.Printf("Journal: %s (revert with gsn rename --undo %s)\n", journalPath, journalPath)
+		}
+	}
+
+	fmt.Printf("\n%s\n", renameSummary(renamedCount, fmt.Sprintf(" across %d root(s) of workspace '%s'", rootCount, workspaceName)))
+}
+
+// renameSummary is the closing line of a rename run, scope says where the files were
+func renameSummary(count int, scope string) string {
+	if dryrun.Enabled() {
+		return fmt.Sprintf("Dry run, nothing renamed: %d file(s) would be renamed%s.", count, scope)
+	}
+	return fmt.Sprintf("Completed! Renamed %d file(s)%s.", count, scope)
+}
+
+// renameOptionsFromFlags merges the workspace defaults with the flags set on the command line
+func renameOptionsFromFlags(cmd *cobra.Command, defaults config.RenameSettings) (renameOptions, error) {
 	extension, _ := cmd.Flags().GetString("extension")
+	template, _ := cmd.Flags().GetString("template")
+	sortOrder, _ := cmd.Flags().GetString("sort")
+	allow, _ := cmd.Flags().GetStringSlice("allow")
+
+	if defaults.Extension != "" && !cmd.Flags().Changed("extension") {
+		extension = defaults.Extension
+	}
+	if defaults.Template != "" && !cmd.Flags().Changed("template") {
+		template = defaults.Template
+	}
+	if defaults.Sort != "" && !cmd.Flags().Changed("sort") {
+		sortOrder = defaults.Sort
+	}
+
+	order, err := parseRenameSort(sortOrder)
+	if err != nil {
+		return renameOptions{}, err
+	}
+	if allow, err = parseLintAllow(allow); err != nil {
+		return renameOptions{}, err
+	}
 
 	// Remove leading dot if present
-	extension = strings.TrimPrefix(extension, ".")
+	return renameOptions{
+		Extension:       strings.TrimPrefix(extension, "."),
+		Template:        template,
+		Sort:            order,
+		ForcedExtension: cmd.Flags().Changed("extension"),
+		Allow:           allow,
+	}, nil
+}
 
+// renameDirectory renames the files of a single directory and records every move in the directory journal
+// and, when given, in the aggregate journal
+func renameDirectory(directoryPath string, opts renameOptions, aggregate *Journal) (int, error) {
 	// Validate directory exists
 	dirInfo, err := os.Stat(directoryPath)
 	if err != nil {
-		log.Fatalf("Error: Directory '%s' does not exist or cannot be accessed: %v\n", directoryPath, err)
-		return
+		return 0, fmt.Errorf("directory '%s' does not exist or cannot be accessed: %w", directoryPath, err)
 	}
 
 	if !dirInfo.IsDir() {
-		log.Fatalf("Error: '%s' is not a directory\n", directoryPath)
-		return
+		return 0, fmt.Errorf("'%s' is not a directory", directoryPath)
 	}
 
+	unlock, err := lockRenameDirs([]string{directoryPath})
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
 	// Read directory contents
 	entries, err := os.ReadDir(directoryPath)
 	if err != nil {
-		log.Fatalf("Error reading directory: %v\n", err)
-		return
+		return 0, fmt.Errorf("error reading directory: %w", err)
 	}
 
-	renamedCount := 0
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue // Skip subdirectories
-		}
-
-		oldName := entry.Name()
-		oldPath := filepath.Join(directoryPath, oldName)
+	plan, err := buildRenamePlan(entries, opts)
+	if err != nil {
+		return 0, err
+	}
+	if err := checkRenamePlan(directoryPath, plan, opts); err != nil {
+		return 0, err
+	}
 
-		baseName := strings.TrimSuffix(oldName, filepath.Ext(oldName))
+	journal, journalPath, err := openDirJournal(directoryPath)
+	if err != nil {
+		return 0, err
+	}
+	absDir := journal.Root
 
-		// Clean the filename: keep only letters, replace spaces with underscores, convert to lowercase
-		cleanedName, err := cleanFileName(baseName)
-		if err != nil {
-			fmt.Printf("Error cleaning filename '%s': %v\n", oldName, err)
+	renamedCount := 0
+	for _, op := range plan {
+		if op.Err != nil {
+			fmt.Printf("Error cleaning filename '%s': %v\n", op.OldName, op.Err)
 			continue
 		}
 
-		// Create new filename with extension
-		newName := fmt.Sprintf("%s.%s", cleanedName, extension)
-		newPath := filepath.Join(directoryPath, newName)
+		oldPath := filepath.Join(directoryPath, op.OldName)
+		newPath := filepath.Join(directoryPath, op.NewName)
 
-		if oldName == newName {
+		if op.OldName == op.NewName {
 			continue
 		}
 
 		// Handle case where new filename already exists
 		if _, err := os.Stat(newPath); err == nil {
-			fmt.Printf("Warning: Skipping '%s' - target name '%s' already exists\n", oldName, newName)
+			fmt.Printf("Warning: Skipping '%s' - target name '%s' already exists\n", op.OldName, op.NewName)
 			continue
 		}
 
-		renameErr := os.Rename(oldPath, newPath)
+		renameErr := dryrun.Do(fmt.Sprintf("rename '%s' to '%s'", oldPath, newPath), func() error { return os.Rename(oldPath, newPath) })
 		if renameErr != nil {
-			fmt.Printf("Error renaming '%s' to '%s': %v\n", oldName, newName, renameErr)
+			fmt.Printf("Error renaming '%s' to '%s': %v\n", op.OldName, op.NewName, renameErr)
 			continue
 		}
 
-		fmt.Printf("Renamed: '%s' -> '%s'\n", oldName, newName)
+		if !dryrun.Enabled() {
+			fmt.Printf("Renamed: '%s' -> '%s'\n", op.OldName, op.NewName)
+		}
+		journal.Record(op.OldName, op.NewName)
+		if aggregate != nil {
+			aggregate.Record(filepath.Join(absDir, op.OldName), filepath.Join(absDir, op.NewName))
+		}
 		renamedCount++
 	}
 
-	fmt.Printf("\nCompleted! Renamed %d file(s).\n", renamedCount)
+	if renamedCount > 0 {
+		if err := dryrun.Do("write the journal "+journalPath, func() error { return journal.Save(journalPath) }); err != nil {
+			fmt.Fprintf(os.Stderr, style.Warning()+"Failed to write journal: %v\n", err)
+		}
+	}
+
+	return renamedCount, nil
 }
 
-func cleanFileName(name string) (string, error) {
-	var result strings.Builder
-	var lastWasSpace bool
-	var lastWasLetter bool
-
-	for _, r := range name {
-		if unicode.IsLetter(r) {
-			result.WriteRune(unicode.ToLower(r))
-			lastWasSpace = false
-			lastWasLetter = true
-		} else if unicode.IsDigit(r) {
-			// Only add digit if previous character was a letter
-			if lastWasLetter {
-				result.WriteRune(r)
-				lastWasSpace = false
-				lastWasLetter = false // Number is not a letter
-			}
-		} else if unicode.IsSpace(r) {
-			if !lastWasSpace && result.Len() > 0 {
-				result.WriteRune('_')
-				lastWasSpace = true
-				lastWasLetter = false
-			}
-		} else {
-			lastWasLetter = false
+// saveWorkspaceJournal writes the aggregate journal of a workspace run to the state dir
+func saveWorkspaceJournal(workspace string, journal *Journal) (string, error) {
+	dir, err := workspaceJournalDir()
+	if err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("rename-%s-%s.json", workspace, journal.CreatedAt.Format("20060102T150405Z"))
+	path := filepath.Join(dir, name)
+	return path, dryrun.Do("write the workspace journal "+path, func() error {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
 		}
+		return journal.Save(path)
+	})
+}
+
+// lockRenameDirs takes the advisory locks keeping other rename runs out of dirs, in a fixed order so two runs
+// never wait on each other. The locks live in the gsn state dir, named after a hash of the directory, so the
+// renamed directories hold nothing but their journal. Dry runs rename nothing and take no lock.
+func lockRenameDirs(dirs []string) (func(), error) {
+	if dryrun.Enabled() {
+		return func() {}, nil
+	}
+	stateDir, err := paths.StateDir()
+	if err != nil {
+		return nil, err
 	}
 
-	cleanedName := result.String()
-	cleanedName = strings.Trim(cleanedName, "_")
+	var abs []string
+	for _, dir := range dirs {
+		a, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		abs = append(abs, a)
+	}
+	slices.Sort(abs)
+	abs = slices.Compact(abs)
 
-	// Handle empty result
-	if cleanedName == "" {
-		return "", fmt.Errorf("cleaned filename is empty for input: %s", name)
+	var unlocks []func()
+	release := func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
 	}
+	for _, dir := range abs {
+		sum := sha256.Sum256([]byte(dir))
+		lockPath := filepath.Join(stateDir, "locks", "rename-"+hex.EncodeToString(sum[:8])+".lock")
+		unlock, err := lockfile.Acquire(lockPath, dir, lockfile.DefaultWait)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		unlocks = append(unlocks, unlock)
+	}
+	return release, nil
+}
 
-	return cleanedName, nil
+// undoRename reverts the moves recorded in a rename journal and removes the journal afterwards
+func undoRename(journalPath string) {
+	journal, err := loadJournal(journalPath)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	if journal.Command != "rename" {
+		clierr.Fatalf("'%s' is a %s journal, not a rename journal", journalPath, journal.Command)
+	}
+
+	var dirs []string
+	for _, entry := range journal.Entries {
+		dirs = append(dirs, filepath.Dir(journal.path(entry.From)))
+	}
+	unlock, err := lockRenameDirs(dirs)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	reverted, err := journal.undo()
+	forgetRenames(reverted)
+	unlock()
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+
+	if len(reverted) == len(journal.Entries) {
+		if err := os.Remove(journalPath); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, style.Warning()+"Failed to remove journal: %v\n", err)
+		}
+	}
+	fmt.Printf("\nCompleted! Restored %d of %d file(s).\n", len(reverted), len(journal.Entries))
+}
+
+// forgetRenames drops reverted moves from the directory journals so they are not replayed again later
+func forgetRenames(reverted []JournalEntry) {
+	byDir := make(map[string]map[JournalEntry]bool)
+	for _, entry := range reverted {
+		dir := filepath.Dir(entry.From)
+		if byDir[dir] == nil {
+			byDir[dir] = make(map[JournalEntry]bool)
+		}
+		byDir[dir][JournalEntry{From: filepath.Base(entry.From), To: filepath.Base(entry.To)}] = true
+	}
+
+	for dir, done := range byDir {
+		journalPath := filepath.Join(dir, renameJournalName)
+		journal, err := loadJournal(journalPath)
+		if err != nil {
+			continue
+		}
+
+		kept := journal.Entries[:0]
+		for _, entry := range journal.Entries {
+			if !done[entry] {
+				kept = append(kept, entry)
+			}
+		}
+		journal.Entries = kept
+
+		if len(kept) == 0 {
+			err = os.Remove(journalPath)
+		} else {
+			err = journal.Save(journalPath)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, style.Warning()+"Failed to update journal '%s': %v\n", journalPath, err)
+		}
+	}
 }
@@ -0,0 +1,161 @@
+package files
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/style"
+)
+
+// fileList is the set of entries cmp archives with --files-from, in the order they were listed and with the
+// parent directories they need
+type fileList struct {
+	Entries []listedEntry
+}
+
+// listedEntry is a path read from --files-from, or a parent directory added for one, with its archive name
+type listedEntry struct {
+	Path string
+	Name string
+	Info os.FileInfo
+}
+
+// readFileList reads the paths of a list, one per line or NUL separated with nul. Empty lines are skipped.
+// source "-" reads standard input.
+func readFileList(source string, nul bool) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if source != "-" {
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the file list: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	sep := byte('\n')
+	if nul {
+		sep = 0
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if i := bytes.IndexByte(data, sep); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	})
+
+	var paths []string
+	for scanner.Scan() {
+		if p := scanner.Text(); p != "" {
+			paths = append(paths, p)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading the file list: %w", err)
+	}
+	return paths, nil
+}
+
+// planFileList resolves listed paths into archive entries named relative to the current directory. A path
+// outside it is an error, or with absoluteNames is stored under its absolute path without the leading slash.
+// Directories are archived as a single entry, not walked; their parents and those of files get an entry before
+// them. Entries left out by filter are dropped, and so is output, the archive being written.
+func planFileList(paths []string, absoluteNames bool, filter archiveFilter, output string) (*fileList, error) {
+	root, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	outputAbs, err := filepath.Abs(output)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &fileList{}
+	seen := make(map[string]bool)
+	add := func(filePath string, name string) error {
+		if seen[name] {
+			return nil
+		}
+		info, err := os.Lstat(filePath)
+		if err != nil {
+			return fmt.Errorf("error accessing listed path '%s': %w", filePath, err)
+		}
+		seen[name] = true
+		list.Entries = append(list.Entries, listedEntry{Path: filePath, Name: name, Info: info})
+		return nil
+	}
+
+	for _, p := range paths {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return nil, err
+		}
+		base := root
+		rel, err := filepath.Rel(root, abs)
+		if err != nil {
+			return nil, err
+		}
+		if rel == "." {
+			// The current directory itself, as find . lists it first, prefixes nothing
+			continue
+		}
+		if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			if !absoluteNames {
+				return nil, clierr.Newf(clierr.Usage, "listed path '%s' is outside the current directory, use --absolute-names to archive it", p)
+			}
+			base, rel = string(filepath.Separator), strings.TrimPrefix(abs, string(filepath.Separator))
+		}
+		if abs == outputAbs {
+			fmt.Fprintf(os.Stderr, style.Warning()+"Skipping '%s', it is the archive being written\n", p)
+			continue
+		}
+		if filter.skipsBelow(".", rel) {
+			continue
+		}
+
+		parts := strings.Split(rel, string(filepath.Separator))
+		for i := 1; i < len(parts); i++ {
+			parent := filepath.Join(parts[:i]...)
+			if err := add(filepath.Join(base, parent), filepath.ToSlash(parent)); err != nil {
+				return nil, err
+			}
+		}
+		if err := add(p, filepath.ToSlash(rel)); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// stats totals the regular files of the list for the progress bar and the size limits
+func (l *fileList) stats() sourceStats {
+	var stats sourceStats
+	for _, e := range l.Entries {
+		if e.Info.Mode().IsRegular() {
+			stats.Size += e.Info.Size()
+			stats.Files++
+		}
+	}
+	return stats
+}
+
+// walk calls fn for every entry of the list, in archive order
+func (l *fileList) walk(fn func(filePath string, name string, info os.FileInfo) error) error {
+	for _, e := range l.Entries {
+		if err := fn(e.Path, e.Name, e.Info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
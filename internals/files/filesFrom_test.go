@@ -0,0 +1,122 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+// awkwardNames are file names a line based list cannot carry, and some it can but only just
+var awkwardNames = map[string]string{
+	"plain.txt":                   "plain",
+	"with space.txt":              "space",
+	"new\nline.txt":               "newline",
+	"tab\tname.txt":               "tab",
+	" leading and trailing ":      "blanks",
+	"dir with space/inner file":   "inner",
+	"dir with space/new\nline/in": "nested",
+	"back\\slash":                 "backslash",
+}
+
+// writeList writes paths to a file joined by sep and ending with it, as find -print0 or -print does
+func writeList(t *testing.T, paths []string, sep string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "list")
+	if err := os.WriteFile(path, []byte(strings.Join(paths, sep)+sep), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadFileListNul(t *testing.T) {
+	names := []string{"./with space.txt", "./new\nline.txt", "./tab\tname.txt", "./ leading and trailing ", "./dir with space/new\nline/in"}
+	got, err := readFileList(writeList(t, names, "\x00"), true)
+	if err != nil || !slices.Equal(got, names) {
+		t.Errorf("NUL separated list = %q, %v\nwant %q", got, err, names)
+	}
+
+	// Empty entries are skipped and the last entry needs no separator
+	path := filepath.Join(t.TempDir(), "list")
+	if err := os.WriteFile(path, []byte("\x00a b\x00\x00new\nline"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := readFileList(path, true); err != nil || !slices.Equal(got, []string{"a b", "new\nline"}) {
+		t.Errorf("list with empty entries = %q, %v", got, err)
+	}
+
+	// A line based list splits a name at its newline, and keeps spaces and tabs
+	if got, _ := readFileList(writeList(t, names, "\n"), false); !slices.Equal(got, []string{"./with space.txt", "./new", "line.txt",
+		"./tab\tname.txt", "./ leading and trailing ", "./dir with space/new", "line/in"}) {
+		t.Errorf("line based list = %q", got)
+	}
+
+	// - reads standard input
+	stdin, err := os.Open(writeList(t, names, "\x00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	saved := os.Stdin
+	os.Stdin = stdin
+	defer func() { os.Stdin = saved }()
+	if got, err := readFileList("-", true); err != nil || !slices.Equal(got, names) {
+		t.Errorf("list from stdin = %q, %v", got, err)
+	}
+
+	if _, err := readFileList(filepath.Join(t.TempDir(), "missing"), true); err == nil || !strings.HasPrefix(err.Error(), "error reading the file list: ") {
+		t.Errorf("missing list = %v", err)
+	}
+}
+
+func TestFilesFromNulArchivesAwkwardNames(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, awkwardNames)
+	t.Chdir(dir)
+
+	// In the order find . -print0 could list them, the current directory first
+	listed := []string{".", "./plain.txt", "./with space.txt", "./new\nline.txt", "./tab\tname.txt", "./ leading and trailing ",
+		"./dir with space/inner file", "./dir with space/new\nline/in", "back\\slash"}
+	paths, err := readFileList(writeList(t, listed, "\x00"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(t.TempDir(), "listed.tar.gz")
+	list, err := planFileList(paths, false, archiveFilter{}, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := compressPath(".", compressOptions{List: list, Output: output, SkipSpaceCheck: true, Progress: progressbar.DefaultBytesSilent(-1)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every name is stored as it is, the parent directories before what is below them
+	var names []string
+	bodies := make(map[string]string)
+	for _, entry := range readFixtureTar(t, output) {
+		names = append(names, entry.Name)
+		bodies[entry.Name] = entry.Body
+	}
+	want := []string{"plain.txt", "with space.txt", "new\nline.txt", "tab\tname.txt", " leading and trailing ",
+		"dir with space", "dir with space/inner file", "dir with space/new\nline", "dir with space/new\nline/in", "back\\slash"}
+	if !slices.Equal(names, want) {
+		t.Errorf("archive holds %q,\nwant %q", names, want)
+	}
+	for name, body := range awkwardNames {
+		if bodies[name] != body {
+			t.Errorf("%q = %q, want %q", name, bodies[name], body)
+		}
+	}
+
+	// Read line by line the same list names paths that do not exist
+	paths, err = readFileList(writeList(t, listed, "\n"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := planFileList(paths, false, archiveFilter{}, output); err == nil || !strings.Contains(err.Error(), "error accessing listed path './new'") {
+		t.Errorf("line based list of a name with a newline = %v", err)
+	}
+}
@@ -0,0 +1,480 @@
+package files
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/output"
+	"gsn-dev-tools/internals/redact"
+	"gsn-dev-tools/internals/style"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed filetypes.yaml
+var builtinFileTypes []byte
+
+// Statuses of a file checked by gsn filetype
+const (
+	typeOK        = "ok"
+	typeMismatch  = "mismatch"
+	typeAmbiguous = "ambiguous"
+	typeUnknown   = "unknown"
+)
+
+// fileType is an entry of filetypes.yaml
+type fileType struct {
+	Name       string           `yaml:"-"`
+	MIME       string           `yaml:"mime"`
+	Extensions []string         `yaml:"extensions"`
+	Generic    bool             `yaml:"generic"`
+	Signatures [][]magicPattern `yaml:"signatures"`
+}
+
+// magicPattern is bytes expected at Offset, or anywhere in the first Within bytes when Within is set
+type magicPattern struct {
+	Offset int    `yaml:"offset"`
+	Within int    `yaml:"within"`
+	Hex    string `yaml:"hex"`
+	Text   string `yaml:"text"`
+
+	bytes []byte
+}
+
+func (p magicPattern) matches(head []byte) bool {
+	if p.Within > 0 {
+		return bytes.Contains(head[:min(p.Within, len(head))], p.bytes)
+	}
+	return p.Offset+len(p.bytes) <= len(head) && bytes.Equal(head[p.Offset:p.Offset+len(p.bytes)], p.bytes)
+}
+
+// fileTypeTable holds the file types by name and how much of a file their signatures look at
+type fileTypeTable struct {
+	types    []*fileType
+	headSize int
+}
+
+func loadFileTypes() (fileTypeTable, error) {
+	var types map[string]*fileType
+	if err := yaml.Unmarshal(builtinFileTypes, &types); err != nil {
+		return fileTypeTable{}, fmt.Errorf("invalid built-in file types: %w", err)
+	}
+	var table fileTypeTable
+	for name, t := range types {
+		t.Name = name
+		if len(t.Extensions) == 0 || len(t.Signatures) == 0 {
+			return table, fmt.Errorf("invalid built-in file type %s: it needs extensions and signatures", name)
+		}
+		for _, signature := range t.Signatures {
+			if len(signature) == 0 {
+				return table, fmt.Errorf("invalid built-in file type %s: a signature has no pattern", name)
+			}
+			for i := range signature {
+				p := &signature[i]
+				switch {
+				case (p.Hex == "") == (p.Text == ""):
+					return table, fmt.Errorf("invalid built-in file type %s: a pattern takes either hex or text", name)
+				case p.Within > 0 && p.Offset > 0:
+					return table, fmt.Errorf("invalid built-in file type %s: a pattern takes either offset or within", name)
+				case p.Hex != "":
+					decoded, err := hex.DecodeString(p.Hex)
+					if err != nil {
+						return table, fmt.Errorf("invalid built-in file type %s: %w", name, err)
+					}
+					p.bytes = decoded
+				default:
+					p.bytes = []byte(p.Text)
+				}
+				table.headSize = max(table.headSize, p.Offset+len(p.bytes), p.Within)
+			}
+		}
+		table.types = append(table.types, t)
+	}
+	slices.SortFunc(table.types, func(a, b *fileType) int { return strings.Compare(a.Name, b.Name) })
+	return table, nil
+}
+
+// detect returns the types whose signatures match the head of a file best: the one matching the most bytes,
+// several when they tie, none when no signature matches
+func (t fileTypeTable) detect(head []byte) []*fileType {
+	var best []*fileType
+	bestScore := 0
+	for _, ft := range t.types {
+		score := 0
+		for _, signature := range ft.Signatures {
+			matched, n := true, 0
+			for _, p := range signature {
+				if !p.matches(head) {
+					matched = false
+					break
+				}
+				n += len(p.bytes)
+			}
+			if matched {
+				score = max(score, n)
+			}
+		}
+		switch {
+		case score == 0 || score < bestScore:
+		case score > bestScore:
+			best, bestScore = []*fileType{ft}, score
+		default:
+			best = append(best, ft)
+		}
+	}
+	return best
+}
+
+// fileTypeResult is the verdict of gsn filetype on a single file
+type fileTypeResult struct {
+	Path string `json:"path"`
+	// Type names the detected type, the candidates joined with " or " when several share the magic bytes
+	Type      string `json:"type,omitempty"`
+	MIME      string `json:"mime,omitempty"`
+	Extension string `json:"extension"`
+	// Expected lists the extensions the content may carry, canonical first
+	Expected  []string `json:"expected_extensions,omitempty"`
+	Status    string   `json:"status"`
+	RenamedTo string   `json:"renamed_to,omitempty"`
+
+	fileType *fileType
+}
+
+// classifyFile compares the extension of path with the types detected from its content. An extension of any of
+// the detected types settles a tie, otherwise a tie or a generic container is ambiguous: it is reported with
+// every extension it may need rather than guessing one.
+func classifyFile(path string, types []*fileType) fileTypeResult {
+	r := fileTypeResult{Path: path, Extension: fileExtension(path), Status: typeUnknown}
+	if len(types) == 0 {
+		return r
+	}
+	for _, t := range types {
+		if slices.Contains(t.Extensions, r.Extension) {
+			r.Type, r.MIME, r.Expected, r.Status = t.Name, t.MIME, t.Extensions, typeOK
+			return r
+		}
+	}
+
+	var names, mimes []string
+	for _, t := range types {
+		names = append(names, t.Name)
+		if !slices.Contains(mimes, t.MIME) {
+			mimes = append(mimes, t.MIME)
+		}
+		for _, ext := range t.Extensions {
+			if !slices.Contains(r.Expected, ext) {
+				r.Expected = append(r.Expected, ext)
+			}
+		}
+	}
+	r.Type, r.MIME = strings.Join(names, " or "), strings.Join(mimes, ", ")
+	r.Status = typeAmbiguous
+	if len(types) == 1 && !types[0].Generic {
+		r.Status, r.fileType = typeMismatch, types[0]
+	}
+	return r
+}
+
+// fileExtension returns the lower case extension of a file name without the dot, empty for dot files like
+// .bashrc that have none
+func fileExtension(path string) string {
+	name := filepath.Base(path)
+	if strings.LastIndexByte(name, '.') <= 0 {
+		return ""
+	}
+	return strings.ToLower(strings.TrimPrefix(filepath.Ext(name), "."))
+}
+
+// fixedName replaces the extension of name with the canonical one of t, the way gsn rename -e does. A name
+// like backup.tar.gz holding a plain tar loses its last extension only.
+func fixedName(name string, t *fileType) string {
+	base := name
+	if fileExtension(name) != "" {
+		base = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	canonical := t.Extensions[0]
+	if fileExtension(base) == canonical {
+		return base
+	}
+	return base + "." + canonical
+}
+
+var fileTypeColumns = []output.Column[fileTypeResult]{
+	{Name: "path", Value: func(r fileTypeResult) any { return r.Path }},
+	{Name: "type", Value: func(r fileTypeResult) any { return r.Type }},
+	{Name: "mime", Value: func(r fileTypeResult) any { return r.MIME }},
+	{Name: "extension", Value: func(r fileTypeResult) any { return r.Extension }},
+	{Name: "expected", Value: func(r fileTypeResult) any { return strings.Join(r.Expected, ",") }},
+	{Name: "status", Value: func(r fileTypeResult) any { return r.Status }},
+}
+
+func FileTypeCmd() *cobra.Command {
+	fileTypeCmd := cobra.Command{
+		Use:   "filetype <path>...",
+		Short: "Identifies files by their magic bytes and checks their extensions",
+		Long: `Reads the first bytes of every file and matches them against an embedded table of signatures of common
+images, archives, documents, audio and video, executables and fonts. Every file is reported with its type, MIME
+type and whether its extension fits: ok, mismatch, ambiguous when several formats share the magic bytes, like
+the ZIP container of .jar and .docx files, or unknown, which includes every text file. An ambiguous file whose
+extension belongs to one of the candidates is ok.
+
+Directories are walked recursively; --preset, --exclude and the depth flags leave out entries the same way they
+do for cmp, and the .git, .hg and .svn directories are skipped.
+
+--fix-ext renames the mismatched files to the canonical extension of their type, replacing the last extension.
+Ambiguous and unknown files are never renamed. The moves are recorded in the ` + renameJournalName + ` of each
+directory, so gsn rename --undo reverts them.`,
+		Example: `  gsn filetype ~/Downloads
+  gsn filetype scan.txt photo
+  gsn filetype ~/Downloads --fix-ext --dry-run
+  gsn filetype . --exclude node_modules --json`,
+		Args: cobra.MinimumNArgs(1),
+		Run:  IdentifyFileTypes,
+	}
+
+	addArchiveFilterFlags(&fileTypeCmd)
+	output.AddFlags(&fileTypeCmd)
+	fileTypeCmd.Flags().Bool("fix-ext", false, "Rename mismatched files to the canonical extension of their type")
+	fileTypeCmd.Flags().Bool("json", false, "Print the files and their types as JSON")
+	dryrun.Adopt(&fileTypeCmd)
+	return &fileTypeCmd
+}
+
+func IdentifyFileTypes(cmd *cobra.Command, args []string) {
+	fixExt, _ := cmd.Flags().GetBool("fix-ext")
+	asJSON, _ := cmd.Flags().GetBool("json")
+
+	opts, err := output.OptionsFromFlags(cmd)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	if asJSON && opts.Format != output.FormatTable {
+		clierr.Exitf(clierr.Usage, "--json cannot be combined with --csv or --tsv")
+	}
+	table, err := loadFileTypes()
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+
+	var paths []string
+	for _, arg := range args {
+		found, err := fileTypePaths(cmd, arg)
+		if err != nil {
+			clierr.Fatalf("%v", err)
+		}
+		paths = append(paths, found...)
+	}
+	results := detectFileTypes(paths, table, runtime.NumCPU())
+
+	if asJSON {
+		// The renames are reported on stderr, stdout carries the JSON listing where the files went
+		if fixExt {
+			dryrun.Plan = os.Stderr
+			if _, err := fixExtensions(results, os.Stderr); err != nil {
+				clierr.Fatalf("%v", err)
+			}
+		}
+		if err := output.Sort(fileTypeColumns, results, opts); err != nil {
+			clierr.Fatalf("%v", err)
+		}
+		data, err := json.MarshalIndent(struct {
+			Files []fileTypeResult `json:"files"`
+		}{results}, "", "  ")
+		if err != nil {
+			clierr.Fatalf("%v", err)
+		}
+		redact.JSONOutput()
+		fmt.Println(string(data))
+		return
+	}
+	if err := output.Render(os.Stdout, fileTypeColumns, results, opts); err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	if opts.Format != output.FormatTable && !fixExt {
+		return
+	}
+	renamed := 0
+	if fixExt {
+		fmt.Println()
+		if renamed, err = fixExtensions(results, os.Stdout); err != nil {
+			clierr.Fatalf("%v", err)
+		}
+	}
+	if opts.Format != output.FormatTable {
+		return
+	}
+
+	counts := map[string]int{}
+	for _, r := range results {
+		counts[r.Status]++
+	}
+	fmt.Printf("\n%d file(s): %d ok, %d mismatched, %d ambiguous, %d unknown\n", len(results), counts[typeOK], counts[typeMismatch], counts[typeAmbiguous], counts[typeUnknown])
+	if fixExt && dryrun.Enabled() {
+		fmt.Printf("Dry run, nothing renamed: %d file(s) would be renamed.\n", renamed)
+	} else if fixExt {
+		fmt.Printf("Renamed %d file(s).\n", renamed)
+	}
+}
+
+// fileTypePaths returns path when it is a file, or the regular files below it when it is a directory, left out
+// by the filter flags and gsn's own metadata like rename journals
+func fileTypePaths(cmd *cobra.Command, root string) ([]string, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{root}, nil
+	}
+	filter, err := archiveFilterFromFlags(cmd, root)
+	if err != nil {
+		return nil, err
+	}
+	filter.Excludes = slices.Concat(filter.Excludes, vcsDirs)
+
+	entries, err := walkParallel(root, defaultWalkWorkers, filter.walkDepth())
+	if err != nil {
+		return nil, fmt.Errorf("error walking '%s': %w", root, err)
+	}
+	var paths []string
+	for _, e := range entries {
+		if !e.Info.Mode().IsRegular() || strings.HasPrefix(e.Info.Name(), ".gsn-") || filter.skipsBelow(root, e.Path) {
+			continue
+		}
+		paths = append(paths, e.Path)
+	}
+	return paths, nil
+}
+
+// detectFileTypes reads the head of every file on workers goroutines and classifies it, in the order of paths.
+// Files that cannot be read are reported and left out.
+func detectFileTypes(paths []string, table fileTypeTable, workers int) []fileTypeResult {
+	results := make([]fileTypeResult, len(paths))
+	failed := make([]bool, len(paths))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			head := make([]byte, table.headSize)
+			for i := range jobs {
+				n, err := readHead(paths[i], head)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, style.Warning()+"Skipping '%s': %v\n", paths[i], err)
+					failed[i] = true
+					continue
+				}
+				results[i] = classifyFile(paths[i], table.detect(head[:n]))
+			}
+		}()
+	}
+	for i := range paths {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	kept := results[:0]
+	for i, r := range results {
+		if !failed[i] {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+// readHead reads the start of a file into head and returns how many bytes it holds, fewer for a short file
+func readHead(path string, head []byte) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	n, err := io.ReadFull(f, head)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return n, err
+}
+
+// fixExtensions renames the mismatched files to the canonical extension of their type and records the moves in
+// the rename journal of each directory. A file whose new name is taken is skipped. It returns how many files
+// were renamed, the renames are reported on messages.
+func fixExtensions(results []fileTypeResult, messages io.Writer) (int, error) {
+	byDir := map[string][]int{}
+	var dirs []string
+	for i, r := range results {
+		if r.Status != typeMismatch {
+			continue
+		}
+		dir := filepath.Dir(r.Path)
+		if byDir[dir] == nil {
+			dirs = append(dirs, dir)
+		}
+		byDir[dir] = append(byDir[dir], i)
+	}
+	if len(dirs) == 0 {
+		return 0, nil
+	}
+	slices.Sort(dirs)
+
+	unlock, err := lockRenameDirs(dirs)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	renamed := 0
+	for _, dir := range dirs {
+		journal, journalPath, err := openDirJournal(dir)
+		if err != nil {
+			return renamed, err
+		}
+		taken := map[string]bool{}
+		recorded := 0
+		for _, i := range byDir[dir] {
+			r := &results[i]
+			oldName := filepath.Base(r.Path)
+			newName := fixedName(oldName, r.fileType)
+			newPath := filepath.Join(dir, newName)
+			if _, err := os.Lstat(newPath); err == nil || taken[newName] {
+				fmt.Fprintf(messages, "Warning: Skipping '%s' - target name '%s' already exists\n", r.Path, newName)
+				continue
+			}
+
+			if err := dryrun.Do(fmt.Sprintf("rename '%s' to '%s'", r.Path, newPath), func() error { return os.Rename(r.Path, newPath) }); err != nil {
+				fmt.Fprintf(messages, "Error renaming '%s' to '%s': %v\n", oldName, newName, err)
+				continue
+			}
+			if !dryrun.Enabled() {
+				fmt.Fprintf(messages, "Renamed: '%s' -> '%s'\n", r.Path, newPath)
+			}
+			taken[newName] = true
+			r.RenamedTo = newPath
+			journal.Record(oldName, newName)
+			recorded++
+		}
+		renamed += recorded
+
+		if recorded > 0 {
+			if err := dryrun.Do("write the journal "+journalPath, func() error { return journal.Save(journalPath) }); err != nil {
+				fmt.Fprintf(os.Stderr, style.Warning()+"Failed to write journal: %v\n", err)
+			}
+		}
+	}
+	return renamed, nil
+}
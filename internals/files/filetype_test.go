@@ -0,0 +1,289 @@
+package files
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// sharedSignatures are the types whose magic bytes are the same: a file with them is ambiguous by design
+var sharedSignatures = map[string][]string{
+	"Java class file":         {"Java class file", "Mach-O universal binary"},
+	"Mach-O universal binary": {"Java class file", "Mach-O universal binary"},
+}
+
+// signatureFixture builds the head of a file matching signature: its patterns at their offsets, what a within
+// pattern looks for right after the others, zeros in between
+func signatureFixture(t *testing.T, signature []magicPattern) []byte {
+	t.Helper()
+	var head []byte
+	put := func(at int, b []byte) {
+		if len(head) < at+len(b) {
+			head = append(head, make([]byte, at+len(b)-len(head))...)
+		}
+		copy(head[at:], b)
+	}
+	for _, p := range signature {
+		if p.Within == 0 {
+			put(p.Offset, p.bytes)
+		}
+	}
+	for _, p := range signature {
+		if p.Within > 0 {
+			if len(head)+len(p.bytes) > p.Within {
+				t.Fatalf("no room for %q within %d bytes", p.bytes, p.Within)
+			}
+			put(len(head), p.bytes)
+		}
+	}
+	return head
+}
+
+func typeNames(types []*fileType) []string {
+	var names []string
+	for _, t := range types {
+		names = append(names, t.Name)
+	}
+	return names
+}
+
+// TestFileTypeTable builds a fixture for every signature of the table and checks it is detected as its type
+// alone, so no signature is shadowed by a longer one of another type or ties with one by accident
+func TestFileTypeTable(t *testing.T) {
+	table, err := loadFileTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(table.types) < 50 {
+		t.Fatalf("loaded %d file types", len(table.types))
+	}
+	for _, ft := range table.types {
+		if ft.MIME == "" || strings.ToLower(strings.Join(ft.Extensions, " ")) != strings.Join(ft.Extensions, " ") {
+			t.Errorf("%s: MIME %q, extensions %v", ft.Name, ft.MIME, ft.Extensions)
+		}
+		want := sharedSignatures[ft.Name]
+		if want == nil {
+			want = []string{ft.Name}
+		}
+		for i, signature := range ft.Signatures {
+			head := signatureFixture(t, signature)
+			if len(head) > table.headSize {
+				t.Errorf("%s signature %d: needs %d bytes, gsn filetype reads %d", ft.Name, i, len(head), table.headSize)
+			}
+			if got := typeNames(table.detect(head)); !slices.Equal(got, want) {
+				t.Errorf("%s signature %d (% x) detected as %v, want %v", ft.Name, i, head[:min(len(head), 16)], got, want)
+			}
+			// One byte short of the signature is not enough
+			if got := table.detect(head[:len(head)-1]); slices.ContainsFunc(got, func(d *fileType) bool { return d == ft }) {
+				t.Errorf("%s signature %d matched a truncated head", ft.Name, i)
+			}
+		}
+	}
+}
+
+func TestLoadFileTypesErrors(t *testing.T) {
+	saved := builtinFileTypes
+	t.Cleanup(func() { builtinFileTypes = saved })
+
+	tests := map[string]string{
+		"Bad:\n  extensions: [bad]\n":                                                         "it needs extensions and signatures",
+		"Bad:\n  extensions: [bad]\n  signatures:\n    - []\n":                                "a signature has no pattern",
+		"Bad:\n  extensions: [bad]\n  signatures:\n    - [{offset: 2}]\n":                     "a pattern takes either hex or text",
+		"Bad:\n  extensions: [bad]\n  signatures:\n    - [{hex: 00, text: a}]\n":              "a pattern takes either hex or text",
+		"Bad:\n  extensions: [bad]\n  signatures:\n    - [{hex: zz}]\n":                       "invalid byte",
+		"Bad:\n  extensions: [bad]\n  signatures:\n    - [{offset: 1, within: 8, text: a}]\n": "a pattern takes either offset or within",
+		"- not a map\n": "invalid built-in file types",
+	}
+	for yaml, want := range tests {
+		builtinFileTypes = []byte(yaml)
+		if _, err := loadFileTypes(); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: %v, want %q", yaml, err, want)
+		}
+	}
+}
+
+// fileTypeFixtures are the heads of the files TestDetectFileTypes writes
+var fileTypeFixtures = map[string]string{
+	"photo.png": "89504e470d0a1a0a0000000d49484452",
+	"PHOTO.PNG": "89504e470d0a1a0a0000000d49484452",
+	"scan.txt":  hex.EncodeToString([]byte("%PDF-1.7\n")),
+	"report.docx": "504b0304140000000800" + strings.Repeat("00", 20) +
+		hex.EncodeToString([]byte("word/document.xml")),
+	"archive.bin": "504b030414000000",
+	"book.zip": "504b0304" + strings.Repeat("00", 26) +
+		hex.EncodeToString([]byte("mimetypeapplication/epub+zip")),
+	"Main.class": "cafebabe00000034",
+	"lib.dylib":  "cafebabe00000002",
+	"universal":  "cafebabe00000002",
+	"talk.ogg":   hex.EncodeToString([]byte("OggS")) + strings.Repeat("00", 24) + hex.EncodeToString([]byte("OpusHead")),
+	"notes.md":   hex.EncodeToString([]byte("# Notes\n\nPlain text has no magic bytes.\n")),
+	"empty.pdf":  "",
+	"short.gif":  hex.EncodeToString([]byte("GIF8")),
+	".bashrc":    hex.EncodeToString([]byte("export PATH\n")),
+	"bitmap.bmp": hex.EncodeToString([]byte("BM is how this text starts\n")),
+}
+
+// TestDetectFileTypes reads the files from disk and checks the verdict on each: ok, mismatch, ambiguous when
+// several types share the magic bytes or the type is a generic container, unknown for text, empty and short files
+func TestDetectFileTypes(t *testing.T) {
+	table, err := loadFileTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	files := map[string]string{}
+	var paths []string
+	for name, head := range fileTypeFixtures {
+		data, err := hex.DecodeString(head)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[name] = string(data)
+		paths = append(paths, filepath.Join(dir, name))
+	}
+	writeTree(t, dir, files)
+	slices.Sort(paths)
+
+	tests := map[string]struct {
+		typ, mime, ext, expected, status string
+	}{
+		"photo.png":   {"PNG image", "image/png", "png", "png", typeOK},
+		"PHOTO.PNG":   {"PNG image", "image/png", "png", "png", typeOK},
+		"scan.txt":    {"PDF document", "application/pdf", "txt", "pdf", typeMismatch},
+		"report.docx": {"ZIP archive", "application/zip", "docx", strings.Join(zipExtensions(t, table), ","), typeOK},
+		"archive.bin": {"ZIP archive", "application/zip", "bin", strings.Join(zipExtensions(t, table), ","), typeAmbiguous},
+		// The EPUB signature is longer than the ZIP one, so the .zip extension is wrong
+		"book.zip":   {"EPUB book", "application/epub+zip", "zip", "epub", typeMismatch},
+		"Main.class": {"Java class file", "application/java-vm", "class", "class", typeOK},
+		"lib.dylib":  {"Mach-O universal binary", "application/x-mach-binary", "dylib", "dylib,bundle", typeOK},
+		"universal":  {"Java class file or Mach-O universal binary", "application/java-vm, application/x-mach-binary", "", "class,dylib,bundle", typeAmbiguous},
+		"talk.ogg":   {"Opus audio", "audio/opus", "ogg", "opus,ogg,oga", typeOK},
+		"notes.md":   {"", "", "md", "", typeUnknown},
+		"empty.pdf":  {"", "", "pdf", "", typeUnknown},
+		"short.gif":  {"", "", "gif", "", typeUnknown},
+		".bashrc":    {"", "", "", "", typeUnknown},
+		"bitmap.bmp": {"", "", "bmp", "", typeUnknown},
+	}
+	results := detectFileTypes(paths, table, 3)
+	if len(results) != len(paths) {
+		t.Fatalf("got %d result(s) for %d file(s)", len(results), len(paths))
+	}
+	for i, r := range results {
+		if r.Path != paths[i] {
+			t.Fatalf("result %d is for %s, want %s: the order of the paths is kept", i, r.Path, paths[i])
+		}
+		name := filepath.Base(r.Path)
+		want := tests[name]
+		if r.Type != want.typ || r.MIME != want.mime || r.Extension != want.ext || strings.Join(r.Expected, ",") != want.expected || r.Status != want.status {
+			t.Errorf("%s = %q %q %q %v %s, want %+v", name, r.Type, r.MIME, r.Extension, r.Expected, r.Status, want)
+		}
+		// Only a mismatch names the type --fix-ext renames to
+		if (r.fileType != nil) != (r.Status == typeMismatch) {
+			t.Errorf("%s: %s with a type to rename to: %v", name, r.Status, r.fileType != nil)
+		}
+	}
+
+	// A file that cannot be read is left out with a warning
+	missing := filepath.Join(dir, "missing.png")
+	stderr := captureStderr(t, func() {
+		results = detectFileTypes([]string{paths[0], missing, paths[1]}, table, 2)
+	})
+	if len(results) != 2 || results[0].Path != paths[0] || results[1].Path != paths[1] || !strings.Contains(stderr, "Skipping '"+missing+"'") {
+		t.Errorf("with a missing file = %v, stderr %q", results, stderr)
+	}
+}
+
+func zipExtensions(t *testing.T, table fileTypeTable) []string {
+	t.Helper()
+	for _, ft := range table.types {
+		if ft.Name == "ZIP archive" {
+			return ft.Extensions
+		}
+	}
+	t.Fatal("no ZIP archive in the table")
+	return nil
+}
+
+func TestFixedName(t *testing.T) {
+	pdf := &fileType{Name: "PDF document", Extensions: []string{"pdf"}}
+	tar := &fileType{Name: "tar archive", Extensions: []string{"tar"}}
+	jpeg := &fileType{Name: "JPEG image", Extensions: []string{"jpg", "jpeg"}}
+	tests := []struct {
+		name string
+		t    *fileType
+		want string
+	}{
+		{"scan.txt", pdf, "scan.pdf"},
+		{"scan", pdf, "scan.pdf"},
+		{"SCAN.TXT", pdf, "SCAN.pdf"},
+		{"backup.tar.gz", tar, "backup.tar"},
+		{"backup.tgz", tar, "backup.tar"},
+		{"photo.jpeg.png", jpeg, "photo.jpeg.jpg"},
+		{".bashrc", pdf, ".bashrc.pdf"},
+		{"v1.2", tar, "v1.tar"},
+	}
+	for _, test := range tests {
+		if got := fixedName(test.name, test.t); got != test.want {
+			t.Errorf("fixedName(%q, %s) = %q, want %q", test.name, test.t.Name, got, test.want)
+		}
+	}
+}
+
+// TestFixExtensions renames the mismatched files only, skips one whose new name is taken and journals the move
+func TestFixExtensions(t *testing.T) {
+	useGSNHome(t, t.TempDir())
+	table, err := loadFileTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	png, _ := hex.DecodeString(fileTypeFixtures["photo.png"])
+	zip, _ := hex.DecodeString(fileTypeFixtures["archive.bin"])
+	writeTree(t, dir, map[string]string{
+		"scan.txt":    "%PDF-1.7\n",
+		"clip.txt":    string(png),
+		"clip.png":    string(png),
+		"archive.bin": string(zip),
+		"notes.md":    "# Notes\n",
+	})
+	var paths []string
+	for _, name := range []string{"scan.txt", "clip.txt", "archive.bin", "notes.md"} {
+		paths = append(paths, filepath.Join(dir, name))
+	}
+	results := detectFileTypes(paths, table, 1)
+
+	var messages bytes.Buffer
+	renamed, err := fixExtensions(results, &messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renamed != 1 {
+		t.Errorf("renamed %d file(s), want 1\n%s", renamed, messages.String())
+	}
+	if results[0].RenamedTo != filepath.Join(dir, "scan.pdf") || results[1].RenamedTo != "" {
+		t.Errorf("renamed to %q and %q", results[0].RenamedTo, results[1].RenamedTo)
+	}
+	if !strings.Contains(messages.String(), "Skipping '"+paths[1]+"' - target name 'clip.png' already exists") {
+		t.Errorf("messages =\n%s", messages.String())
+	}
+	var left []string
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		left = append(left, e.Name())
+	}
+	if want := []string{renameJournalName, "archive.bin", "clip.png", "clip.txt", "notes.md", "scan.pdf"}; !slices.Equal(left, want) {
+		t.Errorf("directory holds %v, want %v", left, want)
+	}
+	journal, err := loadJournal(filepath.Join(dir, renameJournalName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(journal.Entries); got != "[{scan.txt scan.pdf}]" {
+		t.Errorf("journal entries = %s", got)
+	}
+}
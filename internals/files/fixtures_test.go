@@ -0,0 +1,208 @@
+package files
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fixtureEntry is an entry of a tar fixture, a regular file unless Type says otherwise
+type fixtureEntry struct {
+	Name string
+	Body string
+	Type byte
+	Link string
+	Mode int64
+}
+
+// fixtureTime is the modification time of every fixture entry
+var fixtureTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// writeFixtureTar writes entries to a tar archive at path, gzip compressed when path ends in .gz
+func writeFixtureTar(t *testing.T, path string, entries []fixtureEntry) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var out io.Writer = file
+	if strings.HasSuffix(path, ".gz") {
+		gz := gzip.NewWriter(file)
+		defer gz.Close()
+		out = gz
+	}
+	tw := tar.NewWriter(out)
+	defer tw.Close()
+	for _, e := range entries {
+		writeFixtureEntry(t, tw, e)
+	}
+}
+
+func writeFixtureEntry(t *testing.T, tw *tar.Writer, e fixtureEntry) {
+	t.Helper()
+	header := fixtureHeader(e)
+	if err := tw.WriteHeader(header); err != nil {
+		t.Fatal(err)
+	}
+	if header.Size > 0 {
+		if _, err := io.WriteString(tw, e.Body); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// fixtureHeader is the tar header of a fixture entry, with the default type and mode filled in
+func fixtureHeader(e fixtureEntry) *tar.Header {
+	header := &tar.Header{Name: e.Name, Typeflag: e.Type, Linkname: e.Link, Mode: e.Mode, ModTime: fixtureTime, Format: tar.FormatPAX}
+	if header.Typeflag == 0 {
+		header.Typeflag = tar.TypeReg
+	}
+	if header.Mode == 0 {
+		header.Mode = 0o644
+		if header.Typeflag == tar.TypeDir {
+			header.Mode = 0o755
+		}
+	}
+	if header.Typeflag == tar.TypeReg {
+		header.Size = int64(len(e.Body))
+	}
+	return header
+}
+
+// writeFixtureArchive writes entries to an archive of any format gsn writes, the single entry of a gz or zst
+func writeFixtureArchive(t *testing.T, path string, format archiveFormat, entries []fixtureEntry) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if format.Container == containerNone {
+		if len(entries) != 1 {
+			t.Fatalf("a %s file holds a single entry, got %d", format, len(entries))
+		}
+		w, err := newCodecWriter(file, format.Codec, memoryBudget{})
+		if err == nil {
+			_, err = io.WriteString(w, entries[0].Body)
+		}
+		if err == nil {
+			err = w.Close()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	w, err := createArchive(file, format, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		header := &tar.Header{Name: e.Name, Typeflag: e.Type, Linkname: e.Link, Mode: e.Mode, ModTime: fixtureTime, Format: tar.FormatPAX}
+		if header.Typeflag == tar.TypeReg {
+			header.Size = int64(len(e.Body))
+		}
+		if err := w.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Size > 0 {
+			if _, err := io.WriteString(w, e.Body); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readFixtureTar lists the entries of an archive gsn can open, with the content of its regular files
+func readFixtureTar(t *testing.T, path string) []fixtureEntry {
+	t.Helper()
+	tr, err := openArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	var entries []fixtureEntry
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, fixtureEntry{Name: header.Name, Body: string(body), Type: header.Typeflag, Link: header.Linkname, Mode: header.Mode})
+	}
+}
+
+// writeTree creates files below root, a name ending in / is a directory
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, body := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if strings.HasSuffix(name, "/") {
+			if err := os.MkdirAll(path, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// captureStdout runs f with os.Stdout sent to a temp file and returns what f wrote
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	return captureFile(t, &os.Stdout, f)
+}
+
+// captureStderr runs f with os.Stderr sent to a temp file and returns what f wrote
+func captureStderr(t *testing.T, f func()) string {
+	t.Helper()
+	return captureFile(t, &os.Stderr, f)
+}
+
+func captureFile(t *testing.T, std **os.File, f func()) string {
+	t.Helper()
+	file, err := os.CreateTemp(t.TempDir(), "output-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	saved := *std
+	*std = file
+	defer func() { *std = saved }()
+
+	f()
+
+	data, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// testExtractPolicies returns a conflict resolver and permission policy with the defaults of gsn extract
+func testExtractPolicies(destDir string) (*conflictResolver, *permissionPolicy) {
+	return newConflictResolver(conflictOverwrite, destDir), &permissionPolicy{UID: -1, GID: -1, umask: 0o022}
+}
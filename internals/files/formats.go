@@ -0,0 +1,572 @@
+package files
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gsn-dev-tools/internals/clierr"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// container is how an archive lays out its entries
+type container string
+
+const (
+	containerTar container = "tar"
+	containerZip container = "zip"
+	// containerNone is a single compressed file without entries, as written by gzip
+	containerNone container = "none"
+)
+
+// codec is the compression applied to the whole stream of a container
+type codec string
+
+const (
+	codecNone  codec = "none"
+	codecGzip  codec = "gzip"
+	codecBzip2 codec = "bzip2"
+	codecZstd  codec = "zstd"
+)
+
+// archiveFormat combines a container with the codec compressing it
+type archiveFormat struct {
+	Container container
+	Codec     codec
+}
+
+var (
+	formatTar    = archiveFormat{containerTar, codecNone}
+	formatTarGz  = archiveFormat{containerTar, codecGzip}
+	formatTarBz2 = archiveFormat{containerTar, codecBzip2}
+	formatTarZst = archiveFormat{containerTar, codecZstd}
+	formatZip    = archiveFormat{containerZip, codecNone}
+	formatGz     = archiveFormat{containerNone, codecGzip}
+	formatBz2    = archiveFormat{containerNone, codecBzip2}
+	formatZst    = archiveFormat{containerNone, codecZstd}
+)
+
+// formatNames names every supported format, the name is also its file extension
+var formatNames = map[archiveFormat]string{
+	formatTar:    "tar",
+	formatTarGz:  "tar.gz",
+	formatTarBz2: "tar.bz2",
+	formatTarZst: "tar.zst",
+	formatZip:    "zip",
+	formatGz:     "gz",
+	formatBz2:    "bz2",
+	formatZst:    "zst",
+}
+
+// writableFormats lists the formats that can be produced, bzip2 has no writer in the standard library
+var writableFormats = []archiveFormat{formatTar, formatTarGz, formatTarZst, formatZip, formatGz, formatZst}
+
+func (f archiveFormat) String() string {
+	if name, ok := formatNames[f]; ok {
+		return name
+	}
+	return fmt.Sprintf("%s/%s", f.Container, f.Codec)
+}
+
+// Extension returns the file name suffix used for archives of this format
+func (f archiveFormat) Extension() string {
+	return "." + f.String()
+}
+
+// parseFormat validates a user supplied format name
+func parseFormat(name string) (archiveFormat, error) {
+	name = strings.TrimPrefix(strings.ToLower(name), ".")
+	switch name {
+	case "tgz":
+		name = formatTarGz.String()
+	case "tzst":
+		name = formatTarZst.String()
+	}
+
+	for _, f := range writableFormats {
+		if f.String() == name {
+			return f, nil
+		}
+	}
+	return archiveFormat{}, clierr.Newf(clierr.Usage, "unsupported archive format '%s'", name)
+}
+
+// archiveExtensions are the suffixes of archive names, longest first so .tar.gz wins over .gz
+var archiveExtensions = []string{".tar.gz", ".tgz", ".tar.bz2", ".tar.zst", ".tzst", ".tar", ".zip", ".gz", ".bz2", ".zst", ".deb", ".rpm"}
+
+// trimArchiveExt removes a known archive suffix from a file name
+func trimArchiveExt(name string) string {
+	for _, ext := range archiveExtensions {
+		if strings.HasSuffix(strings.ToLower(name), ext) {
+			return name[:len(name)-len(ext)]
+		}
+	}
+	return name
+}
+
+// formatOfName returns the writable format named by the extension of an archive file name
+func formatOfName(name string) (archiveFormat, bool) {
+	for _, ext := range archiveExtensions {
+		if strings.HasSuffix(strings.ToLower(name), ext) {
+			format, err := parseFormat(ext)
+			return format, err == nil
+		}
+	}
+	return archiveFormat{}, false
+}
+
+// hasTarExt reports whether the name says the archive holds a tar stream
+func hasTarExt(name string) bool {
+	lower := strings.ToLower(name)
+	for _, ext := range []string{".tar", ".tar.gz", ".tgz", ".tar.bz2", ".tar.zst", ".tzst"} {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// entryReader iterates the entries of an archive, exposing tar headers regardless of the source format
+type entryReader interface {
+	Next() (*tar.Header, error)
+	io.Reader
+	io.Closer
+}
+
+// entryWriter appends entries described by tar headers to an archive
+type entryWriter interface {
+	WriteHeader(hdr *tar.Header) error
+	io.Writer
+	io.Closer
+}
+
+// detectCodec sniffs the codec of a stream from its leading magic bytes, zip archives have their own
+func detectCodec(br *bufio.Reader) (codec, bool) {
+	magic, _ := br.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")), bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		return codecNone, true
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return codecGzip, false
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return codecBzip2, false
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return codecZstd, false
+	default:
+		return codecNone, false
+	}
+}
+
+// isTarStream reports whether the decompressed stream starts with a ustar header
+func isTarStream(br *bufio.Reader) bool {
+	block, _ := br.Peek(512)
+	return len(block) == 512 && bytes.HasPrefix(block[257:], []byte("ustar"))
+}
+
+// newCodecReader decompresses r, the closer releases the decoder
+func newCodecReader(r io.Reader, c codec) (io.Reader, io.Closer, error) {
+	switch c {
+	case codecGzip:
+		gzReader, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open gzip stream: %w", err)
+		}
+		return gzReader, gzReader, nil
+	case codecBzip2:
+		return bzip2.NewReader(r), io.NopCloser(nil), nil
+	case codecZstd:
+		zstReader, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open zstd stream: %w", err)
+		}
+		return zstReader, zstReader.IOReadCloser(), nil
+	default:
+		return r, io.NopCloser(nil), nil
+	}
+}
+
+// newCodecWriter compresses what is written into out, closing it flushes the codec but not out. The budget
+// sizes the buffers of codecs that have a choice.
+func newCodecWriter(out io.Writer, c codec, budget memoryBudget) (io.WriteCloser, error) {
+	switch c {
+	case codecGzip:
+		return gzip.NewWriter(out), nil
+	case codecZstd:
+		zstWriter, err := zstd.NewWriter(out, budget.zstdOptions()...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd stream: %w", err)
+		}
+		return zstWriter, nil
+	case codecNone:
+		return nopWriteCloser{out}, nil
+	default:
+		return nil, fmt.Errorf("cannot write %s streams", c)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// openArchive opens an archive of any supported format: the codec is detected by its magic bytes, and a
+// compressed stream without a tar header and without a tar extension is a single compressed file. Debian and RPM
+// packages and docker save tarballs are recognized by their magic bytes and first entry too, and list the
+// entries of the archives nested in them. An archive encrypted with age is decrypted first, it always holds a tar
+// stream since cmp encrypts nothing else.
+func openArchive(archivePath string) (entryReader, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(file)
+	encrypted := isEncrypted(br)
+	if encrypted {
+		decrypted, err := decryptArchive(br, archivePath)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		br = bufio.NewReader(decrypted)
+	}
+	if !encrypted {
+		if pkg, err := openPackage(file, br); pkg != nil || err != nil {
+			if err != nil {
+				file.Close()
+			}
+			return pkg, err
+		}
+	}
+	c, isZip := detectCodec(br)
+	if isZip {
+		if encrypted {
+			file.Close()
+			return nil, fmt.Errorf("'%s' holds an encrypted zip, decrypt it with age first", archivePath)
+		}
+		return newZipEntryReader(file)
+	}
+
+	stream, decoder, err := newCodecReader(br, c)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	closers := []io.Closer{decoder, file}
+
+	decoded := bufio.NewReader(stream)
+	if c == codecNone || encrypted || isTarStream(decoded) || hasTarExt(archivePath) {
+		if !encrypted && isDockerSaveCandidate(decoded) {
+			if image, err := openDockerSave(archivePath, c); image != nil || err != nil {
+				decoder.Close()
+				file.Close()
+				return image, err
+			}
+		}
+		return &tarEntryReader{Reader: tar.NewReader(decoded), closers: closers}, nil
+	}
+	single, err := newSingleEntryReader(file, archivePath, stream, decoded, c, closers)
+	if err != nil {
+		decoder.Close()
+		file.Close()
+		return nil, err
+	}
+	return single, nil
+}
+
+// createArchive wraps out in a writer producing the given format. A zip stores the entries of the stored types
+// as they are, nil deflates every file.
+func createArchive(out io.Writer, format archiveFormat, stored *storedTypes) (entryWriter, error) {
+	switch format.Container {
+	case containerTar:
+		codecWriter, err := newCodecWriter(out, format.Codec, memoryBudget{})
+		if err != nil {
+			return nil, err
+		}
+		return &tarEntryWriter{Writer: tar.NewWriter(codecWriter), codec: codecWriter}, nil
+	case containerZip:
+		return &zipEntryWriter{zw: zip.NewWriter(out), stored: stored}, nil
+	default:
+		return nil, fmt.Errorf("cannot write archives in format '%s', it holds a single file", format)
+	}
+}
+
+// singleEntryReader exposes a single compressed file, like file.txt.gz, as an archive of one entry named
+// after the original file
+type singleEntryReader struct {
+	header  *tar.Header
+	stream  io.Reader
+	done    bool
+	closers []io.Closer
+}
+
+func newSingleEntryReader(file *os.File, archivePath string, stream io.Reader, decoded io.Reader, c codec, closers []io.Closer) (*singleEntryReader, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     trimArchiveExt(filepath.Base(archivePath)),
+		Mode:     0o644,
+		ModTime:  info.ModTime(),
+		Uid:      os.Getuid(),
+		Gid:      os.Getgid(),
+		Format:   tar.FormatPAX,
+	}
+	switch c {
+	case codecGzip:
+		gz := stream.(*gzip.Reader)
+		if name := filepath.Base(gz.Name); gz.Name != "" && filepath.IsLocal(name) {
+			header.Name = name
+		}
+		if !gz.ModTime.IsZero() {
+			header.ModTime = gz.ModTime
+		}
+		// The trailer holds the size modulo 4 GiB
+		var trailer [4]byte
+		if _, err := file.ReadAt(trailer[:], info.Size()-4); err == nil {
+			header.Size = int64(binary.LittleEndian.Uint32(trailer[:]))
+		}
+	case codecZstd:
+		var frame zstd.Header
+		start := make([]byte, zstd.HeaderMaxSize)
+		if n, _ := file.ReadAt(start, 0); frame.Decode(start[:n]) == nil && frame.HasFCS {
+			header.Size = int64(frame.FrameContentSize)
+		} else if header.Size, err = decodedSize(archivePath, c); err != nil {
+			return nil, err
+		}
+	case codecBzip2:
+		if header.Size, err = decodedSize(archivePath, c); err != nil {
+			return nil, err
+		}
+	}
+	return &singleEntryReader{header: header, stream: decoded, closers: closers}, nil
+}
+
+// decodedSize decompresses a file once to learn the size of its content, for codecs that may not record it
+func decodedSize(path string, c codec) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	stream, decoder, err := newCodecReader(bufio.NewReader(file), c)
+	if err != nil {
+		return 0, err
+	}
+	defer decoder.Close()
+	return io.Copy(io.Discard, stream)
+}
+
+func (r *singleEntryReader) Next() (*tar.Header, error) {
+	if r.done {
+		return nil, io.EOF
+	}
+	r.done = true
+	return r.header, nil
+}
+
+func (r *singleEntryReader) Read(p []byte) (int, error) {
+	if !r.done {
+		return 0, io.EOF
+	}
+	return r.stream.Read(p)
+}
+
+func (r *singleEntryReader) Close() error {
+	var firstErr error
+	for _, c := range r.closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// tarEntryReader reads a tar stream and closes the underlying codec and file together
+type tarEntryReader struct {
+	*tar.Reader
+	closers []io.Closer
+}
+
+func (r *tarEntryReader) Close() error {
+	var firstErr error
+	for _, c := range r.closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// tarEntryWriter writes a tar stream and flushes the optional codec on close
+type tarEntryWriter struct {
+	*tar.Writer
+	codec io.Closer
+}
+
+func (w *tarEntryWriter) Close() error {
+	if err := w.Writer.Close(); err != nil {
+		return err
+	}
+	if w.codec != nil {
+		return w.codec.Close()
+	}
+	return nil
+}
+
+// zipEntryReader exposes zip members as tar headers.
+// Zip has no ownership metadata, so entries are attributed to the invoking user's uid/gid.
+type zipEntryReader struct {
+	file    *os.File
+	zr      *zip.Reader
+	index   int
+	current io.ReadCloser
+}
+
+func newZipEntryReader(file *os.File) (*zipEntryReader, error) {
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	zr, err := zip.NewReader(file, info.Size())
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to open zip archive: %w", err)
+	}
+	return &zipEntryReader{file: file, zr: zr}, nil
+}
+
+func (r *zipEntryReader) Next() (*tar.Header, error) {
+	if r.current != nil {
+		r.current.Close()
+		r.current = nil
+	}
+	if r.index >= len(r.zr.File) {
+		return nil, io.EOF
+	}
+
+	f := r.zr.File[r.index]
+	r.index++
+
+	mode := f.Mode()
+	header := &tar.Header{
+		Name:    f.Name,
+		Mode:    int64(mode.Perm()),
+		ModTime: f.Modified,
+		Uid:     os.Getuid(),
+		Gid:     os.Getgid(),
+		Format:  tar.FormatPAX,
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open zip entry '%s': %w", f.Name, err)
+	}
+
+	switch {
+	case mode.IsDir():
+		header.Typeflag = tar.TypeDir
+		rc.Close()
+		r.current = io.NopCloser(bytes.NewReader(nil))
+	case mode&fs.ModeSymlink != 0:
+		target, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		header.Typeflag = tar.TypeSymlink
+		header.Linkname = string(target)
+		r.current = io.NopCloser(bytes.NewReader(nil))
+	default:
+		header.Typeflag = tar.TypeReg
+		header.Size = int64(f.UncompressedSize64)
+		r.current = rc
+	}
+
+	return header, nil
+}
+
+func (r *zipEntryReader) Read(p []byte) (int, error) {
+	if r.current == nil {
+		return 0, io.EOF
+	}
+	return r.current.Read(p)
+}
+
+func (r *zipEntryReader) Close() error {
+	if r.current != nil {
+		r.current.Close()
+	}
+	return r.file.Close()
+}
+
+// zipEntryWriter maps tar headers onto zip members, ownership metadata is dropped
+type zipEntryWriter struct {
+	zw      *zip.Writer
+	current io.Writer
+	// stored lists the types written with the store method instead of deflate, nil deflates every file
+	stored *storedTypes
+}
+
+func (w *zipEntryWriter) WriteHeader(hdr *tar.Header) error {
+	info := hdr.FileInfo()
+	fh, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	fh.Name = hdr.Name
+	fh.Method = zip.Deflate
+	if w.stored.stores(hdr.Name) {
+		fh.Method = zip.Store
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		fh.Name = strings.TrimSuffix(fh.Name, "/") + "/"
+		fh.Method = zip.Store
+	case tar.TypeSymlink:
+		fh.Method = zip.Store
+	}
+
+	writer, err := w.zw.CreateHeader(fh)
+	if err != nil {
+		return err
+	}
+	w.current = writer
+
+	if hdr.Typeflag == tar.TypeSymlink {
+		_, err = io.WriteString(writer, hdr.Linkname)
+	}
+	return err
+}
+
+func (w *zipEntryWriter) Write(p []byte) (int, error) {
+	if w.current == nil {
+		return 0, fmt.Errorf("zip: write before header")
+	}
+	return w.current.Write(p)
+}
+
+func (w *zipEntryWriter) Close() error {
+	return w.zw.Close()
+}
@@ -0,0 +1,277 @@
+package files
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/schollz/progressbar/v3"
+)
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		want archiveFormat
+	}{
+		{"tar", formatTar},
+		{"tar.gz", formatTarGz},
+		{".TGZ", formatTarGz},
+		{"tzst", formatTarZst},
+		{"zip", formatZip},
+		{"gz", formatGz},
+		{"zst", formatZst},
+	}
+	for _, test := range tests {
+		if got, err := parseFormat(test.name); err != nil || got != test.want {
+			t.Errorf("parseFormat(%q) = %v, %v, want %v", test.name, got, err, test.want)
+		}
+	}
+	// bzip2 can be read but not written
+	for _, name := range []string{"tar.bz2", "bz2", "rar", ""} {
+		if _, err := parseFormat(name); clierr.CodeOf(err) != clierr.Usage {
+			t.Errorf("parseFormat(%q) = %v, want a usage error", name, err)
+		}
+	}
+
+	names := map[string]archiveFormat{"a.tar.gz": formatTarGz, "a.TAR": formatTar, "notes.txt.gz": formatGz, "a.tzst": formatTarZst}
+	for name, want := range names {
+		if got, ok := formatOfName(name); !ok || got != want {
+			t.Errorf("formatOfName(%q) = %v, %v, want %v", name, got, ok, want)
+		}
+	}
+	if _, ok := formatOfName("a.tar.bz2"); ok {
+		t.Error("formatOfName accepted a format it cannot write")
+	}
+}
+
+// openStdlib decompresses an archive gsn wrote with the standard library and klauspost readers, not with gsn
+func openStdlib(t *testing.T, path string, c codec) io.Reader {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	switch c {
+	case codecGzip:
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return gz
+	case codecZstd:
+		dec, err := zstd.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(dec.Close)
+		return dec
+	}
+	return bytes.NewReader(data)
+}
+
+func TestCompressTarFormatsRoundTrip(t *testing.T) {
+	files := map[string]string{
+		"project/README.md":      "# project\n",
+		"project/media/clip.mp4": strings.Repeat("\x00\x01frame", 4096),
+		"project/empty":          "",
+	}
+	magic := map[archiveFormat][]byte{formatTarGz: {0x1f, 0x8b}, formatTarZst: {0x28, 0xb5, 0x2f, 0xfd}}
+
+	for _, format := range []archiveFormat{formatTar, formatTarGz, formatTarZst} {
+		t.Run(format.String(), func(t *testing.T) {
+			dir := t.TempDir()
+			writeTree(t, dir, files)
+			archive := filepath.Join(dir, "project"+format.Extension())
+			opts := compressOptions{Format: format, SkipSpaceCheck: true, Progress: progressbar.DefaultBytesSilent(-1)}
+			result, err := compressPath(filepath.Join(dir, "project"), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.ArchivePath != archive || result.FileCount != 3 {
+				t.Errorf("result = %+v, want %s holding 3 files", result, archive)
+			}
+
+			data, _ := os.ReadFile(archive)
+			if want := magic[format]; want != nil && !bytes.HasPrefix(data, want) {
+				t.Errorf("%s starts with % x", format, data[:4])
+			}
+			if format == formatTar && (len(data) < 512 || !bytes.HasPrefix(data[257:], []byte("ustar"))) {
+				t.Error("--format tar did not write a plain tar stream")
+			}
+
+			// Other tools read what gsn writes
+			tr := tar.NewReader(openStdlib(t, archive, format.Codec))
+			got := make(map[string]string)
+			for {
+				header, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if header.Typeflag == tar.TypeReg {
+					body, _ := io.ReadAll(tr)
+					got[header.Name] = string(body)
+				}
+			}
+			for name, body := range files {
+				if got[name] != body {
+					t.Errorf("the %s holds %q for %s", format, got[name], name)
+				}
+			}
+
+			dest := t.TempDir()
+			conflicts, perms := testExtractPolicies(dest)
+			if _, err := extractAll(archive, dest, conflicts, perms, nil); err != nil {
+				t.Fatal(err)
+			}
+			checkFiles(t, dest, files)
+		})
+	}
+}
+
+func TestCompressSingleFile(t *testing.T) {
+	content := strings.Repeat("remember the milk\n", 1000)
+	modTime := time.Date(2024, 3, 9, 8, 30, 0, 0, time.UTC)
+
+	for _, format := range []archiveFormat{formatGz, formatZst} {
+		t.Run(format.String(), func(t *testing.T) {
+			dir := t.TempDir()
+			source := filepath.Join(dir, "notes.txt")
+			writeTree(t, dir, map[string]string{"notes.txt": content})
+			if err := os.Chtimes(source, modTime, modTime); err != nil {
+				t.Fatal(err)
+			}
+
+			opts := compressOptions{Format: format, SkipSpaceCheck: true, Progress: progressbar.DefaultBytesSilent(-1)}
+			result, err := compressPath(source, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			archive := source + format.Extension()
+			if result.ArchivePath != archive || result.FileCount != 1 || result.SourceSize != int64(len(content)) {
+				t.Errorf("result = %+v", result)
+			}
+
+			// The stream holds the file itself, as gzip and zstd write it, without a tar layer
+			stream := openStdlib(t, archive, format.Codec)
+			if body, err := io.ReadAll(stream); err != nil || string(body) != content {
+				t.Errorf("decompressed %d bytes, %v, want the %d bytes of the file", len(body), err, len(content))
+			}
+			if gz, ok := stream.(*gzip.Reader); ok && (gz.Name != "notes.txt" || !gz.ModTime.Equal(modTime)) {
+				t.Errorf("gzip header names %q at %v", gz.Name, gz.ModTime)
+			}
+			if format == formatZst {
+				var frame zstd.Header
+				data, _ := os.ReadFile(archive)
+				if err := frame.Decode(data); err != nil || !frame.HasFCS || frame.FrameContentSize != uint64(len(content)) {
+					t.Errorf("zstd frame = %+v, %v, want the content size", frame, err)
+				}
+			}
+
+			entries := readFixtureTar(t, archive)
+			if len(entries) != 1 || entries[0].Name != "notes.txt" || entries[0].Body != content {
+				t.Errorf("gsn reads %d entries, first %q", len(entries), entries[0].Name)
+			}
+			dest := t.TempDir()
+			conflicts, perms := testExtractPolicies(dest)
+			if n, err := extractAll(archive, dest, conflicts, perms, nil); err != nil || n != 1 {
+				t.Fatalf("extractAll = %d, %v", n, err)
+			}
+			checkFiles(t, dest, map[string]string{"notes.txt": content})
+		})
+	}
+}
+
+// TestSingleFilesOfOtherTools reads compressed files gsn did not write
+func TestSingleFilesOfOtherTools(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, gzName string, content []byte) string {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Name = gzName
+		gz.Write(content)
+		gz.Close()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	var tarStream bytes.Buffer
+	tw := tar.NewWriter(&tarStream)
+	writeFixtureEntry(t, tw, fixtureEntry{Name: "inside.txt", Body: "inside\n"})
+	tw.Close()
+
+	tests := []struct {
+		path string
+		want []string
+	}{
+		// gzip -n leaves the name out, the entry is named after the file
+		{write("report.csv.gz", "", []byte("a,b\n")), []string{"report.csv"}},
+		{write("download.gz", "original.csv", []byte("a,b\n")), []string{"original.csv"}},
+		// Only the base of a recorded name is used, it cannot leave the destination
+		{write("escape.txt.gz", "../../etc/passwd", []byte("x")), []string{"passwd"}},
+		// A tar stream is read as a tar even without a .tar name
+		{write("bundle.gz", "", tarStream.Bytes()), []string{"inside.txt"}},
+	}
+	for _, test := range tests {
+		var names []string
+		for _, e := range readFixtureTar(t, test.path) {
+			names = append(names, e.Name)
+		}
+		if strings.Join(names, " ") != strings.Join(test.want, " ") {
+			t.Errorf("%s holds %q, want %q", filepath.Base(test.path), names, test.want)
+		}
+	}
+
+	// The size of the entry comes from the gzip trailer
+	r, err := openArchive(tests[1].path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if header, err := r.Next(); err != nil || header.Size != 4 {
+		t.Errorf("entry = %+v, %v, want a size of 4", header, err)
+	}
+}
+
+func TestSingleFileFormatRejections(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"project/a.txt": "a", "notes.txt": "n"})
+	silent := progressbar.DefaultBytesSilent(-1)
+
+	tests := []struct {
+		path    string
+		opts    compressOptions
+		wantErr string
+	}{
+		{"project", compressOptions{Format: formatGz}, "--format gz compresses a single file, use tar.gz for a directory"},
+		{"notes.txt", compressOptions{Format: formatZst, EmbedManifest: true}, "--embed-manifest needs a tar archive"},
+		{"notes.txt", compressOptions{Format: formatGz, Sparse: true}, "--sparse needs a tar archive"},
+		{"project", compressOptions{Format: formatZip, Sparse: true}, "zip has no sparse members"},
+	}
+	for _, test := range tests {
+		test.opts.SkipSpaceCheck, test.opts.Progress = true, silent
+		_, err := compressPath(filepath.Join(dir, test.path), test.opts)
+		if clierr.CodeOf(err) != clierr.Usage || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("compressPath(%s, %s) = %v, want %q", test.path, test.opts.Format, err, test.wantErr)
+		}
+	}
+	for _, name := range []string{"project.gz", "project.zip"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("a refused format left %s behind", name)
+		}
+	}
+}
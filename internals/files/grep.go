@@ -0,0 +1,229 @@
+package files
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/dryrun"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// sniffSize is how much of an entry is looked at for a NUL byte to tell binary contents apart, as git and
+	// grep do
+	sniffSize = 8000
+
+	// maxGrepLine caps the part of a line that is matched and printed, the rest of a longer line is skipped so a
+	// file without newlines does not have to fit in memory
+	maxGrepLine = 1 << 20
+)
+
+func GrepArchiveCmd() *cobra.Command {
+	grepCmd := &cobra.Command{
+		Use:   "grep <pattern> <archive>",
+		Short: "Searches the file contents of an archive without extracting it",
+		Long: `Streams the archive and prints every line of its files matching the regular expression (Go syntax) as
+entry:line: text. Nothing is written to disk, and files are read line by line so large entries do not have to
+fit in memory; only the first 1 MiB of a longer line is matched. Binary files, those with a NUL byte in their
+first 8000 bytes, are reported once when they match instead of being printed. Exits with 1 when nothing matches.
+
+--max-depth N only searches the files N levels down into the archive at most, counting the archive itself as
+level 0 like cmp --max-depth counts the source; --top-level-only is --max-depth 1. An archive written by cmp
+keeps everything below the directory it was made of, so its files start at level 2.`,
+		Example: `  gsn cmp grep TODO project.tar.gz
+  gsn cmp grep -i 'api[_-]?key' backup.tar.zst --include '*.go' --exclude vendor
+  gsn cmp grep -F 'panic(' project.zip --max-matches 10
+  gsn cmp grep version dotfiles.tar.gz --max-depth 2`,
+		Args: cobra.ExactArgs(2),
+		Run:  GrepArchive,
+	}
+
+	grepCmd.Flags().BoolP("ignore-case", "i", false, "Match without regard to case")
+	grepCmd.Flags().BoolP("fixed-strings", "F", false, "Match the pattern as a literal string instead of a regular expression")
+	grepCmd.Flags().StringSlice("include", nil, "Only search entries whose name or path matches this glob (repeatable)")
+	grepCmd.Flags().StringSlice("exclude", nil, "Skip entries whose name, path or a parent directory matches this glob (repeatable)")
+	grepCmd.Flags().Int("max-matches", 0, "Stop reading the archive after this many matches, 0 for no limit")
+	grepCmd.Flags().Int("max-depth", unlimitedDepth, "Only search files this many levels down into the archive at most, its top level is 1")
+	grepCmd.Flags().Bool("top-level-only", false, "Only search the files at the top of the archive, like --max-depth 1")
+	grepCmd.MarkFlagsMutuallyExclusive("max-depth", "top-level-only")
+	dryrun.ReadOnly(grepCmd)
+	return grepCmd
+}
+
+func GrepArchive(cmd *cobra.Command, args []string) {
+	pattern, archivePath := args[0], args[1]
+	ignoreCase, _ := cmd.Flags().GetBool("ignore-case")
+	fixed, _ := cmd.Flags().GetBool("fixed-strings")
+	includes, _ := cmd.Flags().GetStringSlice("include")
+	excludes, _ := cmd.Flags().GetStringSlice("exclude")
+	maxMatches, _ := cmd.Flags().GetInt("max-matches")
+
+	if maxMatches < 0 {
+		clierr.Exitf(clierr.Usage, "--max-matches cannot be negative")
+	}
+	maxDepth, err := depthFromFlags(cmd)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	if maxDepth == 0 {
+		clierr.Exitf(clierr.Usage, "--max-depth 0 leaves no file to search, the top of the archive is level 1")
+	}
+	for _, glob := range slices.Concat(includes, excludes) {
+		if _, err := path.Match(glob, ""); err != nil {
+			clierr.Exitf(clierr.Usage, "invalid pattern '%s': %v", glob, err)
+		}
+	}
+	if fixed {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		clierr.Exitf(clierr.Usage, "invalid pattern: %v", err)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	g := &archiveGrep{re: re, includes: includes, excludes: excludes, maxMatches: maxMatches, maxDepth: maxDepth, out: out}
+	err = g.run(archivePath)
+	if flushErr := out.Flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	if g.matches == 0 {
+		clierr.Exit(clierr.Failure)
+	}
+}
+
+// archiveGrep matches the lines of the files of an archive against re
+type archiveGrep struct {
+	re         *regexp.Regexp
+	includes   []string
+	excludes   []string
+	maxMatches int
+	// maxDepth is the deepest level searched, the top of the archive is level 1
+	maxDepth int
+	out      io.Writer
+
+	matches int
+}
+
+func (g *archiveGrep) run(archivePath string) error {
+	r, err := openArchive(archivePath)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	br := bufio.NewReaderSize(r, 64*1024)
+	for !g.done() {
+		header, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+		if header.Name == embeddedManifestName || (header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA) {
+			continue
+		}
+		name := strings.TrimPrefix(path.Clean(header.Name), "./")
+		if !g.searches(name) {
+			continue
+		}
+
+		br.Reset(r)
+		if err := g.grepEntry(name, br); err != nil {
+			return fmt.Errorf("failed to read '%s': %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (g *archiveGrep) done() bool {
+	return g.maxMatches > 0 && g.matches >= g.maxMatches
+}
+
+// searches reports whether the entry passes --max-depth, --include and --exclude
+func (g *archiveGrep) searches(name string) bool {
+	if g.maxDepth != unlimitedDepth && strings.Count(name, "/")+1 > g.maxDepth {
+		return false
+	}
+	if (archiveFilter{Excludes: g.excludes}).skipsBelow(".", name) {
+		return false
+	}
+	return len(g.includes) == 0 || matchesGlob(".", name, g.includes)
+}
+
+// grepEntry prints the matching lines of one entry, or a single note when the entry is binary
+func (g *archiveGrep) grepEntry(name string, r *bufio.Reader) error {
+	head, err := r.Peek(sniffSize)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return err
+	}
+	binary := bytes.IndexByte(head, 0) >= 0
+
+	return scanLines(r, func(number int, line []byte) (bool, error) {
+		if !g.re.Match(line) {
+			return true, nil
+		}
+		g.matches++
+		if binary {
+			_, err := fmt.Fprintf(g.out, "Binary entry %s matches\n", name)
+			return false, err
+		}
+		if _, err := fmt.Fprintf(g.out, "%s:%d: %s\n", name, number, line); err != nil {
+			return false, err
+		}
+		return !g.done(), nil
+	})
+}
+
+// scanLines calls fn with every line of r, numbered from 1 and without its line ending, until fn returns false.
+// Lines are capped at maxGrepLine, the rest of a longer line is read and dropped.
+func scanLines(r *bufio.Reader, fn func(number int, line []byte) (bool, error)) error {
+	var long []byte
+	number := 0
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			if len(long) < maxGrepLine {
+				long = append(long, chunk[:min(len(chunk), maxGrepLine-len(long))]...)
+			}
+			continue
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if len(chunk) == 0 && len(long) == 0 {
+			return nil
+		}
+
+		line := chunk
+		if long != nil {
+			if len(long) < maxGrepLine {
+				long = append(long, chunk[:min(len(chunk), maxGrepLine-len(long))]...)
+			}
+			line = long
+		}
+		number++
+		more, fnErr := fn(number, bytes.TrimRight(line, "\r\n"))
+		long = nil
+		if fnErr != nil || !more || err == io.EOF {
+			return fnErr
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package files
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+func TestGrepMaxDepth(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "dotfiles.tar")
+	writeFixtureTar(t, archive, []fixtureEntry{
+		{Name: "version.txt", Body: "version 1\n"},
+		{Name: "./dotfiles/", Type: '5'},
+		{Name: "./dotfiles/.gitconfig", Body: "version 2\n"},
+		{Name: "dotfiles/nvim/init.lua", Body: "version 3\n"},
+		{Name: "dotfiles/nvim/lua/plugins.lua", Body: "version 4\n"},
+	})
+
+	tests := []struct {
+		depth int
+		want  []string
+	}{
+		{1, []string{"version.txt:1: version 1"}},
+		// Names are counted after ./ is dropped
+		{2, []string{"version.txt:1: version 1", "dotfiles/.gitconfig:1: version 2"}},
+		{3, []string{"version.txt:1: version 1", "dotfiles/.gitconfig:1: version 2", "dotfiles/nvim/init.lua:1: version 3"}},
+		{unlimitedDepth, []string{"version.txt:1: version 1", "dotfiles/.gitconfig:1: version 2", "dotfiles/nvim/init.lua:1: version 3", "dotfiles/nvim/lua/plugins.lua:1: version 4"}},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		g := &archiveGrep{re: regexp.MustCompile("version"), maxDepth: test.depth, out: &out}
+		if err := g.run(archive); err != nil {
+			t.Fatal(err)
+		}
+		got := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+		if strings.Join(got, "\n") != strings.Join(test.want, "\n") || g.matches != len(test.want) {
+			t.Errorf("grep --max-depth %d =\n%s\nwant\n%s", test.depth, out.String(), strings.Join(test.want, "\n"))
+		}
+	}
+}
+
+// grepFixture covers what a line scanner can get wrong: CRLF endings, a missing final newline, empty files,
+// lines longer than the read buffer and than maxGrepLine, and binary files
+var grepFixture = map[string]string{
+	"README.md":               "# Project\n\nTODO: write the docs\n",
+	"main.go":                 "package main\n\n// TODO handle errors\nfunc main() {}\n",
+	"windows.txt":             "first line\r\nTODO: crlf\r\nlast\r\n",
+	"no-newline.txt":          "nothing\nTODO at the end",
+	"empty.txt":               "",
+	"src/app/handler.go":      "package app\n\n// todo lower case\n// TODO upper case\n",
+	"src/app/handler_test.go": "package app\n\n// TODO test more\n",
+	"vendor/lib/lib.go":       "package lib // TODO vendored\n",
+	"data/long.txt":           strings.Repeat("x", 200_000) + " TODO after 200 KB\nshort TODO\n",
+	"data/huge.txt":           strings.Repeat("y", maxGrepLine) + " TODO past the cap\nTODO below\n",
+	"bin/tool":                "\x7fELF\x00\x00\x00TODO in a binary\n",
+	"bin/clean":               "\x00\x01\x02 no match here\n",
+	"deep/a/b/c/d.txt":        "TODO deep\nTODO twice\n",
+}
+
+// grepTree greps the files below root like cmp grep greps an archive of them, the reference its output is held to
+func grepTree(t *testing.T, root string, re *regexp.Regexp) []string {
+	t.Helper()
+	var out []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		name := filepath.ToSlash(rel)
+		binary := bytes.IndexByte(data[:min(len(data), sniffSize)], 0) >= 0
+		lines := strings.Split(string(data), "\n")
+		if len(data) == 0 || data[len(data)-1] == '\n' {
+			lines = lines[:len(lines)-1]
+		}
+		for i, line := range lines {
+			line = strings.TrimRight(line[:min(len(line), maxGrepLine)], "\r")
+			if !re.MatchString(line) {
+				continue
+			}
+			if binary {
+				out = append(out, "Binary entry "+name+" matches")
+				break
+			}
+			out = append(out, fmt.Sprintf("%s:%d: %s", name, i+1, line))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// grepArchive runs cmp grep on an archive and returns its lines
+func grepArchive(t *testing.T, archive string, g *archiveGrep) []string {
+	t.Helper()
+	var out bytes.Buffer
+	g.out = &out
+	if g.maxDepth == 0 {
+		g.maxDepth = unlimitedDepth
+	}
+	if err := g.run(archive); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+}
+
+// sorted returns the lines in order, the walks of the archive and the reference need not agree on one
+func sorted(lines []string) []string {
+	lines = slices.Clone(lines)
+	slices.Sort(lines)
+	return lines
+}
+
+func TestGrepMatchesTheExtractedTree(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "project")
+	writeTree(t, source, grepFixture)
+	if err := os.Symlink("main.go", filepath.Join(source, "link.go")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []archiveFormat{formatTarGz, formatTarZst, formatZip} {
+		t.Run(format.Extension(), func(t *testing.T) {
+			archive := filepath.Join(t.TempDir(), "project"+format.Extension())
+			written, writtenFormat := archive, format
+			if format == formatZip {
+				// cmp writes zip archives by converting them
+				written, writtenFormat = filepath.Join(filepath.Dir(archive), "project.tar"), formatTar
+			}
+			opts := compressOptions{Format: writtenFormat, Output: written, SkipSpaceCheck: true, Progress: progressbar.DefaultBytesSilent(-1)}
+			if _, err := compressPath(source, opts); err != nil {
+				t.Fatal(err)
+			}
+			if written != archive {
+				if _, err := convertArchive(written, archive, format, nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+			dest := t.TempDir()
+			conflicts, perms := testExtractPolicies(dest)
+			if _, err := extractAll(archive, dest, conflicts, perms, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, pattern := range []string{"TODO", "(?i)todo", `^package \w+$`, "TODO past the cap", `\x00`, "no such text"} {
+				re := regexp.MustCompile(pattern)
+				got := grepArchive(t, archive, &archiveGrep{re: re})
+				want := grepTree(t, dest, re)
+				if !slices.Equal(sorted(got), sorted(want)) {
+					t.Errorf("cmp grep %q =\n%s\ngrep of the extracted tree =\n%s", pattern, strings.Join(got, "\n"), strings.Join(want, "\n"))
+				}
+			}
+		})
+	}
+}
+
+func TestGrepFilters(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "project")
+	writeTree(t, source, grepFixture)
+	archive := filepath.Join(dir, "project.tar.gz")
+	opts := compressOptions{Format: formatTarGz, Output: archive, SkipSpaceCheck: true, Progress: progressbar.DefaultBytesSilent(-1)}
+	if _, err := compressPath(source, opts); err != nil {
+		t.Fatal(err)
+	}
+	re := regexp.MustCompile("TODO")
+	files := func(lines []string) []string {
+		var names []string
+		for _, line := range lines {
+			name, _, _ := strings.Cut(strings.TrimPrefix(line, "Binary entry "), ":")
+			name = strings.TrimSuffix(name, " matches")
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+		slices.Sort(names)
+		return names
+	}
+
+	tests := []struct {
+		name     string
+		includes []string
+		excludes []string
+		want     []string
+	}{
+		// Globs match the name or the whole path
+		{"include by name", []string{"*.go"}, nil, []string{"project/main.go", "project/src/app/handler.go", "project/src/app/handler_test.go", "project/vendor/lib/lib.go"}},
+		{"include by path", []string{"project/src/*/*.go"}, nil, []string{"project/src/app/handler.go", "project/src/app/handler_test.go"}},
+		// An excluded directory takes everything below it
+		{"exclude directories", []string{"*.go"}, []string{"vendor", "*_test.go"}, []string{"project/main.go", "project/src/app/handler.go"}},
+		{"exclude data", nil, []string{"data", "bin", "deep"}, []string{"project/README.md", "project/main.go", "project/no-newline.txt", "project/src/app/handler.go", "project/src/app/handler_test.go", "project/vendor/lib/lib.go", "project/windows.txt"}},
+	}
+	for _, test := range tests {
+		got := files(grepArchive(t, archive, &archiveGrep{re: re, includes: test.includes, excludes: test.excludes}))
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%s: matched %q, want %q", test.name, got, test.want)
+		}
+	}
+
+	// --max-matches stops at the first N matches of the full run
+	all := grepArchive(t, archive, &archiveGrep{re: re})
+	for _, limit := range []int{1, 3, len(all)} {
+		g := &archiveGrep{re: re, maxMatches: limit}
+		if got := grepArchive(t, archive, g); !slices.Equal(got, all[:limit]) || g.matches != limit {
+			t.Errorf("--max-matches %d =\n%s\nwant the first %d of\n%s", limit, strings.Join(got, "\n"), limit, strings.Join(all, "\n"))
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package files
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+
+	"gsn-dev-tools/internals/style"
+	"gsn-dev-tools/internals/tui"
+	"gsn-dev-tools/internals/units"
+
+	"golang.org/x/term"
+)
+
+// defaultMaxCompressFiles is the file count above which cmp asks for confirmation
+const defaultMaxCompressFiles = 1_000_000
+
+// sourceStats is the result of the sizing pass run before compressing
+type sourceStats struct {
+	Size  int64
+	Files int
+
+	// Subdirs totals the immediate children of the source, for the confirmation prompt
+	Subdirs []duEntry
+}
+
+// measureDirectory walks a directory in parallel to calculate the total size and count of the files to archive.
+// Entries left out by filter are not counted.
+func measureDirectory(path string, filter archiveFilter) (sourceStats, error) {
+	entries, err := walkParallel(path, defaultWalkWorkers, filter.walkDepth())
+	if err != nil {
+		return sourceStats{}, err
+	}
+	entries = slices.DeleteFunc(entries, func(e walkEntry) bool { return filter.skipsBelow(path, e.Path) })
+
+	// Only regular files have content, symlinks are stored as headers and counting their size kept the
+	// totals from matching what the archiver reports
+	stats := sourceStats{Subdirs: aggregateBySubdir(path, entries)}
+	for _, entry := range entries {
+		if entry.Info.Mode().IsRegular() {
+			stats.Size += entry.Info.Size()
+			stats.Files++
+		}
+	}
+	return stats, nil
+}
+
+// measureDirectoryStreaming measures like measureDirectory with a sequential walk keeping no entries, its
+// memory does not grow with the number of files
+func measureDirectoryStreaming(path string, filter archiveFilter) (sourceStats, error) {
+	var stats sourceStats
+	usage := newSubdirUsage(path)
+	err := walkArchiveEntries(path, filter, func(filePath string, name string, info os.FileInfo) error {
+		usage.add(filePath, info)
+		if info.Mode().IsRegular() {
+			stats.Size += info.Size()
+			stats.Files++
+		}
+		return nil
+	})
+	stats.Subdirs = usage.entries()
+	return stats, err
+}
+
+// checkProtectedPath refuses filesystem roots and the user's home directory
+func checkProtectedPath(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	abs = filepath.Clean(abs)
+
+	if abs == filepath.VolumeName(abs)+string(filepath.Separator) {
+		return fmt.Errorf("refusing to compress the filesystem root '%s' without --i-know-what-im-doing", abs)
+	}
+	if home, err := os.UserHomeDir(); err == nil && abs == filepath.Clean(home) {
+		return fmt.Errorf("refusing to compress your entire home directory '%s' without --i-know-what-im-doing", abs)
+	}
+	return nil
+}
+
+// confirmLargeSource asks for confirmation when the sizing pass exceeds the configured limits.
+// It prints the totals and the largest subdirectories so a mistyped path is easy to spot.
+func confirmLargeSource(root string, stats sourceStats, opts compressOptions) error {
+	overSize := opts.MaxSize > 0 && stats.Size > opts.MaxSize
+	overFiles := opts.MaxFiles > 0 && stats.Files > opts.MaxFiles
+	if !overSize && !overFiles {
+		return nil
+	}
+
+	fmt.Printf(style.Warning()+"'%s' contains %s in %d file(s), above the configured limits (--max-size %s, --max-files %d)\n",
+		root, units.FormatBytes(stats.Size), stats.Files, units.FormatBytes(opts.MaxSize), opts.MaxFiles)
+
+	usage := slices.Clone(stats.Subdirs)
+	sort.SliceStable(usage, func(i, j int) bool { return usage[i].Size > usage[j].Size })
+	if len(usage) > 5 {
+		usage = usage[:5]
+	}
+	if len(usage) > 0 {
+		fmt.Println("Largest entries:")
+		for _, e := range usage {
+			fmt.Printf("  %10s  %s\n", units.FormatBytes(e.Size), e.Path)
+		}
+	}
+
+	if opts.AssumeYes {
+		return nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return fmt.Errorf("source exceeds the size limits, rerun with --yes to proceed")
+	}
+	if !tui.Confirm("Continue?") {
+		return fmt.Errorf("compression cancelled")
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package files
+
+import "os"
+
+// hardLinkID never reports links here, every link is archived with its content
+func hardLinkID(info os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
@@ -0,0 +1,443 @@
+package files
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/diffout"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/output"
+	"gsn-dev-tools/internals/style"
+	"gsn-dev-tools/internals/units"
+
+	"github.com/spf13/cobra"
+)
+
+// manifestColumns declares the columns available to `cmp list`
+var manifestColumns = []output.Column[ManifestEntry]{
+	{Name: "path", Value: func(e ManifestEntry) any { return e.Path }},
+	{Name: "type", Value: func(e ManifestEntry) any { return e.Type }},
+	{
+		Name:    "size",
+		Value:   func(e ManifestEntry) any { return e.Size },
+		Display: func(e ManifestEntry) string { return units.FormatBytes(e.Size) },
+	},
+	{
+		Name:    "mode",
+		Value:   func(e ManifestEntry) any { return int64(e.Mode) },
+		Display: func(e ManifestEntry) string { return os.FileMode(e.Mode).String() },
+	},
+	{Name: "mtime", Value: func(e ManifestEntry) any { return e.ModTime }},
+	{Name: "sha256", Value: func(e ManifestEntry) any { return e.SHA256 }},
+}
+
+func ListArchiveCmd() *cobra.Command {
+	listCmd := cobra.Command{
+		Use:   "list <archive>",
+		Short: "Lists the entries of an archive",
+		Long: `Lists archive entries from the verified sidecar manifest when present, streaming the archive otherwise.
+Debian and RPM packages and docker save tarballs list the entries nested in them, see gsn extract.`,
+		Example: `  gsn cmp list project.tar.gz
+  gsn cmp list project.tar.gz --sort size:desc --columns path,size
+  gsn cmp list image.tar --columns path,size`,
+		Args: cobra.ExactArgs(1),
+		Run:  ListArchive,
+	}
+
+	output.AddFlags(&listCmd)
+	dryrun.ReadOnly(&listCmd)
+	return &listCmd
+}
+
+func ListArchive(cmd *cobra.Command, args []string) {
+	archivePath := args[0]
+	opts, err := output.OptionsFromFlags(cmd)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	if len(opts.Columns) == 0 {
+		opts.Columns = []string{"path", "type", "size", "mode", "mtime"}
+	}
+
+	m, err := archiveManifest(archivePath, false)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+
+	if err := output.Render(os.Stdout, manifestColumns, m.Entries, opts); err != nil {
+		clierr.Fatalf("%v", err)
+	}
+}
+
+// archiveManifest prefers the verified sidecar manifest and falls back to streaming the archive
+func archiveManifest(archivePath string, withHashes bool) (*Manifest, error) {
+	m, err := loadVerifiedManifest(archivePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, style.Warning()+"Ignoring manifest: %v\n", err)
+	}
+	if m != nil {
+		return m, nil
+	}
+	return streamManifest(archivePath, withHashes)
+}
+
+func VerifyArchiveCmd() *cobra.Command {
+	verifyCmd := cobra.Command{
+		Use:   "verify <archive>",
+		Short: "Verifies an archive against its manifest",
+		Long: `Streams the archive and checks that every entry agrees with the sidecar manifest (name, type and size),
+and re-hashes a random sample of files to compare against the recorded SHA-256.
+
+--checksum also hashes the whole archive against <archive>.sha256sum, as gsn backup run writes it. The archive
+is hashed the way the checksum was recorded: a plain SHA-256, or a gsn-tree-sha256:<chunksize>:<hex> tree hash
+whose chunks are hashed in parallel. --parallel-hash implies --checksum and insists on a tree hash, a plain
+SHA-256 checksum is then an error instead of a slow serial hash.`,
+		Example: `  gsn cmp verify project.tar.gz
+  gsn cmp verify project.tar.gz --sample 0
+  gsn cmp verify photos-20260101-030000.tar.zst --parallel-hash`,
+		Args: cobra.ExactArgs(1),
+		Run:  VerifyArchive,
+	}
+
+	verifyCmd.Flags().Int("sample", 10, "Number of random files whose content hash is checked (0 checks every file)")
+	verifyCmd.Flags().Bool("checksum", false, "Hash the whole archive and compare it with <archive>.sha256sum")
+	verifyCmd.Flags().Bool("parallel-hash", false, "Like --checksum, requiring a gsn-tree-sha256 checksum hashed across every CPU")
+	dryrun.ReadOnly(&verifyCmd)
+	return &verifyCmd
+}
+
+func VerifyArchive(cmd *cobra.Command, args []string) {
+	archivePath := args[0]
+	sample, _ := cmd.Flags().GetInt("sample")
+	checksum, _ := cmd.Flags().GetBool("checksum")
+	parallel, _ := cmd.Flags().GetBool("parallel-hash")
+	startTime := time.Now()
+
+	m, err := loadVerifiedManifest(archivePath)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	if m == nil {
+		clierr.Fatalf("No manifest found for '%s', create one with `gsn cmp --manifest`", archivePath)
+	}
+
+	problems, checked, err := verifyAgainstManifest(archivePath, m, sample)
+	if err != nil {
+		clierr.Fatalf("Verification failed: %v", err)
+	}
+	if checksum || parallel {
+		digest, err := verifyChecksum(archivePath, parallel)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", filepath.Base(archivePath), err))
+		} else {
+			fmt.Printf("%s %s\n", digest.Format, digest.Hex)
+		}
+	}
+
+	for _, problem := range problems {
+		fmt.Println(style.Failure() + problem)
+	}
+	if len(problems) > 0 {
+		fmt.Printf("\n%d problem(s) found in %s\n", len(problems), archivePath)
+		clierr.Exit(clierr.Failure)
+	}
+	fmt.Printf(style.Success()+"%s matches its manifest (%s entries, %s hashed, Time: %s)\n", archivePath, units.FormatInt(int64(len(m.Entries))), units.FormatInt(int64(checked)), units.FormatDuration(time.Since(startTime)))
+}
+
+// verifyChecksum hashes an archive the way its checksum file records it and compares both. requireTree refuses a
+// plain SHA-256 checksum before hashing anything.
+func verifyChecksum(archivePath string, requireTree bool) (fileDigest, error) {
+	recorded, err := readChecksumFile(archivePath+checksumSuffix, filepath.Base(archivePath))
+	if err != nil {
+		return fileDigest{}, err
+	}
+	if requireTree && recorded.Format != treeHashFormat {
+		return fileDigest{}, fmt.Errorf("the checksum is a %s digest, --parallel-hash needs a %s one; use --checksum to hash it serially", recorded.Format, treeHashFormat)
+	}
+	computed, err := digestFileAs(archivePath, recorded)
+	if err != nil {
+		return fileDigest{}, err
+	}
+	return computed, compareDigests(recorded, computed)
+}
+
+// verifyAgainstManifest compares the streamed archive with the manifest and hashes a random sample of files.
+// It returns human readable disagreements and the number of files whose content was hashed.
+func verifyAgainstManifest(archivePath string, m *Manifest, sample int) ([]string, int, error) {
+	expected := make(map[string]ManifestEntry, len(m.Entries))
+	var files []string
+	for _, e := range m.Entries {
+		expected[e.Path] = e
+		if e.Type == "file" {
+			files = append(files, e.Path)
+		}
+	}
+
+	sampled := make(map[string]bool)
+	if sample <= 0 || sample >= len(files) {
+		for _, f := range files {
+			sampled[f] = true
+		}
+	} else {
+		for _, i := range rand.Perm(len(files))[:sample] {
+			sampled[files[i]] = true
+		}
+	}
+
+	r, err := openArchive(archivePath)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer r.Close()
+
+	var problems []string
+	seen := make(map[string]bool)
+	checked := 0
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return problems, checked, fmt.Errorf("failed to read archive: %w", err)
+		}
+		if header.Name == embeddedManifestName {
+			continue
+		}
+
+		actual := entryFromHeader(header, "")
+		want, ok := expected[actual.Path]
+		seen[actual.Path] = true
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%s: present in archive but not in manifest", actual.Path))
+			continue
+		case want.Type != actual.Type:
+			problems = append(problems, fmt.Sprintf("%s: type %s in archive, %s in manifest", actual.Path, actual.Type, want.Type))
+			continue
+		case want.Size != actual.Size:
+			problems = append(problems, fmt.Sprintf("%s: size %d in archive, %d in manifest", actual.Path, actual.Size, want.Size))
+			continue
+		}
+
+		if sampled[actual.Path] && (header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeRegA) {
+			hasher := sha256.New()
+			if _, err := io.Copy(hasher, r); err != nil {
+				return problems, checked, err
+			}
+			checked++
+			if sum := hex.EncodeToString(hasher.Sum(nil)); want.SHA256 != "" && sum != want.SHA256 {
+				problems = append(problems, fmt.Sprintf("%s: content hash does not match the manifest", actual.Path))
+			}
+		}
+	}
+
+	for _, e := range m.Entries {
+		if !seen[e.Path] {
+			problems = append(problems, fmt.Sprintf("%s: listed in manifest but missing from archive", e.Path))
+		}
+	}
+	return problems, checked, nil
+}
+
+func DiffArchiveCmd() *cobra.Command {
+	diffCmd := cobra.Command{
+		Use:   "diff <archive> <path>",
+		Short: "Compares an archive with the live file or directory it was created from",
+		Long: `Reports entries added (+), removed (-) or modified (~) on disk since the archive was written, with what
+differs for modified entries. --diff-format json or summary prints the changes as JSON or their counts. Exits
+with 1 when differences are found.`,
+		Example: `  gsn cmp diff project.tar.gz ./project
+  gsn cmp diff project.tar.gz ./project --hash
+  gsn cmp diff project.tar.gz ./project --diff-format summary`,
+		Args: cobra.ExactArgs(2),
+		Run:  DiffArchive,
+	}
+
+	diffCmd.Flags().Bool("hash", false, "Compare file contents by SHA-256 instead of size and mtime")
+	diffout.AddFlags(&diffCmd)
+	dryrun.ReadOnly(&diffCmd)
+	return &diffCmd
+}
+
+func DiffArchive(cmd *cobra.Command, args []string) {
+	archivePath, sourcePath := args[0], args[1]
+	withHashes, _ := cmd.Flags().GetBool("hash")
+	opts, err := diffout.OptionsFromFlags(cmd)
+	if err != nil {
+		clierr.Fatal(err)
+	}
+
+	m, err := archiveManifest(archivePath, withHashes)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+
+	live, err := liveEntries(sourcePath, withHashes)
+	if err != nil {
+		clierr.Fatalf("Error reading '%s': %v", sourcePath, err)
+	}
+
+	changes := diffEntries(m.Entries, live, withHashes)
+	if err := diffout.Render(os.Stdout, entryChanges(changes), opts); err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	if len(changes) > 0 {
+		clierr.Exit(clierr.Failure)
+	}
+}
+
+// entryChange is a single difference between two entry sets
+type entryChange struct {
+	Kind string // "+" added, "-" removed, "~" modified
+	Path string
+	// Reason names what differs for modified entries: type, size, mode, content or mtime
+	Reason string
+}
+
+// entryChanges converts entry changes to the change set of diffout
+func entryChanges(changes []entryChange) []diffout.Change {
+	converted := make([]diffout.Change, len(changes))
+	for i, c := range changes {
+		converted[i] = diffout.Change{Key: c.Path, Kind: diffout.Kind(changeNames[c.Kind]), Detail: c.Reason}
+	}
+	return converted
+}
+
+// diffEntries compares archived entries with live ones by path, using hashes when both sides have them
+func diffEntries(before []ManifestEntry, after []ManifestEntry, withHashes bool) []entryChange {
+	old := make(map[string]ManifestEntry, len(before))
+	for _, e := range before {
+		old[e.Path] = e
+	}
+
+	var changes []entryChange
+	current := make(map[string]bool, len(after))
+	for _, e := range after {
+		current[e.Path] = true
+		prev, ok := old[e.Path]
+		switch {
+		case !ok:
+			changes = append(changes, entryChange{Kind: "+", Path: e.Path})
+		case prev.Type != e.Type:
+			changes = append(changes, entryChange{Kind: "~", Path: e.Path, Reason: "type"})
+		case prev.Size != e.Size:
+			changes = append(changes, entryChange{Kind: "~", Path: e.Path, Reason: "size"})
+		case prev.Mode != e.Mode:
+			changes = append(changes, entryChange{Kind: "~", Path: e.Path, Reason: "mode"})
+		case e.Type != "file":
+		case withHashes && prev.SHA256 != "" && e.SHA256 != "":
+			if prev.SHA256 != e.SHA256 {
+				changes = append(changes, entryChange{Kind: "~", Path: e.Path, Reason: "content"})
+			}
+		case !prev.ModTime.Equal(e.ModTime):
+			changes = append(changes, entryChange{Kind: "~", Path: e.Path, Reason: "mtime"})
+		}
+	}
+	for _, e := range before {
+		if !current[e.Path] {
+			changes = append(changes, entryChange{Kind: "-", Path: e.Path})
+		}
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// liveEntries describes a file or directory on disk using the same entries the archiver produces: no entry
+// for the directory itself, and further links to a hard linked file as hardlink entries
+func liveEntries(sourcePath string, withHashes bool) ([]ManifestEntry, error) {
+	info, err := os.Lstat(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+
+	base := filepath.Base(sourcePath)
+	var entries []ManifestEntry
+	links := make(map[fileID]bool)
+	add := func(path string, name string, info os.FileInfo) error {
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = name
+
+		if info.Mode().IsRegular() {
+			if id, ok := hardLinkID(info); ok {
+				if links[id] {
+					header.Typeflag, header.Size = tar.TypeLink, 0
+					entries = append(entries, entryFromHeader(header, ""))
+					return nil
+				}
+				links[id] = true
+			}
+		}
+
+		var digest string
+		if withHashes && info.Mode().IsRegular() {
+			if digest, err = hashFile(path); err != nil {
+				return err
+			}
+		}
+		entries = append(entries, entryFromHeader(header, digest))
+		return nil
+	}
+
+	if !info.IsDir() {
+		return entries, add(sourcePath, base, info)
+	}
+
+	walked, err := walkParallel(sourcePath, defaultWalkWorkers, unlimitedDepth)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]string, len(walked))
+	for _, w := range walked {
+		rel, err := filepath.Rel(sourcePath, w.Path)
+		if err != nil {
+			return nil, err
+		}
+		names[w.Path] = filepath.ToSlash(rel)
+	}
+	// Visit entries in the order of filepath.Walk so the same link of a file is the first one as in the archive
+	sort.Slice(walked, func(i, j int) bool {
+		return slices.Compare(strings.Split(names[walked[i].Path], "/"), strings.Split(names[walked[j].Path], "/")) < 0
+	})
+
+	for _, w := range walked {
+		rel := names[w.Path]
+		if rel == "." {
+			continue
+		}
+		if err := add(w.Path, base+"/"+rel, w.Info); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// hashFile returns the hex SHA-256 of a file's content
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
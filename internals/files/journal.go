@@ -0,0 +1,230 @@
+package files
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gsn-dev-tools/internals/paths"
+	"gsn-dev-tools/internals/state"
+)
+
+// extractJournalName is the journal written into the destination when extraction moves files aside
+const extractJournalName = ".gsn-extract-journal.json"
+
+// renameJournalName is the journal rename keeps in every directory it touched
+const renameJournalName = ".gsn-rename-journal.json"
+
+// journalKind versions the journals. gsn state migrate upgrades the workspace journals in the state dir,
+// those inside renamed directories are upgraded when they are read.
+var journalKind = state.Register(&state.Kind{
+	Name:     "journal",
+	Format:   state.JSON,
+	Version:  1,
+	Patterns: []string{".gsn-*-journal.json", "rename-*.json"},
+	Validate: func(values map[string]any) error {
+		if command, _ := values["command"].(string); command == "" {
+			return errors.New("the journal names no command")
+		}
+		return nil
+	},
+	Files: func() ([]string, error) {
+		dir, err := workspaceJournalDir()
+		if err != nil {
+			return nil, err
+		}
+		entries, err := os.ReadDir(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var paths []string
+		for _, e := range entries {
+			if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+				paths = append(paths, filepath.Join(dir, e.Name()))
+			}
+		}
+		return paths, nil
+	},
+})
+
+// workspaceJournalDir returns the directory of the aggregate journals of workspace runs
+func workspaceJournalDir() (string, error) {
+	dir, err := paths.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "journals"), nil
+}
+
+// Journal records file moves performed by a command so they can be reverted later
+type Journal struct {
+	SchemaVersion int            `json:"schema_version"`
+	Command       string         `json:"command"`
+	CreatedAt     time.Time      `json:"created_at"`
+	Root          string         `json:"root"`
+	Entries       []JournalEntry `json:"entries"`
+}
+
+// JournalEntry is a single move, undone by moving To back to From
+type JournalEntry struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// newJournal starts an empty journal for command operating below root
+func newJournal(command string, root string) *Journal {
+	return &Journal{SchemaVersion: journalKind.Version, Command: command, CreatedAt: time.Now().UTC(), Root: root}
+}
+
+// Record appends a move to the journal
+func (j *Journal) Record(from string, to string) {
+	j.Entries = append(j.Entries, JournalEntry{From: from, To: to})
+}
+
+// Save writes the journal as JSON to path
+func (j *Journal) Save(path string) error {
+	j.SchemaVersion = journalKind.Version
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// loadJournal reads a journal previously written with Save. Every caller moves files by it or rewrites it, so
+// journals written by a newer gsn are refused.
+func loadJournal(path string) (*Journal, error) {
+	var j Journal
+	doc, err := journalKind.Load(path, &j)
+	if err != nil {
+		return nil, err
+	}
+	if err := doc.Writable(); err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// openDirJournal loads the rename journal kept in dir, or starts one when the directory has none yet. Its Root
+// is the absolute path of dir.
+func openDirJournal(dir string) (*Journal, string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, "", err
+	}
+	journalPath := filepath.Join(dir, renameJournalName)
+	journal, err := loadJournal(journalPath)
+	if errors.Is(err, os.ErrNotExist) {
+		journal = newJournal("rename", absDir)
+	} else if err != nil {
+		return nil, "", err
+	}
+	journal.Root = absDir
+	return journal, journalPath, nil
+}
+
+// path resolves a journal path, relative entries are relative to Root
+func (j *Journal) path(p string) string {
+	if filepath.IsAbs(p) || j.Root == "" {
+		return p
+	}
+	return filepath.Join(j.Root, p)
+}
+
+// undo reverts the recorded moves newest first and returns them with absolute paths.
+// Entries whose original path is taken again are skipped.
+func (j *Journal) undo() ([]JournalEntry, error) {
+	var reverted []JournalEntry
+	for i := len(j.Entries) - 1; i >= 0; i-- {
+		from, to := j.path(j.Entries[i].From), j.path(j.Entries[i].To)
+
+		if _, err := os.Lstat(from); err == nil {
+			fmt.Printf("Warning: Skipping '%s' - '%s' already exists\n", to, from)
+			continue
+		}
+		if err := os.Rename(to, from); err != nil {
+			return reverted, fmt.Errorf("failed to restore '%s': %w", from, err)
+		}
+
+		fmt.Printf("Restored: '%s' -> '%s'\n", to, from)
+		reverted = append(reverted, JournalEntry{From: from, To: to})
+	}
+	return reverted, nil
+}
+
+// restoreJournaledNames replays extracted rename journals in reverse so the tree carries its names from before
+// gsn rename. Existing files at an original name go through conflicts. A journal is removed once every move
+// was undone, or rewritten with the moves left, unless keep is set.
+func restoreJournaledNames(journals []string, conflicts *conflictResolver, keep bool) (int, error) {
+	restored := 0
+	for _, journalPath := range journals {
+		n, err := restoreJournal(journalPath, conflicts, keep)
+		restored += n
+		if err != nil {
+			return restored, err
+		}
+	}
+	return restored, nil
+}
+
+func restoreJournal(journalPath string, conflicts *conflictResolver, keep bool) (int, error) {
+	journal, err := loadJournal(journalPath)
+	if err != nil {
+		return 0, err
+	}
+	if journal.Command != "rename" {
+		return 0, fmt.Errorf("'%s' is a %s journal, not a rename journal", journalPath, journal.Command)
+	}
+	for _, entry := range journal.Entries {
+		if !filepath.IsLocal(entry.From) || !filepath.IsLocal(entry.To) {
+			return 0, fmt.Errorf("'%s' records a move outside its directory: '%s' -> '%s'", journalPath, entry.From, entry.To)
+		}
+	}
+
+	// Root names the directory that was renamed, the extracted copy is the one holding the journal
+	if journal.Root, err = filepath.Abs(filepath.Dir(journalPath)); err != nil {
+		return 0, err
+	}
+
+	restored := 0
+	var left []JournalEntry
+	for i := len(journal.Entries) - 1; i >= 0; i-- {
+		entry := journal.Entries[i]
+		from, to := journal.path(entry.From), journal.path(entry.To)
+		if _, err := os.Lstat(to); os.IsNotExist(err) {
+			fmt.Printf("Warning: Skipping '%s' - it is not in the archive\n", to)
+			continue
+		}
+
+		write, err := conflicts.resolve(from)
+		if err != nil {
+			return restored, err
+		}
+		if !write {
+			left = append(left, entry)
+			continue
+		}
+		if err := os.Rename(to, from); err != nil {
+			return restored, fmt.Errorf("failed to restore '%s': %w", from, err)
+		}
+		restored++
+	}
+
+	if keep {
+		return restored, nil
+	}
+	if len(left) == 0 {
+		return restored, os.Remove(journalPath)
+	}
+	slices.Reverse(left)
+	journal.Entries = left
+	return restored, journal.Save(journalPath)
+}
@@ -0,0 +1,158 @@
+package files
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// locFixture is a small polyglot tree, with the counts gsn loc must find in it below
+var locFixture = map[string]string{
+	"main.go": `// Package main is a fixture
+package main
+
+import "fmt"
+
+/* a block
+   comment */
+func main() {
+	fmt.Println("hi") // trailing
+	x := 1 /* inline */ + 2
+	/* open
+	*/ y := 3
+	_ = x + y
+}
+`,
+	"lib/util.go":   "package lib\n\n// Add adds\nfunc Add(a, b int) int { return a + b }\n",
+	"script.py":     "#!/usr/bin/env python3\n# comment\n\nimport os\nprint(os.name)  # trailing\n   \n",
+	"SHOUT.PY":      "pass\n",
+	"Makefile":      "# build\nall:\n\tgo build ./...\n",
+	"page.html":     "<!-- header -->\n<html>\n<!--\nmulti\n-->\n</html>\n",
+	"query.sql":     "-- list\nSELECT 1; /* why */\n",
+	"no_newline.sh": "echo hi",
+	"crlf.c":        "int x;\r\n// c\r\n\r\n",
+	// Not counted: binary, of no known language, or in a VCS directory
+	"blob.go":       "package blob\x00\x01",
+	"notes.unknown": "text\n",
+	".git/hook.sh":  "echo hook\n",
+}
+
+var locFixtureCounts = map[string]locCount{
+	"Go":       {Language: "Go", Files: 2, Code: 10, Comment: 5, Blank: 3},
+	"Python":   {Language: "Python", Files: 2, Code: 3, Comment: 2, Blank: 2},
+	"Makefile": {Language: "Makefile", Files: 1, Code: 2, Comment: 1},
+	"HTML":     {Language: "HTML", Files: 1, Code: 2, Comment: 4},
+	"SQL":      {Language: "SQL", Files: 1, Code: 1, Comment: 1},
+	"Shell":    {Language: "Shell", Files: 1, Code: 1},
+	"C":        {Language: "C", Files: 1, Code: 1, Comment: 1, Blank: 1},
+}
+
+type locReport struct {
+	Languages []locCount `json:"languages"`
+	Total     locCount   `json:"total"`
+	Skipped   int        `json:"skipped_files"`
+}
+
+// runLoc runs gsn loc with args and returns what it printed
+func runLoc(t *testing.T, args ...string) string {
+	t.Helper()
+	cmd := LinesOfCodeCmd()
+	cmd.SetArgs(args)
+	return captureStdout(t, func() {
+		if err := cmd.Execute(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestLocFixtureCounts(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, locFixture)
+
+	var report locReport
+	if err := json.Unmarshal([]byte(runLoc(t, dir, "--json")), &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Languages) != len(locFixtureCounts) {
+		t.Errorf("counted %d languages, want %d: %+v", len(report.Languages), len(locFixtureCounts), report.Languages)
+	}
+	for i, got := range report.Languages {
+		if want := locFixtureCounts[got.Language]; got != want {
+			t.Errorf("%s = %+v, want %+v", got.Language, got, want)
+		}
+		// Sorted by code, most first
+		if i > 0 && got.Code > report.Languages[i-1].Code {
+			t.Errorf("%s with %d lines of code follows %s with %d", got.Language, got.Code, report.Languages[i-1].Language, report.Languages[i-1].Code)
+		}
+	}
+	want := locCount{Language: "total", Files: 9, Code: 20, Comment: 14, Blank: 6}
+	if report.Total != want || report.Skipped != 2 {
+		t.Errorf("total = %+v with %d skipped, want %+v with 2 skipped", report.Total, report.Skipped, want)
+	}
+
+	// The table ends with the same totals
+	table := runLoc(t, dir)
+	if !strings.Contains(table, "\nTotal: 40 line(s) in 9 file(s): 20 code, 14 comment, 6 blank\n2 file(s) not counted: binary or of another language\n") {
+		t.Errorf("table =\n%s", table)
+	}
+}
+
+func TestLocExcludes(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, locFixture)
+	writeTree(t, dir, map[string]string{"vendor/dep/dep.go": "package dep\n\nvar X = 1\n"})
+
+	var report locReport
+	if err := json.Unmarshal([]byte(runLoc(t, dir, "--json")), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Total.Files != 10 || report.Total.Code != 22 {
+		t.Errorf("total with vendor = %+v", report.Total)
+	}
+	if err := json.Unmarshal([]byte(runLoc(t, dir, "--json", "--exclude", "vendor", "--exclude", "*.py")), &report); err != nil {
+		t.Fatal(err)
+	}
+	// Patterns match case-sensitively, SHOUT.PY is still counted as Python
+	want := locCount{Language: "total", Files: 8, Code: 18, Comment: 12, Blank: 4}
+	if report.Total != want {
+		t.Errorf("total without vendor and *.py = %+v, want %+v", report.Total, want)
+	}
+}
+
+func TestCountFilesIsTheSameOnAnyNumberOfWorkers(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, locFixture)
+	langs, err := loadLanguages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []locFile
+	for name := range locFixture {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if lang := langs.of(path); lang != nil && !strings.HasPrefix(name, ".git/") {
+			files = append(files, locFile{Path: path, Lang: lang})
+		}
+	}
+	serial, serialBinary := countFiles(files, 1)
+	parallel, parallelBinary := countFiles(files, 8)
+	if len(serial) != len(locFixtureCounts) || serialBinary != 1 || parallelBinary != 1 {
+		t.Fatalf("counted %+v with %d binary", serial, serialBinary)
+	}
+	for i := range serial {
+		if serial[i] != parallel[i] || serial[i] != locFixtureCounts[serial[i].Language] {
+			t.Errorf("one worker counts %+v, eight %+v", serial[i], parallel[i])
+		}
+	}
+
+	// A file that cannot be read is reported and left out
+	if err := os.Remove(filepath.Join(dir, "crlf.c")); err != nil {
+		t.Fatal(err)
+	}
+	var counts []locCount
+	stderr := captureStderr(t, func() { counts, _ = countFiles(files, 4) })
+	if len(counts) != len(locFixtureCounts)-1 || !strings.Contains(stderr, "crlf.c") {
+		t.Errorf("counts without crlf.c = %+v, stderr %q", counts, stderr)
+	}
+}
@@ -0,0 +1,341 @@
+package files
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gsn-dev-tools/internals/tmpfs"
+)
+
+const (
+	// manifestSchemaVersion is bumped whenever the manifest layout changes incompatibly
+	manifestSchemaVersion = 1
+
+	// manifestSuffix is appended to the archive path for the sidecar manifest
+	manifestSuffix = ".manifest.json"
+
+	// manifestHashSuffix is appended to the manifest path for its SHA-256 checksum
+	manifestHashSuffix = ".sha256"
+
+	// embeddedManifestName is the name of the manifest entry appended to archives with --embed-manifest
+	embeddedManifestName = ".gsn-manifest.json"
+)
+
+// Manifest lists every entry of an archive so it can be listed and verified without full decompression.
+// Snapshots written by `snap create` use the same layout with Source set instead of the archive fields.
+type Manifest struct {
+	SchemaVersion int             `json:"schema_version"`
+	Archive       string          `json:"archive,omitempty"`
+	ArchiveSize   int64           `json:"archive_size,omitempty"`
+	Source        string          `json:"source,omitempty"`
+	CreatedAt     time.Time       `json:"created_at"`
+	Entries       []ManifestEntry `json:"entries"`
+
+	// spillAfter moves the entries to a temp file once there are this many, so large trees do not keep them in
+	// memory. Zero never spills.
+	spillAfter int
+	spill      *manifestSpill
+}
+
+// manifestSpill holds manifest entries as JSON lines in a temp workspace
+type manifestSpill struct {
+	workspace *tmpfs.Workspace
+	file      *os.File
+	buf       *bufio.Writer
+	count     int
+}
+
+// ManifestEntry describes a single archive entry
+type ManifestEntry struct {
+	Path    string    `json:"path"`
+	Type    string    `json:"type"`
+	Size    int64     `json:"size"`
+	Mode    uint32    `json:"mode"`
+	ModTime time.Time `json:"mtime"`
+	SHA256  string    `json:"sha256,omitempty"`
+}
+
+func newManifest(archivePath string) *Manifest {
+	return &Manifest{
+		SchemaVersion: manifestSchemaVersion,
+		Archive:       filepath.Base(archivePath),
+		CreatedAt:     time.Now().UTC(),
+	}
+}
+
+// entryFromHeader converts a tar header into a manifest entry
+func entryFromHeader(header *tar.Header, digest string) ManifestEntry {
+	entryType := "file"
+	switch header.Typeflag {
+	case tar.TypeDir:
+		entryType = "dir"
+	case tar.TypeSymlink:
+		entryType = "symlink"
+	case tar.TypeLink:
+		entryType = "hardlink"
+	}
+
+	return ManifestEntry{
+		Path:    header.Name,
+		Type:    entryType,
+		Size:    header.Size,
+		Mode:    uint32(os.FileMode(header.Mode).Perm()),
+		ModTime: header.ModTime.UTC().Truncate(time.Second),
+		SHA256:  digest,
+	}
+}
+
+func (m *Manifest) add(header *tar.Header, digest string) error {
+	entry := entryFromHeader(header, digest)
+	if m.spill == nil && (m.spillAfter == 0 || len(m.Entries) < m.spillAfter) {
+		m.Entries = append(m.Entries, entry)
+		return nil
+	}
+
+	if m.spill == nil {
+		if err := m.startSpill(); err != nil {
+			return err
+		}
+	}
+	m.spill.count++
+	return json.NewEncoder(m.spill.buf).Encode(entry)
+}
+
+// startSpill moves the entries collected so far to a temp file, where the following ones are appended
+func (m *Manifest) startSpill() error {
+	workspace, err := tmpfs.New("manifest")
+	if err != nil {
+		return err
+	}
+	file, err := workspace.CreateFile("entries-*.jsonl")
+	if err != nil {
+		workspace.Cleanup()
+		return err
+	}
+	m.spill = &manifestSpill{workspace: workspace, file: file, buf: bufio.NewWriter(file)}
+
+	enc := json.NewEncoder(m.spill.buf)
+	for _, entry := range m.Entries {
+		if err := enc.Encode(entry); err != nil {
+			return err
+		}
+	}
+	m.spill.count = len(m.Entries)
+	m.Entries = nil
+	return nil
+}
+
+// close removes the spilled entries, the manifest cannot be written afterwards
+func (m *Manifest) close() {
+	if m.spill != nil {
+		m.spill.file.Close()
+		m.spill.workspace.Cleanup()
+		m.spill = nil
+	}
+}
+
+// writeJSON encodes the manifest like json.Marshal, or json.MarshalIndent with indent, streaming spilled
+// entries back from their temp file
+func (m *Manifest) writeJSON(w io.Writer, indent bool) error {
+	marshal := func(v any, prefix string) ([]byte, error) {
+		if indent {
+			return json.MarshalIndent(v, prefix, "  ")
+		}
+		return json.Marshal(v)
+	}
+	if m.spill == nil {
+		data, err := marshal(m, "")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+
+	// Entries is the last field, its empty list is where the spilled entries go
+	head := *m
+	head.Entries = []ManifestEntry{}
+	data, err := marshal(&head, "")
+	if err != nil {
+		return err
+	}
+	split := bytes.LastIndex(data, []byte("[]")) + 1
+	if _, err := w.Write(data[:split]); err != nil {
+		return err
+	}
+
+	if err := m.spill.buf.Flush(); err != nil {
+		return err
+	}
+	if _, err := m.spill.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bufio.NewReader(m.spill.file))
+	for i := 0; dec.More(); i++ {
+		var entry ManifestEntry
+		if err := dec.Decode(&entry); err != nil {
+			return fmt.Errorf("error reading spilled manifest entries: %w", err)
+		}
+		line, err := marshal(entry, "    ")
+		if err != nil {
+			return err
+		}
+		sep := ","
+		if i == 0 {
+			sep = ""
+		}
+		if indent {
+			sep += "\n    "
+		}
+		if _, err := io.WriteString(w, sep); err != nil {
+			return err
+		}
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+	}
+	if _, err := m.spill.file.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+
+	tail := data[split:]
+	if indent && m.spill.count > 0 {
+		tail = append([]byte("\n  "), tail...)
+	}
+	_, err = w.Write(tail)
+	return err
+}
+
+// embed appends the manifest as the final entry of the archive
+func (m *Manifest) embed(tw entryWriter) error {
+	// The size goes in the header, a spilled manifest is encoded twice instead of held in memory
+	var size countingWriter
+	if err := m.writeJSON(&size, false); err != nil {
+		return err
+	}
+
+	header := &tar.Header{
+		Name:     embeddedManifestName,
+		Typeflag: tar.TypeReg,
+		Mode:     0o644,
+		Size:     int64(size),
+		ModTime:  m.CreatedAt,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	return m.writeJSON(tw, false)
+}
+
+// save writes the sidecar manifest and its checksum next to the archive
+func (m *Manifest) save(archivePath string) error {
+	manifestPath := archivePath + manifestSuffix
+	file, err := os.OpenFile(manifestPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	buf := bufio.NewWriter(io.MultiWriter(file, hasher))
+	if err := m.writeJSON(buf, true); err != nil {
+		return err
+	}
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.WriteFile(manifestPath+manifestHashSuffix, []byte(hex.EncodeToString(hasher.Sum(nil))+"\n"), 0o644)
+}
+
+// countingWriter counts the bytes written to it and drops them
+type countingWriter int64
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	*c += countingWriter(len(p))
+	return len(p), nil
+}
+
+// loadVerifiedManifest returns the sidecar manifest of an archive when it exists,
+// matches its stored checksum and was produced for an archive of the current size.
+// A nil manifest without error means the caller should fall back to streaming.
+func loadVerifiedManifest(archivePath string) (*Manifest, error) {
+	manifestPath := archivePath + manifestSuffix
+	data, err := os.ReadFile(manifestPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	storedSum, err := os.ReadFile(manifestPath + manifestHashSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("manifest checksum missing: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != string(bytes.TrimSpace(storedSum)) {
+		return nil, fmt.Errorf("manifest checksum mismatch for '%s'", manifestPath)
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid manifest '%s': %w", manifestPath, err)
+	}
+	if m.SchemaVersion > manifestSchemaVersion {
+		return nil, fmt.Errorf("manifest schema version %d is newer than supported version %d", m.SchemaVersion, manifestSchemaVersion)
+	}
+
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() != m.ArchiveSize {
+		return nil, fmt.Errorf("manifest was written for a %d byte archive but '%s' is %d bytes", m.ArchiveSize, archivePath, info.Size())
+	}
+	return &m, nil
+}
+
+// streamManifest builds a manifest by reading the whole archive, hashing contents when withHashes is set
+func streamManifest(archivePath string, withHashes bool) (*Manifest, error) {
+	r, err := openArchive(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	m := newManifest(archivePath)
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			return m, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+		if header.Name == embeddedManifestName {
+			continue
+		}
+
+		var digest string
+		if withHashes && (header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeRegA) {
+			hasher := sha256.New()
+			if _, err := io.Copy(hasher, r); err != nil {
+				return nil, err
+			}
+			digest = hex.EncodeToString(hasher.Sum(nil))
+		}
+		m.add(header, digest)
+	}
+}
@@ -0,0 +1,215 @@
+package files
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var manifestFixture = []fixtureEntry{
+	{Name: "project/", Type: '5'},
+	{Name: "project/a.txt", Body: "alpha\n"},
+	{Name: "project/b.txt", Body: "bravo\n"},
+	{Name: "project/link", Type: '2', Link: "a.txt"},
+}
+
+// writeManifestFixture writes the fixture archive with its sidecar manifest and returns the archive path
+func writeManifestFixture(t *testing.T) string {
+	t.Helper()
+	archive := filepath.Join(t.TempDir(), "project.tar.gz")
+	writeFixtureTar(t, archive, manifestFixture)
+	m, err := streamManifest(archive, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.ArchiveSize = info.Size()
+	if err := m.save(archive); err != nil {
+		t.Fatal(err)
+	}
+	return archive
+}
+
+func TestManifestRoundTrip(t *testing.T) {
+	archive := writeManifestFixture(t)
+	m, err := loadVerifiedManifest(archive)
+	if err != nil || m == nil {
+		t.Fatalf("loadVerifiedManifest = %v, %v", m, err)
+	}
+	if m.SchemaVersion != manifestSchemaVersion || m.Archive != "project.tar.gz" {
+		t.Errorf("manifest = %+v", m)
+	}
+	var paths []string
+	for _, e := range m.Entries {
+		paths = append(paths, e.Path+":"+e.Type)
+	}
+	if want := []string{"project/:dir", "project/a.txt:file", "project/b.txt:file", "project/link:symlink"}; !slices.Equal(paths, want) {
+		t.Errorf("entries = %v, want %v", paths, want)
+	}
+	if sum := sha256.Sum256([]byte("alpha\n")); m.Entries[1].SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("a.txt hash = %q", m.Entries[1].SHA256)
+	}
+	if !m.Entries[1].ModTime.Equal(fixtureTime) || m.Entries[1].Mode != 0o644 || m.Entries[1].Size != 6 {
+		t.Errorf("a.txt = %+v", m.Entries[1])
+	}
+}
+
+func TestLoadVerifiedManifestRejections(t *testing.T) {
+	if m, err := loadVerifiedManifest(filepath.Join(t.TempDir(), "none.tar.gz")); m != nil || err != nil {
+		t.Errorf("without a manifest = %v, %v, want nil to stream instead", m, err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(archive string)
+		want   string
+	}{
+		{"tampered", func(archive string) {
+			data, _ := os.ReadFile(archive + manifestSuffix)
+			_ = os.WriteFile(archive+manifestSuffix, bytes.Replace(data, []byte(`"size": 6`), []byte(`"size": 7`), 1), 0o644)
+		}, "checksum mismatch"},
+		{"checksum missing", func(archive string) {
+			_ = os.Remove(archive + manifestSuffix + manifestHashSuffix)
+		}, "checksum missing"},
+		{"archive rewritten", func(archive string) {
+			writeFixtureTar(t, archive, append(manifestFixture, fixtureEntry{Name: "project/c.txt", Body: "charlie\n"}))
+		}, "byte archive"},
+		{"newer schema", func(archive string) {
+			var m Manifest
+			data, _ := os.ReadFile(archive + manifestSuffix)
+			_ = json.Unmarshal(data, &m)
+			m.SchemaVersion = manifestSchemaVersion + 1
+			if err := m.save(archive); err != nil {
+				t.Fatal(err)
+			}
+		}, "newer than supported"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			archive := writeManifestFixture(t)
+			test.change(archive)
+			m, err := loadVerifiedManifest(archive)
+			if m != nil || err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("loadVerifiedManifest = %v, %v, want an error containing %q", m, err, test.want)
+			}
+		})
+	}
+}
+
+func TestArchiveManifestPrefersTheSidecar(t *testing.T) {
+	archive := writeManifestFixture(t)
+	m, _ := loadVerifiedManifest(archive)
+	// A manifest naming an entry the archive lacks tells which source was used
+	m.Entries = append(m.Entries, ManifestEntry{Path: "project/only-in-manifest", Type: "file"})
+	if err := m.save(archive); err != nil {
+		t.Fatal(err)
+	}
+	listed, err := archiveManifest(archive, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed.Entries) != 5 {
+		t.Errorf("listed %d entries, want the 5 of the manifest", len(listed.Entries))
+	}
+
+	// A manifest that does not verify is ignored and the archive streamed
+	if err := os.WriteFile(archive+manifestSuffix+manifestHashSuffix, []byte("0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stderr := captureStderr(t, func() { listed, err = archiveManifest(archive, false) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed.Entries) != 4 || !strings.Contains(stderr, "Ignoring manifest") {
+		t.Errorf("listed %d entries with %q, want the 4 streamed and a warning", len(listed.Entries), stderr)
+	}
+}
+
+func TestVerifyAgainstManifestFindsDisagreements(t *testing.T) {
+	archive := writeManifestFixture(t)
+	m, _ := loadVerifiedManifest(archive)
+
+	problems, checked, err := verifyAgainstManifest(archive, m, 0)
+	if err != nil || len(problems) > 0 || checked != 2 {
+		t.Fatalf("verify of a matching archive = %v, %d checked, %v", problems, checked, err)
+	}
+
+	// Same size, different content; a new entry; a missing one; a file turned into a directory
+	writeFixtureTar(t, archive, []fixtureEntry{
+		{Name: "project/", Type: '5'},
+		{Name: "project/a.txt", Body: "ALPHA\n"},
+		{Name: "project/b.txt", Type: '5'},
+		{Name: "project/new.txt", Body: "new\n"},
+	})
+	problems, _, err = verifyAgainstManifest(archive, m, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"project/a.txt: content hash does not match the manifest",
+		"project/b.txt: type dir in archive, file in manifest",
+		"project/new.txt: present in archive but not in manifest",
+		"project/link: listed in manifest but missing from archive",
+	}
+	if !slices.Equal(problems, want) {
+		t.Errorf("problems =\n%s\nwant\n%s", strings.Join(problems, "\n"), strings.Join(want, "\n"))
+	}
+
+	// A sample hashes that many files only
+	if _, checked, _ := verifyAgainstManifest(archive, m, 1); checked > 1 {
+		t.Errorf("a sample of 1 hashed %d files", checked)
+	}
+}
+
+func TestManifestSpillMatchesInMemory(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "many.tar")
+	var entries []fixtureEntry
+	for i := range 50 {
+		entries = append(entries, fixtureEntry{Name: "f" + strings.Repeat("x", i%7) + string(rune('a'+i%26)) + ".txt", Body: strings.Repeat("y", i)})
+	}
+	writeFixtureTar(t, archive, entries)
+	inMemory, err := streamManifest(archive, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spilled := newManifest(archive)
+	spilled.CreatedAt = inMemory.CreatedAt
+	spilled.spillAfter = 8
+	for _, e := range readFixtureTar(t, archive) {
+		header := fixtureHeader(e)
+		var digest string
+		for _, m := range inMemory.Entries {
+			if m.Path == e.Name {
+				digest = m.SHA256
+			}
+		}
+		if err := spilled.add(header, digest); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer spilled.close()
+	if spilled.spill == nil {
+		t.Fatal("the manifest did not spill")
+	}
+
+	var a, b bytes.Buffer
+	if err := inMemory.writeJSON(&a, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := spilled.writeJSON(&b, true); err != nil {
+		t.Fatal(err)
+	}
+	if a.String() != b.String() {
+		t.Errorf("spilled manifest differs:\n%s\nin memory:\n%s", b.String(), a.String())
+	}
+}
@@ -0,0 +1,137 @@
+package files
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"gsn-dev-tools/internals/progress"
+	"gsn-dev-tools/internals/units"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// manifestEntryCost approximates the memory of a manifest entry kept in a slice, its path included
+const manifestEntryCost = 256
+
+// memoryBudget bounds what an archive operation keeps in memory with --max-memory, a zero limit leaves it
+// unbounded. The walk state, the manifest and the codec buffers are what grows with large trees.
+type memoryBudget struct {
+	Limit int64
+}
+
+// streamWalk reports whether the sizing pass walks the tree sequentially instead of listing it in parallel
+func (b memoryBudget) streamWalk() bool {
+	return b.Limit > 0
+}
+
+// manifestSpillAfter is the number of manifest entries kept in memory before they move to a temp file, an
+// eighth of the limit. Zero keeps them all in memory.
+func (b memoryBudget) manifestSpillAfter() int {
+	if b.Limit == 0 {
+		return 0
+	}
+	return max(1024, int(b.Limit/8/manifestEntryCost))
+}
+
+// mergePathCost approximates the memory of a path kept in the merge index map, its key included
+const mergePathCost = 128
+
+// mergeSpillAfter is the number of paths gsn cmp merge keeps in memory before they move to a hash table in a
+// temp file, a quarter of the limit. Zero keeps them all in memory.
+func (b memoryBudget) mergeSpillAfter() int {
+	if b.Limit == 0 {
+		return 0
+	}
+	return max(1024, int(b.Limit/4/mergePathCost))
+}
+
+// zstdWindow is the zstd window for the limit, a 64th of it between 1 MiB and the 8 MiB of the default level
+func (b memoryBudget) zstdWindow() int {
+	window := 1 << 20
+	for window < 8<<20 && int64(window)*2 <= b.Limit/64 {
+		window *= 2
+	}
+	return window
+}
+
+// zstdOptions keeps a single zstd encoder goroutine with a window sized to the limit, each goroutine holds
+// buffers of its own
+func (b memoryBudget) zstdOptions() []zstd.EOption {
+	if b.Limit == 0 {
+		return nil
+	}
+	return []zstd.EOption{zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(b.zstdWindow())}
+}
+
+// spoolMemory is how much of an entry --retry-changed keeps in memory before it moves to a temp file, 8 MiB
+// or an eighth of the limit when that is smaller
+func (b memoryBudget) spoolMemory() int64 {
+	if b.Limit == 0 {
+		return 8 << 20
+	}
+	return max(64<<10, min(8<<20, b.Limit/8))
+}
+
+// describe tells what the limit changes, for --verbose
+func (b memoryBudget) describe() string {
+	return fmt.Sprintf("Memory limit %s: sequential sizing walk, manifest entries spill to disk after %d, zstd window %s",
+		units.FormatBytes(b.Limit), b.manifestSpillAfter(), units.FormatBytes(int64(b.zstdWindow())))
+}
+
+// memorySampler tracks the peak RSS and heap of the process while an operation runs, and shows them in the
+// description of its progress bar
+type memorySampler struct {
+	mu       sync.Mutex
+	peakHeap uint64
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// sampleMemory samples every interval until stop is called
+func sampleMemory(bar progress.Tracker, description string, interval time.Duration) *memorySampler {
+	s := &memorySampler{stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			s.sample()
+			progress.Describe(bar, fmt.Sprintf("%s (%s)", description, s.summary()))
+			select {
+			case <-ticker.C:
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+func (s *memorySampler) sample() {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	s.mu.Lock()
+	s.peakHeap = max(s.peakHeap, stats.HeapAlloc)
+	s.mu.Unlock()
+}
+
+// summary renders the peaks seen so far
+func (s *memorySampler) summary() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rss, ok := peakRSS(); ok {
+		return fmt.Sprintf("peak RSS %s, heap %s", units.FormatBytes(rss), units.FormatBytes(int64(s.peakHeap)))
+	}
+	return fmt.Sprintf("peak heap %s", units.FormatBytes(int64(s.peakHeap)))
+}
+
+// finish stops sampling and prints the peaks
+func (s *memorySampler) finish() {
+	close(s.stop)
+	<-s.done
+	s.sample()
+	fmt.Fprintf(os.Stderr, "Memory: %s\n", s.summary())
+}
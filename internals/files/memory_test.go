@@ -0,0 +1,147 @@
+package files
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+// writeEmptyFiles creates count empty files below root, sharded into directories of a thousand
+func writeEmptyFiles(tb testing.TB, root string, count int) {
+	tb.Helper()
+	const shard = 1000
+	var wg sync.WaitGroup
+	errs := make(chan error, 1)
+	for start := 0; start < count; start += shard {
+		dir := filepath.Join(root, fmt.Sprintf("shard-%04d", start/shard))
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			tb.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := start; i < min(start+shard, count); i++ {
+				file, err := os.Create(filepath.Join(dir, fmt.Sprintf("f-%07d", i)))
+				if err == nil {
+					err = file.Close()
+				}
+				if err != nil {
+					select {
+					case errs <- err:
+					default:
+					}
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	select {
+	case err := <-errs:
+		tb.Fatal(err)
+	default:
+	}
+}
+
+// peakHeapGrowth runs f and returns how far the live heap grew above what it was before, sampled every
+// millisecond
+func peakHeapGrowth(f func()) uint64 {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	baseline := stats.HeapAlloc
+
+	var peak atomic.Uint64
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		for {
+			var stats runtime.MemStats
+			runtime.ReadMemStats(&stats)
+			if stats.HeapAlloc > peak.Load() {
+				peak.Store(stats.HeapAlloc)
+			}
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	f()
+	close(stop)
+	<-done
+	if peak.Load() < baseline {
+		return 0
+	}
+	return peak.Load() - baseline
+}
+
+// compressManyFiles archives source with a manifest as tar.zst under limit and returns the peak heap growth
+func compressManyFiles(tb testing.TB, source string, limit int64) uint64 {
+	tb.Helper()
+	output := filepath.Join(tb.TempDir(), "many.tar.zst")
+	opts := compressOptions{Format: formatTarZst, Output: output, Manifest: true, SkipSpaceCheck: true,
+		Memory: memoryBudget{Limit: limit}, Progress: progressbar.DefaultBytesSilent(-1)}
+	var err error
+	growth := peakHeapGrowth(func() {
+		_, err = compressPath(source, opts)
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return growth
+}
+
+func TestMaxMemoryBoundsManyFiles(t *testing.T) {
+	if testing.Short() {
+		t.Skip("creates 40,000 files")
+	}
+	const files = 40_000
+	source := filepath.Join(t.TempDir(), "many")
+	writeEmptyFiles(t, source, files)
+
+	unlimited := compressManyFiles(t, source, 0)
+	limited := compressManyFiles(t, source, 16<<20)
+	t.Logf("peak heap growth for %d files: %d KiB unlimited, %d KiB with a 16 MiB limit", files, unlimited>>10, limited>>10)
+	if limited > 16<<20 {
+		t.Errorf("the heap grew by %d KiB with a 16 MiB limit", limited>>10)
+	}
+	// The manifest entries and the parallel sizing walk are what the limit keeps out of memory
+	if unlimited < 2*limited {
+		t.Errorf("the limit saved little: %d KiB unlimited, %d KiB limited", unlimited>>10, limited>>10)
+	}
+}
+
+// BenchmarkCompressManyFiles archives 500,000 empty files with --manifest --format tar.zst, the case the
+// --max-memory numbers in the help of cmp are measured on
+func BenchmarkCompressManyFiles(b *testing.B) {
+	const files = 500_000
+	source := filepath.Join(b.TempDir(), "many")
+	writeEmptyFiles(b, source, files)
+
+	for _, limit := range []int64{0, 256 << 20, 64 << 20} {
+		name := "unlimited"
+		if limit > 0 {
+			name = fmt.Sprintf("max-memory-%dMB", limit>>20)
+		}
+		b.Run(name, func(b *testing.B) {
+			for b.Loop() {
+				growth := compressManyFiles(b, source, limit)
+				b.ReportMetric(float64(growth)/(1<<20), "peak-heap-MiB")
+				if limit > 0 && growth > uint64(limit) {
+					b.Fatalf("the heap grew by %d MiB with --max-memory %d MiB", growth>>20, limit>>20)
+				}
+			}
+		})
+	}
+}
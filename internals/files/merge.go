@@ -0,0 +1,277 @@
+package files
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/style"
+	"gsn-dev-tools/internals/units"
+
+	"github.com/spf13/cobra"
+)
+
+// Policies of --dedupe-paths for a path found in several inputs of gsn cmp merge
+const (
+	mergeLast  = "last"
+	mergeFirst = "first"
+	mergeError = "error"
+)
+
+var mergePolicies = []string{mergeLast, mergeFirst, mergeError}
+
+func MergeArchiveCmd() *cobra.Command {
+	mergeCmd := cobra.Command{
+		Use:   "merge <archive>... -o <archive>",
+		Short: "Merges several archives into a single tar archive without extracting them",
+		Long: `Streams the entries of every input, in the order given, into a single tar, tar.gz or tar.zst archive named
+by -o. Headers are copied as they are, so modes, owners, times, links and extended attributes are kept, and
+nothing is extracted to disk. The result is written to a temp file and renamed into place once the merge
+succeeds.
+
+--dedupe-paths decides which entry is kept for a path found in more than one input, or twice in one: last keeps
+the newest one, the way daily incremental tarballs stack up; first keeps the oldest; error fails the merge.
+Directories found in several inputs are never an error, a single entry is kept with the metadata of the one the
+policy picks, the first under error. Keeping the last entry reads the headers of every input once before writing. A hard link is kept only when its
+target is taken from the same input, otherwise the merge fails rather than link to different content. Tarballs
+do not record deleted files, so a file removed between two inputs stays in the result.
+
+Only the set of paths seen is kept in memory. --max-memory bounds it for inputs with millions of entries: past
+a quarter of the limit the paths move to a hash table in a temp file.`,
+		Example: `  gsn cmp merge day01.tar.gz day02.tar.gz day03.tar.gz -o month.tar.gz
+  gsn cmp merge base.tar.zst patch.tar.zst -o combined.tar.zst --dedupe-paths error
+  gsn cmp merge daily/*.tar.gz -o 2026-01.tar.zst --max-memory 256MB`,
+		Args: cobra.MinimumNArgs(2),
+		Run:  MergeArchives,
+	}
+
+	mergeCmd.Flags().StringP("output", "o", "", "Archive to write, its extension picks the format: .tar, .tar.gz or .tar.zst")
+	mergeCmd.Flags().String("dedupe-paths", mergeLast, "Entry kept for a path found in several inputs: last, first or error")
+	mergeCmd.Flags().String("max-memory", "", "Bound the memory of the seen paths, e.g. 256MB, spilling them to a temp file")
+	_ = mergeCmd.MarkFlagRequired("output")
+	return &mergeCmd
+}
+
+func MergeArchives(cmd *cobra.Command, args []string) {
+	outPath, _ := cmd.Flags().GetString("output")
+	policy, _ := cmd.Flags().GetString("dedupe-paths")
+	maxMemoryValue, _ := cmd.Flags().GetString("max-memory")
+	startTime := time.Now()
+
+	if !slices.Contains(mergePolicies, policy) {
+		clierr.Exitf(clierr.Usage, "invalid --dedupe-paths '%s' (use %s)", policy, strings.Join(mergePolicies, ", "))
+	}
+	format, ok := formatOfName(outPath)
+	if !ok || format.Container != containerTar {
+		clierr.Exitf(clierr.Usage, "'%s' is not a tar archive name, merge writes .tar, .tar.gz or .tar.zst", outPath)
+	}
+	var budget memoryBudget
+	if maxMemoryValue != "" {
+		var err error
+		if budget.Limit, err = units.ParseBytes(maxMemoryValue); err != nil {
+			clierr.Fatalf("%v", err)
+		}
+	}
+	if out, err := os.Stat(outPath); err == nil {
+		for _, input := range args {
+			if in, err := os.Stat(input); err == nil && os.SameFile(in, out) {
+				clierr.Exitf(clierr.Usage, "the output '%s' is also an input", outPath)
+			}
+		}
+	}
+
+	result, err := mergeArchives(args, outPath, format, policy, budget)
+	if err != nil {
+		clierr.Fatalf("Merge failed: %v", err)
+	}
+
+	fmt.Printf(style.Success()+"Merged %d archives -> %s (%s entries, %s duplicates left out, Time: %s)\n", len(args), outPath,
+		units.FormatInt(int64(result.Entries)), units.FormatInt(int64(result.Dropped)), units.FormatDuration(time.Since(startTime)))
+}
+
+// mergeResult counts what a merge wrote and left out
+type mergeResult struct {
+	Entries int
+	Dropped int
+}
+
+// merger writes the entries of several inputs to one archive. Every entry read gets a sequence number, counting
+// across the inputs in order, and the index maps each path to the number of the entry taken for it.
+type merger struct {
+	inputs []string
+	policy string
+	index  *mergeIndex
+	// starts holds the sequence number of the first entry of every input read so far
+	starts []uint64
+}
+
+// mergeArchives merges inputs into an archive at outPath, written to a temp file in its directory and renamed
+// into place on success
+func mergeArchives(inputs []string, outPath string, format archiveFormat, policy string, budget memoryBudget) (mergeResult, error) {
+	m := &merger{inputs: inputs, policy: policy, index: newMergeIndex(budget.mergeSpillAfter())}
+	defer m.index.close()
+
+	if policy == mergeLast {
+		if err := m.indexLast(); err != nil {
+			return mergeResult{}, err
+		}
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(outPath), ".gsn-merge-*")
+	if err != nil {
+		return mergeResult{}, fmt.Errorf("error creating temp file: %w", err)
+	}
+	tmpName := tmpFile.Name()
+	defer os.Remove(tmpName) // no-op once renamed
+	defer tmpFile.Close()
+
+	codecWriter, err := newCodecWriter(tmpFile, format.Codec, budget)
+	if err != nil {
+		return mergeResult{}, err
+	}
+	dst := &tarEntryWriter{Writer: tar.NewWriter(codecWriter), codec: codecWriter}
+
+	result, err := m.write(dst)
+	if err != nil {
+		return result, err
+	}
+	if err := dst.Close(); err != nil {
+		return result, fmt.Errorf("error finalizing archive: %w", err)
+	}
+	if err := tmpFile.Chmod(newFileMode()); err != nil {
+		return result, err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return result, err
+	}
+	if err := os.Rename(tmpName, outPath); err != nil {
+		return result, fmt.Errorf("error moving archive into place: %w", err)
+	}
+	return result, nil
+}
+
+// indexLast reads the headers of every input and records the last entry of each path
+func (m *merger) indexLast() error {
+	var seq uint64
+	for _, input := range m.inputs {
+		err := eachEntry(input, func(header *tar.Header, _ io.Reader) error {
+			err := m.index.set(mergeKey(header.Name), seq)
+			seq++
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write copies the entries the policy keeps to dst
+func (m *merger) write(dst entryWriter) (mergeResult, error) {
+	var result mergeResult
+	var seq uint64
+	for i, input := range m.inputs {
+		m.starts = append(m.starts, seq)
+		err := eachEntry(input, func(header *tar.Header, content io.Reader) error {
+			defer func() { seq++ }()
+			keep, err := m.keeps(i, header, seq)
+			if err != nil || !keep {
+				if err == nil {
+					result.Dropped++
+				}
+				return err
+			}
+			if header.Typeflag == tar.TypeLink {
+				if err := m.checkLink(i, header, seq); err != nil {
+					return err
+				}
+			}
+
+			if err := dst.WriteHeader(header); err != nil {
+				return fmt.Errorf("failed to write header for '%s': %w", header.Name, err)
+			}
+			if header.Size > 0 && header.Typeflag != tar.TypeLink && header.Typeflag != tar.TypeSymlink {
+				if _, err := io.Copy(dst, content); err != nil {
+					return fmt.Errorf("failed to copy '%s' of %s: %w", header.Name, input, err)
+				}
+			}
+			result.Entries++
+			return nil
+		})
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// keeps decides whether the entry numbered seq of input i is written
+func (m *merger) keeps(i int, header *tar.Header, seq uint64) (bool, error) {
+	key := mergeKey(header.Name)
+	taken, found, err := m.index.get(key)
+	if err != nil {
+		return false, err
+	}
+	if m.policy == mergeLast {
+		return found && taken == seq, nil
+	}
+	if !found {
+		return true, m.index.set(key, seq)
+	}
+	if m.policy == mergeError && header.Typeflag != tar.TypeDir {
+		return false, clierr.Newf(clierr.Conflict, "'%s' of %s is also in %s (--dedupe-paths error)", header.Name, m.inputs[i], m.inputs[m.inputOf(taken)])
+	}
+	return false, nil
+}
+
+// checkLink refuses a hard link whose target is not written before it from the same input: it would point to
+// an entry with other content, or to nothing
+func (m *merger) checkLink(i int, header *tar.Header, seq uint64) error {
+	taken, found, err := m.index.get(mergeKey(header.Linkname))
+	if err != nil {
+		return err
+	}
+	if !found || taken < m.starts[i] || taken >= seq {
+		return clierr.Newf(clierr.Conflict, "hard link '%s' of %s points to '%s', which the merge takes from another input", header.Name, m.inputs[i], header.Linkname)
+	}
+	return nil
+}
+
+// inputOf returns the index of the input holding the entry numbered seq
+func (m *merger) inputOf(seq uint64) int {
+	return sort.Search(len(m.starts), func(i int) bool { return m.starts[i] > seq }) - 1
+}
+
+// eachEntry calls f with every entry of an archive in order, content reads the entry being visited
+func eachEntry(archivePath string, f func(header *tar.Header, content io.Reader) error) error {
+	src, err := openArchive(archivePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	for {
+		header, err := src.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read an entry of %s: %w", archivePath, err)
+		}
+		if err := f(header, src); err != nil {
+			return err
+		}
+	}
+}
+
+// mergeKey is the path an entry name stands for: ./a, a and a/ are the same path
+func mergeKey(name string) string {
+	return path.Clean("/" + name)
+}
@@ -0,0 +1,175 @@
+package files
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"os"
+
+	"gsn-dev-tools/internals/tmpfs"
+)
+
+// mergeSlotSize is the size of a slot of the spilled index: 16 bytes of the path's sha256 and the value plus
+// one, so that a zero slot is empty
+const mergeSlotSize = 24
+
+// mergeIndex maps paths to sequence numbers for gsn cmp merge. It is a map until it holds spillAfter paths,
+// then it moves to an open addressing hash table in a temp file, keyed by a hash of the path so that its slots
+// have a fixed size. Zero spillAfter keeps it in memory.
+type mergeIndex struct {
+	spillAfter int
+	paths      map[string]uint64
+
+	workspace *tmpfs.Workspace
+	table     *os.File
+	slots     uint64
+	used      uint64
+}
+
+func newMergeIndex(spillAfter int) *mergeIndex {
+	return &mergeIndex{spillAfter: spillAfter, paths: make(map[string]uint64)}
+}
+
+func (x *mergeIndex) get(key string) (uint64, bool, error) {
+	if x.table == nil {
+		value, found := x.paths[key]
+		return value, found, nil
+	}
+	digest := mergeDigest(key)
+	_, value, found, err := x.find(digest)
+	return value, found, err
+}
+
+func (x *mergeIndex) set(key string, value uint64) error {
+	if x.table == nil {
+		x.paths[key] = value
+		if x.spillAfter == 0 || len(x.paths) < x.spillAfter {
+			return nil
+		}
+		return x.spill()
+	}
+	return x.put(mergeDigest(key), value)
+}
+
+// spill moves the map to a table with room for twice its paths
+func (x *mergeIndex) spill() error {
+	workspace, err := tmpfs.New("merge")
+	if err != nil {
+		return err
+	}
+	x.workspace = workspace
+	if err := x.allocate(uint64(len(x.paths)) * 4); err != nil {
+		return err
+	}
+	for key, value := range x.paths {
+		if err := x.put(mergeDigest(key), value); err != nil {
+			return err
+		}
+	}
+	x.paths = nil
+	return nil
+}
+
+// allocate starts an empty table of slots, a power of two, replacing the current one
+func (x *mergeIndex) allocate(slots uint64) error {
+	size := uint64(1024)
+	for size < slots {
+		size *= 2
+	}
+	table, err := x.workspace.CreateFile("paths-*.bin")
+	if err != nil {
+		return fmt.Errorf("failed to create the merge index: %w", err)
+	}
+	if err := table.Truncate(int64(size * mergeSlotSize)); err != nil {
+		table.Close()
+		return fmt.Errorf("failed to size the merge index: %w", err)
+	}
+	if x.table != nil {
+		x.table.Close()
+		os.Remove(x.table.Name())
+	}
+	x.table, x.slots, x.used = table, size, 0
+	return nil
+}
+
+func (x *mergeIndex) put(digest [16]byte, value uint64) error {
+	slot, _, found, err := x.find(digest)
+	if err != nil {
+		return err
+	}
+	var buf [mergeSlotSize]byte
+	copy(buf[:16], digest[:])
+	binary.LittleEndian.PutUint64(buf[16:], value+1)
+	if _, err := x.table.WriteAt(buf[:], int64(slot*mergeSlotSize)); err != nil {
+		return fmt.Errorf("failed to write the merge index: %w", err)
+	}
+	if found {
+		return nil
+	}
+	x.used++
+	if x.used*2 <= x.slots {
+		return nil
+	}
+	return x.grow()
+}
+
+// grow doubles the table once it is half full, rehashing every slot in use
+func (x *mergeIndex) grow() error {
+	old := x.table
+	oldSlots := x.slots
+	x.table = nil
+	if err := x.allocate(oldSlots * 2); err != nil {
+		old.Close()
+		return err
+	}
+	defer os.Remove(old.Name())
+	defer old.Close()
+
+	var buf [mergeSlotSize]byte
+	for slot := range oldSlots {
+		if _, err := old.ReadAt(buf[:], int64(slot*mergeSlotSize)); err != nil {
+			return fmt.Errorf("failed to read the merge index: %w", err)
+		}
+		value := binary.LittleEndian.Uint64(buf[16:])
+		if value == 0 {
+			continue
+		}
+		if err := x.put([16]byte(buf[:16]), value-1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// find probes from the slot of digest until it holds digest or is empty, returning that slot
+func (x *mergeIndex) find(digest [16]byte) (slot uint64, value uint64, found bool, err error) {
+	var buf [mergeSlotSize]byte
+	slot = binary.LittleEndian.Uint64(digest[:8]) & (x.slots - 1)
+	for {
+		if _, err := x.table.ReadAt(buf[:], int64(slot*mergeSlotSize)); err != nil {
+			return 0, 0, false, fmt.Errorf("failed to read the merge index: %w", err)
+		}
+		stored := binary.LittleEndian.Uint64(buf[16:])
+		if stored == 0 {
+			return slot, 0, false, nil
+		}
+		if [16]byte(buf[:16]) == digest {
+			return slot, stored - 1, true, nil
+		}
+		slot = (slot + 1) & (x.slots - 1)
+	}
+}
+
+func (x *mergeIndex) close() {
+	if x.table != nil {
+		x.table.Close()
+	}
+	if x.workspace != nil {
+		x.workspace.Cleanup()
+	}
+}
+
+func mergeDigest(key string) [16]byte {
+	sum := sha256.Sum256([]byte(key))
+	return [16]byte(sum[:16])
+}
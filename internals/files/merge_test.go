@@ -0,0 +1,234 @@
+package files
+
+import (
+	"archive/tar"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gsn-dev-tools/internals/clierr"
+)
+
+// mergeInputs are three daily tarballs, each in a format merge reads, sharing paths spelled differently: a
+// directory, a file changed every day and a file twice in the first input. link is a hard link to c.
+var mergeInputs = []struct {
+	name    string
+	entries []fixtureEntry
+}{
+	{"day1.tar", []fixtureEntry{
+		{Name: "etc/", Type: tar.TypeDir},
+		{Name: "etc/a", Body: "a1"},
+		{Name: "c", Body: "c1"},
+		{Name: "etc/a", Body: "a1 again"},
+		{Name: "link", Type: tar.TypeLink, Link: "c"},
+	}},
+	{"day2.tar.gz", []fixtureEntry{
+		{Name: "./etc/", Type: tar.TypeDir, Mode: 0o700},
+		{Name: "./etc/b", Body: "b2"},
+		{Name: "etc/a", Body: "a2"},
+	}},
+	{"day3.tar.zst", []fixtureEntry{
+		{Name: "etc", Type: tar.TypeDir, Mode: 0o750},
+		{Name: "etc/b", Body: "b3"},
+		{Name: "d", Body: "d3", Mode: 0o600},
+		{Name: "sub/dir/", Type: tar.TypeDir},
+	}},
+}
+
+// writeMergeInputs writes the inputs named, out of mergeInputs plus extra ones, to dir and returns their paths.
+// Entries get the default type and mode of fixtureHeader.
+func writeMergeInputs(t *testing.T, dir string, extra map[string][]fixtureEntry, names ...string) []string {
+	t.Helper()
+	all := map[string][]fixtureEntry{}
+	for _, in := range mergeInputs {
+		all[in.name] = in.entries
+	}
+	for name, entries := range extra {
+		all[name] = entries
+	}
+	var paths []string
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		format, ok := formatOfName(name)
+		if !ok {
+			t.Fatalf("no format for %s", name)
+		}
+		var entries []fixtureEntry
+		for _, e := range all[name] {
+			header := fixtureHeader(e)
+			e.Type, e.Mode = header.Typeflag, header.Mode
+			entries = append(entries, e)
+		}
+		writeFixtureArchive(t, path, format, entries)
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// runMerge merges inputs to out, in memory or with the seen paths spilled to a temp file
+func runMerge(t *testing.T, inputs []string, out string, policy string, spill bool) (mergeResult, error) {
+	t.Helper()
+	format, ok := formatOfName(out)
+	if !ok {
+		t.Fatalf("no format for %s", out)
+	}
+	var budget memoryBudget
+	if spill {
+		budget.Limit = 1
+	}
+	return mergeArchives(inputs, out, format, policy, budget)
+}
+
+// TestMergePolicies checks the entry set of the merged archive against the union each policy keeps: the last or
+// the first entry of every path, ./a, a and a/ being the same path, in the order of the inputs
+func TestMergePolicies(t *testing.T) {
+	dir := t.TempDir()
+	inputs := writeMergeInputs(t, dir, nil, "day1.tar", "day2.tar.gz", "day3.tar.zst")
+	tests := []struct {
+		policy  string
+		dropped int
+		want    []string
+	}{
+		// The newest of each path, written where its input puts it
+		{mergeLast, 5, []string{
+			`c 0644 "c1"`,
+			"link => c",
+			`etc/a 0644 "a2"`,
+			"etc 0750",
+			`etc/b 0644 "b3"`,
+			`d 0600 "d3"`,
+			"sub/dir/ 0755",
+		}},
+		{mergeFirst, 5, []string{
+			"etc/ 0755",
+			`etc/a 0644 "a1"`,
+			`c 0644 "c1"`,
+			"link => c",
+			`./etc/b 0644 "b2"`,
+			`d 0600 "d3"`,
+			"sub/dir/ 0755",
+		}},
+	}
+	for _, test := range tests {
+		for _, out := range []string{"merged.tar", "merged.tar.gz", "merged.tar.zst"} {
+			out = filepath.Join(dir, test.policy+"-"+out)
+			result, err := runMerge(t, inputs, out, test.policy, false)
+			if err != nil {
+				t.Fatalf("%s: %v", test.policy, err)
+			}
+			if result.Entries != len(test.want) || result.Dropped != test.dropped {
+				t.Errorf("%s: %+v, want %d entries and %d dropped", test.policy, result, len(test.want), test.dropped)
+			}
+			checkListing(t, "--dedupe-paths "+test.policy, out, test.want...)
+		}
+	}
+
+	// Under error directories never conflict, the first one is kept as under first
+	daily := writeMergeInputs(t, dir, map[string][]fixtureEntry{
+		"x.tar": {{Name: "etc/", Type: tar.TypeDir}, {Name: "etc/x", Body: "x"}},
+		"y.tar": {{Name: "./etc", Type: tar.TypeDir, Mode: 0o700}, {Name: "etc/y", Body: "y"}},
+	}, "x.tar", "y.tar")
+	out := filepath.Join(dir, "error.tar")
+	if result, err := runMerge(t, daily, out, mergeError, false); err != nil || result != (mergeResult{Entries: 3, Dropped: 1}) {
+		t.Fatalf("error policy without conflicts = %+v, %v", result, err)
+	}
+	checkListing(t, "--dedupe-paths error", out, "etc/ 0755", `etc/x 0644 "x"`, `etc/y 0644 "y"`)
+}
+
+// TestMergeSpilled merges inputs with more paths than the index keeps in memory under --max-memory: the result is
+// the same as in memory, and the temp file of the index is removed
+func TestMergeSpilled(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	dir := t.TempDir()
+	spillAfter := memoryBudget{Limit: 1}.mergeSpillAfter()
+	extra := map[string][]fixtureEntry{}
+	var names []string
+	for day := range 3 {
+		name := fmt.Sprintf("gen%d.tar.gz", day)
+		var entries []fixtureEntry
+		// Every day overlaps half of the day before
+		for i := day * spillAfter / 2; i < day*spillAfter/2+spillAfter; i++ {
+			entries = append(entries, fixtureEntry{Name: fmt.Sprintf("gen/%05d", i), Body: fmt.Sprintf("day %d", day)})
+		}
+		extra[name] = entries
+		names = append(names, name)
+	}
+	inputs := writeMergeInputs(t, dir, extra, append(names, "day1.tar", "day2.tar.gz", "day3.tar.zst")...)
+	unique := 2*spillAfter + 7
+
+	for _, policy := range []string{mergeLast, mergeFirst} {
+		inMemory, spilled := filepath.Join(dir, policy+"-memory.tar"), filepath.Join(dir, policy+"-spilled.tar")
+		want, err := runMerge(t, inputs, inMemory, policy, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := runMerge(t, inputs, spilled, policy, true)
+		if err != nil {
+			t.Fatalf("%s spilled: %v", policy, err)
+		}
+		if want.Entries != unique || got != want {
+			t.Errorf("%s: spilled %+v, in memory %+v, want %d entries", policy, got, want, unique)
+		}
+		wantList, err := listArchive(inMemory)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkListing(t, policy+" spilled", spilled, wantList...)
+		if left, _ := os.ReadDir(os.Getenv("TMPDIR")); len(left) != 0 {
+			t.Errorf("%s left %v in TMPDIR", policy, left)
+		}
+	}
+
+	// Under last every generated path comes from the last day holding it
+	list, _ := listArchive(filepath.Join(dir, "last-spilled.tar"))
+	for _, line := range list {
+		if strings.HasPrefix(line, "gen/") {
+			var i int
+			fmt.Sscanf(line, "gen/%05d", &i)
+			day := min(i/(spillAfter/2), 2)
+			if !strings.HasSuffix(line, fmt.Sprintf(`"day %d"`, day)) {
+				t.Errorf("%s, want the content of day %d", line, day)
+			}
+		}
+	}
+}
+
+func TestMergeErrors(t *testing.T) {
+	dir := t.TempDir()
+	extra := map[string][]fixtureEntry{
+		"c.tar":    {{Name: "c", Body: "c4"}},
+		"link.tar": {{Name: "link2", Type: tar.TypeLink, Link: "c"}},
+	}
+	in := func(names ...string) []string { return writeMergeInputs(t, dir, extra, names...) }
+	tests := []struct {
+		inputs []string
+		policy string
+		want   string
+	}{
+		{in("day1.tar"), mergeError, "'etc/a' of " + filepath.Join(dir, "day1.tar") + " is also in " + filepath.Join(dir, "day1.tar") + " (--dedupe-paths error)"},
+		{in("day2.tar.gz", "day3.tar.zst"), mergeError, "'etc/b' of " + filepath.Join(dir, "day3.tar.zst") + " is also in " + filepath.Join(dir, "day2.tar.gz")},
+		// The target of the link is replaced by a later input, or taken from an earlier one
+		{in("day1.tar", "c.tar"), mergeLast, "hard link 'link' of " + filepath.Join(dir, "day1.tar") + " points to 'c', which the merge takes from another input"},
+		{in("day1.tar", "link.tar"), mergeFirst, "hard link 'link2' of " + filepath.Join(dir, "link.tar") + " points to 'c', which the merge takes from another input"},
+		{in("link.tar"), mergeLast, "hard link 'link2' of " + filepath.Join(dir, "link.tar") + " points to 'c'"},
+	}
+	for _, test := range tests {
+		out := filepath.Join(dir, "out", "merged.tar")
+		if err := os.MkdirAll(filepath.Dir(out), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		_, err := runMerge(t, test.inputs, out, test.policy, false)
+		if clierr.CodeOf(err) != clierr.Conflict || err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s of %v = %v, want %q", test.policy, test.inputs, err, test.want)
+		}
+		if left, _ := os.ReadDir(filepath.Dir(out)); len(left) != 0 {
+			t.Errorf("failed merge left %v", left)
+		}
+	}
+
+	if _, err := runMerge(t, []string{filepath.Join(dir, "missing.tar")}, filepath.Join(dir, "out", "merged.tar"), mergeLast, false); err == nil {
+		t.Error("merging a missing input succeeded")
+	}
+}
@@ -0,0 +1,57 @@
+package files
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gsn-dev-tools/internals/output"
+	"gsn-dev-tools/internals/style"
+
+	"github.com/spf13/cobra"
+)
+
+// addMetricsFlags registers the Prometheus textfile flags on a command
+func addMetricsFlags(cmd *cobra.Command) {
+	cmd.Flags().String("metrics-file", "", "Write Prometheus textfile collector metrics to this path on completion")
+	cmd.Flags().String("metrics-profile", "default", "Value of the profile label in the written metrics")
+}
+
+// writeCompressionMetrics records the outcome of a compression run, warning instead of failing on errors
+func writeCompressionMetrics(cmd *cobra.Command, source string, startTime time.Time, result *CompressResult, runErr error) {
+	path, _ := cmd.Flags().GetString("metrics-file")
+	if path == "" {
+		return
+	}
+	profile, _ := cmd.Flags().GetString("metrics-profile")
+
+	if abs, err := filepath.Abs(source); err == nil {
+		source = abs
+	}
+	labels := map[string]string{"profile": profile, "source": source}
+
+	var sourceBytes, archiveBytes, files float64
+	if result != nil {
+		sourceBytes = float64(result.SourceSize)
+		archiveBytes = float64(result.ArchiveSize)
+		files = float64(result.FileCount)
+	}
+	success := 1.0
+	if runErr != nil {
+		success = 0
+	}
+
+	gauges := []output.Gauge{
+		{Name: "gsn_cmp_last_run_timestamp_seconds", Help: "Unix time the last compression run finished.", Labels: labels, Value: float64(time.Now().Unix())},
+		{Name: "gsn_cmp_duration_seconds", Help: "Duration of the last compression run.", Labels: labels, Value: time.Since(startTime).Seconds()},
+		{Name: "gsn_cmp_source_bytes", Help: "Total size of the compressed source.", Labels: labels, Value: sourceBytes},
+		{Name: "gsn_cmp_archive_bytes", Help: "Size of the produced archive.", Labels: labels, Value: archiveBytes},
+		{Name: "gsn_cmp_files", Help: "Number of files written to the archive.", Labels: labels, Value: files},
+		{Name: "gsn_cmp_success", Help: "Whether the last compression run succeeded (1) or failed (0).", Labels: labels, Value: success},
+	}
+
+	if err := output.WritePrometheusFile(path, gauges); err != nil {
+		fmt.Fprintf(os.Stderr, style.Warning()+"Failed to write metrics file: %v\n", err)
+	}
+}
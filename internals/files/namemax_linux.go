@@ -0,0 +1,14 @@
+//go:build linux
+
+package files
+
+import "golang.org/x/sys/unix"
+
+// nameMax returns the longest file name in bytes the filesystem holding dir accepts
+func nameMax(dir string) int {
+	var fs unix.Statfs_t
+	if err := unix.Statfs(dir, &fs); err != nil || fs.Namelen <= 0 {
+		return defaultNameMax
+	}
+	return int(fs.Namelen)
+}
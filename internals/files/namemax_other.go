@@ -0,0 +1,8 @@
+//go:build !linux
+
+package files
+
+// nameMax assumes the 255 bytes common to the filesystems of other systems
+func nameMax(dir string) int {
+	return defaultNameMax
+}
@@ -0,0 +1,583 @@
+package files
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// arMagic starts an ar archive, the container of Debian packages
+	arMagic = []byte("!<arch>\n")
+	// rpmMagic starts the lead of an RPM package
+	rpmMagic = []byte{0xed, 0xab, 0xee, 0xdb}
+	// rpmHeaderMagic starts the signature and the main header of an RPM package
+	rpmHeaderMagic = []byte{0x8e, 0xad, 0xe8, 0x01}
+	// xzMagic and lzmaMagic start members compressed with codecs gsn has no reader for
+	xzMagic   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	lzmaMagic = []byte{0x5d, 0x00, 0x00}
+)
+
+const (
+	// rpmLeadSize is the size of the obsolete lead before the headers of an RPM package
+	rpmLeadSize = 96
+	// maxRPMHeader bounds the index and the data of an RPM header, the largest real packages use a few MiB
+	maxRPMHeader = 256 << 20
+	// maxEntryName bounds the names of ar members and cpio entries, far above any path a filesystem takes
+	maxEntryName = 64 << 10
+	// maxArNames bounds the table of long member names of an ar archive
+	maxArNames = 1 << 20
+	// cpioTrailer names the entry ending a cpio archive
+	cpioTrailer = "TRAILER!!!"
+)
+
+// openPackage opens a Debian or RPM package as an archive, nil when br starts with neither
+func openPackage(file *os.File, br *bufio.Reader) (entryReader, error) {
+	magic, _ := br.Peek(len(arMagic))
+	switch {
+	case bytes.HasPrefix(magic, arMagic):
+		if _, err := br.Discard(len(arMagic)); err != nil {
+			return nil, err
+		}
+		return &debEntryReader{ar: &arReader{r: br}, file: file}, nil
+	case bytes.HasPrefix(magic, rpmMagic):
+		return newRPMEntryReader(file, br)
+	default:
+		return nil, nil
+	}
+}
+
+// openMemberStream decompresses a member nested in a package or an image, the closer releases the decoder
+func openMemberStream(r io.Reader, member string) (io.Reader, io.Closer, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(xzMagic))
+	if bytes.HasPrefix(magic, xzMagic) || bytes.HasPrefix(magic, lzmaMagic) {
+		return nil, nil, fmt.Errorf("'%s' is compressed with xz or lzma, which gsn cannot read", member)
+	}
+	c, _ := detectCodec(br)
+	return newCodecReader(br, c)
+}
+
+// nestedName moves the name of an entry of member below prefix. Names are relative to the member, a name
+// escaping it is refused like one escaping the destination directory. The member root itself is "".
+func nestedName(prefix string, name string, member string) (string, error) {
+	trimmed := strings.TrimSuffix(strings.TrimPrefix(name, "./"), "/")
+	if trimmed == "" || trimmed == "." {
+		return "", nil
+	}
+	cleaned := path.Clean(trimmed)
+	if path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("entry '%s' of '%s' escapes it", name, member)
+	}
+	return prefix + cleaned, nil
+}
+
+// nestedTar reads a tar member of a package or an image, its entries are named below prefix and led by a
+// directory entry for prefix itself
+type nestedTar struct {
+	tr      *tar.Reader
+	member  string
+	prefix  string
+	root    *tar.Header
+	decoder io.Closer
+}
+
+func newNestedTar(r io.Reader, member string, prefix string, modTime time.Time) (*nestedTar, error) {
+	stream, decoder, err := openMemberStream(r, member)
+	if err != nil {
+		return nil, err
+	}
+	root := &tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     prefix,
+		Mode:     0o755,
+		ModTime:  modTime,
+		Uid:      os.Getuid(),
+		Gid:      os.Getgid(),
+		Format:   tar.FormatPAX,
+	}
+	return &nestedTar{tr: tar.NewReader(stream), member: member, prefix: prefix, root: root, decoder: decoder}, nil
+}
+
+func (n *nestedTar) Next() (*tar.Header, error) {
+	if root := n.root; root != nil {
+		n.root = nil
+		return root, nil
+	}
+	for {
+		header, err := n.tr.Next()
+		if err == io.EOF {
+			return nil, err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read '%s': %w", n.member, err)
+		}
+		name, err := nestedName(n.prefix, header.Name, n.member)
+		if err != nil {
+			return nil, err
+		}
+		if name == "" {
+			continue
+		}
+		nested := *header
+		nested.Name = name
+		if header.Typeflag == tar.TypeDir {
+			nested.Name += "/"
+		}
+		if header.Typeflag == tar.TypeLink {
+			if nested.Linkname, err = nestedName(n.prefix, header.Linkname, n.member); err != nil {
+				return nil, err
+			}
+		}
+		return &nested, nil
+	}
+}
+
+func (n *nestedTar) Read(p []byte) (int, error) {
+	return n.tr.Read(p)
+}
+
+func (n *nestedTar) Close() error {
+	return n.decoder.Close()
+}
+
+// arMember is the header of a member of an ar archive
+type arMember struct {
+	Name    string
+	Size    int64
+	Mode    int64
+	ModTime time.Time
+	Uid     int
+	Gid     int
+}
+
+// arReader reads the members of an ar archive whose global header was consumed, in the common format written
+// by GNU and BSD ar
+type arReader struct {
+	r         *bufio.Reader
+	remaining int64
+	pad       int64
+	// longNames is the table of the GNU "//" member, names of "/<offset>" point into it
+	longNames []byte
+}
+
+func (a *arReader) Next() (arMember, error) {
+	for {
+		if _, err := a.r.Discard(int(a.remaining + a.pad)); err != nil {
+			return arMember{}, unexpectedEOF(err)
+		}
+		a.remaining, a.pad = 0, 0
+
+		var block [60]byte
+		if _, err := io.ReadFull(a.r, block[:]); err != nil {
+			if err == io.EOF {
+				return arMember{}, err
+			}
+			return arMember{}, fmt.Errorf("failed to read ar member header: %w", unexpectedEOF(err))
+		}
+		if string(block[58:60]) != "`\n" {
+			return arMember{}, errors.New("corrupt ar member header")
+		}
+		field := func(from, to int) string { return strings.TrimSpace(string(block[from:to])) }
+		size, err := strconv.ParseInt(field(48, 58), 10, 64)
+		if err != nil || size < 0 {
+			return arMember{}, fmt.Errorf("corrupt ar member size '%s'", field(48, 58))
+		}
+		mtime, _ := strconv.ParseInt(field(16, 28), 10, 64)
+		uid, _ := strconv.Atoi(field(28, 34))
+		gid, _ := strconv.Atoi(field(34, 40))
+		mode, _ := strconv.ParseInt(field(40, 48), 8, 64)
+		a.remaining, a.pad = size, size%2
+
+		member := arMember{Name: field(0, 16), Size: size, Mode: mode & 0o7777, ModTime: time.Unix(mtime, 0), Uid: uid, Gid: gid}
+		switch {
+		case member.Name == "/" || member.Name == "/SYM64/":
+			// Symbol table of object archives
+			continue
+		case member.Name == "//":
+			if size > maxArNames {
+				return arMember{}, errors.New("ar name table is too large")
+			}
+			if a.longNames, err = io.ReadAll(a); err != nil {
+				return arMember{}, unexpectedEOF(err)
+			}
+			continue
+		case strings.HasPrefix(member.Name, "#1/"):
+			// BSD stores long names at the start of the data
+			length, err := strconv.Atoi(member.Name[3:])
+			if err != nil || length < 0 || int64(length) > size || length > maxEntryName {
+				return arMember{}, fmt.Errorf("corrupt ar member name '%s'", member.Name)
+			}
+			name := make([]byte, length)
+			if _, err := io.ReadFull(a, name); err != nil {
+				return arMember{}, unexpectedEOF(err)
+			}
+			member.Name = string(bytes.TrimRight(name, "\x00"))
+			member.Size -= int64(length)
+		case strings.HasPrefix(member.Name, "/"):
+			offset, err := strconv.Atoi(member.Name[1:])
+			if err != nil || offset < 0 || offset >= len(a.longNames) {
+				return arMember{}, fmt.Errorf("corrupt ar member name '%s'", member.Name)
+			}
+			name, _, _ := strings.Cut(string(a.longNames[offset:]), "\n")
+			member.Name = strings.TrimSuffix(name, "/")
+		default:
+			member.Name = strings.TrimSuffix(member.Name, "/")
+		}
+		return member, nil
+	}
+}
+
+func (a *arReader) Read(p []byte) (int, error) {
+	if a.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > a.remaining {
+		p = p[:a.remaining]
+	}
+	n, err := a.r.Read(p)
+	a.remaining -= int64(n)
+	if err == io.EOF && a.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// unexpectedEOF turns the end of the stream inside a structure into io.ErrUnexpectedEOF
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// debMemberPrefix returns the directory the tar member of a Debian package is listed below: control/ for the
+// maintainer scripts and metadata, data/ for the files the package installs
+func debMemberPrefix(name string) (string, bool) {
+	for _, member := range []string{"control", "data"} {
+		if name == member+".tar" || strings.HasPrefix(name, member+".tar.") {
+			return member + "/", true
+		}
+	}
+	return "", false
+}
+
+// debEntryReader lists a Debian package: debian-binary and any other plain member as entries of their own, the
+// control and data tar members as directories of their entries
+type debEntryReader struct {
+	ar      *arReader
+	file    *os.File
+	nested  *nestedTar
+	current io.Reader
+}
+
+func (d *debEntryReader) Next() (*tar.Header, error) {
+	d.current = nil
+	for {
+		if d.nested != nil {
+			header, err := d.nested.Next()
+			if err == io.EOF {
+				d.nested.Close()
+				d.nested = nil
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			d.current = d.nested
+			return header, nil
+		}
+
+		member, err := d.ar.Next()
+		if err != nil {
+			if err != io.EOF {
+				err = fmt.Errorf("failed to read package: %w", err)
+			}
+			return nil, err
+		}
+		if prefix, ok := debMemberPrefix(member.Name); ok {
+			if d.nested, err = newNestedTar(d.ar, member.Name, prefix, member.ModTime); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		name, err := nestedName("", member.Name, "package")
+		if err != nil {
+			return nil, err
+		}
+		d.current = d.ar
+		return &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Size:     member.Size,
+			Mode:     member.Mode,
+			ModTime:  member.ModTime,
+			Uid:      member.Uid,
+			Gid:      member.Gid,
+			Format:   tar.FormatPAX,
+		}, nil
+	}
+}
+
+func (d *debEntryReader) Read(p []byte) (int, error) {
+	if d.current == nil {
+		return 0, io.EOF
+	}
+	return d.current.Read(p)
+}
+
+func (d *debEntryReader) Close() error {
+	if d.nested != nil {
+		d.nested.Close()
+	}
+	return d.file.Close()
+}
+
+// newRPMEntryReader skips the lead, the signature and the main header of an RPM package and reads the cpio
+// payload after them. The headers describe the package, the entries come from the payload.
+func newRPMEntryReader(file *os.File, br *bufio.Reader) (entryReader, error) {
+	if _, err := br.Discard(rpmLeadSize); err != nil {
+		return nil, fmt.Errorf("failed to read package: %w", unexpectedEOF(err))
+	}
+	// The signature is padded to 8 bytes, the main header follows it directly
+	for _, padded := range []bool{true, false} {
+		if err := skipRPMHeader(br, padded); err != nil {
+			return nil, err
+		}
+	}
+	stream, decoder, err := openMemberStream(br, "payload")
+	if err != nil {
+		return nil, err
+	}
+	return &cpioEntryReader{r: bufio.NewReader(stream), closers: []io.Closer{decoder, file}, pending: map[cpioInode][]*tar.Header{}}, nil
+}
+
+// skipRPMHeader skips an RPM header structure: a 16 byte intro with the number of index entries and the size of
+// the data, then the index and the data
+func skipRPMHeader(br *bufio.Reader, padded bool) error {
+	var intro [16]byte
+	if _, err := io.ReadFull(br, intro[:]); err != nil {
+		return fmt.Errorf("failed to read package header: %w", unexpectedEOF(err))
+	}
+	if !bytes.HasPrefix(intro[:], rpmHeaderMagic) {
+		return errors.New("corrupt package header")
+	}
+	size := 16*int64(binary.BigEndian.Uint32(intro[8:12])) + int64(binary.BigEndian.Uint32(intro[12:16]))
+	if size > maxRPMHeader {
+		return fmt.Errorf("package header of %d bytes is too large", size)
+	}
+	if padded {
+		size += (8 - size%8) % 8
+	}
+	if _, err := br.Discard(int(size)); err != nil {
+		return fmt.Errorf("failed to read package header: %w", unexpectedEOF(err))
+	}
+	return nil
+}
+
+// cpioInode identifies a file of a cpio archive, the names sharing it are hard links
+type cpioInode struct {
+	dev   uint64
+	inode uint64
+}
+
+// cpioEntryReader reads a cpio archive in the new ASCII format RPM payloads use. Hard links are stored as entries
+// without content before the one holding it, they are listed after it as links to it.
+type cpioEntryReader struct {
+	r         *bufio.Reader
+	closers   []io.Closer
+	remaining int64
+	pad       int64
+	// pending are the empty names of each inode waiting for its content, in the order the inodes appeared
+	pending map[cpioInode][]*tar.Header
+	inodes  []cpioInode
+	// queue are the links to list before reading on
+	queue []*tar.Header
+	done  bool
+}
+
+func (c *cpioEntryReader) Next() (*tar.Header, error) {
+	for {
+		if _, err := c.r.Discard(int(c.remaining + c.pad)); err != nil {
+			return nil, fmt.Errorf("failed to read payload: %w", unexpectedEOF(err))
+		}
+		c.remaining, c.pad = 0, 0
+		if len(c.queue) > 0 {
+			header := c.queue[0]
+			c.queue = c.queue[1:]
+			return header, nil
+		}
+		if c.done {
+			return nil, io.EOF
+		}
+
+		header, inode, links, err := c.readHeader()
+		if err != nil {
+			return nil, err
+		}
+		if header == nil {
+			c.flushPending()
+			c.done = true
+			continue
+		}
+		if header.Typeflag == tar.TypeReg && links > 1 {
+			if header.Size == 0 {
+				if _, ok := c.pending[inode]; !ok {
+					c.inodes = append(c.inodes, inode)
+				}
+				c.pending[inode] = append(c.pending[inode], header)
+				continue
+			}
+			for _, link := range c.pending[inode] {
+				c.queue = append(c.queue, hardLinkTo(link, header.Name))
+			}
+			delete(c.pending, inode)
+		}
+		if header.Name == "" {
+			continue
+		}
+		return header, nil
+	}
+}
+
+// flushPending lists the hard linked names whose content never came, the files are empty: the first name as
+// a file and the others as links to it
+func (c *cpioEntryReader) flushPending() {
+	for _, inode := range c.inodes {
+		names, ok := c.pending[inode]
+		if !ok {
+			continue
+		}
+		c.queue = append(c.queue, names[0])
+		for _, link := range names[1:] {
+			c.queue = append(c.queue, hardLinkTo(link, names[0].Name))
+		}
+	}
+	c.pending = nil
+}
+
+func hardLinkTo(header *tar.Header, target string) *tar.Header {
+	link := *header
+	link.Typeflag = tar.TypeLink
+	link.Linkname = target
+	return &link
+}
+
+// readHeader reads the next cpio header and its name, nil at the trailer. Entries of the root directory and of
+// sockets have an empty name, they are read past.
+func (c *cpioEntryReader) readHeader() (*tar.Header, cpioInode, int64, error) {
+	var block [110]byte
+	if _, err := io.ReadFull(c.r, block[:]); err != nil {
+		return nil, cpioInode{}, 0, fmt.Errorf("failed to read payload: %w", unexpectedEOF(err))
+	}
+	magic := string(block[:6])
+	if magic != "070701" && magic != "070702" {
+		return nil, cpioInode{}, 0, fmt.Errorf("unsupported payload format, cpio magic '%s'", magic)
+	}
+	var fields [13]uint64
+	for i := range fields {
+		value, err := strconv.ParseUint(string(block[6+8*i:14+8*i]), 16, 32)
+		if err != nil {
+			return nil, cpioInode{}, 0, errors.New("corrupt cpio header")
+		}
+		fields[i] = value
+	}
+	inode, mode, uid, gid, links, mtime, size := fields[0], fields[1], fields[2], fields[3], fields[4], fields[5], fields[6]
+	devMajor, devMinor, rdevMajor, rdevMinor, nameSize := fields[7], fields[8], fields[9], fields[10], fields[11]
+	if nameSize == 0 || nameSize > maxEntryName {
+		return nil, cpioInode{}, 0, fmt.Errorf("corrupt cpio name size %d", nameSize)
+	}
+
+	name := make([]byte, nameSize)
+	if _, err := io.ReadFull(c.r, name); err != nil {
+		return nil, cpioInode{}, 0, fmt.Errorf("failed to read payload: %w", unexpectedEOF(err))
+	}
+	if _, err := c.r.Discard(int((4 - (110+nameSize)%4) % 4)); err != nil {
+		return nil, cpioInode{}, 0, fmt.Errorf("failed to read payload: %w", unexpectedEOF(err))
+	}
+	rawName := string(bytes.TrimRight(name, "\x00"))
+	if rawName == cpioTrailer {
+		return nil, cpioInode{}, 0, nil
+	}
+	c.remaining, c.pad = int64(size), int64((4-size%4)%4)
+
+	entryName, err := nestedName("", rawName, "payload")
+	if err != nil {
+		return nil, cpioInode{}, 0, err
+	}
+	header := &tar.Header{
+		Name:     entryName,
+		Mode:     int64(mode & 0o7777),
+		Uid:      int(uid),
+		Gid:      int(gid),
+		ModTime:  time.Unix(int64(mtime), 0),
+		Devmajor: int64(rdevMajor),
+		Devminor: int64(rdevMinor),
+		Format:   tar.FormatPAX,
+	}
+	switch mode & 0o170000 {
+	case 0o040000:
+		header.Typeflag = tar.TypeDir
+		if header.Name != "" {
+			header.Name += "/"
+		}
+	case 0o100000:
+		header.Typeflag = tar.TypeReg
+		header.Size = int64(size)
+	case 0o120000:
+		if size > maxEntryName {
+			return nil, cpioInode{}, 0, fmt.Errorf("corrupt symlink target of '%s'", rawName)
+		}
+		target := make([]byte, size)
+		if _, err := io.ReadFull(c.r, target); err != nil {
+			return nil, cpioInode{}, 0, fmt.Errorf("failed to read payload: %w", unexpectedEOF(err))
+		}
+		c.remaining = 0
+		header.Typeflag = tar.TypeSymlink
+		header.Linkname = string(target)
+	case 0o020000:
+		header.Typeflag = tar.TypeChar
+	case 0o060000:
+		header.Typeflag = tar.TypeBlock
+	case 0o010000:
+		header.Typeflag = tar.TypeFifo
+	default:
+		// Sockets cannot be stored in a tar header, they are left out
+		header.Name = ""
+	}
+	return header, cpioInode{dev: devMajor<<32 | devMinor, inode: inode}, int64(links), nil
+}
+
+func (c *cpioEntryReader) Read(p []byte) (int, error) {
+	if c.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	if err == io.EOF && c.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (c *cpioEntryReader) Close() error {
+	var firstErr error
+	for _, closer := range c.closers {
+		if err := closer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
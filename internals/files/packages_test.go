@@ -0,0 +1,416 @@
+package files
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tarBytes writes entries as a tar stream compressed with c
+func tarBytes(t *testing.T, c codec, entries []fixtureEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := newCodecWriter(&buf, c, memoryBudget{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(w)
+	for _, e := range entries {
+		writeFixtureEntry(t, tw, e)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// arFile is a member of an ar fixture
+type arFile struct {
+	name string
+	data []byte
+}
+
+// arBytes writes files as an ar archive like dpkg-deb does. Names longer than the 16 bytes of the header go
+// into a GNU name table, or at the start of the data in the BSD way with bsd set.
+func arBytes(bsd bool, files ...arFile) []byte {
+	var buf, names bytes.Buffer
+	buf.Write(arMagic)
+	header := func(name string, size int) {
+		fmt.Fprintf(&buf, "%-16s%-12d%-6d%-6d%-8o%-10d`\n", name, fixtureTime.Unix(), 0, 0, 0o100644, size)
+	}
+	member := func(name string, data []byte) {
+		header(name, len(data))
+		buf.Write(data)
+		if len(data)%2 == 1 {
+			buf.WriteByte('\n')
+		}
+	}
+	var offsets []int
+	for _, f := range files {
+		offsets = append(offsets, names.Len())
+		if len(f.name) > 15 && !bsd {
+			names.WriteString(f.name + "/\n")
+		}
+	}
+	if names.Len() > 0 {
+		member("//", names.Bytes())
+	}
+	for i, f := range files {
+		switch {
+		case len(f.name) <= 15:
+			member(f.name, f.data)
+		case bsd:
+			member(fmt.Sprintf("#1/%d", len(f.name)), append([]byte(f.name), f.data...))
+		default:
+			member(fmt.Sprintf("/%d", offsets[i]), f.data)
+		}
+	}
+	return buf.Bytes()
+}
+
+// cpioFile is an entry of a cpio fixture in the newc format of RPM payloads
+type cpioFile struct {
+	name  string
+	mode  uint32
+	inode uint32
+	links uint32
+	data  string
+}
+
+func cpioBytes(files ...cpioFile) []byte {
+	var buf bytes.Buffer
+	for _, f := range append(files, cpioFile{name: cpioTrailer, links: 1}) {
+		fmt.Fprintf(&buf, "070701%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X",
+			f.inode, f.mode, 0, 0, f.links, fixtureTime.Unix(), len(f.data), 0, 1, 0, 0, len(f.name)+1, 0)
+		buf.WriteString(f.name + "\x00")
+		buf.Write(make([]byte, (4-buf.Len()%4)%4))
+		buf.WriteString(f.data)
+		buf.Write(make([]byte, (4-buf.Len()%4)%4))
+	}
+	return buf.Bytes()
+}
+
+// rpmBytes puts payload behind the lead, the signature and the main header of an RPM package. The headers hold
+// only zeros, the reader skips them by their sizes.
+func rpmBytes(payload []byte) []byte {
+	var buf bytes.Buffer
+	buf.Write(rpmMagic)
+	buf.Write(make([]byte, rpmLeadSize-len(rpmMagic)))
+	header := func(entries int, size int, padded bool) {
+		buf.Write(rpmHeaderMagic)
+		buf.Write(make([]byte, 4))
+		_ = binary.Write(&buf, binary.BigEndian, [2]uint32{uint32(entries), uint32(size)})
+		buf.Write(make([]byte, 16*entries+size))
+		if padded {
+			buf.Write(make([]byte, (8-(16*entries+size)%8)%8))
+		}
+	}
+	header(2, 13, true)
+	header(3, 40, false)
+	buf.Write(payload)
+	return buf.Bytes()
+}
+
+// writeFixtureFile writes data to name in a new temp dir and returns its path
+func writeFixtureFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// listArchive lists the archive at path like readFixtureTar, stopping at the first error
+func listArchive(path string) ([]string, error) {
+	r, err := openArchive(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var lines []string
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			return lines, nil
+		}
+		if err != nil {
+			return lines, err
+		}
+		body, err := io.ReadAll(r)
+		if err != nil {
+			return lines, err
+		}
+		lines = append(lines, describeEntry(header, body))
+	}
+}
+
+// describeEntry is a line naming an entry, its type and its mode or content
+func describeEntry(header *tar.Header, body []byte) string {
+	switch header.Typeflag {
+	case tar.TypeDir:
+		return fmt.Sprintf("%s %04o", header.Name, header.Mode)
+	case tar.TypeSymlink:
+		return header.Name + " -> " + header.Linkname
+	case tar.TypeLink:
+		return header.Name + " => " + header.Linkname
+	case tar.TypeFifo:
+		return header.Name + " fifo"
+	default:
+		return fmt.Sprintf("%s %04o %q", header.Name, header.Mode, body)
+	}
+}
+
+func checkListing(t *testing.T, what string, path string, want ...string) {
+	t.Helper()
+	got, err := listArchive(path)
+	if err != nil {
+		t.Fatalf("%s: %v", what, err)
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("%s lists\n%s\nwant\n%s", what, strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// debFixture is a package as dpkg-deb builds it, with a gzip control member, a zstd data member and a signature
+// member whose name needs the name table
+func debFixture(t *testing.T, bsd bool) []byte {
+	t.Helper()
+	control := tarBytes(t, codecGzip, []fixtureEntry{
+		{Name: "./", Type: tar.TypeDir},
+		{Name: "./control", Body: "Package: tool\nVersion: 1.0\n"},
+		{Name: "./postinst", Body: "#!/bin/sh\n", Mode: 0o755},
+	})
+	data := tarBytes(t, codecZstd, []fixtureEntry{
+		{Name: "./", Type: tar.TypeDir},
+		{Name: "./usr/", Type: tar.TypeDir},
+		{Name: "./usr/bin/", Type: tar.TypeDir},
+		{Name: "./usr/bin/tool", Body: "#!/bin/sh\necho tool\n", Mode: 0o755},
+		{Name: "./usr/bin/t", Type: tar.TypeSymlink, Link: "tool"},
+		{Name: "./usr/bin/tool2", Type: tar.TypeLink, Link: "./usr/bin/tool"},
+	})
+	return arBytes(bsd,
+		arFile{"debian-binary", []byte("2.0\n")},
+		arFile{"control.tar.gz", control},
+		arFile{"data.tar.zst", data},
+		arFile{"_gpgorigin-maintainer.asc", []byte("signature")},
+	)
+}
+
+func TestDebianPackage(t *testing.T) {
+	want := []string{
+		`debian-binary 0644 "2.0\n"`,
+		"control/ 0755",
+		`control/control 0644 "Package: tool\nVersion: 1.0\n"`,
+		`control/postinst 0755 "#!/bin/sh\n"`,
+		"data/ 0755",
+		"data/usr/ 0755",
+		"data/usr/bin/ 0755",
+		`data/usr/bin/tool 0755 "#!/bin/sh\necho tool\n"`,
+		"data/usr/bin/t -> tool",
+		"data/usr/bin/tool2 => data/usr/bin/tool",
+		`_gpgorigin-maintainer.asc 0644 "signature"`,
+	}
+	checkListing(t, "deb", writeFixtureFile(t, "tool_1.0_amd64.deb", debFixture(t, false)), want...)
+	checkListing(t, "deb with BSD names", writeFixtureFile(t, "tool.deb", debFixture(t, true)), want...)
+}
+
+func TestDebianPackageErrors(t *testing.T) {
+	xz := append(append([]byte{}, xzMagic...), "rest of the stream"...)
+	escaping := tarBytes(t, codecNone, []fixtureEntry{{Name: "./usr/", Type: tar.TypeDir}, {Name: "../etc/passwd", Body: "root"}})
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"xz data", arBytes(false, arFile{"debian-binary", []byte("2.0\n")}, arFile{"data.tar.xz", xz}), "'data.tar.xz' is compressed with xz or lzma, which gsn cannot read"},
+		{"escaping entry", arBytes(false, arFile{"data.tar", escaping}), "entry '../etc/passwd' of 'data.tar' escapes it"},
+		{"truncated header", arBytes(false, arFile{"debian-binary", []byte("2.0\n")})[:40], "failed to read package: failed to read ar member header: unexpected EOF"},
+		{"truncated member", arBytes(false, arFile{"debian-binary", []byte("2.0\n")})[:70], "unexpected EOF"},
+		{"bad header", append(append([]byte{}, arMagic...), bytes.Repeat([]byte{' '}, 60)...), "corrupt ar member header"},
+		{"bad long name", append(append([]byte{}, arMagic...), fmt.Sprintf("%-16s%-12d%-6d%-6d%-8o%-10d`\n", "/99", 0, 0, 0, 0o644, 0)...), "corrupt ar member name '/99'"},
+	}
+	for _, test := range tests {
+		_, err := listArchive(writeFixtureFile(t, "broken.deb", test.data))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: %v, want %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestRPMPackage(t *testing.T) {
+	payload := cpioBytes(
+		cpioFile{name: ".", mode: 0o040755, inode: 1, links: 2},
+		cpioFile{name: "./usr", mode: 0o040755, inode: 2, links: 2},
+		cpioFile{name: "./usr/bin/tool", mode: 0o100755, inode: 3, links: 1, data: "#!/bin/sh\n"},
+		cpioFile{name: "./usr/bin/t", mode: 0o120777, inode: 4, links: 1, data: "tool"},
+		// Hard links: the names without content come first, the last name holds it
+		cpioFile{name: "./usr/lib/a.so", mode: 0o100644, inode: 7, links: 2},
+		cpioFile{name: "./usr/lib/b.so", mode: 0o100644, inode: 7, links: 2, data: "\x7fELF"},
+		// Hard links whose content never comes are empty files
+		cpioFile{name: "./etc/x.conf", mode: 0o100600, inode: 9, links: 2},
+		cpioFile{name: "./etc/y.conf", mode: 0o100600, inode: 9, links: 2},
+		cpioFile{name: "./run/pipe", mode: 0o010644, inode: 10, links: 1},
+		// Sockets cannot be stored in a tar header
+		cpioFile{name: "./run/sock", mode: 0o140755, inode: 11, links: 1},
+	)
+	var gz bytes.Buffer
+	w, _ := newCodecWriter(&gz, codecGzip, memoryBudget{})
+	w.Write(payload)
+	w.Close()
+
+	want := []string{
+		"usr/ 0755",
+		`usr/bin/tool 0755 "#!/bin/sh\n"`,
+		"usr/bin/t -> tool",
+		`usr/lib/b.so 0644 "\x7fELF"`,
+		"usr/lib/a.so => usr/lib/b.so",
+		"run/pipe fifo",
+		`etc/x.conf 0600 ""`,
+		"etc/y.conf => etc/x.conf",
+	}
+	checkListing(t, "rpm", writeFixtureFile(t, "tool-1.0.x86_64.rpm", rpmBytes(gz.Bytes())), want...)
+	checkListing(t, "rpm without compression", writeFixtureFile(t, "tool.rpm", rpmBytes(payload)), want...)
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"escaping entry", rpmBytes(cpioBytes(cpioFile{name: "../../etc/passwd", mode: 0o100644, inode: 1, links: 1, data: "root"})), "entry '../../etc/passwd' of 'payload' escapes it"},
+		{"odc payload", rpmBytes([]byte(strings.Repeat("070707", 30))), "unsupported payload format, cpio magic '070707'"},
+		{"bad header magic", append(rpmBytes(nil)[:rpmLeadSize], make([]byte, 16)...), "corrupt package header"},
+		{"truncated payload", rpmBytes(payload[:200]), "failed to read payload"},
+	}
+	for _, test := range tests {
+		_, err := listArchive(writeFixtureFile(t, "broken.rpm", test.data))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: %v, want %q", test.name, err, test.want)
+		}
+	}
+}
+
+// dockerFixture is a tarball in the layout of docker save before OCI: a directory per layer named by its id,
+// and a third layer linked to the first, as docker writes a layer an image holds twice
+func dockerFixture(t *testing.T, layers []string, extraLayer []fixtureEntry) []fixtureEntry {
+	t.Helper()
+	a, b, c := strings.Repeat("a", 64), strings.Repeat("b", 64), strings.Repeat("c", 64)
+	manifest, err := json.Marshal([]map[string]any{{"Config": strings.Repeat("d", 64) + ".json", "RepoTags": []string{"app:1"}, "Layers": layers}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := []fixtureEntry{
+		{Name: a + "/", Type: tar.TypeDir},
+		{Name: a + "/VERSION", Body: "1.0"},
+		{Name: a + "/layer.tar", Body: string(tarBytes(t, codecNone, []fixtureEntry{
+			{Name: "etc/", Type: tar.TypeDir},
+			{Name: "etc/os-release", Body: "ID=alpine\n"},
+		}))},
+		{Name: b + "/", Type: tar.TypeDir},
+		{Name: b + "/layer.tar", Body: string(tarBytes(t, codecNone, append([]fixtureEntry{
+			{Name: "app/", Type: tar.TypeDir},
+			{Name: "app/main", Body: "binary", Mode: 0o755},
+			{Name: "app/current", Type: tar.TypeLink, Link: "app/main"},
+		}, extraLayer...)))},
+		{Name: c + "/", Type: tar.TypeDir},
+		{Name: c + "/layer.tar", Type: tar.TypeSymlink, Link: "../" + a + "/layer.tar"},
+		{Name: strings.Repeat("d", 64) + ".json", Body: "{}"},
+		{Name: "manifest.json", Body: string(manifest)},
+		{Name: "repositories", Body: `{"app":{"1":"` + b + `"}}`},
+	}
+	return entries
+}
+
+func TestDockerSave(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	a, b, c := strings.Repeat("a", 64), strings.Repeat("b", 64), strings.Repeat("c", 64)
+	layers := []string{a + "/layer.tar", b + "/layer.tar", c + "/layer.tar"}
+	want := []string{
+		a + "/ 0755",
+		a + `/VERSION 0644 "1.0"`,
+		b + "/ 0755",
+		c + "/ 0755",
+		strings.Repeat("d", 64) + `.json 0644 "{}"`,
+		"", // manifest.json
+		"", // repositories
+		// The layers in manifest order, base first, the one linked twice once
+		"layer-1/ 0755",
+		"layer-1/etc/ 0755",
+		`layer-1/etc/os-release 0644 "ID=alpine\n"`,
+		"layer-2/ 0755",
+		"layer-2/app/ 0755",
+		`layer-2/app/main 0755 "binary"`,
+		"layer-2/app/current => layer-2/app/main",
+	}
+	for _, name := range []string{"image.tar", "image.tar.gz"} {
+		path := filepath.Join(t.TempDir(), name)
+		entries := dockerFixture(t, layers, nil)
+		writeFixtureTar(t, path, entries)
+		want[5] = fmt.Sprintf("manifest.json 0644 %q", entries[8].Body)
+		want[6] = fmt.Sprintf("repositories 0644 %q", entries[9].Body)
+		checkListing(t, name, path, want...)
+	}
+	if spooled, _ := os.ReadDir(os.Getenv("TMPDIR")); len(spooled) != 0 {
+		t.Errorf("the decompressed image was left in %s: %v", os.Getenv("TMPDIR"), spooled)
+	}
+
+	// Without a manifest listing layers it is a plain tar
+	plain := filepath.Join(t.TempDir(), "plain.tar")
+	writeFixtureTar(t, plain, dockerFixture(t, nil, nil))
+	if got, err := listArchive(plain); err != nil || len(got) != 10 || !strings.HasPrefix(got[2], a+"/layer.tar ") {
+		t.Errorf("a tar of layer directories without layers in its manifest = %v, %v", got, err)
+	}
+
+	tests := []struct {
+		name   string
+		layers []string
+		extra  []fixtureEntry
+		want   string
+	}{
+		{"missing layer", []string{a + "/layer.tar", strings.Repeat("e", 64) + "/layer.tar"}, nil, "layer '" + strings.Repeat("e", 64) + "/layer.tar' listed in manifest.json is missing"},
+		{"escaping layer entry", layers, []fixtureEntry{{Name: "../../evil", Body: "x"}}, "entry '../../evil' of '" + b + "/layer.tar' escapes it"},
+		{"layer directory", []string{a}, nil, "layer '" + a + "' listed in manifest.json is not a file"},
+	}
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "image.tar")
+		writeFixtureTar(t, path, dockerFixture(t, test.layers, test.extra))
+		if _, err := listArchive(path); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: %v, want %q", test.name, err, test.want)
+		}
+	}
+}
+
+// TestExtractPackage extracts a deb the way gsn extract does: below the destination, with its links
+func TestExtractPackage(t *testing.T) {
+	path := writeFixtureFile(t, "tool.deb", debFixture(t, false))
+	dest := filepath.Join(t.TempDir(), "out")
+	conflicts, perms := testExtractPolicies(dest)
+	count, err := extractAll(path, dest, conflicts, perms, nil)
+	if err != nil || count != 11 {
+		t.Fatalf("extractAll = %d, %v", count, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dest, "data", "usr", "bin", "tool")); err != nil || string(data) != "#!/bin/sh\necho tool\n" {
+		t.Errorf("data/usr/bin/tool = %q, %v", data, err)
+	}
+	if target, err := os.Readlink(filepath.Join(dest, "data", "usr", "bin", "t")); err != nil || target != "tool" {
+		t.Errorf("data/usr/bin/t links to %q, %v", target, err)
+	}
+	tool, _ := os.Stat(filepath.Join(dest, "data", "usr", "bin", "tool"))
+	tool2, err := os.Stat(filepath.Join(dest, "data", "usr", "bin", "tool2"))
+	if err != nil || !os.SameFile(tool, tool2) {
+		t.Errorf("data/usr/bin/tool2 is not a hard link of tool: %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(dest, "control", "postinst")); err != nil || info.Mode().Perm() != 0o755 {
+		t.Errorf("control/postinst = %v, %v", info, err)
+	}
+}
@@ -0,0 +1,206 @@
+package files
+
+import (
+	"archive/tar"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/style"
+
+	"github.com/spf13/cobra"
+)
+
+// permissionPolicy decides the mode and owner of every restored entry. Without --chmod the modes of the tar
+// headers are used, masked by the umask. Directory modes are applied once extraction is done, so a read-only
+// directory does not stop its own entries from being written.
+type permissionPolicy struct {
+	// FileMode and DirMode override the header modes when set with --chmod
+	FileMode, DirMode       os.FileMode
+	HasFileMode, HasDirMode bool
+
+	// UID and GID are the owner set with --chown, -1 keeps the current one
+	UID, GID int
+
+	umask       os.FileMode
+	dirs        []pendingDir
+	chownFailed bool
+}
+
+// pendingDir is a restored directory whose mode is applied by finish
+type pendingDir struct {
+	Path string
+	Mode os.FileMode
+}
+
+// addPermissionFlags registers --chmod and --chown on the extract command
+func addPermissionFlags(cmd *cobra.Command) {
+	cmd.Flags().String("chmod", "", "Give restored entries these modes instead of the archived ones, e.g. files=644,dirs=755")
+	cmd.Flags().String("chown", "", "Give restored entries this owner, as user, user:group or :group (usually needs root)")
+}
+
+// permissionPolicyFromFlags reads --chmod and --chown
+func permissionPolicyFromFlags(cmd *cobra.Command) (*permissionPolicy, error) {
+	chmod, _ := cmd.Flags().GetString("chmod")
+	chown, _ := cmd.Flags().GetString("chown")
+
+	p := &permissionPolicy{UID: -1, GID: -1, umask: processUmask()}
+	if err := p.parseChmod(chmod); err != nil {
+		return nil, err
+	}
+	var err error
+	if p.UID, p.GID, err = parseOwner(chown); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// parseChmod reads a mode spec such as files=644,dirs=755, either part may be left out
+func (p *permissionPolicy) parseChmod(spec string) error {
+	if spec == "" {
+		return nil
+	}
+
+	for _, part := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return clierr.Newf(clierr.Usage, "invalid --chmod '%s': expected files=MODE and/or dirs=MODE", spec)
+		}
+		mode, err := strconv.ParseUint(value, 8, 32)
+		if err != nil || mode > 0o777 {
+			return clierr.Newf(clierr.Usage, "invalid --chmod mode '%s': expected an octal mode from 000 to 777", value)
+		}
+
+		switch key {
+		case "files":
+			if p.HasFileMode {
+				return clierr.Newf(clierr.Usage, "invalid --chmod '%s': files is given twice", spec)
+			}
+			p.FileMode, p.HasFileMode = os.FileMode(mode), true
+		case "dirs":
+			if p.HasDirMode {
+				return clierr.Newf(clierr.Usage, "invalid --chmod '%s': dirs is given twice", spec)
+			}
+			p.DirMode, p.HasDirMode = os.FileMode(mode), true
+		default:
+			return clierr.Newf(clierr.Usage, "invalid --chmod key '%s': use files or dirs", key)
+		}
+	}
+	return nil
+}
+
+// parseOwner resolves user, user:group or :group to numeric ids, names and ids are both accepted.
+// An empty spec returns -1 for both, keeping the owner.
+func parseOwner(spec string) (int, int, error) {
+	if spec == "" {
+		return -1, -1, nil
+	}
+
+	name, group, _ := strings.Cut(spec, ":")
+	if name == "" && group == "" {
+		return -1, -1, clierr.Newf(clierr.Usage, "invalid --chown '%s': expected user, user:group or :group", spec)
+	}
+
+	uid, gid := -1, -1
+	if name != "" {
+		id, err := strconv.Atoi(name)
+		if err != nil {
+			u, lerr := user.Lookup(name)
+			if lerr != nil {
+				return -1, -1, clierr.Newf(clierr.Usage, "invalid --chown user '%s': %w", name, lerr)
+			}
+			if id, err = strconv.Atoi(u.Uid); err != nil {
+				return -1, -1, fmt.Errorf("user '%s' has no numeric id", name)
+			}
+		}
+		uid = id
+	}
+	if group != "" {
+		id, err := strconv.Atoi(group)
+		if err != nil {
+			g, lerr := user.LookupGroup(group)
+			if lerr != nil {
+				return -1, -1, clierr.Newf(clierr.Usage, "invalid --chown group '%s': %w", group, lerr)
+			}
+			if id, err = strconv.Atoi(g.Gid); err != nil {
+				return -1, -1, fmt.Errorf("group '%s' has no numeric id", group)
+			}
+		}
+		gid = id
+	}
+	return uid, gid, nil
+}
+
+// apply sets the mode and owner of an entry restored at target. Directories only get their owner here,
+// their mode is applied by finish.
+func (p *permissionPolicy) apply(target string, header *tar.Header) error {
+	switch header.Typeflag {
+	case tar.TypeLink:
+		// Shares the inode of the entry it links to, which already got its mode and owner
+		return nil
+	case tar.TypeSymlink:
+		p.chown(target)
+		return nil
+	case tar.TypeDir:
+		mode := os.FileMode(header.Mode).Perm() &^ p.umask
+		if p.HasDirMode {
+			mode = p.DirMode
+		}
+		p.dirs = append(p.dirs, pendingDir{Path: target, Mode: mode})
+		p.chown(target)
+		return nil
+	default:
+		mode := os.FileMode(header.Mode).Perm() &^ p.umask
+		if p.HasFileMode {
+			mode = p.FileMode
+		}
+		if err := os.Chmod(target, mode); err != nil {
+			return err
+		}
+		p.chown(target)
+		return nil
+	}
+}
+
+// chown changes the owner when --chown is set. Failures, like running without root, are reported once
+// and extraction continues with the current owner.
+func (p *permissionPolicy) chown(target string) {
+	if p.UID < 0 && p.GID < 0 {
+		return
+	}
+	if err := os.Lchown(target, p.UID, p.GID); err != nil && !p.chownFailed {
+		p.chownFailed = true
+		fmt.Fprintf(os.Stderr, style.Warning()+"Cannot change the owner of restored entries, keeping the current owner: %v\n", err)
+	}
+}
+
+// finish applies the directory modes, deepest directories first so the path to them is still searchable
+func (p *permissionPolicy) finish() error {
+	sort.SliceStable(p.dirs, func(i, j int) bool {
+		return depth(p.dirs[i].Path) > depth(p.dirs[j].Path)
+	})
+
+	var firstErr error
+	for _, d := range p.dirs {
+		if err := os.Chmod(d.Path, d.Mode); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	p.dirs = nil
+	return firstErr
+}
+
+func depth(path string) int {
+	return strings.Count(filepath.Clean(path), string(filepath.Separator))
+}
+
+// newFileMode is the mode os.Create gives a file, 0666 masked by the umask. Archives written to a temp file,
+// which CreateTemp makes 0600, get it before they are renamed into place.
+func newFileMode() os.FileMode {
+	return 0o666 &^ processUmask()
+}
@@ -0,0 +1,96 @@
+package files
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gsn-dev-tools/internals/clierr"
+)
+
+func TestParseChmod(t *testing.T) {
+	tests := []struct {
+		spec             string
+		files, dirs      os.FileMode
+		hasFiles, hasDir bool
+		wantErr          string
+	}{
+		{"", 0, 0, false, false, ""},
+		{"files=644,dirs=755", 0o644, 0o755, true, true, ""},
+		{"dirs=700,files=600", 0o600, 0o700, true, true, ""},
+		{"files=640", 0o640, 0, true, false, ""},
+		{"dirs=0750", 0, 0o750, false, true, ""},
+		{"files=000", 0, 0, true, false, ""},
+		{" files=644 , dirs=755 ", 0o644, 0o755, true, true, ""},
+
+		{"644", 0, 0, false, false, "invalid --chmod '644': expected files=MODE and/or dirs=MODE"},
+		{"files=644,", 0, 0, false, false, "expected files=MODE and/or dirs=MODE"},
+		{"files=888", 0, 0, false, false, "invalid --chmod mode '888': expected an octal mode from 000 to 777"},
+		{"files=1777", 0, 0, false, false, "invalid --chmod mode '1777'"},
+		{"files=rw", 0, 0, false, false, "invalid --chmod mode 'rw'"},
+		{"dirs=", 0, 0, false, false, "invalid --chmod mode ''"},
+		{"files=644,files=600", 0, 0, false, false, "files is given twice"},
+		{"dirs=755,dirs=700", 0, 0, false, false, "dirs is given twice"},
+		{"links=777", 0, 0, false, false, "invalid --chmod key 'links': use files or dirs"},
+	}
+	for _, test := range tests {
+		p := &permissionPolicy{}
+		err := p.parseChmod(test.spec)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) || clierr.CodeOf(err) != clierr.Usage {
+				t.Errorf("parseChmod(%q) = %v, want a usage error with %q", test.spec, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil || p.FileMode != test.files || p.DirMode != test.dirs || p.HasFileMode != test.hasFiles || p.HasDirMode != test.hasDir {
+			t.Errorf("parseChmod(%q) = files %o (%v), dirs %o (%v), %v", test.spec, p.FileMode, p.HasFileMode, p.DirMode, p.HasDirMode, err)
+		}
+	}
+}
+
+func TestParseOwner(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skipf("no current user: %v", err)
+	}
+	uid, _ := strconv.Atoi(current.Uid)
+	gid, _ := strconv.Atoi(current.Gid)
+	group, err := user.LookupGroupId(current.Gid)
+	if err != nil {
+		t.Skipf("no primary group: %v", err)
+	}
+
+	tests := []struct {
+		spec     string
+		uid, gid int
+		wantErr  string
+	}{
+		{"", -1, -1, ""},
+		{"1000", 1000, -1, ""},
+		{"1000:100", 1000, 100, ""},
+		{":100", -1, 100, ""},
+		{"1000:", 1000, -1, ""},
+		{current.Username, uid, -1, ""},
+		{current.Username + ":" + group.Name, uid, gid, ""},
+		{":" + group.Name, -1, gid, ""},
+		{"1000:" + group.Name, 1000, gid, ""},
+
+		{":", 0, 0, "invalid --chown ':': expected user, user:group or :group"},
+		{"gsn-no-such-user", 0, 0, "invalid --chown user 'gsn-no-such-user'"},
+		{"1000:gsn-no-such-group", 0, 0, "invalid --chown group 'gsn-no-such-group'"},
+	}
+	for _, test := range tests {
+		uid, gid, err := parseOwner(test.spec)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) || clierr.CodeOf(err) != clierr.Usage {
+				t.Errorf("parseOwner(%q) = %v, want a usage error with %q", test.spec, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil || uid != test.uid || gid != test.gid {
+			t.Errorf("parseOwner(%q) = %d, %d, %v, want %d, %d", test.spec, uid, gid, err, test.uid, test.gid)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+//go:build unix
+
+package files
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// unprivilegedEnv marks a test binary started by asUnprivileged
+const unprivilegedEnv = "GSN_TEST_UNPRIVILEGED"
+
+// asUnprivileged makes the calling test run as a user without root, because root ignores permission bits
+// and may give files to anyone. Run as root, it runs the test again as nobody in a copy of the test binary
+// and returns false, the caller then returns at once.
+func asUnprivileged(t *testing.T) bool {
+	t.Helper()
+	if os.Getuid() != 0 {
+		return true
+	}
+	if os.Getenv(unprivilegedEnv) != "" {
+		t.Fatal("the test still runs as root")
+	}
+
+	// nobody needs to reach the copy of the test binary
+	dir := t.TempDir()
+	for _, d := range []string{filepath.Dir(dir), dir} {
+		if err := os.Chmod(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	binary := filepath.Join(dir, "files.test")
+	if err := copyExecutable(os.Args[0], binary); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(binary, "-test.run=^"+t.Name()+"$", "-test.v")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), unprivilegedEnv+"=1", "HOME="+dir)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: 65534, Gid: 65534}}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("run as nobody: %v\n%s", err, out)
+	}
+	if !strings.Contains(string(out), "--- PASS: "+t.Name()) {
+		t.Fatalf("the test did not run as nobody:\n%s", out)
+	}
+	return false
+}
+
+func copyExecutable(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(to, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// writableDest returns a destination dir that is made writable again before it is removed, so read-only
+// directories restored into it can be cleaned up
+func writableDest(t *testing.T) string {
+	t.Helper()
+	dest := filepath.Join(t.TempDir(), "dest")
+	t.Cleanup(func() {
+		// Walk visits a directory before reading it, so each one is opened up in time
+		filepath.Walk(dest, func(path string, info os.FileInfo, err error) error {
+			if err == nil && info.IsDir() {
+				os.Chmod(path, 0o700)
+			}
+			return nil
+		})
+	})
+	return dest
+}
+
+// modeOf returns the permission bits of path, without following a symlink
+func modeOf(t *testing.T, path string) os.FileMode {
+	t.Helper()
+	info, err := os.Lstat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Mode().Perm()
+}
+
+func TestExtractIntoReadOnlyDirectories(t *testing.T) {
+	if !asUnprivileged(t) {
+		return
+	}
+	archive := filepath.Join(t.TempDir(), "ro.tar")
+	writeFixtureTar(t, archive, []fixtureEntry{
+		{Name: "ro/", Type: tar.TypeDir, Mode: 0o555},
+		{Name: "ro/a.txt", Body: "a", Mode: 0o640},
+		{Name: "ro/shared.txt", Body: "shared", Mode: 0o666},
+		{Name: "ro/sub/", Type: tar.TypeDir, Mode: 0o555},
+		{Name: "ro/sub/b.txt", Body: "b", Mode: 0o444},
+		{Name: "ro/sub/run.sh", Body: "#!/bin/sh\n", Mode: 0o777},
+		// Not searchable: if it were locked first, the directory inside could not be reached any more
+		{Name: "sealed/", Type: tar.TypeDir, Mode: 0o400},
+		{Name: "sealed/inner/", Type: tar.TypeDir, Mode: 0o500},
+		{Name: "sealed/inner/c.txt", Body: "c", Mode: 0o600},
+	})
+
+	dest := writableDest(t)
+	conflicts, perms := testExtractPolicies(dest)
+	count, err := extractAll(archive, dest, conflicts, perms, nil)
+	if err != nil || count != 9 {
+		t.Fatalf("extractAll = %d, %v", count, err)
+	}
+	// Until finish every directory stays writable
+	for _, dir := range []string{"ro", "ro/sub", "sealed", "sealed/inner"} {
+		if mode := modeOf(t, filepath.Join(dest, dir)); mode&0o700 != 0o700 {
+			t.Errorf("%s is %o before finish", dir, mode)
+		}
+	}
+	if err := perms.finish(); err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+
+	// The archived modes, less the umask of 022
+	want := map[string]os.FileMode{
+		"ro":            0o555,
+		"ro/a.txt":      0o640,
+		"ro/shared.txt": 0o644,
+		"ro/sub":        0o555,
+		"ro/sub/b.txt":  0o444,
+		"ro/sub/run.sh": 0o755,
+		"sealed":        0o400,
+	}
+	for name, mode := range want {
+		if got := modeOf(t, filepath.Join(dest, name)); got != mode {
+			t.Errorf("%s is %o, want %o", name, got, mode)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(dest, "ro/sub/b.txt")); err != nil || string(data) != "b" {
+		t.Errorf("ro/sub/b.txt = %q, %v", data, err)
+	}
+	if err := os.WriteFile(filepath.Join(dest, "ro/new.txt"), nil, 0o644); err == nil {
+		t.Error("ro is still writable")
+	}
+
+	// sealed/inner got its mode before sealed was locked
+	if _, err := os.Lstat(filepath.Join(dest, "sealed/inner")); err == nil {
+		t.Error("sealed is still searchable")
+	}
+	if err := os.Chmod(filepath.Join(dest, "sealed"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if got := modeOf(t, filepath.Join(dest, "sealed/inner")); got != 0o500 {
+		t.Errorf("sealed/inner is %o, want 500", got)
+	}
+	if got := modeOf(t, filepath.Join(dest, "sealed/inner/c.txt")); got != 0o600 {
+		t.Errorf("sealed/inner/c.txt is %o, want 600", got)
+	}
+}
+
+func TestChmodOverridesArchivedModes(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "modes.tar")
+	writeFixtureTar(t, archive, []fixtureEntry{
+		{Name: "app/", Type: tar.TypeDir, Mode: 0o555},
+		{Name: "app/run.sh", Body: "#!/bin/sh\n", Mode: 0o755},
+		{Name: "app/conf/", Type: tar.TypeDir, Mode: 0o700},
+		{Name: "app/conf/settings.yaml", Body: "a: 1\n", Mode: 0o444},
+		{Name: "app/latest", Type: tar.TypeSymlink, Link: "run.sh"},
+	})
+
+	dest := writableDest(t)
+	conflicts, perms := testExtractPolicies(dest)
+	if err := perms.parseChmod("files=600,dirs=750"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := extractAll(archive, dest, conflicts, perms, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := perms.finish(); err != nil {
+		t.Fatal(err)
+	}
+	for name, mode := range map[string]os.FileMode{"app": 0o750, "app/conf": 0o750, "app/run.sh": 0o600, "app/conf/settings.yaml": 0o600} {
+		if got := modeOf(t, filepath.Join(dest, name)); got != mode {
+			t.Errorf("%s is %o, want %o", name, got, mode)
+		}
+	}
+	// The symlink itself is left alone, and so is its target through it
+	if info, err := os.Lstat(filepath.Join(dest, "app/latest")); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("app/latest = %v, %v", info, err)
+	}
+}
+
+func TestChownWarnsOnceAndContinues(t *testing.T) {
+	if !asUnprivileged(t) {
+		return
+	}
+	archive := filepath.Join(t.TempDir(), "owned.tar")
+	writeFixtureTar(t, archive, []fixtureEntry{
+		{Name: "data/", Type: tar.TypeDir},
+		{Name: "data/a.txt", Body: "a", Mode: 0o640},
+		{Name: "data/b.txt", Body: "b"},
+		{Name: "data/link", Type: tar.TypeSymlink, Link: "a.txt"},
+		{Name: "data/hard", Type: tar.TypeLink, Link: "data/b.txt"},
+	})
+	extract := func(uid, gid int) (string, string) {
+		dest := writableDest(t)
+		conflicts, perms := testExtractPolicies(dest)
+		perms.UID, perms.GID = uid, gid
+		stderr := captureStderr(t, func() {
+			if _, err := extractAll(archive, dest, conflicts, perms, nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := perms.finish(); err != nil {
+				t.Fatal(err)
+			}
+		})
+		return dest, stderr
+	}
+
+	// Giving entries to ourselves works without root
+	if _, stderr := extract(os.Getuid(), os.Getgid()); stderr != "" {
+		t.Errorf("--chown to the current user warned: %q", stderr)
+	}
+
+	// Giving them to root does not: one warning, and every entry is still restored with its mode
+	dest, stderr := extract(0, 0)
+	if n := strings.Count(stderr, "Cannot change the owner of restored entries, keeping the current owner"); n != 1 {
+		t.Errorf("%d warning(s) on stderr: %q", n, stderr)
+	}
+	for _, name := range []string{"data/a.txt", "data/b.txt", "data/link", "data/hard"} {
+		info, err := os.Lstat(filepath.Join(dest, name))
+		if err != nil {
+			t.Errorf("%s was not restored: %v", name, err)
+			continue
+		}
+		if stat := info.Sys().(*syscall.Stat_t); int(stat.Uid) != os.Getuid() {
+			t.Errorf("%s is owned by %d", name, stat.Uid)
+		}
+	}
+	if got := modeOf(t, filepath.Join(dest, "data/a.txt")); got != 0o640 {
+		t.Errorf("data/a.txt is %o, want 640", got)
+	}
+}
+
+// useUmask sets the umask of the process for the test
+func useUmask(t *testing.T, mask int) {
+	t.Helper()
+	saved := syscall.Umask(mask)
+	t.Cleanup(func() { syscall.Umask(saved) })
+}
+
+// TestWrittenArchiveModes checks that archives written to a temp file and renamed into place get the mode of a
+// new file, like those gsn cmp creates, rather than the 0600 of the temp file
+func TestWrittenArchiveModes(t *testing.T) {
+	useUmask(t, 0o027)
+	dir := t.TempDir()
+	source := filepath.Join(dir, "project.tar.gz")
+	writeFixtureTar(t, source, convertFixture)
+
+	converted := filepath.Join(dir, "project.zip")
+	if _, err := convertArchive(source, converted, formatZip, nil); err != nil {
+		t.Fatal(err)
+	}
+	if mode := modeOf(t, converted); mode != 0o640 {
+		t.Errorf("converted archive is %04o, want 0640", mode)
+	}
+
+	merged := filepath.Join(dir, "merged.tar.zst")
+	if _, err := mergeArchives([]string{source, converted}, merged, formatTarZst, mergeLast, memoryBudget{}); err != nil {
+		t.Fatal(err)
+	}
+	if mode := modeOf(t, merged); mode != 0o640 {
+		t.Errorf("merged archive is %04o, want 0640", mode)
+	}
+
+	stream, err := os.Open(filepath.Join(dir, "project.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	gz, err := gzip.NewReader(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported := filepath.Join(dir, "export.tar")
+	if _, err := ExportTarStream(gz, exported, ExportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if mode := modeOf(t, exported); mode != 0o640 {
+		t.Errorf("exported archive is %04o, want 0640", mode)
+	}
+}
@@ -0,0 +1,57 @@
+package files
+
+import (
+	"os"
+	"sort"
+	"strings"
+
+	"gsn-dev-tools/internals/tui"
+
+	"github.com/spf13/cobra"
+)
+
+// pathArg returns the path given as first argument, or the one picked from candidates with --pick
+func pathArg(cmd *cobra.Command, args []string, prompt string, candidates func() ([]string, error)) (string, error) {
+	if !tui.Requested(cmd) {
+		return args[0], nil
+	}
+
+	names, err := candidates()
+	if err != nil {
+		return "", err
+	}
+	i, err := tui.Pick(prompt, names)
+	if err != nil {
+		return "", err
+	}
+	return names[i], nil
+}
+
+// archivesInCwd lists the archives of a supported format in the current directory
+func archivesInCwd() ([]string, error) {
+	return cwdEntries(func(e os.DirEntry) bool {
+		name := strings.TrimSuffix(e.Name(), encryptedExt)
+		return e.Type().IsRegular() && trimArchiveExt(name) != name
+	})
+}
+
+// directoriesInCwd lists the directories in the current directory
+func directoriesInCwd() ([]string, error) {
+	return cwdEntries(func(e os.DirEntry) bool { return e.IsDir() })
+}
+
+func cwdEntries(keep func(os.DirEntry) bool) ([]string, error) {
+	entries, err := os.ReadDir(".")
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		if keep(e) {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
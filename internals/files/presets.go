@@ -0,0 +1,251 @@
+package files
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/config"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/ignore"
+	"gsn-dev-tools/internals/output"
+	"gsn-dev-tools/internals/style"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// builtinPresets holds the presets shipped with gsn, config presets are applied over them
+//
+//go:embed presets.yaml
+var builtinPresets []byte
+
+// presetAuto picks the preset whose sentinel files the source directory has
+const presetAuto = "auto"
+
+// archivePreset is a preset with its extends resolved
+type archivePreset struct {
+	Name      string
+	Source    string
+	Sentinels []string
+	Exclude   []string
+	Include   []string
+}
+
+// presetColumns declares the columns available to `cmp presets`
+var presetColumns = []output.Column[archivePreset]{
+	{Name: "name", Value: func(p archivePreset) any { return p.Name }},
+	{Name: "source", Value: func(p archivePreset) any { return p.Source }},
+	{Name: "sentinels", Value: func(p archivePreset) any { return p.Sentinels }, Display: func(p archivePreset) string { return strings.Join(p.Sentinels, " ") }},
+	{Name: "exclude", Value: func(p archivePreset) any { return p.Exclude }, Display: func(p archivePreset) string { return strings.Join(p.Exclude, " ") }},
+	{Name: "include", Value: func(p archivePreset) any { return p.Include }, Display: func(p archivePreset) string { return strings.Join(p.Include, " ") }},
+}
+
+func PresetsCmd() *cobra.Command {
+	presetsCmd := &cobra.Command{
+		Use:   "presets",
+		Short: "Lists the archiving presets usable with --preset",
+		Long: `Lists the built-in presets and those of cmp.presets in the config file with their patterns. A config preset
+replaces the built-in preset of the same name, and with extends keeps the patterns of another preset and adds
+its own; a preset extending its own name extends the built-in one:
+
+  cmp:
+    presets:
+      node:
+        extends: node
+        exclude: [.turbo]
+      rust:
+        sentinels: [Cargo.toml]
+        exclude: [target]`,
+		Example: `  gsn cmp presets
+  gsn cmp presets --tsv`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			opts, err := output.OptionsFromFlags(cmd)
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			presets, err := loadPresets()
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			if err := output.Render(os.Stdout, presetColumns, presets, opts); err != nil {
+				clierr.Fatalf("%v", err)
+			}
+		},
+	}
+
+	output.AddFlags(presetsCmd)
+	dryrun.ReadOnly(presetsCmd)
+	return presetsCmd
+}
+
+// loadPresets returns the built-in presets with those of the config file applied, sorted by name
+func loadPresets() ([]archivePreset, error) {
+	var builtin map[string]config.ArchivePreset
+	if err := yaml.Unmarshal(builtinPresets, &builtin); err != nil {
+		return nil, fmt.Errorf("invalid built-in presets: %w", err)
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	defined := make(map[string]config.ArchivePreset)
+	sources := make(map[string]string)
+	for name, p := range builtin {
+		defined[name], sources[name] = p, "builtin"
+	}
+	for name, p := range cfg.Cmp.Presets {
+		if name == presetAuto {
+			return nil, fmt.Errorf("'%s' is reserved for preset detection and cannot be defined", presetAuto)
+		}
+		defined[name], sources[name] = p, "config"
+	}
+
+	presets := make([]archivePreset, 0, len(defined))
+	for name := range defined {
+		p, err := resolvePreset(name, defined, builtin, nil)
+		if err != nil {
+			return nil, err
+		}
+		presets = append(presets, archivePreset{Name: name, Source: sources[name], Sentinels: p.Sentinels, Exclude: p.Exclude, Include: p.Include})
+	}
+	slices.SortFunc(presets, func(a, b archivePreset) int { return strings.Compare(a.Name, b.Name) })
+	return presets, nil
+}
+
+// resolvePreset adds the patterns of the presets name extends to its own
+func resolvePreset(name string, defined map[string]config.ArchivePreset, builtin map[string]config.ArchivePreset, seen []string) (config.ArchivePreset, error) {
+	p, ok := defined[name]
+	if !ok {
+		return p, fmt.Errorf("unknown preset '%s'", name)
+	}
+	if p.Extends == "" {
+		return p, nil
+	}
+	if slices.Contains(seen, name) {
+		return p, fmt.Errorf("presets extend each other in a loop: %s", strings.Join(append(seen, name), " -> "))
+	}
+
+	var parent config.ArchivePreset
+	if p.Extends == name {
+		if parent, ok = builtin[name]; !ok {
+			return p, fmt.Errorf("preset '%s' extends itself but there is no built-in '%s'", name, name)
+		}
+	} else {
+		var err error
+		if parent, err = resolvePreset(p.Extends, defined, builtin, append(seen, name)); err != nil {
+			return p, err
+		}
+	}
+
+	return config.ArchivePreset{
+		Sentinels: slices.Concat(parent.Sentinels, p.Sentinels),
+		Exclude:   slices.Concat(parent.Exclude, p.Exclude),
+		Include:   slices.Concat(parent.Include, p.Include),
+	}, nil
+}
+
+// choosePreset looks up the preset given with --preset, detecting it for the source with auto. A nil
+// preset means auto found no sentinel.
+func choosePreset(name string, source string) (*archivePreset, error) {
+	presets, err := loadPresets()
+	if err != nil {
+		return nil, err
+	}
+
+	if name != presetAuto {
+		for i := range presets {
+			if presets[i].Name == name {
+				return &presets[i], nil
+			}
+		}
+		names := make([]string, 0, len(presets))
+		for _, p := range presets {
+			names = append(names, p.Name)
+		}
+		return nil, clierr.Newf(clierr.Usage, "unknown preset '%s' (use %s or auto)", name, strings.Join(names, ", "))
+	}
+
+	for i, p := range presets {
+		for _, sentinel := range p.Sentinels {
+			if _, err := os.Stat(filepath.Join(source, sentinel)); err == nil {
+				return &presets[i], nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// addArchiveFilterFlags registers the --preset, --exclude and depth flags of cmp
+func addArchiveFilterFlags(cmd *cobra.Command) {
+	addExcludeFlags(cmd)
+	addDepthFlags(cmd)
+}
+
+// addExcludeFlags registers --preset and --exclude on a command walking a directory
+func addExcludeFlags(cmd *cobra.Command) {
+	cmd.Flags().String("preset", "", "Leave out what a project type does not need: go, node, python, a config preset or auto")
+	cmd.Flags().StringSlice("exclude", nil, "Skip entries whose name or relative path matches this glob (repeatable)")
+}
+
+// addGitAttributesFlag registers --respect-gitattributes on a command archiving a directory
+func addGitAttributesFlag(cmd *cobra.Command) {
+	cmd.Flags().Bool("respect-gitattributes", true, "Leave out the paths .gitattributes marks export-ignore when the source is in a git work tree, like git archive")
+}
+
+// archiveFilterFromFlags combines the --preset patterns with the --exclude, depth and --respect-gitattributes
+// flags of cmp
+func archiveFilterFromFlags(cmd *cobra.Command, source string) (archiveFilter, error) {
+	presetName, _ := cmd.Flags().GetString("preset")
+	excludes, _ := cmd.Flags().GetStringSlice("exclude")
+	respectAttributes, _ := cmd.Flags().GetBool("respect-gitattributes")
+	depth, err := depthFromFlags(cmd)
+	if err != nil {
+		return archiveFilter{}, err
+	}
+	return archiveFilterFor(presetName, excludes, depth, source, respectAttributes)
+}
+
+// archiveFilterFor builds the filter of a preset, extra exclude patterns and a depth limit. respectAttributes
+// leaves out the export-ignore paths of the git work tree holding source, if there is one.
+func archiveFilterFor(presetName string, excludes []string, depth int, source string, respectAttributes bool) (archiveFilter, error) {
+	var filter archiveFilter
+	if respectAttributes {
+		if root := ignore.WorkTree(source); root != "" {
+			attributes, err := ignore.LoadAttributes(root)
+			if err != nil {
+				return filter, fmt.Errorf("failed to read the git attributes of '%s': %w", root, err)
+			}
+			filter.Attributes = attributes
+		}
+	}
+	if presetName != "" {
+		preset, err := choosePreset(presetName, source)
+		if err != nil {
+			return filter, err
+		}
+		// Reported on stderr, cmp map prints JSON on stdout
+		if preset != nil {
+			fmt.Fprintf(os.Stderr, "Preset %s: excluding %s\n", preset.Name, strings.Join(preset.Exclude, ", "))
+			filter.Excludes, filter.Includes = preset.Exclude, preset.Include
+		} else {
+			fmt.Fprintln(os.Stderr, style.Warning()+"No preset matches the source, archiving everything")
+		}
+	}
+
+	filter.Excludes = slices.Concat(filter.Excludes, excludes)
+	for _, pattern := range slices.Concat(filter.Excludes, filter.Includes) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return filter, clierr.Newf(clierr.Usage, "invalid pattern '%s': %v", pattern, err)
+		}
+	}
+	filter.LimitDepth, filter.MaxDepth = depth != unlimitedDepth, depth
+	return filter, nil
+}
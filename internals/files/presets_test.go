@@ -0,0 +1,261 @@
+package files
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/config"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+// presetSkeletons are project skeletons of each built-in preset, with what the preset must leave out next to
+// what it must keep. A name ending in / is an empty directory.
+var presetSkeletons = map[string]map[string]string{
+	"go": {
+		"go.mod":                        "module example.com/app\n",
+		"go.sum":                        "example.com/dep v1.0.0 h1:x\n",
+		"main.go":                       "package main\n",
+		"cmd/tool/main.go":              "package main\n",
+		"vendor/example.com/dep/dep.go": "package dep\n",
+		"vendor/modules.txt":            "# example.com/dep v1.0.0\n",
+		"bin/app":                       "\x7fELF",
+		"tools/bin/lint":                "#!/bin/sh\n",
+		"internal/binary/read.go":       "package binary\n",
+		"docs/vendor.md":                "# Vendoring\n",
+	},
+	"node": {
+		"package.json":                          "{}\n",
+		"package-lock.json":                     "{}\n",
+		"src/index.js":                          "export {}\n",
+		"src/dist.js":                           "export {}\n",
+		"node_modules/lodash/index.js":          "module.exports = {}\n",
+		"node_modules/.bin/":                    "",
+		"packages/ui/node_modules/react/x.js":   "x\n",
+		"packages/ui/src/button.jsx":            "x\n",
+		".next/cache/webpack.pack":              "x",
+		"dist/app.js":                           "x",
+		"coverage/lcov.info":                    "TN:\n",
+		"packages/ui/coverage/lcov-report/x":    "x",
+		"docs/coverage.md":                      "# Coverage\n",
+		"scripts/build.sh":                      "#!/bin/sh\n",
+		"packages/ui/package.json":              "{}\n",
+		"packages/ui/dist/button.js":            "x",
+		"packages/ui/.next/":                    "",
+		"public/favicon.ico":                    "ico",
+		"src/node_modules_helper.js":            "x\n",
+		"test/fixtures/dist/expected/output.js": "x\n",
+	},
+	"python": {
+		"pyproject.toml":                       "[project]\n",
+		"requirements.txt":                     "requests\n",
+		"app/__init__.py":                      "",
+		"app/main.py":                          "print()\n",
+		"app/__pycache__/main.cpython-312.pyc": "x",
+		"app/legacy.pyc":                       "x",
+		"venv/bin/python":                      "x",
+		".venv/lib/site.py":                    "x",
+		".tox/py312/log":                       "x",
+		".pytest_cache/v/cache/nodeids":        "[]",
+		".mypy_cache/3.12/app.json":            "{}",
+		"tests/test_main.py":                   "def test(): pass\n",
+		"tests/__pycache__/":                   "",
+		"docs/venv.md":                         "# venv\n",
+	},
+}
+
+// presetKeeps lists the entries each preset keeps of its skeleton, below the project directory
+var presetKeeps = map[string][]string{
+	"go": {
+		"cmd", "cmd/tool", "cmd/tool/main.go", "docs", "docs/vendor.md", "go.mod", "go.sum",
+		"internal", "internal/binary", "internal/binary/read.go", "main.go", "tools",
+	},
+	"node": {
+		"docs", "docs/coverage.md", "package-lock.json", "package.json",
+		"packages", "packages/ui", "packages/ui/package.json", "packages/ui/src", "packages/ui/src/button.jsx",
+		"public", "public/favicon.ico", "scripts", "scripts/build.sh",
+		"src", "src/dist.js", "src/index.js", "src/node_modules_helper.js",
+		"test", "test/fixtures",
+	},
+	"python": {
+		"app", "app/__init__.py", "app/main.py", "docs", "docs/venv.md", "pyproject.toml", "requirements.txt",
+		"tests", "tests/test_main.py",
+	},
+}
+
+// keptEntries lists the entries cmp writes for the project at root with filter, relative to root
+func keptEntries(t *testing.T, root string, filter archiveFilter) []string {
+	t.Helper()
+	rows, err := archiveMappings(root, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, row := range rows {
+		if name, ok := strings.CutPrefix(row.Name, "project/"); ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestBuiltinPresetsOnSkeletons(t *testing.T) {
+	for name, skeleton := range presetSkeletons {
+		t.Run(name, func(t *testing.T) {
+			root := filepath.Join(t.TempDir(), "project")
+			writeTree(t, root, skeleton)
+
+			for _, preset := range []string{name, presetAuto} {
+				var filter archiveFilter
+				captureStderr(t, func() {
+					var err error
+					if filter, err = archiveFilterFor(preset, nil, unlimitedDepth, root, false); err != nil {
+						t.Fatal(err)
+					}
+				})
+				if got := keptEntries(t, root, filter); !slices.Equal(got, presetKeeps[name]) {
+					t.Errorf("--preset %s keeps %q,\nwant %q", preset, got, presetKeeps[name])
+				}
+			}
+		})
+	}
+}
+
+// TestPresetArchiveMatchesMap checks that the archive cmp writes with a preset holds what cmp map lists
+func TestPresetArchiveMatchesMap(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "project")
+	writeTree(t, root, presetSkeletons["node"])
+	var filter archiveFilter
+	captureStderr(t, func() {
+		var err error
+		filter, err = archiveFilterFor("node", []string{"*.sh", "public"}, unlimitedDepth, root, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	archive := filepath.Join(t.TempDir(), "project.tar.gz")
+	if _, err := compressPath(root, compressOptions{Output: archive, Filter: filter, SkipSpaceCheck: true, Progress: progressbar.DefaultBytesSilent(-1)}); err != nil {
+		t.Fatal(err)
+	}
+	var written []string
+	for _, e := range readFixtureTar(t, archive) {
+		if name, ok := strings.CutPrefix(strings.TrimSuffix(e.Name, "/"), "project/"); ok {
+			written = append(written, name)
+		}
+	}
+	slices.Sort(written)
+
+	// --exclude patterns compose with the preset
+	want := slices.DeleteFunc(slices.Clone(presetKeeps["node"]), func(name string) bool {
+		return strings.HasPrefix(name, "public") || strings.HasSuffix(name, ".sh")
+	})
+	if mapped := keptEntries(t, root, filter); !slices.Equal(mapped, want) || !slices.Equal(written, want) {
+		t.Errorf("cmp map lists %q,\nthe archive holds %q,\nwant %q", mapped, written, want)
+	}
+}
+
+func TestAutoPresetDetection(t *testing.T) {
+	tests := []struct {
+		files map[string]string
+		want  string
+	}{
+		{map[string]string{"go.mod": ""}, "go"},
+		{map[string]string{"package.json": ""}, "node"},
+		{map[string]string{"setup.py": ""}, "python"},
+		{map[string]string{"requirements.txt": ""}, "python"},
+		// The first preset by name wins
+		{map[string]string{"package.json": "", "go.mod": ""}, "go"},
+		{map[string]string{"package.json": "", "pyproject.toml": ""}, "node"},
+		// Sentinels are only looked for at the top
+		{map[string]string{"sub/go.mod": ""}, ""},
+		{map[string]string{"README.md": ""}, ""},
+	}
+	for _, test := range tests {
+		root := t.TempDir()
+		writeTree(t, root, test.files)
+		preset, err := choosePreset(presetAuto, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got string
+		if preset != nil {
+			got = preset.Name
+		}
+		if got != test.want {
+			t.Errorf("auto preset of %v = %q, want %q", test.files, got, test.want)
+		}
+	}
+
+	// Without a match everything is archived, with a warning
+	root := filepath.Join(t.TempDir(), "project")
+	writeTree(t, root, map[string]string{"node_modules/x.js": "x", "README.md": "x"})
+	var filter archiveFilter
+	stderr := captureStderr(t, func() {
+		var err error
+		if filter, err = archiveFilterFor(presetAuto, nil, unlimitedDepth, root, false); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if !strings.Contains(stderr, "No preset matches the source, archiving everything") {
+		t.Errorf("stderr = %q", stderr)
+	}
+	if got := keptEntries(t, root, filter); !slices.Equal(got, []string{"README.md", "node_modules", "node_modules/x.js"}) {
+		t.Errorf("kept without a preset = %q", got)
+	}
+}
+
+func TestPresetRejections(t *testing.T) {
+	root := t.TempDir()
+	if _, err := choosePreset("rust", root); clierr.CodeOf(err) != clierr.Usage || !strings.Contains(err.Error(), "unknown preset 'rust' (use go, node, python or auto)") {
+		t.Errorf("unknown preset = %v", err)
+	}
+	if _, err := archiveFilterFor("", []string{"[a-"}, unlimitedDepth, root, false); clierr.CodeOf(err) != clierr.Usage || !strings.Contains(err.Error(), "invalid pattern '[a-'") {
+		t.Errorf("invalid --exclude = %v", err)
+	}
+}
+
+func TestResolvePreset(t *testing.T) {
+	builtin := map[string]config.ArchivePreset{
+		"node": {Sentinels: []string{"package.json"}, Exclude: []string{"node_modules"}},
+	}
+	defined := map[string]config.ArchivePreset{
+		// Extending its own name extends the built-in preset
+		"node":  {Extends: "node", Exclude: []string{".turbo"}},
+		"web":   {Extends: "node", Exclude: []string{"public/build"}, Include: []string{"public/build/keep.txt"}},
+		"rust":  {Sentinels: []string{"Cargo.toml"}, Exclude: []string{"target"}},
+		"a":     {Extends: "b"},
+		"b":     {Extends: "a"},
+		"self":  {Extends: "self"},
+		"stray": {Extends: "missing"},
+	}
+
+	tests := []struct {
+		name    string
+		want    config.ArchivePreset
+		wantErr string
+	}{
+		{"node", config.ArchivePreset{Sentinels: []string{"package.json"}, Exclude: []string{"node_modules", ".turbo"}}, ""},
+		{"web", config.ArchivePreset{Sentinels: []string{"package.json"}, Exclude: []string{"node_modules", ".turbo", "public/build"}, Include: []string{"public/build/keep.txt"}}, ""},
+		{"rust", defined["rust"], ""},
+		{"a", config.ArchivePreset{}, "presets extend each other in a loop: a -> b -> a"},
+		{"self", config.ArchivePreset{}, "preset 'self' extends itself but there is no built-in 'self'"},
+		{"stray", config.ArchivePreset{}, "unknown preset 'missing'"},
+	}
+	for _, test := range tests {
+		got, err := resolvePreset(test.name, defined, builtin, nil)
+		if test.wantErr != "" {
+			if err == nil || err.Error() != test.wantErr {
+				t.Errorf("resolvePreset(%s) = %v, want %q", test.name, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got.Sentinels, test.want.Sentinels) || !slices.Equal(got.Exclude, test.want.Exclude) || !slices.Equal(got.Include, test.want.Include) {
+			t.Errorf("resolvePreset(%s) = %+v, %v, want %+v", test.name, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,379 @@
+package files
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/hooks"
+	"gsn-dev-tools/internals/notify"
+	"gsn-dev-tools/internals/progress"
+	"gsn-dev-tools/internals/style"
+	"gsn-dev-tools/internals/tui"
+	"gsn-dev-tools/internals/units"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+func PruneCmd() *cobra.Command {
+	pruneCmd := cobra.Command{
+		Use:   "prune <directory> --older-than <age>",
+		Short: "Deletes, moves or archives files older than a given age",
+		Long: `Finds the files below a directory whose modification time is older than --older-than (e.g. 90d, 12w, 6m)
+and deletes them after confirmation. With --move-to they are moved into another directory keeping their relative
+paths, with --archive-to they are packed into a dated .tar.gz that is verified before the originals are removed.`,
+		Example: `  gsn prune ~/Screenshots --older-than 90d
+  gsn prune /var/log/myapp --older-than 4w --match '*.log' --archive-to /mnt/archive
+  gsn prune ./tmp --older-than 6m --move-to ./old -y`,
+		Args: cobra.ExactArgs(1),
+		Run:  PruneFiles,
+	}
+
+	pruneCmd.Flags().String("older-than", "", "Minimum age of the files to prune, in days (d), weeks (w) or months (m) of 30 days")
+	pruneCmd.Flags().StringSlice("match", nil, "Only prune files whose name or relative path matches this glob (repeatable)")
+	pruneCmd.Flags().StringSlice("exclude", nil, "Keep files whose name or relative path matches this glob (repeatable)")
+	pruneCmd.Flags().String("move-to", "", "Move the files into this directory instead of deleting them")
+	pruneCmd.Flags().String("archive-to", "", "Archive the files into a dated .tar.gz in this directory, then remove them")
+	pruneCmd.Flags().BoolP("yes", "y", false, "Delete without asking for confirmation")
+	pruneCmd.MarkFlagRequired("older-than")
+	pruneCmd.MarkFlagsMutuallyExclusive("move-to", "archive-to")
+	progress.AddStatusFlags(&pruneCmd)
+	dryrun.Adopt(&pruneCmd)
+
+	return &pruneCmd
+}
+
+func PruneFiles(cmd *cobra.Command, args []string) {
+	startTime := time.Now()
+	olderThan, _ := cmd.Flags().GetString("older-than")
+	matches, _ := cmd.Flags().GetStringSlice("match")
+	excludes, _ := cmd.Flags().GetStringSlice("exclude")
+	moveTo, _ := cmd.Flags().GetString("move-to")
+	archiveTo, _ := cmd.Flags().GetString("archive-to")
+	assumeYes, _ := cmd.Flags().GetBool("yes")
+
+	age, err := parseAge(olderThan)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	for _, pattern := range append(matches, excludes...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			clierr.Exitf(clierr.Usage, "invalid glob '%s': %v", pattern, err)
+		}
+	}
+
+	dir := args[0]
+	candidates, err := findPruneCandidates(dir, pruneFilter{
+		Cutoff:   startTime.Add(-age),
+		Matches:  matches,
+		Excludes: excludes,
+		Skip:     []string{moveTo, archiveTo},
+	})
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	if len(candidates) == 0 {
+		fmt.Printf("No files in '%s' are older than %s.\n", dir, olderThan)
+		return
+	}
+
+	var total int64
+	for _, c := range candidates {
+		total += c.Info.Size()
+	}
+	fmt.Printf("Found %s file(s), %s, older than %s in '%s'\n", units.FormatInt(int64(len(candidates))), units.FormatBytes(total), olderThan, dir)
+
+	finishStatus := progress.StartStatus(cmd, "prune")
+	progress.AddFiles(len(candidates))
+	var result *pruneResult
+	switch {
+	case moveTo != "":
+		result, err = moveCandidates(dir, moveTo, candidates)
+	case archiveTo != "":
+		err = dryrun.Do(fmt.Sprintf("archive the %s file(s) into a dated .tar.gz in %s and remove them", units.FormatInt(int64(len(candidates))), archiveTo), func() error {
+			var err error
+			result, err = archiveCandidates(dir, archiveTo, candidates, startTime)
+			return err
+		})
+	default:
+		if !assumeYes && !dryrun.Enabled() {
+			if !term.IsTerminal(int(os.Stdin.Fd())) {
+				clierr.Exitf(clierr.Usage, "refusing to delete without confirmation, rerun with --yes")
+			}
+			for _, c := range candidates {
+				fmt.Printf("  %10s  %s  %s\n", units.FormatBytes(c.Info.Size()), c.Info.ModTime().Format("2006-01-02"), c.Path)
+			}
+			if !tui.Confirm(fmt.Sprintf("Delete %s file(s)?", units.FormatInt(int64(len(candidates))))) {
+				fmt.Println("Nothing deleted.")
+				return
+			}
+		}
+		result, err = deleteCandidates(candidates)
+	}
+
+	finishStatus(err)
+
+	ev := notify.NewEvent("prune", startTime, err)
+	if result != nil {
+		ev.ArchivePath = result.ArchivePath
+		ev.ArchiveSize = result.ArchiveSize
+		ev.Counts = map[string]int{"files": result.Files}
+	}
+	hooks.Post(ev)
+
+	if err != nil {
+		clierr.Fatalf("Prune failed: %v", err)
+	}
+	if dryrun.Enabled() {
+		fmt.Printf("Dry run, nothing changed: %s file(s), %s\n", units.FormatInt(int64(len(candidates))), units.FormatBytes(total))
+		return
+	}
+
+	switch {
+	case moveTo != "":
+		fmt.Printf(style.Success()+"Moved %s file(s) to %s, reclaimed %s in %s (Time: %s)\n", units.FormatInt(int64(result.Files)), moveTo, units.FormatBytes(result.Reclaimed), dir, units.FormatDuration(time.Since(startTime)))
+	case archiveTo != "":
+		fmt.Printf(style.Success()+"Archived %s file(s) into %s (%s), reclaimed %s (Time: %s)\n", units.FormatInt(int64(result.Files)), result.ArchivePath, units.FormatBytes(result.ArchiveSize), units.FormatBytes(result.Reclaimed), units.FormatDuration(time.Since(startTime)))
+	default:
+		fmt.Printf(style.Success()+"Deleted %s file(s), reclaimed %s (Time: %s)\n", units.FormatInt(int64(result.Files)), units.FormatBytes(result.Reclaimed), units.FormatDuration(time.Since(startTime)))
+	}
+}
+
+// parseAge parses ages such as 90d, 12w or 6m (30 day months), plain Go durations like 36h are accepted too.
+// Fractions take either decimal mark, e.g. 1.5d or 1,5d.
+func parseAge(value string) (time.Duration, error) {
+	s, err := units.NormalizeDecimal(strings.TrimSpace(value))
+	if err != nil {
+		return 0, clierr.Newf(clierr.Usage, "invalid age: %v", err)
+	}
+	suffixes := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour, "m": 30 * 24 * time.Hour}
+	if len(s) > 1 {
+		if unit, ok := suffixes[s[len(s)-1:]]; ok {
+			if n, err := strconv.ParseFloat(s[:len(s)-1], 64); err == nil && n >= 0 {
+				return time.Duration(n * float64(unit)), nil
+			}
+		}
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, clierr.Newf(clierr.Usage, "invalid age '%s', use e.g. 90d, 12w or 6m", value)
+	}
+	return d, nil
+}
+
+// pruneFilter selects the files findPruneCandidates returns
+type pruneFilter struct {
+	Cutoff   time.Time
+	Matches  []string
+	Excludes []string
+
+	// Skip lists directories that are never descended into, such as the --move-to destination
+	Skip []string
+}
+
+// pruneResult summarizes a prune run
+type pruneResult struct {
+	Files       int
+	Reclaimed   int64
+	ArchivePath string
+	ArchiveSize int64
+}
+
+// findPruneCandidates returns the regular files below dir modified before the cutoff, in walk order
+func findPruneCandidates(dir string, filter pruneFilter) ([]walkEntry, error) {
+	var skip []string
+	for _, s := range filter.Skip {
+		if s == "" {
+			continue
+		}
+		abs, err := filepath.Abs(s)
+		if err != nil {
+			return nil, err
+		}
+		skip = append(skip, abs)
+	}
+
+	var candidates []walkEntry
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			abs, err := filepath.Abs(filePath)
+			if err != nil {
+				return err
+			}
+			for _, s := range skip {
+				if abs == s {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+
+		if !info.Mode().IsRegular() || !info.ModTime().Before(filter.Cutoff) {
+			return nil
+		}
+		if len(filter.Matches) > 0 && !matchesGlob(dir, filePath, filter.Matches) {
+			return nil
+		}
+		if matchesGlob(dir, filePath, filter.Excludes) {
+			return nil
+		}
+		candidates = append(candidates, walkEntry{Path: filePath, Info: info})
+		return nil
+	})
+	return candidates, err
+}
+
+// deleteCandidates removes every candidate, continuing past failures
+func deleteCandidates(candidates []walkEntry) (*pruneResult, error) {
+	result := &pruneResult{}
+	var errs []error
+	for _, c := range candidates {
+		if err := dryrun.Do("remove "+c.Path, func() error { return os.Remove(c.Path) }); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		result.Files++
+		progress.FileDone()
+		result.Reclaimed += c.Info.Size()
+	}
+	return result, errors.Join(errs...)
+}
+
+// moveCandidates moves every candidate below dest, keeping its path relative to dir.
+// Files on another filesystem are copied with verification and removed afterwards.
+func moveCandidates(dir string, dest string, candidates []walkEntry) (*pruneResult, error) {
+	result := &pruneResult{}
+	var errs []error
+	for _, c := range candidates {
+		rel, err := filepath.Rel(dir, c.Path)
+		if err != nil {
+			return result, err
+		}
+		target := filepath.Join(dest, rel)
+		if _, err := os.Lstat(target); err == nil {
+			errs = append(errs, clierr.Newf(clierr.Conflict, "'%s' already exists", target))
+			continue
+		}
+		err = dryrun.Do(fmt.Sprintf("move %s to %s", c.Path, target), func() error {
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			if err := os.Rename(c.Path, target); err != nil {
+				if _, err := copyPath(c.Path, target, copyOptions{Verify: true}); err != nil {
+					return err
+				}
+				return os.Remove(c.Path)
+			}
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		result.Files++
+		progress.FileDone()
+		result.Reclaimed += c.Info.Size()
+	}
+	return result, errors.Join(errs...)
+}
+
+// archiveCandidates packs the candidates into <dest>/<dir>-pruned-<date>.tar.gz with a manifest,
+// verifies every entry against it and only then removes the originals
+func archiveCandidates(dir string, dest string, candidates []walkEntry, now time.Time) (*pruneResult, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	base := filepath.Base(absDir)
+
+	if err := os.MkdirAll(dest, 0o755); err != nil {
+		return nil, err
+	}
+	outFile, archivePath, err := createDatedArchive(dest, base+"-pruned-"+now.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	defer outFile.Close()
+
+	var total int64
+	for _, c := range candidates {
+		total += c.Info.Size()
+	}
+	bar := progress.NewBytes(total, fmt.Sprintf("Archiving %s", base))
+
+	gzWriter := gzip.NewWriter(outFile)
+	tarWriter := tar.NewWriter(gzWriter)
+	a := &archiver{tw: tarWriter, bar: bar, total: total, manifest: newManifest(archivePath)}
+	for _, c := range candidates {
+		name, err := archiveEntryName(dir, base, c.Path)
+		if err == nil {
+			err = a.addEntry(c.Path, name, c.Info)
+		}
+		if err != nil {
+			os.Remove(archivePath)
+			return nil, fmt.Errorf("error archiving '%s': %w", c.Path, err)
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		return nil, err
+	}
+	if err := gzWriter.Close(); err != nil {
+		return nil, err
+	}
+	if err := outFile.Close(); err != nil {
+		return nil, err
+	}
+	bar.Finish()
+	a.warnChanges()
+
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	a.manifest.ArchiveSize = info.Size()
+	if err := a.manifest.save(archivePath); err != nil {
+		return nil, fmt.Errorf("error writing manifest: %w", err)
+	}
+
+	problems, _, err := verifyAgainstManifest(archivePath, a.manifest, 0)
+	if err == nil && len(problems) > 0 {
+		err = fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("archive '%s' failed verification, originals were kept: %w", archivePath, err)
+	}
+
+	result, err := deleteCandidates(candidates)
+	result.ArchivePath = archivePath
+	result.ArchiveSize = info.Size()
+	return result, err
+}
+
+// createDatedArchive creates <dest>/<name>.tar.gz, adding a counter when an archive of the same day exists
+func createDatedArchive(dest string, name string) (*os.File, string, error) {
+	for i := 1; ; i++ {
+		archivePath := filepath.Join(dest, name+".tar.gz")
+		if i > 1 {
+			archivePath = filepath.Join(dest, fmt.Sprintf("%s-%d.tar.gz", name, i))
+		}
+		f, err := os.OpenFile(archivePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		return f, archivePath, err
+	}
+}
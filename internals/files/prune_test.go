@@ -0,0 +1,193 @@
+package files
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+)
+
+// pruneNow is the time prune runs at in the tests, the fixture mtimes are set relative to it
+var pruneNow = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// writePruneFixture creates files below dir backdated by the given ages
+func writePruneFixture(t *testing.T, dir string, ages map[string]time.Duration) {
+	t.Helper()
+	for name, age := range ages {
+		writeTree(t, dir, map[string]string{name: name})
+		mtime := pruneNow.Add(-age)
+		if err := os.Chtimes(filepath.Join(dir, filepath.FromSlash(name)), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// candidateNames lists candidates relative to dir
+func candidateNames(t *testing.T, dir string, candidates []walkEntry) []string {
+	t.Helper()
+	var names []string
+	for _, c := range candidates {
+		rel, err := filepath.Rel(dir, c.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, filepath.ToSlash(rel))
+	}
+	return names
+}
+
+const day = 24 * time.Hour
+
+func TestParseAge(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"90d", 90 * day},
+		{"12w", 84 * day},
+		{"6m", 180 * day},
+		{"1.5d", 36 * time.Hour},
+		{"1,5d", 36 * time.Hour},
+		{"36h", 36 * time.Hour},
+		{" 0d ", 0},
+	}
+	for _, test := range tests {
+		if got, err := parseAge(test.value); err != nil || got != test.want {
+			t.Errorf("parseAge(%q) = %v, %v, want %v", test.value, got, err, test.want)
+		}
+	}
+	for _, value := range []string{"", "d", "90", "-3d", "-1h", "3y", "ninety days"} {
+		if _, err := parseAge(value); clierr.CodeOf(err) != clierr.Usage {
+			t.Errorf("parseAge(%q) = %v, want a usage error", value, err)
+		}
+	}
+}
+
+func TestFindPruneCandidates(t *testing.T) {
+	dir := t.TempDir()
+	writePruneFixture(t, dir, map[string]time.Duration{
+		"old.png":           120 * day,
+		"recent.png":        10 * day,
+		"at-cutoff.png":     90 * day,
+		"just-past.png":     90*day + time.Second,
+		"logs/app.log":      200 * day,
+		"logs/app.log.keep": 200 * day,
+		"logs/new.log":      1 * day,
+		"old/archive.txt":   365 * day,
+	})
+	// A symlink to an old file is never a candidate itself
+	if err := os.Symlink("old.png", filepath.Join(dir, "link.png")); err != nil {
+		t.Fatal(err)
+	}
+	cutoff := pruneNow.Add(-90 * day)
+
+	tests := []struct {
+		name   string
+		filter pruneFilter
+		want   []string
+	}{
+		{"age only", pruneFilter{Cutoff: cutoff}, []string{"just-past.png", "logs/app.log", "logs/app.log.keep", "old/archive.txt", "old.png"}},
+		{"match", pruneFilter{Cutoff: cutoff, Matches: []string{"*.log", "*.png"}}, []string{"just-past.png", "logs/app.log", "old.png"}},
+		{"match relative path", pruneFilter{Cutoff: cutoff, Matches: []string{"logs/*"}}, []string{"logs/app.log", "logs/app.log.keep"}},
+		{"exclude", pruneFilter{Cutoff: cutoff, Excludes: []string{"*.keep", "old/*"}}, []string{"just-past.png", "logs/app.log", "old.png"}},
+		{"skip destination", pruneFilter{Cutoff: cutoff, Skip: []string{filepath.Join(dir, "old"), ""}}, []string{"just-past.png", "logs/app.log", "logs/app.log.keep", "old.png"}},
+		{"longer age", pruneFilter{Cutoff: pruneNow.Add(-180 * day)}, []string{"logs/app.log", "logs/app.log.keep", "old/archive.txt"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			candidates, err := findPruneCandidates(dir, test.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := candidateNames(t, dir, candidates); !slices.Equal(got, test.want) {
+				t.Errorf("candidates = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPruneDeleteAndMove(t *testing.T) {
+	dir := t.TempDir()
+	writePruneFixture(t, dir, map[string]time.Duration{"a/old.txt": 100 * day, "b/old.txt": 100 * day, "new.txt": day})
+	candidates, err := findPruneCandidates(dir, pruneFilter{Cutoff: pruneNow.Add(-30 * day)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dest := filepath.Join(t.TempDir(), "moved")
+	// b/old.txt is already there and stays where it is
+	writeTree(t, dest, map[string]string{"b/old.txt": "other"})
+	result, err := moveCandidates(dir, dest, candidates)
+	if clierr.CodeOf(err) != clierr.Conflict {
+		t.Errorf("move over an existing file = %v, want a conflict", err)
+	}
+	if result.Files != 1 || result.Reclaimed != int64(len("a/old.txt")) {
+		t.Errorf("move result = %+v", result)
+	}
+	if got := listTree(t, dir); !slices.Equal(got, []string{"a/", "b/", "b/old.txt", "new.txt"}) {
+		t.Errorf("left in the source: %v", got)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dest, "a", "old.txt")); string(data) != "a/old.txt" {
+		t.Errorf("moved content = %q", data)
+	}
+	// The mtime travels along, a later prune of the destination sees the same age
+	info, err := os.Stat(filepath.Join(dest, "a", "old.txt"))
+	if err != nil || !info.ModTime().Equal(pruneNow.Add(-100*day)) {
+		t.Errorf("moved mtime = %v, %v", info.ModTime(), err)
+	}
+
+	candidates, _ = findPruneCandidates(dir, pruneFilter{Cutoff: pruneNow.Add(-30 * day)})
+	result, err = deleteCandidates(candidates)
+	if err != nil || result.Files != 1 {
+		t.Errorf("delete = %+v, %v", result, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b", "old.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("b/old.txt after the delete: %v", err)
+	}
+}
+
+func TestPruneArchive(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+	writePruneFixture(t, dir, map[string]time.Duration{"2023/jan.log": 400 * day, "2023/feb.log": 380 * day, "today.log": 0})
+	dest := t.TempDir()
+
+	candidates, err := findPruneCandidates(dir, pruneFilter{Cutoff: pruneNow.Add(-365 * day)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := archiveCandidates(dir, dest, candidates, pruneNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ArchivePath != filepath.Join(dest, "logs-pruned-2024-06-01.tar.gz") || result.Files != 2 {
+		t.Errorf("result = %+v", result)
+	}
+	var names []string
+	for _, e := range readFixtureTar(t, result.ArchivePath) {
+		names = append(names, e.Name)
+	}
+	if !slices.Equal(names, []string{"logs/2023/feb.log", "logs/2023/jan.log"}) {
+		t.Errorf("archived %v", names)
+	}
+	if got := listTree(t, dir); !slices.Equal(got, []string{"2023/", "today.log"}) {
+		t.Errorf("left after the archive: %v", got)
+	}
+	if _, err := os.Stat(result.ArchivePath + manifestSuffix); err != nil {
+		t.Errorf("no manifest next to the archive: %v", err)
+	}
+
+	// A second prune on the same day does not overwrite the first archive
+	writePruneFixture(t, dir, map[string]time.Duration{"2023/mar.log": 370 * day})
+	candidates, _ = findPruneCandidates(dir, pruneFilter{Cutoff: pruneNow.Add(-365 * day)})
+	result, err = archiveCandidates(dir, dest, candidates, pruneNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(result.ArchivePath) != "logs-pruned-2024-06-01-2.tar.gz" {
+		t.Errorf("second archive = %s", result.ArchivePath)
+	}
+}
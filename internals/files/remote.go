@@ -0,0 +1,371 @@
+package files
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/progress"
+	"gsn-dev-tools/internals/remote"
+	"gsn-dev-tools/internals/style"
+	"gsn-dev-tools/internals/units"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// remoteEntry is an entry of a remote tree and its name in an archive of the tree
+type remoteEntry struct {
+	Path string
+	Name string
+	Info fs.FileInfo
+}
+
+// checkRemoteFlags refuses the flags of cmd a --remote run does not honour, rather than ignoring them
+func checkRemoteFlags(cmd *cobra.Command, supported ...string) error {
+	var unsupported []string
+	cmd.LocalNonPersistentFlags().VisitAll(func(f *pflag.Flag) {
+		if !f.Changed {
+			return
+		}
+		for _, name := range supported {
+			if f.Name == name {
+				return
+			}
+		}
+		unsupported = append(unsupported, "--"+f.Name)
+	})
+	if len(unsupported) > 0 {
+		return clierr.Newf(clierr.Usage, "%s does not support %s with --remote", cmd.CommandPath(), strings.Join(unsupported, ", "))
+	}
+	return nil
+}
+
+// walkRemoteEntries is walkArchiveEntries for a tree of the remote host: it calls fn for every entry cmp
+// writes for root, in archive order, with the entry name. Entries left out by filter are not visited, the
+// filter is matched here rather than on the remote host.
+func walkRemoteEntries(c *remote.SFTP, root string, filter archiveFilter, fn func(entry remoteEntry) error) error {
+	root = path.Clean(root)
+	base := path.Base(root)
+	info, err := c.Stat(root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fn(remoteEntry{Path: root, Name: base, Info: info})
+	}
+	// At depth 0 the source directory is the only entry, so extracting the archive still creates it
+	if !filter.descends(root, root) {
+		return fn(remoteEntry{Path: root, Name: base, Info: info})
+	}
+
+	var walkDir func(dir string, name string) error
+	walkDir = func(dir string, name string) error {
+		children, err := c.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			childPath, childName := path.Join(dir, child.Name()), name+"/"+child.Name()
+			if filter.skips(root, childPath) {
+				continue
+			}
+			if err := fn(remoteEntry{Path: childPath, Name: childName, Info: child}); err != nil {
+				return err
+			}
+			if child.IsDir() && filter.descends(root, childPath) {
+				if err := walkDir(childPath, childName); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return walkDir(root, base)
+}
+
+// listRemoteTree resolves root on the remote host and returns its absolute path and the entries an archive of
+// it holds, with the sizes and file count the progress of reading them is measured against
+func listRemoteTree(c *remote.SFTP, root string, filter archiveFilter) (string, []remoteEntry, sourceStats, error) {
+	absRoot, err := c.RealPath(root)
+	if err != nil {
+		return "", nil, sourceStats{}, err
+	}
+	var entries []remoteEntry
+	var stats sourceStats
+	err = walkRemoteEntries(c, absRoot, filter, func(entry remoteEntry) error {
+		entries = append(entries, entry)
+		if entry.Info.Mode().IsRegular() {
+			stats.Size += entry.Info.Size()
+			stats.Files++
+		}
+		return nil
+	})
+	return absRoot, entries, stats, err
+}
+
+// remoteHeader is the tar header of a remote entry, with the numeric owner SFTP reports
+func remoteHeader(c *remote.SFTP, entry remoteEntry) (*tar.Header, error) {
+	var link string
+	if entry.Info.Mode()&fs.ModeSymlink != 0 {
+		var err error
+		if link, err = c.ReadLink(entry.Path); err != nil {
+			return nil, err
+		}
+	}
+	header, err := tar.FileInfoHeader(entry.Info, link)
+	if err != nil {
+		return nil, err
+	}
+	header.Name = entry.Name
+	if info, ok := entry.Info.(*remote.FileInfo); ok {
+		header.Uid, header.Gid = int(info.UID), int(info.GID)
+	}
+	return header, nil
+}
+
+// copyRemoteFile copies the content of a remote file to dst, exactly the size its header records
+func copyRemoteFile(c *remote.SFTP, entry remoteEntry, dst io.Writer, limiter *bandwidthLimiter) error {
+	file, err := c.Open(entry.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.CopyN(dst, limiter.Reader(file), entry.Info.Size())
+	if errors.Is(err, io.EOF) {
+		return fmt.Errorf("'%s' shrank while it was read", entry.Path)
+	}
+	return err
+}
+
+// remoteCompressOptions is what a --remote cmp honours of compressOptions
+type remoteCompressOptions struct {
+	Format  archiveFormat
+	Filter  archiveFilter
+	Limiter *bandwidthLimiter
+	// Smart stores the files of these types in a zip, and counts them for other formats
+	Smart *storedTypes
+	// Output is the local path of the archive, "-" for stdout, the remote base name in the current directory
+	// when empty
+	Output string
+}
+
+// compressRemote is gsn cmp with --remote: the source is read from the remote host over SFTP and the archive
+// written locally, or to stdout with -o - with the summary on stderr
+func compressRemote(cmd *cobra.Command, args []string) {
+	if err := checkRemoteFlags(cmd, "format", "output", "preset", "exclude", "max-depth", "top-level-only", "bwlimit", "smart"); err != nil {
+		clierr.Fatal(err)
+	}
+	if len(args) != 1 {
+		clierr.Exitf(clierr.Usage, "--remote needs the remote path to compress")
+	}
+	formatName, _ := cmd.Flags().GetString("format")
+	outputPath, _ := cmd.Flags().GetString("output")
+	presetName, _ := cmd.Flags().GetString("preset")
+	excludes, _ := cmd.Flags().GetStringSlice("exclude")
+	smart, _ := cmd.Flags().GetBool("smart")
+	startTime := time.Now()
+
+	format, err := parseFormat(formatName)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	if format.Container == containerNone {
+		clierr.Exitf(clierr.Usage, "--remote writes archives with entries, use tar.gz, tar, tar.zst or zip")
+	}
+	if presetName == presetAuto {
+		clierr.Exitf(clierr.Usage, "--preset auto reads the project files of a local source, name the preset with --remote")
+	}
+	depth, err := depthFromFlags(cmd)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	filter, err := archiveFilterFor(presetName, excludes, depth, args[0], false)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	limiter, err := bandwidthLimiterFromFlags(cmd)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	var stored *storedTypes
+	if smart {
+		if stored, err = loadStoredTypes(); err != nil {
+			clierr.Fatalf("%v", err)
+		}
+	}
+	conn, err := remote.Connect()
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+
+	result, err := compressRemotePath(conn, args[0], remoteCompressOptions{Format: format, Filter: filter, Limiter: limiter, Smart: stored, Output: outputPath})
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+
+	// The archive may be on stdout, the summary goes to stderr then
+	summary := os.Stdout
+	if outputPath == "-" {
+		summary = os.Stderr
+	}
+	elapsed := time.Since(startTime)
+	fmt.Fprintf(summary, style.Success()+"Compression of %s:%s successful. Archive created: %s (Time: %s)\n", conn.Host, args[0], result.ArchivePath, units.FormatDuration(elapsed))
+	fmt.Fprintf(summary, "%s file(s), %s -> %s (%s), %s\n", units.FormatInt(int64(result.FileCount)), units.FormatBytes(result.SourceSize),
+		units.FormatBytes(result.ArchiveSize), compressionRatio(result), units.FormatRate(result.SourceSize, elapsed))
+	if line := result.precompressed.report(result, format); line != "" {
+		fmt.Fprintln(summary, line)
+	}
+}
+
+// compressRemotePath archives a file or directory of the remote host
+func compressRemotePath(conn *remote.Conn, root string, opts remoteCompressOptions) (*CompressResult, error) {
+	absRoot, entries, stats, err := listRemoteTree(conn.SFTP, root, opts.Filter)
+	if err != nil {
+		return nil, fmt.Errorf("error reading '%s' on %s: %w", root, conn.Host, err)
+	}
+
+	outputPath := opts.Output
+	if outputPath == "" {
+		outputPath = path.Base(absRoot) + opts.Format.Extension()
+	}
+	var outFile *os.File
+	var bar progress.Tracker
+	description := fmt.Sprintf("Compressing %s:%s", conn.Host, path.Base(absRoot))
+	if outputPath == "-" {
+		outFile, outputPath = os.Stdout, "stdout"
+		bar = progress.NewBytesStderr(stats.Size, description)
+	} else {
+		if outFile, err = os.Create(outputPath); err != nil {
+			return nil, fmt.Errorf("error creating output file: %w", err)
+		}
+		defer outFile.Close()
+		bar = progress.NewBytes(stats.Size, description)
+	}
+	progress.AddFiles(stats.Files)
+
+	var archiveSize countingWriter
+	w, err := createArchive(io.MultiWriter(outFile, &archiveSize), opts.Format, opts.Smart)
+	if err != nil {
+		return nil, err
+	}
+	// A zip stores them, a tar stream can only count them
+	var tally *precompressedTally
+	if opts.Smart != nil && opts.Format.Container != containerZip {
+		tally = &precompressedTally{types: opts.Smart}
+	}
+	err = writeRemoteEntries(conn.SFTP, entries, w, bar, opts.Limiter, tally)
+	if closeErr := w.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("error finalizing the archive: %w", closeErr)
+	}
+	// A failed run leaves no truncated archive behind
+	if err != nil {
+		if outFile != os.Stdout {
+			outFile.Close()
+			os.Remove(outputPath)
+		}
+		return nil, fmt.Errorf("compression failed: %w", err)
+	}
+	bar.Finish()
+
+	return &CompressResult{
+		ArchivePath:   outputPath,
+		ArchiveSize:   int64(archiveSize),
+		SourceSize:    stats.Size,
+		FileCount:     stats.Files,
+		precompressed: tally,
+	}, nil
+}
+
+// writeRemoteEntries writes the listed entries of the remote tree to an archive
+func writeRemoteEntries(c *remote.SFTP, entries []remoteEntry, w entryWriter, bar progress.Tracker, limiter *bandwidthLimiter, tally *precompressedTally) error {
+	for _, entry := range entries {
+		header, err := remoteHeader(c, entry)
+		if err != nil {
+			return err
+		}
+		if err := w.WriteHeader(header); err != nil {
+			return err
+		}
+		if !entry.Info.Mode().IsRegular() {
+			continue
+		}
+		tally.add(entry.Name, entry.Info.Size())
+		if err := copyRemoteFile(c, entry, io.MultiWriter(w, bar), limiter); err != nil {
+			return err
+		}
+		progress.FileDone()
+	}
+	return nil
+}
+
+// remoteDiskUsage is walkParallel and aggregateBySubdir for a directory of the remote host
+func remoteDiskUsage(root string, depth int) (string, []duEntry, error) {
+	conn, err := remote.Connect()
+	if err != nil {
+		return "", nil, err
+	}
+	filter := archiveFilter{LimitDepth: depth != unlimitedDepth, MaxDepth: depth}
+	absRoot, entries, _, err := listRemoteTree(conn.SFTP, root, filter)
+	if err != nil {
+		return "", nil, fmt.Errorf("error reading '%s' on %s: %w", root, conn.Host, err)
+	}
+	usage := newSubdirUsage(absRoot)
+	for _, entry := range entries {
+		usage.add(entry.Path, entry.Info)
+	}
+	return absRoot, usage.entries(), nil
+}
+
+// snapshotRemote is snapshotPath for a file or directory of the remote host, hashing reads every file
+// through SFTP
+func snapshotRemote(root string, withHashes bool) (*Manifest, error) {
+	conn, err := remote.Connect()
+	if err != nil {
+		return nil, err
+	}
+	absRoot, entries, stats, err := listRemoteTree(conn.SFTP, root, archiveFilter{})
+	if err != nil {
+		return nil, fmt.Errorf("error reading '%s' on %s: %w", root, conn.Host, err)
+	}
+
+	var bar progress.Tracker
+	if withHashes {
+		bar = progress.NewBytesStderr(stats.Size, fmt.Sprintf("Hashing %s:%s", conn.Host, path.Base(absRoot)))
+	}
+	manifestEntries := make([]ManifestEntry, 0, len(entries))
+	for _, entry := range entries {
+		header, err := remoteHeader(conn.SFTP, entry)
+		if err != nil {
+			return nil, err
+		}
+		var digest string
+		if withHashes && entry.Info.Mode().IsRegular() {
+			hasher := sha256.New()
+			if err := copyRemoteFile(conn.SFTP, entry, io.MultiWriter(hasher, bar), nil); err != nil {
+				return nil, err
+			}
+			digest = hex.EncodeToString(hasher.Sum(nil))
+		}
+		manifestEntries = append(manifestEntries, entryFromHeader(header, digest))
+	}
+	if bar != nil {
+		bar.Finish()
+	}
+
+	return &Manifest{
+		SchemaVersion: manifestSchemaVersion,
+		Source:        conn.Host + ":" + absRoot,
+		CreatedAt:     time.Now().UTC(),
+		Entries:       manifestEntries,
+	}, nil
+}
@@ -0,0 +1,183 @@
+package files
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/style"
+)
+
+// defaultNameMax is the NAME_MAX assumed where the filesystem cannot tell
+const defaultNameMax = 255
+
+// Rules of the rename plan lint, the names --allow takes
+const (
+	lintCollision = "collision"
+	lintEmptyName = "empty-name"
+	lintCaseOnly  = "case-only"
+	lintNameMax   = "name-max"
+	lintMagic     = "magic"
+)
+
+var lintRules = []string{lintCollision, lintEmptyName, lintCaseOnly, lintNameMax, lintMagic}
+
+// parseLintAllow validates the rules given with --allow
+func parseLintAllow(rules []string) ([]string, error) {
+	for _, rule := range rules {
+		if !slices.Contains(lintRules, rule) {
+			return nil, clierr.Newf(clierr.Usage, "unknown --allow rule '%s' (use %s)", rule, strings.Join(lintRules, ", "))
+		}
+	}
+	return rules, nil
+}
+
+// caseInsensitive and filesystemNameMax tell what the filesystem holding a directory allows. They are variables
+// so the rules depending on the filesystem can be tested on any.
+var (
+	caseInsensitive   = probeCaseInsensitive
+	filesystemNameMax = nameMax
+)
+
+// lintFinding is a surprising outcome of a rename plan
+type lintFinding struct {
+	Rule    string
+	OldName string
+	Reason  string
+}
+
+// magicExtensions lists the extensions expected for the content types http.DetectContentType recognizes by
+// their magic bytes. Types missing here, and text, are not checked.
+var magicExtensions = map[string][]string{
+	"image/png":                    {"png"},
+	"image/jpeg":                   {"jpg", "jpeg"},
+	"image/gif":                    {"gif"},
+	"image/webp":                   {"webp"},
+	"image/bmp":                    {"bmp"},
+	"image/x-icon":                 {"ico"},
+	"application/pdf":              {"pdf"},
+	"application/zip":              {"zip", "jar", "apk", "epub", "docx", "xlsx", "pptx", "odt", "ods", "odp"},
+	"application/x-gzip":           {"gz", "tgz"},
+	"application/x-rar-compressed": {"rar"},
+	"application/wasm":             {"wasm"},
+	"application/ogg":              {"ogg", "oga", "ogv", "opus"},
+	"audio/mpeg":                   {"mp3"},
+	"audio/wave":                   {"wav"},
+	"audio/aiff":                   {"aif", "aiff"},
+	"audio/midi":                   {"mid", "midi"},
+	"video/mp4":                    {"mp4", "m4v", "m4a"},
+	"video/webm":                   {"webm", "mkv"},
+	"video/avi":                    {"avi"},
+	"font/ttf":                     {"ttf"},
+	"font/otf":                     {"otf"},
+	"font/woff":                    {"woff"},
+	"font/woff2":                   {"woff2"},
+}
+
+// lintRenamePlan checks the plan of a directory for outcomes that lose files or surprise: two files getting
+// the same name, names left empty, case only changes the filesystem cannot tell apart, names too long for the
+// filesystem, and, with an extension given explicitly, contents that do not match the new extension.
+func lintRenamePlan(dir string, plan []renameOp, opts renameOptions) []lintFinding {
+	var findings []lintFinding
+	add := func(rule string, op renameOp, format string, args ...any) {
+		findings = append(findings, lintFinding{Rule: rule, OldName: op.OldName, Reason: fmt.Sprintf(format, args...)})
+	}
+
+	limit := filesystemNameMax(dir)
+	folds := -1
+	targets := make(map[string]string, len(plan))
+	for _, op := range plan {
+		if op.Err != nil {
+			add(lintEmptyName, op, "no name is left after cleaning")
+			continue
+		}
+		if base := strings.TrimSuffix(op.NewName, filepath.Ext(op.NewName)); strings.Trim(base, "._- ") == "" {
+			add(lintEmptyName, op, "the new name '%s' is only an extension", op.NewName)
+		}
+		if first, ok := targets[op.NewName]; ok {
+			add(lintCollision, op, "'%s' is also the new name of '%s'", op.NewName, first)
+		} else {
+			targets[op.NewName] = op.OldName
+		}
+		if len(op.NewName) > limit {
+			add(lintNameMax, op, "the new name is %d bytes long, the filesystem allows %d", len(op.NewName), limit)
+		}
+		if op.OldName != op.NewName && strings.EqualFold(op.OldName, op.NewName) {
+			if folds < 0 {
+				folds = 0
+				if caseInsensitive(dir) {
+					folds = 1
+				}
+			}
+			if folds == 1 {
+				add(lintCaseOnly, op, "only the case changes, the filesystem does not tell '%s' and '%s' apart", op.OldName, op.NewName)
+			}
+		}
+		if opts.ForcedExtension && !strings.EqualFold(filepath.Ext(op.OldName), filepath.Ext(op.NewName)) {
+			if kind, exts := contentKind(filepath.Join(dir, op.OldName)); kind != "" && !slices.Contains(exts, strings.ToLower(opts.Extension)) {
+				add(lintMagic, op, "the content is %s, not .%s", kind, opts.Extension)
+			}
+		}
+	}
+	return findings
+}
+
+// probeCaseInsensitive creates a lower case file in dir and reports whether it is found by its upper case name.
+// A directory the probe cannot be written to is taken as case sensitive.
+func probeCaseInsensitive(dir string) bool {
+	f, err := os.CreateTemp(dir, ".gsn-case-probe-*")
+	if err != nil {
+		return false
+	}
+	name := f.Name()
+	f.Close()
+	defer os.Remove(name)
+
+	upper := filepath.Join(dir, strings.ToUpper(filepath.Base(name)))
+	_, err = os.Lstat(upper)
+	return err == nil
+}
+
+// contentKind sniffs the magic bytes of a file and returns its content type with the extensions expected for
+// it, or an empty kind for text and contents it does not know
+func contentKind(path string) (string, []string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", nil
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", nil
+	}
+	kind, _, _ := strings.Cut(http.DetectContentType(head[:n]), ";")
+	exts, ok := magicExtensions[kind]
+	if !ok {
+		return "", nil
+	}
+	return kind, exts
+}
+
+// checkRenamePlan prints the findings of the lint and returns an error when any is not allowed
+func checkRenamePlan(dir string, plan []renameOp, opts renameOptions) error {
+	blocked := 0
+	for _, f := range lintRenamePlan(dir, plan, opts) {
+		if slices.Contains(opts.Allow, f.Rule) {
+			fmt.Fprintf(os.Stderr, style.Warning()+"'%s': %s (allowed %s)\n", f.OldName, f.Reason, f.Rule)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, style.Failure()+"'%s': %s (--allow %s)\n", f.OldName, f.Reason, f.Rule)
+		blocked++
+	}
+	if blocked > 0 {
+		return clierr.Newf(clierr.Conflict, "refusing to rename in '%s': the plan has %d problem(s), nothing was renamed", dir, blocked)
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package files
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"gsn-dev-tools/internals/clierr"
+)
+
+// pngHeader is the start of a PNG file, enough for its magic bytes to be recognized
+const pngHeader = "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+
+// useFilesystem makes the lint see a filesystem folding case or not, with names of at most limit bytes, and
+// counts the case probes
+func useFilesystem(t *testing.T, folds bool, limit int) *int {
+	t.Helper()
+	probes := 0
+	previousCase, previousMax := caseInsensitive, filesystemNameMax
+	caseInsensitive = func(string) bool { probes++; return folds }
+	filesystemNameMax = func(string) int { return limit }
+	t.Cleanup(func() { caseInsensitive, filesystemNameMax = previousCase, previousMax })
+	return &probes
+}
+
+// lint runs the lint over renames given as "old -> new" and returns its findings as "rule old: reason"
+func lint(t *testing.T, dir string, opts renameOptions, renames ...string) []string {
+	t.Helper()
+	var plan []renameOp
+	for _, rename := range renames {
+		oldName, newName, _ := strings.Cut(rename, " -> ")
+		op := renameOp{OldName: oldName, NewName: newName}
+		if newName == "" {
+			op.Err = errors.New("no valid name")
+		}
+		plan = append(plan, op)
+	}
+	var got []string
+	for _, f := range lintRenamePlan(dir, plan, opts) {
+		got = append(got, f.Rule+" "+f.OldName+": "+f.Reason)
+	}
+	return got
+}
+
+func TestLintCollision(t *testing.T) {
+	useFilesystem(t, false, 255)
+	got := lint(t, t.TempDir(), renameOptions{}, "My File.txt -> my_file.txt", "my-file.txt -> my_file.txt", "MY FILE.txt -> my_file.txt", "other.txt -> other.txt")
+	want := []string{
+		"collision my-file.txt: 'my_file.txt' is also the new name of 'My File.txt'",
+		"collision MY FILE.txt: 'my_file.txt' is also the new name of 'My File.txt'",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("findings = %q, want %q", got, want)
+	}
+}
+
+func TestLintEmptyName(t *testing.T) {
+	useFilesystem(t, false, 255)
+	tests := []struct {
+		rename string
+		want   string
+	}{
+		{"!!!.txt -> ", "empty-name !!!.txt: no name is left after cleaning"},
+		{"---.jpg -> .jpg", "empty-name ---.jpg: the new name '.jpg' is only an extension"},
+		{"(1).png -> _.png", "empty-name (1).png: the new name '_.png' is only an extension"},
+		{"a.png -> a.png", ""},
+		{".env -> .env", "empty-name .env: the new name '.env' is only an extension"},
+		{"x -> x", ""},
+	}
+	for _, test := range tests {
+		got := lint(t, t.TempDir(), renameOptions{}, test.rename)
+		if test.want == "" && len(got) != 0 || test.want != "" && !slices.Equal(got, []string{test.want}) {
+			t.Errorf("lint(%s) = %q, want %q", test.rename, got, test.want)
+		}
+	}
+}
+
+func TestLintCaseOnly(t *testing.T) {
+	dir := t.TempDir()
+	renames := []string{"Photo.JPG -> photo.jpg", "other one.jpg -> other_one.jpg", "README -> readme"}
+
+	probes := useFilesystem(t, true, 255)
+	want := []string{
+		"case-only Photo.JPG: only the case changes, the filesystem does not tell 'Photo.JPG' and 'photo.jpg' apart",
+		"case-only README: only the case changes, the filesystem does not tell 'README' and 'readme' apart",
+	}
+	if got := lint(t, dir, renameOptions{}, renames...); !slices.Equal(got, want) {
+		t.Errorf("findings on a case-insensitive filesystem = %q, want %q", got, want)
+	}
+	if *probes != 1 {
+		t.Errorf("the filesystem was probed %d times, want once per plan", *probes)
+	}
+
+	probes = useFilesystem(t, false, 255)
+	if got := lint(t, dir, renameOptions{}, renames...); len(got) != 0 {
+		t.Errorf("findings on a case-sensitive filesystem = %q", got)
+	}
+	// A plan without case only renames does not probe
+	probes = useFilesystem(t, true, 255)
+	if lint(t, dir, renameOptions{}, "a b.txt -> a_b.txt"); *probes != 0 {
+		t.Errorf("the filesystem was probed %d times for a plan without case only renames", *probes)
+	}
+}
+
+func TestProbeCaseInsensitive(t *testing.T) {
+	dir := t.TempDir()
+	folds := probeCaseInsensitive(dir)
+	// The probe agrees with a file created in lower case and looked up in upper case
+	if err := os.WriteFile(filepath.Join(dir, "probe.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := os.Lstat(filepath.Join(dir, "PROBE.TXT"))
+	if folds != (err == nil) {
+		t.Errorf("probeCaseInsensitive = %v, but looking up PROBE.TXT gave %v", folds, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("the probe left %d files behind", len(entries)-1)
+	}
+	// A directory the probe cannot write to is taken as case sensitive
+	if probeCaseInsensitive(filepath.Join(dir, "missing")) {
+		t.Error("a missing directory was probed as case-insensitive")
+	}
+}
+
+func TestLintNameMax(t *testing.T) {
+	useFilesystem(t, false, 16)
+	got := lint(t, t.TempDir(), renameOptions{}, "a.txt -> exactly_16_bytes", "b.txt -> seventeen_bytes_x", "c.txt -> ünïcödé_ñámé")
+	want := []string{
+		"name-max b.txt: the new name is 17 bytes long, the filesystem allows 16",
+		// Bytes count, not its 12 characters
+		"name-max c.txt: the new name is 19 bytes long, the filesystem allows 16",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("findings = %q, want %q", got, want)
+	}
+	if limit := nameMax(t.TempDir()); limit < 14 || limit > 1024 {
+		t.Errorf("nameMax of the temp dir = %d", limit)
+	}
+}
+
+func TestLintMagic(t *testing.T) {
+	useFilesystem(t, false, 255)
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"image.png":  pngHeader,
+		"image2.png": pngHeader,
+		"photo.jpeg": "\xff\xd8\xff\xe0\x00\x10JFIF\x00",
+		"notes.md":   "# notes\n",
+		"doc.pdf":    "%PDF-1.7\n",
+	})
+	tests := []struct {
+		name   string
+		opts   renameOptions
+		rename string
+		want   string
+	}{
+		{"png to txt", renameOptions{Extension: "txt", ForcedExtension: true}, "image.png -> image.txt", "magic image.png: the content is image/png, not .txt"},
+		{"pdf to doc", renameOptions{Extension: "doc", ForcedExtension: true}, "doc.pdf -> doc.doc", "magic doc.pdf: the content is application/pdf, not .doc"},
+		// Only an extension given with -e is checked
+		{"derived extension", renameOptions{Extension: "txt"}, "image.png -> image.txt", ""},
+		{"same extension", renameOptions{Extension: "PNG", ForcedExtension: true}, "image2.png -> image2.PNG", ""},
+		{"another expected extension", renameOptions{Extension: "jpg", ForcedExtension: true}, "photo.jpeg -> photo.jpg", ""},
+		// Text and unknown contents may get any extension
+		{"text", renameOptions{Extension: "txt", ForcedExtension: true}, "notes.md -> notes.txt", ""},
+		{"missing file", renameOptions{Extension: "txt", ForcedExtension: true}, "gone.png -> gone.txt", ""},
+	}
+	for _, test := range tests {
+		got := lint(t, dir, test.opts, test.rename)
+		if test.want == "" && len(got) != 0 || test.want != "" && !slices.Equal(got, []string{test.want}) {
+			t.Errorf("%s: findings = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCheckRenamePlan(t *testing.T) {
+	useFilesystem(t, false, 255)
+	dir := t.TempDir()
+	plan := []renameOp{
+		{OldName: "a b.txt", NewName: "a_b.txt"},
+		{OldName: "a-b.txt", NewName: "a_b.txt"},
+		{OldName: "---.txt", NewName: ".txt"},
+	}
+
+	var err error
+	stderr := captureStderr(t, func() { err = checkRenamePlan(dir, plan, renameOptions{}) })
+	if clierr.CodeOf(err) != clierr.Conflict || !strings.Contains(err.Error(), "the plan has 2 problem(s), nothing was renamed") {
+		t.Errorf("checkRenamePlan = %v", err)
+	}
+	for _, line := range []string{"'a-b.txt': 'a_b.txt' is also the new name of 'a b.txt' (--allow collision)", "'---.txt': the new name '.txt' is only an extension (--allow empty-name)"} {
+		if !strings.Contains(stderr, line) {
+			t.Errorf("stderr = %q, want %q", stderr, line)
+		}
+	}
+
+	// --allow lets one rule through and still blocks the others
+	stderr = captureStderr(t, func() { err = checkRenamePlan(dir, plan, renameOptions{Allow: []string{lintCollision}}) })
+	if err == nil || !strings.Contains(err.Error(), "1 problem(s)") || !strings.Contains(stderr, "(allowed collision)") {
+		t.Errorf("with --allow collision: %v\n%s", err, stderr)
+	}
+	if err := checkRenamePlan(dir, plan, renameOptions{Allow: []string{lintCollision, lintEmptyName}}); err != nil {
+		t.Errorf("with every rule allowed: %v", err)
+	}
+}
+
+func TestParseLintAllow(t *testing.T) {
+	if rules, err := parseLintAllow(slices.Clone(lintRules)); err != nil || !slices.Equal(rules, lintRules) {
+		t.Errorf("parseLintAllow(every rule) = %q, %v", rules, err)
+	}
+	_, err := parseLintAllow([]string{"magic", "colision"})
+	if clierr.CodeOf(err) != clierr.Usage || !strings.Contains(err.Error(), "unknown --allow rule 'colision' (use collision, empty-name, case-only, name-max, magic)") {
+		t.Errorf("parseLintAllow(colision) = %v", err)
+	}
+}
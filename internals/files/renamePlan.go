@@ -0,0 +1,160 @@
+package files
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gsn-dev-tools/internals/clierr"
+)
+
+// renameSort selects the order in which the rename plan assigns sequence numbers
+type renameSort string
+
+const (
+	sortName    renameSort = "name"
+	sortNatural renameSort = "natural"
+	sortMtime   renameSort = "mtime"
+	sortSize    renameSort = "size"
+)
+
+func parseRenameSort(value string) (renameSort, error) {
+	switch s := renameSort(strings.ToLower(value)); s {
+	case sortName, sortNatural, sortMtime, sortSize:
+		return s, nil
+	default:
+		return "", clierr.Newf(clierr.Usage, "invalid --sort value '%s' (use name, natural, mtime or size)", value)
+	}
+}
+
+// renameOptions configures how new names are derived
+type renameOptions struct {
+	Extension string
+	Template  string
+	Sort      renameSort
+
+	// ForcedExtension is set when -e was given, only then is the content checked against the new extension
+	ForcedExtension bool
+	// Allow lists the lint rules whose findings do not stop the rename
+	Allow []string
+}
+
+// renameSlugOptions are the naming rules of gsn rename: lowercase letters, a digit only right after a letter,
+// words split at whitespace and joined with _
+var renameSlugOptions = SlugOptions{Allow: SlugLetters | SlugLetterDigit, DropPunct: true}
+
+// renameOp is a single planned rename; Err is set when no valid name could be derived
+type renameOp struct {
+	OldName string
+	NewName string
+	Info    os.FileInfo
+	Err     error
+}
+
+// buildRenamePlan computes the new name of every file, numbering them in the requested order
+func buildRenamePlan(entries []os.DirEntry, opts renameOptions) ([]renameOp, error) {
+	var plan []renameOp
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue // Skip subdirectories
+		}
+		if strings.HasPrefix(entry.Name(), ".gsn-") {
+			continue // Skip journals and other gsn metadata
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		plan = append(plan, renameOp{OldName: entry.Name(), Info: info})
+	}
+
+	sortRenamePlan(plan, opts.Sort)
+
+	width := len(strconv.Itoa(len(plan)))
+	for i := range plan {
+		op := &plan[i]
+		baseName := strings.TrimSuffix(op.OldName, filepath.Ext(op.OldName))
+
+		cleanedName, err := Slugify(baseName, renameSlugOptions)
+		if opts.Template == "" {
+			if err != nil {
+				op.Err = err
+				continue
+			}
+		} else {
+			cleanedName = strings.NewReplacer(
+				"{name}", cleanedName,
+				"{n}", fmt.Sprintf("%0*d", width, i+1),
+			).Replace(opts.Template)
+			if strings.TrimSpace(cleanedName) == "" {
+				op.Err = fmt.Errorf("template produced an empty name for: %s", op.OldName)
+				continue
+			}
+		}
+
+		// Create new filename with extension
+		op.NewName = fmt.Sprintf("%s.%s", cleanedName, opts.Extension)
+	}
+
+	return plan, nil
+}
+
+// sortRenamePlan stably orders the plan before sequence numbers are assigned
+func sortRenamePlan(plan []renameOp, order renameSort) {
+	var less func(a, b renameOp) bool
+	switch order {
+	case sortNatural:
+		less = func(a, b renameOp) bool { return naturalLess(a.OldName, b.OldName) }
+	case sortMtime:
+		less = func(a, b renameOp) bool { return a.Info.ModTime().Before(b.Info.ModTime()) }
+	case sortSize:
+		less = func(a, b renameOp) bool { return a.Info.Size() < b.Info.Size() }
+	default:
+		less = func(a, b renameOp) bool { return a.OldName < b.OldName }
+	}
+
+	sort.SliceStable(plan, func(i, j int) bool { return less(plan[i], plan[j]) })
+}
+
+// naturalLess compares strings treating runs of digits as numbers, so img2 sorts before img10.
+// Equal numbers with different zero padding fall back to the shorter run first.
+func naturalLess(a string, b string) bool {
+	ra, rb := []rune(a), []rune(b)
+	i, j := 0, 0
+	for i < len(ra) && j < len(rb) {
+		if unicode.IsDigit(ra[i]) && unicode.IsDigit(rb[j]) {
+			si := i
+			for i < len(ra) && unicode.IsDigit(ra[i]) {
+				i++
+			}
+			sj := j
+			for j < len(rb) && unicode.IsDigit(rb[j]) {
+				j++
+			}
+
+			na := strings.TrimLeft(string(ra[si:i]), "0")
+			nb := strings.TrimLeft(string(rb[sj:j]), "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			if i-si != j-sj {
+				return i-si < j-sj
+			}
+			continue
+		}
+
+		if ra[i] != rb[j] {
+			return ra[i] < rb[j]
+		}
+		i++
+		j++
+	}
+	return len(ra)-i < len(rb)-j
+}
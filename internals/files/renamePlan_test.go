@@ -0,0 +1,118 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNaturalLess(t *testing.T) {
+	sorted := []string{"img1", "img01", "img001", "img2", "img02", "img9", "img10", "img10a", "img10b", "img100", "img100.jpg", "photo", "photo2"}
+	for i := range sorted {
+		for j := range sorted {
+			if got, want := naturalLess(sorted[i], sorted[j]), i < j; got != want {
+				t.Errorf("naturalLess(%q, %q) = %v, want %v", sorted[i], sorted[j], got, want)
+			}
+		}
+	}
+	shuffled := []string{"img10", "img001", "photo2", "img2", "img100.jpg", "img9", "img02", "img1", "photo", "img10b", "img100", "img01", "img10a"}
+	slices.SortFunc(shuffled, func(a, b string) int {
+		if naturalLess(a, b) {
+			return -1
+		}
+		if naturalLess(b, a) {
+			return 1
+		}
+		return 0
+	})
+	if !slices.Equal(shuffled, sorted) {
+		t.Errorf("sorted = %v, want %v", shuffled, sorted)
+	}
+}
+
+// planNames lists the old and new names of a rename plan over the files below dir
+func planNames(t *testing.T, dir string, opts renameOptions) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := buildRenamePlan(entries, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, op := range plan {
+		if op.Err != nil {
+			t.Fatalf("%s: %v", op.OldName, op.Err)
+		}
+		names = append(names, op.OldName+" -> "+op.NewName)
+	}
+	return names
+}
+
+func TestRenamePlanNumbering(t *testing.T) {
+	dir := t.TempDir()
+	sizes := map[string]int{"img10.jpg": 3, "img2.jpg": 1, "img002.jpg": 4, "img1.jpg": 2, "IMG_3.jpg": 5}
+	base := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	mtimes := map[string]time.Time{
+		"img10.jpg": base, "img2.jpg": base.Add(time.Minute), "img002.jpg": base, "img1.jpg": base.Add(-time.Minute), "IMG_3.jpg": base,
+	}
+	for name, size := range sizes {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(strings.Repeat("x", size)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtimes[name], mtimes[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// gsn metadata and directories are never numbered
+	writeTree(t, dir, map[string]string{".gsn-rename-journal.json": "{}", "sub/": ""})
+
+	tests := []struct {
+		order renameSort
+		want  []string
+	}{
+		{sortName, []string{"IMG_3.jpg", "img002.jpg", "img1.jpg", "img10.jpg", "img2.jpg"}},
+		{sortNatural, []string{"IMG_3.jpg", "img1.jpg", "img2.jpg", "img002.jpg", "img10.jpg"}},
+		// Equal mtimes keep the name order
+		{sortMtime, []string{"img1.jpg", "IMG_3.jpg", "img002.jpg", "img10.jpg", "img2.jpg"}},
+		{sortSize, []string{"img2.jpg", "img1.jpg", "img10.jpg", "img002.jpg", "IMG_3.jpg"}},
+	}
+	for _, test := range tests {
+		got := planNames(t, dir, renameOptions{Extension: "jpg", Template: "photo_{n}", Sort: test.order})
+		var want []string
+		for i, name := range test.want {
+			want = append(want, name+" -> photo_"+string(rune('1'+i))+".jpg")
+		}
+		slices.Sort(got)
+		slices.Sort(want)
+		if !slices.Equal(got, want) {
+			t.Errorf("--sort %s =\n%s\nwant\n%s", test.order, strings.Join(got, "\n"), strings.Join(want, "\n"))
+		}
+	}
+}
+
+func TestRenamePlanPadsNumbers(t *testing.T) {
+	dir := t.TempDir()
+	for i := range 12 {
+		writeTree(t, dir, map[string]string{"scan" + strings.Repeat("0", i%3) + string(rune('a'+i)) + ".png": ""})
+	}
+	names := planNames(t, dir, renameOptions{Extension: "png", Template: "{name}-{n}", Sort: sortNatural})
+	if len(names) != 12 || !strings.HasSuffix(names[0], "-01.png") || !strings.HasSuffix(names[11], "-12.png") {
+		t.Errorf("names = %v, want 01 to 12", names)
+	}
+}
+
+func TestParseRenameSort(t *testing.T) {
+	if s, err := parseRenameSort("Natural"); err != nil || s != sortNatural {
+		t.Errorf("parseRenameSort(Natural) = %q, %v", s, err)
+	}
+	if _, err := parseRenameSort("random"); err == nil {
+		t.Error("parseRenameSort(random) succeeded")
+	}
+}
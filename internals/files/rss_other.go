@@ -0,0 +1,8 @@
+//go:build !unix
+
+package files
+
+// peakRSS is unknown where getrusage is missing, only the heap is reported
+func peakRSS() (int64, bool) {
+	return 0, false
+}
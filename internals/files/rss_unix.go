@@ -0,0 +1,21 @@
+//go:build unix
+
+package files
+
+import (
+	"runtime"
+	"syscall"
+)
+
+// peakRSS returns the largest resident set size the process reached
+func peakRSS() (int64, bool) {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0, false
+	}
+	// macOS reports bytes, the other systems kilobytes
+	if runtime.GOOS == "darwin" {
+		return int64(usage.Maxrss), true
+	}
+	return int64(usage.Maxrss) * 1024, true
+}
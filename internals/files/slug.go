@@ -0,0 +1,291 @@
+package files
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/style"
+
+	"github.com/spf13/cobra"
+)
+
+// SlugClass is a set of character classes a slug keeps
+type SlugClass int
+
+const (
+	// SlugLetters keeps letters
+	SlugLetters SlugClass = 1 << iota
+	// SlugDigits keeps digits
+	SlugDigits
+	// SlugLetterDigit keeps a digit only when it directly follows a letter, the rule of gsn rename: "v2 a12"
+	// becomes "v2_a1"
+	SlugLetterDigit
+	// SlugASCII drops every character outside ASCII, use it with Transliterate to keep accented letters
+	SlugASCII
+)
+
+// SlugCase is the case style of a slug
+type SlugCase string
+
+const (
+	// SlugLower lowercases the words and joins them with the separator
+	SlugLower SlugCase = "lower"
+	// SlugSnake lowercases the words and joins them with _
+	SlugSnake SlugCase = "snake"
+	// SlugKebab lowercases the words and joins them with -
+	SlugKebab SlugCase = "kebab"
+)
+
+// SlugOptions configures Slugify. The zero value keeps letters and digits, lowercased, joined with _.
+type SlugOptions struct {
+	// Separator joins the words of a SlugLower slug, _ when empty
+	Separator string
+	// MaxLength caps the slug in bytes, dropping the last words that do not fit; a first word too long alone is
+	// cut. 0 keeps the slug whole.
+	MaxLength int
+	// Allow are the characters kept, SlugLetters|SlugDigits when 0
+	Allow SlugClass
+	// Transliterate replaces accented and other Latin letters by their ASCII spelling, like é by e and ß by ss
+	Transliterate bool
+	// Case is the case style, SlugLower when empty
+	Case SlugCase
+	// DropPunct removes punctuation and symbols inside words instead of splitting the words at them, the rule of
+	// gsn rename: "foo-bar" becomes "foobar" rather than "foo_bar"
+	DropPunct bool
+}
+
+// slugTransliterations spells the Latin letters without a decomposition into a base letter and accents
+var slugTransliterations = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'Æ': "AE", 'œ': "oe", 'Œ': "OE", 'ø': "o", 'Ø': "O", 'đ': "d", 'Đ': "D",
+	'ł': "l", 'Ł': "L", 'þ': "th", 'Þ': "TH", 'ð': "d", 'Ð': "D", 'ı': "i", 'ħ': "h", 'Ħ': "H",
+}
+
+// slugAccented maps the accented Latin letters to their base letter, by the letters of each base
+var slugAccented = func() map[rune]rune {
+	bases := map[rune]string{
+		'a': "àáâãäåāăą", 'A': "ÀÁÂÃÄÅĀĂĄ", 'c': "çćĉċč", 'C': "ÇĆĈĊČ", 'd': "ď", 'D': "Ď",
+		'e': "èéêëēĕėęě", 'E': "ÈÉÊËĒĔĖĘĚ", 'g': "ĝğġģ", 'G': "ĜĞĠĢ", 'h': "ĥ", 'H': "Ĥ",
+		'i': "ìíîïĩīĭįİ", 'I': "ÌÍÎÏĨĪĬĮ", 'j': "ĵ", 'J': "Ĵ", 'k': "ķ", 'K': "Ķ", 'l': "ĺļľŀ", 'L': "ĹĻĽĿ",
+		'n': "ñńņňŉ", 'N': "ÑŃŅŇ", 'o': "òóôõöōŏő", 'O': "ÒÓÔÕÖŌŎŐ", 'r': "ŕŗř", 'R': "ŔŖŘ",
+		's': "śŝşšș", 'S': "ŚŜŞŠȘ", 't': "ţťŧț", 'T': "ŢŤŦȚ", 'u': "ùúûüũūŭůűų", 'U': "ÙÚÛÜŨŪŬŮŰŲ",
+		'w': "ŵ", 'W': "Ŵ", 'y': "ýÿŷ", 'Y': "ÝŸŶ", 'z': "źżž", 'Z': "ŹŻŽ",
+	}
+	accented := map[rune]rune{}
+	for base, letters := range bases {
+		for _, r := range letters {
+			accented[r] = base
+		}
+	}
+	return accented
+}()
+
+// transliterate spells s with ASCII letters where it knows how
+func transliterate(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if base, ok := slugAccented[r]; ok {
+			b.WriteRune(base)
+		} else if spelled, ok := slugTransliterations[r]; ok {
+			b.WriteString(spelled)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// separator returns the string joining the words of a slug
+func (o SlugOptions) separator() (string, error) {
+	switch o.Case {
+	case "", SlugLower:
+		if o.Separator == "" {
+			return "_", nil
+		}
+		return o.Separator, nil
+	case SlugSnake, SlugKebab:
+		sep := "_"
+		if o.Case == SlugKebab {
+			sep = "-"
+		}
+		if o.Separator != "" && o.Separator != sep {
+			return "", clierr.Newf(clierr.Usage, "the %s case joins words with %s, it cannot use the separator '%s'", o.Case, sep, o.Separator)
+		}
+		return sep, nil
+	}
+	return "", clierr.Newf(clierr.Usage, "invalid case '%s', use lower, snake or kebab", o.Case)
+}
+
+// Slugify turns s into a name safe for files, branches and archives: the characters of opts.Allow in lowercase
+// words joined by the separator. Whitespace always separates words, punctuation and symbols too unless
+// opts.DropPunct is set. A slug left empty is an error.
+func Slugify(s string, opts SlugOptions) (string, error) {
+	sep, err := opts.separator()
+	if err != nil {
+		return "", err
+	}
+	if opts.MaxLength < 0 {
+		return "", clierr.Newf(clierr.Usage, "the maximum slug length must not be negative")
+	}
+	allow := opts.Allow
+	if allow == 0 {
+		allow = SlugLetters | SlugDigits
+	}
+	text := s
+	if opts.Transliterate {
+		text = transliterate(s)
+	}
+
+	var words []string
+	var word strings.Builder
+	endWord := func() {
+		if word.Len() > 0 {
+			words = append(words, word.String())
+			word.Reset()
+		}
+	}
+	lastWasLetter := false
+	for _, r := range text {
+		wasLetter := lastWasLetter
+		lastWasLetter = false
+		switch {
+		case allow&SlugASCII != 0 && r >= utf8.RuneSelf:
+			continue
+		case unicode.IsLetter(r):
+			if allow&SlugLetters != 0 {
+				word.WriteRune(unicode.ToLower(r))
+				lastWasLetter = true
+			}
+		case unicode.IsDigit(r):
+			if allow&SlugDigits != 0 || allow&SlugLetterDigit != 0 && wasLetter {
+				word.WriteRune(r)
+			}
+		case unicode.IsSpace(r):
+			endWord()
+		case !opts.DropPunct && (unicode.IsPunct(r) || unicode.IsSymbol(r)):
+			endWord()
+		}
+	}
+	endWord()
+
+	slug := strings.Join(words, sep)
+	if opts.MaxLength > 0 && len(slug) > opts.MaxLength {
+		slug = truncateSlug(words, sep, opts.MaxLength)
+	}
+	if slug == "" {
+		return "", fmt.Errorf("no name is left after cleaning '%s'", s)
+	}
+	return slug, nil
+}
+
+// truncateSlug joins as many whole words as fit in limit bytes, cutting the first word when it does not fit alone
+func truncateSlug(words []string, sep string, limit int) string {
+	slug := ""
+	for i, w := range words {
+		next := w
+		if i > 0 {
+			next = slug + sep + w
+		}
+		if len(next) > limit {
+			break
+		}
+		slug = next
+	}
+	if slug != "" {
+		return slug
+	}
+
+	cut := words[0]
+	for len(cut) > limit {
+		_, size := utf8.DecodeLastRuneInString(cut)
+		cut = cut[:len(cut)-size]
+	}
+	return cut
+}
+
+// SlugCmd turns text into filename safe slugs
+func SlugCmd() *cobra.Command {
+	slugCmd := &cobra.Command{
+		Use:   "slug [text]...",
+		Short: "Turn text into a filename safe slug",
+		Long: `Prints the slug of the arguments, joined by spaces, or of every line of stdin without arguments. A slug keeps
+the characters of --allow in lowercase words joined by the separator of --case; whitespace, punctuation and
+symbols separate words. Accented letters are spelled in ASCII unless --transliterate=false. --max-length drops
+the last words that do not fit.
+
+A text left without any allowed character has no slug: it is reported and gsn slug exits with 1 once every line
+is done.`,
+		Example: `  gsn slug "Quarterly Report: Café Sales (2024)"
+  gsn slug --case snake --max-length 30 "A very long branch name for a small fix"
+  git log --format=%s -5 | gsn slug --allow letters`,
+		Run: runSlug,
+	}
+
+	slugCmd.Flags().String("case", string(SlugKebab), "Case style: lower, snake or kebab")
+	slugCmd.Flags().String("separator", "", "Separator of the words with --case lower (default _)")
+	slugCmd.Flags().Int("max-length", 0, "Maximum slug length in bytes, 0 for no limit")
+	slugCmd.Flags().StringSlice("allow", []string{"letters", "digits"}, "Character classes kept: letters, digits and ascii")
+	slugCmd.Flags().Bool("transliterate", true, "Spell accented letters in ASCII")
+	dryrun.ReadOnly(slugCmd)
+	return slugCmd
+}
+
+func runSlug(cmd *cobra.Command, args []string) {
+	caseName, _ := cmd.Flags().GetString("case")
+	separator, _ := cmd.Flags().GetString("separator")
+	maxLength, _ := cmd.Flags().GetInt("max-length")
+	allowNames, _ := cmd.Flags().GetStringSlice("allow")
+	translit, _ := cmd.Flags().GetBool("transliterate")
+
+	opts := SlugOptions{Case: SlugCase(caseName), Separator: separator, MaxLength: maxLength, Transliterate: translit}
+	for _, name := range allowNames {
+		switch strings.TrimSpace(name) {
+		case "letters":
+			opts.Allow |= SlugLetters
+		case "digits":
+			opts.Allow |= SlugDigits
+		case "ascii":
+			opts.Allow |= SlugASCII
+		default:
+			clierr.Exitf(clierr.Usage, "invalid --allow class '%s', use letters, digits or ascii", name)
+		}
+	}
+	if opts.Allow == SlugASCII {
+		clierr.Exitf(clierr.Usage, "--allow ascii needs letters or digits as well")
+	}
+
+	if len(args) > 0 {
+		slug, err := Slugify(strings.Join(args, " "), opts)
+		if err != nil {
+			clierr.Fatalf("%v", err)
+		}
+		fmt.Println(slug)
+		return
+	}
+
+	failed := false
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		slug, err := Slugify(scanner.Text(), opts)
+		if clierr.CodeOf(err) == clierr.Usage {
+			clierr.Fatalf("%v", err)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, style.Error()+"%v\n", err)
+			failed = true
+			continue
+		}
+		fmt.Println(slug)
+	}
+	if err := scanner.Err(); err != nil {
+		clierr.Fatalf("failed to read stdin: %v", err)
+	}
+	if failed {
+		clierr.Exit(clierr.Failure)
+	}
+}
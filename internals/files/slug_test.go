@@ -0,0 +1,178 @@
+package files
+
+import (
+	"math/rand/v2"
+	"strings"
+	"testing"
+	"unicode"
+
+	"gsn-dev-tools/internals/clierr"
+)
+
+// TestSlugify is the spec of the slug rules, one option at a time
+func TestSlugify(t *testing.T) {
+	kebab := SlugOptions{Case: SlugKebab, Transliterate: true}
+	tests := []struct {
+		name string
+		in   string
+		opts SlugOptions
+		want string
+	}{
+		{"zero options", "Hello World", SlugOptions{}, "hello_world"},
+		{"whitespace runs", "  Hello \t\n World  ", SlugOptions{}, "hello_world"},
+		{"punctuation splits words", "foo-bar.baz,qux", SlugOptions{}, "foo_bar_baz_qux"},
+		{"symbols split words", "a+b=c $5", SlugOptions{}, "a_b_c_5"},
+		{"punctuation runs", "what?! (really)", SlugOptions{}, "what_really"},
+		{"digits", "Report 2024 v2", SlugOptions{}, "report_2024_v2"},
+		{"unicode letters kept", "Größe Ελλάδα 東京", SlugOptions{}, "größe_ελλάδα_東京"},
+		{"separator", "Hello World", SlugOptions{Separator: "."}, "hello.world"},
+		{"lower case with separator", "Hello World", SlugOptions{Case: SlugLower, Separator: "+"}, "hello+world"},
+		{"snake", "Hello World", SlugOptions{Case: SlugSnake}, "hello_world"},
+		{"snake with its own separator", "Hello World", SlugOptions{Case: SlugSnake, Separator: "_"}, "hello_world"},
+		{"kebab", "Hello World", SlugOptions{Case: SlugKebab}, "hello-world"},
+		{"kebab of a title", "Quarterly Report: Café Sales (2024)", kebab, "quarterly-report-cafe-sales-2024"},
+
+		{"transliterated accents", "Crème Brûlée à la Ñandú", kebab, "creme-brulee-a-la-nandu"},
+		{"transliterated ligatures", "Straße Œuvre Æsir Øre Łódź Þór", kebab, "strasse-oeuvre-aesir-ore-lodz-thor"},
+		{"not transliterated", "Crème Brûlée", SlugOptions{Case: SlugKebab}, "crème-brûlée"},
+		{"ascii drops the rest", "Crème 東京 Brûlée", SlugOptions{Allow: SlugLetters | SlugASCII}, "crme_brle"},
+		{"ascii after transliteration", "Crème 東京 Brûlée", SlugOptions{Allow: SlugLetters | SlugASCII, Transliterate: true}, "creme_brulee"},
+
+		{"letters only", "Release 2.0 notes", SlugOptions{Allow: SlugLetters}, "release_notes"},
+		{"digits only", "Release 2.0 notes", SlugOptions{Allow: SlugDigits}, "2_0"},
+		{"a digit after a letter", "v2 a12 2b", SlugOptions{Allow: SlugLetters | SlugLetterDigit}, "v2_a1_b"},
+		{"drop punctuation", "foo-bar's.txt", SlugOptions{DropPunct: true}, "foobarstxt"},
+		{"drop punctuation keeps spaces", "don't stop - now", SlugOptions{DropPunct: true}, "dont_stop_now"},
+
+		{"fits", "one two three", SlugOptions{MaxLength: 13}, "one_two_three"},
+		{"last word dropped", "one two three", SlugOptions{MaxLength: 12}, "one_two"},
+		{"only whole words", "one two three", SlugOptions{MaxLength: 6}, "one"},
+		{"first word cut", "extraordinary things", SlugOptions{MaxLength: 5}, "extra"},
+		{"cut between runes", "ééééé", SlugOptions{MaxLength: 5}, "éé"},
+		{"max length with kebab", "A very long branch name for a small fix", SlugOptions{Case: SlugKebab, MaxLength: 20}, "a-very-long-branch"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Slugify(test.in, test.opts)
+			if err != nil || got != test.want {
+				t.Errorf("Slugify(%q, %+v) = %q, %v, want %q", test.in, test.opts, got, err, test.want)
+			}
+		})
+	}
+}
+
+func TestSlugifyErrors(t *testing.T) {
+	tests := []struct {
+		in   string
+		opts SlugOptions
+		want string
+		code clierr.Code
+	}{
+		{"", SlugOptions{}, "no name is left after cleaning ''", clierr.Failure},
+		{" -- ... ", SlugOptions{}, "no name is left after cleaning ' -- ... '", clierr.Failure},
+		{"2024", SlugOptions{Allow: SlugLetters}, "no name is left after cleaning '2024'", clierr.Failure},
+		{"東京", SlugOptions{Allow: SlugLetters | SlugASCII}, "no name is left after cleaning '東京'", clierr.Failure},
+		{"a b", SlugOptions{Case: "camel"}, "invalid case 'camel', use lower, snake or kebab", clierr.Usage},
+		{"a b", SlugOptions{Case: SlugKebab, Separator: "_"}, "the kebab case joins words with -, it cannot use the separator '_'", clierr.Usage},
+		{"a b", SlugOptions{Case: SlugSnake, Separator: "-"}, "the snake case joins words with _, it cannot use the separator '-'", clierr.Usage},
+		{"a b", SlugOptions{MaxLength: -1}, "the maximum slug length must not be negative", clierr.Usage},
+	}
+	for _, test := range tests {
+		_, err := Slugify(test.in, test.opts)
+		if err == nil || err.Error() != test.want || clierr.CodeOf(err) != test.code {
+			t.Errorf("Slugify(%q, %+v) = %v (code %d), want %q (code %d)", test.in, test.opts, err, clierr.CodeOf(err), test.want, test.code)
+		}
+	}
+}
+
+// renameCleanV1 is the cleaning gsn rename did before Slugify, kept as the reference renameSlugOptions must match
+// so renames keep their names
+func renameCleanV1(name string) (string, bool) {
+	var result strings.Builder
+	var lastWasSpace, lastWasLetter bool
+	for _, r := range name {
+		if unicode.IsLetter(r) {
+			result.WriteRune(unicode.ToLower(r))
+			lastWasSpace = false
+			lastWasLetter = true
+		} else if unicode.IsDigit(r) {
+			if lastWasLetter {
+				result.WriteRune(r)
+				lastWasSpace = false
+				lastWasLetter = false
+			}
+		} else if unicode.IsSpace(r) {
+			if !lastWasSpace && result.Len() > 0 {
+				result.WriteRune('_')
+				lastWasSpace = true
+				lastWasLetter = false
+			}
+		} else {
+			lastWasLetter = false
+		}
+	}
+	cleaned := strings.Trim(result.String(), "_")
+	return cleaned, cleaned != ""
+}
+
+func TestSlugifyRenameCompat(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"Holiday Photo", "holiday_photo"},
+		{"IMG 2041", "img"},
+		{"IMG2041", "img2"},
+		{"v2 a12 2b", "v2_a1_b"},
+		{"track01-final", "track0final"},
+		{"foo-bar's copy", "foobars_copy"},
+		{"a-1", "a"},
+		{"  lots   of\tspace  ", "lots_of_space"},
+		{"a 1 b", "a_b"},
+		{"Résumé (Final)", "résumé_final"},
+		{"東京 2024 旅行", "東京_旅行"},
+		{"1 2 3 go", "go"},
+	}
+	for _, test := range tests {
+		got, err := Slugify(test.in, renameSlugOptions)
+		if err != nil || got != test.want {
+			t.Errorf("Slugify(%q, renameSlugOptions) = %q, %v, want %q", test.in, got, err, test.want)
+		}
+		if old, _ := renameCleanV1(test.in); old != test.want {
+			t.Errorf("the old cleaning of %q = %q, the table is wrong", test.in, old)
+		}
+	}
+	for _, in := range []string{"", "2024", "--- ...", "(1) [2]"} {
+		if got, err := Slugify(in, renameSlugOptions); err == nil {
+			t.Errorf("Slugify(%q, renameSlugOptions) = %q, want an error like before", in, got)
+		}
+	}
+
+	// Random names built from the characters the rules treat differently
+	alphabet := []rune("aZé東1٣ \t_-.'()ß!")
+	rng := rand.New(rand.NewPCG(465, 1))
+	for i := range 20000 {
+		name := make([]rune, rng.IntN(12))
+		for j := range name {
+			name[j] = alphabet[rng.IntN(len(alphabet))]
+		}
+		want, ok := renameCleanV1(string(name))
+		got, err := Slugify(string(name), renameSlugOptions)
+		if (err == nil) != ok || got != want {
+			t.Fatalf("name %d %q: Slugify = %q, %v, the old cleaning %q", i, string(name), got, err, want)
+		}
+	}
+}
+
+func TestTransliterate(t *testing.T) {
+	for in, want := range map[string]string{
+		"àéîõüçñ":       "aeioucn",
+		"ÀÉÎÕÜÇÑ":       "AEIOUCN",
+		"ßæœøđłþðıħ":    "ssaeoeodlthdih",
+		"東京 and ελλάδα": "東京 and ελλάδα",
+	} {
+		if got := transliterate(in); got != want {
+			t.Errorf("transliterate(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package files
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"gsn-dev-tools/internals/config"
+	"gsn-dev-tools/internals/units"
+)
+
+// defaultStoredExtensions are the extensions of files whose content is compressed already, deflating them again
+// costs CPU for next to no gain. cmp.stored_extensions in the config file replaces the list.
+var defaultStoredExtensions = []string{
+	// Images
+	"jpg", "jpeg", "png", "gif", "webp", "heic", "avif",
+	// Audio and video
+	"mp3", "m4a", "aac", "ogg", "opus", "flac", "mp4", "m4v", "mov", "mkv", "webm", "avi",
+	// Archives and compressed streams
+	"zip", "gz", "tgz", "bz2", "xz", "zst", "lz4", "br", "7z", "rar",
+	// Formats holding zip or deflate streams
+	"jar", "apk", "whl", "docx", "xlsx", "pptx", "odt", "ods", "epub", "woff", "woff2",
+}
+
+// storedTypes decides which entries --smart stores instead of compressing, by their extension
+type storedTypes struct {
+	exts map[string]bool
+}
+
+// loadStoredTypes returns the extensions of cmp.stored_extensions, or the default list
+func loadStoredTypes() (*storedTypes, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	list := defaultStoredExtensions
+	if len(cfg.Cmp.StoredExtensions) > 0 {
+		list = cfg.Cmp.StoredExtensions
+	}
+	s := &storedTypes{exts: make(map[string]bool, len(list))}
+	for _, ext := range list {
+		s.exts[strings.ToLower(strings.TrimPrefix(ext, "."))] = true
+	}
+	return s, nil
+}
+
+// stores reports whether the entry called name is compressed already. The last extension counts, so
+// logs.tar.gz is and logs.tar is not. A nil storedTypes stores nothing.
+func (s *storedTypes) stores(name string) bool {
+	if s == nil {
+		return false
+	}
+	ext := strings.TrimPrefix(path.Ext(name), ".")
+	return ext != "" && s.exts[strings.ToLower(ext)]
+}
+
+// precompressedTally counts the already compressed files a single-stream archive ran through its codec anyway
+type precompressedTally struct {
+	types *storedTypes
+	Files int
+	Size  int64
+}
+
+func (t *precompressedTally) add(name string, size int64) {
+	if t != nil && t.types.stores(name) {
+		t.Files++
+		t.Size += size
+	}
+}
+
+// report describes the effort spent on the counted files, "" when there were none
+func (t *precompressedTally) report(result *CompressResult, format archiveFormat) string {
+	if t == nil || t.Files == 0 || format.Codec == codecNone {
+		return ""
+	}
+	share := "n/a"
+	if result.SourceSize > 0 {
+		share = units.FormatDecimal(float64(t.Size)/float64(result.SourceSize)*100, 1) + "%"
+	}
+	return fmt.Sprintf("Already compressed: %s file(s), %s (%s of the source) ran through %s for next to no gain; %s compresses the whole stream, "+
+		"--format zip --smart stores them instead", units.FormatInt(int64(t.Files)), units.FormatBytes(t.Size), share, format.Codec, format)
+}
+
+// entryStats counts the files an archive stores as they are and the files it compresses, for --stats
+type entryStats struct {
+	// stored reports whether the file called name is written as it is
+	stored func(name string) bool
+
+	Stored         int
+	StoredSize     int64
+	Compressed     int
+	CompressedSize int64
+}
+
+// newEntryStats counts for an archive of the format: a zip stores the files of the stored types, nil none, a
+// stream without codec stores every file and a codec compresses every file
+func newEntryStats(format archiveFormat, stored *storedTypes) *entryStats {
+	switch {
+	case format.Container == containerZip:
+		return &entryStats{stored: stored.stores}
+	case format.Codec == codecNone:
+		return &entryStats{stored: func(string) bool { return true }}
+	default:
+		return &entryStats{stored: func(string) bool { return false }}
+	}
+}
+
+func (s *entryStats) add(name string, size int64) {
+	if s == nil {
+		return
+	}
+	if s.stored(name) {
+		s.Stored++
+		s.StoredSize += size
+	} else {
+		s.Compressed++
+		s.CompressedSize += size
+	}
+}
+
+func (s *entryStats) String() string {
+	return fmt.Sprintf("Stored as they are: %s file(s), %s; compressed: %s file(s), %s", units.FormatInt(int64(s.Stored)),
+		units.FormatBytes(s.StoredSize), units.FormatInt(int64(s.Compressed)), units.FormatBytes(s.CompressedSize))
+}
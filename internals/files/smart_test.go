@@ -0,0 +1,288 @@
+package files
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+// noise is size bytes no codec can shrink
+func noise(size int, seed uint64) string {
+	data := make([]byte, size)
+	rng := rand.NewChaCha8([32]byte{byte(seed)})
+	_, _ = rng.Read(data)
+	return string(data)
+}
+
+// smartFixture holds already compressed files, of upper and lower case extensions, next to text that deflates
+func smartFixture(t *testing.T) []fixtureEntry {
+	t.Helper()
+	text := strings.Repeat("level=info msg=\"request served\" status=200\n", 2000)
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	if _, err := zw.Write([]byte(noise(50_000, 3) + text)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return []fixtureEntry{
+		{Name: "media/", Type: tar.TypeDir, Mode: 0o755},
+		{Name: "media/photo.JPG", Type: tar.TypeReg, Body: noise(300_000, 1), Mode: 0o644},
+		{Name: "media/clip.mp4", Type: tar.TypeReg, Body: noise(200_000, 2), Mode: 0o644},
+		{Name: "logs/app.log.gz", Type: tar.TypeReg, Body: gz.String(), Mode: 0o644},
+		{Name: "logs/app.log", Type: tar.TypeReg, Body: text, Mode: 0o644},
+		{Name: "logs/old.tar", Type: tar.TypeReg, Body: text, Mode: 0o644},
+	}
+}
+
+// zipMembers maps the members of a zip to their header
+func zipMembers(t *testing.T, path string) map[string]*zip.FileHeader {
+	t.Helper()
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	members := make(map[string]*zip.FileHeader)
+	for _, f := range r.File {
+		members[f.Name] = &f.FileHeader
+	}
+	return members
+}
+
+func TestStoredTypes(t *testing.T) {
+	useGSNHome(t, t.TempDir())
+	stored, err := loadStoredTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]bool{
+		"photo.jpg":        true,
+		"photo.JPG":        true,
+		"backup.tar.gz":    true, // the last extension counts
+		"backup.tar":       false,
+		"report.docx":      true,
+		"notes.txt":        false,
+		"Makefile":         false,
+		"media/clip.MP4":   true,
+		"gz/readme":        false,
+		"archive.zip.part": false,
+	}
+	for name, want := range tests {
+		if got := stored.stores(name); got != want {
+			t.Errorf("stores(%q) = %v, want %v", name, got, want)
+		}
+	}
+	if (*storedTypes)(nil).stores("photo.jpg") {
+		t.Error("a nil storedTypes stores photo.jpg")
+	}
+}
+
+// TestConvertZipSmart converts the same tar to a zip with and without --smart: the already compressed members
+// are stored as they are, the rest deflate the same way, and every member extracts to its content
+func TestConvertZipSmart(t *testing.T) {
+	useGSNHome(t, t.TempDir())
+	entries := smartFixture(t)
+	dir := t.TempDir()
+	sourcePath := filepath.Join(dir, "source.tar")
+	writeFixtureArchive(t, sourcePath, formatTar, entries)
+
+	stored, err := loadStoredTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plainPath, smartPath := filepath.Join(dir, "plain.zip"), filepath.Join(dir, "smart.zip")
+	if _, err := convertArchive(sourcePath, plainPath, formatZip, nil); err != nil {
+		t.Fatal(err)
+	}
+	digests, err := convertArchive(sourcePath, smartPath, formatZip, stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyDigests(smartPath, digests); err != nil {
+		t.Errorf("verifyDigests: %v", err)
+	}
+
+	plain, smart := zipMembers(t, plainPath), zipMembers(t, smartPath)
+	for _, name := range []string{"media/photo.JPG", "media/clip.mp4", "logs/app.log.gz"} {
+		p, s := plain[name], smart[name]
+		if p.Method != zip.Deflate || s.Method != zip.Store {
+			t.Errorf("%s: method %d without --smart and %d with it, want deflate then store", name, p.Method, s.Method)
+		}
+		// Deflating what is compressed already only adds the framing of its blocks
+		if s.CompressedSize64 != s.UncompressedSize64 || p.CompressedSize64 <= s.CompressedSize64 {
+			t.Errorf("%s: %d bytes deflated, %d stored, of %d", name, p.CompressedSize64, s.CompressedSize64, s.UncompressedSize64)
+		}
+	}
+	for _, name := range []string{"logs/app.log", "logs/old.tar"} {
+		p, s := plain[name], smart[name]
+		if p.Method != zip.Deflate || s.Method != zip.Deflate || p.CompressedSize64 != s.CompressedSize64 || s.CompressedSize64*10 > s.UncompressedSize64 {
+			t.Errorf("%s: deflated to %d bytes without --smart and %d with it, of %d", name, p.CompressedSize64, s.CompressedSize64, s.UncompressedSize64)
+		}
+	}
+	plainInfo, _ := os.Stat(plainPath)
+	smartInfo, _ := os.Stat(smartPath)
+	if smartInfo.Size() >= plainInfo.Size() {
+		t.Errorf("smart zip is %d bytes, the plain one %d", smartInfo.Size(), plainInfo.Size())
+	}
+
+	// Stored members read back and extract like deflated ones
+	got := readConverted(t, smartPath)
+	if len(got) != len(entries) {
+		t.Fatalf("smart zip has %d entries, want %d", len(got), len(entries))
+	}
+	for i, want := range entries {
+		if got[i].fixtureEntry != want {
+			t.Errorf("entry %s differs after the conversion", want.Name)
+		}
+	}
+	dest := filepath.Join(dir, "out")
+	conflicts, perms := testExtractPolicies(dest)
+	if count, err := extractAll(smartPath, dest, conflicts, perms, nil); err != nil || count != len(entries) {
+		t.Fatalf("extractAll = %d, %v", count, err)
+	}
+	for _, want := range entries {
+		if want.Type != tar.TypeReg {
+			continue
+		}
+		if data, err := os.ReadFile(filepath.Join(dest, filepath.FromSlash(want.Name))); err != nil || string(data) != want.Body {
+			t.Errorf("extracted %s differs (%d bytes, %v), want %d bytes", want.Name, len(data), err, len(want.Body))
+		}
+	}
+}
+
+// TestCompressSmartReport counts the already compressed files a tar.gz runs through gzip anyway, and reports
+// nothing for a tar, which compresses nothing
+func TestCompressSmartReport(t *testing.T) {
+	useGSNHome(t, t.TempDir())
+	stored, err := loadStoredTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := filepath.Join(t.TempDir(), "project")
+	files := make(map[string]string)
+	var size int64
+	for _, e := range smartFixture(t) {
+		if e.Type == tar.TypeReg {
+			files[e.Name] = e.Body
+			if stored.stores(e.Name) {
+				size += int64(len(e.Body))
+			}
+		}
+	}
+	writeTree(t, source, files)
+
+	for _, format := range []archiveFormat{formatTarGz, formatTar} {
+		output := filepath.Join(t.TempDir(), "project."+format.String())
+		result, err := compressPath(source, compressOptions{Format: format, Output: output, Smart: stored, SkipSpaceCheck: true, Progress: progressbar.DefaultBytesSilent(-1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.precompressed.Files != 3 || result.precompressed.Size != size {
+			t.Errorf("%s: tally = %d file(s), %d bytes, want 3 and %d", format, result.precompressed.Files, result.precompressed.Size, size)
+		}
+		report := result.precompressed.report(result, format)
+		if format == formatTar && report != "" {
+			t.Errorf("tar report = %q", report)
+		}
+		if format == formatTarGz && (!strings.HasPrefix(report, "Already compressed: 3 file(s), ") || !strings.Contains(report, "ran through gzip")) {
+			t.Errorf("tar.gz report = %q", report)
+		}
+	}
+}
+
+// TestCompressZipSmart compresses a tree to a zip with and without --smart: the already compressed files are stored
+// as they are, a hard link is archived with its content, and --stats counts the stored and compressed files
+func TestCompressZipSmart(t *testing.T) {
+	useGSNHome(t, t.TempDir())
+	stored, err := loadStoredTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := filepath.Join(t.TempDir(), "project")
+	files := make(map[string]string)
+	var storedSize, compressedSize int64
+	for _, e := range smartFixture(t) {
+		if e.Type == tar.TypeReg {
+			files[e.Name] = e.Body
+			if stored.stores(e.Name) {
+				storedSize += int64(len(e.Body))
+			} else {
+				compressedSize += int64(len(e.Body))
+			}
+		}
+	}
+	writeTree(t, source, files)
+	if err := os.Link(filepath.Join(source, "logs", "app.log"), filepath.Join(source, "app.log")); err != nil {
+		t.Fatal(err)
+	}
+	compressedSize += int64(len(files["logs/app.log"]))
+
+	compress := func(format archiveFormat, smart *storedTypes) (string, *CompressResult) {
+		t.Helper()
+		output := filepath.Join(t.TempDir(), "project."+format.String())
+		result, err := compressPath(source, compressOptions{Format: format, Output: output, Smart: smart, Stats: true, SkipSpaceCheck: true, Progress: progressbar.DefaultBytesSilent(-1)})
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		return output, result
+	}
+
+	smartPath, result := compress(formatZip, stored)
+	if result.precompressed != nil {
+		t.Errorf("a zip counted %d precompressed file(s), it stores them", result.precompressed.Files)
+	}
+	if s := result.stats; s.Stored != 3 || s.StoredSize != storedSize || s.Compressed != 3 || s.CompressedSize != compressedSize {
+		t.Errorf("smart zip stats = %+v, want 3 stored of %d bytes and 3 compressed of %d", *s, storedSize, compressedSize)
+	}
+	if got := result.stats.String(); !strings.HasPrefix(got, "Stored as they are: 3 file(s), ") || !strings.Contains(got, "; compressed: 3 file(s), ") {
+		t.Errorf("stats line = %q", got)
+	}
+	plainPath, plainResult := compress(formatZip, nil)
+	if s := plainResult.stats; s.Stored != 0 || s.Compressed != 6 {
+		t.Errorf("zip stats without --smart = %+v, want every file compressed", *s)
+	}
+
+	smart, plain := zipMembers(t, smartPath), zipMembers(t, plainPath)
+	for name := range files {
+		want := zip.Deflate
+		if stored.stores(name) {
+			want = zip.Store
+		}
+		s, p := smart["project/"+name], plain["project/"+name]
+		if s == nil || s.Method != want || p == nil || p.Method != zip.Deflate {
+			t.Errorf("%s: smart member %+v, plain member %+v, want method %d with --smart", name, s, p, want)
+		}
+	}
+	if link := smart["project/app.log"]; link == nil || link.Method != zip.Deflate || link.UncompressedSize64 != uint64(len(files["logs/app.log"])) {
+		t.Errorf("hard link member = %+v, want the content of logs/app.log", link)
+	}
+
+	dest := filepath.Join(t.TempDir(), "out")
+	conflicts, perms := testExtractPolicies(dest)
+	if _, err := extractAll(smartPath, dest, conflicts, perms, nil); err != nil {
+		t.Fatal(err)
+	}
+	files["app.log"] = files["logs/app.log"]
+	for name, body := range files {
+		if data, err := os.ReadFile(filepath.Join(dest, "project", filepath.FromSlash(name))); err != nil || string(data) != body {
+			t.Errorf("extracted %s differs (%d bytes, %v), want %d bytes", name, len(data), err, len(body))
+		}
+	}
+
+	// A tar stores every file, a tar.gz compresses every file, the hard link is a link entry in both
+	for format, want := range map[archiveFormat][2]int{formatTar: {5, 0}, formatTarGz: {0, 5}} {
+		if _, result := compress(format, stored); result.stats.Stored != want[0] || result.stats.Compressed != want[1] {
+			t.Errorf("%s stats = %+v, want %d stored and %d compressed", format, *result.stats, want[0], want[1])
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package files
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/output"
+	"gsn-dev-tools/internals/pathfmt"
+	"gsn-dev-tools/internals/redact"
+	"gsn-dev-tools/internals/remote"
+	"gsn-dev-tools/internals/style"
+	"gsn-dev-tools/internals/units"
+
+	"github.com/spf13/cobra"
+)
+
+// changeColumns declares the columns available to `snap diff`
+var changeColumns = []output.Column[entryChange]{
+	{Name: "change", Value: func(c entryChange) any { return changeNames[c.Kind] }},
+	{Name: "path", Value: func(c entryChange) any { return c.Path }},
+	{Name: "reason", Value: func(c entryChange) any { return c.Reason }},
+}
+
+// changeNames spells out the kinds of entryChange for tables and scripts
+var changeNames = map[string]string{"+": "added", "-": "removed", "~": "modified"}
+
+func SnapCmd() *cobra.Command {
+	snapCmd := &cobra.Command{
+		Use:   "snap",
+		Short: "Snapshots a directory and reports what changed since",
+		Long: `Records the path, size, mode, mtime and optionally SHA-256 of every entry of a directory, then compares the
+snapshot with the directory or another snapshot later. Snapshots use the archive manifest format, so the
+manifest written by gsn cmp --manifest can be compared with a directory as well.`,
+		Example: `  gsn snap create ./project -o before.json --hash
+  gsn snap diff before.json ./project`,
+	}
+
+	snapCmd.AddCommand(createSnapCmd())
+	snapCmd.AddCommand(diffSnapCmd())
+	return snapCmd
+}
+
+func createSnapCmd() *cobra.Command {
+	createCmd := &cobra.Command{
+		Use:   "create <directory>",
+		Short: "Writes a snapshot of a directory",
+		Long: `Walks a directory in parallel and writes its entries as JSON to the file given with -o, or to stdout.
+With --remote the directory is one of the remote host: it is listed, and with --hash read, over SFTP, and the
+snapshot can be compared with a local copy by gsn snap diff.`,
+		Example: `  gsn snap create ./project -o before.json
+  gsn snap create ./project --hash > before.json
+  gsn --remote deploy@web1 snap create /srv/app --hash -o web1.json`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			root := args[0]
+			outPath, _ := cmd.Flags().GetString("output")
+			withHashes, _ := cmd.Flags().GetBool("hash")
+			startTime := time.Now()
+
+			snapshot := snapshotPath
+			if remote.Enabled() {
+				snapshot = snapshotRemote
+			}
+			m, err := snapshot(root, withHashes)
+			if err != nil {
+				clierr.Fatalf("Error reading '%s': %v", root, err)
+			}
+			data, err := json.MarshalIndent(m, "", "  ")
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			data = append(data, '\n')
+
+			if outPath == "" {
+				redact.JSONOutput()
+				os.Stdout.Write(data)
+				return
+			}
+			if err := output.WriteFileAtomic(outPath, data, 0o644); err != nil {
+				clierr.Fatalf("Error writing '%s': %v", outPath, err)
+			}
+			fmt.Printf(style.Success()+"Snapshot of %s entries written to %s (Time: %s)\n", units.FormatInt(int64(len(m.Entries))), outPath, units.FormatDuration(time.Since(startTime)))
+		},
+	}
+
+	createCmd.Flags().StringP("output", "o", "", "Write the snapshot to this file instead of stdout")
+	createCmd.Flags().Bool("hash", false, "Record the SHA-256 of every file so diff can compare contents")
+	remote.Adopt(createCmd)
+	return createCmd
+}
+
+func diffSnapCmd() *cobra.Command {
+	diffCmd := &cobra.Command{
+		Use:   "diff <snapshot> <directory|snapshot>",
+		Short: "Compares a snapshot with a directory or another snapshot",
+		Long: `Reports entries added, removed or modified since the snapshot was taken. Paths are compared relative to the
+snapshotted directory, so a snapshot can be compared with a copy under another name. Files are compared by
+SHA-256 when both sides have hashes, by size and mtime otherwise. Exits with 1 when differences are found.
+
+Paths are shown relative to the directory, or to the directory the second snapshot was taken of, unless
+--relative-to names another base: ~ abbreviates the home directory instead and / keeps them absolute. --json
+writes absolute paths unless --relative-json is given. Archive manifests record no directory, their paths are
+shown as they are stored.`,
+		Example: `  gsn snap diff before.json ./project
+  gsn snap diff before.json after.json --csv
+  gsn snap diff before.json ./project --relative-to '~'
+  gsn snap diff before.json ./project --json
+  gsn snap diff project.tar.gz.manifest.json ./project --hash`,
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			snapPath, target := args[0], args[1]
+			withHashes, _ := cmd.Flags().GetBool("hash")
+			asJSON, _ := cmd.Flags().GetBool("json")
+			opts, err := output.OptionsFromFlags(cmd)
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			if asJSON && opts.Format != output.FormatTable {
+				clierr.Exitf(clierr.Usage, "--json cannot be combined with --csv or --tsv")
+			}
+
+			before, err := loadSnapshot(snapPath)
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			var after *Manifest
+			root := target
+			if isSnapshotFile(target) {
+				after, err = loadSnapshot(target)
+				if after != nil {
+					root = after.Source
+				}
+			} else {
+				// Hash the live side when the snapshot has hashes to compare with, or when asked to
+				after, err = snapshotPath(target, withHashes || hasHashes(before))
+			}
+			if err != nil {
+				clierr.Fatalf("Error reading '%s': %v", target, err)
+			}
+
+			changes := diffEntries(relativeEntries(before.Entries), relativeEntries(after.Entries), true)
+			display, err := pathfmt.FromFlags(cmd, root)
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			if asJSON {
+				writeChangesJSON(changes, root, display)
+				if len(changes) > 0 {
+					clierr.Exit(clierr.Failure)
+				}
+				return
+			}
+			for i := range changes {
+				changes[i].Path = changePath(changes[i].Path, root, display.Path)
+			}
+			if len(changes) == 0 && opts.Format == output.FormatTable {
+				fmt.Println(style.Success() + "No differences")
+				return
+			}
+			if err := output.Render(os.Stdout, changeColumns, changes, opts); err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			if len(changes) > 0 {
+				if opts.Format == output.FormatTable {
+					fmt.Printf("\n%d difference(s)\n", len(changes))
+				}
+				clierr.Exit(clierr.Failure)
+			}
+		},
+	}
+
+	diffCmd.Flags().Bool("hash", false, "Hash the files of a live directory even when the snapshot has no hashes")
+	diffCmd.Flags().Bool("json", false, "Print the differences as JSON")
+	output.AddFlags(diffCmd)
+	pathfmt.AddFlags(diffCmd)
+	dryrun.ReadOnly(diffCmd)
+	return diffCmd
+}
+
+// changePath joins the path of a changed entry to root and passes it through show. Without a root, as for
+// archive manifests, it stays as it is.
+func changePath(rel string, root string, show func(string) string) string {
+	if root == "" {
+		return rel
+	}
+	return show(filepath.Join(root, filepath.FromSlash(rel)))
+}
+
+// writeChangesJSON prints the differences of snap diff as JSON
+func writeChangesJSON(changes []entryChange, root string, display pathfmt.Display) {
+	type changeJSON struct {
+		Change string `json:"change"`
+		Path   string `json:"path"`
+		Reason string `json:"reason,omitempty"`
+	}
+	result := make([]changeJSON, 0, len(changes))
+	for _, c := range changes {
+		result = append(result, changeJSON{Change: changeNames[c.Kind], Path: changePath(c.Path, root, display.JSONPath), Reason: c.Reason})
+	}
+	data, err := json.MarshalIndent(struct {
+		Changes []changeJSON `json:"changes"`
+	}{result}, "", "  ")
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	redact.JSONOutput()
+	fmt.Println(string(data))
+}
+
+// snapshotPath describes a directory with the entry names an archive of it would have
+func snapshotPath(root string, withHashes bool) (*Manifest, error) {
+	entries, err := liveEntries(root, withHashes)
+	if err != nil {
+		return nil, err
+	}
+
+	source := root
+	if abs, err := filepath.Abs(root); err == nil {
+		source = abs
+	}
+	return &Manifest{
+		SchemaVersion: manifestSchemaVersion,
+		Source:        source,
+		CreatedAt:     time.Now().UTC(),
+		Entries:       entries,
+	}, nil
+}
+
+// loadSnapshot reads a snapshot or an archive manifest
+func loadSnapshot(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid snapshot '%s': %w", path, err)
+	}
+	if m.SchemaVersion == 0 {
+		return nil, fmt.Errorf("'%s' is not a snapshot or manifest written by gsn", path)
+	}
+	if m.SchemaVersion > manifestSchemaVersion {
+		return nil, fmt.Errorf("snapshot schema version %d is newer than supported version %d", m.SchemaVersion, manifestSchemaVersion)
+	}
+	return &m, nil
+}
+
+// isSnapshotFile reports whether the diff target is a JSON file rather than a live path
+func isSnapshotFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular() && strings.HasSuffix(path, ".json")
+}
+
+func hasHashes(m *Manifest) bool {
+	for _, e := range m.Entries {
+		if e.SHA256 != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// relativeEntries strips the root name every entry starts with, so snapshots of differently named copies
+// of a directory compare by their contents. The root itself becomes ".".
+func relativeEntries(entries []ManifestEntry) []ManifestEntry {
+	result := make([]ManifestEntry, 0, len(entries))
+	for _, e := range entries {
+		if _, rest, ok := strings.Cut(e.Path, "/"); ok {
+			e.Path = rest
+		} else {
+			e.Path = "."
+		}
+		result = append(result, e)
+	}
+	return result
+}
@@ -0,0 +1,184 @@
+package files
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// snapFixture is the tree every snap test mutates
+var snapFixture = map[string]string{
+	"README.md":           "# project\n",
+	"src/main.go":         "package main\n",
+	"src/util/strings.go": "package util\n",
+	"assets/logo.svg":     "<svg/>",
+	"empty/":              "",
+}
+
+// describeChanges renders changes as "kind path reason"
+func describeChanges(changes []entryChange) []string {
+	var lines []string
+	for _, c := range changes {
+		lines = append(lines, strings.TrimSpace(c.Kind+" "+c.Path+" "+c.Reason))
+	}
+	return lines
+}
+
+// snapDiff snapshots root, lets mutate change it and returns the differences snap diff reports
+func snapDiff(t *testing.T, root string, withHashes bool, mutate func(root string)) []string {
+	t.Helper()
+	before, err := snapshotPath(root, withHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mutate(root)
+	after, err := snapshotPath(root, withHashes || hasHashes(before))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return describeChanges(diffEntries(relativeEntries(before.Entries), relativeEntries(after.Entries), true))
+}
+
+func TestSnapDiffDetectsMutations(t *testing.T) {
+	later := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	must := func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// keepMtime writes data to a file without changing its size or mtime, as a careful tool would
+	keepMtime := func(path string, data string) {
+		info, err := os.Stat(path)
+		must(err)
+		must(os.WriteFile(path, []byte(data), 0o644))
+		must(os.Chtimes(path, info.ModTime(), info.ModTime()))
+	}
+
+	tests := []struct {
+		name       string
+		mutate     func(root string)
+		plain      []string
+		withHashes []string
+	}{
+		{"unchanged", func(root string) {}, nil, nil},
+		{"file added", func(root string) {
+			must(os.WriteFile(filepath.Join(root, "src/new.go"), []byte("package main\n"), 0o644))
+		}, []string{"+ src/new.go"}, []string{"+ src/new.go"}},
+		{"file removed", func(root string) {
+			must(os.Remove(filepath.Join(root, "assets/logo.svg")))
+		}, []string{"- assets/logo.svg"}, []string{"- assets/logo.svg"}},
+		{"directory removed", func(root string) {
+			must(os.RemoveAll(filepath.Join(root, "src/util")))
+		}, []string{"- src/util", "- src/util/strings.go"}, []string{"- src/util", "- src/util/strings.go"}},
+		{"file grown", func(root string) {
+			must(os.WriteFile(filepath.Join(root, "README.md"), []byte("# project\n\nMore.\n"), 0o644))
+		}, []string{"~ README.md size"}, []string{"~ README.md size"}},
+		{"same size, new mtime", func(root string) {
+			path := filepath.Join(root, "src/main.go")
+			must(os.WriteFile(path, []byte("package mian\n"), 0o644))
+			must(os.Chtimes(path, later, later))
+		}, []string{"~ src/main.go mtime"}, []string{"~ src/main.go content"}},
+		// Only hashes see a rewrite that keeps size and mtime
+		{"same size, same mtime", func(root string) {
+			keepMtime(filepath.Join(root, "src/main.go"), "package mian\n")
+		}, nil, []string{"~ src/main.go content"}},
+		// Only the mtime changed, hashes show the content is the same
+		{"touched", func(root string) {
+			must(os.Chtimes(filepath.Join(root, "README.md"), later, later))
+		}, []string{"~ README.md mtime"}, nil},
+		{"mode changed", func(root string) {
+			must(os.Chmod(filepath.Join(root, "README.md"), 0o600))
+		}, []string{"~ README.md mode"}, []string{"~ README.md mode"}},
+		{"file replaced by a directory", func(root string) {
+			path := filepath.Join(root, "assets/logo.svg")
+			must(os.Remove(path))
+			must(os.Mkdir(path, 0o755))
+		}, []string{"~ assets/logo.svg type"}, []string{"~ assets/logo.svg type"}},
+		{"directory filled", func(root string) {
+			must(os.WriteFile(filepath.Join(root, "empty/.keep"), nil, 0o644))
+		}, []string{"+ empty/.keep"}, []string{"+ empty/.keep"}},
+		{"hard link added", func(root string) {
+			must(os.Link(filepath.Join(root, "README.md"), filepath.Join(root, "README.txt")))
+		}, []string{"+ README.txt"}, []string{"+ README.txt"}},
+		{"renamed", func(root string) {
+			must(os.Rename(filepath.Join(root, "src/main.go"), filepath.Join(root, "src/cmd.go")))
+		}, []string{"+ src/cmd.go", "- src/main.go"}, []string{"+ src/cmd.go", "- src/main.go"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, withHashes := range []bool{false, true} {
+				root := filepath.Join(t.TempDir(), "project")
+				writeTree(t, root, snapFixture)
+				// Backdated, so every rewrite changes the mtime
+				past := time.Now().Add(-time.Hour)
+				filepath.Walk(root, func(path string, _ os.FileInfo, _ error) error { return os.Chtimes(path, past, past) })
+
+				want := test.plain
+				if withHashes {
+					want = test.withHashes
+				}
+				if got := snapDiff(t, root, withHashes, test.mutate); !slices.Equal(got, want) {
+					t.Errorf("hashes %v: changes = %q, want %q", withHashes, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSnapDiffComparesCopiesByContent(t *testing.T) {
+	dir := t.TempDir()
+	original, copied := filepath.Join(dir, "project"), filepath.Join(dir, "project-copy")
+	writeTree(t, original, snapFixture)
+	writeTree(t, copied, snapFixture)
+	must := func(err error) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Copies under another name and with other mtimes match by hash
+	before, err := snapshotPath(original, true)
+	must(err)
+	later := time.Now().Add(time.Hour)
+	must(os.Chtimes(filepath.Join(copied, "README.md"), later, later))
+	after, err := snapshotPath(copied, true)
+	must(err)
+	if changes := diffEntries(relativeEntries(before.Entries), relativeEntries(after.Entries), true); len(changes) != 0 {
+		t.Errorf("copy differs: %q", describeChanges(changes))
+	}
+
+	// A snapshot survives being written and read back, and records where it was taken
+	path := filepath.Join(dir, "before.json")
+	data, err := json.Marshal(before)
+	must(err)
+	must(os.WriteFile(path, data, 0o644))
+	loaded, err := loadSnapshot(path)
+	must(err)
+	if loaded.Source != original || len(loaded.Entries) != len(before.Entries) || !isSnapshotFile(path) || isSnapshotFile(original) {
+		t.Errorf("loaded %+v from %s", loaded, path)
+	}
+	if changes := diffEntries(relativeEntries(loaded.Entries), relativeEntries(before.Entries), true); len(changes) != 0 {
+		t.Errorf("the snapshot changed through JSON: %q", describeChanges(changes))
+	}
+}
+
+func TestLoadSnapshotRejects(t *testing.T) {
+	tests := map[string]string{
+		`{"entries":[]}`:                    "is not a snapshot or manifest written by gsn",
+		`{"schema_version":2,"entries":[]}`: "snapshot schema version 2 is newer than supported version 1",
+		`[`:                                 "invalid snapshot",
+	}
+	for data, want := range tests {
+		path := filepath.Join(t.TempDir(), "snap.json")
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadSnapshot(path); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("loadSnapshot(%s) = %v, want %q", data, err, want)
+		}
+	}
+}
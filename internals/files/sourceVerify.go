@@ -0,0 +1,137 @@
+package files
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"time"
+
+	"gsn-dev-tools/internals/progress"
+	"gsn-dev-tools/internals/style"
+)
+
+// archivedSource is where an archived file or symlink was read from, and the mtime the walk saw it with
+type archivedSource struct {
+	Path    string
+	ModTime time.Time
+}
+
+// sourceReport sorts the archived files and symlinks checked against the live tree after archiving
+type sourceReport struct {
+	Verified int
+	// ChangedAfter were changed or removed on disk since the walk reached them, they are expected to differ
+	ChangedAfter []string
+	// Mismatched differ from the file they were read from although it did not change: the archive is corrupt
+	Mismatched []string
+}
+
+// verifyAgainstSource streams the archive back and compares the content of every archived file, and the
+// target of every symlink, with the live path it was read from. An entry that differs is changed after
+// archiving when the live path is gone or its mtime is no longer the one the walk saw, and mismatched
+// otherwise.
+func verifyAgainstSource(archivePath string, sources map[string]archivedSource, total int64) (*sourceReport, error) {
+	r, err := openArchive(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	// Formats without a container name their single entry after the archive, which --output may change
+	_, single := r.(*singleEntryReader)
+
+	bar := progress.NewBytes(total, "Verifying against the source")
+	report := &sourceReport{}
+	seen := make(map[string]bool, len(sources))
+	for {
+		header, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+		src, ok := sources[header.Name]
+		if single && len(sources) == 1 {
+			for name, only := range sources {
+				header.Name, src, ok = name, only, true
+			}
+		}
+		if !ok {
+			continue
+		}
+		seen[header.Name] = true
+
+		same, err := sameAsSource(header, r, src.Path, bar)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case same:
+			report.Verified++
+		case changedSince(src):
+			report.ChangedAfter = append(report.ChangedAfter, header.Name)
+		default:
+			report.Mismatched = append(report.Mismatched, header.Name)
+		}
+	}
+	bar.Finish()
+
+	for name := range sources {
+		if !seen[name] {
+			report.Mismatched = append(report.Mismatched, name+" (missing from the archive)")
+		}
+	}
+	sort.Strings(report.ChangedAfter)
+	sort.Strings(report.Mismatched)
+	return report, nil
+}
+
+// sameAsSource compares the entry being read with the live path, a removed path is never the same
+func sameAsSource(header *tar.Header, content io.Reader, path string, bar io.Writer) (bool, error) {
+	if header.Typeflag == tar.TypeSymlink {
+		target, err := os.Readlink(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return err == nil && target == header.Linkname, nil
+	}
+
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(hasher, bar), content); err != nil {
+		return false, fmt.Errorf("failed to read %s from the archive: %w", header.Name, err)
+	}
+	digest, err := hashFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return digest == hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// changedSince tells whether the live path was changed or removed after the walk stat'ed it
+func changedSince(src archivedSource) bool {
+	info, err := os.Lstat(src.Path)
+	if err != nil {
+		return true
+	}
+	return !info.ModTime().Equal(src.ModTime)
+}
+
+// print lists the changed and mismatched entries and sums the three buckets up
+func (r *sourceReport) print() {
+	for _, name := range r.ChangedAfter {
+		fmt.Println(style.Warning() + "Changed after archiving: " + name)
+	}
+	for _, name := range r.Mismatched {
+		fmt.Println(style.Failure() + "Mismatched: " + name)
+	}
+	fmt.Printf("Verified against the source: %d verified, %d changed after archiving, %d mismatched\n",
+		r.Verified, len(r.ChangedAfter), len(r.Mismatched))
+}
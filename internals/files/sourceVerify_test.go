@@ -0,0 +1,216 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+// archiveVerifying archives source with the walk-time sources kept, as --verify-against-source does
+func archiveVerifying(t *testing.T, source string, format archiveFormat) *CompressResult {
+	t.Helper()
+	opts := compressOptions{Format: format, Output: filepath.Join(t.TempDir(), "archive."+format.String()), VerifySource: true,
+		SkipSpaceCheck: true, Progress: progressbar.DefaultBytesSilent(-1)}
+	result, err := compressPath(source, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+// rewrite replaces the content of path, then gives it back the mtime it had when keepMtime is set
+func rewrite(t *testing.T, path string, content string, keepMtime bool) {
+	t.Helper()
+	info, err := os.Lstat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if keepMtime {
+		if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		later := info.ModTime().Add(time.Second)
+		if err := os.Chtimes(path, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestVerifyAgainstSourceBuckets(t *testing.T) {
+	tests := []struct {
+		name string
+		// change runs on the source once the archive is written
+		change                 func(t *testing.T, source string)
+		verified               int
+		changedAfter, mismatch []string
+	}{
+		{"untouched", func(t *testing.T, source string) {}, 4, nil, nil},
+		// Only the content counts, a touched file is still verified
+		{"touched", func(t *testing.T, source string) {
+			later := time.Now().Add(time.Hour)
+			os.Chtimes(filepath.Join(source, "a.txt"), later, later)
+		}, 4, nil, nil},
+		{"edited", func(t *testing.T, source string) {
+			rewrite(t, filepath.Join(source, "a.txt"), "edited since\n", false)
+		}, 3, []string{"project/a.txt"}, nil},
+		{"removed", func(t *testing.T, source string) {
+			os.Remove(filepath.Join(source, "sub", "b.txt"))
+		}, 3, []string{"project/sub/b.txt"}, nil},
+		{"symlink removed", func(t *testing.T, source string) {
+			os.Remove(filepath.Join(source, "link"))
+		}, 3, []string{"project/link"}, nil},
+		// Rewritten with its mtime restored, the difference cannot come from a later change
+		{"rewritten in place", func(t *testing.T, source string) {
+			rewrite(t, filepath.Join(source, "sub", "b.txt"), "bravo!\n", true)
+		}, 3, nil, []string{"project/sub/b.txt"}},
+		{"one of each", func(t *testing.T, source string) {
+			rewrite(t, filepath.Join(source, "a.txt"), "alpha, edited\n", false)
+			rewrite(t, filepath.Join(source, "c.bin"), "charlie\x01", true)
+		}, 2, []string{"project/a.txt"}, []string{"project/c.bin"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := filepath.Join(t.TempDir(), "project")
+			writeTree(t, source, map[string]string{"a.txt": "alpha\n", "sub/b.txt": "bravo\n", "c.bin": "charlie\x00", "empty/": ""})
+			if err := os.Symlink("a.txt", filepath.Join(source, "link")); err != nil {
+				t.Fatal(err)
+			}
+			result := archiveVerifying(t, source, formatTarGz)
+
+			// Files and symlinks are checked, directories are not
+			var names []string
+			for name := range result.sources {
+				names = append(names, name)
+			}
+			slices.Sort(names)
+			if want := []string{"project/a.txt", "project/c.bin", "project/link", "project/sub/b.txt"}; !slices.Equal(names, want) {
+				t.Fatalf("sources = %q, want %q", names, want)
+			}
+
+			test.change(t, source)
+			report, err := verifyAgainstSource(result.ArchivePath, result.sources, result.SourceSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Verified != test.verified || !slices.Equal(report.ChangedAfter, test.changedAfter) || !slices.Equal(report.Mismatched, test.mismatch) {
+				t.Errorf("report = %+v\nwant %d verified, changed after %q, mismatched %q", *report, test.verified, test.changedAfter, test.mismatch)
+			}
+		})
+	}
+}
+
+func TestVerifyAgainstSourceSymlinkTarget(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "project")
+	writeTree(t, source, map[string]string{"a.txt": "alpha\n", "b.txt": "bravo\n"})
+	link := filepath.Join(source, "link")
+	if err := os.Symlink("a.txt", link); err != nil {
+		t.Fatal(err)
+	}
+	result := archiveVerifying(t, source, formatTar)
+
+	// A symlink pointed elsewhere is compared by its target, and was recreated after the walk
+	os.Remove(link)
+	if err := os.Symlink("b.txt", link); err != nil {
+		t.Fatal(err)
+	}
+	src := result.sources["project/link"]
+	src.ModTime = src.ModTime.Add(-time.Second)
+	result.sources["project/link"] = src
+	report, err := verifyAgainstSource(result.ArchivePath, result.sources, result.SourceSize)
+	if err != nil || report.Verified != 2 || !slices.Equal(report.ChangedAfter, []string{"project/link"}) {
+		t.Errorf("report = %+v, %v", report, err)
+	}
+
+	// Had the walk seen the mtime the link has now, the other target is a mismatch
+	info, _ := os.Lstat(link)
+	result.sources["project/link"] = archivedSource{Path: link, ModTime: info.ModTime()}
+	report, err = verifyAgainstSource(result.ArchivePath, result.sources, result.SourceSize)
+	if err != nil || !slices.Equal(report.Mismatched, []string{"project/link"}) {
+		t.Errorf("report with the mtime unchanged = %+v, %v", report, err)
+	}
+}
+
+func TestVerifyAgainstSourceEntryMissingFromTheArchive(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "project")
+	writeTree(t, source, map[string]string{"a.txt": "alpha\n"})
+	result := archiveVerifying(t, source, formatTarZst)
+
+	result.sources["project/ghost.txt"] = archivedSource{Path: filepath.Join(source, "a.txt")}
+	report, err := verifyAgainstSource(result.ArchivePath, result.sources, result.SourceSize)
+	if err != nil || report.Verified != 1 || !slices.Equal(report.Mismatched, []string{"project/ghost.txt (missing from the archive)"}) {
+		t.Errorf("report = %+v, %v", report, err)
+	}
+}
+
+func TestVerifyAgainstSourceSingleFileFormats(t *testing.T) {
+	for _, format := range []archiveFormat{formatGz, formatZst} {
+		t.Run(format.String(), func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "dump.sql")
+			writeTree(t, dir, map[string]string{"dump.sql": strings.Repeat("INSERT INTO t VALUES (1);\n", 100)})
+			// --output names the archive, the single entry is still matched with the file
+			result := archiveVerifying(t, path, format)
+
+			report, err := verifyAgainstSource(result.ArchivePath, result.sources, result.SourceSize)
+			if err != nil || report.Verified != 1 || len(report.ChangedAfter)+len(report.Mismatched) != 0 {
+				t.Fatalf("report = %+v, %v", report, err)
+			}
+			rewrite(t, path, strings.Repeat("INSERT INTO t VALUES (2);\n", 100), true)
+			report, err = verifyAgainstSource(result.ArchivePath, result.sources, result.SourceSize)
+			if err != nil || !slices.Equal(report.Mismatched, []string{"dump.sql"}) {
+				t.Errorf("report after a rewrite in place = %+v, %v", report, err)
+			}
+		})
+	}
+}
+
+func TestChangedSince(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+	writeTree(t, dir, map[string]string{"file": "x"})
+	walked := time.Date(2024, 6, 1, 12, 0, 0, 123456789, time.UTC)
+	if err := os.Chtimes(path, walked, walked); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		src     archivedSource
+		changed bool
+	}{
+		{"same mtime", archivedSource{Path: path, ModTime: walked}, false},
+		{"same mtime in another zone", archivedSource{Path: path, ModTime: walked.In(time.FixedZone("", 3600))}, false},
+		{"a nanosecond apart", archivedSource{Path: path, ModTime: walked.Add(time.Nanosecond)}, true},
+		{"earlier mtime", archivedSource{Path: path, ModTime: walked.Add(-time.Hour)}, true},
+		{"removed", archivedSource{Path: filepath.Join(dir, "gone"), ModTime: walked}, true},
+	}
+	for _, test := range tests {
+		if changed := changedSince(test.src); changed != test.changed {
+			t.Errorf("%s: changedSince = %v, want %v", test.name, changed, test.changed)
+		}
+	}
+}
+
+func TestSourceReportPrint(t *testing.T) {
+	report := &sourceReport{Verified: 7, ChangedAfter: []string{"project/a.txt"}, Mismatched: []string{"project/b.txt", "project/c.txt (missing from the archive)"}}
+	out := captureStdout(t, report.print)
+	for _, want := range []string{
+		"Changed after archiving: project/a.txt\n",
+		"Mismatched: project/b.txt\n",
+		"Mismatched: project/c.txt (missing from the archive)\n",
+		"Verified against the source: 7 verified, 1 changed after archiving, 2 mismatched\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("report prints\n%s\nwithout %q", out, want)
+		}
+	}
+}
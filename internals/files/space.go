@@ -0,0 +1,100 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/units"
+)
+
+const (
+	// tarBlock is the size of a tar header and the unit tar pads contents to
+	tarBlock = 512
+
+	// fsBlock is the allocation unit assumed for every extracted file, the common 4 KiB
+	fsBlock = 4096
+
+	// codecOverhead is the worst growth of a stream the codecs cannot compress, with a wide margin: gzip and
+	// zstd store such blocks raw with a few bytes of framing each
+	codecOverhead = 1.01
+)
+
+// spaceAvailable reports the free space of the filesystem holding a directory. It is a variable so low space
+// can be simulated without filling a disk.
+var spaceAvailable = availableSpace
+
+// checkFreeSpace refuses to start writing required bytes below dir when its filesystem has less available.
+// Systems where the free space cannot be read are not checked.
+func checkFreeSpace(dir string, required int64, what string) error {
+	dir, err := existingAncestor(dir)
+	if err != nil {
+		return err
+	}
+	available, err := spaceAvailable(dir)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot read the free space of '%s': %w (--no-space-check skips the check)", dir, err)
+	}
+	if required > available {
+		return clierr.Newf(clierr.Conflict, "not enough space in '%s' for %s: %s required, %s available (--no-space-check skips the check)",
+			dir, what, units.FormatBytes(required), units.FormatBytes(available))
+	}
+	return nil
+}
+
+// existingAncestor returns dir or the closest directory above it that exists, destinations are created on the
+// filesystem of that one
+func existingAncestor(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		if _, err := os.Stat(dir); err == nil || filepath.Dir(dir) == dir {
+			return dir, nil
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// estimateArchiveSize is the space cmp reserves for an archive. It assumes the content does not compress at
+// all: every file gets a header and padding, the archive its end blocks, and the codec its framing.
+func estimateArchiveSize(stats sourceStats, format archiveFormat) int64 {
+	size := stats.Size
+	if format.Container != containerNone {
+		size += int64(stats.Files+1) * 2 * tarBlock
+	}
+	if format.Codec != codecNone {
+		size = int64(float64(size) * codecOverhead)
+	}
+	return size
+}
+
+// extractionSize sums the contents extract writes for the entries matching pattern, every entry when it is
+// empty, each rounded up to whole filesystem blocks. The sidecar manifest provides the sizes when there is
+// one, the archive headers otherwise.
+func extractionSize(archivePath string, pattern string) (int64, error) {
+	m, err := archiveManifest(archivePath, false)
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	for _, e := range m.Entries {
+		if e.Type != "file" {
+			continue
+		}
+		if pattern != "" && !matchEntry(pattern, strings.TrimSuffix(path.Clean(e.Path), "/")) {
+			continue
+		}
+		size += (e.Size + fsBlock - 1) / fsBlock * fsBlock
+	}
+	return size, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !dragonfly && !windows
+
+package files
+
+import "errors"
+
+// availableSpace cannot tell the free space here, the space check is skipped
+func availableSpace(dir string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
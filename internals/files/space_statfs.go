@@ -0,0 +1,14 @@
+//go:build linux || darwin || freebsd || dragonfly
+
+package files
+
+import "golang.org/x/sys/unix"
+
+// availableSpace returns the bytes an unprivileged user can still write to the filesystem holding dir
+func availableSpace(dir string) (int64, error) {
+	var fs unix.Statfs_t
+	if err := unix.Statfs(dir, &fs); err != nil {
+		return 0, err
+	}
+	return int64(fs.Bavail) * int64(fs.Bsize), nil
+}
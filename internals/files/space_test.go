@@ -0,0 +1,172 @@
+package files
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/schollz/progressbar/v3"
+
+	"gsn-dev-tools/internals/clierr"
+)
+
+// useSpace makes the space check see what report returns, recording the directories it is asked about
+func useSpace(t *testing.T, report func(dir string) (int64, error)) *[]string {
+	t.Helper()
+	var asked []string
+	previous := spaceAvailable
+	spaceAvailable = func(dir string) (int64, error) {
+		asked = append(asked, dir)
+		return report(dir)
+	}
+	t.Cleanup(func() { spaceAvailable = previous })
+	return &asked
+}
+
+func fixedSpace(n int64) func(string) (int64, error) {
+	return func(string) (int64, error) { return n, nil }
+}
+
+func TestAvailableSpace(t *testing.T) {
+	available, err := availableSpace(t.TempDir())
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip("the free space cannot be read on this system")
+	}
+	if err != nil || available <= 0 {
+		t.Errorf("availableSpace = %d, %v", available, err)
+	}
+}
+
+func TestCheckFreeSpace(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name     string
+		report   func(string) (int64, error)
+		required int64
+		code     clierr.Code
+		wantErr  string
+	}{
+		{"room to spare", fixedSpace(10 << 20), 1 << 20, clierr.Success, ""},
+		{"exactly enough", fixedSpace(1 << 20), 1 << 20, clierr.Success, ""},
+		{"too little", fixedSpace(512 << 10), 3 << 29, clierr.Conflict, "for the archive: 1.5 GiB required, 512.0 KiB available (--no-space-check skips the check)"},
+		{"full disk", fixedSpace(0), 1, clierr.Conflict, "1 B required, 0 B available"},
+		// Systems without a way to tell are not checked
+		{"unsupported", func(string) (int64, error) { return 0, errors.ErrUnsupported }, 1 << 40, clierr.Success, ""},
+		{"unreadable", func(string) (int64, error) { return 0, syscall.EACCES }, 1, clierr.Failure, "cannot read the free space of"},
+	}
+	for _, test := range tests {
+		useSpace(t, test.report)
+		err := checkFreeSpace(dir, test.required, "the archive")
+		if clierr.CodeOf(err) != test.code || (test.wantErr != "" && !strings.Contains(err.Error(), test.wantErr)) {
+			t.Errorf("%s: checkFreeSpace = %v, want code %d with %q", test.name, err, test.code, test.wantErr)
+		}
+	}
+
+	// A destination that does not exist yet is measured on the directory it will be created in
+	asked := useSpace(t, fixedSpace(1<<30))
+	if err := checkFreeSpace(filepath.Join(dir, "new", "nested"), 1, "the extracted entries"); err != nil {
+		t.Fatal(err)
+	}
+	if len(*asked) != 1 || (*asked)[0] != dir {
+		t.Errorf("asked about %q, want %s", *asked, dir)
+	}
+}
+
+func TestEstimateArchiveSize(t *testing.T) {
+	stats := sourceStats{Files: 3, Size: 100_000}
+	framed := func(n int64) int64 { return int64(float64(n) * codecOverhead) }
+	tests := []struct {
+		format archiveFormat
+		want   int64
+	}{
+		// Every file and the end of the archive get a header and at worst a block of padding
+		{formatTar, 100_000 + 4*2*tarBlock},
+		{formatTarGz, framed(100_000 + 4*2*tarBlock)},
+		{formatTarZst, framed(100_000 + 4*2*tarBlock)},
+		{formatGz, framed(100_000)},
+	}
+	for _, test := range tests {
+		if got := estimateArchiveSize(stats, test.format); got != test.want {
+			t.Errorf("estimateArchiveSize(%s) = %d, want %d", test.format.Extension(), got, test.want)
+		}
+	}
+}
+
+func TestExtractionSize(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "project.tar")
+	writeFixtureTar(t, archive, []fixtureEntry{
+		{Name: "project/", Type: '5'},
+		{Name: "project/small.txt", Body: "x"},
+		{Name: "project/docs/", Type: '5'},
+		{Name: "project/docs/guide.md", Body: strings.Repeat("g", fsBlock+1)},
+		{Name: "project/link", Type: '2', Link: "small.txt"},
+	})
+	tests := map[string]int64{
+		"":                  3 * fsBlock,
+		"project/small.txt": fsBlock,
+		"project/*":         fsBlock,
+		"*/*/*.md":          2 * fsBlock,
+		// --file matches entries, a directory does not bring its files
+		"project/docs":          0,
+		"project/docs/guide.md": 2 * fsBlock,
+		"project/missing":       0,
+	}
+	for pattern, want := range tests {
+		if got, err := extractionSize(archive, pattern); err != nil || got != want {
+			t.Errorf("extractionSize(%q) = %d, %v, want %d", pattern, got, err, want)
+		}
+	}
+
+	// The sidecar manifest gives the same sizes as the headers
+	source := filepath.Join(dir, "src")
+	writeTree(t, source, map[string]string{"a.txt": "a", "b/c.txt": strings.Repeat("c", 3*fsBlock)})
+	output := filepath.Join(dir, "src.tar.zst")
+	useSpace(t, fixedSpace(1<<30))
+	opts := compressOptions{Format: formatTarZst, Output: output, Manifest: true, Progress: progressbar.DefaultBytesSilent(-1)}
+	if _, err := compressPath(source, opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(output + manifestSuffix); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := extractionSize(output, ""); err != nil || got != 4*fsBlock {
+		t.Errorf("extractionSize with a manifest = %d, %v, want %d", got, err, 4*fsBlock)
+	}
+}
+
+func TestCompressRefusesWithoutSpace(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "project")
+	writeTree(t, source, map[string]string{"data.bin": strings.Repeat("x", 64<<10)})
+	output := filepath.Join(dir, "out", "project.tar.gz")
+	asked := useSpace(t, fixedSpace(32<<10))
+
+	opts := compressOptions{Format: formatTarGz, Output: output, Progress: progressbar.DefaultBytesSilent(-1)}
+	_, err := compressPath(source, opts)
+	if clierr.CodeOf(err) != clierr.Conflict || !strings.Contains(err.Error(), "not enough space in '"+dir+"' for the archive") {
+		t.Fatalf("compress with 32 KiB free = %v", err)
+	}
+	// Nothing was started
+	if _, err := os.Stat(filepath.Dir(output)); !os.IsNotExist(err) {
+		t.Errorf("the refused compression left %s behind: %v", filepath.Dir(output), err)
+	}
+	if len(*asked) != 1 {
+		t.Errorf("the free space was read %d times", len(*asked))
+	}
+
+	// --no-space-check starts anyway
+	opts.SkipSpaceCheck = true
+	if err := os.MkdirAll(filepath.Dir(output), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := compressPath(source, opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(*asked) != 1 {
+		t.Error("--no-space-check still read the free space")
+	}
+}
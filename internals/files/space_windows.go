@@ -0,0 +1,16 @@
+package files
+
+import "golang.org/x/sys/windows"
+
+// availableSpace returns the bytes the current user can still write to the volume holding dir
+func availableSpace(dir string) (int64, error) {
+	path, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+	var available, total, free uint64
+	if err := windows.GetDiskFreeSpaceEx(path, &available, &total, &free); err != nil {
+		return 0, err
+	}
+	return int64(available), nil
+}
@@ -0,0 +1,150 @@
+package files
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// errSparseUnsupported is returned by dataSegments where holes cannot be detected
+var errSparseUnsupported = errors.New("sparse file detection is not supported on this platform")
+
+// sparseRecordPrefix stands in for "GNU.sparse." while archive/tar formats the header, which drops
+// GNU sparse records it did not produce itself. Both prefixes have the same length, so replacing it
+// afterwards keeps every PAX record length valid.
+const sparseRecordPrefix = "GSN.sparse."
+
+// sparseSegment is a run of data inside a sparse file
+type sparseSegment struct {
+	Offset int64
+	Length int64
+}
+
+// fileID identifies a file across its hard links
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// isSparse reports whether the data segments leave any hole in a file of the given size
+func isSparse(segments []sparseSegment, size int64) bool {
+	var data int64
+	for _, s := range segments {
+		data += s.Length
+	}
+	return data < size
+}
+
+// isSparseHeader reports whether an entry was stored as a GNU/PAX sparse file
+func isSparseHeader(header *tar.Header) bool {
+	return header.PAXRecords["GNU.sparse.major"] != "" || header.PAXRecords["GNU.sparse.map"] != ""
+}
+
+// writeSparseEntry writes file as a PAX 1.0 sparse entry, the format GNU tar uses: the entry data is the
+// sparse map followed by the data segments only. archive/tar reads it back with the holes filled with zeros.
+func (a *archiver) writeSparseEntry(file *os.File, header *tar.Header, segments []sparseSegment, hasher hash.Hash) error {
+	if strings.Contains(header.Name, sparseRecordPrefix) {
+		return fmt.Errorf("cannot store '%s' as a sparse entry", header.Name)
+	}
+	if n := len(segments); n == 0 || segments[n-1].Offset+segments[n-1].Length < header.Size {
+		// A trailing hole is marked by an empty segment at the end of the file
+		segments = append(segments, sparseSegment{Offset: header.Size})
+	}
+
+	sparseMap := strconv.AppendInt(nil, int64(len(segments)), 10)
+	sparseMap = append(sparseMap, '\n')
+	var dataSize int64
+	for _, s := range segments {
+		sparseMap = append(strconv.AppendInt(sparseMap, s.Offset, 10), '\n')
+		sparseMap = append(strconv.AppendInt(sparseMap, s.Length, 10), '\n')
+		dataSize += s.Length
+	}
+	sparseMap = append(sparseMap, make([]byte, blockPadding(int64(len(sparseMap))))...)
+
+	realName := header.Name
+	dir, base := path.Split(realName)
+	sparseHeader := *header
+	sparseHeader.Name = path.Join(dir, "GNUSparseFile.0", base)
+	sparseHeader.Size = int64(len(sparseMap)) + dataSize
+	sparseHeader.Format = tar.FormatPAX
+	sparseHeader.PAXRecords = map[string]string{
+		sparseRecordPrefix + "major":    "1",
+		sparseRecordPrefix + "minor":    "0",
+		sparseRecordPrefix + "name":     realName,
+		sparseRecordPrefix + "realsize": strconv.FormatInt(header.Size, 10),
+	}
+
+	var headers bytes.Buffer
+	if err := tar.NewWriter(&headers).WriteHeader(&sparseHeader); err != nil {
+		return err
+	}
+	raw := bytes.ReplaceAll(headers.Bytes(), []byte(" "+sparseRecordPrefix), []byte(" GNU.sparse."))
+
+	// Finish the previous entry, then write this one below the tar writer. A zip refuses --sparse, so the
+	// entries go to a tar writer.
+	if err := a.tw.(*tar.Writer).Flush(); err != nil {
+		return err
+	}
+	if _, err := a.raw.Write(raw); err != nil {
+		return err
+	}
+	if _, err := a.raw.Write(sparseMap); err != nil {
+		return err
+	}
+
+	var offset int64
+	for _, s := range segments {
+		hole := s.Offset - offset
+		a.bar.Add64(hole)
+		if a.manifest != nil {
+			if _, err := io.CopyN(hasher, zeroReader{}, hole); err != nil {
+				return err
+			}
+		}
+
+		if _, err := file.Seek(s.Offset, io.SeekStart); err != nil {
+			return err
+		}
+		var dst io.Writer = a.raw
+		if a.manifest != nil {
+			dst = io.MultiWriter(a.raw, hasher)
+		}
+		barReader := io.TeeReader(a.limiter.Reader(io.LimitReader(file, s.Length)), a.bar)
+		n, err := a.copy(dst, barReader)
+		if err != nil {
+			return err
+		}
+		if n < s.Length {
+			if err := a.padShrunk(dst, realName, s.Length-n); err != nil {
+				return err
+			}
+		}
+		offset = s.Offset + s.Length
+	}
+	if err := a.checkGrown(file, realName, header.Size); err != nil {
+		return err
+	}
+
+	_, err := a.raw.Write(make([]byte, blockPadding(sparseHeader.Size)))
+	return err
+}
+
+// blockPadding is the number of bytes needed to fill the last 512 byte tar block
+func blockPadding(size int64) int64 {
+	return -size & 511
+}
+
+// zeroReader reads an endless stream of zeros
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
@@ -0,0 +1,40 @@
+//go:build linux
+
+package files
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// dataSegments lists the data regions of f using SEEK_DATA and SEEK_HOLE
+func dataSegments(f *os.File, size int64) ([]sparseSegment, error) {
+	var segments []sparseSegment
+	for offset := int64(0); offset < size; {
+		data, err := f.Seek(offset, unix.SEEK_DATA)
+		if errors.Is(err, unix.ENXIO) {
+			// No data past offset, the rest of the file is a hole
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		hole, err := f.Seek(data, unix.SEEK_HOLE)
+		if err != nil {
+			return nil, err
+		}
+		if hole > size {
+			hole = size
+		}
+		segments = append(segments, sparseSegment{Offset: data, Length: hole - data})
+		offset = hole
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return segments, nil
+}
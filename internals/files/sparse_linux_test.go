@@ -0,0 +1,117 @@
+package files
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+// sparseFixtureSize is the apparent size of the sparse fixture, of which only two small regions hold data
+const sparseFixtureSize = 8 << 20
+
+// writeSparseFixture creates disk.img with data at the start and in the middle and a trailing hole, and
+// notes.txt hard linked as sub/notes-link.txt. It skips the test on filesystems that do not keep holes.
+func writeSparseFixture(t *testing.T, dir string) {
+	t.Helper()
+	writeTree(t, dir, map[string]string{"notes.txt": "linked notes\n", "sub/": ""})
+	if err := os.Link(filepath.Join(dir, "notes.txt"), filepath.Join(dir, "sub", "notes-link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Create(filepath.Join(dir, "disk.img"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(bytes.Repeat([]byte("head"), 1024)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt(bytes.Repeat([]byte("middle"), 1024), sparseFixtureSize/2); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(sparseFixtureSize); err != nil {
+		t.Fatal(err)
+	}
+	segments, err := dataSegments(f, sparseFixtureSize)
+	if err != nil || !isSparse(segments, sparseFixtureSize) {
+		t.Skipf("%s keeps no holes (%v)", dir, err)
+	}
+}
+
+// allocated is the space a file takes on disk
+func allocated(t *testing.T, path string) int64 {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Sys().(*syscall.Stat_t).Blocks * 512
+}
+
+func TestSparseAndHardLinkedArchive(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "image")
+	writeSparseFixture(t, source)
+	want, err := os.ReadFile(filepath.Join(source, "disk.img"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archiveOf := func(sparse bool) string {
+		out := filepath.Join(t.TempDir(), "image.tar")
+		opts := compressOptions{Format: formatTar, Output: out, Sparse: sparse, SkipSpaceCheck: true, AllowProtectedDir: true, Progress: progressbar.DefaultBytesSilent(-1)}
+		if _, err := compressPath(source, opts); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	sparse, full := archiveOf(true), archiveOf(false)
+
+	// Uncompressed, the full archive holds every zero, the sparse one only the two data regions
+	sparseInfo, _ := os.Stat(sparse)
+	fullInfo, _ := os.Stat(full)
+	if fullInfo.Size() < sparseFixtureSize || sparseInfo.Size() > 64<<10 {
+		t.Errorf("archive sizes: sparse %d, full %d bytes for a %d byte file", sparseInfo.Size(), fullInfo.Size(), sparseFixtureSize)
+	}
+
+	var links []string
+	for _, e := range readFixtureTar(t, sparse) {
+		if e.Type == tar.TypeLink {
+			links = append(links, e.Name+" -> "+e.Link)
+		}
+		if e.Name == "image/disk.img" && e.Body != string(want) {
+			t.Error("archive/tar reads disk.img back with other content")
+		}
+	}
+	// The walk reaches notes.txt first, its second name points back at it
+	if strings.Join(links, ",") != "image/sub/notes-link.txt -> image/notes.txt" {
+		t.Errorf("hard link entries = %v", links)
+	}
+
+	dest := t.TempDir()
+	conflicts, perms := testExtractPolicies(dest)
+	if _, err := extractAll(sparse, dest, conflicts, perms, nil); err != nil {
+		t.Fatal(err)
+	}
+	restored := filepath.Join(dest, "image", "disk.img")
+	got, err := os.ReadFile(restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("extracted disk.img differs from the source")
+	}
+	if used := allocated(t, restored); used >= sparseFixtureSize/2 {
+		t.Errorf("extracted disk.img takes %d bytes on disk, the holes were filled", used)
+	}
+	notes, _ := os.Stat(filepath.Join(dest, "image", "notes.txt"))
+	link, _ := os.Stat(filepath.Join(dest, "image", "sub", "notes-link.txt"))
+	if notes == nil || link == nil || !os.SameFile(notes, link) {
+		t.Error("the extracted names of notes.txt are not hard linked")
+	}
+}
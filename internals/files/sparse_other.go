@@ -0,0 +1,10 @@
+//go:build !linux
+
+package files
+
+import "os"
+
+// dataSegments cannot detect holes here, files are always archived with their zeros
+func dataSegments(f *os.File, size int64) ([]sparseSegment, error) {
+	return nil, errSparseUnsupported
+}
@@ -0,0 +1,98 @@
+package files
+
+import (
+	"bytes"
+	"io"
+	"os"
+
+	"gsn-dev-tools/internals/tmpfs"
+)
+
+// entrySpool holds the content of one archive entry until it is known to be consistent. Up to limit bytes stay
+// in memory, larger contents move to a temp file, which is reused by the following entries.
+type entrySpool struct {
+	limit     int64
+	buf       bytes.Buffer
+	workspace *tmpfs.Workspace
+	file      *os.File
+	spilled   bool
+	size      int64
+}
+
+func newEntrySpool(limit int64) *entrySpool {
+	return &entrySpool{limit: limit}
+}
+
+func (s *entrySpool) Write(p []byte) (int, error) {
+	if !s.spilled && int64(s.buf.Len()+len(p)) > s.limit {
+		if err := s.spill(); err != nil {
+			return 0, err
+		}
+	}
+	var n int
+	var err error
+	if s.spilled {
+		n, err = s.file.Write(p)
+	} else {
+		n, err = s.buf.Write(p)
+	}
+	s.size += int64(n)
+	return n, err
+}
+
+// spill moves the buffered content to the temp file, created with the first entry that needs it
+func (s *entrySpool) spill() error {
+	if s.file == nil {
+		workspace, err := tmpfs.New("spool")
+		if err != nil {
+			return err
+		}
+		file, err := workspace.CreateFile("entry-*")
+		if err != nil {
+			workspace.Cleanup()
+			return err
+		}
+		s.workspace, s.file = workspace, file
+	}
+	if _, err := s.file.Write(s.buf.Bytes()); err != nil {
+		return err
+	}
+	s.buf.Reset()
+	s.spilled = true
+	return nil
+}
+
+// reset empties the spool for the next attempt or entry, the memory buffer keeps its capacity
+func (s *entrySpool) reset() error {
+	s.buf.Reset()
+	s.size = 0
+	if !s.spilled {
+		return nil
+	}
+	s.spilled = false
+	if err := s.file.Truncate(0); err != nil {
+		return err
+	}
+	_, err := s.file.Seek(0, io.SeekStart)
+	return err
+}
+
+// WriteTo copies the spooled content to w
+func (s *entrySpool) WriteTo(w io.Writer) (int64, error) {
+	if !s.spilled {
+		return io.Copy(w, bytes.NewReader(s.buf.Bytes()))
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.Copy(w, io.LimitReader(s.file, s.size))
+}
+
+// close removes the temp file
+func (s *entrySpool) close() {
+	if s.file != nil {
+		s.file.Close()
+		s.workspace.Cleanup()
+		s.file = nil
+	}
+}
@@ -0,0 +1,499 @@
+package files
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/config"
+	"gsn-dev-tools/internals/paths"
+	"gsn-dev-tools/internals/secrets"
+	"gsn-dev-tools/internals/state"
+	"gsn-dev-tools/internals/style"
+	"gsn-dev-tools/internals/units"
+
+	"github.com/spf13/cobra"
+)
+
+// exportManifestName is the first entry of a state export, listing what it holds and what was left out
+const exportManifestName = "gsn-export.json"
+
+// Roots of a state export, every other entry is below one of them
+const (
+	exportConfigRoot = "config"
+	exportStateRoot  = "state"
+)
+
+// exportKind versions the manifest of state exports, an export written by a newer gsn is not imported
+var exportKind = state.Register(&state.Kind{
+	Name:     "state export",
+	Format:   state.JSON,
+	Version:  1,
+	Patterns: []string{exportManifestName},
+})
+
+// exportManifest describes a state export
+type exportManifest struct {
+	SchemaVersion int            `json:"schema_version"`
+	CreatedAt     time.Time      `json:"created_at"`
+	Host          string         `json:"host"`
+	Files         []string       `json:"files"`
+	Excluded      []exportedSkip `json:"excluded,omitempty"`
+}
+
+// exportedSkip is a file left out of an export and why
+type exportedSkip struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// secretFiles are the files of the config dir holding secrets or the keys to them, they never leave the machine.
+// The gh token file is exported when it holds a keyring:<name> reference rather than the token.
+var secretFiles = map[string]string{
+	"age-identity.txt":   "age private key of config.secrets.age, copy it yourself",
+	"config.secrets.age": "encrypted config secrets, copy them with their identity yourself",
+	"secrets.enc":        "file secret store, set the secrets again with gsn secret set",
+	"gh-token":           "GitHub token, run gsn gh auth login again",
+}
+
+// runtimeFiles are state only meaningful to the running machine
+var runtimeFiles = map[string]bool{
+	"daemon.json": true,
+	"locks":       true,
+}
+
+func ExportStateCmd() *cobra.Command {
+	exportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "Bundles the gsn config and state into an archive for another machine",
+		Long: `Writes the config dir, with the config file, saved requests and user templates, and the state dir, with the
+gh queue, the completion history, the workspace journals and the backup store, into a tar.gz that gsn import
+restores on another machine.
+
+Secrets stay behind: the age identity, config.secrets.age, the file secret store and the gh token file are left
+out and listed, unless the token file holds a keyring:<name> reference, which is exported as the reference.
+Values of the config that are keyring references stay references. --no-history leaves out the completion
+history and --no-journals the workspace rename journals.`,
+		Example: `  gsn export -o gsn-state.tar.gz
+  gsn export -o gsn-state.tar.gz --no-history --no-journals`,
+		Args: cobra.NoArgs,
+		Run:  ExportState,
+	}
+
+	exportCmd.Flags().StringP("output", "o", "gsn-state.tar.gz", "Archive to write")
+	exportCmd.Flags().Bool("force", false, "Overwrite the archive when it exists")
+	exportCmd.Flags().Bool("no-history", false, "Leave out the history of recent repos and pull requests")
+	exportCmd.Flags().Bool("no-journals", false, "Leave out the workspace rename journals")
+	return exportCmd
+}
+
+func ExportState(cmd *cobra.Command, args []string) {
+	outPath, _ := cmd.Flags().GetString("output")
+	force, _ := cmd.Flags().GetBool("force")
+	noHistory, _ := cmd.Flags().GetBool("no-history")
+	noJournals, _ := cmd.Flags().GetBool("no-journals")
+
+	if _, err := os.Lstat(outPath); err == nil && !force {
+		clierr.Exitf(clierr.Conflict, "'%s' already exists, use --force to replace it", outPath)
+	}
+
+	sources, skipped, err := exportSources(noHistory, noJournals)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	if len(sources) == 0 {
+		clierr.Exitf(clierr.NotFound, "there is no gsn config or state to export")
+	}
+
+	host, _ := os.Hostname()
+	manifest := exportManifest{SchemaVersion: exportKind.Version, CreatedAt: time.Now().UTC(), Host: host, Excluded: skipped}
+	for _, src := range sources {
+		manifest.Files = append(manifest.Files, src.Name)
+	}
+	if err := writeStateExport(outPath, manifest, sources); err != nil {
+		clierr.Fatalf("%v", err)
+	}
+
+	for _, skip := range skipped {
+		fmt.Fprintf(os.Stderr, "Left out %s: %s\n", skip.Path, skip.Reason)
+	}
+	info, _ := os.Stat(outPath)
+	fmt.Printf(style.Success()+"Exported %s file(s) to %s (%s)\n", units.FormatInt(int64(len(sources))), outPath, units.FormatBytes(info.Size()))
+}
+
+// exportSource is a file to export and its entry name
+type exportSource struct {
+	Path string
+	Name string
+}
+
+// exportSources lists the files of the config and state dirs to export, in walk order, and those left out
+func exportSources(noHistory bool, noJournals bool) ([]exportSource, []exportedSkip, error) {
+	configDir, err := paths.ConfigDir()
+	if err != nil {
+		return nil, nil, err
+	}
+	configFile, err := config.Path()
+	if err != nil {
+		return nil, nil, err
+	}
+	stateDir, err := paths.StateDir()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var sources []exportSource
+	var skipped []exportedSkip
+	walk := func(root string, prefix string) error {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root, path)
+			if err != nil || rel == "." {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+			name := prefix + "/" + rel
+
+			if runtimeFiles[rel] || strings.HasSuffix(rel, ".lock") {
+				return skipEntry(d)
+			}
+			switch {
+			case prefix == exportStateRoot && noHistory && rel == "gh-completion.json":
+				skipped = append(skipped, exportedSkip{Path: name, Reason: "--no-history"})
+				return nil
+			case prefix == exportStateRoot && noJournals && rel == "journals":
+				skipped = append(skipped, exportedSkip{Path: name, Reason: "--no-journals"})
+				return fs.SkipDir
+			case prefix == exportConfigRoot && rel == "config.yaml" && path != configFile:
+				// $GSN_CONFIG points elsewhere, that file is exported instead
+				return nil
+			}
+			if reason, ok := secretFiles[rel]; ok && prefix == exportConfigRoot && !isSecretRefFile(path) {
+				skipped = append(skipped, exportedSkip{Path: name, Reason: reason})
+				return nil
+			}
+			if d.Type().IsRegular() {
+				sources = append(sources, exportSource{Path: path, Name: name})
+			}
+			return nil
+		})
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	if err := walk(configDir, exportConfigRoot); err != nil {
+		return nil, nil, err
+	}
+	if filepath.Dir(configFile) != configDir && isRegularFile(configFile) {
+		sources = append(sources, exportSource{Path: configFile, Name: exportConfigRoot + "/config.yaml"})
+	}
+	if err := walk(stateDir, exportStateRoot); err != nil {
+		return nil, nil, err
+	}
+	return sources, skipped, nil
+}
+
+// skipEntry leaves out an entry of a walk, with its contents for a directory
+func skipEntry(d fs.DirEntry) error {
+	if d.IsDir() {
+		return fs.SkipDir
+	}
+	return nil
+}
+
+// isSecretRefFile reports whether a file holds a single keyring:<name> reference instead of a secret
+func isSecretRefFile(path string) bool {
+	data, err := os.ReadFile(path)
+	return err == nil && secrets.IsRef(strings.TrimSpace(string(data)))
+}
+
+// writeStateExport writes the manifest and the sources to a .part file renamed into place once complete
+func writeStateExport(outPath string, manifest exportManifest, sources []exportSource) error {
+	format, err := parseFormat(formatTarGz.String())
+	if err != nil {
+		return err
+	}
+	partPath := outPath + ".part"
+	out, err := os.OpenFile(partPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(partPath) // no-op once renamed
+
+	aw, err := createArchive(out, format, nil)
+	if err == nil {
+		err = writeExportEntries(aw, manifest, sources)
+		if cerr := aw.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(partPath, outPath)
+}
+
+func writeExportEntries(aw entryWriter, manifest exportManifest, sources []exportSource) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	header := &tar.Header{Name: exportManifestName, Mode: 0o600, Size: int64(len(data)), ModTime: manifest.CreatedAt, Typeflag: tar.TypeReg}
+	if err := aw.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := aw.Write(data); err != nil {
+		return err
+	}
+
+	for _, src := range sources {
+		if err := writeExportFile(aw, src); err != nil {
+			return fmt.Errorf("error exporting '%s': %w", src.Path, err)
+		}
+	}
+	return nil
+}
+
+func writeExportFile(aw entryWriter, src exportSource) error {
+	f, err := os.Open(src.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = src.Name
+	header.Uname, header.Gname = "", ""
+	if err := aw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.CopyN(aw, f, header.Size)
+	return err
+}
+
+func ImportStateCmd() *cobra.Command {
+	importCmd := &cobra.Command{
+		Use:   "import <file>",
+		Short: "Restores the gsn config and state bundled by gsn export",
+		Long: `Restores the files of an export into the config and state dirs of this machine. A file that exists with
+other content is a conflict, resolved with --on-conflict: prompt asks for every file, the default on a
+terminal, overwrite replaces it, skip keeps it and backup keeps it as <file>.bak.N. State files are checked
+like gsn state migrate does: those written by a newer gsn, or corrupted, are not imported. Every state file is
+checked once the import is done, and the problems found are listed.
+
+Secrets are not part of an export, set them again on this machine.`,
+		Example: `  gsn import gsn-state.tar.gz
+  gsn import gsn-state.tar.gz --on-conflict skip`,
+		Args: cobra.ExactArgs(1),
+		Run:  ImportState,
+	}
+
+	importCmd.Flags().String("on-conflict", string(conflictPrompt), "What to do with existing files that differ: prompt, overwrite, skip or backup")
+	return importCmd
+}
+
+func ImportState(cmd *cobra.Command, args []string) {
+	archivePath := args[0]
+	onConflict, _ := cmd.Flags().GetString("on-conflict")
+
+	policy, err := parseConflictPolicy(onConflict)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	roots, err := importRoots()
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+
+	if err := os.MkdirAll(roots[exportStateRoot], 0o700); err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	conflicts := newConflictResolver(policy, "")
+	perms := &permissionPolicy{UID: -1, GID: -1, umask: processUmask()}
+	result, err := importStateArchive(archivePath, roots, conflicts, perms)
+	if ferr := perms.finish(); ferr != nil && err == nil {
+		err = fmt.Errorf("failed to set directory modes: %w", ferr)
+	}
+	for _, entry := range conflicts.journal.Entries {
+		fmt.Printf("Kept the previous '%s' as '%s'\n", entry.From, entry.To)
+	}
+	if err != nil {
+		clierr.Fatalf("Import failed: %v", err)
+	}
+
+	fmt.Printf(style.Success()+"Imported %d file(s) from %s, exported on %s at %s, %d already up to date\n",
+		result.Written, archivePath, result.Manifest.Host, result.Manifest.CreatedAt.Local().Format("2006-01-02 15:04"), result.Unchanged)
+	if summary := conflicts.summary(); summary != "" {
+		fmt.Println(summary)
+	}
+	for _, skip := range result.Manifest.Excluded {
+		fmt.Printf("Not in the export, %s: %s\n", skip.Path, skip.Reason)
+	}
+
+	checked, problems := state.Check()
+	for _, problem := range problems {
+		fmt.Fprintf(os.Stderr, style.Warning()+"%v\n", problem)
+	}
+	if len(problems) > 0 || result.Refused > 0 {
+		clierr.Exitf(clierr.Failure, "%d state file(s) checked, %d problem(s), %d file(s) not imported", checked, len(problems), result.Refused)
+	}
+	fmt.Printf("Checked %d state file(s), no problems found\n", checked)
+}
+
+// importResult counts what an import did
+type importResult struct {
+	Manifest  exportManifest
+	Written   int
+	Unchanged int
+	Refused   int
+}
+
+// importRoots maps the roots of an export to the directories of this machine
+func importRoots() (map[string]string, error) {
+	configDir, err := paths.ConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	stateDir, err := paths.StateDir()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{exportConfigRoot: configDir, exportStateRoot: stateDir}, nil
+}
+
+// importTarget resolves an entry name to its path on this machine, the config file follows $GSN_CONFIG
+func importTarget(roots map[string]string, name string) (string, error) {
+	root, rel, ok := strings.Cut(name, "/")
+	dir, known := roots[root]
+	if !ok || !known || rel == "" {
+		return "", fmt.Errorf("entry '%s' is outside the config and state dirs", name)
+	}
+	if root == exportConfigRoot && rel == "config.yaml" {
+		return config.Path()
+	}
+	// Exports of older versions carry the workspace journals in the config dir
+	if root == exportConfigRoot && (rel == "journals" || strings.HasPrefix(rel, "journals/")) {
+		dir = roots[exportStateRoot]
+	}
+	return safeJoin(dir, rel)
+}
+
+// importStateArchive restores the entries of an export, the manifest has to come first
+func importStateArchive(archivePath string, roots map[string]string, conflicts *conflictResolver, perms *permissionPolicy) (importResult, error) {
+	var result importResult
+	tr, err := openArchive(archivePath)
+	if err != nil {
+		return result, err
+	}
+	defer tr.Close()
+
+	header, err := tr.Next()
+	if err != nil || header.Name != exportManifestName {
+		return result, fmt.Errorf("'%s' is not a gsn export, it does not start with %s", archivePath, exportManifestName)
+	}
+	if err == nil {
+		result.Manifest, err = readExportManifest(archivePath, tr)
+	}
+	if err != nil {
+		return result, err
+	}
+
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+		target, err := importTarget(roots, header.Name)
+		if err != nil {
+			return result, err
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return result, err
+		}
+
+		if err := checkImportedState(header.Name, target, data); err != nil {
+			fmt.Fprintf(os.Stderr, style.Warning()+"Not importing %s: %v\n", header.Name, err)
+			result.Refused++
+			continue
+		}
+		if current, err := os.ReadFile(target); err == nil && bytes.Equal(current, data) {
+			result.Unchanged++
+			continue
+		}
+		write, err := conflicts.resolve(target)
+		if err != nil {
+			return result, err
+		}
+		if !write {
+			continue
+		}
+		if err := restoreEntry(bytes.NewReader(data), header, target, conflicts, perms); err != nil {
+			return result, err
+		}
+		result.Written++
+	}
+}
+
+// readExportManifest reads the manifest, refusing exports written by a newer gsn
+func readExportManifest(archivePath string, r io.Reader) (exportManifest, error) {
+	var manifest exportManifest
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return manifest, err
+	}
+	doc, err := exportKind.Parse(archivePath+":"+exportManifestName, data)
+	if err != nil {
+		return manifest, err
+	}
+	if err := doc.Writable(); err != nil {
+		return manifest, err
+	}
+	return manifest, doc.Decode(&manifest)
+}
+
+// checkImportedState applies the schema checks of the state package to a state file about to be imported,
+// other files pass as they are. Templates are never state, whatever their names.
+func checkImportedState(name string, target string, data []byte) error {
+	if strings.HasPrefix(name, exportConfigRoot+"/templates/") {
+		return nil
+	}
+	k, err := state.KindOf(target)
+	if err != nil {
+		return nil
+	}
+	doc, err := k.Parse(target, data)
+	if err != nil {
+		return err
+	}
+	return doc.Writable()
+}
@@ -0,0 +1,332 @@
+package files
+
+import (
+	"archive/tar"
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"gsn-dev-tools/internals/paths"
+)
+
+// useGSNHome points the config and state dirs below a portable home, with $GSN_CONFIG unset
+func useGSNHome(t *testing.T, home string) {
+	t.Helper()
+	t.Setenv(paths.HomeEnv, home)
+	t.Setenv("GSN_CONFIG", "")
+}
+
+// exportedMachine is the config and state of the machine exported in these tests
+var exportedMachine = map[string]string{
+	"config/config.yaml":                 "schema_version: 1\n",
+	"config/templates/go-cli/main.go":    "package main\n",
+	"config/requests/health.yaml":        "url: https://example.com/health\n",
+	"config/age-identity.txt":            "AGE-SECRET-KEY-1...",
+	"config/config.secrets.age":          "age-encryption.org/v1...",
+	"config/secrets.enc":                 "sealed",
+	"config/gh-token":                    "keyring:gh-token\n",
+	"state/gh-completion.json":           `{"repos":["owner/repo"]}`,
+	"state/gh-queue/0001.json":           `{"kind":"approve"}`,
+	"state/gh-queue/.lock":               "",
+	"state/backups/index.json":           `{"schema_version":1,"next_id":1}`,
+	"state/journals/rename-1.json":       `{"schema_version":1,"command":"rename"}`,
+	"state/locks/3f2a.lock":              "4242\n",
+	"state/daemon.json":                  `{"pid":4242}`,
+	"state/gh-completion.json.lock":      "",
+	"state/backups/objects/ab/cdef.blob": "backed up",
+}
+
+// exportMachine writes exportedMachine below a home and exports it
+func exportMachine(t *testing.T, noHistory bool, noJournals bool) (string, exportManifest) {
+	t.Helper()
+	home := t.TempDir()
+	writeTree(t, home, exportedMachine)
+	useGSNHome(t, home)
+
+	sources, skipped, err := exportSources(noHistory, noJournals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := exportManifest{SchemaVersion: exportKind.Version, CreatedAt: time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC), Host: "laptop", Excluded: skipped}
+	for _, src := range sources {
+		manifest.Files = append(manifest.Files, src.Name)
+	}
+	archive := filepath.Join(t.TempDir(), "gsn-state.tar.gz")
+	if err := writeStateExport(archive, manifest, sources); err != nil {
+		t.Fatal(err)
+	}
+	return archive, manifest
+}
+
+// importInto imports archive below a fresh portable home holding existing, with conflicts resolved by policy
+func importInto(t *testing.T, archive string, existing map[string]string, resolver func(r *conflictResolver)) (string, importResult, *conflictResolver, error) {
+	t.Helper()
+	home := t.TempDir()
+	writeTree(t, home, existing)
+	useGSNHome(t, home)
+
+	roots, err := importRoots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conflicts := newConflictResolver(conflictOverwrite, "")
+	if resolver != nil {
+		resolver(conflicts)
+	}
+	perms := &permissionPolicy{UID: -1, GID: -1, umask: 0o022}
+	var result importResult
+	captureStderr(t, func() { result, err = importStateArchive(archive, roots, conflicts, perms) })
+	if ferr := perms.finish(); ferr != nil {
+		t.Fatal(ferr)
+	}
+	return home, result, conflicts, err
+}
+
+func TestStateExportRoundTrip(t *testing.T) {
+	archive, manifest := exportMachine(t, false, false)
+
+	// The manifest comes first, secrets and runtime files stay behind
+	entries := readFixtureTar(t, archive)
+	if entries[0].Name != exportManifestName {
+		t.Fatalf("first entry = %s", entries[0].Name)
+	}
+	var names []string
+	for _, e := range entries[1:] {
+		names = append(names, e.Name)
+	}
+	slices.Sort(names)
+	want := []string{
+		"config/config.yaml",
+		"config/gh-token",
+		"config/requests/health.yaml",
+		"config/templates/go-cli/main.go",
+		"state/backups/index.json",
+		"state/backups/objects/ab/cdef.blob",
+		"state/gh-completion.json",
+		"state/gh-queue/0001.json",
+		"state/journals/rename-1.json",
+	}
+	if !slices.Equal(names, want) {
+		t.Errorf("entries =\n%s\nwant\n%s", strings.Join(names, "\n"), strings.Join(want, "\n"))
+	}
+	var excluded []string
+	for _, skip := range manifest.Excluded {
+		excluded = append(excluded, skip.Path)
+	}
+	slices.Sort(excluded)
+	if want := []string{"config/age-identity.txt", "config/config.secrets.age", "config/secrets.enc"}; !slices.Equal(excluded, want) {
+		t.Errorf("excluded = %q, want %q", excluded, want)
+	}
+
+	home, result, _, err := importInto(t, archive, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Written != len(want) || result.Unchanged != 0 || result.Refused != 0 {
+		t.Errorf("result = %+v", result)
+	}
+	if result.Manifest.Host != "laptop" || !slices.Equal(result.Manifest.Files, manifest.Files) {
+		t.Errorf("manifest read back = %+v", result.Manifest)
+	}
+	for _, name := range want {
+		checkFiles(t, home, map[string]string{name: exportedMachine[name]})
+	}
+	for _, name := range []string{"config/age-identity.txt", "state/daemon.json", "state/locks/3f2a.lock"} {
+		if _, err := os.Stat(filepath.Join(home, name)); !os.IsNotExist(err) {
+			t.Errorf("%s was imported: %v", name, err)
+		}
+	}
+
+	// Importing again changes nothing
+	_, result, conflicts, err := importInto(t, archive, exportedMachine, nil)
+	if err != nil || result.Written != 0 || result.Unchanged != len(want) || conflicts.summary() != "" {
+		t.Errorf("import over identical files = %+v, %v, %q", result, err, conflicts.summary())
+	}
+}
+
+func TestStateExportLeavesOutHistoryAndJournals(t *testing.T) {
+	_, manifest := exportMachine(t, true, true)
+	for _, name := range manifest.Files {
+		if name == "state/gh-completion.json" || strings.HasPrefix(name, "state/journals/") {
+			t.Errorf("%s was exported", name)
+		}
+	}
+	reasons := map[string]string{}
+	for _, skip := range manifest.Excluded {
+		reasons[skip.Path] = skip.Reason
+	}
+	if reasons["state/gh-completion.json"] != "--no-history" || reasons["state/journals"] != "--no-journals" {
+		t.Errorf("excluded = %v", manifest.Excluded)
+	}
+}
+
+func TestStateExportTokenFile(t *testing.T) {
+	// A token file holding the token itself is a secret
+	home := t.TempDir()
+	writeTree(t, home, map[string]string{"config/gh-token": "ghp_secret\n"})
+	useGSNHome(t, home)
+	sources, skipped, err := exportSources(false, false)
+	if err != nil || len(sources) != 0 || len(skipped) != 1 || skipped[0].Path != "config/gh-token" {
+		t.Errorf("exportSources = %v, %v, %v", sources, skipped, err)
+	}
+}
+
+func TestStateImportConflicts(t *testing.T) {
+	archive, _ := exportMachine(t, false, false)
+	existing := map[string]string{
+		"config/config.yaml":       "schema_version: 1\neditor: nano\n",
+		"state/gh-queue/0001.json": `{"kind":"label"}`,
+		"state/backups/index.json": exportedMachine["state/backups/index.json"],
+	}
+	tests := []struct {
+		name     string
+		resolver func(r *conflictResolver)
+		want     map[string]string
+		written  int
+		summary  string
+	}{
+		{"overwrite", func(r *conflictResolver) { r.policy = conflictOverwrite }, map[string]string{
+			"config/config.yaml":       exportedMachine["config/config.yaml"],
+			"state/gh-queue/0001.json": exportedMachine["state/gh-queue/0001.json"],
+		}, 8, "Conflicts: 2 overwritten, 0 skipped, 0 backed up"},
+		{"skip", func(r *conflictResolver) { r.policy = conflictSkip }, map[string]string{
+			"config/config.yaml":       existing["config/config.yaml"],
+			"state/gh-queue/0001.json": existing["state/gh-queue/0001.json"],
+		}, 6, "Conflicts: 0 overwritten, 2 skipped, 0 backed up"},
+		{"backup", func(r *conflictResolver) { r.policy = conflictBackup }, map[string]string{
+			"config/config.yaml":              exportedMachine["config/config.yaml"],
+			"config/config.yaml.bak.1":        existing["config/config.yaml"],
+			"state/gh-queue/0001.json":        exportedMachine["state/gh-queue/0001.json"],
+			"state/gh-queue/0001.json.bak.1":  existing["state/gh-queue/0001.json"],
+			"state/journals/rename-1.json":    exportedMachine["state/journals/rename-1.json"],
+			"config/templates/go-cli/main.go": exportedMachine["config/templates/go-cli/main.go"],
+		}, 8, "Conflicts: 0 overwritten, 0 skipped, 2 backed up"},
+		// The config file is kept, then every other conflict overwritten
+		{"prompt", func(r *conflictResolver) {
+			r.policy = conflictPrompt
+			r.input = bufio.NewReader(strings.NewReader("s\nO\n"))
+			r.interactive = true
+		}, map[string]string{
+			"config/config.yaml":       existing["config/config.yaml"],
+			"state/gh-queue/0001.json": exportedMachine["state/gh-queue/0001.json"],
+		}, 7, "Conflicts: 1 overwritten, 1 skipped, 0 backed up"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var home string
+			var result importResult
+			var conflicts *conflictResolver
+			var err error
+			captureStdout(t, func() { home, result, conflicts, err = importInto(t, archive, existing, test.resolver) })
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkFiles(t, home, test.want)
+			// The identical backup index is no conflict
+			if result.Written != test.written || result.Unchanged != 1 || conflicts.summary() != test.summary {
+				t.Errorf("result = %+v, %q, want %d written and %q", result, conflicts.summary(), test.written, test.summary)
+			}
+		})
+	}
+}
+
+// writeExport writes an export by hand, with the manifest first unless it is nil
+func writeExport(t *testing.T, manifest any, entries []fixtureEntry) string {
+	t.Helper()
+	if manifest != nil {
+		data, err := json.Marshal(manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append([]fixtureEntry{{Name: exportManifestName, Body: string(data)}}, entries...)
+	}
+	path := filepath.Join(t.TempDir(), "export.tar")
+	writeFixtureTar(t, path, entries)
+	return path
+}
+
+func TestStateImportChecksEveryFile(t *testing.T) {
+	manifest := map[string]any{"schema_version": 1, "host": "laptop"}
+	archive := writeExport(t, manifest, []fixtureEntry{
+		{Name: "state/backups/index.json", Body: `{"schema_version":99}`},
+		{Name: "config/config.yaml", Body: "schema_version: [1\n"},
+		// Templates are never state, whatever their names
+		{Name: "config/templates/site/config.yaml", Body: "schema_version: 99\n"},
+		{Name: "config/journals/rename-1.json", Body: `{"schema_version":1,"command":"rename"}`},
+		{Name: "state/gh-queue/link", Type: tar.TypeSymlink, Link: "/etc/passwd"},
+	})
+	home, result, _, err := importInto(t, archive, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Refused != 2 || result.Written != 2 {
+		t.Errorf("result = %+v, want the newer index and the corrupted config refused", result)
+	}
+	// Journals of older exports land in the state dir, links are not restored
+	checkFiles(t, home, map[string]string{"config/templates/site/config.yaml": "schema_version: 99\n", "state/journals/rename-1.json": `{"schema_version":1,"command":"rename"}`})
+	for _, name := range []string{"state/backups/index.json", "config/config.yaml", "state/gh-queue/link"} {
+		if _, err := os.Lstat(filepath.Join(home, name)); !os.IsNotExist(err) {
+			t.Errorf("%s was imported: %v", name, err)
+		}
+	}
+}
+
+func TestStateImportRefusesOtherArchives(t *testing.T) {
+	tests := []struct {
+		name    string
+		archive string
+		want    string
+	}{
+		{"no manifest", writeExport(t, nil, []fixtureEntry{{Name: "state/gh-queue/0001.json", Body: "{}"}}), "is not a gsn export"},
+		{"empty", writeExport(t, nil, nil), "is not a gsn export"},
+		{"newer export", writeExport(t, map[string]any{"schema_version": 2}, nil), "newer"},
+		{"outside the roots", writeExport(t, map[string]any{"schema_version": 1}, []fixtureEntry{{Name: "cache/x", Body: "x"}}), "outside the config and state dirs"},
+		{"escaping a root", writeExport(t, map[string]any{"schema_version": 1}, []fixtureEntry{{Name: "state/../../x", Body: "x"}}), ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			home, _, _, err := importInto(t, test.archive, nil, nil)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("import = %v, want an error with %q", err, test.want)
+			}
+			if _, err := os.Stat(filepath.Join(filepath.Dir(home), "x")); !os.IsNotExist(err) {
+				t.Errorf("a file was written outside the home: %v", err)
+			}
+		})
+	}
+}
+
+func TestImportTarget(t *testing.T) {
+	roots := map[string]string{exportConfigRoot: "/home/me/.config/gsn", exportStateRoot: "/home/me/.local/state/gsn"}
+	t.Setenv("GSN_CONFIG", "/etc/gsn/config.yaml")
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"config/config.yaml", "/etc/gsn/config.yaml"},
+		{"config/requests/health.yaml", "/home/me/.config/gsn/requests/health.yaml"},
+		{"config/journals/rename-1.json", "/home/me/.local/state/gsn/journals/rename-1.json"},
+		{"state/gh-queue/0001.json", "/home/me/.local/state/gsn/gh-queue/0001.json"},
+		{"config", ""},
+		{"state/", ""},
+		{"data/x", ""},
+		{"state/../../../etc/passwd", ""},
+	}
+	for _, test := range tests {
+		got, err := importTarget(roots, test.name)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("importTarget(%q) = %q, want an error", test.name, got)
+			}
+			continue
+		}
+		if err != nil || got != filepath.FromSlash(test.want) {
+			t.Errorf("importTarget(%q) = %q, %v, want %q", test.name, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package files
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Formats of the digests written to checksum files and compared by the verify paths
+const (
+	// digestSHA256 is the SHA-256 of the whole content, as sha256sum writes it
+	digestSHA256 = "sha256"
+	// treeHashFormat labels tree hashes: the SHA-256 of the concatenated SHA-256 digests of the fixed-size chunks
+	// of the content. The label versions the construction, a change to how chunks or the root are hashed takes a
+	// new label so a recorded digest is never recomputed with other rules.
+	treeHashFormat = "gsn-tree-sha256"
+)
+
+// defaultTreeChunkSize is the chunk size of --parallel-hash, large enough for sequential reads within a chunk
+const defaultTreeChunkSize = 16 << 20
+
+// fileDigest is the digest of a file in one of the digest formats
+type fileDigest struct {
+	Format string
+	// ChunkSize is the chunk size of a tree hash, 0 for sha256
+	ChunkSize int64
+	Hex       string
+}
+
+// String returns the digest as checksum files hold it: the bare hex for sha256,
+// gsn-tree-sha256:<chunksize>:<hex> for tree hashes
+func (d fileDigest) String() string {
+	if d.Format == treeHashFormat {
+		return fmt.Sprintf("%s:%d:%s", treeHashFormat, d.ChunkSize, d.Hex)
+	}
+	return d.Hex
+}
+
+// parseDigest reads a digest written by fileDigest.String. A labeled digest of another format, a later tree hash
+// version included, is an error rather than a mismatch.
+func parseDigest(s string) (fileDigest, error) {
+	label, rest, labeled := strings.Cut(s, ":")
+	if !labeled {
+		if !isSHA256Hex(s) {
+			return fileDigest{}, fmt.Errorf("'%s' is not a SHA-256 digest", s)
+		}
+		return fileDigest{Format: digestSHA256, Hex: strings.ToLower(s)}, nil
+	}
+	if label != treeHashFormat {
+		return fileDigest{}, fmt.Errorf("unknown digest format '%s', this gsn reads sha256 and %s", label, treeHashFormat)
+	}
+	size, sum, ok := strings.Cut(rest, ":")
+	chunkSize, err := strconv.ParseInt(size, 10, 64)
+	if !ok || err != nil || chunkSize <= 0 || !isSHA256Hex(sum) {
+		return fileDigest{}, fmt.Errorf("invalid %s digest '%s', expected %s:<chunksize>:<hex>", treeHashFormat, s, treeHashFormat)
+	}
+	return fileDigest{Format: treeHashFormat, ChunkSize: chunkSize, Hex: strings.ToLower(sum)}, nil
+}
+
+func isSHA256Hex(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// compareDigests checks computed against recorded. Digests of different formats or chunk sizes describe the same
+// content differently, comparing them is refused instead of reported as a mismatch.
+func compareDigests(recorded fileDigest, computed fileDigest) error {
+	if recorded.Format != computed.Format {
+		return fmt.Errorf("cannot compare a %s digest with a %s one, the content must be hashed the way it was recorded", recorded.Format, computed.Format)
+	}
+	if recorded.ChunkSize != computed.ChunkSize {
+		return fmt.Errorf("cannot compare %s digests of %d and %d byte chunks", treeHashFormat, recorded.ChunkSize, computed.ChunkSize)
+	}
+	if recorded.Hex != computed.Hex {
+		return fmt.Errorf("digest mismatch: recorded %s, computed %s", recorded, computed)
+	}
+	return nil
+}
+
+// digestFile hashes a file as sha256, or as a tree hash of the default chunk size across every CPU when parallel
+// is set
+func digestFile(path string, parallel bool) (fileDigest, error) {
+	if parallel {
+		return digestFileAs(path, fileDigest{Format: treeHashFormat, ChunkSize: defaultTreeChunkSize})
+	}
+	return digestFileAs(path, fileDigest{Format: digestSHA256})
+}
+
+// digestFileAs hashes a file in the format and chunk size of like, to compare it with like
+func digestFileAs(path string, like fileDigest) (fileDigest, error) {
+	d := fileDigest{Format: like.Format, ChunkSize: like.ChunkSize}
+	var err error
+	if like.Format == treeHashFormat {
+		d.Hex, err = treeHashFile(path, like.ChunkSize, runtime.NumCPU())
+	} else {
+		d.Hex, err = hashFile(path)
+	}
+	return d, err
+}
+
+// treeHashFile returns the hex tree hash of a file, its chunks hashed by workers reading at their own offsets
+func treeHashFile(path string, chunkSize int64, workers int) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	chunks := (info.Size() + chunkSize - 1) / chunkSize
+	digests := make([][]byte, chunks)
+	jobs := make(chan int64)
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hasher := sha256.New()
+			for i := range jobs {
+				hasher.Reset()
+				n, err := io.Copy(hasher, io.NewSectionReader(file, i*chunkSize, chunkSize))
+				if err == nil && n != min(chunkSize, info.Size()-i*chunkSize) {
+					err = fmt.Errorf("'%s' changed size while it was hashed", path)
+				}
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					continue
+				}
+				digests[i] = hasher.Sum(nil)
+			}
+		}()
+	}
+	for i := range chunks {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	if firstErr != nil {
+		return "", firstErr
+	}
+
+	root := sha256.New()
+	for _, d := range digests {
+		root.Write(d)
+	}
+	return hex.EncodeToString(root.Sum(nil)), nil
+}
+
+// treeHasher computes the tree hash of a stream written to it, for content read once in order like a copy. It
+// gives the digest treeHashFile computes for the same content and chunk size.
+type treeHasher struct {
+	chunkSize int64
+	chunk     hash.Hash
+	filled    int64
+	root      hash.Hash
+}
+
+func newTreeHasher(chunkSize int64) *treeHasher {
+	return &treeHasher{chunkSize: chunkSize, chunk: sha256.New(), root: sha256.New()}
+}
+
+func (t *treeHasher) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		n := min(int64(len(p)), t.chunkSize-t.filled)
+		t.chunk.Write(p[:n])
+		t.filled += n
+		p = p[n:]
+		if t.filled == t.chunkSize {
+			t.endChunk()
+		}
+	}
+	return written, nil
+}
+
+func (t *treeHasher) endChunk() {
+	t.root.Write(t.chunk.Sum(nil))
+	t.chunk.Reset()
+	t.filled = 0
+}
+
+// Digest ends the stream and returns its tree hash
+func (t *treeHasher) Digest() fileDigest {
+	if t.filled > 0 {
+		t.endChunk()
+	}
+	return fileDigest{Format: treeHashFormat, ChunkSize: t.chunkSize, Hex: hex.EncodeToString(t.root.Sum(nil))}
+}
+
+// readChecksumFile returns the digest recorded for name in a checksum file of `<digest>  <name>` lines, as
+// sha256sum and the checksum stage of gsn backup run write them
+func readChecksumFile(path string, name string) (fileDigest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return fileDigest{}, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		digest, entry, ok := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		// The digest is followed by a space, then by another space or the * of files hashed in binary mode
+		if ok && len(entry) > 0 && entry[1:] == name {
+			return parseDigest(digest)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fileDigest{}, err
+	}
+	return fileDigest{}, fmt.Errorf("'%s' has no checksum for '%s'", path, name)
+}
@@ -0,0 +1,218 @@
+package files
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// serialTreeHash is the tree hash as its format defines it, computed the plain way: the SHA-256 of the
+// concatenated SHA-256 digests of the chunks
+func serialTreeHash(data []byte, chunkSize int) string {
+	root := sha256.New()
+	for start := 0; start < len(data); start += chunkSize {
+		sum := sha256.Sum256(data[start:min(start+chunkSize, len(data))])
+		root.Write(sum[:])
+	}
+	return hex.EncodeToString(root.Sum(nil))
+}
+
+// randomFile writes size random bytes to a file of dir and returns its path and content
+func randomFile(t testing.TB, dir string, size int, seed uint64) (string, []byte) {
+	t.Helper()
+	data := make([]byte, size)
+	rng := rand.New(rand.NewPCG(seed, seed))
+	for i := range data {
+		data[i] = byte(rng.Uint32())
+	}
+	path := filepath.Join(dir, fmt.Sprintf("data-%d", size))
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path, data
+}
+
+// TestTreeHashMatchesSerial checks the parallel hash of a file and the streaming one against the serial
+// definition, for sizes around the chunk boundaries and any number of workers
+func TestTreeHashMatchesSerial(t *testing.T) {
+	const chunk = 4096
+	dir := t.TempDir()
+	for _, size := range []int{0, 1, chunk - 1, chunk, chunk + 1, 3 * chunk, 3*chunk + 7, 17*chunk + 123} {
+		path, data := randomFile(t, dir, size, uint64(size))
+		want := serialTreeHash(data, chunk)
+
+		for _, workers := range []int{0, 1, 3, 8, 64} {
+			got, err := treeHashFile(path, chunk, workers)
+			if err != nil || got != want {
+				t.Errorf("%d bytes, %d workers: treeHashFile = %s, %v, want %s", size, workers, got, err, want)
+			}
+		}
+
+		// Written in pieces that never line up with the chunks
+		hasher := newTreeHasher(chunk)
+		for rest := data; len(rest) > 0; {
+			n := min(len(rest), 1000)
+			hasher.Write(rest[:n])
+			rest = rest[n:]
+		}
+		if got := hasher.Digest(); got.Hex != want || got.ChunkSize != chunk || got.Format != treeHashFormat {
+			t.Errorf("%d bytes: treeHasher = %+v, want %s", size, got, want)
+		}
+	}
+
+	// A single chunk holding everything is the SHA-256 of the SHA-256
+	path, data := randomFile(t, dir, 5000, 1)
+	inner := sha256.Sum256(data)
+	outer := sha256.Sum256(inner[:])
+	if got, _ := treeHashFile(path, 1<<20, 4); got != hex.EncodeToString(outer[:]) {
+		t.Errorf("one chunk = %s, want the SHA-256 of the SHA-256", got)
+	}
+	// Another chunk size is another digest, and never the plain SHA-256
+	plain, _ := hashFile(path)
+	small, _ := treeHashFile(path, 1024, 4)
+	if small == plain || small == hex.EncodeToString(outer[:]) {
+		t.Errorf("1 KiB chunks gave %s", small)
+	}
+}
+
+func TestDigestFileAs(t *testing.T) {
+	path, data := randomFile(t, t.TempDir(), 40_000, 7)
+	sum := sha256.Sum256(data)
+
+	plain, err := digestFile(path, false)
+	if err != nil || plain.Format != digestSHA256 || plain.Hex != hex.EncodeToString(sum[:]) {
+		t.Errorf("digestFile = %+v, %v", plain, err)
+	}
+	tree, err := digestFileAs(path, fileDigest{Format: treeHashFormat, ChunkSize: 8192})
+	if err != nil || tree.Hex != serialTreeHash(data, 8192) || tree.ChunkSize != 8192 {
+		t.Errorf("digestFileAs with 8 KiB chunks = %+v, %v", tree, err)
+	}
+	if parallel, err := digestFile(path, true); err != nil || parallel.ChunkSize != defaultTreeChunkSize || parallel.Hex != serialTreeHash(data, defaultTreeChunkSize) {
+		t.Errorf("digestFile parallel = %+v, %v", parallel, err)
+	}
+	if _, err := treeHashFile(filepath.Join(t.TempDir(), "missing"), 4096, 2); !os.IsNotExist(err) {
+		t.Errorf("treeHashFile of a missing file = %v", err)
+	}
+}
+
+func TestParseDigest(t *testing.T) {
+	hexSum := strings.Repeat("ab", 32)
+	tests := []struct {
+		in      string
+		want    fileDigest
+		wantErr string
+	}{
+		{hexSum, fileDigest{Format: digestSHA256, Hex: hexSum}, ""},
+		{strings.ToUpper(hexSum), fileDigest{Format: digestSHA256, Hex: hexSum}, ""},
+		{"gsn-tree-sha256:16777216:" + hexSum, fileDigest{Format: treeHashFormat, ChunkSize: 16 << 20, Hex: hexSum}, ""},
+		{"abc", fileDigest{}, "'abc' is not a SHA-256 digest"},
+		{"gsn-tree-sha512:1024:" + hexSum, fileDigest{}, "unknown digest format 'gsn-tree-sha512'"},
+		{"gsn-tree-sha256-v2:1024:" + hexSum, fileDigest{}, "unknown digest format 'gsn-tree-sha256-v2'"},
+		{"gsn-tree-sha256:0:" + hexSum, fileDigest{}, "invalid gsn-tree-sha256 digest"},
+		{"gsn-tree-sha256:" + hexSum, fileDigest{}, "invalid gsn-tree-sha256 digest"},
+		{"gsn-tree-sha256:1024:xyz", fileDigest{}, "invalid gsn-tree-sha256 digest"},
+	}
+	for _, test := range tests {
+		got, err := parseDigest(test.in)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("parseDigest(%q) = %+v, %v, want %q", test.in, got, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("parseDigest(%q) = %+v, %v, want %+v", test.in, got, err, test.want)
+		}
+		// What is parsed is written back the same way
+		if again, _ := parseDigest(got.String()); again != got {
+			t.Errorf("%q does not survive String: %q", test.in, got.String())
+		}
+	}
+}
+
+func TestCompareDigests(t *testing.T) {
+	a, b := strings.Repeat("a", 64), strings.Repeat("b", 64)
+	tree := func(chunk int64, sum string) fileDigest {
+		return fileDigest{Format: treeHashFormat, ChunkSize: chunk, Hex: sum}
+	}
+	tests := []struct {
+		recorded, computed fileDigest
+		wantErr            string
+	}{
+		{fileDigest{Format: digestSHA256, Hex: a}, fileDigest{Format: digestSHA256, Hex: a}, ""},
+		{tree(1024, a), tree(1024, a), ""},
+		{fileDigest{Format: digestSHA256, Hex: a}, fileDigest{Format: digestSHA256, Hex: b}, "digest mismatch: recorded " + a + ", computed " + b},
+		{tree(1024, a), tree(1024, b), "digest mismatch: recorded gsn-tree-sha256:1024:" + a},
+		// The same content hashed another way is never a mismatch
+		{fileDigest{Format: digestSHA256, Hex: a}, tree(1024, a), "cannot compare a sha256 digest with a gsn-tree-sha256 one"},
+		{tree(1024, a), tree(2048, a), "cannot compare gsn-tree-sha256 digests of 1024 and 2048 byte chunks"},
+	}
+	for _, test := range tests {
+		err := compareDigests(test.recorded, test.computed)
+		if test.wantErr == "" && err != nil || test.wantErr != "" && (err == nil || !strings.HasPrefix(err.Error(), test.wantErr)) {
+			t.Errorf("compareDigests(%s, %s) = %v, want %q", test.recorded, test.computed, err, test.wantErr)
+		}
+	}
+}
+
+func TestReadChecksumFile(t *testing.T) {
+	a, b := strings.Repeat("a", 64), strings.Repeat("b", 64)
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"SHA256SUMS": a + "  notes.tar.gz\n" +
+		b + " *binary.tar\n" +
+		"gsn-tree-sha256:16777216:" + a + "  backup.tar.zst\n" +
+		"gsn-tree-sha999:1:" + a + "  future.tar\n"})
+	path := filepath.Join(dir, "SHA256SUMS")
+
+	tests := map[string]string{
+		"notes.tar.gz":   a,
+		"binary.tar":     b,
+		"backup.tar.zst": "gsn-tree-sha256:16777216:" + a,
+	}
+	for name, want := range tests {
+		if got, err := readChecksumFile(path, name); err != nil || got.String() != want {
+			t.Errorf("%s: %s, %v, want %s", name, got, err, want)
+		}
+	}
+	if _, err := readChecksumFile(path, "future.tar"); err == nil || !strings.Contains(err.Error(), "unknown digest format") {
+		t.Errorf("future.tar = %v", err)
+	}
+	if _, err := readChecksumFile(path, "tar.gz"); err == nil || !strings.Contains(err.Error(), "has no checksum for 'tar.gz'") {
+		t.Errorf("a name matching the end of a line = %v", err)
+	}
+}
+
+// BenchmarkTreeHash compares the serial SHA-256 of a 256 MiB file with its tree hash on one worker and on every
+// CPU. The tree hash only pays off with several CPUs; on one it costs the extra root hash.
+func BenchmarkTreeHash(b *testing.B) {
+	path, _ := randomFile(b, b.TempDir(), 256<<20, 1)
+
+	b.Run("sha256", func(b *testing.B) {
+		b.SetBytes(256 << 20)
+		for b.Loop() {
+			if _, err := hashFile(path); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	pools := []int{1}
+	if cpus := runtime.NumCPU(); cpus > 1 {
+		pools = append(pools, cpus)
+	}
+	for _, workers := range pools {
+		b.Run(fmt.Sprintf("tree-%d-workers", workers), func(b *testing.B) {
+			b.SetBytes(256 << 20)
+			for b.Loop() {
+				if _, err := treeHashFile(path, defaultTreeChunkSize, workers); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
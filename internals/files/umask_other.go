@@ -0,0 +1,10 @@
+//go:build !unix
+
+package files
+
+import "os"
+
+// processUmask reports no umask, other systems do not mask the modes of new files
+func processUmask() os.FileMode {
+	return 0
+}
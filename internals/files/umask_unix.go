@@ -0,0 +1,16 @@
+//go:build unix
+
+package files
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// processUmask returns the umask of the process. Reading it means setting it, so it is put back at once.
+func processUmask() os.FileMode {
+	mask := unix.Umask(0)
+	unix.Umask(mask)
+	return os.FileMode(mask)
+}
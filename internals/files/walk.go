@@ -0,0 +1,158 @@
+package files
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"gsn-dev-tools/internals/clierr"
+
+	"github.com/spf13/cobra"
+)
+
+// defaultWalkWorkers bounds how many directories are read concurrently, tuned for network filesystems
+const defaultWalkWorkers = 16
+
+// unlimitedDepth walks every level below the root
+const unlimitedDepth = -1
+
+// addDepthFlags registers --max-depth and its --top-level-only shorthand on a command walking a directory
+func addDepthFlags(cmd *cobra.Command) {
+	cmd.Flags().Int("max-depth", unlimitedDepth, "Stop the walk this many levels below the source, which is level 0; directories at the limit are kept empty")
+	cmd.Flags().Bool("top-level-only", false, "Only walk the immediate children of the source, like --max-depth 1")
+	cmd.MarkFlagsMutuallyExclusive("max-depth", "top-level-only")
+}
+
+// depthFromFlags returns the depth limit requested with --max-depth or --top-level-only, unlimitedDepth when
+// there is none
+func depthFromFlags(cmd *cobra.Command) (int, error) {
+	if topLevel, _ := cmd.Flags().GetBool("top-level-only"); topLevel {
+		return 1, nil
+	}
+	depth, _ := cmd.Flags().GetInt("max-depth")
+	if depth < unlimitedDepth {
+		return 0, clierr.Newf(clierr.Usage, "invalid --max-depth %d, it must be 0 or more", depth)
+	}
+	return depth, nil
+}
+
+// walkEntry is a single filesystem entry found by walkParallel
+type walkEntry struct {
+	Path string
+	Info fs.FileInfo
+}
+
+// dirTask is a directory waiting to be read and its depth below the root
+type dirTask struct {
+	dir   string
+	depth int
+}
+
+// dirResult is what a worker reports back after reading one directory
+type dirResult struct {
+	dir     string
+	depth   int
+	entries []walkEntry
+	subdirs []string
+	err     error
+}
+
+// walkParallel walks root with a bounded pool of workers, one task per directory.
+// Entries are returned sorted by path so callers aggregate deterministically regardless of scheduling.
+// Symlinks are not followed. Consumers that need the exact filepath.Walk visiting order
+// (such as archive writers) should keep using the sequential walk.
+// Entries more than maxDepth levels below root are not listed, the directories at maxDepth are not read;
+// unlimitedDepth walks the whole tree.
+func walkParallel(root string, workers int, maxDepth int) ([]walkEntry, error) {
+	rootInfo, err := os.Lstat(root)
+	if err != nil {
+		return nil, err
+	}
+	entries := []walkEntry{{Path: root, Info: rootInfo}}
+	if !rootInfo.IsDir() || maxDepth == 0 {
+		return entries, nil
+	}
+
+	if workers < 1 {
+		workers = defaultWalkWorkers
+	}
+
+	tasks := make(chan dirTask)
+	results := make(chan dirResult)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for task := range tasks {
+				res := readDirectory(task.dir)
+				res.depth = task.depth
+				results <- res
+			}
+		}()
+	}
+
+	// Dispatch directories from an unbounded queue so workers never block on each other
+	queue := []dirTask{{dir: root}}
+	inFlight := 0
+	var errs []dirResult
+	for len(queue) > 0 || inFlight > 0 {
+		var send chan dirTask
+		var next dirTask
+		if len(queue) > 0 {
+			send = tasks
+			next = queue[0]
+		}
+
+		select {
+		case send <- next:
+			queue = queue[1:]
+			inFlight++
+		case res := <-results:
+			inFlight--
+			if res.err != nil {
+				errs = append(errs, res)
+			}
+			entries = append(entries, res.entries...)
+			if maxDepth == unlimitedDepth || res.depth+1 < maxDepth {
+				for _, dir := range res.subdirs {
+					queue = append(queue, dirTask{dir: dir, depth: res.depth + 1})
+				}
+			}
+		}
+	}
+	close(tasks)
+
+	slices.SortFunc(entries, func(a, b walkEntry) int { return strings.Compare(a.Path, b.Path) })
+
+	if len(errs) > 0 {
+		sort.Slice(errs, func(i, j int) bool { return errs[i].dir < errs[j].dir })
+		return entries, errs[0].err
+	}
+	return entries, nil
+}
+
+// readDirectory lists a single directory and stats its children
+func readDirectory(dir string) dirResult {
+	res := dirResult{dir: dir}
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		res.err = err
+		return res
+	}
+
+	for _, de := range dirEntries {
+		path := filepath.Join(dir, de.Name())
+		info, err := de.Info()
+		if err != nil {
+			res.err = err
+			return res
+		}
+		res.entries = append(res.entries, walkEntry{Path: path, Info: info})
+		if de.IsDir() {
+			res.subdirs = append(res.subdirs, path)
+		}
+	}
+	return res
+}
@@ -0,0 +1,130 @@
+package files
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// sequentialWalk lists root with filepath.Walk in the order walkParallel returns its entries
+func sequentialWalk(t testing.TB, root string, maxDepth int) []string {
+	t.Helper()
+	var paths []string
+	err := filepath.Walk(root, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		depth := pathDepth(root, path)
+		if maxDepth != unlimitedDepth && depth > maxDepth {
+			return filepath.SkipDir
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+// writeWalkTree creates files spread over nested directories below root, dirs directories of perDir files
+func writeWalkTree(t testing.TB, root string, dirs int, perDir int) {
+	t.Helper()
+	for d := 0; d < dirs; d++ {
+		dir := filepath.Join(root, fmt.Sprintf("d%02d", d%10), fmt.Sprintf("sub%03d", d))
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		for f := 0; f < perDir; f++ {
+			if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("f%03d.txt", f)), []byte("x"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestWalkParallelMatchesSequentialWalk(t *testing.T) {
+	root := t.TempDir()
+	writeWalkTree(t, root, 40, 12)
+	writeTree(t, root, map[string]string{"a-b/c.txt": "c", "a/b/c.txt": "c", "empty/": ""})
+	if err := os.Symlink("a", filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, depth := range []int{unlimitedDepth, 0, 1, 2} {
+		for _, workers := range []int{1, 4, defaultWalkWorkers} {
+			entries, err := walkParallel(root, workers, depth)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range entries {
+				got = append(got, e.Path)
+			}
+			if want := sequentialWalk(t, root, depth); !slices.Equal(got, want) {
+				t.Errorf("depth %d, %d workers: walkParallel listed %d entries, filepath.Walk %d", depth, workers, len(got), len(want))
+			}
+		}
+	}
+}
+
+func TestWalkParallelDoesNotFollowSymlinks(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"dir/file.txt": "x"})
+	if err := os.Symlink("dir", filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := walkParallel(root, defaultWalkWorkers, unlimitedDepth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Path == filepath.Join(root, "link") && e.Info.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("link listed with mode %v, want a symlink", e.Info.Mode())
+		}
+		if filepath.Dir(e.Path) == filepath.Join(root, "link") {
+			t.Errorf("walked into the symlink: %s", e.Path)
+		}
+	}
+}
+
+func TestWalkParallelReportsMissingRoot(t *testing.T) {
+	if _, err := walkParallel(filepath.Join(t.TempDir(), "missing"), defaultWalkWorkers, unlimitedDepth); !os.IsNotExist(err) {
+		t.Errorf("walkParallel of a missing root = %v, want not exist", err)
+	}
+}
+
+func TestCopySourceSizeSkipsExcludedDirectories(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"keep.txt": "12345", "node_modules/pkg/index.js": "0123456789", "src/main.go": "abc"})
+	size, files, err := copySourceSize(root, archiveFilter{Excludes: []string{"node_modules"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 8 || files != 2 {
+		t.Errorf("copySourceSize = %d bytes in %d files, want 8 bytes in 2", size, files)
+	}
+}
+
+// BenchmarkWalk compares walkParallel with filepath.Walk over a tree of 50,000 files
+func BenchmarkWalk(b *testing.B) {
+	root := b.TempDir()
+	writeWalkTree(b, root, 500, 100)
+
+	b.Run("parallel", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := walkParallel(root, defaultWalkWorkers, unlimitedDepth); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("sequential", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			sequentialWalk(b, root, unlimitedDepth)
+		}
+	})
+}
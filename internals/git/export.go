@@ -0,0 +1,139 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/execx"
+	"gsn-dev-tools/internals/files"
+	"gsn-dev-tools/internals/style"
+	"gsn-dev-tools/internals/units"
+
+	"github.com/spf13/cobra"
+)
+
+func exportCmd() *cobra.Command {
+	var outputPath, format, prefix string
+	var excludes []string
+
+	exportCmd := &cobra.Command{
+		Use:   "export [ref]",
+		Short: "Writes the tree of a ref into an archive, like git archive",
+		Long: `Exports the committed tree of a ref, HEAD by default, into any archive format gsn cmp writes: tar.gz, tar,
+tar.zst or zip, picked from the extension of --output or with --format. Files marked export-ignore in
+.gitattributes are left out like git archive does, --exclude leaves out more with the globs of gsn cmp.
+
+Entries carry the commit time and no owner, so exporting the same commit twice gives identical archives.
+Uncommitted changes are never part of an export, gsn warns when the working tree has some and the ref is the
+checked out commit.`,
+		Example: `  gsn git export
+  gsn git export v1.2.0 -o release.tar.zst
+  gsn git export main --format zip --exclude 'docs/*'`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ref := "HEAD"
+			if len(args) == 1 {
+				ref = args[0]
+			}
+			startTime := time.Now()
+
+			ctx := cmd.Context()
+			r, err := openRepo(ctx)
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			commit, err := r.resolve(ctx, ref+"^{commit}")
+			if err == nil && commit == "" {
+				err = clierr.Newf(clierr.NotFound, "'%s' does not name a commit of this repository", ref)
+			}
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			r.warnUncommitted(ctx, commit)
+
+			name := exportName(r.Root, ref, commit)
+			if prefix == "" {
+				prefix = name
+			}
+			if outputPath == "" {
+				ext := ".tar.gz"
+				if format != "" {
+					ext = "." + strings.TrimPrefix(format, ".")
+				}
+				outputPath = name + ext
+			}
+
+			result, err := r.export(ctx, commit, outputPath, files.ExportOptions{Format: format, Prefix: prefix, Exclude: excludes})
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			fmt.Printf(style.Success()+"Exported %s (%s) to %s: %s entries, %s (Time: %s)\n", ref, commit[:12], result.ArchivePath,
+				units.FormatInt(int64(result.Entries)), units.FormatBytes(result.ArchiveSize), units.FormatDuration(time.Since(startTime)))
+			if result.Excluded > 0 {
+				fmt.Printf("%s entries excluded\n", units.FormatInt(int64(result.Excluded)))
+			}
+		},
+	}
+
+	exportCmd.Flags().StringVarP(&outputPath, "output", "o", "", "Archive to write (default: <repo>-<ref>.tar.gz)")
+	exportCmd.Flags().StringVar(&format, "format", "", "Archive format: tar.gz, tar, tar.zst or zip (default: from the --output extension)")
+	exportCmd.Flags().StringVar(&prefix, "prefix", "", "Directory the entries are stored below (default: <repo>-<ref>)")
+	exportCmd.Flags().StringSliceVar(&excludes, "exclude", nil, "Skip entries whose name or relative path matches this glob (repeatable)")
+	return exportCmd
+}
+
+// exportName names an export after the repository and the ref, or the short commit for HEAD
+func exportName(root string, ref string, commit string) string {
+	if ref == "HEAD" {
+		ref = commit[:12]
+	}
+	return filepath.Base(root) + "-" + strings.NewReplacer("/", "-", "\\", "-", ":", "-").Replace(ref)
+}
+
+// warnUncommitted warns when commit is the checked out one and the working tree differs from it, the export
+// holds the commit only
+func (r *repo) warnUncommitted(ctx context.Context, commit string) {
+	head, err := r.resolve(ctx, "HEAD^{commit}")
+	if err != nil || head != commit {
+		return
+	}
+	status, err := r.git(ctx, nil, "status", "--porcelain", "--untracked-files=no")
+	if err == nil && status != "" {
+		fmt.Fprintln(os.Stderr, style.Warning()+"The working tree has uncommitted changes, they are not part of the export")
+	}
+}
+
+// export streams git archive of commit into an archive written by the files package
+func (r *repo) export(ctx context.Context, commit string, outputPath string, opts files.ExportOptions) (*files.ExportResult, error) {
+	pr, pw := io.Pipe()
+	gitDone := make(chan error, 1)
+	go func() {
+		_, _, _, err := Runner.Run(ctx, "git", []string{"archive", "--format=tar", commit}, execx.Options{Dir: r.Root, Stdout: pw})
+		pw.CloseWithError(err)
+		gitDone <- err
+	}()
+
+	result, err := files.ExportTarStream(pr, outputPath, opts)
+	if err == nil {
+		// git pads the stream past the end of the tar, reading it lets git exit
+		io.Copy(io.Discard, pr)
+	}
+	// Unblocks git when the archive failed before reading the whole stream
+	pr.CloseWithError(err)
+	gitErr := <-gitDone
+	if err != nil {
+		return nil, err
+	}
+	if gitErr != nil {
+		// The tar ended but git failed, the archive is not trusted
+		os.Remove(result.ArchivePath)
+		return nil, gitErr
+	}
+	return result, nil
+}
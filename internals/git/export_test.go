@@ -0,0 +1,257 @@
+package git
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/execx"
+	"gsn-dev-tools/internals/files"
+)
+
+var exportFixture = map[string]string{
+	".gitattributes":    "secret/ export-ignore\n*.psd export-ignore\n",
+	"README.md":         "# repo\n",
+	"cmd/main.go":       "package main\n",
+	"docs/guide.md":     "# guide\n",
+	"art/logo.psd":      "psd",
+	"secret/key.txt":    "key",
+	"secret/nested/x":   "x",
+	"internal/a/a_test": "test",
+}
+
+// commitTime is the date isolateGit gives commits
+var commitTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// tarNames lists the entries of a tar.gz export with the content of its files
+func tarNames(t *testing.T, path string) map[string]string {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := make(map[string]string)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(tr)
+		entries[header.Name] = string(body)
+		if header.Uid != 0 || header.Gid != 0 || !header.ModTime.Equal(commitTime) {
+			t.Errorf("%s is owned by %d:%d at %v, want the commit time without an owner", header.Name, header.Uid, header.Gid, header.ModTime)
+		}
+	}
+}
+
+func exportHere(t *testing.T, rev string, output string, opts files.ExportOptions) (*files.ExportResult, error) {
+	t.Helper()
+	ctx := context.Background()
+	r, err := openRepo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := r.resolve(ctx, rev+"^{commit}")
+	if err != nil || commit == "" {
+		t.Fatalf("resolve(%s) = %q, %v", rev, commit, err)
+	}
+	return r.export(ctx, commit, output, opts)
+}
+
+func TestExportHonorsExportIgnore(t *testing.T) {
+	dir := fixtureRepo(t, exportFixture)
+	out := filepath.Join(t.TempDir(), "repo.tar.gz")
+
+	result, err := exportHere(t, "HEAD", out, files.ExportOptions{Prefix: "repo-1.0", Exclude: []string{"docs"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := tarNames(t, out)
+	var names []string
+	for name := range got {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	// Like with git archive, art/ stays behind empty when export-ignore matches its files rather than itself
+	want := []string{"repo-1.0/.gitattributes", "repo-1.0/README.md", "repo-1.0/art/", "repo-1.0/cmd/", "repo-1.0/cmd/main.go",
+		"repo-1.0/internal/", "repo-1.0/internal/a/", "repo-1.0/internal/a/a_test"}
+	if !slices.Equal(names, want) {
+		t.Errorf("export holds %q,\nwant %q", names, want)
+	}
+	if got["repo-1.0/cmd/main.go"] != "package main\n" {
+		t.Errorf("cmd/main.go = %q", got["repo-1.0/cmd/main.go"])
+	}
+	// The docs directory and its file
+	if result.Entries != len(want) || result.Excluded != 2 {
+		t.Errorf("result = %+v", result)
+	}
+
+	// Uncommitted changes are never exported
+	writeFiles(t, dir, map[string]string{"README.md": "changed\n", "untracked.txt": "x"})
+	if _, err := exportHere(t, "HEAD", out, files.ExportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := tarNames(t, out); got["README.md"] != "# repo\n" || got["untracked.txt"] != "" {
+		t.Errorf("export picked up the working tree: %q", got["README.md"])
+	}
+}
+
+func TestExportIsReproducible(t *testing.T) {
+	dir := fixtureRepo(t, exportFixture)
+	gitIn(t, dir, "tag", "v1.0")
+	writeFiles(t, dir, map[string]string{"README.md": "# repo 2\n"})
+	gitIn(t, dir, "commit", "--quiet", "-am", "second")
+
+	outDir := t.TempDir()
+	for _, ext := range []string{".tar.gz", ".tar", ".tar.zst", ".zip"} {
+		first, second := filepath.Join(outDir, "first"+ext), filepath.Join(outDir, "second"+ext)
+		for _, out := range []string{first, second} {
+			if _, err := exportHere(t, "v1.0", out, files.ExportOptions{Prefix: "repo"}); err != nil {
+				t.Fatalf("export to %s: %v", ext, err)
+			}
+		}
+		a, _ := os.ReadFile(first)
+		b, _ := os.ReadFile(second)
+		if len(a) == 0 || !bytes.Equal(a, b) {
+			t.Errorf("two %s exports of the same commit differ", ext)
+		}
+	}
+
+	// The tag exports its own commit, not HEAD
+	zr, err := zip.OpenReader(filepath.Join(outDir, "first.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		if f.Name == "repo/README.md" {
+			rc, _ := f.Open()
+			body, _ := io.ReadAll(rc)
+			rc.Close()
+			if string(body) != "# repo\n" {
+				t.Errorf("README.md of v1.0 = %q", body)
+			}
+		}
+	}
+}
+
+func TestExportFailuresLeaveNothing(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		output  string
+		format  string
+		wantErr string
+	}{
+		{"repo.rar", "", "cannot tell the archive format of"},
+		{"repo.gz", "", "--format gz holds a single file, export to tar.gz instead"},
+		{"repo.tar.gz", "bz2", "unsupported archive format 'bz2'"},
+	}
+	for _, test := range tests {
+		fake := &execx.Fake{}
+		fake.Expect("git", "archive", "--format=tar", "abc123").Return("", 0)
+		useRunner(t, fake)
+		r := &repo{Root: dir}
+		output := filepath.Join(dir, test.output)
+		if _, err := r.export(context.Background(), "abc123", output, files.ExportOptions{Format: test.format}); err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("export to %s = %v, want %q", test.output, err, test.wantErr)
+		}
+		// git has exited by the time export returns
+		if err := fake.Verify(); err != nil {
+			t.Errorf("export to %s: %v", test.output, err)
+		}
+	}
+	fake := &execx.Fake{}
+	fake.Expect("git", "archive", "--format=tar", "abc123").Return("", 0)
+	useRunner(t, fake)
+	if _, err := (&repo{Root: dir}).export(context.Background(), "abc123", filepath.Join(dir, "x.tar"), files.ExportOptions{Exclude: []string{"[a-"}}); clierr.CodeOf(err) != clierr.Usage {
+		t.Errorf("export with an invalid --exclude = %v", err)
+	}
+	if err := fake.Verify(); err != nil {
+		t.Errorf("export with an invalid --exclude: %v", err)
+	}
+
+	// git failing halfway leaves no archive behind
+	fake = &execx.Fake{}
+	fake.Expect("git", "archive", "--format=tar", "abc123").Return("", 128).Stderr("fatal: not a valid object name")
+	useRunner(t, fake)
+	if _, err := (&repo{Root: dir}).export(context.Background(), "abc123", filepath.Join(dir, "x.tar.gz"), files.ExportOptions{}); err == nil || !strings.Contains(err.Error(), "not a valid object name") {
+		t.Errorf("export with git failing = %v", err)
+	}
+	// or after writing a whole tar
+	var stream bytes.Buffer
+	tw := tar.NewWriter(&stream)
+	tw.WriteHeader(&tar.Header{Name: "README.md", Mode: 0o644, Size: 2})
+	tw.Write([]byte("x\n"))
+	tw.Close()
+	fake = &execx.Fake{}
+	fake.Expect("git", "archive", "--format=tar", "abc123").Return(stream.String(), 1).Stderr("fatal: early EOF")
+	useRunner(t, fake)
+	if _, err := (&repo{Root: dir}).export(context.Background(), "abc123", filepath.Join(dir, "x.tar"), files.ExportOptions{}); err == nil || !strings.Contains(err.Error(), "early EOF") {
+		t.Errorf("export with git failing after the tar = %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("failed exports left %d files", len(entries))
+	}
+}
+
+func TestExportName(t *testing.T) {
+	commit := "0123456789abcdef0123"
+	tests := map[string]string{
+		"HEAD":             "project-0123456789ab",
+		"v1.2.0":           "project-v1.2.0",
+		"feature/login":    "project-feature-login",
+		"origin/main:docs": "project-origin-main-docs",
+	}
+	for ref, want := range tests {
+		if got := exportName("/src/project", ref, commit); got != want {
+			t.Errorf("exportName(%s) = %q, want %q", ref, got, want)
+		}
+	}
+}
+
+func TestWarnUncommitted(t *testing.T) {
+	dir := fixtureRepo(t, map[string]string{"a.txt": "1"})
+	first := gitIn(t, dir, "rev-parse", "HEAD")
+	writeFiles(t, dir, map[string]string{"a.txt": "2"})
+	gitIn(t, dir, "commit", "--quiet", "-am", "second")
+	head := gitIn(t, dir, "rev-parse", "HEAD")
+
+	ctx := context.Background()
+	r, err := openRepo(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Untracked files are not uncommitted changes of the commit
+	writeFiles(t, dir, map[string]string{"untracked.txt": "x"})
+	if got := captureStderr(t, func() { r.warnUncommitted(ctx, head) }); got != "" {
+		t.Errorf("warning with only untracked files: %q", got)
+	}
+
+	writeFiles(t, dir, map[string]string{"a.txt": "3"})
+	if got := captureStderr(t, func() { r.warnUncommitted(ctx, head) }); !strings.Contains(got, "The working tree has uncommitted changes, they are not part of the export") {
+		t.Errorf("warning = %q", got)
+	}
+	// Another commit than the checked out one is unrelated to the working tree
+	if got := captureStderr(t, func() { r.warnUncommitted(ctx, first) }); got != "" {
+		t.Errorf("warning for another commit: %q", got)
+	}
+}
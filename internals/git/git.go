@@ -0,0 +1,83 @@
+// Package git implements gsn git, helpers on top of the git command line. Every git invocation goes through
+// Runner, so the plumbing can be exercised without a repository.
+package git
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/execx"
+
+	"github.com/spf13/cobra"
+)
+
+// Runner executes git, tests replace it with an execx.Fake
+var Runner execx.Runner = execx.Default
+
+func GitCmd() *cobra.Command {
+	gitCmd := &cobra.Command{
+		Use:   "git",
+		Short: "Snapshots work in progress and exports trees of a git repository",
+		Long: `Helpers around git for the repository in the working directory. wip records the working tree on a wip/<branch>
+ref without touching it or the index, export writes the tree of any ref into an archive like git archive does.`,
+		Example: `  gsn git wip
+  gsn git export v1.2.0 -o release.tar.zst`,
+	}
+
+	gitCmd.AddCommand(wipCmd())
+	gitCmd.AddCommand(exportCmd())
+	return gitCmd
+}
+
+// repo is a git repository found from the working directory
+type repo struct {
+	Root   string
+	GitDir string
+}
+
+// openRepo finds the repository the working directory belongs to
+func openRepo(ctx context.Context) (*repo, error) {
+	if _, err := Runner.LookPath("git"); err != nil {
+		return nil, fmt.Errorf("git is not installed or not on the PATH")
+	}
+	out, err := run(ctx, "", nil, "rev-parse", "--show-toplevel", "--absolute-git-dir")
+	if err != nil {
+		var exitErr *execx.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, clierr.Newf(clierr.Conflict, "the working directory is not inside a git work tree")
+		}
+		return nil, err
+	}
+	root, gitDir, ok := strings.Cut(out, "\n")
+	if !ok {
+		return nil, fmt.Errorf("unexpected output of git rev-parse: %q", out)
+	}
+	return &repo{Root: root, GitDir: gitDir}, nil
+}
+
+// git runs git at the top of the repository with env added to its environment
+func (r *repo) git(ctx context.Context, env []string, args ...string) (string, error) {
+	return run(ctx, r.Root, env, args...)
+}
+
+// resolve returns the object name of rev, empty when it does not exist
+func (r *repo) resolve(ctx context.Context, rev string) (string, error) {
+	out, err := r.git(ctx, nil, "rev-parse", "--verify", "--quiet", rev)
+	var exitErr *execx.ExitError
+	if errors.As(err, &exitErr) && exitErr.Code == 1 {
+		return "", nil
+	}
+	return out, err
+}
+
+// run runs git in dir and returns its trimmed output
+func run(ctx context.Context, dir string, env []string, args ...string) (string, error) {
+	out, _, _, err := Runner.Run(ctx, "git", args, execx.Options{Dir: dir, Env: env})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,146 @@
+package git
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/execx"
+)
+
+// isolateGit keeps the git of the tests away from the configuration of the machine and from repositories
+// around the temp dir, and gives commits a fixed author and date
+func isolateGit(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	for name, value := range map[string]string{
+		"GIT_CONFIG_GLOBAL":       os.DevNull,
+		"GIT_CONFIG_NOSYSTEM":     "1",
+		"GIT_CEILING_DIRECTORIES": filepath.Dir(t.TempDir()),
+		"GIT_AUTHOR_NAME":         "Test",
+		"GIT_AUTHOR_EMAIL":        "test@example.com",
+		"GIT_AUTHOR_DATE":         "2024-05-01T12:00:00Z",
+		"GIT_COMMITTER_NAME":      "Test",
+		"GIT_COMMITTER_EMAIL":     "test@example.com",
+		"GIT_COMMITTER_DATE":      "2024-05-01T12:00:00Z",
+	} {
+		t.Setenv(name, value)
+	}
+}
+
+// fixtureRepo creates a repository on main with one commit of files and makes it the working directory
+func fixtureRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+	isolateGit(t)
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	gitIn(t, dir, "init", "--quiet", "--initial-branch=main")
+	writeFiles(t, dir, files)
+	if len(files) > 0 {
+		gitIn(t, dir, "add", "--all")
+		gitIn(t, dir, "commit", "--quiet", "-m", "initial")
+	}
+	t.Chdir(dir)
+	return dir
+}
+
+// gitIn runs git in dir for the setup of a test and returns its trimmed output
+func gitIn(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// useRunner replaces Runner for the test
+func useRunner(t *testing.T, r execx.Runner) {
+	t.Helper()
+	saved := Runner
+	Runner = r
+	t.Cleanup(func() { Runner = saved })
+}
+
+func captureStderr(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = saved }()
+
+	done := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- string(data)
+	}()
+	f()
+	w.Close()
+	return <-done
+}
+
+func TestOpenRepo(t *testing.T) {
+	root := fixtureRepo(t, map[string]string{"README.md": "# repo\n", "sub/dir/file.txt": "x"})
+	t.Chdir(filepath.Join(root, "sub", "dir"))
+
+	r, err := openRepo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Root != root || r.GitDir != filepath.Join(root, ".git") {
+		t.Errorf("openRepo = %+v, want the root %s", r, root)
+	}
+}
+
+func TestOpenRepoOutsideRepositories(t *testing.T) {
+	isolateGit(t)
+	t.Chdir(t.TempDir())
+	if _, err := openRepo(context.Background()); clierr.CodeOf(err) != clierr.Conflict || !strings.Contains(err.Error(), "not inside a git work tree") {
+		t.Errorf("openRepo outside a repository = %v", err)
+	}
+
+	// Inside the .git directory there is no work tree either
+	root := fixtureRepo(t, map[string]string{"a": "a"})
+	t.Chdir(filepath.Join(root, ".git"))
+	if _, err := openRepo(context.Background()); clierr.CodeOf(err) != clierr.Conflict {
+		t.Errorf("openRepo inside .git = %v", err)
+	}
+}
+
+func TestOpenRepoWithoutGit(t *testing.T) {
+	fake := &execx.Fake{Paths: []string{}}
+	useRunner(t, fake)
+	if _, err := openRepo(context.Background()); err == nil || err.Error() != "git is not installed or not on the PATH" {
+		t.Errorf("openRepo without git = %v", err)
+	}
+	if calls := fake.Calls(); len(calls) != 0 {
+		t.Errorf("ran %v without git", calls)
+	}
+}
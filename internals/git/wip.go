@@ -0,0 +1,174 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/style"
+
+	"github.com/spf13/cobra"
+)
+
+// wipRefPrefix holds the snapshots, git resolves wip/<branch> to refs/wip/<branch> like it does for tags
+const wipRefPrefix = "refs/wip/"
+
+func wipCmd() *cobra.Command {
+	var message, name string
+
+	wipCmd := &cobra.Command{
+		Use:   "wip",
+		Short: "Snapshots the working tree on a wip/<branch> ref without touching it",
+		Long: `Commits the working tree, untracked files included and ignored files left out, onto refs/wip/<branch>. The
+working tree, the index and the branch stay as they are, so it is safe in the middle of any work. Every
+snapshot has the previous one and HEAD as parents: git log wip/<branch> lists them, and
+git restore --source wip/<branch> -- . brings the latest back.
+
+Nothing is recorded when the working tree matches the last snapshot, or HEAD when there is none. A detached
+HEAD has no branch to name the ref after, give one with --name.`,
+		Example: `  gsn git wip
+  gsn git wip -m "before the big refactor"
+  git log --stat wip/main`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := cmd.Context()
+			r, err := openRepo(ctx)
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			snapshot, err := r.snapshot(ctx, name, message)
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			if snapshot == nil {
+				fmt.Println(style.Success() + "Nothing to snapshot, the working tree has not changed")
+				return
+			}
+			fmt.Printf(style.Success()+"Saved %s as %s\n", snapshot.Ref, snapshot.Commit[:12])
+		},
+	}
+
+	wipCmd.Flags().StringVarP(&message, "message", "m", "", "Describe the snapshot in its commit message")
+	wipCmd.Flags().StringVar(&name, "name", "", "Name of the wip ref (default: the current branch)")
+	return wipCmd
+}
+
+// wipSnapshot is a commit recorded by gsn git wip
+type wipSnapshot struct {
+	Ref    string
+	Commit string
+}
+
+// snapshot commits the working tree onto the wip ref of the branch, built in a temporary index so the real one
+// is left alone. It returns nil when the tree has not changed since the last snapshot.
+func (r *repo) snapshot(ctx context.Context, name string, message string) (*wipSnapshot, error) {
+	branch := name
+	if branch == "" {
+		out, err := r.git(ctx, nil, "symbolic-ref", "--quiet", "--short", "HEAD")
+		if err != nil {
+			return nil, clierr.Newf(clierr.Conflict, "HEAD is detached, name the wip ref with --name")
+		}
+		branch = out
+	}
+	ref := wipRefPrefix + branch
+	if _, err := r.git(ctx, nil, "check-ref-format", ref); err != nil {
+		return nil, fmt.Errorf("'%s' is not a valid ref name", branch)
+	}
+
+	head, err := r.resolve(ctx, "HEAD^{commit}")
+	if err != nil {
+		return nil, err
+	}
+	previous, err := r.resolve(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	tree, err := r.workingTree(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// A tree matching the last snapshot, or HEAD without one, has nothing new to record
+	base := previous
+	if base == "" {
+		base = head
+	}
+	baseTree := ""
+	if base != "" {
+		if baseTree, err = r.git(ctx, nil, "rev-parse", base+"^{tree}"); err != nil {
+			return nil, err
+		}
+	} else if entries, err := r.git(ctx, nil, "ls-tree", tree); err != nil || entries == "" {
+		// A repository without commits and without files
+		return nil, err
+	}
+	if baseTree == tree {
+		return nil, nil
+	}
+
+	subject := fmt.Sprintf("wip on %s: %s", branch, time.Now().Format(time.DateTime))
+	if message != "" {
+		subject += "\n\n" + message
+	}
+	args := []string{"commit-tree", tree, "-m", subject}
+	for _, parent := range []string{previous, head} {
+		if parent != "" {
+			args = append(args, "-p", parent)
+		}
+	}
+	commit, err := r.git(ctx, nil, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	// The old value makes the update fail instead of dropping a snapshot taken concurrently
+	if _, err := r.git(ctx, nil, "update-ref", "-m", "gsn git wip", ref, commit, previous); err != nil {
+		return nil, err
+	}
+	return &wipSnapshot{Ref: strings.TrimPrefix(ref, "refs/"), Commit: commit}, nil
+}
+
+// workingTree writes the tree of the working tree, untracked files included, through a copy of the index
+func (r *repo) workingTree(ctx context.Context) (string, error) {
+	dir, err := os.MkdirTemp("", "gsn-wip-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	// Starting from the real index reuses its stat data, so only changed files are hashed again
+	index := filepath.Join(dir, "index")
+	if err := copyFile(filepath.Join(r.GitDir, "index"), index); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	env := []string{"GIT_INDEX_FILE=" + index}
+	if _, err := r.git(ctx, env, "add", "--all", "--", "."); err != nil {
+		return "", err
+	}
+	return r.git(ctx, env, "write-tree")
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
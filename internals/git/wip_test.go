@@ -0,0 +1,155 @@
+package git
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"gsn-dev-tools/internals/clierr"
+)
+
+// snapshotHere takes a snapshot of the repository in the working directory
+func snapshotHere(t *testing.T, name string, message string) (*wipSnapshot, error) {
+	t.Helper()
+	r, err := openRepo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r.snapshot(context.Background(), name, message)
+}
+
+// treeFiles lists the files of the tree of rev
+func treeFiles(t *testing.T, dir string, rev string) []string {
+	t.Helper()
+	return strings.Split(gitIn(t, dir, "ls-tree", "-r", "--name-only", rev), "\n")
+}
+
+func TestWipSnapshotLeavesTheWorkAlone(t *testing.T) {
+	dir := fixtureRepo(t, map[string]string{".gitignore": "*.log\n", "main.go": "package main\n", "staged.go": "package main\n"})
+	head := gitIn(t, dir, "rev-parse", "HEAD")
+
+	// Nothing changed since HEAD
+	if snapshot, err := snapshotHere(t, "", ""); snapshot != nil || err != nil {
+		t.Fatalf("snapshot of a clean tree = %+v, %v", snapshot, err)
+	}
+
+	writeFiles(t, dir, map[string]string{"main.go": "package main\n\nfunc main() {}\n", "staged.go": "package staged\n", "new.go": "package main\n", "debug.log": "noise"})
+	gitIn(t, dir, "add", "staged.go")
+	statusBefore := gitIn(t, dir, "status", "--porcelain")
+	indexBefore := gitIn(t, dir, "ls-files", "--stage")
+
+	snapshot, err := snapshotHere(t, "", "before the refactor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Ref != "wip/main" || snapshot.Commit != gitIn(t, dir, "rev-parse", "wip/main") {
+		t.Errorf("snapshot = %+v", snapshot)
+	}
+
+	// Untracked files are part of the snapshot, ignored ones are not
+	if got := treeFiles(t, dir, "wip/main"); !slices.Equal(got, []string{".gitignore", "main.go", "new.go", "staged.go"}) {
+		t.Errorf("snapshot holds %q", got)
+	}
+	if got := gitIn(t, dir, "show", "wip/main:main.go"); got != "package main\n\nfunc main() {}" {
+		t.Errorf("snapshot of main.go = %q", got)
+	}
+	message := gitIn(t, dir, "log", "-1", "--format=%s%n%b", "wip/main")
+	if !strings.HasPrefix(message, "wip on main: ") || !strings.Contains(message, "before the refactor") {
+		t.Errorf("message = %q", message)
+	}
+	if parents := gitIn(t, dir, "log", "-1", "--format=%P", "wip/main"); parents != head {
+		t.Errorf("parents = %q, want HEAD %s", parents, head)
+	}
+
+	// The branch, the index and the working tree are as they were
+	if got := gitIn(t, dir, "rev-parse", "HEAD"); got != head {
+		t.Errorf("HEAD moved to %s", got)
+	}
+	if got := gitIn(t, dir, "symbolic-ref", "HEAD"); got != "refs/heads/main" {
+		t.Errorf("HEAD = %s", got)
+	}
+	if got := gitIn(t, dir, "ls-files", "--stage"); got != indexBefore {
+		t.Errorf("index changed:\n%s\nwas\n%s", got, indexBefore)
+	}
+	if got := gitIn(t, dir, "status", "--porcelain"); got != statusBefore {
+		t.Errorf("status changed:\n%s\nwas\n%s", got, statusBefore)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "debug.log")); string(data) != "noise" {
+		t.Error("the ignored file was touched")
+	}
+}
+
+func TestWipSnapshotsChain(t *testing.T) {
+	dir := fixtureRepo(t, map[string]string{"a.txt": "1"})
+	head := gitIn(t, dir, "rev-parse", "HEAD")
+
+	writeFiles(t, dir, map[string]string{"a.txt": "2"})
+	first, err := snapshotHere(t, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The tree matches the last snapshot
+	if again, err := snapshotHere(t, "", ""); again != nil || err != nil {
+		t.Errorf("repeated snapshot = %+v, %v", again, err)
+	}
+
+	writeFiles(t, dir, map[string]string{"a.txt": "3"})
+	second, err := snapshotHere(t, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parents := gitIn(t, dir, "log", "-1", "--format=%P", "wip/main"); parents != first.Commit+" "+head {
+		t.Errorf("parents = %q, want the last snapshot then HEAD", parents)
+	}
+	if second.Commit == first.Commit || gitIn(t, dir, "show", "wip/main:a.txt") != "3" {
+		t.Error("the second snapshot did not record the change")
+	}
+
+	// Going back to HEAD is a change from the last snapshot
+	writeFiles(t, dir, map[string]string{"a.txt": "1"})
+	if back, err := snapshotHere(t, "", ""); back == nil || err != nil {
+		t.Errorf("snapshot matching HEAD after snapshots = %+v, %v", back, err)
+	}
+}
+
+func TestWipSnapshotNames(t *testing.T) {
+	dir := fixtureRepo(t, map[string]string{"a.txt": "1"})
+	gitIn(t, dir, "checkout", "--quiet", "--detach")
+	writeFiles(t, dir, map[string]string{"a.txt": "2"})
+
+	if _, err := snapshotHere(t, "", ""); clierr.CodeOf(err) != clierr.Conflict || !strings.Contains(err.Error(), "--name") {
+		t.Errorf("snapshot of a detached HEAD = %v", err)
+	}
+	if _, err := snapshotHere(t, "bad..name", ""); err == nil || err.Error() != "'bad..name' is not a valid ref name" {
+		t.Errorf("snapshot with an invalid name = %v", err)
+	}
+	snapshot, err := snapshotHere(t, "feature/x", "")
+	if err != nil || snapshot.Ref != "wip/feature/x" {
+		t.Fatalf("snapshot with --name = %+v, %v", snapshot, err)
+	}
+	if got := gitIn(t, dir, "rev-parse", "refs/wip/feature/x"); got != snapshot.Commit {
+		t.Errorf("refs/wip/feature/x = %s, want %s", got, snapshot.Commit)
+	}
+}
+
+func TestWipSnapshotWithoutCommits(t *testing.T) {
+	dir := fixtureRepo(t, nil)
+	if snapshot, err := snapshotHere(t, "", ""); snapshot != nil || err != nil {
+		t.Errorf("snapshot of an empty repository = %+v, %v", snapshot, err)
+	}
+
+	writeFiles(t, dir, map[string]string{"first.txt": "x"})
+	snapshot, err := snapshotHere(t, "", "")
+	if err != nil || snapshot == nil {
+		t.Fatalf("snapshot before the first commit = %+v, %v", snapshot, err)
+	}
+	if parents := gitIn(t, dir, "log", "-1", "--format=%P", "wip/main"); parents != "" {
+		t.Errorf("parents = %q, want none", parents)
+	}
+	if got := treeFiles(t, dir, "wip/main"); !slices.Equal(got, []string{"first.txt"}) {
+		t.Errorf("snapshot holds %q", got)
+	}
+}
@@ -0,0 +1,145 @@
+package hooks
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gsn-dev-tools/internals/config"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/execx"
+	"gsn-dev-tools/internals/notify"
+	"gsn-dev-tools/internals/style"
+
+	"github.com/spf13/cobra"
+)
+
+// defaultTimeout bounds hooks that do not set their own timeout
+const defaultTimeout = time.Minute
+
+// Runner executes every hook, tests replace it with an execx.Fake to assert commands and environment
+var Runner execx.Runner = execx.Default
+
+// invocation remembers the running command so post hooks receive the same context as pre hooks
+var invocation struct {
+	key      string
+	args     []string
+	disabled bool
+}
+
+// AddFlag registers the persistent --no-hooks flag on the root command
+func AddFlag(cmd *cobra.Command) {
+	cmd.PersistentFlags().Bool("no-hooks", false, "Do not run the pre/post hooks from the config file")
+}
+
+// Pre runs the configured pre hooks of cmd. The first failing hook aborts the command.
+func Pre(cmd *cobra.Command, args []string) error {
+	noHooks, _ := cmd.Flags().GetBool("no-hooks")
+	invocation.key = commandKey(cmd)
+	invocation.args = args
+	invocation.disabled = noHooks
+	if noHooks {
+		return nil
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	env := baseEnv("pre")
+	for _, hook := range cfg.Hooks[invocation.key].Pre {
+		if err := dryrun.Do(fmt.Sprintf("run the pre hook '%s'", hook.Run), func() error { return runHook(hook, env) }); err != nil {
+			return fmt.Errorf("pre hook '%s' failed: %w", hook.Run, err)
+		}
+	}
+	return nil
+}
+
+// Post runs the post_success or post_failure hooks matching ev.Status.
+// Failures only log a warning so they never change the command's exit code.
+func Post(ev notify.Event) {
+	if invocation.disabled || invocation.key == "" {
+		return
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, style.Warning()+"Skipping post hooks: %v\n", err)
+		return
+	}
+
+	hooks := cfg.Hooks[invocation.key].PostSuccess
+	phase := "post_success"
+	if ev.Status == notify.StatusFailure {
+		hooks = cfg.Hooks[invocation.key].PostFailure
+		phase = "post_failure"
+	}
+
+	env := append(baseEnv(phase), eventEnv(ev)...)
+	for _, hook := range hooks {
+		if err := dryrun.Do(fmt.Sprintf("run the %s hook '%s'", phase, hook.Run), func() error { return runHook(hook, env) }); err != nil {
+			fmt.Fprintf(os.Stderr, style.Warning()+"Post hook '%s' failed: %v\n", hook.Run, err)
+		}
+	}
+}
+
+// commandKey is the command path without the root command, e.g. "cmp" or "pr merge"
+func commandKey(cmd *cobra.Command) string {
+	fields := strings.Fields(cmd.CommandPath())
+	if len(fields) <= 1 {
+		return ""
+	}
+	return strings.Join(fields[1:], " ")
+}
+
+// baseEnv describes the running command to every hook
+func baseEnv(phase string) []string {
+	return []string{
+		"GSN_HOOK_PHASE=" + phase,
+		"GSN_HOOK_COMMAND=" + invocation.key,
+		"GSN_HOOK_ARGS=" + strings.Join(invocation.args, " "),
+	}
+}
+
+// eventEnv exposes the result fields of a finished command
+func eventEnv(ev notify.Event) []string {
+	env := []string{
+		"GSN_HOOK_STATUS=" + ev.Status,
+		"GSN_HOOK_DURATION_MS=" + strconv.FormatInt(ev.DurationMs, 10),
+		"GSN_HOOK_ERROR=" + ev.Error,
+		"GSN_HOOK_ARCHIVE_PATH=" + ev.ArchivePath,
+		"GSN_HOOK_ARCHIVE_SIZE=" + strconv.FormatInt(ev.ArchiveSize, 10),
+	}
+	for name, count := range ev.Counts {
+		env = append(env, "GSN_HOOK_COUNT_"+strings.ToUpper(name)+"="+strconv.Itoa(count))
+	}
+	return env
+}
+
+// runHook runs a single hook through Runner, bounded by its timeout
+func runHook(hook config.Hook, env []string) error {
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := runShell(ctx, hook.Run, env)
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+	return err
+}
+
+// runShell runs command through sh. Hook output goes to stderr so it never mixes with data
+// a command writes to stdout, such as `extract --stdout`.
+func runShell(ctx context.Context, command string, env []string) error {
+	_, _, _, err := Runner.Run(ctx, "sh", []string{"-c", command}, execx.Options{Env: env, Stdout: os.Stderr, Stderr: os.Stderr})
+	return err
+}
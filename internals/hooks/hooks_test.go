@@ -0,0 +1,232 @@
+package hooks
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"gsn-dev-tools/internals/execx"
+	"gsn-dev-tools/internals/notify"
+
+	"github.com/spf13/cobra"
+)
+
+// testConfig holds the hooks of every test, the config is loaded once per process
+const testConfig = `hooks:
+  cmp:
+    pre:
+      - git pull
+      - run: make assets
+        timeout: 5s
+    post_success: notify-send done
+    post_failure:
+      - notify-send failed
+      - logger failed
+  snap create:
+    pre:
+      - "false"
+      - echo never
+  du:
+    pre:
+      run: exec sleep 5
+      timeout: 100ms
+`
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "gsn-hooks-test-")
+	if err != nil {
+		panic(err)
+	}
+	config := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(config, []byte(testConfig), 0o600); err != nil {
+		panic(err)
+	}
+	os.Setenv("GSN_CONFIG", config)
+	os.Setenv("GSN_HOME", dir)
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// command returns the cobra command of path under a root with --no-hooks, its flags parsed from args
+func command(t *testing.T, path string, args ...string) *cobra.Command {
+	t.Helper()
+	root := &cobra.Command{Use: "gsn"}
+	AddFlag(root)
+	parent := root
+	for _, name := range strings.Fields(path) {
+		cmd := &cobra.Command{Use: name, Run: func(*cobra.Command, []string) {}}
+		parent.AddCommand(cmd)
+		parent = cmd
+	}
+	if err := parent.ParseFlags(args); err != nil {
+		t.Fatal(err)
+	}
+	return parent
+}
+
+// useRunner replaces Runner for the test
+func useRunner(t *testing.T, r execx.Runner) {
+	t.Helper()
+	saved := Runner
+	Runner = r
+	t.Cleanup(func() { Runner = saved })
+}
+
+// envOf returns the value of name in env
+func envOf(env []string, name string) string {
+	for _, kv := range env {
+		if v, ok := strings.CutPrefix(kv, name+"="); ok {
+			return v
+		}
+	}
+	return "<unset>"
+}
+
+func TestPreHooksRunInOrderWithContext(t *testing.T) {
+	fake := &execx.Fake{InOrder: true}
+	fake.Expect("sh", "-c", "git pull")
+	fake.Expect("sh", "-c", "make assets")
+	useRunner(t, fake)
+
+	if err := Pre(command(t, "cmp"), []string{"./project", "--manifest"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	for _, call := range fake.Calls() {
+		for name, want := range map[string]string{"GSN_HOOK_PHASE": "pre", "GSN_HOOK_COMMAND": "cmp", "GSN_HOOK_ARGS": "./project --manifest"} {
+			if got := envOf(call.Env, name); got != want {
+				t.Errorf("%s: %s = %q, want %q", call.Args[1], name, got, want)
+			}
+		}
+	}
+}
+
+func TestFailingPreHookAborts(t *testing.T) {
+	fake := &execx.Fake{InOrder: true}
+	fake.Expect("sh", "-c", "false").Return("", 1)
+	useRunner(t, fake)
+
+	err := Pre(command(t, "snap create"), nil)
+	if err == nil || !strings.Contains(err.Error(), "pre hook 'false' failed") {
+		t.Fatalf("Pre = %v, want the failing hook", err)
+	}
+	// The hook after the failing one does not run
+	if err := fake.Verify(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPostHooksMatchTheResult(t *testing.T) {
+	pre := &execx.Fake{}
+	pre.Expect("sh", "-c", execx.Any())
+	pre.Expect("sh", "-c", execx.Any())
+	useRunner(t, pre)
+	if err := Pre(command(t, "cmp"), []string{"./project"}); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &execx.Fake{InOrder: true}
+	fake.Expect("sh", "-c", "notify-send done")
+	fake.Expect("sh", "-c", "notify-send failed").Return("", 2)
+	fake.Expect("sh", "-c", "logger failed")
+	useRunner(t, fake)
+
+	success := notify.NewEvent("cmp", time.Now(), nil)
+	success.ArchivePath, success.ArchiveSize = "project.tar.gz", 2048
+	success.Counts = map[string]int{"files": 12}
+	Post(success)
+
+	// A failing post hook warns and the next one still runs
+	stderr := captureStderr(t, func() { Post(notify.NewEvent("cmp", time.Now(), os.ErrPermission)) })
+	if !strings.Contains(stderr, "Post hook 'notify-send failed' failed") {
+		t.Errorf("stderr = %q, want a warning", stderr)
+	}
+	if err := fake.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := fake.Calls()
+	done := calls[0].Env
+	for name, want := range map[string]string{
+		"GSN_HOOK_PHASE": "post_success", "GSN_HOOK_COMMAND": "cmp", "GSN_HOOK_ARGS": "./project", "GSN_HOOK_STATUS": notify.StatusSuccess,
+		"GSN_HOOK_ARCHIVE_PATH": "project.tar.gz", "GSN_HOOK_ARCHIVE_SIZE": "2048", "GSN_HOOK_COUNT_FILES": "12", "GSN_HOOK_ERROR": "",
+	} {
+		if got := envOf(done, name); got != want {
+			t.Errorf("post_success %s = %q, want %q", name, got, want)
+		}
+	}
+	failed := calls[2].Env
+	if envOf(failed, "GSN_HOOK_PHASE") != "post_failure" || envOf(failed, "GSN_HOOK_ERROR") != os.ErrPermission.Error() {
+		t.Errorf("post_failure env = %v", failed)
+	}
+}
+
+func TestNoHooks(t *testing.T) {
+	fake := &execx.Fake{}
+	useRunner(t, fake)
+
+	if err := Pre(command(t, "cmp", "--no-hooks"), nil); err != nil {
+		t.Fatal(err)
+	}
+	Post(notify.NewEvent("cmp", time.Now(), nil))
+	// A command without hooks runs none either
+	if err := Pre(command(t, "show"), nil); err != nil {
+		t.Fatal(err)
+	}
+	Post(notify.NewEvent("show", time.Now(), nil))
+	if calls := fake.Calls(); len(calls) > 0 {
+		t.Errorf("ran %v", calls)
+	}
+}
+
+func TestHookTimeout(t *testing.T) {
+	useRunner(t, execx.Default)
+	start := time.Now()
+	err := Pre(command(t, "du"), nil)
+	if err == nil || !strings.Contains(err.Error(), "timed out after 100ms") {
+		t.Errorf("Pre = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 4*time.Second {
+		t.Errorf("the hook ran for %v", elapsed)
+	}
+}
+
+func TestCommandKey(t *testing.T) {
+	for path, want := range map[string]string{"cmp": "cmp", "pr merge": "pr merge", "": ""} {
+		cmd := command(t, path)
+		if got := commandKey(cmd); got != want {
+			t.Errorf("commandKey(%q) = %q, want %q", path, got, want)
+		}
+	}
+	env := eventEnv(notify.Event{Counts: map[string]int{"renamed": 3}})
+	if !slices.Contains(env, "GSN_HOOK_COUNT_RENAMED=3") {
+		t.Errorf("eventEnv = %v", env)
+	}
+}
+
+// captureStderr runs f with os.Stderr sent to a temp file and returns what f wrote
+func captureStderr(t *testing.T, f func()) string {
+	t.Helper()
+	file, err := os.CreateTemp(t.TempDir(), "stderr-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	stderr := os.Stderr
+	os.Stderr = file
+	defer func() { os.Stderr = stderr }()
+
+	f()
+
+	data, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
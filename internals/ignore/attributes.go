@@ -0,0 +1,311 @@
+// Package ignore reads the files git uses to decide which paths of a work tree to leave out, so archives of a
+// work tree leave out what git archive would.
+package ignore
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gsn-dev-tools/internals/style"
+)
+
+// ExportIgnore is the attribute of the paths git archive leaves out
+const ExportIgnore = "export-ignore"
+
+// State is how a path has an attribute, see gitattributes(5)
+type State int
+
+const (
+	// Unspecified is the state of attributes no line mentions, or that a line reset with !name
+	Unspecified State = iota
+	// Set is the state of a bare name
+	Set
+	// Unset is the state of -name
+	Unset
+	// Valued is the state of name=value
+	Valued
+)
+
+// Rule is a line of a .gitattributes file: a pattern and the attributes of the paths it matches
+type Rule struct {
+	Pattern string
+	// Attrs maps the attribute names of the line to their state, Values the valued ones to their value
+	Attrs  map[string]State
+	Values map[string]string
+
+	match   func(rel string) bool
+	dirOnly bool
+}
+
+// ParseAttributes reads the lines of a .gitattributes file. Unlike .gitignore, a pattern cannot be negated: a
+// line starting with ! is ignored with a warning, as git does. Macro definitions ([attr]name) are skipped, only
+// the built-in binary macro is expanded.
+func ParseAttributes(r io.Reader, source string) ([]Rule, error) {
+	var rules []Rule
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "[attr]") {
+			continue
+		}
+
+		pattern, rest, err := splitPattern(line)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, style.Warning()+"%s:%d: %v\n", source, lineNo, err)
+			continue
+		}
+		if strings.HasPrefix(pattern, "!") {
+			fmt.Fprintf(os.Stderr, style.Warning()+"%s:%d: negative patterns are ignored in git attributes, use '\\!' for a literal leading !\n", source, lineNo)
+			continue
+		}
+
+		rule := Rule{Pattern: pattern, Attrs: make(map[string]State), Values: make(map[string]string)}
+		for _, attr := range strings.Fields(rest) {
+			switch {
+			case attr == "binary":
+				rule.Attrs["binary"] = Set
+				for _, name := range []string{"diff", "merge", "text"} {
+					rule.Attrs[name] = Unset
+				}
+			case strings.HasPrefix(attr, "-"):
+				rule.Attrs[attr[1:]] = Unset
+			case strings.HasPrefix(attr, "!"):
+				rule.Attrs[attr[1:]] = Unspecified
+			default:
+				if name, value, ok := strings.Cut(attr, "="); ok {
+					rule.Attrs[name], rule.Values[name] = Valued, value
+				} else {
+					rule.Attrs[attr] = Set
+				}
+			}
+		}
+		rule.match, rule.dirOnly = compilePattern(pattern)
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// splitPattern separates the pattern of a line from its attributes, unquoting a pattern in double quotes
+func splitPattern(line string) (string, string, error) {
+	if !strings.HasPrefix(line, `"`) {
+		pattern, rest, _ := strings.Cut(strings.ReplaceAll(line, "\t", " "), " ")
+		return pattern, rest, nil
+	}
+	quoted, err := strconv.QuotedPrefix(line)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid quoted pattern: %w", err)
+	}
+	pattern, err := strconv.Unquote(quoted)
+	return pattern, line[len(quoted):], err
+}
+
+// Matches reports whether the rule applies to rel, the slash separated path relative to the directory of the
+// .gitattributes file holding the rule
+func (r Rule) Matches(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	return r.match(rel)
+}
+
+// compilePattern turns a pattern into a matcher of paths relative to the directory of its file. A pattern
+// without a slash matches the name of a path at any depth, one with a slash the whole path, with ** matching
+// any number of directories. A trailing slash only matches directories.
+func compilePattern(pattern string) (func(rel string) bool, bool) {
+	dirOnly := strings.HasSuffix(pattern, "/")
+	pattern = strings.TrimSuffix(pattern, "/")
+	if !strings.Contains(pattern, "/") {
+		return func(rel string) bool {
+			matched, _ := path.Match(pattern, path.Base(rel))
+			return matched
+		}, dirOnly
+	}
+
+	segments := strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+	return func(rel string) bool {
+		return matchSegments(segments, strings.Split(rel, "/"))
+	}, dirOnly
+}
+
+// matchSegments matches the segments of a path against those of a pattern, a ** segment standing for zero or
+// more segments
+func matchSegments(pattern []string, names []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for skip := 0; skip <= len(names); skip++ {
+				if matchSegments(pattern[1:], names[skip:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(names) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(pattern[0], names[0]); !matched {
+			return false
+		}
+		pattern, names = pattern[1:], names[1:]
+	}
+	return len(names) == 0
+}
+
+// Attributes answers attribute lookups for the paths of a git work tree, reading the .gitattributes file of a
+// directory the first time a path below it is looked up. It is safe for concurrent use.
+type Attributes struct {
+	Root string
+
+	// info holds the rules of $GIT_DIR/info/attributes, which win over every .gitattributes file
+	info []Rule
+
+	mu   sync.Mutex
+	dirs map[string][]Rule
+}
+
+// WorkTree returns the root of the git work tree holding path, "" when it is in none
+func WorkTree(p string) string {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return ""
+	}
+	for dir := abs; ; dir = filepath.Dir(dir) {
+		if _, err := os.Lstat(filepath.Join(dir, ".git")); err == nil {
+			return dir
+		}
+		if dir == filepath.Dir(dir) {
+			return ""
+		}
+	}
+}
+
+// LoadAttributes prepares the attribute lookups of the work tree at root
+func LoadAttributes(root string) (*Attributes, error) {
+	a := &Attributes{Root: root, dirs: make(map[string][]Rule)}
+	gitDir, err := resolveGitDir(root)
+	if err != nil {
+		return nil, err
+	}
+	infoPath := filepath.Join(gitDir, "info", "attributes")
+	if a.info, err = readRules(infoPath); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// resolveGitDir returns the directory holding info/attributes: .git itself, or the common directory of a
+// linked work tree whose .git file points to its own git directory
+func resolveGitDir(root string) (string, error) {
+	dotGit := filepath.Join(root, ".git")
+	info, err := os.Stat(dotGit)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return dotGit, nil
+	}
+	data, err := os.ReadFile(dotGit)
+	if err != nil {
+		return "", err
+	}
+	gitDir, ok := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir:")
+	if !ok {
+		return "", fmt.Errorf("'%s' does not point to a git directory", dotGit)
+	}
+	gitDir = strings.TrimSpace(gitDir)
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(root, gitDir)
+	}
+	if common, err := os.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+		commonDir := strings.TrimSpace(string(common))
+		if !filepath.IsAbs(commonDir) {
+			commonDir = filepath.Join(gitDir, commonDir)
+		}
+		return commonDir, nil
+	}
+	return gitDir, nil
+}
+
+// readRules parses an attributes file, a missing one has no rules
+func readRules(p string) ([]Rule, error) {
+	file, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseAttributes(file, p)
+}
+
+// rulesOf returns the rules of the .gitattributes file in dir, slash separated and relative to the root
+func (a *Attributes) rulesOf(dir string) []Rule {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	rules, ok := a.dirs[dir]
+	if !ok {
+		var err error
+		p := filepath.Join(a.Root, filepath.FromSlash(dir), ".gitattributes")
+		if rules, err = readRules(p); err != nil {
+			fmt.Fprintf(os.Stderr, style.Warning()+"Ignoring %s: %v\n", p, err)
+		}
+		a.dirs[dir] = rules
+	}
+	return rules
+}
+
+// Lookup returns the state and value of the attribute name for p. As in git, the last line mentioning name
+// among those matching p wins: lines of a deeper .gitattributes come after those of the directories above it,
+// and $GIT_DIR/info/attributes comes last. A path outside the work tree has no attributes.
+func (a *Attributes) Lookup(p string, isDir bool, name string) (State, string) {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return Unspecified, ""
+	}
+	rel, err := filepath.Rel(a.Root, abs)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return Unspecified, ""
+	}
+	rel = filepath.ToSlash(rel)
+
+	state, value := Unspecified, ""
+	apply := func(rules []Rule, relToDir string) {
+		for _, rule := range rules {
+			if s, ok := rule.Attrs[name]; ok && rule.Matches(relToDir, isDir) {
+				state, value = s, rule.Values[name]
+			}
+		}
+	}
+	dirs := []string{}
+	for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+		dirs = append(dirs, dir)
+	}
+	dirs = append(dirs, "")
+	slices.Reverse(dirs)
+	for _, dir := range dirs {
+		relToDir := rel
+		if dir != "" {
+			relToDir = strings.TrimPrefix(rel, dir+"/")
+		}
+		apply(a.rulesOf(dir), relToDir)
+	}
+	apply(a.info, rel)
+	return state, value
+}
+
+// ExportIgnored reports whether git archive leaves p out, i.e. p has the export-ignore attribute set. The
+// directories above p are not looked at, a walk skips the contents of an ignored directory.
+func (a *Attributes) ExportIgnored(p string, isDir bool) bool {
+	state, _ := a.Lookup(p, isDir, ExportIgnore)
+	return state == Set
+}
@@ -0,0 +1,345 @@
+package ignore
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fixtureAttributes is the .gitattributes of the fixture repo, covering each kind of pattern
+const fixtureAttributes = `# Comments and blank lines are skipped
+
+*.log export-ignore
+docs export-ignore
+build/ export-ignore
+/TODO export-ignore
+tests/** export-ignore
+vendor/**/v.go export-ignore
+!README.md export-ignore
+"with space.md" export-ignore
+\!bang.txt export-ignore
+*.bin binary
+[attr]mine export-ignore
+`
+
+// fixtureFiles is the fixture repo, with what git archive leaves out of it
+var fixtureFiles = map[string]bool{
+	"README.md":              false, // the negative pattern is ignored, not read as README.md
+	"app.log":                true,
+	"src/debug.log":          true,
+	"src/main.go":            false,
+	"docs/guide.md":          true,  // a directory matched by name
+	"src/docs/api.md":        true,  // at any depth
+	"build/out.o":            true,  // a pattern ending in / matches the directory
+	"src/build/out.o":        true,  // at any depth too
+	"src/build.go":           false, // but not a file
+	"TODO":                   true,
+	"src/TODO":               false, // /TODO is anchored to the root
+	"tests/unit/a_test.go":   true,
+	"src/tests/b_test.go":    false, // tests/** is anchored, it holds a slash
+	"vendor/v.go":            true,  // ** matches no directory
+	"vendor/a/b/v.go":        true,
+	"vendor/a/w.go":          false,
+	"with space.md":          true,
+	"!bang.txt":              true,
+	"data.bin":               false,
+	"sub/local.txt":          true,  // from sub/.gitattributes
+	"local.txt":              false, // which only applies below sub
+	"sub/special.log":        false, // !export-ignore in sub/.gitattributes resets *.log
+	"sub/other.log":          true,
+	"sub/deeper/special.log": false,
+	"wanted.log":             false, // info/attributes wins over every .gitattributes
+	"src/secret.txt":         true,  // from info/attributes only
+}
+
+const subAttributes = `local.txt export-ignore
+special.log !export-ignore
+`
+
+const infoAttributes = `wanted.log -export-ignore
+src/secret.txt export-ignore
+`
+
+// writeFixture lays the fixture repo out in dir, its git directory holding only info/attributes
+func writeFixture(t *testing.T, dir string) {
+	t.Helper()
+	files := map[string]string{
+		".gitattributes":       fixtureAttributes,
+		"sub/.gitattributes":   subAttributes,
+		".git/info/attributes": infoAttributes,
+	}
+	for name := range fixtureFiles {
+		files[name] = name + "\n"
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// exported walks root like the archive commands do, skipping .git and the contents of ignored directories,
+// and returns the slash separated files left in
+func exported(t *testing.T, a *Attributes, root string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.WalkDir(root, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil || p == root {
+			return err
+		}
+		if entry.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if a.ExportIgnored(p, entry.IsDir()) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.IsDir() && entry.Name() != ".gitattributes" {
+			rel, _ := filepath.Rel(root, p)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(files)
+	return files
+}
+
+// keptFixtureFiles is the sorted list of the fixture files git archive keeps
+func keptFixtureFiles() []string {
+	var kept []string
+	for name, ignored := range fixtureFiles {
+		if !ignored {
+			kept = append(kept, name)
+		}
+	}
+	slices.Sort(kept)
+	return kept
+}
+
+func captureStderr(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = saved }()
+
+	done := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- string(data)
+	}()
+	f()
+	w.Close()
+	return <-done
+}
+
+func TestParseAttributes(t *testing.T) {
+	var rules []Rule
+	stderr := captureStderr(t, func() {
+		var err error
+		rules, err = ParseAttributes(strings.NewReader(fixtureAttributes+"*.go text eol=lf -diff !merge\n\"bad\n"), ".gitattributes")
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	var patterns []string
+	for _, rule := range rules {
+		patterns = append(patterns, rule.Pattern)
+	}
+	want := []string{"*.log", "docs", "build/", "/TODO", "tests/**", "vendor/**/v.go", "with space.md", `\!bang.txt`, "*.bin", "*.go"}
+	if !slices.Equal(patterns, want) {
+		t.Errorf("patterns = %q, want %q", patterns, want)
+	}
+	if !strings.Contains(stderr, ".gitattributes:9: negative patterns are ignored") || !strings.Contains(stderr, ".gitattributes:15: invalid quoted pattern") {
+		t.Errorf("warnings:\n%s", stderr)
+	}
+
+	// The binary macro expands, the states of a line are kept apart
+	binary := rules[8].Attrs
+	if binary["binary"] != Set || binary["diff"] != Unset || binary["merge"] != Unset || binary["text"] != Unset {
+		t.Errorf("binary = %v", binary)
+	}
+	goRule := rules[9]
+	if goRule.Attrs["text"] != Set || goRule.Attrs["eol"] != Valued || goRule.Values["eol"] != "lf" || goRule.Attrs["diff"] != Unset || goRule.Attrs["merge"] != Unspecified {
+		t.Errorf("*.go = %v %v", goRule.Attrs, goRule.Values)
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		rel     string
+		isDir   bool
+		want    bool
+	}{
+		{"*.log", "a.log", false, true},
+		{"*.log", "deep/down/a.log", false, true},
+		{"*.log", "a.log/b", false, false},
+		{"build/", "build", true, true},
+		{"build/", "build", false, false},
+		{"/TODO", "TODO", false, true},
+		{"/TODO", "src/TODO", false, false},
+		{"src/*.go", "src/main.go", false, true},
+		{"src/*.go", "src/sub/main.go", false, false},
+		{"a/**/b", "a/b", false, true},
+		{"a/**/b", "a/x/y/b", false, true},
+		{"a/**/b", "a/x/y/c", false, false},
+		{"**/b", "x/y/b", false, true},
+	}
+	for _, test := range tests {
+		rules, err := ParseAttributes(strings.NewReader(test.pattern+" export-ignore\n"), "test")
+		if err != nil || len(rules) != 1 {
+			t.Fatalf("%q: %v, %v", test.pattern, rules, err)
+		}
+		if got := rules[0].Matches(test.rel, test.isDir); got != test.want {
+			t.Errorf("%q matches %q (dir %v) = %v, want %v", test.pattern, test.rel, test.isDir, got, test.want)
+		}
+	}
+}
+
+// TestExportIgnoredFixture pins the paths gsn leaves out of the fixture repo
+func TestExportIgnoredFixture(t *testing.T) {
+	root := t.TempDir()
+	writeFixture(t, root)
+	a, err := LoadAttributes(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	captureStderr(t, func() {
+		if got, want := exported(t, a, root), keptFixtureFiles(); !slices.Equal(got, want) {
+			t.Errorf("exported =\n%q\nwant\n%q", got, want)
+		}
+	})
+
+	// The root and paths outside the work tree have no attributes
+	if a.ExportIgnored(root, true) || a.ExportIgnored(filepath.Join(filepath.Dir(root), "app.log"), false) {
+		t.Error("a path outside the work tree is ignored")
+	}
+}
+
+// TestExportIgnoredMatchesGitArchive checks the fixture repo against what git archive leaves out of it
+func TestExportIgnoredMatchesGitArchive(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	for name, value := range map[string]string{
+		"GIT_CONFIG_GLOBAL":       os.DevNull,
+		"GIT_CONFIG_NOSYSTEM":     "1",
+		"GIT_CEILING_DIRECTORIES": filepath.Dir(t.TempDir()),
+		"GIT_AUTHOR_NAME":         "Test",
+		"GIT_AUTHOR_EMAIL":        "test@example.com",
+		"GIT_COMMITTER_NAME":      "Test",
+		"GIT_COMMITTER_EMAIL":     "test@example.com",
+	} {
+		t.Setenv(name, value)
+	}
+	root := t.TempDir()
+	git := func(args ...string) []byte {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = root
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, stderr.String())
+		}
+		return out
+	}
+	git("init", "--quiet")
+	writeFixture(t, root)
+	git("add", "--all")
+	git("commit", "--quiet", "-m", "fixture")
+
+	var archived []string
+	reader := tar.NewReader(bytes.NewReader(git("archive", "--format=tar", "HEAD")))
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg && path.Base(header.Name) != ".gitattributes" {
+			archived = append(archived, header.Name)
+		}
+	}
+	slices.Sort(archived)
+
+	a, err := LoadAttributes(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	captureStderr(t, func() {
+		if got := exported(t, a, root); !slices.Equal(got, archived) {
+			t.Errorf("gsn keeps\n%q\ngit archive keeps\n%q", got, archived)
+		}
+	})
+}
+
+// TestLinkedWorkTree reads info/attributes from the common directory of a linked work tree
+func TestLinkedWorkTree(t *testing.T) {
+	base := t.TempDir()
+	common := filepath.Join(base, "main", ".git")
+	linked := filepath.Join(common, "worktrees", "feature")
+	tree := filepath.Join(base, "feature")
+	for _, dir := range []string{filepath.Join(common, "info"), linked, filepath.Join(tree, "src")} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		filepath.Join(common, "info", "attributes"): "*.secret export-ignore\n",
+		filepath.Join(linked, "commondir"):          "../..\n",
+		filepath.Join(tree, ".git"):                 "gitdir: " + linked + "\n",
+		filepath.Join(tree, "src", "key.secret"):    "",
+	}
+	for p, content := range files {
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := WorkTree(filepath.Join(tree, "src", "key.secret")); got != tree {
+		t.Errorf("WorkTree = %q, want %q", got, tree)
+	}
+	a, err := LoadAttributes(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.ExportIgnored(filepath.Join(tree, "src", "key.secret"), false) {
+		t.Error("the info/attributes of the common directory were not read")
+	}
+
+	if err := os.WriteFile(filepath.Join(tree, ".git"), []byte("not a pointer\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadAttributes(tree); err == nil || !strings.Contains(err.Error(), "does not point to a git directory") {
+		t.Errorf("LoadAttributes of a broken .git file = %v", err)
+	}
+	if got := WorkTree(base); got != "" {
+		t.Errorf("WorkTree outside any work tree = %q", got)
+	}
+}
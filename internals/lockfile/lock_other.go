@@ -0,0 +1,12 @@
+//go:build !unix && !windows
+
+package lockfile
+
+import "os"
+
+// tryLock always succeeds where the OS has no file locks, gsn processes are not kept apart there
+func tryLock(f *os.File) (bool, error) {
+	return true, nil
+}
+
+func unlock(f *os.File) {}
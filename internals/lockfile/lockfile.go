@@ -0,0 +1,96 @@
+// Package lockfile keeps gsn processes from changing the same state at once. A lock is an OS lock on a lock file,
+// flock on Unix and LockFileEx on Windows, so it goes away with the process holding it: a lock left behind by a
+// killed gsn is free again right away and the lock file is simply reused. The file records the pid of the holder
+// for the error of a process that gave up waiting.
+package lockfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+)
+
+// DefaultWait is how long a command waits for another gsn process to release a lock
+var DefaultWait = 10 * time.Second
+
+// retryInterval is how often a held lock is tried again while waiting
+const retryInterval = 50 * time.Millisecond
+
+// HeldError reports a lock another process still held when the wait ran out
+type HeldError struct {
+	// Target is what the lock protects, a state file or a directory
+	Target string
+	// PID is the holder recorded in the lock file, 0 when it could not be read
+	PID int
+}
+
+func (e *HeldError) Error() string {
+	if e.PID == 0 {
+		return fmt.Sprintf("another gsn process holds the lock on '%s', try again once it is done", e.Target)
+	}
+	return fmt.Sprintf("another gsn process holds the lock on '%s' (pid %d), try again once it is done", e.Target, e.PID)
+}
+
+// ExitCode reports a held lock as a Conflict
+func (e *HeldError) ExitCode() clierr.Code {
+	return clierr.Conflict
+}
+
+// Acquire takes the exclusive lock of the lock file at path, creating it and its directory, waiting up to wait
+// for another process to release it. target names what the lock protects in errors. The returned func releases
+// the lock; the lock file stays, removing it would let a waiting process lock a file already replaced.
+func Acquire(path string, target string, wait time.Duration) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(wait)
+	for {
+		locked, err := tryLock(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to lock '%s': %w", target, err)
+		}
+		if locked {
+			break
+		}
+		if time.Now().After(deadline) {
+			pid := holder(path)
+			f.Close()
+			return nil, &HeldError{Target: target, PID: pid}
+		}
+		time.Sleep(retryInterval)
+	}
+
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return func() {
+		unlock(f)
+		f.Close()
+	}, nil
+}
+
+// ForFile locks a state file through <path>.lock next to it, waiting DefaultWait
+func ForFile(path string) (func(), error) {
+	return Acquire(path+".lock", path, DefaultWait)
+}
+
+// holder reads the pid recorded in a lock file
+func holder(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid
+}
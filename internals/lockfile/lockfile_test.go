@@ -0,0 +1,237 @@
+//go:build unix || windows
+
+package lockfile
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+)
+
+// TestMain lets a test start the test binary again as a child process working on GSN_LOCKFILE_PATH: "hold" takes
+// the lock, prints its pid and waits to be killed, "count" adds one to the counter next to the lock
+// GSN_LOCKFILE_TIMES times
+func TestMain(m *testing.M) {
+	path := os.Getenv("GSN_LOCKFILE_PATH")
+	switch os.Getenv("GSN_LOCKFILE_CHILD") {
+	case "hold":
+		if _, err := Acquire(path, "counter", time.Minute); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println(os.Getpid())
+		time.Sleep(time.Minute)
+		os.Exit(0)
+	case "count":
+		times, _ := strconv.Atoi(os.Getenv("GSN_LOCKFILE_TIMES"))
+		for range times {
+			if err := increment(path, time.Minute); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// increment adds one to the counter file next to the lock at path under the lock. The read and the write are
+// apart long enough for an unlocked writer to lose updates.
+func increment(path string, wait time.Duration) error {
+	unlock, err := Acquire(path, "counter", wait)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	counter := strings.TrimSuffix(path, ".lock")
+	data, err := os.ReadFile(counter)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	time.Sleep(time.Millisecond)
+	return os.WriteFile(counter, []byte(strconv.Itoa(n+1)), 0o600)
+}
+
+func readCounter(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := strconv.Atoi(string(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// startChild starts the test binary as a child process in mode on the lock at path
+func startChild(t *testing.T, mode string, path string, env ...string) (*exec.Cmd, *bufio.Reader) {
+	t.Helper()
+	child := exec.Command(os.Args[0])
+	child.Env = append(os.Environ(), append([]string{"GSN_LOCKFILE_CHILD=" + mode, "GSN_LOCKFILE_PATH=" + path}, env...)...)
+	stdout, err := child.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := child.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = child.Process.Kill(); _ = child.Wait() })
+	return child, bufio.NewReader(stdout)
+}
+
+func TestAcquireSerializesGoroutines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "counter.lock")
+	const workers, times = 8, 10
+
+	var holders, most atomic.Int32
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range times {
+				unlock, err := Acquire(path, "counter", time.Minute)
+				if err != nil {
+					errs <- err
+					return
+				}
+				n := holders.Add(1)
+				for m := most.Load(); n > m && !most.CompareAndSwap(m, n); m = most.Load() {
+				}
+				holders.Add(-1)
+				unlock()
+
+				if err := increment(path, time.Minute); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	if most.Load() != 1 {
+		t.Errorf("%d goroutines held the lock at once", most.Load())
+	}
+	if n := readCounter(t, filepath.Join(filepath.Dir(path), "counter")); n != workers*times {
+		t.Errorf("counter = %d, want %d, updates were lost", n, workers*times)
+	}
+}
+
+func TestAcquireSerializesProcesses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counter.lock")
+	const processes, times = 4, 25
+
+	var children []*exec.Cmd
+	for range processes {
+		child, _ := startChild(t, "count", path, "GSN_LOCKFILE_TIMES="+strconv.Itoa(times))
+		children = append(children, child)
+	}
+	for _, child := range children {
+		if err := child.Wait(); err != nil {
+			t.Fatalf("child %d: %v", child.Process.Pid, err)
+		}
+	}
+	if n := readCounter(t, filepath.Join(filepath.Dir(path), "counter")); n != processes*times {
+		t.Errorf("counter = %d, want %d, updates were lost", n, processes*times)
+	}
+}
+
+func TestAcquireGivesUpOnAHeldLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.json.lock")
+	unlock, err := Acquire(path, "index.json", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	_, err = Acquire(path, "index.json", 200*time.Millisecond)
+	var held *HeldError
+	if !errors.As(err, &held) || held.PID != os.Getpid() || held.Target != "index.json" {
+		t.Fatalf("Acquire on a held lock = %v", err)
+	}
+	if waited := time.Since(start); waited < 200*time.Millisecond || waited > 5*time.Second {
+		t.Errorf("gave up after %v, want the 200ms wait", waited)
+	}
+	if want := fmt.Sprintf("another gsn process holds the lock on 'index.json' (pid %d), try again once it is done", os.Getpid()); err.Error() != want {
+		t.Errorf("error = %q, want %q", err, want)
+	}
+	if code := clierr.CodeOf(err); code != clierr.Conflict {
+		t.Errorf("exit code = %d, want %d", code, clierr.Conflict)
+	}
+
+	// Released, the lock is taken right away and the file reused
+	unlock()
+	unlock, err = Acquire(path, "index.json", 0)
+	if err != nil {
+		t.Fatalf("Acquire once released = %v", err)
+	}
+	unlock()
+}
+
+func TestLockDiesWithItsProcess(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.lock")
+	child, stdout := startChild(t, "hold", path)
+	line, err := stdout.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid, _ := strconv.Atoi(strings.TrimSpace(line)); pid != child.Process.Pid {
+		t.Fatalf("child printed %q", line)
+	}
+
+	_, err = Acquire(path, "queue", 100*time.Millisecond)
+	var held *HeldError
+	if !errors.As(err, &held) || held.PID != child.Process.Pid {
+		t.Fatalf("Acquire while the child holds the lock = %v, want the child's pid %d", err, child.Process.Pid)
+	}
+
+	// A killed holder releases nothing itself, the lock goes with the process
+	if err := child.Process.Kill(); err != nil {
+		t.Fatal(err)
+	}
+	_ = child.Wait()
+	unlock, err := Acquire(path, "queue", time.Second)
+	if err != nil {
+		t.Fatalf("Acquire after the holder was killed = %v", err)
+	}
+	defer unlock()
+	if pid := holder(path); pid != os.Getpid() {
+		t.Errorf("lock file records pid %d, want %d", pid, os.Getpid())
+	}
+}
+
+func TestForFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backups", "index.json")
+	unlock, err := ForFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+	if _, err := os.Stat(path + ".lock"); err != nil {
+		t.Errorf("no lock file next to the state file: %v", err)
+	}
+	if _, err := Acquire(path+".lock", path, 0); err == nil || !strings.Contains(err.Error(), "'"+path+"'") {
+		t.Errorf("Acquire on the lock of ForFile = %v", err)
+	}
+}
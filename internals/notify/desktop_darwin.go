@@ -0,0 +1,14 @@
+//go:build darwin
+
+package notify
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// sendDesktop shows a best-effort desktop notification through osascript
+func sendDesktop(ev Event) error {
+	script := fmt.Sprintf("display notification %q with title %q", Summary(ev), "gsn "+ev.Command)
+	return exec.Command("osascript", "-e", script).Run()
+}
@@ -0,0 +1,10 @@
+//go:build linux
+
+package notify
+
+import "os/exec"
+
+// sendDesktop shows a best-effort desktop notification through notify-send
+func sendDesktop(ev Event) error {
+	return exec.Command("notify-send", "gsn "+ev.Command, Summary(ev)).Run()
+}
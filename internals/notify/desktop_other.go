@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package notify
+
+// sendDesktop is a no-op on platforms without a supported notifier
+func sendDesktop(ev Event) error {
+	return nil
+}
@@ -0,0 +1,151 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/style"
+	"gsn-dev-tools/internals/units"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	StatusSuccess = "success"
+	StatusFailure = "failure"
+)
+
+// Event is the payload sent to a notification target once a long running command finishes
+type Event struct {
+	Command     string         `json:"command"`
+	Status      string         `json:"status"`
+	DurationMs  int64          `json:"duration_ms"`
+	ArchivePath string         `json:"archive_path,omitempty"`
+	ArchiveSize int64          `json:"archive_size,omitempty"`
+	Counts      map[string]int `json:"counts,omitempty"`
+	Error       string         `json:"error,omitempty"`
+}
+
+// NewEvent builds an event for the given command, deriving the status from err
+func NewEvent(command string, startTime time.Time, err error) Event {
+	ev := Event{
+		Command:    command,
+		Status:     StatusSuccess,
+		DurationMs: time.Since(startTime).Milliseconds(),
+	}
+	if err != nil {
+		ev.Status = StatusFailure
+		ev.Error = err.Error()
+	}
+	return ev
+}
+
+// AddFlag registers the --notify flag on a long running command
+func AddFlag(cmd *cobra.Command) {
+	cmd.Flags().String("notify", "", "Notify on completion: webhook URL, slack://<webhook-path> or desktop")
+}
+
+// Finish sends ev to the target configured through --notify, if any.
+// Notification failures only log a warning so they never change the command's exit code.
+func Finish(cmd *cobra.Command, ev Event) {
+	target, _ := cmd.Flags().GetString("notify")
+	Deliver(target, ev)
+}
+
+// Deliver sends ev to target, when there is one, and only warns when that fails
+func Deliver(target string, ev Event) {
+	if target == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := dryrun.Do("send "+describeTarget(target), func() error { return Send(ctx, target, ev) }); err != nil {
+		fmt.Fprintf(os.Stderr, style.Warning()+"Failed to send notification: %v\n", err)
+	}
+}
+
+// describeTarget names a target without the tokens webhook URLs carry in their path
+func describeTarget(target string) string {
+	switch {
+	case target == "desktop":
+		return "a desktop notification"
+	case strings.HasPrefix(target, "slack://"):
+		return "a Slack notification"
+	}
+	if u, err := url.Parse(target); err == nil && u.Host != "" {
+		return "a notification to " + u.Scheme + "://" + u.Host
+	}
+	return "a notification"
+}
+
+// Send dispatches ev to the given target
+func Send(ctx context.Context, target string, ev Event) error {
+	switch {
+	case target == "desktop":
+		return sendDesktop(ev)
+	case strings.HasPrefix(target, "slack://"):
+		return postJSON(ctx, "https://"+strings.TrimPrefix(target, "slack://"), SlackPayload(ev))
+	case strings.HasPrefix(target, "http://"), strings.HasPrefix(target, "https://"):
+		return postJSON(ctx, target, ev)
+	default:
+		return fmt.Errorf("unsupported notification target: %s", target)
+	}
+}
+
+// SlackPayload formats ev as a Slack incoming webhook message
+func SlackPayload(ev Event) map[string]string {
+	return map[string]string{"text": Summary(ev)}
+}
+
+// Summary renders a single human readable line describing ev
+func Summary(ev Event) string {
+	prefix := style.Success()
+	if ev.Status != StatusSuccess {
+		prefix = style.Failure()
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%sgsn %s %s in %s", prefix, ev.Command, ev.Status, time.Duration(ev.DurationMs)*time.Millisecond)
+	if ev.ArchivePath != "" {
+		fmt.Fprintf(&b, " — %s (%s bytes)", ev.ArchivePath, units.FormatInt(ev.ArchiveSize))
+	}
+	if ev.Error != "" {
+		fmt.Fprintf(&b, " — %s", ev.Error)
+	}
+	return b.String()
+}
+
+// postJSON sends payload as a JSON POST request to url
+func postJSON(ctx context.Context, url string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post notification: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("notification endpoint returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gsn-dev-tools/internals/style"
+)
+
+// receiver records the requests an httptest server gets and answers them with status
+func receiver(t *testing.T, status int) (*httptest.Server, *[]map[string]any) {
+	t.Helper()
+	var bodies []map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("method = %s, want POST", r.Method)
+		}
+		if got := r.Header.Get("Content-Type"); got != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", got)
+		}
+		data, _ := io.ReadAll(r.Body)
+		var body map[string]any
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Errorf("body is not JSON: %v: %s", err, data)
+		}
+		bodies = append(bodies, body)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, &bodies
+}
+
+func TestSendWebhookPayload(t *testing.T) {
+	server, bodies := receiver(t, http.StatusOK)
+	ev := Event{
+		Command:     "cmp",
+		Status:      StatusSuccess,
+		DurationMs:  1500,
+		ArchivePath: "/backups/project.tar.gz",
+		ArchiveSize: 2048,
+		Counts:      map[string]int{"files": 12},
+	}
+
+	if err := Send(context.Background(), server.URL, ev); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if len(*bodies) != 1 {
+		t.Fatalf("got %d requests, want 1", len(*bodies))
+	}
+	want := map[string]any{
+		"command":      "cmp",
+		"status":       "success",
+		"duration_ms":  1500.0,
+		"archive_path": "/backups/project.tar.gz",
+		"archive_size": 2048.0,
+		"counts":       map[string]any{"files": 12.0},
+	}
+	got := (*bodies)[0]
+	if len(got) != len(want) {
+		t.Errorf("payload has keys %v, want %v", keys(got), keys(want))
+	}
+	for key, value := range want {
+		if !jsonEqual(got[key], value) {
+			t.Errorf("payload[%q] = %v, want %v", key, got[key], value)
+		}
+	}
+}
+
+func TestSendFailurePayloadOmitsArchive(t *testing.T) {
+	server, bodies := receiver(t, http.StatusNoContent)
+	ev := NewEvent("extract", time.Now(), errors.New("disk full"))
+
+	if err := Send(context.Background(), server.URL, ev); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	got := (*bodies)[0]
+	if got["status"] != StatusFailure || got["error"] != "disk full" {
+		t.Errorf("payload = %v, want a failure with the error", got)
+	}
+	for _, key := range []string{"archive_path", "archive_size", "counts"} {
+		if _, ok := got[key]; ok {
+			t.Errorf("payload has %q, want it omitted", key)
+		}
+	}
+}
+
+func TestSlackPayload(t *testing.T) {
+	server, bodies := receiver(t, http.StatusOK)
+	ev := Event{Command: "cmp", Status: StatusFailure, DurationMs: 2000, ArchivePath: "a.tar.gz", ArchiveSize: 512, Error: "boom"}
+
+	if err := postJSON(context.Background(), server.URL, SlackPayload(ev)); err != nil {
+		t.Fatalf("postJSON: %v", err)
+	}
+	got := (*bodies)[0]
+	if len(got) != 1 {
+		t.Fatalf("Slack payload has keys %v, want only text", keys(got))
+	}
+	want := "❌ gsn cmp failure in 2s — a.tar.gz (512 bytes) — boom"
+	if got["text"] != want {
+		t.Errorf("text = %q, want %q", got["text"], want)
+	}
+}
+
+func TestSummaryPlain(t *testing.T) {
+	style.SetPlain(true)
+	t.Cleanup(func() { style.SetPlain(false) })
+
+	tests := map[string]string{
+		StatusSuccess: "OK: gsn cmp success in 2s",
+		StatusFailure: "ERROR: gsn cmp failure in 2s",
+	}
+	for status, want := range tests {
+		if got := Summary(Event{Command: "cmp", Status: status, DurationMs: 2000}); got != want {
+			t.Errorf("Summary of a %s = %q, want %q", status, got, want)
+		}
+	}
+}
+
+func TestSendReportsEndpointErrors(t *testing.T) {
+	server, _ := receiver(t, http.StatusInternalServerError)
+
+	err := Send(context.Background(), server.URL, Event{Command: "cmp", Status: StatusSuccess})
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("Send = %v, want the 500 status", err)
+	}
+	if err := Send(context.Background(), "ftp://example.com", Event{}); err == nil {
+		t.Error("Send to an ftp target succeeded, want unsupported target")
+	}
+}
+
+func TestDescribeTargetHidesTokens(t *testing.T) {
+	tests := map[string]string{
+		"desktop":                            "a desktop notification",
+		"slack://hooks.slack.com/T0/B0/XYZ":  "a Slack notification",
+		"https://example.com/hook?token=abc": "a notification to https://example.com",
+		"not a url":                          "a notification",
+	}
+	for target, want := range tests {
+		if got := describeTarget(target); got != want {
+			t.Errorf("describeTarget(%q) = %q, want %q", target, got, want)
+		}
+	}
+}
+
+func keys(m map[string]any) []string {
+	var out []string
+	for key := range m {
+		out = append(out, key)
+	}
+	return out
+}
+
+func jsonEqual(a, b any) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return string(x) == string(y)
+}
@@ -0,0 +1,104 @@
+package output
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	gauges := []Gauge{
+		{Name: "gsn_cmp_success", Help: "Whether the run succeeded.", Labels: map[string]string{"source": "/srv/data", "profile": "nightly"}, Value: 1},
+		{Name: "gsn_cmp_duration_seconds", Help: "Duration\nof the run with a \\ backslash.", Value: 12.5},
+		{Name: "gsn_cmp_source_bytes", Help: "Source size.", Labels: map[string]string{"source": `C:\data "quoted"` + "\nnext"}, Value: 1e12},
+	}
+	var buf bytes.Buffer
+	if err := WritePrometheus(&buf, gauges); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP gsn_cmp_success Whether the run succeeded.
+# TYPE gsn_cmp_success gauge
+gsn_cmp_success{profile="nightly",source="/srv/data"} 1
+# HELP gsn_cmp_duration_seconds Duration\nof the run with a \\ backslash.
+# TYPE gsn_cmp_duration_seconds gauge
+gsn_cmp_duration_seconds 12.5
+# HELP gsn_cmp_source_bytes Source size.
+# TYPE gsn_cmp_source_bytes gauge
+gsn_cmp_source_bytes{source="C:\\data \"quoted\"\nnext"} 1000000000000
+`
+	if buf.String() != want {
+		t.Errorf("WritePrometheus =\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "gsn.prom")
+	if err := os.WriteFile(path, []byte("old\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFileAtomic(path, []byte("new\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o644 {
+		t.Errorf("mode = %v, want 0644", info.Mode().Perm())
+	}
+	if data, _ := os.ReadFile(path); string(data) != "new\n" {
+		t.Errorf("content = %q, want new", data)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("the temp file was left behind: %v", entries)
+	}
+
+	if err := WriteFileAtomic(filepath.Join(dir, "missing", "gsn.prom"), []byte("x"), 0o644); err == nil {
+		t.Error("writing into a missing directory succeeded")
+	}
+}
+
+func TestWritePrometheusFileIsNeverPartial(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gsn.prom")
+	gauges := make([]Gauge, 200)
+	for i := range gauges {
+		gauges[i] = Gauge{Name: "gsn_test_gauge", Help: strings.Repeat("h", 100), Value: float64(i)}
+	}
+	if err := WritePrometheusFile(path, gauges); err != nil {
+		t.Fatal(err)
+	}
+	var full bytes.Buffer
+	_ = WritePrometheus(&full, gauges)
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			// node_exporter reads the file as a whole, it must always see a complete exposition
+			if data, err := os.ReadFile(path); err != nil || !bytes.Equal(data, full.Bytes()) {
+				t.Errorf("read %d bytes (%v), want the %d bytes of the whole file", len(data), err, full.Len())
+				return
+			}
+		}
+	}()
+	for range 50 {
+		if err := WritePrometheusFile(path, gauges); err != nil {
+			t.Error(err)
+			break
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
@@ -0,0 +1,353 @@
+package output
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/redact"
+	"gsn-dev-tools/internals/style"
+
+	"github.com/rivo/uniseg"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// Format selects how a table is rendered
+type Format int
+
+const (
+	FormatTable Format = iota
+	FormatCSV
+	FormatTSV
+)
+
+// Column declares a table column through typed accessors.
+// Value must return a comparable value (string, int, int64, float64, time.Time, time.Duration)
+// so sorting compares real values, Display optionally overrides how the value is printed.
+type Column[T any] struct {
+	Name    string
+	Value   func(T) any
+	Display func(T) string
+}
+
+// Options controls column selection, sorting and layout of a rendered table
+type Options struct {
+	Columns  []string
+	SortBy   string
+	Desc     bool
+	Format   Format
+	Plain    bool
+	MaxWidth int
+}
+
+// AddFlags registers the shared table flags on a list-style command
+func AddFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSlice("columns", nil, "Comma separated list of columns to show")
+	cmd.Flags().String("sort", "", "Sort by column, optionally suffixed with :asc or :desc (e.g. age:desc)")
+	cmd.Flags().Bool("csv", false, "Output as CSV")
+	cmd.Flags().Bool("tsv", false, "Output as TSV")
+}
+
+// OptionsFromFlags reads the shared table flags and detects whether stdout is a terminal
+func OptionsFromFlags(cmd *cobra.Command) (Options, error) {
+	columns, _ := cmd.Flags().GetStringSlice("columns")
+	sortSpec, _ := cmd.Flags().GetString("sort")
+	asCSV, _ := cmd.Flags().GetBool("csv")
+	asTSV, _ := cmd.Flags().GetBool("tsv")
+
+	opts := Options{Columns: columns}
+	switch {
+	case asCSV && asTSV:
+		return opts, clierr.Newf(clierr.Usage, "--csv and --tsv are mutually exclusive")
+	case asCSV:
+		opts.Format = FormatCSV
+	case asTSV:
+		opts.Format = FormatTSV
+	}
+
+	if sortSpec != "" {
+		name, direction, _ := strings.Cut(sortSpec, ":")
+		switch direction {
+		case "", "asc":
+		case "desc":
+			opts.Desc = true
+		default:
+			return opts, clierr.Newf(clierr.Usage, "invalid sort direction '%s', use asc or desc", direction)
+		}
+		opts.SortBy = name
+	}
+
+	terminal := TerminalOptions()
+	opts.Plain, opts.MaxWidth = terminal.Plain, terminal.MaxWidth
+	return opts, nil
+}
+
+// TerminalOptions are the options of a table without flags: every column, fitted to stdout when it is a terminal
+func TerminalOptions() Options {
+	opts := Options{Plain: style.Plain()}
+	fd := redact.Fd(os.Stdout)
+	if term.IsTerminal(fd) {
+		if width, _, err := term.GetSize(fd); err == nil {
+			opts.MaxWidth = width
+		}
+	}
+	return opts
+}
+
+// Render writes rows to w using the given column definitions and options
+func Render[T any](w io.Writer, columns []Column[T], rows []T, opts Options) error {
+	selected, err := selectColumns(columns, opts.Columns)
+	if err != nil {
+		return err
+	}
+
+	sorted := make([]T, len(rows))
+	copy(sorted, rows)
+	if opts.SortBy != "" {
+		if err := sortRows(sorted, columns, opts.SortBy, opts.Desc); err != nil {
+			return err
+		}
+	}
+
+	header := make([]string, len(selected))
+	for i, col := range selected {
+		header[i] = col.Name
+	}
+
+	cells := make([][]string, len(sorted))
+	for r, row := range sorted {
+		cells[r] = make([]string, len(selected))
+		for c, col := range selected {
+			cells[r][c] = displayValue(col, row)
+		}
+	}
+
+	switch opts.Format {
+	case FormatCSV, FormatTSV:
+		cw := csv.NewWriter(w)
+		if opts.Format == FormatTSV {
+			cw.Comma = '\t'
+		}
+		if err := cw.Write(header); err != nil {
+			return err
+		}
+		if err := cw.WriteAll(cells); err != nil {
+			return err
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return renderAligned(w, header, cells, opts)
+	}
+}
+
+// Sort orders rows in place the way Render would show them, for commands also writing them in another encoding
+func Sort[T any](columns []Column[T], rows []T, opts Options) error {
+	if opts.SortBy == "" {
+		return nil
+	}
+	return sortRows(rows, columns, opts.SortBy, opts.Desc)
+}
+
+// selectColumns resolves the requested column names, keeping declaration order when none are given
+func selectColumns[T any](columns []Column[T], names []string) ([]Column[T], error) {
+	if len(names) == 0 {
+		return columns, nil
+	}
+
+	var selected []Column[T]
+	for _, name := range names {
+		col, ok := findColumn(columns, name)
+		if !ok {
+			return nil, clierr.Newf(clierr.Usage, "unknown column '%s' (available: %s)", name, columnNames(columns))
+		}
+		selected = append(selected, col)
+	}
+	return selected, nil
+}
+
+func findColumn[T any](columns []Column[T], name string) (Column[T], bool) {
+	for _, col := range columns {
+		if strings.EqualFold(col.Name, strings.TrimSpace(name)) {
+			return col, true
+		}
+	}
+	return Column[T]{}, false
+}
+
+func columnNames[T any](columns []Column[T]) string {
+	names := make([]string, len(columns))
+	for i, col := range columns {
+		names[i] = col.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// sortRows stably sorts rows by the typed value of the named column
+func sortRows[T any](rows []T, columns []Column[T], name string, desc bool) error {
+	col, ok := findColumn(columns, name)
+	if !ok {
+		return clierr.Newf(clierr.Usage, "unknown sort column '%s' (available: %s)", name, columnNames(columns))
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := col.Value(rows[i]), col.Value(rows[j])
+		if desc {
+			return compareValues(b, a) < 0
+		}
+		return compareValues(a, b) < 0
+	})
+	return nil
+}
+
+// compareValues orders two column values, falling back to their string form for unknown types
+func compareValues(a any, b any) int {
+	switch av := a.(type) {
+	case int:
+		if bv, ok := b.(int); ok {
+			return cmpOrdered(av, bv)
+		}
+	case int64:
+		if bv, ok := b.(int64); ok {
+			return cmpOrdered(av, bv)
+		}
+	case float64:
+		if bv, ok := b.(float64); ok {
+			return cmpOrdered(av, bv)
+		}
+	case time.Duration:
+		if bv, ok := b.(time.Duration); ok {
+			return cmpOrdered(av, bv)
+		}
+	case time.Time:
+		if bv, ok := b.(time.Time); ok {
+			return av.Compare(bv)
+		}
+	case string:
+		if bv, ok := b.(string); ok {
+			return strings.Compare(av, bv)
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func cmpOrdered[N int | int64 | float64 | time.Duration](a N, b N) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func displayValue[T any](col Column[T], row T) string {
+	if col.Display != nil {
+		return col.Display(row)
+	}
+
+	switch v := col.Value(row).(type) {
+	case time.Time:
+		return v.Format("2006-01-02 15:04")
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// renderAligned prints cells as space aligned columns, truncating the widest column to fit MaxWidth
+func renderAligned(w io.Writer, header []string, cells [][]string, opts Options) error {
+	widths := make([]int, len(header))
+	for i, h := range header {
+		widths[i] = uniseg.StringWidth(h)
+	}
+	for _, row := range cells {
+		for i, cell := range row {
+			widths[i] = max(widths[i], uniseg.StringWidth(cell))
+		}
+	}
+
+	if opts.MaxWidth > 0 {
+		fitWidths(widths, opts.MaxWidth)
+	}
+
+	writeRow := func(row []string, bold bool) error {
+		var b strings.Builder
+		for i, cell := range row {
+			cell = truncate(cell, widths[i])
+			if i < len(row)-1 {
+				cell += strings.Repeat(" ", widths[i]-uniseg.StringWidth(cell)+2)
+			}
+			b.WriteString(cell)
+		}
+		line := strings.TrimRight(b.String(), " ")
+		if bold {
+			line = style.Bold(line)
+		}
+		_, err := fmt.Fprintln(w, line)
+		return err
+	}
+
+	if err := writeRow(header, !opts.Plain); err != nil {
+		return err
+	}
+	for _, row := range cells {
+		if err := writeRow(row, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fitWidths shrinks the widest columns until the row including separators fits maxWidth
+func fitWidths(widths []int, maxWidth int) {
+	const minWidth = 4
+	total := func() int {
+		sum := 2 * (len(widths) - 1)
+		for _, w := range widths {
+			sum += w
+		}
+		return sum
+	}
+
+	for total() > maxWidth {
+		widest := 0
+		for i, w := range widths {
+			if w > widths[widest] {
+				widest = i
+			}
+		}
+		if widths[widest] <= minWidth {
+			return
+		}
+		widths[widest]--
+	}
+}
+
+// truncate shortens s to at most width display cells, marking the cut with an ellipsis
+func truncate(s string, width int) string {
+	if uniseg.StringWidth(s) <= width {
+		return s
+	}
+
+	var b strings.Builder
+	used := 0
+	g := uniseg.NewGraphemes(s)
+	for g.Next() {
+		w := g.Width()
+		if used+w > width-1 {
+			break
+		}
+		b.WriteString(g.Str())
+		used += w
+	}
+	return b.String() + "…"
+}
@@ -0,0 +1,207 @@
+package output
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+
+	"github.com/rivo/uniseg"
+	"github.com/spf13/cobra"
+)
+
+type testRow struct {
+	Name    string
+	Size    int64
+	Age     time.Duration
+	Updated time.Time
+}
+
+var testColumns = []Column[testRow]{
+	{Name: "name", Value: func(r testRow) any { return r.Name }},
+	{Name: "size", Value: func(r testRow) any { return r.Size }},
+	{Name: "age", Value: func(r testRow) any { return r.Age }, Display: func(r testRow) string { return r.Age.String() }},
+	{Name: "updated", Value: func(r testRow) any { return r.Updated }},
+}
+
+var testRows = []testRow{
+	{Name: "beta", Size: 9, Age: 2 * time.Hour, Updated: time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)},
+	{Name: "alpha", Size: 10, Age: 30 * time.Minute, Updated: time.Date(2024, 1, 15, 8, 30, 0, 0, time.UTC)},
+	{Name: "gamma", Size: 100, Age: 48 * time.Hour, Updated: time.Date(2023, 12, 31, 23, 59, 0, 0, time.UTC)},
+}
+
+func render(t *testing.T, opts Options) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := Render(&buf, testColumns, testRows, opts); err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	return buf.String()
+}
+
+func TestRenderAligned(t *testing.T) {
+	got := render(t, Options{Plain: true})
+	want := "" +
+		"name   size  age      updated\n" +
+		"beta   9     2h0m0s   2024-03-01 10:00\n" +
+		"alpha  10    30m0s    2024-01-15 08:30\n" +
+		"gamma  100   48h0m0s  2023-12-31 23:59\n"
+	if got != want {
+		t.Errorf("Render =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRenderSortsTypedValues(t *testing.T) {
+	tests := []struct {
+		sortBy string
+		desc   bool
+		want   []string
+	}{
+		// Sizes compare as numbers: 9 < 10 < 100, where strings would put 10 and 100 first
+		{"size", false, []string{"beta", "alpha", "gamma"}},
+		{"size", true, []string{"gamma", "alpha", "beta"}},
+		{"age", true, []string{"gamma", "beta", "alpha"}},
+		{"updated", false, []string{"gamma", "alpha", "beta"}},
+		{"NAME", false, []string{"alpha", "beta", "gamma"}},
+	}
+	for _, test := range tests {
+		got := render(t, Options{Plain: true, Columns: []string{"name"}, SortBy: test.sortBy, Desc: test.desc})
+		want := "name\n" + strings.Join(test.want, "\n") + "\n"
+		if got != want {
+			t.Errorf("sort %s desc=%v =\n%s\nwant\n%s", test.sortBy, test.desc, got, want)
+		}
+	}
+}
+
+func TestSortIsStable(t *testing.T) {
+	rows := []testRow{{Name: "a", Size: 1}, {Name: "b", Size: 0}, {Name: "c", Size: 1}, {Name: "d", Size: 0}}
+	if err := Sort(testColumns, rows, Options{SortBy: "size"}); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, r := range rows {
+		names = append(names, r.Name)
+	}
+	if got := strings.Join(names, ""); got != "bdac" {
+		t.Errorf("Sort = %s, want bdac", got)
+	}
+}
+
+func TestRenderUnknownColumns(t *testing.T) {
+	var buf bytes.Buffer
+	err := Render(&buf, testColumns, testRows, Options{Columns: []string{"name", "owner"}})
+	if clierr.CodeOf(err) != clierr.Usage || !strings.Contains(err.Error(), "owner") {
+		t.Errorf("unknown column = %v, want a usage error naming it", err)
+	}
+	err = Render(&buf, testColumns, testRows, Options{SortBy: "owner"})
+	if clierr.CodeOf(err) != clierr.Usage {
+		t.Errorf("unknown sort column = %v, want a usage error", err)
+	}
+}
+
+func TestRenderCSVAndTSV(t *testing.T) {
+	rows := []testRow{{Name: `quoted "name", with comma`, Size: 1}}
+	var buf bytes.Buffer
+	if err := Render(&buf, testColumns, rows, Options{Format: FormatCSV, Columns: []string{"name", "size"}}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "name,size\n\"quoted \"\"name\"\", with comma\",1\n"; buf.String() != want {
+		t.Errorf("CSV = %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err := Render(&buf, testColumns, testRows[:1], Options{Format: FormatTSV, Columns: []string{"name", "age"}, MaxWidth: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "name\tage\nbeta\t2h0m0s\n"; buf.String() != want {
+		t.Errorf("TSV = %q, want %q, never truncated", buf.String(), want)
+	}
+}
+
+func TestRenderWideRunes(t *testing.T) {
+	rows := []testRow{{Name: "日本語", Size: 1}, {Name: "ascii", Size: 22}, {Name: "🚀 go", Size: 3}}
+	var buf bytes.Buffer
+	if err := Render(&buf, testColumns, rows, Options{Plain: true, Columns: []string{"name", "size"}}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	// The size column starts at the same display cell on every line
+	for _, line := range lines {
+		i := strings.LastIndex(line, "  ")
+		if w := uniseg.StringWidth(line[:i+2]); w != 8 {
+			t.Errorf("size column of %q starts at cell %d, want 8", line, w)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s     string
+		width int
+		want  string
+	}{
+		{"short", 10, "short"},
+		{"exactly", 7, "exactly"},
+		{"truncated", 6, "trunc…"},
+		// A double width rune never gets cut in half
+		{"日本語テキスト", 6, "日本…"},
+		{"日本語テキスト", 7, "日本語…"},
+		// A combining accent stays with its letter
+		{"été", 2, "é…"},
+	}
+	for _, test := range tests {
+		got := truncate(test.s, test.width)
+		if got != test.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", test.s, test.width, got, test.want)
+		}
+		if uniseg.StringWidth(got) > test.width {
+			t.Errorf("truncate(%q, %d) is %d cells wide", test.s, test.width, uniseg.StringWidth(got))
+		}
+	}
+}
+
+func TestRenderFitsMaxWidth(t *testing.T) {
+	rows := []testRow{{Name: strings.Repeat("long-name-", 8), Size: 1}}
+	var buf bytes.Buffer
+	if err := Render(&buf, testColumns, rows, Options{Plain: true, Columns: []string{"name", "size"}, MaxWidth: 30}); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		if w := uniseg.StringWidth(line); w > 30 {
+			t.Errorf("line %q is %d cells wide, want at most 30", line, w)
+		}
+	}
+	if !strings.Contains(buf.String(), "…") {
+		t.Errorf("the long name was not truncated:\n%s", buf.String())
+	}
+}
+
+func TestOptionsFromFlags(t *testing.T) {
+	parse := func(args ...string) (Options, error) {
+		cmd := &cobra.Command{Use: "list"}
+		AddFlags(cmd)
+		if err := cmd.ParseFlags(args); err != nil {
+			t.Fatal(err)
+		}
+		return OptionsFromFlags(cmd)
+	}
+
+	opts, err := parse("--sort", "age:desc", "--columns", "name,age", "--tsv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.SortBy != "age" || !opts.Desc || opts.Format != FormatTSV || strings.Join(opts.Columns, ",") != "name,age" {
+		t.Errorf("options = %+v", opts)
+	}
+	if opts, _ := parse("--sort", "size"); opts.SortBy != "size" || opts.Desc {
+		t.Errorf("--sort size = %+v, want ascending", opts)
+	}
+	if _, err := parse("--csv", "--tsv"); clierr.CodeOf(err) != clierr.Usage {
+		t.Errorf("--csv --tsv = %v, want a usage error", err)
+	}
+	if _, err := parse("--sort", "age:sideways"); clierr.CodeOf(err) != clierr.Usage {
+		t.Errorf("--sort age:sideways = %v, want a usage error", err)
+	}
+}
@@ -0,0 +1,115 @@
+// Package pathfmt shortens the paths reports print. Paths are shown relative to a base directory, the root
+// argument of the command unless --relative-to names another, so a pasted report does not carry the home
+// directory along. Paths outside the base stay absolute. JSON output keeps absolute paths for scripts unless
+// --relative-json asks for the displayed ones.
+package pathfmt
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+// HomeBase is the --relative-to value abbreviating the home directory as ~ instead
+const HomeBase = "~"
+
+// mode is how a Display shows paths
+type mode int
+
+const (
+	modeRelative mode = iota
+	modeHome
+	modeAbsolute
+)
+
+// Display rewrites the paths of a report for showing them
+type Display struct {
+	mode         mode
+	base         string
+	home         string
+	relativeJSON bool
+}
+
+// AddFlags registers --relative-to and --relative-json on a command printing paths
+func AddFlags(cmd *cobra.Command) {
+	cmd.Flags().String("relative-to", "", "Show paths relative to this directory, ~ to abbreviate the home directory instead or / to keep them absolute (default: the directory argument)")
+	cmd.Flags().Bool("relative-json", false, "Write the paths of --json output as they are shown, rather than absolute")
+}
+
+// FromFlags reads the flags of AddFlags, paths are shown relative to root unless --relative-to names a base
+func FromFlags(cmd *cobra.Command, root string) (Display, error) {
+	base, _ := cmd.Flags().GetString("relative-to")
+	relativeJSON, _ := cmd.Flags().GetBool("relative-json")
+	if base == "" {
+		base = root
+	}
+	return New(base, relativeJSON)
+}
+
+// New returns a Display showing paths relative to base, with the home directory abbreviated when base is ~
+// and absolute when it is the filesystem root
+func New(base string, relativeJSON bool) (Display, error) {
+	d := Display{relativeJSON: relativeJSON}
+	if home, err := os.UserHomeDir(); err == nil {
+		d.home = filepath.Clean(home)
+	}
+	if base == HomeBase {
+		d.mode = modeHome
+		return d, nil
+	}
+	abs, err := filepath.Abs(base)
+	if err != nil {
+		return d, err
+	}
+	d.base = abs
+	if abs == filepath.VolumeName(abs)+string(filepath.Separator) {
+		d.mode = modeAbsolute
+	}
+	return d, nil
+}
+
+// Path returns p as it is shown: relative to the base, below ~, or absolute when it is outside of them
+func (d Display) Path(p string) string {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return p
+	}
+	switch d.mode {
+	case modeRelative:
+		if rel, ok := within(d.base, abs); ok {
+			return rel
+		}
+	case modeHome:
+		if rel, ok := within(d.home, abs); ok {
+			if rel == "." {
+				return HomeBase
+			}
+			return HomeBase + string(filepath.Separator) + rel
+		}
+	}
+	return abs
+}
+
+// JSONPath returns p as JSON output writes it, absolute unless --relative-json was given
+func (d Display) JSONPath(p string) string {
+	if d.relativeJSON {
+		return d.Path(p)
+	}
+	if abs, err := filepath.Abs(p); err == nil {
+		return abs
+	}
+	return p
+}
+
+// within returns the path of target relative to dir, false when target is not below it
+func within(dir string, target string) (string, bool) {
+	if dir == "" {
+		return "", false
+	}
+	rel, err := filepath.Rel(dir, target)
+	if err != nil || !filepath.IsLocal(rel) {
+		return "", false
+	}
+	return rel, true
+}
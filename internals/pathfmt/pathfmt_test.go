@@ -0,0 +1,110 @@
+package pathfmt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestPath(t *testing.T) {
+	root := t.TempDir()
+	home := filepath.Join(root, "home", "ada")
+	t.Setenv("HOME", home)
+	project := filepath.Join(home, "code", "project")
+	if err := os.MkdirAll(project, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(filepath.Dir(project))
+
+	tests := []struct {
+		base string
+		path string
+		want string
+	}{
+		// Nested paths, however they are spelled
+		{project, filepath.Join(project, "src", "main.go"), filepath.Join("src", "main.go")},
+		{project, filepath.Join(project, "src", "deep", "er", "x.txt"), filepath.Join("src", "deep", "er", "x.txt")},
+		{project, filepath.Join(project, "src", "..", "docs", "a.md"), filepath.Join("docs", "a.md")},
+		{project, project, "."},
+		{project, filepath.Join("project", "src"), "src"},
+		// A relative base is taken from the working directory
+		{"project", filepath.Join(project, "src"), "src"},
+		// Outside the base, next to it, above it or sharing a prefix of its name, paths stay absolute
+		{project, filepath.Join(home, "code", "other", "main.go"), filepath.Join(home, "code", "other", "main.go")},
+		{project, filepath.Join(home, "code"), filepath.Join(home, "code")},
+		{project, project + "-old", project + "-old"},
+		{project, "other", filepath.Join(home, "code", "other")},
+		{project, filepath.Join(project, "..", "project-old", "x"), filepath.Join(home, "code", "project-old", "x")},
+		// ~ abbreviates the home directory, what is outside of it stays absolute
+		{HomeBase, filepath.Join(project, "src"), filepath.Join("~", "code", "project", "src")},
+		{HomeBase, home, "~"},
+		{HomeBase, home + "2", home + "2"},
+		{HomeBase, root, root},
+		// The root keeps every path absolute
+		{"/", filepath.Join(project, "src"), filepath.Join(project, "src")},
+		{"/", "project", project},
+	}
+	for _, test := range tests {
+		d, err := New(test.base, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := d.Path(test.path); got != test.want {
+			t.Errorf("New(%q).Path(%q) = %q, want %q", test.base, test.path, got, test.want)
+		}
+	}
+}
+
+func TestJSONPath(t *testing.T) {
+	project := t.TempDir()
+	t.Chdir(project)
+	nested := filepath.Join(project, "src", "main.go")
+	outside := filepath.Dir(project)
+
+	absolute, _ := New(project, false)
+	relative, _ := New(project, true)
+	for _, test := range []struct {
+		d    Display
+		path string
+		want string
+	}{
+		{absolute, nested, nested},
+		{absolute, filepath.Join("src", "main.go"), nested},
+		{relative, nested, filepath.Join("src", "main.go")},
+		{relative, outside, outside},
+	} {
+		if got := test.d.JSONPath(test.path); got != test.want {
+			t.Errorf("JSONPath(%q) with --relative-json=%v = %q, want %q", test.path, test.d.relativeJSON, got, test.want)
+		}
+	}
+}
+
+func TestFromFlags(t *testing.T) {
+	root := t.TempDir()
+	other := filepath.Join(root, "sub")
+	path := filepath.Join(other, "x")
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, filepath.Join("sub", "x")},
+		{[]string{"--relative-to", other}, "x"},
+		{[]string{"--relative-to", "/"}, path},
+	}
+	for _, test := range tests {
+		cmd := &cobra.Command{Use: "du"}
+		AddFlags(cmd)
+		if err := cmd.ParseFlags(test.args); err != nil {
+			t.Fatal(err)
+		}
+		d, err := FromFlags(cmd, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := d.Path(path); got != test.want {
+			t.Errorf("%v: Path = %q, want %q", test.args, got, test.want)
+		}
+	}
+}
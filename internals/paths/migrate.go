@@ -0,0 +1,141 @@
+package paths
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/style"
+)
+
+// move is a file or directory of an older gsn and where it belongs now
+type move struct {
+	From string
+	To   string
+}
+
+// legacyMoves lists the locations older versions of gsn wrote to. A portable install moves nothing, the data of
+// the machine it runs on is not its own.
+func (e env) legacyMoves() ([]move, error) {
+	if e.getenv(HomeEnv) != "" {
+		return nil, nil
+	}
+	configDir, err := e.resolve(Config)
+	if err != nil {
+		return nil, err
+	}
+	stateDir, err := e.resolve(State)
+	if err != nil {
+		return nil, err
+	}
+
+	var moves []move
+	// The state dir was ~/.local/state/gsn on every OS, Windows now keeps it in %LocalAppData%
+	if e.goos == "windows" {
+		legacy := e.getenv("XDG_STATE_HOME")
+		if legacy == "" {
+			home, err := e.homeDir(State, ".local", "state")
+			if err != nil {
+				return nil, err
+			}
+			legacy = home
+		}
+		moves = append(moves, move{From: filepath.Join(legacy, appName), To: stateDir})
+	}
+	// The journals of workspace runs were kept with the configuration
+	moves = append(moves, move{From: filepath.Join(configDir, "journals"), To: filepath.Join(stateDir, "journals")})
+	return moves, nil
+}
+
+// Migrate moves the files of older gsn versions to the directories they belong in now. A moved file is gone from
+// its old location, so each move happens once. A file that exists at both places is left where it is with a
+// warning, and so is anything that cannot be moved: gsn goes on without it rather than failing the command.
+// Nothing moves under --dry-run.
+func Migrate() {
+	if dryrun.Enabled() {
+		return
+	}
+	moves, err := osEnv().legacyMoves()
+	if err != nil {
+		return
+	}
+	for _, m := range moves {
+		moved, err := moveTree(m.From, m.To)
+		if moved > 0 {
+			fmt.Fprintf(os.Stderr, "Moved %d file(s) from %s to %s\n", moved, m.From, m.To)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, style.Warning()+"Left %s in place: %v\n", m.From, err)
+		}
+	}
+}
+
+// moveTree moves from to to, merging into a directory that already exists: entries missing at to are moved, the
+// others are kept at from and reported. Directories emptied by the move are removed. It returns the number of
+// files moved.
+func moveTree(from string, to string) (int, error) {
+	info, err := os.Lstat(from)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := os.Lstat(to); errors.Is(err, fs.ErrNotExist) {
+		if err := os.MkdirAll(filepath.Dir(to), 0o700); err != nil {
+			return 0, err
+		}
+		if err := os.Rename(from, to); err != nil {
+			return 0, err
+		}
+		return countFiles(to), nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	if !info.IsDir() {
+		return 0, fmt.Errorf("'%s' exists as well", to)
+	}
+	toInfo, err := os.Stat(to)
+	if err != nil {
+		return 0, err
+	}
+	if !toInfo.IsDir() {
+		return 0, fmt.Errorf("'%s' is not a directory", to)
+	}
+
+	entries, err := os.ReadDir(from)
+	if err != nil {
+		return 0, err
+	}
+	moved := 0
+	var errs []error
+	for _, e := range entries {
+		n, err := moveTree(filepath.Join(from, e.Name()), filepath.Join(to, e.Name()))
+		moved += n
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		// Only fails when something appeared meanwhile, which then stays where it is
+		_ = os.Remove(from)
+	}
+	return moved, errors.Join(errs...)
+}
+
+// countFiles counts the files below path, 1 for a file
+func countFiles(path string) int {
+	n := 0
+	_ = filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			n++
+		}
+		return nil
+	})
+	return n
+}
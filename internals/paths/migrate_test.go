@@ -0,0 +1,157 @@
+package paths
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestLegacyMoves(t *testing.T) {
+	tests := []struct {
+		name string
+		goos string
+		vars map[string]string
+		want []move
+	}{
+		{"linux", "linux", map[string]string{"HOME": "/home/ana"}, []move{
+			{"/home/ana/.config/gsn/journals", "/home/ana/.local/state/gsn/journals"},
+		}},
+		{"windows", "windows", map[string]string{
+			"USERPROFILE":  `C:\Users\ana`,
+			"AppData":      `C:\Users\ana\AppData\Roaming`,
+			"LocalAppData": `C:\Users\ana\AppData\Local`,
+		}, []move{
+			{filepath.Join(`C:\Users\ana`, ".local", "state", "gsn"), filepath.Join(`C:\Users\ana\AppData\Local`, "gsn", "state")},
+			{filepath.Join(`C:\Users\ana\AppData\Roaming`, "gsn", "journals"), filepath.Join(`C:\Users\ana\AppData\Local`, "gsn", "state", "journals")},
+		}},
+		// An XDG state dir set on Windows was where the state went
+		{"windows XDG", "windows", map[string]string{
+			"AppData":        `C:\Users\ana\AppData\Roaming`,
+			"LocalAppData":   `C:\Users\ana\AppData\Local`,
+			"XDG_STATE_HOME": `D:\state`,
+		}, []move{
+			{filepath.Join(`D:\state`, "gsn"), filepath.Join(`C:\Users\ana\AppData\Local`, "gsn", "state")},
+			{filepath.Join(`C:\Users\ana\AppData\Roaming`, "gsn", "journals"), filepath.Join(`C:\Users\ana\AppData\Local`, "gsn", "state", "journals")},
+		}},
+		{"portable", "windows", map[string]string{HomeEnv: "/media/usb/gsn", "USERPROFILE": `C:\Users\ana`}, nil},
+	}
+	for _, test := range tests {
+		got, err := fakeEnv(test.goos, test.vars).legacyMoves()
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%s: moves =\n%v\nwant\n%v", test.name, got, test.want)
+		}
+	}
+}
+
+// writeFiles creates files below root with their own name as content
+func writeFiles(t *testing.T, root string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// listFiles lists the files below root with their content, as name=content
+func listFiles(t *testing.T, root string) string {
+	t.Helper()
+	var files []string
+	filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			data, _ := os.ReadFile(path)
+			rel, _ := filepath.Rel(root, path)
+			files = append(files, filepath.ToSlash(rel)+"="+string(data))
+		}
+		return nil
+	})
+	return strings.Join(files, " ")
+}
+
+func TestMoveTree(t *testing.T) {
+	dir := t.TempDir()
+	from, to := filepath.Join(dir, "old"), filepath.Join(dir, "new", "state")
+
+	// Nothing to move
+	if n, err := moveTree(from, to); n != 0 || err != nil {
+		t.Errorf("missing source = %d, %v", n, err)
+	}
+
+	// A missing target is the source renamed
+	writeFiles(t, from, "a.json", "sub/b.json")
+	if n, err := moveTree(from, to); n != 2 || err != nil {
+		t.Fatalf("move to a new dir = %d, %v", n, err)
+	}
+	if _, err := os.Stat(from); !os.IsNotExist(err) {
+		t.Errorf("source still there: %v", err)
+	}
+	if got := listFiles(t, to); got != "a.json=a.json sub/b.json=sub/b.json" {
+		t.Errorf("moved files: %s", got)
+	}
+
+	// An existing target gets what it lacks, a file at both places stays where it is
+	writeFiles(t, from, "c.json", "a.json", "sub/d.json")
+	if err := os.WriteFile(filepath.Join(from, "a.json"), []byte("older a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	n, err := moveTree(from, to)
+	if n != 2 || err == nil || !strings.Contains(err.Error(), "'"+filepath.Join(to, "a.json")+"' exists as well") {
+		t.Errorf("merge = %d, %v", n, err)
+	}
+	if got := listFiles(t, to); got != "a.json=a.json c.json=c.json sub/b.json=sub/b.json sub/d.json=sub/d.json" {
+		t.Errorf("merged files: %s", got)
+	}
+	if got := listFiles(t, from); got != "a.json=older a" {
+		t.Errorf("left in place: %s", got)
+	}
+
+	// Moving again once the conflict is gone empties the source
+	os.Remove(filepath.Join(to, "a.json"))
+	if n, err := moveTree(from, to); n != 1 || err != nil {
+		t.Errorf("second run = %d, %v", n, err)
+	}
+	if _, err := os.Stat(from); !os.IsNotExist(err) {
+		t.Errorf("emptied source still there: %v", err)
+	}
+}
+
+// TestMigrate moves the journals an older gsn kept in the config dir, once
+func TestMigrate(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the XDG layout is not used on Windows")
+	}
+	home := t.TempDir()
+	t.Setenv(HomeEnv, "")
+	t.Setenv("HOME", home)
+	for _, name := range []string{"XDG_CONFIG_HOME", "XDG_STATE_HOME"} {
+		t.Setenv(name, filepath.Join(home, strings.ToLower(name)))
+	}
+	configDir, _ := ConfigDir()
+	stateDir, _ := StateDir()
+	writeFiles(t, filepath.Join(configDir, "journals"), "rename-1.json", "rename-2.json")
+	writeFiles(t, configDir, "config.yaml")
+
+	Migrate()
+	if got := listFiles(t, filepath.Join(stateDir, "journals")); got != "rename-1.json=rename-1.json rename-2.json=rename-2.json" {
+		t.Errorf("state journals: %s", got)
+	}
+	if got := listFiles(t, configDir); got != "config.yaml=config.yaml" {
+		t.Errorf("config dir holds %s", got)
+	}
+	// A second run finds nothing to move
+	Migrate()
+	if got := listFiles(t, stateDir); got != "journals/rename-1.json=rename-1.json journals/rename-2.json=rename-2.json" {
+		t.Errorf("state dir after a second run: %s", got)
+	}
+}
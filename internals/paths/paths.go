@@ -0,0 +1,138 @@
+// Package paths resolves the directories gsn keeps its files in. Every feature that persists data asks this
+// package for its directory, so nothing ends up as a dotfile in the home directory.
+package paths
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// Kind is a directory gsn keeps files in
+type Kind string
+
+const (
+	// Config holds what the user writes: config.yaml, templates, saved requests
+	Config Kind = "config"
+	// State holds what gsn writes and needs again on a later run: journals, queues, the backup store
+	State Kind = "state"
+	// Data holds data gsn produces for the user that is neither configuration nor state
+	Data Kind = "data"
+	// Cache holds what can be fetched or computed again, removing it loses nothing
+	Cache Kind = "cache"
+)
+
+// Kinds lists every kind, in the order they are shown
+var Kinds = []Kind{Config, State, Data, Cache}
+
+// HomeEnv names the variable of portable installs: every directory is below it, e.g. on a USB stick
+const HomeEnv = "GSN_HOME"
+
+// appName is the directory of gsn below the base directories of the OS
+const appName = "gsn"
+
+// env is where resolve reads the OS and the environment from, the real ones outside of tests
+type env struct {
+	goos   string
+	getenv func(string) string
+}
+
+func osEnv() env {
+	return env{goos: runtime.GOOS, getenv: os.Getenv}
+}
+
+// Dir returns the directory of kind
+func Dir(kind Kind) (string, error) {
+	return osEnv().resolve(kind)
+}
+
+// ConfigDir returns the gsn config directory, e.g. ~/.config/gsn
+func ConfigDir() (string, error) { return Dir(Config) }
+
+// StateDir returns the gsn state directory, e.g. ~/.local/state/gsn
+func StateDir() (string, error) { return Dir(State) }
+
+// DataDir returns the gsn data directory, e.g. ~/.local/share/gsn
+func DataDir() (string, error) { return Dir(Data) }
+
+// CacheDir returns the gsn cache directory, e.g. ~/.cache/gsn
+func CacheDir() (string, error) { return Dir(Cache) }
+
+// Portable reports whether $GSN_HOME holds every directory
+func Portable() bool {
+	return os.Getenv(HomeEnv) != ""
+}
+
+// resolve returns the directory of kind:
+//   - with $GSN_HOME, $GSN_HOME/<kind>
+//   - on Windows, %AppData%\gsn for config and %LocalAppData%\gsn\<kind> for the others
+//   - on macOS, ~/Library/Application Support/gsn for config and ~/Library/Caches/gsn for cache. State and data
+//     follow XDG like on Linux, Application Support already being the config dir.
+//   - elsewhere, the XDG base directories: $XDG_CONFIG_HOME, $XDG_STATE_HOME, $XDG_DATA_HOME and $XDG_CACHE_HOME,
+//     ~/.config, ~/.local/state, ~/.local/share and ~/.cache when unset. Relative values are ignored as the spec asks.
+func (e env) resolve(kind Kind) (string, error) {
+	if home := e.getenv(HomeEnv); home != "" {
+		abs, err := filepath.Abs(home)
+		if err != nil {
+			return "", fmt.Errorf("invalid $%s: %w", HomeEnv, err)
+		}
+		return filepath.Join(abs, string(kind)), nil
+	}
+
+	switch e.goos {
+	case "windows":
+		name, elem := "LocalAppData", []string{appName, string(kind)}
+		if kind == Config {
+			name, elem = "AppData", []string{appName}
+		}
+		dir := e.getenv(name)
+		if dir == "" {
+			return "", fmt.Errorf("cannot locate %s dir: %%%s%% is not defined", kind, name)
+		}
+		return filepath.Join(append([]string{dir}, elem...)...), nil
+	case "darwin", "ios":
+		switch kind {
+		case Config:
+			return e.homeDir(kind, "Library", "Application Support", appName)
+		case Cache:
+			return e.homeDir(kind, "Library", "Caches", appName)
+		}
+	}
+
+	switch kind {
+	case Config:
+		return e.baseDir("XDG_CONFIG_HOME", ".config", kind, appName)
+	case State:
+		return e.baseDir("XDG_STATE_HOME", filepath.Join(".local", "state"), kind, appName)
+	case Data:
+		return e.baseDir("XDG_DATA_HOME", filepath.Join(".local", "share"), kind, appName)
+	case Cache:
+		return e.baseDir("XDG_CACHE_HOME", ".cache", kind, appName)
+	}
+	return "", fmt.Errorf("unknown directory kind '%s'", kind)
+}
+
+// baseDir joins elem to the absolute directory in the XDG variable name, or to fallback below the home directory
+func (e env) baseDir(name string, fallback string, kind Kind, elem ...string) (string, error) {
+	if dir := e.getenv(name); dir != "" && filepath.IsAbs(dir) {
+		return filepath.Join(append([]string{dir}, elem...)...), nil
+	}
+	return e.homeDir(kind, append([]string{fallback}, elem...)...)
+}
+
+// homeDir joins elem to the home directory, found like os.UserHomeDir does
+func (e env) homeDir(kind Kind, elem ...string) (string, error) {
+	name := "HOME"
+	switch e.goos {
+	case "windows":
+		name = "USERPROFILE"
+	case "plan9":
+		name = "home"
+	}
+	home := e.getenv(name)
+	if home == "" {
+		return "", fmt.Errorf("cannot locate %s dir: $%s is not defined", kind, name)
+	}
+	return filepath.Join(append([]string{home}, elem...)...), nil
+}
@@ -0,0 +1,144 @@
+package paths
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeEnv is an env on goos with only the variables in vars set
+func fakeEnv(goos string, vars map[string]string) env {
+	return env{goos: goos, getenv: func(name string) string { return vars[name] }}
+}
+
+func TestResolvePerOS(t *testing.T) {
+	linuxHome := map[string]string{"HOME": "/home/ana"}
+	xdg := map[string]string{
+		"HOME":            "/home/ana",
+		"XDG_CONFIG_HOME": "/xdg/config",
+		"XDG_STATE_HOME":  "/xdg/state",
+		"XDG_DATA_HOME":   "/xdg/data",
+		"XDG_CACHE_HOME":  "/xdg/cache",
+	}
+	windows := map[string]string{
+		"USERPROFILE":  `C:\Users\ana`,
+		"AppData":      `C:\Users\ana\AppData\Roaming`,
+		"LocalAppData": `C:\Users\ana\AppData\Local`,
+		// XDG variables set by a POSIX shell on Windows are not read there
+		"XDG_CONFIG_HOME": "/xdg/config",
+	}
+	macHome := map[string]string{"HOME": "/Users/ana", "XDG_STATE_HOME": "/xdg/state"}
+
+	tests := []struct {
+		name string
+		goos string
+		vars map[string]string
+		want map[Kind]string
+	}{
+		{"linux defaults", "linux", linuxHome, map[Kind]string{
+			Config: "/home/ana/.config/gsn",
+			State:  "/home/ana/.local/state/gsn",
+			Data:   "/home/ana/.local/share/gsn",
+			Cache:  "/home/ana/.cache/gsn",
+		}},
+		{"linux XDG", "linux", xdg, map[Kind]string{
+			Config: "/xdg/config/gsn",
+			State:  "/xdg/state/gsn",
+			Data:   "/xdg/data/gsn",
+			Cache:  "/xdg/cache/gsn",
+		}},
+		{"relative XDG ignored", "freebsd", map[string]string{"HOME": "/home/ana", "XDG_CONFIG_HOME": "config", "XDG_CACHE_HOME": "./cache"}, map[Kind]string{
+			Config: "/home/ana/.config/gsn",
+			Cache:  "/home/ana/.cache/gsn",
+		}},
+		{"windows", "windows", windows, map[Kind]string{
+			Config: filepath.Join(`C:\Users\ana\AppData\Roaming`, "gsn"),
+			State:  filepath.Join(`C:\Users\ana\AppData\Local`, "gsn", "state"),
+			Data:   filepath.Join(`C:\Users\ana\AppData\Local`, "gsn", "data"),
+			Cache:  filepath.Join(`C:\Users\ana\AppData\Local`, "gsn", "cache"),
+		}},
+		{"macOS", "darwin", macHome, map[Kind]string{
+			Config: "/Users/ana/Library/Application Support/gsn",
+			State:  "/xdg/state/gsn",
+			Data:   "/Users/ana/.local/share/gsn",
+			Cache:  "/Users/ana/Library/Caches/gsn",
+		}},
+		{"plan9", "plan9", map[string]string{"home": "/usr/ana"}, map[Kind]string{
+			Config: "/usr/ana/.config/gsn",
+			State:  "/usr/ana/.local/state/gsn",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := fakeEnv(test.goos, test.vars)
+			for kind, want := range test.want {
+				if got, err := e.resolve(kind); err != nil || got != want {
+					t.Errorf("%s dir = %q, %v, want %q", kind, got, err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestResolvePortable(t *testing.T) {
+	// Every OS keeps every kind below $GSN_HOME, whatever else is set
+	for _, goos := range []string{"linux", "windows", "darwin"} {
+		e := fakeEnv(goos, map[string]string{HomeEnv: "/media/stick/gsn", "HOME": "/home/ana", "AppData": `C:\AppData`, "XDG_STATE_HOME": "/xdg/state"})
+		for _, kind := range Kinds {
+			if got, err := e.resolve(kind); err != nil || got != filepath.Join("/media/stick/gsn", string(kind)) {
+				t.Errorf("%s: %s dir = %q, %v", goos, kind, got, err)
+			}
+		}
+	}
+
+	// A relative $GSN_HOME is taken from the working directory
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if got, err := fakeEnv("linux", map[string]string{HomeEnv: "portable"}).resolve(State); err != nil || got != filepath.Join(dir, "portable", "state") {
+		t.Errorf("relative $GSN_HOME: state dir = %q, %v", got, err)
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	tests := []struct {
+		goos string
+		vars map[string]string
+		kind Kind
+		want string
+	}{
+		{"linux", nil, Config, "cannot locate config dir: $HOME is not defined"},
+		{"linux", map[string]string{"XDG_CACHE_HOME": "relative"}, Cache, "cannot locate cache dir: $HOME is not defined"},
+		{"darwin", nil, Cache, "cannot locate cache dir: $HOME is not defined"},
+		{"windows", map[string]string{"USERPROFILE": `C:\Users\ana`}, Config, "cannot locate config dir: %AppData% is not defined"},
+		{"windows", map[string]string{"AppData": `C:\AppData`}, State, "cannot locate state dir: %LocalAppData% is not defined"},
+		{"plan9", nil, Data, "cannot locate data dir: $home is not defined"},
+		{"linux", map[string]string{"HOME": "/home/ana"}, Kind("logs"), "unknown directory kind 'logs'"},
+	}
+	for _, test := range tests {
+		if got, err := fakeEnv(test.goos, test.vars).resolve(test.kind); err == nil || err.Error() != test.want {
+			t.Errorf("%s %s dir = %q, %v, want the error %q", test.goos, test.kind, got, err, test.want)
+		}
+	}
+}
+
+func TestDirReadsTheEnvironment(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv(HomeEnv, home)
+	if !Portable() {
+		t.Error("Portable() = false with $GSN_HOME set")
+	}
+	dirs := map[string]func() (string, error){"config": ConfigDir, "state": StateDir, "data": DataDir, "cache": CacheDir}
+	for kind, dir := range dirs {
+		if got, err := dir(); err != nil || got != filepath.Join(home, kind) {
+			t.Errorf("%s dir = %q, %v", kind, got, err)
+		}
+	}
+
+	t.Setenv(HomeEnv, "")
+	if Portable() {
+		t.Error("Portable() = true with $GSN_HOME empty")
+	}
+	if got, err := StateDir(); err != nil || !strings.HasSuffix(got, "gsn") || strings.HasPrefix(got, home) {
+		t.Errorf("state dir without $GSN_HOME = %q, %v", got, err)
+	}
+}
@@ -0,0 +1,153 @@
+package progress
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/redact"
+
+	"github.com/spf13/cobra"
+)
+
+// Modes of --progress
+const (
+	ModeBar  = "bar"
+	ModeJSON = "json"
+)
+
+// eventInterval is how often a tick event may be written, one is written only when the progress moved; tests
+// shorten it
+var eventInterval = 250 * time.Millisecond
+
+// eventSchemaVersion is the version of the event layout, raised when a field is renamed or changes meaning.
+// Fields may be added without raising it.
+const eventSchemaVersion = 1
+
+// Events of --progress json, one JSON object a line on stderr. start is written once, when the first phase
+// begins; tick when the progress moved, at most every eventInterval; done when gsn exits, failures included.
+// Lines of stderr that do not start with { are messages, like warnings.
+type startEvent struct {
+	Event         string `json:"event"`
+	SchemaVersion int    `json:"schema_version"`
+	Command       string `json:"command"`
+	Phase         string `json:"phase"`
+	Total         int64  `json:"total"`
+}
+
+type tickEvent struct {
+	Event      string `json:"event"`
+	Phase      string `json:"phase"`
+	Done       int64  `json:"done"`
+	Total      int64  `json:"total"`
+	FilesDone  int64  `json:"files_done"`
+	FilesTotal int64  `json:"files_total"`
+}
+
+type doneEvent struct {
+	Event      string `json:"event"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// eventStream writes the events of a run, it is nil unless --progress json is set
+type eventStream struct {
+	command string
+	started time.Time
+
+	once sync.Once
+	stop chan struct{}
+	done chan struct{}
+	// last is the last tick written, a tick repeating it is left out
+	last tickEvent
+}
+
+var events *eventStream
+
+// AddFlag registers the persistent --progress flag on the root command
+func AddFlag(cmd *cobra.Command) {
+	cmd.PersistentFlags().String("progress", ModeBar, "How progress is shown: bar, or json for newline-delimited JSON events on stderr")
+}
+
+// Configure reads --progress. With json the bars of this package draw nothing and every phase they count is
+// reported as events instead.
+func Configure(cmd *cobra.Command) error {
+	mode, _ := cmd.Flags().GetString("progress")
+	switch mode {
+	case ModeBar:
+		return nil
+	case ModeJSON:
+	default:
+		return clierr.Newf(clierr.Usage, "invalid --progress '%s', use bar or json", mode)
+	}
+	events = &eventStream{command: cmd.CommandPath(), started: time.Now(), stop: make(chan struct{}), done: make(chan struct{})}
+	clierr.AtExit(events.finish)
+	return nil
+}
+
+// JSONEvents reports whether progress is written as events rather than drawn
+func JSONEvents() bool {
+	return events != nil
+}
+
+// wake starts writing events once the first phase begins. It never reads the progress itself, the bar calling
+// it may hold the lock its source takes.
+func (e *eventStream) wake() {
+	if e == nil {
+		return
+	}
+	e.once.Do(func() {
+		go e.run()
+	})
+}
+
+func (e *eventStream) run() {
+	defer close(e.done)
+	name, _, _, total := readProgress()
+	e.write(startEvent{Event: "start", SchemaVersion: eventSchemaVersion, Command: e.command, Phase: name, Total: total})
+
+	ticker := time.NewTicker(eventInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.tick()
+		case <-e.stop:
+			e.tick()
+			return
+		}
+	}
+}
+
+// tick writes the progress when it moved since the last tick
+func (e *eventStream) tick() {
+	name, _, done, total := readProgress()
+	t := tickEvent{Event: "tick", Phase: name, Done: done, Total: total, FilesDone: filesDone.Load(), FilesTotal: filesTotal.Load()}
+	if t == e.last {
+		return
+	}
+	e.last = t
+	e.write(t)
+}
+
+// finish writes the last tick and done, when a phase ever began. It runs when gsn exits.
+func (e *eventStream) finish() {
+	running := true
+	e.once.Do(func() { running = false })
+	if !running {
+		return
+	}
+	close(e.stop)
+	<-e.done
+	e.write(doneEvent{Event: "done", DurationMS: time.Since(e.started).Milliseconds()})
+}
+
+// write prints an event as a line of stderr, in a single write so other output cannot split it
+func (e *eventStream) write(event any) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	os.Stderr.Write(append(redact.JSON(data), '\n'))
+}
@@ -0,0 +1,184 @@
+package progress
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// useEvents turns on --progress json for the test, with ticks every interval, and returns the stream
+func useEvents(t *testing.T, interval time.Duration) *eventStream {
+	t.Helper()
+	was := eventInterval
+	eventInterval = interval
+	root := &cobra.Command{Use: "gsn"}
+	AddFlag(root)
+	var err error
+	root.AddCommand(&cobra.Command{Use: "cmp", Run: func(cmd *cobra.Command, args []string) { err = Configure(cmd) }})
+	root.SetArgs([]string{"cmp", "--progress", ModeJSON})
+	if execErr := root.Execute(); execErr != nil || err != nil {
+		t.Fatal(execErr, err)
+	}
+	filesDone.Store(0)
+	filesTotal.Store(0)
+	t.Cleanup(func() {
+		eventInterval, events = was, nil
+		sourceMu.Lock()
+		sourcePhase, readSource = "", nil
+		sourceMu.Unlock()
+	})
+	return events
+}
+
+// TestEventSchema pins the fields of every event. Renaming one or changing what it means breaks consumers: it
+// raises eventSchemaVersion and this test with it. Adding one only extends the expected lines.
+func TestEventSchema(t *testing.T) {
+	tests := []struct {
+		event any
+		want  string
+	}{
+		{startEvent{Event: "start", SchemaVersion: eventSchemaVersion, Command: "gsn cmp", Phase: "compressing", Total: 4096},
+			`{"event":"start","schema_version":1,"command":"gsn cmp","phase":"compressing","total":4096}`},
+		{tickEvent{Event: "tick", Phase: "compressing", Done: 1024, Total: 4096, FilesDone: 1, FilesTotal: 4},
+			`{"event":"tick","phase":"compressing","done":1024,"total":4096,"files_done":1,"files_total":4}`},
+		// Zero values are written too, a consumer never has to tell a missing field from a zero
+		{tickEvent{Event: "tick"},
+			`{"event":"tick","phase":"","done":0,"total":0,"files_done":0,"files_total":0}`},
+		{doneEvent{Event: "done", DurationMS: 1500},
+			`{"event":"done","duration_ms":1500}`},
+	}
+	for _, test := range tests {
+		data, err := json.Marshal(test.event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != test.want {
+			t.Errorf("%T =\n%s\nwant\n%s", test.event, data, test.want)
+		}
+	}
+}
+
+// TestEventStreamConsumer reads the events the way a consumer does, line by line while the run goes on, with
+// copies in several goroutines and warnings written to stderr in between. Every line is whole, start comes
+// first and done last, and the ticks in between never go back.
+func TestEventStreamConsumer(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stderr
+	os.Stderr = w
+	t.Cleanup(func() { os.Stderr = saved })
+
+	var lines []string
+	consumed := make(chan struct{})
+	go func() {
+		defer close(consumed)
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+	}()
+
+	stream := useEvents(t, 5*time.Millisecond)
+	const files, fileSize = 4, 32 << 10
+	AddFiles(files)
+	bar := NewBytesStderr(files*fileSize, "compressing")
+	var wg sync.WaitGroup
+	for range files {
+		wg.Go(func() {
+			if _, err := io.Copy(bar, &slowSource{left: fileSize, chunk: 1 << 10, delay: time.Millisecond}); err != nil {
+				t.Error(err)
+			}
+			FileDone()
+		})
+	}
+	stopWarnings := make(chan struct{})
+	warned := make(chan struct{})
+	go func() {
+		defer close(warned)
+		for {
+			select {
+			case <-stopWarnings:
+				return
+			case <-time.After(3 * time.Millisecond):
+				os.Stderr.Write([]byte("warning: notes.txt changed while it was read\n"))
+			}
+		}
+	}()
+	wg.Wait()
+	close(stopWarnings)
+	<-warned
+	stream.finish()
+	w.Close()
+	<-consumed
+
+	type event struct {
+		Event         string
+		SchemaVersion *int `json:"schema_version"`
+		Command       string
+		Phase         string
+		Done, Total   int64
+		FilesDone     int64  `json:"files_done"`
+		FilesTotal    int64  `json:"files_total"`
+		DurationMS    *int64 `json:"duration_ms"`
+	}
+	var got []event
+	warnings := 0
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "{") {
+			if line != "warning: notes.txt changed while it was read" {
+				t.Fatalf("message line split or mixed with an event: %q", line)
+			}
+			warnings++
+			continue
+		}
+		var e event
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("event line is not whole: %v\n%q", err, line)
+		}
+		got = append(got, e)
+	}
+	if warnings == 0 || len(got) < 3 {
+		t.Fatalf("read %d event(s) and %d warning(s), want start, ticks, done and warnings", len(got), warnings)
+	}
+
+	start, done, ticks := got[0], got[len(got)-1], got[1:len(got)-1]
+	if start.Event != "start" || start.SchemaVersion == nil || *start.SchemaVersion != eventSchemaVersion || start.Command != "gsn cmp" || start.Phase != "compressing" || start.Total != files*fileSize {
+		t.Errorf("first event = %+v", start)
+	}
+	if done.Event != "done" || done.DurationMS == nil || *done.DurationMS < 0 {
+		t.Errorf("last event = %+v", done)
+	}
+	moving := 0
+	for i, tick := range ticks {
+		if tick.Event != "tick" || tick.Phase != "compressing" || tick.Total != files*fileSize || tick.FilesTotal != files || tick.Done > tick.Total {
+			t.Errorf("tick %d = %+v", i, tick)
+		}
+		if i > 0 {
+			previous := ticks[i-1]
+			if tick.Done < previous.Done || tick.FilesDone < previous.FilesDone {
+				t.Errorf("tick %d went back: %+v after %+v", i, tick, previous)
+			}
+			if tick == previous {
+				t.Errorf("tick %d repeats the one before: %+v", i, tick)
+			}
+		}
+		if tick.Done > 0 && tick.Done < tick.Total {
+			moving++
+		}
+	}
+	if moving < 2 {
+		t.Errorf("%d tick(s) of the running copy, want the progress reported while it ran", moving)
+	}
+	if last := ticks[len(ticks)-1]; last.Done != files*fileSize || last.FilesDone != files {
+		t.Errorf("last tick = %+v, want every byte and file done", last)
+	}
+}
@@ -0,0 +1,202 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gsn-dev-tools/internals/redact"
+	"gsn-dev-tools/internals/style"
+	"gsn-dev-tools/internals/units"
+
+	"golang.org/x/term"
+)
+
+const (
+	multiBarWidth   = 20
+	multiDescWidth  = 24
+	multiRedrawRate = 100 * time.Millisecond
+)
+
+// Multi draws the bars of tasks running side by side, one line each, redrawn together in place on stderr.
+// Finished bars leave the block. When stderr is not a terminal no bar is drawn, Printf still prints.
+type Multi struct {
+	mu    sync.Mutex
+	out   io.Writer
+	bars  []*Bar
+	drawn int
+	live  bool
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// NewMulti starts redrawing the bars added to it until Stop is called
+func NewMulti() *Multi {
+	m := &Multi{
+		out:  os.Stderr,
+		live: term.IsTerminal(redact.Fd(os.Stderr)) && !JSONEvents(),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	watchStatus("", m.totals)
+	if !m.live {
+		close(m.done)
+		return m
+	}
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(multiRedrawRate)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.mu.Lock()
+				m.draw()
+				m.mu.Unlock()
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+	return m
+}
+
+// totals sums the bytes of every bar, it is the source of the status file
+func (m *Multi) totals() (int64, int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var current, total int64
+	for _, b := range m.bars {
+		current += b.current
+		total += b.total
+	}
+	return current, total
+}
+
+// Add appends the bar of a new task, sized later with ChangeMax64
+func (m *Multi) Add(description string) *Bar {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b := &Bar{multi: m, description: style.Package() + description, start: time.Now()}
+	m.bars = append(m.bars, b)
+	return b
+}
+
+// Printf prints a line above the bars
+func (m *Multi) Printf(format string, args ...any) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clear()
+	fmt.Fprintf(m.out, format, args...)
+	m.draw()
+}
+
+// Stop stops redrawing and removes the bars still drawn
+func (m *Multi) Stop() {
+	if m.live {
+		close(m.stop)
+	}
+	<-m.done
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clear()
+}
+
+// clear erases the drawn block and leaves the cursor where it started, m.mu is held
+func (m *Multi) clear() {
+	if m.drawn > 0 {
+		fmt.Fprintf(m.out, "\x1b[%dA\r\x1b[J", m.drawn)
+		m.drawn = 0
+	}
+}
+
+// draw redraws the block of unfinished bars, m.mu is held
+func (m *Multi) draw() {
+	if !m.live {
+		return
+	}
+	var sb strings.Builder
+	if m.drawn > 0 {
+		fmt.Fprintf(&sb, "\x1b[%dA", m.drawn)
+	}
+	lines := 0
+	for _, b := range m.bars {
+		if b.finished {
+			continue
+		}
+		sb.WriteString("\r\x1b[2K" + b.line() + "\n")
+		lines++
+	}
+	// A block shorter than the last one leaves stale lines below it
+	sb.WriteString("\x1b[J")
+	m.drawn = lines
+	io.WriteString(m.out, sb.String())
+}
+
+// Bar is the line of one task of a Multi, it is a Tracker
+type Bar struct {
+	multi       *Multi
+	description string
+	start       time.Time
+	current     int64
+	total       int64
+	finished    bool
+}
+
+func (b *Bar) Write(p []byte) (int, error) {
+	b.Add64(int64(len(p)))
+	return len(p), nil
+}
+
+func (b *Bar) Add64(n int64) error {
+	b.multi.mu.Lock()
+	b.current += n
+	b.multi.mu.Unlock()
+	return nil
+}
+
+func (b *Bar) ChangeMax64(total int64) {
+	b.multi.mu.Lock()
+	b.total = total
+	b.multi.mu.Unlock()
+}
+
+// Describe replaces the description of the bar, it is what Describe of this package calls
+func (b *Bar) Describe(description string) {
+	b.multi.mu.Lock()
+	b.description = description
+	b.multi.mu.Unlock()
+}
+
+// Finish removes the bar from the block
+func (b *Bar) Finish() error {
+	b.multi.mu.Lock()
+	defer b.multi.mu.Unlock()
+	if !b.finished {
+		b.finished = true
+		b.multi.draw()
+	}
+	return nil
+}
+
+// line renders the bar as the bars of NewBytes look in plain mode, m.mu is held
+func (b *Bar) line() string {
+	description := []rune(b.description)
+	if len(description) > multiDescWidth {
+		description = append(description[:multiDescWidth-1], '~')
+	}
+	percent := 0
+	if b.total > 0 {
+		percent = int(min(b.current*100/b.total, 100))
+	}
+	filled := percent * multiBarWidth / 100
+	bar := strings.Repeat("=", filled)
+	if filled < multiBarWidth {
+		bar += ">" + strings.Repeat(".", multiBarWidth-filled-1)
+	}
+	return fmt.Sprintf("%-*s %3d%% [%s] (%s/%s, %s)", multiDescWidth, string(description), percent, bar,
+		units.FormatBytes(b.current), units.FormatBytes(b.total), units.FormatRate(b.current, time.Since(b.start)))
+}
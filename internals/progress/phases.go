@@ -0,0 +1,381 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gsn-dev-tools/internals/redact"
+	"gsn-dev-tools/internals/style"
+	"gsn-dev-tools/internals/units"
+
+	"github.com/rivo/uniseg"
+	"golang.org/x/term"
+)
+
+const (
+	// phaseBarMinWidth and phaseBarMaxWidth bound the bar of the active phase, below the minimum it is left out
+	phaseBarMinWidth = 10
+	phaseBarMaxWidth = 40
+	// phaseFallbackWidth is the width drawn at when the terminal does not tell its size
+	phaseFallbackWidth = 80
+)
+
+// phaseState is where a phase is in its run
+type phaseState int
+
+const (
+	phasePending phaseState = iota
+	phaseActive
+	phaseDone
+	phaseFailed
+	phaseSkipped
+)
+
+// Phases shows the named phases of a multi-stage command on stderr: a header line listing them, those done
+// checked, and the bar of the active phase beneath it, redrawn in place at the current width of the terminal.
+// When stderr is not a terminal each phase prints a plain line as it starts and as it ends instead, and under
+// --progress json nothing but the lines of Printf.
+type Phases struct {
+	mu    sync.Mutex
+	out   io.Writer
+	live  bool
+	width func() int
+	// quiet leaves out the plain lines too, the events of --progress json report the phases
+	quiet bool
+
+	names   []string
+	states  []phaseState
+	started []time.Time
+	bar     *PhaseBar
+
+	// drawn holds the display widths of the lines drawn last, the rows they take depend on the width they are
+	// erased at, as a terminal made narrower wraps them
+	drawn []int
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewPhases starts showing the phases of a run, in the order they run, until Stop is called
+func NewPhases(names ...string) *Phases {
+	fd := redact.Fd(os.Stderr)
+	p := newPhases(os.Stderr, term.IsTerminal(fd) && !JSONEvents(), func() int {
+		width, _, err := term.GetSize(fd)
+		if err != nil {
+			return 0
+		}
+		return width
+	}, names)
+	p.quiet = JSONEvents()
+	return p
+}
+
+// newPhases draws on out, in place when live, at the width returned by width; 0 is an unknown width
+func newPhases(out io.Writer, live bool, width func() int, names []string) *Phases {
+	p := &Phases{
+		out:     out,
+		live:    live,
+		width:   width,
+		names:   names,
+		states:  make([]phaseState, len(names)),
+		started: make([]time.Time, len(names)),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if !live {
+		close(p.done)
+		return p
+	}
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(multiRedrawRate)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.mu.Lock()
+				p.draw()
+				p.mu.Unlock()
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// index returns the position of the phase called name, it panics on a name the run was not started with
+func (p *Phases) index(name string) int {
+	for i, n := range p.names {
+		if n == name {
+			return i
+		}
+	}
+	panic(fmt.Sprintf("progress: unknown phase %q", name))
+}
+
+// Start makes name the active phase and returns the bar its progress is counted on, sized with ChangeMax64.
+// The phase is also the phase of the status file.
+func (p *Phases) Start(name string) *PhaseBar {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i := p.index(name)
+	p.states[i], p.started[i] = phaseActive, time.Now()
+	p.bar = &PhaseBar{phases: p, start: time.Now()}
+	watchStatus(name, p.bar.read)
+	if !p.live {
+		if !p.quiet {
+			fmt.Fprintf(p.out, "==> %s\n", name)
+		}
+		return p.bar
+	}
+	p.draw()
+	return p.bar
+}
+
+// Done ends the phase called name, as failed when err is set
+func (p *Phases) Done(name string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i := p.index(name)
+	p.states[i], p.bar = phaseDone, nil
+	if err != nil {
+		p.states[i] = phaseFailed
+	}
+	if !p.live {
+		if p.quiet {
+			return
+		}
+		fmt.Fprintf(p.out, "%s %s (%s)\n", p.mark(p.states[i]), name, units.FormatDuration(time.Since(p.started[i]).Round(time.Millisecond)))
+		return
+	}
+	p.draw()
+}
+
+// Skip marks the phase called name as not run
+func (p *Phases) Skip(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.states[p.index(name)] = phaseSkipped
+	if !p.live {
+		if p.quiet {
+			return
+		}
+		fmt.Fprintf(p.out, "%s %s\n", p.mark(phaseSkipped), name)
+		return
+	}
+	p.draw()
+}
+
+// Printf prints a line above the phases
+func (p *Phases) Printf(format string, args ...any) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	fmt.Fprintf(p.out, format, args...)
+	p.draw()
+}
+
+// Writer returns a writer printing above the phases, for messages of the running phase. Each write should
+// end in a newline.
+func (p *Phases) Writer() io.Writer {
+	return phaseWriter{p}
+}
+
+type phaseWriter struct{ phases *Phases }
+
+func (w phaseWriter) Write(b []byte) (int, error) {
+	w.phases.Printf("%s", b)
+	return len(b), nil
+}
+
+// Stop stops redrawing and leaves the header line with the outcome of every phase
+func (p *Phases) Stop() {
+	if p.live {
+		close(p.stop)
+	}
+	<-p.done
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.live {
+		return
+	}
+	p.bar = nil
+	p.draw()
+	p.drawn = nil
+}
+
+// mark is the glyph of a phase state in the header
+func (p *Phases) mark(state phaseState) string {
+	marks := map[phaseState][2]string{
+		phasePending: {"·", "[ ]"},
+		phaseActive:  {"▸", "[>]"},
+		phaseDone:    {"✓", "[x]"},
+		phaseFailed:  {"✗", "[!]"},
+		phaseSkipped: {"-", "[-]"},
+	}
+	if style.Plain() {
+		return marks[state][1]
+	}
+	return marks[state][0]
+}
+
+// currentWidth is the width of the terminal, or the fallback when it is not known
+func (p *Phases) currentWidth() int {
+	if width := p.width(); width > 0 {
+		return width
+	}
+	return phaseFallbackWidth
+}
+
+// clear erases the drawn lines and leaves the cursor where they started, p.mu is held
+func (p *Phases) clear() {
+	if len(p.drawn) == 0 {
+		return
+	}
+	width := p.currentWidth()
+	rows := 0
+	for _, w := range p.drawn {
+		rows += max(1, (w+width-1)/width)
+	}
+	fmt.Fprintf(p.out, "\x1b[%dA\r\x1b[J", rows)
+	p.drawn = nil
+}
+
+// draw redraws the header and the bar of the active phase, p.mu is held. Lines stay one cell short of the
+// width, so a terminal that wraps at the last column does not add a row.
+func (p *Phases) draw() {
+	if !p.live {
+		return
+	}
+	p.clear()
+	width := p.currentWidth() - 1
+	lines := []string{p.header(width)}
+	if p.bar != nil {
+		lines = append(lines, p.bar.line(width))
+	}
+	var sb strings.Builder
+	for _, line := range lines {
+		sb.WriteString("\r\x1b[2K" + line + "\n")
+		p.drawn = append(p.drawn, uniseg.StringWidth(line))
+	}
+	io.WriteString(p.out, sb.String())
+}
+
+// header lists the phases with their marks, or only the active one with its position when they do not fit
+func (p *Phases) header(width int) string {
+	items := make([]string, len(p.names))
+	current := -1
+	for i, name := range p.names {
+		items[i] = p.mark(p.states[i]) + " " + name
+		if p.states[i] == phaseActive || (current < 0 && p.states[i] == phasePending) {
+			current = i
+		}
+	}
+	if line := strings.Join(items, "  "); uniseg.StringWidth(line) <= width {
+		return line
+	}
+	if current < 0 {
+		current = len(p.names) - 1
+	}
+	return fitWidth(fmt.Sprintf("[%d/%d] %s", current+1, len(p.names), items[current]), width)
+}
+
+// fitWidth cuts s to at most width display cells, marking the cut with ~
+func fitWidth(s string, width int) string {
+	if uniseg.StringWidth(s) <= width {
+		return s
+	}
+	var b strings.Builder
+	used := 0
+	g := uniseg.NewGraphemes(s)
+	for g.Next() {
+		if used+g.Width() > width-1 {
+			break
+		}
+		b.WriteString(g.Str())
+		used += g.Width()
+	}
+	return b.String() + "~"
+}
+
+// PhaseBar counts the progress of the active phase of a Phases, it is a Tracker
+type PhaseBar struct {
+	phases      *Phases
+	description string
+	start       time.Time
+	current     int64
+	total       int64
+}
+
+func (b *PhaseBar) Write(p []byte) (int, error) {
+	b.Add64(int64(len(p)))
+	return len(p), nil
+}
+
+func (b *PhaseBar) Add64(n int64) error {
+	b.phases.mu.Lock()
+	b.current += n
+	b.phases.mu.Unlock()
+	return nil
+}
+
+func (b *PhaseBar) ChangeMax64(total int64) {
+	b.phases.mu.Lock()
+	b.total = total
+	b.phases.mu.Unlock()
+}
+
+// Describe sets the text shown before the bar, it is what Describe of this package calls
+func (b *PhaseBar) Describe(description string) {
+	b.phases.mu.Lock()
+	b.description = description
+	b.phases.mu.Unlock()
+}
+
+// Finish does nothing, the bar goes when its phase is done
+func (b *PhaseBar) Finish() error {
+	return nil
+}
+
+// read is the source of the status file while the phase runs
+func (b *PhaseBar) read() (int64, int64) {
+	b.phases.mu.Lock()
+	defer b.phases.mu.Unlock()
+	return b.current, b.total
+}
+
+// line renders the bar at most width cells wide, p.mu is held. Without a total it shows the bytes counted and
+// the time spent, and the bar is left out when the width has no room for it.
+func (b *PhaseBar) line(width int) string {
+	elapsed := time.Since(b.start)
+	prefix := "  "
+	if b.description != "" {
+		prefix += b.description + " "
+	}
+	if b.total <= 0 {
+		counted := ""
+		if b.current > 0 {
+			counted = units.FormatBytes(b.current) + ", "
+		}
+		return fitWidth(prefix+"("+counted+units.FormatDuration(elapsed.Round(time.Second))+")", width)
+	}
+
+	percent := int(min(b.current*100/b.total, 100))
+	numbers := fmt.Sprintf("%3d%%", percent)
+	detail := fmt.Sprintf(" (%s/%s, %s)", units.FormatBytes(b.current), units.FormatBytes(b.total), units.FormatRate(b.current, elapsed))
+	barWidth := min(width-uniseg.StringWidth(prefix+numbers+detail)-3, phaseBarMaxWidth)
+	if barWidth < phaseBarMinWidth {
+		return fitWidth(prefix+numbers+detail, width)
+	}
+	filled := percent * barWidth / 100
+	bar := strings.Repeat("=", filled)
+	if filled < barWidth {
+		bar += ">" + strings.Repeat(".", barWidth-filled-1)
+	}
+	return prefix + numbers + " [" + bar + "]" + detail
+}
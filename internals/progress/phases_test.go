@@ -0,0 +1,296 @@
+package progress
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"gsn-dev-tools/internals/style"
+)
+
+// vt is the screen of a terminal of the given width, enough of one for the sequences Phases writes: \r, \n as
+// the tty turns it into \r\n, ESC[nA, ESC[J and ESC[2K. It wraps at the last column like xterm, and a resize
+// reflows the lines it wrapped like most terminals do.
+type vt struct {
+	width int
+	rows  [][]rune
+	// wrapped marks the rows continued on the next one
+	wrapped     []bool
+	row, col    int
+	pendingWrap bool
+}
+
+func newVT(width int) *vt {
+	t := &vt{width: width}
+	t.ensureRow()
+	return t
+}
+
+func (t *vt) ensureRow() {
+	for len(t.rows) <= t.row {
+		t.rows = append(t.rows, nil)
+		t.wrapped = append(t.wrapped, false)
+	}
+}
+
+var csi = regexp.MustCompile(`^\x1b\[(\d*)([A-Za-z])`)
+
+func (t *vt) Write(p []byte) (int, error) {
+	for s := string(p); s != ""; {
+		if m := csi.FindStringSubmatch(s); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			switch m[2] {
+			case "A":
+				t.row, t.pendingWrap = max(0, t.row-max(n, 1)), false
+			case "J":
+				t.rows[t.row] = t.rows[t.row][:min(t.col, len(t.rows[t.row]))]
+				t.rows, t.wrapped = t.rows[:t.row+1], t.wrapped[:t.row+1]
+				t.wrapped[t.row] = false
+			case "K":
+				if n != 2 {
+					return 0, errors.New("vt: only ESC[2K is known")
+				}
+				t.rows[t.row], t.wrapped[t.row] = nil, false
+			default:
+				return 0, errors.New("vt: unknown sequence " + strconv.Quote(m[0]))
+			}
+			s = s[len(m[0]):]
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s)
+		s = s[size:]
+		switch r {
+		case '\r':
+			t.col, t.pendingWrap = 0, false
+		case '\n':
+			t.row, t.col, t.pendingWrap = t.row+1, 0, false
+			t.ensureRow()
+		case '\x1b':
+			return 0, errors.New("vt: unknown escape")
+		default:
+			if t.pendingWrap {
+				t.wrapped[t.row] = true
+				t.row, t.col, t.pendingWrap = t.row+1, 0, false
+				t.ensureRow()
+			}
+			for len(t.rows[t.row]) <= t.col {
+				t.rows[t.row] = append(t.rows[t.row], ' ')
+			}
+			t.rows[t.row][t.col] = r
+			if t.col == t.width-1 {
+				t.pendingWrap = true
+			} else {
+				t.col++
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// resize reflows the lines at a new width, the cursor staying at the start of its line
+func (t *vt) resize(width int) {
+	var lines [][]rune
+	cursorLine := 0
+	var line []rune
+	for i, row := range t.rows {
+		line = append(line, row...)
+		if i == t.row {
+			cursorLine = len(lines)
+		}
+		if !t.wrapped[i] {
+			lines = append(lines, line)
+			line = nil
+		}
+	}
+	t.width, t.rows, t.wrapped = width, nil, nil
+	for i, line := range lines {
+		if i == cursorLine {
+			t.row = len(t.rows)
+		}
+		for len(line) > width {
+			t.rows, t.wrapped = append(t.rows, line[:width]), append(t.wrapped, true)
+			line = line[width:]
+		}
+		t.rows, t.wrapped = append(t.rows, line), append(t.wrapped, false)
+	}
+	t.col, t.pendingWrap = 0, false
+}
+
+// screen returns the rows above the cursor, trimmed of trailing blanks; every row drawn fits in the width
+func (t *vt) screen() []string {
+	var rows []string
+	for _, row := range t.rows[:t.row] {
+		rows = append(rows, strings.TrimRight(string(row), " "))
+	}
+	return rows
+}
+
+// vtPhases draws phases live on a virtual terminal, redrawn only by the test: the ticker of the run is stopped
+func vtPhases(t *testing.T, width int, names ...string) (*Phases, *vt) {
+	t.Helper()
+	style.SetPlain(false)
+	screen := newVT(width)
+	p := newPhases(screen, true, func() int { return screen.width }, names)
+	close(p.stop)
+	<-p.done
+	p.stop, p.done = make(chan struct{}), make(chan struct{})
+	close(p.done)
+	return p, screen
+}
+
+// checkScreen compares the screen with want, where a line ending in ... only has to start with what precedes it
+func checkScreen(t *testing.T, step string, screen *vt, want ...string) {
+	t.Helper()
+	got := screen.screen()
+	ok := len(got) == len(want)
+	for i := 0; ok && i < len(want); i++ {
+		if prefix, cut := strings.CutSuffix(want[i], "..."); cut {
+			ok = strings.HasPrefix(got[i], prefix)
+		} else {
+			ok = got[i] == want[i]
+		}
+	}
+	if !ok {
+		t.Errorf("%s: screen at width %d =\n%s\nwant\n%s", step, screen.width, strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// TestPhasesFrames follows the frames of a run on a virtual terminal: each redraw replaces the previous one in
+// place, lines printed above stay, and a resize between redraws leaves no row of the wider frame behind
+func TestPhasesFrames(t *testing.T) {
+	p, screen := vtPhases(t, 80, "compress", "verify", "upload")
+
+	bar := p.Start("compress")
+	checkScreen(t, "started", screen, "▸ compress  · verify  · upload", "  (0s)")
+
+	bar.Describe("Compressing backup")
+	bar.ChangeMax64(100 << 20)
+	_ = bar.Add64(50 << 20)
+	p.mu.Lock()
+	for range 3 {
+		p.draw()
+	}
+	p.mu.Unlock()
+	checkScreen(t, "redrawn", screen, "▸ compress  · verify  · upload", "  Compressing backup  50% [=...")
+
+	p.Printf("warning: %s changed while it was read\n", "notes.txt")
+	_, _ = p.Writer().Write([]byte("warning: another one\n"))
+	checkScreen(t, "printed above", screen,
+		"warning: notes.txt changed while it was read",
+		"warning: another one",
+		"▸ compress  · verify  · upload",
+		"  Compressing backup  50% [...")
+
+	p.Done("compress", nil)
+	p.Start("verify")
+	checkScreen(t, "next phase", screen,
+		"warning: notes.txt changed while it was read", "warning: another one",
+		"✓ compress  ▸ verify  · upload", "  (0s)")
+	_ = p.bar.Add64(3 << 20)
+
+	// Narrower, the 80 column lines drawn last now take two rows each, all erased by the next redraw
+	p.mu.Lock()
+	screen.resize(30)
+	p.draw()
+	p.mu.Unlock()
+	checkScreen(t, "resized to 30", screen,
+		"warning: notes.txt changed whi", "le it was read", "warning: another one",
+		"[2/3] ▸ verify", "  (3.0 MiB, 0s)")
+
+	p.mu.Lock()
+	screen.resize(12)
+	p.draw()
+	p.mu.Unlock()
+	checkScreen(t, "resized to 12", screen,
+		"warning: not", "es.txt chang", "ed while it", "was read", "warning: ano", "ther one",
+		"[2/3] ▸ ve~", "  (3.0 MiB~")
+
+	p.Done("verify", errors.New("digest mismatch"))
+	p.Skip("upload")
+	p.mu.Lock()
+	screen.resize(80)
+	p.mu.Unlock()
+	p.Stop()
+	checkScreen(t, "stopped", screen,
+		"warning: notes.txt changed while it was read", "warning: another one",
+		"✓ compress  ✗ verify  - upload")
+}
+
+func TestPhasesBarWidth(t *testing.T) {
+	p, _ := vtPhases(t, 80, "compress")
+	bar := p.Start("compress")
+	bar.ChangeMax64(1000)
+	_ = bar.Add64(250)
+	// At most 40 cells of bar, 25% of them filled
+	if got, want := bar.line(79), "   25% ["+strings.Repeat("=", 10)+">"+strings.Repeat(".", 29)+"] (250 B/1000 B, "; !strings.HasPrefix(got, want) {
+		t.Errorf("line(79) = %q, want it to start with %q", got, want)
+	}
+	// Less room shortens the bar, the line filling the width
+	if got := bar.line(60); !strings.Contains(got, "[") || utf8.RuneCountInString(got) != 60 {
+		t.Errorf("line(60) = %q", got)
+	}
+	// Too narrow for a bar of 10 cells, the numbers are left
+	if got := bar.line(30); !strings.HasPrefix(got, "   25% (250 B/1000 B, ") || utf8.RuneCountInString(got) > 30 {
+		t.Errorf("line(30) = %q", got)
+	}
+	p.Stop()
+}
+
+func TestFitWidth(t *testing.T) {
+	tests := []struct {
+		s     string
+		width int
+		want  string
+	}{
+		{"compress", 8, "compress"},
+		{"compress", 7, "compre~"},
+		{"✓ 圧縮", 6, "✓ 圧縮"},
+		{"✓ 圧縮", 5, "✓ 圧~"},
+		// A wide grapheme that does not fit whole is left out
+		{"✓ 圧縮", 4, "✓ ~"},
+	}
+	for _, test := range tests {
+		if got := fitWidth(test.s, test.width); got != test.want {
+			t.Errorf("fitWidth(%q, %d) = %q, want %q", test.s, test.width, got, test.want)
+		}
+	}
+}
+
+// TestPhasesPlainLines checks the lines printed when stderr is not a terminal, where nothing is redrawn
+func TestPhasesPlainLines(t *testing.T) {
+	style.SetPlain(true)
+	defer style.SetPlain(false)
+	var out bytes.Buffer
+	p := newPhases(&out, false, func() int { return 0 }, []string{"compress", "verify", "upload"})
+	p.Start("compress")
+	p.Printf("warning: %s\n", "notes.txt changed")
+	p.Done("compress", nil)
+	p.Start("verify")
+	p.Done("verify", errors.New("digest mismatch"))
+	p.Skip("upload")
+	p.Stop()
+
+	want := regexp.MustCompile(`^==> compress\nwarning: notes.txt changed\n\[x\] compress \(\d+m?s\)\n==> verify\n\[!\] verify \(\d+m?s\)\n\[-\] upload\n$`)
+	if !want.MatchString(out.String()) {
+		t.Errorf("plain lines =\n%s", out.String())
+	}
+	if strings.Contains(out.String(), "\x1b") {
+		t.Error("escape sequences written to a file")
+	}
+
+	// Under --progress json only the lines of Printf are left
+	out.Reset()
+	p = newPhases(&out, false, func() int { return 0 }, []string{"compress"})
+	p.quiet = true
+	p.Start("compress")
+	p.Printf("warning: kept\n")
+	p.Done("compress", nil)
+	p.Stop()
+	if out.String() != "warning: kept\n" {
+		t.Errorf("quiet lines = %q", out.String())
+	}
+}
@@ -0,0 +1,103 @@
+package progress
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"gsn-dev-tools/internals/style"
+	"gsn-dev-tools/internals/units"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+// Tracker receives the progress of a long running operation. The bars of this package satisfy it, gsn daemon
+// records it so clients can poll the progress of a job.
+type Tracker interface {
+	io.Writer
+	Add64(n int64) error
+	ChangeMax64(total int64)
+	Finish() error
+}
+
+// NewBytes creates the byte based progress bar shared by long running file commands. Sizes use the binary
+// units of units.FormatBytes, or raw counts with --bytes. In plain mode the bar uses ASCII glyphs only and no
+// color codes.
+func NewBytes(total int64, description string) *progressbar.ProgressBar {
+	return watchBar(progressbar.NewOptions64(total, bytesOptions(description)...), description)
+}
+
+// NewBytesStderr is NewBytes drawn on stderr, for commands whose stdout carries results
+func NewBytesStderr(total int64, description string) *progressbar.ProgressBar {
+	options := bytesOptions(description)
+	if !JSONEvents() {
+		options = append(options, progressbar.OptionSetWriter(os.Stderr))
+	}
+	return watchBar(progressbar.NewOptions64(total, options...), description)
+}
+
+// watchBar makes a byte bar the source of the status file, it starts the phase named by its description
+func watchBar(bar *progressbar.ProgressBar, description string) *progressbar.ProgressBar {
+	watchStatus(description, func() (int64, int64) {
+		state := bar.State()
+		return state.CurrentNum, state.Max
+	})
+	return bar
+}
+
+func bytesOptions(description string) []progressbar.Option {
+	options := []progressbar.Option{
+		progressbar.OptionSetDescription(style.Package() + description),
+		progressbar.OptionShowBytes(!units.Raw()),
+		progressbar.OptionUseIECUnits(true),
+		progressbar.OptionShowCount(),
+		progressbar.OptionThrottle(65 * time.Millisecond), // Update rate for smoother display
+		progressbar.OptionClearOnFinish(),
+	}
+
+	if style.Plain() {
+		options = append(options,
+			progressbar.OptionSetTheme(progressbar.ThemeASCII),
+			progressbar.OptionSpinnerCustom([]string{"|", "/", "-", "\\"}),
+			progressbar.OptionEnableColorCodes(false),
+		)
+	}
+	// Under --progress json the events report the progress, the bar draws nothing. It still counts, an invisible
+	// bar would not, so it draws to nowhere.
+	if JSONEvents() {
+		options = append(options, progressbar.OptionSetWriter(io.Discard))
+	}
+	return options
+}
+
+// NewCount creates a progress bar counting items on stderr, for commands whose stdout carries results
+func NewCount(total int, description string) *progressbar.ProgressBar {
+	options := []progressbar.Option{
+		progressbar.OptionSetDescription(style.Package() + description),
+		progressbar.OptionSetWriter(os.Stderr),
+		progressbar.OptionShowCount(),
+		progressbar.OptionThrottle(65 * time.Millisecond),
+		progressbar.OptionClearOnFinish(),
+	}
+
+	if style.Plain() {
+		options = append(options,
+			progressbar.OptionSetTheme(progressbar.ThemeASCII),
+			progressbar.OptionSpinnerCustom([]string{"|", "/", "-", "\\"}),
+			progressbar.OptionEnableColorCodes(false),
+		)
+	}
+	// Under --progress json the events report the progress, the bar draws nothing
+	if JSONEvents() {
+		options = append(options, progressbar.OptionSetVisibility(false))
+	}
+
+	return progressbar.NewOptions(total, options...)
+}
+
+// Describe replaces the description of the bars of this package, other trackers have none and are left alone
+func Describe(t Tracker, description string) {
+	if bar, ok := t.(interface{ Describe(string) }); ok {
+		bar.Describe(style.Package() + description)
+	}
+}
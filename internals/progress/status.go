@@ -0,0 +1,230 @@
+package progress
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/output"
+	"gsn-dev-tools/internals/redact"
+	"gsn-dev-tools/internals/style"
+
+	"github.com/spf13/cobra"
+)
+
+// statusInterval is how often the status file is rewritten while a command runs, tests shorten it
+var statusInterval = 2 * time.Second
+
+// statusSchemaVersion is the version of the status file layout, raised when a field changes meaning
+const statusSchemaVersion = 1
+
+// Snapshot is the content of a status file. Bytes count the current phase, each phase like compressing or
+// verifying starts over from 0; files count the whole run. A total of 0 is not known.
+type Snapshot struct {
+	SchemaVersion int        `json:"schema_version"`
+	Command       string     `json:"command"`
+	PID           int        `json:"pid"`
+	State         string     `json:"state"`
+	Phase         string     `json:"phase"`
+	BytesDone     int64      `json:"bytes_done"`
+	BytesTotal    int64      `json:"bytes_total"`
+	FilesDone     int64      `json:"files_done"`
+	FilesTotal    int64      `json:"files_total"`
+	Percent       float64    `json:"percent"`
+	StartedAt     time.Time  `json:"started_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	ETA           *time.Time `json:"eta,omitempty"`
+	Error         string     `json:"error,omitempty"`
+}
+
+// Snapshot states
+const (
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+// statusFile publishes the progress of the running command. The bars only count as they always do, the file is
+// written by its own goroutine reading them every statusInterval, so the copy loops never wait on it.
+type statusFile struct {
+	path    string
+	command string
+	started time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+var (
+	statusMu sync.Mutex
+	status   *statusFile
+
+	// The phase running and the source of its bytes, set by the bars of this package and read by the status
+	// file and the events of --progress json
+	sourceMu    sync.Mutex
+	sourcePhase string
+	sourceStart time.Time
+	readSource  func() (int64, int64)
+
+	filesDone  atomic.Int64
+	filesTotal atomic.Int64
+)
+
+// AddStatusFlags registers --status-file and --keep-status on a long running command
+func AddStatusFlags(cmd *cobra.Command) {
+	cmd.Flags().String("status-file", "", "Keep a JSON snapshot of the progress in this file while running, e.g. for cron jobs")
+	cmd.Flags().Bool("keep-status", false, "Keep the status file with the final state instead of removing it")
+}
+
+// StartStatus starts writing the status file of --status-file, if any, and returns the func to call with the
+// outcome of the command. On success the file is removed unless --keep-status is set, a failure is kept with its
+// error. Like notifications, a status file that cannot be written only logs a warning. Nothing is written under
+// --dry-run.
+func StartStatus(cmd *cobra.Command, command string) func(err error) {
+	path, _ := cmd.Flags().GetString("status-file")
+	keep, _ := cmd.Flags().GetBool("keep-status")
+	if path == "" || dryrun.Enabled() {
+		return func(error) {}
+	}
+
+	now := time.Now()
+	s := &statusFile{path: path, command: command, started: now, stop: make(chan struct{}), done: make(chan struct{})}
+	filesDone.Store(0)
+	filesTotal.Store(0)
+	statusMu.Lock()
+	status = s
+	statusMu.Unlock()
+
+	if err := s.write(StatusRunning, nil); err != nil {
+		fmt.Fprintf(os.Stderr, style.Warning()+"Failed to write the status file '%s': %v\n", path, err)
+	}
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(statusInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				// A failed write is retried on the next tick, the final one reports it
+				_ = s.write(StatusRunning, nil)
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+
+	return func(runErr error) {
+		close(s.stop)
+		<-s.done
+		statusMu.Lock()
+		status = nil
+		statusMu.Unlock()
+
+		var err error
+		switch {
+		case runErr != nil:
+			err = s.write(StatusFailed, runErr)
+		case keep:
+			err = s.write(StatusDone, nil)
+		default:
+			if err = os.Remove(path); os.IsNotExist(err) {
+				err = nil
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, style.Warning()+"Failed to update the status file '%s': %v\n", path, err)
+		}
+	}
+}
+
+// watchStatus makes read the source of the bytes of the status file and the progress events, starting a new
+// phase; an empty phase keeps the name of the previous one. read may take locks held by the caller, it is only
+// called later.
+func watchStatus(name string, read func() (int64, int64)) {
+	sourceMu.Lock()
+	if name != "" {
+		sourcePhase = name
+	}
+	sourceStart, readSource = time.Now(), read
+	sourceMu.Unlock()
+	events.wake()
+}
+
+// readProgress returns the phase running, when it started and the bytes it counted so far
+func readProgress() (string, time.Time, int64, int64) {
+	sourceMu.Lock()
+	name, start, read := sourcePhase, sourceStart, readSource
+	sourceMu.Unlock()
+	var done, total int64
+	if read != nil {
+		done, total = read()
+	}
+	return name, start, done, total
+}
+
+// SetPhase names the current phase of the status file, for steps without a byte bar
+func SetPhase(phase string) {
+	watchStatus(phase, nil)
+}
+
+// AddFiles adds n to the number of files the run processes
+func AddFiles(n int) {
+	filesTotal.Add(int64(n))
+}
+
+// FileDone counts a processed file, it costs an atomic add so hot loops may call it
+func FileDone() {
+	filesDone.Add(1)
+}
+
+// snapshot reads the progress of the current phase
+func (s *statusFile) snapshot(state string, runErr error) Snapshot {
+	now := time.Now()
+	snap := Snapshot{
+		SchemaVersion: statusSchemaVersion,
+		Command:       s.command,
+		PID:           os.Getpid(),
+		State:         state,
+		FilesDone:     filesDone.Load(),
+		FilesTotal:    filesTotal.Load(),
+		StartedAt:     s.started.UTC(),
+		UpdatedAt:     now.UTC(),
+	}
+	if runErr != nil {
+		snap.Error = runErr.Error()
+	}
+
+	var phaseStart time.Time
+	snap.Phase, phaseStart, snap.BytesDone, snap.BytesTotal = readProgress()
+
+	switch {
+	case snap.BytesTotal > 0:
+		snap.Percent = float64(min(snap.BytesDone, snap.BytesTotal)) * 100 / float64(snap.BytesTotal)
+	case snap.FilesTotal > 0:
+		snap.Percent = float64(min(snap.FilesDone, snap.FilesTotal)) * 100 / float64(snap.FilesTotal)
+	}
+	snap.Percent = float64(int(snap.Percent*10)) / 10
+
+	// The ETA assumes the rate of the phase so far holds for the rest of it
+	elapsed := now.Sub(phaseStart)
+	if state == StatusRunning && snap.BytesDone > 0 && snap.BytesTotal > snap.BytesDone && elapsed > 0 {
+		left := time.Duration(float64(elapsed) * float64(snap.BytesTotal-snap.BytesDone) / float64(snap.BytesDone))
+		eta := now.Add(left).UTC().Truncate(time.Second)
+		snap.ETA = &eta
+	}
+	return snap
+}
+
+// write replaces the status file with a redacted snapshot, atomically so readers never see half of it
+func (s *statusFile) write(state string, runErr error) error {
+	data, err := json.MarshalIndent(s.snapshot(state, runErr), "", "  ")
+	if err != nil {
+		return err
+	}
+	// The phase and the error name what the run works on, as its output does
+	return output.WriteFileAtomic(s.path, append(redact.JSON(data), '\n'), 0o644)
+}
@@ -0,0 +1,189 @@
+package progress
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// slowSource is a reader of size bytes served a chunk at a time, each after delay, like a throttled disk
+type slowSource struct {
+	left  int
+	chunk int
+	delay time.Duration
+}
+
+func (s *slowSource) Read(p []byte) (int, error) {
+	if s.left == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(s.delay)
+	n := min(len(p), s.chunk, s.left)
+	clear(p[:n])
+	s.left -= n
+	return n, nil
+}
+
+// useStatusInterval makes the status file rewritten every interval for the test
+func useStatusInterval(t *testing.T, interval time.Duration) {
+	t.Helper()
+	was := statusInterval
+	statusInterval = interval
+	t.Cleanup(func() { statusInterval = was })
+}
+
+// statusCommand returns a command parsed with args and the status flags
+func statusCommand(t *testing.T, args ...string) *cobra.Command {
+	t.Helper()
+	cmd := &cobra.Command{Use: "cmp"}
+	AddStatusFlags(cmd)
+	if err := cmd.ParseFlags(args); err != nil {
+		t.Fatal(err)
+	}
+	return cmd
+}
+
+func readSnapshot(t *testing.T, path string) Snapshot {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snap Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		t.Fatalf("status file is not a whole snapshot: %v\n%s", err, data)
+	}
+	return snap
+}
+
+func TestStatusFileMidOperation(t *testing.T) {
+	useStatusInterval(t, 10*time.Millisecond)
+	path := filepath.Join(t.TempDir(), "cmp.status.json")
+	finish := StartStatus(statusCommand(t, "--status-file", path), "cmp")
+
+	// The file is there from the start, before any phase counts
+	if snap := readSnapshot(t, path); snap.State != StatusRunning || snap.Command != "cmp" || snap.PID != os.Getpid() || snap.SchemaVersion != statusSchemaVersion {
+		t.Errorf("first snapshot = %+v", snap)
+	}
+
+	// Four files of 16 KiB, read 1 KiB every 3ms
+	const files, fileSize = 4, 16 << 10
+	SetPhase("compressing")
+	multi := NewMulti()
+	bar := multi.Add("project")
+	bar.ChangeMax64(files * fileSize)
+	AddFiles(files)
+	copied := make(chan error, 1)
+	go func() {
+		for range files {
+			if _, err := io.Copy(bar, &slowSource{left: fileSize, chunk: 1 << 10, delay: 3 * time.Millisecond}); err != nil {
+				copied <- err
+				return
+			}
+			FileDone()
+		}
+		copied <- nil
+	}()
+
+	var seen []Snapshot
+	deadline := time.After(10 * time.Second)
+	for running := true; running; {
+		select {
+		case err := <-copied:
+			if err != nil {
+				t.Fatal(err)
+			}
+			running = false
+		case <-deadline:
+			t.Fatal("the copy did not finish")
+		case <-time.After(5 * time.Millisecond):
+			if snap := readSnapshot(t, path); snap.BytesDone > 0 && snap.BytesDone < snap.BytesTotal {
+				seen = append(seen, snap)
+			}
+		}
+	}
+	if len(seen) < 2 {
+		t.Fatalf("read %d snapshots of the running copy, want the file rewritten while it ran", len(seen))
+	}
+
+	for i, snap := range seen {
+		if snap.State != StatusRunning || snap.Phase != "compressing" || snap.BytesTotal != files*fileSize || snap.FilesTotal != files {
+			t.Errorf("snapshot %d = %+v", i, snap)
+		}
+		if want := float64(int(float64(snap.BytesDone)*100/float64(snap.BytesTotal)*10)) / 10; snap.Percent != want {
+			t.Errorf("snapshot %d: percent = %v, want %v for %d of %d bytes", i, snap.Percent, want, snap.BytesDone, snap.BytesTotal)
+		}
+		if snap.ETA == nil || snap.ETA.Before(snap.UpdatedAt.Truncate(time.Second)) || snap.UpdatedAt.Before(snap.StartedAt) {
+			t.Errorf("snapshot %d times: started %v, updated %v, eta %v", i, snap.StartedAt, snap.UpdatedAt, snap.ETA)
+		}
+		if i > 0 && (snap.BytesDone < seen[i-1].BytesDone || snap.FilesDone < seen[i-1].FilesDone) {
+			t.Errorf("snapshot %d went back: %+v after %+v", i, snap, seen[i-1])
+		}
+	}
+	if first, last := seen[0], seen[len(seen)-1]; last.BytesDone <= first.BytesDone {
+		t.Errorf("the progress never moved: %d bytes first, %d last", first.BytesDone, last.BytesDone)
+	}
+
+	// A successful run takes its file with it
+	multi.Stop()
+	finish(nil)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("status file after success: %v", err)
+	}
+}
+
+func TestStatusFileOutcome(t *testing.T) {
+	useStatusInterval(t, time.Hour)
+	tests := []struct {
+		name  string
+		keep  bool
+		err   error
+		state string
+	}{
+		{"kept", true, nil, StatusDone},
+		{"failed", false, errors.New("disk full"), StatusFailed},
+		{"failed and kept", true, errors.New("disk full"), StatusFailed},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "status.json")
+			args := []string{"--status-file", path}
+			if test.keep {
+				args = append(args, "--keep-status")
+			}
+			finish := StartStatus(statusCommand(t, args...), "backup run")
+			SetPhase("verifying")
+			AddFiles(3)
+			FileDone()
+			finish(test.err)
+
+			snap := readSnapshot(t, path)
+			if snap.State != test.state || snap.Command != "backup run" || snap.Phase != "verifying" || snap.FilesDone != 1 || snap.FilesTotal != 3 {
+				t.Errorf("final snapshot = %+v", snap)
+			}
+			if snap.ETA != nil {
+				t.Errorf("a finished run has an ETA: %v", snap.ETA)
+			}
+			if test.err != nil && snap.Error != test.err.Error() {
+				t.Errorf("error = %q, want %q", snap.Error, test.err)
+			}
+		})
+	}
+}
+
+func TestStatusFileNotAsked(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	finish := StartStatus(statusCommand(t), "prune")
+	SetPhase("pruning")
+	finish(nil)
+	if entries, _ := os.ReadDir(dir); len(entries) > 0 {
+		t.Errorf("wrote %v without --status-file", entries)
+	}
+}
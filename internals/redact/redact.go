@@ -0,0 +1,158 @@
+// Package redact replaces the strings listed in the redact section of the config file with [REDACTED] in
+// everything gsn prints and keeps: stdout and stderr go through a filter once Start has run, and the state
+// files that record names, like the completion history, pass their JSON through JSON before they are written.
+// Redaction changes what is shown, never what a command does or how it exits.
+package redact
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Replacement is what every match is replaced with
+const Replacement = "[REDACTED]"
+
+// active matches every listed string and pattern, nil when nothing is redacted
+var active *regexp.Regexp
+
+// Compile joins literal strings and regular expressions into a single expression, compiled once and matched in
+// one pass. Longer literals come first so a literal containing another is replaced whole. nil when both are
+// empty.
+func Compile(literals []string, patterns []string) (*regexp.Regexp, error) {
+	literals = slices.DeleteFunc(slices.Clone(literals), func(s string) bool { return s == "" })
+	slices.SortStableFunc(literals, func(a, b string) int { return len(b) - len(a) })
+
+	var alternatives []string
+	for _, literal := range literals {
+		alternatives = append(alternatives, regexp.QuoteMeta(literal))
+	}
+	for _, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+		if _, err := regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("invalid redact pattern '%s': %w", pattern, err)
+		}
+		alternatives = append(alternatives, "(?:"+pattern+")")
+	}
+	if len(alternatives) == 0 {
+		return nil, nil
+	}
+	return regexp.Compile(strings.Join(alternatives, "|"))
+}
+
+// Configure makes literals and patterns the redacted strings of the process
+func Configure(literals []string, patterns []string) error {
+	re, err := Compile(literals, patterns)
+	if err != nil {
+		return err
+	}
+	active = re
+	return nil
+}
+
+// Enabled reports whether anything is redacted
+func Enabled() bool {
+	return active != nil
+}
+
+// String returns s with every match replaced
+func String(s string) string {
+	if active == nil {
+		return s
+	}
+	return active.ReplaceAllLiteralString(s, Replacement)
+}
+
+// JSON redacts the string values and keys of a JSON document. Matches are looked for in the decoded strings,
+// which are encoded again when they change, so the structure and everything else stays byte for byte as it was.
+// A string left open at the end of data is kept as it is.
+func JSON(data []byte) []byte {
+	if active == nil {
+		return data
+	}
+	return redactJSON(active, data, false)
+}
+
+// redactJSON redacts the strings of data with re. With loose, text outside strings that is not JSON, like an
+// error line among JSON documents, is redacted as text.
+func redactJSON(re *regexp.Regexp, data []byte, loose bool) []byte {
+	var out []byte
+	copied := 0
+	outside := 0
+	flushOutside := func(end int) {
+		if !loose || outside >= end || isStructural(data[outside:end]) {
+			return
+		}
+		out = append(out, data[copied:outside]...)
+		out = append(out, re.ReplaceAllLiteral(data[outside:end], []byte(Replacement))...)
+		copied = end
+	}
+	for i := 0; i < len(data); i++ {
+		if data[i] != '"' {
+			continue
+		}
+		end := stringEnd(data, i)
+		if end < 0 {
+			break
+		}
+		flushOutside(i)
+		var s string
+		if err := json.Unmarshal(data[i:end], &s); err == nil && re.MatchString(s) {
+			quoted, _ := json.Marshal(re.ReplaceAllLiteralString(s, Replacement))
+			out = append(out, data[copied:i]...)
+			out = append(out, quoted...)
+			copied = end
+		}
+		i = end - 1
+		outside = end
+	}
+	if end := safeEnd(data); outside < end {
+		flushOutside(end)
+	}
+	if copied == 0 {
+		return data
+	}
+	return append(out, data[copied:]...)
+}
+
+// stringEnd returns the index after the closing quote of the string literal opening at start, -1 when it is
+// not closed in data
+func stringEnd(data []byte, start int) int {
+	for i := start + 1; i < len(data); i++ {
+		switch data[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// safeEnd returns the length of the longest prefix of data that does not end inside a string literal
+func safeEnd(data []byte) int {
+	safe := 0
+	for i := 0; i < len(data); i++ {
+		if data[i] != '"' {
+			safe = i + 1
+			continue
+		}
+		end := stringEnd(data, i)
+		if end < 0 {
+			break
+		}
+		i, safe = end-1, end
+	}
+	return safe
+}
+
+// structuralRun matches what JSON holds between its strings: punctuation, numbers and literals
+var structuralRun = regexp.MustCompile(`^(?:[\s{}\[\],:0-9.eE+-]|true|false|null)*$`)
+
+func isStructural(run []byte) bool {
+	return structuralRun.Match(run)
+}
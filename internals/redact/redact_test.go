@@ -0,0 +1,230 @@
+package redact
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+// useRedaction makes literals and patterns the redacted strings for the test
+func useRedaction(t *testing.T, literals []string, patterns []string) {
+	t.Helper()
+	was := active
+	if err := Configure(literals, patterns); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { active = was })
+}
+
+// testFilter runs a filter writing into a file, read with the returned function; closing the writer ends it
+func testFilter(t *testing.T, literals []string, patterns []string) (*filter, func() string) {
+	t.Helper()
+	useRedaction(t, literals, patterns)
+	path := filepath.Join(t.TempDir(), "out")
+	out, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { out.Close() })
+	f, err := newFilter(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		f.w.Close()
+		<-f.done
+	})
+	return f, func() string {
+		data, _ := os.ReadFile(path)
+		return string(data)
+	}
+}
+
+// finish closes the writer of f and waits for the filter to write everything out
+func finish(f *filter) {
+	f.w.Close()
+	<-f.done
+}
+
+func TestCompile(t *testing.T) {
+	re, err := Compile([]string{"acme", "", "acme-corp", "a.b"}, []string{`cust-\d+`, ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]string{
+		"pr for acme-corp":     "pr for [REDACTED]",
+		"acme and acme-co":     "[REDACTED] and [REDACTED]-co",
+		"a.b but not axb":      "[REDACTED] but not axb",
+		"cust-42/cust-x":       "[REDACTED]/cust-x",
+		"nothing to hide here": "nothing to hide here",
+	}
+	for s, want := range tests {
+		if got := re.ReplaceAllLiteralString(s, Replacement); got != want {
+			t.Errorf("%q redacts to %q, want %q", s, got, want)
+		}
+	}
+
+	if re, err := Compile(nil, []string{""}); re != nil || err != nil {
+		t.Errorf("Compile of nothing = %v, %v", re, err)
+	}
+	if _, err := Compile(nil, []string{"cust-("}); err == nil || !strings.Contains(err.Error(), "invalid redact pattern 'cust-('") {
+		t.Errorf("Compile of an invalid pattern = %v", err)
+	}
+}
+
+func TestString(t *testing.T) {
+	useRedaction(t, nil, nil)
+	if Enabled() || String("acme-corp") != "acme-corp" {
+		t.Error("nothing configured redacts")
+	}
+	useRedaction(t, []string{"acme-corp"}, nil)
+	if got := String("fix: acme-corp login"); got != "fix: [REDACTED] login" {
+		t.Errorf("String = %q", got)
+	}
+}
+
+// TestJSON redacts decoded strings, keys included, re-encoding only those that change, and keeps the document
+// valid whatever the escapes
+func TestJSON(t *testing.T) {
+	useRedaction(t, []string{"acme-corp", `a"b`}, []string{`cust-\d+`})
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{`{"title":"fix acme-corp login","count":2}`, `{"title":"fix [REDACTED] login","count":2}`},
+		// Keys are strings too
+		{`{"acme-corp":{"size":10}}`, `{"[REDACTED]":{"size":10}}`},
+		// Matches are looked for in the decoded string: an escaped quote, an escaped slash and a unicode escape
+		{`["say a\"b", "acme\u002dcorp", "acme\/corp"]`, `["say [REDACTED]", "[REDACTED]", "acme\/corp"]`},
+		// Strings without a match keep their escapes byte for byte
+		{`{"path": "C:\\Users\\me", "n": [1, 2.5e3, true, null]}`, `{"path": "C:\\Users\\me", "n": [1, 2.5e3, true, null]}`},
+		{"{\n  \"ticket\": \"cust-1234\"\n}", "{\n  \"ticket\": \"[REDACTED]\"\n}"},
+		// A string left open is kept as it is
+		{`{"title":"acme-corp`, `{"title":"acme-corp`},
+	}
+	for _, test := range tests {
+		got := string(JSON([]byte(test.in)))
+		if got != test.want {
+			t.Errorf("JSON(%s) = %s, want %s", test.in, got, test.want)
+		}
+		if json.Valid([]byte(test.in)) && !json.Valid([]byte(got)) {
+			t.Errorf("JSON(%s) = %s, no longer valid", test.in, got)
+		}
+	}
+
+	// Text among JSON documents is redacted as text by the output filter, never by JSON
+	mixed := "error: acme-corp not found\n{\"name\":\"acme-corp\"}\n"
+	if got := string(JSON([]byte(mixed))); got != "error: acme-corp not found\n{\"name\":\"[REDACTED]\"}\n" {
+		t.Errorf("JSON of mixed output = %q", got)
+	}
+	if got := string(redactJSON(active, []byte(mixed), true)); got != "error: [REDACTED] not found\n{\"name\":\"[REDACTED]\"}\n" {
+		t.Errorf("loose redactJSON of mixed output = %q", got)
+	}
+}
+
+// TestFilterPlain redacts whole lines, matches split across writes included, and shows a prompt left without a
+// line end once holdTime has passed
+func TestFilterPlain(t *testing.T) {
+	f, read := testFilter(t, []string{"acme-corp"}, []string{`cust-\d+`})
+	for _, chunk := range []string{"merged acme-", "corp#12\nticket cu", "st-77 closed\n"} {
+		if _, err := f.w.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(holdTime / 4)
+	}
+	if _, err := f.w.Write([]byte("Delete acme-corp? [y/N] ")); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.HasSuffix(read(), "[y/N] ") && time.Now().Before(deadline) {
+		time.Sleep(holdTime)
+	}
+	want := "merged [REDACTED]#12\nticket [REDACTED] closed\nDelete [REDACTED]? [y/N] "
+	if got := read(); got != want {
+		t.Errorf("while running, wrote %q, want %q", got, want)
+	}
+
+	if _, err := f.w.Write([]byte("yes, acme-c")); err != nil {
+		t.Fatal(err)
+	}
+	finish(f)
+	if got := read(); got != want+"yes, acme-c" {
+		t.Errorf("after closing, wrote %q", got)
+	}
+}
+
+// TestFilterJSON keeps the stream valid JSON: a string split across writes and lines is held until it closes,
+// and an error line in between is redacted as text
+func TestFilterJSON(t *testing.T) {
+	f, read := testFilter(t, []string{"acme-corp"}, nil)
+	f.json.Store(true)
+	chunks := []string{
+		"{\n  \"entries\": [\n    {\"path\": \"/srv/acme-",
+		"corp/logs\", \"size\": 3000},\n",
+		"    {\"path\": \"/srv/other\", \"note\": \"escaped \\\"acme-corp\\\"\"}\n  ]\n}\n",
+		"error: acme-corp vanished\n",
+	}
+	for _, chunk := range chunks {
+		if _, err := f.w.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+		// Longer than holdTime, the open string must be held anyway
+		time.Sleep(2 * holdTime)
+	}
+	finish(f)
+
+	got := read()
+	document, rest, _ := strings.Cut(got, "}\n  ]\n}\n")
+	document += "}\n  ]\n}\n"
+	if !json.Valid([]byte(document)) {
+		t.Fatalf("filtered JSON is not valid:\n%s", got)
+	}
+	var v struct {
+		Entries []struct{ Path, Note string }
+	}
+	if err := json.Unmarshal([]byte(document), &v); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Entries) != 2 || v.Entries[0].Path != "/srv/[REDACTED]/logs" || v.Entries[1].Note != `escaped "[REDACTED]"` {
+		t.Errorf("filtered entries = %+v", v.Entries)
+	}
+	if rest != "error: [REDACTED] vanished\n" {
+		t.Errorf("text after the JSON = %q", rest)
+	}
+}
+
+// TestFilterProgressDescription draws a progress bar whose description names what it works on through the filter:
+// each redraw starts with \r and has no line end, and none of them shows the name
+func TestFilterProgressDescription(t *testing.T) {
+	f, read := testFilter(t, []string{"acme-corp"}, nil)
+	bar := progressbar.NewOptions64(1000,
+		progressbar.OptionSetWriter(f.w),
+		progressbar.OptionSetDescription("Copying acme-corp"),
+		progressbar.OptionShowBytes(true),
+		progressbar.OptionThrottle(0),
+		progressbar.OptionSetTheme(progressbar.ThemeASCII),
+	)
+	for i := range 4 {
+		if i == 3 {
+			bar.Describe("Verifying acme-corp")
+		}
+		_ = bar.Add(250)
+		time.Sleep(holdTime / 2)
+	}
+	finish(f)
+
+	got := read()
+	if strings.Contains(got, "acme") {
+		t.Errorf("a description leaked through:\n%q", got)
+	}
+	for _, want := range []string{"\rCopying [REDACTED]", "\rVerifying [REDACTED]", "100%"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("bar output lacks %q:\n%q", want, got)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package redact
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// holdTime is how long output without a line end is held for the rest of a match, a prompt shows after it
+	holdTime = 20 * time.Millisecond
+	// maxHeld bounds the output held for a line end or the end of a JSON string, beyond it the output is
+	// redacted as it is
+	maxHeld = 1 << 20
+	// closeTimeout bounds the wait for the output still in the pipes on exit, a background child holding a pipe
+	// open would keep it from ending
+	closeTimeout = time.Second
+)
+
+// filter redacts what is written to the pipe standing in for stdout or stderr and writes it to the file it
+// replaced
+type filter struct {
+	r    *os.File
+	w    *os.File
+	out  *os.File
+	re   *regexp.Regexp
+	json atomic.Bool
+	done chan struct{}
+}
+
+var (
+	mu sync.Mutex
+	// filters are the filters of stdout and stderr, in that order, while they run
+	filters []*filter
+	// originals maps the pipes standing in for stdout and stderr to the files they replaced
+	originals = map[*os.File]*os.File{}
+)
+
+// Start routes stdout and stderr through filters redacting them, when anything is redacted. Commands keep
+// writing to os.Stdout and os.Stderr; Close flushes and ends the filters.
+func Start() error {
+	mu.Lock()
+	defer mu.Unlock()
+	if active == nil || len(filters) > 0 {
+		return nil
+	}
+	stdout, err := newFilter(os.Stdout)
+	if err != nil {
+		return err
+	}
+	stderr, err := newFilter(os.Stderr)
+	if err != nil {
+		stdout.r.Close()
+		stdout.w.Close()
+		return err
+	}
+	filters = []*filter{stdout, stderr}
+	originals[stdout.w], originals[stderr.w] = os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = stdout.w, stderr.w
+	return nil
+}
+
+// JSONOutput tells the stdout filter that JSON follows, it redacts the strings of it from then on rather than
+// whole lines, so the output stays valid JSON
+func JSONOutput() {
+	mu.Lock()
+	defer mu.Unlock()
+	if len(filters) > 0 {
+		filters[0].json.Store(true)
+	}
+}
+
+// Unfiltered returns the file f stands in for, or f. Programs taking over the terminal, like an editor, are
+// given it, as they cannot run on a pipe.
+func Unfiltered(f *os.File) *os.File {
+	mu.Lock()
+	defer mu.Unlock()
+	if original, ok := originals[f]; ok {
+		return original
+	}
+	return f
+}
+
+// Fd returns the descriptor of the file f stands in for, to ask whether stdout or stderr is a terminal
+func Fd(f *os.File) int {
+	return int(Unfiltered(f).Fd())
+}
+
+// Close flushes the filters and gives stdout and stderr their files back, it is safe to call more than once
+func Close() {
+	mu.Lock()
+	running := filters
+	filters = nil
+	for _, f := range running {
+		if os.Stdout == f.w {
+			os.Stdout = f.out
+		}
+		if os.Stderr == f.w {
+			os.Stderr = f.out
+		}
+	}
+	mu.Unlock()
+
+	deadline := time.After(closeTimeout)
+	for _, f := range running {
+		f.w.Close()
+		select {
+		case <-f.done:
+		case <-deadline:
+		}
+	}
+}
+
+func newFilter(out *os.File) (*filter, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	f := &filter{r: r, w: w, out: out, re: active, done: make(chan struct{})}
+	go f.run()
+	return f, nil
+}
+
+// run copies the pipe to the file, redacting complete lines at once and what is left after holdTime
+func (f *filter) run() {
+	defer close(f.done)
+	defer f.r.Close()
+	buf := make([]byte, 32<<10)
+	var held []byte
+	for {
+		deadline := time.Time{}
+		if len(held) > 0 {
+			deadline = time.Now().Add(holdTime)
+		}
+		f.r.SetReadDeadline(deadline)
+		n, err := f.r.Read(buf)
+		held = append(held, buf[:n]...)
+		switch {
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			held = f.emit(held, true)
+		case err != nil:
+			f.flushAll(held)
+			return
+		default:
+			held = f.emit(held, len(held) > maxHeld)
+		}
+	}
+}
+
+// flushAll writes out what is held when the pipe ends, strings left open included
+func (f *filter) flushAll(held []byte) {
+	if rest := f.emit(held, true); len(rest) > 0 {
+		f.out.Write(f.re.ReplaceAllLiteral(rest, []byte(Replacement)))
+	}
+}
+
+// emit writes the redacted part of data that can be redacted now and returns the rest to hold. Text is cut after
+// its last line end; when all is set all of it goes, but for a JSON string still open.
+func (f *filter) emit(data []byte, all bool) []byte {
+	if len(data) == 0 {
+		return data[:0]
+	}
+	if f.json.Load() {
+		// JSON strings hold no raw line ends, the last one before the end of the last string is outside of them
+		end := safeEnd(data)
+		if !all {
+			end = bytes.LastIndexByte(data[:end], '\n') + 1
+		}
+		if len(data)-end > maxHeld {
+			end = len(data)
+		}
+		if end > 0 {
+			f.out.Write(redactJSON(f.re, data[:end], true))
+		}
+		return append(data[:0], data[end:]...)
+	}
+
+	end := len(data)
+	if !all {
+		end = bytes.LastIndexAny(data, "\n\r") + 1
+	}
+	if end > 0 {
+		f.out.Write(f.re.ReplaceAllLiteral(data[:end], []byte(Replacement)))
+	}
+	return append(data[:0], data[end:]...)
+}
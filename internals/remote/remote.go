@@ -0,0 +1,283 @@
+// Package remote implements the persistent --remote flag: commands that adopt it read the tree they work on
+// from another host over SSH, through the SFTP subsystem of its sshd, so nothing needs to be installed there.
+// Any other command refuses to run with --remote rather than running locally.
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// annotation is the key of the cobra annotation marking the commands that can run against a remote host
+const annotation = "gsn/remote"
+
+// dialTimeout bounds the TCP connection and the SSH handshake
+const dialTimeout = 15 * time.Second
+
+// target is the [user@]host[:port] given to --remote, "" for a local run
+var target string
+
+// The connection of the run, opened by the first command asking for it and reused by every later one
+var (
+	mu      sync.Mutex
+	conn    *Conn
+	connErr error
+)
+
+// Conn is an SSH connection to the remote host and the SFTP session on it
+type Conn struct {
+	// Host is the host as given to --remote, for messages
+	Host string
+	SFTP *SFTP
+
+	client *ssh.Client
+}
+
+// AddFlag registers the persistent --remote flag on the root command
+func AddFlag(cmd *cobra.Command) {
+	cmd.PersistentFlags().String("remote", "", "Run the command against a remote host over SSH, as [user@]host[:port] (cmp, du and snap create)")
+}
+
+// Adopt marks commands that read their tree through Connect when --remote is set
+func Adopt(cmds ...*cobra.Command) {
+	for _, cmd := range cmds {
+		if cmd.Annotations == nil {
+			cmd.Annotations = map[string]string{}
+		}
+		cmd.Annotations[annotation] = "adopted"
+	}
+}
+
+// Configure reads --remote and refuses to run a command that does not support it. A command whose own --remote
+// flag hides the persistent one is an error rather than a local run.
+func Configure(cmd *cobra.Command) error {
+	var err error
+	if target, err = cmd.Flags().GetString("remote"); err != nil {
+		return fmt.Errorf("%s cannot read the persistent --remote flag: %w", cmd.CommandPath(), err)
+	}
+	if target != "" && cmd.Annotations[annotation] == "" {
+		return clierr.Newf(clierr.Usage, "%s does not support --remote yet, nothing was run", cmd.CommandPath())
+	}
+	return nil
+}
+
+// Enabled reports whether the command runs against a remote host
+func Enabled() bool {
+	return target != ""
+}
+
+// Connect returns the connection to the --remote host, opening it on the first call. A failed connection is
+// not retried, every later call returns the same error.
+func Connect() (*Conn, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if conn == nil && connErr == nil {
+		conn, connErr = dial(target)
+	}
+	return conn, connErr
+}
+
+// CloseAll closes the connection of the run, if one was opened
+func CloseAll() {
+	mu.Lock()
+	defer mu.Unlock()
+	if conn != nil {
+		conn.Close()
+		conn = nil
+	}
+}
+
+// Dial connects to a [user@]host[:port] of its own, for the hosts the config file names rather than --remote.
+// The caller closes the connection.
+func Dial(hostSpec string) (*Conn, error) {
+	return dial(hostSpec)
+}
+
+// Close ends the SFTP session and the SSH connection
+func (c *Conn) Close() error {
+	c.SFTP.Close()
+	return c.client.Close()
+}
+
+// dial connects to a [user@]host[:port] and starts its sftp subsystem
+func dial(hostSpec string) (*Conn, error) {
+	userName, addr, err := parseTarget(hostSpec)
+	if err != nil {
+		return nil, err
+	}
+	hostKeys, err := hostKeyCallback()
+	if err != nil {
+		return nil, err
+	}
+	auth, closeAgent := authMethods()
+	defer closeAgent()
+
+	config := &ssh.ClientConfig{
+		User:              userName,
+		Auth:              auth,
+		HostKeyCallback:   hostKeys,
+		HostKeyAlgorithms: knownAlgorithms(hostKeys, addr),
+		Timeout:           dialTimeout,
+	}
+	client, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		return nil, explainDialError(hostSpec, err)
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to open a session on %s: %w", hostSpec, err)
+	}
+	w, err := session.StdinPipe()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	r, err := session.StdoutPipe()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("%s has no sftp subsystem, enable it in its sshd_config: %w", hostSpec, err)
+	}
+	sftp, err := NewSFTP(r, w)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &Conn{Host: hostSpec, SFTP: sftp, client: client}, nil
+}
+
+// parseTarget splits [user@]host[:port] into the login and the address to dial, the login defaults to the
+// local user and the port to 22
+func parseTarget(hostSpec string) (string, string, error) {
+	userName, host, found := strings.Cut(hostSpec, "@")
+	if !found {
+		host, userName = hostSpec, ""
+	}
+	if userName == "" {
+		if u, err := user.Current(); err == nil {
+			userName = u.Username
+		}
+	}
+	port := "22"
+	// host:port and [ipv6]:port carry a port, a bare IPv6 address does not
+	if strings.HasPrefix(host, "[") || strings.Count(host, ":") == 1 {
+		var err error
+		if host, port, err = net.SplitHostPort(host); err != nil {
+			return "", "", clierr.Newf(clierr.Usage, "invalid --remote '%s': %v", hostSpec, err)
+		}
+	}
+	if host == "" || userName == "" {
+		return "", "", clierr.Newf(clierr.Usage, "invalid --remote '%s', expected [user@]host[:port]", hostSpec)
+	}
+	return userName, net.JoinHostPort(host, port), nil
+}
+
+// hostKeyCallback checks host keys against ~/.ssh/known_hosts, as ssh does with StrictHostKeyChecking
+func hostKeyCallback() (ssh.HostKeyCallback, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	knownHostsPath := filepath.Join(home, ".ssh", "known_hosts")
+	callback, err := knownhosts.New(knownHostsPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s does not exist, connect to the host with ssh once to check and record its key", knownHostsPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", knownHostsPath, err)
+	}
+	return callback, nil
+}
+
+// probeKey is a host key no host has, checking it lists the keys known_hosts holds for a host
+type probeKey struct{}
+
+func (probeKey) Type() string                                 { return "gsn-probe" }
+func (probeKey) Marshal() []byte                              { return []byte("gsn-probe") }
+func (probeKey) Verify(data []byte, sig *ssh.Signature) error { return errors.New("probe key") }
+
+// knownAlgorithms returns the algorithms of the keys known_hosts holds for addr, so the server is asked for a
+// key that can be checked rather than the first it prefers. nil, for the defaults, when the host is unknown.
+func knownAlgorithms(callback ssh.HostKeyCallback, addr string) []string {
+	tcpAddr, _ := net.ResolveTCPAddr("tcp", addr)
+	if tcpAddr == nil {
+		tcpAddr = &net.TCPAddr{}
+	}
+	var keyErr *knownhosts.KeyError
+	if err := callback(addr, tcpAddr, probeKey{}); !errors.As(err, &keyErr) {
+		return nil
+	}
+	var algorithms []string
+	for _, known := range keyErr.Want {
+		switch keyType := known.Key.Type(); keyType {
+		case ssh.KeyAlgoRSA:
+			algorithms = append(algorithms, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA)
+		default:
+			algorithms = append(algorithms, keyType)
+		}
+	}
+	return algorithms
+}
+
+// authMethods offers the keys of the ssh agent, then the unencrypted default keys of ~/.ssh. Encrypted keys
+// are left to the agent. The returned func closes the connection to the agent.
+func authMethods() ([]ssh.AuthMethod, func()) {
+	var methods []ssh.AuthMethod
+	closeAgent := func() {}
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		if agentConn, err := net.Dial("unix", socket); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(agentConn).Signers))
+			closeAgent = func() { agentConn.Close() }
+		}
+	}
+
+	var signers []ssh.Signer
+	if home, err := os.UserHomeDir(); err == nil {
+		for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+			data, err := os.ReadFile(filepath.Join(home, ".ssh", name))
+			if err != nil {
+				continue
+			}
+			if signer, err := ssh.ParsePrivateKey(data); err == nil {
+				signers = append(signers, signer)
+			}
+		}
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+	return methods, closeAgent
+}
+
+// explainDialError adds what to do to the errors of unknown or changed host keys and refused keys
+func explainDialError(hostSpec string, err error) error {
+	var keyErr *knownhosts.KeyError
+	switch {
+	case errors.As(err, &keyErr) && len(keyErr.Want) == 0:
+		return fmt.Errorf("the key of %s is not in known_hosts, connect with ssh once to check and record it", hostSpec)
+	case errors.As(err, &keyErr):
+		return fmt.Errorf("the key of %s does not match known_hosts, it may be impersonated: %w", hostSpec, err)
+	case strings.Contains(err.Error(), "unable to authenticate"):
+		return fmt.Errorf("%s refused every key, gsn offers the keys of the ssh agent and the unencrypted ~/.ssh/id_* keys: %w", hostSpec, err)
+	}
+	return fmt.Errorf("failed to connect to %s: %w", hostSpec, err)
+}
@@ -0,0 +1,168 @@
+package remote_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gsn-dev-tools/internals/remote"
+	"gsn-dev-tools/internals/remote/sftptest"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshHost serves root over SSH in process and points $HOME to a home holding its known_hosts and the key it
+// accepts, away from the ssh agent of the machine. It returns the host to dial and the home.
+func sshHost(t *testing.T, root string) (string, string) {
+	t.Helper()
+	home := t.TempDir()
+	hostSpec := (&sftptest.Server{Root: root}).ListenSSH(t, home)
+	t.Setenv("HOME", home)
+	t.Setenv("SSH_AUTH_SOCK", "")
+	return hostSpec, home
+}
+
+func TestDialOverSSH(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "logs"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "logs", "app.log"), []byte("started\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	hostSpec, _ := sshHost(t, root)
+
+	conn, err := remote.Dial(hostSpec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.Host != hostSpec {
+		t.Errorf("Host = %q, want %q", conn.Host, hostSpec)
+	}
+
+	entries, err := conn.SFTP.ReadDir("/logs")
+	if err != nil || len(entries) != 1 || entries[0].Name() != "app.log" || entries[0].Size() != 8 {
+		t.Fatalf("ReadDir = %v, %v", entries, err)
+	}
+	f, err := conn.SFTP.Open("/logs/app.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil || string(data) != "started\n" {
+		t.Errorf("read %q, %v", data, err)
+	}
+
+	w, err := conn.SFTP.Create("/logs/copy.log", 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("copied\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "logs", "copy.log")); string(data) != "copied\n" {
+		t.Errorf("wrote %q", data)
+	}
+}
+
+func TestDialRefusals(t *testing.T) {
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPublic, err := ssh.NewPublicKey(otherKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		user  string
+		spoil func(hostSpec string, sshDir string) error
+		want  string
+	}{
+		{"no known_hosts", "", func(_ string, sshDir string) error {
+			return os.Remove(filepath.Join(sshDir, "known_hosts"))
+		}, "known_hosts does not exist, connect to the host with ssh once"},
+		{"unknown host", "", func(_ string, sshDir string) error {
+			return os.WriteFile(filepath.Join(sshDir, "known_hosts"), nil, 0o600)
+		}, "is not in known_hosts, connect with ssh once to check and record it"},
+		{"changed host key", "", func(hostSpec string, sshDir string) error {
+			_, addr, _ := strings.Cut(hostSpec, "@")
+			line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, otherPublic) + "\n"
+			return os.WriteFile(filepath.Join(sshDir, "known_hosts"), []byte(line), 0o600)
+		}, "does not match known_hosts, it may be impersonated"},
+		{"no key", "", func(_ string, sshDir string) error {
+			return os.Remove(filepath.Join(sshDir, "id_ed25519"))
+		}, "refused every key"},
+		{"other user", "root", func(string, string) error { return nil }, "refused every key"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hostSpec, home := sshHost(t, t.TempDir())
+			if err := test.spoil(hostSpec, filepath.Join(home, ".ssh")); err != nil {
+				t.Fatal(err)
+			}
+			if test.user != "" {
+				hostSpec = test.user + strings.TrimPrefix(hostSpec, sftptest.User)
+			}
+			conn, err := remote.Dial(hostSpec)
+			if err == nil {
+				conn.Close()
+			}
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("Dial = %v, want %q", err, test.want)
+			}
+		})
+	}
+}
+
+// configureUnder runs a command of a root with the persistent --remote flag and returns what Configure said
+func configureUnder(t *testing.T, sub *cobra.Command, args ...string) error {
+	t.Helper()
+	root := &cobra.Command{Use: "gsn"}
+	remote.AddFlag(root)
+	var err error
+	sub.Run = func(cmd *cobra.Command, args []string) { err = remote.Configure(cmd) }
+	root.AddCommand(sub)
+	root.SetArgs(args)
+	if execErr := root.Execute(); execErr != nil {
+		t.Fatal(execErr)
+	}
+	// Leaves no target behind for the next test
+	t.Cleanup(func() { _ = remote.Configure(&cobra.Command{}) })
+	return err
+}
+
+func TestConfigure(t *testing.T) {
+	adopted := &cobra.Command{Use: "du"}
+	remote.Adopt(adopted)
+	if err := configureUnder(t, adopted, "--remote", "deploy@web1", "du"); err != nil || !remote.Enabled() {
+		t.Errorf("an adopting command = %v, enabled %v", err, remote.Enabled())
+	}
+
+	if err := configureUnder(t, &cobra.Command{Use: "approve"}, "approve", "--remote", "deploy@web1"); err == nil || !strings.Contains(err.Error(), "gsn approve does not support --remote yet") {
+		t.Errorf("a command that does not adopt --remote = %v", err)
+	}
+	if err := configureUnder(t, &cobra.Command{Use: "approve"}, "approve"); err != nil || remote.Enabled() {
+		t.Errorf("a local run = %v, enabled %v", err, remote.Enabled())
+	}
+
+	// A local flag named --remote hides the persistent one, which must not turn into a local run
+	hiding := &cobra.Command{Use: "branch-cleanup"}
+	hiding.Flags().Bool("remote", false, "")
+	if err := configureUnder(t, hiding, "branch-cleanup", "--remote"); err == nil || !strings.Contains(err.Error(), "cannot read the persistent --remote flag") {
+		t.Errorf("a command hiding --remote = %v", err)
+	}
+}
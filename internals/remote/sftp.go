@@ -0,0 +1,673 @@
+package remote
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Packet types of SFTP version 3, see draft-ietf-secsh-filexfer-02
+const (
+	fxpInit     = 1
+	fxpVersion  = 2
+	fxpOpen     = 3
+	fxpClose    = 4
+	fxpRead     = 5
+	fxpWrite    = 6
+	fxpLstat    = 7
+	fxpOpendir  = 11
+	fxpReaddir  = 12
+	fxpRemove   = 13
+	fxpRealpath = 16
+	fxpStat     = 17
+	fxpRename   = 18
+	fxpReadlink = 19
+	fxpStatus   = 101
+	fxpHandle   = 102
+	fxpData     = 103
+	fxpName     = 104
+	fxpAttrs    = 105
+	fxpExtended = 200
+)
+
+// Status codes of SSH_FXP_STATUS
+const (
+	fxOK               = 0
+	fxEOF              = 1
+	fxNoSuchFile       = 2
+	fxPermissionDenied = 3
+	fxOpUnsupported    = 8
+)
+
+// Flags of the attributes of a file, only the ones read here
+const (
+	attrSize        = 0x00000001
+	attrUIDGID      = 0x00000002
+	attrPermissions = 0x00000004
+	attrACModTime   = 0x00000008
+	attrExtended    = 0x80000000
+)
+
+const (
+	// readChunk is the size of a read request, the largest every server answers in full
+	readChunk = 32 << 10
+	// readAhead is how many read requests a file keeps in flight, hiding the round trip to the server
+	readAhead = 16
+	// maxPacket bounds the packets accepted from the server, a larger length is a broken stream
+	maxPacket = 256 << 10
+)
+
+// SFTP is a client of SFTP version 3, of the reads and of the writes an upload needs. Requests may be issued from
+// several goroutines, the answers are matched to them by request id.
+type SFTP struct {
+	w io.WriteCloser
+	// wmu keeps the requests whole on the stream, it is not held with mu so answers are received meanwhile
+	wmu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  uint32
+	pending map[uint32]chan packet
+	// err fails every request once the stream to the server broke
+	err error
+}
+
+// packet is an answer of the server: its type and the bytes after the request id
+type packet struct {
+	typ  byte
+	data []byte
+}
+
+// StatusError is an SSH_FXP_STATUS answer other than OK
+type StatusError struct {
+	Code    uint32
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("sftp: %s (code %d)", e.Message, e.Code)
+	}
+	return fmt.Sprintf("sftp: status code %d", e.Code)
+}
+
+// Is lets errors.Is match the codes of missing files and denied access to their fs errors
+func (e *StatusError) Is(target error) bool {
+	switch e.Code {
+	case fxNoSuchFile:
+		return target == fs.ErrNotExist
+	case fxPermissionDenied:
+		return target == fs.ErrPermission
+	}
+	return false
+}
+
+// NewSFTP starts an SFTP session over the stdin and stdout of the sftp subsystem
+func NewSFTP(r io.Reader, w io.WriteCloser) (*SFTP, error) {
+	init := binary.BigEndian.AppendUint32([]byte{fxpInit}, 3)
+	if _, err := w.Write(frame(init)); err != nil {
+		return nil, fmt.Errorf("sftp: failed to start the session: %w", err)
+	}
+	body, err := readPacket(r)
+	if err != nil {
+		return nil, fmt.Errorf("sftp: failed to start the session: %w", err)
+	}
+	if body[0] != fxpVersion {
+		return nil, fmt.Errorf("sftp: expected the server version, got packet type %d", body[0])
+	}
+	if version := binary.BigEndian.Uint32(body[1:]); version < 3 {
+		return nil, fmt.Errorf("sftp: the server speaks version %d, version 3 is needed", version)
+	}
+
+	c := &SFTP{w: w, pending: make(map[uint32]chan packet)}
+	go c.receive(r)
+	return c, nil
+}
+
+// frame prefixes a packet with its length
+func frame(body []byte) []byte {
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(body))), body...)
+}
+
+// readPacket reads a packet without its length, it holds at least a type and a uint32
+func readPacket(r io.Reader) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(length[:])
+	if n < 5 || n > maxPacket {
+		return nil, fmt.Errorf("invalid packet length %d", n)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// receive hands the answers of the server to the requests waiting for them, until the stream ends
+func (c *SFTP) receive(r io.Reader) {
+	for {
+		body, err := readPacket(r)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		id := binary.BigEndian.Uint32(body[1:5])
+		c.mu.Lock()
+		ch, ok := c.pending[id]
+		delete(c.pending, id)
+		c.mu.Unlock()
+		if ok {
+			ch <- packet{typ: body[0], data: body[5:]}
+		}
+	}
+}
+
+func (c *SFTP) fail(err error) {
+	if errors.Is(err, io.EOF) {
+		err = errors.New("sftp: the server closed the session")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+}
+
+// send writes a request and returns the channel its answer arrives on, closed when the session fails first.
+// args are encoded after the request id: strings and byte slices with their length, integers as they are.
+func (c *SFTP) send(typ byte, args ...any) (<-chan packet, error) {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	c.nextID++
+	id := c.nextID
+	// Buffered, so an answer nobody waits for anymore does not stall the receiver
+	ch := make(chan packet, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	body := binary.BigEndian.AppendUint32([]byte{typ}, id)
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case string:
+			body = appendString(body, []byte(v))
+		case []byte:
+			body = appendString(body, v)
+		case uint32:
+			body = binary.BigEndian.AppendUint32(body, v)
+		case uint64:
+			body = binary.BigEndian.AppendUint64(body, v)
+		default:
+			panic(fmt.Sprintf("sftp: cannot encode %T", arg))
+		}
+	}
+	c.wmu.Lock()
+	_, err := c.w.Write(frame(body))
+	c.wmu.Unlock()
+	if err != nil {
+		c.fail(err)
+		return nil, err
+	}
+	return ch, nil
+}
+
+func appendString(b []byte, s []byte) []byte {
+	return append(binary.BigEndian.AppendUint32(b, uint32(len(s))), s...)
+}
+
+// wait returns the answer of a request, an error when the session failed meanwhile
+func (c *SFTP) wait(ch <-chan packet) (packet, error) {
+	p, ok := <-ch
+	if !ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return packet{}, c.err
+	}
+	return p, nil
+}
+
+// call sends a request and waits for its answer. A status answer is turned into an error, unless want is
+// fxpStatus and it is OK.
+func (c *SFTP) call(want byte, typ byte, args ...any) (*reader, error) {
+	ch, err := c.send(typ, args...)
+	if err != nil {
+		return nil, err
+	}
+	p, err := c.wait(ch)
+	if err != nil {
+		return nil, err
+	}
+	return expect(p, want)
+}
+
+// expect reads the answer p as a packet of type want
+func expect(p packet, want byte) (*reader, error) {
+	r := &reader{b: p.data}
+	if p.typ == fxpStatus {
+		code, msg := r.uint32(), r.string()
+		if code == fxOK && want == fxpStatus {
+			return r, nil
+		}
+		return nil, &StatusError{Code: code, Message: msg}
+	}
+	if p.typ != want {
+		return nil, fmt.Errorf("sftp: expected packet type %d, got %d", want, p.typ)
+	}
+	return r, nil
+}
+
+// Close ends the session
+func (c *SFTP) Close() error {
+	return c.w.Close()
+}
+
+// Stat returns the attributes of the file at p, following symlinks
+func (c *SFTP) Stat(p string) (fs.FileInfo, error) {
+	return c.stat(fxpStat, "stat", p)
+}
+
+// Lstat returns the attributes of the file at p, a symlink itself rather than its target
+func (c *SFTP) Lstat(p string) (fs.FileInfo, error) {
+	return c.stat(fxpLstat, "lstat", p)
+}
+
+func (c *SFTP) stat(typ byte, op string, p string) (fs.FileInfo, error) {
+	r, err := c.call(fxpAttrs, typ, p)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: p, Err: err}
+	}
+	info := r.attrs(path.Base(p))
+	return info, r.err
+}
+
+// RealPath resolves p on the server to an absolute path, relative paths start at the login directory
+func (c *SFTP) RealPath(p string) (string, error) {
+	return c.singleName(fxpRealpath, "realpath", p)
+}
+
+// ReadLink returns the target of the symlink at p
+func (c *SFTP) ReadLink(p string) (string, error) {
+	return c.singleName(fxpReadlink, "readlink", p)
+}
+
+func (c *SFTP) singleName(typ byte, op string, p string) (string, error) {
+	r, err := c.call(fxpName, typ, p)
+	if err != nil {
+		return "", &fs.PathError{Op: op, Path: p, Err: err}
+	}
+	if count := r.uint32(); count != 1 {
+		return "", &fs.PathError{Op: op, Path: p, Err: fmt.Errorf("sftp: expected one name, got %d", count)}
+	}
+	name := r.string()
+	return name, r.err
+}
+
+// ReadDir returns the attributes of the entries of the directory at p, sorted by name, without . and ..
+func (c *SFTP) ReadDir(p string) ([]fs.FileInfo, error) {
+	handle, err := c.handle(fxpOpendir, p)
+	if err != nil {
+		return nil, &fs.PathError{Op: "opendir", Path: p, Err: err}
+	}
+	defer c.closeHandle(handle)
+
+	var entries []fs.FileInfo
+	for {
+		r, err := c.call(fxpName, fxpReaddir, handle)
+		var status *StatusError
+		if errors.As(err, &status) && status.Code == fxEOF {
+			break
+		}
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: p, Err: err}
+		}
+		for count := r.uint32(); count > 0 && r.err == nil; count-- {
+			name := r.string()
+			r.string() // the ls -l line, informational only
+			info := r.attrs(name)
+			if name != "." && name != ".." {
+				entries = append(entries, info)
+			}
+		}
+		if r.err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: p, Err: r.err}
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// handle opens a file or directory, args follow the path in the request
+func (c *SFTP) handle(typ byte, p string, args ...any) (string, error) {
+	r, err := c.call(fxpHandle, typ, append([]any{p}, args...)...)
+	if err != nil {
+		return "", err
+	}
+	handle := r.string()
+	return handle, r.err
+}
+
+func (c *SFTP) closeHandle(handle string) error {
+	_, err := c.call(fxpStatus, fxpClose, handle)
+	return err
+}
+
+// Open opens the file at p for reading
+func (c *SFTP) Open(p string) (*File, error) {
+	const pflagRead = 0x00000001
+	handle, err := c.handle(fxpOpen, p, uint32(pflagRead), uint32(0))
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: p, Err: err}
+	}
+	return &File{c: c, path: p, handle: handle}, nil
+}
+
+// File is a remote file open for reading. Reads are sequential: the next chunks are requested ahead of them
+// so a large file streams at the bandwidth of the link rather than one round trip per chunk.
+type File struct {
+	c      *SFTP
+	path   string
+	handle string
+
+	// next is the offset of the next chunk to request, pending the requests in flight in offset order
+	next    uint64
+	pending []readRequest
+	buf     []byte
+	eof     bool
+}
+
+type readRequest struct {
+	offset uint64
+	ch     <-chan packet
+}
+
+func (f *File) Read(p []byte) (int, error) {
+	for len(f.buf) == 0 {
+		if f.eof {
+			return 0, io.EOF
+		}
+		if err := f.fill(); err != nil {
+			return 0, &fs.PathError{Op: "read", Path: f.path, Err: err}
+		}
+	}
+	n := copy(p, f.buf)
+	f.buf = f.buf[n:]
+	return n, nil
+}
+
+// fill receives the next chunk, topping up the requests in flight first
+func (f *File) fill() error {
+	for len(f.pending) < readAhead {
+		ch, err := f.c.send(fxpRead, f.handle, f.next, uint32(readChunk))
+		if err != nil {
+			return err
+		}
+		f.pending = append(f.pending, readRequest{offset: f.next, ch: ch})
+		f.next += readChunk
+	}
+	req := f.pending[0]
+	f.pending = f.pending[1:]
+	p, err := f.c.wait(req.ch)
+	if err != nil {
+		return err
+	}
+	r, err := expect(p, fxpData)
+	var status *StatusError
+	if errors.As(err, &status) && status.Code == fxEOF {
+		f.eof, f.pending = true, nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	data := []byte(r.string())
+	if r.err != nil {
+		return r.err
+	}
+	// A short read ends at the end of the file or is a server limit: the chunks requested after it are
+	// dropped and requested again from where it stopped
+	if len(data) < readChunk {
+		f.next, f.pending = req.offset+uint64(len(data)), nil
+	}
+	f.buf = data
+	if len(data) == 0 {
+		f.eof = true
+	}
+	return nil
+}
+
+// Close closes the file, answers to reads still in flight are dropped
+func (f *File) Close() error {
+	return f.c.closeHandle(f.handle)
+}
+
+// Create opens the file at p for writing, creating it with perm or truncating it
+func (c *SFTP) Create(p string, perm fs.FileMode) (*FileWriter, error) {
+	const pflagWrite, pflagCreate, pflagTruncate = 0x00000002, 0x00000008, 0x00000010
+	handle, err := c.handle(fxpOpen, p, uint32(pflagWrite|pflagCreate|pflagTruncate), uint32(attrPermissions), uint32(perm.Perm()))
+	if err != nil {
+		return nil, &fs.PathError{Op: "create", Path: p, Err: err}
+	}
+	return &FileWriter{c: c, path: p, handle: handle}, nil
+}
+
+// FileWriter is a remote file open for writing. Writes are sequential and not waited for one by one: up to
+// readAhead of them are in flight, a failure is returned by a later Write or by Close.
+type FileWriter struct {
+	c      *SFTP
+	path   string
+	handle string
+
+	offset  uint64
+	pending []<-chan packet
+	err     error
+}
+
+func (f *FileWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 && f.err == nil {
+		chunk := p[:min(len(p), readChunk)]
+		ch, err := f.c.send(fxpWrite, f.handle, f.offset, chunk)
+		if err != nil {
+			f.err = err
+			break
+		}
+		f.pending = append(f.pending, ch)
+		f.offset += uint64(len(chunk))
+		written += len(chunk)
+		p = p[len(chunk):]
+		for len(f.pending) >= readAhead && f.err == nil {
+			f.settle()
+		}
+	}
+	if f.err != nil {
+		return written, &fs.PathError{Op: "write", Path: f.path, Err: f.err}
+	}
+	return written, nil
+}
+
+// settle waits for the oldest write in flight
+func (f *FileWriter) settle() {
+	ch := f.pending[0]
+	f.pending = f.pending[1:]
+	p, err := f.c.wait(ch)
+	if err == nil {
+		_, err = expect(p, fxpStatus)
+	}
+	if f.err == nil {
+		f.err = err
+	}
+}
+
+// Close waits for the writes in flight and closes the file, returning the first failure
+func (f *FileWriter) Close() error {
+	for len(f.pending) > 0 {
+		f.settle()
+	}
+	err := f.c.closeHandle(f.handle)
+	if f.err != nil {
+		err = f.err
+	}
+	if err != nil {
+		return &fs.PathError{Op: "close", Path: f.path, Err: err}
+	}
+	return nil
+}
+
+// Remove removes the file at p
+func (c *SFTP) Remove(p string) error {
+	if _, err := c.call(fxpStatus, fxpRemove, p); err != nil {
+		return &fs.PathError{Op: "remove", Path: p, Err: err}
+	}
+	return nil
+}
+
+// Rename moves oldPath to newPath, replacing newPath like os.Rename. Version 3 renames refuse an existing
+// target, the posix-rename@openssh.com extension replaces it and is used when the server has it; without it an
+// existing newPath is removed first.
+func (c *SFTP) Rename(oldPath string, newPath string) error {
+	_, err := c.call(fxpStatus, fxpExtended, "posix-rename@openssh.com", oldPath, newPath)
+	var status *StatusError
+	if errors.As(err, &status) && status.Code == fxOpUnsupported {
+		if _, err = c.call(fxpStatus, fxpRename, oldPath, newPath); err != nil {
+			if _, statErr := c.Lstat(newPath); statErr == nil {
+				if _, err = c.call(fxpStatus, fxpRemove, newPath); err == nil {
+					_, err = c.call(fxpStatus, fxpRename, oldPath, newPath)
+				}
+			}
+		}
+	}
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: err}
+	}
+	return nil
+}
+
+// FileInfo describes a remote file from its SFTP attributes
+type FileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+	// UID and GID are the numeric owner of the file, they have no names in SFTP version 3
+	UID, GID uint32
+}
+
+func (fi *FileInfo) Name() string       { return fi.name }
+func (fi *FileInfo) Size() int64        { return fi.size }
+func (fi *FileInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi *FileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *FileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *FileInfo) Sys() any           { return fi }
+
+// reader decodes the fields of a packet, the first error sticks and makes every later field zero
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) uint32() uint32 {
+	if r.err != nil || len(r.b) < 4 {
+		r.short()
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.b)
+	r.b = r.b[4:]
+	return v
+}
+
+func (r *reader) uint64() uint64 {
+	if r.err != nil || len(r.b) < 8 {
+		r.short()
+		return 0
+	}
+	v := binary.BigEndian.Uint64(r.b)
+	r.b = r.b[8:]
+	return v
+}
+
+func (r *reader) string() string {
+	n := r.uint32()
+	if r.err != nil || uint32(len(r.b)) < n {
+		r.short()
+		return ""
+	}
+	s := string(r.b[:n])
+	r.b = r.b[n:]
+	return s
+}
+
+func (r *reader) short() {
+	if r.err == nil {
+		r.err = errors.New("sftp: packet too short")
+	}
+}
+
+// attrs decodes the attributes of the file called name
+func (r *reader) attrs(name string) *FileInfo {
+	fi := &FileInfo{name: name}
+	flags := r.uint32()
+	if flags&attrSize != 0 {
+		fi.size = int64(r.uint64())
+	}
+	if flags&attrUIDGID != 0 {
+		fi.UID, fi.GID = r.uint32(), r.uint32()
+	}
+	if flags&attrPermissions != 0 {
+		fi.mode = fileMode(r.uint32())
+	}
+	if flags&attrACModTime != 0 {
+		r.uint32() // access time
+		fi.modTime = time.Unix(int64(r.uint32()), 0)
+	}
+	if flags&attrExtended != 0 {
+		for count := r.uint32(); count > 0 && r.err == nil; count-- {
+			r.string()
+			r.string()
+		}
+	}
+	return fi
+}
+
+// fileMode converts the st_mode bits SFTP servers send to a FileMode
+func fileMode(m uint32) fs.FileMode {
+	mode := fs.FileMode(m & 0o777)
+	switch m & 0o170000 {
+	case 0o040000:
+		mode |= fs.ModeDir
+	case 0o120000:
+		mode |= fs.ModeSymlink
+	case 0o010000:
+		mode |= fs.ModeNamedPipe
+	case 0o140000:
+		mode |= fs.ModeSocket
+	case 0o020000:
+		mode |= fs.ModeDevice | fs.ModeCharDevice
+	case 0o060000:
+		mode |= fs.ModeDevice
+	}
+	if m&0o4000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if m&0o2000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if m&0o1000 != 0 {
+		mode |= fs.ModeSticky
+	}
+	return mode
+}
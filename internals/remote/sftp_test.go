@@ -0,0 +1,126 @@
+package remote_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"gsn-dev-tools/internals/remote/sftptest"
+)
+
+func TestSFTPWriteAndRead(t *testing.T) {
+	root := t.TempDir()
+	client := (&sftptest.Server{Root: root}).Client(t)
+	content := bytes.Repeat([]byte("0123456789abcdef"), 100_000)
+
+	w, err := client.Create("/data.bin", 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One write larger than the requests in flight, then small ones
+	if n, err := w.Write(content[:1<<20]); err != nil || n != 1<<20 {
+		t.Fatalf("write = %d, %v", n, err)
+	}
+	for rest := content[1<<20:]; len(rest) > 0; rest = rest[min(len(rest), 1000):] {
+		if _, err := w.Write(rest[:min(len(rest), 1000)]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "data.bin")); !bytes.Equal(data, content) {
+		t.Fatalf("wrote %d bytes that differ from the %d sent", len(data), len(content))
+	}
+	if info, _ := os.Stat(filepath.Join(root, "data.bin")); info.Mode().Perm() != 0o600 {
+		t.Errorf("mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	r, err := client.Open("/data.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if data, err := io.ReadAll(r); err != nil || !bytes.Equal(data, content) {
+		t.Errorf("read back %d bytes (%v), want the %d written", len(data), err, len(content))
+	}
+}
+
+func TestSFTPWriteFailure(t *testing.T) {
+	client := (&sftptest.Server{Root: t.TempDir(), FailWritesAfter: 1000}).Client(t)
+	w, err := client.Create("/full.bin", 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The failed write is still in flight when Write returns, Close reports it
+	_, writeErr := w.Write(make([]byte, 4000))
+	closeErr := w.Close()
+	if writeErr == nil && closeErr == nil {
+		t.Error("a write past the free space succeeded")
+	}
+
+	if _, err := client.Create("/missing/dir/f", 0o644); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("create in a missing directory = %v, want not exist", err)
+	}
+}
+
+func TestSFTPRenameReplaces(t *testing.T) {
+	for _, noPosixRename := range []bool{false, true} {
+		root := t.TempDir()
+		client := (&sftptest.Server{Root: root, NoPosixRename: noPosixRename}).Client(t)
+		for name, content := range map[string]string{"new": "new", "old": "old"} {
+			if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := client.Rename("/new", "/old"); err != nil {
+			t.Fatalf("without posix-rename %v: %v", noPosixRename, err)
+		}
+		if data, _ := os.ReadFile(filepath.Join(root, "old")); string(data) != "new" {
+			t.Errorf("without posix-rename %v: old = %q, want new", noPosixRename, data)
+		}
+		if err := client.Remove("/old"); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Remove("/old"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("removing a missing file = %v", err)
+		}
+	}
+}
+
+func TestSFTPConcurrentRequests(t *testing.T) {
+	root := t.TempDir()
+	client := (&sftptest.Server{Root: root}).Client(t)
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			name := "/f" + string(rune('a'+i))
+			w, err := client.Create(name, 0o644)
+			if err == nil {
+				_, err = w.Write(make([]byte, 300<<10))
+				if closeErr := w.Close(); err == nil {
+					err = closeErr
+				}
+			}
+			if err == nil {
+				_, err = client.Stat(name)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
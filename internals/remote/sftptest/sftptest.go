@@ -0,0 +1,415 @@
+// Package sftptest serves a local directory over SFTP version 3 in process, for the tests of commands reading
+// and writing a remote host through remote.SFTP.
+package sftptest
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"gsn-dev-tools/internals/remote"
+)
+
+// Packet types and status codes of SFTP version 3, see draft-ietf-secsh-filexfer-02
+const (
+	fxpInit     = 1
+	fxpVersion  = 2
+	fxpOpen     = 3
+	fxpClose    = 4
+	fxpRead     = 5
+	fxpWrite    = 6
+	fxpLstat    = 7
+	fxpSetstat  = 9
+	fxpOpendir  = 11
+	fxpReaddir  = 12
+	fxpRemove   = 13
+	fxpRealpath = 16
+	fxpStat     = 17
+	fxpRename   = 18
+	fxpReadlink = 19
+	fxpStatus   = 101
+	fxpHandle   = 102
+	fxpData     = 103
+	fxpName     = 104
+	fxpAttrs    = 105
+	fxpExtended = 200
+
+	fxOK               = 0
+	fxEOF              = 1
+	fxNoSuchFile       = 2
+	fxPermissionDenied = 3
+	fxFailure          = 4
+	fxOpUnsupported    = 8
+
+	attrSize        = 0x00000001
+	attrUIDGID      = 0x00000002
+	attrPermissions = 0x00000004
+	attrACModTime   = 0x00000008
+)
+
+// Server serves the files below Root, the remote path / is Root. Set its fields before calling Client.
+type Server struct {
+	Root string
+	// FailWritesAfter fails the writes reaching past this offset of a file, as a full disk would; 0 never fails
+	FailWritesAfter int64
+	// NoPosixRename answers the posix-rename@openssh.com extension as unsupported, like servers other than
+	// OpenSSH
+	NoPosixRename bool
+
+	mu      sync.Mutex
+	handles map[string]any
+	next    int
+}
+
+// dirHandle is an open directory, its entries are all sent by the first read
+type dirHandle struct {
+	dir  string
+	done bool
+}
+
+// Client starts serving and returns a session connected to the server, closed at the end of the test
+func (s *Server) Client(t testing.TB) *remote.SFTP {
+	t.Helper()
+	s.handles = make(map[string]any)
+	requests, toServer := io.Pipe()
+	fromServer, answers := io.Pipe()
+	go func() {
+		// Unblocks the writes of the client once the server stopped reading
+		requests.CloseWithError(s.serve(requests, answers))
+	}()
+
+	client, err := remote.NewSFTP(fromServer, toServer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// serve answers the requests until the client closes the session, and returns the error that ended it
+func (s *Server) serve(r io.Reader, w io.WriteCloser) error {
+	defer w.Close()
+	for {
+		body, err := readPacket(r)
+		if err != nil {
+			return err
+		}
+		var answer []byte
+		if body[0] == fxpInit {
+			answer = binary.BigEndian.AppendUint32([]byte{fxpVersion}, 3)
+		} else {
+			in := &decoder{b: body[5:]}
+			answer = s.handle(body[0], binary.BigEndian.Uint32(body[1:5]), in)
+		}
+		if _, err := w.Write(append(binary.BigEndian.AppendUint32(nil, uint32(len(answer))), answer...)); err != nil {
+			return err
+		}
+	}
+}
+
+func readPacket(r io.Reader) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	body := make([]byte, binary.BigEndian.Uint32(length[:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	if len(body) < 5 {
+		return nil, errors.New("sftptest: packet too short")
+	}
+	return body, nil
+}
+
+// local maps a remote path to the file below Root
+func (s *Server) local(p string) string {
+	return filepath.Join(s.Root, filepath.FromSlash(path.Clean("/"+p)))
+}
+
+// handle answers the request id of type typ
+func (s *Server) handle(typ byte, id uint32, in *decoder) []byte {
+	switch typ {
+	case fxpOpen:
+		name, pflags := in.string(), in.uint32()
+		attrs := in.attrs()
+		flags := 0
+		switch {
+		case pflags&0x3 == 0x3:
+			flags = os.O_RDWR
+		case pflags&0x2 != 0:
+			flags = os.O_WRONLY
+		}
+		if pflags&0x08 != 0 {
+			flags |= os.O_CREATE
+		}
+		if pflags&0x10 != 0 {
+			flags |= os.O_TRUNC
+		}
+		if pflags&0x20 != 0 {
+			flags |= os.O_EXCL
+		}
+		perm := fs.FileMode(0o644)
+		if attrs.flags&attrPermissions != 0 {
+			perm = fs.FileMode(attrs.perm & 0o777)
+		}
+		f, err := os.OpenFile(s.local(name), flags, perm)
+		if err != nil {
+			return status(id, err)
+		}
+		return s.newHandle(id, f)
+	case fxpOpendir:
+		dir := s.local(in.string())
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return status(id, fs.ErrNotExist)
+		}
+		return s.newHandle(id, &dirHandle{dir: dir})
+	case fxpClose:
+		h := s.take(in.string())
+		if f, ok := h.(*os.File); ok {
+			return status(id, f.Close())
+		}
+		return status(id, nil)
+	case fxpRead:
+		f, _ := s.lookup(in.string()).(*os.File)
+		offset, length := in.uint64(), in.uint32()
+		if f == nil {
+			return status(id, fs.ErrInvalid)
+		}
+		buf := make([]byte, length)
+		n, err := f.ReadAt(buf, int64(offset))
+		if n == 0 && err == io.EOF {
+			return statusCode(id, fxEOF, "EOF")
+		}
+		if n == 0 && err != nil {
+			return status(id, err)
+		}
+		return appendBytes([]byte{fxpData}, id, buf[:n])
+	case fxpWrite:
+		f, _ := s.lookup(in.string()).(*os.File)
+		offset, data := in.uint64(), in.bytes()
+		if f == nil {
+			return status(id, fs.ErrInvalid)
+		}
+		if s.FailWritesAfter > 0 && int64(offset)+int64(len(data)) > s.FailWritesAfter {
+			return statusCode(id, fxFailure, "no space left on device")
+		}
+		_, err := f.WriteAt(data, int64(offset))
+		return status(id, err)
+	case fxpReaddir:
+		d, _ := s.lookup(in.string()).(*dirHandle)
+		if d == nil {
+			return status(id, fs.ErrInvalid)
+		}
+		if d.done {
+			return statusCode(id, fxEOF, "EOF")
+		}
+		d.done = true
+		entries, err := os.ReadDir(d.dir)
+		if err != nil {
+			return status(id, err)
+		}
+		out := binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32([]byte{fxpName}, id), uint32(len(entries)))
+		for _, e := range entries {
+			info, err := e.Info()
+			if err != nil {
+				return status(id, err)
+			}
+			out = appendString(appendString(out, e.Name()), e.Name())
+			out = appendAttrs(out, info)
+		}
+		return out
+	case fxpLstat, fxpStat:
+		p := s.local(in.string())
+		stat := os.Lstat
+		if typ == fxpStat {
+			stat = os.Stat
+		}
+		info, err := stat(p)
+		if err != nil {
+			return status(id, err)
+		}
+		return appendAttrs(binary.BigEndian.AppendUint32([]byte{fxpAttrs}, id), info)
+	case fxpSetstat:
+		p := s.local(in.string())
+		attrs := in.attrs()
+		if attrs.flags&attrPermissions != 0 {
+			if err := os.Chmod(p, fs.FileMode(attrs.perm&0o777)); err != nil {
+				return status(id, err)
+			}
+		}
+		if attrs.flags&attrACModTime != 0 {
+			if err := os.Chtimes(p, time.Unix(int64(attrs.atime), 0), time.Unix(int64(attrs.mtime), 0)); err != nil {
+				return status(id, err)
+			}
+		}
+		return status(id, nil)
+	case fxpRealpath:
+		return name(id, path.Clean("/"+in.string()))
+	case fxpReadlink:
+		target, err := os.Readlink(s.local(in.string()))
+		if err != nil {
+			return status(id, err)
+		}
+		return name(id, target)
+	case fxpRemove:
+		p := s.local(in.string())
+		if info, err := os.Lstat(p); err == nil && info.IsDir() {
+			return statusCode(id, fxFailure, "is a directory")
+		}
+		return status(id, os.Remove(p))
+	case fxpRename:
+		oldPath, newPath := s.local(in.string()), s.local(in.string())
+		// Version 3 renames refuse to replace a file
+		if _, err := os.Lstat(newPath); err == nil {
+			return statusCode(id, fxFailure, "file exists")
+		}
+		return status(id, os.Rename(oldPath, newPath))
+	case fxpExtended:
+		if in.string() != "posix-rename@openssh.com" || s.NoPosixRename {
+			return statusCode(id, fxOpUnsupported, "unsupported")
+		}
+		return status(id, os.Rename(s.local(in.string()), s.local(in.string())))
+	}
+	return statusCode(id, fxOpUnsupported, "unsupported request "+strconv.Itoa(int(typ)))
+}
+
+func (s *Server) newHandle(id uint32, h any) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next++
+	key := strconv.Itoa(s.next)
+	s.handles[key] = h
+	return appendString(binary.BigEndian.AppendUint32([]byte{fxpHandle}, id), key)
+}
+
+func (s *Server) lookup(key string) any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.handles[key]
+}
+
+func (s *Server) take(key string) any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := s.handles[key]
+	delete(s.handles, key)
+	return h
+}
+
+// status answers with the status code matching err, OK when it is nil
+func status(id uint32, err error) []byte {
+	switch {
+	case err == nil:
+		return statusCode(id, fxOK, "")
+	case errors.Is(err, fs.ErrNotExist):
+		return statusCode(id, fxNoSuchFile, err.Error())
+	case errors.Is(err, fs.ErrPermission):
+		return statusCode(id, fxPermissionDenied, err.Error())
+	}
+	return statusCode(id, fxFailure, err.Error())
+}
+
+func statusCode(id uint32, code uint32, message string) []byte {
+	out := binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32([]byte{fxpStatus}, id), code)
+	return appendString(appendString(out, message), "")
+}
+
+// name answers with a single name without attributes
+func name(id uint32, n string) []byte {
+	out := binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32([]byte{fxpName}, id), 1)
+	out = appendString(appendString(out, n), n)
+	return binary.BigEndian.AppendUint32(out, 0)
+}
+
+func appendString(b []byte, s string) []byte {
+	return append(binary.BigEndian.AppendUint32(b, uint32(len(s))), s...)
+}
+
+func appendBytes(b []byte, id uint32, data []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, id)
+	return append(binary.BigEndian.AppendUint32(b, uint32(len(data))), data...)
+}
+
+// appendAttrs encodes the size, st_mode and times of info
+func appendAttrs(b []byte, info fs.FileInfo) []byte {
+	mode := uint32(info.Mode().Perm())
+	switch {
+	case info.IsDir():
+		mode |= 0o040000
+	case info.Mode()&fs.ModeSymlink != 0:
+		mode |= 0o120000
+	case info.Mode().IsRegular():
+		mode |= 0o100000
+	}
+	b = binary.BigEndian.AppendUint32(b, attrSize|attrPermissions|attrACModTime)
+	b = binary.BigEndian.AppendUint64(b, uint64(info.Size()))
+	b = binary.BigEndian.AppendUint32(b, mode)
+	mtime := uint32(info.ModTime().Unix())
+	return binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(b, mtime), mtime)
+}
+
+// decoder reads the fields of a request, a short request reads as zero values
+type decoder struct {
+	b []byte
+}
+
+func (d *decoder) uint32() uint32 {
+	if len(d.b) < 4 {
+		d.b = nil
+		return 0
+	}
+	v := binary.BigEndian.Uint32(d.b)
+	d.b = d.b[4:]
+	return v
+}
+
+func (d *decoder) uint64() uint64 {
+	return uint64(d.uint32())<<32 | uint64(d.uint32())
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uint32()
+	if uint32(len(d.b)) < n {
+		d.b = nil
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes())
+}
+
+// requestAttrs are the attributes sent with open and setstat
+type requestAttrs struct {
+	flags, perm, atime, mtime uint32
+}
+
+func (d *decoder) attrs() requestAttrs {
+	a := requestAttrs{flags: d.uint32()}
+	if a.flags&attrSize != 0 {
+		d.uint64()
+	}
+	if a.flags&attrUIDGID != 0 {
+		d.uint32()
+		d.uint32()
+	}
+	if a.flags&attrPermissions != 0 {
+		a.perm = d.uint32()
+	}
+	if a.flags&attrACModTime != 0 {
+		a.atime, a.mtime = d.uint32(), d.uint32()
+	}
+	return a
+}
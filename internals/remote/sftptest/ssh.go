@@ -0,0 +1,134 @@
+package sftptest
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// User is the only login ListenSSH accepts
+const User = "gsn"
+
+// ListenSSH serves the files over SSH on a port of 127.0.0.1 until the end of the test, as an sshd with its
+// sftp subsystem would. It writes home/.ssh/known_hosts with the key of the server and an unencrypted
+// home/.ssh/id_ed25519 the server accepts, so remote.Dial connects with $HOME set to home, and returns the
+// user@host:port to dial.
+func (s *Server) ListenSSH(t testing.TB, home string) string {
+	t.Helper()
+	s.handles = make(map[string]any)
+	_, hostKey := newKey(t)
+	clientPrivate, clientKey := newKey(t)
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if meta.User() == User && bytes.Equal(key.Marshal(), clientKey.PublicKey().Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("sftptest: unknown key")
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var conns []net.Conn
+	t.Cleanup(func() {
+		listener.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, c := range conns {
+			c.Close()
+		}
+	})
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, c)
+			mu.Unlock()
+			go s.serveSSH(c, config)
+		}
+	}()
+
+	addr := listener.Addr().String()
+	writeSSHFile(t, home, "known_hosts", knownhosts.Line([]string{knownhosts.Normalize(addr)}, hostKey.PublicKey())+"\n")
+	block, err := ssh.MarshalPrivateKey(clientPrivate, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeSSHFile(t, home, "id_ed25519", string(pem.EncodeToMemory(block)))
+	return User + "@" + addr
+}
+
+// newKey generates an Ed25519 key and its signer
+func newKey(t testing.TB) (ed25519.PrivateKey, ssh.Signer) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, signer
+}
+
+func writeSSHFile(t testing.TB, home string, name string, content string) {
+	t.Helper()
+	dir := filepath.Join(home, ".ssh")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serveSSH runs the SFTP server on every session of c that asks for the sftp subsystem, and refuses the rest
+func (s *Server) serveSSH(c net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(c, config)
+	if err != nil {
+		c.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "sftptest only opens sessions")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for req := range requests {
+				// The payload of a subsystem request is the name as an SSH string
+				sftp := req.Type == "subsystem" && len(req.Payload) >= 4 &&
+					string(req.Payload[4:]) == "sftp" && binary.BigEndian.Uint32(req.Payload) == 4
+				req.Reply(sftp, nil)
+				if sftp {
+					go s.serve(channel, channel)
+				}
+			}
+		}()
+	}
+}
@@ -0,0 +1,372 @@
+package request
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/redact"
+	"gsn-dev-tools/internals/style"
+	"gsn-dev-tools/internals/units"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+func HTTPCmd() *cobra.Command {
+	httpCmd := &cobra.Command{
+		Use:   "http <method> <url>",
+		Short: "Sends HTTP requests and saves the ones used again",
+		Long: `Sends a request and prints the response body, JSON indented on a terminal, then the status, time, size and
+content type on stderr. -d takes the body inline, from @file or from @- for stdin; a body that is JSON is sent
+as application/json unless -H sets a Content-Type. --token sends a bearer token, and header values may be
+keyring:<name> references resolved from the secret store (gsn secret).
+
+--save <name> keeps the request in requests.yaml of the gsn config dir and --run <name> sends it again, with
+-H, -d and --token of the command line replacing the saved ones. Tokens and credential headers are only saved
+as keyring references, and @file bodies as absolute paths read at every run. {{name}} placeholders in the URL,
+headers and inline body are filled with --var name=value.
+
+On a terminal a body larger than --max-body goes to a temporary file instead. Network errors and 429, 502, 503
+and 504 answers are retried --retries times for GET, HEAD, OPTIONS, PUT and DELETE, and for every method when
+--retries is given; --timeout bounds each attempt. A 404 exits with 3, a 409 or 422 with 4, any other status
+outside 2xx with 1.`,
+		Example: `  gsn http get https://api.example.com/v1/users -H 'Accept: application/json'
+  gsn http post https://api.example.com/v1/users -d '{"name": "ada"}' --token keyring:example-api
+  gsn http put https://api.example.com/v1/users/{{id}} -d @user.json --token keyring:example-api --save update-user
+  gsn http --run update-user --var id=42
+  gsn http --list`,
+		Args: cobra.MaximumNArgs(2),
+		Run:  SendHTTP,
+	}
+
+	httpCmd.Flags().StringArrayP("header", "H", nil, "Header as 'Name: value', the value may be keyring:<name> (repeatable)")
+	httpCmd.Flags().StringP("data", "d", "", "Request body, @file to read it from a file or @- from stdin")
+	httpCmd.Flags().String("token", "", "Bearer token, keyring:<name> to read it from the secret store")
+	httpCmd.Flags().StringArray("var", nil, "Value of a {{name}} placeholder as name=value (repeatable)")
+	httpCmd.Flags().String("save", "", "Save the request under this name, then send it")
+	httpCmd.Flags().String("run", "", "Send the request saved under this name")
+	httpCmd.Flags().Bool("list", false, "List the saved requests")
+	httpCmd.Flags().String("delete", "", "Delete the request saved under this name")
+	httpCmd.Flags().Duration("timeout", 30*time.Second, "Timeout of each attempt")
+	httpCmd.Flags().Int("retries", 2, "Attempts after the first on network errors and 429, 502, 503 and 504 answers")
+	httpCmd.Flags().StringP("output", "o", "", "Write the response body to this file")
+	httpCmd.Flags().String("max-body", "1MiB", "Largest body printed on a terminal, larger ones go to a temporary file")
+	httpCmd.Flags().BoolP("include", "i", false, "Print the response headers on stderr")
+	httpCmd.Flags().Bool("raw", false, "Print JSON bodies as received")
+	_ = httpCmd.RegisterFlagCompletionFunc("run", completeSaved)
+	_ = httpCmd.RegisterFlagCompletionFunc("delete", completeSaved)
+	return httpCmd
+}
+
+func completeSaved(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	saved, _, err := loadSaved()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return saved.names(), cobra.ShellCompDirectiveNoFileComp
+}
+
+func SendHTTP(cmd *cobra.Command, args []string) {
+	headers, _ := cmd.Flags().GetStringArray("header")
+	data, _ := cmd.Flags().GetString("data")
+	token, _ := cmd.Flags().GetString("token")
+	varFlags, _ := cmd.Flags().GetStringArray("var")
+	saveName, _ := cmd.Flags().GetString("save")
+	runName, _ := cmd.Flags().GetString("run")
+	list, _ := cmd.Flags().GetBool("list")
+	deleteName, _ := cmd.Flags().GetString("delete")
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+	retries, _ := cmd.Flags().GetInt("retries")
+	outPath, _ := cmd.Flags().GetString("output")
+	maxBodyFlag, _ := cmd.Flags().GetString("max-body")
+	include, _ := cmd.Flags().GetBool("include")
+	raw, _ := cmd.Flags().GetBool("raw")
+
+	if list || deleteName != "" {
+		if len(args) > 0 || runName != "" || saveName != "" {
+			clierr.Exitf(clierr.Usage, "--list and --delete take no request")
+		}
+		if err := manageSaved(list, deleteName); err != nil {
+			clierr.Fatalf("%v", err)
+		}
+		return
+	}
+
+	maxBody, err := units.ParseBytes(maxBodyFlag)
+	if err != nil {
+		clierr.Exitf(clierr.Usage, "invalid --max-body: %v", err)
+	}
+	if retries < 0 {
+		clierr.Exitf(clierr.Usage, "--retries cannot be negative")
+	}
+	vars, err := parseVars(varFlags)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+
+	var sp spec
+	switch {
+	case runName != "":
+		if len(args) > 0 {
+			clierr.Exitf(clierr.Usage, "--run takes no method or URL, they are saved with the request")
+		}
+		if saveName != "" {
+			clierr.Exitf(clierr.Usage, "--save cannot be combined with --run")
+		}
+		saved, _, err := loadSaved()
+		if err != nil {
+			clierr.Fatalf("%v", err)
+		}
+		found, ok := saved.Requests[runName]
+		if !ok {
+			clierr.Exitf(clierr.NotFound, "no saved request '%s', see gsn http --list", runName)
+		}
+		sp = found
+		sp.Headers = mergeHeaders(sp.Headers, headers)
+		if cmd.Flags().Changed("data") {
+			sp.Data = data
+		}
+		if cmd.Flags().Changed("token") {
+			sp.Token = token
+		}
+	case len(args) == 2:
+		sp = spec{Method: strings.ToUpper(args[0]), URL: args[1], Headers: headers, Data: data, Token: token}
+	default:
+		clierr.Exitf(clierr.Usage, "expected a method and a URL, or --run <name>")
+	}
+
+	if saveName != "" {
+		if err := saveRequest(saveName, sp); err != nil {
+			clierr.Fatalf("%v", err)
+		}
+	}
+
+	sp, err = expand(sp, vars)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	p, err := prepare(sp, os.Stdin)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	if !idempotent(p.Method) && !cmd.Flags().Changed("retries") {
+		retries = 0
+	}
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	s := sender{Client: &http.Client{Timeout: timeout}, Retries: retries, Log: os.Stderr}
+	start := time.Now()
+	resp, err := s.send(ctx, p)
+	if err != nil {
+		clierr.Fatalf("%s %s failed: %v", p.Method, p.URL, err)
+	}
+	defer resp.Body.Close()
+
+	if include {
+		fmt.Fprintf(os.Stderr, "%s %s\n", resp.Proto, resp.Status)
+		names := make([]string, 0, len(resp.Header))
+		for name := range resp.Header {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			for _, value := range resp.Header[name] {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", name, value)
+			}
+		}
+		fmt.Fprintln(os.Stderr)
+	}
+
+	body := bodyWriter{Path: outPath, MaxBody: maxBody, Pretty: !raw, Terminal: term.IsTerminal(redact.Fd(os.Stdout))}
+	size, note, err := body.write(resp)
+	if err != nil {
+		clierr.Fatalf("Error reading the response: %v", err)
+	}
+
+	code := statusCode(resp.StatusCode)
+	mark := style.Success()
+	if code != clierr.Success {
+		mark = style.Failure()
+	}
+	summary := fmt.Sprintf("%s in %s, %s", resp.Status, units.FormatDuration(time.Since(start)), units.FormatBytes(size))
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		summary += ", " + contentType
+	}
+	fmt.Fprintln(os.Stderr, mark+summary)
+	if note != "" {
+		fmt.Fprintln(os.Stderr, note)
+	}
+	if code != clierr.Success {
+		clierr.Exit(code)
+	}
+}
+
+// mergeHeaders replaces the saved headers named again on the command line and adds the others
+func mergeHeaders(saved []string, given []string) []string {
+	names := make(map[string]bool, len(given))
+	for _, h := range given {
+		name, _, _ := strings.Cut(h, ":")
+		names[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+	var merged []string
+	for _, h := range saved {
+		name, _, _ := strings.Cut(h, ":")
+		if !names[strings.ToLower(strings.TrimSpace(name))] {
+			merged = append(merged, h)
+		}
+	}
+	return append(merged, given...)
+}
+
+func saveRequest(name string, sp spec) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	sp, err := forSaving(sp)
+	if err != nil {
+		return err
+	}
+	saved, path, err := loadSaved()
+	if err != nil {
+		return err
+	}
+	_, exists := saved.Requests[name]
+	saved.Requests[name] = sp
+	if err := saved.save(path); err != nil {
+		return err
+	}
+	verb := "Saved"
+	if exists {
+		verb = "Updated"
+	}
+	fmt.Fprintf(os.Stderr, style.Success()+"%s %s\n", verb, describe(name, sp))
+	return nil
+}
+
+func manageSaved(list bool, deleteName string) error {
+	saved, path, err := loadSaved()
+	if err != nil {
+		return err
+	}
+	if list {
+		if len(saved.Requests) == 0 {
+			fmt.Println("No saved requests, save one with gsn http <method> <url> --save <name>")
+			return nil
+		}
+		width := 0
+		for name := range saved.Requests {
+			width = max(width, len(name))
+		}
+		for _, name := range saved.names() {
+			sp := saved.Requests[name]
+			fmt.Printf("%-*s  %-6s  %s\n", width, name, sp.Method, sp.URL)
+		}
+		return nil
+	}
+
+	sp, ok := saved.Requests[deleteName]
+	if !ok {
+		return clierr.Newf(clierr.NotFound, "no saved request '%s'", deleteName)
+	}
+	delete(saved.Requests, deleteName)
+	if err := saved.save(path); err != nil {
+		return err
+	}
+	fmt.Printf(style.Trash()+"Deleted %s\n", describe(deleteName, sp))
+	return nil
+}
+
+// bodyWriter sends a response body to a file, a pipe or the terminal
+type bodyWriter struct {
+	Path     string
+	MaxBody  int64
+	Pretty   bool
+	Terminal bool
+}
+
+// write copies the body of resp and returns its size and, when it went to a file, a line saying where
+func (b bodyWriter) write(resp *http.Response) (int64, string, error) {
+	if b.Path != "" {
+		n, err := writeFile(b.Path, resp.Body)
+		return n, fmt.Sprintf("Body written to %s", b.Path), err
+	}
+	if !b.Terminal {
+		n, err := io.Copy(os.Stdout, resp.Body)
+		return n, "", err
+	}
+
+	head, err := io.ReadAll(io.LimitReader(resp.Body, b.MaxBody+1))
+	if err != nil {
+		return 0, "", err
+	}
+	if int64(len(head)) > b.MaxBody {
+		file, err := os.CreateTemp("", "gsn-http-*"+extension(resp.Header.Get("Content-Type")))
+		if err != nil {
+			return 0, "", err
+		}
+		defer file.Close()
+		n, err := io.Copy(file, io.MultiReader(bytes.NewReader(head), resp.Body))
+		if err != nil {
+			return n, "", err
+		}
+		return n, fmt.Sprintf("Body larger than %s written to %s", units.FormatBytes(b.MaxBody), file.Name()), file.Close()
+	}
+
+	out := head
+	if isJSON(resp.Header.Get("Content-Type")) {
+		redact.JSONOutput()
+	}
+	if b.Pretty && isJSON(resp.Header.Get("Content-Type")) {
+		var indented bytes.Buffer
+		if json.Indent(&indented, head, "", "  ") == nil {
+			out = indented.Bytes()
+		}
+	}
+	if len(out) > 0 && out[len(out)-1] != '\n' {
+		out = append(out, '\n')
+	}
+	_, err = os.Stdout.Write(out)
+	return int64(len(head)), "", err
+}
+
+func writeFile(path string, r io.Reader) (int64, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return n, err
+}
+
+// extension picks the file extension of a content type, so the temporary file opens in the right program
+func extension(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case isJSON(contentType):
+		return ".json"
+	case mediaType == "text/plain":
+		return ".txt"
+	case mediaType == "text/html":
+		return ".html"
+	}
+	if exts, err := mime.ExtensionsByType(contentType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
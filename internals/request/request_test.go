@@ -0,0 +1,363 @@
+package request
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/paths"
+	"gsn-dev-tools/internals/secrets"
+)
+
+// useHome gives the test a gsn home of its own with the encrypted file as secret store, holding secrets
+func useHome(t *testing.T, stored map[string]string) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv(paths.HomeEnv, home)
+	t.Setenv("GSN_SECRETS_BACKEND", "file")
+	t.Setenv("GSN_SECRETS_PASSWORD", "test password")
+	backend, err := secrets.Open("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range stored {
+		if err := backend.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return home
+}
+
+// received is a request as the test server saw it
+type received struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   string
+}
+
+// recordingServer answers every request with the next of statuses, the last one repeated, and records them
+type recordingServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []received
+}
+
+func newRecordingServer(t *testing.T, header http.Header, statuses ...int) *recordingServer {
+	t.Helper()
+	s := &recordingServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		s.requests = append(s.requests, received{r.Method, r.URL.Path, r.Header.Clone(), string(body)})
+		status := statuses[min(len(s.requests), len(statuses))-1]
+		s.mu.Unlock()
+		for name, values := range header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, `{"ok":true}`)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *recordingServer) Requests() []received {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.requests)
+}
+
+func TestSaveAndRunRoundTrip(t *testing.T) {
+	home := useHome(t, map[string]string{"api-token": "s3cret", "api-key": "k3y"})
+	server := newRecordingServer(t, nil, http.StatusOK)
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if err := os.WriteFile("user.json", []byte(`{"name":"ada"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	sp := spec{
+		Method:  "PUT",
+		URL:     server.URL + "/users/{{id}}",
+		Headers: []string{"Accept: application/json", "X-Api-Key: keyring:api-key"},
+		Data:    "@user.json",
+		Token:   "keyring:api-token",
+	}
+	if err := saveRequest("update-user", sp); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file holds the references and the absolute body path, no secret, and is readable only by the user
+	path := filepath.Join(home, "config", savedFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "s3cret") || strings.Contains(string(data), "k3y") || !strings.Contains(string(data), "keyring:api-token") {
+		t.Errorf("%s =\n%s", savedFile, data)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o600 {
+		t.Errorf("%s has mode %v", savedFile, info.Mode().Perm())
+	}
+
+	// Run from elsewhere, with the body changed since and Accept given again on the command line
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile(filepath.Join(dir, "user.json"), []byte(`{"name":"grace"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	saved, _, err := loadSaved()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found, ok := saved.Requests["update-user"]
+	if !ok || found.Data != "@"+filepath.Join(dir, "user.json") {
+		t.Fatalf("saved request = %+v", found)
+	}
+	found.Headers = mergeHeaders(found.Headers, []string{"accept: text/plain"})
+	found, err = expand(found, map[string]string{"id": "42"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := prepare(found, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := sender{Client: server.Client(), Log: io.Discard}.send(context.Background(), p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	requests := server.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("%d requests", len(requests))
+	}
+	got := requests[0]
+	if got.Method != "PUT" || got.Path != "/users/42" || got.Body != `{"name":"grace"}` {
+		t.Errorf("request = %s %s %s", got.Method, got.Path, got.Body)
+	}
+	for name, want := range map[string]string{
+		"Authorization": "Bearer s3cret",
+		"X-Api-Key":     "k3y",
+		"Accept":        "text/plain",
+		"Content-Type":  "application/json",
+		"User-Agent":    "gsn",
+	} {
+		if values := got.Header.Values(name); len(values) != 1 || values[0] != want {
+			t.Errorf("%s = %q, want %q", name, values, want)
+		}
+	}
+
+	// Saving again updates, deleting removes
+	sp.URL = server.URL + "/v2/users/{{id}}"
+	if err := saveRequest("update-user", sp); err != nil {
+		t.Fatal(err)
+	}
+	if saved, _, _ := loadSaved(); len(saved.Requests) != 1 || saved.Requests["update-user"].URL != sp.URL {
+		t.Errorf("after the update = %+v", saved.Requests)
+	}
+	if err := manageSaved(false, "update-user"); err != nil {
+		t.Fatal(err)
+	}
+	if err := manageSaved(false, "update-user"); clierr.CodeOf(err) != clierr.NotFound {
+		t.Errorf("deleting again = %v", err)
+	}
+}
+
+func TestForSavingRefusesSecretsInClear(t *testing.T) {
+	tests := []struct {
+		sp   spec
+		want string
+	}{
+		{spec{Token: "ghp_clear"}, "refusing to save a token in clear"},
+		{spec{Headers: []string{"Authorization: Bearer abc"}}, "refusing to save the Authorization header in clear"},
+		{spec{Headers: []string{" cookie : session=1"}}, "refusing to save the cookie header in clear"},
+		{spec{Headers: []string{"X-API-Key: abc"}}, "refusing to save the X-API-Key header in clear"},
+		{spec{Headers: []string{"Proxy-Authorization: Basic abc"}}, "refusing to save the Proxy-Authorization header"},
+		{spec{Data: "@-"}, "a body read from stdin cannot be saved"},
+	}
+	for _, test := range tests {
+		if _, err := forSaving(test.sp); clierr.CodeOf(err) != clierr.Usage || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("forSaving(%+v) = %v, want %q", test.sp, err, test.want)
+		}
+	}
+
+	ok := spec{Token: "keyring:t", Headers: []string{"Authorization: keyring:auth", "Accept: */*"}, Data: `{"inline":true}`}
+	if got, err := forSaving(ok); err != nil || got.Data != ok.Data {
+		t.Errorf("forSaving(%+v) = %+v, %v", ok, got, err)
+	}
+	for _, name := range []string{"a", "list-users", "v1.get_user", "0"} {
+		if err := checkName(name); err != nil {
+			t.Errorf("checkName(%s) = %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "-x", ".hidden", "a/b", "a b"} {
+		if err := checkName(name); clierr.CodeOf(err) != clierr.Usage {
+			t.Errorf("checkName(%q) = %v, want a usage error", name, err)
+		}
+	}
+}
+
+func TestExpandPlaceholders(t *testing.T) {
+	sp := spec{
+		URL:     "https://api/{{ org }}/{{repo}}/issues/{{id}}",
+		Headers: []string{"X-Org: {{org}}"},
+		Data:    `{"title": "{{title}}"}`,
+		Token:   "keyring:{{org}}-token",
+	}
+	got, err := expand(sp, map[string]string{"org": "acme", "repo": "api", "id": "7", "title": "Crash"})
+	if err != nil || got.URL != "https://api/acme/api/issues/7" || got.Headers[0] != "X-Org: acme" ||
+		got.Data != `{"title": "Crash"}` || got.Token != "keyring:acme-token" {
+		t.Errorf("expand = %+v, %v", got, err)
+	}
+	if sp.Headers[0] != "X-Org: {{org}}" {
+		t.Error("expand changed the headers of the saved request")
+	}
+
+	// Every missing value is listed once, in order
+	if _, err := expand(sp, map[string]string{"org": "acme"}); clierr.CodeOf(err) != clierr.Usage ||
+		err.Error() != "no value for repo, id, title, pass --var name=value" {
+		t.Errorf("expand with missing values = %v", err)
+	}
+	// A body file is read as is, placeholders in its path too
+	if got, err := expand(spec{Data: "@/tmp/{{x}}.json"}, nil); err != nil || got.Data != "@/tmp/{{x}}.json" {
+		t.Errorf("expand of an @file body = %+v, %v", got, err)
+	}
+}
+
+func TestPrepare(t *testing.T) {
+	useHome(t, map[string]string{"api-token": "s3cret"})
+
+	p, err := prepare(spec{Method: "post", URL: "http://x", Data: "@-"}, strings.NewReader("plain text"))
+	if err != nil || p.Method != "POST" || string(p.Body) != "plain text" || p.Header.Get("Content-Type") != "" {
+		t.Errorf("prepare with a stdin body = %+v, %v", p, err)
+	}
+	p, err = prepare(spec{Method: "POST", Data: `[1]`, Headers: []string{"Content-Type: text/csv"}}, nil)
+	if err != nil || p.Header.Get("Content-Type") != "text/csv" {
+		t.Errorf("a given Content-Type is kept, got %q, %v", p.Header.Get("Content-Type"), err)
+	}
+
+	tests := []struct {
+		sp   spec
+		want string
+	}{
+		{spec{Headers: []string{"no colon"}}, "invalid header 'no colon', expected 'Name: value'"},
+		{spec{Headers: []string{": value"}}, "invalid header ': value'"},
+		{spec{Headers: []string{"Authorization: Basic x"}, Token: "t"}, "--token cannot be combined with an Authorization header"},
+		{spec{Token: "keyring:missing"}, "failed to resolve keyring:missing from file: secret not found"},
+		{spec{Headers: []string{"X-Key: keyring:missing"}}, "failed to resolve keyring:missing"},
+		{spec{Data: "@" + filepath.Join(t.TempDir(), "missing.json")}, "cannot read the body: "},
+	}
+	for _, test := range tests {
+		if _, err := prepare(test.sp, nil); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("prepare(%+v) = %v, want %q", test.sp, err, test.want)
+		}
+	}
+}
+
+func TestSendRetries(t *testing.T) {
+	p := prepared{Method: "GET", Header: http.Header{}}
+
+	// Overloaded twice, Retry-After: 0 skips the backoff
+	server := newRecordingServer(t, http.Header{"Retry-After": {"0"}}, http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK)
+	p.URL = server.URL
+	var log strings.Builder
+	resp, err := sender{Client: server.Client(), Retries: 2, Log: &log}.send(context.Background(), p)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("send = %v, %v", resp, err)
+	}
+	resp.Body.Close()
+	if n := len(server.Requests()); n != 3 {
+		t.Errorf("%d attempts, want 3", n)
+	}
+	if !strings.Contains(log.String(), "503 Service Unavailable, retrying in 0s (1/2)") || !strings.Contains(log.String(), "429 Too Many Requests, retrying in 0s (2/2)") {
+		t.Errorf("log =\n%s", log.String())
+	}
+
+	// Out of retries, the last answer is returned
+	server = newRecordingServer(t, http.Header{"Retry-After": {"0"}}, http.StatusBadGateway)
+	p.URL = server.URL
+	resp, err = sender{Client: server.Client(), Retries: 1, Log: io.Discard}.send(context.Background(), p)
+	if err != nil || resp.StatusCode != http.StatusBadGateway || len(server.Requests()) != 2 {
+		t.Errorf("send = %v, %v after %d attempts", resp, err, len(server.Requests()))
+	}
+
+	// Other failures are final
+	server = newRecordingServer(t, nil, http.StatusInternalServerError)
+	p.URL = server.URL
+	resp, err = sender{Client: server.Client(), Retries: 3, Log: io.Discard}.send(context.Background(), p)
+	if err != nil || resp.StatusCode != http.StatusInternalServerError || len(server.Requests()) != 1 {
+		t.Errorf("send = %v, %v after %d attempts", resp, err, len(server.Requests()))
+	}
+
+	// A cancel during the backoff ends the wait
+	server = newRecordingServer(t, http.Header{"Retry-After": {"30"}}, http.StatusServiceUnavailable)
+	p.URL = server.URL
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := (sender{Client: server.Client(), Retries: 5, Log: io.Discard}).send(ctx, p); err != context.DeadlineExceeded || time.Since(start) > 5*time.Second {
+		t.Errorf("send during a cancelled backoff = %v after %s", err, time.Since(start))
+	}
+
+	// Network errors are retried, and reported without the method and URL
+	server = newRecordingServer(t, nil, http.StatusOK)
+	p.URL = server.URL
+	server.Close()
+	log.Reset()
+	if _, err := (sender{Client: &http.Client{}, Retries: 1, Log: &log}).send(context.Background(), p); err == nil || strings.Contains(err.Error(), p.URL) {
+		t.Errorf("send to a closed server = %v", err)
+	}
+	if !strings.Contains(log.String(), "connection refused, retrying in 500ms (1/1)") {
+		t.Errorf("log = %q", log.String())
+	}
+}
+
+func TestRetryAfterAndBackoff(t *testing.T) {
+	for value, want := range map[string]time.Duration{"0": 0, "7": 7 * time.Second, time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat): 0} {
+		if got, ok := retryAfter(http.Header{"Retry-After": {value}}); !ok || got != want {
+			t.Errorf("retryAfter(%s) = %s, %v, want %s", value, got, ok, want)
+		}
+	}
+	future := time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat)
+	if got, ok := retryAfter(http.Header{"Retry-After": {future}}); !ok || got <= 8*time.Second || got > 10*time.Second {
+		t.Errorf("retryAfter(%s) = %s, %v", future, got, ok)
+	}
+	for _, value := range []string{"", "-1", "soon"} {
+		if _, ok := retryAfter(http.Header{"Retry-After": {value}}); ok {
+			t.Errorf("retryAfter(%q) is accepted", value)
+		}
+	}
+
+	var waits []time.Duration
+	for attempt := range 8 {
+		waits = append(waits, backoff(attempt))
+	}
+	want := []time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, maxBackoff, maxBackoff}
+	if !slices.Equal(waits, want) {
+		t.Errorf("backoff = %v, want %v", waits, want)
+	}
+}
+
+func TestStatusCode(t *testing.T) {
+	for status, want := range map[int]clierr.Code{
+		200: clierr.Success, 204: clierr.Success, 301: clierr.Failure, 400: clierr.Failure, 401: clierr.Failure,
+		404: clierr.NotFound, 410: clierr.NotFound, 405: clierr.Conflict, 409: clierr.Conflict, 412: clierr.Conflict,
+		422: clierr.Conflict, 500: clierr.Failure,
+	} {
+		if got := statusCode(status); got != want {
+			t.Errorf("statusCode(%d) = %d, want %d", status, got, want)
+		}
+	}
+}
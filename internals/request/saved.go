@@ -0,0 +1,198 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/output"
+	"gsn-dev-tools/internals/paths"
+	"gsn-dev-tools/internals/secrets"
+	"gsn-dev-tools/internals/state"
+
+	"gopkg.in/yaml.v3"
+)
+
+// savedFile holds the saved requests inside the gsn config dir
+const savedFile = "requests.yaml"
+
+// savedKind versions the saved requests
+var savedKind = state.Register(&state.Kind{
+	Name:     "saved requests",
+	Format:   state.YAML,
+	Version:  1,
+	Patterns: []string{savedFile},
+	Files: func() ([]string, error) {
+		path, err := savedPath()
+		if err != nil {
+			return nil, err
+		}
+		return []string{path}, nil
+	},
+})
+
+// namePattern matches the names requests are saved under
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// spec is a request as given on the command line or saved. Header values and Token may be keyring:<name>
+// references, Data may be @file, and every field but Method may hold {{var}} placeholders.
+type spec struct {
+	Method  string   `yaml:"method"`
+	URL     string   `yaml:"url"`
+	Headers []string `yaml:"headers,omitempty"`
+	Data    string   `yaml:"data,omitempty"`
+	Token   string   `yaml:"token,omitempty"`
+}
+
+// savedRequests is the content of requests.yaml
+type savedRequests struct {
+	SchemaVersion int             `yaml:"schema_version"`
+	Requests      map[string]spec `yaml:"requests"`
+}
+
+func savedPath() (string, error) {
+	dir, err := paths.ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, savedFile), nil
+}
+
+// loadSaved reads the saved requests, none when the file does not exist yet
+func loadSaved() (savedRequests, string, error) {
+	saved := savedRequests{Requests: map[string]spec{}}
+	path, err := savedPath()
+	if err != nil {
+		return saved, "", err
+	}
+	doc, err := savedKind.Load(path, &saved)
+	if errors.Is(err, os.ErrNotExist) {
+		return saved, path, nil
+	}
+	if err != nil {
+		return saved, path, err
+	}
+	if saved.Requests == nil {
+		saved.Requests = map[string]spec{}
+	}
+	return saved, path, doc.Writable()
+}
+
+func (s savedRequests) save(path string) error {
+	s.SchemaVersion = savedKind.Version
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return output.WriteFileAtomic(path, data, 0o600)
+}
+
+func (s savedRequests) names() []string {
+	names := make([]string, 0, len(s.Requests))
+	for name := range s.Requests {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// forSaving checks that sp keeps its secrets out of the file and makes its @file absolute, so the request runs
+// from any directory
+func forSaving(sp spec) (spec, error) {
+	if sp.Token != "" && !secrets.IsRef(sp.Token) {
+		return sp, clierr.Newf(clierr.Usage, "refusing to save a token in clear, store it with `gsn secret set <name>` and pass --token keyring:<name>")
+	}
+	for _, h := range sp.Headers {
+		name, value, _ := strings.Cut(h, ":")
+		if sensitiveHeader(name) && !secrets.IsRef(strings.TrimSpace(value)) {
+			return sp, clierr.Newf(clierr.Usage, "refusing to save the %s header in clear, use --token or a keyring:<name> value", strings.TrimSpace(name))
+		}
+	}
+	switch {
+	case sp.Data == "@-":
+		return sp, clierr.Newf(clierr.Usage, "a body read from stdin cannot be saved, save it in a file and pass -d @file")
+	case strings.HasPrefix(sp.Data, "@"):
+		abs, err := filepath.Abs(strings.TrimPrefix(sp.Data, "@"))
+		if err != nil {
+			return sp, err
+		}
+		sp.Data = "@" + abs
+	}
+	return sp, nil
+}
+
+// sensitiveHeader reports headers carrying credentials, which are only saved as keyring references
+func sensitiveHeader(name string) bool {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "authorization", "proxy-authorization", "cookie", "x-api-key":
+		return true
+	}
+	return false
+}
+
+// placeholderPattern matches {{name}} in a saved request
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// expand replaces the {{name}} placeholders of sp with vars and fails listing those without a value
+func expand(sp spec, vars map[string]string) (spec, error) {
+	var missing []string
+	replace := func(s string) string {
+		return placeholderPattern.ReplaceAllStringFunc(s, func(m string) string {
+			name := placeholderPattern.FindStringSubmatch(m)[1]
+			value, ok := vars[name]
+			if !ok {
+				if !slices.Contains(missing, name) {
+					missing = append(missing, name)
+				}
+				return m
+			}
+			return value
+		})
+	}
+
+	sp.URL = replace(sp.URL)
+	sp.Token = replace(sp.Token)
+	sp.Headers = slices.Clone(sp.Headers)
+	for i, h := range sp.Headers {
+		sp.Headers[i] = replace(h)
+	}
+	if !strings.HasPrefix(sp.Data, "@") {
+		sp.Data = replace(sp.Data)
+	}
+	if len(missing) > 0 {
+		return sp, clierr.Newf(clierr.Usage, "no value for %s, pass --var name=value", strings.Join(missing, ", "))
+	}
+	return sp, nil
+}
+
+// parseVars reads the --var name=value flags
+func parseVars(values []string) (map[string]string, error) {
+	vars := make(map[string]string, len(values))
+	for _, v := range values {
+		name, value, ok := strings.Cut(v, "=")
+		if !ok || name == "" {
+			return nil, clierr.Newf(clierr.Usage, "invalid --var '%s', expected name=value", v)
+		}
+		vars[name] = value
+	}
+	return vars, nil
+}
+
+func checkName(name string) error {
+	if !namePattern.MatchString(name) {
+		return clierr.Newf(clierr.Usage, "invalid request name '%s', use letters, digits, '.', '_' and '-'", name)
+	}
+	return nil
+}
+
+func describe(name string, sp spec) string {
+	return fmt.Sprintf("%s: %s %s", name, sp.Method, sp.URL)
+}
@@ -0,0 +1,195 @@
+package request
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/secrets"
+	"gsn-dev-tools/internals/style"
+)
+
+// maxBackoff caps the wait between two attempts, Retry-After included
+const maxBackoff = 30 * time.Second
+
+// prepared is a request with its secrets resolved and its body read, ready to be sent any number of times
+type prepared struct {
+	Method string
+	URL    string
+	Header http.Header
+	Body   []byte
+}
+
+// prepare resolves the keyring references of sp and reads its body
+func prepare(sp spec, stdin io.Reader) (prepared, error) {
+	p := prepared{Method: strings.ToUpper(sp.Method), URL: sp.URL, Header: http.Header{}}
+	for _, h := range sp.Headers {
+		name, value, ok := strings.Cut(h, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return p, clierr.Newf(clierr.Usage, "invalid header '%s', expected 'Name: value'", h)
+		}
+		value, err := secrets.Resolve(strings.TrimSpace(value))
+		if err != nil {
+			return p, err
+		}
+		p.Header.Add(name, value)
+	}
+	if sp.Token != "" {
+		if p.Header.Get("Authorization") != "" {
+			return p, clierr.Newf(clierr.Usage, "--token cannot be combined with an Authorization header")
+		}
+		token, err := secrets.Resolve(sp.Token)
+		if err != nil {
+			return p, err
+		}
+		p.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	switch {
+	case sp.Data == "@-":
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return p, err
+		}
+		p.Body = data
+	case strings.HasPrefix(sp.Data, "@"):
+		data, err := os.ReadFile(strings.TrimPrefix(sp.Data, "@"))
+		if err != nil {
+			return p, fmt.Errorf("cannot read the body: %w", err)
+		}
+		p.Body = data
+	case sp.Data != "":
+		p.Body = []byte(sp.Data)
+	}
+	if len(p.Body) > 0 && p.Header.Get("Content-Type") == "" && json.Valid(p.Body) {
+		p.Header.Set("Content-Type", "application/json")
+	}
+	return p, nil
+}
+
+// sender sends a prepared request, retrying network errors and the statuses of an overloaded server
+type sender struct {
+	Client  *http.Client
+	Retries int
+	// Log receives a line for every retry
+	Log io.Writer
+}
+
+// send returns the response of the last attempt, its body still to be read by the caller
+func (s sender) send(ctx context.Context, p prepared) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, p.Method, p.URL, bytes.NewReader(p.Body))
+		if err != nil {
+			return nil, clierr.New(clierr.Usage, err)
+		}
+		req.Header = p.Header.Clone()
+		if req.Header.Get("User-Agent") == "" {
+			req.Header.Set("User-Agent", "gsn")
+		}
+
+		resp, err := s.Client.Do(req)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		// The method and URL that url.Error repeats are in the messages of the caller already
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		if attempt == s.Retries || (err == nil && !retryableStatus(resp.StatusCode)) {
+			return resp, err
+		}
+
+		wait := backoff(attempt)
+		var reason string
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = resp.Status
+			if after, ok := retryAfter(resp.Header); ok {
+				wait = min(after, maxBackoff)
+			}
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+			resp.Body.Close()
+		}
+		fmt.Fprintf(s.Log, style.Warning()+"%s, retrying in %s (%d/%d)\n", reason, wait, attempt+1, s.Retries)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// retryableStatus reports the statuses of a server that may answer a moment later
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff doubles from half a second for every attempt
+func backoff(attempt int) time.Duration {
+	return min(500*time.Millisecond<<min(attempt, 8), maxBackoff)
+}
+
+// retryAfter reads the Retry-After header, in seconds or as a date
+func retryAfter(header http.Header) (time.Duration, bool) {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0), true
+	}
+	return 0, false
+}
+
+// idempotent reports methods that may be sent twice without changing the outcome, the only ones retried unless
+// --retries is given
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// statusCode maps a response status to the exit code of gsn like the GitHub commands do
+func statusCode(status int) clierr.Code {
+	switch {
+	case status >= 200 && status < 300:
+		return clierr.Success
+	case status == http.StatusNotFound || status == http.StatusGone:
+		return clierr.NotFound
+	case status == http.StatusMethodNotAllowed, status == http.StatusConflict, status == http.StatusPreconditionFailed, status == http.StatusUnprocessableEntity:
+		return clierr.Conflict
+	}
+	return clierr.Failure
+}
+
+// isJSON reports whether a response body is JSON by its content type
+func isJSON(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
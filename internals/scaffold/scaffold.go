@@ -0,0 +1,486 @@
+package scaffold
+
+import (
+	"bufio"
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/paths"
+	"gsn-dev-tools/internals/style"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed all:templates
+var builtinTemplates embed.FS
+
+const (
+	// manifestName declares the variables of a template, it is not rendered itself
+	manifestName = "template.yaml"
+
+	// templateSuffix is dropped from the names of rendered files, so built-in templates can hold files like
+	// go.mod and main.go without the Go tooling picking them up
+	templateSuffix = ".tmpl"
+
+	builtinSource = "built-in"
+)
+
+// variableName restricts variable names to those templates can use as {{ .name }}
+var variableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// variable is a value a template asks for, declared in its template.yaml
+type variable struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	// Default is itself a template, rendered with the variables declared before it
+	Default  string `yaml:"default"`
+	Required bool   `yaml:"required"`
+	Pattern  string `yaml:"pattern"`
+
+	pattern *regexp.Regexp
+}
+
+type manifest struct {
+	Description string     `yaml:"description"`
+	Variables   []variable `yaml:"variables"`
+}
+
+// scaffoldTemplate is a built-in or user template, its files are rooted at FS
+type scaffoldTemplate struct {
+	Name     string
+	Source   string
+	FS       fs.FS
+	Manifest manifest
+}
+
+// renderedFile is a file of a template after rendering, Path is relative to the destination
+type renderedFile struct {
+	Path    string
+	Content []byte
+	Mode    os.FileMode
+}
+
+// funcs are the functions templates can call besides the text/template builtins
+var funcs = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"base":  path.Base,
+}
+
+func ScaffoldCmd() *cobra.Command {
+	newCmd := &cobra.Command{
+		Use:   "new <template> [dest]",
+		Short: "Scaffolds files for a new project from a template",
+		Long: `Renders every file of a template into dest, the current directory by default, with Go text/template. The
+values of the variables a template declares come from --var key=value, are asked for on a terminal, or fall
+back to their defaults; a required variable without a value, or a value not matching the pattern of its
+variable, stops the command before anything is written. Existing files are never overwritten without --force.
+
+Built-in templates: go-cli, docker-compose-postgres and github-actions-go. User templates are directories
+below ~/.config/gsn/templates/<name>/ and take precedence over built-in ones of the same name. Every file in
+them is rendered, also its path, and a trailing .tmpl is dropped from file names. An optional template.yaml
+declares the variables:
+
+  description: Small web service
+  variables:
+    - name: service
+      description: Name of the service
+      required: true
+      pattern: '^[a-z][a-z0-9-]*$'
+    - name: image
+      default: 'ghcr.io/me/{{ .service }}'
+
+Defaults are templates too, rendered with the variables declared before them. Besides the text/template
+builtins, templates can call lower, upper and base. A literal {{ is written as {{ "{{" }}, as GitHub Actions
+expressions need.`,
+		Example: `  gsn new --list
+  gsn new go-cli ./mytool --var module=github.com/me/mytool
+  gsn new docker-compose-postgres --var project=shop --var port=5433
+  gsn new github-actions-go . --dry-run`,
+		Args: cobra.RangeArgs(0, 2),
+		Run:  Scaffold,
+	}
+
+	newCmd.Flags().StringArray("var", nil, "Value of a template variable as key=value (repeatable)")
+	newCmd.Flags().Bool("force", false, "Overwrite files that already exist in the destination")
+	newCmd.Flags().Bool("list", false, "List the available templates and their variables")
+	newCmd.Flags().Bool("no-input", false, "Never prompt, use the defaults for the variables not given with --var")
+	dryrun.Adopt(newCmd)
+	return newCmd
+}
+
+func Scaffold(cmd *cobra.Command, args []string) {
+	list, _ := cmd.Flags().GetBool("list")
+	rawVars, _ := cmd.Flags().GetStringArray("var")
+	force, _ := cmd.Flags().GetBool("force")
+	noInput, _ := cmd.Flags().GetBool("no-input")
+
+	if list {
+		if err := printTemplates(); err != nil {
+			clierr.Fatalf("%v", err)
+		}
+		return
+	}
+	if len(args) == 0 {
+		clierr.Exitf(clierr.Usage, "requires a template name, see gsn new --list")
+	}
+	dest := "."
+	if len(args) == 2 {
+		dest = args[1]
+	}
+
+	given, err := parseVars(rawVars)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	t, err := findTemplate(args[0])
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	var ask func(v variable, def string) (string, error)
+	if !noInput && term.IsTerminal(int(os.Stdin.Fd())) {
+		ask = promptVariable(bufio.NewReader(os.Stdin))
+	}
+	values, err := resolveVariables(t, given, ask)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	files, err := renderTemplate(t, values)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+
+	if err := writeFiles(files, dest, force); err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	if dryrun.Enabled() {
+		return
+	}
+	for _, f := range files {
+		fmt.Printf("  %s\n", filepath.Join(dest, f.Path))
+	}
+	fmt.Printf(style.Success()+"Scaffolded %d file(s) from %s into %s\n", len(files), t.Name, dest)
+}
+
+// userTemplateDir is where user templates live, one directory each
+func userTemplateDir() (string, error) {
+	dir, err := paths.ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "templates"), nil
+}
+
+// listTemplates returns the user and built-in templates by name, a user template hides a built-in one of the
+// same name
+func listTemplates() ([]scaffoldTemplate, error) {
+	byName := make(map[string]scaffoldTemplate)
+
+	entries, err := fs.ReadDir(builtinTemplates, "templates")
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		sub, err := fs.Sub(builtinTemplates, "templates/"+e.Name())
+		if err != nil {
+			return nil, err
+		}
+		byName[e.Name()] = scaffoldTemplate{Name: e.Name(), Source: builtinSource, FS: sub}
+	}
+
+	dir, err := userTemplateDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err = os.ReadDir(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			root := filepath.Join(dir, e.Name())
+			byName[e.Name()] = scaffoldTemplate{Name: e.Name(), Source: root, FS: os.DirFS(root)}
+		}
+	}
+
+	templates := make([]scaffoldTemplate, 0, len(byName))
+	for _, t := range byName {
+		templates = append(templates, t)
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates, nil
+}
+
+// findTemplate returns a template with its manifest loaded
+func findTemplate(name string) (scaffoldTemplate, error) {
+	templates, err := listTemplates()
+	if err != nil {
+		return scaffoldTemplate{}, err
+	}
+	var names []string
+	for _, t := range templates {
+		if t.Name == name {
+			t.Manifest, err = loadManifest(t.FS)
+			if err != nil {
+				return t, fmt.Errorf("invalid template '%s' (%s): %w", t.Name, t.Source, err)
+			}
+			return t, nil
+		}
+		names = append(names, t.Name)
+	}
+	return scaffoldTemplate{}, clierr.Newf(clierr.NotFound, "no template '%s' (available: %s)", name, strings.Join(names, ", "))
+}
+
+// loadManifest reads and validates the template.yaml of a template, a template without one has no variables
+func loadManifest(fsys fs.FS) (manifest, error) {
+	var m manifest
+	data, err := fs.ReadFile(fsys, manifestName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return m, err
+	}
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("%s: %w", manifestName, err)
+	}
+
+	seen := make(map[string]bool)
+	for i := range m.Variables {
+		v := &m.Variables[i]
+		if !variableName.MatchString(v.Name) {
+			return m, fmt.Errorf("%s: invalid variable name '%s', use letters, digits and underscores", manifestName, v.Name)
+		}
+		if seen[v.Name] {
+			return m, fmt.Errorf("%s: variable '%s' is declared twice", manifestName, v.Name)
+		}
+		seen[v.Name] = true
+		if v.Pattern != "" {
+			if v.pattern, err = regexp.Compile(v.Pattern); err != nil {
+				return m, fmt.Errorf("%s: invalid pattern of variable '%s': %w", manifestName, v.Name, err)
+			}
+		}
+	}
+	return m, nil
+}
+
+// printTemplates lists the templates with their description and variables
+func printTemplates() error {
+	templates, err := listTemplates()
+	if err != nil {
+		return err
+	}
+	for _, t := range templates {
+		m, err := loadManifest(t.FS)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, style.Warning()+"Invalid template '%s' (%s): %v\n", t.Name, t.Source, err)
+			continue
+		}
+		fmt.Printf("%s (%s)\n", t.Name, t.Source)
+		if m.Description != "" {
+			fmt.Printf("  %s\n", m.Description)
+		}
+		for _, v := range m.Variables {
+			detail := v.Description
+			switch {
+			case v.Required:
+				detail += " (required)"
+			case v.Default != "":
+				detail += fmt.Sprintf(" (default %s)", v.Default)
+			}
+			fmt.Printf("    %-18s %s\n", v.Name, strings.TrimSpace(detail))
+		}
+	}
+	return nil
+}
+
+// parseVars reads the key=value pairs of --var
+func parseVars(raw []string) (map[string]string, error) {
+	values := make(map[string]string, len(raw))
+	for _, pair := range raw {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return nil, clierr.Newf(clierr.Usage, "invalid --var '%s', use key=value", pair)
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+// promptVariable asks for a variable on the terminal, an empty answer takes the default
+func promptVariable(in *bufio.Reader) func(v variable, def string) (string, error) {
+	return func(v variable, def string) (string, error) {
+		label := v.Name
+		if v.Description != "" {
+			label = fmt.Sprintf("%s (%s)", v.Description, v.Name)
+		}
+		if def != "" {
+			label += fmt.Sprintf(" [%s]", def)
+		}
+		fmt.Fprintf(os.Stderr, "%s: ", label)
+		line, err := in.ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			return line, nil
+		}
+		return def, nil
+	}
+}
+
+// resolveVariables settles the value of every variable of t: the given one, the answer of ask when it is not
+// nil, or the rendered default. Values are then checked against the required flag and the pattern. Given names
+// the template does not declare are refused, so a typo does not go unnoticed.
+func resolveVariables(t scaffoldTemplate, given map[string]string, ask func(v variable, def string) (string, error)) (map[string]string, error) {
+	declared := make([]string, 0, len(t.Manifest.Variables))
+	for _, v := range t.Manifest.Variables {
+		declared = append(declared, v.Name)
+	}
+	for key := range given {
+		if !slices.Contains(declared, key) {
+			if len(declared) == 0 {
+				return nil, clierr.Newf(clierr.Usage, "template '%s' has no variables, --var %s is not used", t.Name, key)
+			}
+			return nil, clierr.Newf(clierr.Usage, "template '%s' has no variable '%s' (variables: %s)", t.Name, key, strings.Join(declared, ", "))
+		}
+	}
+
+	values := make(map[string]string, len(declared))
+	var missing []string
+	for _, v := range t.Manifest.Variables {
+		value, ok := given[v.Name]
+		if !ok {
+			def, err := renderString(v.Name+" default", v.Default, values)
+			if err != nil && len(missing) > 0 {
+				// The default refers to a missing value, which is reported below
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid default of variable '%s': %w", v.Name, err)
+			}
+			value = def
+			if ask != nil {
+				if value, err = ask(v, def); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if value == "" && v.Required {
+			missing = append(missing, v.Name)
+			continue
+		}
+		if value != "" && v.pattern != nil && !v.pattern.MatchString(value) {
+			return nil, clierr.Newf(clierr.Usage, "invalid value '%s' for variable '%s', it must match %s", value, v.Name, v.Pattern)
+		}
+		values[v.Name] = value
+	}
+	if len(missing) > 0 {
+		return nil, clierr.Newf(clierr.Usage, "template '%s' needs a value for %s, set it with --var %s=...", t.Name, strings.Join(missing, ", "), missing[0])
+	}
+	return values, nil
+}
+
+// renderString renders text as a template with values, referring to an unset value is an error
+func renderString(name string, text string, values map[string]string) (string, error) {
+	tmpl, err := template.New(name).Funcs(funcs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, values); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// renderTemplate renders the paths and contents of every file of t, the manifest aside
+func renderTemplate(t scaffoldTemplate, values map[string]string) ([]renderedFile, error) {
+	var files []renderedFile
+	err := fs.WalkDir(t.FS, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || name == manifestName {
+			return nil
+		}
+
+		target, err := renderString(name, name, values)
+		if err != nil {
+			return fmt.Errorf("file name '%s': %w", name, err)
+		}
+		target = strings.TrimSuffix(path.Clean(target), templateSuffix)
+		if target == "." || target == ".." || strings.HasPrefix(target, "../") || path.IsAbs(target) {
+			return fmt.Errorf("file name '%s' renders to '%s', outside the destination", name, target)
+		}
+
+		data, err := fs.ReadFile(t.FS, name)
+		if err != nil {
+			return err
+		}
+		content, err := renderString(name, string(data), values)
+		if err != nil {
+			return fmt.Errorf("file '%s': %w", name, err)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		mode := os.FileMode(0o644)
+		if info.Mode()&0o111 != 0 {
+			mode = 0o755
+		}
+		files = append(files, renderedFile{Path: filepath.FromSlash(target), Content: []byte(content), Mode: mode})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot render template '%s': %w", t.Name, err)
+	}
+	return files, nil
+}
+
+// writeFiles writes the rendered files below dest. Without force nothing is written when any of them exists.
+func writeFiles(files []renderedFile, dest string, force bool) error {
+	if !force {
+		var existing []string
+		for _, f := range files {
+			target := filepath.Join(dest, f.Path)
+			if _, err := os.Lstat(target); err == nil {
+				existing = append(existing, target)
+			}
+		}
+		if len(existing) > 0 {
+			return clierr.Newf(clierr.Conflict, "refusing to overwrite %s, nothing was written (use --force)", strings.Join(existing, ", "))
+		}
+	}
+
+	for _, f := range files {
+		target := filepath.Join(dest, f.Path)
+		err := dryrun.Do("write "+target, func() error {
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			return os.WriteFile(target, f.Content, f.Mode)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
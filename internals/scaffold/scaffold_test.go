@@ -0,0 +1,414 @@
+package scaffold
+
+import (
+	"go/format"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/paths"
+
+	"gopkg.in/yaml.v3"
+)
+
+// mapTemplate returns a template whose files are given in memory, its manifest loaded
+func mapTemplate(t *testing.T, files map[string]string) scaffoldTemplate {
+	t.Helper()
+	fsys := fstest.MapFS{}
+	for name, content := range files {
+		mode := os.FileMode(0o644)
+		if strings.HasSuffix(name, ".sh") {
+			mode = 0o755
+		}
+		fsys[name] = &fstest.MapFile{Data: []byte(content), Mode: mode}
+	}
+	m, err := loadManifest(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return scaffoldTemplate{Name: "test", Source: "memory", FS: fsys, Manifest: m}
+}
+
+func TestRenderString(t *testing.T) {
+	values := map[string]string{"name": "My-Tool", "module": "github.com/me/tool"}
+	tests := []struct {
+		text, want string
+	}{
+		{"{{ .name }}", "My-Tool"},
+		{"{{ lower .name }} {{ upper .name }}", "my-tool MY-TOOL"},
+		{"{{ base .module }}", "tool"},
+		// GitHub Actions expressions are written with a literal {{
+		{`${{ "{{" }} matrix.go }}`, "${{ matrix.go }}"},
+		{"{{ if .name }}yes{{ end }}", "yes"},
+	}
+	for _, test := range tests {
+		if got, err := renderString("test", test.text, values); err != nil || got != test.want {
+			t.Errorf("renderString(%q) = %q, %v, want %q", test.text, got, err, test.want)
+		}
+	}
+	for _, text := range []string{"{{ .missing }}", "{{ .name ", "{{ nosuchfunc .name }}"} {
+		if _, err := renderString("test", text, values); err == nil {
+			t.Errorf("renderString(%q) succeeded", text)
+		}
+	}
+}
+
+func TestLoadManifest(t *testing.T) {
+	// No manifest, no variables
+	if m, err := loadManifest(fstest.MapFS{"README.md": {}}); err != nil || len(m.Variables) != 0 {
+		t.Errorf("manifest of a template without one = %+v, %v", m, err)
+	}
+	m, err := loadManifest(fstest.MapFS{manifestName: {Data: []byte("description: d\nvariables:\n  - name: a_1\n    pattern: '^x+$'\n")}})
+	if err != nil || m.Description != "d" || len(m.Variables) != 1 || !m.Variables[0].pattern.MatchString("xx") {
+		t.Errorf("loadManifest = %+v, %v", m, err)
+	}
+
+	tests := []struct {
+		manifest, want string
+	}{
+		{"variables:\n  - name: my-var\n", "invalid variable name 'my-var'"},
+		{"variables:\n  - name: 1st\n", "invalid variable name '1st'"},
+		{"variables:\n  - name: a\n  - name: a\n", "variable 'a' is declared twice"},
+		{"variables:\n  - name: a\n    pattern: '('\n", "invalid pattern of variable 'a'"},
+		{"variables: [", "template.yaml: yaml:"},
+	}
+	for _, test := range tests {
+		if _, err := loadManifest(fstest.MapFS{manifestName: {Data: []byte(test.manifest)}}); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("loadManifest(%q) = %v, want %q", test.manifest, err, test.want)
+		}
+	}
+}
+
+func TestResolveVariables(t *testing.T) {
+	tmpl := mapTemplate(t, map[string]string{manifestName: `variables:
+  - name: service
+    required: true
+    pattern: '^[a-z][a-z0-9-]*$'
+  - name: image
+    default: 'ghcr.io/me/{{ .service }}'
+  - name: port
+    default: '8080'
+    pattern: '^[0-9]+$'
+  - name: note
+`})
+
+	values, err := resolveVariables(tmpl, map[string]string{"service": "shop"}, nil)
+	want := map[string]string{"service": "shop", "image": "ghcr.io/me/shop", "port": "8080", "note": ""}
+	if err != nil || !mapsEqual(values, want) {
+		t.Errorf("defaults = %v, %v, want %v", values, err, want)
+	}
+
+	// The prompt is offered the rendered default and asked only for what --var leaves out
+	var asked []string
+	ask := func(v variable, def string) (string, error) {
+		asked = append(asked, v.Name+"="+def)
+		if v.Name == "port" {
+			return "9000", nil
+		}
+		return def, nil
+	}
+	values, err = resolveVariables(tmpl, map[string]string{"service": "cart", "note": "hi"}, ask)
+	if err != nil || values["port"] != "9000" || values["image"] != "ghcr.io/me/cart" || values["note"] != "hi" {
+		t.Errorf("with prompts = %v, %v", values, err)
+	}
+	if !slices.Equal(asked, []string{"image=ghcr.io/me/cart", "port=8080"}) {
+		t.Errorf("asked for %q", asked)
+	}
+
+	tests := []struct {
+		given map[string]string
+		want  string
+	}{
+		// The default of image needs service, only the missing service is reported
+		{nil, "template 'test' needs a value for service, set it with --var service=..."},
+		{map[string]string{"service": "Shop"}, "invalid value 'Shop' for variable 'service', it must match ^[a-z][a-z0-9-]*$"},
+		{map[string]string{"service": "shop", "port": "http"}, "invalid value 'http' for variable 'port'"},
+		{map[string]string{"service": "shop", "prot": "1"}, "template 'test' has no variable 'prot' (variables: service, image, port, note)"},
+	}
+	for _, test := range tests {
+		_, err := resolveVariables(tmpl, test.given, nil)
+		if clierr.CodeOf(err) != clierr.Usage || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("resolveVariables(%v) = %v, want a usage error %q", test.given, err, test.want)
+		}
+	}
+
+	bare := mapTemplate(t, map[string]string{"README.md": "hi"})
+	if _, err := resolveVariables(bare, map[string]string{"x": "1"}, nil); err == nil || !strings.Contains(err.Error(), "has no variables, --var x is not used") {
+		t.Errorf("--var for a template without variables = %v", err)
+	}
+	broken := mapTemplate(t, map[string]string{manifestName: "variables:\n  - name: a\n    default: '{{ .nope }}'\n"})
+	if _, err := resolveVariables(broken, nil, nil); err == nil || !strings.Contains(err.Error(), "invalid default of variable 'a'") {
+		t.Errorf("default referring to an unknown variable = %v", err)
+	}
+}
+
+func mapsEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRenderTemplate(t *testing.T) {
+	tmpl := mapTemplate(t, map[string]string{
+		manifestName:                "variables:\n  - name: name\n",
+		"{{ .name }}/main.go.tmpl":  "package main // {{ .name }}\n",
+		"scripts/run.sh":            "#!/bin/sh\necho {{ upper .name }}\n",
+		".gitignore.tmpl":           "/{{ .name }}\n",
+		"docs/{{ lower .name }}.md": "# {{ .name }}\n",
+		"keep.tmpl.txt":             "plain",
+		"workflow.yml":              `run: ${{ "{{" }} github.sha }}`,
+	})
+	files, err := renderTemplate(tmpl, map[string]string{"name": "Tool"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]renderedFile)
+	for _, f := range files {
+		got[filepath.ToSlash(f.Path)] = f
+	}
+	want := map[string]string{
+		"Tool/main.go":   "package main // Tool\n",
+		"scripts/run.sh": "#!/bin/sh\necho TOOL\n",
+		".gitignore":     "/Tool\n",
+		"docs/tool.md":   "# Tool\n",
+		"keep.tmpl.txt":  "plain",
+		"workflow.yml":   "run: ${{ github.sha }}",
+	}
+	if len(got) != len(want) {
+		t.Errorf("rendered %d files, want %d: %v", len(got), len(want), files)
+	}
+	for name, content := range want {
+		if string(got[name].Content) != content {
+			t.Errorf("%s = %q, want %q", name, got[name].Content, content)
+		}
+	}
+	if got["scripts/run.sh"].Mode != 0o755 || got[".gitignore"].Mode != 0o644 {
+		t.Errorf("modes = %v and %v, want executables to stay executable", got["scripts/run.sh"].Mode, got[".gitignore"].Mode)
+	}
+
+	// Paths rendering outside the destination and broken contents are refused
+	tests := []struct {
+		file, content, name, want string
+	}{
+		{"{{ .name }}/x", "", "..", "file name '{{ .name }}/x' renders to '../x', outside the destination"},
+		{"{{ .name }}", "", "a/../..", "file name '{{ .name }}' renders to '..', outside the destination"},
+		{"{{ .name }}", "", "/etc/passwd", "renders to '/etc/passwd', outside the destination"},
+		{"{{ .name }}.txt", "", "", "file name '{{ .name }}.txt': "},
+		{"x.txt", "{{ .missing }}", "Tool", "file 'x.txt': "},
+	}
+	for _, test := range tests {
+		bad := mapTemplate(t, map[string]string{test.file: test.content})
+		values := map[string]string{"name": test.name}
+		if test.name == "" {
+			values = nil
+		}
+		if _, err := renderTemplate(bad, values); err == nil || !strings.HasPrefix(err.Error(), "cannot render template 'test': ") ||
+			!strings.Contains(err.Error(), test.want) {
+			t.Errorf("rendering %s with name %q = %v, want %q", test.file, test.name, err, test.want)
+		}
+	}
+}
+
+func TestWriteFilesRefusesToOverwrite(t *testing.T) {
+	dest := t.TempDir()
+	files := []renderedFile{
+		{Path: "a.txt", Content: []byte("new a"), Mode: 0o644},
+		{Path: filepath.Join("sub", "b.sh"), Content: []byte("new b"), Mode: 0o755},
+	}
+	if err := os.WriteFile(filepath.Join(dest, "a.txt"), []byte("old a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is written, not even the file that does not exist yet
+	err := writeFiles(files, dest, false)
+	if clierr.CodeOf(err) != clierr.Conflict || !strings.Contains(err.Error(), "refusing to overwrite "+filepath.Join(dest, "a.txt")) {
+		t.Errorf("writeFiles = %v, want a conflict", err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "sub")); !os.IsNotExist(err) {
+		t.Errorf("sub was created: %v", err)
+	}
+
+	if err := writeFiles(files, dest, true); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dest, "a.txt")); string(data) != "new a" {
+		t.Errorf("a.txt = %q after --force", data)
+	}
+	if info, err := os.Stat(filepath.Join(dest, "sub", "b.sh")); err != nil || info.Mode().Perm() != 0o755 {
+		t.Errorf("sub/b.sh = %v, %v", info, err)
+	}
+}
+
+func TestScaffoldBuiltinTemplates(t *testing.T) {
+	t.Setenv(paths.HomeEnv, t.TempDir())
+
+	tests := []struct {
+		name  string
+		given map[string]string
+		check func(t *testing.T, dest string)
+	}{
+		{"go-cli", map[string]string{"module": "github.com/me/mytool"}, func(t *testing.T, dest string) {
+			if data, _ := os.ReadFile(filepath.Join(dest, "go.mod")); string(data) != "module github.com/me/mytool\n\ngo 1.25\n" {
+				t.Errorf("go.mod = %q", data)
+			}
+			main, err := os.ReadFile(filepath.Join(dest, "main.go"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Valid and gofmt formatted Go, with the name derived from the module
+			if formatted, err := format.Source(main); err != nil || string(formatted) != string(main) {
+				t.Errorf("main.go is not gofmt formatted Go: %v\n%s", err, main)
+			}
+			if !strings.Contains(string(main), `fmt.Println("mytool", version)`) {
+				t.Errorf("main.go =\n%s", main)
+			}
+			for _, name := range []string{"Makefile", ".gitignore"} {
+				if _, err := os.Stat(filepath.Join(dest, name)); err != nil {
+					t.Error(err)
+				}
+			}
+		}},
+		{"docker-compose-postgres", map[string]string{"project": "shop", "port": "5433"}, func(t *testing.T, dest string) {
+			var compose struct {
+				Services map[string]struct {
+					Image string   `yaml:"image"`
+					Ports []string `yaml:"ports"`
+				} `yaml:"services"`
+			}
+			data, err := os.ReadFile(filepath.Join(dest, "docker-compose.yml"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := yaml.Unmarshal(data, &compose); err != nil || len(compose.Services) != 1 {
+				t.Fatalf("docker-compose.yml = %v\n%s", err, data)
+			}
+			for _, service := range compose.Services {
+				if service.Image != "postgres:17" || !slices.ContainsFunc(service.Ports, func(p string) bool { return strings.HasPrefix(p, "5433:") }) {
+					t.Errorf("service = %+v", service)
+				}
+			}
+			if data, _ := os.ReadFile(filepath.Join(dest, ".env.example")); !strings.Contains(string(data), "shop") {
+				t.Errorf(".env.example = %q", data)
+			}
+		}},
+		{"github-actions-go", map[string]string{"branch": "trunk"}, func(t *testing.T, dest string) {
+			var workflow struct {
+				On struct {
+					Push struct {
+						Branches []string `yaml:"branches"`
+					} `yaml:"push"`
+				} `yaml:"on"`
+			}
+			data, err := os.ReadFile(filepath.Join(dest, ".github", "workflows", "go.yml"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := yaml.Unmarshal(data, &workflow); err != nil || !slices.Equal(workflow.On.Push.Branches, []string{"trunk"}) {
+				t.Errorf("go.yml = %+v, %v", workflow, err)
+			}
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tmpl, err := findTemplate(test.name)
+			if err != nil || tmpl.Source != builtinSource {
+				t.Fatalf("findTemplate = %+v, %v", tmpl, err)
+			}
+			values, err := resolveVariables(tmpl, test.given, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files, err := renderTemplate(tmpl, values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dest := filepath.Join(t.TempDir(), "project")
+			if err := writeFiles(files, dest, false); err != nil {
+				t.Fatal(err)
+			}
+			test.check(t, dest)
+			for _, f := range files {
+				if strings.HasSuffix(f.Path, templateSuffix) || filepath.Base(f.Path) == manifestName {
+					t.Errorf("wrote %s", f.Path)
+				}
+			}
+		})
+	}
+}
+
+func TestUserTemplatesHideBuiltinOnes(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv(paths.HomeEnv, home)
+	dir, err := userTemplateDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(dir, home) {
+		t.Fatalf("user templates in %s, outside GSN_HOME", dir)
+	}
+	for name, content := range map[string]string{
+		"go-cli/template.yaml": "description: mine\nvariables:\n  - name: who\n    default: me\n",
+		"go-cli/hello.txt":     "hello {{ .who }}\n",
+		"extra/README.md":      "extra\n",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	templates, err := listTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, tmpl := range templates {
+		names = append(names, tmpl.Name)
+	}
+	if !slices.Equal(names, []string{"docker-compose-postgres", "extra", "github-actions-go", "go-cli"}) {
+		t.Errorf("templates = %q", names)
+	}
+
+	tmpl, err := findTemplate("go-cli")
+	if err != nil || tmpl.Source != filepath.Join(dir, "go-cli") || tmpl.Manifest.Description != "mine" {
+		t.Fatalf("go-cli = %+v, %v", tmpl, err)
+	}
+	values, err := resolveVariables(tmpl, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := renderTemplate(tmpl, values)
+	if err != nil || len(files) != 1 || string(files[0].Content) != "hello me\n" {
+		t.Errorf("rendered %+v, %v", files, err)
+	}
+
+	if _, err := findTemplate("nope"); clierr.CodeOf(err) != clierr.NotFound ||
+		!strings.Contains(err.Error(), "no template 'nope' (available: docker-compose-postgres, extra, github-actions-go, go-cli)") {
+		t.Errorf("findTemplate(nope) = %v", err)
+	}
+}
+
+func TestParseVars(t *testing.T) {
+	values, err := parseVars([]string{"a=1", "b=x=y", "c="})
+	if err != nil || !mapsEqual(values, map[string]string{"a": "1", "b": "x=y", "c": ""}) {
+		t.Errorf("parseVars = %v, %v", values, err)
+	}
+	for _, raw := range []string{"a", "=1"} {
+		if _, err := parseVars([]string{raw}); clierr.CodeOf(err) != clierr.Usage {
+			t.Errorf("parseVars(%s) = %v, want a usage error", raw, err)
+		}
+	}
+}
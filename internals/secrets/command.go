@@ -0,0 +1,93 @@
+package secrets
+
+import (
+	"errors"
+	"fmt"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/style"
+
+	"github.com/spf13/cobra"
+)
+
+func SecretCmd() *cobra.Command {
+	secretCmd := &cobra.Command{
+		Use:   "secret",
+		Short: "Manage secrets in the OS keychain or the encrypted secret store",
+		Long: `Stores tokens and passphrases outside the config file. Values are referenced from config and flags as
+keyring:<name>. The OS keychain is used when available (macOS Keychain, Linux Secret Service), otherwise an
+scrypt + AES-GCM encrypted file in the gsn config dir unlocked with $GSN_SECRETS_PASSWORD or a prompt.`,
+		Example: `  gsn secret set github-token
+  gsn secret get github-token
+  gsn secret rm github-token --backend file`,
+	}
+	secretCmd.PersistentFlags().String("backend", "", "Secret backend: keychain or file (default: automatic)")
+
+	setCmd := &cobra.Command{
+		Use:   "set <name>",
+		Short: "Stores a secret read from a hidden prompt or stdin",
+		Long:  "Reads the value from a hidden prompt, or from stdin when it is not a terminal, and stores it under name.",
+		Example: `  gsn secret set github-token
+  printf %s "$TOKEN" | gsn secret set github-token --backend file`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			backend := openFromFlags(cmd)
+			value, err := ReadSecret(fmt.Sprintf("Value for %s: ", args[0]))
+			if err != nil {
+				clierr.Fatalf("Failed to read value: %v", err)
+			}
+			if value == "" {
+				clierr.Exitf(clierr.Usage, "Empty value, nothing stored")
+			}
+			if err := backend.Set(args[0], value); err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			fmt.Printf(style.Success()+"Stored %s in %s (use it as %s%s)\n", args[0], backend.Name(), RefPrefix, args[0])
+		},
+	}
+
+	getCmd := &cobra.Command{
+		Use:     "get <name>",
+		Short:   "Prints a secret to stdout",
+		Long:    "Prints the stored value followed by a newline, e.g. to pass it to another tool.",
+		Example: "  gsn secret get github-token",
+		Args:    cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			value, err := openFromFlags(cmd).Get(args[0])
+			if err != nil {
+				clierr.Fatalf("%s: %v", args[0], err)
+			}
+			fmt.Println(value)
+		},
+	}
+
+	rmCmd := &cobra.Command{
+		Use:     "rm <name>",
+		Short:   "Deletes a secret",
+		Long:    "Deletes the secret from the selected backend and fails when it does not exist.",
+		Example: "  gsn secret rm github-token",
+		Args:    cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			backend := openFromFlags(cmd)
+			if err := backend.Delete(args[0]); errors.Is(err, ErrNotFound) {
+				clierr.Fatalf("%s: %v", args[0], err)
+			} else if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			fmt.Printf(style.Trash()+"Removed %s from %s\n", args[0], backend.Name())
+		},
+	}
+
+	secretCmd.AddCommand(setCmd, getCmd, rmCmd)
+	return secretCmd
+}
+
+// openFromFlags opens the backend selected with --backend
+func openFromFlags(cmd *cobra.Command) Backend {
+	name, _ := cmd.Flags().GetString("backend")
+	backend, err := Open(name)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	return backend
+}
@@ -0,0 +1,219 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gsn-dev-tools/internals/output"
+	"gsn-dev-tools/internals/paths"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// fileName is the encrypted store inside the gsn config dir
+	fileName = "secrets.enc"
+
+	fileVersion = 1
+
+	// scrypt parameters recommended for interactive logins
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// errWrongPassword is returned when the store cannot be decrypted with the given password
+var errWrongPassword = errors.New("wrong password or corrupted secret store")
+
+// envelope is the on-disk format of the encrypted store
+type envelope struct {
+	Version int    `json:"version"`
+	KDF     string `json:"kdf"`
+	N       int    `json:"n"`
+	R       int    `json:"r"`
+	P       int    `json:"p"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+// fileBackend keeps every secret in one file encrypted with AES-256-GCM under an scrypt derived key
+type fileBackend struct {
+	path     string
+	password []byte
+}
+
+func newFileBackend() (Backend, error) {
+	dir, err := paths.ConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	return &fileBackend{path: filepath.Join(dir, fileName)}, nil
+}
+
+func (f *fileBackend) Name() string { return "file" }
+
+func (f *fileBackend) Get(name string) (string, error) {
+	values, err := f.load()
+	if err != nil {
+		return "", err
+	}
+	value, ok := values[name]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (f *fileBackend) Set(name string, value string) error {
+	values, err := f.load()
+	if err != nil {
+		return err
+	}
+	values[name] = value
+	return f.save(values)
+}
+
+func (f *fileBackend) Delete(name string) error {
+	values, err := f.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := values[name]; !ok {
+		return ErrNotFound
+	}
+	delete(values, name)
+	return f.save(values)
+}
+
+// unlockPassword returns the store password from $GSN_SECRETS_PASSWORD or a prompt, asked once per process
+func (f *fileBackend) unlockPassword() ([]byte, error) {
+	if f.password != nil {
+		return f.password, nil
+	}
+
+	password := os.Getenv("GSN_SECRETS_PASSWORD")
+	if password == "" {
+		var err error
+		if password, err = ReadSecret("Secret store password: "); err != nil {
+			return nil, err
+		}
+	}
+	if password == "" {
+		return nil, fmt.Errorf("a password is required for the encrypted secret store")
+	}
+
+	f.password = []byte(password)
+	return f.password, nil
+}
+
+// load decrypts the store, a missing file is an empty store
+func (f *fileBackend) load() (map[string]string, error) {
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	password, err := f.unlockPassword()
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := open(password, data)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+	if err := json.Unmarshal(plaintext, &values); err != nil {
+		return nil, errWrongPassword
+	}
+	return values, nil
+}
+
+// save encrypts the store with a fresh salt and nonce and writes it readable only by the current user
+func (f *fileBackend) save(values map[string]string) error {
+	password, err := f.unlockPassword()
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	data, err := seal(password, plaintext)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o700); err != nil {
+		return err
+	}
+	return output.WriteFileAtomic(f.path, data, 0o600)
+}
+
+// seal encrypts plaintext into a JSON envelope
+func seal(password []byte, plaintext []byte) ([]byte, error) {
+	env := envelope{Version: fileVersion, KDF: "scrypt", N: scryptN, R: scryptR, P: scryptP, Salt: make([]byte, 16)}
+	if _, err := rand.Read(env.Salt); err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(password, env)
+	if err != nil {
+		return nil, err
+	}
+	env.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(env.Nonce); err != nil {
+		return nil, err
+	}
+	env.Data = gcm.Seal(nil, env.Nonce, plaintext, nil)
+
+	return json.MarshalIndent(env, "", "  ")
+}
+
+// open decrypts a JSON envelope produced by seal
+func open(password []byte, data []byte) ([]byte, error) {
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("invalid secret store: %w", err)
+	}
+	if env.Version != fileVersion || env.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported secret store version %d (%s)", env.Version, env.KDF)
+	}
+
+	gcm, err := newGCM(password, env)
+	if err != nil {
+		return nil, err
+	}
+	if len(env.Nonce) != gcm.NonceSize() {
+		return nil, errWrongPassword
+	}
+	plaintext, err := gcm.Open(nil, env.Nonce, env.Data, nil)
+	if err != nil {
+		return nil, errWrongPassword
+	}
+	return plaintext, nil
+}
+
+// newGCM derives the AES-256 key for env from password
+func newGCM(password []byte, env envelope) (cipher.AEAD, error) {
+	key, err := scrypt.Key(password, env.Salt, env.N, env.R, env.P, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,145 @@
+package secrets
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestFileBackend(t *testing.T, path string, password string) *fileBackend {
+	t.Helper()
+	return &fileBackend{path: path, password: []byte(password)}
+}
+
+func TestFileBackendRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config", fileName)
+	f := newTestFileBackend(t, path, "correct horse")
+
+	if _, err := f.Get("gh-token"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get from a missing store = %v, want ErrNotFound", err)
+	}
+	if err := f.Set("gh-token", "ghp_secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Set("smtp", "multi\nline value"); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("store mode = %v, want 0600", info.Mode().Perm())
+	}
+	data, _ := os.ReadFile(path)
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		t.Fatal(err)
+	}
+	if env.Version != fileVersion || env.KDF != "scrypt" || env.N != scryptN || len(env.Salt) != 16 {
+		t.Errorf("envelope = %+v", env)
+	}
+
+	// A second process with the same password reads both values back
+	again := newTestFileBackend(t, path, "correct horse")
+	for name, want := range map[string]string{"gh-token": "ghp_secret", "smtp": "multi\nline value"} {
+		if got, err := again.Get(name); err != nil || got != want {
+			t.Errorf("Get(%s) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+
+	if err := again.Delete("gh-token"); err != nil {
+		t.Fatal(err)
+	}
+	if err := again.Delete("gh-token"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second Delete = %v, want ErrNotFound", err)
+	}
+	if _, err := f.Get("gh-token"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete = %v, want ErrNotFound", err)
+	}
+}
+
+func TestFileBackendWrongPassword(t *testing.T) {
+	path := filepath.Join(t.TempDir(), fileName)
+	if err := newTestFileBackend(t, path, "right").Set("name", "value"); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := os.ReadFile(path)
+
+	wrong := newTestFileBackend(t, path, "wrong")
+	if _, err := wrong.Get("name"); !errors.Is(err, errWrongPassword) {
+		t.Errorf("Get with the wrong password = %v", err)
+	}
+	// Nothing is written over the store it could not open
+	if err := wrong.Set("other", "value"); !errors.Is(err, errWrongPassword) {
+		t.Errorf("Set with the wrong password = %v", err)
+	}
+	if after, _ := os.ReadFile(path); string(after) != string(before) {
+		t.Error("the store changed after a failed unlock")
+	}
+}
+
+func TestSealUsesFreshSaltAndNonce(t *testing.T) {
+	password := []byte("pw")
+	first, err := seal(password, []byte(`{"a":"1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := seal(password, []byte(`{"a":"1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var a, b envelope
+	json.Unmarshal(first, &a)
+	json.Unmarshal(second, &b)
+	if string(a.Salt) == string(b.Salt) || string(a.Nonce) == string(b.Nonce) || string(a.Data) == string(b.Data) {
+		t.Error("two seals of the same plaintext share salt, nonce or ciphertext")
+	}
+	if plaintext, err := open(password, second); err != nil || string(plaintext) != `{"a":"1"}` {
+		t.Errorf("open = %q, %v", plaintext, err)
+	}
+}
+
+func TestOpenRejectsTamperedStores(t *testing.T) {
+	password := []byte("pw")
+	sealed, err := seal(password, []byte(`{"a":"1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tamper := func(change func(*envelope)) []byte {
+		var env envelope
+		if err := json.Unmarshal(sealed, &env); err != nil {
+			t.Fatal(err)
+		}
+		change(&env)
+		data, _ := json.Marshal(env)
+		return data
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wrongPw bool
+	}{
+		{"flipped ciphertext bit", tamper(func(e *envelope) { e.Data[0] ^= 1 }), true},
+		{"other salt", tamper(func(e *envelope) { e.Salt[0] ^= 1 }), true},
+		{"short nonce", tamper(func(e *envelope) { e.Nonce = e.Nonce[:4] }), true},
+		{"future version", tamper(func(e *envelope) { e.Version = 2 }), false},
+		{"other kdf", tamper(func(e *envelope) { e.KDF = "argon2" }), false},
+		{"not json", []byte("garbage"), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := open(password, test.data)
+			if err == nil {
+				t.Fatal("opened a tampered store")
+			}
+			if errors.Is(err, errWrongPassword) != test.wrongPw {
+				t.Errorf("open = %v", err)
+			}
+		})
+	}
+}
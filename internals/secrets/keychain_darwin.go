@@ -0,0 +1,48 @@
+//go:build darwin
+
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"gsn-dev-tools/internals/execx"
+)
+
+// keychain stores secrets in the macOS login keychain through the security tool
+type keychain struct{}
+
+func newKeychain() Backend {
+	if _, err := Runner.LookPath("security"); err != nil {
+		return nil
+	}
+	return keychain{}
+}
+
+func (keychain) Name() string { return "keychain" }
+
+func (keychain) Get(name string) (string, error) {
+	out, _, _, err := Runner.Run(context.Background(), "security", []string{"find-generic-password", "-s", service, "-a", name, "-w"}, execx.Options{})
+	if err != nil {
+		return "", ErrNotFound
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+func (keychain) Set(name string, value string) error {
+	// -U updates an existing item instead of failing
+	args := []string{"add-generic-password", "-U", "-s", service, "-a", name, "-w", value}
+	_, stderr, _, err := Runner.Run(context.Background(), "security", args, execx.Options{})
+	if err != nil {
+		return fmt.Errorf("security: %s", strings.TrimSpace(string(stderr)))
+	}
+	return nil
+}
+
+func (keychain) Delete(name string) error {
+	if _, _, _, err := Runner.Run(context.Background(), "security", []string{"delete-generic-password", "-s", service, "-a", name}, execx.Options{}); err != nil {
+		return ErrNotFound
+	}
+	return nil
+}
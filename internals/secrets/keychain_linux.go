@@ -0,0 +1,53 @@
+//go:build linux
+
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"gsn-dev-tools/internals/execx"
+)
+
+// keychain stores secrets in the Secret Service (GNOME Keyring, KWallet) through secret-tool
+type keychain struct{}
+
+func newKeychain() Backend {
+	if _, err := Runner.LookPath("secret-tool"); err != nil {
+		return nil
+	}
+	// Without a session bus there is no Secret Service to talk to
+	if os.Getenv("DBUS_SESSION_BUS_ADDRESS") == "" {
+		return nil
+	}
+	return keychain{}
+}
+
+func (keychain) Name() string { return "keychain" }
+
+func (keychain) Get(name string) (string, error) {
+	out, _, _, err := Runner.Run(context.Background(), "secret-tool", []string{"lookup", "service", service, "account", name}, execx.Options{})
+	if err != nil || len(out) == 0 {
+		return "", ErrNotFound
+	}
+	return string(out), nil
+}
+
+func (keychain) Set(name string, value string) error {
+	args := []string{"store", "--label", service + " " + name, "service", service, "account", name}
+	_, stderr, _, err := Runner.Run(context.Background(), "secret-tool", args, execx.Options{Stdin: strings.NewReader(value)})
+	if err != nil {
+		return fmt.Errorf("secret-tool: %s", strings.TrimSpace(string(stderr)))
+	}
+	return nil
+}
+
+func (k keychain) Delete(name string) error {
+	if _, err := k.Get(name); err != nil {
+		return err
+	}
+	_, _, _, err := Runner.Run(context.Background(), "secret-tool", []string{"clear", "service", service, "account", name}, execx.Options{})
+	return err
+}
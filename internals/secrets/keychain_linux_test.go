@@ -0,0 +1,76 @@
+package secrets
+
+import (
+	"errors"
+	"testing"
+
+	"gsn-dev-tools/internals/execx"
+)
+
+// useFakeRunner swaps Runner for fake until the test ends
+func useFakeRunner(t *testing.T, fake *execx.Fake) {
+	t.Helper()
+	previous := Runner
+	Runner = fake
+	t.Cleanup(func() { Runner = previous })
+}
+
+func TestLinuxKeychainCommands(t *testing.T) {
+	fake := &execx.Fake{InOrder: true}
+	useFakeRunner(t, fake)
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", "unix:path=/run/user/1000/bus")
+
+	fake.Expect("secret-tool", "store", "--label", "gsn gh-token", "service", "gsn", "account", "gh-token")
+	fake.Expect("secret-tool", "lookup", "service", "gsn", "account", "gh-token").Return("ghp_secret", 0)
+	fake.Expect("secret-tool", "lookup", "service", "gsn", "account", "gh-token").Return("ghp_secret", 0)
+	fake.Expect("secret-tool", "clear", "service", "gsn", "account", "gh-token")
+	fake.Expect("secret-tool", "lookup", "service", "gsn", "account", "gh-token").Return("", 1)
+
+	kc := newKeychain()
+	if kc == nil {
+		t.Fatal("no keychain with secret-tool and a session bus")
+	}
+	if err := kc.Set("gh-token", "ghp_secret"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := kc.Get("gh-token"); err != nil || got != "ghp_secret" {
+		t.Errorf("Get = %q, %v", got, err)
+	}
+	if err := kc.Delete("gh-token"); err != nil {
+		t.Fatal(err)
+	}
+	if err := kc.Delete("gh-token"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete of a missing secret = %v, want ErrNotFound", err)
+	}
+	if err := fake.Verify(); err != nil {
+		t.Error(err)
+	}
+
+	// The value goes through stdin, never the command line
+	if calls := fake.Calls(); calls[0].Stdin != "ghp_secret" {
+		t.Errorf("store stdin = %q", calls[0].Stdin)
+	}
+}
+
+func TestLinuxKeychainStoreError(t *testing.T) {
+	fake := &execx.Fake{}
+	useFakeRunner(t, fake)
+	fake.Expect("secret-tool", "store", execx.Rest()).Return("", 1).Stderr("No such interface\n")
+	if err := (keychain{}).Set("name", "value"); err == nil || err.Error() != "secret-tool: No such interface" {
+		t.Errorf("Set = %v", err)
+	}
+}
+
+func TestLinuxKeychainAvailability(t *testing.T) {
+	useFakeRunner(t, &execx.Fake{Paths: []string{}})
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", "unix:path=/run/user/1000/bus")
+	if newKeychain() != nil {
+		t.Error("keychain without secret-tool")
+	}
+
+	useFakeRunner(t, &execx.Fake{})
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", "")
+	if newKeychain() != nil {
+		t.Error("keychain without a session bus")
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package secrets
+
+// newKeychain reports no keychain on platforms without a supported integration,
+// Windows Credential Manager is not wired up yet so the encrypted file is used there
+func newKeychain() Backend {
+	return nil
+}
@@ -0,0 +1,121 @@
+package secrets
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/execx"
+
+	"golang.org/x/term"
+)
+
+// Runner runs the keychain tools, tests replace it with an execx.Fake
+var Runner execx.Runner = execx.Default
+
+// RefPrefix marks a config or flag value that must be looked up in the secret store, e.g. keyring:gh-token
+const RefPrefix = "keyring:"
+
+// service is the keychain service every gsn secret is stored under
+const service = "gsn"
+
+// ErrNotFound is returned when a secret does not exist in the selected backend, gsn exits with NotFound on it
+var ErrNotFound = clierr.New(clierr.NotFound, errors.New("secret not found"))
+
+// Backend stores named secrets
+type Backend interface {
+	Name() string
+	Get(name string) (string, error)
+	Set(name string, value string) error
+	Delete(name string) error
+}
+
+// Open returns the backend with the given name, or picks one when name is empty:
+// the OS keychain when it is usable, the encrypted file otherwise.
+func Open(name string) (Backend, error) {
+	switch name {
+	case "":
+		if kc := newKeychain(); kc != nil {
+			return kc, nil
+		}
+		return newFileBackend()
+	case "keychain":
+		if kc := newKeychain(); kc != nil {
+			return kc, nil
+		}
+		return nil, fmt.Errorf("no OS keychain available on this system")
+	case "file":
+		return newFileBackend()
+	default:
+		return nil, fmt.Errorf("unknown secret backend '%s' (use keychain or file)", name)
+	}
+}
+
+// IsRef reports whether value is a keyring:<name> reference
+func IsRef(value string) bool {
+	return strings.HasPrefix(value, RefPrefix)
+}
+
+// Resolve returns value unchanged unless it is a keyring:<name> reference, which is looked up with the
+// backend from $GSN_SECRETS_BACKEND (automatic when unset)
+func Resolve(value string) (string, error) {
+	if !IsRef(value) {
+		return value, nil
+	}
+
+	name := strings.TrimPrefix(value, RefPrefix)
+	backend, err := Open(os.Getenv("GSN_SECRETS_BACKEND"))
+	if err != nil {
+		return "", err
+	}
+	secret, err := backend.Get(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s from %s: %w", value, backend.Name(), err)
+	}
+	return secret, nil
+}
+
+// ReadSecret reads a value with hidden input on a terminal, or the first line of piped stdin
+func ReadSecret(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, prompt)
+		data, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return strings.TrimRight(string(data), "\r\n"), err
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// ReadPassphrase returns $envVar when set, or reads a passphrase from the terminal or piped stdin. confirm asks
+// twice on a terminal, for a passphrase protecting something new, so a typo does not lock it away.
+func ReadPassphrase(envVar string, prompt string, confirm bool) (string, error) {
+	if p := os.Getenv(envVar); p != "" {
+		return p, nil
+	}
+	p, err := ReadSecret(prompt)
+	if err != nil {
+		return "", err
+	}
+	if p == "" {
+		return "", fmt.Errorf("a passphrase is required, set $%s or type one", envVar)
+	}
+	if confirm && term.IsTerminal(int(os.Stdin.Fd())) {
+		again, err := ReadSecret("Confirm " + strings.ToLower(prompt[:1]) + prompt[1:])
+		if err != nil {
+			return "", err
+		}
+		if again != p {
+			return "", fmt.Errorf("the passphrases do not match")
+		}
+	}
+	return p, nil
+}
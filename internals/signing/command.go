@@ -0,0 +1,54 @@
+package signing
+
+import (
+	"errors"
+	"fmt"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/style"
+
+	"github.com/spf13/cobra"
+)
+
+func VerifySigCmd() *cobra.Command {
+	verifyCmd := &cobra.Command{
+		Use:   "verify-sig <file>",
+		Short: "Checks the detached signature of a file",
+		Long: `Checks a detached signature written by --sign of gsn cmp or gsn backup, gpg --detach-sign or ssh-keygen -Y sign
+-n file. --sig defaults to <file>.sig.
+
+An SSH signature is checked against --key, a public key or a file of them like authorized_keys or an allowed
+signers file, and must be made by one of them. A PGP signature is checked against --key, an armored or binary
+public key, or against gpg's keyring when --key is not given.
+
+Exits with 0 when the signature holds and 1 when it does not.`,
+		Example: `  gsn verify-sig project.tar.gz --key alice.asc
+  gsn verify-sig project.tar.gz --key ~/.ssh/id_ed25519.pub
+  gsn verify-sig backup.tar.gz.sha256sum --sig backup.tar.gz.sha256sum.sig`,
+		Args: cobra.ExactArgs(1),
+		Run:  VerifySig,
+	}
+
+	verifyCmd.Flags().String("sig", "", "Signature file (default <file>.sig)")
+	verifyCmd.Flags().String("key", "", "Public key of the signer: a PGP key file, or SSH public keys")
+	dryrun.ReadOnly(verifyCmd)
+	return verifyCmd
+}
+
+func VerifySig(cmd *cobra.Command, args []string) {
+	sigPath, _ := cmd.Flags().GetString("sig")
+	keyPath, _ := cmd.Flags().GetString("key")
+	if sigPath == "" {
+		sigPath = args[0] + Suffix
+	}
+
+	signer, err := VerifyFile(cmd.Context(), args[0], sigPath, keyPath)
+	if errors.Is(err, ErrBadSignature) {
+		clierr.Exitf(clierr.Failure, "%s: %v", args[0], err)
+	}
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	fmt.Printf(style.Success()+"Good signature of %s by %s\n", args[0], signer)
+}
@@ -0,0 +1,152 @@
+package signing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gsn-dev-tools/internals/execx"
+	"gsn-dev-tools/internals/secrets"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	pgperrors "golang.org/x/crypto/openpgp/errors"
+)
+
+// pgpSignatureHeader starts an armored PGP signature
+const pgpSignatureHeader = "-----BEGIN PGP SIGNATURE-----"
+
+// Runner executes gpg, tests replace it with an execx.Fake
+var Runner execx.Runner = execx.Default
+
+// readKeyRing reads an armored or binary PGP key file
+func readKeyRing(data []byte) (openpgp.EntityList, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		return openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	}
+	return openpgp.ReadKeyRing(bytes.NewReader(data))
+}
+
+// loadPGPSigner reads the first private key of a key file, asking for its passphrase when it is encrypted
+func loadPGPSigner(path string) (*openpgp.Entity, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := readKeyRing(data)
+	if errors.As(err, new(pgperrors.UnsupportedError)) {
+		return nil, fmt.Errorf("failed to read the PGP key '%s': %w; only RSA, DSA and ECDSA keys are read from files, sign through gpg with --sign-key <key id> instead", path, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the PGP key '%s': %w", path, err)
+	}
+	for _, entity := range keys {
+		if entity.PrivateKey == nil {
+			continue
+		}
+		if entity.PrivateKey.Encrypted {
+			passphrase, err := secrets.ReadPassphrase("GSN_SIGN_PASSPHRASE", fmt.Sprintf("Passphrase for %s: ", path), false)
+			if err != nil {
+				return nil, err
+			}
+			if err := entity.PrivateKey.Decrypt([]byte(passphrase)); err != nil {
+				return nil, fmt.Errorf("failed to decrypt '%s', wrong passphrase?", path)
+			}
+		}
+		return entity, nil
+	}
+	return nil, fmt.Errorf("'%s' holds no PGP private key, export one with gpg --export-secret-keys --armor", path)
+}
+
+// signPGP returns the armored detached signature of message
+func signPGP(signer *openpgp.Entity, message io.Reader) ([]byte, error) {
+	var out bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&out, signer, message, nil); err != nil {
+		return nil, err
+	}
+	out.WriteString("\n")
+	return out.Bytes(), nil
+}
+
+// verifyPGP checks an armored or binary detached signature of message against a public key file and returns
+// the identity that signed
+func verifyPGP(sig []byte, message io.Reader, keyData []byte) (string, error) {
+	keys, err := readKeyRing(keyData)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the PGP public key: %w", err)
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(sig), []byte(pgpSignatureHeader)) {
+		block, err := armor.Decode(bytes.NewReader(sig))
+		if err != nil {
+			return "", fmt.Errorf("malformed PGP signature: %w", err)
+		}
+		if sig, err = io.ReadAll(block.Body); err != nil {
+			return "", fmt.Errorf("malformed PGP signature: %w", err)
+		}
+	}
+
+	signer, err := openpgp.CheckDetachedSignature(keys, message, bytes.NewReader(sig))
+	switch {
+	case errors.Is(err, pgperrors.ErrUnknownIssuer):
+		return "", fmt.Errorf("%w: signed by a key that is not in the given keys", ErrBadSignature)
+	case errors.As(err, new(pgperrors.SignatureError)):
+		return "", fmt.Errorf("%w: %v", ErrBadSignature, err)
+	case err != nil:
+		return "", err
+	}
+	return entityName(signer), nil
+}
+
+// entityName is the first user id of a key, with its fingerprint
+func entityName(e *openpgp.Entity) string {
+	fingerprint := fmt.Sprintf("%X", e.PrimaryKey.Fingerprint)
+	for name := range e.Identities {
+		return fmt.Sprintf("%s (%s)", name, fingerprint)
+	}
+	return fingerprint
+}
+
+// signGPG signs with gpg, whose agent holds the key and asks for its passphrase. user picks the key, gpg's
+// default key is used when empty.
+func signGPG(ctx context.Context, path string, sigPath string, user string) error {
+	args := []string{"--yes", "--armor", "--detach-sign", "--output", sigPath}
+	if user != "" {
+		args = append(args, "--local-user", user)
+	}
+	args = append(args, path)
+	if _, err := Runner.LookPath("gpg"); err != nil {
+		return fmt.Errorf("gpg is not installed, sign with --sign-key <armored key file> or --ssh-key instead")
+	}
+	_, _, _, err := Runner.Run(ctx, "gpg", args, execx.Options{Stdin: os.Stdin})
+	return err
+}
+
+// verifyGPG checks a signature against the keys in gpg's keyring and returns what gpg says about the signer
+func verifyGPG(ctx context.Context, path string, sigPath string) (string, error) {
+	if _, err := Runner.LookPath("gpg"); err != nil {
+		return "", fmt.Errorf("gpg is not installed, give the public key with --key")
+	}
+	_, stderr, code, err := Runner.Run(ctx, "gpg", []string{"--verify", sigPath, path}, execx.Options{})
+	if code == 1 {
+		return "", fmt.Errorf("%w: %s", ErrBadSignature, gpgSummary(stderr))
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(gpgSummary(stderr), "Good signature from "), nil
+}
+
+// gpgSummary keeps the line of gpg's report naming the outcome and the signer
+func gpgSummary(stderr []byte) string {
+	for _, line := range strings.Split(string(stderr), "\n") {
+		line = strings.TrimPrefix(line, "gpg: ")
+		if strings.HasPrefix(line, "Good signature") || strings.HasPrefix(line, "BAD signature") {
+			return line
+		}
+	}
+	return strings.TrimSpace(string(stderr))
+}
@@ -0,0 +1,146 @@
+// Package signing writes and checks detached signatures of files, with PGP keys or SSH keys
+package signing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"gsn-dev-tools/internals/output"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/ssh"
+)
+
+// Suffix is appended to a file's path for its detached signature
+const Suffix = ".sig"
+
+// ErrBadSignature is returned when a signature does not match the file or was made by an untrusted key
+var ErrBadSignature = errors.New("bad signature")
+
+// Key says what signs: an SSH private key when SSHKey is set, otherwise PGP, from a key file when PGPKey names
+// an existing file or through gpg and its agent, with PGPKey as the key id, otherwise
+type Key struct {
+	PGPKey string
+	SSHKey string
+}
+
+// AddFlags registers --sign, --sign-key and --ssh-key on a command writing files worth signing
+func AddFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("sign", false, "Write a detached signature <file>.sig, with gpg's default key unless --sign-key or --ssh-key is given")
+	cmd.Flags().String("sign-key", "", "Armored PGP private key file, or a gpg key id or email, to sign with (implies --sign)")
+	cmd.Flags().String("ssh-key", "", "SSH private key to sign with, as ssh-keygen -Y sign does (implies --sign)")
+}
+
+// KeyFromFlags reads the flags of AddFlags, nil means no signature was asked for
+func KeyFromFlags(cmd *cobra.Command) (*Key, error) {
+	sign, _ := cmd.Flags().GetBool("sign")
+	pgpKey, _ := cmd.Flags().GetString("sign-key")
+	sshKey, _ := cmd.Flags().GetString("ssh-key")
+	if pgpKey != "" && sshKey != "" {
+		return nil, fmt.Errorf("--sign-key and --ssh-key are mutually exclusive")
+	}
+	if !sign && pgpKey == "" && sshKey == "" {
+		return nil, nil
+	}
+	return &Key{PGPKey: pgpKey, SSHKey: sshKey}, nil
+}
+
+// Signer signs files with a loaded key, so an encrypted key asks for its passphrase before the work producing
+// the files rather than after it
+type Signer struct {
+	key Key
+	ssh ssh.Signer
+	pgp *openpgp.Entity
+}
+
+// NewSigner loads the key files of key, gpg loads its keys itself when signing
+func NewSigner(key Key) (*Signer, error) {
+	s := &Signer{key: key}
+	var err error
+	switch {
+	case key.SSHKey != "":
+		s.ssh, err = loadSSHSigner(key.SSHKey)
+	case isFile(key.PGPKey):
+		s.pgp, err = loadPGPSigner(key.PGPKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SignFile writes the detached signature of path to path.sig and returns its path
+func (s *Signer) SignFile(ctx context.Context, path string) (string, error) {
+	sigPath := path + Suffix
+	if s.ssh == nil && s.pgp == nil {
+		if err := signGPG(ctx, path, sigPath, s.key.PGPKey); err != nil {
+			return "", fmt.Errorf("failed to sign '%s': %w", path, err)
+		}
+		return sigPath, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	var sig []byte
+	if s.ssh != nil {
+		sig, err = signSSH(s.ssh, file)
+	} else {
+		sig, err = signPGP(s.pgp, file)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to sign '%s': %w", path, err)
+	}
+	if err := output.WriteFileAtomic(sigPath, sig, 0o644); err != nil {
+		return "", err
+	}
+	return sigPath, nil
+}
+
+// VerifyFile checks the detached signature sigPath of path and returns who signed. keyPath is an SSH public key
+// or allowed signers file for SSH signatures, a PGP public key file for PGP ones; without it PGP signatures are
+// checked by gpg against its keyring. A signature that does not hold wraps ErrBadSignature.
+func VerifyFile(ctx context.Context, path string, sigPath string, keyPath string) (string, error) {
+	sig, err := os.ReadFile(sigPath)
+	if err != nil {
+		return "", err
+	}
+	var keyData []byte
+	if keyPath != "" {
+		if keyData, err = os.ReadFile(keyPath); err != nil {
+			return "", err
+		}
+	}
+	if !isSSHSignature(sig) && keyPath == "" {
+		return verifyGPG(ctx, path, sigPath)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if isSSHSignature(sig) {
+		if keyPath == "" {
+			return "", fmt.Errorf("an SSH signature needs the signer's public key, give it with --key")
+		}
+		key, err := verifySSH(sig, file, keyData)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s key %s", key.Type(), ssh.FingerprintSHA256(key)), nil
+	}
+	return verifyPGP(sig, file, keyData)
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
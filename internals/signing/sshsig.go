@@ -0,0 +1,197 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gsn-dev-tools/internals/secrets"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// The SSH signature format of ssh-keygen -Y sign, see PROTOCOL.sshsig in OpenSSH
+const (
+	sshsigMagic     = "SSHSIG"
+	sshsigVersion   = 1
+	sshsigNamespace = "file"
+	sshsigBegin     = "-----BEGIN SSH SIGNATURE-----"
+	sshsigEnd       = "-----END SSH SIGNATURE-----"
+)
+
+// magic starts both the signature blob and the signed data
+var magic = [6]byte([]byte(sshsigMagic))
+
+// sshsigBlob is the signature blob, the public key and signature are wire encoded
+type sshsigBlob struct {
+	Magic     [6]byte
+	Version   uint32
+	PublicKey []byte
+	Namespace string
+	Reserved  string
+	HashAlg   string
+	Signature []byte
+}
+
+// sshsigSigned is what the private key actually signs
+type sshsigSigned struct {
+	Magic     [6]byte
+	Namespace string
+	Reserved  string
+	HashAlg   string
+	Hash      []byte
+}
+
+// loadSSHSigner reads an OpenSSH or PEM private key, asking for its passphrase when it is encrypted
+func loadSSHSigner(path string) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		passphrase, err := secrets.ReadPassphrase("GSN_SIGN_PASSPHRASE", fmt.Sprintf("Passphrase for %s: ", path), false)
+		if err != nil {
+			return nil, err
+		}
+		if signer, err = ssh.ParsePrivateKeyWithPassphrase(data, []byte(passphrase)); err != nil {
+			return nil, fmt.Errorf("failed to decrypt '%s': %w", path, err)
+		}
+		return signer, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the SSH key '%s': %w", path, err)
+	}
+	return signer, nil
+}
+
+// signSSH returns the armored SSH signature of message, as ssh-keygen -Y sign -n file writes it
+func signSSH(signer ssh.Signer, message io.Reader) ([]byte, error) {
+	hash := sha512.New()
+	if _, err := io.Copy(hash, message); err != nil {
+		return nil, err
+	}
+	signed := ssh.Marshal(sshsigSigned{Magic: magic, Namespace: sshsigNamespace, HashAlg: "sha512", Hash: hash.Sum(nil)})
+
+	var sig *ssh.Signature
+	var err error
+	// RSA keys sign with SHA-1 unless asked otherwise, which ssh-keygen refuses for signatures
+	if algSigner, ok := signer.(ssh.AlgorithmSigner); ok && signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		sig, err = algSigner.SignWithAlgorithm(rand.Reader, signed, ssh.KeyAlgoRSASHA512)
+	} else {
+		sig, err = signer.Sign(rand.Reader, signed)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	blob := ssh.Marshal(sshsigBlob{
+		Magic:     magic,
+		Version:   sshsigVersion,
+		PublicKey: signer.PublicKey().Marshal(),
+		Namespace: sshsigNamespace,
+		HashAlg:   "sha512",
+		Signature: ssh.Marshal(sig),
+	})
+
+	var out bytes.Buffer
+	out.WriteString(sshsigBegin + "\n")
+	encoded := base64.StdEncoding.EncodeToString(blob)
+	for len(encoded) > 70 {
+		out.WriteString(encoded[:70] + "\n")
+		encoded = encoded[70:]
+	}
+	out.WriteString(encoded + "\n" + sshsigEnd + "\n")
+	return out.Bytes(), nil
+}
+
+// isSSHSignature reports whether sig is an armored SSH signature
+func isSSHSignature(sig []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(sig), []byte(sshsigBegin))
+}
+
+// verifySSH checks an armored SSH signature of message against the public keys of keyData, an authorized_keys
+// or allowed signers like list, and returns the key that signed
+func verifySSH(sig []byte, message io.Reader, keyData []byte) (ssh.PublicKey, error) {
+	text := strings.TrimSpace(string(sig))
+	text = strings.TrimSuffix(strings.TrimPrefix(text, sshsigBegin), sshsigEnd)
+	raw, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(text), ""))
+	if err != nil {
+		return nil, fmt.Errorf("malformed SSH signature: %w", err)
+	}
+	var blob sshsigBlob
+	if err := ssh.Unmarshal(raw, &blob); err != nil || blob.Magic != magic {
+		return nil, fmt.Errorf("malformed SSH signature")
+	}
+	if blob.Version != sshsigVersion {
+		return nil, fmt.Errorf("unsupported SSH signature version %d", blob.Version)
+	}
+	if blob.Namespace != sshsigNamespace {
+		return nil, fmt.Errorf("the SSH signature is for namespace '%s', not '%s'", blob.Namespace, sshsigNamespace)
+	}
+	signerKey, err := ssh.ParsePublicKey(blob.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("malformed key in the SSH signature: %w", err)
+	}
+	if !trustsSSHKey(keyData, signerKey) {
+		return nil, fmt.Errorf("%w: signed by %s, which is not in the given keys", ErrBadSignature, ssh.FingerprintSHA256(signerKey))
+	}
+
+	var digest []byte
+	switch blob.HashAlg {
+	case "sha512":
+		h := sha512.New()
+		if _, err := io.Copy(h, message); err != nil {
+			return nil, err
+		}
+		digest = h.Sum(nil)
+	case "sha256":
+		h := sha256.New()
+		if _, err := io.Copy(h, message); err != nil {
+			return nil, err
+		}
+		digest = h.Sum(nil)
+	default:
+		return nil, fmt.Errorf("unsupported SSH signature hash '%s'", blob.HashAlg)
+	}
+
+	signature := new(ssh.Signature)
+	if err := ssh.Unmarshal(blob.Signature, signature); err != nil {
+		return nil, fmt.Errorf("malformed SSH signature: %w", err)
+	}
+	signed := ssh.Marshal(sshsigSigned{Magic: magic, Namespace: blob.Namespace, Reserved: blob.Reserved, HashAlg: blob.HashAlg, Hash: digest})
+	if err := signerKey.Verify(signed, signature); err != nil {
+		return nil, fmt.Errorf("%w: the file does not match it", ErrBadSignature)
+	}
+	return signerKey, nil
+}
+
+// trustsSSHKey looks for key among the public keys of keyData, one per line with optional principals or
+// options before them as in allowed signers files
+func trustsSSHKey(keyData []byte, key ssh.PublicKey) bool {
+	for _, line := range strings.Split(string(keyData), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		// The key type starts the key, principals and options may come first
+		for i := range fields {
+			trusted, _, _, _, err := ssh.ParseAuthorizedKey([]byte(strings.Join(fields[i:], " ")))
+			if err == nil {
+				if bytes.Equal(trusted.Marshal(), key.Marshal()) {
+					return true
+				}
+				break
+			}
+		}
+	}
+	return false
+}
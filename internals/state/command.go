@@ -0,0 +1,180 @@
+package state
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/output"
+	"gsn-dev-tools/internals/style"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+func StateCmd() *cobra.Command {
+	stateCmd := &cobra.Command{
+		Use:   "state",
+		Short: "Manages the versioned files gsn keeps between runs",
+		Long: `Every config and state file of gsn carries a schema_version. Files written by an older gsn are upgraded in
+memory when read, and files written by a newer gsn are refused by commands that would change them or act on
+what they record.`,
+		Example: "  gsn state migrate",
+	}
+
+	stateCmd.AddCommand(migrateCmd())
+	return stateCmd
+}
+
+func migrateCmd() *cobra.Command {
+	var reset bool
+
+	migrateCmd := &cobra.Command{
+		Use:   "migrate [file]...",
+		Short: "Upgrades the config and state files to the schema this gsn writes",
+		Long: `Rewrites the config file, the gh queue and the workspace rename journals with the current schema_version,
+migrating those written by an older gsn. Every rewritten file is first copied to <file>.v<version>.bak. Files
+written by a newer gsn are left alone. Rename journals inside the renamed directories are migrated the next
+time gsn reads them, or when they are given as arguments.
+
+A corrupted file is reported with an error, --reset moves it to <file>.corrupt.bak so gsn starts over without
+it.`,
+		Example: `  gsn state migrate
+  gsn state migrate --reset
+  gsn state migrate --reset ./photos/.gsn-rename-journal.json`,
+		Run: func(cmd *cobra.Command, args []string) {
+			failed := 0
+			report := func(err error) {
+				fmt.Fprintf(os.Stderr, style.Error()+"%v\n", err)
+				failed++
+			}
+
+			if len(args) > 0 {
+				for _, path := range args {
+					k, err := KindOf(path)
+					if err == nil {
+						err = migrateFile(k, path, reset)
+					}
+					if err != nil {
+						report(err)
+					}
+				}
+			} else {
+				for _, k := range kinds {
+					if k.Files == nil {
+						continue
+					}
+					paths, err := k.Files()
+					if err != nil {
+						report(fmt.Errorf("cannot list %s files: %w", k.Name, err))
+						continue
+					}
+					for _, path := range paths {
+						if err := migrateFile(k, path, reset); err != nil {
+							report(err)
+						}
+					}
+				}
+			}
+			if failed > 0 {
+				clierr.Exit(clierr.Failure)
+			}
+		},
+	}
+
+	migrateCmd.Flags().BoolVar(&reset, "reset", false, "Move corrupted files aside instead of failing on them")
+	return migrateCmd
+}
+
+// migrateFile upgrades one file in place after backing it up, or moves it aside when it is corrupted and reset
+// is set
+func migrateFile(k *Kind, path string, reset bool) error {
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	doc, err := k.Parse(path, data)
+	if IsCorrupt(err) && reset {
+		backup := path + ".corrupt.bak"
+		if err := os.Rename(path, backup); err != nil {
+			return err
+		}
+		fmt.Printf(style.Trash()+"Moved corrupted '%s' to '%s'\n", path, backup)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if doc.Newer() {
+		fmt.Fprintf(os.Stderr, style.Warning()+"Leaving '%s', it was written by a newer gsn (%s schema version %d)\n", path, k.Name, doc.Version)
+		return nil
+	}
+
+	stamped, err := rewrite(doc, data)
+	if err != nil {
+		return fmt.Errorf("'%s': %w", path, err)
+	}
+	if stamped == nil {
+		return nil
+	}
+
+	backup := fmt.Sprintf("%s.v%d.bak", path, doc.Version)
+	if err := output.WriteFileAtomic(backup, data, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("cannot back up '%s': %w", path, err)
+	}
+	if err := output.WriteFileAtomic(path, stamped, info.Mode().Perm()); err != nil {
+		return err
+	}
+	if doc.Version == k.Version {
+		fmt.Printf(style.Success()+"Added %s %d to '%s' (backup '%s')\n", VersionField, k.Version, path, backup)
+	} else {
+		fmt.Printf(style.Success()+"Migrated '%s' from %s schema version %d to %d (backup '%s')\n", path, k.Name, doc.Version, k.Version, backup)
+	}
+	return nil
+}
+
+// rewrite returns the content of a migrated file, nil when it is current and has its schema_version already.
+// A YAML file that only lacks schema_version keeps its comments and key order.
+func rewrite(doc *Document, original []byte) ([]byte, error) {
+	if doc.Current() {
+		return nil, nil
+	}
+	if doc.Version == doc.Kind.Version && doc.Kind.Format == YAML {
+		return stampYAML(original, doc.Kind.Version)
+	}
+	return doc.Marshal()
+}
+
+// stampYAML adds schema_version in front of the top-level keys of a YAML document
+func stampYAML(data []byte, version int) ([]byte, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	if len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("the document is not a mapping")
+	}
+	mapping := root.Content[0]
+	key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: VersionField}
+	value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: fmt.Sprint(version)}
+	mapping.Content = slices.Insert(mapping.Content, 0, key, value)
+
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err := enc.Encode(&root); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), enc.Close()
+}
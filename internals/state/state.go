@@ -0,0 +1,321 @@
+// Package state versions the JSON and YAML files gsn keeps between runs. Every file carries a schema_version,
+// files written by an older gsn are migrated when read, and files written by a newer gsn are refused for
+// anything that would change them.
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+
+	"gsn-dev-tools/internals/clierr"
+
+	"gopkg.in/yaml.v3"
+)
+
+// VersionField is the key holding the schema version at the top of every state file
+const VersionField = "schema_version"
+
+// Format is the encoding of a kind of state file
+type Format int
+
+const (
+	JSON Format = iota
+	YAML
+)
+
+// Migration upgrades the values of a file by one version, in place
+type Migration func(values map[string]any) error
+
+// Kind describes a kind of state file: its current schema version and how older versions are upgraded
+type Kind struct {
+	Name   string
+	Format Format
+
+	// Version is the schema version this gsn writes
+	Version int
+	// Migrations upgrades a file from the version it is keyed by to the next one
+	Migrations map[int]Migration
+	// Validate checks the migrated values beyond what decoding them checks, it is optional
+	Validate func(values map[string]any) error
+	// Files lists the files of this kind gsn state migrate upgrades. Kinds also kept next to user data, like
+	// the rename journals, only list those in the gsn directories, the others are migrated when read. It is nil
+	// for kinds never kept in the gsn directories, like the manifest of gsn export.
+	Files func() ([]string, error)
+	// Patterns match the base names of its files, so files given to gsn state migrate find their kind
+	Patterns []string
+}
+
+// kinds holds the registered kinds in registration order
+var kinds []*Kind
+
+// Register adds a kind to those gsn state migrate handles and returns it
+func Register(k *Kind) *Kind {
+	kinds = append(kinds, k)
+	return k
+}
+
+// KindOf finds the kind of path: the kind listing it among its files, or else the first whose patterns match its
+// base name
+func KindOf(path string) (*Kind, error) {
+	if abs, err := filepath.Abs(path); err == nil {
+		for _, k := range kinds {
+			if k.Files == nil {
+				continue
+			}
+			files, err := k.Files()
+			if err != nil {
+				continue
+			}
+			for _, f := range files {
+				if f == abs {
+					return k, nil
+				}
+			}
+		}
+	}
+	for _, k := range kinds {
+		for _, pattern := range k.Patterns {
+			if ok, _ := filepath.Match(pattern, filepath.Base(path)); ok {
+				return k, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("'%s' is not a file gsn keeps state in", path)
+}
+
+// Check reads every file of the registered kinds and returns how many it read and the problems found: files
+// that are corrupted or written by a newer gsn
+func Check() (int, []error) {
+	checked := 0
+	var problems []error
+	for _, k := range kinds {
+		if k.Files == nil {
+			continue
+		}
+		paths, err := k.Files()
+		if err != nil {
+			problems = append(problems, fmt.Errorf("cannot list %s files: %w", k.Name, err))
+			continue
+		}
+		for _, path := range paths {
+			doc, err := k.Read(path)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			checked++
+			if err == nil {
+				err = doc.Writable()
+			}
+			if err != nil {
+				problems = append(problems, err)
+			}
+		}
+	}
+	return checked, problems
+}
+
+// CorruptError reports a state file that cannot be parsed or decoded
+type CorruptError struct {
+	Path string
+	Err  error
+}
+
+func (e *CorruptError) Error() string {
+	return fmt.Sprintf("'%s' is corrupted: %v (gsn state migrate --reset '%s' moves it aside)", e.Path, e.Err, e.Path)
+}
+
+func (e *CorruptError) Unwrap() error {
+	return e.Err
+}
+
+// NewerError reports a state file written by a newer gsn than this one
+type NewerError struct {
+	Path      string
+	Kind      string
+	Version   int
+	Supported int
+}
+
+func (e *NewerError) Error() string {
+	return fmt.Sprintf("'%s' was written by a newer gsn (%s schema version %d, this gsn supports up to %d), upgrade gsn to change it",
+		e.Path, e.Kind, e.Version, e.Supported)
+}
+
+// ExitCode reports a newer file as a Conflict, gsn is older than the state it is asked to change
+func (e *NewerError) ExitCode() clierr.Code {
+	return clierr.Conflict
+}
+
+// Document is a state file read and migrated in memory to the current version of its kind
+type Document struct {
+	Path string
+	Kind *Kind
+
+	// Version is the schema version the file was written with, 1 for files predating schema_version
+	Version int
+	// Values is the content migrated to Kind.Version, or as read when the file is newer
+	Values map[string]any
+
+	// versioned is false for files predating schema_version
+	versioned bool
+}
+
+// Read parses and migrates a state file. Errors reading it are returned as they are, so a missing file can be
+// told apart with os.ErrNotExist.
+func (k *Kind) Read(path string) (*Document, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return k.Parse(path, data)
+}
+
+// Parse parses and migrates the content of a state file. An empty file is an empty document of the current
+// version.
+func (k *Kind) Parse(path string, data []byte) (*Document, error) {
+	doc := &Document{Path: path, Kind: k, Version: k.Version, Values: map[string]any{}, versioned: true}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return doc, nil
+	}
+
+	var err error
+	switch k.Format {
+	case YAML:
+		err = yaml.Unmarshal(data, &doc.Values)
+	default:
+		// Numbers stay json.Number so large integers survive a migration
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		err = dec.Decode(&doc.Values)
+	}
+	if err != nil {
+		return nil, &CorruptError{Path: path, Err: err}
+	}
+	if doc.Values == nil {
+		doc.Values = map[string]any{}
+	}
+
+	_, doc.versioned = doc.Values[VersionField]
+	if doc.Version, err = readVersion(doc.Values); err != nil {
+		return nil, &CorruptError{Path: path, Err: err}
+	}
+	if doc.Newer() {
+		return doc, nil
+	}
+
+	for v := doc.Version; v < k.Version; v++ {
+		migrate, ok := k.Migrations[v]
+		if !ok {
+			return nil, fmt.Errorf("'%s': no migration of %s schema version %d to %d", path, k.Name, v, v+1)
+		}
+		if err := migrate(doc.Values); err != nil {
+			return nil, fmt.Errorf("'%s': migrating %s schema version %d to %d: %w", path, k.Name, v, v+1, err)
+		}
+	}
+	doc.Values[VersionField] = k.Version
+
+	if k.Validate != nil {
+		if err := k.Validate(doc.Values); err != nil {
+			return nil, &CorruptError{Path: path, Err: err}
+		}
+	}
+	return doc, nil
+}
+
+// readVersion returns the schema_version of values, files written before it existed are version 1
+func readVersion(values map[string]any) (int, error) {
+	raw, ok := values[VersionField]
+	if !ok {
+		return 1, nil
+	}
+
+	var version float64
+	switch v := raw.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s %v", VersionField, raw)
+		}
+		version = f
+	case int:
+		version = float64(v)
+	case uint64:
+		version = float64(v)
+	case float64:
+		version = v
+	default:
+		return 0, fmt.Errorf("%s is %v, not a number", VersionField, raw)
+	}
+	if version < 1 || version != math.Trunc(version) {
+		return 0, fmt.Errorf("invalid %s %v", VersionField, raw)
+	}
+	return int(version), nil
+}
+
+// Newer reports whether the file was written by a newer gsn
+func (d *Document) Newer() bool {
+	return d.Version > d.Kind.Version
+}
+
+// Current reports whether the file records the schema version this gsn writes
+func (d *Document) Current() bool {
+	return d.versioned && d.Version == d.Kind.Version
+}
+
+// Writable refuses files written by a newer gsn, commands check it before changing a file or acting on what it
+// records, since this gsn may have dropped fields it does not know
+func (d *Document) Writable() error {
+	if d.Newer() {
+		return &NewerError{Path: d.Path, Kind: d.Kind.Name, Version: d.Version, Supported: d.Kind.Version}
+	}
+	return nil
+}
+
+// Marshal encodes the values in the format of the kind
+func (d *Document) Marshal() ([]byte, error) {
+	if d.Kind.Format == YAML {
+		return yaml.Marshal(d.Values)
+	}
+	data, err := json.MarshalIndent(d.Values, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// Decode stores the values in v, which is what the kind's files decode into
+func (d *Document) Decode(v any) error {
+	data, err := d.Marshal()
+	if err != nil {
+		return err
+	}
+	if d.Kind.Format == YAML {
+		err = yaml.Unmarshal(data, v)
+	} else {
+		err = json.Unmarshal(data, v)
+	}
+	if err != nil {
+		return &CorruptError{Path: d.Path, Err: err}
+	}
+	return nil
+}
+
+// Load reads, migrates and decodes a state file into v
+func (k *Kind) Load(path string, v any) (*Document, error) {
+	doc, err := k.Read(path)
+	if err != nil {
+		return nil, err
+	}
+	return doc, doc.Decode(v)
+}
+
+// IsCorrupt reports whether err is, or wraps, a CorruptError
+func IsCorrupt(err error) bool {
+	var corrupt *CorruptError
+	return errors.As(err, &corrupt)
+}
@@ -0,0 +1,340 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gsn-dev-tools/internals/clierr"
+)
+
+// widgetKind returns a kind at version 3 in format: version 1 named the title "name", version 2 kept the tags
+// as one comma separated string. Validate refuses widgets without a title.
+func widgetKind(format Format) *Kind {
+	return &Kind{
+		Name:     "widget",
+		Format:   format,
+		Version:  3,
+		Patterns: []string{"*.widget.json", "*.widget.yaml"},
+		Migrations: map[int]Migration{
+			1: func(values map[string]any) error {
+				values["title"] = values["name"]
+				delete(values, "name")
+				return nil
+			},
+			2: func(values map[string]any) error {
+				tags, ok := values["tags"].(string)
+				if !ok {
+					return errors.New("tags is not a string")
+				}
+				var list []any
+				for _, tag := range strings.Split(tags, ",") {
+					list = append(list, tag)
+				}
+				values["tags"] = list
+				return nil
+			},
+		},
+		Validate: func(values map[string]any) error {
+			if title, _ := values["title"].(string); title == "" {
+				return errors.New("the widget has no title")
+			}
+			return nil
+		},
+	}
+}
+
+// useKinds registers only ks until the test ends
+func useKinds(t *testing.T, ks ...*Kind) {
+	t.Helper()
+	saved := kinds
+	kinds = nil
+	for _, k := range ks {
+		Register(k)
+	}
+	t.Cleanup(func() { kinds = saved })
+}
+
+func writeState(t *testing.T, path string, content string) string {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMigrationChain(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  Format
+		data    string
+		version int
+	}{
+		{"json v1 without schema_version", JSON, `{"name":"gear","tags":"a,b","size":9007199254740993}`, 1},
+		{"json v1", JSON, `{"schema_version":1,"name":"gear","tags":"a,b","size":9007199254740993}`, 1},
+		{"json v2", JSON, `{"schema_version":2,"title":"gear","tags":"a,b","size":9007199254740993}`, 2},
+		{"json v3", JSON, `{"schema_version":3,"title":"gear","tags":["a","b"],"size":9007199254740993}`, 3},
+		{"yaml v1 without schema_version", YAML, "name: gear\ntags: a,b\nsize: 9007199254740993\n", 1},
+		{"yaml v2", YAML, "schema_version: 2\ntitle: gear\ntags: a,b\nsize: 9007199254740993\n", 2},
+		{"yaml v3", YAML, "schema_version: 3\ntitle: gear\ntags: [a, b]\nsize: 9007199254740993\n", 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			doc, err := widgetKind(test.format).Parse("w", []byte(test.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if doc.Version != test.version || doc.Newer() || doc.Writable() != nil || doc.Current() != (test.version == 3) {
+				t.Errorf("version %d, newer %v, current %v", doc.Version, doc.Newer(), doc.Current())
+			}
+
+			// Every version decodes to the same widget, the large integer included
+			var w struct {
+				SchemaVersion int      `json:"schema_version" yaml:"schema_version"`
+				Title         string   `json:"title" yaml:"title"`
+				Tags          []string `json:"tags" yaml:"tags"`
+				Size          uint64   `json:"size" yaml:"size"`
+				Name          string   `json:"name" yaml:"name"`
+			}
+			if err := doc.Decode(&w); err != nil {
+				t.Fatal(err)
+			}
+			if w.SchemaVersion != 3 || w.Title != "gear" || !reflect.DeepEqual(w.Tags, []string{"a", "b"}) || w.Size != 9007199254740993 || w.Name != "" {
+				t.Errorf("decoded %+v", w)
+			}
+		})
+	}
+}
+
+func TestMigrationFailures(t *testing.T) {
+	k := widgetKind(JSON)
+	if _, err := k.Parse("w.json", []byte(`{"schema_version":2,"title":"gear","tags":7}`)); err == nil ||
+		err.Error() != "'w.json': migrating widget schema version 2 to 3: tags is not a string" {
+		t.Errorf("failing migration = %v", err)
+	}
+
+	delete(k.Migrations, 2)
+	if _, err := k.Parse("w.json", []byte(`{"name":"gear","tags":"a"}`)); err == nil ||
+		err.Error() != "'w.json': no migration of widget schema version 2 to 3" {
+		t.Errorf("missing migration = %v", err)
+	}
+}
+
+func TestNewerFiles(t *testing.T) {
+	for _, format := range []Format{JSON, YAML} {
+		data := `{"schema_version":4,"title":"gear","shape":"round"}`
+		if format == YAML {
+			data = "schema_version: 4\ntitle: gear\nshape: round\n"
+		}
+		doc, err := widgetKind(format).Parse("w", []byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Nothing is migrated, and every field is kept for reading
+		if !doc.Newer() || doc.Version != 4 || doc.Values["shape"] != "round" {
+			t.Errorf("newer doc = %+v", doc)
+		}
+
+		err = doc.Writable()
+		var newer *NewerError
+		if !errors.As(err, &newer) || clierr.CodeOf(err) != clierr.Conflict ||
+			err.Error() != "'w' was written by a newer gsn (widget schema version 4, this gsn supports up to 3), upgrade gsn to change it" {
+			t.Errorf("Writable = %v", err)
+		}
+	}
+}
+
+func TestCorruptFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  Format
+		data    string
+		wantErr string
+	}{
+		{"truncated json", JSON, `{"schema_version":3,"title":`, "unexpected EOF"},
+		{"json list", JSON, `["title"]`, "cannot unmarshal array"},
+		{"bad yaml", YAML, "title: [gear\n", "yaml:"},
+		{"yaml scalar", YAML, "just text\n", "cannot unmarshal"},
+		{"version as text", JSON, `{"schema_version":"three","title":"gear"}`, "schema_version is three, not a number"},
+		{"version zero", JSON, `{"schema_version":0,"title":"gear"}`, "invalid schema_version 0"},
+		{"negative version", YAML, "schema_version: -2\ntitle: gear\n", "invalid schema_version -2"},
+		{"fractional version", JSON, `{"schema_version":2.5,"title":"gear"}`, "invalid schema_version 2.5"},
+		{"invalid after migrating", JSON, `{"name":"","tags":"a"}`, "the widget has no title"},
+		{"wrong type", JSON, `{"schema_version":3,"title":"gear","tags":"a,b"}`, "cannot unmarshal string"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := writeState(t, filepath.Join(t.TempDir(), "w.state"), test.data)
+			var w struct {
+				Title string   `json:"title" yaml:"title"`
+				Tags  []string `json:"tags" yaml:"tags"`
+			}
+			_, err := widgetKind(test.format).Load(path, &w)
+
+			var corrupt *CorruptError
+			if !errors.As(err, &corrupt) || !IsCorrupt(err) || corrupt.Path != path || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("Load = %v, want a CorruptError with %q", err, test.wantErr)
+			}
+			if want := "gsn state migrate --reset '" + path + "' moves it aside"; !strings.Contains(err.Error(), want) {
+				t.Errorf("no reset hint in %v", err)
+			}
+		})
+	}
+
+	// A missing file is not corrupt, and an empty one is an empty file of the current version
+	k := widgetKind(JSON)
+	if _, err := k.Read(filepath.Join(t.TempDir(), "missing")); !errors.Is(err, os.ErrNotExist) || IsCorrupt(err) {
+		t.Errorf("Read of a missing file = %v", err)
+	}
+	for _, data := range []string{"", " \n\t"} {
+		if doc, err := k.Parse("w", []byte(data)); err != nil || doc.Version != 3 || !doc.Current() || len(doc.Values) != 0 {
+			t.Errorf("Parse(%q) = %+v, %v", data, doc, err)
+		}
+	}
+}
+
+func TestMigrateFile(t *testing.T) {
+	k := widgetKind(JSON)
+	dir := t.TempDir()
+	v1 := `{"name":"gear","tags":"a,b"}`
+	path := writeState(t, filepath.Join(dir, "gear.widget.json"), v1)
+
+	out := captureOutput(t, func() {
+		if err := migrateFile(k, path, false); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if !strings.Contains(out, "Migrated '"+path+"' from widget schema version 1 to 3 (backup '"+path+".v1.bak')") {
+		t.Errorf("output = %q", out)
+	}
+	if backup, _ := os.ReadFile(path + ".v1.bak"); string(backup) != v1 {
+		t.Errorf("backup = %s", backup)
+	}
+	data, _ := os.ReadFile(path)
+	var values map[string]any
+	if err := json.Unmarshal(data, &values); err != nil || values["schema_version"] != 3.0 || values["title"] != "gear" || !reflect.DeepEqual(values["tags"], []any{"a", "b"}) {
+		t.Errorf("migrated file = %s", data)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o600 {
+		t.Errorf("migrated file mode %o", info.Mode().Perm())
+	}
+
+	// A current file is left as it is
+	if out := captureOutput(t, func() { migrateFile(k, path, false) }); out != "" {
+		t.Errorf("second migration printed %q", out)
+	}
+	if again, _ := os.ReadFile(path); string(again) != string(data) {
+		t.Errorf("second migration rewrote the file: %s", again)
+	}
+
+	// So is a newer one, and a missing one is skipped
+	newer := `{"schema_version":7,"title":"gear"}`
+	newerPath := writeState(t, filepath.Join(dir, "newer.widget.json"), newer)
+	if out := captureOutput(t, func() { migrateFile(k, newerPath, false) }); !strings.Contains(out, "Leaving '"+newerPath+"', it was written by a newer gsn (widget schema version 7)") {
+		t.Errorf("output = %q", out)
+	}
+	if kept, _ := os.ReadFile(newerPath); string(kept) != newer {
+		t.Errorf("newer file rewritten: %s", kept)
+	}
+	if err := migrateFile(k, filepath.Join(dir, "missing.widget.json"), false); err != nil {
+		t.Errorf("missing file = %v", err)
+	}
+
+	// A corrupted file fails, unless it is moved aside
+	corrupt := writeState(t, filepath.Join(dir, "bad.widget.json"), `{"title":`)
+	if err := migrateFile(k, corrupt, false); !IsCorrupt(err) {
+		t.Errorf("corrupted file = %v", err)
+	}
+	captureOutput(t, func() {
+		if err := migrateFile(k, corrupt, true); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if _, err := os.Stat(corrupt); !errors.Is(err, os.ErrNotExist) {
+		t.Error("the corrupted file is still there")
+	}
+	if moved, _ := os.ReadFile(corrupt + ".corrupt.bak"); string(moved) != `{"title":` {
+		t.Errorf("moved aside = %q", moved)
+	}
+}
+
+func TestMigrateStampsYAMLKeepingComments(t *testing.T) {
+	k := &Kind{Name: "config", Format: YAML, Version: 1}
+	original := "# My settings\ngh:\n  # Used by approve\n  review_templates:\n    thanks: Thanks!\n"
+	path := writeState(t, filepath.Join(t.TempDir(), "config.yaml"), original)
+
+	out := captureOutput(t, func() {
+		if err := migrateFile(k, path, false); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if !strings.Contains(out, "Added schema_version 1 to '"+path+"'") {
+		t.Errorf("output = %q", out)
+	}
+	// The comment stays with the key it was written above
+	want := "schema_version: 1\n# My settings\ngh:\n  # Used by approve\n  review_templates:\n    thanks: Thanks!\n"
+	if data, _ := os.ReadFile(path); string(data) != want {
+		t.Errorf("stamped file =\n%s\nwant\n%s", data, want)
+	}
+	if backup, _ := os.ReadFile(path + ".v1.bak"); string(backup) != original {
+		t.Errorf("backup = %s", backup)
+	}
+}
+
+func TestKindOfAndCheck(t *testing.T) {
+	dir := t.TempDir()
+	good := writeState(t, filepath.Join(dir, "queue-1"), `{"schema_version":3,"title":"gear","tags":[]}`)
+	old := writeState(t, filepath.Join(dir, "queue-2"), `{"name":"gear","tags":"a"}`)
+	newer := writeState(t, filepath.Join(dir, "queue-3"), `{"schema_version":9}`)
+	broken := writeState(t, filepath.Join(dir, "queue-4"), `{`)
+	queue := &Kind{Name: "queue", Format: JSON, Version: 3, Migrations: widgetKind(JSON).Migrations,
+		Files: func() ([]string, error) {
+			return []string{good, old, newer, broken, filepath.Join(dir, "queue-5")}, nil
+		}}
+	widget := widgetKind(JSON)
+	useKinds(t, queue, widget)
+
+	// Listed files belong to their kind whatever their name, others are found by pattern
+	for path, want := range map[string]*Kind{good: queue, "x/gear.widget.yaml": widget} {
+		if k, err := KindOf(path); k != want || err != nil {
+			t.Errorf("KindOf(%s) = %v, %v", path, k, err)
+		}
+	}
+	if _, err := KindOf("notes.txt"); err == nil || err.Error() != "'notes.txt' is not a file gsn keeps state in" {
+		t.Errorf("KindOf(notes.txt) = %v", err)
+	}
+
+	checked, problems := Check()
+	if checked != 4 || len(problems) != 2 || !IsCorrupt(problems[1]) {
+		t.Fatalf("Check = %d, %v", checked, problems)
+	}
+	var newerErr *NewerError
+	if !errors.As(problems[0], &newerErr) || newerErr.Path != newer {
+		t.Errorf("first problem = %v", problems[0])
+	}
+}
+
+// captureOutput runs f with os.Stdout and os.Stderr sent to a temp file and returns what f wrote
+func captureOutput(t *testing.T, f func()) string {
+	t.Helper()
+	file, err := os.CreateTemp(t.TempDir(), "output-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = file, file
+	defer func() { os.Stdout, os.Stderr = stdout, stderr }()
+
+	f()
+
+	data, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
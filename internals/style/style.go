@@ -0,0 +1,82 @@
+package style
+
+import (
+	"os"
+
+	"gsn-dev-tools/internals/redact"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// plain disables emojis and ANSI colors for every decorated output
+var plain bool
+
+// AddFlag registers the persistent --no-color flag on the root command
+func AddFlag(cmd *cobra.Command) {
+	cmd.PersistentFlags().Bool("no-color", false, "Disable colors and emojis (also honors NO_COLOR)")
+}
+
+// Configure switches to plain output when --no-color is set, NO_COLOR is present or stdout is not a terminal
+func Configure(cmd *cobra.Command) {
+	noColor, _ := cmd.Flags().GetBool("no-color")
+	_, noColorEnv := os.LookupEnv("NO_COLOR")
+	SetPlain(noColor || noColorEnv || !term.IsTerminal(redact.Fd(os.Stdout)))
+}
+
+// SetPlain forces plain output on or off
+func SetPlain(p bool) {
+	plain = p
+}
+
+// Plain reports whether output must stay plain ASCII without colors
+func Plain() bool {
+	return plain
+}
+
+func pick(decorated string, ascii string) string {
+	if plain {
+		return ascii
+	}
+	return decorated
+}
+
+// Success prefixes messages reporting a completed operation
+func Success() string { return pick("✅ ", "OK: ") }
+
+// Celebrate prefixes messages reporting a completed remote operation
+func Celebrate() string { return pick("🎉 ", "OK: ") }
+
+// Error prefixes fatal error messages
+func Error() string { return pick("☠️ ", "ERROR: ") }
+
+// Failure prefixes messages reporting a failed operation
+func Failure() string { return pick("❌ ", "ERROR: ") }
+
+// Warning prefixes non fatal warnings
+func Warning() string { return pick("⚠️ ", "WARNING: ") }
+
+// Package prefixes progress descriptions for archive operations
+func Package() string { return pick("📦 ", "") }
+
+// Trash prefixes messages about removed files
+func Trash() string { return pick("🗑️ ", "") }
+
+func colorize(code string, s string) string {
+	if plain {
+		return s
+	}
+	return "\x1b[" + code + "m" + s + "\x1b[0m"
+}
+
+// Bold renders s in bold
+func Bold(s string) string { return colorize("1", s) }
+
+// Red renders s in red
+func Red(s string) string { return colorize("31", s) }
+
+// Green renders s in green
+func Green(s string) string { return colorize("32", s) }
+
+// Yellow renders s in yellow
+func Yellow(s string) string { return colorize("33", s) }
@@ -0,0 +1,54 @@
+package style
+
+import (
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestPrefixesAndColors(t *testing.T) {
+	defer SetPlain(plain)
+
+	SetPlain(false)
+	decorated := []string{Success(), Error(), Warning(), Failure(), Bold("b"), Red("r")}
+	want := []string{"✅ ", "☠️ ", "⚠️ ", "❌ ", "\x1b[1mb\x1b[0m", "\x1b[31mr\x1b[0m"}
+	for i := range want {
+		if decorated[i] != want[i] {
+			t.Errorf("decorated %d = %q, want %q", i, decorated[i], want[i])
+		}
+	}
+
+	SetPlain(true)
+	plainOut := []string{Success(), Celebrate(), Error(), Failure(), Warning(), Package(), Trash(), Bold("b"), Green("g"), Yellow("y")}
+	want = []string{"OK: ", "OK: ", "ERROR: ", "ERROR: ", "WARNING: ", "", "", "b", "g", "y"}
+	for i := range want {
+		if plainOut[i] != want[i] {
+			t.Errorf("plain %d = %q, want %q", i, plainOut[i], want[i])
+		}
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	defer SetPlain(plain)
+	parse := func(args ...string) *cobra.Command {
+		cmd := &cobra.Command{Use: "gsn"}
+		AddFlag(cmd)
+		if err := cmd.ParseFlags(args); err != nil {
+			t.Fatal(err)
+		}
+		return cmd
+	}
+
+	t.Setenv("NO_COLOR", "")
+	SetPlain(false)
+	Configure(parse())
+	if !Plain() {
+		t.Error("NO_COLOR set to an empty value did not switch to plain output")
+	}
+
+	SetPlain(false)
+	Configure(parse("--no-color"))
+	if !Plain() {
+		t.Error("--no-color did not switch to plain output")
+	}
+}
@@ -0,0 +1,213 @@
+package timeconv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/output"
+	"gsn-dev-tools/internals/redact"
+
+	"github.com/spf13/cobra"
+)
+
+// row is a time shown in one zone
+type row struct {
+	Zone   string `json:"zone"`
+	Time   string `json:"time"`
+	Offset string `json:"offset"`
+	Abbrev string `json:"abbreviation"`
+	DST    bool   `json:"dst"`
+	// Days is the difference between the date in the zone and the date in the first row
+	Days int `json:"day_offset"`
+
+	t time.Time
+}
+
+// timeColumns declares the columns available to `time`
+var timeColumns = []output.Column[row]{
+	{Name: "zone", Value: func(r row) any { return r.Zone }},
+	{Name: "time", Value: func(r row) any { return r.t }, Display: func(r row) string { return r.t.Format("Mon 2006-01-02 15:04:05") }},
+	{Name: "offset", Value: func(r row) any { return r.Offset }},
+	{Name: "abbr", Value: func(r row) any { return r.Abbrev }},
+	{Name: "day", Value: func(r row) any { return r.Days }, Display: func(r row) string {
+		if r.Days == 0 {
+			return ""
+		}
+		return fmt.Sprintf("%+d", r.Days)
+	}},
+}
+
+func TimeCmd() *cobra.Command {
+	timeCmd := &cobra.Command{
+		Use:   "time <time>",
+		Short: "Converts a time between time zones and Unix timestamps",
+		Long: `Shows a time in the zones of --to. The time is read as a wall time of --from, the local zone by default, in
+one of these layouts: 2024-06-01 15:00, 2024-06-01T15:00:05, 2024/06/01 15:00, Jun 1 2024 3:04pm,
+1 Jun 2024 15:00, 2024-06-01 or, for today, 15:00 and 3pm. ISO 8601 times with an offset, like
+2024-06-01T15:00:00Z or 2024-06-01T15:00+02:00, and Unix timestamps in seconds, milliseconds, microseconds or
+nanoseconds carry their own zone and ignore --from.
+
+Zones are IANA names like Europe/Berlin, UTC, local or offsets like +05:30 and UTC-8. A wall time the clocks skip
+or repeat at a DST transition is refused, listing the instants it could be, rather than shifted: add its offset
+to pick one.`,
+		Example: `  gsn time "2024-06-01 15:00" --from America/New_York --to UTC,Europe/Berlin,Asia/Kolkata
+  gsn time 1717251600
+  gsn time 3pm --to America/Los_Angeles
+  gsn time 2024-06-01T15:00:00Z --json`,
+		Args: cobra.ExactArgs(1),
+		Run:  ConvertTime,
+	}
+
+	timeCmd.Flags().String("from", "local", "Zone the time is read in")
+	timeCmd.Flags().StringSlice("to", []string{"UTC", "local"}, "Comma separated zones to show the time in")
+	timeCmd.Flags().Bool("json", false, "Print the time in each zone as JSON")
+	output.AddFlags(timeCmd)
+
+	timeCmd.AddCommand(nowCmd())
+	dryrun.ReadOnly(timeCmd)
+	return timeCmd
+}
+
+func nowCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "now",
+		Short: "Shows the current time in several time zones",
+		Long:  `Shows the current time in the local zone and the zones of --in, and the Unix timestamp.`,
+		Example: `  gsn time now --in America/New_York,Europe/Berlin,Asia/Tokyo
+  gsn time now --json`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			zones, _ := cmd.Flags().GetStringSlice("in")
+			show(cmd, time.Now(), "local", append([]string{"local"}, zones...))
+		},
+	}
+
+	cmd.Flags().StringSlice("in", []string{"UTC"}, "Comma separated zones to show the time in")
+	cmd.Flags().Bool("json", false, "Print the time in each zone as JSON")
+	output.AddFlags(cmd)
+	dryrun.ReadOnly(cmd)
+	return cmd
+}
+
+func ConvertTime(cmd *cobra.Command, args []string) {
+	from, _ := cmd.Flags().GetString("from")
+	to, _ := cmd.Flags().GetStringSlice("to")
+
+	loc, err := LoadZone(from)
+	if err != nil {
+		clierr.Exitf(clierr.Usage, "%v", err)
+	}
+	t, zoned, err := Parse(args[0], loc, time.Now())
+	var wallErr *WallTimeError
+	switch {
+	case errors.As(err, &wallErr):
+		clierr.Exitf(clierr.Failure, "%v", err)
+	case err != nil:
+		clierr.Exitf(clierr.Usage, "%v", err)
+	}
+
+	source := from
+	if zoned {
+		source = zoneName(t.Location())
+		if source == "" {
+			source = formatOffset(zoneOffset(t))
+		}
+	} else {
+		t = t.In(loc)
+	}
+	show(cmd, t, source, append([]string{source}, to...))
+}
+
+// show prints t in every zone, the first one being the reference of the day offsets
+func show(cmd *cobra.Command, t time.Time, source string, zones []string) {
+	asJSON, _ := cmd.Flags().GetBool("json")
+	opts, err := output.OptionsFromFlags(cmd)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	if asJSON && opts.Format != output.FormatTable {
+		clierr.Exitf(clierr.Usage, "--json cannot be combined with --csv or --tsv")
+	}
+
+	var rows []row
+	seen := map[string]bool{}
+	for _, name := range zones {
+		loc, err := LoadZone(name)
+		if err != nil {
+			clierr.Exitf(clierr.Usage, "%v", err)
+		}
+		if name == source {
+			// A zoned input shows in its own offset, which LoadZone would turn into a fixed zone anyway
+			loc = t.Location()
+		}
+		in := t.In(loc)
+		abbrev, offset := in.Zone()
+		label := zoneName(loc)
+		if label == "" {
+			label = name
+		}
+		if seen[label+abbrev] {
+			continue
+		}
+		seen[label+abbrev] = true
+		rows = append(rows, row{Zone: label, Time: in.Format(time.RFC3339Nano), Offset: formatOffset(offset), Abbrev: abbrev, DST: in.IsDST(), t: in})
+	}
+	for i := range rows {
+		rows[i].Days = dayDiff(rows[0].t, rows[i].t)
+	}
+
+	if asJSON {
+		data, err := json.MarshalIndent(struct {
+			Unix  int64 `json:"unix"`
+			Zones []row `json:"zones"`
+		}{t.Unix(), rows}, "", "  ")
+		if err != nil {
+			clierr.Fatalf("%v", err)
+		}
+		redact.JSONOutput()
+		fmt.Println(string(data))
+		return
+	}
+	if err := output.Sort(timeColumns, rows, opts); err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	if err := output.Render(os.Stdout, timeColumns, rows, opts); err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	if opts.Format == output.FormatTable {
+		fmt.Printf("\nUnix %d\n", t.Unix())
+	}
+}
+
+// zoneName names a location for display, local with the zone it stands for when known
+func zoneName(loc *time.Location) string {
+	switch name := loc.String(); name {
+	case "Local":
+		if tz := os.Getenv("TZ"); tz != "" {
+			return "local (" + tz + ")"
+		}
+		if target, err := os.Readlink("/etc/localtime"); err == nil {
+			if _, zone, ok := strings.Cut(target, "zoneinfo/"); ok {
+				return "local (" + zone + ")"
+			}
+		}
+		return "local"
+	default:
+		return name
+	}
+}
+
+// dayDiff counts the calendar days from the date of a to the date of b, each on its own wall clock
+func dayDiff(a time.Time, b time.Time) int {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	da := time.Date(ay, am, ad, 0, 0, 0, 0, time.UTC)
+	db := time.Date(by, bm, bd, 0, 0, 0, 0, time.UTC)
+	return int(db.Sub(da).Hours() / 24)
+}
@@ -0,0 +1,207 @@
+// Package timeconv converts times between time zones and from and to Unix timestamps
+package timeconv
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// zonedLayouts carry their own offset, the source zone does not apply to them
+var zonedLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04Z07:00",
+	"20060102T150405Z0700",
+	time.RFC1123Z,
+	time.RFC822Z,
+}
+
+// wallLayouts are read as a wall time of the source zone. The text is lowercased for them, am and pm being lower
+// case in the layouts, so the T of ISO 8601 is a t here.
+var wallLayouts = []string{
+	"2006-01-02t15:04:05.999999999",
+	"2006-01-02t15:04",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04",
+	"2006-01-02 3:04pm",
+	"2006-01-02 3pm",
+	"2006-01-02",
+	"20060102t150405",
+	"2006/01/02 15:04:05",
+	"2006/01/02 15:04",
+	"2006/01/02",
+	"Jan 2 2006 15:04",
+	"Jan 2 2006 3:04pm",
+	"Jan 2, 2006 15:04",
+	"Jan 2, 2006 3:04pm",
+	"2 Jan 2006 15:04",
+	"2 Jan 2006 3:04pm",
+}
+
+// clockLayouts only have a time of day, the date is today in the source zone
+var clockLayouts = []string{
+	"15:04:05",
+	"15:04",
+	"3:04pm",
+	"3pm",
+}
+
+var epochPattern = regexp.MustCompile(`^-?\d+(\.\d+)?$`)
+
+// offsetPattern matches the fixed offsets accepted as zones: +05:30, -0800, UTC+2, GMT-03:00
+var offsetPattern = regexp.MustCompile(`^(?i:UTC|GMT)?([+-])(\d{1,2})(?::?(\d{2}))?$`)
+
+// LoadZone reads an IANA zone name like Europe/Berlin, UTC, local or a fixed offset like +05:30 or UTC-8
+func LoadZone(name string) (*time.Location, error) {
+	switch strings.ToLower(name) {
+	case "", "local":
+		return time.Local, nil
+	case "utc", "z", "gmt":
+		return time.UTC, nil
+	}
+	if m := offsetPattern.FindStringSubmatch(name); m != nil {
+		hours, _ := strconv.Atoi(m[2])
+		minutes, _ := strconv.Atoi(m[3])
+		if hours > 14 || minutes > 59 {
+			return nil, fmt.Errorf("offset '%s' is out of range", name)
+		}
+		seconds := hours*3600 + minutes*60
+		if m[1] == "-" {
+			seconds = -seconds
+		}
+		return time.FixedZone("", seconds), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone '%s', expected an IANA name like Europe/Berlin, UTC, local or an offset like +05:30", name)
+	}
+	return loc, nil
+}
+
+// ParseEpoch reads a Unix timestamp in seconds, milliseconds, microseconds or nanoseconds, told apart by their
+// number of digits the way current timestamps have 10, 13, 16 and 19
+func ParseEpoch(text string) (time.Time, bool) {
+	if !epochPattern.MatchString(text) {
+		return time.Time{}, false
+	}
+	whole, fraction, _ := strings.Cut(strings.TrimPrefix(text, "-"), ".")
+	if fraction != "" || len(whole) <= 11 {
+		seconds, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		sec := int64(seconds)
+		return time.Unix(sec, int64((seconds-float64(sec))*1e9)).UTC(), true
+	}
+	n, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	switch {
+	case len(whole) <= 14:
+		return time.UnixMilli(n).UTC(), true
+	case len(whole) <= 17:
+		return time.UnixMicro(n).UTC(), true
+	}
+	return time.Unix(0, n).UTC(), true
+}
+
+// WallTimeError reports a wall time a zone skips, or has twice, around a DST transition
+type WallTimeError struct {
+	Wall       string
+	Zone       string
+	Candidates []time.Time
+}
+
+func (e *WallTimeError) Error() string {
+	if len(e.Candidates) == 0 {
+		return fmt.Sprintf("%s does not exist in %s, the clocks skip it", e.Wall, e.Zone)
+	}
+	options := make([]string, len(e.Candidates))
+	for i, c := range e.Candidates {
+		options[i] = fmt.Sprintf("%s (%s)", c.Format("2006-01-02T15:04:05-07:00"), c.Format("MST"))
+	}
+	return fmt.Sprintf("%s happens twice in %s: %s, add the offset to pick one", e.Wall, e.Zone, strings.Join(options, " or "))
+}
+
+// Parse reads text as a timestamp carrying its offset, a Unix timestamp, or a wall time of loc. now gives the date
+// of times of day. A wall time loc skips or has twice is a *WallTimeError rather than shifted silently.
+func Parse(text string, loc *time.Location, now time.Time) (t time.Time, zoned bool, err error) {
+	text = strings.TrimSpace(text)
+	if t, ok := ParseEpoch(text); ok {
+		return t, true, nil
+	}
+	for _, layout := range zonedLayouts {
+		if t, err := time.Parse(layout, text); err == nil {
+			return t, true, nil
+		}
+	}
+	lower := strings.ToLower(text)
+	for _, layout := range wallLayouts {
+		if t, err := time.Parse(layout, lower); err == nil {
+			t, err = resolveWall(t, loc)
+			return t, false, err
+		}
+	}
+	for _, layout := range clockLayouts {
+		if t, err := time.Parse(layout, lower); err == nil {
+			y, m, d := now.In(loc).Date()
+			wall := time.Date(y, m, d, t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+			t, err = resolveWall(wall, loc)
+			return t, false, err
+		}
+	}
+	return time.Time{}, false, fmt.Errorf("cannot read '%s' as a time, use e.g. \"2024-06-01 15:00\", 2024-06-01T15:00:00Z, 3pm or a Unix timestamp", text)
+}
+
+// resolveWall places the wall time held in the fields of wall in loc, refusing one the zone skips or repeats
+func resolveWall(wall time.Time, loc *time.Location) (time.Time, error) {
+	y, mo, d := wall.Date()
+	h, mi, s := wall.Clock()
+	ns := wall.Nanosecond()
+	guess := time.Date(y, mo, d, h, mi, s, ns, loc)
+
+	// The wall time can only exist with the offsets of the zone period it fell in and of its neighbours
+	start, end := guess.ZoneBounds()
+	offsets := []int{zoneOffset(guess)}
+	if !start.IsZero() {
+		offsets = append(offsets, zoneOffset(start.Add(-time.Nanosecond)))
+	}
+	if !end.IsZero() {
+		offsets = append(offsets, zoneOffset(end))
+	}
+
+	wallUTC := time.Date(y, mo, d, h, mi, s, ns, time.UTC)
+	var candidates []time.Time
+	for _, off := range offsets {
+		c := wallUTC.Add(-time.Duration(off) * time.Second).In(loc)
+		if zoneOffset(c) != off || slices.ContainsFunc(candidates, c.Equal) {
+			continue
+		}
+		candidates = append(candidates, c)
+	}
+	if len(candidates) == 1 {
+		return candidates[0], nil
+	}
+	slices.SortFunc(candidates, time.Time.Compare)
+	return time.Time{}, &WallTimeError{Wall: wall.Format("2006-01-02 15:04:05"), Zone: loc.String(), Candidates: candidates}
+}
+
+func zoneOffset(t time.Time) int {
+	_, off := t.Zone()
+	return off
+}
+
+// formatOffset renders an offset in seconds as +05:30
+func formatOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign, seconds = "-", -seconds
+	}
+	return fmt.Sprintf("%s%02d:%02d", sign, seconds/3600, seconds%3600/60)
+}
@@ -0,0 +1,243 @@
+package timeconv
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := LoadZone(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestLoadZone(t *testing.T) {
+	tests := []struct {
+		name   string
+		offset int
+	}{
+		{"UTC", 0},
+		{"z", 0},
+		{"GMT", 0},
+		{"+05:30", 5*3600 + 1800},
+		{"-0800", -8 * 3600},
+		{"UTC+2", 2 * 3600},
+		{"utc-8", -8 * 3600},
+		{"GMT-03:00", -3 * 3600},
+		{"+14", 14 * 3600},
+		{"-00:45", -45 * 60},
+	}
+	winter := time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC)
+	for _, test := range tests {
+		loc, err := LoadZone(test.name)
+		if err != nil {
+			t.Errorf("LoadZone(%q) = %v", test.name, err)
+			continue
+		}
+		if _, offset := winter.In(loc).Zone(); offset != test.offset {
+			t.Errorf("LoadZone(%q) has offset %d, want %d", test.name, offset, test.offset)
+		}
+	}
+	for _, name := range []string{"", "local", "Local"} {
+		if loc, err := LoadZone(name); err != nil || loc != time.Local {
+			t.Errorf("LoadZone(%q) = %v, %v, want the local zone", name, loc, err)
+		}
+	}
+	if loc, err := LoadZone("Europe/Berlin"); err != nil || loc.String() != "Europe/Berlin" {
+		t.Errorf("LoadZone(Europe/Berlin) = %v, %v", loc, err)
+	}
+
+	errs := map[string]string{
+		"+15":          "offset '+15' is out of range",
+		"UTC+02:60":    "offset 'UTC+02:60' is out of range",
+		"Mars/Olympus": "unknown time zone 'Mars/Olympus', expected an IANA name like Europe/Berlin, UTC, local or an offset like +05:30",
+		"+5:3":         "unknown time zone '+5:3', expected an IANA name like Europe/Berlin, UTC, local or an offset like +05:30",
+		"EST5EDT+1":    "unknown time zone 'EST5EDT+1', expected an IANA name like Europe/Berlin, UTC, local or an offset like +05:30",
+	}
+	for name, want := range errs {
+		if _, err := LoadZone(name); err == nil || err.Error() != want {
+			t.Errorf("LoadZone(%q) = %v, want %q", name, err, want)
+		}
+	}
+}
+
+func TestParseEpoch(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"0", "1970-01-01T00:00:00Z"},
+		{"1717254000", "2024-06-01T15:00:00Z"},
+		{"-86400", "1969-12-31T00:00:00Z"},
+		{"1717254000.25", "2024-06-01T15:00:00.25Z"},
+		// Told apart by their digits: 11 are still seconds, then milliseconds, microseconds and nanoseconds
+		{"99999999999", "5138-11-16T09:46:39Z"},
+		{"1717254000123", "2024-06-01T15:00:00.123Z"},
+		{"1717254000123456", "2024-06-01T15:00:00.123456Z"},
+		{"1717254000123456789", "2024-06-01T15:00:00.123456789Z"},
+	}
+	for _, test := range tests {
+		got, ok := ParseEpoch(test.text)
+		if !ok || got.Format(time.RFC3339Nano) != test.want || got.Location() != time.UTC {
+			t.Errorf("ParseEpoch(%q) = %s, %v, want %s", test.text, got, ok, test.want)
+		}
+	}
+	for _, text := range []string{"", "1e9", "17172540O0", "+1717254000", "1717254000.", "99999999999999999999"} {
+		if got, ok := ParseEpoch(text); ok {
+			t.Errorf("ParseEpoch(%q) = %s, want no timestamp", text, got)
+		}
+	}
+}
+
+func TestParseLayouts(t *testing.T) {
+	berlin := mustLoad(t, "Europe/Berlin")
+	now := time.Date(2024, 6, 1, 22, 30, 0, 0, time.UTC)
+	tests := []struct {
+		text  string
+		want  string
+		zoned bool
+	}{
+		// Offsets win over the source zone
+		{"2024-06-01T15:00:00Z", "2024-06-01T15:00:00Z", true},
+		{"2024-06-01T15:00:00.5+05:30", "2024-06-01T15:00:00.5+05:30", true},
+		{"2024-06-01T15:00-04:00", "2024-06-01T15:00:00-04:00", true},
+		{"2024-06-01 15:00:05+02:00", "2024-06-01T15:00:05+02:00", true},
+		{"20240601T150000Z", "2024-06-01T15:00:00Z", true},
+		{"Sat, 01 Jun 2024 15:00:00 +0100", "2024-06-01T15:00:00+01:00", true},
+		{"1717254000", "2024-06-01T15:00:00Z", true},
+		// Wall times of Berlin, on summer time
+		{"2024-06-01T15:00:05", "2024-06-01T15:00:05+02:00", false},
+		{"2024-06-01 15:00", "2024-06-01T15:00:00+02:00", false},
+		{"2024-06-01 3:04PM", "2024-06-01T15:04:00+02:00", false},
+		{"2024-06-01 3pm", "2024-06-01T15:00:00+02:00", false},
+		{"2024-06-01", "2024-06-01T00:00:00+02:00", false},
+		{"20240601T150000", "2024-06-01T15:00:00+02:00", false},
+		{"2024/06/01 15:00", "2024-06-01T15:00:00+02:00", false},
+		{"Jun 1 2024 15:00", "2024-06-01T15:00:00+02:00", false},
+		{"jun 1, 2024 3:04pm", "2024-06-01T15:04:00+02:00", false},
+		{"1 Jun 2024 15:00", "2024-06-01T15:00:00+02:00", false},
+		{"  2024-01-15 09:00  ", "2024-01-15T09:00:00+01:00", false},
+		// Times of day take the date of now in the source zone, already the 2nd in Berlin
+		{"15:00", "2024-06-02T15:00:00+02:00", false},
+		{"15:00:30", "2024-06-02T15:00:30+02:00", false},
+		{"3:04pm", "2024-06-02T15:04:00+02:00", false},
+		{"12AM", "2024-06-02T00:00:00+02:00", false},
+	}
+	for _, test := range tests {
+		got, zoned, err := Parse(test.text, berlin, now)
+		if err != nil || got.Format(time.RFC3339Nano) != test.want || zoned != test.zoned {
+			t.Errorf("Parse(%q) = %s, zoned %v, %v\nwant %s, zoned %v", test.text, got.Format(time.RFC3339Nano), zoned, err, test.want, test.zoned)
+		}
+	}
+
+	for _, text := range []string{"", "tomorrow", "2024-13-01", "25:00", "2024-02-30 10:00"} {
+		_, _, err := Parse(text, berlin, now)
+		if err == nil || !strings.HasPrefix(err.Error(), "cannot read '"+text+"' as a time, use e.g.") {
+			t.Errorf("Parse(%q) = %v", text, err)
+		}
+	}
+}
+
+func TestParseAroundDST(t *testing.T) {
+	tests := []struct {
+		zone string
+		wall string
+		// want is the instant, or the error listing what the wall time could be
+		want string
+		err  string
+	}{
+		// New York springs forward on 10 March 2024 at 02:00 EST, falls back on 3 November at 02:00 EDT
+		{"America/New_York", "2024-03-10 01:59:59", "2024-03-10T01:59:59-05:00", ""},
+		{"America/New_York", "2024-03-10 02:00", "", "2024-03-10 02:00:00 does not exist in America/New_York, the clocks skip it"},
+		{"America/New_York", "2024-03-10 02:30", "", "2024-03-10 02:30:00 does not exist in America/New_York, the clocks skip it"},
+		{"America/New_York", "2024-03-10 03:00", "2024-03-10T03:00:00-04:00", ""},
+		{"America/New_York", "2024-11-03 00:59", "2024-11-03T00:59:00-04:00", ""},
+		{"America/New_York", "2024-11-03 01:00", "", "2024-11-03 01:00:00 happens twice in America/New_York: 2024-11-03T01:00:00-04:00 (EDT) or 2024-11-03T01:00:00-05:00 (EST), add the offset to pick one"},
+		{"America/New_York", "2024-11-03 01:59:59", "", "2024-11-03 01:59:59 happens twice in America/New_York: 2024-11-03T01:59:59-04:00 (EDT) or 2024-11-03T01:59:59-05:00 (EST), add the offset to pick one"},
+		{"America/New_York", "2024-11-03 02:00", "2024-11-03T02:00:00-05:00", ""},
+		// Berlin, east of UTC, springs forward on 31 March 2024 at 02:00 CET and falls back on 27 October at 03:00 CEST
+		{"Europe/Berlin", "2024-03-31 02:15", "", "2024-03-31 02:15:00 does not exist in Europe/Berlin, the clocks skip it"},
+		{"Europe/Berlin", "2024-03-31 03:00", "2024-03-31T03:00:00+02:00", ""},
+		{"Europe/Berlin", "2024-10-27 01:59", "2024-10-27T01:59:00+02:00", ""},
+		{"Europe/Berlin", "2024-10-27 02:30", "", "2024-10-27 02:30:00 happens twice in Europe/Berlin: 2024-10-27T02:30:00+02:00 (CEST) or 2024-10-27T02:30:00+01:00 (CET), add the offset to pick one"},
+		{"Europe/Berlin", "2024-10-27 03:00", "2024-10-27T03:00:00+01:00", ""},
+		// Lord Howe Island moves by half an hour
+		{"Australia/Lord_Howe", "2024-10-06 02:15", "", "2024-10-06 02:15:00 does not exist in Australia/Lord_Howe, the clocks skip it"},
+		{"Australia/Lord_Howe", "2024-10-06 02:30", "2024-10-06T02:30:00+11:00", ""},
+		{"Australia/Lord_Howe", "2024-04-07 01:45", "", "2024-04-07 01:45:00 happens twice in Australia/Lord_Howe: 2024-04-07T01:45:00+11:00 (+11) or 2024-04-07T01:45:00+10:30 (+1030), add the offset to pick one"},
+		{"Australia/Lord_Howe", "2024-04-07 01:29", "2024-04-07T01:29:00+11:00", ""},
+		// Samoa skipped the 30th of December 2011 crossing the date line
+		{"Pacific/Apia", "2011-12-30 12:00", "", "2011-12-30 12:00:00 does not exist in Pacific/Apia, the clocks skip it"},
+		{"Pacific/Apia", "2011-12-31 00:00", "2011-12-31T00:00:00+14:00", ""},
+		// Zones without DST and fixed offsets have no ambiguous times
+		{"Asia/Kolkata", "2024-03-10 02:30", "2024-03-10T02:30:00+05:30", ""},
+		{"UTC", "2024-11-03 01:30", "2024-11-03T01:30:00Z", ""},
+		{"-05:00", "2024-11-03 01:30", "2024-11-03T01:30:00-05:00", ""},
+	}
+	for _, test := range tests {
+		got, _, err := Parse(test.wall, mustLoad(t, test.zone), time.Now())
+		if test.err != "" {
+			var wallErr *WallTimeError
+			if !errors.As(err, &wallErr) || err.Error() != test.err {
+				t.Errorf("Parse(%q in %s) = %s, %v\nwant %s", test.wall, test.zone, got, err, test.err)
+			}
+			continue
+		}
+		if err != nil || got.Format(time.RFC3339) != test.want {
+			t.Errorf("Parse(%q in %s) = %s, %v, want %s", test.wall, test.zone, got.Format(time.RFC3339), err, test.want)
+		}
+	}
+}
+
+func TestParseTimeOfDayOnATransition(t *testing.T) {
+	// A time of day is checked against the transitions of the day it lands on
+	ny := mustLoad(t, "America/New_York")
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	_, _, err := Parse("2:30am", ny, now)
+	var wallErr *WallTimeError
+	if !errors.As(err, &wallErr) || len(wallErr.Candidates) != 0 || wallErr.Wall != "2024-03-10 02:30:00" {
+		t.Errorf("Parse(2:30am on the day New York springs forward) = %v", err)
+	}
+	if got, _, err := Parse("2:30am", ny, now.AddDate(0, 0, 1)); err != nil || got.Format(time.RFC3339) != "2024-03-11T02:30:00-04:00" {
+		t.Errorf("Parse(2:30am the next day) = %s, %v", got, err)
+	}
+}
+
+func TestDayDiffAndOffsets(t *testing.T) {
+	instant := time.Date(2024, 6, 1, 23, 30, 0, 0, time.UTC)
+	tests := []struct {
+		zone   string
+		days   int
+		offset string
+	}{
+		{"UTC", 0, "+00:00"},
+		{"Asia/Kolkata", 1, "+05:30"},
+		{"Pacific/Kiritimati", 1, "+14:00"},
+		{"America/Los_Angeles", 0, "-07:00"},
+		{"Pacific/Pago_Pago", 0, "-11:00"},
+		{"America/St_Johns", 0, "-02:30"},
+	}
+	for _, test := range tests {
+		in := instant.In(mustLoad(t, test.zone))
+		if days := dayDiff(instant, in); days != test.days {
+			t.Errorf("dayDiff(UTC, %s) = %d, want %d", test.zone, days, test.days)
+		}
+		if offset := formatOffset(zoneOffset(in)); offset != test.offset {
+			t.Errorf("offset of %s = %s, want %s", test.zone, offset, test.offset)
+		}
+	}
+	// Across a month and a year end, backwards too
+	a := time.Date(2024, 12, 30, 23, 30, 0, 0, mustLoad(t, "-11:00"))
+	if days := dayDiff(a, a.In(mustLoad(t, "+14:00"))); days != 2 {
+		t.Errorf("dayDiff from -11:00 to +14:00 = %d, want 2", days)
+	}
+	if days := dayDiff(a.In(mustLoad(t, "+14:00")), a); days != -2 {
+		t.Errorf("dayDiff from +14:00 to -11:00 = %d, want -2", days)
+	}
+}
@@ -0,0 +1,46 @@
+package tmpfs
+
+import (
+	"fmt"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/style"
+
+	"github.com/spf13/cobra"
+)
+
+func CleanTempCmd() *cobra.Command {
+	cleanCmd := cobra.Command{
+		Use:   "clean-temp",
+		Short: "Removes temp workspaces left behind by interrupted gsn runs",
+		Long:  "Sweeps gsn temp workspaces under the OS temp directory whose marker file is older than --older-than.",
+		Example: `  gsn clean-temp
+  gsn clean-temp --older-than 1h`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			olderThan, _ := cmd.Flags().GetDuration("older-than")
+
+			// Under --dry-run Sweep prints the plan and nothing was removed
+			removed, err := Sweep(olderThan)
+			if dryrun.Enabled() {
+				if err != nil {
+					clierr.Fatalf("%v", err)
+				}
+				return
+			}
+			for _, dir := range removed {
+				fmt.Printf("Removed %s\n", dir)
+			}
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			fmt.Printf(style.Success()+"Removed %d orphaned workspace(s)\n", len(removed))
+		},
+	}
+
+	cleanCmd.Flags().Duration("older-than", 24*time.Hour, "Only remove workspaces older than this")
+	dryrun.Adopt(&cleanCmd)
+	return &cleanCmd
+}
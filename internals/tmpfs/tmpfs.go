@@ -0,0 +1,182 @@
+package tmpfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/dryrun"
+)
+
+const (
+	// dirPrefix namespaces every workspace created under the OS temp root
+	dirPrefix = "gsn-"
+
+	// markerName identifies a directory as a gsn workspace so the sweeper never touches foreign dirs
+	markerName = ".gsn-workspace"
+)
+
+// marker is the content of the marker file written into each workspace
+type marker struct {
+	PID       int       `json:"pid"`
+	Namespace string    `json:"namespace"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Workspace is a temp directory owned by the current process
+type Workspace struct {
+	Dir  string
+	once sync.Once
+	err  error
+}
+
+var (
+	mu         sync.Mutex
+	active     = make(map[*Workspace]struct{})
+	partials   = make(map[string]struct{})
+	signalOnce sync.Once
+)
+
+// New creates a workspace under the OS temp root, e.g. /tmp/gsn-convert-123456.
+// Callers should defer Cleanup; workspaces left behind by an interrupted run are removed by
+// CleanupAll on SIGINT/SIGTERM or later by Sweep.
+func New(namespace string) (*Workspace, error) {
+	dir, err := os.MkdirTemp("", dirPrefix+namespace+"-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp workspace: %w", err)
+	}
+
+	data, _ := json.Marshal(marker{PID: os.Getpid(), Namespace: namespace, CreatedAt: time.Now().UTC()})
+	if err := os.WriteFile(filepath.Join(dir, markerName), data, 0o600); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to mark temp workspace: %w", err)
+	}
+
+	w := &Workspace{Dir: dir}
+	mu.Lock()
+	active[w] = struct{}{}
+	mu.Unlock()
+
+	signalOnce.Do(installSignalHandler)
+	return w, nil
+}
+
+// Path joins name to the workspace directory
+func (w *Workspace) Path(name string) string {
+	return filepath.Join(w.Dir, name)
+}
+
+// CreateFile creates a new temp file inside the workspace
+func (w *Workspace) CreateFile(pattern string) (*os.File, error) {
+	return os.CreateTemp(w.Dir, pattern)
+}
+
+// Cleanup removes the workspace, it is safe to call several times and from several goroutines
+func (w *Workspace) Cleanup() error {
+	w.once.Do(func() {
+		mu.Lock()
+		delete(active, w)
+		mu.Unlock()
+		w.err = os.RemoveAll(w.Dir)
+	})
+	return w.err
+}
+
+// CleanupAll removes every workspace and partial file still tracked by this process
+func CleanupAll() {
+	mu.Lock()
+	pending := make([]*Workspace, 0, len(active))
+	for w := range active {
+		pending = append(pending, w)
+	}
+	mu.Unlock()
+
+	for _, w := range pending {
+		_ = w.Cleanup()
+	}
+
+	mu.Lock()
+	files := make([]string, 0, len(partials))
+	for path := range partials {
+		files = append(files, path)
+	}
+	partials = make(map[string]struct{})
+	mu.Unlock()
+
+	for _, path := range files {
+		_ = os.Remove(path)
+	}
+}
+
+// TrackPartial registers a file that is being written outside a workspace so it is removed if the
+// process is interrupted. The returned func stops tracking it once the file is complete.
+func TrackPartial(path string) func() {
+	mu.Lock()
+	partials[path] = struct{}{}
+	mu.Unlock()
+
+	signalOnce.Do(installSignalHandler)
+	return func() {
+		mu.Lock()
+		delete(partials, path)
+		mu.Unlock()
+	}
+}
+
+// installSignalHandler removes tracked workspaces before the process dies from SIGINT or SIGTERM
+func installSignalHandler() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		CleanupAll()
+		if sig == os.Interrupt {
+			clierr.Exit(clierr.Interrupted)
+		}
+		clierr.Exit(clierr.Code(128 + int(syscall.SIGTERM)))
+	}()
+}
+
+// Sweep removes orphaned workspaces under the OS temp root whose marker is older than maxAge.
+// Workspaces owned by the current process are never removed.
+func Sweep(maxAge time.Duration) ([]string, error) {
+	root := os.TempDir()
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), dirPrefix) {
+			continue
+		}
+
+		dir := filepath.Join(root, entry.Name())
+		data, err := os.ReadFile(filepath.Join(dir, markerName))
+		if err != nil {
+			continue // not a gsn workspace
+		}
+
+		var m marker
+		if err := json.Unmarshal(data, &m); err != nil || m.PID == os.Getpid() {
+			continue
+		}
+		if time.Since(m.CreatedAt) < maxAge {
+			continue
+		}
+
+		if err := dryrun.Do("remove "+dir, func() error { return os.RemoveAll(dir) }); err != nil {
+			return removed, fmt.Errorf("failed to remove '%s': %w", dir, err)
+		}
+		removed = append(removed, dir)
+	}
+	return removed, nil
+}
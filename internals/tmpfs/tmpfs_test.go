@@ -0,0 +1,164 @@
+package tmpfs
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestMain lets a test start the test binary again as a child process owning a workspace, which prints the
+// workspace and waits to be killed
+func TestMain(m *testing.M) {
+	if os.Getenv("GSN_TMPFS_CHILD") == "1" {
+		w, err := New("child")
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println(w.Dir)
+		time.Sleep(time.Minute)
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// startChild starts a child process owning a workspace under tmp and returns it with the workspace
+func startChild(t *testing.T, tmp string) (*exec.Cmd, string) {
+	t.Helper()
+	child := exec.Command(os.Args[0])
+	child.Env = append(os.Environ(), "GSN_TMPFS_CHILD=1", "TMPDIR="+tmp)
+	stdout, err := child.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := child.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = child.Process.Kill(); _ = child.Wait() })
+	dir, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	return child, strings.TrimSpace(dir)
+}
+
+func TestSweepRemovesWorkspacesOfKilledProcesses(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	child, dir := startChild(t, tmp)
+	if _, err := os.Stat(filepath.Join(dir, markerName)); err != nil {
+		t.Fatalf("the child workspace has no marker: %v", err)
+	}
+	// SIGKILL runs no handler, the workspace stays behind
+	if err := child.Process.Kill(); err != nil {
+		t.Fatal(err)
+	}
+	_ = child.Wait()
+	if _, err := os.Stat(dir); err != nil {
+		t.Fatalf("the workspace of the killed child is gone already: %v", err)
+	}
+
+	// Foreign directories and files are left alone, as are workspaces younger than the limit
+	foreign := filepath.Join(tmp, dirPrefix+"foreign")
+	if err := os.Mkdir(foreign, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if removed, err := Sweep(time.Hour); err != nil || len(removed) > 0 {
+		t.Errorf("Sweep(1h) = %v, %v, want nothing removed yet", removed, err)
+	}
+
+	removed, err := Sweep(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0] != dir {
+		t.Errorf("Sweep = %v, want %s", removed, dir)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("the workspace is still there: %v", err)
+	}
+	if _, err := os.Stat(foreign); err != nil {
+		t.Errorf("the foreign directory was removed: %v", err)
+	}
+}
+
+func TestSweepKeepsOwnWorkspaces(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	w, err := New("own")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Cleanup()
+	if removed, err := Sweep(0); err != nil || len(removed) > 0 {
+		t.Errorf("Sweep = %v, %v, want the workspace of this process kept", removed, err)
+	}
+}
+
+func TestConcurrentWorkspaces(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	var wg sync.WaitGroup
+	dirs := make([]string, 32)
+	for i := range dirs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w, err := New("pool")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			dirs[i] = w.Dir
+			f, err := w.CreateFile("part-*")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			f.Close()
+			// Cleanup may race with itself and with CleanupAll
+			if i%2 == 0 {
+				go w.Cleanup()
+				_ = w.Cleanup()
+			}
+		}()
+	}
+	wg.Wait()
+	partial := filepath.Join(os.TempDir(), "archive.partial")
+	if err := os.WriteFile(partial, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	TrackPartial(partial)
+	CleanupAll()
+
+	for _, dir := range dirs {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("%s is left after CleanupAll: %v", dir, err)
+		}
+	}
+	if _, err := os.Stat(partial); !os.IsNotExist(err) {
+		t.Errorf("the partial file is left after CleanupAll: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(active) > 0 || len(partials) > 0 {
+		t.Errorf("%d workspaces and %d partial files are still tracked", len(active), len(partials))
+	}
+}
+
+func TestTrackPartialStops(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "done.tar.gz")
+	if err := os.WriteFile(path, []byte("complete"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	done := TrackPartial(path)
+	done()
+	CleanupAll()
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("a completed file was removed: %v", err)
+	}
+}
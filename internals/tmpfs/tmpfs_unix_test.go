@@ -0,0 +1,24 @@
+//go:build unix
+
+package tmpfs
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestInterruptRemovesWorkspace(t *testing.T) {
+	tmp := t.TempDir()
+	child, dir := startChild(t, tmp)
+	if err := child.Process.Signal(syscall.SIGINT); err != nil {
+		t.Fatal(err)
+	}
+	err := child.Wait()
+	if code := child.ProcessState.ExitCode(); code != 130 {
+		t.Errorf("the interrupted child exited %d (%v), want 130", code, err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("the workspace survived SIGINT: %v", err)
+	}
+}
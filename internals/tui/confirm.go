@@ -0,0 +1,25 @@
+package tui
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// stdin buffers os.Stdin once for every question, a reader per question would swallow the answers piped after
+// the first one
+var stdin = bufio.NewReader(os.Stdin)
+
+// Confirm asks a yes/no question on stdout and reads the answer from stdin, anything but y or yes means no
+func Confirm(question string) bool {
+	return confirm(stdin, os.Stdout, question)
+}
+
+func confirm(in *bufio.Reader, out io.Writer, question string) bool {
+	fmt.Fprintf(out, "%s [y/N]: ", question)
+	answer, _ := in.ReadString('\n')
+	a := strings.ToLower(strings.TrimSpace(answer))
+	return a == "y" || a == "yes"
+}
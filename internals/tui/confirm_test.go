@@ -0,0 +1,26 @@
+package tui
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestConfirm(t *testing.T) {
+	in := bufio.NewReader(strings.NewReader("y\n YES \nn\nyep\n\nYes"))
+	var out strings.Builder
+	var got []bool
+	for range 7 {
+		got = append(got, confirm(in, &out, "Delete?"))
+	}
+	// Answers piped one per line each answer their own question, the last one needs no line end
+	want := []bool{true, true, false, false, false, true, false}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("answer %d = %v, want %v", i+1, got[i], want[i])
+		}
+	}
+	if out.String() != strings.Repeat("Delete? [y/N]: ", 7) {
+		t.Errorf("prompts = %q", out.String())
+	}
+}
@@ -0,0 +1,99 @@
+package tui
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Scores added by Score, a substring match always outranks a scattered subsequence match
+const (
+	scoreSubstring   = 1000
+	scoreMatch       = 16
+	scoreConsecutive = 8
+	scoreBoundary    = 10
+	penaltyGap       = 1
+)
+
+// Match is a candidate accepted by Filter
+type Match struct {
+	// Index is the position of the candidate in the list given to Filter
+	Index int
+	Score int
+}
+
+// Score rates how well candidate matches query, ignoring case. Candidates containing the query as a
+// substring score highest, earlier and word aligned occurrences first. Otherwise the query must be a
+// subsequence of the candidate, rewarding consecutive runs and matches at word starts and penalising gaps.
+// An empty query matches everything with a score of 0.
+func Score(query string, candidate string) (int, bool) {
+	if query == "" {
+		return 0, true
+	}
+	q := []rune(strings.ToLower(query))
+	c := []rune(strings.ToLower(candidate))
+
+	if i := strings.Index(string(c), string(q)); i >= 0 {
+		at := utf8.RuneCountInString(string(c)[:i])
+		score := scoreSubstring - at
+		if isBoundary(c, at) {
+			score += scoreBoundary
+		}
+		return score, true
+	}
+
+	score, qi, last := 0, 0, -1
+	for ci := 0; ci < len(c) && qi < len(q); ci++ {
+		if c[ci] != q[qi] {
+			continue
+		}
+		score += scoreMatch
+		if last >= 0 && ci == last+1 {
+			score += scoreConsecutive
+		} else if last >= 0 {
+			score -= (ci - last - 1) * penaltyGap
+		}
+		if isBoundary(c, ci) {
+			score += scoreBoundary
+		}
+		last = ci
+		qi++
+	}
+	if qi < len(q) {
+		return 0, false
+	}
+	return score, true
+}
+
+// Filter returns the candidates matching query, best first. Ties go to the shorter candidate, then to
+// the one listed first. An empty query keeps the candidates in their order.
+func Filter(query string, candidates []string) []Match {
+	var matches []Match
+	for i, c := range candidates {
+		if score, ok := Score(query, c); ok {
+			matches = append(matches, Match{Index: i, Score: score})
+		}
+	}
+
+	if query == "" {
+		return matches
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		return len(candidates[a.Index]) < len(candidates[b.Index])
+	})
+	return matches
+}
+
+// isBoundary reports whether the rune at i starts a word: the first rune or one following a separator
+func isBoundary(runes []rune, i int) bool {
+	if i == 0 {
+		return true
+	}
+	prev := runes[i-1]
+	return !unicode.IsLetter(prev) && !unicode.IsDigit(prev)
+}
@@ -0,0 +1,82 @@
+package tui
+
+import (
+	"slices"
+	"testing"
+)
+
+// ranked returns the candidates matching query in the order Filter ranks them
+func ranked(query string, candidates []string) []string {
+	var out []string
+	for _, m := range Filter(query, candidates) {
+		out = append(out, candidates[m.Index])
+	}
+	return out
+}
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		query, candidate string
+		want             int
+		ok               bool
+	}{
+		{"", "anything", 0, true},
+		{"main", "main.go", scoreSubstring + scoreBoundary, true},
+		{"MAIN", "cmd/Main.go", scoreSubstring - 4 + scoreBoundary, true},
+		{"main", "domain.go", scoreSubstring - 2, true},
+		// m a i n at word starts, each after a gap of one
+		{"main", "m_a_i_n", 4*(scoreMatch+scoreBoundary) - 3*penaltyGap, true},
+		// a consecutive run
+		{"mn", "mnx", scoreSubstring + scoreBoundary, true},
+		{"mx", "mnx", 2*scoreMatch + scoreBoundary - penaltyGap, true},
+		{"mxn", "mnx", 0, false},
+		{"main", "manifest", 0, false},
+		{"é", "café.txt", scoreSubstring - 3, true},
+	}
+	for _, test := range tests {
+		got, ok := Score(test.query, test.candidate)
+		if got != test.want || ok != test.ok {
+			t.Errorf("Score(%q, %q) = %d, %v, want %d, %v", test.query, test.candidate, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestFilterRanking(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		candidates []string
+		want       []string
+	}{
+		{"empty query keeps the order", "", []string{"b", "a", "c"}, []string{"b", "a", "c"}},
+		{"substring before subsequence", "main",
+			[]string{"m_a_i_n.txt", "domain.go", "manifest", "cmd/main_test.go", "main.go"},
+			[]string{"main.go", "cmd/main_test.go", "domain.go", "m_a_i_n.txt"}},
+		{"earlier occurrence first", "log",
+			[]string{"xxlog", "xlog", "catalog.db"},
+			[]string{"xlog", "xxlog", "catalog.db"}},
+		{"word start first", "test",
+			[]string{"latest.txt", "my-test.txt"},
+			[]string{"my-test.txt", "latest.txt"}},
+		{"consecutive before scattered", "bkp",
+			[]string{"b-k-p", "bkp-2024", "backup"},
+			[]string{"bkp-2024", "b-k-p", "backup"}},
+		{"ties go to the shorter, then the first", "tar",
+			[]string{"tar.gz", "tar.zst", "tar.xz"},
+			[]string{"tar.gz", "tar.xz", "tar.zst"}},
+		{"case is ignored", "README",
+			[]string{"docs/readme.md", "ReadMe"},
+			[]string{"ReadMe", "docs/readme.md"}},
+		{"nothing matches", "zzz", []string{"a", "b"}, nil},
+		{"pull requests", "deps",
+			[]string{"owner/repo#9 Drop eslint plugins", "owner/repo#4 Update deps-pinning", "owner/dependabot#3 Fix", "owner/repo#12 Bump deps"},
+			[]string{"owner/repo#12 Bump deps", "owner/repo#4 Update deps-pinning", "owner/repo#9 Drop eslint plugins"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ranked(test.query, test.candidates); !slices.Equal(got, test.want) {
+				t.Errorf("Filter(%q) = %q, want %q", test.query, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package tui
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/redact"
+	"gsn-dev-tools/internals/style"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// ErrNotTerminal is returned by Pick when stdin or stderr is not a terminal
+var ErrNotTerminal = errors.New("--pick needs an interactive terminal, pass the argument instead")
+
+// ErrCancelled is returned by Pick when the list is closed with Esc or Ctrl-C
+var ErrCancelled = errors.New("nothing picked")
+
+// maxRows is how many candidates the list shows at once
+const maxRows = 10
+
+// Keys understood by the list, as read from a terminal in raw mode
+const (
+	keyCtrlC     = 3
+	keyCtrlG     = 7
+	keyBackspace = 8
+	keyTab       = 9
+	keyLF        = 10
+	keyCR        = 13
+	keyCtrlN     = 14
+	keyCtrlP     = 16
+	keyCtrlU     = 21
+	keyEsc       = 27
+	keyDelete    = 127
+)
+
+// AddFlag registers the --pick flag, usage says what is offered
+func AddFlag(cmd *cobra.Command, usage string) {
+	cmd.Flags().Bool("pick", false, usage)
+}
+
+// Requested reports whether --pick was set
+func Requested(cmd *cobra.Command) bool {
+	pick, _ := cmd.Flags().GetBool("pick")
+	return pick
+}
+
+// Args accepts no positional arguments with --pick and applies args otherwise
+func Args(args cobra.PositionalArgs) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, positional []string) error {
+		if !Requested(cmd) {
+			return args(cmd, positional)
+		}
+		if len(positional) > 0 {
+			return clierr.Newf(clierr.Usage, "--pick cannot be combined with arguments")
+		}
+		return nil
+	}
+}
+
+// Pick shows items in a fuzzy searchable list on the terminal and returns the index of the chosen one.
+// Typing filters the list, the arrow keys, Tab, Ctrl-N and Ctrl-P move the selection, Enter picks and Esc
+// or Ctrl-C cancel. The list is drawn on stderr so stdout stays clean for the command's output.
+func Pick(prompt string, items []string) (int, error) {
+	if len(items) == 0 {
+		return -1, fmt.Errorf("nothing to pick from")
+	}
+	in, out := int(os.Stdin.Fd()), redact.Fd(os.Stderr)
+	if !term.IsTerminal(in) || !term.IsTerminal(out) {
+		return -1, ErrNotTerminal
+	}
+
+	state, err := term.MakeRaw(in)
+	if err != nil {
+		return -1, err
+	}
+	defer term.Restore(in, state)
+
+	p := newPicker(prompt, items)
+	if width, height, err := term.GetSize(out); err == nil && width > 0 && height > 0 {
+		p.width = width
+		p.rows = max(1, min(maxRows, height-2))
+	}
+	return p.run(os.Stdin, os.Stderr)
+}
+
+// picker is the state of the list, kept apart from the terminal so it can be driven by any reader
+type picker struct {
+	prompt string
+	items  []string
+	width  int
+	rows   int
+
+	query    []rune
+	matches  []Match
+	selected int
+	offset   int
+	drawn    int
+}
+
+func newPicker(prompt string, items []string) *picker {
+	p := &picker{prompt: prompt, items: items, width: 80, rows: maxRows}
+	p.filter()
+	return p
+}
+
+// run reads keys from in until an item is picked or the list is cancelled, redrawing on out after each read
+func (p *picker) run(in io.Reader, out io.Writer) (int, error) {
+	io.WriteString(out, "\x1b[?25l")
+	defer io.WriteString(out, "\x1b[?25h")
+
+	buf := make([]byte, 64)
+	for {
+		p.draw(out)
+		n, err := in.Read(buf)
+		if n == 0 && err != nil {
+			p.clear(out)
+			if err == io.EOF {
+				return -1, ErrCancelled
+			}
+			return -1, err
+		}
+
+		picked, done, err := p.handle(buf[:n])
+		if done || err != nil {
+			p.clear(out)
+			return picked, err
+		}
+	}
+}
+
+// handle applies the keys of one read, returning the picked index once Enter selects a match
+func (p *picker) handle(keys []byte) (int, bool, error) {
+	changed := false
+	for i := 0; i < len(keys); i++ {
+		switch b := keys[i]; {
+		case b == keyCtrlC || b == keyCtrlG:
+			return -1, true, ErrCancelled
+		case b == keyEsc:
+			// Arrow keys arrive as ESC [ A or ESC O A, a lone ESC closes the list
+			if i+2 >= len(keys) || (keys[i+1] != '[' && keys[i+1] != 'O') {
+				return -1, true, ErrCancelled
+			}
+			switch keys[i+2] {
+			case 'A':
+				p.move(-1)
+			case 'B':
+				p.move(1)
+			}
+			i += 2
+		case b == keyCR || b == keyLF:
+			// Keys typed ahead of Enter in the same read filter first
+			if changed {
+				p.filter()
+				changed = false
+			}
+			if len(p.matches) > 0 {
+				return p.matches[p.selected].Index, true, nil
+			}
+		case b == keyCtrlN || b == keyTab:
+			p.move(1)
+		case b == keyCtrlP:
+			p.move(-1)
+		case b == keyBackspace || b == keyDelete:
+			if len(p.query) > 0 {
+				p.query = p.query[:len(p.query)-1]
+				changed = true
+			}
+		case b == keyCtrlU:
+			p.query, changed = nil, true
+		case b >= ' ':
+			r, size := utf8.DecodeRune(keys[i:])
+			p.query = append(p.query, r)
+			changed = true
+			i += size - 1
+		}
+	}
+	if changed {
+		p.filter()
+	}
+	return -1, false, nil
+}
+
+func (p *picker) filter() {
+	p.matches = Filter(string(p.query), p.items)
+	p.selected, p.offset = 0, 0
+}
+
+// move changes the selection by delta, wrapping around at both ends
+func (p *picker) move(delta int) {
+	if len(p.matches) == 0 {
+		return
+	}
+	p.selected = (p.selected + delta + len(p.matches)) % len(p.matches)
+	if p.selected < p.offset {
+		p.offset = p.selected
+	}
+	if p.selected >= p.offset+p.rows {
+		p.offset = p.selected - p.rows + 1
+	}
+}
+
+// draw replaces the previously drawn list with the prompt line and the visible matches
+func (p *picker) draw(out io.Writer) {
+	var b strings.Builder
+	p.rewind(&b)
+
+	fmt.Fprintf(&b, "%s> %s  (%d/%d)", p.prompt, string(p.query), len(p.matches), len(p.items))
+	p.drawn = 0
+	for i := p.offset; i < len(p.matches) && i < p.offset+p.rows; i++ {
+		line := truncate(p.items[p.matches[i].Index], p.width-3)
+		switch {
+		case i != p.selected:
+			fmt.Fprintf(&b, "\r\n  %s", line)
+		case style.Plain():
+			fmt.Fprintf(&b, "\r\n> %s", line)
+		default:
+			fmt.Fprintf(&b, "\r\n> \x1b[7m%s\x1b[0m", line)
+		}
+		p.drawn++
+	}
+	io.WriteString(out, b.String())
+}
+
+// clear removes the list from the terminal
+func (p *picker) clear(out io.Writer) {
+	var b strings.Builder
+	p.rewind(&b)
+	p.drawn = 0
+	io.WriteString(out, b.String())
+}
+
+// rewind moves the cursor back to the prompt line and erases everything below it
+func (p *picker) rewind(b *strings.Builder) {
+	if p.drawn > 0 {
+		fmt.Fprintf(b, "\x1b[%dA", p.drawn)
+	}
+	b.WriteString("\r\x1b[J")
+}
+
+// truncate shortens s to width runes, marking the cut with an ellipsis or ~ in plain mode
+func truncate(s string, width int) string {
+	if width < 2 || utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	runes := []rune(s)
+	if style.Plain() {
+		return string(runes[:width-1]) + "~"
+	}
+	return string(runes[:width-1]) + "…"
+}
@@ -0,0 +1,198 @@
+package tui
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gsn-dev-tools/internals/style"
+)
+
+// screen is a minimal terminal: it understands the carriage returns, line feeds, cursor up, erase below,
+// cursor visibility and color sequences the picker writes, and keeps the text on screen
+type screen struct {
+	lines    [][]rune
+	row, col int
+	hidden   bool
+}
+
+func (s *screen) Write(p []byte) (int, error) {
+	text := []rune(string(p))
+	for i := 0; i < len(text); i++ {
+		switch r := text[i]; r {
+		case '\r':
+			s.col = 0
+		case '\n':
+			s.row++
+		case '\x1b':
+			end := i + 2
+			for end < len(text) && !(text[end] >= '@' && text[end] <= '~') {
+				end++
+			}
+			s.escape(string(text[i+2:end]), text[end])
+			i = end
+		default:
+			for len(s.lines) <= s.row {
+				s.lines = append(s.lines, nil)
+			}
+			line := s.lines[s.row]
+			for len(line) <= s.col {
+				line = append(line, ' ')
+			}
+			line[s.col] = r
+			s.lines[s.row] = line
+			s.col++
+		}
+	}
+	return len(p), nil
+}
+
+// escape applies the CSI sequence with parameters params and final byte final
+func (s *screen) escape(params string, final rune) {
+	switch {
+	case final == 'A':
+		n, _ := strconv.Atoi(params)
+		s.row = max(0, s.row-max(n, 1))
+	case final == 'J':
+		if s.row < len(s.lines) {
+			s.lines[s.row] = s.lines[s.row][:min(s.col, len(s.lines[s.row]))]
+			s.lines = s.lines[:s.row+1]
+		}
+	case params == "?25l" && final == 'l':
+		s.hidden = true
+	case params == "?25h" && final == 'h':
+		s.hidden = false
+	}
+}
+
+// text returns the non-empty lines on screen
+func (s *screen) text() []string {
+	var out []string
+	for _, l := range s.lines {
+		if line := strings.TrimRight(string(l), " "); line != "" {
+			out = append(out, line)
+		}
+	}
+	return out
+}
+
+// keyScript feeds one chunk of keys per read, recording the screen the picker drew before each read
+type keyScript struct {
+	keys   []string
+	screen *screen
+	frames [][]string
+}
+
+func (k *keyScript) Read(p []byte) (int, error) {
+	k.frames = append(k.frames, k.screen.text())
+	if len(k.keys) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, k.keys[0])
+	k.keys = k.keys[1:]
+	return n, nil
+}
+
+// runPicker drives a picker over items with keys, in plain style so the selection is marked with >
+func runPicker(t *testing.T, items []string, rows int, keys ...string) (int, *keyScript, error) {
+	t.Helper()
+	style.SetPlain(true)
+	t.Cleanup(func() { style.SetPlain(false) })
+
+	term := &screen{}
+	script := &keyScript{keys: keys, screen: term}
+	p := newPicker("Archive", items)
+	p.rows, p.width = rows, 30
+	picked, err := p.run(script, term)
+	if len(term.text()) != 0 || term.hidden {
+		t.Errorf("the list was left on screen: %q, cursor hidden %v", term.text(), term.hidden)
+	}
+	return picked, script, err
+}
+
+var pickItems = []string{"backup-2024-01.tar.gz", "backup-2024-02.tar.zst", "notes.zip", "photos-2023.tar.xz", "release.tar.gz"}
+
+func TestPickerFrames(t *testing.T) {
+	picked, script, err := runPicker(t, pickItems, 3, "tar", "\x1b[B", "\x1b[B", "\x1b[B", "\r")
+	if err != nil || pickItems[picked] != "backup-2024-02.tar.zst" {
+		t.Fatalf("picked %d, %v", picked, err)
+	}
+	want := [][]string{
+		{"Archive>   (5/5)", "> backup-2024-01.tar.gz", "  backup-2024-02.tar.zst", "  notes.zip"},
+		// Filtered, the shortest of the equal matches first
+		{"Archive> tar  (4/5)", "> release.tar.gz", "  photos-2023.tar.xz", "  backup-2024-01.tar.gz"},
+		{"Archive> tar  (4/5)", "  release.tar.gz", "> photos-2023.tar.xz", "  backup-2024-01.tar.gz"},
+		{"Archive> tar  (4/5)", "  release.tar.gz", "  photos-2023.tar.xz", "> backup-2024-01.tar.gz"},
+		// The selection scrolls the list
+		{"Archive> tar  (4/5)", "  photos-2023.tar.xz", "  backup-2024-01.tar.gz", "> backup-2024-02.tar.zst"},
+	}
+	if len(script.frames) != len(want) {
+		t.Fatalf("%d frames:\n%q", len(script.frames), script.frames)
+	}
+	for i, frame := range script.frames {
+		if !slices.Equal(frame, want[i]) {
+			t.Errorf("frame %d =\n%s\nwant\n%s", i, strings.Join(frame, "\n"), strings.Join(want[i], "\n"))
+		}
+	}
+}
+
+func TestPickerKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    []string
+		want    string
+		wantErr error
+	}{
+		{"enter picks the first", []string{"\r"}, "backup-2024-01.tar.gz", nil},
+		{"line feed picks too", []string{"\n"}, "backup-2024-01.tar.gz", nil},
+		{"down, tab and ctrl-n move", []string{"\x1b[B", "\t", "\x0e", "\r"}, "photos-2023.tar.xz", nil},
+		{"up wraps to the last", []string{"\x1bOA", "\r"}, "release.tar.gz", nil},
+		{"ctrl-p moves up", []string{"\t", "\t", "\x10", "\r"}, "backup-2024-02.tar.zst", nil},
+		{"typing filters", []string{"zip", "\r"}, "notes.zip", nil},
+		{"keys in one read", []string{"photo\r"}, "photos-2023.tar.xz", nil},
+		{"backspace edits the query", []string{"zipx", "\x7f", "\r"}, "notes.zip", nil},
+		{"ctrl-u clears the query", []string{"zzz", "\x15", "\r"}, "backup-2024-01.tar.gz", nil},
+		{"enter without matches waits", []string{"zzz", "\r", "\x08\x08\x08rel", "\r"}, "release.tar.gz", nil},
+		{"escape cancels", []string{"\x1b"}, "", ErrCancelled},
+		{"ctrl-c cancels", []string{"no", "\x03"}, "", ErrCancelled},
+		{"ctrl-g cancels", []string{"\x07"}, "", ErrCancelled},
+		{"end of input cancels", []string{"tar"}, "", ErrCancelled},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			picked, _, err := runPicker(t, pickItems, 10, test.keys...)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("err = %v, want %v", err, test.wantErr)
+			}
+			if test.wantErr != nil {
+				if picked != -1 {
+					t.Errorf("cancelled with %d picked", picked)
+				}
+				return
+			}
+			if got := pickItems[picked]; got != test.want {
+				t.Errorf("picked %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPickerTruncatesLongItems(t *testing.T) {
+	_, script, _ := runPicker(t, []string{strings.Repeat("x", 40)}, 10, "\x03")
+	if got := script.frames[0][1]; got != "> "+strings.Repeat("x", 26)+"~" {
+		t.Errorf("long item drawn as %q", got)
+	}
+}
+
+func TestPickWithoutTerminal(t *testing.T) {
+	// go test runs with stdin not attached to a terminal
+	if _, err := Pick("PR", []string{"a"}); !errors.Is(err, ErrNotTerminal) {
+		t.Errorf("Pick = %v, want ErrNotTerminal", err)
+	}
+	if _, err := Pick("PR", nil); err == nil || !strings.Contains(err.Error(), "nothing to pick from") {
+		t.Errorf("Pick without items = %v", err)
+	}
+}
@@ -0,0 +1,181 @@
+package units
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gsn-dev-tools/internals/clierr"
+
+	"github.com/spf13/cobra"
+)
+
+// Locale is how numbers are written for people: the mark between thousands and the decimal mark
+type Locale struct {
+	Name string
+	// Thousands separates groups of three digits in counts, "" writes them ungrouped
+	Thousands string
+	Decimal   string
+}
+
+// cLocale is used when the environment names no known locale, it writes numbers as gsn always did
+var cLocale = Locale{Name: "C", Decimal: "."}
+
+// locales maps languages, and territories whose numbers differ from their language, to their marks
+var locales = map[string]Locale{
+	"en":    {Thousands: ",", Decimal: "."},
+	"de":    {Thousands: ".", Decimal: ","},
+	"de_CH": {Thousands: "'", Decimal: "."},
+	"es":    {Thousands: ".", Decimal: ","},
+	"it":    {Thousands: ".", Decimal: ","},
+	"nl":    {Thousands: ".", Decimal: ","},
+	"pt":    {Thousands: ".", Decimal: ","},
+	"da":    {Thousands: ".", Decimal: ","},
+	"fr":    {Thousands: " ", Decimal: ","},
+	"pl":    {Thousands: " ", Decimal: ","},
+	"sv":    {Thousands: " ", Decimal: ","},
+	"ru":    {Thousands: " ", Decimal: ","},
+	"ja":    {Thousands: ",", Decimal: "."},
+	"zh":    {Thousands: ",", Decimal: "."},
+}
+
+// locale is the locale numbers are formatted and parsed with
+var locale = cLocale
+
+// AddLocaleFlag registers the persistent --locale flag on the root command
+func AddLocaleFlag(cmd *cobra.Command) {
+	cmd.PersistentFlags().String("locale", "", "Locale of human-formatted numbers, e.g. en_US or de_DE (default: from $LC_ALL, $LC_NUMERIC or $LANG)")
+}
+
+// ConfigureLocale applies --locale, or the locale of the environment when it is not given. An unknown --locale
+// is an error, an unknown locale in the environment falls back to C.
+func ConfigureLocale(cmd *cobra.Command) error {
+	name, _ := cmd.Flags().GetString("locale")
+	if name == "" {
+		SetLocale(envLocale())
+		return nil
+	}
+	l, ok := LookupLocale(name)
+	if !ok {
+		return clierr.Newf(clierr.Usage, "unknown --locale '%s', use e.g. C, en_US or de_DE", name)
+	}
+	SetLocale(l)
+	return nil
+}
+
+// envLocale returns the locale of numbers named by the environment, as setlocale reads it
+func envLocale() Locale {
+	for _, name := range []string{"LC_ALL", "LC_NUMERIC", "LANG"} {
+		if value := os.Getenv(name); value != "" {
+			l, _ := LookupLocale(value)
+			return l
+		}
+	}
+	return cLocale
+}
+
+// LookupLocale finds the locale of a name like de_DE.UTF-8, by territory first and then by language. C,
+// POSIX and unknown names give the C locale, reported as not found for the latter.
+func LookupLocale(name string) (Locale, bool) {
+	name, _, _ = strings.Cut(name, ".")
+	name, _, _ = strings.Cut(name, "@")
+	name = strings.ReplaceAll(name, "-", "_")
+	if name == "C" || name == "POSIX" {
+		return cLocale, true
+	}
+	lang, territory, _ := strings.Cut(name, "_")
+	lang = strings.ToLower(lang)
+	key := lang
+	if territory != "" {
+		key += "_" + strings.ToUpper(territory)
+	}
+	for _, k := range []string{key, lang} {
+		if l, ok := locales[k]; ok {
+			l.Name = key
+			return l, true
+		}
+	}
+	return cLocale, false
+}
+
+// SetLocale sets the locale numbers are formatted and parsed with
+func SetLocale(l Locale) {
+	locale = l
+}
+
+// CurrentLocale returns the locale numbers are formatted and parsed with
+func CurrentLocale() Locale {
+	return locale
+}
+
+// FormatInt renders a count with the thousands separator of the locale, e.g. 3,217,442 or 3.217.442
+func FormatInt(n int64) string {
+	digits := strings.TrimPrefix(strconv.FormatInt(n, 10), "-")
+	sign := ""
+	if n < 0 {
+		sign = "-"
+	}
+	if locale.Thousands == "" || len(digits) <= 3 {
+		return sign + digits
+	}
+	var b strings.Builder
+	b.WriteString(sign)
+	head := len(digits) % 3
+	if head > 0 {
+		b.WriteString(digits[:head])
+	}
+	for i := head; i < len(digits); i += 3 {
+		if i > 0 {
+			b.WriteString(locale.Thousands)
+		}
+		b.WriteString(digits[i : i+3])
+	}
+	return b.String()
+}
+
+// FormatDecimal renders a number with the given digits after the decimal mark of the locale, e.g. 42.5 or 42,5
+func FormatDecimal(f float64, digits int) string {
+	return localizeDecimal(strconv.FormatFloat(f, 'f', digits, 64))
+}
+
+// localizeDecimal swaps the decimal point of a number formatted by Go for the decimal mark of the locale
+func localizeDecimal(s string) string {
+	if locale.Decimal == "." {
+		return s
+	}
+	return strings.Replace(s, ".", locale.Decimal, 1)
+}
+
+// decimalNumber matches the numbers of a duration or size as typed, with either decimal mark
+var decimalNumber = regexp.MustCompile(`[0-9]*[.,][0-9.,]*`)
+
+// NormalizeDecimal rewrites the numbers of value with a dot as decimal mark, for strconv and time to parse.
+// Either mark is accepted, with these rules keeping it unambiguous:
+//   - a number holds at most one mark, thousands separators are not accepted
+//   - a mark other than the decimal mark of the locale between one to three digits, not all zeros, and exactly
+//     three digits, like 1,500 in English or 1.500 in German, may be a thousands separator and is rejected
+//
+// The returned error explains what to type instead, with the marks of the locale.
+func NormalizeDecimal(value string) (string, error) {
+	var bad error
+	normalized := decimalNumber.ReplaceAllStringFunc(value, func(number string) string {
+		if bad != nil {
+			return number
+		}
+		if strings.Count(number, ".")+strings.Count(number, ",") > 1 {
+			bad = clierr.Newf(clierr.Usage, "'%s' has more than one decimal mark, write numbers without thousands separators", value)
+			return number
+		}
+		i := strings.IndexAny(number, ".,")
+		whole, fraction := number[:i], number[i+1:]
+		if number[i:i+1] != locale.Decimal && len(fraction) == 3 && len(whole) <= 3 && strings.TrimLeft(whole, "0") != "" {
+			f, _ := strconv.ParseFloat(whole+"."+fraction, 64)
+			bad = clierr.Newf(clierr.Usage, "'%s' is ambiguous, %s may separate thousands: write %s%s without a separator, or %s with the decimal mark",
+				value, number, whole, fraction, localizeDecimal(strconv.FormatFloat(f, 'f', -1, 64)))
+			return number
+		}
+		return whole + "." + fraction
+	})
+	return normalized, bad
+}
@@ -0,0 +1,143 @@
+package units
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+
+	"github.com/spf13/cobra"
+)
+
+// raw prints byte counts as plain integers for scripts
+var raw bool
+
+// AddFlag registers the persistent --bytes flag on the root command
+func AddFlag(cmd *cobra.Command) {
+	cmd.PersistentFlags().Bool("bytes", false, "Print sizes as raw byte counts instead of KiB, MiB, ...")
+}
+
+// Configure switches to raw byte counts when --bytes is set
+func Configure(cmd *cobra.Command) {
+	rawBytes, _ := cmd.Flags().GetBool("bytes")
+	SetRaw(rawBytes)
+}
+
+// SetRaw forces raw byte counts on or off
+func SetRaw(r bool) {
+	raw = r
+}
+
+// Raw reports whether sizes must be printed as raw byte counts
+func Raw() bool {
+	return raw
+}
+
+// byteUnits maps the accepted suffixes, compared case-insensitively, to their factor
+var byteUnits = map[string]int64{
+	"":  1,
+	"b": 1,
+
+	"kb": 1e3, "mb": 1e6, "gb": 1e9, "tb": 1e12, "pb": 1e15, "eb": 1e18,
+
+	"kib": 1 << 10, "mib": 1 << 20, "gib": 1 << 30, "tib": 1 << 40, "pib": 1 << 50, "eib": 1 << 60,
+}
+
+// FormatBytes renders a byte count with binary units and the decimal mark of the locale, e.g. 1.5 MiB or
+// 1,5 MiB, or as a plain integer with --bytes
+func FormatBytes(n int64) string {
+	if raw {
+		return strconv.FormatInt(n, 10)
+	}
+
+	sign := ""
+	if n < 0 {
+		sign = "-"
+		n = -n
+	}
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%s%d B", sign, n)
+	}
+
+	// The unit is picked after rounding, so 1048575 bytes are 1.0 MiB rather than 1024.0 KiB
+	value, exp := float64(n)/unit, 0
+	for math.Round(value*10)/10 >= unit && exp < len("KMGTPE")-1 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%s%s %ciB", sign, localizeDecimal(fmt.Sprintf("%.1f", value)), "KMGTPE"[exp])
+}
+
+// FormatRate renders a throughput in bytes per second, e.g. 12.3 MiB/s
+func FormatRate(bytes int64, d time.Duration) string {
+	if d <= 0 {
+		return FormatBytes(bytes) + "/s"
+	}
+	return FormatBytes(int64(float64(bytes)/d.Seconds())) + "/s"
+}
+
+// ParseBytes parses sizes such as 500MB, 1.5 GiB, 1,5 GiB or a plain byte count. KB, MB, ... are decimal (SI)
+// and KiB, MiB, ... binary (IEC). Single letter suffixes like 10K or 2G are rejected as ambiguous, so are the
+// decimal marks NormalizeDecimal rejects.
+func ParseBytes(value string) (int64, error) {
+	s, err := NormalizeDecimal(strings.TrimSpace(value))
+	if err != nil {
+		return 0, err
+	}
+	end := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if end < 0 {
+		end = len(s)
+	}
+	number, suffix := s[:end], strings.ToLower(strings.TrimSpace(s[end:]))
+
+	if number == "" || strings.Count(number, ".") > 1 || number == "." {
+		return 0, clierr.Newf(clierr.Usage, "invalid size '%s'", value)
+	}
+
+	factor, ok := byteUnits[suffix]
+	if !ok {
+		if len(suffix) == 1 && strings.Contains("kmgtpe", suffix) {
+			upper := strings.ToUpper(suffix)
+			return 0, clierr.Newf(clierr.Usage, "ambiguous size '%s', use %sB (powers of 1000) or %siB (powers of 1024)", value, upper, upper)
+		}
+		return 0, clierr.Newf(clierr.Usage, "invalid size '%s': unknown unit '%s'", value, s[end:])
+	}
+
+	if !strings.Contains(number, ".") {
+		n, err := strconv.ParseInt(number, 10, 64)
+		if err != nil || (n > 0 && factor > math.MaxInt64/n) {
+			return 0, clierr.Newf(clierr.Usage, "size '%s' is too large", value)
+		}
+		return n * factor, nil
+	}
+
+	if factor == 1 {
+		return 0, clierr.Newf(clierr.Usage, "invalid size '%s': a byte count must be a whole number", value)
+	}
+	f, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, clierr.Newf(clierr.Usage, "invalid size '%s'", value)
+	}
+	bytes := f * float64(factor)
+	if bytes >= math.MaxInt64 {
+		return 0, clierr.Newf(clierr.Usage, "size '%s' is too large", value)
+	}
+	return int64(bytes), nil
+}
+
+// FormatDuration renders an elapsed time rounded to what a reader cares about: milliseconds below a second,
+// tenths of a second below a minute and whole seconds above, with the decimal mark of the locale
+func FormatDuration(d time.Duration) string {
+	switch {
+	case d < time.Second:
+		return localizeDecimal(d.Round(time.Millisecond).String())
+	case d < time.Minute:
+		return localizeDecimal(d.Round(100 * time.Millisecond).String())
+	default:
+		return d.Round(time.Second).String()
+	}
+}
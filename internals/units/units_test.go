@@ -0,0 +1,194 @@
+package units
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+)
+
+// useLocale switches to the locale named name until the test ends
+func useLocale(t *testing.T, name string) {
+	t.Helper()
+	l, ok := LookupLocale(name)
+	if !ok {
+		t.Fatalf("unknown locale %s", name)
+	}
+	SetLocale(l)
+	t.Cleanup(func() { SetLocale(cLocale) })
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n       int64
+		c, de   string
+		rawWant string
+	}{
+		{0, "0 B", "0 B", "0"},
+		{1, "1 B", "1 B", "1"},
+		{1023, "1023 B", "1023 B", "1023"},
+		{1024, "1.0 KiB", "1,0 KiB", "1024"},
+		{1536, "1.5 KiB", "1,5 KiB", "1536"},
+		// The unit follows the rounded value
+		{1<<20 - 1, "1.0 MiB", "1,0 MiB", "1048575"},
+		{1048524, "1023.9 KiB", "1023,9 KiB", "1048524"},
+		{1048525, "1.0 MiB", "1,0 MiB", "1048525"},
+		{1<<30 - 1, "1.0 GiB", "1,0 GiB", "1073741823"},
+		{1 << 20, "1.0 MiB", "1,0 MiB", "1048576"},
+		{5*1<<30 + 1<<29, "5.5 GiB", "5,5 GiB", "5905580032"},
+		{1 << 40, "1.0 TiB", "1,0 TiB", "1099511627776"},
+		{1 << 50, "1.0 PiB", "1,0 PiB", "1125899906842624"},
+		{math.MaxInt64, "8.0 EiB", "8,0 EiB", "9223372036854775807"},
+		{-1536, "-1.5 KiB", "-1,5 KiB", "-1536"},
+		{-10, "-10 B", "-10 B", "-10"},
+	}
+	for _, test := range tests {
+		if got := FormatBytes(test.n); got != test.c {
+			t.Errorf("FormatBytes(%d) = %q, want %q", test.n, got, test.c)
+		}
+	}
+
+	useLocale(t, "de_DE.UTF-8")
+	for _, test := range tests {
+		if got := FormatBytes(test.n); got != test.de {
+			t.Errorf("de_DE: FormatBytes(%d) = %q, want %q", test.n, got, test.de)
+		}
+	}
+
+	SetRaw(true)
+	defer SetRaw(false)
+	for _, test := range tests {
+		if got := FormatBytes(test.n); got != test.rawWant {
+			t.Errorf("--bytes: FormatBytes(%d) = %q, want %q", test.n, got, test.rawWant)
+		}
+	}
+}
+
+func TestFormatRate(t *testing.T) {
+	if got := FormatRate(3<<20, 2*time.Second); got != "1.5 MiB/s" {
+		t.Errorf("FormatRate = %q", got)
+	}
+	// No time elapsed yet, the count itself is shown
+	if got := FormatRate(512, 0); got != "512 B/s" {
+		t.Errorf("FormatRate without elapsed time = %q", got)
+	}
+}
+
+func TestParseBytes(t *testing.T) {
+	tests := []struct {
+		locale, value string
+		want          int64
+		wantErr       string
+	}{
+		// Plain counts and both unit systems, in any case and with or without a space
+		{"C", "0", 0, ""},
+		{"C", "4096", 4096, ""},
+		{"C", " 12 ", 12, ""},
+		{"C", "10b", 10, ""},
+		{"C", "1KB", 1000, ""},
+		{"C", "1kb", 1000, ""},
+		{"C", "1KiB", 1024, ""},
+		{"C", "500MB", 500_000_000, ""},
+		{"C", "500 MiB", 500 << 20, ""},
+		{"C", "2GB", 2_000_000_000, ""},
+		{"C", "2gib", 2 << 30, ""},
+		{"C", "1TB", 1e12, ""},
+		{"C", "1 TiB", 1 << 40, ""},
+		{"C", "1PB", 1e15, ""},
+		{"C", "1PiB", 1 << 50, ""},
+		{"C", "7EiB", 7 << 60, ""},
+
+		// Fractions of a unit, with either decimal mark
+		{"C", "1.5 GiB", 3 << 29, ""},
+		{"C", "1,5 GiB", 3 << 29, ""},
+		{"C", ".5KiB", 512, ""},
+		{"C", "0.5MB", 500_000, ""},
+		{"de_DE", "1,5 GiB", 3 << 29, ""},
+		{"de_DE", "1.5 GiB", 3 << 29, ""},
+		{"C", "2.5", 0, "a byte count must be a whole number"},
+
+		// A mark that is not the locale's, before three digits, may separate thousands
+		{"C", "1,500MB", 0, "'1,500MB' is ambiguous, 1,500 may separate thousands: write 1500 without a separator, or 1.5 with the decimal mark"},
+		{"en_US", "1,500MB", 0, "is ambiguous"},
+		{"de_DE", "1.500MB", 0, "'1.500MB' is ambiguous, 1.500 may separate thousands: write 1500 without a separator, or 1,5 with the decimal mark"},
+		{"C", "1.500MB", 1_500_000, ""},
+		{"de_DE", "1,500MB", 1_500_000, ""},
+		{"C", "0,500MB", 500_000, ""},
+		{"C", "1,50MB", 1_500_000, ""},
+		{"C", "1,000,000", 0, "more than one decimal mark"},
+
+		// Single letters could be either system
+		{"C", "10K", 0, "ambiguous size '10K', use KB (powers of 1000) or KiB (powers of 1024)"},
+		{"C", "2g", 0, "ambiguous size '2g', use GB (powers of 1000) or GiB (powers of 1024)"},
+
+		// Garbage and overflow
+		{"C", "", 0, "invalid size ''"},
+		{"C", "MB", 0, "invalid size 'MB'"},
+		{"C", "-1MB", 0, "invalid size '-1MB'"},
+		{"C", ".", 0, "invalid size '.'"},
+		{"C", "10 bytes", 0, "unknown unit ' bytes'"},
+		{"C", "10XB", 0, "unknown unit 'XB'"},
+		{"C", "8EiB", 0, "too large"},
+		{"C", "9223372036854775808", 0, "too large"},
+		{"C", "9.5EB", 0, "too large"},
+	}
+	for _, test := range tests {
+		t.Run(test.locale+" "+test.value, func(t *testing.T) {
+			useLocale(t, test.locale)
+			got, err := ParseBytes(test.value)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("ParseBytes(%q) = %d, %v, want an error with %q", test.value, got, err, test.wantErr)
+				}
+				return
+			}
+			if err != nil || got != test.want {
+				t.Errorf("ParseBytes(%q) = %d, %v, want %d", test.value, got, err, test.want)
+			}
+		})
+	}
+}
+
+func TestParseBytesRejectsAsUsageErrors(t *testing.T) {
+	for _, value := range []string{"1,500MB", "1,000,000", "MB", "10XB", "2.5", "10K", "8EiB", "9223372036854775808", "9.5EB"} {
+		if _, err := ParseBytes(value); clierr.CodeOf(err) != clierr.Usage {
+			t.Errorf("ParseBytes(%q) = %v, exit code %d, want a usage error", value, err, clierr.CodeOf(err))
+		}
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		d     time.Duration
+		c, de string
+	}{
+		{0, "0s", "0s"},
+		{400 * time.Microsecond, "0s", "0s"},
+		{1500 * time.Microsecond, "2ms", "2ms"},
+		{42 * time.Millisecond, "42ms", "42ms"},
+		{999 * time.Millisecond, "999ms", "999ms"},
+		{999600 * time.Microsecond, "1s", "1s"},
+		{time.Second, "1s", "1s"},
+		{1234 * time.Millisecond, "1.2s", "1,2s"},
+		{1250 * time.Millisecond, "1.3s", "1,3s"},
+		{59940 * time.Millisecond, "59.9s", "59,9s"},
+		{59960 * time.Millisecond, "1m0s", "1m0s"},
+		{time.Minute, "1m0s", "1m0s"},
+		{90*time.Second + 400*time.Millisecond, "1m30s", "1m30s"},
+		{90*time.Second + 600*time.Millisecond, "1m31s", "1m31s"},
+		{2*time.Hour + 3*time.Minute, "2h3m0s", "2h3m0s"},
+	}
+	for _, test := range tests {
+		if got := FormatDuration(test.d); got != test.c {
+			t.Errorf("FormatDuration(%v) = %q, want %q", test.d, got, test.c)
+		}
+	}
+	useLocale(t, "de_DE")
+	for _, test := range tests {
+		if got := FormatDuration(test.d); got != test.de {
+			t.Errorf("de_DE: FormatDuration(%v) = %q, want %q", test.d, got, test.de)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package waitfor
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/execx"
+	"gsn-dev-tools/internals/style"
+	"gsn-dev-tools/internals/units"
+
+	"github.com/spf13/cobra"
+)
+
+// minAttemptTimeout bounds a single probe when the interval is shorter, so slow handshakes still get a chance
+const minAttemptTimeout = time.Second
+
+func WaitCmd() *cobra.Command {
+	waitCmd := &cobra.Command{
+		Use:   "wait",
+		Short: "Waits until TCP ports and HTTP endpoints are reachable",
+		Long: `Polls every --tcp address and --http URL concurrently until all of them are up, or one of them with --any,
+printing a line each time a target goes up or the reason it is down changes. A TCP target is up when a
+connection is accepted, an HTTP target when a GET answers with a 2xx status.
+
+--command runs a shell command first, like docker compose up -d, and must return before the polling starts.
+Exits with 0 once the targets are up, 124 when --timeout expires (0 waits forever) and 130 on Ctrl+C.`,
+		Example: `  gsn wait --tcp localhost:5432 --tcp localhost:9092 --http http://localhost:8080/health --timeout 60s
+  gsn wait --command "docker compose up -d" --tcp localhost:5432 && go test ./...
+  gsn wait --any --http http://localhost:8080/health --http http://localhost:8081/health --interval 500ms`,
+		Args: cobra.NoArgs,
+		Run:  Wait,
+	}
+
+	waitCmd.Flags().StringArray("tcp", nil, "host:port to connect to (repeatable)")
+	waitCmd.Flags().StringArray("http", nil, "URL that must answer GET with a 2xx status (repeatable)")
+	waitCmd.Flags().Duration("timeout", 60*time.Second, "Give up after this long, 0 waits forever")
+	waitCmd.Flags().Duration("interval", time.Second, "Time between two attempts on the same target")
+	waitCmd.Flags().Bool("any", false, "Succeed as soon as one target is up")
+	waitCmd.Flags().Bool("insecure", false, "Accept any certificate from https targets")
+	waitCmd.Flags().String("command", "", "Shell command to run before waiting, e.g. to start the services")
+	dryrun.ReadOnly(waitCmd)
+	return waitCmd
+}
+
+func Wait(cmd *cobra.Command, args []string) {
+	tcpAddrs, _ := cmd.Flags().GetStringArray("tcp")
+	httpURLs, _ := cmd.Flags().GetStringArray("http")
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+	interval, _ := cmd.Flags().GetDuration("interval")
+	anyTarget, _ := cmd.Flags().GetBool("any")
+	insecure, _ := cmd.Flags().GetBool("insecure")
+	command, _ := cmd.Flags().GetString("command")
+
+	targets, err := parseTargets(tcpAddrs, httpURLs)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	if interval <= 0 {
+		clierr.Exitf(clierr.Usage, "--interval must be positive")
+	}
+	if timeout < 0 {
+		clierr.Exitf(clierr.Usage, "--timeout cannot be negative")
+	}
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if command != "" {
+		fmt.Fprintf(os.Stderr, "Running %s\n", command)
+		opts := execx.Options{Stdout: os.Stderr, Stderr: os.Stderr}
+		if _, _, _, err := execx.Default.Run(ctx, "sh", []string{"-c", command}, opts); err != nil {
+			clierr.Fatalf("--command failed: %v", err)
+		}
+	}
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	w := waiter{Interval: interval, Any: anyTarget, Client: httpClient(insecure)}
+	start := time.Now()
+	pending, err := w.wait(ctx, targets, func(e event) { printEvent(e, start) })
+	if err == nil {
+		fmt.Printf(style.Success()+"Ready after %s\n", units.FormatDuration(time.Since(start)))
+		return
+	}
+
+	names := make([]string, len(pending))
+	for i, t := range pending {
+		names[i] = t.String()
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		clierr.Exitf(clierr.Timeout, "Timed out after %s, still down: %s", timeout, strings.Join(names, ", "))
+	}
+	clierr.Exitf(clierr.Interrupted, "Interrupted, still down: %s", strings.Join(names, ", "))
+}
+
+// target is an address to poll, Kind is tcp or http
+type target struct {
+	Kind string
+	Addr string
+}
+
+func (t target) String() string {
+	return t.Addr
+}
+
+// parseTargets validates the --tcp and --http values, a target given twice is polled once
+func parseTargets(tcpAddrs []string, httpURLs []string) ([]target, error) {
+	if len(tcpAddrs)+len(httpURLs) == 0 {
+		return nil, clierr.Newf(clierr.Usage, "nothing to wait for, give at least one --tcp or --http target")
+	}
+	var targets []target
+	for _, addr := range tcpAddrs {
+		if _, port, err := net.SplitHostPort(addr); err != nil || port == "" {
+			return nil, clierr.Newf(clierr.Usage, "--tcp '%s' is not host:port", addr)
+		}
+		targets = appendTarget(targets, target{Kind: "tcp", Addr: addr})
+	}
+	for _, raw := range httpURLs {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, clierr.Newf(clierr.Usage, "--http '%s' is not an http or https URL", raw)
+		}
+		targets = appendTarget(targets, target{Kind: "http", Addr: raw})
+	}
+	return targets, nil
+}
+
+func appendTarget(targets []target, t target) []target {
+	if slices.Contains(targets, t) {
+		return targets
+	}
+	return append(targets, t)
+}
+
+func httpClient(insecure bool) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DisableKeepAlives = true
+	if insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return &http.Client{Transport: transport}
+}
+
+// event is the result of one attempt on a target
+type event struct {
+	Target target
+	Err    error
+	// Changed is set on the first attempt, when the target goes up, and when the reason it is down changes
+	Changed bool
+	At      time.Time
+}
+
+func printEvent(e event, start time.Time) {
+	if !e.Changed {
+		return
+	}
+	elapsed := units.FormatDuration(e.At.Sub(start))
+	if e.Err == nil {
+		fmt.Printf(style.Success()+"%s is up (%s)\n", e.Target, elapsed)
+		return
+	}
+	fmt.Printf("%s is down: %v (%s)\n", e.Target, e.Err, elapsed)
+}
+
+// waiter polls targets until they are up
+type waiter struct {
+	Interval time.Duration
+	Any      bool
+	Client   *http.Client
+}
+
+// wait polls every target on its own goroutine and reports each attempt. It returns once all targets, or one with
+// Any, have been up, or with the error of ctx and the targets that never came up.
+func (w waiter) wait(ctx context.Context, targets []target, report func(event)) ([]target, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	events := make(chan event)
+	for _, t := range targets {
+		go w.poll(ctx, t, events)
+	}
+
+	up := make(map[target]bool, len(targets))
+	last := make(map[target]string, len(targets))
+	for len(up) < len(targets) {
+		select {
+		case <-ctx.Done():
+			var pending []target
+			for _, t := range targets {
+				if !up[t] {
+					pending = append(pending, t)
+				}
+			}
+			return pending, ctx.Err()
+		case e := <-events:
+			status := "up"
+			if e.Err != nil {
+				status = e.Err.Error()
+			}
+			e.Changed = last[e.Target] != status
+			last[e.Target] = status
+			report(e)
+			if e.Err == nil {
+				up[e.Target] = true
+				if w.Any {
+					return nil, nil
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+// poll probes t every interval until it is up or ctx is done
+func (w waiter) poll(ctx context.Context, t target, events chan<- event) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		err := w.probe(ctx, t)
+		if ctx.Err() != nil || expired(ctx) {
+			return
+		}
+		select {
+		case events <- event{Target: t, Err: err, At: time.Now()}:
+		case <-ctx.Done():
+			return
+		}
+		if err == nil {
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// expired reports whether the deadline of ctx has passed, a dial can notice it before ctx.Err does
+func expired(ctx context.Context) bool {
+	deadline, ok := ctx.Deadline()
+	return ok && !time.Now().Before(deadline)
+}
+
+// probe makes one attempt on t, nil means it is up
+func (w waiter) probe(ctx context.Context, t target) error {
+	ctx, cancel := context.WithTimeout(ctx, max(w.Interval, minAttemptTimeout))
+	defer cancel()
+
+	if t.Kind == "tcp" {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", t.Addr)
+		if err != nil {
+			return shortError(err)
+		}
+		return conn.Close()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.Addr, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return shortError(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
+
+// shortError drops the operation and address that dial and HTTP errors repeat, the target is printed already
+func shortError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		err = opErr.Err
+	}
+	return err
+}
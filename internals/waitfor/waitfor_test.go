@@ -0,0 +1,229 @@
+package waitfor
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+)
+
+const testInterval = 20 * time.Millisecond
+
+// freeAddr returns a local address nothing listens on, until the test listens on it
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+// listen starts accepting connections on addr for the rest of the test
+func listen(t *testing.T, addr string) net.Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	return l
+}
+
+// transitions returns the events that changed the status of their target, as "addr up" or "addr down: reason"
+func transitions(events []event) []string {
+	var lines []string
+	for _, e := range events {
+		if !e.Changed {
+			continue
+		}
+		if e.Err == nil {
+			lines = append(lines, e.Target.Addr+" up")
+		} else {
+			lines = append(lines, e.Target.Addr+" down: "+e.Err.Error())
+		}
+	}
+	return lines
+}
+
+func newWaiter() waiter {
+	return waiter{Interval: testInterval, Client: httpClient(false)}
+}
+
+func TestWaitTCPListenerStartedMidPoll(t *testing.T) {
+	addr := freeAddr(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// The listener comes up after the third refused attempt
+	var events []event
+	pending, err := newWaiter().wait(ctx, []target{{Kind: "tcp", Addr: addr}}, func(e event) {
+		events = append(events, e)
+		if len(events) == 3 {
+			listen(t, addr)
+		}
+	})
+	if err != nil || pending != nil {
+		t.Fatalf("wait = %v, %v", pending, err)
+	}
+	if len(events) != 4 {
+		t.Errorf("%d attempts, want 3 refused and 1 accepted", len(events))
+	}
+	want := []string{addr + " down: " + events[0].Err.Error(), addr + " up"}
+	if got := transitions(events); !slices.Equal(got, want) || !strings.Contains(want[0], "connection refused") {
+		t.Errorf("transitions = %q, want %q", got, want)
+	}
+	// Attempts are an interval apart
+	for i := 1; i < len(events); i++ {
+		if gap := events[i].At.Sub(events[i-1].At); gap < testInterval/2 {
+			t.Errorf("attempt %d came %s after the previous one", i+1, gap)
+		}
+	}
+}
+
+func TestWaitHTTPServerStartedMidPoll(t *testing.T) {
+	addr := freeAddr(t)
+	var hits atomic.Int32
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Starting, then failing for another reason, then healthy
+		switch n := hits.Add(1); {
+		case n <= 2:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case n == 3:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})}
+	defer server.Close()
+	url := "http://" + addr + "/health"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var events []event
+	_, err := newWaiter().wait(ctx, []target{{Kind: "http", Addr: url}}, func(e event) {
+		events = append(events, e)
+		if len(events) == 2 {
+			l, err := net.Listen("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			go server.Serve(l)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The refused attempts count as one state, the two 503 as another
+	got := transitions(events)
+	want := []string{url + " down: status 503 Service Unavailable", url + " down: status 500 Internal Server Error", url + " up"}
+	if len(events) != 6 || len(got) != 4 || !strings.Contains(got[0], "connection refused") || !slices.Equal(got[1:], want) {
+		t.Errorf("%d attempts, transitions = %q\nwant connection refused then %q", len(events), got, want)
+	}
+}
+
+func TestWaitAllAndAny(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer up.Close()
+	late := freeAddr(t)
+	targets := []target{{Kind: "http", Addr: up.URL}, {Kind: "tcp", Addr: late}}
+
+	// Every target must be up, the late one comes up once the first one is
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	started := false
+	pending, err := newWaiter().wait(ctx, targets, func(e event) {
+		if e.Target.Addr == up.URL && e.Err == nil && !started {
+			started = true
+			listen(t, late)
+		}
+	})
+	if err != nil || pending != nil || !started {
+		t.Errorf("wait for all = %v, %v", pending, err)
+	}
+
+	// One target is enough with Any, the other never comes up
+	w := newWaiter()
+	w.Any = true
+	var reported []string
+	pending, err = w.wait(ctx, []target{{Kind: "tcp", Addr: freeAddr(t)}, {Kind: "http", Addr: up.URL}}, func(e event) {
+		if e.Err == nil {
+			reported = append(reported, e.Target.Addr)
+		}
+	})
+	if err != nil || pending != nil || !slices.Equal(reported, []string{up.URL}) {
+		t.Errorf("wait for any = %v, %v, up %q", pending, err, reported)
+	}
+}
+
+func TestWaitReturnsWhatIsStillDown(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer up.Close()
+	down := freeAddr(t)
+	targets := []target{{Kind: "tcp", Addr: down}, {Kind: "http", Addr: up.URL}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	pending, err := newWaiter().wait(ctx, targets, func(event) {})
+	if !errors.Is(err, context.DeadlineExceeded) || !slices.Equal(pending, targets[:1]) {
+		t.Errorf("wait = %v, %v, want %s still down at the deadline", pending, err, down)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("wait returned %s after a 150ms deadline", elapsed)
+	}
+
+	// Interrupted, the caller tells a cancel from a deadline
+	ctx, cancel = context.WithCancel(context.Background())
+	pending, err = newWaiter().wait(ctx, targets, func(e event) {
+		if e.Target.Addr == down {
+			cancel()
+		}
+	})
+	if !errors.Is(err, context.Canceled) || !slices.Contains(pending, targets[0]) {
+		t.Errorf("wait after cancel = %v, %v", pending, err)
+	}
+}
+
+func TestParseTargets(t *testing.T) {
+	targets, err := parseTargets([]string{"localhost:5432", "[::1]:80", "localhost:5432"}, []string{"http://localhost:8080/health", "https://example.com"})
+	want := []target{{"tcp", "localhost:5432"}, {"tcp", "[::1]:80"}, {"http", "http://localhost:8080/health"}, {"http", "https://example.com"}}
+	if err != nil || !slices.Equal(targets, want) {
+		t.Errorf("parseTargets = %v, %v\nwant %v", targets, err, want)
+	}
+
+	tests := []struct {
+		tcp, http []string
+		want      string
+	}{
+		{nil, nil, "nothing to wait for, give at least one --tcp or --http target"},
+		{[]string{"localhost"}, nil, "--tcp 'localhost' is not host:port"},
+		{[]string{"localhost:"}, nil, "--tcp 'localhost:' is not host:port"},
+		{nil, []string{"localhost:8080"}, "--http 'localhost:8080' is not an http or https URL"},
+		{nil, []string{"ftp://example.com"}, "--http 'ftp://example.com' is not an http or https URL"},
+		{nil, []string{"http://"}, "--http 'http://' is not an http or https URL"},
+	}
+	for _, test := range tests {
+		_, err := parseTargets(test.tcp, test.http)
+		if clierr.CodeOf(err) != clierr.Usage || err.Error() != test.want {
+			t.Errorf("parseTargets(%q, %q) = %v, want %q", test.tcp, test.http, err, test.want)
+		}
+	}
+}
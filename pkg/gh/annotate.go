@@ -0,0 +1,407 @@
+package gh
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/editor"
+	"gsn-dev-tools/internals/paths"
+	"gsn-dev-tools/internals/style"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// annotationPrefix starts the lines of the edited diff holding review comments
+	annotationPrefix = "#>"
+
+	// annotationDir is the directory below the gsn state dir keeping the annotated diffs a submission failed on
+	annotationDir = "annotations"
+)
+
+// annotationHelp heads the diff opened in the editor, its lines are ignored like every line starting with #
+const annotationHelp = `#
+# Write review comments on lines starting with #> right below the diff line they are about. Consecutive #>
+# lines form one comment, #> lines above the first file form the summary of the review. Other lines starting
+# with # are ignored and the diff itself must stay as it is. Without any comment nothing is submitted.
+#
+`
+
+func annotatePrCmd() *cobra.Command {
+	var from string
+	var approve, requestChanges, comment bool
+
+	annotateCmd := &cobra.Command{
+		Use:   "annotate <PR_URL>",
+		Short: "Review a pull request by annotating its diff in your editor",
+		Long: `Opens the unified diff of the pull request in $VISUAL or $EDITOR. Comments written on lines starting with #>
+below a line of the diff become line comments on it, #> lines above the first file the summary of the review.
+Everything is submitted as a single review pinned to the head commit the diff was taken from.
+
+Without a verdict the review stays pending, so you can look it over and submit it on GitHub; --approve,
+--request-changes or --comment submit it right away, and --dry-run prints the review instead. When the submission fails the annotated diff is saved
+below the gsn state dir and --from opens it again, as long as the pull request did not change meanwhile.`,
+		Example: `  gsn pr annotate https://github.com/owner/repo/pull/42
+  gsn pr annotate owner/repo#42 --request-changes
+  gsn pr annotate owner/repo#42 --from ~/.local/state/gsn/annotations/owner-repo-42-20260114-093000.diff`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completePRRefs,
+		Run: func(cmd *cobra.Command, args []string) {
+			ref, err := ParsePRURL(args[0])
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			client, err := NewClient("repo")
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			event := ""
+			switch {
+			case approve:
+				event = "APPROVE"
+			case requestChanges:
+				event = "REQUEST_CHANGES"
+			case comment:
+				event = "COMMENT"
+			}
+			if err := annotatePR(cmd.Context(), client, ref, event, from); err != nil {
+				clierr.Fatalf("%v", err)
+			}
+		},
+	}
+
+	annotateCmd.Flags().StringVar(&from, "from", "", "Start from an annotated diff saved by a failed submission instead of the fresh diff")
+	annotateCmd.Flags().BoolVar(&approve, "approve", false, "Submit the review as an approval")
+	annotateCmd.Flags().BoolVar(&requestChanges, "request-changes", false, "Submit the review requesting changes")
+	annotateCmd.Flags().BoolVar(&comment, "comment", false, "Submit the review as a comment without a verdict")
+	annotateCmd.MarkFlagsMutuallyExclusive("approve", "request-changes", "comment")
+	dryrun.Adopt(annotateCmd)
+	return annotateCmd
+}
+
+// annotatePR lets the user annotate the diff of a PR and submits the comments as one review, an empty event
+// leaves it pending
+func annotatePR(ctx context.Context, client *Client, ref PRRef, event string, from string) error {
+	pr, err := client.GetPR(ctx, ref)
+	if err != nil {
+		return err
+	}
+	files, err := client.ListPRFiles(ctx, ref)
+	if err != nil {
+		return err
+	}
+	diff := renderPRDiff(files)
+
+	initial := fmt.Sprintf("# Review of %s: %s (head %s)\n", ref, strings.Join(strings.Fields(pr.Title), " "), shortSHA(pr.Head.SHA)) + annotationHelp + diff
+	if from != "" {
+		data, err := os.ReadFile(from)
+		if err != nil {
+			return err
+		}
+		initial = string(data)
+	}
+	text, err := editor.Edit(ctx, initial, fmt.Sprintf("%s-%s-%d-*.diff", ref.Owner, ref.Repo, ref.Number))
+	if err != nil {
+		return err
+	}
+
+	annotated, err := parseAnnotatedDiff(text)
+	if err == nil {
+		err = annotated.matches(diff)
+	}
+	if err != nil {
+		return saveAnnotation(ref, text, err)
+	}
+	if annotated.Body == "" && len(annotated.Comments) == 0 && event != "APPROVE" {
+		return fmt.Errorf("aborting, the annotated diff has no comments")
+	}
+
+	review := reviewRequest{CommitID: pr.Head.SHA, Event: event, Body: annotated.Body, Comments: annotated.Comments}
+	if client.DryRun {
+		printAnnotatedReview(ref, review)
+	}
+	if _, err := client.SubmitReview(ctx, ref, review); err != nil {
+		return saveAnnotation(ref, text, err)
+	}
+	if client.DryRun {
+		return nil
+	}
+
+	verdict := "Left a pending review"
+	switch event {
+	case "APPROVE":
+		verdict = "Approved"
+	case "REQUEST_CHANGES":
+		verdict = "Requested changes"
+	case "COMMENT":
+		verdict = "Commented"
+	}
+	fmt.Printf(style.Success()+"%s on %s with %d comment(s)\n", verdict, ref, len(review.Comments))
+	return nil
+}
+
+// printAnnotatedReview shows the review a dry run would submit
+func printAnnotatedReview(ref PRRef, review reviewRequest) {
+	event := review.Event
+	if event == "" {
+		event = "PENDING"
+	}
+	fmt.Printf("Would submit a review of %s at %s as %s with %d comment(s)\n", ref, shortSHA(review.CommitID), event, len(review.Comments))
+	if review.Body != "" {
+		fmt.Printf("  %s\n", strings.ReplaceAll(review.Body, "\n", "\n  "))
+	}
+	for _, c := range review.Comments {
+		fmt.Printf("  %s @%d: %s\n", c.Path, c.Position, strings.ReplaceAll(c.Body, "\n", "\n    "))
+	}
+}
+
+// saveAnnotation keeps the annotated diff below the state dir when it could not be submitted and returns cause
+// with the path to resume from
+func saveAnnotation(ref PRRef, text string, cause error) error {
+	dir, err := paths.StateDir()
+	if err == nil {
+		dir = filepath.Join(dir, annotationDir)
+		err = os.MkdirAll(dir, 0o700)
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s-%d-%s.diff", ref.Owner, ref.Repo, ref.Number, time.Now().Format("20060102-150405")))
+	if err == nil {
+		err = os.WriteFile(path, []byte(text), 0o600)
+	}
+	if err != nil {
+		return fmt.Errorf("%w (saving the annotated diff failed as well: %v)", cause, err)
+	}
+	fmt.Fprintf(os.Stderr, style.Warning()+"Saved the annotated diff to %s, resume with gsn pr annotate %s --from %s\n", path, ref, path)
+	return cause
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+// renderPRDiff writes the files of a PR as a git diff. The patches come as GitHub serves them, so the positions
+// of review comments count their lines exactly.
+func renderPRDiff(files []PRFile) string {
+	var b strings.Builder
+	for _, f := range files {
+		oldPath := f.Filename
+		if f.PreviousFilename != "" {
+			oldPath = f.PreviousFilename
+		}
+		fmt.Fprintf(&b, "diff --git %s %s\n", quoteDiffPath("a/"+oldPath), quoteDiffPath("b/"+f.Filename))
+		if oldPath != f.Filename {
+			fmt.Fprintf(&b, "rename from %s\nrename to %s\n", quoteDiffPath(oldPath), quoteDiffPath(f.Filename))
+		}
+		if f.Patch == "" {
+			b.WriteString("# no diff shown, the file is binary or its diff too large\n")
+			continue
+		}
+		from, to := quoteDiffPath("a/"+oldPath), quoteDiffPath("b/"+f.Filename)
+		switch f.Status {
+		case "added":
+			from = "/dev/null"
+		case "removed":
+			to = "/dev/null"
+		}
+		fmt.Fprintf(&b, "--- %s\n+++ %s\n%s\n", from, to, strings.TrimSuffix(f.Patch, "\n"))
+	}
+	return b.String()
+}
+
+// quoteDiffPath quotes a path the way git does when it holds quotes, backslashes or control characters
+func quoteDiffPath(p string) string {
+	if strings.ContainsFunc(p, func(r rune) bool { return r < ' ' || r == '"' || r == '\\' || r == 0x7f }) {
+		return strconv.Quote(p)
+	}
+	return p
+}
+
+// annotatedDiff is what the user wrote into the diff
+type annotatedDiff struct {
+	// Body is the summary of the review, the #> lines above the first file
+	Body     string
+	Comments []reviewComment
+
+	// lines are the lines of the diff left when the comments are taken out, with their line numbers in the
+	// edited text
+	lines   []string
+	numbers []int
+}
+
+// diffFile tracks the file of a diff being parsed
+type diffFile struct {
+	// header is the path guessed from the diff --git line, the others come from the lines below it
+	header, renameTo, oldPath, newPath string
+	// inHunks is set once the first hunk header was read
+	inHunks bool
+	// position counts the lines since the first hunk header, target is the position a comment below the
+	// current line is placed at, 0 when no comment may follow it
+	position, target int
+}
+
+// path is the path GitHub knows the file by: the new one, or the old one of a deleted file
+func (f *diffFile) path() string {
+	for _, p := range []string{f.newPath, f.renameTo, f.oldPath} {
+		if p != "" {
+			return p
+		}
+	}
+	return f.header
+}
+
+// parseAnnotatedDiff reads the comments of an annotated diff and places them by the position of the line above
+// them. Positions count from the first hunk header of each file, through the headers of later hunks, and start
+// over with every file, the way GitHub counts the lines of a file's patch.
+func parseAnnotatedDiff(text string) (annotatedDiff, error) {
+	var result annotatedDiff
+	var file *diffFile
+	var pending []string
+	pendingLine := 0
+
+	flush := func() error {
+		body := strings.TrimSpace(strings.Join(pending, "\n"))
+		pending = nil
+		switch {
+		case body == "":
+			return nil
+		case file == nil:
+			result.Body = strings.TrimSpace(result.Body + "\n\n" + body)
+			return nil
+		case file.target == 0:
+			return clierr.Newf(clierr.Usage, "line %d: a comment must be below a line of a hunk of %s", pendingLine, file.path())
+		}
+		result.Comments = append(result.Comments, reviewComment{Path: file.path(), Position: file.target, Body: body})
+		return nil
+	}
+
+	for i, line := range splitDiffLines(text) {
+		if rest, ok := strings.CutPrefix(line, annotationPrefix); ok {
+			if len(pending) == 0 {
+				pendingLine = i + 1
+			}
+			pending = append(pending, strings.TrimPrefix(rest, " "))
+			continue
+		}
+		if err := flush(); err != nil {
+			return result, err
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		result.lines = append(result.lines, strings.TrimRight(line, " \t"))
+		result.numbers = append(result.numbers, i+1)
+
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			file = &diffFile{header: gitHeaderPath(strings.TrimPrefix(line, "diff --git "))}
+		case file == nil:
+		case !file.inHunks:
+			if strings.HasPrefix(line, "@@") {
+				file.inHunks = true
+				continue
+			}
+			if p, ok := strings.CutPrefix(line, "rename to "); ok {
+				file.renameTo = unquoteDiffPath(p)
+			} else if p, ok := strings.CutPrefix(line, "--- "); ok {
+				file.oldPath = strings.TrimPrefix(unquoteDiffPath(p), "a/")
+			} else if p, ok := strings.CutPrefix(line, "+++ "); ok {
+				file.newPath = strings.TrimPrefix(unquoteDiffPath(p), "b/")
+			}
+			if file.oldPath == "/dev/null" {
+				file.oldPath = ""
+			}
+			if file.newPath == "/dev/null" {
+				file.newPath = ""
+			}
+		default:
+			file.position++
+			switch {
+			case strings.HasPrefix(line, "@@"):
+				file.target = 0
+			case strings.HasPrefix(line, `\`):
+				// "\ No newline at end of file" is counted but a comment below it is about the line above
+			default:
+				file.target = file.position
+			}
+		}
+	}
+	return result, flush()
+}
+
+// matches checks that only comments were added to diff, so the positions of the comments are those of the
+// lines the user put them below
+func (a annotatedDiff) matches(diff string) error {
+	want, _ := parseAnnotatedDiff(diff)
+	n := min(len(a.lines), len(want.lines))
+	for i := range n {
+		if a.lines[i] != want.lines[i] {
+			return clierr.Newf(clierr.Conflict, "line %d differs from the diff of the pull request: only add lines starting with #>, or the pull request changed since", a.numbers[i])
+		}
+	}
+	switch {
+	case len(a.lines) > n:
+		return clierr.Newf(clierr.Conflict, "line %d is not part of the diff of the pull request: only add lines starting with #>, or the pull request changed since", a.numbers[n])
+	case len(want.lines) > n:
+		return clierr.Newf(clierr.Conflict, "the annotated diff ends before the diff of the pull request does: only add lines starting with #>, or the pull request changed since")
+	}
+	return nil
+}
+
+// splitDiffLines splits text into lines, dropping the blank lines at its end an editor may add or strip
+func splitDiffLines(text string) []string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// gitHeaderPath guesses the new path from the names of a diff --git line, the lines below it are more reliable
+// as unquoted names are ambiguous when they hold spaces
+func gitHeaderPath(names string) string {
+	if strings.HasPrefix(names, `"`) {
+		if quoted, err := strconv.QuotedPrefix(names); err == nil {
+			names = strings.TrimSpace(strings.TrimPrefix(names, quoted))
+			return strings.TrimPrefix(unquoteDiffPath(names), "b/")
+		}
+	}
+	if strings.HasSuffix(names, `"`) {
+		if i := strings.LastIndex(names, ` "`); i >= 0 {
+			return strings.TrimPrefix(unquoteDiffPath(names[i+1:]), "b/")
+		}
+	}
+	// a/p b/p for an unrenamed file is the one split with the same name on both sides
+	if half := (len(names) - 5) / 2; half > 0 && len(names) == 2*half+5 {
+		if p := names[2 : 2+half]; names == "a/"+p+" b/"+p {
+			return p
+		}
+	}
+	if i := strings.LastIndex(names, " b/"); i >= 0 {
+		return names[i+3:]
+	}
+	return names
+}
+
+// unquoteDiffPath reads a path of a diff header, quoted by git when it holds special characters and followed by
+// a tab and a timestamp in diffs of other tools
+func unquoteDiffPath(p string) string {
+	if quoted, err := strconv.QuotedPrefix(p); err == nil {
+		if unquoted, err := strconv.Unquote(quoted); err == nil {
+			return unquoted
+		}
+	}
+	if i := strings.IndexByte(p, '\t'); i >= 0 {
+		p = p[:i]
+	}
+	return p
+}
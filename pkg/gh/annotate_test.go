@@ -0,0 +1,174 @@
+package gh
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// annotateFiles are the files of a PR covering the ways GitHub counts positions: two hunks, a rename with and
+// without edits, a deleted file and an added one ending without a newline, with a quoted path
+var annotateFiles = []PRFile{
+	{Filename: "main.go", Status: "modified", Patch: "@@ -1,3 +1,4 @@\n package main\n+import \"fmt\"\n \n func main() {\n@@ -10,3 +11,3 @@ func main() {\n-\told()\n+\tfmt.Println()\n }"},
+	{Filename: "pkg/new name.go", PreviousFilename: "pkg/old.go", Status: "renamed", Patch: "@@ -1 +1 @@\n-package old\n+package renamed"},
+	{Filename: "docs/moved.md", PreviousFilename: "moved.md", Status: "renamed"},
+	{Filename: "gone.txt", Status: "removed", Patch: "@@ -1,2 +0,0 @@\n-first gone\n-second gone"},
+	{Filename: "say \"hi\".txt", Status: "added", Patch: "@@ -0,0 +1,2 @@\n+one\n+two\n\\ No newline at end of file"},
+}
+
+// annotate puts comment lines right below the first line of diff equal to after, or at the top when after is ""
+func annotate(t *testing.T, diff string, after string, comment ...string) string {
+	t.Helper()
+	var block strings.Builder
+	for _, line := range comment {
+		block.WriteString(annotationPrefix + " " + line + "\n")
+	}
+	if after == "" {
+		return block.String() + diff
+	}
+	i := strings.Index("\n"+diff, "\n"+after+"\n")
+	if i < 0 {
+		t.Fatalf("no line %q in the diff", after)
+	}
+	end := i + len(after) + 1
+	return diff[:end] + block.String() + diff[end:]
+}
+
+func TestRenderPRDiff(t *testing.T) {
+	got := renderPRDiff(annotateFiles)
+	for _, want := range []string{
+		"diff --git a/main.go b/main.go\n--- a/main.go\n+++ b/main.go\n@@ -1,3 +1,4 @@\n",
+		"diff --git a/pkg/old.go b/pkg/new name.go\nrename from pkg/old.go\nrename to pkg/new name.go\n--- a/pkg/old.go\n+++ b/pkg/new name.go\n",
+		"diff --git a/moved.md b/docs/moved.md\nrename from moved.md\nrename to docs/moved.md\n# no diff shown",
+		"--- a/gone.txt\n+++ /dev/null\n",
+		"diff --git \"a/say \\\"hi\\\".txt\" \"b/say \\\"hi\\\".txt\"\n--- /dev/null\n+++ \"b/say \\\"hi\\\".txt\"\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("diff lacks\n%s\nin\n%s", want, got)
+		}
+	}
+}
+
+// TestParseAnnotatedDiffPositions places comments on every kind of line and checks the position GitHub expects
+// for each: counted from the first hunk header of the file, through later hunk headers and markers
+func TestParseAnnotatedDiffPositions(t *testing.T) {
+	diff := renderPRDiff(annotateFiles)
+	text := annotate(t, diff, "", "Summary line one", "", "Summary line two")
+	text = annotate(t, text, " package main", "first context line")
+	text = annotate(t, text, `+import "fmt"`, "an addition")
+	text = annotate(t, text, " func main() {", "last line of the first hunk")
+	// The second hunk header counts as a line, the lines below it go on
+	text = annotate(t, text, "-\told()", "a removal in the second hunk", "on two lines")
+	text = annotate(t, text, " }", "last line of the file")
+	text = annotate(t, text, "+package renamed", "in a renamed file")
+	text = annotate(t, text, "-second gone", "in a deleted file")
+	text = annotate(t, text, "+one", "in an added file")
+	// A comment below the marker is about the line above it
+	text = annotate(t, text, `\ No newline at end of file`, "below the marker")
+
+	got, err := parseAnnotatedDiff(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Body != "Summary line one\n\nSummary line two" {
+		t.Errorf("Body = %q", got.Body)
+	}
+	want := []reviewComment{
+		{"main.go", 1, "first context line"},
+		{"main.go", 2, "an addition"},
+		{"main.go", 4, "last line of the first hunk"},
+		{"main.go", 6, "a removal in the second hunk\non two lines"},
+		{"main.go", 8, "last line of the file"},
+		{"pkg/new name.go", 2, "in a renamed file"},
+		{"gone.txt", 2, "in a deleted file"},
+		{`say "hi".txt`, 1, "in an added file"},
+		{`say "hi".txt`, 2, "below the marker"},
+	}
+	if fmt.Sprint(got.Comments) != fmt.Sprint(want) {
+		t.Errorf("Comments =\n%v\nwant\n%v", got.Comments, want)
+	}
+
+	if err := got.matches(diff); err != nil {
+		t.Errorf("matches the diff it was written on: %v", err)
+	}
+	// An editor saving CRLF line ends changes nothing
+	crlf, err := parseAnnotatedDiff(strings.ReplaceAll(text, "\n", "\r\n"))
+	if err != nil || fmt.Sprint(crlf.Comments) != fmt.Sprint(want) {
+		t.Errorf("CRLF text = %v, %v", crlf.Comments, err)
+	}
+	if err := crlf.matches(diff); err != nil {
+		t.Errorf("CRLF text does not match: %v", err)
+	}
+}
+
+func TestParseAnnotatedDiffMisplaced(t *testing.T) {
+	diff := renderPRDiff(annotateFiles)
+	tests := []struct {
+		after string
+		want  string
+	}{
+		{"+++ b/main.go", "a comment must be below a line of a hunk of main.go"},
+		{"@@ -1,3 +1,4 @@", "a comment must be below a line of a hunk of main.go"},
+		{"@@ -10,3 +11,3 @@ func main() {", "a comment must be below a line of a hunk of main.go"},
+		{"rename to pkg/new name.go", "a comment must be below a line of a hunk of pkg/new name.go"},
+		{"rename to docs/moved.md", "a comment must be below a line of a hunk of docs/moved.md"},
+		{"# no diff shown, the file is binary or its diff too large", "a comment must be below a line of a hunk of docs/moved.md"},
+	}
+	for _, test := range tests {
+		text := annotate(t, diff, test.after, "misplaced")
+		line := strings.Count(text[:strings.Index(text, "#> misplaced")], "\n") + 1
+		want := fmt.Sprintf("line %d: %s", line, test.want)
+		if _, err := parseAnnotatedDiff(text); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("comment below %q = %v, want %q", test.after, err, want)
+		}
+	}
+}
+
+func TestAnnotatedDiffMatches(t *testing.T) {
+	diff := renderPRDiff(annotateFiles)
+	annotated, err := parseAnnotatedDiff(annotate(t, diff, "+one", "fine"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The PR changed since: a line edited in the fresh diff, one more file, or one less
+	changed := strings.Replace(diff, "+\tfmt.Println()", "+\tfmt.Printf(\"\")", 1)
+	if err := annotated.matches(changed); err == nil || !strings.Contains(err.Error(), "differs from the diff of the pull request") {
+		t.Errorf("edited diff line = %v", err)
+	}
+	longer := renderPRDiff(append(annotateFiles, PRFile{Filename: "extra.go", Status: "added", Patch: "@@ -0,0 +1 @@\n+package extra"}))
+	if err := annotated.matches(longer); err == nil || !strings.Contains(err.Error(), "ends before the diff of the pull request does") {
+		t.Errorf("longer diff = %v", err)
+	}
+	shorter := renderPRDiff(annotateFiles[:4])
+	if err := annotated.matches(shorter); err == nil || !strings.Contains(err.Error(), "is not part of the diff of the pull request") {
+		t.Errorf("shorter diff = %v", err)
+	}
+}
+
+func TestDiffHeaderPaths(t *testing.T) {
+	headers := map[string]string{
+		"a/main.go b/main.go":                   "main.go",
+		"a/with space.go b/with space.go":       "with space.go",
+		"a/b/ b.go b/b/ b.go":                   "b/ b.go",
+		"a/old.go b/new.go":                     "new.go",
+		`"a/say \"hi\".txt" "b/say \"hi\".txt"`: `say "hi".txt`,
+		`a/plain.txt "b/tab\there.txt"`:         "tab\there.txt",
+	}
+	for names, want := range headers {
+		if got := gitHeaderPath(names); got != want {
+			t.Errorf("gitHeaderPath(%q) = %q, want %q", names, got, want)
+		}
+	}
+	paths := map[string]string{
+		"b/main.go":                      "b/main.go",
+		"b/main.go\t2024-05-01 12:00:00": "b/main.go",
+		`"b/caf\303\251.go"`:             "b/café.go",
+		`"b/new\nline"`:                  "b/new\nline",
+	}
+	for p, want := range paths {
+		if got := unquoteDiffPath(p); got != want {
+			t.Errorf("unquoteDiffPath(%q) = %q, want %q", p, got, want)
+		}
+	}
+}
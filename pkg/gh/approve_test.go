@@ -0,0 +1,101 @@
+package gh
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"gsn-dev-tools/internals/clierr"
+)
+
+// reviewRecorder serves pullRequests and keeps the bodies of the reviews submitted
+type reviewRecorder struct {
+	mu      sync.Mutex
+	reviews []reviewRequest
+}
+
+func (r *reviewRecorder) handle(w http.ResponseWriter, req *http.Request, body []byte) {
+	if req.Method == http.MethodPost && strings.HasSuffix(req.URL.Path, "/reviews") {
+		var review reviewRequest
+		_ = json.Unmarshal(body, &review)
+		r.mu.Lock()
+		r.reviews = append(r.reviews, review)
+		r.mu.Unlock()
+	}
+	pullRequests(w, req, body)
+}
+
+// The fake PR 1 has a head of 39 zeros and a 1
+const fakeHeadSHA = "0000000000000000000000000000000000000001"
+
+func TestApproveRefusesMovedHead(t *testing.T) {
+	recorder := &reviewRecorder{}
+	server := newFakeGitHub(t, recorder.handle)
+	client := server.client(io.Discard)
+
+	_, err := approvePR(context.Background(), client, "https://github.com/owner/repo/pull/1", "abcdef1234", "LGTM")
+	if clierr.CodeOf(err) != clierr.Conflict {
+		t.Fatalf("approve with a moved head = %v, want a conflict", err)
+	}
+	for _, sha := range []string{"abcdef1234", fakeHeadSHA} {
+		if !strings.Contains(err.Error(), sha) {
+			t.Errorf("error %q does not name %s", err, sha)
+		}
+	}
+	if len(server.writes) > 0 {
+		t.Errorf("a review was submitted: %v", server.writes)
+	}
+}
+
+func TestApproveSendsCommitID(t *testing.T) {
+	for _, pinned := range []string{"", fakeHeadSHA, "0000000"} {
+		recorder := &reviewRecorder{}
+		server := newFakeGitHub(t, recorder.handle)
+		client := server.client(io.Discard)
+
+		a, err := submitApproval(context.Background(), client, "https://github.com/owner/repo/pull/1", pinned, "LGTM")
+		if err != nil {
+			t.Fatalf("approve pinned to %q: %v", pinned, err)
+		}
+		if a.ReviewID != 7 {
+			t.Errorf("review ID = %d, want 7", a.ReviewID)
+		}
+		if len(recorder.reviews) != 1 {
+			t.Fatalf("submitted %d reviews, want 1", len(recorder.reviews))
+		}
+		// The review names the head seen when the PR was resolved, also without --head-sha
+		if got := recorder.reviews[0]; got.CommitID != fakeHeadSHA || got.Event != "APPROVE" || got.Body != "LGTM" {
+			t.Errorf("review pinned to %q = %+v, want commit_id %s", pinned, got, fakeHeadSHA)
+		}
+	}
+}
+
+func TestHeadSHAOf(t *testing.T) {
+	server := newFakeGitHub(t, pullRequests)
+	sha, err := headSHAOf(context.Background(), server.client(io.Discard), "https://github.com/owner/repo/pull/1")
+	if err != nil || sha != fakeHeadSHA {
+		t.Errorf("headSHAOf = %q, %v, want %s", sha, err, fakeHeadSHA)
+	}
+}
+
+func TestMatchesSHA(t *testing.T) {
+	tests := []struct {
+		expected string
+		want     bool
+	}{
+		{fakeHeadSHA, true},
+		{strings.ToUpper("0000000"), true},
+		{" 0000000000 ", true},
+		{"000000", false}, // Too short to be unambiguous
+		{"1111111", false},
+	}
+	for _, test := range tests {
+		if got := matchesSHA(fakeHeadSHA, test.expected); got != test.want {
+			t.Errorf("matchesSHA(%q) = %v, want %v", test.expected, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package gh
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/style"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// fineGrainedTokenURL is where GitHub creates fine-grained personal access tokens
+const fineGrainedTokenURL = "https://github.com/settings/personal-access-tokens/new"
+
+// GhCmd groups GitHub account and repository management commands
+func GhCmd() *cobra.Command {
+	ghCmd := &cobra.Command{
+		Use:   "gh",
+		Short: "GitHub account and repository utilities",
+		Long: `Groups the commands that manage how gsn talks to GitHub. Tokens come from $GSN_GH_TOKEN, the token stored
+by gh auth login, or the credentials of the gh CLI.`,
+		Example: `  gsn gh auth login
+  gsn gh auth status
+  gsn gh branch-cleanup --dry-run`,
+	}
+
+	ghCmd.AddCommand(authCmd())
+	ghCmd.AddCommand(branchCleanupCmd())
+	ghCmd.AddCommand(statusCmd())
+	ghCmd.AddCommand(repoCmd())
+	ghCmd.AddCommand(queueCmd())
+	ghCmd.AddCommand(reportCmd())
+	return ghCmd
+}
+
+func authCmd() *cobra.Command {
+	authCmd := &cobra.Command{
+		Use:   "auth",
+		Short: "Manage the GitHub token used by gsn",
+		Long:  "Creates, inspects and checks the GitHub token gsn uses for its API calls.",
+		Example: `  gsn gh auth login
+  gsn gh auth check`,
+	}
+
+	authCmd.AddCommand(&cobra.Command{
+		Use:   "login",
+		Short: "Create and store a GitHub token for gsn",
+		Long: `Lists the permissions each command needs, reads a token from a hidden prompt, checks it against GitHub
+and stores it for later runs.`,
+		Example: "  gsn gh auth login",
+		Args:    cobra.NoArgs,
+		Run:     authLogin,
+	})
+	authCmd.AddCommand(&cobra.Command{
+		Use:   "status",
+		Short: "Show which host and token are active and their scopes",
+		Long:  "Prints the API host, where the active token comes from, the authenticated user and the token's scopes.",
+		Example: `  gsn gh auth status
+  GSN_GH_API_URL=https://ghe.example.com/api/v3 gsn gh auth status`,
+		Args: cobra.NoArgs,
+		Run:  authStatus,
+	})
+	authCmd.AddCommand(&cobra.Command{
+		Use:   "check",
+		Short: "Check the active token's scopes against what each command needs",
+		Long: `Compares the scopes of the active token with the scopes every command group needs and fails when one is
+missing. Fine-grained tokens do not report scopes, so the required permissions are listed instead.`,
+		Example: "  gsn gh auth check",
+		Args:    cobra.NoArgs,
+		Run:     authCheck,
+	})
+	return authCmd
+}
+
+func authLogin(cmd *cobra.Command, args []string) {
+	fmt.Printf("Create a fine-grained token at %s\n\n", fineGrainedTokenURL)
+	fmt.Println("Grant the repository permissions needed by the commands you use:")
+	for _, group := range commandScopes {
+		fmt.Printf("  %s\n", group.Commands)
+		for _, perm := range group.FineGrained {
+			fmt.Printf("    - %s\n", perm)
+		}
+	}
+	fmt.Printf("\nClassic tokens need the %q scope.\n\n", "repo")
+
+	token, err := readToken()
+	if err != nil {
+		clierr.Fatalf("Failed to read token: %v", err)
+	}
+	if token == "" {
+		clierr.Exitf(clierr.Usage, "No token provided")
+	}
+
+	client := NewClientWithBackend(NewHTTPBackend(apiURL(), token))
+	login, _, err := currentUser(cmd, client)
+	if err != nil {
+		clierr.Fatalf("Token rejected by GitHub: %v", err)
+	}
+
+	path, err := storeToken(token)
+	if err != nil {
+		clierr.Fatalf("Failed to store token: %v", err)
+	}
+	fmt.Printf(style.Success()+"Logged in as %s, token stored in %s\n", login, path)
+}
+
+func authStatus(cmd *cobra.Command, args []string) {
+	a, err := resolveAccess(Runner)
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+	fmt.Printf("Host:   %s\n", apiURL())
+
+	var client *Client
+	if a.Token != "" {
+		fmt.Printf("Token:  %s (from %s)\n", maskToken(a.Token), a.TokenSource)
+		client = NewClientWithBackend(NewHTTPBackend(apiURL(), a.Token))
+	} else {
+		fmt.Println("Token:  none, using the gh CLI credentials")
+		client = NewClientWithBackend(&cliBackend{runner: Runner})
+	}
+
+	login, scopes, err := currentUser(cmd, client)
+	if err != nil {
+		clierr.Fatalf("Authentication failed: %v", err)
+	}
+	fmt.Printf("User:   %s\n", login)
+	fmt.Printf("Scopes: %s\n", describeScopes(scopes))
+}
+
+func authCheck(cmd *cobra.Command, args []string) {
+	client, err := NewClient()
+	if err != nil {
+		clierr.Fatalf("%v", err)
+	}
+
+	login, scopes, err := currentUser(cmd, client)
+	if err != nil {
+		clierr.Fatalf("Authentication failed: %v", err)
+	}
+	fmt.Printf("Authenticated as %s\n\n", login)
+
+	if scopes == nil {
+		fmt.Println("Fine-grained tokens do not report scopes, make sure these permissions are granted:")
+		for _, group := range commandScopes {
+			fmt.Printf("  %s: %s\n", group.Commands, strings.Join(group.FineGrained, ", "))
+		}
+		return
+	}
+
+	failed := false
+	for _, group := range commandScopes {
+		if missing := missingScopes(scopes, group.Classic); len(missing) > 0 {
+			fmt.Printf(style.Failure()+"%s: missing %s\n", group.Commands, strings.Join(missing, ", "))
+			failed = true
+		} else {
+			fmt.Printf(style.Success()+"%s\n", group.Commands)
+		}
+	}
+	if failed {
+		clierr.Exit(clierr.Failure)
+	}
+}
+
+// currentUser fetches the authenticated login and the token scopes, nil scopes meaning none reported
+func currentUser(cmd *cobra.Command, client *Client) (string, []string, error) {
+	var user struct {
+		Login string `json:"login"`
+	}
+	resp, err := client.request(cmd.Context(), http.MethodGet, "/user", nil, &user)
+	if err != nil {
+		return "", nil, err
+	}
+
+	scopes, ok := parseScopes(resp.Header)
+	if ok && scopes == nil {
+		scopes = []string{}
+	}
+	return user.Login, scopes, nil
+}
+
+func describeScopes(scopes []string) string {
+	switch {
+	case scopes == nil:
+		return "not reported (fine-grained token)"
+	case len(scopes) == 0:
+		return "none"
+	default:
+		return strings.Join(scopes, ", ")
+	}
+}
+
+// readToken reads a token from the terminal without echoing it, or from piped stdin
+func readToken() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		fmt.Print("Paste your token: ")
+		data, err := term.ReadPassword(fd)
+		fmt.Println()
+		return strings.TrimSpace(string(data)), err
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
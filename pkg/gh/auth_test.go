@@ -0,0 +1,148 @@
+package gh
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestStoreToken(t *testing.T) {
+	t.Setenv("GSN_HOME", t.TempDir())
+	for _, name := range []string{"GSN_GH_TOKEN", "GITHUB_TOKEN", "GH_TOKEN"} {
+		t.Setenv(name, "")
+	}
+
+	path, err := storeToken("ghp_first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A second login replaces the token and tightens a file made readable meanwhile
+	if err := os.Chmod(path, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if path, err = storeToken("ghp_second"); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("token file mode = %v, want 0600", perm)
+	}
+	if dir, _ := os.Stat(filepath.Dir(path)); dir.Mode().Perm() != 0o700 {
+		t.Errorf("config dir mode = %v, want 0700", dir.Mode().Perm())
+	}
+
+	token, source := resolveToken()
+	if token != "ghp_second" || source != path {
+		t.Errorf("resolveToken = %q from %q, want ghp_second from %s", token, source, path)
+	}
+}
+
+func TestResolveTokenPrefersTheEnvironment(t *testing.T) {
+	t.Setenv("GSN_HOME", t.TempDir())
+	if _, err := storeToken("ghp_file"); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GSN_GH_TOKEN", "")
+	t.Setenv("GITHUB_TOKEN", "ghp_github")
+	t.Setenv("GH_TOKEN", "ghp_gh")
+	if token, source := resolveToken(); token != "ghp_github" || source != "env GITHUB_TOKEN" {
+		t.Errorf("resolveToken = %q from %q, want ghp_github from env GITHUB_TOKEN", token, source)
+	}
+	t.Setenv("GSN_GH_TOKEN", "ghp_gsn")
+	if token, _ := resolveToken(); token != "ghp_gsn" {
+		t.Errorf("resolveToken = %q, want GSN_GH_TOKEN first", token)
+	}
+}
+
+func TestMaskToken(t *testing.T) {
+	if got := maskToken("ghp_abcdefgh1234"); got != "********1234" {
+		t.Errorf("maskToken = %q", got)
+	}
+	if got := maskToken("abc"); got != "***" {
+		t.Errorf("maskToken of a short token = %q", got)
+	}
+}
+
+func TestParseScopes(t *testing.T) {
+	header := http.Header{}
+	if scopes, ok := parseScopes(header); ok || scopes != nil {
+		t.Errorf("no header = %v, %v, want not reported", scopes, ok)
+	}
+	header.Set("X-OAuth-Scopes", "")
+	if scopes, ok := parseScopes(header); !ok || len(scopes) != 0 {
+		t.Errorf("empty header = %v, %v, want reported and empty", scopes, ok)
+	}
+	header.Set("X-OAuth-Scopes", "repo, read:org,  gist")
+	if scopes, _ := parseScopes(header); !slices.Equal(scopes, []string{"repo", "read:org", "gist"}) {
+		t.Errorf("scopes = %v", scopes)
+	}
+}
+
+func TestMissingScopes(t *testing.T) {
+	tests := []struct {
+		granted, required, want []string
+	}{
+		{[]string{"repo"}, []string{"repo"}, nil},
+		{[]string{"repo"}, []string{"public_repo", "repo:status"}, nil},
+		{[]string{"admin:org"}, []string{"read:org"}, nil},
+		{[]string{"public_repo"}, []string{"repo"}, []string{"repo"}},
+		{nil, []string{"repo", "read:org"}, []string{"repo", "read:org"}},
+	}
+	for _, test := range tests {
+		if got := missingScopes(test.granted, test.required); !slices.Equal(got, test.want) {
+			t.Errorf("missingScopes(%v, %v) = %v, want %v", test.granted, test.required, got, test.want)
+		}
+	}
+}
+
+func TestCheckScopesWarnsOnce(t *testing.T) {
+	stderr := captureStderr(t, func() {
+		client := &Client{requiredScopes: []string{"repo"}}
+		// A response without the header, as from a fine-grained token, does not count as checked
+		client.checkScopes(&Response{Header: http.Header{}})
+		for range 2 {
+			client.checkScopes(&Response{Header: http.Header{"X-Oauth-Scopes": {"gist"}}})
+		}
+	})
+	if n := strings.Count(stderr, "missing required scopes: repo"); n != 1 {
+		t.Errorf("warned %d times, want once:\n%s", n, stderr)
+	}
+}
+
+// captureStdout runs f with os.Stdout sent to a temp file and returns what f wrote
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	return captureFile(t, &os.Stdout, f)
+}
+
+// captureStderr runs f with os.Stderr sent to a temp file and returns what f wrote
+func captureStderr(t *testing.T, f func()) string {
+	t.Helper()
+	return captureFile(t, &os.Stderr, f)
+}
+
+func captureFile(t *testing.T, std **os.File, f func()) string {
+	t.Helper()
+	file, err := os.CreateTemp(t.TempDir(), "output-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	saved := *std
+	*std = file
+	defer func() { *std = saved }()
+
+	f()
+
+	data, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,152 @@
+package gh
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/progress"
+	"gsn-dev-tools/internals/redact"
+	"gsn-dev-tools/internals/style"
+	"gsn-dev-tools/internals/units"
+
+	"github.com/schollz/progressbar/v3"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// defaultConcurrency is how many items a batch command processes at once unless --concurrency is set
+const defaultConcurrency = 4
+
+// batchOptions configures runBatch
+type batchOptions struct {
+	Concurrency int
+	Verbose     bool
+	// Verb names the operation in progress and failure messages, such as "approve"
+	Verb string
+	// Quiet draws no progress line, for output read by scripts
+	Quiet bool
+}
+
+// batchResult is the outcome of one item; Message is printed for successful items
+type batchResult struct {
+	Name     string
+	Message  string
+	Err      error
+	Duration time.Duration
+}
+
+// addBatchFlags registers the flags shared by commands that act on many pull requests
+func addBatchFlags(cmd *cobra.Command) {
+	cmd.Flags().IntP("concurrency", "j", defaultConcurrency, "Number of items processed at the same time")
+	cmd.Flags().BoolP("verbose", "v", false, "Print every item as it completes instead of a progress line")
+}
+
+// batchOptionsFromFlags reads the batch flags
+func batchOptionsFromFlags(cmd *cobra.Command, verb string) (batchOptions, error) {
+	concurrency, _ := cmd.Flags().GetInt("concurrency")
+	verbose, _ := cmd.Flags().GetBool("verbose")
+	if concurrency < 1 {
+		return batchOptions{}, clierr.Newf(clierr.Usage, "--concurrency must be at least 1")
+	}
+	return batchOptions{Concurrency: concurrency, Verbose: verbose, Verb: verb}, nil
+}
+
+// runBatch runs op for every item with at most opts.Concurrency workers. Results are returned in the order
+// of items whatever order they complete in. Workers share the client, so its rate limit budget pauses them all.
+func runBatch[T any](ctx context.Context, items []T, name func(T) string, opts batchOptions, op func(context.Context, T) (string, error)) []batchResult {
+	results := make([]batchResult, len(items))
+	workers := max(1, min(opts.Concurrency, len(items)))
+
+	var bar *progressbar.ProgressBar
+	if !opts.Verbose && !opts.Quiet && len(items) > 1 && term.IsTerminal(redact.Fd(os.Stderr)) {
+		bar = progress.NewCount(len(items), opts.Verb)
+	}
+
+	var mu sync.Mutex
+	done := 0
+	report := func(r batchResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		done++
+		switch {
+		case bar != nil:
+			bar.Add(1)
+		case opts.Verbose && r.Err != nil:
+			fmt.Fprintf(os.Stderr, "[%d/%d] %s failed after %s: %v\n", done, len(items), r.Name, units.FormatDuration(r.Duration), r.Err)
+		case opts.Verbose:
+			fmt.Fprintf(os.Stderr, "[%d/%d] %s done in %s\n", done, len(items), r.Name, units.FormatDuration(r.Duration))
+		}
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				start := time.Now()
+				r := batchResult{Name: name(items[i])}
+				if err := ctx.Err(); err != nil {
+					r.Err = err
+				} else {
+					r.Message, r.Err = op(ctx, items[i])
+				}
+				r.Duration = time.Since(start)
+				results[i] = r
+				report(r)
+			}
+		}()
+	}
+	for i := range items {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	if bar != nil {
+		bar.Finish()
+	}
+	return results
+}
+
+// printBatchSummary prints every result in input order and returns how many failed. Messages go to stdout,
+// failures and the totals to stderr.
+func printBatchSummary(results []batchResult, verb string) int {
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			fmt.Fprintf(os.Stderr, style.Error()+"Failed to %s %s: %v\n", verb, r.Name, r.Err)
+			failed++
+			continue
+		}
+		if r.Message != "" {
+			fmt.Println(r.Message)
+		}
+	}
+	if len(results) > 1 {
+		fmt.Fprintf(os.Stderr, "\n%d succeeded, %d failed.\n", len(results)-failed, failed)
+	}
+	return failed
+}
+
+// batchExitCode is the exit code of a batch with failures: the code they all share, or Failure when they differ
+func batchExitCode(results []batchResult) clierr.Code {
+	code := clierr.Success
+	for _, r := range results {
+		if r.Err == nil {
+			continue
+		}
+		switch c := clierr.CodeOf(r.Err); {
+		case code == clierr.Success:
+			code = c
+		case c != code:
+			return clierr.Failure
+		}
+	}
+	return code
+}
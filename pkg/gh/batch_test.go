@@ -0,0 +1,202 @@
+package gh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+)
+
+// slowAPI answers GET /items/<n> with n after a random delay, tracking how many requests are in flight. Run the
+// tests with -race: the workers share the client, its rate budget and the results.
+type slowAPI struct {
+	inFlight, peak atomic.Int32
+	mu             sync.Mutex
+	started        []time.Time
+	// rateLimit returns the X-RateLimit-Remaining and X-RateLimit-Reset headers of a response
+	rateLimit func() (int, time.Time)
+}
+
+func (s *slowAPI) handle(w http.ResponseWriter, r *http.Request, body []byte) {
+	n := s.inFlight.Add(1)
+	defer s.inFlight.Add(-1)
+	for {
+		peak := s.peak.Load()
+		if n <= peak || s.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	s.mu.Lock()
+	s.started = append(s.started, time.Now())
+	s.mu.Unlock()
+
+	time.Sleep(time.Duration(rand.IntN(20)) * time.Millisecond)
+	if s.rateLimit != nil {
+		remaining, reset := s.rateLimit()
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+	}
+	item := strings.TrimPrefix(r.URL.Path, "/items/")
+	if item == "13" {
+		http.Error(w, `{"message":"unlucky"}`, http.StatusUnprocessableEntity)
+		return
+	}
+	fmt.Fprintf(w, `{"item":%s}`, item)
+}
+
+// fetchItems runs a batch getting /items/<n> for every item through client
+func fetchItems(client *Client, items []int, concurrency int) []batchResult {
+	opts := batchOptions{Concurrency: concurrency, Verb: "fetch", Quiet: true}
+	return runBatch(context.Background(), items, strconv.Itoa, opts, func(ctx context.Context, n int) (string, error) {
+		var out struct {
+			Item int `json:"item"`
+		}
+		if err := client.Get(ctx, fmt.Sprintf("/items/%d", n), &out); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("item %d", out.Item), nil
+	})
+}
+
+func TestRunBatchConcurrently(t *testing.T) {
+	for _, concurrency := range []int{1, 3, 8} {
+		t.Run(fmt.Sprintf("concurrency %d", concurrency), func(t *testing.T) {
+			api := &slowAPI{rateLimit: func() (int, time.Time) { return 4000 + rand.IntN(900), time.Now().Add(time.Hour) }}
+			server := newFakeGitHub(t, api.handle)
+			client := server.client(nil)
+
+			items := make([]int, 40)
+			for i := range items {
+				items[i] = i
+			}
+			results := fetchItems(client, items, concurrency)
+
+			// Results are in input order whatever order they completed in
+			for i, r := range results {
+				if r.Name != strconv.Itoa(i) {
+					t.Fatalf("result %d is %s", i, r.Name)
+				}
+				if i == 13 {
+					if r.Err == nil || !strings.Contains(r.Err.Error(), "unlucky") {
+						t.Errorf("item 13 = %q, %v, want the API error", r.Message, r.Err)
+					}
+					continue
+				}
+				if r.Err != nil || r.Message != fmt.Sprintf("item %d", i) {
+					t.Errorf("item %d = %q, %v", i, r.Message, r.Err)
+				}
+			}
+			if peak := api.peak.Load(); peak > int32(concurrency) {
+				t.Errorf("%d requests in flight, the limit is %d", peak, concurrency)
+			}
+			if concurrency > 1 && api.peak.Load() < 2 {
+				t.Error("the batch never ran two requests at once")
+			}
+			if len(server.requests) != len(items) {
+				t.Errorf("%d requests for %d items", len(server.requests), len(items))
+			}
+		})
+	}
+}
+
+func TestRunBatchPausesEveryWorkerOnLowRateLimit(t *testing.T) {
+	// The first response leaves 3 requests in a window ending within the second, the next ones a full quota
+	reset := time.Now().Add(time.Second).Truncate(time.Second)
+	var calls atomic.Int32
+	api := &slowAPI{rateLimit: func() (int, time.Time) {
+		if calls.Add(1) == 1 {
+			return 3, reset
+		}
+		return 5000, reset.Add(time.Hour)
+	}}
+	server := newFakeGitHub(t, api.handle)
+	client := server.client(nil)
+
+	stderr := captureStderr(t, func() {
+		if err := client.Get(context.Background(), "/items/0", nil); err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range fetchItems(client, []int{1, 2, 3, 4, 5, 6}, 4) {
+			if r.Err != nil {
+				t.Errorf("%s: %v", r.Name, r.Err)
+			}
+		}
+	})
+
+	// No worker sent a request before the window reset, and the pause was announced once
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	for i, started := range api.started[1:] {
+		if started.Before(reset) {
+			t.Errorf("request %d started at %v, before the reset at %v", i+1, started, reset)
+		}
+	}
+	if strings.Count(stderr, "rate limit nearly used up (3 left)") != 1 {
+		t.Errorf("stderr = %q", stderr)
+	}
+}
+
+func TestRateBudget(t *testing.T) {
+	header := func(remaining int, reset time.Time) http.Header {
+		h := http.Header{}
+		h.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		h.Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		return h
+	}
+	window := time.Now().Add(time.Hour)
+
+	// Within a window the lowest count wins, a later window replaces it
+	b := newRateBudget(50)
+	b.observe(header(100, window))
+	b.observe(header(40, window))
+	b.observe(header(90, window))
+	if b.remaining != 40 {
+		t.Errorf("remaining = %d, want the lowest of the window", b.remaining)
+	}
+	b.observe(header(4999, window.Add(time.Hour)))
+	if b.remaining != 4999 {
+		t.Errorf("remaining = %d after the next window", b.remaining)
+	}
+	b.observe(http.Header{"X-Ratelimit-Remaining": {"garbage"}})
+	if b.remaining != 4999 {
+		t.Errorf("remaining = %d after a response without a valid header", b.remaining)
+	}
+
+	// Below the floor a wait ends with its context
+	b.observe(header(0, window.Add(2*time.Hour)))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	captureStderr(t, func() {
+		if err := b.wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("wait = %v, want the deadline", err)
+		}
+	})
+}
+
+func TestBatchExitCode(t *testing.T) {
+	notFound, conflict := clierr.Newf(clierr.NotFound, "gone"), clierr.Newf(clierr.Conflict, "conflict")
+	tests := []struct {
+		name    string
+		results []batchResult
+		want    clierr.Code
+	}{
+		{"all succeeded", []batchResult{{}, {}}, clierr.Success},
+		{"shared code", []batchResult{{Err: notFound}, {}, {Err: notFound}}, clierr.NotFound},
+		{"different codes", []batchResult{{Err: notFound}, {Err: conflict}}, clierr.Failure},
+		{"plain error", []batchResult{{}, {Err: errors.New("boom")}}, clierr.Failure},
+	}
+	for _, test := range tests {
+		if got := batchExitCode(test.results); got != test.want {
+			t.Errorf("%s: batchExitCode = %d, want %d", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package gh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/output"
+	"gsn-dev-tools/internals/style"
+	"gsn-dev-tools/internals/tui"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// defaultBotAuthors are the dependency bots whose PRs approve-bots targets by default
+var defaultBotAuthors = []string{"dependabot[bot]", "renovate[bot]"}
+
+func ApproveBotsCmd() *cobra.Command {
+	var repo string
+	var authors []string
+	var assumeYes, noPreview bool
+
+	botsCmd := &cobra.Command{
+		Use:   "approve-bots",
+		Short: "Approve every open, non-draft PR opened by dependency bots in a repository",
+		Long: `Lists the open pull requests of --repo and approves the non-draft ones opened by the --author logins,
+Dependabot and Renovate by default.
+
+Before approving it previews what the pull requests change, like gsn pr deps: the dependency, the version
+change and its semver class, the CI state and the changelog link, then asks for confirmation. --yes approves
+without asking, which is needed when stdin is not a terminal, and --no-preview skips the preview as well.`,
+		Example: `  gsn approve-bots --repo owner/repo
+  gsn approve-bots -R owner/repo --author "dependabot[bot]" --dry-run
+  gsn approve-bots -R owner/repo --yes
+  gsn approve-bots -R owner/repo -m "Auto-approved {title} ({files_changed} files)"`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			owner, name, ok := strings.Cut(repo, "/")
+			if !ok {
+				clierr.Exitf(clierr.Usage, "--repo must be in owner/repo format")
+			}
+			rememberRepos(repo)
+
+			message, err := reviewMessageFromFlags(cmd)
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			client, err := NewClient("repo")
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			if client.Queue, err = OpenQueue(); err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			prs, err := client.ListOpenPRs(cmd.Context(), owner, name)
+			if err != nil {
+				clierr.Fatalf("Failed to list pull requests: %v", err)
+			}
+
+			opts, err := batchOptionsFromFlags(cmd, "approve")
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			var matched []PRDetails
+			for _, pr := range prs {
+				if !pr.Draft && slices.Contains(authors, pr.User.Login) {
+					matched = append(matched, pr)
+				}
+			}
+
+			fmt.Printf("%d bot PR(s) matched.\n", len(matched))
+			if len(matched) > 0 && !noPreview {
+				if err := previewBotPRs(cmd.Context(), client, owner, name, matched, opts.Concurrency); err != nil {
+					clierr.Fatalf("%v", err)
+				}
+			}
+			if len(matched) > 0 && !assumeYes && !client.DryRun {
+				if !term.IsTerminal(int(os.Stdin.Fd())) {
+					clierr.Exitf(clierr.Usage, "refusing to approve without confirmation, rerun with --yes")
+				}
+				if !tui.Confirm(fmt.Sprintf("Approve %d bot PR(s) in %s?", len(matched), repo)) {
+					clierr.Exitf(clierr.Failure, "approval cancelled")
+				}
+			}
+			prName := func(pr PRDetails) string { return PRRef{Owner: owner, Repo: name, Number: pr.Number}.String() }
+			results := runBatch(cmd.Context(), matched, prName, opts, func(ctx context.Context, pr PRDetails) (string, error) {
+				ref := PRRef{Owner: owner, Repo: name, Number: pr.Number}
+				if usesStatPlaceholders(message) {
+					// The list endpoint leaves out the diff stats
+					full, err := client.GetPR(ctx, ref)
+					if err != nil {
+						return "", err
+					}
+					pr = *full
+				}
+				body, err := renderReviewMessage(message, ref, &pr)
+				if err != nil {
+					return "", err
+				}
+				if _, err := client.Approve(ctx, ref, pr.Head.SHA, body); errors.Is(err, ErrQueued) {
+					return fmt.Sprintf(style.Warning()+"GitHub is unreachable, queued the approval of %s (%v)", ref, err), nil
+				} else if err != nil {
+					return "", err
+				}
+				if client.DryRun {
+					return fmt.Sprintf("Would approve %s: %s", ref, pr.Title), nil
+				}
+				return fmt.Sprintf(style.Celebrate()+"Approved %s: %s", ref, pr.Title), nil
+			})
+
+			if printBatchSummary(results, opts.Verb) > 0 {
+				clierr.Exit(batchExitCode(results))
+			}
+		},
+	}
+
+	botsCmd.Flags().StringVarP(&repo, "repo", "R", "", "Repository in owner/repo format")
+	_ = botsCmd.RegisterFlagCompletionFunc("repo", completeRepos)
+	botsCmd.Flags().StringSliceVar(&authors, "author", defaultBotAuthors, "Bot logins whose PRs are approved")
+	addReviewMessageFlags(botsCmd)
+	addBatchFlags(botsCmd)
+	botsCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Approve without asking for confirmation")
+	botsCmd.Flags().BoolVar(&noPreview, "no-preview", false, "Skip the preview of the dependency updates")
+	_ = botsCmd.MarkFlagRequired("repo")
+	dryrun.Adopt(botsCmd)
+	return botsCmd
+}
+
+// previewBotPRs prints the dependency updates of the matched pull requests. The list endpoint carries their
+// title, body and head, only the CI state is looked up.
+func previewBotPRs(ctx context.Context, client *Client, owner string, name string, prs []PRDetails, concurrency int) error {
+	previews := make([]depPreview, len(prs))
+	indexes := make([]int, len(prs))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	runBatch(ctx, indexes, func(i int) string { return fmt.Sprint(prs[i].Number) }, batchOptions{Concurrency: concurrency, Quiet: true},
+		func(ctx context.Context, i int) (string, error) {
+			previews[i] = previewDeps(ctx, client, PRRef{Owner: owner, Repo: name, Number: prs[i].Number}, &prs[i])
+			return "", nil
+		})
+
+	fmt.Println()
+	if err := output.Render(os.Stdout, depColumns, depRows(previews), output.TerminalOptions()); err != nil {
+		return err
+	}
+	fmt.Println()
+	return nil
+}
@@ -0,0 +1,267 @@
+package gh
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/style"
+	"gsn-dev-tools/internals/tui"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// branchBatchSize bounds how many branches are looked up in a single GraphQL query
+const branchBatchSize = 50
+
+// localBranch is a local branch and its upstream tracking state
+type localBranch struct {
+	Name         string
+	SHA          string
+	Current      bool
+	Remote       string
+	RemoteRef    string
+	UpstreamGone bool
+	Ahead        int
+}
+
+// branchPR is the most recent pull request opened from a branch
+type branchPR struct {
+	Number     int    `json:"number"`
+	State      string `json:"state"`
+	URL        string `json:"url"`
+	HeadRefOid string `json:"headRefOid"`
+}
+
+// cleanupCandidate is a branch together with its pull request and why it is kept, if it is
+type cleanupCandidate struct {
+	Branch localBranch
+	PR     *branchPR
+	Keep   string
+}
+
+func branchCleanupCmd() *cobra.Command {
+	var deleteRemote, assumeYes bool
+
+	cleanupCmd := &cobra.Command{
+		Use:   "branch-cleanup",
+		Short: "Delete local branches whose pull requests were merged or closed",
+		Long: `Looks up the latest pull request of every local branch with one GraphQL query per 50 branches and offers to
+delete the branches whose pull request is merged or closed. The default branch, the checked out branch and
+branches with commits that are not part of their pull request or not pushed are never deleted. With
+--delete-remote the upstream branch is deleted as well.
+
+--delete-remote used to be --remote, which is now the global flag naming the SSH host of remote mode.
+branch-cleanup does not run remotely, so gsn gh branch-cleanup --remote is refused instead of deleting the
+upstream branches.`,
+		Example: `  gsn gh branch-cleanup --dry-run
+  gsn gh branch-cleanup --delete-remote
+  gsn gh branch-cleanup -R owner/repo --yes`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			repo, _ := cmd.Flags().GetString("repo")
+			ctx := cmd.Context()
+
+			owner, name, err := resolveRepo(ctx, repo)
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			branches, err := listLocalBranches(ctx)
+			if err != nil {
+				clierr.Fatalf("Failed to list branches: %v", err)
+			}
+
+			client, err := NewClient("repo")
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			defaultBranch, prs, err := client.branchPullRequests(ctx, owner, name, branches)
+			if err != nil {
+				clierr.Fatalf("Failed to look up pull requests: %v", err)
+			}
+
+			candidates := classifyBranches(branches, prs, defaultBranch)
+			deletable := 0
+			for _, c := range candidates {
+				if c.Keep == "" {
+					deletable++
+				}
+			}
+			if deletable == 0 {
+				fmt.Println("No branches to clean up.")
+				return
+			}
+			if !dryrun.Enabled() && !assumeYes && !term.IsTerminal(int(os.Stdin.Fd())) {
+				clierr.Exitf(clierr.Usage, "Refusing to delete branches without confirmation, rerun with --yes")
+			}
+
+			deleted, failed := 0, 0
+			for _, c := range candidates {
+				label := fmt.Sprintf("%s (#%d %s)", c.Branch.Name, c.PR.Number, strings.ToLower(c.PR.State))
+				if c.Keep != "" {
+					fmt.Printf("Keeping %s: %s\n", label, c.Keep)
+					continue
+				}
+				if dryrun.Enabled() {
+					fmt.Printf("Would delete %s\n", label)
+					continue
+				}
+				if !assumeYes && !tui.Confirm(fmt.Sprintf("Delete %s?", label)) {
+					continue
+				}
+
+				if err := deleteBranch(ctx, c.Branch, deleteRemote); err != nil {
+					fmt.Fprintf(os.Stderr, style.Error()+"Failed to delete %s: %v\n", c.Branch.Name, err)
+					failed++
+					continue
+				}
+				fmt.Printf(style.Trash()+"Deleted %s\n", label)
+				deleted++
+			}
+
+			if !dryrun.Enabled() {
+				fmt.Printf(style.Success()+"Deleted %d branch(es)\n", deleted)
+			}
+			if failed > 0 {
+				clierr.Exit(clierr.Failure)
+			}
+		},
+	}
+
+	cleanupCmd.Flags().StringP("repo", "R", "", "Repository in owner/repo format (defaults to the origin remote)")
+	_ = cleanupCmd.RegisterFlagCompletionFunc("repo", completeRepos)
+	cleanupCmd.Flags().BoolVar(&deleteRemote, "delete-remote", false, "Also delete the upstream branch on the remote")
+	cleanupCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Delete without asking for each branch")
+	dryrun.Adopt(cleanupCmd)
+	return cleanupCmd
+}
+
+// listLocalBranches reads every local branch with its upstream and ahead count
+func listLocalBranches(ctx context.Context) ([]localBranch, error) {
+	format := strings.Join([]string{"%(refname:short)", "%(objectname)", "%(HEAD)", "%(upstream:remotename)", "%(upstream:remoteref)", "%(upstream:track)"}, "%00")
+	out, err := runGit(ctx, "for-each-ref", "--format="+format, "refs/heads")
+	if err != nil {
+		return nil, err
+	}
+
+	var branches []localBranch
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, "\x00")
+		if len(fields) != 6 {
+			continue
+		}
+		b := localBranch{
+			Name:         fields[0],
+			SHA:          fields[1],
+			Current:      fields[2] == "*",
+			Remote:       fields[3],
+			RemoteRef:    strings.TrimPrefix(fields[4], "refs/heads/"),
+			UpstreamGone: fields[5] == "[gone]",
+		}
+		b.Ahead = parseAhead(fields[5])
+		branches = append(branches, b)
+	}
+	return branches, nil
+}
+
+// parseAhead reads the ahead count out of a tracking state such as "[ahead 2, behind 1]"
+func parseAhead(track string) int {
+	track = strings.Trim(track, "[]")
+	for _, part := range strings.Split(track, ", ") {
+		if n, ok := strings.CutPrefix(part, "ahead "); ok {
+			ahead, _ := strconv.Atoi(n)
+			return ahead
+		}
+	}
+	return 0
+}
+
+// branchPullRequests returns the default branch and the latest pull request of each branch, batching the lookups
+func (c *Client) branchPullRequests(ctx context.Context, owner string, name string, branches []localBranch) (string, map[string]*branchPR, error) {
+	prs := make(map[string]*branchPR)
+	var defaultBranch string
+
+	for start := 0; start < len(branches); start += branchBatchSize {
+		batch := branches[start:min(start+branchBatchSize, len(branches))]
+
+		var fields, params []string
+		variables := map[string]any{"owner": owner, "name": name}
+		for i, b := range batch {
+			params = append(params, fmt.Sprintf("$b%d: String!", i))
+			fields = append(fields, fmt.Sprintf("b%d: pullRequests(headRefName: $b%d, first: 1, orderBy: {field: CREATED_AT, direction: DESC}) { nodes { number state url headRefOid } }", i, i))
+			variables[fmt.Sprintf("b%d", i)] = b.Name
+		}
+		query := fmt.Sprintf("query($owner: String!, $name: String!%s) { repository(owner: $owner, name: $name) { defaultBranchRef { name } %s } }",
+			prefixJoin(", ", params), strings.Join(fields, " "))
+
+		var data struct {
+			Repository map[string]struct {
+				Name  string     `json:"name"`
+				Nodes []branchPR `json:"nodes"`
+			} `json:"repository"`
+		}
+		if err := c.GraphQL(ctx, query, variables, &data); err != nil {
+			return "", nil, err
+		}
+
+		defaultBranch = data.Repository["defaultBranchRef"].Name
+		for i, b := range batch {
+			if nodes := data.Repository[fmt.Sprintf("b%d", i)].Nodes; len(nodes) > 0 {
+				prs[b.Name] = &nodes[0]
+			}
+		}
+	}
+	return defaultBranch, prs, nil
+}
+
+// prefixJoin joins parts with sep and prefixes the result with sep when it is not empty
+func prefixJoin(sep string, parts []string) string {
+	if len(parts) == 0 {
+		return ""
+	}
+	return sep + strings.Join(parts, sep)
+}
+
+// classifyBranches returns the branches with a merged or closed pull request and why any of them must be kept
+func classifyBranches(branches []localBranch, prs map[string]*branchPR, defaultBranch string) []cleanupCandidate {
+	var candidates []cleanupCandidate
+	for _, b := range branches {
+		pr := prs[b.Name]
+		if pr == nil || pr.State == "OPEN" {
+			continue
+		}
+
+		c := cleanupCandidate{Branch: b, PR: pr}
+		switch {
+		case b.Name == defaultBranch:
+			c.Keep = "default branch"
+		case b.Current:
+			c.Keep = "checked out"
+		case b.Ahead > 0:
+			c.Keep = fmt.Sprintf("%d unpushed commit(s)", b.Ahead)
+		case b.SHA != pr.HeadRefOid:
+			c.Keep = "has commits that are not part of the pull request"
+		}
+		candidates = append(candidates, c)
+	}
+	return candidates
+}
+
+// deleteBranch force deletes the local branch, which is needed after squash merges, and optionally its upstream
+func deleteBranch(ctx context.Context, b localBranch, remote bool) error {
+	if _, err := runGit(ctx, "branch", "-D", b.Name); err != nil {
+		return err
+	}
+	if !remote || b.Remote == "" || b.UpstreamGone {
+		return nil
+	}
+	if _, err := runGit(ctx, "push", b.Remote, "--delete", b.RemoteRef); err != nil {
+		return fmt.Errorf("local branch deleted, remote branch kept: %w", err)
+	}
+	return nil
+}
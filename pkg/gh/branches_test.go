@@ -0,0 +1,246 @@
+package gh
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"gsn-dev-tools/internals/execx"
+)
+
+// useFakeRunner swaps Runner for fake until the test ends
+func useFakeRunner(t *testing.T, fake *execx.Fake) {
+	t.Helper()
+	previous := Runner
+	Runner = fake
+	t.Cleanup(func() { Runner = previous })
+}
+
+// forEachRef renders branches the way listLocalBranches asks git for-each-ref to print them
+func forEachRef(branches ...[6]string) string {
+	var lines []string
+	for _, b := range branches {
+		lines = append(lines, strings.Join(b[:], "\x00"))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// cleanupBranches covers every reason to keep or delete a branch, main being the default branch
+var cleanupBranches = forEachRef(
+	[6]string{"main", "m1", "", "origin", "refs/heads/main", ""},
+	[6]string{"feature-merged", "a1", "", "origin", "refs/heads/feature-merged", ""},
+	[6]string{"feature-closed", "c1", "", "origin", "refs/heads/feature-closed", "[gone]"},
+	[6]string{"feature-open", "o1", "", "origin", "refs/heads/feature-open", ""},
+	[6]string{"feature-ahead", "h1", "", "origin", "refs/heads/feature-ahead", "[ahead 2, behind 1]"},
+	[6]string{"feature-amended", "x2", "", "origin", "refs/heads/feature-amended", ""},
+	[6]string{"current", "u1", "*", "", "", ""},
+	[6]string{"no-pr", "n1", "", "", "", ""},
+)
+
+// branchPRs are the pull requests of cleanupBranches, by head branch
+var branchPRs = map[string]branchPR{
+	"main":            {Number: 1, State: "MERGED", HeadRefOid: "m1"},
+	"feature-merged":  {Number: 2, State: "MERGED", HeadRefOid: "a1"},
+	"feature-closed":  {Number: 3, State: "CLOSED", HeadRefOid: "c1"},
+	"feature-open":    {Number: 4, State: "OPEN", HeadRefOid: "o1"},
+	"feature-ahead":   {Number: 5, State: "MERGED", HeadRefOid: "h1"},
+	"feature-amended": {Number: 6, State: "MERGED", HeadRefOid: "x1"},
+	"current":         {Number: 7, State: "CLOSED", HeadRefOid: "u1"},
+}
+
+// branchGraphQL answers the batched pull request queries of branchPullRequests from prs, counting the queries
+func branchGraphQL(prs map[string]branchPR, queries *int) func(w http.ResponseWriter, r *http.Request, body []byte) {
+	return func(w http.ResponseWriter, r *http.Request, body []byte) {
+		var req struct {
+			Query     string         `json:"query"`
+			Variables map[string]any `json:"variables"`
+		}
+		if r.URL.Path != "/graphql" || json.Unmarshal(body, &req) != nil {
+			http.NotFound(w, r)
+			return
+		}
+		*queries++
+		repository := map[string]any{"defaultBranchRef": map[string]any{"name": "main"}}
+		for key, value := range req.Variables {
+			if !strings.HasPrefix(key, "b") {
+				continue
+			}
+			nodes := []branchPR{}
+			if pr, ok := prs[value.(string)]; ok {
+				nodes = append(nodes, pr)
+			}
+			repository[key] = map[string]any{"nodes": nodes}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"repository": repository}})
+	}
+}
+
+func TestListLocalBranches(t *testing.T) {
+	fake := &execx.Fake{}
+	useFakeRunner(t, fake)
+	fake.Expect("git", "for-each-ref", execx.HasPrefix("--format=%(refname:short)%00"), "refs/heads").Return(cleanupBranches, 0)
+
+	branches, err := listLocalBranches(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(branches) != 8 {
+		t.Fatalf("parsed %d branches", len(branches))
+	}
+	want := localBranch{Name: "feature-ahead", SHA: "h1", Remote: "origin", RemoteRef: "feature-ahead", Ahead: 2}
+	if branches[4] != want {
+		t.Errorf("feature-ahead = %+v, want %+v", branches[4], want)
+	}
+	if !branches[2].UpstreamGone || !branches[6].Current || branches[7].Remote != "" {
+		t.Errorf("branches = %+v", branches)
+	}
+	if err := fake.Verify(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClassifyBranches(t *testing.T) {
+	fake := &execx.Fake{}
+	useFakeRunner(t, fake)
+	fake.Expect("git", execx.Rest()).Return(cleanupBranches, 0)
+	branches, _ := listLocalBranches(context.Background())
+
+	prs := map[string]*branchPR{}
+	for name, pr := range branchPRs {
+		prs[name] = &pr
+	}
+	var got []string
+	for _, c := range classifyBranches(branches, prs, "main") {
+		got = append(got, fmt.Sprintf("%s: %s", c.Branch.Name, c.Keep))
+	}
+	want := []string{
+		"main: default branch",
+		"feature-merged: ",
+		"feature-closed: ",
+		"feature-ahead: 2 unpushed commit(s)",
+		"feature-amended: has commits that are not part of the pull request",
+		"current: checked out",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("candidates =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestBranchPullRequestsBatchesQueries(t *testing.T) {
+	var branches []localBranch
+	prs := map[string]branchPR{}
+	for i := range branchBatchSize + 3 {
+		name := fmt.Sprintf("branch-%02d", i)
+		branches = append(branches, localBranch{Name: name})
+		if i%2 == 0 {
+			prs[name] = branchPR{Number: i, State: "MERGED"}
+		}
+	}
+	queries := 0
+	server := newFakeGitHub(t, branchGraphQL(prs, &queries))
+
+	defaultBranch, found, err := server.client(nil).branchPullRequests(context.Background(), "owner", "repo", branches)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queries != 2 || defaultBranch != "main" || len(found) != len(prs) {
+		t.Errorf("%d queries, default %q, %d pull requests found, want 2, main and %d", queries, defaultBranch, len(found), len(prs))
+	}
+	if pr := found["branch-52"]; pr == nil || pr.Number != 52 {
+		t.Errorf("the second batch lost branch-52: %+v", pr)
+	}
+}
+
+func TestDeleteBranch(t *testing.T) {
+	tests := []struct {
+		name   string
+		branch localBranch
+		remote bool
+		expect func(f *execx.Fake)
+	}{
+		{"local only", localBranch{Name: "a", Remote: "origin", RemoteRef: "a"}, false, func(f *execx.Fake) {
+			f.Expect("git", "branch", "-D", "a")
+		}},
+		{"with upstream", localBranch{Name: "a", Remote: "fork", RemoteRef: "topic/a"}, true, func(f *execx.Fake) {
+			f.Expect("git", "branch", "-D", "a")
+			f.Expect("git", "push", "fork", "--delete", "topic/a")
+		}},
+		{"upstream gone", localBranch{Name: "a", Remote: "origin", RemoteRef: "a", UpstreamGone: true}, true, func(f *execx.Fake) {
+			f.Expect("git", "branch", "-D", "a")
+		}},
+		{"no upstream", localBranch{Name: "a"}, true, func(f *execx.Fake) {
+			f.Expect("git", "branch", "-D", "a")
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := &execx.Fake{InOrder: true}
+			useFakeRunner(t, fake)
+			test.expect(fake)
+			if err := deleteBranch(context.Background(), test.branch, test.remote); err != nil {
+				t.Fatal(err)
+			}
+			if err := fake.Verify(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+
+	fake := &execx.Fake{InOrder: true}
+	useFakeRunner(t, fake)
+	fake.Expect("git", "branch", "-D", "a")
+	fake.Expect("git", "push", "origin", "--delete", "a").Return("", 1).Stderr("remote: permission denied")
+	err := deleteBranch(context.Background(), localBranch{Name: "a", Remote: "origin", RemoteRef: "a"}, true)
+	if err == nil || !strings.Contains(err.Error(), "local branch deleted, remote branch kept") {
+		t.Errorf("failed push = %v", err)
+	}
+}
+
+func TestBranchCleanupCommand(t *testing.T) {
+	queries := 0
+	server := newFakeGitHub(t, branchGraphQL(branchPRs, &queries))
+	t.Setenv("GSN_GH_TOKEN", "test-token")
+	t.Setenv("GSN_GH_API_URL", server.URL)
+	// -R records the repository for completion, in a state dir of the test
+	t.Setenv("GSN_HOME", t.TempDir())
+
+	fake := &execx.Fake{InOrder: true}
+	useFakeRunner(t, fake)
+	fake.Expect("git", "for-each-ref", execx.Rest()).Return(cleanupBranches, 0)
+	fake.Expect("git", "branch", "-D", "feature-merged")
+	fake.Expect("git", "push", "origin", "--delete", "feature-merged")
+	fake.Expect("git", "branch", "-D", "feature-closed")
+
+	cmd := branchCleanupCmd()
+	cmd.SetArgs([]string{"-R", "owner/repo", "--yes", "--delete-remote"})
+	out := captureStdout(t, func() {
+		if err := cmd.Execute(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	for _, want := range []string{
+		"Keeping main (#1 merged): default branch",
+		"Deleted feature-merged (#2 merged)",
+		"Deleted feature-closed (#3 closed)",
+		"Keeping feature-ahead (#5 merged): 2 unpushed commit(s)",
+		"Keeping current (#7 closed): checked out",
+		"Deleted 2 branch(es)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output misses %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "feature-open") || strings.Contains(out, "no-pr") {
+		t.Errorf("branches without a closed pull request were listed:\n%s", out)
+	}
+	if err := fake.Verify(); err != nil {
+		t.Error(err)
+	}
+	if len(server.writes) != 0 || queries != 1 {
+		t.Errorf("%d queries, writes %v", queries, server.writes)
+	}
+}
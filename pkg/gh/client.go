@@ -0,0 +1,499 @@
+package gh
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/execx"
+	"gsn-dev-tools/internals/style"
+)
+
+// defaultAPIURL is the GitHub REST endpoint used unless GSN_GH_API_URL overrides it
+const defaultAPIURL = "https://api.github.com"
+
+// ErrReadOnly is returned for mutating requests while GSN_GH_READONLY is set
+var ErrReadOnly = errors.New("GSN_GH_READONLY is set, refusing to send write requests to GitHub")
+
+// ErrNetwork marks requests that never got a response, e.g. while offline
+var ErrNetwork = errors.New("network unavailable")
+
+// ErrNotSent marks network errors raised before the request left, a failed dial or DNS lookup, so sending it
+// again cannot apply it twice
+var ErrNotSent = errors.New("request not sent")
+
+// Runner executes the gh and git binaries, tests replace it with an execx.Fake
+var Runner execx.Runner = execx.Default
+
+// MissingGhError reports that the gh CLI cannot be run and there is no token to use the API without it
+type MissingGhError struct {
+	Err error
+}
+
+func (e *MissingGhError) Error() string {
+	return "the gh CLI is not installed and no GitHub token is set. Either install gh (https://cli.github.com) and " +
+		"run gh auth login, or set GSN_GH_TOKEN (or run gsn gh auth login) so gsn talks to the GitHub API directly"
+}
+
+func (e *MissingGhError) Unwrap() error {
+	return e.Err
+}
+
+// access is what gsn can reach GitHub with: a token for the API, the gh CLI, or both
+type access struct {
+	Token       string
+	TokenSource string
+	GhCLI       bool
+}
+
+// resolveAccess looks up the token and the gh CLI. Every gh subcommand goes through it, so a missing gh is
+// reported the same way everywhere: a MissingGhError when there is no token either.
+func resolveAccess(runner execx.Runner) (access, error) {
+	var a access
+	a.Token, a.TokenSource = resolveToken()
+	_, err := runner.LookPath("gh")
+	a.GhCLI = err == nil
+	if a.Token == "" && !a.GhCLI {
+		return a, &MissingGhError{Err: err}
+	}
+	return a, nil
+}
+
+// Response is the raw result of a GitHub API call
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// APIError is returned when GitHub answers with a non 2xx status
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s", e.Method, e.Path, e.StatusCode, e.Message)
+}
+
+// ExitCode maps the status to the exit code of gsn: a missing resource is NotFound, a request GitHub refused
+// in the current state of the resource is a Conflict
+func (e *APIError) ExitCode() clierr.Code {
+	switch e.StatusCode {
+	case http.StatusNotFound:
+		return clierr.NotFound
+	case http.StatusMethodNotAllowed, http.StatusConflict, http.StatusPreconditionFailed, http.StatusUnprocessableEntity:
+		return clierr.Conflict
+	}
+	return clierr.Failure
+}
+
+// Backend performs a single GitHub API request
+type Backend interface {
+	Do(ctx context.Context, method string, path string, body []byte) (*Response, error)
+}
+
+// Client wraps a Backend and separates read requests from mutating ones.
+// Reads always go through so filters and previews stay accurate, writes are
+// printed instead of sent in dry-run mode and refused in read-only mode.
+// A Client is safe for concurrent use.
+type Client struct {
+	backend  Backend
+	DryRun   bool
+	ReadOnly bool
+	Out      io.Writer
+
+	// requiredScopes are checked against X-OAuth-Scopes on the first response
+	requiredScopes []string
+	scopesChecked  bool
+	scopesMu       sync.Mutex
+
+	// rate is shared by every request so concurrent batch workers pause together
+	rate *rateBudget
+
+	// Queue, when set, receives writes that fail with ErrNotSent, and every write while Offline is set
+	Queue   *Queue
+	Offline bool
+}
+
+// NewClient picks the direct API backend when a token is configured and falls back to the gh binary.
+// The scopes the invoking command needs are verified once the first response arrives, and writes are only
+// printed under --dry-run.
+func NewClient(requiredScopes ...string) (*Client, error) {
+	a, err := resolveAccess(Runner)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		DryRun:         dryrun.Enabled(),
+		ReadOnly:       os.Getenv("GSN_GH_READONLY") == "1",
+		Out:            os.Stdout,
+		requiredScopes: requiredScopes,
+		rate:           newRateBudget(rateLimitFloor),
+	}
+	if a.Token != "" {
+		c.backend = NewHTTPBackend(apiURL(), a.Token)
+	} else {
+		c.backend = &cliBackend{runner: Runner}
+	}
+	return c, nil
+}
+
+// NewClientWithBackend builds a client around an explicit backend
+func NewClientWithBackend(backend Backend) *Client {
+	return &Client{backend: backend, Out: os.Stdout, rate: newRateBudget(rateLimitFloor)}
+}
+
+func apiURL() string {
+	if url := os.Getenv("GSN_GH_API_URL"); url != "" {
+		return strings.TrimSuffix(url, "/")
+	}
+	return defaultAPIURL
+}
+
+// IsWrite reports whether a request mutates state on GitHub. A GraphQL POST only does when its document is a
+// mutation.
+func IsWrite(method string, path string, body []byte) bool {
+	switch {
+	case method == http.MethodGet || method == http.MethodHead:
+		return false
+	case method == http.MethodPost && path == "/graphql":
+		var doc struct {
+			Query string `json:"query"`
+		}
+		return json.Unmarshal(body, &doc) != nil || isMutation(doc.Query)
+	}
+	return true
+}
+
+// isMutation reports whether a GraphQL document starts with a mutation, skipping whitespace and comments.
+// Anything else is a query, including the { ... } shorthand.
+func isMutation(query string) bool {
+	for {
+		query = strings.TrimLeft(query, " \t\r\n,\ufeff")
+		if !strings.HasPrefix(query, "#") {
+			break
+		}
+		if i := strings.IndexByte(query, '\n'); i >= 0 {
+			query = query[i+1:]
+		} else {
+			query = ""
+		}
+	}
+	word := query
+	if i := strings.IndexFunc(word, func(r rune) bool { return r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) }); i >= 0 {
+		word = word[:i]
+	}
+	return word == "mutation" || word == "subscription"
+}
+
+// Get performs a read request and decodes the JSON response into out
+func (c *Client) Get(ctx context.Context, path string, out any) error {
+	_, err := c.request(ctx, http.MethodGet, path, nil, out)
+	return err
+}
+
+// linkNext finds the URL of the next page in a Link header
+var linkNext = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// GetPages performs a read request and follows the Link headers of its responses, calling page with the body of
+// each page in order until there is no next page or page returns false
+func (c *Client) GetPages(ctx context.Context, path string, page func(body []byte) (bool, error)) error {
+	for path != "" {
+		resp, err := c.request(ctx, http.MethodGet, path, nil, nil)
+		if err != nil {
+			return err
+		}
+		more, err := page(resp.Body)
+		if err != nil || !more {
+			return err
+		}
+		path = nextPagePath(resp.Header)
+	}
+	return nil
+}
+
+// nextPagePath returns the next page of a Link header as a path relative to the API root, empty on the last page
+func nextPagePath(header http.Header) string {
+	m := linkNext.FindStringSubmatch(header.Get("Link"))
+	if m == nil {
+		return ""
+	}
+	next, err := url.Parse(m[1])
+	if err != nil {
+		return ""
+	}
+	// GitHub Enterprise links carry the /api/v3 prefix of the REST root
+	if root, err := url.Parse(apiURL()); err == nil {
+		return strings.TrimPrefix(next.RequestURI(), strings.TrimSuffix(root.Path, "/"))
+	}
+	return next.RequestURI()
+}
+
+// GraphQL runs a document against the GraphQL API and decodes its data into out.
+// Queries are sent in dry-run mode as well, mutations go through Write.
+func (c *Client) GraphQL(ctx context.Context, query string, variables map[string]any, out any) error {
+	var resp struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	in := map[string]any{"query": query, "variables": variables}
+	if isMutation(query) {
+		if err := c.Write(ctx, http.MethodPost, "/graphql", in, &resp); err != nil || c.DryRun {
+			return err
+		}
+	} else if _, err := c.request(ctx, http.MethodPost, "/graphql", in, &resp); err != nil {
+		return err
+	}
+	if len(resp.Errors) > 0 {
+		messages := make([]string, len(resp.Errors))
+		for i, e := range resp.Errors {
+			messages[i] = e.Message
+		}
+		return fmt.Errorf("graphql: %s", strings.Join(messages, "; "))
+	}
+	return json.Unmarshal(resp.Data, out)
+}
+
+// Write performs a mutating request. In dry-run mode the call is printed as
+// "METHOD /path" and nothing is sent; in read-only mode ErrReadOnly is returned.
+// With a Queue, writes that could not be sent are queued and ErrQueued is returned. A write that failed once
+// it was sent is not, GitHub may have applied it.
+func (c *Client) Write(ctx context.Context, method string, path string, in any, out any) error {
+	if c.ReadOnly {
+		return ErrReadOnly
+	}
+	if c.DryRun {
+		fmt.Fprintf(c.Out, "%s %s\n", method, path)
+		return nil
+	}
+	if c.Offline && c.Queue != nil {
+		return c.queueRequest(method, path, in)
+	}
+	_, err := c.send(ctx, method, path, in, out)
+	if errors.Is(err, ErrNotSent) && c.Queue != nil {
+		return c.queueRequest(method, path, in)
+	}
+	return err
+}
+
+// request sends a read request, refusing one that mutates so no write gets past Write
+func (c *Client) request(ctx context.Context, method string, path string, in any, out any) (*Response, error) {
+	body, err := encodeBody(in)
+	if err != nil {
+		return nil, err
+	}
+	if IsWrite(method, path, body) {
+		return nil, fmt.Errorf("%s %s mutates, it must be sent with Write", method, path)
+	}
+	return c.send(ctx, method, path, in, out)
+}
+
+// encodeBody encodes the JSON body of a request, nil without one
+func encodeBody(in any) ([]byte, error) {
+	if in == nil {
+		return nil, nil
+	}
+	body, err := json.Marshal(in)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request body: %w", err)
+	}
+	return body, nil
+}
+
+// send encodes in, sends the request and decodes the JSON body into out
+func (c *Client) send(ctx context.Context, method string, path string, in any, out any) (*Response, error) {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	body, err := encodeBody(in)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.rate.wait(ctx); err != nil {
+		return nil, err
+	}
+	resp, err := c.backend.Do(ctx, method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	c.rate.observe(resp.Header)
+	c.checkScopes(resp)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(resp.Body, &apiErr)
+		if apiErr.Message == "" {
+			apiErr.Message = http.StatusText(resp.StatusCode)
+		}
+		return resp, &APIError{Method: method, Path: path, StatusCode: resp.StatusCode, Message: apiErr.Message}
+	}
+
+	if out != nil && len(resp.Body) > 0 {
+		if err := json.Unmarshal(resp.Body, out); err != nil {
+			return resp, fmt.Errorf("failed to decode response of %s %s: %w", method, path, err)
+		}
+	}
+	return resp, nil
+}
+
+// checkScopes warns once when the token lacks scopes the invoked command needs
+func (c *Client) checkScopes(resp *Response) {
+	c.scopesMu.Lock()
+	defer c.scopesMu.Unlock()
+	if c.scopesChecked || len(c.requiredScopes) == 0 {
+		return
+	}
+	granted, ok := parseScopes(resp.Header)
+	if !ok {
+		return
+	}
+	c.scopesChecked = true
+
+	if missing := missingScopes(granted, c.requiredScopes); len(missing) > 0 {
+		fmt.Fprintf(os.Stderr, style.Warning()+"Your GitHub token is missing required scopes: %s (run `gsn gh auth check`)\n", strings.Join(missing, ", "))
+	}
+}
+
+// httpBackend talks to the GitHub REST API directly with a token
+type httpBackend struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+// NewHTTPBackend creates a backend sending authenticated requests to baseURL
+func NewHTTPBackend(baseURL string, token string) Backend {
+	return &httpBackend{baseURL: baseURL, token: token, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (b *httpBackend) Do(ctx context.Context, method string, path string, body []byte) (*Response, error) {
+	target := b.baseURL + path
+	if path == "/graphql" {
+		// GitHub Enterprise serves GraphQL at /api/graphql next to the /api/v3 REST root
+		target = strings.TrimSuffix(b.baseURL, "/v3") + path
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if b.token != "" {
+		req.Header.Set("Authorization", "Bearer "+b.token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		if notSent(err) {
+			return nil, fmt.Errorf("%w: %w: %v", ErrNetwork, ErrNotSent, err)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrNetwork, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &Response{StatusCode: resp.StatusCode, Header: resp.Header, Body: data}, nil
+}
+
+// notSent reports whether a transport error happened before any of the request was written: the host name did
+// not resolve or the connection was never established
+func notSent(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// cliBackend sends requests through `gh api`, reusing the gh CLI's authentication
+type cliBackend struct {
+	runner execx.Runner
+}
+
+func (b *cliBackend) Do(ctx context.Context, method string, path string, body []byte) (*Response, error) {
+	args := []string{"api", "-i", "-X", method, strings.TrimPrefix(path, "/")}
+	if body != nil {
+		args = append(args, "--input", "-")
+	}
+
+	var opts execx.Options
+	if body != nil {
+		opts.Stdin = bytes.NewReader(body)
+	}
+
+	stdout, _, _, runErr := b.runner.Run(ctx, "gh", args, opts)
+	if len(stdout) == 0 {
+		if runErr != nil {
+			return nil, fmt.Errorf("gh api %s %s failed: %w", method, path, runErr)
+		}
+		return &Response{StatusCode: http.StatusNoContent, Header: http.Header{}}, nil
+	}
+	return parseIncludedResponse(stdout)
+}
+
+// parseIncludedResponse splits the output of `gh api -i` into status, headers and body
+func parseIncludedResponse(data []byte) (*Response, error) {
+	reader := textproto.NewReader(bufio.NewReader(bytes.NewReader(data)))
+
+	statusLine, err := reader.ReadLine()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gh api status line: %w", err)
+	}
+	fields := strings.Fields(statusLine)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("unexpected gh api status line %q", statusLine)
+	}
+	status, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("unexpected gh api status line %q", statusLine)
+	}
+
+	header, err := reader.ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read gh api headers: %w", err)
+	}
+
+	body, err := io.ReadAll(reader.R)
+	if err != nil {
+		return nil, err
+	}
+	return &Response{StatusCode: status, Header: http.Header(header), Body: bytes.TrimSpace(body)}, nil
+}
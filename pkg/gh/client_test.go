@@ -0,0 +1,350 @@
+package gh
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"gsn-dev-tools/internals/execx"
+)
+
+// fakeGitHub is an httptest server answering the GitHub API calls of the tests and recording every request
+type fakeGitHub struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []string
+	writes   []string
+
+	// handle answers a request, nil answers 404
+	handle func(w http.ResponseWriter, r *http.Request, body []byte)
+}
+
+func newFakeGitHub(t *testing.T, handle func(w http.ResponseWriter, r *http.Request, body []byte)) *fakeGitHub {
+	t.Helper()
+	f := &fakeGitHub{handle: handle}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		call := r.Method + " " + r.URL.RequestURI()
+		f.mu.Lock()
+		f.requests = append(f.requests, call)
+		if IsWrite(r.Method, r.URL.Path, body) {
+			f.writes = append(f.writes, call)
+		}
+		f.mu.Unlock()
+		if f.handle == nil {
+			http.NotFound(w, r)
+			return
+		}
+		f.handle(w, r, body)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// client returns a client sending its requests to the fake server, printing dry-run calls to out
+func (f *fakeGitHub) client(out io.Writer) *Client {
+	c := NewClientWithBackend(NewHTTPBackend(f.URL, "test-token"))
+	c.Out = out
+	return c
+}
+
+// pullRequests answers the pull request endpoints of owner/repo with an open PR 1 and PR 2, and GraphQL queries
+func pullRequests(w http.ResponseWriter, r *http.Request, body []byte) {
+	pr := func(n int) map[string]any {
+		return map[string]any{"number": n, "title": fmt.Sprintf("Bump dep %d", n), "state": "open",
+			"user": map[string]any{"login": "dependabot[bot]"}, "head": map[string]any{"sha": fmt.Sprintf("%040d", n)}}
+	}
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/repos/owner/repo/pulls":
+		_ = json.NewEncoder(w).Encode([]any{pr(1), pr(2)})
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/repos/owner/repo/pulls/"):
+		var n int
+		fmt.Sscanf(r.URL.Path, "/repos/owner/repo/pulls/%d", &n)
+		_ = json.NewEncoder(w).Encode(pr(n))
+	case r.URL.Path == "/graphql":
+		_, _ = io.WriteString(w, `{"data":{"viewer":{"login":"me"}}}`)
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/reviews"):
+		_, _ = io.WriteString(w, `{"id":7}`)
+	default:
+		w.WriteHeader(http.StatusOK)
+		_, _ = io.WriteString(w, `{}`)
+	}
+}
+
+func TestDryRunSendsNoWrites(t *testing.T) {
+	server := newFakeGitHub(t, pullRequests)
+	var out bytes.Buffer
+	client := server.client(&out)
+	client.DryRun = true
+	client.Queue = &Queue{Dir: t.TempDir()}
+	ctx := context.Background()
+
+	for _, prURL := range []string{"https://github.com/owner/repo/pull/1", "https://github.com/owner/repo/pull/2"} {
+		if _, err := approvePR(ctx, client, prURL, "", "LGTM {author}"); err != nil {
+			t.Fatalf("approve %s: %v", prURL, err)
+		}
+		if _, err := mergePR(ctx, client, prURL, "squash"); err != nil {
+			t.Fatalf("merge %s: %v", prURL, err)
+		}
+	}
+	prs, err := client.ListOpenPRs(ctx, "owner", "repo")
+	if err != nil || len(prs) != 2 {
+		t.Fatalf("ListOpenPRs = %v, %v", prs, err)
+	}
+	if _, err := client.Approve(ctx, PRRef{Owner: "owner", Repo: "repo", Number: prs[0].Number}, prs[0].Head.SHA, "bot"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Write(ctx, http.MethodPost, "/repos/owner/repo/issues/1/labels", labelsRequest{Labels: []string{"deps"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	var viewer struct{ Viewer struct{ Login string } }
+	if err := client.GraphQL(ctx, "query { viewer { login } }", nil, &viewer); err != nil || viewer.Viewer.Login != "me" {
+		t.Fatalf("GraphQL query = %+v, %v, want it sent in dry-run mode", viewer, err)
+	}
+	if err := client.GraphQL(ctx, "# close it\nmutation($id: ID!) { closePullRequest(input: {pullRequestId: $id}) { clientMutationId } }", map[string]any{"id": "PR_1"}, &struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(server.writes) > 0 {
+		t.Errorf("dry-run sent %d write request(s): %v", len(server.writes), server.writes)
+	}
+	if len(server.requests) == 0 {
+		t.Error("dry-run sent no read request, the PRs were not resolved")
+	}
+	want := []string{
+		"POST /repos/owner/repo/pulls/1/reviews", "PUT /repos/owner/repo/pulls/1/merge",
+		"POST /repos/owner/repo/pulls/2/reviews", "PUT /repos/owner/repo/pulls/2/merge",
+		"POST /repos/owner/repo/pulls/1/reviews", "POST /repos/owner/repo/issues/1/labels", "POST /graphql",
+	}
+	if got := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("dry-run printed\n%s\nwant\n%s", out.String(), strings.Join(want, "\n"))
+	}
+	if ops, _ := client.Queue.List(); len(ops) > 0 {
+		t.Errorf("dry-run queued %d operation(s)", len(ops))
+	}
+}
+
+func TestReadOnlyRefusesWrites(t *testing.T) {
+	server := newFakeGitHub(t, pullRequests)
+	client := server.client(io.Discard)
+	client.ReadOnly = true
+	ctx := context.Background()
+
+	if _, err := approvePR(ctx, client, "https://github.com/owner/repo/pull/1", "", "LGTM"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("approve = %v, want ErrReadOnly", err)
+	}
+	if err := client.GraphQL(ctx, "mutation { addStar(input: {starrableId: \"R_1\"}) { clientMutationId } }", nil, &struct{}{}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("GraphQL mutation = %v, want ErrReadOnly", err)
+	}
+	if err := client.GraphQL(ctx, "{ viewer { login } }", nil, &struct{}{}); err != nil {
+		t.Errorf("GraphQL query = %v, want it sent", err)
+	}
+	if len(server.writes) > 0 {
+		t.Errorf("read-only sent %v", server.writes)
+	}
+}
+
+func TestReadRequestsRefuseWrites(t *testing.T) {
+	server := newFakeGitHub(t, pullRequests)
+	client := server.client(io.Discard)
+	in := map[string]any{"query": "mutation { x }"}
+	if _, err := client.request(context.Background(), http.MethodPost, "/graphql", in, nil); err == nil {
+		t.Error("a mutation went through the read path")
+	}
+	if _, err := client.request(context.Background(), http.MethodDelete, "/repos/owner/repo/git/refs/heads/x", nil, nil); err == nil {
+		t.Error("a DELETE went through the read path")
+	}
+	if len(server.requests) > 0 {
+		t.Errorf("sent %v", server.requests)
+	}
+}
+
+func TestIsWrite(t *testing.T) {
+	tests := []struct {
+		method, path, body string
+		want               bool
+	}{
+		{"GET", "/repos/o/r/pulls", "", false},
+		{"HEAD", "/repos/o/r", "", false},
+		{"POST", "/repos/o/r/pulls/1/reviews", `{}`, true},
+		{"PUT", "/repos/o/r/pulls/1/merge", `{}`, true},
+		{"DELETE", "/repos/o/r/git/refs/heads/x", "", true},
+		{"POST", "/graphql", `{"query":"query { viewer { login } }"}`, false},
+		{"POST", "/graphql", `{"query":"{ viewer { login } }"}`, false},
+		{"POST", "/graphql", `{"query":"  # a comment\n mutation Close { closePullRequest }"}`, true},
+		{"POST", "/graphql", `{"query":"mutation{ x }"}`, true},
+		{"POST", "/graphql", `{"query":"query mutationsList { x }"}`, false},
+		{"POST", "/graphql", `not json`, true},
+	}
+	for _, test := range tests {
+		if got := IsWrite(test.method, test.path, []byte(test.body)); got != test.want {
+			t.Errorf("IsWrite(%s %s %s) = %v, want %v", test.method, test.path, test.body, got, test.want)
+		}
+	}
+}
+
+// failingBackend fails every request with err
+type failingBackend struct {
+	err   error
+	calls int
+}
+
+func (b *failingBackend) Do(ctx context.Context, method string, path string, body []byte) (*Response, error) {
+	b.calls++
+	return nil, b.err
+}
+
+func TestWriteQueuesOnlyUnsentRequests(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		queued bool
+	}{
+		{"dial", fmt.Errorf("%w: %w: dial tcp: connection refused", ErrNetwork, ErrNotSent), true},
+		{"response lost", fmt.Errorf("%w: read: connection reset by peer", ErrNetwork), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := NewClientWithBackend(&failingBackend{err: test.err})
+			client.Queue = &Queue{Dir: t.TempDir()}
+			err := client.Write(context.Background(), http.MethodPost, "/repos/o/r/issues/1/comments", map[string]string{"body": "hi"}, nil)
+			ops, _ := client.Queue.List()
+			if test.queued && (!errors.Is(err, ErrQueued) || len(ops) != 1) {
+				t.Errorf("Write = %v with %d queued, want the request queued", err, len(ops))
+			}
+			if !test.queued && (errors.Is(err, ErrQueued) || len(ops) != 0 || !errors.Is(err, ErrNetwork)) {
+				t.Errorf("Write = %v with %d queued, want the network error and nothing queued", err, len(ops))
+			}
+		})
+	}
+}
+
+func TestHTTPBackendTellsUnsentRequests(t *testing.T) {
+	// Nothing listens on a port taken from a closed listener, the dial fails before anything is sent
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedURL := "http://" + listener.Addr().String()
+	listener.Close()
+	_, err = NewHTTPBackend(closedURL, "").Do(context.Background(), http.MethodPost, "/x", []byte("{}"))
+	if !errors.Is(err, ErrNetwork) || !errors.Is(err, ErrNotSent) {
+		t.Errorf("dial failure = %v, want ErrNetwork and ErrNotSent", err)
+	}
+
+	// A server that drops the connection once it read the request may have acted on it
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.ReadAll(r.Body)
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+	defer server.Close()
+	_, err = NewHTTPBackend(server.URL, "").Do(context.Background(), http.MethodPost, "/x", []byte("{}"))
+	if !errors.Is(err, ErrNetwork) || errors.Is(err, ErrNotSent) {
+		t.Errorf("dropped connection = %v, want ErrNetwork without ErrNotSent", err)
+	}
+}
+
+// TestGhAccessCombinations covers every combination of the gh CLI being installed and a token being set: gh is
+// used when it is there, the API when only a token is, and a MissingGhError naming both options otherwise
+func TestGhAccessCombinations(t *testing.T) {
+	api := newFakeGitHub(t, pullRequests)
+	tests := []struct {
+		name     string
+		gh       bool
+		token    string
+		wantCLI  bool
+		wantNote string
+		wantErr  bool
+	}{
+		{"gh and a token", true, "ghp_token", true, "", false},
+		{"gh only", true, "", true, "", false},
+		{"token only", false, "ghp_token", false, "gh is not installed, listing through the GitHub API with the token from env GSN_GH_TOKEN", false},
+		{"neither", false, "", false, "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("GSN_HOME", t.TempDir())
+			for _, name := range []string{"GSN_GH_TOKEN", "GITHUB_TOKEN", "GH_TOKEN"} {
+				t.Setenv(name, "")
+			}
+			t.Setenv("GSN_GH_TOKEN", test.token)
+			t.Setenv("GSN_GH_API_URL", api.URL)
+			fake := &execx.Fake{}
+			if !test.gh {
+				fake.Paths = []string{}
+			} else {
+				fake.Expect("gh", "pr", "list", "--json", "number,title,url,createdAt,author", "--repo", "owner/repo").
+					Return(`[{"number":1,"title":"Add feature","url":"https://github.com/owner/repo/pull/1"}]`, 0)
+			}
+			useFakeRunner(t, fake)
+
+			a, err := resolveAccess(Runner)
+			var missing *MissingGhError
+			if test.wantErr {
+				if !errors.As(err, &missing) || !errors.Is(err, exec.ErrNotFound) {
+					t.Fatalf("resolveAccess = %v, want a MissingGhError wrapping exec.ErrNotFound", err)
+				}
+				for _, option := range []string{"install gh", "set GSN_GH_TOKEN"} {
+					if !strings.Contains(err.Error(), option) {
+						t.Errorf("error %q does not suggest %q", err, option)
+					}
+				}
+				if _, err := NewClient(); !errors.As(err, &missing) {
+					t.Errorf("NewClient = %v, want the same MissingGhError", err)
+				}
+				if _, err := ListPullRequests(context.Background(), "owner/repo", ""); !errors.As(err, &missing) {
+					t.Errorf("ListPullRequests = %v, want the same MissingGhError", err)
+				}
+				return
+			}
+			if err != nil || a.GhCLI != test.gh || a.Token != test.token {
+				t.Fatalf("resolveAccess = %+v, %v", a, err)
+			}
+
+			// The client prefers the token, gh api is only used without one
+			client, err := NewClient()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, cli := client.backend.(*cliBackend); cli != (test.token == "") {
+				t.Errorf("client backend = %T", client.backend)
+			}
+
+			api.mu.Lock()
+			before := len(api.requests)
+			api.mu.Unlock()
+			var prs []PullRequest
+			note := captureStderr(t, func() {
+				prs, err = ListPullRequests(context.Background(), "owner/repo", "")
+			})
+			if err != nil || len(prs) == 0 || prs[0].Number != 1 {
+				t.Fatalf("ListPullRequests = %+v, %v", prs, err)
+			}
+			if strings.TrimSpace(note) != test.wantNote {
+				t.Errorf("note = %q, want %q", note, test.wantNote)
+			}
+			api.mu.Lock()
+			sent := api.requests[before:]
+			api.mu.Unlock()
+			if test.gh && len(sent) != 0 || !test.gh && !slices.Equal(sent, []string{"GET /repos/owner/repo/pulls?state=open&per_page=100"}) {
+				t.Errorf("requests to the API = %q", sent)
+			}
+			if err := fake.Verify(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package gh
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gsn-dev-tools/internals/lockfile"
+	"gsn-dev-tools/internals/output"
+	"gsn-dev-tools/internals/paths"
+	"gsn-dev-tools/internals/redact"
+	"gsn-dev-tools/internals/state"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// completionFileName is the file below the gsn state dir holding the recent repos and the cached PRs
+	completionFileName = "gh-completion.json"
+
+	// completionTimeout bounds the API lookup of a completion, the shell waits for it on every Tab
+	completionTimeout = 500 * time.Millisecond
+
+	// completionTTL is how long cached pull requests are offered without asking the API again
+	completionTTL = 2 * time.Minute
+
+	// recentRepoLimit is how many repos the history keeps, newest first
+	recentRepoLimit = 20
+)
+
+// completionKind versions the completion cache
+var completionKind = state.Register(&state.Kind{
+	Name:     "completion cache",
+	Format:   state.JSON,
+	Version:  1,
+	Patterns: []string{completionFileName},
+	Files: func() ([]string, error) {
+		path, err := completionPath()
+		if err != nil {
+			return nil, err
+		}
+		return []string{path}, nil
+	},
+})
+
+// completionCache holds the repos recently used by gh commands and the open pull requests last seen in them
+type completionCache struct {
+	SchemaVersion int                     `json:"schema_version"`
+	Repos         []recentRepo            `json:"repos"`
+	PRs           map[string]cachedPRList `json:"prs,omitempty"`
+}
+
+type recentRepo struct {
+	Repo   string    `json:"repo"`
+	UsedAt time.Time `json:"used_at"`
+}
+
+type cachedPRList struct {
+	FetchedAt time.Time  `json:"fetched_at"`
+	PRs       []cachedPR `json:"prs"`
+}
+
+type cachedPR struct {
+	Number int    `json:"number"`
+	Title  string `json:"title"`
+}
+
+func completionPath() (string, error) {
+	dir, err := paths.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, completionFileName), nil
+}
+
+// loadCompletionCache reads the cache, a missing file is an empty cache
+func loadCompletionCache() (completionCache, error) {
+	cache := completionCache{PRs: map[string]cachedPRList{}}
+	path, err := completionPath()
+	if err != nil {
+		return cache, err
+	}
+	doc, err := completionKind.Load(path, &cache)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cache, nil
+	}
+	if err != nil {
+		return cache, err
+	}
+	if err := doc.Writable(); err != nil {
+		return cache, err
+	}
+	if cache.PRs == nil {
+		cache.PRs = map[string]cachedPRList{}
+	}
+	return cache, nil
+}
+
+func (c completionCache) save() error {
+	path, err := completionPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	c.SchemaVersion = completionKind.Version
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Repos and pull request titles matching the redact section are stored as [REDACTED], completion cannot offer them
+	return output.WriteFileAtomic(path, append(redact.JSON(data), '\n'), 0o600)
+}
+
+// updateCompletionCache applies change to the cache read afresh under its lock, so gsn processes remembering
+// repos at the same time keep each other's. Completion must stay quick, the lock is waited for only briefly.
+func updateCompletionCache(change func(cache *completionCache)) error {
+	path, err := completionPath()
+	if err != nil {
+		return err
+	}
+	unlock, err := lockfile.Acquire(path+".lock", path, completionTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	cache, err := loadCompletionCache()
+	if err != nil {
+		return err
+	}
+	change(&cache)
+	return cache.save()
+}
+
+// use moves repo to the front of the history
+func (c *completionCache) use(repo string, now time.Time) {
+	c.Repos = slices.DeleteFunc(c.Repos, func(r recentRepo) bool { return r.Repo == repo })
+	c.Repos = slices.Insert(c.Repos, 0, recentRepo{Repo: repo, UsedAt: now})
+	if len(c.Repos) > recentRepoLimit {
+		for _, r := range c.Repos[recentRepoLimit:] {
+			delete(c.PRs, r.Repo)
+		}
+		c.Repos = c.Repos[:recentRepoLimit]
+	}
+}
+
+// rememberRepos adds repos to the history used by completion. It is best effort: a command never fails because
+// its history could not be written.
+func rememberRepos(repos ...string) {
+	if len(repos) == 0 {
+		return
+	}
+	now := time.Now()
+	_ = updateCompletionCache(func(cache *completionCache) {
+		for _, repo := range repos {
+			cache.use(repo, now)
+		}
+	})
+}
+
+// rememberPRRepos records the repos of PR references, those that do not parse are left to the command to report
+func rememberPRRepos(refs []string) {
+	var repos []string
+	for _, raw := range refs {
+		if ref, err := ParsePRURL(raw); err == nil {
+			repos = append(repos, ref.Owner+"/"+ref.Repo)
+		}
+	}
+	rememberRepos(repos...)
+}
+
+// completionClient builds the client completion looks up pull requests with. It is a variable so completion can
+// run against a fake backend.
+var completionClient = func() (*Client, error) {
+	return NewClient()
+}
+
+// completePRRefs completes owner/repo#number arguments. Before the # it offers the recent repos, after it the
+// open pull requests of the repo with their titles, from the cache while it is fresh and from the API otherwise.
+// A lookup slower than completionTimeout, or failing, falls back to what is cached.
+func completePRRefs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if strings.Contains(toComplete, "://") {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	cache, _ := loadCompletionCache()
+
+	repo, number, ok := strings.Cut(toComplete, "#")
+	if !ok {
+		var repos []string
+		for _, r := range cache.Repos {
+			if strings.HasPrefix(r.Repo, toComplete) {
+				repos = append(repos, r.Repo+"#")
+			}
+		}
+		return repos, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+	}
+	owner, name, err := ParseRepo(repo)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), completionTimeout)
+	defer cancel()
+	prs := cachedOpenPRs(ctx, &cache, owner, name, time.Now())
+
+	var refs []string
+	for _, pr := range prs {
+		n := fmt.Sprint(pr.Number)
+		if strings.HasPrefix(n, number) && !slices.Contains(args, repo+"#"+n) {
+			refs = append(refs, fmt.Sprintf("%s#%s\t%s", repo, n, pr.Title))
+		}
+	}
+	return refs, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveKeepOrder
+}
+
+// cachedOpenPRs returns the open pull requests of a repo, refreshing the cache when it is older than completionTTL
+func cachedOpenPRs(ctx context.Context, cache *completionCache, owner string, name string, now time.Time) []cachedPR {
+	repo := owner + "/" + name
+	cached, ok := cache.PRs[repo]
+	if ok && now.Sub(cached.FetchedAt) < completionTTL {
+		return cached.PRs
+	}
+
+	client, err := completionClient()
+	if err != nil {
+		return cached.PRs
+	}
+	details, err := client.ListOpenPRs(ctx, owner, name)
+	if err != nil {
+		return cached.PRs
+	}
+
+	prs := make([]cachedPR, len(details))
+	for i, d := range details {
+		prs[i] = cachedPR{Number: d.Number, Title: d.Title}
+	}
+	fetched := cachedPRList{FetchedAt: now, PRs: prs}
+	cache.PRs[repo] = fetched
+	cache.use(repo, now)
+	_ = updateCompletionCache(func(latest *completionCache) {
+		latest.PRs[repo] = fetched
+		latest.use(repo, now)
+	})
+	return prs
+}
+
+// completeRepos completes --repo with the recent repos
+func completeRepos(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	cache, _ := loadCompletionCache()
+	var repos []string
+	for _, r := range cache.Repos {
+		if strings.HasPrefix(r.Repo, toComplete) {
+			repos = append(repos, r.Repo)
+		}
+	}
+	return repos, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveKeepOrder
+}
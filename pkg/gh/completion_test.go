@@ -0,0 +1,153 @@
+package gh
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// useCompletionAPI makes completion look pull requests up on api, with a fresh state dir
+func useCompletionAPI(t *testing.T, api *fakeGitHub) {
+	t.Helper()
+	t.Setenv("GSN_HOME", t.TempDir())
+	previous := completionClient
+	completionClient = func() (*Client, error) { return api.client(io.Discard), nil }
+	t.Cleanup(func() { completionClient = previous })
+}
+
+// seedCompletionCache writes the repos, newest first, and the pull requests cached for them at fetched
+func seedCompletionCache(t *testing.T, repos []string, prs map[string][]cachedPR, fetched time.Time) {
+	t.Helper()
+	cache := completionCache{PRs: map[string]cachedPRList{}}
+	for i := len(repos) - 1; i >= 0; i-- {
+		cache.use(repos[i], fetched)
+	}
+	for repo, list := range prs {
+		cache.PRs[repo] = cachedPRList{FetchedAt: fetched, PRs: list}
+	}
+	if err := cache.save(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// apiRequests returns the requests api received since the first n
+func apiRequests(api *fakeGitHub, n int) []string {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return slices.Clone(api.requests[n:])
+}
+
+func TestCompleteRepos(t *testing.T) {
+	useCompletionAPI(t, newFakeGitHub(t, nil))
+	seedCompletionCache(t, []string{"owner/web", "other/tool", "owner/api"}, nil, time.Now())
+
+	got, directive := completeRepos(nil, nil, "")
+	if !slices.Equal(got, []string{"owner/web", "other/tool", "owner/api"}) || directive != cobra.ShellCompDirectiveNoFileComp|cobra.ShellCompDirectiveKeepOrder {
+		t.Errorf("completeRepos(\"\") = %q, %v", got, directive)
+	}
+	if got, _ := completeRepos(nil, nil, "owner/"); !slices.Equal(got, []string{"owner/web", "owner/api"}) {
+		t.Errorf("completeRepos(owner/) = %q", got)
+	}
+
+	// Using a repo moves it to the front, the history keeps the last recentRepoLimit
+	rememberRepos("owner/api")
+	if got, _ := completeRepos(nil, nil, ""); got[0] != "owner/api" || len(got) != 3 {
+		t.Errorf("after using owner/api: %q", got)
+	}
+	for i := range recentRepoLimit {
+		rememberRepos(fmt.Sprintf("many/repo-%02d", i))
+	}
+	got, _ = completeRepos(nil, nil, "")
+	if len(got) != recentRepoLimit || got[0] != fmt.Sprintf("many/repo-%02d", recentRepoLimit-1) || slices.Contains(got, "owner/api") {
+		t.Errorf("history after %d more repos = %q", recentRepoLimit, got)
+	}
+}
+
+func TestCompletePRRefs(t *testing.T) {
+	var slow atomic.Bool
+	api := newFakeGitHub(t, func(w http.ResponseWriter, r *http.Request, body []byte) {
+		if slow.Load() {
+			// Answers only once completion gave up on the request
+			<-r.Context().Done()
+			return
+		}
+		if r.URL.Path != "/repos/owner/repo/pulls" {
+			http.Error(w, `{"message":"Server Error"}`, http.StatusInternalServerError)
+			return
+		}
+		_, _ = io.WriteString(w, `[{"number":12,"title":"Fix login"},{"number":15,"title":"Add search"},{"number":120,"title":"Bump deps"}]`)
+	})
+	useCompletionAPI(t, api)
+	now := time.Now()
+	cached := map[string][]cachedPR{"owner/repo": {{Number: 7, Title: "Cached one"}, {Number: 12, Title: "Cached two"}}}
+
+	// Before the # the recent repos are offered, without a space so the number can follow
+	seedCompletionCache(t, []string{"owner/repo", "owner/other"}, cached, now)
+	got, directive := completePRRefs(nil, nil, "owner/r")
+	if !slices.Equal(got, []string{"owner/repo#"}) || directive&cobra.ShellCompDirectiveNoSpace == 0 {
+		t.Errorf("completePRRefs(owner/r) = %q, %v", got, directive)
+	}
+
+	tests := []struct {
+		name       string
+		fetched    time.Time
+		slow       bool
+		args       []string
+		toComplete string
+		want       []string
+		requests   int
+	}{
+		{"fresh cache", now, false, nil, "owner/repo#", []string{"owner/repo#7\tCached one", "owner/repo#12\tCached two"}, 0},
+		{"number prefix", now, false, nil, "owner/repo#1", []string{"owner/repo#12\tCached two"}, 0},
+		{"already given", now, false, []string{"owner/repo#7"}, "owner/repo#", []string{"owner/repo#12\tCached two"}, 0},
+		{"stale cache", now.Add(-completionTTL), false, nil, "owner/repo#1", []string{"owner/repo#12\tFix login", "owner/repo#15\tAdd search", "owner/repo#120\tBump deps"}, 1},
+		// Past the timeout what is cached is offered, however old
+		{"slow API", now.Add(-time.Hour), true, nil, "owner/repo#", []string{"owner/repo#7\tCached one", "owner/repo#12\tCached two"}, 1},
+		{"failing API", now.Add(-time.Hour), false, nil, "owner/other#", nil, 1},
+		{"not a repo", now, false, nil, "owner#", nil, 0},
+		{"URL", now, false, nil, "https://github.com/owner/repo/pull/", nil, 0},
+	}
+	for _, test := range tests {
+		seedCompletionCache(t, []string{"owner/repo", "owner/other"}, cached, test.fetched)
+		slow.Store(test.slow)
+		before := len(apiRequests(api, 0))
+		start := time.Now()
+		got, _ := completePRRefs(nil, test.args, test.toComplete)
+		if elapsed := time.Since(start); elapsed > completionTimeout+250*time.Millisecond {
+			t.Errorf("%s: completion took %v", test.name, elapsed)
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%s: completePRRefs(%s) = %q, want %q", test.name, test.toComplete, got, test.want)
+		}
+		if requests := apiRequests(api, before); len(requests) != test.requests {
+			t.Errorf("%s: requests to the API = %q", test.name, requests)
+		}
+	}
+
+	// A refreshed list is cached for the next Tab
+	seedCompletionCache(t, []string{"owner/other", "owner/repo"}, cached, now.Add(-time.Hour))
+	slow.Store(false)
+	completePRRefs(nil, nil, "owner/repo#")
+	cache, err := loadCompletionCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := cache.PRs["owner/repo"]
+	if len(list.PRs) != 3 || list.PRs[0] != (cachedPR{Number: 12, Title: "Fix login"}) || time.Since(list.FetchedAt) > time.Minute {
+		t.Errorf("cached after the lookup: %+v", list)
+	}
+	if cache.Repos[0].Repo != "owner/repo" {
+		t.Errorf("the completed repo was not moved to the front: %+v", cache.Repos)
+	}
+	before := len(apiRequests(api, 0))
+	completePRRefs(nil, nil, "owner/repo#")
+	if requests := apiRequests(api, before); len(requests) != 0 {
+		t.Errorf("the next completion asked the API again: %q", requests)
+	}
+}
@@ -0,0 +1,337 @@
+package gh
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/output"
+	"gsn-dev-tools/internals/style"
+	"gsn-dev-tools/internals/tui"
+
+	"github.com/spf13/cobra"
+)
+
+// depUpdate is one dependency a bot pull request changes. From is empty when the bot only names the new version,
+// Path is the directory of a monorepo the update applies to.
+type depUpdate struct {
+	Name string
+	From string
+	To   string
+	Path string
+}
+
+var (
+	// dependabotTitle matches "Bump lodash from 4.17.20 to 4.17.21 in /frontend", with any commit prefix
+	dependabotTitle = regexp.MustCompile(`(?i)\bbump (\S+) from (\S+) to (\S+?)(?: in (/\S*))?$`)
+	// dependabotGroupTitle matches "Bump the npm group in /web with 2 updates", its updates are in the body
+	dependabotGroupTitle = regexp.MustCompile(`(?i)\bbump the \S+ group(?: in (/\S*))?`)
+	// dependabotUpdate matches the "Updates `lodash` from 4.17.20 to 4.17.21" lines of grouped updates
+	dependabotUpdate = regexp.MustCompile("^Updates `([^`]+)` from (\\S+) to (\\S+?)\\.?$")
+	// dependabotRow matches the "| [lodash](url) | `4.17.20` | `4.17.21` |" rows of grouped updates
+	dependabotRow = regexp.MustCompile("^\\|\\s*\\[([^\\]]+)\\]\\([^)]*\\)\\s*\\|\\s*`([^`]+)`\\s*\\|\\s*`([^`]+)`\\s*\\|")
+	// dependabotDir matches "Bumps the npm group with 1 update in the /frontend directory: [lodash](url)", naming the
+	// directory of the packages linked after it
+	dependabotDir = regexp.MustCompile("^Bumps .* in the `?(/[^\\s`]*)`? directory")
+	// markdownLinkText matches the text of the [name](url) links of a line
+	markdownLinkText = regexp.MustCompile(`\[([^\]]+)\]\([^)]*\)`)
+	// renovateTitle matches "Update dependency lodash to v4.17.21" and "chore(deps): update module x/y to v1.3.0", and
+	// the kinds Renovate names after the package: "Update actions/checkout action to v4", "Update nginx Docker tag to v1.27"
+	renovateTitle = regexp.MustCompile(`(?i)\bupdate (?:(?:dependency|module|package|rust crate|gem|docker tag|docker image|image|helm release|github action|action|plugin)\s+)?(\S+?)(?: (?:monorepo|action|docker tag|image|orb))? to (v?\d\S*)`)
+	// renovateRow matches the "| [lodash](url) ([source](url)) | [`4.17.20` -> `4.17.21`](url) |" rows of the table
+	// Renovate writes, whatever columns sit between the package and the change
+	renovateRow = regexp.MustCompile("^\\|\\s*(?:\\[([^\\]]+)\\]\\([^)]*\\)|`?([^|`\\[]+)`?)[^|]*\\|.*?`([^`]+)`\\s*(?:->|→)\\s*`([^`]+)`")
+
+	// changelogLinks find the release notes or changelog link of a pull request body, the first that matches wins
+	changelogLinks = []*regexp.Regexp{
+		// Dependabot: <summary>Release notes</summary> <p><em>Sourced from <a href="...">
+		regexp.MustCompile(`(?s)<summary>(?:Release notes|Changelog)</summary>\s*<p><em>Sourced from <a href="([^"]+)"`),
+		// Dependabot, grouped: - [Release notes](https://github.com/lodash/lodash/releases)
+		regexp.MustCompile(`- \[(?:Release notes|Changelog)\]\((https?://[^)\s]+)\)`),
+		// Renovate: ### [`v4.17.21`](https://github.com/lodash/lodash/releases/tag/4.17.21)
+		regexp.MustCompile("### \\[`[^`]+`\\]\\((https?://[^)\\s]+)\\)"),
+		regexp.MustCompile(`\[Compare Source\]\((https?://[^)\s]+)\)`),
+	}
+)
+
+// parseDepUpdates reads the dependency updates of a Dependabot or Renovate pull request from its title and body.
+// The body lists every update of grouped pull requests, the title is used when it lists none.
+func parseDepUpdates(title string, body string) []depUpdate {
+	var updates []depUpdate
+	seen := map[depUpdate]bool{}
+	add := func(u depUpdate) {
+		u.From, u.To = strings.Trim(u.From, "`"), strings.Trim(u.To, "`")
+		if !seen[u] {
+			seen[u] = true
+			updates = append(updates, u)
+		}
+	}
+
+	// Updates across directories are listed after the lines naming the packages of each directory, a package
+	// updated in two directories is listed once per directory in the same order. A line naming no package heads
+	// the table of its directory.
+	dirs := map[string][]string{}
+	current := ""
+	dirOf := func(name string) (string, bool) {
+		if len(dirs[name]) == 0 {
+			return current, false
+		}
+		dir := dirs[name][0]
+		dirs[name] = dirs[name][1:]
+		return dir, true
+	}
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if m := dependabotDir.FindStringSubmatch(line); m != nil {
+			current = m[1]
+			for _, link := range markdownLinkText.FindAllStringSubmatch(line, -1) {
+				dirs[link[1]] = append(dirs[link[1]], m[1])
+			}
+		}
+		if m := dependabotUpdate.FindStringSubmatch(line); m != nil {
+			// The update lines repeat the rows of the tables, whose directory is already known
+			dir, mapped := dirOf(m[1])
+			listed := slices.ContainsFunc(updates, func(u depUpdate) bool { return u.Name == m[1] && u.From == m[2] && u.To == m[3] })
+			if mapped || !listed {
+				add(depUpdate{Name: m[1], From: m[2], To: m[3], Path: dir})
+			}
+		} else if m := dependabotRow.FindStringSubmatch(line); m != nil {
+			add(depUpdate{Name: m[1], From: m[2], To: m[3], Path: current})
+		} else if m := renovateRow.FindStringSubmatch(line); m != nil {
+			add(depUpdate{Name: m[1] + strings.TrimSpace(m[2]), From: m[3], To: m[4]})
+		}
+	}
+
+	// The directory of a single directory update is only named in the title
+	titleDir := ""
+	if m := dependabotGroupTitle.FindStringSubmatch(title); m != nil {
+		titleDir = m[1]
+	}
+	if m := dependabotTitle.FindStringSubmatch(title); m != nil {
+		titleDir = m[4]
+		if len(updates) == 0 {
+			add(depUpdate{Name: m[1], From: m[2], To: m[3], Path: m[4]})
+		}
+	}
+	if len(updates) == 0 {
+		if m := renovateTitle.FindStringSubmatch(title); m != nil {
+			add(depUpdate{Name: m[1], To: m[2]})
+		}
+	}
+	for i := range updates {
+		if updates[i].Path == "" {
+			updates[i].Path = titleDir
+		}
+	}
+	return updates
+}
+
+// changelogURL returns the release notes or changelog link of a pull request body, "" when it has none
+func changelogURL(body string) string {
+	for _, re := range changelogLinks {
+		if m := re.FindStringSubmatch(body); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// semverClass names the part of the version an update changes: major, minor, patch or prerelease. It is unknown
+// when a version is missing or is not a version number, like a digest.
+func semverClass(from string, to string) string {
+	f, ok := parseVersion(from)
+	t, ok2 := parseVersion(to)
+	if !ok || !ok2 {
+		return "unknown"
+	}
+	for i, class := range []string{"major", "minor", "patch"} {
+		if f[i] != t[i] {
+			return class
+		}
+	}
+	if strings.TrimPrefix(from, "v") != strings.TrimPrefix(to, "v") {
+		return "prerelease"
+	}
+	return "none"
+}
+
+// parseVersion reads the major, minor and patch numbers of a version like v1.2 or 1.2.3-rc.1, missing ones are 0
+func parseVersion(v string) ([3]int, bool) {
+	var parts [3]int
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	fields := strings.Split(v, ".")
+	if v == "" || len(fields) > 3 {
+		return parts, false
+	}
+	for i, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 {
+			return parts, false
+		}
+		parts[i] = n
+	}
+	return parts, true
+}
+
+// ciSummary reduces the statuses and check runs of a commit to failing, pending, passing or none
+func ciSummary(statuses []CommitStatus) string {
+	if len(statuses) == 0 {
+		return "none"
+	}
+	summary := "passing"
+	for _, s := range statuses {
+		switch s.State {
+		case "success", "neutral", "skipped":
+		case "failure", "error", "cancelled", "timed_out", "action_required", "startup_failure":
+			return "failing"
+		default:
+			summary = "pending"
+		}
+	}
+	return summary
+}
+
+// depPreview is what a bot pull request changes, with the state of its CI
+type depPreview struct {
+	Ref       PRRef
+	Updates   []depUpdate
+	Changelog string
+	CI        string
+}
+
+// previewDeps parses the updates of pr and looks up the CI state of its head. A CI state that cannot be read is
+// unknown, the preview is still worth showing.
+func previewDeps(ctx context.Context, client *Client, ref PRRef, pr *PRDetails) depPreview {
+	preview := depPreview{
+		Ref:       ref,
+		Updates:   parseDepUpdates(pr.Title, pr.Body),
+		Changelog: changelogURL(pr.Body),
+		CI:        "unknown",
+	}
+	if statuses, err := client.ListStatuses(ctx, ref.Owner, ref.Repo, pr.Head.SHA); err == nil {
+		preview.CI = ciSummary(statuses)
+	}
+	return preview
+}
+
+// depRow is a row of the preview table, one per update
+type depRow struct {
+	Preview depPreview
+	Update  depUpdate
+}
+
+// depColumns declares the columns of the dependency preview
+var depColumns = []output.Column[depRow]{
+	{Name: "pr", Value: func(r depRow) any { return r.Preview.Ref.String() }},
+	{Name: "dependency", Value: func(r depRow) any { return r.Update.Name }},
+	{Name: "path", Value: func(r depRow) any { return r.Update.Path }},
+	{
+		Name:  "change",
+		Value: func(r depRow) any { return r.Update.From + "→" + r.Update.To },
+		Display: func(r depRow) string {
+			from := r.Update.From
+			if from == "" {
+				from = "?"
+			}
+			return from + "→" + r.Update.To
+		},
+	},
+	{Name: "class", Value: func(r depRow) any { return semverClass(r.Update.From, r.Update.To) }},
+	{Name: "ci", Value: func(r depRow) any { return r.Preview.CI }},
+	{Name: "changelog", Value: func(r depRow) any { return r.Preview.Changelog }},
+}
+
+// depRows flattens previews into table rows, a pull request whose updates could not be parsed keeps one row
+func depRows(previews []depPreview) []depRow {
+	var rows []depRow
+	for _, p := range previews {
+		if len(p.Updates) == 0 {
+			rows = append(rows, depRow{Preview: p, Update: depUpdate{Name: "?"}})
+			continue
+		}
+		for _, u := range p.Updates {
+			rows = append(rows, depRow{Preview: p, Update: u})
+		}
+	}
+	return rows
+}
+
+func depsPrsCmd() *cobra.Command {
+	depsCmd := &cobra.Command{
+		Use:   "deps <PR_URL>...",
+		Short: "Show the dependency updates of bot pull requests",
+		Long: `Reads the dependency name and version change of Dependabot and Renovate pull requests from their title and
+body, grouped updates and monorepo directories included, and prints one row per update with the semver class
+of the change, the CI state of the head commit and the changelog or release notes link. gsn approve-bots shows
+the same table before approving.`,
+		Example: `  gsn pr deps https://github.com/owner/repo/pull/42
+  gsn pr deps owner/repo#42 owner/repo#43 --columns pr,dependency,change,class`,
+		Args:              tui.Args(cobra.MinimumNArgs(1)),
+		ValidArgsFunction: completePRRefs,
+		Run: func(cmd *cobra.Command, args []string) {
+			tableOpts, err := output.OptionsFromFlags(cmd)
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			client, err := NewClient()
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			if args, err = prArgs(cmd, client, args); err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			opts, err := batchOptionsFromFlags(cmd, "preview")
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			previews := make([]depPreview, len(args))
+			indexes := make([]int, len(args))
+			for i := range indexes {
+				indexes[i] = i
+			}
+			results := runBatch(cmd.Context(), indexes, func(i int) string { return args[i] }, opts, func(ctx context.Context, i int) (string, error) {
+				ref, err := ParsePRURL(args[i])
+				if err != nil {
+					return "", err
+				}
+				pr, err := client.GetPR(ctx, ref)
+				if err != nil {
+					return "", err
+				}
+				previews[i] = previewDeps(ctx, client, ref, pr)
+				return "", nil
+			})
+
+			var found []depPreview
+			for i, r := range results {
+				if r.Err != nil {
+					fmt.Fprintf(os.Stderr, style.Error()+"Failed to preview %s: %v\n", r.Name, r.Err)
+					continue
+				}
+				found = append(found, previews[i])
+			}
+			if err := output.Render(os.Stdout, depColumns, depRows(found), tableOpts); err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			if len(found) < len(results) {
+				clierr.Exit(batchExitCode(results))
+			}
+		},
+	}
+
+	output.AddFlags(depsCmd)
+	addBatchFlags(depsCmd)
+	addPickFlag(depsCmd)
+	dryrun.ReadOnly(depsCmd)
+	return depsCmd
+}
@@ -0,0 +1,139 @@
+package gh
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// TestParseDepUpdatesFixtures reads the bodies Dependabot and Renovate write, kept in testdata/deps
+func TestParseDepUpdatesFixtures(t *testing.T) {
+	tests := []struct {
+		fixture   string
+		title     string
+		want      []depUpdate
+		changelog string
+	}{
+		{"dependabot-single", "Bump lodash from 4.17.20 to 4.17.21 in /frontend",
+			[]depUpdate{{Name: "lodash", From: "4.17.20", To: "4.17.21", Path: "/frontend"}},
+			"https://github.com/lodash/lodash/releases"},
+		{"dependabot-group", "Bump the npm group in /web with 2 updates",
+			[]depUpdate{
+				{Name: "react", From: "18.2.0", To: "18.3.1", Path: "/web"},
+				{Name: "typescript", From: "5.3.3", To: "5.4.5", Path: "/web"},
+			},
+			"https://github.com/facebook/react/releases"},
+		// The update lines repeat the table, every update is listed once
+		{"dependabot-group-table", "chore(deps): bump the go_modules group with 3 updates",
+			[]depUpdate{
+				{Name: "golang.org/x/net", From: "0.23.0", To: "0.25.0"},
+				{Name: "golang.org/x/crypto", From: "0.22.0", To: "0.23.0"},
+				{Name: "github.com/spf13/cobra", From: "1.8.0", To: "1.8.1"},
+			},
+			"https://github.com/spf13/cobra/releases"},
+		// A package updated in two directories is listed once for each, in the order of the directory lines
+		{"dependabot-directories", "Bump the npm_and_yarn group across 2 directories with 2 updates",
+			[]depUpdate{
+				{Name: "axios", From: "1.6.0", To: "1.7.4", Path: "/frontend"},
+				{Name: "axios", From: "1.5.1", To: "1.7.4", Path: "/services/api"},
+				{Name: "express", From: "4.18.2", To: "4.19.2", Path: "/services/api"},
+			},
+			"https://github.com/axios/axios/releases"},
+		{"renovate-single", "chore(deps): update dependency eslint to v9.9.1",
+			[]depUpdate{{Name: "eslint", From: "9.9.0", To: "9.9.1"}},
+			"https://github.com/eslint/eslint/releases/tag/v9.9.1"},
+		{"renovate-monorepo", "fix(deps): update babel monorepo to v7.25.2",
+			[]depUpdate{
+				{Name: "@babel/core", From: "7.25.1", To: "7.25.2"},
+				{Name: "@babel/preset-env", From: "7.24.8", To: "7.25.2"},
+				{Name: "golang", From: "a1b2c3d", To: "e4f5a6b"},
+			},
+			"https://github.com/babel/babel/compare/v7.25.1...v7.25.2"},
+	}
+	for _, test := range tests {
+		t.Run(test.fixture, func(t *testing.T) {
+			body, err := os.ReadFile(filepath.Join("testdata", "deps", test.fixture+".md"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := parseDepUpdates(test.title, string(body)); !slices.Equal(got, test.want) {
+				t.Errorf("updates =\n%+v\nwant\n%+v", got, test.want)
+			}
+			if got := changelogURL(string(body)); got != test.changelog {
+				t.Errorf("changelog = %q, want %q", got, test.changelog)
+			}
+		})
+	}
+}
+
+// TestParseDepUpdatesTitles reads the titles of pull requests whose body lists nothing
+func TestParseDepUpdatesTitles(t *testing.T) {
+	tests := []struct {
+		title string
+		want  []depUpdate
+	}{
+		{"Bump golang.org/x/net from 0.23.0 to 0.25.0", []depUpdate{{Name: "golang.org/x/net", From: "0.23.0", To: "0.25.0"}}},
+		{"build(deps): bump actions/checkout from 3 to 4 in /.github/workflows", []depUpdate{{Name: "actions/checkout", From: "3", To: "4", Path: "/.github/workflows"}}},
+		{"[Security] Bump express from 4.17.1 to 4.17.3 in /services/api", []depUpdate{{Name: "express", From: "4.17.1", To: "4.17.3", Path: "/services/api"}}},
+		{"Update dependency lodash to v4.17.21", []depUpdate{{Name: "lodash", To: "v4.17.21"}}},
+		{"chore(deps): update module github.com/spf13/cobra to v1.8.1", []depUpdate{{Name: "github.com/spf13/cobra", To: "v1.8.1"}}},
+		{"Update rust crate serde to 1.0.210", []depUpdate{{Name: "serde", To: "1.0.210"}}},
+		{"Update Helm release grafana to v8.4.5", []depUpdate{{Name: "grafana", To: "v8.4.5"}}},
+		{"Update babel monorepo to v7.25.2", []depUpdate{{Name: "babel", To: "v7.25.2"}}},
+		{"Update actions/checkout action to v4", []depUpdate{{Name: "actions/checkout", To: "v4"}}},
+		{"Update nginx Docker tag to v1.27", []depUpdate{{Name: "nginx", To: "v1.27"}}},
+		{"Fix the login page", nil},
+		{"Update the docs to the new API", nil},
+	}
+	for _, test := range tests {
+		if got := parseDepUpdates(test.title, ""); !slices.Equal(got, test.want) {
+			t.Errorf("parseDepUpdates(%q) = %+v, want %+v", test.title, got, test.want)
+		}
+	}
+}
+
+func TestSemverClass(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     string
+	}{
+		{"1.2.3", "2.0.0", "major"},
+		{"v1.2.3", "v1.3.0", "minor"},
+		{"4.17.20", "4.17.21", "patch"},
+		{"3", "4", "major"},
+		{"1.2", "1.2.1", "patch"},
+		{"1.0.0-rc.1", "1.0.0", "prerelease"},
+		{"v1.2.3", "1.2.3", "none"},
+		{"", "v4.17.21", "unknown"},
+		{"a1b2c3d", "e4f5a6b", "unknown"},
+		{"1.2.3.4", "1.2.3.5", "unknown"},
+	}
+	for _, test := range tests {
+		if got := semverClass(test.from, test.to); got != test.want {
+			t.Errorf("semverClass(%q, %q) = %q, want %q", test.from, test.to, got, test.want)
+		}
+	}
+}
+
+func TestCISummary(t *testing.T) {
+	tests := []struct {
+		states []string
+		want   string
+	}{
+		{nil, "none"},
+		{[]string{"success", "neutral", "skipped"}, "passing"},
+		{[]string{"success", "pending"}, "pending"},
+		{[]string{"pending", "failure"}, "failing"},
+		{[]string{"success", "timed_out"}, "failing"},
+	}
+	for _, test := range tests {
+		var statuses []CommitStatus
+		for _, state := range test.states {
+			statuses = append(statuses, CommitStatus{State: state})
+		}
+		if got := ciSummary(statuses); got != test.want {
+			t.Errorf("ciSummary(%q) = %q, want %q", test.states, got, test.want)
+		}
+	}
+}
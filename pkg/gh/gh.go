@@ -1,44 +1,244 @@
 package gh
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"os"
-	"os/exec"
+	"strconv"
+	"strings"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/style"
+	"gsn-dev-tools/internals/tui"
 
 	"github.com/spf13/cobra"
 )
 
 func ApproveGhPrs() *cobra.Command {
-	var message string
+	var headSHA string
+	var printHead bool
+	var offline bool
+	var porcelain bool
 
 	approveCmd := &cobra.Command{
-		Use:   "approve <PR_URL>",
-		Short: "Approve a GitHub PR with optional message",
-		Args:  cobra.ExactArgs(1),
+		Use:   "approve <PR_URL>...",
+		Short: "Approve one or more GitHub PRs with optional message",
+		Long: `Submits an approving review on each pull request. With --head-sha the approval is refused when the PR head
+moved, so you only approve the commit you reviewed. The message given with -m or picked from gh.review_templates
+of the config file with --template may use {author}, {title}, {number}, {repo}, {files_changed}, {additions} and
+{deletions}; {{ and }} are literal braces.
+
+--porcelain is for scripts and hooks: it prints exactly one line per PR in input order, the PR as given, approved
+or failed, and the review ID or the error, separated by tabs. Diagnostics go to stderr, nothing is queued when
+GitHub cannot be reached, and the exit code is the one of the failures.`,
+		Example: `  gsn approve https://github.com/owner/repo/pull/42
+  gsn approve https://github.com/owner/repo/pull/42 --head-sha 1a2b3c4 -m "LGTM, thanks @{author}!"
+  gsn approve https://github.com/owner/repo/pull/42 --template thanks
+  gsn approve https://github.com/owner/repo/pull/7 https://github.com/owner/repo/pull/8 --dry-run
+  gsn approve --pick -m "LGTM"
+  gsn approve owner/repo#7 owner/repo#8 --porcelain`,
+		Args:              tui.Args(cobra.MinimumNArgs(1)),
+		ValidArgsFunction: completePRRefs,
 		Run: func(cmd *cobra.Command, args []string) {
-			prURL := args[0]
+			if porcelain && dryrun.Enabled() {
+				clierr.Exitf(clierr.Usage, "--porcelain cannot be used with --dry-run")
+			}
+			client, err := NewClient("repo")
+			if err != nil && porcelain && len(args) > 0 {
+				failAllPorcelain(args, err)
+			}
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			if args, err = prArgs(cmd, client, args); err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			client.Offline = offline
+			if !printHead && !porcelain {
+				if client.Queue, err = OpenQueue(); err != nil {
+					clierr.Fatalf("%v", err)
+				}
+			}
 
-			// Construct the gh command
-			var ghCmd *exec.Cmd
-			if message != "" {
-				ghCmd = exec.Command("gh", "pr", "review", prURL, "--approve", "--body", message)
-			} else {
-				ghCmd = exec.Command("gh", "pr", "review", prURL, "--approve")
+			if headSHA != "" && len(args) > 1 {
+				clierr.Exitf(clierr.Usage, "--head-sha can only be used with a single PR")
 			}
 
-			// Attach stdout and stderr so you can see gh output
-			ghCmd.Stdout = os.Stdout
-			ghCmd.Stderr = os.Stderr
+			message, err := reviewMessageFromFlags(cmd)
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			opts, err := batchOptionsFromFlags(cmd, "approve")
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			op := func(ctx context.Context, prURL string) (string, error) {
+				return approvePR(ctx, client, prURL, headSHA, message)
+			}
+			if printHead {
+				opts.Verb = "resolve"
+				op = func(ctx context.Context, prURL string) (string, error) {
+					return headSHAOf(ctx, client, prURL)
+				}
+			}
+			if porcelain {
+				opts.Quiet = true
+				op = func(ctx context.Context, prURL string) (string, error) {
+					a, err := submitApproval(ctx, client, prURL, headSHA, message)
+					return strconv.FormatInt(a.ReviewID, 10), err
+				}
+			}
 
-			// Run the command
-			if err := ghCmd.Run(); err != nil {
-				fmt.Fprintf(os.Stderr, "☠️ Failed to approve the PR: %v\n", err.Error())
-			} else {
-				fmt.Println("🎉 Pull Request approved successfully!")
+			results := runBatch(cmd.Context(), args, func(prURL string) string { return prURL }, opts, op)
+			if porcelain {
+				if printPorcelain(results) > 0 {
+					clierr.Exit(batchExitCode(results))
+				}
+				return
+			}
+			if printBatchSummary(results, opts.Verb) > 0 {
+				clierr.Exit(batchExitCode(results))
 			}
 		},
 	}
 
-	approveCmd.Flags().StringVarP(&message, "message", "m", "", "Optional review message")
+	addReviewMessageFlags(approveCmd)
+	approveCmd.Flags().BoolVar(&offline, "offline", false, "Queue the approvals for gsn gh queue flush without contacting GitHub")
+	approveCmd.Flags().StringVar(&headSHA, "head-sha", "", "Refuse to approve unless the PR head matches this commit SHA")
+	approveCmd.Flags().BoolVar(&printHead, "print-head", false, "Print the current head SHA of each PR instead of approving")
+	approveCmd.Flags().BoolVar(&porcelain, "porcelain", false, "Print one tab separated line per PR: the PR, approved or failed, and the review ID or the error")
+	approveCmd.MarkFlagsMutuallyExclusive("porcelain", "offline")
+	approveCmd.MarkFlagsMutuallyExclusive("porcelain", "print-head")
+	addBatchFlags(approveCmd)
+	addPickFlag(approveCmd)
+	dryrun.Adopt(approveCmd)
 	return approveCmd
 }
+
+// ApprovePR approves a single PR like gsn approve, queueing the approval for gsn gh queue flush when GitHub
+// cannot be reached. It returns the message gsn approve prints.
+func ApprovePR(ctx context.Context, prURL string, headSHA string, message string) (string, error) {
+	client, err := NewClient("repo")
+	if err != nil {
+		return "", err
+	}
+	if client.Queue, err = OpenQueue(); err != nil {
+		return "", err
+	}
+	return approvePR(ctx, client, prURL, headSHA, message)
+}
+
+// approval is the outcome of approvePR
+type approval struct {
+	// Message is what gsn approve prints
+	Message string
+	// ReviewID identifies the submitted review, 0 when the approval was only printed or queued
+	ReviewID int64
+}
+
+// approvePR resolves a single PR and submits the approval pinned to its current head commit, with the
+// message's placeholders filled in from the PR
+func approvePR(ctx context.Context, client *Client, prURL string, headSHA string, message string) (string, error) {
+	a, err := submitApproval(ctx, client, prURL, headSHA, message)
+	return a.Message, err
+}
+
+// submitApproval is approvePR returning the review ID as well
+func submitApproval(ctx context.Context, client *Client, prURL string, headSHA string, message string) (approval, error) {
+	ref, err := ParsePRURL(prURL)
+	if err != nil {
+		return approval{}, err
+	}
+	if client.Offline && client.Queue != nil {
+		queued, err := queueApproval(client, ref, headSHA, message)
+		return approval{Message: queued}, err
+	}
+
+	pr, err := client.GetPR(ctx, ref)
+	if errors.Is(err, ErrNetwork) && client.Queue != nil {
+		queued, err := queueApproval(client, ref, headSHA, message)
+		return approval{Message: queued}, err
+	}
+	if err != nil {
+		return approval{}, err
+	}
+	if pr.State != "open" {
+		return approval{}, clierr.Newf(clierr.Conflict, "pull request is %s", pr.State)
+	}
+	if headSHA != "" && !matchesSHA(pr.Head.SHA, headSHA) {
+		return approval{}, clierr.Newf(clierr.Conflict, "head moved: expected %s but the PR head is %s", headSHA, pr.Head.SHA)
+	}
+
+	body, err := renderReviewMessage(message, ref, pr)
+	if err != nil {
+		return approval{}, err
+	}
+	reviewID, err := client.Approve(ctx, ref, pr.Head.SHA, body)
+	if errors.Is(err, ErrQueued) {
+		return approval{Message: fmt.Sprintf(style.Warning()+"GitHub is unreachable, queued the approval of %s (%v)", ref, err)}, nil
+	} else if err != nil {
+		return approval{}, err
+	}
+
+	if client.DryRun {
+		return approval{Message: fmt.Sprintf("Would approve %s: %s", ref, pr.Title)}, nil
+	}
+	return approval{Message: fmt.Sprintf(style.Celebrate()+"Pull Request %s approved successfully!", ref), ReviewID: reviewID}, nil
+}
+
+// printPorcelain prints the results of approve --porcelain, one line per PR, and returns how many failed
+func printPorcelain(results []batchResult) int {
+	failed := 0
+	for _, r := range results {
+		if r.Err != nil {
+			fmt.Printf("%s\tfailed\t%s\n", r.Name, strings.Join(strings.Fields(r.Err.Error()), " "))
+			failed++
+			continue
+		}
+		fmt.Printf("%s\tapproved\t%s\n", r.Name, r.Message)
+	}
+	return failed
+}
+
+// failAllPorcelain reports every PR as failed with err, for errors that stop approve before any PR is tried
+func failAllPorcelain(prs []string, err error) {
+	results := make([]batchResult, len(prs))
+	for i, pr := range prs {
+		results[i] = batchResult{Name: pr, Err: err}
+	}
+	printPorcelain(results)
+	clierr.Exit(batchExitCode(results))
+}
+
+// queueApproval saves an approval for gsn gh queue flush, which resolves the PR and renders the message on replay
+func queueApproval(client *Client, ref PRRef, headSHA string, message string) (string, error) {
+	op, err := client.Queue.Add(QueuedOp{Kind: opApprove, PR: ref.String(), HeadSHA: headSHA, Message: message})
+	if err != nil {
+		return "", fmt.Errorf("failed to queue the approval: %w", err)
+	}
+	return fmt.Sprintf(style.Warning()+"Queued the approval of %s as %s, run gsn gh queue flush once online", ref, op.ID), nil
+}
+
+// headSHAOf returns the current head commit of a PR so it can be pinned with --head-sha
+func headSHAOf(ctx context.Context, client *Client, prURL string) (string, error) {
+	ref, err := ParsePRURL(prURL)
+	if err != nil {
+		return "", err
+	}
+
+	pr, err := client.GetPR(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	return pr.Head.SHA, nil
+}
+
+// matchesSHA compares a full commit SHA against a full or abbreviated (7+ chars) expectation
+func matchesSHA(actual string, expected string) bool {
+	expected = strings.ToLower(strings.TrimSpace(expected))
+	actual = strings.ToLower(actual)
+	return actual == expected || (len(expected) >= 7 && strings.HasPrefix(actual, expected))
+}
@@ -0,0 +1,114 @@
+package gh
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Issue holds the issue metadata returned by the REST API
+type Issue struct {
+	Number    int       `json:"number"`
+	Title     string    `json:"title"`
+	Body      string    `json:"body"`
+	State     string    `json:"state"`
+	HTMLURL   string    `json:"html_url"`
+	CreatedAt time.Time `json:"created_at"`
+	User      struct {
+		Login string `json:"login"`
+	} `json:"user"`
+	Labels []struct {
+		Name string `json:"name"`
+	} `json:"labels"`
+	Assignees []struct {
+		Login string `json:"login"`
+	} `json:"assignees"`
+
+	// PullRequest is set when the issues endpoint returns a pull request
+	PullRequest *struct{} `json:"pull_request,omitempty"`
+}
+
+// issueRequest is the payload of the create issue endpoint
+type issueRequest struct {
+	Title     string   `json:"title"`
+	Body      string   `json:"body,omitempty"`
+	Labels    []string `json:"labels,omitempty"`
+	Assignees []string `json:"assignees,omitempty"`
+}
+
+// commentRequest is the payload of the create comment endpoint
+type commentRequest struct {
+	Body string `json:"body"`
+}
+
+// stateRequest changes the state of an issue
+type stateRequest struct {
+	State       string `json:"state"`
+	StateReason string `json:"state_reason,omitempty"`
+}
+
+// IssueListOptions filters ListIssues
+type IssueListOptions struct {
+	State    string
+	Assignee string
+	Labels   []string
+}
+
+// GetIssue fetches the metadata of a single issue
+func (c *Client) GetIssue(ctx context.Context, ref IssueRef) (*Issue, error) {
+	var issue Issue
+	if err := c.Get(ctx, ref.APIPath(), &issue); err != nil {
+		return nil, err
+	}
+	return &issue, nil
+}
+
+// CreateIssue opens an issue. In dry-run mode nothing is sent and the returned issue is empty.
+func (c *Client) CreateIssue(ctx context.Context, owner string, repo string, req issueRequest) (*Issue, error) {
+	var issue Issue
+	path := fmt.Sprintf("/repos/%s/%s/issues", owner, repo)
+	if err := c.Write(ctx, "POST", path, req, &issue); err != nil {
+		return nil, err
+	}
+	return &issue, nil
+}
+
+// CommentIssue adds a comment to an issue or pull request
+func (c *Client) CommentIssue(ctx context.Context, ref IssueRef, body string) error {
+	return c.Write(ctx, "POST", ref.APIPath()+"/comments", commentRequest{Body: body}, nil)
+}
+
+// CloseIssue closes an issue with the given reason, completed or not_planned
+func (c *Client) CloseIssue(ctx context.Context, ref IssueRef, reason string) error {
+	return c.Write(ctx, "PATCH", ref.APIPath(), stateRequest{State: "closed", StateReason: reason}, nil)
+}
+
+// ListIssues fetches the issues of a repository, leaving out pull requests
+func (c *Client) ListIssues(ctx context.Context, owner string, repo string, opts IssueListOptions) ([]Issue, error) {
+	query := url.Values{"per_page": {"100"}}
+	if opts.State != "" {
+		query.Set("state", opts.State)
+	}
+	if opts.Assignee != "" {
+		query.Set("assignee", opts.Assignee)
+	}
+	if len(opts.Labels) > 0 {
+		query.Set("labels", strings.Join(opts.Labels, ","))
+	}
+
+	var all []Issue
+	path := fmt.Sprintf("/repos/%s/%s/issues?%s", owner, repo, query.Encode())
+	if err := c.Get(ctx, path, &all); err != nil {
+		return nil, err
+	}
+
+	issues := all[:0]
+	for _, issue := range all {
+		if issue.PullRequest == nil {
+			issues = append(issues, issue)
+		}
+	}
+	return issues, nil
+}
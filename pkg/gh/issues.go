@@ -0,0 +1,335 @@
+package gh
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/clipboard"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/editor"
+	"gsn-dev-tools/internals/output"
+	"gsn-dev-tools/internals/style"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+var issueColumns = []output.Column[Issue]{
+	{Name: "number", Value: func(i Issue) any { return i.Number }},
+	{Name: "title", Value: func(i Issue) any { return i.Title }},
+	{Name: "labels", Value: func(i Issue) any {
+		var names []string
+		for _, l := range i.Labels {
+			names = append(names, l.Name)
+		}
+		return strings.Join(names, ",")
+	}},
+	{Name: "assignees", Value: func(i Issue) any {
+		var logins []string
+		for _, a := range i.Assignees {
+			logins = append(logins, a.Login)
+		}
+		return strings.Join(logins, ",")
+	}},
+	{Name: "author", Value: func(i Issue) any { return i.User.Login }},
+	{
+		Name:    "age",
+		Value:   func(i Issue) any { return time.Since(i.CreatedAt) },
+		Display: func(i Issue) string { return formatAge(time.Since(i.CreatedAt)) },
+	},
+	{Name: "url", Value: func(i Issue) any { return i.HTMLURL }},
+}
+
+func IssueCmd() *cobra.Command {
+	issueCmd := &cobra.Command{
+		Use:   "issue",
+		Short: "Create, close and list GitHub issues",
+		Long:  "Triage GitHub issues from the terminal. Without --repo the repository of the origin remote is used.",
+		Example: `  gsn issue list --assignee @me
+  gsn issue create --title "Crash on start" --label bug
+  gsn issue close https://github.com/owner/repo/issues/12 --comment "Fixed in #40"`,
+	}
+
+	issueCmd.AddCommand(createIssueCmd())
+	issueCmd.AddCommand(closeIssuesCmd())
+	issueCmd.AddCommand(listIssuesCmd())
+	return issueCmd
+}
+
+func createIssueCmd() *cobra.Command {
+	var repo, title, body, templateName string
+	var labels, assignees []string
+	var edit, copyURL bool
+
+	createCmd := &cobra.Command{
+		Use:   "create",
+		Short: "Open a new issue",
+		Long: `Opens an issue with --title and --body, or composes it in $EDITOR with --edit: the first line is the title
+and the rest the body. With --edit the markdown templates in .github/ISSUE_TEMPLATE are offered, or picked
+with --template, and their title, labels and assignees are applied. The new issue URL is printed.`,
+		Example: `  gsn issue create --title "Crash on start" --body "Steps to reproduce..." --label bug --assignee @me
+  gsn issue create -R owner/repo --edit --template bug_report --copy`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := cmd.Context()
+			owner, name, err := resolveRepo(ctx, repo)
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			req := issueRequest{Title: title, Body: body, Labels: labels, Assignees: assignees}
+			if edit {
+				if err := composeIssue(ctx, &req, templateName); err != nil {
+					clierr.Fatalf("%v", err)
+				}
+			} else if templateName != "" {
+				clierr.Exitf(clierr.Usage, "--template requires --edit")
+			}
+			if req.Title == "" {
+				clierr.Exitf(clierr.Usage, "The issue needs a title, use --title or --edit")
+			}
+
+			client, err := NewClient("repo")
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			if req.Assignees, err = expandMe(cmd, client, req.Assignees); err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			issue, err := client.CreateIssue(ctx, owner, name, req)
+			if err != nil {
+				clierr.Fatalf("Failed to create issue: %v", err)
+			}
+			if client.DryRun {
+				fmt.Printf("Would create issue in %s/%s: %s\n", owner, name, req.Title)
+				return
+			}
+
+			fmt.Printf(style.Success()+"Created %s/%s#%d: %s\n", owner, name, issue.Number, issue.HTMLURL)
+			if copyURL {
+				if err := clipboard.Copy(ctx, issue.HTMLURL); err != nil {
+					fmt.Fprintf(os.Stderr, style.Warning()+"Could not copy the URL: %v\n", err)
+				}
+			}
+		},
+	}
+
+	createCmd.Flags().StringVarP(&repo, "repo", "R", "", "Repository in owner/repo format (defaults to the origin remote)")
+	_ = createCmd.RegisterFlagCompletionFunc("repo", completeRepos)
+	createCmd.Flags().StringVarP(&title, "title", "t", "", "Issue title")
+	createCmd.Flags().StringVarP(&body, "body", "b", "", "Issue body (markdown)")
+	createCmd.Flags().BoolVarP(&edit, "edit", "e", false, "Compose the title and body in $EDITOR")
+	createCmd.Flags().StringVar(&templateName, "template", "", "Issue template from .github/ISSUE_TEMPLATE to start from (with --edit)")
+	createCmd.Flags().StringSliceVarP(&labels, "label", "l", nil, "Labels to add (comma separated)")
+	createCmd.Flags().StringSliceVarP(&assignees, "assignee", "a", nil, "Logins to assign, @me for yourself (comma separated)")
+	createCmd.Flags().BoolVar(&copyURL, "copy", false, "Copy the new issue URL to the clipboard")
+	dryrun.Adopt(createCmd)
+	return createCmd
+}
+
+// composeIssue fills req from an issue template and the user's editor
+func composeIssue(ctx context.Context, req *issueRequest, templateName string) error {
+	root, err := repoRoot(ctx)
+	if err != nil {
+		root = "."
+	}
+	templates, err := loadIssueTemplates(root)
+	if err != nil {
+		return err
+	}
+
+	var tmpl *issueTemplate
+	switch {
+	case templateName != "":
+		if tmpl, err = findIssueTemplate(templates, templateName); err != nil {
+			return err
+		}
+	case len(templates) > 0 && term.IsTerminal(int(os.Stdin.Fd())):
+		if tmpl, err = promptIssueTemplate(templates); err != nil {
+			return err
+		}
+	}
+
+	if tmpl != nil {
+		if req.Title == "" {
+			req.Title = tmpl.Title
+		}
+		if req.Body == "" {
+			req.Body = tmpl.Body
+		}
+		req.Labels = mergeNames(req.Labels, tmpl.Labels)
+		req.Assignees = mergeNames(req.Assignees, tmpl.Assignees)
+	}
+
+	text, err := editor.Edit(ctx, editorContent(req.Title, req.Body), "issue-*.md")
+	if err != nil {
+		return err
+	}
+	req.Title, req.Body = parseEditedIssue(text)
+	if req.Title == "" {
+		return fmt.Errorf("aborting, the edited issue has no title")
+	}
+	return nil
+}
+
+// mergeNames appends the names of extra not already in names
+func mergeNames(names []string, extra []string) []string {
+	for _, name := range extra {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// expandMe replaces @me with the login of the authenticated user
+func expandMe(cmd *cobra.Command, client *Client, logins []string) ([]string, error) {
+	if !slices.Contains(logins, "@me") {
+		return logins, nil
+	}
+	me, _, err := currentUser(cmd, client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve @me: %w", err)
+	}
+
+	expanded := make([]string, len(logins))
+	for i, login := range logins {
+		if login == "@me" {
+			login = me
+		}
+		expanded[i] = login
+	}
+	return expanded, nil
+}
+
+func closeIssuesCmd() *cobra.Command {
+	var comment, reason string
+
+	closeCmd := &cobra.Command{
+		Use:   "close <ISSUE_URL>...",
+		Short: "Close one or more issues, optionally with a comment",
+		Long: `Closes each issue with --reason. With --comment the comment is posted first, so the timeline reads as the
+explanation followed by the close event.`,
+		Example: `  gsn issue close https://github.com/owner/repo/issues/12
+  gsn issue close owner/repo#12 owner/repo#13 --comment "Duplicate of #10" --reason not_planned`,
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			switch reason {
+			case "completed", "not_planned":
+			default:
+				clierr.Exitf(clierr.Usage, "invalid --reason '%s' (use completed or not_planned)", reason)
+			}
+
+			client, err := NewClient("repo")
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			failed := 0
+			for _, issueURL := range args {
+				ref, err := ParseIssueURL(issueURL)
+				if err == nil {
+					err = closeIssue(cmd.Context(), client, ref, comment, reason)
+				}
+				if err != nil {
+					fmt.Fprintf(os.Stderr, style.Error()+"Failed to close %s: %v\n", issueURL, err)
+					failed++
+					continue
+				}
+
+				if client.DryRun {
+					fmt.Printf("Would close %s as %s\n", ref, reason)
+				} else {
+					fmt.Printf(style.Success()+"Closed %s\n", ref)
+				}
+			}
+
+			if failed > 0 {
+				clierr.Exit(clierr.Failure)
+			}
+		},
+	}
+
+	closeCmd.Flags().StringVarP(&comment, "comment", "c", "", "Comment posted before closing")
+	closeCmd.Flags().StringVar(&reason, "reason", "completed", "Close reason: completed or not_planned")
+	dryrun.Adopt(closeCmd)
+	return closeCmd
+}
+
+// closeIssue posts the optional comment, then closes the issue
+func closeIssue(ctx context.Context, client *Client, ref IssueRef, comment string, reason string) error {
+	issue, err := client.GetIssue(ctx, ref)
+	if err != nil {
+		return err
+	}
+	if issue.State == "closed" {
+		return fmt.Errorf("issue is already closed")
+	}
+
+	if comment != "" {
+		if err := client.CommentIssue(ctx, ref, comment); err != nil {
+			return fmt.Errorf("failed to comment: %w", err)
+		}
+	}
+	return client.CloseIssue(ctx, ref, reason)
+}
+
+func listIssuesCmd() *cobra.Command {
+	var repo, assignee, state string
+	var labels []string
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the issues of a repository",
+		Long:  "Lists the issues of a repository, leaving out pull requests, filtered by state, assignee and labels.",
+		Example: `  gsn issue list --assignee @me
+  gsn issue list -R owner/repo --label bug,triage --sort age:desc
+  gsn issue list --state closed --csv`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			opts, err := output.OptionsFromFlags(cmd)
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			owner, name, err := resolveRepo(cmd.Context(), repo)
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			client, err := NewClient("repo")
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			assignees, err := expandMe(cmd, client, []string{assignee})
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			issues, err := client.ListIssues(cmd.Context(), owner, name, IssueListOptions{State: state, Assignee: assignees[0], Labels: labels})
+			if err != nil {
+				clierr.Fatalf("Failed to list issues: %v", err)
+			}
+
+			if err := output.Render(os.Stdout, issueColumns, issues, opts); err != nil {
+				clierr.Fatalf("%v", err)
+			}
+		},
+	}
+
+	listCmd.Flags().StringVarP(&repo, "repo", "R", "", "Repository in owner/repo format (defaults to the origin remote)")
+	_ = listCmd.RegisterFlagCompletionFunc("repo", completeRepos)
+	listCmd.Flags().StringVarP(&assignee, "assignee", "a", "", "Only issues assigned to this login, @me for yourself")
+	listCmd.Flags().StringSliceVarP(&labels, "label", "l", nil, "Only issues with all of these labels (comma separated)")
+	listCmd.Flags().StringVar(&state, "state", "open", "Issue state: open, closed or all")
+	output.AddFlags(listCmd)
+	return listCmd
+}
@@ -0,0 +1,81 @@
+package gh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/style"
+	"gsn-dev-tools/internals/tui"
+
+	"github.com/spf13/cobra"
+)
+
+// labelsRequest is the payload of the add labels endpoint
+type labelsRequest struct {
+	Labels []string `json:"labels"`
+}
+
+func labelPrsCmd() *cobra.Command {
+	var labels []string
+	var offline bool
+
+	labelCmd := &cobra.Command{
+		Use:   "label <PR_URL>...",
+		Short: "Add labels to one or more pull requests",
+		Long:  "Adds the --add labels to each pull request, keeping the labels it already has.",
+		Example: `  gsn pr label https://github.com/owner/repo/pull/42 --add needs-review,backend
+  gsn pr label --pick --add backend`,
+		Args:              tui.Args(cobra.MinimumNArgs(1)),
+		ValidArgsFunction: completePRRefs,
+		Run: func(cmd *cobra.Command, args []string) {
+			client, err := NewClient("repo")
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			if args, err = prArgs(cmd, client, args); err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			client.Offline = offline
+			if client.Queue, err = OpenQueue(); err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			opts, err := batchOptionsFromFlags(cmd, "label")
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			results := runBatch(cmd.Context(), args, func(prURL string) string { return prURL }, opts, func(ctx context.Context, prURL string) (string, error) {
+				ref, err := ParsePRURL(prURL)
+				if err != nil {
+					return "", err
+				}
+				path := fmt.Sprintf("/repos/%s/%s/issues/%d/labels", ref.Owner, ref.Repo, ref.Number)
+				if err := client.Write(ctx, "POST", path, labelsRequest{Labels: labels}, nil); errors.Is(err, ErrQueued) {
+					return fmt.Sprintf(style.Warning()+"GitHub is unreachable, queued the labels of %s (%v)", ref, err), nil
+				} else if err != nil {
+					return "", err
+				}
+
+				if client.DryRun {
+					return fmt.Sprintf("Would label %s with %v", ref, labels), nil
+				}
+				return fmt.Sprintf(style.Success()+"Labeled %s with %v", ref, labels), nil
+			})
+			if printBatchSummary(results, opts.Verb) > 0 {
+				clierr.Exit(batchExitCode(results))
+			}
+		},
+	}
+
+	labelCmd.Flags().StringSliceVarP(&labels, "add", "l", nil, "Labels to add (comma separated)")
+	labelCmd.Flags().BoolVar(&offline, "offline", false, "Queue the labels for gsn gh queue flush without contacting GitHub")
+	addBatchFlags(labelCmd)
+	addPickFlag(labelCmd)
+	_ = labelCmd.MarkFlagRequired("add")
+	dryrun.Adopt(labelCmd)
+	return labelCmd
+}
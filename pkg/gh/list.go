@@ -0,0 +1,166 @@
+package gh
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/execx"
+	"gsn-dev-tools/internals/output"
+
+	"github.com/spf13/cobra"
+)
+
+// PullRequest holds the fields of a PR shown by list-style commands
+type PullRequest struct {
+	Number    int       `json:"number"`
+	Title     string    `json:"title"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"createdAt"`
+	Author    struct {
+		Login string `json:"login"`
+	} `json:"author"`
+}
+
+// prColumns declares the columns available to `pr list`
+var prColumns = []output.Column[PullRequest]{
+	{Name: "number", Value: func(pr PullRequest) any { return pr.Number }},
+	{Name: "title", Value: func(pr PullRequest) any { return pr.Title }},
+	{Name: "author", Value: func(pr PullRequest) any { return pr.Author.Login }},
+	{
+		Name:    "age",
+		Value:   func(pr PullRequest) any { return time.Since(pr.CreatedAt) },
+		Display: func(pr PullRequest) string { return formatAge(time.Since(pr.CreatedAt)) },
+	},
+	{Name: "url", Value: func(pr PullRequest) any { return pr.URL }},
+}
+
+func PrCmd() *cobra.Command {
+	prCmd := &cobra.Command{
+		Use:   "pr",
+		Short: "Work with GitHub pull requests",
+		Long:  "Lists, merges and labels GitHub pull requests by URL, reviews them by annotating their diff, and lists the review requests waiting on you for too long.",
+		Example: `  gsn pr list --repo owner/repo
+  gsn pr merge https://github.com/owner/repo/pull/42`,
+	}
+
+	prCmd.AddCommand(listPrsCmd())
+	prCmd.AddCommand(mergePrsCmd())
+	prCmd.AddCommand(labelPrsCmd())
+	prCmd.AddCommand(staleCmd())
+	prCmd.AddCommand(depsPrsCmd())
+	prCmd.AddCommand(annotatePrCmd())
+	return prCmd
+}
+
+func listPrsCmd() *cobra.Command {
+	var repo string
+	var search string
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List open pull requests of a repository",
+		Long: `Lists the open pull requests of a repository through the gh CLI, optionally filtered by a GitHub search query.
+Without gh, a token from $GSN_GH_TOKEN or gsn gh auth login lists them through the GitHub API instead.`,
+		Example: `  gsn pr list
+  gsn pr list -R owner/repo --search "author:app/dependabot" --sort age:desc`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			opts, err := output.OptionsFromFlags(cmd)
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			prs, err := ListPullRequests(cmd.Context(), repo, search)
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			if err := output.Render(os.Stdout, prColumns, prs, opts); err != nil {
+				clierr.Fatalf("%v", err)
+			}
+		},
+	}
+
+	listCmd.Flags().StringVarP(&repo, "repo", "R", "", "Repository in owner/repo format (defaults to the current repo)")
+	_ = listCmd.RegisterFlagCompletionFunc("repo", completeRepos)
+	listCmd.Flags().StringVarP(&search, "search", "s", "", "Filter pull requests with a GitHub search query")
+	output.AddFlags(listCmd)
+	return listCmd
+}
+
+// ListPullRequests lists the open pull requests of repo, or of the current repo when empty, through the gh
+// CLI, optionally filtered by a GitHub search query. Without gh it uses the API when a token is set.
+func ListPullRequests(ctx context.Context, repo string, search string) ([]PullRequest, error) {
+	a, err := resolveAccess(Runner)
+	if err != nil {
+		return nil, err
+	}
+	if !a.GhCLI {
+		fmt.Fprintf(os.Stderr, "gh is not installed, listing through the GitHub API with the token from %s\n", a.TokenSource)
+		return listPullRequestsAPI(ctx, repo, search)
+	}
+
+	ghArgs := []string{"pr", "list", "--json", "number,title,url,createdAt,author"}
+	if repo != "" {
+		ghArgs = append(ghArgs, "--repo", repo)
+	}
+	if search != "" {
+		ghArgs = append(ghArgs, "--search", search)
+	}
+
+	out, _, _, err := Runner.Run(ctx, "gh", ghArgs, execx.Options{Stderr: os.Stderr})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pull requests: %w", err)
+	}
+
+	var prs []PullRequest
+	if err := json.Unmarshal(out, &prs); err != nil {
+		return nil, fmt.Errorf("failed to parse gh output: %w", err)
+	}
+	return prs, nil
+}
+
+// listPullRequestsAPI is ListPullRequests through the API, searching when there is a query like gh does
+func listPullRequestsAPI(ctx context.Context, repo string, search string) ([]PullRequest, error) {
+	owner, name, err := resolveRepo(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	client, err := NewClient()
+	if err != nil {
+		return nil, err
+	}
+
+	var details []PRDetails
+	if search == "" {
+		details, err = client.ListOpenPRs(ctx, owner, name)
+	} else {
+		details, err = client.SearchPRs(ctx, fmt.Sprintf("repo:%s/%s is:pr is:open %s", owner, name, search), "")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pull requests: %w", err)
+	}
+
+	prs := make([]PullRequest, len(details))
+	for i, d := range details {
+		prs[i] = PullRequest{Number: d.Number, Title: d.Title, URL: d.HTMLURL, CreatedAt: d.CreatedAt}
+		prs[i].Author.Login = d.User.Login
+	}
+	return prs, nil
+}
+
+// formatAge renders a duration in the coarse units used for PR ages
+func formatAge(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	}
+}
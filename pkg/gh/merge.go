@@ -0,0 +1,91 @@
+package gh
+
+import (
+	"context"
+	"fmt"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/style"
+	"gsn-dev-tools/internals/tui"
+
+	"github.com/spf13/cobra"
+)
+
+// mergeRequest is the payload of the merge endpoint
+type mergeRequest struct {
+	MergeMethod string `json:"merge_method"`
+	SHA         string `json:"sha,omitempty"`
+}
+
+func mergePrsCmd() *cobra.Command {
+	var method string
+
+	mergeCmd := &cobra.Command{
+		Use:   "merge <PR_URL>...",
+		Short: "Merge one or more open pull requests",
+		Long:  "Merges each open pull request with --method, pinned to the head commit seen when the PR was resolved.",
+		Example: `  gsn pr merge https://github.com/owner/repo/pull/42
+  gsn pr merge https://github.com/owner/repo/pull/42 --method rebase --dry-run
+  gsn pr merge --pick`,
+		Args:              tui.Args(cobra.MinimumNArgs(1)),
+		ValidArgsFunction: completePRRefs,
+		Run: func(cmd *cobra.Command, args []string) {
+			switch method {
+			case "merge", "squash", "rebase":
+			default:
+				clierr.Exitf(clierr.Usage, "invalid --method '%s' (use merge, squash or rebase)", method)
+			}
+
+			client, err := NewClient("repo")
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			if args, err = prArgs(cmd, client, args); err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			opts, err := batchOptionsFromFlags(cmd, "merge")
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			results := runBatch(cmd.Context(), args, func(prURL string) string { return prURL }, opts, func(ctx context.Context, prURL string) (string, error) {
+				return mergePR(ctx, client, prURL, method)
+			})
+			if printBatchSummary(results, opts.Verb) > 0 {
+				clierr.Exit(batchExitCode(results))
+			}
+		},
+	}
+
+	mergeCmd.Flags().StringVar(&method, "method", "squash", "Merge method: merge, squash or rebase")
+	addBatchFlags(mergeCmd)
+	addPickFlag(mergeCmd)
+	dryrun.Adopt(mergeCmd)
+	return mergeCmd
+}
+
+// mergePR resolves a single open PR and merges it pinned to its current head commit
+func mergePR(ctx context.Context, client *Client, prURL string, method string) (string, error) {
+	ref, err := ParsePRURL(prURL)
+	if err != nil {
+		return "", err
+	}
+
+	pr, err := client.GetPR(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	if pr.State != "open" {
+		return "", clierr.Newf(clierr.Conflict, "pull request is %s", pr.State)
+	}
+	if err := client.Write(ctx, "PUT", ref.APIPath()+"/merge", mergeRequest{MergeMethod: method, SHA: pr.Head.SHA}, nil); err != nil {
+		return "", err
+	}
+
+	if client.DryRun {
+		return fmt.Sprintf("Would merge %s with %s", ref, method), nil
+	}
+	return fmt.Sprintf(style.Celebrate()+"Merged %s", ref), nil
+}
@@ -0,0 +1,45 @@
+package gh
+
+import (
+	"fmt"
+
+	"gsn-dev-tools/internals/tui"
+
+	"github.com/spf13/cobra"
+)
+
+// addPickFlag registers --pick on commands taking pull request URLs
+func addPickFlag(cmd *cobra.Command) {
+	tui.AddFlag(cmd, "Pick the pull request from the ones waiting for your review in a searchable list")
+}
+
+// prArgs returns the pull request URLs given as arguments, or the one picked from the review queue with --pick
+func prArgs(cmd *cobra.Command, client *Client, args []string) ([]string, error) {
+	if !tui.Requested(cmd) {
+		rememberPRRepos(args)
+		return args, nil
+	}
+
+	prs, err := client.ReviewQueue(cmd.Context())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load your review queue: %w", err)
+	}
+	if len(prs) == 0 {
+		return nil, fmt.Errorf("no pull requests are waiting for your review")
+	}
+
+	items := make([]string, len(prs))
+	for i, pr := range prs {
+		name := pr.HTMLURL
+		if ref, err := ParsePRURL(pr.HTMLURL); err == nil {
+			name = ref.String()
+		}
+		items[i] = fmt.Sprintf("%s  %s  @%s", name, pr.Title, pr.User.Login)
+	}
+
+	i, err := tui.Pick("PR", items)
+	if err != nil {
+		return nil, err
+	}
+	return []string{prs[i].HTMLURL}, nil
+}
@@ -0,0 +1,137 @@
+package gh
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// PRDetails holds the pull request metadata returned by the REST API
+type PRDetails struct {
+	Number       int       `json:"number"`
+	Title        string    `json:"title"`
+	Body         string    `json:"body"`
+	State        string    `json:"state"`
+	Draft        bool      `json:"draft"`
+	Merged       bool      `json:"merged"`
+	HTMLURL      string    `json:"html_url"`
+	CreatedAt    time.Time `json:"created_at"`
+	Additions    int       `json:"additions"`
+	Deletions    int       `json:"deletions"`
+	ChangedFiles int       `json:"changed_files"`
+	User         struct {
+		Login string `json:"login"`
+	} `json:"user"`
+	Head struct {
+		SHA string `json:"sha"`
+		Ref string `json:"ref"`
+	} `json:"head"`
+	Base struct {
+		Ref string `json:"ref"`
+	} `json:"base"`
+	Labels []struct {
+		Name string `json:"name"`
+	} `json:"labels"`
+}
+
+// GetPR fetches the metadata of a single pull request
+func (c *Client) GetPR(ctx context.Context, ref PRRef) (*PRDetails, error) {
+	var pr PRDetails
+	if err := c.Get(ctx, ref.APIPath(), &pr); err != nil {
+		return nil, err
+	}
+	return &pr, nil
+}
+
+// ListOpenPRs fetches the open pull requests of a repository
+func (c *Client) ListOpenPRs(ctx context.Context, owner string, repo string) ([]PRDetails, error) {
+	var prs []PRDetails
+	path := fmt.Sprintf("/repos/%s/%s/pulls?state=open&per_page=100", owner, repo)
+	if err := c.Get(ctx, path, &prs); err != nil {
+		return nil, err
+	}
+	return prs, nil
+}
+
+// reviewQueueQuery finds the open pull requests waiting for the authenticated user's review
+const reviewQueueQuery = "is:pr is:open review-requested:@me archived:false"
+
+// ReviewQueue searches the open pull requests waiting for the authenticated user's review, oldest first.
+// Search results carry no head or diff stats, only the fields shared with issues.
+func (c *Client) ReviewQueue(ctx context.Context) ([]PRDetails, error) {
+	return c.SearchPRs(ctx, reviewQueueQuery, "&sort=created&order=asc")
+}
+
+// SearchPRs runs a GitHub search query for pull requests, order is appended to the query string as it is.
+// Search results carry no head or diff stats, only the fields shared with issues.
+func (c *Client) SearchPRs(ctx context.Context, query string, order string) ([]PRDetails, error) {
+	var result struct {
+		Items []PRDetails `json:"items"`
+	}
+	path := "/search/issues?q=" + url.QueryEscape(query) + order + "&per_page=100"
+	if err := c.Get(ctx, path, &result); err != nil {
+		return nil, err
+	}
+	return result.Items, nil
+}
+
+// reviewRequest is the payload of the create review endpoint, a review without Event is left pending
+type reviewRequest struct {
+	CommitID string          `json:"commit_id,omitempty"`
+	Event    string          `json:"event,omitempty"`
+	Body     string          `json:"body,omitempty"`
+	Comments []reviewComment `json:"comments,omitempty"`
+}
+
+// reviewComment is a line comment of a review. Position counts the lines of the file's patch below its first
+// hunk header, as GitHub places comments on the diff.
+type reviewComment struct {
+	Path     string `json:"path"`
+	Position int    `json:"position"`
+	Body     string `json:"body"`
+}
+
+// PRFile is a file changed by a pull request. Patch is its unified diff without the file header, empty for
+// binary files and diffs too large for GitHub to serve.
+type PRFile struct {
+	Filename         string `json:"filename"`
+	PreviousFilename string `json:"previous_filename"`
+	Status           string `json:"status"`
+	Patch            string `json:"patch"`
+}
+
+// ListPRFiles returns the files changed by a pull request in the order GitHub shows them
+func (c *Client) ListPRFiles(ctx context.Context, ref PRRef) ([]PRFile, error) {
+	var files []PRFile
+	err := c.GetPages(ctx, ref.APIPath()+"/files?per_page=100", func(body []byte) (bool, error) {
+		var page []PRFile
+		if err := json.Unmarshal(body, &page); err != nil {
+			return false, fmt.Errorf("failed to decode the files of %s: %w", ref, err)
+		}
+		files = append(files, page...)
+		return len(page) > 0, nil
+	})
+	return files, err
+}
+
+// Approve submits an approving review on a pull request and returns the ID of the review, 0 when the request
+// was only printed or queued. commitID pins the review to the head commit that was inspected so GitHub records
+// exactly what was reviewed.
+func (c *Client) Approve(ctx context.Context, ref PRRef, commitID string, message string) (int64, error) {
+	var review struct {
+		ID int64 `json:"id"`
+	}
+	err := c.Write(ctx, "POST", ref.APIPath()+"/reviews", reviewRequest{CommitID: commitID, Event: "APPROVE", Body: message}, &review)
+	return review.ID, err
+}
+
+// SubmitReview creates a review with its line comments in a single request and returns the ID of the review
+func (c *Client) SubmitReview(ctx context.Context, ref PRRef, review reviewRequest) (int64, error) {
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	err := c.Write(ctx, "POST", ref.APIPath()+"/reviews", review, &created)
+	return created.ID, err
+}
@@ -0,0 +1,221 @@
+package gh
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gsn-dev-tools/internals/lockfile"
+	"gsn-dev-tools/internals/output"
+	"gsn-dev-tools/internals/paths"
+	"gsn-dev-tools/internals/state"
+)
+
+// queueDirName is the directory below the gsn state dir holding queued operations
+const queueDirName = "gh-queue"
+
+// queueLockName keeps two flushes from replaying the same operations
+const queueLockName = ".lock"
+
+// ErrQueued is returned for writes that were queued instead of sent
+var ErrQueued = errors.New("queued for gsn gh queue flush")
+
+// Kinds of queued operations
+const (
+	// opApprove replays approve for a pull request, resolving and re-validating its head first
+	opApprove = "approve"
+	// opRequest replays a single write request as it was captured
+	opRequest = "request"
+)
+
+// queueKind versions the queued operations. Version 1 kept its version in a "version" field.
+var queueKind = state.Register(&state.Kind{
+	Name:     "queued operation",
+	Format:   state.JSON,
+	Version:  2,
+	Patterns: []string{"[0-9]*.json"},
+	Migrations: map[int]state.Migration{
+		1: func(values map[string]any) error {
+			delete(values, "version")
+			return nil
+		},
+	},
+	Validate: func(values map[string]any) error {
+		if kind, _ := values["kind"].(string); kind != opApprove && kind != opRequest {
+			return fmt.Errorf("unknown queued operation kind '%v'", values["kind"])
+		}
+		return nil
+	},
+	Files: func() ([]string, error) {
+		queue, err := OpenQueue()
+		if err != nil {
+			return nil, err
+		}
+		return queue.files()
+	},
+})
+
+// QueuedOp is a write operation saved while GitHub could not be reached
+type QueuedOp struct {
+	SchemaVersion int       `json:"schema_version"`
+	ID            string    `json:"id"`
+	Kind          string    `json:"kind"`
+	CreatedAt     time.Time `json:"created_at"`
+
+	// PR, HeadSHA and Message describe an approve operation, HeadSHA only when --head-sha pinned one
+	PR      string `json:"pr,omitempty"`
+	HeadSHA string `json:"head_sha,omitempty"`
+	Message string `json:"message,omitempty"`
+
+	// Method, Path and Body describe a request operation
+	Method string          `json:"method,omitempty"`
+	Path   string          `json:"path,omitempty"`
+	Body   json.RawMessage `json:"body,omitempty"`
+
+	Attempts  int    `json:"attempts,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// Target describes what the operation acts on
+func (op QueuedOp) Target() string {
+	if op.Kind == opApprove {
+		return op.PR
+	}
+	return op.Method + " " + op.Path
+}
+
+// Queue stores operations as one JSON file each, named so that listing them sorted keeps queue order
+type Queue struct {
+	Dir string
+
+	mu   sync.Mutex
+	last int64
+}
+
+// OpenQueue opens the queue in the gsn state dir. The directory is created when the first operation is added.
+func OpenQueue() (*Queue, error) {
+	dir, err := paths.StateDir()
+	if err != nil {
+		return nil, err
+	}
+	return &Queue{Dir: filepath.Join(dir, queueDirName)}, nil
+}
+
+// Add saves an operation at the end of the queue and returns it with its ID set
+func (q *Queue) Add(op QueuedOp) (QueuedOp, error) {
+	q.mu.Lock()
+	// Batch workers may queue within the same nanosecond, IDs must still be unique and ordered
+	id := max(time.Now().UnixNano(), q.last+1)
+	q.last = id
+	q.mu.Unlock()
+
+	op.SchemaVersion = queueKind.Version
+	op.ID = fmt.Sprintf("%020d", id)
+	op.CreatedAt = time.Now()
+	return op, q.Update(op)
+}
+
+// Update rewrites a queued operation, e.g. after a failed replay
+func (q *Queue) Update(op QueuedOp) error {
+	data, err := json.MarshalIndent(op, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(q.Dir, 0o700); err != nil {
+		return err
+	}
+	return output.WriteFileAtomic(q.path(op.ID), append(data, '\n'), 0o600)
+}
+
+// Remove deletes a replayed operation
+func (q *Queue) Remove(op QueuedOp) error {
+	return os.Remove(q.path(op.ID))
+}
+
+// List returns the queued operations in queue order. Files that cannot be read, or that were written by a newer
+// gsn, are returned as errors so they are reported instead of replayed.
+func (q *Queue) List() ([]QueuedOp, []error) {
+	paths, err := q.files()
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	var ops []QueuedOp
+	var errs []error
+	for _, path := range paths {
+		op, err := readQueuedOp(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		ops = append(ops, op)
+	}
+	return ops, errs
+}
+
+// files returns the paths of the queued operations in queue order
+func (q *Queue) files() ([]string, error) {
+	entries, err := os.ReadDir(q.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			paths = append(paths, filepath.Join(q.Dir, e.Name()))
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// Lock takes the queue lock, waiting a while for another flush holding it
+func (q *Queue) Lock() (func(), error) {
+	return lockfile.Acquire(filepath.Join(q.Dir, queueLockName), q.Dir, lockfile.DefaultWait)
+}
+
+func (q *Queue) path(id string) string {
+	return filepath.Join(q.Dir, id+".json")
+}
+
+// readQueuedOp reads one queued operation, migrating it from older versions and refusing it when a newer gsn
+// wrote it
+func readQueuedOp(path string) (QueuedOp, error) {
+	var op QueuedOp
+	doc, err := queueKind.Load(path, &op)
+	if err != nil {
+		return QueuedOp{}, err
+	}
+	if err := doc.Writable(); err != nil {
+		return QueuedOp{}, err
+	}
+	return op, nil
+}
+
+// queueRequest saves a write request that could not be sent
+func (c *Client) queueRequest(method string, path string, in any) error {
+	var body json.RawMessage
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to encode request body: %w", err)
+		}
+		body = data
+	}
+
+	op, err := c.Queue.Add(QueuedOp{Kind: opRequest, Method: method, Path: path, Body: body})
+	if err != nil {
+		return fmt.Errorf("failed to queue %s %s: %w", method, path, err)
+	}
+	return fmt.Errorf("%w as %s", ErrQueued, op.ID)
+}
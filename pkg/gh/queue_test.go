@@ -0,0 +1,228 @@
+package gh
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"gsn-dev-tools/internals/state"
+)
+
+func TestQueueKeepsOrderAcrossConcurrentAdds(t *testing.T) {
+	queue := &Queue{Dir: filepath.Join(t.TempDir(), queueDirName)}
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := queue.Add(QueuedOp{Kind: opRequest, Method: "POST", Path: fmt.Sprintf("/repos/owner/repo/issues/%d/labels", i)}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	ops, errs := queue.List()
+	if len(errs) > 0 || len(ops) != 20 {
+		t.Fatalf("List = %d op(s), %v", len(ops), errs)
+	}
+	for i, op := range ops {
+		if op.SchemaVersion != queueKind.Version || op.CreatedAt.IsZero() {
+			t.Errorf("op %s = %+v", op.ID, op)
+		}
+		if i > 0 && op.ID <= ops[i-1].ID {
+			t.Errorf("op %d has ID %s after %s", i, op.ID, ops[i-1].ID)
+		}
+	}
+
+	if err := queue.Remove(ops[3]); err != nil {
+		t.Fatal(err)
+	}
+	if err := queue.Remove(ops[3]); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("second remove = %v", err)
+	}
+	if rest, _ := queue.List(); len(rest) != 19 || slices.ContainsFunc(rest, func(op QueuedOp) bool { return op.ID == ops[3].ID }) {
+		t.Errorf("after the remove %d op(s) are left", len(rest))
+	}
+}
+
+func TestQueueFileVersions(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    QueuedOp
+		wantErr string
+	}{
+		{"version 1", `{"version":1,"id":"00000000000000000001","kind":"approve","pr":"owner/repo#1","message":"LGTM"}`,
+			QueuedOp{SchemaVersion: 2, ID: "00000000000000000001", Kind: opApprove, PR: "owner/repo#1", Message: "LGTM"}, ""},
+		{"version 2", `{"schema_version":2,"id":"00000000000000000002","kind":"request","method":"POST","path":"/x","body":{"labels":["deps"]}}`,
+			QueuedOp{SchemaVersion: 2, ID: "00000000000000000002", Kind: opRequest, Method: "POST", Path: "/x", Body: json.RawMessage(`{"labels":["deps"]}`)}, ""},
+		{"newer", `{"schema_version":3,"id":"00000000000000000003","kind":"approve","pr":"owner/repo#1"}`,
+			QueuedOp{}, "written by a newer gsn (queued operation schema version 3, this gsn supports up to 2)"},
+		{"unknown kind", `{"schema_version":2,"id":"00000000000000000004","kind":"merge"}`, QueuedOp{}, "unknown queued operation kind 'merge'"},
+		{"truncated", `{"schema_version":2,"id":"0000`, QueuedOp{}, "00000000000000000005.json"},
+	}
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, fmt.Sprintf("%020d.json", i+1))
+			if err := os.WriteFile(path, []byte(test.data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			ops, errs := (&Queue{Dir: dir}).List()
+			if test.wantErr != "" {
+				if len(ops) != 0 || len(errs) != 1 || !strings.Contains(errs[0].Error(), test.wantErr) {
+					t.Errorf("List = %+v, %v, want the file refused with %q", ops, errs, test.wantErr)
+				}
+				return
+			}
+			if len(errs) != 0 || len(ops) != 1 {
+				t.Fatalf("List = %+v, %v", ops, errs)
+			}
+			got, want := ops[0], test.want
+			// The body is decoded through the schema migration, which reformats it
+			var body bytes.Buffer
+			if len(got.Body) > 0 {
+				if err := json.Compact(&body, got.Body); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got.Body = body.Bytes()
+			if got.SchemaVersion != want.SchemaVersion || got.ID != want.ID || got.Kind != want.Kind || got.PR != want.PR ||
+				got.Message != want.Message || got.Method != want.Method || got.Path != want.Path || string(got.Body) != string(want.Body) {
+				t.Errorf("op = %+v, want %+v", got, want)
+			}
+		})
+	}
+
+	// A newer file is refused with the Conflict exit code of state files
+	var newer *state.NewerError
+	_, errs := (&Queue{Dir: writeQueueFile(t, `{"schema_version":9,"kind":"approve"}`)}).List()
+	if len(errs) != 1 || !errors.As(errs[0], &newer) {
+		t.Errorf("errors = %v, want a NewerError", errs)
+	}
+}
+
+// writeQueueFile writes one queued operation to a new queue dir and returns the dir
+func writeQueueFile(t *testing.T, data string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "00000000000000000001.json"), []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// reviewingGitHub is pullRequests keeping the reviews and comments submitted, so replays see earlier ones
+type reviewingGitHub struct {
+	mu       sync.Mutex
+	reviews  []map[string]any
+	comments []map[string]any
+}
+
+func (g *reviewingGitHub) handle(w http.ResponseWriter, r *http.Request, body []byte) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var in map[string]any
+	_ = json.Unmarshal(body, &in)
+	user := map[string]any{"login": "me"}
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/reviews") && r.Method == http.MethodGet:
+		_ = json.NewEncoder(w).Encode(g.reviews)
+	case strings.HasSuffix(r.URL.Path, "/reviews"):
+		g.reviews = append(g.reviews, map[string]any{"state": "APPROVED", "commit_id": in["commit_id"], "user": user})
+		_, _ = io.WriteString(w, `{"id":7}`)
+	case strings.HasSuffix(r.URL.Path, "/comments") && r.Method == http.MethodGet:
+		_ = json.NewEncoder(w).Encode(g.comments)
+	case strings.HasSuffix(r.URL.Path, "/comments"):
+		g.comments = append(g.comments, map[string]any{"body": in["body"], "user": user})
+		_, _ = io.WriteString(w, `{}`)
+	default:
+		pullRequests(w, r, body)
+	}
+}
+
+func TestReplayNeverAppliesTwice(t *testing.T) {
+	github := &reviewingGitHub{}
+	server := newFakeGitHub(t, github.handle)
+	client := server.client(io.Discard)
+	ctx := context.Background()
+
+	approve := QueuedOp{Kind: opApprove, PR: "https://github.com/owner/repo/pull/1", Message: "LGTM"}
+	review := QueuedOp{Kind: opRequest, Method: http.MethodPost, Path: "/repos/owner/repo/pulls/2/reviews",
+		Body: json.RawMessage(`{"commit_id":"` + fmt.Sprintf("%040d", 2) + `","event":"APPROVE"}`)}
+	comment := QueuedOp{Kind: opRequest, Method: http.MethodPost, Path: "/repos/owner/repo/issues/1/comments", Body: json.RawMessage(`{"body":"Rebased"}`)}
+	labels := QueuedOp{Kind: opRequest, Method: http.MethodPost, Path: "/repos/owner/repo/issues/1/labels", Body: json.RawMessage(`{"labels":["deps"]}`)}
+
+	// A flush whose responses were lost runs again: reviews and comments are skipped, labels are idempotent
+	for round, wantSkipped := range []bool{false, true} {
+		for _, op := range []QueuedOp{approve, review, comment} {
+			message, err := replayQueuedOp(ctx, client, "me", op)
+			if err != nil {
+				t.Fatalf("round %d, %s: %v", round, op.Target(), err)
+			}
+			if skipped := strings.HasPrefix(message, "Skipped"); skipped != wantSkipped {
+				t.Errorf("round %d, %s: %q", round, op.Target(), message)
+			}
+		}
+		if _, err := replayQueuedOp(ctx, client, "me", labels); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(github.reviews) != 2 || len(github.comments) != 1 {
+		t.Errorf("%d review(s) and %d comment(s), want each applied once", len(github.reviews), len(github.comments))
+	}
+	if labelWrites := slices.DeleteFunc(slices.Clone(server.writes), func(w string) bool { return !strings.HasSuffix(w, "/labels") }); len(labelWrites) != 2 {
+		t.Errorf("label writes = %v, want both replayed", labelWrites)
+	}
+
+	// Someone else's approval does not count, an approval of an older commit neither
+	github.reviews = []map[string]any{
+		{"state": "APPROVED", "commit_id": fmt.Sprintf("%040d", 1), "user": map[string]any{"login": "other"}},
+		{"state": "APPROVED", "commit_id": "0ld", "user": map[string]any{"login": "me"}},
+	}
+	if message, err := replayQueuedOp(ctx, client, "me", approve); err != nil || strings.HasPrefix(message, "Skipped") {
+		t.Errorf("replay with unrelated approvals = %q, %v, want it approved", message, err)
+	}
+
+	// A pinned head that moved is a conflict and nothing is sent
+	before := len(server.writes)
+	pinned := approve
+	pinned.HeadSHA = "abc1234"
+	if _, err := replayQueuedOp(ctx, client, "me", pinned); err == nil || !strings.Contains(err.Error(), "head moved") {
+		t.Errorf("replay with a moved head = %v", err)
+	}
+	if len(server.writes) != before {
+		t.Errorf("the moved head was approved: %v", server.writes[before:])
+	}
+}
+
+func TestStopsFlush(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{fmt.Errorf("dial: %w", ErrNetwork), true},
+		{&APIError{StatusCode: http.StatusUnauthorized}, true},
+		{fmt.Errorf("approve: %w", &APIError{StatusCode: http.StatusForbidden}), true},
+		{&APIError{StatusCode: http.StatusUnprocessableEntity}, false},
+		{errors.New("head moved"), false},
+	}
+	for _, test := range tests {
+		if got := stopsFlush(test.err); got != test.want {
+			t.Errorf("stopsFlush(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package gh
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/output"
+	"gsn-dev-tools/internals/style"
+
+	"github.com/spf13/cobra"
+)
+
+var queueColumns = []output.Column[QueuedOp]{
+	{Name: "id", Value: func(op QueuedOp) any { return op.ID }},
+	{Name: "kind", Value: func(op QueuedOp) any { return op.Kind }},
+	{Name: "target", Value: func(op QueuedOp) any { return op.Target() }},
+	{
+		Name:    "age",
+		Value:   func(op QueuedOp) any { return time.Since(op.CreatedAt) },
+		Display: func(op QueuedOp) string { return formatAge(time.Since(op.CreatedAt)) },
+	},
+	{Name: "attempts", Value: func(op QueuedOp) any { return op.Attempts }},
+	{Name: "last_error", Value: func(op QueuedOp) any { return op.LastError }},
+}
+
+func queueCmd() *cobra.Command {
+	queueCmd := &cobra.Command{
+		Use:   "queue",
+		Short: "List and replay write operations queued while GitHub was unreachable",
+		Long: `approve, approve-bots and pr label queue their writes in ~/.local/state/gsn/gh-queue when GitHub cannot be
+reached or --offline is set. flush replays them in order once the network is back.`,
+		Example: `  gsn gh queue list
+  gsn gh queue flush`,
+	}
+
+	queueCmd.AddCommand(listQueueCmd())
+	queueCmd.AddCommand(flushQueueCmd())
+	queueCmd.AddCommand(dropQueueCmd())
+	return queueCmd
+}
+
+func listQueueCmd() *cobra.Command {
+	listCmd := &cobra.Command{
+		Use:     "list",
+		Short:   "Show the pending operations",
+		Long:    "Lists the queued operations in the order flush replays them, with the error of their last replay.",
+		Example: "  gsn gh queue list --columns id,target,last_error",
+		Args:    cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			opts, err := output.OptionsFromFlags(cmd)
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			queue, err := OpenQueue()
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			ops, errs := queue.List()
+			for _, err := range errs {
+				fmt.Fprintf(os.Stderr, style.Warning()+"%v\n", err)
+			}
+			if err := output.Render(os.Stdout, queueColumns, ops, opts); err != nil {
+				clierr.Fatalf("%v", err)
+			}
+		},
+	}
+
+	output.AddFlags(listCmd)
+	return listCmd
+}
+
+func flushQueueCmd() *cobra.Command {
+	flushCmd := &cobra.Command{
+		Use:   "flush",
+		Short: "Replay the pending operations in order",
+		Long: `Replays every queued operation in order and removes it once it succeeded. Failed operations stay queued with
+their error. An authentication error or a lost connection stops the flush. Approvals re-check the PR head when
+--head-sha pinned one, and operations GitHub already has, like an approval of the same commit or an identical
+comment, are skipped so a flush never applies them twice.`,
+		Example: "  gsn gh queue flush",
+		Args:    cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			queue, err := OpenQueue()
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			unlock, err := queue.Lock()
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			defer unlock()
+
+			ops, errs := queue.List()
+			for _, err := range errs {
+				fmt.Fprintf(os.Stderr, style.Warning()+"Skipping %v\n", err)
+			}
+			if len(ops) == 0 {
+				fmt.Println("The queue is empty.")
+				return
+			}
+
+			// Without a Queue on the client failed replays are reported instead of queued again
+			client, err := NewClient("repo")
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			me, _, err := currentUser(cmd, client)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, style.Error()+"Cannot reach GitHub: %v\n", err)
+				unlock()
+				clierr.Exit(clierr.CodeOf(err))
+			}
+
+			done, failed := 0, 0
+			for i, op := range ops {
+				message, err := replayQueuedOp(cmd.Context(), client, me, op)
+				if err == nil {
+					if err := queue.Remove(op); err != nil {
+						fmt.Fprintf(os.Stderr, style.Warning()+"Replayed %s but could not remove it: %v\n", op.ID, err)
+					}
+					fmt.Println(message)
+					done++
+					continue
+				}
+
+				failed++
+				op.Attempts++
+				op.LastError = err.Error()
+				if uerr := queue.Update(op); uerr != nil {
+					fmt.Fprintf(os.Stderr, style.Warning()+"Could not record the failure of %s: %v\n", op.ID, uerr)
+				}
+				fmt.Fprintf(os.Stderr, style.Error()+"Failed to replay %s (%s): %v\n", op.ID, op.Target(), err)
+
+				if stopsFlush(err) {
+					fmt.Fprintf(os.Stderr, style.Warning()+"Stopped, %d operation(s) left untouched\n", len(ops)-i-1)
+					break
+				}
+			}
+
+			fmt.Printf("\n%d replayed, %d failed.\n", done, failed)
+			if failed > 0 {
+				unlock()
+				clierr.Exit(clierr.Failure)
+			}
+		},
+	}
+	return flushCmd
+}
+
+func dropQueueCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:     "drop <ID>...",
+		Short:   "Remove operations from the queue without replaying them",
+		Long:    "Removes queued operations, e.g. an approval whose pinned head moved, by the IDs shown by queue list.",
+		Example: "  gsn gh queue drop 01760000000000000000",
+		Args:    cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			queue, err := OpenQueue()
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			failed := 0
+			for _, id := range args {
+				err := queue.Remove(QueuedOp{ID: id})
+				if errors.Is(err, os.ErrNotExist) {
+					err = fmt.Errorf("no queued operation has this ID")
+				}
+				if err != nil {
+					fmt.Fprintf(os.Stderr, style.Error()+"Failed to drop %s: %v\n", id, err)
+					failed++
+					continue
+				}
+				fmt.Printf(style.Trash()+"Dropped %s\n", id)
+			}
+			if failed > 0 {
+				clierr.Exit(clierr.Failure)
+			}
+		},
+	}
+}
+
+// replayQueuedOp sends one queued operation unless GitHub already has its effect
+func replayQueuedOp(ctx context.Context, client *Client, me string, op QueuedOp) (string, error) {
+	switch op.Kind {
+	case opApprove:
+		ref, err := ParsePRURL(op.PR)
+		if err != nil {
+			return "", err
+		}
+		pr, err := client.GetPR(ctx, ref)
+		if err != nil {
+			return "", err
+		}
+		if op.HeadSHA != "" && !matchesSHA(pr.Head.SHA, op.HeadSHA) {
+			return "", clierr.Newf(clierr.Conflict, "head moved: expected %s but the PR head is %s", op.HeadSHA, pr.Head.SHA)
+		}
+		approved, err := hasApproval(ctx, client, ref.APIPath()+"/reviews", me, pr.Head.SHA)
+		if err != nil {
+			return "", err
+		}
+		if approved {
+			return fmt.Sprintf("Skipped %s, already approved at %s", ref, pr.Head.SHA[:min(len(pr.Head.SHA), 7)]), nil
+		}
+		return approvePR(ctx, client, op.PR, op.HeadSHA, op.Message)
+
+	default:
+		applied, err := requestApplied(ctx, client, me, op)
+		if err != nil {
+			return "", err
+		}
+		if applied {
+			return fmt.Sprintf("Skipped %s %s, GitHub already has it", op.Method, op.Path), nil
+		}
+
+		var in any
+		if len(op.Body) > 0 {
+			in = op.Body
+		}
+		if err := client.Write(ctx, op.Method, op.Path, in, nil); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf(style.Success()+"Replayed %s %s", op.Method, op.Path), nil
+	}
+}
+
+// requestApplied reports whether a captured review or comment was already created, e.g. by a replay whose
+// response was lost. Other writes, like adding labels, are idempotent and always replayed.
+func requestApplied(ctx context.Context, client *Client, me string, op QueuedOp) (bool, error) {
+	if op.Method != http.MethodPost {
+		return false, nil
+	}
+
+	var body struct {
+		CommitID string `json:"commit_id"`
+		Event    string `json:"event"`
+		Body     string `json:"body"`
+	}
+	if len(op.Body) > 0 {
+		if err := json.Unmarshal(op.Body, &body); err != nil {
+			return false, err
+		}
+	}
+
+	switch {
+	case strings.HasSuffix(op.Path, "/reviews") && body.Event == "APPROVE":
+		return hasApproval(ctx, client, op.Path, me, body.CommitID)
+	case strings.HasSuffix(op.Path, "/comments"):
+		var comments []struct {
+			Body string `json:"body"`
+			User struct {
+				Login string `json:"login"`
+			} `json:"user"`
+		}
+		if err := client.Get(ctx, op.Path+"?per_page=100", &comments); err != nil {
+			return false, err
+		}
+		for _, c := range comments {
+			if c.User.Login == me && c.Body == body.Body {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// hasApproval reports whether login approved the pull request at commit, or at any commit when commit is empty
+func hasApproval(ctx context.Context, client *Client, reviewsPath string, login string, commit string) (bool, error) {
+	var reviews []struct {
+		State    string `json:"state"`
+		CommitID string `json:"commit_id"`
+		User     struct {
+			Login string `json:"login"`
+		} `json:"user"`
+	}
+	if err := client.Get(ctx, reviewsPath+"?per_page=100", &reviews); err != nil {
+		return false, err
+	}
+	for _, r := range reviews {
+		if r.User.Login == login && r.State == "APPROVED" && (commit == "" || r.CommitID == commit) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// stopsFlush reports whether replaying the remaining operations is pointless after err
+func stopsFlush(err error) bool {
+	if errors.Is(err, ErrNetwork) {
+		return true
+	}
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden)
+}
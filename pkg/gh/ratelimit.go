@@ -0,0 +1,78 @@
+package gh
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"gsn-dev-tools/internals/style"
+)
+
+// rateLimitFloor is the remaining request quota below which requests wait for the rate limit window to reset
+const rateLimitFloor = 50
+
+// rateBudget tracks the rate limit reported by GitHub and holds back every request of a client, including
+// those of concurrent batch workers, while the remaining quota is below the floor
+type rateBudget struct {
+	mu        sync.Mutex
+	floor     int
+	known     bool
+	remaining int
+	reset     time.Time
+	announced time.Time
+}
+
+func newRateBudget(floor int) *rateBudget {
+	return &rateBudget{floor: floor}
+}
+
+// observe records the X-RateLimit headers of a response
+func (r *rateBudget) observe(header http.Header) {
+	remaining, err := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	resetUnix, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return
+	}
+	reset := time.Unix(resetUnix, 0)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// Responses of concurrent requests arrive out of order, so within a window only the lowest count is kept
+	switch {
+	case !r.known || reset.After(r.reset):
+		r.known, r.remaining, r.reset = true, remaining, reset
+	case reset.Equal(r.reset) && remaining < r.remaining:
+		r.remaining = remaining
+	}
+}
+
+// wait blocks until the quota is above the floor or the window resets
+func (r *rateBudget) wait(ctx context.Context) error {
+	r.mu.Lock()
+	if !r.known || r.remaining >= r.floor || !time.Now().Before(r.reset) {
+		r.mu.Unlock()
+		return nil
+	}
+	until := r.reset.Add(time.Second)
+	if !r.announced.Equal(until) {
+		r.announced = until
+		fmt.Fprintf(os.Stderr, style.Warning()+"GitHub rate limit nearly used up (%d left), pausing until %s\n", r.remaining, until.Format(time.TimeOnly))
+	}
+	r.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(until))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,67 @@
+package gh
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"gsn-dev-tools/internals/execx"
+)
+
+// resolveRepo returns the --repo value, or the GitHub repository of the origin remote when it is empty
+func resolveRepo(ctx context.Context, repo string) (string, string, error) {
+	if repo != "" {
+		owner, name, err := ParseRepo(repo)
+		if err == nil {
+			rememberRepos(owner + "/" + name)
+		}
+		return owner, name, err
+	}
+
+	remote, err := runGit(ctx, "remote", "get-url", "origin")
+	if err != nil {
+		return "", "", fmt.Errorf("no --repo given and the origin remote could not be read: %w", err)
+	}
+	owner, name, err := parseRemoteURL(remote)
+	if err != nil {
+		return "", "", fmt.Errorf("no --repo given: %w", err)
+	}
+	return owner, name, nil
+}
+
+// parseRemoteURL extracts owner/repo from the https, ssh and scp-like forms of a git remote
+func parseRemoteURL(remote string) (string, string, error) {
+	path := remote
+	if strings.Contains(remote, "://") {
+		u, err := url.Parse(remote)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid remote '%s': %w", remote, err)
+		}
+		path = u.Path
+	} else if _, rest, ok := strings.Cut(remote, ":"); ok {
+		// git@github.com:owner/repo.git
+		path = rest
+	}
+
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	owner, name, err := ParseRepo(path)
+	if err != nil {
+		return "", "", fmt.Errorf("remote '%s' does not point to a GitHub repository", remote)
+	}
+	return owner, name, nil
+}
+
+// repoRoot returns the top level directory of the git repository in the working directory
+func repoRoot(ctx context.Context) (string, error) {
+	return runGit(ctx, "rev-parse", "--show-toplevel")
+}
+
+// runGit runs git in the working directory and returns its trimmed output
+func runGit(ctx context.Context, args ...string) (string, error) {
+	out, _, _, err := Runner.Run(ctx, "git", args, execx.Options{})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
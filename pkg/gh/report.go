@@ -0,0 +1,528 @@
+package gh
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/style"
+	"gsn-dev-tools/internals/units"
+
+	"github.com/spf13/cobra"
+)
+
+// searchResultLimit is the number of results GitHub search returns for a query at most
+const searchResultLimit = 1000
+
+// reportPeriod is the time range a report covers, Start included and End excluded
+type reportPeriod struct {
+	Start time.Time
+	End   time.Time
+}
+
+// reportItem is a pull request or issue of a report
+type reportItem struct {
+	Repo      string
+	Number    int
+	Title     string
+	URL       string
+	Additions int
+	Deletions int
+}
+
+// reportCommit is a commit of a report
+type reportCommit struct {
+	Repo    string
+	SHA     string
+	Message string
+	URL     string
+}
+
+// activityReport is what the authenticated user did on GitHub in a period
+type activityReport struct {
+	Login    string
+	Org      string
+	Period   reportPeriod
+	Merged   []reportItem
+	Reviewed []reportItem
+	Closed   []reportItem
+	Commits  []reportCommit
+}
+
+// activityCounts are the totals of a report, the rows of its summary table
+type activityCounts struct {
+	Merged, Reviewed, Closed, Commits, Additions, Deletions int
+}
+
+func reportCmd() *cobra.Command {
+	var since, org, compare string
+
+	reportCmd := &cobra.Command{
+		Use:   "report",
+		Short: "Summarize your GitHub activity as markdown",
+		Long: `Gathers the pull requests you merged, the pull requests you reviewed, the issues you closed and the commits
+you authored since --since, across every repository or those of --org, and prints a markdown summary grouped by
+repository with links, counts and the additions and deletions of the merged pull requests.
+
+Merged pull requests and commits come from GitHub search, reviews and closed issues from your events, which GitHub
+keeps for 90 days and 300 events at most; gsn warns when a period reaches past them. --compare previous adds the
+change of every count against the period of the same length just before.`,
+		Example: `  gsn gh report
+  gsn gh report --since 14d --org acme
+  gsn gh report --compare previous > sprint.md`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			length, err := parsePeriod("--since", since)
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			if compare != "" && compare != "previous" {
+				clierr.Exitf(clierr.Usage, "invalid --compare '%s', the only period to compare with is previous", compare)
+			}
+
+			client, err := NewClient("repo")
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			var user struct {
+				Login string `json:"login"`
+			}
+			if err := client.Get(cmd.Context(), "/user", &user); err != nil {
+				clierr.Fatalf("failed to look up the authenticated user: %v", err)
+			}
+
+			end := time.Now().UTC().Truncate(time.Second)
+			current, err := gatherReport(cmd.Context(), client, user.Login, org, reportPeriod{Start: end.Add(-length), End: end})
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			var previous *activityReport
+			if compare != "" {
+				previous, err = gatherReport(cmd.Context(), client, user.Login, org, reportPeriod{Start: end.Add(-2 * length), End: end.Add(-length)})
+				if err != nil {
+					clierr.Fatalf("%v", err)
+				}
+			}
+
+			if err := renderReport(os.Stdout, current, previous); err != nil {
+				clierr.Fatalf("%v", err)
+			}
+		},
+	}
+
+	reportCmd.Flags().StringVar(&since, "since", "7d", "Length of the period reported, e.g. 7d, 2w or 36h")
+	reportCmd.Flags().StringVar(&org, "org", "", "Only report activity in the repositories of this organization")
+	reportCmd.Flags().StringVar(&compare, "compare", "", "Show the change of every count against the previous period")
+	dryrun.ReadOnly(reportCmd)
+	return reportCmd
+}
+
+// parsePeriod parses a length such as 7d or 2w given as name, plain Go durations like 36h are accepted too.
+// Fractions take either decimal mark, e.g. 1.5d or 1,5d.
+func parsePeriod(name string, value string) (time.Duration, error) {
+	s, err := units.NormalizeDecimal(strings.TrimSpace(value))
+	if err != nil {
+		return 0, clierr.Newf(clierr.Usage, "invalid %s: %v", name, err)
+	}
+	suffixes := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	if len(s) > 1 {
+		if unit, ok := suffixes[s[len(s)-1:]]; ok {
+			if n, err := strconv.ParseFloat(s[:len(s)-1], 64); err == nil && n > 0 {
+				return time.Duration(n * float64(unit)), nil
+			}
+		}
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, clierr.Newf(clierr.Usage, "invalid %s '%s', use e.g. 7d, 2w or 36h", name, value)
+	}
+	return d, nil
+}
+
+// reportSource fetches one kind of activity into a report
+type reportSource struct {
+	Name  string
+	Fetch func(context.Context) error
+}
+
+// gatherReport fetches the activity of login in a period. The sources are fetched at the same time and the
+// merged pull requests are then looked up for their diff stats, all through the one client so its rate limit
+// budget holds every request back.
+func gatherReport(ctx context.Context, client *Client, login string, org string, period reportPeriod) (*activityReport, error) {
+	report := &activityReport{Login: login, Org: org, Period: period}
+	sources := []reportSource{
+		{"merged pull requests", func(ctx context.Context) (err error) {
+			report.Merged, err = searchMergedPRs(ctx, client, login, org, period)
+			return err
+		}},
+		{"commits", func(ctx context.Context) (err error) {
+			report.Commits, err = searchCommits(ctx, client, login, org, period)
+			return err
+		}},
+		{"events", func(ctx context.Context) (err error) {
+			report.Reviewed, report.Closed, err = eventActivity(ctx, client, login, org, period)
+			return err
+		}},
+	}
+	results := runBatch(ctx, sources, func(s reportSource) string { return s.Name }, batchOptions{Concurrency: len(sources), Quiet: true}, func(ctx context.Context, s reportSource) (string, error) {
+		return "", s.Fetch(ctx)
+	})
+	for _, r := range results {
+		if r.Err != nil {
+			return nil, fmt.Errorf("failed to fetch the %s: %w", r.Name, r.Err)
+		}
+	}
+
+	merged := make([]*reportItem, len(report.Merged))
+	for i := range report.Merged {
+		merged[i] = &report.Merged[i]
+	}
+	results = runBatch(ctx, merged, func(pr *reportItem) string { return pr.URL }, batchOptions{Concurrency: defaultConcurrency, Quiet: true}, func(ctx context.Context, pr *reportItem) (string, error) {
+		owner, name, _ := strings.Cut(pr.Repo, "/")
+		details, err := client.GetPR(ctx, PRRef{Owner: owner, Repo: name, Number: pr.Number})
+		if err != nil {
+			return "", err
+		}
+		pr.Additions, pr.Deletions = details.Additions, details.Deletions
+		return "", nil
+	})
+	for _, r := range results {
+		if r.Err != nil {
+			return nil, fmt.Errorf("failed to fetch the diff stats of %s: %w", r.Name, r.Err)
+		}
+	}
+	return report, nil
+}
+
+// searchRange is the search qualifier value matching a period
+func searchRange(period reportPeriod) string {
+	// The range is inclusive on both ends, the last second of the period is the one before End
+	return period.Start.UTC().Format(time.RFC3339) + ".." + period.End.Add(-time.Second).UTC().Format(time.RFC3339)
+}
+
+// searchAll runs a search query and returns its items, reading every page GitHub serves
+func searchAll[T any](ctx context.Context, client *Client, endpoint string, query string) ([]T, error) {
+	var items []T
+	total := 0
+	path := endpoint + "?q=" + url.QueryEscape(query) + "&per_page=100"
+	err := client.GetPages(ctx, path, func(body []byte) (bool, error) {
+		var page struct {
+			TotalCount int `json:"total_count"`
+			Items      []T `json:"items"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return false, fmt.Errorf("failed to decode the search results: %w", err)
+		}
+		total = page.TotalCount
+		items = append(items, page.Items...)
+		return len(page.Items) > 0, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if total > searchResultLimit {
+		fmt.Fprintf(os.Stderr, style.Warning()+"GitHub search matched %d results for %q but only returns %d, the report is incomplete\n", total, query, searchResultLimit)
+	}
+	return items, nil
+}
+
+// repoFromAPIURL returns owner/repo of a repository API URL like https://api.github.com/repos/owner/repo
+func repoFromAPIURL(apiURL string) string {
+	parts := strings.Split(strings.TrimSuffix(apiURL, "/"), "/")
+	if len(parts) < 2 {
+		return apiURL
+	}
+	return parts[len(parts)-2] + "/" + parts[len(parts)-1]
+}
+
+// searchMergedPRs finds the pull requests login authored that were merged in the period
+func searchMergedPRs(ctx context.Context, client *Client, login string, org string, period reportPeriod) ([]reportItem, error) {
+	query := fmt.Sprintf("is:pr is:merged author:%s merged:%s", login, searchRange(period))
+	if org != "" {
+		query += " org:" + org
+	}
+	found, err := searchAll[struct {
+		Number        int    `json:"number"`
+		Title         string `json:"title"`
+		HTMLURL       string `json:"html_url"`
+		RepositoryURL string `json:"repository_url"`
+	}](ctx, client, "/search/issues", query)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]reportItem, len(found))
+	for i, f := range found {
+		items[i] = reportItem{Repo: repoFromAPIURL(f.RepositoryURL), Number: f.Number, Title: f.Title, URL: f.HTMLURL}
+	}
+	return items, nil
+}
+
+// searchCommits finds the commits login authored that were committed in the period
+func searchCommits(ctx context.Context, client *Client, login string, org string, period reportPeriod) ([]reportCommit, error) {
+	query := fmt.Sprintf("author:%s committer-date:%s", login, searchRange(period))
+	if org != "" {
+		query += " org:" + org
+	}
+	found, err := searchAll[struct {
+		SHA     string `json:"sha"`
+		HTMLURL string `json:"html_url"`
+		Commit  struct {
+			Message string `json:"message"`
+		} `json:"commit"`
+		Repository struct {
+			FullName string `json:"full_name"`
+		} `json:"repository"`
+	}](ctx, client, "/search/commits", query)
+	if err != nil {
+		return nil, err
+	}
+
+	// A commit pushed to several branches or forks is found once per repository
+	commits := make([]reportCommit, 0, len(found))
+	seen := map[string]bool{}
+	for _, f := range found {
+		key := f.Repository.FullName + "@" + f.SHA
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		subject, _, _ := strings.Cut(f.Commit.Message, "\n")
+		commits = append(commits, reportCommit{Repo: f.Repository.FullName, SHA: f.SHA, Message: subject, URL: f.HTMLURL})
+	}
+	return commits, nil
+}
+
+// activityEvent holds the fields of a user event the report reads
+type activityEvent struct {
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Repo      struct {
+		Name string `json:"name"`
+	} `json:"repo"`
+	Payload struct {
+		Action      string `json:"action"`
+		PullRequest *struct {
+			Number  int    `json:"number"`
+			Title   string `json:"title"`
+			HTMLURL string `json:"html_url"`
+		} `json:"pull_request"`
+		Issue *struct {
+			Number      int    `json:"number"`
+			Title       string `json:"title"`
+			HTMLURL     string `json:"html_url"`
+			PullRequest *struct {
+				URL string `json:"url"`
+			} `json:"pull_request"`
+		} `json:"issue"`
+	} `json:"payload"`
+}
+
+// eventActivity reads the events of login, newest first, and returns the pull requests login reviewed and the
+// issues login closed in the period
+func eventActivity(ctx context.Context, client *Client, login string, org string, period reportPeriod) ([]reportItem, []reportItem, error) {
+	var reviewed, closed []reportItem
+	seenReviews := map[string]bool{}
+	reachedStart := false
+
+	path := fmt.Sprintf("/users/%s/events?per_page=100", url.PathEscape(login))
+	err := client.GetPages(ctx, path, func(body []byte) (bool, error) {
+		var events []activityEvent
+		if err := json.Unmarshal(body, &events); err != nil {
+			return false, fmt.Errorf("failed to decode the events: %w", err)
+		}
+		for _, e := range events {
+			if e.CreatedAt.Before(period.Start) {
+				reachedStart = true
+				return false, nil
+			}
+			if !e.CreatedAt.Before(period.End) || !inOrg(e.Repo.Name, org) {
+				continue
+			}
+			switch p := e.Payload; {
+			case e.Type == "PullRequestReviewEvent" && p.PullRequest != nil:
+				key := fmt.Sprintf("%s#%d", e.Repo.Name, p.PullRequest.Number)
+				if seenReviews[key] {
+					continue
+				}
+				seenReviews[key] = true
+				reviewed = append(reviewed, reportItem{Repo: e.Repo.Name, Number: p.PullRequest.Number, Title: p.PullRequest.Title, URL: p.PullRequest.HTMLURL})
+			case e.Type == "IssuesEvent" && p.Action == "closed" && p.Issue != nil && p.Issue.PullRequest == nil:
+				closed = append(closed, reportItem{Repo: e.Repo.Name, Number: p.Issue.Number, Title: p.Issue.Title, URL: p.Issue.HTMLURL})
+			}
+		}
+		return len(events) > 0, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if !reachedStart {
+		fmt.Fprintf(os.Stderr, style.Warning()+"GitHub keeps only your last 300 events of the past 90 days, reviews and closed issues since %s may be missing\n", period.Start.Local().Format(time.DateOnly))
+	}
+	return reviewed, closed, nil
+}
+
+// inOrg reports whether the owner/repo name belongs to org, every repository does without an org
+func inOrg(repo string, org string) bool {
+	owner, _, _ := strings.Cut(repo, "/")
+	return org == "" || strings.EqualFold(owner, org)
+}
+
+// counts totals the activity of a report
+func (r *activityReport) counts() activityCounts {
+	c := activityCounts{Merged: len(r.Merged), Reviewed: len(r.Reviewed), Closed: len(r.Closed), Commits: len(r.Commits)}
+	for _, pr := range r.Merged {
+		c.Additions += pr.Additions
+		c.Deletions += pr.Deletions
+	}
+	return c
+}
+
+// repoActivity is the activity of a report in one repository
+type repoActivity struct {
+	Merged, Reviewed, Closed []reportItem
+	Commits                  []reportCommit
+}
+
+func (a *repoActivity) total() int {
+	return len(a.Merged) + len(a.Reviewed) + len(a.Closed) + len(a.Commits)
+}
+
+// byRepo groups the activity of a report by repository, the busiest first
+func (r *activityReport) byRepo() ([]string, map[string]*repoActivity) {
+	repos := map[string]*repoActivity{}
+	get := func(name string) *repoActivity {
+		if repos[name] == nil {
+			repos[name] = &repoActivity{}
+		}
+		return repos[name]
+	}
+	for _, pr := range r.Merged {
+		get(pr.Repo).Merged = append(get(pr.Repo).Merged, pr)
+	}
+	for _, pr := range r.Reviewed {
+		get(pr.Repo).Reviewed = append(get(pr.Repo).Reviewed, pr)
+	}
+	for _, issue := range r.Closed {
+		get(issue.Repo).Closed = append(get(issue.Repo).Closed, issue)
+	}
+	for _, commit := range r.Commits {
+		get(commit.Repo).Commits = append(get(commit.Repo).Commits, commit)
+	}
+
+	names := make([]string, 0, len(repos))
+	for name := range repos {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if ti, tj := repos[names[i]].total(), repos[names[j]].total(); ti != tj {
+			return ti > tj
+		}
+		return names[i] < names[j]
+	})
+	return names, repos
+}
+
+// renderReport writes a report as markdown, with the change against previous when it is set
+func renderReport(w io.Writer, report *activityReport, previous *activityReport) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# GitHub activity of @%s\n\n", report.Login)
+	scope := "all repositories"
+	if report.Org != "" {
+		scope = "repositories of " + report.Org
+	}
+	fmt.Fprintf(&b, "%s to %s, %s\n\n", report.Period.Start.Local().Format(time.DateOnly), report.Period.End.Local().Format(time.DateOnly), scope)
+
+	current := report.counts()
+	rows := []struct {
+		Name string
+		Get  func(activityCounts) int
+	}{
+		{"Merged pull requests", func(c activityCounts) int { return c.Merged }},
+		{"Reviewed pull requests", func(c activityCounts) int { return c.Reviewed }},
+		{"Issues closed", func(c activityCounts) int { return c.Closed }},
+		{"Commits", func(c activityCounts) int { return c.Commits }},
+		{"Additions", func(c activityCounts) int { return c.Additions }},
+		{"Deletions", func(c activityCounts) int { return c.Deletions }},
+	}
+	if previous == nil {
+		b.WriteString("| Activity | Count |\n| --- | ---: |\n")
+		for _, row := range rows {
+			fmt.Fprintf(&b, "| %s | %d |\n", row.Name, row.Get(current))
+		}
+	} else {
+		before := previous.counts()
+		b.WriteString("| Activity | Count | Previous | Change |\n| --- | ---: | ---: | ---: |\n")
+		for _, row := range rows {
+			fmt.Fprintf(&b, "| %s | %d | %d | %s |\n", row.Name, row.Get(current), row.Get(before), formatDelta(row.Get(current)-row.Get(before)))
+		}
+	}
+
+	names, repos := report.byRepo()
+	if len(names) == 0 {
+		b.WriteString("\nNo activity in this period.\n")
+	}
+	for _, name := range names {
+		a := repos[name]
+		fmt.Fprintf(&b, "\n## [%s](https://github.com/%s)\n", markdownEscape(name), name)
+		if len(a.Merged) > 0 {
+			additions, deletions := 0, 0
+			for _, pr := range a.Merged {
+				additions += pr.Additions
+				deletions += pr.Deletions
+			}
+			fmt.Fprintf(&b, "\n### Merged pull requests (%d, +%d -%d)\n\n", len(a.Merged), additions, deletions)
+			for _, pr := range a.Merged {
+				fmt.Fprintf(&b, "- %s (+%d -%d)\n", itemLink(pr), pr.Additions, pr.Deletions)
+			}
+		}
+		writeItems(&b, "Reviewed pull requests", a.Reviewed)
+		writeItems(&b, "Issues closed", a.Closed)
+		if len(a.Commits) > 0 {
+			fmt.Fprintf(&b, "\n### Commits (%d)\n\n", len(a.Commits))
+			for _, c := range a.Commits {
+				fmt.Fprintf(&b, "- [`%s`](%s) %s\n", c.SHA[:min(7, len(c.SHA))], c.URL, markdownEscape(c.Message))
+			}
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeItems writes a section listing pull requests or issues, nothing when there are none
+func writeItems(b *strings.Builder, title string, items []reportItem) {
+	if len(items) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "\n### %s (%d)\n\n", title, len(items))
+	for _, item := range items {
+		fmt.Fprintf(b, "- %s\n", itemLink(item))
+	}
+}
+
+// itemLink renders a pull request or issue as a markdown link with its number and title
+func itemLink(item reportItem) string {
+	return fmt.Sprintf("[#%d %s](%s)", item.Number, markdownEscape(item.Title), item.URL)
+}
+
+// formatDelta renders a change of a count with its sign
+func formatDelta(d int) string {
+	if d > 0 {
+		return "+" + strconv.Itoa(d)
+	}
+	return strconv.Itoa(d)
+}
+
+// markdownEscape keeps titles and commit messages from being read as markdown
+var markdownEscape = strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`, "*", `\*`, "_", `\_`, "`", "\\`", "<", `\<`, "|", `\|`).Replace
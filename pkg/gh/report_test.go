@@ -0,0 +1,308 @@
+package gh
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+)
+
+// reportPeriodFixture is the week the recorded responses in testdata/report cover. The bounds are at noon UTC so
+// the dates of the report are the same in every time zone the tests run in.
+var reportPeriodFixture = reportPeriod{
+	Start: time.Date(2024, 6, 3, 12, 0, 0, 0, time.UTC),
+	End:   time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC),
+}
+
+// recordedReport answers the report endpoints for the user octo with the recorded responses in testdata/report,
+// paginated with Link headers like GitHub does. Page 3 of the events is linked but never served, the report
+// must stop before it.
+func recordedReport(t *testing.T) func(w http.ResponseWriter, r *http.Request, body []byte) {
+	t.Helper()
+	dir := filepath.Join("testdata", "report")
+	data, err := os.ReadFile(filepath.Join(dir, "pulls.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pulls map[string]json.RawMessage
+	if err := json.Unmarshal(data, &pulls); err != nil {
+		t.Fatal(err)
+	}
+	pages := map[string]struct{ file, next string }{
+		"/search/issues":            {"search-issues-1.json", "2"},
+		"/search/issues?page=2":     {"search-issues-2.json", ""},
+		"/search/commits":           {"search-commits.json", ""},
+		"/users/octo/events":        {"events-1.json", "2"},
+		"/users/octo/events?page=2": {"events-2.json", "3"},
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, body []byte) {
+		if pull, ok := pulls[r.URL.Path]; ok {
+			_, _ = w.Write(pull)
+			return
+		}
+		key := r.URL.Path
+		if page := r.URL.Query().Get("page"); page != "" {
+			key += "?page=" + page
+		}
+		page, ok := pages[key]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		data, err := os.ReadFile(filepath.Join(dir, page.file))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if page.next != "" {
+			query := r.URL.Query()
+			query.Set("page", page.next)
+			w.Header().Set("Link", fmt.Sprintf(`<http://%s%s?%s>; rel="next", <http://%s%s?page=9>; rel="last"`, r.Host, r.URL.Path, query.Encode(), r.Host, r.URL.Path))
+		}
+		_, _ = w.Write(data)
+	}
+}
+
+// itemNames lists the items of a report as owner/repo#number
+func itemNames(items []reportItem) []string {
+	var names []string
+	for _, item := range items {
+		names = append(names, fmt.Sprintf("%s#%d", item.Repo, item.Number))
+	}
+	return names
+}
+
+// searchQueries returns the decoded q parameter of every request to endpoint
+func searchQueries(api *fakeGitHub, endpoint string) []string {
+	var queries []string
+	for _, call := range api.requests {
+		uri, ok := strings.CutPrefix(call, "GET "+endpoint+"?")
+		if !ok {
+			continue
+		}
+		values, _ := url.ParseQuery(uri)
+		queries = append(queries, values.Get("q"))
+	}
+	return queries
+}
+
+func TestGatherReportReadsEveryPage(t *testing.T) {
+	api := newFakeGitHub(t, recordedReport(t))
+	var report *activityReport
+	stderr := captureStderr(t, func() {
+		var err error
+		report, err = gatherReport(context.Background(), api.client(io.Discard), "octo", "", reportPeriodFixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if stderr != "" {
+		t.Errorf("warned about a complete report:\n%s", stderr)
+	}
+
+	// Both pages of the search, and the diff stats of every merged pull request
+	if got, want := itemNames(report.Merged), []string{"acme/api#12", "acme/api#15", "other/lib#3"}; !slices.Equal(got, want) {
+		t.Errorf("merged = %q, want %q", got, want)
+	}
+	var stats []string
+	for _, pr := range report.Merged {
+		stats = append(stats, fmt.Sprintf("+%d -%d", pr.Additions, pr.Deletions))
+	}
+	if want := []string{"+120 -30", "+5 -5", "+10 -0"}; !slices.Equal(stats, want) {
+		t.Errorf("diff stats = %q, want %q", stats, want)
+	}
+
+	// A review is counted once per pull request, events after the period and closed pull requests are not
+	if got, want := itemNames(report.Reviewed), []string{"acme/web#7", "other/lib#4"}; !slices.Equal(got, want) {
+		t.Errorf("reviewed = %q, want %q", got, want)
+	}
+	if got, want := itemNames(report.Closed), []string{"acme/api#20", "other/lib#6"}; !slices.Equal(got, want) {
+		t.Errorf("closed = %q, want %q", got, want)
+	}
+
+	// The commit found twice is reported once, with its subject only
+	if len(report.Commits) != 2 || report.Commits[0].Message != "Add the limiter" || report.Commits[1].Repo != "other/lib" {
+		t.Errorf("commits = %+v", report.Commits)
+	}
+
+	if got, want := searchQueries(api, "/search/issues"), []string{
+		"is:pr is:merged author:octo merged:2024-06-03T12:00:00Z..2024-06-10T11:59:59Z",
+		"is:pr is:merged author:octo merged:2024-06-03T12:00:00Z..2024-06-10T11:59:59Z",
+	}; !slices.Equal(got, want) {
+		t.Errorf("issue searches = %q, want %q", got, want)
+	}
+	for _, call := range api.requests {
+		if strings.Contains(call, "/events") && strings.Contains(call, "page=3") {
+			t.Errorf("events were read past the start of the period: %s", call)
+		}
+	}
+}
+
+func TestGatherReportOrg(t *testing.T) {
+	api := newFakeGitHub(t, recordedReport(t))
+	report, err := gatherReport(context.Background(), api.client(io.Discard), "octo", "ACME", reportPeriodFixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Search filters by org on GitHub's side, the events are filtered here, the owner in any case
+	for _, query := range append(searchQueries(api, "/search/issues"), searchQueries(api, "/search/commits")...) {
+		if !strings.HasSuffix(query, " org:ACME") {
+			t.Errorf("search %q is not limited to the org", query)
+		}
+	}
+	if got, want := itemNames(report.Reviewed), []string{"acme/web#7"}; !slices.Equal(got, want) {
+		t.Errorf("reviewed = %q, want %q", got, want)
+	}
+	if got, want := itemNames(report.Closed), []string{"acme/api#20"}; !slices.Equal(got, want) {
+		t.Errorf("closed = %q, want %q", got, want)
+	}
+}
+
+func TestGatherReportWarnings(t *testing.T) {
+	// The search matches more than it returns, the events run out before the start of the period
+	api := newFakeGitHub(t, func(w http.ResponseWriter, r *http.Request, body []byte) {
+		switch r.URL.Path {
+		case "/search/issues", "/search/commits":
+			_, _ = io.WriteString(w, `{"total_count":1500,"items":[]}`)
+		case "/users/octo/events":
+			_, _ = io.WriteString(w, `[{"type":"WatchEvent","created_at":"2024-06-09T10:00:00Z","repo":{"name":"acme/web"},"payload":{}}]`)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	stderr := captureStderr(t, func() {
+		if _, err := gatherReport(context.Background(), api.client(io.Discard), "octo", "", reportPeriodFixture); err != nil {
+			t.Fatal(err)
+		}
+	})
+	for _, want := range []string{
+		`GitHub search matched 1500 results for "is:pr is:merged author:octo`,
+		`GitHub search matched 1500 results for "author:octo committer-date:`,
+		"but only returns 1000, the report is incomplete",
+		"GitHub keeps only your last 300 events of the past 90 days, reviews and closed issues since 2024-06-03 may be missing",
+	} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("stderr does not warn %q:\n%s", want, stderr)
+		}
+	}
+}
+
+func TestGatherReportFailure(t *testing.T) {
+	api := newFakeGitHub(t, func(w http.ResponseWriter, r *http.Request, body []byte) {
+		if r.URL.Path == "/search/commits" {
+			http.Error(w, `{"message":"Validation Failed"}`, http.StatusUnprocessableEntity)
+			return
+		}
+		recordedReport(t)(w, r, body)
+	})
+	_, err := gatherReport(context.Background(), api.client(io.Discard), "octo", "", reportPeriodFixture)
+	if err == nil || !strings.HasPrefix(err.Error(), "failed to fetch the commits: ") {
+		t.Errorf("gatherReport = %v", err)
+	}
+}
+
+// TestRenderReportGolden pins the markdown of the recorded week in testdata/report, alone and against a previous
+// week
+func TestRenderReportGolden(t *testing.T) {
+	api := newFakeGitHub(t, recordedReport(t))
+	report, err := gatherReport(context.Background(), api.client(io.Discard), "octo", "", reportPeriodFixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := &activityReport{
+		Login:  "octo",
+		Period: reportPeriod{Start: reportPeriodFixture.Start.AddDate(0, 0, -7), End: reportPeriodFixture.Start},
+		Merged: []reportItem{
+			{Repo: "acme/api", Number: 9, Additions: 200, Deletions: 10},
+			{Repo: "acme/api", Number: 10, Additions: 40, Deletions: 25},
+		},
+		Reviewed: []reportItem{{Repo: "acme/web", Number: 5}, {Repo: "acme/web", Number: 6}, {Repo: "acme/web", Number: 8}},
+		Commits:  []reportCommit{{Repo: "acme/api", SHA: "fedcba9876543210"}, {Repo: "acme/api", SHA: "1111111"}},
+	}
+
+	tests := []struct {
+		golden   string
+		previous *activityReport
+	}{
+		{"week", nil},
+		{"compare", previous},
+	}
+	for _, test := range tests {
+		t.Run(test.golden, func(t *testing.T) {
+			var got bytes.Buffer
+			if err := renderReport(&got, report, test.previous); err != nil {
+				t.Fatal(err)
+			}
+			want, err := os.ReadFile(filepath.Join("testdata", "report", test.golden+".golden"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != string(want) {
+				t.Errorf("report =\n%s\nwant testdata/report/%s.golden\n%s", got.String(), test.golden, want)
+			}
+		})
+	}
+}
+
+func TestRenderReportEmptyOrg(t *testing.T) {
+	var got bytes.Buffer
+	if err := renderReport(&got, &activityReport{Login: "octo", Org: "acme", Period: reportPeriodFixture}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got.String(), "2024-06-03 to 2024-06-10, repositories of acme\n") || strings.Contains(got.String(), "## ") {
+		t.Errorf("empty report =\n%s", got.String())
+	}
+}
+
+func TestParsePeriod(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"7d", 7 * 24 * time.Hour},
+		{"2w", 14 * 24 * time.Hour},
+		{"36h", 36 * time.Hour},
+		{" 90m ", 90 * time.Minute},
+		{"1.5d", 36 * time.Hour},
+		{"1,5d", 36 * time.Hour},
+	}
+	for _, test := range tests {
+		if got, err := parsePeriod("--since", test.value); err != nil || got != test.want {
+			t.Errorf("parsePeriod(%q) = %v, %v, want %v", test.value, got, err, test.want)
+		}
+	}
+
+	for _, value := range []string{"", "d", "0d", "-1w", "7x", "soon"} {
+		_, err := parsePeriod("--since", value)
+		if err == nil || clierr.CodeOf(err) != clierr.Usage || !strings.Contains(err.Error(), "invalid --since") {
+			t.Errorf("parsePeriod(%q) = %v, want a usage error", value, err)
+		}
+	}
+}
+
+func TestMarkdownEscape(t *testing.T) {
+	tests := map[string]string{
+		"Add rate limiting":              "Add rate limiting",
+		"Fix [flaky] test_retry | again": `Fix \[flaky\] test\_retry \| again`,
+		"Docs: `make` targets":           "Docs: \\`make\\` targets",
+		`*bold* <b> C:\tmp`:              `\*bold\* \<b> C:\\tmp`,
+	}
+	for in, want := range tests {
+		if got := markdownEscape(in); got != want {
+			t.Errorf("markdownEscape(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
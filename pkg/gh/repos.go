@@ -0,0 +1,219 @@
+package gh
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/diffout"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/output"
+	"gsn-dev-tools/internals/style"
+	"gsn-dev-tools/internals/tui"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+	"gopkg.in/yaml.v3"
+)
+
+func repoCmd() *cobra.Command {
+	repoCmd := &cobra.Command{
+		Use:   "repo",
+		Short: "Snapshot repository settings and check them for drift",
+		Long: `Exports the default branch, merge settings, topics, labels and classic branch protections of a repository to
+YAML and compares repositories against such a snapshot, so several repositories can share the same settings.
+Rulesets are not included.`,
+		Example: `  gsn gh repo snapshot owner/repo -o repo.yaml
+  gsn gh repo check owner/other --against repo.yaml`,
+	}
+
+	repoCmd.AddCommand(snapshotRepoCmd())
+	repoCmd.AddCommand(checkRepoCmd())
+	return repoCmd
+}
+
+func snapshotRepoCmd() *cobra.Command {
+	var outputPath string
+
+	snapshotCmd := &cobra.Command{
+		Use:   "snapshot [owner/repo]",
+		Short: "Export the settings of a repository to YAML",
+		Long:  "Reads the settings of a repository, defaulting to the origin remote, and writes them as YAML. Only GET requests are sent.",
+		Example: `  gsn gh repo snapshot owner/repo -o repo.yaml
+  gsn gh repo snapshot > repo.yaml`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			owner, name, err := resolveRepo(cmd.Context(), firstArg(args))
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			client, err := NewClient("repo")
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			client.ReadOnly = true
+
+			settings, err := client.GetRepoSettings(cmd.Context(), owner, name)
+			if err != nil {
+				clierr.Fatalf("Failed to read the settings of %s/%s: %v", owner, name, err)
+			}
+
+			var buf bytes.Buffer
+			fmt.Fprintf(&buf, "# Settings of %s/%s, written by gsn gh repo snapshot\n", owner, name)
+			encoder := yaml.NewEncoder(&buf)
+			encoder.SetIndent(2)
+			if err := encoder.Encode(settings); err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			data := buf.Bytes()
+
+			if outputPath == "" || outputPath == "-" {
+				os.Stdout.Write(data)
+				return
+			}
+			if err := output.WriteFileAtomic(outputPath, data, 0o644); err != nil {
+				clierr.Fatalf("Failed to write %s: %v", outputPath, err)
+			}
+			fmt.Fprintf(os.Stderr, style.Success()+"Wrote the settings of %s/%s to %s\n", owner, name, outputPath)
+		},
+	}
+
+	snapshotCmd.Flags().StringVarP(&outputPath, "output", "o", "", "File to write the snapshot to (defaults to stdout)")
+	return snapshotCmd
+}
+
+func checkRepoCmd() *cobra.Command {
+	var against string
+	var apply, assumeYes bool
+
+	checkCmd := &cobra.Command{
+		Use:   "check [owner/repo]",
+		Short: "Report where a repository's settings drifted from a snapshot",
+		Long: `Compares the settings of a repository with a snapshot and prints every field that differs, from the value
+in the snapshot to the one in the repository, like gsn env diff does; --diff-format json or summary suit
+scripts. check exits with 1 when there is drift. With --apply the snapshot's values are written back after confirmation: missing labels
+are created, changed ones updated and extra ones deleted, topics are replaced and branch protections are
+set or removed to match.`,
+		Example: `  gsn gh repo check owner/repo --against repo.yaml
+  gsn gh repo check owner/repo --against repo.yaml --apply --dry-run
+  gsn gh repo check --against repo.yaml --apply --yes
+  gsn gh repo check owner/repo --against repo.yaml --diff-format json`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := cmd.Context()
+			opts, err := diffout.OptionsFromFlags(cmd)
+			if err != nil {
+				clierr.Fatal(err)
+			}
+			opts.ShowValues = true
+
+			want, err := loadRepoSettings(against)
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			owner, name, err := resolveRepo(ctx, firstArg(args))
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			client, err := NewClient("repo")
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			client.ReadOnly = client.ReadOnly || !apply
+
+			have, err := client.GetRepoSettings(ctx, owner, name)
+			if err != nil {
+				clierr.Fatalf("Failed to read the settings of %s/%s: %v", owner, name, err)
+			}
+
+			drift, err := diffSettings(want, have)
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			if len(drift) == 0 && opts.Format == diffout.FormatUnified {
+				fmt.Printf(style.Success()+"%s/%s matches %s\n", owner, name, against)
+				return
+			}
+			if err := diffout.Render(os.Stdout, driftChanges(drift), opts); err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			if len(drift) == 0 {
+				return
+			}
+			fmt.Fprintf(os.Stderr, style.Warning()+"%s/%s differs from %s in %d field(s)\n", owner, name, against, len(drift))
+			if !apply {
+				clierr.Exit(clierr.Failure)
+			}
+
+			if err := applyRepoSettings(ctx, client, owner, name, want, have, assumeYes || client.DryRun); err != nil {
+				clierr.Fatalf("%v", err)
+			}
+		},
+	}
+
+	checkCmd.Flags().StringVar(&against, "against", "", "Snapshot file written by gh repo snapshot")
+	checkCmd.Flags().BoolVar(&apply, "apply", false, "Write the snapshot's values to the repository")
+	checkCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Apply without asking for confirmation")
+	diffout.AddFlags(checkCmd)
+	_ = checkCmd.MarkFlagRequired("against")
+	dryrun.Adopt(checkCmd)
+	return checkCmd
+}
+
+// applyRepoSettings writes the changes that bring the repository in line with the snapshot, stopping at the first error
+func applyRepoSettings(ctx context.Context, client *Client, owner string, name string, want *RepoSettings, have *RepoSettings, confirmed bool) error {
+	changes := planSettings(owner, name, want, have)
+
+	if !confirmed {
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return fmt.Errorf("refusing to apply without confirmation, rerun with --yes")
+		}
+		fmt.Println("\nChanges:")
+		for _, c := range changes {
+			fmt.Printf("  %s\n", c.Description)
+		}
+		if !tui.Confirm(fmt.Sprintf("Apply %d change(s) to %s/%s?", len(changes), owner, name)) {
+			return fmt.Errorf("apply cancelled")
+		}
+	}
+
+	for _, c := range changes {
+		if err := client.Write(ctx, c.Method, c.Path, c.Body, nil); err != nil {
+			return fmt.Errorf("failed to %s: %w", c.Description, err)
+		}
+		if !client.DryRun {
+			fmt.Printf(style.Success()+"%s\n", c.Description)
+		}
+	}
+	return nil
+}
+
+// loadRepoSettings reads a snapshot written by gh repo snapshot
+func loadRepoSettings(path string) (*RepoSettings, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var settings RepoSettings
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&settings); err != nil {
+		return nil, fmt.Errorf("invalid snapshot %s: %w", path, err)
+	}
+	settings.normalize()
+	return &settings, nil
+}
+
+// firstArg returns the first argument or an empty string
+func firstArg(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	return args[0]
+}
@@ -0,0 +1,315 @@
+package gh
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"gsn-dev-tools/internals/diffout"
+)
+
+// fakeRepoSettings is the live state of owner/repo as the fake API serves it
+type fakeRepoSettings struct {
+	info       map[string]any
+	labels     []map[string]any
+	protection map[string]map[string]any
+	bodies     []string
+}
+
+// baseRepoSettings is the repository the snapshot in repoSnapshot was taken of
+func baseRepoSettings() *fakeRepoSettings {
+	return &fakeRepoSettings{
+		info: map[string]any{
+			"default_branch": "main", "topics": []string{"go", "cli"},
+			"allow_merge_commit": false, "allow_squash_merge": true, "allow_rebase_merge": true,
+			"allow_auto_merge": true, "allow_update_branch": false, "delete_branch_on_merge": true,
+		},
+		labels: []map[string]any{
+			{"name": "bug", "color": "d73a4a", "description": "Something isn't working"},
+			{"name": "dependencies", "color": "0366d6", "description": ""},
+		},
+		protection: map[string]map[string]any{
+			"main": {
+				"required_status_checks":        map[string]any{"strict": true, "contexts": []string{"test", "lint"}},
+				"enforce_admins":                map[string]any{"enabled": true},
+				"required_pull_request_reviews": map[string]any{"required_approving_review_count": 1, "dismiss_stale_reviews": true},
+				"required_linear_history":       map[string]any{"enabled": true},
+				"allow_force_pushes":            map[string]any{"enabled": false},
+			},
+		},
+	}
+}
+
+// repoSnapshot is what gh repo snapshot writes for baseRepoSettings, hand-formatted with the lists out of order
+const repoSnapshot = `default_branch: main
+merge:
+  allow_merge_commit: false
+  allow_squash_merge: true
+  allow_rebase_merge: true
+  allow_auto_merge: true
+  allow_update_branch: false
+  delete_branch_on_merge: true
+topics: [go, cli]
+labels:
+  bug:
+    color: "#D73A4A"
+    description: Something isn't working
+  dependencies:
+    color: 0366d6
+    description: ""
+protection:
+  main:
+    required_status_checks:
+      strict: true
+      contexts: [test, lint]
+    enforce_admins: true
+    required_pull_request_reviews:
+      required_approving_review_count: 1
+      dismiss_stale_reviews: true
+      require_code_owner_reviews: false
+      require_last_push_approval: false
+    required_linear_history: true
+    allow_force_pushes: false
+    allow_deletions: false
+    required_conversation_resolution: false
+`
+
+// serve answers the read endpoints of owner/repo from the fake state and accepts every write
+func (s *fakeRepoSettings) serve(w http.ResponseWriter, r *http.Request, body []byte) {
+	if r.Method != http.MethodGet {
+		s.bodies = append(s.bodies, strings.TrimSpace(string(body)))
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("{}"))
+		return
+	}
+	const base = "/repos/owner/repo"
+	switch path := r.URL.Path; {
+	case path == base:
+		_ = json.NewEncoder(w).Encode(s.info)
+	case path == base+"/labels":
+		_ = json.NewEncoder(w).Encode(s.labels)
+	case path == base+"/branches" && r.URL.Query().Get("protected") == "true":
+		var branches []map[string]any
+		for _, name := range sortedKeys(s.protection) {
+			branches = append(branches, map[string]any{"name": name})
+		}
+		_ = json.NewEncoder(w).Encode(branches)
+	case strings.HasPrefix(path, base+"/branches/") && strings.HasSuffix(path, "/protection"):
+		name := strings.TrimSuffix(strings.TrimPrefix(path, base+"/branches/"), "/protection")
+		if p, ok := s.protection[name]; ok {
+			_ = json.NewEncoder(w).Encode(p)
+			return
+		}
+		http.NotFound(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// writeSnapshot writes data to a snapshot file and loads it
+func writeSnapshot(t *testing.T, data string) *RepoSettings {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "repo.yaml")
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	want, err := loadRepoSettings(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return want
+}
+
+func TestRepoSettingsDrift(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(s *fakeRepoSettings)
+		drift   []string
+		changes []string
+	}{
+		{"in sync", func(s *fakeRepoSettings) {}, nil, nil},
+		{"lists in another order", func(s *fakeRepoSettings) {
+			s.info["topics"] = []string{"cli", "go"}
+			s.protection["main"]["required_status_checks"] = map[string]any{"strict": true, "contexts": []string{"lint", "test"}}
+		}, nil, nil},
+		{"default branch", func(s *fakeRepoSettings) { s.info["default_branch"] = "master" },
+			[]string{`default_branch: snapshot "main", repo "master"`},
+			[]string{"PATCH /repos/owner/repo update default branch and merge settings"}},
+		{"merge method", func(s *fakeRepoSettings) { s.info["allow_merge_commit"] = true },
+			[]string{"merge.allow_merge_commit: snapshot false, repo true"},
+			[]string{"PATCH /repos/owner/repo update default branch and merge settings"}},
+		{"topics", func(s *fakeRepoSettings) { s.info["topics"] = []string{"go"} },
+			[]string{"topics: snapshot [cli, go], repo [go]"},
+			[]string{"PUT /repos/owner/repo/topics replace topics"}},
+		{"label color", func(s *fakeRepoSettings) { s.labels[0]["color"] = "ee0701" },
+			[]string{`labels.bug.color: snapshot "d73a4a", repo "ee0701"`},
+			[]string{"PATCH /repos/owner/repo/labels/bug update label bug"}},
+		{"label missing and extra", func(s *fakeRepoSettings) {
+			s.labels = []map[string]any{s.labels[0], {"name": "good first issue", "color": "7057ff", "description": ""}}
+		}, []string{
+			`labels.dependencies.color: snapshot "0366d6", repo (unset)`,
+			`labels.dependencies.description: snapshot "", repo (unset)`,
+			`labels.good first issue.color: snapshot (unset), repo "7057ff"`,
+			`labels.good first issue.description: snapshot (unset), repo ""`,
+		}, []string{
+			"POST /repos/owner/repo/labels create label dependencies",
+			"DELETE /repos/owner/repo/labels/good%20first%20issue delete label good first issue",
+		}},
+		{"review rule", func(s *fakeRepoSettings) {
+			s.protection["main"]["required_pull_request_reviews"] = map[string]any{"required_approving_review_count": 2, "dismiss_stale_reviews": true}
+		}, []string{"protection.main.required_pull_request_reviews.required_approving_review_count: snapshot 1, repo 2"},
+			[]string{"PUT /repos/owner/repo/branches/main/protection protect main"}},
+		{"rule removed", func(s *fakeRepoSettings) { delete(s.protection["main"], "required_status_checks") },
+			[]string{
+				"protection.main.required_status_checks.contexts: snapshot [lint, test], repo (unset)",
+				"protection.main.required_status_checks.strict: snapshot true, repo (unset)",
+			},
+			[]string{"PUT /repos/owner/repo/branches/main/protection protect main"}},
+		{"branch unprotected", func(s *fakeRepoSettings) { delete(s.protection, "main") },
+			[]string{
+				"protection.main.allow_deletions: snapshot false, repo (unset)",
+				"protection.main.allow_force_pushes: snapshot false, repo (unset)",
+				"protection.main.enforce_admins: snapshot true, repo (unset)",
+				"protection.main.required_conversation_resolution: snapshot false, repo (unset)",
+				"protection.main.required_linear_history: snapshot true, repo (unset)",
+				"protection.main.required_pull_request_reviews.dismiss_stale_reviews: snapshot true, repo (unset)",
+				"protection.main.required_pull_request_reviews.require_code_owner_reviews: snapshot false, repo (unset)",
+				"protection.main.required_pull_request_reviews.require_last_push_approval: snapshot false, repo (unset)",
+				"protection.main.required_pull_request_reviews.required_approving_review_count: snapshot 1, repo (unset)",
+				"protection.main.required_status_checks.contexts: snapshot [lint, test], repo (unset)",
+				"protection.main.required_status_checks.strict: snapshot true, repo (unset)",
+			},
+			[]string{"PUT /repos/owner/repo/branches/main/protection protect main"}},
+		{"extra protected branch", func(s *fakeRepoSettings) {
+			s.protection["release/1.x"] = map[string]any{"allow_deletions": map[string]any{"enabled": true}}
+		}, []string{
+			"protection.release/1.x.allow_deletions: snapshot (unset), repo true",
+			"protection.release/1.x.allow_force_pushes: snapshot (unset), repo false",
+			"protection.release/1.x.enforce_admins: snapshot (unset), repo false",
+			"protection.release/1.x.required_conversation_resolution: snapshot (unset), repo false",
+			"protection.release/1.x.required_linear_history: snapshot (unset), repo false",
+		}, []string{"DELETE /repos/owner/repo/branches/release%2F1.x/protection unprotect release/1.x"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			live := baseRepoSettings()
+			test.change(live)
+			server := newFakeGitHub(t, live.serve)
+			client := server.client(nil)
+			client.ReadOnly = true
+
+			have, err := client.GetRepoSettings(context.Background(), "owner", "repo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := writeSnapshot(t, repoSnapshot)
+
+			drift, err := diffSettings(want, have)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var gotDrift []string
+			for _, d := range drift {
+				gotDrift = append(gotDrift, d.Field+": snapshot "+d.Snapshot+", repo "+d.Repo)
+			}
+			if !slices.Equal(gotDrift, test.drift) {
+				t.Errorf("drift =\n%s\nwant\n%s", strings.Join(gotDrift, "\n"), strings.Join(test.drift, "\n"))
+			}
+
+			var gotChanges []string
+			for _, c := range planSettings("owner", "repo", want, have) {
+				gotChanges = append(gotChanges, c.Method+" "+c.Path+" "+c.Description)
+			}
+			if !slices.Equal(gotChanges, test.changes) {
+				t.Errorf("changes =\n%s\nwant\n%s", strings.Join(gotChanges, "\n"), strings.Join(test.changes, "\n"))
+			}
+			if len(server.writes) != 0 {
+				t.Errorf("reading the settings wrote %v", server.writes)
+			}
+		})
+	}
+}
+
+func TestApplyRepoSettings(t *testing.T) {
+	live := baseRepoSettings()
+	live.info["topics"] = []string{}
+	live.labels = live.labels[:1]
+	live.labels[0]["color"] = "ee0701"
+	server := newFakeGitHub(t, live.serve)
+	want := writeSnapshot(t, repoSnapshot)
+
+	// A dry run prints the calls and sends none of them
+	var out bytes.Buffer
+	client := server.client(&out)
+	client.DryRun = true
+	have, err := client.GetRepoSettings(context.Background(), "owner", "repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := applyRepoSettings(context.Background(), client, "owner", "repo", want, have, true); err != nil {
+		t.Fatal(err)
+	}
+	wantCalls := []string{
+		"PATCH /repos/owner/repo/labels/bug",
+		"POST /repos/owner/repo/labels",
+		"PUT /repos/owner/repo/topics",
+	}
+	if got := strings.Split(strings.TrimSpace(out.String()), "\n"); !slices.Equal(got, wantCalls) {
+		t.Errorf("dry run printed\n%s", out.String())
+	}
+	if len(server.writes) != 0 {
+		t.Fatalf("the dry run wrote %v", server.writes)
+	}
+
+	client = server.client(nil)
+	captureStdout(t, func() {
+		err = applyRepoSettings(context.Background(), client, "owner", "repo", want, have, true)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := server.writes; !slices.Equal(got, wantCalls) {
+		t.Errorf("writes = %v, want %v", got, wantCalls)
+	}
+	// The created label carries its name, the topics are sent sorted
+	wantBodies := []string{
+		`{"color":"d73a4a","description":"Something isn't working"}`,
+		`{"name":"dependencies","color":"0366d6","description":""}`,
+		`{"names":["cli","go"]}`,
+	}
+	if !slices.Equal(live.bodies, wantBodies) {
+		t.Errorf("bodies =\n%s", strings.Join(live.bodies, "\n"))
+	}
+}
+
+func TestLoadRepoSettingsRejectsUnknownFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "repo.yaml")
+	if err := os.WriteFile(path, []byte("default_branch: main\nrulesets: []\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadRepoSettings(path); err == nil || !strings.Contains(err.Error(), "rulesets") {
+		t.Errorf("loadRepoSettings = %v, want the unknown field named", err)
+	}
+}
+
+func TestDriftChanges(t *testing.T) {
+	drift := []settingsDrift{
+		{Field: "default_branch", Snapshot: `"main"`, Repo: `"master"`},
+		{Field: "labels.bug.color", Snapshot: unsetValue, Repo: `"d73a4a"`},
+		{Field: "protection.main.enforce_admins", Snapshot: "true", Repo: unsetValue},
+	}
+	want := []diffout.Change{
+		{Key: "default_branch", Kind: diffout.Modified, Before: `"main"`, After: `"master"`},
+		{Key: "labels.bug.color", Kind: diffout.Added, After: `"d73a4a"`},
+		{Key: "protection.main.enforce_admins", Kind: diffout.Removed, Before: "true"},
+	}
+	if got := driftChanges(drift); !slices.Equal(got, want) {
+		t.Errorf("driftChanges =\n%+v\nwant\n%+v", got, want)
+	}
+}
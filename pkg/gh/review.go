@@ -0,0 +1,108 @@
+package gh
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gsn-dev-tools/internals/config"
+
+	"github.com/spf13/cobra"
+)
+
+// reviewPlaceholders resolves the placeholders of a review message from the pull request
+var reviewPlaceholders = map[string]func(ref PRRef, pr *PRDetails) string{
+	"author":        func(_ PRRef, pr *PRDetails) string { return pr.User.Login },
+	"title":         func(_ PRRef, pr *PRDetails) string { return pr.Title },
+	"number":        func(ref PRRef, _ *PRDetails) string { return strconv.Itoa(ref.Number) },
+	"repo":          func(ref PRRef, _ *PRDetails) string { return ref.Owner + "/" + ref.Repo },
+	"files_changed": func(_ PRRef, pr *PRDetails) string { return strconv.Itoa(pr.ChangedFiles) },
+	"additions":     func(_ PRRef, pr *PRDetails) string { return strconv.Itoa(pr.Additions) },
+	"deletions":     func(_ PRRef, pr *PRDetails) string { return strconv.Itoa(pr.Deletions) },
+}
+
+// statPlaceholders are only returned when a single pull request is fetched, not by the list endpoint
+var statPlaceholders = []string{"files_changed", "additions", "deletions"}
+
+func addReviewMessageFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("message", "m", "", "Optional review message, may use {author}, {title}, {number}, {repo}, {files_changed}, {additions} and {deletions}")
+	cmd.Flags().String("template", "", "Use the review message saved under this name in gh.review_templates of the config file")
+	cmd.MarkFlagsMutuallyExclusive("message", "template")
+}
+
+// reviewMessageFromFlags returns the message template given with -m or --template, checked for unknown placeholders
+func reviewMessageFromFlags(cmd *cobra.Command) (string, error) {
+	message, _ := cmd.Flags().GetString("message")
+	name, _ := cmd.Flags().GetString("template")
+
+	if name != "" {
+		cfg, err := config.Load()
+		if err != nil {
+			return "", err
+		}
+		tmpl, ok := cfg.GH.ReviewTemplates[name]
+		if !ok {
+			return "", cfg.MissingValue(fmt.Errorf("review template '%s' is not defined in gh.review_templates of the config file", name))
+		}
+		message = tmpl
+	}
+
+	if _, err := renderReviewMessage(message, PRRef{}, &PRDetails{}); err != nil {
+		return "", err
+	}
+	return message, nil
+}
+
+// renderReviewMessage replaces the {placeholders} of a review message with the pull request's values.
+// {{ and }} stand for literal braces. Unknown or unclosed placeholders are an error so a message is never
+// submitted with a literal placeholder in it.
+func renderReviewMessage(tmpl string, ref PRRef, pr *PRDetails) (string, error) {
+	var out strings.Builder
+	for i := 0; i < len(tmpl); i++ {
+		c := tmpl[i]
+		switch {
+		case c == '{' && strings.HasPrefix(tmpl[i:], "{{"):
+			out.WriteByte('{')
+			i++
+		case c == '}' && strings.HasPrefix(tmpl[i:], "}}"):
+			out.WriteByte('}')
+			i++
+		case c == '{':
+			end := strings.IndexByte(tmpl[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unclosed placeholder in review message at '%s'", tmpl[i:])
+			}
+			name := tmpl[i+1 : i+end]
+			resolve, ok := reviewPlaceholders[name]
+			if !ok {
+				return "", fmt.Errorf("unknown placeholder {%s} in review message (use %s, or {{ for a literal brace)", name, knownPlaceholders())
+			}
+			out.WriteString(resolve(ref, pr))
+			i += end
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.String(), nil
+}
+
+// usesStatPlaceholders reports whether a message needs the diff stats of a pull request
+func usesStatPlaceholders(tmpl string) bool {
+	for _, name := range statPlaceholders {
+		if strings.Contains(tmpl, "{"+name+"}") {
+			return true
+		}
+	}
+	return false
+}
+
+// knownPlaceholders lists the placeholders in order for error messages
+func knownPlaceholders() string {
+	names := make([]string, 0, len(reviewPlaceholders))
+	for name := range reviewPlaceholders {
+		names = append(names, "{"+name+"}")
+	}
+	slices.Sort(names)
+	return strings.Join(names, ", ")
+}
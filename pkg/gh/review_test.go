@@ -0,0 +1,89 @@
+package gh
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRenderReviewMessage(t *testing.T) {
+	ref := PRRef{Owner: "owner", Repo: "repo", Number: 42}
+	pr := &PRDetails{Title: "Bump golang.org/x/net to 0.30.0", Additions: 12, Deletions: 3, ChangedFiles: 2}
+	pr.User.Login = "dependabot[bot]"
+
+	tests := []struct {
+		name    string
+		tmpl    string
+		want    string
+		wantErr string
+	}{
+		{"empty", "", "", ""},
+		{"no placeholder", "LGTM", "LGTM", ""},
+		{"author and title", "Thanks @{author} for {title}", "Thanks @dependabot[bot] for Bump golang.org/x/net to 0.30.0", ""},
+		{"reference", "{repo}#{number}", "owner/repo#42", ""},
+		{"stats", "{files_changed} files, +{additions} -{deletions}", "2 files, +12 -3", ""},
+		{"same placeholder twice", "{number}/{number}", "42/42", ""},
+		{"escaped braces", "{{author}} is {author}", "{author} is dependabot[bot]", ""},
+		{"escaped around placeholder", "{{{number}}}", "{42}", ""},
+		{"lone closing brace", "a } b", "a } b", ""},
+		{"multibyte text", "Merci {author} ✓", "Merci dependabot[bot] ✓", ""},
+		{"unknown", "Hi {user}", "", "unknown placeholder {user} in review message (use {additions}, {author}, {deletions}, {files_changed}, {number}, {repo}, {title}"},
+		{"case matters", "{Author}", "", "unknown placeholder {Author}"},
+		{"empty placeholder", "{}", "", "unknown placeholder {}"},
+		{"unclosed", "Thanks {author", "", "unclosed placeholder in review message at '{author'"},
+		{"unclosed after escape", "{{ {title", "", "unclosed placeholder"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := renderReviewMessage(test.tmpl, ref, pr)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("renderReviewMessage(%q) = %q, %v, want an error with %q", test.tmpl, got, err, test.wantErr)
+				}
+				return
+			}
+			if err != nil || got != test.want {
+				t.Errorf("renderReviewMessage(%q) = %q, %v, want %q", test.tmpl, got, err, test.want)
+			}
+		})
+	}
+}
+
+func TestUsesStatPlaceholders(t *testing.T) {
+	tests := map[string]bool{
+		"LGTM {author}":   false,
+		"+{additions}":    true,
+		"{files_changed}": true,
+		"-{deletions}":    true,
+		// An escaped placeholder only costs fetching the stats it does not need
+		"{{additions}}":     true,
+		"additions, {repo}": false,
+	}
+	for tmpl, want := range tests {
+		if got := usesStatPlaceholders(tmpl); got != want {
+			t.Errorf("usesStatPlaceholders(%q) = %v, want %v", tmpl, got, want)
+		}
+	}
+}
+
+func TestApproveRendersMessage(t *testing.T) {
+	recorder := &reviewRecorder{}
+	server := newFakeGitHub(t, recorder.handle)
+	client := server.client(io.Discard)
+
+	if _, err := approvePR(context.Background(), client, "https://github.com/owner/repo/pull/2", "", "Thanks {author}, merging {repo}#{number}: {title}"); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorder.reviews) != 1 || recorder.reviews[0].Body != "Thanks dependabot[bot], merging owner/repo#2: Bump dep 2" {
+		t.Errorf("reviews = %+v", recorder.reviews)
+	}
+
+	// A placeholder unknown to the renderer fails before the review is submitted
+	if _, err := approvePR(context.Background(), client, "https://github.com/owner/repo/pull/1", "", "{nope}"); err == nil {
+		t.Error("approved with an unknown placeholder")
+	}
+	if len(server.writes) != 1 {
+		t.Errorf("writes = %v", server.writes)
+	}
+}
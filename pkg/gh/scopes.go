@@ -0,0 +1,97 @@
+package gh
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// scopeGroup describes the token permissions needed by a group of commands
+type scopeGroup struct {
+	Commands    string
+	Classic     []string
+	FineGrained []string
+}
+
+// commandScopes lists the permissions each command group needs, shown by `gh auth login` and `gh auth check`
+var commandScopes = []scopeGroup{
+	{
+		Commands:    "approve, approve-bots, pr list, gh queue flush",
+		Classic:     []string{"repo"},
+		FineGrained: []string{"Pull requests: Read and write", "Metadata: Read"},
+	},
+	{
+		Commands:    "pr merge",
+		Classic:     []string{"repo"},
+		FineGrained: []string{"Pull requests: Read and write", "Contents: Read and write", "Metadata: Read"},
+	},
+	{
+		Commands:    "pr label",
+		Classic:     []string{"repo"},
+		FineGrained: []string{"Issues: Read and write", "Pull requests: Read", "Metadata: Read"},
+	},
+	{
+		Commands:    "issue create, issue close, issue list",
+		Classic:     []string{"repo"},
+		FineGrained: []string{"Issues: Read and write", "Metadata: Read"},
+	},
+	{
+		Commands:    "gh branch-cleanup",
+		Classic:     []string{"repo"},
+		FineGrained: []string{"Pull requests: Read", "Metadata: Read"},
+	},
+	{
+		Commands:    "gh status set, gh status get",
+		Classic:     []string{"repo"},
+		FineGrained: []string{"Commit statuses: Read and write", "Checks: Read", "Pull requests: Read", "Metadata: Read"},
+	},
+	{
+		Commands:    "gh repo snapshot, gh repo check",
+		Classic:     []string{"repo"},
+		FineGrained: []string{"Administration: Read and write", "Issues: Read and write", "Metadata: Read"},
+	},
+}
+
+// impliedScopes maps a classic scope to the narrower scopes it grants
+var impliedScopes = map[string][]string{
+	"repo":      {"repo:status", "repo_deployment", "public_repo", "repo:invite", "security_events"},
+	"admin:org": {"write:org", "read:org"},
+	"write:org": {"read:org"},
+	"user":      {"read:user", "user:email", "user:follow"},
+}
+
+// parseScopes splits an X-OAuth-Scopes header value.
+// The second result is false when the header is absent, as with fine-grained tokens.
+func parseScopes(header http.Header) ([]string, bool) {
+	values, ok := header[http.CanonicalHeaderKey("X-OAuth-Scopes")]
+	if !ok {
+		return nil, false
+	}
+
+	var scopes []string
+	for _, value := range values {
+		for _, scope := range strings.Split(value, ",") {
+			if scope = strings.TrimSpace(scope); scope != "" {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	return scopes, true
+}
+
+// missingScopes returns the required scopes not covered by the granted ones
+func missingScopes(granted []string, required []string) []string {
+	var missing []string
+	for _, need := range required {
+		covered := slices.Contains(granted, need)
+		for _, have := range granted {
+			if slices.Contains(impliedScopes[have], need) {
+				covered = true
+			}
+		}
+		if !covered {
+			missing = append(missing, need)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,404 @@
+package gh
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gsn-dev-tools/internals/diffout"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RepoSettings is the part of a repository's configuration gh repo snapshot exports and gh repo check compares
+type RepoSettings struct {
+	DefaultBranch string                      `yaml:"default_branch"`
+	Merge         MergeSettings               `yaml:"merge"`
+	Topics        []string                    `yaml:"topics"`
+	Labels        map[string]LabelSettings    `yaml:"labels"`
+	Protection    map[string]BranchProtection `yaml:"protection"`
+}
+
+// MergeSettings are the merge methods and merge behavior of a repository
+type MergeSettings struct {
+	AllowMergeCommit    bool `yaml:"allow_merge_commit" json:"allow_merge_commit"`
+	AllowSquashMerge    bool `yaml:"allow_squash_merge" json:"allow_squash_merge"`
+	AllowRebaseMerge    bool `yaml:"allow_rebase_merge" json:"allow_rebase_merge"`
+	AllowAutoMerge      bool `yaml:"allow_auto_merge" json:"allow_auto_merge"`
+	AllowUpdateBranch   bool `yaml:"allow_update_branch" json:"allow_update_branch"`
+	DeleteBranchOnMerge bool `yaml:"delete_branch_on_merge" json:"delete_branch_on_merge"`
+}
+
+// LabelSettings is a label without its name, which keys the labels map
+type LabelSettings struct {
+	Color       string `yaml:"color" json:"color"`
+	Description string `yaml:"description" json:"description"`
+}
+
+// BranchProtection is the classic protection of a branch. The JSON form is the payload of the update endpoint,
+// where a nil rule disables it.
+type BranchProtection struct {
+	StatusChecks           *StatusChecks     `yaml:"required_status_checks,omitempty" json:"required_status_checks"`
+	EnforceAdmins          bool              `yaml:"enforce_admins" json:"enforce_admins"`
+	Reviews                *ReviewRules      `yaml:"required_pull_request_reviews,omitempty" json:"required_pull_request_reviews"`
+	Restrictions           *PushRestrictions `yaml:"restrictions,omitempty" json:"restrictions"`
+	LinearHistory          bool              `yaml:"required_linear_history" json:"required_linear_history"`
+	AllowForcePushes       bool              `yaml:"allow_force_pushes" json:"allow_force_pushes"`
+	AllowDeletions         bool              `yaml:"allow_deletions" json:"allow_deletions"`
+	ConversationResolution bool              `yaml:"required_conversation_resolution" json:"required_conversation_resolution"`
+}
+
+// StatusChecks are the checks that must pass before merging
+type StatusChecks struct {
+	Strict   bool     `yaml:"strict" json:"strict"`
+	Contexts []string `yaml:"contexts" json:"contexts"`
+}
+
+// ReviewRules are the pull request reviews required before merging
+type ReviewRules struct {
+	RequiredApprovals       int  `yaml:"required_approving_review_count" json:"required_approving_review_count"`
+	DismissStaleReviews     bool `yaml:"dismiss_stale_reviews" json:"dismiss_stale_reviews"`
+	RequireCodeOwnerReviews bool `yaml:"require_code_owner_reviews" json:"require_code_owner_reviews"`
+	RequireLastPushApproval bool `yaml:"require_last_push_approval" json:"require_last_push_approval"`
+}
+
+// PushRestrictions limit who can push to a branch, only available for organization repositories
+type PushRestrictions struct {
+	Users []string `yaml:"users" json:"users"`
+	Teams []string `yaml:"teams" json:"teams"`
+	Apps  []string `yaml:"apps" json:"apps"`
+}
+
+// protectionResponse is the branch protection as the REST API returns it
+type protectionResponse struct {
+	RequiredStatusChecks *struct {
+		Strict   bool     `json:"strict"`
+		Contexts []string `json:"contexts"`
+	} `json:"required_status_checks"`
+	EnforceAdmins              enabledSetting `json:"enforce_admins"`
+	RequiredPullRequestReviews *struct {
+		RequiredApprovingReviewCount int  `json:"required_approving_review_count"`
+		DismissStaleReviews          bool `json:"dismiss_stale_reviews"`
+		RequireCodeOwnerReviews      bool `json:"require_code_owner_reviews"`
+		RequireLastPushApproval      bool `json:"require_last_push_approval"`
+	} `json:"required_pull_request_reviews"`
+	Restrictions *struct {
+		Users []struct {
+			Login string `json:"login"`
+		} `json:"users"`
+		Teams []struct {
+			Slug string `json:"slug"`
+		} `json:"teams"`
+		Apps []struct {
+			Slug string `json:"slug"`
+		} `json:"apps"`
+	} `json:"restrictions"`
+	RequiredLinearHistory          enabledSetting `json:"required_linear_history"`
+	AllowForcePushes               enabledSetting `json:"allow_force_pushes"`
+	AllowDeletions                 enabledSetting `json:"allow_deletions"`
+	RequiredConversationResolution enabledSetting `json:"required_conversation_resolution"`
+}
+
+// enabledSetting is how the REST API returns boolean protection rules
+type enabledSetting struct {
+	Enabled bool `json:"enabled"`
+}
+
+// settings converts the API response to the snapshot form
+func (p protectionResponse) settings() BranchProtection {
+	bp := BranchProtection{
+		EnforceAdmins:          p.EnforceAdmins.Enabled,
+		LinearHistory:          p.RequiredLinearHistory.Enabled,
+		AllowForcePushes:       p.AllowForcePushes.Enabled,
+		AllowDeletions:         p.AllowDeletions.Enabled,
+		ConversationResolution: p.RequiredConversationResolution.Enabled,
+	}
+	if c := p.RequiredStatusChecks; c != nil {
+		bp.StatusChecks = &StatusChecks{Strict: c.Strict, Contexts: c.Contexts}
+	}
+	if r := p.RequiredPullRequestReviews; r != nil {
+		bp.Reviews = &ReviewRules{
+			RequiredApprovals:       r.RequiredApprovingReviewCount,
+			DismissStaleReviews:     r.DismissStaleReviews,
+			RequireCodeOwnerReviews: r.RequireCodeOwnerReviews,
+			RequireLastPushApproval: r.RequireLastPushApproval,
+		}
+	}
+	if r := p.Restrictions; r != nil {
+		bp.Restrictions = &PushRestrictions{}
+		for _, u := range r.Users {
+			bp.Restrictions.Users = append(bp.Restrictions.Users, u.Login)
+		}
+		for _, t := range r.Teams {
+			bp.Restrictions.Teams = append(bp.Restrictions.Teams, t.Slug)
+		}
+		for _, a := range r.Apps {
+			bp.Restrictions.Apps = append(bp.Restrictions.Apps, a.Slug)
+		}
+	}
+	return bp
+}
+
+// normalize sorts every list and replaces nil lists with empty ones, so snapshots and live settings compare
+// stably and lists are sent as [] instead of null
+func (s *RepoSettings) normalize() {
+	s.Topics = sorted(s.Topics)
+	if s.Labels == nil {
+		s.Labels = make(map[string]LabelSettings)
+	}
+	for name, l := range s.Labels {
+		l.Color = strings.ToLower(strings.TrimPrefix(l.Color, "#"))
+		s.Labels[name] = l
+	}
+	if s.Protection == nil {
+		s.Protection = make(map[string]BranchProtection)
+	}
+	for branch, bp := range s.Protection {
+		if bp.StatusChecks != nil {
+			checks := *bp.StatusChecks
+			checks.Contexts = sorted(checks.Contexts)
+			bp.StatusChecks = &checks
+		}
+		if bp.Restrictions != nil {
+			bp.Restrictions = &PushRestrictions{
+				Users: sorted(bp.Restrictions.Users),
+				Teams: sorted(bp.Restrictions.Teams),
+				Apps:  sorted(bp.Restrictions.Apps),
+			}
+		}
+		s.Protection[branch] = bp
+	}
+}
+
+// GetRepoSettings reads the settings of a repository. Only GET requests are sent.
+func (c *Client) GetRepoSettings(ctx context.Context, owner string, repo string) (*RepoSettings, error) {
+	base := fmt.Sprintf("/repos/%s/%s", owner, repo)
+
+	var info struct {
+		DefaultBranch string   `json:"default_branch"`
+		Topics        []string `json:"topics"`
+		MergeSettings
+	}
+	if err := c.Get(ctx, base, &info); err != nil {
+		return nil, err
+	}
+	settings := &RepoSettings{
+		DefaultBranch: info.DefaultBranch,
+		Merge:         info.MergeSettings,
+		Topics:        info.Topics,
+		Labels:        make(map[string]LabelSettings),
+		Protection:    make(map[string]BranchProtection),
+	}
+
+	var labels []struct {
+		Name string `json:"name"`
+		LabelSettings
+	}
+	if err := c.Get(ctx, base+"/labels?per_page=100", &labels); err != nil {
+		return nil, fmt.Errorf("failed to list labels: %w", err)
+	}
+	for _, l := range labels {
+		settings.Labels[l.Name] = l.LabelSettings
+	}
+
+	var branches []struct {
+		Name string `json:"name"`
+	}
+	if err := c.Get(ctx, base+"/branches?protected=true&per_page=100", &branches); err != nil {
+		return nil, fmt.Errorf("failed to list protected branches: %w", err)
+	}
+	for _, b := range branches {
+		var protection protectionResponse
+		if err := c.Get(ctx, base+"/branches/"+url.PathEscape(b.Name)+"/protection", &protection); err != nil {
+			return nil, fmt.Errorf("failed to read the protection of %s: %w", b.Name, err)
+		}
+		settings.Protection[b.Name] = protection.settings()
+	}
+	settings.normalize()
+	return settings, nil
+}
+
+// settingsChange is one write needed to bring a repository in line with a snapshot
+type settingsChange struct {
+	Description string
+	Method      string
+	Path        string
+	Body        any
+}
+
+// planSettings lists the writes that turn have into want, both normalized. Labels are created and updated before they are
+// deleted and the default branch changes before protections so a protected branch always exists.
+func planSettings(owner string, repo string, want *RepoSettings, have *RepoSettings) []settingsChange {
+	base := fmt.Sprintf("/repos/%s/%s", owner, repo)
+	var changes []settingsChange
+
+	for _, name := range sortedKeys(want.Labels) {
+		label, ok := have.Labels[name]
+		switch {
+		case !ok:
+			body := struct {
+				Name string `json:"name"`
+				LabelSettings
+			}{name, want.Labels[name]}
+			changes = append(changes, settingsChange{"create label " + name, "POST", base + "/labels", body})
+		case label != want.Labels[name]:
+			changes = append(changes, settingsChange{"update label " + name, "PATCH", base + "/labels/" + url.PathEscape(name), want.Labels[name]})
+		}
+	}
+	for _, name := range sortedKeys(have.Labels) {
+		if _, ok := want.Labels[name]; !ok {
+			changes = append(changes, settingsChange{"delete label " + name, "DELETE", base + "/labels/" + url.PathEscape(name), nil})
+		}
+	}
+
+	if !slices.Equal(want.Topics, have.Topics) {
+		body := struct {
+			Names []string `json:"names"`
+		}{want.Topics}
+		changes = append(changes, settingsChange{"replace topics", "PUT", base + "/topics", body})
+	}
+
+	if want.DefaultBranch != have.DefaultBranch || want.Merge != have.Merge {
+		body := struct {
+			DefaultBranch string `json:"default_branch,omitempty"`
+			MergeSettings
+		}{want.DefaultBranch, want.Merge}
+		changes = append(changes, settingsChange{"update default branch and merge settings", "PATCH", base, body})
+	}
+
+	for _, branch := range sortedKeys(want.Protection) {
+		if current, ok := have.Protection[branch]; !ok || !reflect.DeepEqual(current, want.Protection[branch]) {
+			changes = append(changes, settingsChange{"protect " + branch, "PUT", base + "/branches/" + url.PathEscape(branch) + "/protection", want.Protection[branch]})
+		}
+	}
+	for _, branch := range sortedKeys(have.Protection) {
+		if _, ok := want.Protection[branch]; !ok {
+			changes = append(changes, settingsChange{"unprotect " + branch, "DELETE", base + "/branches/" + url.PathEscape(branch) + "/protection", nil})
+		}
+	}
+	return changes
+}
+
+// settingsDrift is a field whose value in the repository differs from the snapshot
+type settingsDrift struct {
+	Field    string
+	Snapshot string
+	Repo     string
+}
+
+// unsetValue stands for a field missing on one side of a settingsDrift
+const unsetValue = "(unset)"
+
+// diffSettings compares two settings field by field. Fields missing on one side are reported as (unset).
+func diffSettings(want *RepoSettings, have *RepoSettings) ([]settingsDrift, error) {
+	wantFields, err := flattenSettings(want)
+	if err != nil {
+		return nil, err
+	}
+	haveFields, err := flattenSettings(have)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]bool)
+	for f := range wantFields {
+		fields[f] = true
+	}
+	for f := range haveFields {
+		fields[f] = true
+	}
+
+	var drift []settingsDrift
+	for _, f := range sortedKeys(fields) {
+		w, wok := wantFields[f]
+		h, hok := haveFields[f]
+		if wok && hok && w == h {
+			continue
+		}
+		if !wok {
+			w = unsetValue
+		}
+		if !hok {
+			h = unsetValue
+		}
+		drift = append(drift, settingsDrift{Field: f, Snapshot: w, Repo: h})
+	}
+	return drift, nil
+}
+
+// driftChanges is the drift as the change set gh repo check renders, from the snapshot to the repository
+func driftChanges(drift []settingsDrift) []diffout.Change {
+	changes := make([]diffout.Change, len(drift))
+	for i, d := range drift {
+		c := diffout.Change{Key: d.Field, Kind: diffout.Modified, Before: d.Snapshot, After: d.Repo}
+		switch {
+		case d.Snapshot == unsetValue:
+			c.Kind, c.Before = diffout.Added, ""
+		case d.Repo == unsetValue:
+			c.Kind, c.After = diffout.Removed, ""
+		}
+		changes[i] = c
+	}
+	return changes
+}
+
+// flattenSettings maps the dotted path of every scalar in the YAML form of s to its value.
+// Lists are compared as a whole.
+func flattenSettings(s *RepoSettings) (map[string]string, error) {
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	var tree map[string]any
+	if err := yaml.Unmarshal(data, &tree); err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]string)
+	var walk func(prefix string, v any)
+	walk = func(prefix string, v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			for k, child := range v {
+				walk(prefix+"."+k, child)
+			}
+		case []any:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			fields[prefix] = "[" + strings.Join(items, ", ") + "]"
+		case nil:
+		case string:
+			fields[prefix] = strconv.Quote(v)
+		default:
+			fields[prefix] = fmt.Sprint(v)
+		}
+	}
+	for k, v := range tree {
+		walk(k, v)
+	}
+	return fields, nil
+}
+
+// sorted returns a sorted copy of names that is never nil, so empty lists marshal as []
+func sorted(names []string) []string {
+	out := append([]string{}, names...)
+	slices.Sort(out)
+	return out
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
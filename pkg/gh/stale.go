@@ -0,0 +1,357 @@
+package gh
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/lockfile"
+	"gsn-dev-tools/internals/notify"
+	"gsn-dev-tools/internals/output"
+	"gsn-dev-tools/internals/paths"
+	"gsn-dev-tools/internals/state"
+	"gsn-dev-tools/internals/style"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	// snoozeFileName is the file below the gsn state dir holding the snoozed review requests
+	snoozeFileName = "gh-snoozes.json"
+
+	// snoozeLockWait bounds how long a snooze waits for another gsn process writing the file
+	snoozeLockWait = 5 * time.Second
+)
+
+// snoozeKind versions the snoozed review requests
+var snoozeKind = state.Register(&state.Kind{
+	Name:     "review snoozes",
+	Format:   state.JSON,
+	Version:  1,
+	Patterns: []string{snoozeFileName},
+	Files: func() ([]string, error) {
+		path, err := snoozePath()
+		if err != nil {
+			return nil, err
+		}
+		return []string{path}, nil
+	},
+})
+
+// snoozeList maps pull requests, as owner/repo#N, to the time their snooze ends
+type snoozeList struct {
+	SchemaVersion int                  `json:"schema_version"`
+	Snoozes       map[string]time.Time `json:"snoozes"`
+}
+
+func snoozePath() (string, error) {
+	dir, err := paths.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, snoozeFileName), nil
+}
+
+// loadSnoozes reads the snoozes, a missing file has none
+func loadSnoozes() (snoozeList, error) {
+	list := snoozeList{Snoozes: map[string]time.Time{}}
+	path, err := snoozePath()
+	if err != nil {
+		return list, err
+	}
+	doc, err := snoozeKind.Load(path, &list)
+	if errors.Is(err, fs.ErrNotExist) {
+		return list, nil
+	}
+	if err != nil {
+		return list, err
+	}
+	if err := doc.Writable(); err != nil {
+		return list, err
+	}
+	if list.Snoozes == nil {
+		list.Snoozes = map[string]time.Time{}
+	}
+	return list, nil
+}
+
+// until returns when the snooze of ref ends, false when it is not snoozed at now
+func (l snoozeList) until(ref string, now time.Time) (time.Time, bool) {
+	end, ok := l.Snoozes[ref]
+	return end, ok && now.Before(end)
+}
+
+// updateSnoozes applies change to the snoozes read afresh under their lock and saves them without the expired ones
+func updateSnoozes(change func(list *snoozeList)) error {
+	path, err := snoozePath()
+	if err != nil {
+		return err
+	}
+	unlock, err := lockfile.Acquire(path+".lock", path, snoozeLockWait)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	list, err := loadSnoozes()
+	if err != nil {
+		return err
+	}
+	change(&list)
+	now := time.Now()
+	for ref, end := range list.Snoozes {
+		if !now.Before(end) {
+			delete(list.Snoozes, ref)
+		}
+	}
+
+	list.SchemaVersion = snoozeKind.Version
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return output.WriteFileAtomic(path, append(data, '\n'), 0o600)
+}
+
+// staleReview is a review request waiting on the authenticated user
+type staleReview struct {
+	Ref         PRRef
+	PR          PRDetails
+	RequestedAt time.Time
+}
+
+func staleCmd() *cobra.Command {
+	var maxAge string
+	var quiet bool
+
+	staleCmd := &cobra.Command{
+		Use:   "stale",
+		Short: "List the review requests waiting on you for too long",
+		Long: `Lists the open pull requests waiting for your review since longer than --max-age, oldest first. The age counts
+from the last time your review was requested, or your team's when it was not you, and from the creation of the
+pull request when neither is found. Pull requests snoozed with gsn pr stale snooze are left out until the snooze
+ends.
+
+gsn pr stale exits with 4 when any review request is stale and 0 when none is, so a shell prompt or a cron job
+can check it; other errors exit with their own code. With --notify the stale requests are also sent to a
+webhook, Slack or the desktop, only when there are some.`,
+		Example: `  gsn pr stale
+  gsn pr stale --max-age 3d --notify desktop
+  gsn pr stale -q || echo "reviews are waiting"`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			threshold, err := parsePeriod("--max-age", maxAge)
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			start := time.Now()
+
+			client, err := NewClient()
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			var user struct {
+				Login string `json:"login"`
+			}
+			if err := client.Get(cmd.Context(), "/user", &user); err != nil {
+				clierr.Fatalf("failed to look up the authenticated user: %v", err)
+			}
+			snoozes, err := loadSnoozes()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, style.Warning()+"Ignoring the snoozes: %v\n", err)
+			}
+
+			stale, snoozed, err := staleReviews(cmd.Context(), client, user.Login, threshold, snoozes, start)
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			if !quiet {
+				printStaleReviews(stale, snoozed, threshold, start)
+			}
+			if len(stale) == 0 {
+				return
+			}
+
+			ev := notify.NewEvent("pr stale", start, fmt.Errorf("%d review request(s) waiting for over %s", len(stale), formatAge(threshold)))
+			ev.Counts = map[string]int{"stale": len(stale), "snoozed": snoozed}
+			notify.Finish(cmd, ev)
+			clierr.Exit(clierr.Conflict)
+		},
+	}
+
+	staleCmd.Flags().StringVar(&maxAge, "max-age", "48h", "Age from which a review request is stale, e.g. 48h or 3d")
+	staleCmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "Print nothing, only exit with 4 when a review request is stale")
+	notify.AddFlag(staleCmd)
+	dryrun.ReadOnly(staleCmd)
+	staleCmd.AddCommand(snoozeCmd())
+	return staleCmd
+}
+
+// staleReviews returns the review requests of login older than threshold at now, oldest first, and how many were
+// left out as snoozed. Only pull requests created before the threshold can be stale, so only their events are
+// looked up, through the shared client.
+func staleReviews(ctx context.Context, client *Client, login string, threshold time.Duration, snoozes snoozeList, now time.Time) ([]staleReview, int, error) {
+	prs, err := client.ReviewQueue(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to load your review queue: %w", err)
+	}
+
+	var candidates []*staleReview
+	snoozed := 0
+	for _, pr := range prs {
+		ref, err := ParsePRURL(pr.HTMLURL)
+		if err != nil {
+			continue
+		}
+		if _, ok := snoozes.until(ref.String(), now); ok {
+			snoozed++
+			continue
+		}
+		if now.Sub(pr.CreatedAt) >= threshold {
+			candidates = append(candidates, &staleReview{Ref: ref, PR: pr, RequestedAt: pr.CreatedAt})
+		}
+	}
+
+	results := runBatch(ctx, candidates, func(r *staleReview) string { return r.Ref.String() },
+		batchOptions{Concurrency: defaultConcurrency, Quiet: true},
+		func(ctx context.Context, r *staleReview) (string, error) {
+			at, err := reviewRequestedAt(ctx, client, r.Ref, login)
+			if err == nil && !at.IsZero() {
+				r.RequestedAt = at
+			}
+			return "", err
+		})
+	for _, r := range results {
+		if r.Err != nil {
+			fmt.Fprintf(os.Stderr, style.Warning()+"Aging %s from its creation, its review requests could not be read: %v\n", r.Name, r.Err)
+		}
+	}
+
+	var stale []staleReview
+	for _, c := range candidates {
+		if now.Sub(c.RequestedAt) >= threshold {
+			stale = append(stale, *c)
+		}
+	}
+	sort.SliceStable(stale, func(i, j int) bool { return stale[i].RequestedAt.Before(stale[j].RequestedAt) })
+	return stale, snoozed, nil
+}
+
+// reviewRequestedAt returns when the review of login was last requested on ref, or that of a team when login was
+// never requested by name. It is zero when the events show neither.
+func reviewRequestedAt(ctx context.Context, client *Client, ref PRRef, login string) (time.Time, error) {
+	var byName, byTeam time.Time
+	path := fmt.Sprintf("/repos/%s/%s/issues/%d/events?per_page=100", ref.Owner, ref.Repo, ref.Number)
+	err := client.GetPages(ctx, path, func(body []byte) (bool, error) {
+		var events []struct {
+			Event             string    `json:"event"`
+			CreatedAt         time.Time `json:"created_at"`
+			RequestedReviewer *struct {
+				Login string `json:"login"`
+			} `json:"requested_reviewer"`
+			RequestedTeam *struct {
+				Slug string `json:"slug"`
+			} `json:"requested_team"`
+		}
+		if err := json.Unmarshal(body, &events); err != nil {
+			return false, err
+		}
+		for _, e := range events {
+			if e.Event != "review_requested" {
+				continue
+			}
+			switch {
+			case e.RequestedReviewer != nil && strings.EqualFold(e.RequestedReviewer.Login, login):
+				byName = e.CreatedAt
+			case e.RequestedTeam != nil:
+				byTeam = e.CreatedAt
+			}
+		}
+		return true, nil
+	})
+	if !byName.IsZero() {
+		return byName, err
+	}
+	return byTeam, err
+}
+
+// printStaleReviews lists the stale review requests with their age highlighted
+func printStaleReviews(stale []staleReview, snoozed int, threshold time.Duration, now time.Time) {
+	if len(stale) == 0 {
+		fmt.Printf(style.Success()+"No review request is waiting on you for over %s\n", formatAge(threshold))
+	}
+	for _, r := range stale {
+		age := fmt.Sprintf("%-4s", formatAge(now.Sub(r.RequestedAt)))
+		fmt.Printf("%s  %s  %s  @%s\n", style.Red(age), r.Ref, r.PR.Title, r.PR.User.Login)
+	}
+	if snoozed > 0 {
+		fmt.Fprintf(os.Stderr, "%d snoozed review request(s) left out\n", snoozed)
+	}
+}
+
+func snoozeCmd() *cobra.Command {
+	var off bool
+
+	snoozeCmd := &cobra.Command{
+		Use:   "snooze <url> [duration]",
+		Short: "Leave a pull request out of gsn pr stale for a while",
+		Long: `Records in the gsn state dir that a pull request is left out of gsn pr stale for the duration, 24h by default.
+The snooze ends by itself; snoozing again replaces it and --off ends it now. Only this machine knows about the
+snooze, the review request on GitHub stays as it is.`,
+		Example: `  gsn pr stale snooze https://github.com/owner/repo/pull/42 24h
+  gsn pr stale snooze owner/repo#42 3d
+  gsn pr stale snooze owner/repo#42 --off`,
+		Args: cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			ref, err := ParsePRURL(args[0])
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+			if off {
+				if len(args) > 1 {
+					clierr.Exitf(clierr.Usage, "--off takes no duration")
+				}
+				err := dryrun.Do("end the snooze of "+ref.String(), func() error {
+					return updateSnoozes(func(list *snoozeList) { delete(list.Snoozes, ref.String()) })
+				})
+				if err != nil {
+					clierr.Fatalf("failed to save the snooze: %v", err)
+				}
+				if !dryrun.Enabled() {
+					fmt.Printf(style.Success()+"%s is no longer snoozed\n", ref)
+				}
+				return
+			}
+
+			length := 24 * time.Hour
+			if len(args) > 1 {
+				if length, err = parsePeriod("duration", args[1]); err != nil {
+					clierr.Fatalf("%v", err)
+				}
+			}
+			end := time.Now().Add(length).Truncate(time.Second)
+			err = dryrun.Do(fmt.Sprintf("snooze %s until %s", ref, end.Format("2006-01-02 15:04")), func() error {
+				return updateSnoozes(func(list *snoozeList) { list.Snoozes[ref.String()] = end })
+			})
+			if err != nil {
+				clierr.Fatalf("failed to save the snooze: %v", err)
+			}
+			if !dryrun.Enabled() {
+				fmt.Printf(style.Success()+"Snoozed %s until %s\n", ref, end.Format("2006-01-02 15:04"))
+			}
+		},
+	}
+
+	snoozeCmd.Flags().BoolVar(&off, "off", false, "End the snooze of the pull request now")
+	dryrun.Adopt(snoozeCmd)
+	return snoozeCmd
+}
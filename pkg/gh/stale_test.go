@@ -0,0 +1,205 @@
+package gh
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnoozesPersist(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("GSN_HOME", home)
+
+	// No file, no snoozes
+	list, err := loadSnoozes()
+	if err != nil || len(list.Snoozes) != 0 {
+		t.Fatalf("loadSnoozes without a file = %+v, %v", list, err)
+	}
+
+	now := time.Now().Truncate(time.Second)
+	if err := updateSnoozes(func(l *snoozeList) {
+		l.Snoozes["owner/repo#1"] = now.Add(time.Hour)
+		l.Snoozes["owner/repo#2"] = now.Add(48 * time.Hour)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	list, err = loadSnoozes()
+	if err != nil || len(list.Snoozes) != 2 || !list.Snoozes["owner/repo#1"].Equal(now.Add(time.Hour)) {
+		t.Fatalf("snoozes read back = %+v, %v", list, err)
+	}
+	data, err := os.ReadFile(filepath.Join(home, "state", snoozeFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil || doc["schema_version"] != float64(snoozeKind.Version) {
+		t.Errorf("snooze file = %s, %v", data, err)
+	}
+
+	// Ending one snooze keeps the other
+	if err := updateSnoozes(func(l *snoozeList) { delete(l.Snoozes, "owner/repo#1") }); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ = loadSnoozes(); len(list.Snoozes) != 1 || list.Snoozes["owner/repo#2"].IsZero() {
+		t.Errorf("snoozes after ending one = %+v", list.Snoozes)
+	}
+
+	// A file written by a newer gsn is refused rather than overwritten
+	if err := os.WriteFile(filepath.Join(home, "state", snoozeFileName), []byte(`{"schema_version":99,"snoozes":{}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadSnoozes(); err == nil {
+		t.Error("loadSnoozes accepted a newer schema")
+	}
+	if err := updateSnoozes(func(l *snoozeList) {}); err == nil {
+		t.Error("updateSnoozes overwrote a newer schema")
+	}
+}
+
+func TestSnoozesExpire(t *testing.T) {
+	t.Setenv("GSN_HOME", t.TempDir())
+	now := time.Now()
+	list := snoozeList{Snoozes: map[string]time.Time{"owner/repo#1": now.Add(time.Minute)}}
+	if end, ok := list.until("owner/repo#1", now); !ok || !end.Equal(now.Add(time.Minute)) {
+		t.Errorf("until before the end = %v, %v", end, ok)
+	}
+	for _, at := range []time.Time{now.Add(time.Minute), now.Add(time.Hour)} {
+		if _, ok := list.until("owner/repo#1", at); ok {
+			t.Errorf("still snoozed at %v", at.Sub(now))
+		}
+	}
+	if _, ok := list.until("owner/repo#9", now); ok {
+		t.Error("a pull request never snoozed is snoozed")
+	}
+
+	// Expired snoozes are dropped by the next write, whatever it changes
+	if err := updateSnoozes(func(l *snoozeList) {
+		l.Snoozes["owner/repo#1"] = now.Add(-time.Second)
+		l.Snoozes["owner/repo#2"] = now.Add(time.Hour)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := loadSnoozes(); len(list.Snoozes) != 1 || list.Snoozes["owner/repo#2"].IsZero() {
+		t.Errorf("snoozes after the write = %+v", list.Snoozes)
+	}
+}
+
+// staleGitHub serves a review queue and the review request events of its pull requests, created ages before now
+type staleGitHub struct {
+	now    time.Time
+	queue  []PRDetails
+	events map[int]string
+}
+
+func (g *staleGitHub) handle(w http.ResponseWriter, r *http.Request, body []byte) {
+	if r.URL.Path == "/search/issues" {
+		_ = json.NewEncoder(w).Encode(map[string]any{"total_count": len(g.queue), "items": g.queue})
+		return
+	}
+	var n int
+	if _, err := fmt.Sscanf(r.URL.Path, "/repos/owner/repo/issues/%d/events", &n); err == nil {
+		if events, ok := g.events[n]; ok {
+			_, _ = io.WriteString(w, events)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+func (g *staleGitHub) pr(n int, age time.Duration) PRDetails {
+	pr := PRDetails{Number: n, Title: fmt.Sprintf("Change %d", n), HTMLURL: fmt.Sprintf("https://github.com/owner/repo/pull/%d", n), CreatedAt: g.now.Add(-age)}
+	pr.User.Login = "alice"
+	return pr
+}
+
+// requested is an issue event requesting the review of login, or of team when it is set, at before now
+func (g *staleGitHub) requested(ago time.Duration, login string, team string) string {
+	at := g.now.Add(-ago).UTC().Format(time.RFC3339)
+	if team != "" {
+		return fmt.Sprintf(`{"event":"review_requested","created_at":%q,"requested_team":{"slug":%q}}`, at, team)
+	}
+	return fmt.Sprintf(`{"event":"review_requested","created_at":%q,"requested_reviewer":{"login":%q}}`, at, login)
+}
+
+func TestStaleReviews(t *testing.T) {
+	g := &staleGitHub{now: time.Now().Truncate(time.Second)}
+	day := 24 * time.Hour
+	g.queue = []PRDetails{
+		g.pr(1, 10*day), // requested by name a week ago, stale
+		g.pr(2, 10*day), // requested again by name an hour ago, not stale
+		g.pr(3, 5*day),  // requested from the team only, three days ago, stale
+		g.pr(4, time.Hour),
+		g.pr(5, 20*day), // snoozed
+		g.pr(6, 30*day), // its events cannot be read, aged from its creation
+	}
+	g.events = map[int]string{
+		1: "[" + g.requested(7*day, "octo", "") + "]",
+		2: "[" + g.requested(9*day, "octo", "") + "," + g.requested(8*day, "bob", "") + "," + g.requested(time.Hour, "OCTO", "") + "]",
+		3: "[" + g.requested(3*day, "", "reviewers") + "]",
+	}
+	api := newFakeGitHub(t, g.handle)
+	snoozes := snoozeList{Snoozes: map[string]time.Time{"owner/repo#5": g.now.Add(time.Hour), "owner/repo#1": g.now.Add(-time.Hour)}}
+
+	var stale []staleReview
+	var snoozed int
+	stderr := captureStderr(t, func() {
+		var err error
+		stale, snoozed, err = staleReviews(context.Background(), api.client(io.Discard), "octo", 48*time.Hour, snoozes, g.now)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	// Oldest request first
+	var refs []string
+	for _, r := range stale {
+		refs = append(refs, r.Ref.String())
+	}
+	if want := []string{"owner/repo#6", "owner/repo#1", "owner/repo#3"}; !slices.Equal(refs, want) {
+		t.Errorf("stale = %q, want %q", refs, want)
+	}
+	if snoozed != 1 {
+		t.Errorf("snoozed = %d, want 1", snoozed)
+	}
+	if len(stale) == 3 && (!stale[1].RequestedAt.Equal(g.now.Add(-7*day)) || !stale[2].RequestedAt.Equal(g.now.Add(-3*day)) || !stale[0].RequestedAt.Equal(g.now.Add(-30*day))) {
+		t.Errorf("requested at = %v, %v, %v", stale[0].RequestedAt, stale[1].RequestedAt, stale[2].RequestedAt)
+	}
+	if !strings.Contains(stderr, "Aging owner/repo#6 from its creation") {
+		t.Errorf("no warning about the unreadable events:\n%s", stderr)
+	}
+
+	// Neither the young nor the snoozed pull request was looked up
+	for _, call := range api.requests {
+		if strings.Contains(call, "/issues/4/") || strings.Contains(call, "/issues/5/") {
+			t.Errorf("looked up %s", call)
+		}
+	}
+}
+
+func TestReviewRequestedAt(t *testing.T) {
+	g := &staleGitHub{now: time.Now().Truncate(time.Second)}
+	g.events = map[int]string{
+		// The latest request by name wins over any team request
+		1: "[" + g.requested(5*time.Hour, "", "core") + "," + g.requested(4*time.Hour, "octo", "") + "," + g.requested(time.Hour, "", "core") + "]",
+		// The latest team request when never requested by name
+		2: "[" + g.requested(5*time.Hour, "", "core") + "," + g.requested(2*time.Hour, "", "docs") + "," + g.requested(time.Hour, "bob", "") + "]",
+		// Other events and other reviewers do not count
+		3: `[{"event":"labeled","created_at":"2024-01-01T00:00:00Z"},` + g.requested(time.Hour, "bob", "") + "]",
+	}
+	api := newFakeGitHub(t, g.handle)
+	tests := map[int]time.Time{1: g.now.Add(-4 * time.Hour), 2: g.now.Add(-2 * time.Hour), 3: {}}
+	for n, want := range tests {
+		got, err := reviewRequestedAt(context.Background(), api.client(io.Discard), PRRef{Owner: "owner", Repo: "repo", Number: n}, "octo")
+		if err != nil || !got.Equal(want) {
+			t.Errorf("#%d requested at %v, %v, want %v", n, got, err, want)
+		}
+	}
+}
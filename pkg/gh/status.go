@@ -0,0 +1,102 @@
+package gh
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// statusStates are the states the commit statuses API accepts
+var statusStates = []string{"error", "failure", "pending", "success"}
+
+const (
+	// maxStatusContext is the longest context GitHub stores for a commit status
+	maxStatusContext = 255
+	// maxStatusDescription is the longest description GitHub accepts for a commit status
+	maxStatusDescription = 140
+)
+
+// statusRequest is the payload of the create commit status endpoint
+type statusRequest struct {
+	State       string `json:"state"`
+	Context     string `json:"context"`
+	Description string `json:"description,omitempty"`
+	TargetURL   string `json:"target_url,omitempty"`
+}
+
+// CommitStatus is a commit status or check run reported for a commit
+type CommitStatus struct {
+	Kind        string
+	Context     string
+	State       string
+	Description string
+	URL         string
+	UpdatedAt   time.Time
+}
+
+// CreateStatus sets the status of a commit for one context
+func (c *Client) CreateStatus(ctx context.Context, owner string, repo string, sha string, req statusRequest) error {
+	path := fmt.Sprintf("/repos/%s/%s/statuses/%s", owner, repo, sha)
+	return c.Write(ctx, "POST", path, req, nil)
+}
+
+// ListStatuses fetches the latest status of every context and every check run of a commit
+func (c *Client) ListStatuses(ctx context.Context, owner string, repo string, sha string) ([]CommitStatus, error) {
+	var combined struct {
+		Statuses []struct {
+			Context     string    `json:"context"`
+			State       string    `json:"state"`
+			Description string    `json:"description"`
+			TargetURL   string    `json:"target_url"`
+			UpdatedAt   time.Time `json:"updated_at"`
+		} `json:"statuses"`
+	}
+	if err := c.Get(ctx, fmt.Sprintf("/repos/%s/%s/commits/%s/status?per_page=100", owner, repo, sha), &combined); err != nil {
+		return nil, err
+	}
+
+	var checks struct {
+		CheckRuns []struct {
+			Name        string    `json:"name"`
+			Status      string    `json:"status"`
+			Conclusion  string    `json:"conclusion"`
+			DetailsURL  string    `json:"details_url"`
+			StartedAt   time.Time `json:"started_at"`
+			CompletedAt time.Time `json:"completed_at"`
+			Output      struct {
+				Title string `json:"title"`
+			} `json:"output"`
+		} `json:"check_runs"`
+	}
+	if err := c.Get(ctx, fmt.Sprintf("/repos/%s/%s/commits/%s/check-runs?per_page=100", owner, repo, sha), &checks); err != nil {
+		return nil, err
+	}
+
+	var statuses []CommitStatus
+	for _, s := range combined.Statuses {
+		statuses = append(statuses, CommitStatus{
+			Kind:        "status",
+			Context:     s.Context,
+			State:       s.State,
+			Description: s.Description,
+			URL:         s.TargetURL,
+			UpdatedAt:   s.UpdatedAt,
+		})
+	}
+	for _, r := range checks.CheckRuns {
+		// A check run only has a conclusion once it completed
+		state, updated := r.Status, r.StartedAt
+		if r.Status == "completed" {
+			state, updated = r.Conclusion, r.CompletedAt
+		}
+		statuses = append(statuses, CommitStatus{
+			Kind:        "check",
+			Context:     r.Name,
+			State:       state,
+			Description: r.Output.Title,
+			URL:         r.DetailsURL,
+			UpdatedAt:   updated,
+		})
+	}
+	return statuses, nil
+}
@@ -0,0 +1,190 @@
+package gh
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"gsn-dev-tools/internals/clierr"
+	"gsn-dev-tools/internals/dryrun"
+	"gsn-dev-tools/internals/output"
+	"gsn-dev-tools/internals/style"
+
+	"github.com/spf13/cobra"
+)
+
+// fullSHA matches a complete commit SHA, anything else is resolved with git rev-parse
+var fullSHA = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+var statusColumns = []output.Column[CommitStatus]{
+	{Name: "kind", Value: func(s CommitStatus) any { return s.Kind }},
+	{Name: "context", Value: func(s CommitStatus) any { return s.Context }},
+	{Name: "state", Value: func(s CommitStatus) any { return s.State }},
+	{Name: "description", Value: func(s CommitStatus) any { return s.Description }},
+	{
+		Name:    "age",
+		Value:   func(s CommitStatus) any { return time.Since(s.UpdatedAt) },
+		Display: func(s CommitStatus) string { return formatAge(time.Since(s.UpdatedAt)) },
+	},
+	{Name: "url", Value: func(s CommitStatus) any { return s.URL }},
+}
+
+func statusCmd() *cobra.Command {
+	statusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "Report and inspect commit statuses",
+		Long: `Reports the result of checks that run outside GitHub Actions as commit statuses and lists the statuses and
+check runs of a commit. A pull request URL or owner/repo#N reference stands for the head commit of the pull request.`,
+		Example: `  gsn gh status set HEAD --context lint --state success
+  gsn gh status get https://github.com/owner/repo/pull/42`,
+	}
+
+	statusCmd.AddCommand(setStatusCmd())
+	statusCmd.AddCommand(getStatusCmd())
+	return statusCmd
+}
+
+func setStatusCmd() *cobra.Command {
+	var repo, statusContext, state, description, targetURL string
+
+	setCmd := &cobra.Command{
+		Use:   "set <SHA|PR_URL>",
+		Short: "Set the status of a commit for one context",
+		Long: `Posts a commit status for --context. The state must be error, failure, pending or success, the context is
+limited to 255 characters, the description to 140 and the target URL must be an http or https URL.`,
+		Example: `  gsn gh status set HEAD --context perf --state pending --description "Benchmarks running"
+  gsn gh status set owner/repo#42 --context perf --state failure --target-url https://ci.example.com/runs/7`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			req := statusRequest{State: state, Context: statusContext, Description: description, TargetURL: targetURL}
+			if err := validateStatus(req); err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			client, err := NewClient("repo")
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			owner, name, sha, err := resolveCommit(cmd.Context(), client, repo, args[0])
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			if err := client.CreateStatus(cmd.Context(), owner, name, sha, req); err != nil {
+				clierr.Fatalf("Failed to set status: %v", err)
+			}
+			if !client.DryRun {
+				fmt.Printf(style.Success()+"Set %s to %s on %s/%s@%s\n", statusContext, state, owner, name, sha[:min(len(sha), 7)])
+			}
+		},
+	}
+
+	setCmd.Flags().StringVarP(&repo, "repo", "R", "", "Repository in owner/repo format (defaults to the origin remote)")
+	_ = setCmd.RegisterFlagCompletionFunc("repo", completeRepos)
+	setCmd.Flags().StringVar(&statusContext, "context", "", "Name that identifies the check, such as ci/perf")
+	setCmd.Flags().StringVar(&state, "state", "", "Status state: error, failure, pending or success")
+	setCmd.Flags().StringVarP(&description, "description", "d", "", "Short description shown next to the status")
+	setCmd.Flags().StringVar(&targetURL, "target-url", "", "Link to the details of the check")
+	setCmd.MarkFlagRequired("context")
+	setCmd.MarkFlagRequired("state")
+	dryrun.Adopt(setCmd)
+	return setCmd
+}
+
+func getStatusCmd() *cobra.Command {
+	var repo string
+
+	getCmd := &cobra.Command{
+		Use:   "get <SHA|PR_URL>",
+		Short: "List the statuses and check runs of a commit",
+		Long:  "Lists the latest status of every context and every check run reported for a commit, with their states.",
+		Example: `  gsn gh status get HEAD
+  gsn gh status get https://github.com/owner/repo/pull/42 --sort state --csv`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			opts, err := output.OptionsFromFlags(cmd)
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			client, err := NewClient("repo")
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			owner, name, sha, err := resolveCommit(cmd.Context(), client, repo, args[0])
+			if err != nil {
+				clierr.Fatalf("%v", err)
+			}
+
+			statuses, err := client.ListStatuses(cmd.Context(), owner, name, sha)
+			if err != nil {
+				clierr.Fatalf("Failed to list statuses: %v", err)
+			}
+
+			if err := output.Render(os.Stdout, statusColumns, statuses, opts); err != nil {
+				clierr.Fatalf("%v", err)
+			}
+		},
+	}
+
+	getCmd.Flags().StringVarP(&repo, "repo", "R", "", "Repository in owner/repo format (defaults to the origin remote)")
+	_ = getCmd.RegisterFlagCompletionFunc("repo", completeRepos)
+	output.AddFlags(getCmd)
+	return getCmd
+}
+
+// validateStatus checks a status against the limits of the API before anything is sent
+func validateStatus(req statusRequest) error {
+	if !slices.Contains(statusStates, req.State) {
+		return fmt.Errorf("invalid --state '%s' (use %s)", req.State, strings.Join(statusStates, ", "))
+	}
+	if req.Context == "" || len(req.Context) > maxStatusContext {
+		return fmt.Errorf("--context must be between 1 and %d characters", maxStatusContext)
+	}
+	if len(req.Description) > maxStatusDescription {
+		return fmt.Errorf("--description is %d characters, the limit is %d", len(req.Description), maxStatusDescription)
+	}
+	if req.TargetURL != "" {
+		u, err := url.Parse(req.TargetURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("--target-url '%s' is not an http or https URL", req.TargetURL)
+		}
+	}
+	return nil
+}
+
+// resolveCommit returns the repository and full SHA a target stands for. Pull request references resolve to the
+// head commit of the pull request, anything else is a commit of --repo or the origin remote.
+func resolveCommit(ctx context.Context, client *Client, repo string, target string) (string, string, string, error) {
+	if strings.Contains(target, "#") || strings.Contains(target, "://") {
+		ref, err := ParsePRURL(target)
+		if err != nil {
+			return "", "", "", err
+		}
+		pr, err := client.GetPR(ctx, ref)
+		if err != nil {
+			return "", "", "", fmt.Errorf("failed to fetch %s: %w", ref, err)
+		}
+		return ref.Owner, ref.Repo, pr.Head.SHA, nil
+	}
+
+	owner, name, err := resolveRepo(ctx, repo)
+	if err != nil {
+		return "", "", "", err
+	}
+	sha := strings.ToLower(target)
+	if !fullSHA.MatchString(sha) {
+		// Short SHAs, branches and HEAD are expanded by the local clone
+		if sha, err = runGit(ctx, "rev-parse", "--verify", target+"^{commit}"); err != nil {
+			return "", "", "", fmt.Errorf("'%s' is not a commit of the local repository: %w", target, err)
+		}
+	}
+	return owner, name, sha, nil
+}
@@ -0,0 +1,162 @@
+package gh
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"gsn-dev-tools/internals/execx"
+)
+
+func TestValidateStatus(t *testing.T) {
+	valid := statusRequest{State: "success", Context: "ci/lint"}
+	tests := []struct {
+		name    string
+		change  func(r *statusRequest)
+		wantErr string
+	}{
+		{"valid", func(r *statusRequest) {}, ""},
+		{"every state", func(r *statusRequest) { r.State = "error" }, ""},
+		{"unknown state", func(r *statusRequest) { r.State = "passed" }, "invalid --state 'passed' (use error, failure, pending, success)"},
+		{"empty context", func(r *statusRequest) { r.Context = "" }, "--context must be between 1 and 255 characters"},
+		{"longest context", func(r *statusRequest) { r.Context = strings.Repeat("c", 255) }, ""},
+		{"long context", func(r *statusRequest) { r.Context = strings.Repeat("c", 256) }, "--context must be between 1 and 255 characters"},
+		{"longest description", func(r *statusRequest) { r.Description = strings.Repeat("d", 140) }, ""},
+		{"long description", func(r *statusRequest) { r.Description = strings.Repeat("d", 141) }, "--description is 141 characters, the limit is 140"},
+		{"https target", func(r *statusRequest) { r.TargetURL = "https://ci.example.com/runs/7" }, ""},
+		{"relative target", func(r *statusRequest) { r.TargetURL = "/runs/7" }, "is not an http or https URL"},
+		{"other scheme", func(r *statusRequest) { r.TargetURL = "ftp://ci.example.com" }, "is not an http or https URL"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := valid
+			test.change(&req)
+			err := validateStatus(req)
+			if test.wantErr == "" && err != nil {
+				t.Errorf("validateStatus = %v", err)
+			}
+			if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Errorf("validateStatus = %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestCreateStatusPayload(t *testing.T) {
+	var method, path, payload string
+	server := newFakeGitHub(t, func(w http.ResponseWriter, r *http.Request, body []byte) {
+		method, path, payload = r.Method, r.URL.Path, string(body)
+		w.WriteHeader(http.StatusCreated)
+	})
+	client := server.client(nil)
+	sha := strings.Repeat("ab", 20)
+
+	tests := []struct {
+		req  statusRequest
+		want string
+	}{
+		{statusRequest{State: "pending", Context: "perf"}, `{"state":"pending","context":"perf"}`},
+		{statusRequest{State: "failure", Context: "ci/perf", Description: "3% slower", TargetURL: "https://ci.example.com/runs/7"},
+			`{"state":"failure","context":"ci/perf","description":"3% slower","target_url":"https://ci.example.com/runs/7"}`},
+	}
+	for _, test := range tests {
+		if err := client.CreateStatus(context.Background(), "owner", "repo", sha, test.req); err != nil {
+			t.Fatal(err)
+		}
+		if method != http.MethodPost || path != "/repos/owner/repo/statuses/"+sha || strings.TrimSpace(payload) != test.want {
+			t.Errorf("sent %s %s %s, want POST the payload %s", method, path, payload, test.want)
+		}
+	}
+}
+
+func TestResolveCommit(t *testing.T) {
+	// --repo records the repository for completion, in a state dir of the test
+	t.Setenv("GSN_HOME", t.TempDir())
+	server := newFakeGitHub(t, pullRequests)
+	client := server.client(nil)
+	sha := strings.Repeat("0", 39) + "2"
+
+	tests := []struct {
+		name     string
+		repo     string
+		target   string
+		expect   func(f *execx.Fake)
+		want     string
+		wantRepo string
+	}{
+		{"pull request URL", "", "https://github.com/owner/repo/pull/2", nil, sha, "owner/repo"},
+		{"pull request shorthand", "other/ignored", "owner/repo#2", nil, sha, "owner/repo"},
+		{"full SHA", "owner/repo", strings.ToUpper(strings.Repeat("ab", 20)), nil, strings.Repeat("ab", 20), "owner/repo"},
+		{"short SHA", "owner/repo", "abc1234", func(f *execx.Fake) {
+			f.Expect("git", "rev-parse", "--verify", "abc1234^{commit}").Return(strings.Repeat("c", 40)+"\n", 0)
+		}, strings.Repeat("c", 40), "owner/repo"},
+		{"HEAD of origin", "", "HEAD", func(f *execx.Fake) {
+			f.Expect("git", "remote", "get-url", "origin").Return("git@github.com:acme/tool.git\n", 0)
+			f.Expect("git", "rev-parse", "--verify", "HEAD^{commit}").Return(strings.Repeat("d", 40)+"\n", 0)
+		}, strings.Repeat("d", 40), "acme/tool"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := &execx.Fake{InOrder: true}
+			useFakeRunner(t, fake)
+			if test.expect != nil {
+				test.expect(fake)
+			}
+			owner, name, got, err := resolveCommit(context.Background(), client, test.repo, test.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want || owner+"/"+name != test.wantRepo {
+				t.Errorf("resolveCommit = %s/%s@%s, want %s@%s", owner, name, got, test.wantRepo, test.want)
+			}
+			if err := fake.Verify(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+
+	fake := &execx.Fake{}
+	useFakeRunner(t, fake)
+	fake.Expect("git", "rev-parse", execx.Rest()).Return("", 128).Stderr("fatal: Needed a single revision")
+	if _, _, _, err := resolveCommit(context.Background(), client, "owner/repo", "nope"); err == nil || !strings.Contains(err.Error(), "'nope' is not a commit of the local repository") {
+		t.Errorf("unknown revision = %v", err)
+	}
+	if _, _, _, err := resolveCommit(context.Background(), client, "", "owner/repo#99x"); err == nil {
+		t.Error("resolved an invalid pull request reference")
+	}
+}
+
+func TestListStatuses(t *testing.T) {
+	started := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	server := newFakeGitHub(t, func(w http.ResponseWriter, r *http.Request, body []byte) {
+		switch r.URL.Path {
+		case "/repos/owner/repo/commits/abc/status":
+			w.Write([]byte(`{"statuses":[{"context":"perf","state":"failure","description":"3% slower","target_url":"https://ci/7","updated_at":"2024-05-01T12:05:00Z"}]}`))
+		case "/repos/owner/repo/commits/abc/check-runs":
+			w.Write([]byte(`{"check_runs":[
+				{"name":"test","status":"completed","conclusion":"success","started_at":"2024-05-01T12:00:00Z","completed_at":"2024-05-01T12:10:00Z","output":{"title":"42 passed"}},
+				{"name":"lint","status":"in_progress","started_at":"2024-05-01T12:00:00Z"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	statuses, err := server.client(nil).ListStatuses(context.Background(), "owner", "repo", "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []CommitStatus{
+		{Kind: "status", Context: "perf", State: "failure", Description: "3% slower", URL: "https://ci/7", UpdatedAt: started.Add(5 * time.Minute)},
+		{Kind: "check", Context: "test", State: "success", Description: "42 passed", UpdatedAt: started.Add(10 * time.Minute)},
+		{Kind: "check", Context: "lint", State: "in_progress", UpdatedAt: started},
+	}
+	if len(statuses) != len(want) {
+		t.Fatalf("statuses = %+v", statuses)
+	}
+	for i := range want {
+		if statuses[i] != want[i] {
+			t.Errorf("status %d = %+v, want %+v", i, statuses[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package gh
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// issueTemplateDir is where GitHub looks for issue templates inside a repository
+const issueTemplateDir = ".github/ISSUE_TEMPLATE"
+
+// issueTemplate is a markdown issue template with its YAML front matter
+type issueTemplate struct {
+	File      string
+	Name      string   `yaml:"name"`
+	About     string   `yaml:"about"`
+	Title     string   `yaml:"title"`
+	Labels    nameList `yaml:"labels"`
+	Assignees nameList `yaml:"assignees"`
+	Body      string   `yaml:"-"`
+}
+
+// nameList accepts both a YAML list and the comma separated string GitHub also allows
+type nameList []string
+
+func (l *nameList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		for _, name := range strings.Split(node.Value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				*l = append(*l, name)
+			}
+		}
+		return nil
+	}
+	var names []string
+	if err := node.Decode(&names); err != nil {
+		return err
+	}
+	*l = names
+	return nil
+}
+
+// loadIssueTemplates reads the markdown templates of the repository at root, YAML issue forms are skipped
+func loadIssueTemplates(root string) ([]issueTemplate, error) {
+	files, err := filepath.Glob(filepath.Join(root, issueTemplateDir, "*.md"))
+	if err != nil {
+		return nil, err
+	}
+
+	var templates []issueTemplate
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		t, err := parseIssueTemplate(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid issue template '%s': %w", file, err)
+		}
+		t.File = file
+		if t.Name == "" {
+			t.Name = strings.TrimSuffix(filepath.Base(file), ".md")
+		}
+		templates = append(templates, t)
+	}
+	return templates, nil
+}
+
+// parseIssueTemplate splits the optional front matter from the template body
+func parseIssueTemplate(data []byte) (issueTemplate, error) {
+	var t issueTemplate
+	content := strings.ReplaceAll(string(data), "\r\n", "\n")
+	if rest, ok := strings.CutPrefix(content, "---\n"); ok {
+		frontMatter, body, found := strings.Cut(rest, "\n---\n")
+		if !found {
+			return t, fmt.Errorf("unterminated front matter")
+		}
+		if err := yaml.Unmarshal([]byte(frontMatter), &t); err != nil {
+			return t, err
+		}
+		content = body
+	}
+	t.Body = strings.TrimLeft(content, "\n")
+	return t, nil
+}
+
+// findIssueTemplate picks a template by file name or display name, case insensitive
+func findIssueTemplate(templates []issueTemplate, name string) (*issueTemplate, error) {
+	var names []string
+	for i, t := range templates {
+		base := strings.TrimSuffix(filepath.Base(t.File), ".md")
+		if strings.EqualFold(base, name) || strings.EqualFold(t.Name, name) {
+			return &templates[i], nil
+		}
+		names = append(names, base)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no issue templates found in %s", issueTemplateDir)
+	}
+	return nil, fmt.Errorf("no issue template '%s' (available: %s)", name, strings.Join(names, ", "))
+}
+
+// promptIssueTemplate lets the user pick a template, nil means a blank issue
+func promptIssueTemplate(templates []issueTemplate) (*issueTemplate, error) {
+	fmt.Println("Issue templates:")
+	fmt.Println("  0) Blank issue")
+	for i, t := range templates {
+		if t.About != "" {
+			fmt.Printf("  %d) %s - %s\n", i+1, t.Name, t.About)
+		} else {
+			fmt.Printf("  %d) %s\n", i+1, t.Name)
+		}
+	}
+	fmt.Print("Choose a template [0]: ")
+
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	line = strings.TrimSpace(line)
+	if line == "" || line == "0" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(line)
+	if err != nil || n < 0 || n > len(templates) {
+		return nil, fmt.Errorf("invalid choice '%s'", line)
+	}
+	return &templates[n-1], nil
+}
+
+// parseEditedIssue reads the title from the first non-empty line and the body from the rest
+func parseEditedIssue(text string) (string, string) {
+	text = strings.TrimLeft(strings.ReplaceAll(text, "\r\n", "\n"), "\n \t")
+	title, body, _ := strings.Cut(text, "\n")
+	return strings.TrimSpace(title), strings.TrimSpace(body)
+}
+
+// editorContent is what the editor starts with: the title, a blank line and the body
+func editorContent(title string, body string) string {
+	return title + "\n\n" + body
+}
@@ -0,0 +1,82 @@
+package gh
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gsn-dev-tools/internals/paths"
+	"gsn-dev-tools/internals/secrets"
+	"gsn-dev-tools/internals/style"
+)
+
+// tokenFileName is the file in the gsn config dir holding the token stored by `gh auth login`
+const tokenFileName = "gh-token"
+
+// tokenFilePath returns where `gh auth login` stores the token
+func tokenFilePath() (string, error) {
+	dir, err := paths.ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, tokenFileName), nil
+}
+
+// resolveToken returns the active token and a description of where it came from.
+// A keyring:<name> value in the env or the token file is looked up in the secret store.
+func resolveToken() (string, string) {
+	for _, name := range []string{"GSN_GH_TOKEN", "GITHUB_TOKEN", "GH_TOKEN"} {
+		if token := os.Getenv(name); token != "" {
+			return resolveSecretRef(token, "env "+name)
+		}
+	}
+
+	path, err := tokenFilePath()
+	if err != nil {
+		return "", ""
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", ""
+	}
+	return resolveSecretRef(strings.TrimSpace(string(data)), path)
+}
+
+// resolveSecretRef dereferences keyring:<name> tokens, a failed lookup counts as no token
+func resolveSecretRef(token string, source string) (string, string) {
+	if !secrets.IsRef(token) {
+		return token, source
+	}
+
+	value, err := secrets.Resolve(token)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, style.Warning()+"Ignoring token from %s: %v\n", source, err)
+		return "", ""
+	}
+	return value, source + " (" + token + ")"
+}
+
+// storeToken writes the token to the config dir readable only by the current user
+func storeToken(token string) (string, error) {
+	path, err := tokenFilePath()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(token+"\n"), 0o600); err != nil {
+		return "", err
+	}
+	// WriteFile keeps the mode of an existing file, enforce it explicitly
+	return path, os.Chmod(path, 0o600)
+}
+
+// maskToken hides all but the last four characters of a token
+func maskToken(token string) string {
+	if len(token) <= 4 {
+		return strings.Repeat("*", len(token))
+	}
+	return strings.Repeat("*", 8) + token[len(token)-4:]
+}
@@ -0,0 +1,99 @@
+package gh
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gsn-dev-tools/internals/clierr"
+)
+
+// PRRef identifies a pull request on GitHub
+type PRRef struct {
+	Owner  string
+	Repo   string
+	Number int
+}
+
+func (r PRRef) String() string {
+	return fmt.Sprintf("%s/%s#%d", r.Owner, r.Repo, r.Number)
+}
+
+// APIPath returns the REST path of the pull request
+func (r PRRef) APIPath() string {
+	return fmt.Sprintf("/repos/%s/%s/pulls/%d", r.Owner, r.Repo, r.Number)
+}
+
+// ParsePRURL accepts https://github.com/owner/repo/pull/N URLs and the owner/repo#N shorthand
+func ParsePRURL(raw string) (PRRef, error) {
+	owner, repo, number, err := parseRef(raw, "pull request", "pull", "pulls")
+	return PRRef{Owner: owner, Repo: repo, Number: number}, err
+}
+
+// IssueRef identifies an issue on GitHub
+type IssueRef struct {
+	Owner  string
+	Repo   string
+	Number int
+}
+
+func (r IssueRef) String() string {
+	return fmt.Sprintf("%s/%s#%d", r.Owner, r.Repo, r.Number)
+}
+
+// APIPath returns the REST path of the issue
+func (r IssueRef) APIPath() string {
+	return fmt.Sprintf("/repos/%s/%s/issues/%d", r.Owner, r.Repo, r.Number)
+}
+
+// ParseIssueURL accepts https://github.com/owner/repo/issues/N URLs and the owner/repo#N shorthand
+func ParseIssueURL(raw string) (IssueRef, error) {
+	owner, repo, number, err := parseRef(raw, "issue", "issues")
+	return IssueRef{Owner: owner, Repo: repo, Number: number}, err
+}
+
+// parseRef parses an owner/repo#N shorthand or a web URL whose third path segment is one of segments
+func parseRef(raw string, kind string, segments ...string) (string, string, int, error) {
+	if repo, num, ok := strings.Cut(raw, "#"); ok && !strings.Contains(raw, "://") {
+		owner, name, ok := strings.Cut(repo, "/")
+		number, err := strconv.Atoi(num)
+		if !ok || err != nil || owner == "" || name == "" {
+			return "", "", 0, clierr.Newf(clierr.Usage, "invalid %s reference '%s'", kind, raw)
+		}
+		return owner, name, number, nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", "", 0, clierr.Newf(clierr.Usage, "invalid %s URL '%s': %w", kind, raw, err)
+	}
+
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 4 || !slices.Contains(segments, parts[2]) {
+		return "", "", 0, fmt.Errorf("'%s' is not %s URL", raw, article(kind))
+	}
+	number, err := strconv.Atoi(parts[3])
+	if err != nil {
+		return "", "", 0, clierr.Newf(clierr.Usage, "invalid %s number in '%s'", kind, raw)
+	}
+	return parts[0], parts[1], number, nil
+}
+
+// article prefixes kind with a or an
+func article(kind string) string {
+	if strings.ContainsRune("aeiou", rune(kind[0])) {
+		return "an " + kind
+	}
+	return "a " + kind
+}
+
+// ParseRepo splits an owner/repo argument
+func ParseRepo(repo string) (string, string, error) {
+	owner, name, ok := strings.Cut(repo, "/")
+	if !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+		return "", "", clierr.Newf(clierr.Usage, "'%s' is not in owner/repo format", repo)
+	}
+	return owner, name, nil
+}